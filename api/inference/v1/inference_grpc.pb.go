@@ -0,0 +1,223 @@
+// Inference is the gRPC API of agents. The gateway serves it for ToolBindings
+// of type grpc and forwards each call to a replica of the binding's
+// AgentPool, which serves the same service on its agent port.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             v4.24.4
+// source: api/inference/v1/inference.proto
+
+package inferencev1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	Inference_Infer_FullMethodName       = "/neuronetes.inference.v1.Inference/Infer"
+	Inference_StreamInfer_FullMethodName = "/neuronetes.inference.v1.Inference/StreamInfer"
+	Inference_CallTool_FullMethodName    = "/neuronetes.inference.v1.Inference/CallTool"
+)
+
+// InferenceClient is the client API for Inference service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type InferenceClient interface {
+	// Infer runs a turn of the agent and returns its complete output
+	Infer(ctx context.Context, in *InferRequest, opts ...grpc.CallOption) (*InferResponse, error)
+	// StreamInfer runs a turn of the agent and streams its output as it is
+	// generated. The last chunk carries the finish reason and usage.
+	StreamInfer(ctx context.Context, in *InferRequest, opts ...grpc.CallOption) (Inference_StreamInferClient, error)
+	// CallTool invokes a tool of the agent
+	CallTool(ctx context.Context, in *ToolRequest, opts ...grpc.CallOption) (*ToolResponse, error)
+}
+
+type inferenceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewInferenceClient(cc grpc.ClientConnInterface) InferenceClient {
+	return &inferenceClient{cc}
+}
+
+func (c *inferenceClient) Infer(ctx context.Context, in *InferRequest, opts ...grpc.CallOption) (*InferResponse, error) {
+	out := new(InferResponse)
+	err := c.cc.Invoke(ctx, Inference_Infer_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *inferenceClient) StreamInfer(ctx context.Context, in *InferRequest, opts ...grpc.CallOption) (Inference_StreamInferClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Inference_ServiceDesc.Streams[0], Inference_StreamInfer_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &inferenceStreamInferClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Inference_StreamInferClient interface {
+	Recv() (*InferChunk, error)
+	grpc.ClientStream
+}
+
+type inferenceStreamInferClient struct {
+	grpc.ClientStream
+}
+
+func (x *inferenceStreamInferClient) Recv() (*InferChunk, error) {
+	m := new(InferChunk)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *inferenceClient) CallTool(ctx context.Context, in *ToolRequest, opts ...grpc.CallOption) (*ToolResponse, error) {
+	out := new(ToolResponse)
+	err := c.cc.Invoke(ctx, Inference_CallTool_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// InferenceServer is the server API for Inference service.
+// All implementations must embed UnimplementedInferenceServer
+// for forward compatibility
+type InferenceServer interface {
+	// Infer runs a turn of the agent and returns its complete output
+	Infer(context.Context, *InferRequest) (*InferResponse, error)
+	// StreamInfer runs a turn of the agent and streams its output as it is
+	// generated. The last chunk carries the finish reason and usage.
+	StreamInfer(*InferRequest, Inference_StreamInferServer) error
+	// CallTool invokes a tool of the agent
+	CallTool(context.Context, *ToolRequest) (*ToolResponse, error)
+	mustEmbedUnimplementedInferenceServer()
+}
+
+// UnimplementedInferenceServer must be embedded to have forward compatible implementations.
+type UnimplementedInferenceServer struct {
+}
+
+func (UnimplementedInferenceServer) Infer(context.Context, *InferRequest) (*InferResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Infer not implemented")
+}
+func (UnimplementedInferenceServer) StreamInfer(*InferRequest, Inference_StreamInferServer) error {
+	return status.Errorf(codes.Unimplemented, "method StreamInfer not implemented")
+}
+func (UnimplementedInferenceServer) CallTool(context.Context, *ToolRequest) (*ToolResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CallTool not implemented")
+}
+func (UnimplementedInferenceServer) mustEmbedUnimplementedInferenceServer() {}
+
+// UnsafeInferenceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to InferenceServer will
+// result in compilation errors.
+type UnsafeInferenceServer interface {
+	mustEmbedUnimplementedInferenceServer()
+}
+
+func RegisterInferenceServer(s grpc.ServiceRegistrar, srv InferenceServer) {
+	s.RegisterService(&Inference_ServiceDesc, srv)
+}
+
+func _Inference_Infer_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(InferRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(InferenceServer).Infer(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Inference_Infer_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(InferenceServer).Infer(ctx, req.(*InferRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Inference_StreamInfer_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(InferRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(InferenceServer).StreamInfer(m, &inferenceStreamInferServer{stream})
+}
+
+type Inference_StreamInferServer interface {
+	Send(*InferChunk) error
+	grpc.ServerStream
+}
+
+type inferenceStreamInferServer struct {
+	grpc.ServerStream
+}
+
+func (x *inferenceStreamInferServer) Send(m *InferChunk) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Inference_CallTool_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ToolRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(InferenceServer).CallTool(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Inference_CallTool_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(InferenceServer).CallTool(ctx, req.(*ToolRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Inference_ServiceDesc is the grpc.ServiceDesc for Inference service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Inference_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "neuronetes.inference.v1.Inference",
+	HandlerType: (*InferenceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Infer",
+			Handler:    _Inference_Infer_Handler,
+		},
+		{
+			MethodName: "CallTool",
+			Handler:    _Inference_CallTool_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamInfer",
+			Handler:       _Inference_StreamInfer_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "api/inference/v1/inference.proto",
+}
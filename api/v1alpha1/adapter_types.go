@@ -0,0 +1,108 @@
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Phases of an Adapter
+const (
+	// AdapterPending adapters wait for their base model or for replicas
+	// serving it
+	AdapterPending = "Pending"
+
+	// AdapterLoading adapters are downloading or loading onto replicas
+	AdapterLoading = "Loading"
+
+	// AdapterReady adapters are loaded onto every ready replica serving
+	// their base model
+	AdapterReady = "Ready"
+
+	// AdapterFailed adapters could not download their weights
+	AdapterFailed = "Failed"
+)
+
+// AdapterSpec defines the desired state of Adapter
+type AdapterSpec struct {
+	// BaseModelRef names the Model the adapter was trained on, in the
+	// namespace of the adapter. Every replica serving the model loads the
+	// adapter.
+	BaseModelRef corev1.LocalObjectReference `json:"baseModelRef"`
+
+	// WeightsURI is the location of the adapter weights, of any scheme
+	// Model weightsURI supports
+	// +kubebuilder:validation:MinLength=1
+	WeightsURI string `json:"weightsURI"`
+
+	// CredentialsSecretRef selects the key of a Secret in the namespace of
+	// the adapter holding the token of the weights' source
+	// +optional
+	CredentialsSecretRef *corev1.SecretKeySelector `json:"credentialsSecretRef,omitempty"`
+
+	// Scaling multiplies the update of the adapter to the base weights,
+	// such as alpha / rank for LoRA. Defaults to the adapter's own
+	// configuration.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	Scaling *float32 `json:"scaling,omitempty"`
+}
+
+// AdapterStatus defines the observed state of Adapter
+type AdapterStatus struct {
+	// Phase is the phase of the adapter
+	// +kubebuilder:validation:Enum=Pending;Loading;Ready;Failed
+	// +optional
+	Phase string `json:"phase,omitempty"`
+
+	// Path is where replicas read the adapter weights from: their
+	// directory in the model cache, or the weights URI if the controller
+	// does not download them
+	// +optional
+	Path string `json:"path,omitempty"`
+
+	// Replicas is the number of replicas serving the base model
+	// +optional
+	Replicas int32 `json:"replicas,omitempty"`
+
+	// LoadedReplicas is the number of replicas the adapter is loaded onto
+	// +optional
+	LoadedReplicas int32 `json:"loadedReplicas,omitempty"`
+
+	// Message explains the phase
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:scope=Namespaced,shortName=adp
+// +kubebuilder:printcolumn:name="Base Model",type=string,JSONPath=`.spec.baseModelRef.name`
+// +kubebuilder:printcolumn:name="Loaded",type=integer,JSONPath=`.status.loadedReplicas`
+// +kubebuilder:printcolumn:name="Replicas",type=integer,JSONPath=`.status.replicas`
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// Adapter is the Schema for the adapters API. It loads the weights of a
+// LoRA adapter onto the running replicas of its base model, so that one
+// pool serves many fine-tunes, selected by the adapter's name as the model
+// of a request.
+type Adapter struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   AdapterSpec   `json:"spec,omitempty"`
+	Status AdapterStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// AdapterList contains a list of Adapter
+type AdapterList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Adapter `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&Adapter{}, &AdapterList{})
+}
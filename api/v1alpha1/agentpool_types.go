@@ -1,10 +1,13 @@
 package v1alpha1
 
 import (
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
 // AgentPoolSpec defines the desired state of AgentPool
+// +kubebuilder:validation:XValidation:rule="!has(self.migProfile) || !has(self.gpuRequirements) || !has(self.gpuRequirements.vendor) || self.gpuRequirements.vendor == 'nvidia'",message="MIG profiles require NVIDIA GPUs"
 type AgentPoolSpec struct {
 	// AgentClassRef references the AgentClass to use
 	// +kubebuilder:validation:Required
@@ -18,6 +21,13 @@ type AgentPoolSpec struct {
 	// +kubebuilder:validation:Minimum=1
 	MaxReplicas int32 `json:"maxReplicas"`
 
+	// Replicas is the desired number of serving replicas, clamped to
+	// [minReplicas, maxReplicas]. It is normally managed by the autoscaler
+	// through the scale subresource.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	Replicas *int32 `json:"replicas,omitempty"`
+
 	// PrewarmPercent is the percentage of replicas to keep warm (0-100)
 	// +kubebuilder:validation:Minimum=0
 	// +kubebuilder:validation:Maximum=100
@@ -28,6 +38,11 @@ type AgentPoolSpec struct {
 	// +optional
 	TokensPerSecondBudget *int32 `json:"tokensPerSecondBudget,omitempty"`
 
+	// Image is the agent runtime container image. Defaults to the image the
+	// controller manager is configured with.
+	// +optional
+	Image string `json:"image,omitempty"`
+
 	// MIGProfile specifies MIG configuration (e.g., "1g.5gb", "2g.10gb")
 	// +optional
 	MIGProfile string `json:"migProfile,omitempty"`
@@ -47,6 +62,129 @@ type AgentPoolSpec struct {
 	// Scheduling provides scheduling hints
 	// +optional
 	Scheduling *SchedulingConfig `json:"scheduling,omitempty"`
+
+	// Rollout controls how replicas are replaced when the pool's AgentClass
+	// or Model changes
+	// +optional
+	Rollout *RolloutStrategy `json:"rollout,omitempty"`
+
+	// Snapshot releases the GPU memory of idle warm replicas by
+	// snapshotting it, and restores it when they are activated
+	// +optional
+	Snapshot *SnapshotConfig `json:"snapshot,omitempty"`
+
+	// WarmUp runs a sequence of requests against each replica once it
+	// starts, before it is ready, so the graph capture and compilation of
+	// its first requests do not slow down traffic
+	// +optional
+	WarmUp *WarmUpConfig `json:"warmUp,omitempty"`
+}
+
+// WarmUpConfig is the warm-up sequence of a replica. One completion request
+// is sent for each batch size, batching that many prompts, so the runtime
+// captures the graphs of each size.
+type WarmUpConfig struct {
+	// Prompts are the prompts of the warm-up requests, repeated to fill
+	// each batch. Defaults to a single short prompt.
+	// +optional
+	Prompts []string `json:"prompts,omitempty"`
+
+	// BatchSizes are the batch sizes warmed up, in order. Defaults to 1.
+	// +kubebuilder:validation:items:Minimum=1
+	// +optional
+	BatchSizes []int32 `json:"batchSizes,omitempty"`
+
+	// MaxTokens is the number of tokens each warm-up prompt generates.
+	// Defaults to 16.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	MaxTokens *int32 `json:"maxTokens,omitempty"`
+
+	// Model is sent as the model of warm-up requests, for runtimes that
+	// require one
+	// +optional
+	Model string `json:"model,omitempty"`
+
+	// Path is the OpenAI-compatible completions endpoint of the agent
+	// runtime. Defaults to /v1/completions.
+	// +optional
+	Path string `json:"path,omitempty"`
+
+	// Timeout bounds the whole warm-up sequence. Defaults to 10m.
+	// +optional
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+}
+
+// SnapshotConfig snapshots the GPU memory of warm replicas that stay idle,
+// so that activating them restores the loaded model in seconds rather than
+// starting a replica cold
+type SnapshotConfig struct {
+	// Method snapshots GPU memory with cuda-checkpoint run in the agent
+	// container, or through the snapshot API of the agent runtime, such as
+	// the sleep mode of vLLM
+	// +kubebuilder:validation:Enum=cuda-checkpoint;runtime
+	Method string `json:"method"`
+
+	// IdleAfter is how long a replica stays warm before it is
+	// snapshotted. Defaults to 5m.
+	// +optional
+	IdleAfter *metav1.Duration `json:"idleAfter,omitempty"`
+
+	// ProcessID is the PID, in the agent container, of the process
+	// holding the GPU that cuda-checkpoint suspends. Defaults to 1.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	ProcessID *int32 `json:"processID,omitempty"`
+
+	// Runtime is the snapshot API of the agent runtime for the runtime
+	// method
+	// +optional
+	Runtime *RuntimeSnapshotAPI `json:"runtime,omitempty"`
+}
+
+// RuntimeSnapshotAPI is the HTTP API an agent runtime offers to release and
+// restore its GPU memory. The defaults are those of vLLM's sleep mode.
+type RuntimeSnapshotAPI struct {
+	// Port is the port of the API. Defaults to the agent port, 8080.
+	// +optional
+	Port *int32 `json:"port,omitempty"`
+
+	// SnapshotPath is POSTed to release GPU memory. Defaults to /sleep.
+	// +optional
+	SnapshotPath string `json:"snapshotPath,omitempty"`
+
+	// RestorePath is POSTed to restore GPU memory. Defaults to /wake_up.
+	// +optional
+	RestorePath string `json:"restorePath,omitempty"`
+}
+
+// Methods of SnapshotConfig
+const (
+	// SnapshotMethodCUDACheckpoint suspends the CUDA state of the agent
+	// process with NVIDIA's cuda-checkpoint, moving GPU memory to the host
+	SnapshotMethodCUDACheckpoint = "cuda-checkpoint"
+
+	// SnapshotMethodRuntime calls the snapshot API of the agent runtime
+	SnapshotMethodRuntime = "runtime"
+)
+
+// RolloutStrategy controls the rolling replacement of a pool's replicas.
+// Replacements load the new model and become ready before the replicas they
+// replace are removed.
+type RolloutStrategy struct {
+	// MaxSurge is how many replicas, as a count or a percentage of the
+	// pool, may run above its size while replacements load. Defaults to 25%,
+	// or the shard count of a tensor-parallel model.
+	// +kubebuilder:validation:XIntOrString
+	// +optional
+	MaxSurge *intstr.IntOrString `json:"maxSurge,omitempty"`
+
+	// MaxUnavailable is how many replicas, as a count or a percentage of the
+	// pool, may be unavailable during a rollout. Defaults to 0, so serving
+	// capacity never drops.
+	// +kubebuilder:validation:XIntOrString
+	// +optional
+	MaxUnavailable *intstr.IntOrString `json:"maxUnavailable,omitempty"`
 }
 
 // AgentClassReference references an AgentClass resource
@@ -64,6 +202,19 @@ type AutoscalingSpec struct {
 	// Metrics are the metrics to use for autoscaling
 	Metrics []AutoscalingMetric `json:"metrics"`
 
+	// Aggregation combines the ratios of the metrics to their targets into
+	// the ratio that drives scaling. Defaults to max.
+	// +kubebuilder:validation:Enum=max;average;weighted;all-must-exceed
+	// +optional
+	Aggregation string `json:"aggregation,omitempty"`
+
+	// PluginPolicy combines the replica counts recommended by registered
+	// autoscaler plugins with the one computed from the metrics. Defaults
+	// to max.
+	// +kubebuilder:validation:Enum=max;min;average;priority
+	// +optional
+	PluginPolicy string `json:"pluginPolicy,omitempty"`
+
 	// Behavior defines scaling behavior (scale up/down rates)
 	// +optional
 	Behavior *ScalingBehavior `json:"behavior,omitempty"`
@@ -71,23 +222,128 @@ type AutoscalingSpec struct {
 	// CooldownPeriod is the time to wait between scaling operations
 	// +optional
 	CooldownPeriod *metav1.Duration `json:"cooldownPeriod,omitempty"`
+
+	// MinHeadroomPercent triggers scale-up whenever the projected headroom
+	// below any metric target drops under this percentage, even before the
+	// target itself is crossed
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=99
+	// +optional
+	MinHeadroomPercent *int32 `json:"minHeadroomPercent,omitempty"`
+
+	// MaxGPUUtilizationPercent blocks scale-down that would push the average
+	// GPU utilization of the remaining replicas above this percentage
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=100
+	// +optional
+	MaxGPUUtilizationPercent *int32 `json:"maxGPUUtilizationPercent,omitempty"`
+
+	// Schedules override the replica bounds during recurring time windows
+	// +optional
+	Schedules []ScalingSchedule `json:"schedules,omitempty"`
+}
+
+// ScalingSchedule overrides the pool's replica bounds while a cron-defined
+// window is active. Metric-driven decisions are clamped to the overridden
+// bounds.
+type ScalingSchedule struct {
+	// Name identifies the schedule in scaling decisions
+	// +optional
+	Name string `json:"name,omitempty"`
+
+	// Schedule is a five-field cron expression (minute hour day-of-month
+	// month day-of-week). Without a duration the window is active during
+	// every minute the expression matches, e.g. "* 9-17 * * 1-5".
+	// +kubebuilder:validation:Required
+	Schedule string `json:"schedule"`
+
+	// Duration keeps the window active for this long after each time the
+	// schedule matches, e.g. schedule "0 9 * * 1-5" with duration 9h
+	// +optional
+	Duration *metav1.Duration `json:"duration,omitempty"`
+
+	// TimeZone is the IANA time zone the schedule is evaluated in
+	// (defaults to UTC)
+	// +optional
+	TimeZone string `json:"timeZone,omitempty"`
+
+	// MinReplicas replaces spec.minReplicas while the window is active
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MinReplicas *int32 `json:"minReplicas,omitempty"`
+
+	// MaxReplicas replaces spec.maxReplicas while the window is active
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	MaxReplicas *int32 `json:"maxReplicas,omitempty"`
 }
 
 // AutoscalingMetric defines a single autoscaling metric
+// +kubebuilder:validation:XValidation:rule="!(self.type in ['ttft-p95', 'ttft-p99']) || self.target.matches('^[0-9]+(\\\\.[0-9]+)?$|^([0-9]+(\\\\.[0-9]+)?(ns|us|ms|s|m|h))+$')",message="latency targets must be durations such as 500ms"
+// +kubebuilder:validation:XValidation:rule="!(self.type in ['tokens-in-queue', 'concurrent-sessions', 'tokens-per-second', 'queue-depth', 'context-length', 'tool-call-rate']) || self.target.matches('^[0-9]+(\\\\.[0-9]+)?([eE][-+]?[0-9]+|m|k|M|G|T|P|E|Ki|Mi|Gi|Ti|Pi|Ei)?$')",message="count targets must be numbers or quantities such as 2k"
+// +kubebuilder:validation:XValidation:rule="self.type in ['ttft-p95', 'ttft-p99', 'tokens-in-queue', 'concurrent-sessions', 'tokens-per-second', 'queue-depth', 'context-length', 'tool-call-rate'] || self.target.matches('^[0-9]+(\\\\.[0-9]+)?%$|^([0-9]+(\\\\.[0-9]+)?(ns|us|ms|s|m|h))+$|^[0-9]+(\\\\.[0-9]+)?([eE][-+]?[0-9]+|m|k|M|G|T|P|E|Ki|Mi|Gi|Ti|Pi|Ei)?$')",message="targets of metric types added through query overrides must be numbers, durations, percentages or quantities"
+// +kubebuilder:validation:XValidation:rule="self.target.matches('[1-9]')",message="target must be positive"
 type AutoscalingMetric struct {
-	// Type is the metric type
-	// +kubebuilder:validation:Enum=tokens-in-queue;ttft-p95;concurrent-sessions;tokens-per-second;queue-depth;context-length;tool-call-rate
+	// Type is the metric type: a built-in type, or a type added through the
+	// query overrides of the autoscaler
+	// +kubebuilder:validation:Pattern=`^[a-z0-9]+(-[a-z0-9]+)*$`
+	// +kubebuilder:validation:MaxLength=63
 	Type string `json:"type"`
 
-	// Target is the target value for this metric
+	// Target is the target value for this metric. Latency targets are
+	// durations (500ms); others are numbers or quantities (2k).
 	// +kubebuilder:validation:Required
 	Target string `json:"target"`
 
 	// AveragingWindow is the time window for averaging the metric
 	// +optional
 	AveragingWindow *metav1.Duration `json:"averagingWindow,omitempty"`
+
+	// Weight of this metric under weighted aggregation. Defaults to 1.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	Weight *int32 `json:"weight,omitempty"`
+
+	// TolerancePercent is a band around the target, as a percentage of it,
+	// within which the metric does not scale the pool, e.g. 10 for ±10%
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=99
+	// +optional
+	TolerancePercent *int32 `json:"tolerancePercent,omitempty"`
 }
 
+// Metric aggregation modes
+const (
+	// AggregationMax scales on the metric furthest above its target
+	AggregationMax = "max"
+
+	// AggregationAverage scales on the mean ratio across metrics
+	AggregationAverage = "average"
+
+	// AggregationWeighted scales on the weighted mean ratio across metrics
+	AggregationWeighted = "weighted"
+
+	// AggregationAllMustExceed only scales up when every metric is above
+	// its target
+	AggregationAllMustExceed = "all-must-exceed"
+)
+
+// Autoscaler plugin policies
+const (
+	// PluginPolicyMax takes the largest recommendation
+	PluginPolicyMax = "max"
+
+	// PluginPolicyMin takes the smallest recommendation
+	PluginPolicyMin = "min"
+
+	// PluginPolicyAverage takes the mean recommendation, rounded up
+	PluginPolicyAverage = "average"
+
+	// PluginPolicyPriority takes the recommendation of the highest-priority
+	// plugin that made one, falling back to the metrics
+	PluginPolicyPriority = "priority"
+)
+
 // ScalingBehavior controls scaling velocity
 type ScalingBehavior struct {
 	// ScaleUp defines scale-up behavior
@@ -97,6 +353,23 @@ type ScalingBehavior struct {
 	// ScaleDown defines scale-down behavior
 	// +optional
 	ScaleDown *ScalingPolicy `json:"scaleDown,omitempty"`
+
+	// Burst scales up past the scale-up limits during sudden queue spikes
+	// +optional
+	Burst *BurstPolicy `json:"burst,omitempty"`
+}
+
+// BurstPolicy lets a pool absorb a sudden queue spike. While a queue metric
+// is at least ThresholdMultiplier times its target, scale-up skips
+// stabilization, cooldown and the scale-up change limits. Once the spike
+// passes, scale-down is stabilized as usual.
+type BurstPolicy struct {
+	// ThresholdMultiplier is how many times its target tokens-in-queue,
+	// queue-depth or a binding's lag must reach to trigger a burst.
+	// Defaults to 10.
+	// +kubebuilder:validation:Minimum=2
+	// +optional
+	ThresholdMultiplier *int32 `json:"thresholdMultiplier,omitempty"`
 }
 
 // ScalingPolicy defines scaling rate limits
@@ -113,12 +386,17 @@ type ScalingPolicy struct {
 	// +optional
 	MaxChangeAbsolute *int32 `json:"maxChangeAbsolute,omitempty"`
 
-	// PeriodSeconds is how often to evaluate
+	// PeriodSeconds is the window the change limits apply over, counting
+	// every scale made within it. Without it they apply to each evaluation.
+	// +kubebuilder:validation:Minimum=1
 	// +optional
 	PeriodSeconds *int32 `json:"periodSeconds,omitempty"`
 }
 
 // GPURequirements specifies GPU constraints
+// +kubebuilder:validation:XValidation:rule="!has(self.dra) || !has(self.vendor) || self.vendor == 'nvidia'",message="DRA claims require NVIDIA GPUs"
+// +kubebuilder:validation:XValidation:rule="!has(self.sharing) || !has(self.vendor) || self.vendor == 'nvidia'",message="GPU sharing requires NVIDIA GPUs"
+// +kubebuilder:validation:XValidation:rule="!has(self.sharing) || !has(self.dra)",message="GPUs claimed through DRA are shared with dra.sharing"
 type GPURequirements struct {
 	// Count is the number of GPUs per replica
 	// +kubebuilder:validation:Minimum=1
@@ -128,15 +406,85 @@ type GPURequirements struct {
 	// +optional
 	Memory string `json:"memory,omitempty"`
 
-	// Type is the GPU type (e.g., "A100", "H100")
+	// Type is the GPU type (e.g., "A100", "H100", "MI300X")
 	// +optional
 	Type string `json:"type,omitempty"`
 
+	// Vendor is the GPU vendor: nvidia, whose GPUs are requested as
+	// nvidia.com/gpu, or amd, whose ROCm GPUs are requested as amd.com/gpu.
+	// MIG profiles and DRA claim parameters are NVIDIA only.
+	// Defaults to nvidia.
+	// +kubebuilder:validation:Enum=nvidia;amd
+	// +optional
+	Vendor string `json:"vendor,omitempty"`
+
 	// Topology specifies GPU topology requirements
 	// +optional
 	Topology *TopologyRequirement `json:"topology,omitempty"`
+
+	// DRA claims the GPUs, or MIG devices of the pool's MIG profile,
+	// through Dynamic Resource Allocation instead of the device plugin's
+	// extended resources
+	// +optional
+	DRA *DRAConfig `json:"dra,omitempty"`
+
+	// Sharing places replicas on nodes whose device plugin shares each GPU
+	// between several of them, for models too small to use a GPU of their
+	// own. Count and Memory are then of the shared GPU replicas the device
+	// plugin advertises rather than of whole GPUs.
+	// +optional
+	Sharing *GPUSharing `json:"sharing,omitempty"`
+}
+
+// GPUSharing selects how the NVIDIA device plugin shares the GPUs replicas
+// are placed on
+type GPUSharing struct {
+	// Strategy is TimeSlicing or MPS, as the device plugin of the node is
+	// configured
+	// +kubebuilder:validation:Enum=TimeSlicing;MPS
+	Strategy string `json:"strategy"`
 }
 
+// DRAConfig configures the ResourceClaims of a pool's replicas
+type DRAConfig struct {
+	// ResourceClassName is the ResourceClass the claims are allocated from.
+	// Defaults to gpu.nvidia.com, or mig.nvidia.com with a MIG profile, and
+	// is created for the NVIDIA DRA driver if missing.
+	// +optional
+	ResourceClassName string `json:"resourceClassName,omitempty"`
+
+	// Sharing shares the claimed GPUs between all replicas of the pool,
+	// which are then placed on the same node: TimeSlicing or MPS. Without
+	// it each replica claims GPUs of its own.
+	// +kubebuilder:validation:Enum=TimeSlicing;MPS
+	// +optional
+	Sharing string `json:"sharing,omitempty"`
+}
+
+const (
+	// GPUVendorNVIDIA is the vendor of NVIDIA GPUs
+	GPUVendorNVIDIA = "nvidia"
+
+	// GPUVendorAMD is the vendor of AMD Instinct GPUs run with ROCm
+	GPUVendorAMD = "amd"
+)
+
+const (
+	// DRASharingTimeSlicing shares GPUs by time-slicing
+	DRASharingTimeSlicing = "TimeSlicing"
+
+	// DRASharingMPS shares GPUs with the CUDA Multi-Process Service
+	DRASharingMPS = "MPS"
+)
+
+const (
+	// GPUSharingTimeSlicing shares GPUs by time-slicing
+	GPUSharingTimeSlicing = "TimeSlicing"
+
+	// GPUSharingMPS shares GPUs with the CUDA Multi-Process Service
+	GPUSharingMPS = "MPS"
+)
+
 // SessionAffinityConfig defines sticky session behavior
 type SessionAffinityConfig struct {
 	// Enabled turns on session affinity
@@ -154,14 +502,27 @@ type SessionAffinityConfig struct {
 	// +kubebuilder:validation:Enum=conversation-id;user-id;custom
 	// +optional
 	Type string `json:"type,omitempty"`
+
+	// DrainTimeout bounds how long a replica removed on scale-down keeps
+	// serving its sessions before it is terminated. Defaults to 5m.
+	// +optional
+	DrainTimeout *metav1.Duration `json:"drainTimeout,omitempty"`
 }
 
 // SchedulingConfig provides scheduling hints
 type SchedulingConfig struct {
-	// Priority is the scheduling priority
+	// Priority is the scheduling priority of the pool's replicas. Replicas
+	// of a higher priority pool preempt those of lower priority pools when
+	// GPUs are scarce. The controller maps each value to a PriorityClass.
+	// +kubebuilder:validation:Maximum=1000000000
 	// +optional
 	Priority *int32 `json:"priority,omitempty"`
 
+	// PriorityClassName runs the pool's replicas with an existing
+	// PriorityClass instead of the one created for Priority
+	// +optional
+	PriorityClassName string `json:"priorityClassName,omitempty"`
+
 	// CostOptimization enables cost-aware scheduling
 	// +optional
 	CostOptimization *CostOptimizationConfig `json:"costOptimization,omitempty"`
@@ -173,6 +534,37 @@ type SchedulingConfig struct {
 	// NodeSelector is a label selector for nodes
 	// +optional
 	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+
+	// TopologySpread spreads the pool's replicas across topology domains,
+	// such as zones or nodes, so that losing one takes down few of them
+	// +optional
+	TopologySpread []TopologySpreadConfig `json:"topologySpread,omitempty"`
+
+	// QueueName is the Kueue LocalQueue admitting the pool's replicas. Each
+	// replica then runs only once its ClusterQueue has GPU quota for it.
+	// +optional
+	QueueName string `json:"queueName,omitempty"`
+}
+
+// TopologySpreadConfig spreads replicas across the domains of a topology
+// key
+type TopologySpreadConfig struct {
+	// TopologyKey is the node label whose values are the domains, e.g.
+	// topology.kubernetes.io/zone or kubernetes.io/hostname
+	TopologyKey string `json:"topologyKey"`
+
+	// MaxSkew is the most the replica counts of two domains may differ by.
+	// Defaults to 1.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	MaxSkew int32 `json:"maxSkew,omitempty"`
+
+	// WhenUnsatisfiable is DoNotSchedule to leave replicas pending rather
+	// than exceed MaxSkew, the default, or ScheduleAnyway to only prefer
+	// domains with fewer replicas
+	// +kubebuilder:validation:Enum=DoNotSchedule;ScheduleAnyway
+	// +optional
+	WhenUnsatisfiable string `json:"whenUnsatisfiable,omitempty"`
 }
 
 // CostOptimizationConfig defines cost optimization behavior
@@ -180,7 +572,8 @@ type CostOptimizationConfig struct {
 	// Enabled turns on cost optimization
 	Enabled bool `json:"enabled"`
 
-	// MaxCostPerHour is the maximum hourly cost
+	// MaxCostPerHour is the most a replica's GPUs may cost per hour in USD.
+	// Nodes priced above it are not scheduled on.
 	// +optional
 	MaxCostPerHour *float32 `json:"maxCostPerHour,omitempty"`
 
@@ -220,10 +613,29 @@ type AgentPoolStatus struct {
 	// ReadyReplicas is the number of ready replicas
 	ReadyReplicas int32 `json:"readyReplicas"`
 
+	// Selector is the label selector of the pool's pods, in string form
+	// +optional
+	Selector string `json:"selector,omitempty"`
+
 	// PrewarmedReplicas is the number of prewarmed replicas
 	// +optional
 	PrewarmedReplicas int32 `json:"prewarmedReplicas,omitempty"`
 
+	// DrainingReplicas is the number of replicas finishing their sessions
+	// before removal
+	// +optional
+	DrainingReplicas int32 `json:"drainingReplicas,omitempty"`
+
+	// SnapshottedReplicas is the number of prewarmed replicas whose GPU
+	// memory is snapshotted
+	// +optional
+	SnapshottedReplicas int32 `json:"snapshottedReplicas,omitempty"`
+
+	// LastWarmUpDuration is how long the most recent warm-up of a replica
+	// took
+	// +optional
+	LastWarmUpDuration *metav1.Duration `json:"lastWarmUpDuration,omitempty"`
+
 	// CurrentTokensPerSecond is the current throughput
 	// +optional
 	CurrentTokensPerSecond *int32 `json:"currentTokensPerSecond,omitempty"`
@@ -236,11 +648,79 @@ type AgentPoolStatus struct {
 	// +optional
 	LastScaleTime *metav1.Time `json:"lastScaleTime,omitempty"`
 
+	// Recommendation is the autoscaler's latest scaling decision
+	// +optional
+	Recommendation *ScalingRecommendation `json:"recommendation,omitempty"`
+
+	// ScalingHistory holds the most recent scaling decisions that changed,
+	// or in dry-run would have changed, the pool's replicas, oldest first
+	// +optional
+	ScalingHistory []ScalingRecommendation `json:"scalingHistory,omitempty"`
+
+	// GPURecommendation is the suggested GPU sizing of each replica, based
+	// on observed usage
+	// +optional
+	GPURecommendation *GPURecommendation `json:"gpuRecommendation,omitempty"`
+
 	// Conditions represent the latest available observations
 	// +optional
 	Conditions []metav1.Condition `json:"conditions,omitempty"`
 }
 
+// ScalingRecommendation is a scaling decision made by the autoscaler
+type ScalingRecommendation struct {
+	// CurrentReplicas is the replica count the decision was made at
+	CurrentReplicas int32 `json:"currentReplicas"`
+
+	// DesiredReplicas is the recommended replica count
+	DesiredReplicas int32 `json:"desiredReplicas"`
+
+	// Reason explains the recommendation
+	// +optional
+	Reason string `json:"reason,omitempty"`
+
+	// DryRun is true when the recommendation was not applied
+	// +optional
+	DryRun bool `json:"dryRun,omitempty"`
+
+	// Metrics is a snapshot of the values the decision was based on
+	// +optional
+	Metrics map[string]string `json:"metrics,omitempty"`
+
+	// Time is when the recommendation was made
+	Time metav1.Time `json:"time"`
+}
+
+// GPURecommendation is a suggested GPU sizing for an AgentPool's replicas
+type GPURecommendation struct {
+	// Target is the suggested GPU requirements of each replica
+	Target GPURequirements `json:"target"`
+
+	// MIGProfile is the smallest MIG profile a replica fits in, if any
+	// +optional
+	MIGProfile string `json:"migProfile,omitempty"`
+
+	// PeakVRAM is the most GPU memory a replica used during the
+	// observation window, zero if the recommendation is estimated from
+	// the model before usage is observed
+	PeakVRAM resource.Quantity `json:"peakVRAM"`
+
+	// ContextLength is the highest p95 context length observed, in tokens
+	// +optional
+	ContextLength int32 `json:"contextLength,omitempty"`
+
+	// BatchSize is the largest decode batch observed
+	// +optional
+	BatchSize int32 `json:"batchSize,omitempty"`
+
+	// Reason explains the recommendation
+	// +optional
+	Reason string `json:"reason,omitempty"`
+
+	// Time is when the recommendation was made
+	Time metav1.Time `json:"time"`
+}
+
 // CurrentMetric represents a current metric value
 type CurrentMetric struct {
 	// Type is the metric type
@@ -259,7 +739,7 @@ type CurrentMetric struct {
 
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
-// +kubebuilder:subresource:scale:specpath=.spec.minReplicas,statuspath=.status.replicas,selectorpath=.status.selector
+// +kubebuilder:subresource:scale:specpath=.spec.replicas,statuspath=.status.replicas,selectorpath=.status.selector
 // +kubebuilder:resource:scope=Namespaced,shortName=ap
 // +kubebuilder:printcolumn:name="AgentClass",type=string,JSONPath=`.spec.agentClassRef.name`
 // +kubebuilder:printcolumn:name="Min",type=integer,JSONPath=`.spec.minReplicas`
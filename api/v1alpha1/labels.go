@@ -0,0 +1,179 @@
+package v1alpha1
+
+// Well-known labels applied to resources managed by NeuroNetes
+const (
+	// LabelAgentClass is the AgentClass name a replica serves
+	LabelAgentClass = "neuronetes.io/agent-class"
+
+	// LabelPool is the AgentPool name a replica belongs to
+	LabelPool = "neuronetes.io/pool"
+
+	// LabelModel is the Model name a replica serves
+	LabelModel = "neuronetes.io/model"
+
+	// LabelComponent is the NeuroNetes component type
+	LabelComponent = "neuronetes.io/component"
+
+	// LabelRole is the role of an agent replica within its pool
+	LabelRole = "neuronetes.io/role"
+
+	// LabelGang names the gang an agent replica is scheduled with. Replicas
+	// of a gang are only bound once a whole group of the gang's size fits.
+	LabelGang = "neuronetes.io/gang"
+
+	// LabelRollout is the ModelRollout the Model, AgentClasses and
+	// AgentPools serving the new weights of a rollout belong to
+	LabelRollout = "neuronetes.io/rollout"
+
+	// LabelQuantization is the ModelQuantization a derived Model and the
+	// conversion Jobs producing its weights belong to
+	LabelQuantization = "neuronetes.io/quantization"
+
+	// LabelRegistry is the ModelRegistry a Model is synced from
+	LabelRegistry = "neuronetes.io/registry"
+
+	// LabelVectorStore names the vector store a pod serves, or caches. Agent
+	// replicas of pools with vectorStoreAffinity for it are scheduled close
+	// to these pods.
+	LabelVectorStore = "neuronetes.io/vector-store"
+)
+
+// Values of LabelRole
+const (
+	// RoleServing replicas receive traffic
+	RoleServing = "serving"
+
+	// RoleWarm replicas have the model loaded but are excluded from routing
+	// until activated
+	RoleWarm = "warm"
+
+	// RoleDraining marks a replica being removed on scale-down. It keeps
+	// serving its sticky sessions but receives no new ones.
+	RoleDraining = "draining"
+)
+
+// Well-known annotations used by NeuroNetes
+const (
+	// AnnotationBackpressure is set to "true" by a replica that cannot keep
+	// up with its streams and should not receive new requests
+	AnnotationBackpressure = "neuronetes.io/backpressure"
+
+	// AnnotationMetricsSelector overrides the PromQL label matchers that
+	// select an AgentPool's or AgentClass's series, e.g.
+	// `namespace="prod",app="chat"`
+	AnnotationMetricsSelector = "neuronetes.io/metrics-selector"
+
+	// AnnotationActivationRequested is set on an AgentPool, as an RFC 3339
+	// timestamp, when a request is waiting for a scaled-to-zero pool
+	AnnotationActivationRequested = "neuronetes.io/activation-requested"
+
+	// AnnotationAutoscalerDryRun set to "true" on an AgentPool makes the
+	// autoscaler record its decisions without changing the pool's replicas
+	AnnotationAutoscalerDryRun = "neuronetes.io/autoscaler-dry-run"
+
+	// AnnotationPaused set to "true" on an AgentPool freezes it: neither the
+	// autoscaler nor the AgentPool controller change it, but its status keeps
+	// being reported
+	AnnotationPaused = "neuronetes.io/paused"
+
+	// AnnotationActiveSessions is reported by an agent replica with the
+	// number of sessions it is serving
+	AnnotationActiveSessions = "neuronetes.io/active-sessions"
+
+	// AnnotationWarmSince records, as an RFC 3339 timestamp, when an agent
+	// replica last became warm
+	AnnotationWarmSince = "neuronetes.io/warm-since"
+
+	// AnnotationSnapshotted marks a warm agent replica whose GPU memory is
+	// snapshotted, with the method it was snapshotted by
+	AnnotationSnapshotted = "neuronetes.io/snapshotted"
+
+	// AnnotationDrainStarted records, as an RFC 3339 timestamp, when a
+	// replica started draining
+	AnnotationDrainStarted = "neuronetes.io/drain-started"
+
+	// AnnotationRevision is the hash of the AgentClass and Model specs an
+	// agent replica was started with. A change rolls the pool's replicas.
+	AnnotationRevision = "neuronetes.io/revision"
+
+	// AnnotationGangSize is the number of replicas of a gang that must be
+	// scheduled together
+	AnnotationGangSize = "neuronetes.io/gang-size"
+
+	// AnnotationVRAM is the estimated GPU memory an agent replica uses, as
+	// a quantity. The scheduler packs replicas onto nodes by it.
+	AnnotationVRAM = "neuronetes.io/vram"
+
+	// AnnotationCanary names the AgentPool serving the canary of an
+	// AgentPool, which routers split the pool's traffic with
+	AnnotationCanary = "neuronetes.io/canary"
+
+	// AnnotationCanaryWeight is the percentage (0-100) of the traffic of an
+	// AgentPool routed to its canary
+	AnnotationCanaryWeight = "neuronetes.io/canary-weight"
+
+	// AnnotationSourceModel names the Model a derived Model was quantized
+	// from
+	AnnotationSourceModel = "neuronetes.io/source-model"
+
+	// AnnotationSourceWeights is the weights URI of the source Model a
+	// derived Model was quantized from
+	AnnotationSourceWeights = "neuronetes.io/source-weights"
+
+	// AnnotationSourceRevision is the hash of the source Model spec and
+	// quantization settings a derived Model was quantized from
+	AnnotationSourceRevision = "neuronetes.io/source-revision"
+
+	// AnnotationGPUClaims is the extended resource equivalent of the GPUs
+	// an agent replica claims through Dynamic Resource Allocation, e.g.
+	// nvidia.com/gpu=2, for the scheduler to account them
+	AnnotationGPUClaims = "neuronetes.io/gpu-claims"
+
+	// AnnotationShardStrategy is the sharding strategy of the model an
+	// agent replica serves a shard of, tensor-parallel or pipeline-parallel
+	AnnotationShardStrategy = "neuronetes.io/shard-strategy"
+
+	// AnnotationShardCount is the number of shards of the model an agent
+	// replica serves a shard of
+	AnnotationShardCount = "neuronetes.io/shard-count"
+
+	// AnnotationModelLayers is the number of transformer layers of the
+	// model an agent replica serves, which pipeline-parallel shards split
+	AnnotationModelLayers = "neuronetes.io/model-layers"
+
+	// AnnotationShardRank is the rank of the shard an agent replica serves,
+	// set by the scheduler as it binds the replica
+	AnnotationShardRank = "neuronetes.io/shard-rank"
+
+	// AnnotationShardGPUs is the comma-separated indexes of the GPUs of its
+	// node planned for the shard of an agent replica, e.g. 0,1
+	AnnotationShardGPUs = "neuronetes.io/shard-gpus"
+
+	// AnnotationShardLayerRange is the first and last layer a
+	// pipeline-parallel shard serves, e.g. 0-15
+	AnnotationShardLayerRange = "neuronetes.io/shard-layer-range"
+
+	// AnnotationRegistryModel is the name in its registry of a Model synced
+	// from a ModelRegistry
+	AnnotationRegistryModel = "neuronetes.io/registry-model"
+
+	// AnnotationRegistryVersion is the version in its registry of a Model
+	// synced from a ModelRegistry
+	AnnotationRegistryVersion = "neuronetes.io/registry-version"
+
+	// AnnotationAdapters lists the adapters loaded onto an agent replica,
+	// as name=revision pairs separated by commas
+	AnnotationAdapters = "neuronetes.io/adapters"
+
+	// AnnotationKeepAlways set to "true" on a Model keeps the garbage
+	// collector from evicting it from the node caches or deleting it while
+	// unused
+	AnnotationKeepAlways = "neuronetes.io/keep-always"
+)
+
+// Pod conditions of agent replicas
+const (
+	// PodConditionWarmedUp is the readiness gate of the replicas of pools
+	// with spec.warmUp, true once a replica ran its warm-up sequence
+	PodConditionWarmedUp = "neuronetes.io/warmed-up"
+)
@@ -1,16 +1,46 @@
 package v1alpha1
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 // ModelSpec defines the desired state of Model
 type ModelSpec struct {
-	// WeightsURI is the location of the model weights (e.g., s3://bucket/path)
+	// WeightsURI is the location of the model weights (e.g., s3://bucket/path,
+	// hf://org/model@revision or oci://registry/repository:tag)
 	// +kubebuilder:validation:Required
 	WeightsURI string `json:"weightsURI"`
 
+	// CredentialsSecretRef selects the key of a Secret in the namespace of
+	// the model holding the token of the weights' source, e.g. a HuggingFace
+	// access token for gated models
+	// +optional
+	CredentialsSecretRef *corev1.SecretKeySelector `json:"credentialsSecretRef,omitempty"`
+
+	// Mirrors are further locations of the same weights, such as regional
+	// mirrors or a fallback bucket. Downloads try WeightsURI and its mirrors
+	// by descending priority and fail over to the next when one fails.
+	// +optional
+	Mirrors []WeightsMirror `json:"mirrors,omitempty"`
+
+	// FilePatterns select the files of the weights to download by glob
+	// pattern, e.g. *.safetensors. Defaults to all files, or for hf:// URIs
+	// to the safetensors weights with their configuration and tokenizer.
+	// +optional
+	FilePatterns []string `json:"filePatterns,omitempty"`
+
+	// ImagePullSecrets are Docker config Secrets in the namespace of the
+	// model holding the credentials of the registry of oci:// weights
+	// +optional
+	ImagePullSecrets []corev1.LocalObjectReference `json:"imagePullSecrets,omitempty"`
+
+	// Integrity pins the content of the weights, which are verified before
+	// the model becomes Ready
+	// +optional
+	Integrity *ModelIntegrity `json:"integrity,omitempty"`
+
 	// Size is the total size of the model weights
 	// +kubebuilder:validation:Required
 	Size resource.Quantity `json:"size"`
@@ -36,9 +66,103 @@ type ModelSpec struct {
 	// +optional
 	Architecture string `json:"architecture,omitempty"`
 
-	// ParameterCount is the number of parameters in the model
+	// ParameterCount is the number of parameters in the model, e.g. 70B,
+	// 1.5B or 350M. With Quantization it sizes the weights in GPU memory.
 	// +optional
 	ParameterCount string `json:"parameterCount,omitempty"`
+
+	// Serving describes the sequences replicas serve, which size their KV
+	// cache
+	// +optional
+	Serving *ServingSpec `json:"serving,omitempty"`
+}
+
+// WeightsMirror is a further location of the weights of a model
+type WeightsMirror struct {
+	// URI is the location of the weights in the mirror, of any scheme
+	// WeightsURI supports
+	// +kubebuilder:validation:Required
+	URI string `json:"uri"`
+
+	// Priority orders the mirror among the sources of the weights, where
+	// WeightsURI has priority 0: mirrors above it are tried before
+	// WeightsURI, mirrors below it after. Mirrors of the same priority are
+	// tried in order.
+	// +optional
+	Priority int32 `json:"priority,omitempty"`
+
+	// CredentialsSecretRef selects the token of the mirror. The token of
+	// WeightsURI is not sent to mirrors.
+	// +optional
+	CredentialsSecretRef *corev1.SecretKeySelector `json:"credentialsSecretRef,omitempty"`
+}
+
+// ServingSpec describes the sequences replicas of a model serve at once.
+// The layer dimensions size the KV cache of each token; without them they
+// are estimated from ParameterCount as those of a model without grouped
+// query attention, which overestimates the KV cache of most recent models.
+type ServingSpec struct {
+	// ContextLength is the most tokens of a sequence, prompt and
+	// completion. Defaults to 4096.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	ContextLength int32 `json:"contextLength,omitempty"`
+
+	// MaxSequences is the most sequences a replica holds in its KV cache at
+	// full context length. Defaults to 1.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	MaxSequences int32 `json:"maxSequences,omitempty"`
+
+	// KVCacheType is the data type of the KV cache. Defaults to fp16, or
+	// fp32 for fp32 models.
+	// +kubebuilder:validation:Enum=fp32;fp16;fp8;int8
+	// +optional
+	KVCacheType string `json:"kvCacheType,omitempty"`
+
+	// NumLayers is the number of transformer layers of the model
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	NumLayers int32 `json:"numLayers,omitempty"`
+
+	// NumKVHeads is the number of key-value attention heads of each layer,
+	// fewer than the query heads with grouped query attention
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	NumKVHeads int32 `json:"numKVHeads,omitempty"`
+
+	// HeadDim is the dimension of each attention head
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	HeadDim int32 `json:"headDim,omitempty"`
+}
+
+// ModelIntegrity pins the content of the weights of a model
+type ModelIntegrity struct {
+	// SHA256 maps the path of each file of the weights, relative to the
+	// weights URI, to its hex-encoded SHA-256 digest. Every downloaded file
+	// must have a digest.
+	// +optional
+	SHA256 map[string]string `json:"sha256,omitempty"`
+
+	// Signature is a cosign signature of the digests
+	// +optional
+	Signature *WeightsSignature `json:"signature,omitempty"`
+}
+
+// WeightsSignature is a cosign signature of the digests of the weights,
+// which are signed as sha256sum lists them: one "<digest>  <path>" line per
+// file, sorted by path
+type WeightsSignature struct {
+	// PublicKey is the PEM-encoded public key of the cosign key pair
+	// (ECDSA, RSA or Ed25519), e.g. the content of cosign.pub
+	// +kubebuilder:validation:MinLength=1
+	PublicKey string `json:"publicKey"`
+
+	// Signature is the base64-encoded signature, as printed by cosign
+	// sign-blob
+	// +kubebuilder:validation:MinLength=1
+	Signature string `json:"signature"`
 }
 
 // ShardSpec defines model sharding configuration
@@ -58,11 +182,13 @@ type ShardSpec struct {
 
 // TopologyRequirement specifies GPU topology constraints
 type TopologyRequirement struct {
-	// Locality specifies the locality requirement
-	// +kubebuilder:validation:Enum=same-node;same-socket;nvlink;any
+	// Locality specifies the locality requirement. xgmi is the AMD
+	// Infinity Fabric counterpart of nvlink.
+	// +kubebuilder:validation:Enum=same-node;same-socket;nvlink;xgmi;any
 	Locality string `json:"locality"`
 
-	// MinBandwidth is the minimum inter-GPU bandwidth required (GB/s)
+	// MinBandwidth is the minimum bandwidth in GB/s between the GPUs of a
+	// replica, e.g. 600 for A100 NVLink
 	// +optional
 	MinBandwidth *resource.Quantity `json:"minBandwidth,omitempty"`
 }
@@ -85,6 +211,42 @@ type CachePolicy struct {
 	// +kubebuilder:validation:Enum=never;idle;low-priority
 	// +optional
 	EvictionPolicy string `json:"evictionPolicy,omitempty"`
+
+	// Volume caches the weights on a PersistentVolumeClaim rather than the
+	// local disks of nodes, for clusters without large local drives.
+	// Replicas serving the model mount it read-only.
+	// +optional
+	Volume *CacheVolume `json:"volume,omitempty"`
+}
+
+// Access modes of a CacheVolume
+const (
+	// CacheVolumeShared claims are mounted by replicas on every node
+	CacheVolumeShared = "ReadWriteMany"
+
+	// CacheVolumePerNode claims live on the node they are provisioned on,
+	// which replicas serving the model are scheduled onto
+	CacheVolumePerNode = "ReadWriteOnce"
+)
+
+// CacheVolume is a PersistentVolumeClaim caching the weights of a model
+type CacheVolume struct {
+	// StorageClassName is the storage class of the claim. Defaults to the
+	// default class of the cluster.
+	// +optional
+	StorageClassName *string `json:"storageClassName,omitempty"`
+
+	// AccessMode is ReadWriteMany for a claim shared by every node, or
+	// ReadWriteOnce for a claim on a single node
+	// +kubebuilder:validation:Enum=ReadWriteMany;ReadWriteOnce
+	// +kubebuilder:default=ReadWriteMany
+	// +optional
+	AccessMode string `json:"accessMode,omitempty"`
+
+	// Size is the capacity of the claim. Defaults to the size of the model
+	// with room for a second version of the weights.
+	// +optional
+	Size *resource.Quantity `json:"size,omitempty"`
 }
 
 // ModelStatus defines the observed state of Model
@@ -109,18 +271,73 @@ type ModelStatus struct {
 	// +optional
 	Conditions []metav1.Condition `json:"conditions,omitempty"`
 
+	// Download reports the download of the weights into the model cache
+	// +optional
+	Download *DownloadStatus `json:"download,omitempty"`
+
+	// Metadata is read from the headers and configuration of the
+	// downloaded weights. It defaults the parameter count, architecture and
+	// layer dimensions the spec leaves unset, and bounds the context length
+	// of the AgentClasses of the model.
+	// +optional
+	Metadata *ModelMetadata `json:"metadata,omitempty"`
+
+	// VRAM is the estimated GPU memory of a replica of the model, which
+	// replicas are bin packed onto GPUs by
+	// +optional
+	VRAM *VRAMEstimate `json:"vram,omitempty"`
+
+	// CacheVolume reports the weights cached on the claim of
+	// spec.cachePolicy.volume
+	// +optional
+	CacheVolume *CacheVolumeStatus `json:"cacheVolume,omitempty"`
+
 	// Version tracks the model version
 	// +optional
 	Version string `json:"version,omitempty"`
 }
 
+// CacheVolumeStatus reports the weights cached on the claim of a model
+type CacheVolumeStatus struct {
+	// ClaimName is the PersistentVolumeClaim caching the weights
+	ClaimName string `json:"claimName"`
+
+	// Version is the version of the weights replicas read from the claim,
+	// in the directory of that name. It changes once the weights of a new
+	// weights URI are populated.
+	// +optional
+	Version string `json:"version,omitempty"`
+
+	// Phase is the phase of the population of the current weights
+	// +kubebuilder:validation:Enum=Populating;Ready;Failed
+	// +optional
+	Phase string `json:"phase,omitempty"`
+
+	// Message explains the phase
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// Phases of a CacheVolumeStatus
+const (
+	// CacheVolumePopulating claims are being populated with the weights of
+	// the current weights URI
+	CacheVolumePopulating = "Populating"
+
+	// CacheVolumeReady claims hold the weights of the current weights URI
+	CacheVolumeReady = "Ready"
+
+	// CacheVolumeFailed claims failed to be populated
+	CacheVolumeFailed = "Failed"
+)
+
 // NodeCacheStatus represents caching status on a specific node
 type NodeCacheStatus struct {
 	// NodeName is the name of the node
 	NodeName string `json:"nodeName"`
 
 	// Status is the cache status on this node
-	// +kubebuilder:validation:Enum=loading;ready;evicting;failed
+	// +kubebuilder:validation:Enum=loading;ready;evicting;evicted;failed
 	Status string `json:"status"`
 
 	// CachedAt is when the model was cached on this node
@@ -130,6 +347,116 @@ type NodeCacheStatus struct {
 	// Size is the actual size cached on this node
 	// +optional
 	Size *resource.Quantity `json:"size,omitempty"`
+
+	// ProgressPercent is the download/load progress on this node (0-100)
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=100
+	// +optional
+	ProgressPercent *int32 `json:"progressPercent,omitempty"`
+
+	// LastUsed is when a pod on this node last served the model
+	// +optional
+	LastUsed *metav1.Time `json:"lastUsed,omitempty"`
+}
+
+// VRAMEstimate is the estimated GPU memory of a replica of a model
+type VRAMEstimate struct {
+	// Weights is the memory of the weights
+	Weights resource.Quantity `json:"weights"`
+
+	// KVCache is the memory of the KV cache at the context length and
+	// sequences of spec.serving. It is folded into Overhead if the size of
+	// the KV cache is unknown.
+	// +optional
+	KVCache *resource.Quantity `json:"kvCache,omitempty"`
+
+	// Overhead is the memory of activations and the runtime
+	Overhead resource.Quantity `json:"overhead"`
+
+	// Total is the memory of a replica
+	Total resource.Quantity `json:"total"`
+
+	// GPUs is the number of GPUs a replica spreads its memory over, the
+	// count of tensor- or pipeline-parallel shards
+	GPUs int32 `json:"gpus"`
+
+	// PerGPU is the memory of a replica on each of its GPUs
+	PerGPU resource.Quantity `json:"perGPU"`
+}
+
+// ModelMetadata describes a model as read from its weights. Fields that
+// could not be read are left unset.
+type ModelMetadata struct {
+	// Format is the format of the weights, gguf or safetensors
+	// +optional
+	Format string `json:"format,omitempty"`
+
+	// Architecture is the architecture of the model, e.g. llama
+	// +optional
+	Architecture string `json:"architecture,omitempty"`
+
+	// ParameterCount is the number of parameters of the model, e.g. 8.03B
+	// +optional
+	ParameterCount string `json:"parameterCount,omitempty"`
+
+	// ContextLength is the context window the model was trained with, in
+	// tokens
+	// +optional
+	ContextLength int32 `json:"contextLength,omitempty"`
+
+	// Tokenizer is the tokenizer of the model, e.g. BPE or llama
+	// +optional
+	Tokenizer string `json:"tokenizer,omitempty"`
+
+	// NumLayers is the number of transformer layers of the model
+	// +optional
+	NumLayers int32 `json:"numLayers,omitempty"`
+
+	// NumKVHeads is the number of key-value attention heads of each layer
+	// +optional
+	NumKVHeads int32 `json:"numKVHeads,omitempty"`
+
+	// HeadDim is the dimension of each attention head
+	// +optional
+	HeadDim int32 `json:"headDim,omitempty"`
+}
+
+// DownloadStatus reports the download of model weights into the model cache
+type DownloadStatus struct {
+	// Path is the directory of the weights in the model cache
+	Path string `json:"path"`
+
+	// Revision is the immutable revision the weights URI resolved to, e.g.
+	// the commit SHA of a HuggingFace repository, for reproducibility
+	// +optional
+	Revision string `json:"revision,omitempty"`
+
+	// Source is the URI the weights were downloaded from, WeightsURI or
+	// one of its mirrors
+	// +optional
+	Source string `json:"source,omitempty"`
+
+	// Files is the number of files of the weights, set once downloaded
+	// +optional
+	Files int32 `json:"files,omitempty"`
+
+	// BytesTotal is the size of the weights in bytes
+	// +optional
+	BytesTotal int64 `json:"bytesTotal,omitempty"`
+
+	// BytesDownloaded is the number of bytes of the weights downloaded
+	// +optional
+	BytesDownloaded int64 `json:"bytesDownloaded,omitempty"`
+
+	// ProgressPercent is the download progress (0-100)
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=100
+	// +optional
+	ProgressPercent int32 `json:"progressPercent,omitempty"`
+
+	// CompletedAt is when the download completed
+	// +optional
+	CompletedAt *metav1.Time `json:"completedAt,omitempty"`
 }
 
 // +kubebuilder:object:root=true
@@ -137,6 +464,7 @@ type NodeCacheStatus struct {
 // +kubebuilder:resource:scope=Namespaced,shortName=mdl
 // +kubebuilder:printcolumn:name="Size",type=string,JSONPath=`.spec.size`
 // +kubebuilder:printcolumn:name="Quantization",type=string,JSONPath=`.spec.quantization`
+// +kubebuilder:printcolumn:name="VRAM",type=string,JSONPath=`.status.vram.perGPU`
 // +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
 // +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
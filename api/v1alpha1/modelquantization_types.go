@@ -0,0 +1,147 @@
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Phases of a ModelQuantization
+const (
+	// QuantizationPending quantizations wait for their source model
+	QuantizationPending = "Pending"
+
+	// QuantizationRunning quantizations run their conversion Job
+	QuantizationRunning = "Running"
+
+	// QuantizationSucceeded quantizations uploaded the quantized weights
+	// and created the derived model
+	QuantizationSucceeded = "Succeeded"
+
+	// QuantizationFailed quantizations could not convert the weights of
+	// the current source model
+	QuantizationFailed = "Failed"
+)
+
+// ModelQuantizationSpec defines the desired state of ModelQuantization
+type ModelQuantizationSpec struct {
+	// SourceModelRef names the Model whose weights are quantized, in the
+	// namespace of the quantization
+	SourceModelRef corev1.LocalObjectReference `json:"sourceModelRef"`
+
+	// Quantization is the quantization format of the derived model
+	// +kubebuilder:validation:Enum=int8;int4
+	Quantization string `json:"quantization"`
+
+	// Method is the quantization algorithm run by the quantizer, e.g. gptq,
+	// awq or rtn. Defaults to the quantizer's choice for the format.
+	// +optional
+	Method string `json:"method,omitempty"`
+
+	// OutputURI is the object storage prefix the quantized weights are
+	// uploaded under (s3://, gs:// or az://). Each conversion uploads to
+	// <outputURI>/<revision of the source model>.
+	// +kubebuilder:validation:MinLength=1
+	OutputURI string `json:"outputURI"`
+
+	// OutputCredentialsSecretRef selects the key of a Secret in the
+	// namespace of the quantization holding the token the quantized weights
+	// are uploaded and downloaded with. It is copied to the derived model.
+	// +optional
+	OutputCredentialsSecretRef *corev1.SecretKeySelector `json:"outputCredentialsSecretRef,omitempty"`
+
+	// TargetModel is the name of the derived Model. Defaults to
+	// <source model>-<quantization>.
+	// +optional
+	TargetModel string `json:"targetModel,omitempty"`
+
+	// Image is the quantizer image converting the weights. Defaults to the
+	// quantizer image of the controller.
+	// +optional
+	Image string `json:"image,omitempty"`
+
+	// GPUs is the number of GPUs of the conversion Job. Defaults to 1.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	GPUs int32 `json:"gpus,omitempty"`
+
+	// NodeSelector selects the GPU nodes the conversion Job runs on
+	// +optional
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+
+	// ServiceAccountName is the service account of the conversion Job,
+	// e.g. one bound to a cloud identity with write access to outputURI
+	// +optional
+	ServiceAccountName string `json:"serviceAccountName,omitempty"`
+}
+
+// ModelQuantizationStatus defines the observed state of ModelQuantization
+type ModelQuantizationStatus struct {
+	// Phase is the phase of the quantization
+	// +kubebuilder:validation:Enum=Pending;Running;Succeeded;Failed
+	// +optional
+	Phase string `json:"phase,omitempty"`
+
+	// JobName is the conversion Job of the current source revision
+	// +optional
+	JobName string `json:"jobName,omitempty"`
+
+	// SourceRevision is the hash of the source model spec and quantization
+	// settings the derived model was converted from
+	// +optional
+	SourceRevision string `json:"sourceRevision,omitempty"`
+
+	// Model is the derived Model
+	// +optional
+	Model string `json:"model,omitempty"`
+
+	// Size is the total size of the quantized weights
+	// +optional
+	Size *resource.Quantity `json:"size,omitempty"`
+
+	// StartTime is when the current conversion Job was created
+	// +optional
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+
+	// CompletionTime is when the current conversion finished
+	// +optional
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+
+	// Message explains the phase
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:scope=Namespaced,shortName=mq
+// +kubebuilder:printcolumn:name="Source",type=string,JSONPath=`.spec.sourceModelRef.name`
+// +kubebuilder:printcolumn:name="Quantization",type=string,JSONPath=`.spec.quantization`
+// +kubebuilder:printcolumn:name="Model",type=string,JSONPath=`.status.model`
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// ModelQuantization is the Schema for the modelquantizations API. It
+// converts the weights of a Model to int8 or int4 in a Job on a GPU node,
+// uploads them, and keeps a derived Model serving them in step with the
+// source model.
+type ModelQuantization struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ModelQuantizationSpec   `json:"spec,omitempty"`
+	Status ModelQuantizationStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// ModelQuantizationList contains a list of ModelQuantization
+type ModelQuantizationList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ModelQuantization `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ModelQuantization{}, &ModelQuantizationList{})
+}
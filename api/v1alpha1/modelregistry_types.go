@@ -0,0 +1,143 @@
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ModelRegistrySpec defines the desired state of ModelRegistry
+type ModelRegistrySpec struct {
+	// Type is the type of the registry
+	// +kubebuilder:validation:Enum=HuggingFace;MLflow;HTTP
+	Type string `json:"type"`
+
+	// URL is the endpoint of the registry: the HuggingFace Hub (default:
+	// https://huggingface.co), the MLflow tracking server, or the URL
+	// listing the models of a custom registry
+	// +optional
+	URL string `json:"url,omitempty"`
+
+	// Namespace is the registry namespace synced: the author or
+	// organization of HuggingFace models, the prefix of the names of MLflow
+	// registered models, or the namespace query parameter of custom
+	// registries
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+
+	// CredentialsSecretRef selects the key of a Secret in the namespace of
+	// the registry holding its token. Synced models download their weights
+	// with it too.
+	// +optional
+	CredentialsSecretRef *corev1.SecretKeySelector `json:"credentialsSecretRef,omitempty"`
+
+	// Interval is the period between syncs (default: 10m)
+	// +optional
+	Interval *metav1.Duration `json:"interval,omitempty"`
+
+	// TagMappings set fields of synced models from registry tags. Fields
+	// without a mapping are set from the tag named after them, with
+	// parameterCount read from the parameters tag.
+	// +optional
+	TagMappings []TagMapping `json:"tagMappings,omitempty"`
+
+	// Template holds the fields of synced models the registry does not
+	// publish
+	// +optional
+	Template *RegistryModelTemplate `json:"template,omitempty"`
+}
+
+// TagMapping sets a field of synced models from a registry tag
+type TagMapping struct {
+	// Tag is the key of the tag, or a bare tag such as the HuggingFace tag
+	// gguf
+	// +kubebuilder:validation:MinLength=1
+	Tag string `json:"tag"`
+
+	// Field is the field of the Model spec set
+	// +kubebuilder:validation:Enum=quantization;format;architecture;parameterCount;size
+	Field string `json:"field"`
+
+	// Value sets the field when a model has the tag. Defaults to the value
+	// of the tag.
+	// +optional
+	Value string `json:"value,omitempty"`
+}
+
+// RegistryModelTemplate holds fields of the Models synced from a registry
+type RegistryModelTemplate struct {
+	// Labels are added to synced models
+	// +optional
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Annotations are added to synced models
+	// +optional
+	Annotations map[string]string `json:"annotations,omitempty"`
+
+	// FilePatterns select the files of the weights to download
+	// +optional
+	FilePatterns []string `json:"filePatterns,omitempty"`
+
+	// CachePolicy defines caching behavior for synced models
+	// +optional
+	CachePolicy *CachePolicy `json:"cachePolicy,omitempty"`
+
+	// ShardSpec defines how synced models are sharded across GPUs
+	// +optional
+	ShardSpec *ShardSpec `json:"shardSpec,omitempty"`
+
+	// Serving describes the sequences replicas of synced models serve
+	// +optional
+	Serving *ServingSpec `json:"serving,omitempty"`
+}
+
+// ModelRegistryStatus defines the observed state of ModelRegistry
+type ModelRegistryStatus struct {
+	// ObservedGeneration is the generation of the spec last synced
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Models is the number of models synced
+	// +optional
+	Models int32 `json:"models,omitempty"`
+
+	// LastSyncTime is when the registry was last synced
+	// +optional
+	LastSyncTime *metav1.Time `json:"lastSyncTime,omitempty"`
+
+	// Conditions represent the latest available observations
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:scope=Namespaced,shortName=mreg
+// +kubebuilder:printcolumn:name="Type",type=string,JSONPath=`.spec.type`
+// +kubebuilder:printcolumn:name="Namespace",type=string,JSONPath=`.spec.namespace`
+// +kubebuilder:printcolumn:name="Models",type=integer,JSONPath=`.status.models`
+// +kubebuilder:printcolumn:name="Last Sync",type=date,JSONPath=`.status.lastSyncTime`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// ModelRegistry is the Schema for the modelregistries API. It periodically
+// syncs the models published in a registry namespace into Models, creating,
+// updating and deleting them as the registry changes.
+type ModelRegistry struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ModelRegistrySpec   `json:"spec,omitempty"`
+	Status ModelRegistryStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// ModelRegistryList contains a list of ModelRegistry
+type ModelRegistryList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ModelRegistry `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ModelRegistry{}, &ModelRegistryList{})
+}
@@ -0,0 +1,242 @@
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Strategies of a ModelRollout
+const (
+	// RolloutStrategyCanary shifts traffic to the new weights in steps,
+	// with canary replicas sized to their share of the traffic
+	RolloutStrategyCanary = "canary"
+
+	// RolloutStrategyBlueGreen shifts all traffic at once to a full copy of
+	// each pool running the new weights, keeping the old replicas running
+	// to roll back to until promoted
+	RolloutStrategyBlueGreen = "blue-green"
+)
+
+// Phases of a ModelRollout
+const (
+	// RolloutPending rollouts wait for the new weights to be loaded
+	RolloutPending = "Pending"
+
+	// RolloutProgressing rollouts shift traffic to the new weights
+	RolloutProgressing = "Progressing"
+
+	// RolloutPromoting rollouts wait for the pools to roll to the new
+	// weights of the model
+	RolloutPromoting = "Promoting"
+
+	// RolloutPromoted rollouts set the new weights on the model
+	RolloutPromoted = "Promoted"
+
+	// RolloutRolledBack rollouts shifted all traffic back to the current
+	// weights
+	RolloutRolledBack = "RolledBack"
+
+	// RolloutFailed rollouts could not start or load the new weights
+	RolloutFailed = "Failed"
+)
+
+// ModelRolloutSpec defines the desired state of ModelRollout
+type ModelRolloutSpec struct {
+	// ModelRef names the Model to upgrade, in the namespace of the rollout.
+	// The pools of the namespace running an AgentClass of the model take
+	// part in the rollout.
+	ModelRef corev1.LocalObjectReference `json:"modelRef"`
+
+	// WeightsURI is the location of the new weights
+	// +kubebuilder:validation:MinLength=1
+	WeightsURI string `json:"weightsURI"`
+
+	// Size is the total size of the new weights. Defaults to the size of
+	// the model.
+	// +optional
+	Size *resource.Quantity `json:"size,omitempty"`
+
+	// Integrity pins the content of the new weights
+	// +optional
+	Integrity *ModelIntegrity `json:"integrity,omitempty"`
+
+	// Strategy is how traffic shifts to the new weights
+	// +kubebuilder:validation:Enum=canary;blue-green
+	// +kubebuilder:default=canary
+	// +optional
+	Strategy string `json:"strategy,omitempty"`
+
+	// Steps are the percentages of traffic shifted to the new weights, in
+	// order. Each step lasts until its analysis passes, and the weights are
+	// promoted after the last. Defaults to 10 and 50; blue-green rollouts
+	// shift all traffic in a single step.
+	// +kubebuilder:validation:items:Minimum=1
+	// +kubebuilder:validation:items:Maximum=100
+	// +optional
+	Steps []int32 `json:"steps,omitempty"`
+
+	// Analysis decides whether the new weights are promoted or rolled back
+	// +optional
+	Analysis *RolloutAnalysis `json:"analysis,omitempty"`
+}
+
+// RolloutAnalysis compares the replicas running the new weights with those
+// running the current weights
+type RolloutAnalysis struct {
+	// Interval is how often the replicas are compared, over the requests
+	// of the interval. Defaults to 5m.
+	// +optional
+	Interval *metav1.Duration `json:"interval,omitempty"`
+
+	// MinRequests is the number of requests the new weights must serve in
+	// an interval before they are judged. Defaults to 100.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MinRequests *int32 `json:"minRequests,omitempty"`
+
+	// MaxErrorRate is the highest error rate (0-1) of the new weights
+	// tolerated. Defaults to 0.05.
+	// +optional
+	MaxErrorRate *float32 `json:"maxErrorRate,omitempty"`
+
+	// MaxLatencyRatio is the highest ratio of the p95 latency of the new
+	// weights to that of the current weights tolerated. Defaults to 1.5.
+	// Zero disables the latency check.
+	// +optional
+	MaxLatencyRatio *float32 `json:"maxLatencyRatio,omitempty"`
+
+	// MinQualityWinRate is the lowest quality win rate (0-1) of the new
+	// weights against the current weights tolerated, as reported by the
+	// agent_quality_winrate metric of the new replicas. Quality is not
+	// compared if unset.
+	// +optional
+	MinQualityWinRate *float32 `json:"minQualityWinRate,omitempty"`
+
+	// HealthyAnalyses is the number of healthy analyses each step needs to
+	// pass. Defaults to 2.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	HealthyAnalyses int32 `json:"healthyAnalyses,omitempty"`
+}
+
+// ModelRolloutStatus defines the observed state of ModelRollout
+type ModelRolloutStatus struct {
+	// Phase is the phase of the rollout
+	// +kubebuilder:validation:Enum=Pending;Progressing;Promoting;Promoted;RolledBack;Failed
+	// +optional
+	Phase string `json:"phase,omitempty"`
+
+	// CanaryModel is the Model serving the new weights until they are
+	// promoted
+	// +optional
+	CanaryModel string `json:"canaryModel,omitempty"`
+
+	// Pools lists the pools taking part in the rollout
+	// +optional
+	Pools []RolloutPool `json:"pools,omitempty"`
+
+	// Step is the index of the current step
+	// +optional
+	Step int32 `json:"step"`
+
+	// Weight is the percentage of traffic routed to the new weights
+	// +optional
+	Weight int32 `json:"weight"`
+
+	// StepStartTime is when the traffic of the current step started
+	// shifting
+	// +optional
+	StepStartTime *metav1.Time `json:"stepStartTime,omitempty"`
+
+	// HealthyAnalyses is the number of healthy analyses of the current
+	// step
+	// +optional
+	HealthyAnalyses int32 `json:"healthyAnalyses,omitempty"`
+
+	// LastAnalysis is the result of the latest analysis
+	// +optional
+	LastAnalysis *RolloutAnalysisResult `json:"lastAnalysis,omitempty"`
+
+	// Message explains the phase
+	// +optional
+	Message string `json:"message,omitempty"`
+
+	// CompletionTime is when the rollout was promoted, rolled back or
+	// failed
+	// +optional
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+}
+
+// RolloutPool is a pool taking part in a rollout
+type RolloutPool struct {
+	// Name is the name of the pool running the current weights
+	Name string `json:"name"`
+
+	// Canary is the name of the pool running the new weights
+	Canary string `json:"canary"`
+}
+
+// RolloutAnalysisResult is the result of an analysis of a rollout
+type RolloutAnalysisResult struct {
+	// Time is when the analysis ran
+	Time metav1.Time `json:"time"`
+
+	// Verdict is Continue, Promote or Rollback
+	Verdict string `json:"verdict"`
+
+	// Reason explains the verdict
+	// +optional
+	Reason string `json:"reason,omitempty"`
+
+	// Requests is the number of requests the new weights served
+	// +optional
+	Requests int64 `json:"requests,omitempty"`
+
+	// ErrorRate is the error rate of the new weights, e.g. 0.012
+	// +optional
+	ErrorRate string `json:"errorRate,omitempty"`
+
+	// LatencyRatio is the ratio of the p95 latency of the new weights to
+	// that of the current weights, e.g. 1.08
+	// +optional
+	LatencyRatio string `json:"latencyRatio,omitempty"`
+
+	// QualityWinRate is the quality win rate of the new weights, e.g. 0.54
+	// +optional
+	QualityWinRate string `json:"qualityWinRate,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:scope=Namespaced,shortName=mro
+// +kubebuilder:printcolumn:name="Model",type=string,JSONPath=`.spec.modelRef.name`
+// +kubebuilder:printcolumn:name="Strategy",type=string,JSONPath=`.spec.strategy`
+// +kubebuilder:printcolumn:name="Weight",type=integer,JSONPath=`.status.weight`
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// ModelRollout is the Schema for the modelrollouts API. It upgrades the
+// weights of a Model by shifting traffic to replicas running the new
+// weights, and promotes or rolls them back by their error rate, latency and
+// quality against the current weights.
+type ModelRollout struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ModelRolloutSpec   `json:"spec,omitempty"`
+	Status ModelRolloutStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// ModelRolloutList contains a list of ModelRollout
+type ModelRolloutList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ModelRollout `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ModelRollout{}, &ModelRolloutList{})
+}
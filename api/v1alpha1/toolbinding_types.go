@@ -4,6 +4,42 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+// Types of a ToolBinding
+const (
+	// ToolBindingQueue bindings consume a queue
+	ToolBindingQueue = "queue"
+
+	// ToolBindingTopic bindings consume a topic
+	ToolBindingTopic = "topic"
+
+	// ToolBindingWebhook bindings receive webhooks
+	ToolBindingWebhook = "webhook"
+
+	// ToolBindingGRPC bindings are served by the gateway over gRPC on their
+	// grpcConfig authority
+	ToolBindingGRPC = "grpc"
+
+	// ToolBindingHTTP bindings are served by the gateway on their
+	// httpConfig path
+	ToolBindingHTTP = "http"
+
+	// ToolBindingWebSocket bindings are served by the gateway as WebSockets
+	// on their websocketConfig path
+	ToolBindingWebSocket = "websocket"
+)
+
+// Phases of a ToolBinding
+const (
+	// ToolBindingPending bindings wait for their AgentPool
+	ToolBindingPending = "Pending"
+
+	// ToolBindingActive bindings route traffic to their AgentPool
+	ToolBindingActive = "Active"
+
+	// ToolBindingFailed bindings have an invalid or conflicting config
+	ToolBindingFailed = "Failed"
+)
+
 // ToolBindingSpec defines the desired state of ToolBinding
 type ToolBindingSpec struct {
 	// AgentPoolRef references the AgentPool this binding applies to
@@ -11,7 +47,7 @@ type ToolBindingSpec struct {
 	AgentPoolRef AgentPoolReference `json:"agentPoolRef"`
 
 	// Type is the binding type
-	// +kubebuilder:validation:Enum=queue;topic;webhook;grpc;http
+	// +kubebuilder:validation:Enum=queue;topic;webhook;grpc;http;websocket
 	Type string `json:"type"`
 
 	// QueueConfig for queue-based bindings
@@ -26,6 +62,14 @@ type ToolBindingSpec struct {
 	// +optional
 	HTTPConfig *HTTPConfig `json:"httpConfig,omitempty"`
 
+	// WebSocketConfig for WebSocket bindings
+	// +optional
+	WebSocketConfig *WebSocketConfig `json:"websocketConfig,omitempty"`
+
+	// GRPCConfig for gRPC bindings
+	// +optional
+	GRPCConfig *GRPCConfig `json:"grpcConfig,omitempty"`
+
 	// Concurrency limits
 	// +optional
 	Concurrency *ConcurrencyConfig `json:"concurrency,omitempty"`
@@ -67,7 +111,9 @@ type QueueConfig struct {
 	// +optional
 	AutoscaleOnLag bool `json:"autoscaleOnLag,omitempty"`
 
-	// MaxLagThreshold is the lag threshold for scaling (messages)
+	// MaxLagThreshold is the lag per replica the pool is scaled to hold
+	// (messages). Defaults to 100.
+	// +kubebuilder:validation:Minimum=1
 	// +optional
 	MaxLagThreshold *int32 `json:"maxLagThreshold,omitempty"`
 
@@ -106,6 +152,12 @@ type TopicConfig struct {
 	// AutoscaleOnLag enables autoscaling based on topic lag
 	// +optional
 	AutoscaleOnLag bool `json:"autoscaleOnLag,omitempty"`
+
+	// MaxLagThreshold is the consumer lag per replica the pool is scaled to
+	// hold (messages). Defaults to 100.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	MaxLagThreshold *int32 `json:"maxLagThreshold,omitempty"`
 }
 
 // HTTPConfig defines HTTP-based binding configuration
@@ -149,6 +201,41 @@ type CORSConfig struct {
 	MaxAge *int32 `json:"maxAge,omitempty"`
 }
 
+// WebSocketConfig defines WebSocket binding configuration. Each connection
+// counts as one request against the concurrency limits of the binding.
+type WebSocketConfig struct {
+	// Path is the HTTP path clients open WebSockets on
+	// +kubebuilder:validation:Required
+	Path string `json:"path"`
+
+	// AllowedOrigins are the origins browsers may open WebSockets from,
+	// besides the host of the gateway. "*" allows any origin.
+	// +optional
+	AllowedOrigins []string `json:"allowedOrigins,omitempty"`
+
+	// PingInterval is how often both ends of a connection are pinged. Ends
+	// that answer nothing for two intervals are disconnected. Defaults to
+	// 30s.
+	// +optional
+	PingInterval *metav1.Duration `json:"pingInterval,omitempty"`
+
+	// MigrationGracePeriod is how long a draining replica may finish the
+	// messages it is sending after its sessions moved to another replica.
+	// Defaults to 10s.
+	// +optional
+	MigrationGracePeriod *metav1.Duration `json:"migrationGracePeriod,omitempty"`
+}
+
+// GRPCConfig defines gRPC binding configuration. The gateway serves the
+// neuronetes.inference.v1.Inference service for gRPC bindings.
+type GRPCConfig struct {
+	// Authority is the :authority, a host and optional port, clients call
+	// the binding on. Calls on the authority of no binding go to the binding
+	// without one.
+	// +optional
+	Authority string `json:"authority,omitempty"`
+}
+
 // ConcurrencyConfig defines concurrency limits
 type ConcurrencyConfig struct {
 	// MaxConcurrentRequests is the max concurrent requests per replica
@@ -205,7 +292,7 @@ type RetryPolicy struct {
 // ToolBindingStatus defines the observed state of ToolBinding
 type ToolBindingStatus struct {
 	// Phase represents the current phase
-	// +kubebuilder:validation:Enum=Pending;Active;Failed;Terminating
+	// +kubebuilder:validation:Enum=Pending;Active;Failed
 	Phase string `json:"phase"`
 
 	// ActiveConnections is the number of active connections
@@ -21,10 +21,112 @@ limitations under the License.
 package v1alpha1
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Adapter) DeepCopyInto(out *Adapter) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	out.Status = in.Status
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Adapter.
+func (in *Adapter) DeepCopy() *Adapter {
+	if in == nil {
+		return nil
+	}
+	out := new(Adapter)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *Adapter) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AdapterList) DeepCopyInto(out *AdapterList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]Adapter, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AdapterList.
+func (in *AdapterList) DeepCopy() *AdapterList {
+	if in == nil {
+		return nil
+	}
+	out := new(AdapterList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *AdapterList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AdapterSpec) DeepCopyInto(out *AdapterSpec) {
+	*out = *in
+	out.BaseModelRef = in.BaseModelRef
+	if in.CredentialsSecretRef != nil {
+		in, out := &in.CredentialsSecretRef, &out.CredentialsSecretRef
+		*out = new(corev1.SecretKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Scaling != nil {
+		in, out := &in.Scaling, &out.Scaling
+		*out = new(float32)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AdapterSpec.
+func (in *AdapterSpec) DeepCopy() *AdapterSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(AdapterSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AdapterStatus) DeepCopyInto(out *AdapterStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AdapterStatus.
+func (in *AdapterStatus) DeepCopy() *AdapterStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(AdapterStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *AgentClass) DeepCopyInto(out *AgentClass) {
 	*out = *in
@@ -254,6 +356,11 @@ func (in *AgentPoolReference) DeepCopy() *AgentPoolReference {
 func (in *AgentPoolSpec) DeepCopyInto(out *AgentPoolSpec) {
 	*out = *in
 	out.AgentClassRef = in.AgentClassRef
+	if in.Replicas != nil {
+		in, out := &in.Replicas, &out.Replicas
+		*out = new(int32)
+		**out = **in
+	}
 	if in.TokensPerSecondBudget != nil {
 		in, out := &in.TokensPerSecondBudget, &out.TokensPerSecondBudget
 		*out = new(int32)
@@ -279,6 +386,21 @@ func (in *AgentPoolSpec) DeepCopyInto(out *AgentPoolSpec) {
 		*out = new(SchedulingConfig)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.Rollout != nil {
+		in, out := &in.Rollout, &out.Rollout
+		*out = new(RolloutStrategy)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Snapshot != nil {
+		in, out := &in.Snapshot, &out.Snapshot
+		*out = new(SnapshotConfig)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.WarmUp != nil {
+		in, out := &in.WarmUp, &out.WarmUp
+		*out = new(WarmUpConfig)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AgentPoolSpec.
@@ -294,6 +416,11 @@ func (in *AgentPoolSpec) DeepCopy() *AgentPoolSpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *AgentPoolStatus) DeepCopyInto(out *AgentPoolStatus) {
 	*out = *in
+	if in.LastWarmUpDuration != nil {
+		in, out := &in.LastWarmUpDuration, &out.LastWarmUpDuration
+		*out = new(v1.Duration)
+		**out = **in
+	}
 	if in.CurrentTokensPerSecond != nil {
 		in, out := &in.CurrentTokensPerSecond, &out.CurrentTokensPerSecond
 		*out = new(int32)
@@ -310,6 +437,23 @@ func (in *AgentPoolStatus) DeepCopyInto(out *AgentPoolStatus) {
 		in, out := &in.LastScaleTime, &out.LastScaleTime
 		*out = (*in).DeepCopy()
 	}
+	if in.Recommendation != nil {
+		in, out := &in.Recommendation, &out.Recommendation
+		*out = new(ScalingRecommendation)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ScalingHistory != nil {
+		in, out := &in.ScalingHistory, &out.ScalingHistory
+		*out = make([]ScalingRecommendation, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.GPURecommendation != nil {
+		in, out := &in.GPURecommendation, &out.GPURecommendation
+		*out = new(GPURecommendation)
+		(*in).DeepCopyInto(*out)
+	}
 	if in.Conditions != nil {
 		in, out := &in.Conditions, &out.Conditions
 		*out = make([]v1.Condition, len(*in))
@@ -337,6 +481,16 @@ func (in *AutoscalingMetric) DeepCopyInto(out *AutoscalingMetric) {
 		*out = new(v1.Duration)
 		**out = **in
 	}
+	if in.Weight != nil {
+		in, out := &in.Weight, &out.Weight
+		*out = new(int32)
+		**out = **in
+	}
+	if in.TolerancePercent != nil {
+		in, out := &in.TolerancePercent, &out.TolerancePercent
+		*out = new(int32)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AutoscalingMetric.
@@ -369,6 +523,23 @@ func (in *AutoscalingSpec) DeepCopyInto(out *AutoscalingSpec) {
 		*out = new(v1.Duration)
 		**out = **in
 	}
+	if in.MinHeadroomPercent != nil {
+		in, out := &in.MinHeadroomPercent, &out.MinHeadroomPercent
+		*out = new(int32)
+		**out = **in
+	}
+	if in.MaxGPUUtilizationPercent != nil {
+		in, out := &in.MaxGPUUtilizationPercent, &out.MaxGPUUtilizationPercent
+		*out = new(int32)
+		**out = **in
+	}
+	if in.Schedules != nil {
+		in, out := &in.Schedules, &out.Schedules
+		*out = make([]ScalingSchedule, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AutoscalingSpec.
@@ -381,6 +552,26 @@ func (in *AutoscalingSpec) DeepCopy() *AutoscalingSpec {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BurstPolicy) DeepCopyInto(out *BurstPolicy) {
+	*out = *in
+	if in.ThresholdMultiplier != nil {
+		in, out := &in.ThresholdMultiplier, &out.ThresholdMultiplier
+		*out = new(int32)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BurstPolicy.
+func (in *BurstPolicy) DeepCopy() *BurstPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(BurstPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *CORSConfig) DeepCopyInto(out *CORSConfig) {
 	*out = *in
@@ -429,6 +620,11 @@ func (in *CachePolicy) DeepCopyInto(out *CachePolicy) {
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.Volume != nil {
+		in, out := &in.Volume, &out.Volume
+		*out = new(CacheVolume)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CachePolicy.
@@ -441,6 +637,46 @@ func (in *CachePolicy) DeepCopy() *CachePolicy {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CacheVolume) DeepCopyInto(out *CacheVolume) {
+	*out = *in
+	if in.StorageClassName != nil {
+		in, out := &in.StorageClassName, &out.StorageClassName
+		*out = new(string)
+		**out = **in
+	}
+	if in.Size != nil {
+		in, out := &in.Size, &out.Size
+		x := (*in).DeepCopy()
+		*out = &x
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CacheVolume.
+func (in *CacheVolume) DeepCopy() *CacheVolume {
+	if in == nil {
+		return nil
+	}
+	out := new(CacheVolume)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CacheVolumeStatus) DeepCopyInto(out *CacheVolumeStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CacheVolumeStatus.
+func (in *CacheVolumeStatus) DeepCopy() *CacheVolumeStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(CacheVolumeStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ConcurrencyConfig) DeepCopyInto(out *ConcurrencyConfig) {
 	*out = *in
@@ -515,6 +751,21 @@ func (in *CurrentMetric) DeepCopy() *CurrentMetric {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DRAConfig) DeepCopyInto(out *DRAConfig) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DRAConfig.
+func (in *DRAConfig) DeepCopy() *DRAConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(DRAConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *DataLocalityConfig) DeepCopyInto(out *DataLocalityConfig) {
 	*out = *in
@@ -545,6 +796,43 @@ func (in *DataLocalityConfig) DeepCopy() *DataLocalityConfig {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DownloadStatus) DeepCopyInto(out *DownloadStatus) {
+	*out = *in
+	if in.CompletedAt != nil {
+		in, out := &in.CompletedAt, &out.CompletedAt
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DownloadStatus.
+func (in *DownloadStatus) DeepCopy() *DownloadStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(DownloadStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GPURecommendation) DeepCopyInto(out *GPURecommendation) {
+	*out = *in
+	in.Target.DeepCopyInto(&out.Target)
+	out.PeakVRAM = in.PeakVRAM.DeepCopy()
+	in.Time.DeepCopyInto(&out.Time)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GPURecommendation.
+func (in *GPURecommendation) DeepCopy() *GPURecommendation {
+	if in == nil {
+		return nil
+	}
+	out := new(GPURecommendation)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *GPURequirements) DeepCopyInto(out *GPURequirements) {
 	*out = *in
@@ -553,6 +841,16 @@ func (in *GPURequirements) DeepCopyInto(out *GPURequirements) {
 		*out = new(TopologyRequirement)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.DRA != nil {
+		in, out := &in.DRA, &out.DRA
+		*out = new(DRAConfig)
+		**out = **in
+	}
+	if in.Sharing != nil {
+		in, out := &in.Sharing, &out.Sharing
+		*out = new(GPUSharing)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GPURequirements.
@@ -565,6 +863,36 @@ func (in *GPURequirements) DeepCopy() *GPURequirements {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GPUSharing) DeepCopyInto(out *GPUSharing) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GPUSharing.
+func (in *GPUSharing) DeepCopy() *GPUSharing {
+	if in == nil {
+		return nil
+	}
+	out := new(GPUSharing)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GRPCConfig) DeepCopyInto(out *GRPCConfig) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GRPCConfig.
+func (in *GRPCConfig) DeepCopy() *GRPCConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(GRPCConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Guardrail) DeepCopyInto(out *Guardrail) {
 	*out = *in
@@ -669,6 +997,33 @@ func (in *Model) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ModelIntegrity) DeepCopyInto(out *ModelIntegrity) {
+	*out = *in
+	if in.SHA256 != nil {
+		in, out := &in.SHA256, &out.SHA256
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Signature != nil {
+		in, out := &in.Signature, &out.Signature
+		*out = new(WeightsSignature)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ModelIntegrity.
+func (in *ModelIntegrity) DeepCopy() *ModelIntegrity {
+	if in == nil {
+		return nil
+	}
+	out := new(ModelIntegrity)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ModelList) DeepCopyInto(out *ModelList) {
 	*out = *in
@@ -702,38 +1057,448 @@ func (in *ModelList) DeepCopyObject() runtime.Object {
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *ModelReference) DeepCopyInto(out *ModelReference) {
+func (in *ModelMetadata) DeepCopyInto(out *ModelMetadata) {
 	*out = *in
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ModelReference.
-func (in *ModelReference) DeepCopy() *ModelReference {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ModelMetadata.
+func (in *ModelMetadata) DeepCopy() *ModelMetadata {
 	if in == nil {
 		return nil
 	}
-	out := new(ModelReference)
+	out := new(ModelMetadata)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *ModelSpec) DeepCopyInto(out *ModelSpec) {
+func (in *ModelQuantization) DeepCopyInto(out *ModelQuantization) {
 	*out = *in
-	out.Size = in.Size.DeepCopy()
-	if in.ShardSpec != nil {
-		in, out := &in.ShardSpec, &out.ShardSpec
-		*out = new(ShardSpec)
-		(*in).DeepCopyInto(*out)
-	}
-	if in.CachePolicy != nil {
-		in, out := &in.CachePolicy, &out.CachePolicy
-		*out = new(CachePolicy)
-		(*in).DeepCopyInto(*out)
-	}
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ModelSpec.
-func (in *ModelSpec) DeepCopy() *ModelSpec {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ModelQuantization.
+func (in *ModelQuantization) DeepCopy() *ModelQuantization {
+	if in == nil {
+		return nil
+	}
+	out := new(ModelQuantization)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ModelQuantization) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ModelQuantizationList) DeepCopyInto(out *ModelQuantizationList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]ModelQuantization, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ModelQuantizationList.
+func (in *ModelQuantizationList) DeepCopy() *ModelQuantizationList {
+	if in == nil {
+		return nil
+	}
+	out := new(ModelQuantizationList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ModelQuantizationList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ModelQuantizationSpec) DeepCopyInto(out *ModelQuantizationSpec) {
+	*out = *in
+	out.SourceModelRef = in.SourceModelRef
+	if in.OutputCredentialsSecretRef != nil {
+		in, out := &in.OutputCredentialsSecretRef, &out.OutputCredentialsSecretRef
+		*out = new(corev1.SecretKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.NodeSelector != nil {
+		in, out := &in.NodeSelector, &out.NodeSelector
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ModelQuantizationSpec.
+func (in *ModelQuantizationSpec) DeepCopy() *ModelQuantizationSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ModelQuantizationSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ModelQuantizationStatus) DeepCopyInto(out *ModelQuantizationStatus) {
+	*out = *in
+	if in.Size != nil {
+		in, out := &in.Size, &out.Size
+		x := (*in).DeepCopy()
+		*out = &x
+	}
+	if in.StartTime != nil {
+		in, out := &in.StartTime, &out.StartTime
+		*out = (*in).DeepCopy()
+	}
+	if in.CompletionTime != nil {
+		in, out := &in.CompletionTime, &out.CompletionTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ModelQuantizationStatus.
+func (in *ModelQuantizationStatus) DeepCopy() *ModelQuantizationStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ModelQuantizationStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ModelReference) DeepCopyInto(out *ModelReference) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ModelReference.
+func (in *ModelReference) DeepCopy() *ModelReference {
+	if in == nil {
+		return nil
+	}
+	out := new(ModelReference)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ModelRegistry) DeepCopyInto(out *ModelRegistry) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ModelRegistry.
+func (in *ModelRegistry) DeepCopy() *ModelRegistry {
+	if in == nil {
+		return nil
+	}
+	out := new(ModelRegistry)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ModelRegistry) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ModelRegistryList) DeepCopyInto(out *ModelRegistryList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]ModelRegistry, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ModelRegistryList.
+func (in *ModelRegistryList) DeepCopy() *ModelRegistryList {
+	if in == nil {
+		return nil
+	}
+	out := new(ModelRegistryList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ModelRegistryList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ModelRegistrySpec) DeepCopyInto(out *ModelRegistrySpec) {
+	*out = *in
+	if in.CredentialsSecretRef != nil {
+		in, out := &in.CredentialsSecretRef, &out.CredentialsSecretRef
+		*out = new(corev1.SecretKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Interval != nil {
+		in, out := &in.Interval, &out.Interval
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.TagMappings != nil {
+		in, out := &in.TagMappings, &out.TagMappings
+		*out = make([]TagMapping, len(*in))
+		copy(*out, *in)
+	}
+	if in.Template != nil {
+		in, out := &in.Template, &out.Template
+		*out = new(RegistryModelTemplate)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ModelRegistrySpec.
+func (in *ModelRegistrySpec) DeepCopy() *ModelRegistrySpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ModelRegistrySpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ModelRegistryStatus) DeepCopyInto(out *ModelRegistryStatus) {
+	*out = *in
+	if in.LastSyncTime != nil {
+		in, out := &in.LastSyncTime, &out.LastSyncTime
+		*out = (*in).DeepCopy()
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ModelRegistryStatus.
+func (in *ModelRegistryStatus) DeepCopy() *ModelRegistryStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ModelRegistryStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ModelRollout) DeepCopyInto(out *ModelRollout) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ModelRollout.
+func (in *ModelRollout) DeepCopy() *ModelRollout {
+	if in == nil {
+		return nil
+	}
+	out := new(ModelRollout)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ModelRollout) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ModelRolloutList) DeepCopyInto(out *ModelRolloutList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]ModelRollout, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ModelRolloutList.
+func (in *ModelRolloutList) DeepCopy() *ModelRolloutList {
+	if in == nil {
+		return nil
+	}
+	out := new(ModelRolloutList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ModelRolloutList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ModelRolloutSpec) DeepCopyInto(out *ModelRolloutSpec) {
+	*out = *in
+	out.ModelRef = in.ModelRef
+	if in.Size != nil {
+		in, out := &in.Size, &out.Size
+		x := (*in).DeepCopy()
+		*out = &x
+	}
+	if in.Integrity != nil {
+		in, out := &in.Integrity, &out.Integrity
+		*out = new(ModelIntegrity)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Steps != nil {
+		in, out := &in.Steps, &out.Steps
+		*out = make([]int32, len(*in))
+		copy(*out, *in)
+	}
+	if in.Analysis != nil {
+		in, out := &in.Analysis, &out.Analysis
+		*out = new(RolloutAnalysis)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ModelRolloutSpec.
+func (in *ModelRolloutSpec) DeepCopy() *ModelRolloutSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ModelRolloutSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ModelRolloutStatus) DeepCopyInto(out *ModelRolloutStatus) {
+	*out = *in
+	if in.Pools != nil {
+		in, out := &in.Pools, &out.Pools
+		*out = make([]RolloutPool, len(*in))
+		copy(*out, *in)
+	}
+	if in.StepStartTime != nil {
+		in, out := &in.StepStartTime, &out.StepStartTime
+		*out = (*in).DeepCopy()
+	}
+	if in.LastAnalysis != nil {
+		in, out := &in.LastAnalysis, &out.LastAnalysis
+		*out = new(RolloutAnalysisResult)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.CompletionTime != nil {
+		in, out := &in.CompletionTime, &out.CompletionTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ModelRolloutStatus.
+func (in *ModelRolloutStatus) DeepCopy() *ModelRolloutStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ModelRolloutStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ModelSpec) DeepCopyInto(out *ModelSpec) {
+	*out = *in
+	if in.CredentialsSecretRef != nil {
+		in, out := &in.CredentialsSecretRef, &out.CredentialsSecretRef
+		*out = new(corev1.SecretKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Mirrors != nil {
+		in, out := &in.Mirrors, &out.Mirrors
+		*out = make([]WeightsMirror, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.FilePatterns != nil {
+		in, out := &in.FilePatterns, &out.FilePatterns
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ImagePullSecrets != nil {
+		in, out := &in.ImagePullSecrets, &out.ImagePullSecrets
+		*out = make([]corev1.LocalObjectReference, len(*in))
+		copy(*out, *in)
+	}
+	if in.Integrity != nil {
+		in, out := &in.Integrity, &out.Integrity
+		*out = new(ModelIntegrity)
+		(*in).DeepCopyInto(*out)
+	}
+	out.Size = in.Size.DeepCopy()
+	if in.ShardSpec != nil {
+		in, out := &in.ShardSpec, &out.ShardSpec
+		*out = new(ShardSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.CachePolicy != nil {
+		in, out := &in.CachePolicy, &out.CachePolicy
+		*out = new(CachePolicy)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Serving != nil {
+		in, out := &in.Serving, &out.Serving
+		*out = new(ServingSpec)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ModelSpec.
+func (in *ModelSpec) DeepCopy() *ModelSpec {
 	if in == nil {
 		return nil
 	}
@@ -768,98 +1533,292 @@ func (in *ModelStatus) DeepCopyInto(out *ModelStatus) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.Download != nil {
+		in, out := &in.Download, &out.Download
+		*out = new(DownloadStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Metadata != nil {
+		in, out := &in.Metadata, &out.Metadata
+		*out = new(ModelMetadata)
+		**out = **in
+	}
+	if in.VRAM != nil {
+		in, out := &in.VRAM, &out.VRAM
+		*out = new(VRAMEstimate)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.CacheVolume != nil {
+		in, out := &in.CacheVolume, &out.CacheVolume
+		*out = new(CacheVolumeStatus)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ModelStatus.
+func (in *ModelStatus) DeepCopy() *ModelStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ModelStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodeCacheStatus) DeepCopyInto(out *NodeCacheStatus) {
+	*out = *in
+	if in.CachedAt != nil {
+		in, out := &in.CachedAt, &out.CachedAt
+		*out = (*in).DeepCopy()
+	}
+	if in.Size != nil {
+		in, out := &in.Size, &out.Size
+		x := (*in).DeepCopy()
+		*out = &x
+	}
+	if in.ProgressPercent != nil {
+		in, out := &in.ProgressPercent, &out.ProgressPercent
+		*out = new(int32)
+		**out = **in
+	}
+	if in.LastUsed != nil {
+		in, out := &in.LastUsed, &out.LastUsed
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeCacheStatus.
+func (in *NodeCacheStatus) DeepCopy() *NodeCacheStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeCacheStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *QueueConfig) DeepCopyInto(out *QueueConfig) {
+	*out = *in
+	if in.MaxLagThreshold != nil {
+		in, out := &in.MaxLagThreshold, &out.MaxLagThreshold
+		*out = new(int32)
+		**out = **in
+	}
+	if in.PrefetchCount != nil {
+		in, out := &in.PrefetchCount, &out.PrefetchCount
+		*out = new(int32)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new QueueConfig.
+func (in *QueueConfig) DeepCopy() *QueueConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(QueueConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RegistryModelTemplate) DeepCopyInto(out *RegistryModelTemplate) {
+	*out = *in
+	if in.Labels != nil {
+		in, out := &in.Labels, &out.Labels
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Annotations != nil {
+		in, out := &in.Annotations, &out.Annotations
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.FilePatterns != nil {
+		in, out := &in.FilePatterns, &out.FilePatterns
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.CachePolicy != nil {
+		in, out := &in.CachePolicy, &out.CachePolicy
+		*out = new(CachePolicy)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ShardSpec != nil {
+		in, out := &in.ShardSpec, &out.ShardSpec
+		*out = new(ShardSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Serving != nil {
+		in, out := &in.Serving, &out.Serving
+		*out = new(ServingSpec)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RegistryModelTemplate.
+func (in *RegistryModelTemplate) DeepCopy() *RegistryModelTemplate {
+	if in == nil {
+		return nil
+	}
+	out := new(RegistryModelTemplate)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RetryPolicy) DeepCopyInto(out *RetryPolicy) {
+	*out = *in
+	if in.InitialBackoff != nil {
+		in, out := &in.InitialBackoff, &out.InitialBackoff
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.MaxBackoff != nil {
+		in, out := &in.MaxBackoff, &out.MaxBackoff
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.BackoffMultiplier != nil {
+		in, out := &in.BackoffMultiplier, &out.BackoffMultiplier
+		*out = new(float32)
+		**out = **in
+	}
+	if in.RetryableErrors != nil {
+		in, out := &in.RetryableErrors, &out.RetryableErrors
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RetryPolicy.
+func (in *RetryPolicy) DeepCopy() *RetryPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(RetryPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RolloutAnalysis) DeepCopyInto(out *RolloutAnalysis) {
+	*out = *in
+	if in.Interval != nil {
+		in, out := &in.Interval, &out.Interval
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.MinRequests != nil {
+		in, out := &in.MinRequests, &out.MinRequests
+		*out = new(int32)
+		**out = **in
+	}
+	if in.MaxErrorRate != nil {
+		in, out := &in.MaxErrorRate, &out.MaxErrorRate
+		*out = new(float32)
+		**out = **in
+	}
+	if in.MaxLatencyRatio != nil {
+		in, out := &in.MaxLatencyRatio, &out.MaxLatencyRatio
+		*out = new(float32)
+		**out = **in
+	}
+	if in.MinQualityWinRate != nil {
+		in, out := &in.MinQualityWinRate, &out.MinQualityWinRate
+		*out = new(float32)
+		**out = **in
+	}
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ModelStatus.
-func (in *ModelStatus) DeepCopy() *ModelStatus {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RolloutAnalysis.
+func (in *RolloutAnalysis) DeepCopy() *RolloutAnalysis {
 	if in == nil {
 		return nil
 	}
-	out := new(ModelStatus)
+	out := new(RolloutAnalysis)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *NodeCacheStatus) DeepCopyInto(out *NodeCacheStatus) {
+func (in *RolloutAnalysisResult) DeepCopyInto(out *RolloutAnalysisResult) {
 	*out = *in
-	if in.CachedAt != nil {
-		in, out := &in.CachedAt, &out.CachedAt
-		*out = (*in).DeepCopy()
-	}
-	if in.Size != nil {
-		in, out := &in.Size, &out.Size
-		x := (*in).DeepCopy()
-		*out = &x
+	in.Time.DeepCopyInto(&out.Time)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RolloutAnalysisResult.
+func (in *RolloutAnalysisResult) DeepCopy() *RolloutAnalysisResult {
+	if in == nil {
+		return nil
 	}
+	out := new(RolloutAnalysisResult)
+	in.DeepCopyInto(out)
+	return out
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeCacheStatus.
-func (in *NodeCacheStatus) DeepCopy() *NodeCacheStatus {
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RolloutPool) DeepCopyInto(out *RolloutPool) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RolloutPool.
+func (in *RolloutPool) DeepCopy() *RolloutPool {
 	if in == nil {
 		return nil
 	}
-	out := new(NodeCacheStatus)
+	out := new(RolloutPool)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *QueueConfig) DeepCopyInto(out *QueueConfig) {
+func (in *RolloutStrategy) DeepCopyInto(out *RolloutStrategy) {
 	*out = *in
-	if in.MaxLagThreshold != nil {
-		in, out := &in.MaxLagThreshold, &out.MaxLagThreshold
-		*out = new(int32)
+	if in.MaxSurge != nil {
+		in, out := &in.MaxSurge, &out.MaxSurge
+		*out = new(intstr.IntOrString)
 		**out = **in
 	}
-	if in.PrefetchCount != nil {
-		in, out := &in.PrefetchCount, &out.PrefetchCount
-		*out = new(int32)
+	if in.MaxUnavailable != nil {
+		in, out := &in.MaxUnavailable, &out.MaxUnavailable
+		*out = new(intstr.IntOrString)
 		**out = **in
 	}
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new QueueConfig.
-func (in *QueueConfig) DeepCopy() *QueueConfig {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RolloutStrategy.
+func (in *RolloutStrategy) DeepCopy() *RolloutStrategy {
 	if in == nil {
 		return nil
 	}
-	out := new(QueueConfig)
+	out := new(RolloutStrategy)
 	in.DeepCopyInto(out)
 	return out
 }
 
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
-func (in *RetryPolicy) DeepCopyInto(out *RetryPolicy) {
+func (in *RuntimeSnapshotAPI) DeepCopyInto(out *RuntimeSnapshotAPI) {
 	*out = *in
-	if in.InitialBackoff != nil {
-		in, out := &in.InitialBackoff, &out.InitialBackoff
-		*out = new(v1.Duration)
-		**out = **in
-	}
-	if in.MaxBackoff != nil {
-		in, out := &in.MaxBackoff, &out.MaxBackoff
-		*out = new(v1.Duration)
-		**out = **in
-	}
-	if in.BackoffMultiplier != nil {
-		in, out := &in.BackoffMultiplier, &out.BackoffMultiplier
-		*out = new(float32)
+	if in.Port != nil {
+		in, out := &in.Port, &out.Port
+		*out = new(int32)
 		**out = **in
 	}
-	if in.RetryableErrors != nil {
-		in, out := &in.RetryableErrors, &out.RetryableErrors
-		*out = make([]string, len(*in))
-		copy(*out, *in)
-	}
 }
 
-// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RetryPolicy.
-func (in *RetryPolicy) DeepCopy() *RetryPolicy {
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RuntimeSnapshotAPI.
+func (in *RuntimeSnapshotAPI) DeepCopy() *RuntimeSnapshotAPI {
 	if in == nil {
 		return nil
 	}
-	out := new(RetryPolicy)
+	out := new(RuntimeSnapshotAPI)
 	in.DeepCopyInto(out)
 	return out
 }
@@ -877,6 +1836,11 @@ func (in *ScalingBehavior) DeepCopyInto(out *ScalingBehavior) {
 		*out = new(ScalingPolicy)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.Burst != nil {
+		in, out := &in.Burst, &out.Burst
+		*out = new(BurstPolicy)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScalingBehavior.
@@ -924,6 +1888,59 @@ func (in *ScalingPolicy) DeepCopy() *ScalingPolicy {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScalingRecommendation) DeepCopyInto(out *ScalingRecommendation) {
+	*out = *in
+	if in.Metrics != nil {
+		in, out := &in.Metrics, &out.Metrics
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	in.Time.DeepCopyInto(&out.Time)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScalingRecommendation.
+func (in *ScalingRecommendation) DeepCopy() *ScalingRecommendation {
+	if in == nil {
+		return nil
+	}
+	out := new(ScalingRecommendation)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScalingSchedule) DeepCopyInto(out *ScalingSchedule) {
+	*out = *in
+	if in.Duration != nil {
+		in, out := &in.Duration, &out.Duration
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.MinReplicas != nil {
+		in, out := &in.MinReplicas, &out.MinReplicas
+		*out = new(int32)
+		**out = **in
+	}
+	if in.MaxReplicas != nil {
+		in, out := &in.MaxReplicas, &out.MaxReplicas
+		*out = new(int32)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScalingSchedule.
+func (in *ScalingSchedule) DeepCopy() *ScalingSchedule {
+	if in == nil {
+		return nil
+	}
+	out := new(ScalingSchedule)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *SchedulingConfig) DeepCopyInto(out *SchedulingConfig) {
 	*out = *in
@@ -949,6 +1966,11 @@ func (in *SchedulingConfig) DeepCopyInto(out *SchedulingConfig) {
 			(*out)[key] = val
 		}
 	}
+	if in.TopologySpread != nil {
+		in, out := &in.TopologySpread, &out.TopologySpread
+		*out = make([]TopologySpreadConfig, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SchedulingConfig.
@@ -1001,6 +2023,21 @@ func (in *ServiceLevelObjective) DeepCopy() *ServiceLevelObjective {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ServingSpec) DeepCopyInto(out *ServingSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ServingSpec.
+func (in *ServingSpec) DeepCopy() *ServingSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ServingSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *SessionAffinityConfig) DeepCopyInto(out *SessionAffinityConfig) {
 	*out = *in
@@ -1009,6 +2046,11 @@ func (in *SessionAffinityConfig) DeepCopyInto(out *SessionAffinityConfig) {
 		*out = new(v1.Duration)
 		**out = **in
 	}
+	if in.DrainTimeout != nil {
+		in, out := &in.DrainTimeout, &out.DrainTimeout
+		*out = new(v1.Duration)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SessionAffinityConfig.
@@ -1041,6 +2083,51 @@ func (in *ShardSpec) DeepCopy() *ShardSpec {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SnapshotConfig) DeepCopyInto(out *SnapshotConfig) {
+	*out = *in
+	if in.IdleAfter != nil {
+		in, out := &in.IdleAfter, &out.IdleAfter
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.ProcessID != nil {
+		in, out := &in.ProcessID, &out.ProcessID
+		*out = new(int32)
+		**out = **in
+	}
+	if in.Runtime != nil {
+		in, out := &in.Runtime, &out.Runtime
+		*out = new(RuntimeSnapshotAPI)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SnapshotConfig.
+func (in *SnapshotConfig) DeepCopy() *SnapshotConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(SnapshotConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TagMapping) DeepCopyInto(out *TagMapping) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TagMapping.
+func (in *TagMapping) DeepCopy() *TagMapping {
+	if in == nil {
+		return nil
+	}
+	out := new(TagMapping)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ThroughputMetrics) DeepCopyInto(out *ThroughputMetrics) {
 	*out = *in
@@ -1179,6 +2266,16 @@ func (in *ToolBindingSpec) DeepCopyInto(out *ToolBindingSpec) {
 		*out = new(HTTPConfig)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.WebSocketConfig != nil {
+		in, out := &in.WebSocketConfig, &out.WebSocketConfig
+		*out = new(WebSocketConfig)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.GRPCConfig != nil {
+		in, out := &in.GRPCConfig, &out.GRPCConfig
+		*out = new(GRPCConfig)
+		**out = **in
+	}
 	if in.Concurrency != nil {
 		in, out := &in.Concurrency, &out.Concurrency
 		*out = new(ConcurrencyConfig)
@@ -1281,6 +2378,11 @@ func (in *TopicConfig) DeepCopyInto(out *TopicConfig) {
 		*out = make([]int32, len(*in))
 		copy(*out, *in)
 	}
+	if in.MaxLagThreshold != nil {
+		in, out := &in.MaxLagThreshold, &out.MaxLagThreshold
+		*out = new(int32)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TopicConfig.
@@ -1312,3 +2414,142 @@ func (in *TopologyRequirement) DeepCopy() *TopologyRequirement {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TopologySpreadConfig) DeepCopyInto(out *TopologySpreadConfig) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TopologySpreadConfig.
+func (in *TopologySpreadConfig) DeepCopy() *TopologySpreadConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(TopologySpreadConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VRAMEstimate) DeepCopyInto(out *VRAMEstimate) {
+	*out = *in
+	out.Weights = in.Weights.DeepCopy()
+	if in.KVCache != nil {
+		in, out := &in.KVCache, &out.KVCache
+		x := (*in).DeepCopy()
+		*out = &x
+	}
+	out.Overhead = in.Overhead.DeepCopy()
+	out.Total = in.Total.DeepCopy()
+	out.PerGPU = in.PerGPU.DeepCopy()
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VRAMEstimate.
+func (in *VRAMEstimate) DeepCopy() *VRAMEstimate {
+	if in == nil {
+		return nil
+	}
+	out := new(VRAMEstimate)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WarmUpConfig) DeepCopyInto(out *WarmUpConfig) {
+	*out = *in
+	if in.Prompts != nil {
+		in, out := &in.Prompts, &out.Prompts
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.BatchSizes != nil {
+		in, out := &in.BatchSizes, &out.BatchSizes
+		*out = make([]int32, len(*in))
+		copy(*out, *in)
+	}
+	if in.MaxTokens != nil {
+		in, out := &in.MaxTokens, &out.MaxTokens
+		*out = new(int32)
+		**out = **in
+	}
+	if in.Timeout != nil {
+		in, out := &in.Timeout, &out.Timeout
+		*out = new(v1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WarmUpConfig.
+func (in *WarmUpConfig) DeepCopy() *WarmUpConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(WarmUpConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WebSocketConfig) DeepCopyInto(out *WebSocketConfig) {
+	*out = *in
+	if in.AllowedOrigins != nil {
+		in, out := &in.AllowedOrigins, &out.AllowedOrigins
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.PingInterval != nil {
+		in, out := &in.PingInterval, &out.PingInterval
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.MigrationGracePeriod != nil {
+		in, out := &in.MigrationGracePeriod, &out.MigrationGracePeriod
+		*out = new(v1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WebSocketConfig.
+func (in *WebSocketConfig) DeepCopy() *WebSocketConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(WebSocketConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WeightsMirror) DeepCopyInto(out *WeightsMirror) {
+	*out = *in
+	if in.CredentialsSecretRef != nil {
+		in, out := &in.CredentialsSecretRef, &out.CredentialsSecretRef
+		*out = new(corev1.SecretKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WeightsMirror.
+func (in *WeightsMirror) DeepCopy() *WeightsMirror {
+	if in == nil {
+		return nil
+	}
+	out := new(WeightsMirror)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WeightsSignature) DeepCopyInto(out *WeightsSignature) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WeightsSignature.
+func (in *WeightsSignature) DeepCopy() *WeightsSignature {
+	if in == nil {
+		return nil
+	}
+	out := new(WeightsSignature)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -1,16 +1,29 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"os"
+	"time"
 
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/kubernetes"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
 
 	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/autoscaler"
+	"github.com/bowenislandsong/neuronetes/pkg/dcgm"
+	"github.com/bowenislandsong/neuronetes/pkg/metrics"
+	"github.com/bowenislandsong/neuronetes/pkg/plugins"
+	"github.com/bowenislandsong/neuronetes/pkg/router"
+	"github.com/bowenislandsong/neuronetes/pkg/snapshot"
 )
 
 var (
@@ -25,8 +38,52 @@ func init() {
 
 func main() {
 	var metricsAddr string
+	var probeAddr string
+	var enableLeaderElection bool
+	var decisionInterval time.Duration
+	var stabilizationWindow time.Duration
+	var dryRun bool
+	var recommendGPU bool
+	var recommendationWindow time.Duration
+	var evaluateSLOs bool
+	var promConfig autoscaler.PrometheusConfig
+	var snapshotURL string
+	var snapshotInterval time.Duration
+	var snapshotRetention time.Duration
+	var dcgmExporterPort int
+	var metricsConfig string
 
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
+	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
+	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
+		"Enable leader election for the autoscaler. "+
+			"Enabling this will ensure there is only one active autoscaler.")
+	flag.DurationVar(&decisionInterval, "decision-interval", autoscaler.DefaultDecisionInterval, "How often each AgentPool is evaluated.")
+	flag.DurationVar(&stabilizationWindow, "stabilization-window", 5*time.Minute,
+		"Default scale-down stabilization window for pools that do not set one.")
+	flag.BoolVar(&dryRun, "dry-run", false,
+		"Record scaling decisions in AgentPool status and events without changing replicas.")
+	flag.BoolVar(&recommendGPU, "gpu-recommendations", true,
+		"Record suggested GPU sizing in the status of GPU-backed AgentPools.")
+	flag.DurationVar(&recommendationWindow, "gpu-recommendation-window", autoscaler.DefaultRecommendationWindow,
+		"How far back GPU usage is observed for GPU sizing recommendations.")
+	flag.BoolVar(&evaluateSLOs, "slo-evaluation", true,
+		"Compute error budget burn rates of AgentClass SLOs and report them in AgentClass status.")
+	flag.StringVar(&promConfig.Address, "prometheus-address", "", "The Prometheus server URL autoscaling metrics are read from.")
+	flag.StringVar(&promConfig.BearerTokenFile, "prometheus-bearer-token-file", "", "File containing a bearer token for Prometheus.")
+	flag.StringVar(&promConfig.PoolSelector, "prometheus-pool-selector", autoscaler.DefaultPoolSelector, "Template for the PromQL label matchers selecting a pool's series.")
+	flag.StringVar(&promConfig.ClassSelector, "prometheus-class-selector", autoscaler.DefaultClassSelector, "Template for the PromQL label matchers selecting an AgentClass's series.")
+	flag.DurationVar(&promConfig.Timeout, "prometheus-timeout", 10*time.Second, "Timeout for each Prometheus query.")
+	flag.StringVar(&snapshotURL, "snapshot-url", "",
+		"s3://bucket/prefix or gs://bucket/prefix to export metrics snapshots to for capacity planning. Disabled if empty.")
+	flag.DurationVar(&snapshotInterval, "snapshot-interval", snapshot.DefaultInterval,
+		"How often metrics snapshots are exported, and the window each one aggregates.")
+	flag.DurationVar(&snapshotRetention, "snapshot-retention", snapshot.DefaultRetention,
+		"How long metrics snapshots are kept before they are deleted.")
+	flag.IntVar(&dcgmExporterPort, "dcgm-exporter-port", 0,
+		"Port of the dcgm-exporters of the cluster's GPU nodes. When set, their metrics are mapped to AgentPools and scale on GPU utilization. Disabled if 0.")
+	flag.StringVar(&metricsConfig, "metrics-config", "",
+		"YAML file disabling metric families, dropping labels and setting histogram buckets of the agent metrics.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -35,11 +92,132 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
-	setupLog.Info("starting token-aware autoscaler")
+	provider, err := autoscaler.NewPrometheusMetricsProvider(promConfig)
+	if err != nil {
+		setupLog.Error(err, "unable to create metrics provider")
+		os.Exit(1)
+	}
+
+	config := ctrl.GetConfigOrDie()
+	mgr, err := ctrl.NewManager(config, ctrl.Options{
+		Scheme:                 scheme,
+		Metrics:                metricsserver.Options{BindAddress: metricsAddr},
+		HealthProbeBindAddress: probeAddr,
+		LeaderElection:         enableLeaderElection,
+		LeaderElectionID:       "autoscaler.neuronetes.io",
+		// Step down promptly on shutdown so a standby takes over quickly
+		LeaderElectionReleaseOnCancel: true,
+	})
+	if err != nil {
+		setupLog.Error(err, "unable to start manager")
+		os.Exit(1)
+	}
+
+	scaler, err := autoscaler.NewSubresourceScaler(config)
+	if err != nil {
+		setupLog.Error(err, "unable to create scaler")
+		os.Exit(1)
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		setupLog.Error(err, "unable to create clientset")
+		os.Exit(1)
+	}
+
+	var agentMetricsConfig metrics.Config
+	if metricsConfig != "" {
+		if agentMetricsConfig, err = metrics.LoadConfig(metricsConfig); err != nil {
+			setupLog.Error(err, "unable to load metrics config")
+			os.Exit(1)
+		}
+	}
+	agentMetrics, err := metrics.NewAgentMetricsWithConfig(ctrlmetrics.Registry, agentMetricsConfig)
+	if err != nil {
+		setupLog.Error(err, "unable to create metrics")
+		os.Exit(1)
+	}
+	var metricsProvider autoscaler.MetricsProvider = provider
+	if dcgmExporterPort > 0 {
+		bridge := dcgm.NewBridge(mgr.GetClient(), dcgm.NewClient(dcgm.Config{Port: dcgmExporterPort}), agentMetrics, 0)
+		if err = bridge.SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create dcgm-exporter bridge")
+			os.Exit(1)
+		}
+		metricsProvider = &autoscaler.GPUMetricsProvider{Source: bridge, Fallback: provider}
+	}
+
+	tokenAware := autoscaler.NewTokenAwareAutoscaler(metricsProvider, &autoscaler.AutoscalerConfig{
+		DecisionInterval:    decisionInterval,
+		StabilizationWindow: stabilizationWindow,
+	})
+	tokenAware.SetBackpressureReporter(&router.PodBackpressureReporter{Client: clientset})
+	tokenAware.SetLagSource(&autoscaler.ToolBindingLagSource{Reader: mgr.GetClient(), Collector: provider})
+	tokenAware.SetPlugins(plugins.GetGlobalRegistry())
 
-	// Autoscaler implementation would go here
-	// This is a skeleton for the build
+	if err = (&autoscaler.PoolReconciler{
+		Client:     mgr.GetClient(),
+		Autoscaler: tokenAware,
+		Scaler:     scaler,
+		Recorder:   mgr.GetEventRecorderFor("autoscaler"),
+		DryRun:     dryRun,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Autoscaler")
+		os.Exit(1)
+	}
+
+	if recommendGPU {
+		if err = (&autoscaler.GPURecommender{
+			Client: mgr.GetClient(),
+			Usage:  provider,
+			Window: recommendationWindow,
+			Margin: autoscaler.DefaultVRAMMargin,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "GPURecommender")
+			os.Exit(1)
+		}
+	}
+
+	if evaluateSLOs {
+		if err = (&autoscaler.SLOEvaluator{
+			Client:      mgr.GetClient(),
+			ErrorRatios: provider,
+			Metrics:     agentMetrics,
+			Histograms:  agentMetricsConfig.HistogramOptions,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "SLOEvaluator")
+			os.Exit(1)
+		}
+	}
+
+	if snapshotURL != "" {
+		store, err := snapshot.NewStore(context.Background(), snapshotURL)
+		if err != nil {
+			setupLog.Error(err, "unable to create snapshot store")
+			os.Exit(1)
+		}
+		if err = (&snapshot.Exporter{
+			Querier:   provider.API(),
+			Store:     store,
+			Interval:  snapshotInterval,
+			Retention: snapshotRetention,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create snapshot exporter")
+			os.Exit(1)
+		}
+	}
+
+	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
+		setupLog.Error(err, "unable to set up health check")
+		os.Exit(1)
+	}
+	if err := mgr.AddReadyzCheck("readyz", healthz.Ping); err != nil {
+		setupLog.Error(err, "unable to set up ready check")
+		os.Exit(1)
+	}
 
-	setupLog.Info("autoscaler running")
-	select {}
+	setupLog.Info("starting token-aware autoscaler")
+	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
+		setupLog.Error(err, "problem running autoscaler")
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"net"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	_ "k8s.io/client-go/plugin/pkg/client/auth"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/downloader"
+	"github.com/bowenislandsong/neuronetes/pkg/metrics"
+	"github.com/bowenislandsong/neuronetes/pkg/modelcache"
+)
+
+// main caches the weights of the models assigned to the node it runs on on
+// the node's local disk, and reports the cache for the cache controller. It
+// runs as a DaemonSet on GPU nodes.
+//
+// With --populate-request, it instead downloads the weights of a download
+// request, as JSON, into a version directory of a cache volume once and
+// exits, with modelcache.IntegrityExitCode if they fail integrity
+// verification. The cache volume controller runs it so in a Job mounting
+// the PersistentVolumeClaim of a Model and the Secret of the request.
+func main() {
+	var nodeName string
+	var cacheDir string
+	var interval time.Duration
+	var downloadConcurrency int
+	var minFreePercent int
+	var metricsAddr string
+	var peerBindAddr string
+	var peerAddr string
+	var populateRequest string
+	var keepVersions int
+
+	flag.StringVar(&nodeName, "node-name", os.Getenv("NODE_NAME"), "The node this agent runs on. Defaults to $NODE_NAME.")
+	flag.StringVar(&cacheDir, "cache-dir", "/var/cache/neuronetes/models", "The directory of the model cache on the node's local disk.")
+	flag.DurationVar(&interval, "interval", modelcache.DefaultSyncInterval, "How often the cache is synced with the assigned models and reported.")
+	flag.IntVar(&downloadConcurrency, "download-concurrency", downloader.DefaultConcurrency, "The chunks of Model weights downloaded at once.")
+	flag.IntVar(&minFreePercent, "min-free-percent", modelcache.DefaultMinFreePercent, "The free space of the cache disk, as a percentage of its capacity, below which idle models are evicted.")
+	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to. Empty disables it.")
+	flag.StringVar(&peerBindAddr, "peer-bind-address", "", "The address the cache is served to the agents of other nodes at, such as :7070. Empty disables peer-to-peer distribution. Requires $PEER_TOKEN, the token the agents share.")
+	flag.StringVar(&peerAddr, "peer-address", "", "The host:port other agents reach the peer server at. Defaults to $POD_IP with the port of --peer-bind-address.")
+	flag.StringVar(&populateRequest, "populate-request", "", "The file of a download request, as JSON, to download into a cache volume before exiting, rather than running the agent.")
+	flag.IntVar(&keepVersions, "keep-versions", modelcache.DefaultKeepVersions, "The versions of the weights kept on the cache volume after --populate-request is downloaded.")
+	opts := zap.Options{
+		Development: true,
+	}
+	opts.BindFlags(flag.CommandLine)
+	flag.Parse()
+
+	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
+	setupLog := ctrl.Log.WithName("setup")
+
+	if populateRequest != "" {
+		data, err := os.ReadFile(populateRequest)
+		if err != nil {
+			setupLog.Error(err, "unable to read download request")
+			os.Exit(1)
+		}
+		var req downloader.Request
+		if err := json.Unmarshal(data, &req); err != nil || req.URI == "" || req.Dir == "" {
+			setupLog.Error(err, "invalid download request", "file", populateRequest)
+			os.Exit(1)
+		}
+		ctx := log.IntoContext(ctrl.SetupSignalHandler(), ctrl.Log.WithName("cache-populator"))
+		d := downloader.New(downloader.Options{Concurrency: downloadConcurrency})
+		if _, err := modelcache.Populate(ctx, d, req, keepVersions); err != nil {
+			setupLog.Error(err, "failed to populate cache volume")
+			if errors.Is(err, downloader.ErrIntegrity) {
+				os.Exit(modelcache.IntegrityExitCode)
+			}
+			os.Exit(1)
+		}
+		return
+	}
+
+	if nodeName == "" {
+		setupLog.Info("--node-name or $NODE_NAME is required")
+		os.Exit(1)
+	}
+	scheme := runtime.NewScheme()
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(neuronetes.AddToScheme(scheme))
+	c, err := client.New(ctrl.GetConfigOrDie(), client.Options{Scheme: scheme})
+	if err != nil {
+		setupLog.Error(err, "unable to create client")
+		os.Exit(1)
+	}
+
+	setupLog.Info("starting model cache agent", "node", nodeName, "cacheDir", cacheDir)
+	ctx := log.IntoContext(ctrl.SetupSignalHandler(), ctrl.Log.WithName("cache-agent"))
+	// Peers share chunks best when they download them in different orders
+	d := downloader.New(downloader.Options{Concurrency: downloadConcurrency, Shuffle: peerBindAddr != ""})
+	agent := modelcache.NewAgent(c, nodeName, cacheDir, d, interval)
+	agent.SetMinFreePercent(minFreePercent)
+
+	registry := prometheus.NewRegistry()
+	agentMetrics := metrics.NewAgentMetrics(registry)
+	if metricsAddr != "" {
+		go func() {
+			if err := metrics.NewServer(metricsAddr, registry).Start(ctx); err != nil {
+				setupLog.Error(err, "problem serving metrics")
+				os.Exit(1)
+			}
+		}()
+	}
+
+	if peerBindAddr != "" {
+		if peerAddr == "" {
+			_, port, err := net.SplitHostPort(peerBindAddr)
+			if err != nil || os.Getenv("POD_IP") == "" {
+				setupLog.Info("--peer-address or $POD_IP is required with --peer-bind-address")
+				os.Exit(1)
+			}
+			peerAddr = net.JoinHostPort(os.Getenv("POD_IP"), port)
+		}
+		// The token is shared by the agents through a Secret, and kept out of
+		// the command line
+		peerToken := os.Getenv("PEER_TOKEN")
+		if peerToken == "" {
+			setupLog.Info("$PEER_TOKEN is required with --peer-bind-address")
+			os.Exit(1)
+		}
+		agent.EnablePeers(peerAddr, peerToken, agentMetrics)
+		go func() {
+			if err := agent.Serve(ctx, peerBindAddr); err != nil {
+				setupLog.Error(err, "problem serving model cache to peers")
+				os.Exit(1)
+			}
+		}()
+	}
+
+	if err := agent.Start(ctx); err != nil {
+		setupLog.Error(err, "problem running model cache agent")
+		os.Exit(1)
+	}
+}
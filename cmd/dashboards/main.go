@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/yaml"
+
+	"github.com/bowenislandsong/neuronetes/pkg/dashboards"
+)
+
+// Output formats
+const (
+	formatJSON            = "json"
+	formatConfigMap       = "configmap"
+	formatGrafanaOperator = "grafana-operator"
+)
+
+// main writes the NeuroNetes Grafana dashboards as dashboard JSON, as
+// ConfigMaps for the Grafana dashboard sidecar or as GrafanaDashboard
+// resources for the Grafana operator
+func main() {
+	var names string
+	var format string
+	var namespace string
+	var instanceSelector string
+	var outputDir string
+
+	flag.StringVar(&names, "dashboards", strings.Join(dashboards.Names(), ","), "Comma-separated dashboards to generate.")
+	flag.StringVar(&format, "format", formatJSON, "Output format: json, configmap or grafana-operator.")
+	flag.StringVar(&namespace, "namespace", "monitoring", "Namespace of generated ConfigMaps and GrafanaDashboards.")
+	flag.StringVar(&instanceSelector, "instance-selector", "dashboards=grafana",
+		"Labels of the Grafana instances GrafanaDashboards are imported into.")
+	flag.StringVar(&outputDir, "output-dir", "",
+		"Directory to write one file per dashboard to. Defaults to stdout, as a YAML stream for resources.")
+	flag.Parse()
+
+	if err := run(strings.Split(names, ","), format, namespace, instanceSelector, outputDir); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(names []string, format, namespace, instanceSelector, outputDir string) error {
+	selector, err := labels.ConvertSelectorToLabelsMap(instanceSelector)
+	if err != nil {
+		return fmt.Errorf("invalid instance selector: %w", err)
+	}
+	if format == formatJSON && outputDir == "" && len(names) > 1 {
+		return fmt.Errorf("--output-dir is required to write more than one dashboard as JSON")
+	}
+
+	var stream bytes.Buffer
+	for _, name := range names {
+		data, ext, err := render(name, format, namespace, selector)
+		if err != nil {
+			return err
+		}
+		if outputDir != "" {
+			path := filepath.Join(outputDir, name+ext)
+			if err := os.WriteFile(path, data, 0o644); err != nil {
+				return fmt.Errorf("failed to write %s: %w", path, err)
+			}
+			continue
+		}
+		if stream.Len() > 0 {
+			stream.WriteString("---\n")
+		}
+		stream.Write(data)
+	}
+	_, err = io.Copy(os.Stdout, &stream)
+	return err
+}
+
+// render returns the dashboard called name in format, and the extension of
+// files holding it
+func render(name, format, namespace string, instanceSelector map[string]string) ([]byte, string, error) {
+	var resource interface{}
+	switch format {
+	case formatJSON:
+		d, err := dashboards.Generate(name)
+		if err != nil {
+			return nil, "", err
+		}
+		data, err := d.JSON()
+		if err != nil {
+			return nil, "", err
+		}
+		return append(data, '\n'), ".json", nil
+	case formatConfigMap:
+		cm, err := dashboards.ConfigMap(name, namespace)
+		if err != nil {
+			return nil, "", err
+		}
+		resource = cm
+	case formatGrafanaOperator:
+		dashboard, err := dashboards.GrafanaDashboard(name, namespace, instanceSelector)
+		if err != nil {
+			return nil, "", err
+		}
+		resource = dashboard.Object
+	default:
+		return nil, "", fmt.Errorf("unknown format %q", format)
+	}
+
+	data, err := yaml.Marshal(resource)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to encode dashboard %s: %w", name, err)
+	}
+	return data, ".yaml", nil
+}
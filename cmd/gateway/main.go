@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	_ "k8s.io/client-go/plugin/pkg/client/auth"
+	"k8s.io/client-go/rest"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/accounting"
+	"github.com/bowenislandsong/neuronetes/pkg/activator"
+	"github.com/bowenislandsong/neuronetes/pkg/evaluation"
+	"github.com/bowenislandsong/neuronetes/pkg/gateway"
+	"github.com/bowenislandsong/neuronetes/pkg/metrics"
+	"github.com/bowenislandsong/neuronetes/pkg/pricing"
+)
+
+var (
+	scheme   = runtime.NewScheme()
+	setupLog = ctrl.Log.WithName("setup")
+)
+
+func init() {
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(neuronetes.AddToScheme(scheme))
+}
+
+func main() {
+	var gatewayAddr string
+	var grpcAddr string
+	var metricsAddr string
+	var probeAddr string
+	var replicaPort int
+	var statusInterval time.Duration
+	var activationTimeout time.Duration
+	var heartbeatInterval time.Duration
+	var metricsConfig string
+	var ledgerStore string
+	var ledgerNamespace string
+	var ledgerName string
+	var ledgerBucket string
+	var ledgerRegion string
+	var checkpointInterval time.Duration
+	var pricingProvider string
+	var pricingTable string
+	var gcpAPIKeyFile string
+	var costInterval time.Duration
+	var evaluationSampleRate float64
+
+	flag.StringVar(&gatewayAddr, "gateway-bind-address", ":8000", "The address HTTP ToolBindings are served on.")
+	flag.StringVar(&grpcAddr, "grpc-bind-address", ":9000", "The address gRPC ToolBindings are served on.")
+	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
+	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
+	flag.IntVar(&replicaPort, "replica-port", gateway.DefaultReplicaPort, "The port agent replicas serve on.")
+	flag.DurationVar(&statusInterval, "status-interval", gateway.DefaultStatusInterval,
+		"How often the traffic of each ToolBinding is reported in its status.")
+	flag.DurationVar(&activationTimeout, "activation-timeout", activator.DefaultTimeout,
+		"How long requests are held while the AgentPool of their binding scales from zero.")
+	flag.DurationVar(&heartbeatInterval, "heartbeat-interval", gateway.DefaultHeartbeatInterval,
+		"How long the event stream of a streaming ToolBinding may be quiet before a heartbeat is sent. Zero disables heartbeats.")
+	flag.StringVar(&metricsConfig, "metrics-config", "",
+		"YAML file disabling metric families, dropping labels and setting histogram buckets of the agent metrics.")
+	flag.StringVar(&ledgerStore, "ledger-store", "",
+		"Where the token totals of each tenant and model are checkpointed: configmap or s3. Empty keeps no ledger.")
+	flag.StringVar(&ledgerNamespace, "ledger-namespace", "neuronetes-system", "The namespace of the ConfigMap of the ledger.")
+	flag.StringVar(&ledgerName, "ledger-name", "",
+		"The ConfigMap name or S3 object key of the ledger, which each gateway replica needs its own of. Defaults to token-ledger-<hostname>.")
+	flag.StringVar(&ledgerBucket, "ledger-s3-bucket", "", "The S3 bucket of the ledger.")
+	flag.StringVar(&ledgerRegion, "ledger-s3-region", "", "The region of the S3 bucket of the ledger. Defaults to the region of the AWS configuration.")
+	flag.DurationVar(&checkpointInterval, "ledger-checkpoint-interval", accounting.DefaultCheckpointInterval,
+		"How often the token totals of the ledger are checkpointed.")
+	flag.StringVar(&pricingProvider, "pricing-provider", "",
+		"Where GPU prices are read from to attribute the cost of pools to tenants: aws, gcp, azure or static. Empty attributes no cost.")
+	flag.StringVar(&pricingTable, "pricing-table", "", "YAML file of instance type prices, overriding those of the pricing provider.")
+	flag.StringVar(&gcpAPIKeyFile, "gcp-api-key-file", "", "File holding the API key the gcp pricing provider reads the Cloud Billing Catalog with.")
+	flag.DurationVar(&costInterval, "cost-interval", accounting.DefaultCostInterval,
+		"How often the GPUs of pools are sampled and their cost attributed.")
+	flag.Float64Var(&evaluationSampleRate, "evaluation-sample-rate", 0,
+		"The share of the retrieval-augmented turns replicas report that are evaluated for the RAG quality metrics, between 0 and 1. Zero disables evaluation.")
+	opts := zap.Options{
+		Development: true,
+	}
+	opts.BindFlags(flag.CommandLine)
+	flag.Parse()
+
+	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
+
+	config := ctrl.GetConfigOrDie()
+	extraHandlers := map[string]http.Handler{}
+	var costs *accounting.CostEngine
+	if pricingProvider != "" {
+		provider, err := newPricingProvider(pricingProvider, pricingTable, gcpAPIKeyFile)
+		if err != nil {
+			setupLog.Error(err, "unable to create pricing provider")
+			os.Exit(1)
+		}
+		costs = &accounting.CostEngine{Pricing: provider, Interval: costInterval}
+		extraHandlers[accounting.CostPath] = costs.Handler()
+	}
+	var ledger *accounting.Ledger
+	if ledgerStore != "" {
+		store, err := newLedgerStore(config, ledgerStore, ledgerNamespace, ledgerName, ledgerBucket, ledgerRegion)
+		if err != nil {
+			setupLog.Error(err, "unable to create ledger store")
+			os.Exit(1)
+		}
+		ledger = accounting.NewLedger(store, checkpointInterval)
+		extraHandlers[accounting.UsagePath] = ledger.Handler()
+	}
+
+	// Every replica serves every binding, so there is no leader election
+	mgr, err := ctrl.NewManager(config, ctrl.Options{
+		Scheme:                 scheme,
+		Metrics:                metricsserver.Options{BindAddress: metricsAddr, ExtraHandlers: extraHandlers},
+		HealthProbeBindAddress: probeAddr,
+	})
+	if err != nil {
+		setupLog.Error(err, "unable to start manager")
+		os.Exit(1)
+	}
+
+	var agentMetricsConfig metrics.Config
+	if metricsConfig != "" {
+		if agentMetricsConfig, err = metrics.LoadConfig(metricsConfig); err != nil {
+			setupLog.Error(err, "unable to load metrics config")
+			os.Exit(1)
+		}
+	}
+	agentMetrics, err := metrics.NewAgentMetricsWithConfig(ctrlmetrics.Registry, agentMetricsConfig)
+	if err != nil {
+		setupLog.Error(err, "unable to create metrics")
+		os.Exit(1)
+	}
+
+	act := activator.NewActivator(mgr.GetClient(), agentMetrics)
+	act.Timeout = activationTimeout
+	gw := gateway.NewGateway(act, agentMetrics)
+	gw.Port = replicaPort
+	gw.HeartbeatInterval = heartbeatInterval
+	if costs != nil {
+		costs.Client = mgr.GetClient()
+		costs.Metrics = agentMetrics
+		if err := costs.SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to set up cost engine")
+			os.Exit(1)
+		}
+		gw.Costs = costs
+	}
+	if evaluationSampleRate > 0 {
+		worker := evaluation.NewWorker(
+			evaluation.Chain(evaluation.RetrievalEvaluator{}, evaluation.GroundingEvaluator{}),
+			agentMetrics, evaluation.WorkerOptions{SampleRate: evaluationSampleRate},
+		)
+		gw.Evaluations = worker
+		if err := mgr.Add(worker); err != nil {
+			setupLog.Error(err, "unable to add evaluation worker")
+			os.Exit(1)
+		}
+	}
+	if ledger != nil {
+		gw.Ledger = ledger
+		if err := mgr.Add(ledger); err != nil {
+			setupLog.Error(err, "unable to add ledger")
+			os.Exit(1)
+		}
+	}
+
+	if err = (&gateway.BindingReconciler{
+		Client:         mgr.GetClient(),
+		Gateway:        gw,
+		StatusInterval: statusInterval,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Gateway")
+		os.Exit(1)
+	}
+
+	if err := mgr.Add(&gateway.Server{
+		Addr:    gatewayAddr,
+		Handler: agentMetrics.InstrumentHandler(gw, metrics.InstrumentOptions{Route: gw.Route}),
+	}); err != nil {
+		setupLog.Error(err, "unable to add gateway server")
+		os.Exit(1)
+	}
+	if err := mgr.Add(&gateway.GRPCServer{Addr: grpcAddr, Gateway: gw}); err != nil {
+		setupLog.Error(err, "unable to add gRPC gateway server")
+		os.Exit(1)
+	}
+
+	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
+		setupLog.Error(err, "unable to set up health check")
+		os.Exit(1)
+	}
+	if err := mgr.AddReadyzCheck("readyz", healthz.Ping); err != nil {
+		setupLog.Error(err, "unable to set up ready check")
+		os.Exit(1)
+	}
+
+	setupLog.Info("starting gateway")
+	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
+		setupLog.Error(err, "problem running gateway")
+		os.Exit(1)
+	}
+}
+
+// newLedgerStore returns the store of the ledger of this gateway replica
+func newLedgerStore(config *rest.Config, store, namespace, name, bucket, region string) (accounting.Store, error) {
+	if name == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, err
+		}
+		name = "token-ledger-" + hostname
+	}
+	switch store {
+	case "configmap":
+		// The ledger reads and writes one ConfigMap, which is not worth
+		// caching every ConfigMap of the cluster for
+		c, err := client.New(config, client.Options{Scheme: scheme})
+		if err != nil {
+			return nil, err
+		}
+		return &accounting.ConfigMapStore{Client: c, Namespace: namespace, Name: name}, nil
+	case "s3":
+		if bucket == "" {
+			return nil, fmt.Errorf("--ledger-s3-bucket is required for the s3 ledger store")
+		}
+		return accounting.NewS3Store(context.Background(), bucket, name+".json", region)
+	}
+	return nil, fmt.Errorf("unknown ledger store %q, want configmap or s3", store)
+}
+
+// newPricingProvider returns the provider the GPUs of pools are priced with
+func newPricingProvider(provider, table, gcpAPIKeyFile string) (pricing.Provider, error) {
+	config := pricing.Config{Provider: provider, Table: table}
+	if gcpAPIKeyFile != "" {
+		key, err := os.ReadFile(gcpAPIKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.GCPAPIKey = strings.TrimSpace(string(key))
+	}
+	return pricing.New(context.Background(), config)
+}
@@ -3,7 +3,9 @@ package main
 import (
 	"flag"
 	"os"
+	"time"
 
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
@@ -11,10 +13,17 @@ import (
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
 
 	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
 	"github.com/bowenislandsong/neuronetes/controllers"
+	"github.com/bowenislandsong/neuronetes/pkg/autoscaler"
+	"github.com/bowenislandsong/neuronetes/pkg/checkpoint"
+	"github.com/bowenislandsong/neuronetes/pkg/descheduler"
+	"github.com/bowenislandsong/neuronetes/pkg/downloader"
+	"github.com/bowenislandsong/neuronetes/pkg/metrics"
+	"github.com/bowenislandsong/neuronetes/pkg/plugins"
 )
 
 var (
@@ -32,6 +41,20 @@ func main() {
 	var enableLeaderElection bool
 	var probeAddr string
 	var enableMockMode bool
+	var agentImage string
+	var schedulerName string
+	var deschedulerInterval time.Duration
+	var utilizationThreshold float64
+	var ruleLabels string
+	var modelCacheDir string
+	var downloadConcurrency int
+	var enableModelCache bool
+	var modelRetention time.Duration
+	var deleteUnusedModels bool
+	var promConfig autoscaler.PrometheusConfig
+	var quantizerImage string
+	var cachePopulatorImage string
+	var metricsConfig string
 
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
@@ -39,6 +62,36 @@ func main() {
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
 	flag.BoolVar(&enableMockMode, "enable-mock-mode", false, "Enable mock mode for testing without real infrastructure")
+	flag.StringVar(&agentImage, "agent-image", controllers.DefaultAgentImage, "The default agent runtime image for AgentPools.")
+	flag.StringVar(&schedulerName, "scheduler-name", "",
+		"The scheduler that places agent replicas, e.g. neuronetes-scheduler. Defaults to the cluster's default scheduler.")
+	flag.DurationVar(&deschedulerInterval, "descheduler-interval", 0,
+		"How often replicas are consolidated off mostly idle GPU nodes, e.g. 10m. Zero disables the descheduler.")
+	flag.Float64Var(&utilizationThreshold, "descheduler-utilization-threshold", descheduler.DefaultUtilizationThreshold,
+		"The share of a node's GPUs below which the descheduler moves its replicas.")
+	flag.StringVar(&ruleLabels, "prometheus-rule-labels", "",
+		"Labels added to the PrometheusRule of each AgentPool, e.g. release=kube-prometheus-stack.")
+	flag.StringVar(&modelCacheDir, "model-cache-dir", "",
+		"The directory Model weights are downloaded into, usually a volume shared with the nodes. Empty leaves weights to loader plugins.")
+	flag.IntVar(&downloadConcurrency, "download-concurrency", downloader.DefaultConcurrency,
+		"The chunks of Model weights downloaded at once.")
+	flag.BoolVar(&enableModelCache, "enable-node-model-cache", false,
+		"Assign Models to the node cache agents their CachePolicy preloads them on.")
+	flag.DurationVar(&modelRetention, "model-gc-retention", 0,
+		"How long a Model no AgentClass references stays cached after its last use, e.g. 168h. Zero disables Model garbage collection.")
+	flag.BoolVar(&deleteUnusedModels, "model-gc-delete", false,
+		"Delete the Models garbage collection evicts from the node caches.")
+	flag.StringVar(&quantizerImage, "quantizer-image", controllers.DefaultQuantizerImage,
+		"The default image of the Jobs converting the weights of ModelQuantizations.")
+	flag.StringVar(&cachePopulatorImage, "cache-populator-image", controllers.DefaultCachePopulatorImage,
+		"The image of the Jobs populating the cache volumes of Models, running its /cache-agent.")
+	flag.StringVar(&promConfig.Address, "prometheus-address", "",
+		"The Prometheus server URL ModelRollouts are analyzed from. ModelRollouts are not reconciled if empty.")
+	flag.StringVar(&promConfig.BearerTokenFile, "prometheus-bearer-token-file", "", "File containing a bearer token for Prometheus.")
+	flag.StringVar(&promConfig.PoolSelector, "prometheus-pool-selector", autoscaler.DefaultPoolSelector, "Template for the PromQL label matchers selecting a pool's series.")
+	flag.DurationVar(&promConfig.Timeout, "prometheus-timeout", 10*time.Second, "Timeout for each Prometheus query.")
+	flag.StringVar(&metricsConfig, "metrics-config", "",
+		"YAML file disabling metric families, dropping labels and setting histogram buckets of the agent metrics.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -47,7 +100,14 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
-	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
+	prometheusRuleLabels, err := labels.ConvertSelectorToLabelsMap(ruleLabels)
+	if err != nil {
+		setupLog.Error(err, "invalid prometheus rule labels")
+		os.Exit(1)
+	}
+
+	config := ctrl.GetConfigOrDie()
+	mgr, err := ctrl.NewManager(config, ctrl.Options{
 		Scheme:                 scheme,
 		Metrics:                metricsserver.Options{BindAddress: metricsAddr},
 		HealthProbeBindAddress: probeAddr,
@@ -59,22 +119,152 @@ func main() {
 		os.Exit(1)
 	}
 
-	if err = (&controllers.ModelReconciler{
+	var agentMetricsConfig metrics.Config
+	if metricsConfig != "" {
+		if agentMetricsConfig, err = metrics.LoadConfig(metricsConfig); err != nil {
+			setupLog.Error(err, "unable to load metrics config")
+			os.Exit(1)
+		}
+	}
+	agentMetrics, err := metrics.NewAgentMetricsWithConfig(ctrlmetrics.Registry, agentMetricsConfig)
+	if err != nil {
+		setupLog.Error(err, "unable to create metrics")
+		os.Exit(1)
+	}
+
+	modelReconciler := &controllers.ModelReconciler{
+		Client:  mgr.GetClient(),
+		Scheme:  mgr.GetScheme(),
+		Plugins: plugins.GetGlobalRegistry(),
+		Metrics: agentMetrics,
+	}
+	if modelCacheDir != "" {
+		modelReconciler.Downloader = downloader.New(downloader.Options{Concurrency: downloadConcurrency})
+		modelReconciler.CacheDir = modelCacheDir
+	}
+	if err = modelReconciler.SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Model")
+		os.Exit(1)
+	}
+
+	adapterReconciler := &controllers.AdapterReconciler{
 		Client: mgr.GetClient(),
 		Scheme: mgr.GetScheme(),
+		Loader: controllers.NewAdapterLoader(),
+	}
+	if modelCacheDir != "" {
+		adapterReconciler.Downloader = modelReconciler.Downloader
+		adapterReconciler.CacheDir = modelCacheDir
+	}
+	if err = adapterReconciler.SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Adapter")
+		os.Exit(1)
+	}
+
+	if enableModelCache {
+		if err = (&controllers.ModelCacheReconciler{
+			Client: mgr.GetClient(),
+			Scheme: mgr.GetScheme(),
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "ModelCache")
+			os.Exit(1)
+		}
+	}
+
+	if err = (&controllers.CacheVolumeReconciler{
+		Client: mgr.GetClient(),
+		Scheme: mgr.GetScheme(),
+		Image:  cachePopulatorImage,
 	}).SetupWithManager(mgr); err != nil {
-		setupLog.Error(err, "unable to create controller", "controller", "Model")
+		setupLog.Error(err, "unable to create controller", "controller", "CacheVolume")
 		os.Exit(1)
 	}
 
-	if err = (&controllers.AgentPoolReconciler{
+	if modelRetention > 0 {
+		if err = (&controllers.ModelGCReconciler{
+			Client:       mgr.GetClient(),
+			Scheme:       mgr.GetScheme(),
+			Retention:    modelRetention,
+			DeleteUnused: deleteUnusedModels,
+			CacheDir:     modelCacheDir,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "ModelGC")
+			os.Exit(1)
+		}
+	}
+
+	if err = (&controllers.ModelQuantizationReconciler{
+		Client: mgr.GetClient(),
+		Scheme: mgr.GetScheme(),
+		Image:  quantizerImage,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "ModelQuantization")
+		os.Exit(1)
+	}
+
+	if err = (&controllers.ModelRegistryReconciler{
+		Client: mgr.GetClient(),
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "ModelRegistry")
+		os.Exit(1)
+	}
+
+	if err = (&controllers.AgentClassReconciler{
 		Client: mgr.GetClient(),
 		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "AgentClass")
+		os.Exit(1)
+	}
+
+	podExecutor, err := checkpoint.NewPodExecutor(config)
+	if err != nil {
+		setupLog.Error(err, "unable to create pod executor")
+		os.Exit(1)
+	}
+	if err = (&controllers.AgentPoolReconciler{
+		Client:        mgr.GetClient(),
+		Scheme:        mgr.GetScheme(),
+		AgentImage:    agentImage,
+		SchedulerName: schedulerName,
+		RuleLabels:    prometheusRuleLabels,
+		Snapshots:     controllers.NewSnapshotter(podExecutor),
+		Metrics:       agentMetrics,
+		WarmUp:        controllers.NewWarmUpRunner(),
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "AgentPool")
 		os.Exit(1)
 	}
 
+	if promConfig.Address != "" {
+		provider, err := autoscaler.NewPrometheusMetricsProvider(promConfig)
+		if err != nil {
+			setupLog.Error(err, "unable to create metrics provider")
+			os.Exit(1)
+		}
+		if err = (&controllers.ModelRolloutReconciler{
+			Client: mgr.GetClient(),
+			Scheme: mgr.GetScheme(),
+			Stats:  provider,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "ModelRollout")
+			os.Exit(1)
+		}
+	}
+
+	if deschedulerInterval > 0 {
+		if err = (&descheduler.Descheduler{
+			Client:               mgr.GetClient(),
+			Recorder:             mgr.GetEventRecorderFor("descheduler"),
+			Interval:             deschedulerInterval,
+			UtilizationThreshold: utilizationThreshold,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create descheduler")
+			os.Exit(1)
+		}
+	}
+
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
 		setupLog.Error(err, "unable to set up health check")
 		os.Exit(1)
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	_ "k8s.io/client-go/plugin/pkg/client/auth"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/autoscaler"
+	"github.com/bowenislandsong/neuronetes/pkg/externalmetrics"
+)
+
+var (
+	scheme   = runtime.NewScheme()
+	setupLog = ctrl.Log.WithName("setup")
+)
+
+func init() {
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(neuronetes.AddToScheme(scheme))
+}
+
+func main() {
+	var metricsAddr string
+	var probeAddr string
+	var secureAddr string
+	var certFile string
+	var keyFile string
+	var promConfig autoscaler.PrometheusConfig
+
+	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
+	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
+	flag.StringVar(&secureAddr, "secure-bind-address", ":6443", "The address the External Metrics API is served on.")
+	flag.StringVar(&certFile, "tls-cert-file", "", "Serving certificate. A self-signed certificate is generated if unset.")
+	flag.StringVar(&keyFile, "tls-private-key-file", "", "Private key of the serving certificate.")
+	flag.StringVar(&promConfig.Address, "prometheus-address", "", "The Prometheus server URL agent metrics are read from.")
+	flag.StringVar(&promConfig.BearerTokenFile, "prometheus-bearer-token-file", "", "File containing a bearer token for Prometheus.")
+	flag.StringVar(&promConfig.PoolSelector, "prometheus-pool-selector", autoscaler.DefaultPoolSelector, "Template for the PromQL label matchers selecting a pool's series.")
+	flag.DurationVar(&promConfig.Timeout, "prometheus-timeout", 10*time.Second, "Timeout for each Prometheus query.")
+	opts := zap.Options{
+		Development: true,
+	}
+	opts.BindFlags(flag.CommandLine)
+	flag.Parse()
+
+	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
+
+	provider, err := autoscaler.NewPrometheusMetricsProvider(promConfig)
+	if err != nil {
+		setupLog.Error(err, "unable to create metrics provider")
+		os.Exit(1)
+	}
+
+	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
+		Scheme:                 scheme,
+		Metrics:                metricsserver.Options{BindAddress: metricsAddr},
+		HealthProbeBindAddress: probeAddr,
+	})
+	if err != nil {
+		setupLog.Error(err, "unable to start manager")
+		os.Exit(1)
+	}
+
+	if err := mgr.Add(&externalmetrics.Server{
+		Addr:     secureAddr,
+		CertFile: certFile,
+		KeyFile:  keyFile,
+		Handler:  externalmetrics.NewAdapter(mgr.GetClient(), provider),
+	}); err != nil {
+		setupLog.Error(err, "unable to add external metrics server")
+		os.Exit(1)
+	}
+
+	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
+		setupLog.Error(err, "unable to set up health check")
+		os.Exit(1)
+	}
+	if err := mgr.AddReadyzCheck("readyz", healthz.Ping); err != nil {
+		setupLog.Error(err, "unable to set up ready check")
+		os.Exit(1)
+	}
+
+	setupLog.Info("starting external metrics adapter")
+	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
+		setupLog.Error(err, "problem running metrics adapter")
+		os.Exit(1)
+	}
+}
@@ -1,45 +1,61 @@
 package main
 
 import (
-	"flag"
+	"context"
+	"fmt"
+	"os"
 
-	"k8s.io/apimachinery/pkg/runtime"
-	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
-	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"go.opentelemetry.io/otel"
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
-	ctrl "sigs.k8s.io/controller-runtime"
-	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"k8s.io/component-base/cli"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/kubernetes/cmd/kube-scheduler/app"
 
-	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/metrics"
+	"github.com/bowenislandsong/neuronetes/pkg/scheduler"
 )
 
-var (
-	scheme   = runtime.NewScheme()
-	setupLog = ctrl.Log.WithName("setup")
-)
-
-func init() {
-	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
-	utilruntime.Must(neuronetes.AddToScheme(scheme))
-}
-
+// main runs kube-scheduler with the GPU topology plugin registered. The
+// plugin is enabled for a profile, usually named neuronetes-scheduler,
+// through the KubeSchedulerConfiguration passed with --config.
+//
+// Metrics are also pushed to an OTLP collector when
+// OTEL_EXPORTER_OTLP_ENDPOINT is set, and configured by the file
+// NEURONETES_METRICS_CONFIG points to, if set.
 func main() {
-	var metricsAddr string
+	os.Exit(run())
+}
 
-	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
-	opts := zap.Options{
-		Development: true,
+func run() int {
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" {
+		provider, err := metrics.NewOTLPMeterProvider(context.Background(), metrics.OTLPConfig{
+			ServiceName: "neuronetes-scheduler",
+		})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		otel.SetMeterProvider(provider)
+		// Flush the last metrics on exit
+		defer func() { _ = provider.Shutdown(context.Background()) }()
 	}
-	opts.BindFlags(flag.CommandLine)
-	flag.Parse()
 
-	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
-
-	setupLog.Info("starting GPU topology scheduler")
-
-	// Scheduler implementation would go here
-	// This is a skeleton for the build
+	var config metrics.Config
+	if path := os.Getenv("NEURONETES_METRICS_CONFIG"); path != "" {
+		var err error
+		if config, err = metrics.LoadConfig(path); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+	agentMetrics, err := metrics.NewAgentMetricsWithConfig(legacyregistry.Registerer(), config)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
 
-	setupLog.Info("scheduler running")
-	select {}
+	command := app.NewSchedulerCommand(
+		app.WithPlugin(scheduler.Name, scheduler.NewPluginFactory(agentMetrics)),
+	)
+	return cli.Run(command)
 }
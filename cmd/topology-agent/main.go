@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	_ "k8s.io/client-go/plugin/pkg/client/auth"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+
+	"github.com/bowenislandsong/neuronetes/pkg/carbon"
+	"github.com/bowenislandsong/neuronetes/pkg/dcgm"
+	"github.com/bowenislandsong/neuronetes/pkg/metrics"
+	"github.com/bowenislandsong/neuronetes/pkg/topology"
+)
+
+// main publishes the GPU interconnect topology of the node it runs on for the
+// GPU topology scheduler. It runs as a DaemonSet on GPU nodes.
+//
+// With --carbon-provider, it also estimates the energy and emissions of the
+// node's GPUs from the utilization its dcgm-exporter reports, and serves them
+// as metrics.
+func main() {
+	var nodeName string
+	var interval time.Duration
+	var metricsAddr string
+	var carbonProvider string
+	var carbonTable string
+	var carbonInterval time.Duration
+	var dcgmExporterPort int
+
+	flag.StringVar(&nodeName, "node-name", os.Getenv("NODE_NAME"), "The node this agent runs on. Defaults to $NODE_NAME.")
+	flag.DurationVar(&interval, "interval", topology.DefaultPublishInterval, "How often the topology is rediscovered.")
+	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to with --carbon-provider.")
+	flag.StringVar(&carbonProvider, "carbon-provider", "", "Where the carbon intensity of the node's region is read from: static or electricitymaps, which requires $ELECTRICITYMAPS_TOKEN. Empty disables energy and carbon estimation.")
+	flag.StringVar(&carbonTable, "carbon-table", "", "A YAML file of carbon intensities in gCO2e/kWh by region, taking precedence over the provider's.")
+	flag.DurationVar(&carbonInterval, "carbon-interval", carbon.DefaultEstimateInterval, "How often the energy and emissions of the node are estimated.")
+	flag.IntVar(&dcgmExporterPort, "dcgm-exporter-port", dcgm.DefaultPort, "The port of the node's dcgm-exporter the GPU utilization is read from.")
+	opts := zap.Options{
+		Development: true,
+	}
+	opts.BindFlags(flag.CommandLine)
+	flag.Parse()
+
+	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
+	setupLog := ctrl.Log.WithName("setup")
+
+	if nodeName == "" {
+		setupLog.Info("--node-name or $NODE_NAME is required")
+		os.Exit(1)
+	}
+	clientset, err := kubernetes.NewForConfig(ctrl.GetConfigOrDie())
+	if err != nil {
+		setupLog.Error(err, "unable to create clientset")
+		os.Exit(1)
+	}
+
+	setupLog.Info("starting GPU topology agent", "node", nodeName)
+	ctx := log.IntoContext(ctrl.SetupSignalHandler(), ctrl.Log.WithName("topology-agent"))
+	if carbonProvider != "" {
+		if err := startCarbonEstimator(ctx, clientset, nodeName, metricsAddr, dcgmExporterPort, carbon.Config{
+			Provider:             carbonProvider,
+			Table:                carbonTable,
+			ElectricityMapsToken: os.Getenv("ELECTRICITYMAPS_TOKEN"),
+		}, carbonInterval); err != nil {
+			setupLog.Error(err, "unable to start carbon estimator")
+			os.Exit(1)
+		}
+	}
+	if err := topology.NewAgent(clientset, nodeName, interval).Start(ctx); err != nil {
+		setupLog.Error(err, "problem running topology agent")
+		os.Exit(1)
+	}
+}
+
+// startCarbonEstimator estimates the energy and emissions of the GPUs of
+// node in the background, from the utilization recorded from its
+// dcgm-exporter, and serves them on metricsAddr
+func startCarbonEstimator(ctx context.Context, clientset kubernetes.Interface, nodeName, metricsAddr string, dcgmExporterPort int, config carbon.Config, interval time.Duration) error {
+	provider, err := carbon.New(config)
+	if err != nil {
+		return err
+	}
+	node, err := clientset.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	dcgmClient := dcgm.NewClient(dcgm.Config{Port: dcgmExporterPort})
+	endpoint, err := dcgmClient.Endpoint(node)
+	if err != nil {
+		return err
+	}
+
+	registry := prometheus.NewRegistry()
+	agentMetrics := metrics.NewAgentMetrics(registry)
+	estimator, err := carbon.NewEstimator(node, 0, provider, agentMetrics, interval)
+	if err != nil {
+		return err
+	}
+	logger := log.FromContext(ctx)
+	go func() {
+		if err := metrics.NewServer(metricsAddr, registry).Start(ctx); err != nil {
+			logger.Error(err, "problem serving metrics")
+			os.Exit(1)
+		}
+	}()
+	go func() {
+		_ = dcgm.NewRecorder(dcgmClient, nodeName, endpoint, agentMetrics, 0).Start(ctx)
+	}()
+	go func() {
+		_ = estimator.Start(ctx)
+	}()
+	return nil
+}
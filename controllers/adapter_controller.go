@@ -0,0 +1,494 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/downloader"
+	"github.com/bowenislandsong/neuronetes/pkg/lora"
+	"github.com/bowenislandsong/neuronetes/pkg/modelcache"
+)
+
+const (
+	// adapterFinalizer unloads an Adapter from the replicas it is loaded
+	// onto before it is deleted
+	adapterFinalizer = "neuronetes.io/adapter"
+
+	// adapterRetryInterval is how soon loading an adapter onto a replica
+	// is retried after it failed
+	adapterRetryInterval = 30 * time.Second
+
+	// adapterPollInterval is how often the download of adapter weights is
+	// checked
+	adapterPollInterval = 5 * time.Second
+
+	// adapterCacheDir is the directory of the adapters of a namespace in
+	// the model cache. Names of Models cannot start with a dot, so it
+	// never holds the weights of a model.
+	adapterCacheDir = ".adapters"
+)
+
+// NewAdapterLoader creates a loader of adapters onto the agent runtime of
+// the replicas AgentPoolReconciler creates
+func NewAdapterLoader() *lora.Client {
+	return lora.NewClient(agentPort)
+}
+
+// AdapterReconciler reconciles an Adapter object. It loads the adapter onto
+// the running replicas of the pools serving its base model, and onto new
+// replicas as they become ready, without restarting them.
+type AdapterReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+
+	// Loader loads adapters onto the runtime of replicas
+	Loader lora.Loader
+
+	// Downloader downloads the weights of adapters into CacheDir, for the
+	// weights URIs it supports. Without it, replicas are handed the
+	// weights URI.
+	Downloader *downloader.Downloader
+
+	// CacheDir is the directory of the model cache shared with the nodes
+	// serving the models. Adapter weights are downloaded into
+	// <CacheDir>/<namespace>/.adapters/<name>.
+	CacheDir string
+
+	downloads downloadTracker
+}
+
+// +kubebuilder:rbac:groups=neuronetes.io,resources=adapters,verbs=get;list;watch;update;patch
+// +kubebuilder:rbac:groups=neuronetes.io,resources=adapters/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=neuronetes.io,resources=adapters/finalizers,verbs=update
+// +kubebuilder:rbac:groups=neuronetes.io,resources=models,verbs=get;list;watch
+// +kubebuilder:rbac:groups=neuronetes.io,resources=agentclasses,verbs=get;list;watch
+// +kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch;patch
+// +kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch
+
+// Reconcile loads an adapter onto the replicas serving its base model
+func (r *AdapterReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	var adapter neuronetes.Adapter
+	if err := r.Get(ctx, req.NamespacedName, &adapter); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if !adapter.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, r.finalize(ctx, &adapter)
+	}
+	if controllerutil.AddFinalizer(&adapter, adapterFinalizer) {
+		if err := r.Update(ctx, &adapter); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	status := adapter.Status.DeepCopy()
+	modelKey := types.NamespacedName{Namespace: adapter.Namespace, Name: adapter.Spec.BaseModelRef.Name}
+	if err := r.Get(ctx, modelKey, &neuronetes.Model{}); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return ctrl.Result{}, err
+		}
+		status.Phase = neuronetes.AdapterPending
+		status.Message = fmt.Sprintf("base model %s not found", modelKey.Name)
+		return ctrl.Result{}, r.updateStatus(ctx, &adapter, status)
+	}
+
+	path, message, err := r.weights(ctx, &adapter)
+	if err != nil {
+		status.Phase = neuronetes.AdapterFailed
+		status.Message = err.Error()
+		if statusErr := r.updateStatus(ctx, &adapter, status); statusErr != nil {
+			return ctrl.Result{}, statusErr
+		}
+		var downloadErr *adapterDownloadError
+		if errors.As(err, &downloadErr) {
+			// Failed downloads are retried once the spec changes
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+	if path == "" {
+		status.Phase = neuronetes.AdapterLoading
+		status.Message = message
+		if err := r.updateStatus(ctx, &adapter, status); err != nil {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{RequeueAfter: adapterPollInterval}, nil
+	}
+	status.Path = path
+
+	pods, err := r.replicas(ctx, modelKey)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	loaded, failed, err := r.load(ctx, &adapter, path, pods)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	status.Replicas = int32(len(pods))
+	status.LoadedReplicas = loaded
+	switch {
+	case len(pods) == 0:
+		status.Phase = neuronetes.AdapterPending
+		status.Message = fmt.Sprintf("no replica serves model %s", modelKey.Name)
+	case int(loaded) == len(pods):
+		status.Phase = neuronetes.AdapterReady
+		status.Message = fmt.Sprintf("loaded onto %d replicas", loaded)
+	default:
+		status.Phase = neuronetes.AdapterLoading
+		status.Message = fmt.Sprintf("loaded onto %d of %d replicas", loaded, len(pods))
+		if failed > 0 {
+			status.Message += fmt.Sprintf(", %d failed", failed)
+		}
+	}
+	if err := r.updateStatus(ctx, &adapter, status); err != nil {
+		return ctrl.Result{}, err
+	}
+	if failed > 0 {
+		return ctrl.Result{RequeueAfter: adapterRetryInterval}, nil
+	}
+	return ctrl.Result{}, nil
+}
+
+// adapterDownloadError is the error of a failed download of the weights of
+// an adapter
+type adapterDownloadError struct {
+	err error
+}
+
+func (e *adapterDownloadError) Error() string {
+	return fmt.Sprintf("failed to download adapter weights: %v", e.err)
+}
+
+func (e *adapterDownloadError) Unwrap() error {
+	return e.err
+}
+
+// weights returns where replicas read the weights of adapter from, or an
+// empty path and the progress of their download while downloading. Each
+// weights URI is downloaded into a directory of its own, so that replicas
+// reload the adapter when it changes.
+func (r *AdapterReconciler) weights(ctx context.Context, adapter *neuronetes.Adapter) (string, string, error) {
+	if r.Downloader == nil || !downloader.Supported(adapter.Spec.WeightsURI) {
+		return adapter.Spec.WeightsURI, "", nil
+	}
+	key, dir := r.weightsDir(adapter)
+	snap, ok := r.downloads.snapshot(key)
+	if !ok {
+		if adapter.Status.Path == dir {
+			return dir, "", nil
+		}
+		req, err := modelcache.AdapterDownloadRequest(ctx, r, adapter, dir)
+		if err != nil {
+			return "", "", err
+		}
+		r.downloads.start(key, r.Downloader, req)
+		log.FromContext(ctx).Info("Downloading adapter weights", "uri", adapter.Spec.WeightsURI, "path", dir)
+		return "", "downloading weights", nil
+	}
+	switch {
+	case !snap.finished:
+		return "", fmt.Sprintf("downloading weights, %d%%", snap.percent()), nil
+	case snap.err != nil:
+		return "", "", &adapterDownloadError{err: snap.err}
+	}
+	r.downloads.forget(key)
+	r.pruneWeights(ctx, adapter, dir)
+	return dir, "", nil
+}
+
+// weightsDir returns the download of the weights of adapter and the
+// directory they are downloaded into
+func (r *AdapterReconciler) weightsDir(adapter *neuronetes.Adapter) (types.NamespacedName, string) {
+	sum := sha256.Sum256([]byte(adapter.Spec.WeightsURI))
+	revision := hex.EncodeToString(sum[:])[:10]
+	key := types.NamespacedName{Namespace: adapter.Namespace, Name: adapter.Name + "/" + revision}
+	return key, filepath.Join(r.adapterDir(adapter), revision)
+}
+
+// adapterDir returns the directory of the weights of every weights URI of
+// adapter
+func (r *AdapterReconciler) adapterDir(adapter *neuronetes.Adapter) string {
+	return filepath.Join(r.CacheDir, adapter.Namespace, adapterCacheDir, adapter.Name)
+}
+
+// pruneWeights removes the weights of adapter other than those in keep,
+// downloaded from its previous weights URIs
+func (r *AdapterReconciler) pruneWeights(ctx context.Context, adapter *neuronetes.Adapter, keep string) {
+	entries, err := os.ReadDir(r.adapterDir(adapter))
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		path := filepath.Join(r.adapterDir(adapter), entry.Name())
+		if path == keep {
+			continue
+		}
+		if err := os.RemoveAll(path); err != nil {
+			log.FromContext(ctx).Error(err, "failed to remove stale adapter weights", "path", path)
+		}
+	}
+}
+
+// replicas returns the agent replicas serving the model key: those of the
+// pools of its AgentClasses, in every namespace
+func (r *AdapterReconciler) replicas(ctx context.Context, key types.NamespacedName) ([]*corev1.Pod, error) {
+	var classes neuronetes.AgentClassList
+	if err := r.List(ctx, &classes); err != nil {
+		return nil, fmt.Errorf("failed to list agent classes: %w", err)
+	}
+	var replicas []*corev1.Pod
+	for i := range classes.Items {
+		class := &classes.Items[i]
+		if modelKey(class) != key {
+			continue
+		}
+		var pods corev1.PodList
+		if err := r.List(ctx, &pods,
+			client.InNamespace(class.Namespace),
+			client.MatchingLabels{neuronetes.LabelAgentClass: class.Name, neuronetes.LabelComponent: agentComponent},
+		); err != nil {
+			return nil, fmt.Errorf("failed to list pods: %w", err)
+		}
+		for j := range pods.Items {
+			pod := &pods.Items[j]
+			if pod.DeletionTimestamp.IsZero() && pod.Status.Phase != corev1.PodSucceeded && pod.Status.Phase != corev1.PodFailed {
+				replicas = append(replicas, pod)
+			}
+		}
+	}
+	sort.Slice(replicas, func(i, j int) bool {
+		return client.ObjectKeyFromObject(replicas[i]).String() < client.ObjectKeyFromObject(replicas[j]).String()
+	})
+	return replicas, nil
+}
+
+// load loads adapter onto the ready pods that do not have its current
+// revision, concurrently, and returns how many pods have it and how many
+// failed to load it. Snapshotted replicas load it once restored.
+func (r *AdapterReconciler) load(ctx context.Context, adapter *neuronetes.Adapter, path string, pods []*corev1.Pod) (int32, int32, error) {
+	log := log.FromContext(ctx)
+	spec := lora.Adapter{Name: adapter.Name, Path: path, Scaling: adapter.Spec.Scaling}
+	revision, err := adapterRevision(spec)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var loaded int32
+	var load []*corev1.Pod
+	for _, pod := range pods {
+		switch {
+		case loadedAdapters(pod)[adapter.Name] == revision:
+			loaded++
+		case podCondition(pod, corev1.ContainersReady) == corev1.ConditionTrue && pod.Status.PodIP != "" && !isSnapshotted(pod):
+			load = append(load, pod)
+		}
+	}
+
+	errs := make([]error, len(load))
+	var wg sync.WaitGroup
+	for i, pod := range load {
+		wg.Add(1)
+		go func(i int, pod *corev1.Pod) {
+			defer wg.Done()
+			errs[i] = r.Loader.Load(ctx, pod, spec)
+		}(i, pod)
+	}
+	wg.Wait()
+
+	var failed int32
+	for i, pod := range load {
+		if errs[i] != nil {
+			log.Error(errs[i], "failed to load adapter onto replica", "pod", pod.Name)
+			failed++
+			continue
+		}
+		if err := r.setLoaded(ctx, pod, adapter.Name, revision); err != nil {
+			return 0, 0, err
+		}
+		log.Info("Loaded adapter onto replica", "pod", pod.Name)
+		loaded++
+	}
+	return loaded, failed, nil
+}
+
+// finalize unloads adapter from the replicas it is loaded onto, removes its
+// weights from the model cache and releases it for deletion
+func (r *AdapterReconciler) finalize(ctx context.Context, adapter *neuronetes.Adapter) error {
+	if !controllerutil.ContainsFinalizer(adapter, adapterFinalizer) {
+		return nil
+	}
+	log := log.FromContext(ctx)
+
+	var pods corev1.PodList
+	if err := r.List(ctx, &pods, client.MatchingLabels{neuronetes.LabelComponent: agentComponent}); err != nil {
+		return fmt.Errorf("failed to list pods: %w", err)
+	}
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if _, ok := loadedAdapters(pod)[adapter.Name]; !ok {
+			continue
+		}
+		// Replicas that are not running have lost their adapters
+		if pod.DeletionTimestamp.IsZero() && pod.Status.PodIP != "" && podCondition(pod, corev1.ContainersReady) == corev1.ConditionTrue {
+			if err := r.Loader.Unload(ctx, pod, adapter.Name); err != nil {
+				return err
+			}
+			log.Info("Unloaded adapter from replica", "pod", pod.Name)
+		}
+		if err := r.setLoaded(ctx, pod, adapter.Name, ""); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+
+	if r.Downloader != nil {
+		key, _ := r.weightsDir(adapter)
+		r.downloads.forget(key)
+		if err := os.RemoveAll(r.adapterDir(adapter)); err != nil {
+			return fmt.Errorf("failed to remove adapter weights: %w", err)
+		}
+	}
+
+	controllerutil.RemoveFinalizer(adapter, adapterFinalizer)
+	return r.Update(ctx, adapter)
+}
+
+// setLoaded records in the annotations of pod that it has the revision of
+// the adapter name loaded, or none if revision is empty
+func (r *AdapterReconciler) setLoaded(ctx context.Context, pod *corev1.Pod, name, revision string) error {
+	// Adapters of the same pod are reconciled concurrently
+	patch := client.MergeFromWithOptions(pod.DeepCopy(), client.MergeFromWithOptimisticLock{})
+	adapters := loadedAdapters(pod)
+	if revision == "" {
+		delete(adapters, name)
+	} else {
+		adapters[name] = revision
+	}
+	pairs := make([]string, 0, len(adapters))
+	for name, revision := range adapters {
+		pairs = append(pairs, name+"="+revision)
+	}
+	sort.Strings(pairs)
+
+	if pod.Annotations == nil {
+		pod.Annotations = make(map[string]string)
+	}
+	if len(pairs) == 0 {
+		delete(pod.Annotations, neuronetes.AnnotationAdapters)
+	} else {
+		pod.Annotations[neuronetes.AnnotationAdapters] = strings.Join(pairs, ",")
+	}
+	if err := r.Patch(ctx, pod, patch); err != nil {
+		return fmt.Errorf("failed to record adapters of pod %s: %w", pod.Name, err)
+	}
+	return nil
+}
+
+// updateStatus updates the status of adapter if it changed
+func (r *AdapterReconciler) updateStatus(ctx context.Context, adapter *neuronetes.Adapter, status *neuronetes.AdapterStatus) error {
+	if equality.Semantic.DeepEqual(&adapter.Status, status) {
+		return nil
+	}
+	adapter.Status = *status
+	return r.Status().Update(ctx, adapter)
+}
+
+// loadedAdapters returns the revisions of the adapters loaded onto pod by
+// name
+func loadedAdapters(pod *corev1.Pod) map[string]string {
+	adapters := make(map[string]string)
+	for _, pair := range strings.Split(pod.Annotations[neuronetes.AnnotationAdapters], ",") {
+		if name, revision, ok := strings.Cut(pair, "="); ok {
+			adapters[name] = revision
+		}
+	}
+	return adapters
+}
+
+// adapterRevision hashes what a replica loads of an adapter, so that a
+// change reloads it
+func adapterRevision(adapter lora.Adapter) (string, error) {
+	data, err := json.Marshal(adapter)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])[:10], nil
+}
+
+// adaptersForModel maps a Model to the adapters of it
+func (r *AdapterReconciler) adaptersForModel(ctx context.Context, obj client.Object) []reconcile.Request {
+	return r.adaptersOf(ctx, client.ObjectKeyFromObject(obj))
+}
+
+// adaptersOf returns the requests of the adapters of the model key
+func (r *AdapterReconciler) adaptersOf(ctx context.Context, key types.NamespacedName) []reconcile.Request {
+	var adapters neuronetes.AdapterList
+	if err := r.List(ctx, &adapters, client.InNamespace(key.Namespace)); err != nil {
+		log.FromContext(ctx).Error(err, "failed to list adapters", "model", key.Name)
+		return nil
+	}
+	var requests []reconcile.Request
+	for i := range adapters.Items {
+		if adapters.Items[i].Spec.BaseModelRef.Name == key.Name {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&adapters.Items[i])})
+		}
+	}
+	return requests
+}
+
+// adaptersForAgentClass maps an AgentClass to the adapters of its model
+func (r *AdapterReconciler) adaptersForAgentClass(ctx context.Context, obj client.Object) []reconcile.Request {
+	class, ok := obj.(*neuronetes.AgentClass)
+	if !ok {
+		return nil
+	}
+	return r.adaptersOf(ctx, modelKey(class))
+}
+
+// adaptersForPod maps an agent replica to the adapters of the model of its
+// AgentClass
+func (r *AdapterReconciler) adaptersForPod(ctx context.Context, obj client.Object) []reconcile.Request {
+	name, ok := obj.GetLabels()[neuronetes.LabelAgentClass]
+	if !ok || obj.GetLabels()[neuronetes.LabelComponent] != agentComponent {
+		return nil
+	}
+	var class neuronetes.AgentClass
+	if err := r.Get(ctx, types.NamespacedName{Namespace: obj.GetNamespace(), Name: name}, &class); err != nil {
+		return nil
+	}
+	return r.adaptersForAgentClass(ctx, &class)
+}
+
+// SetupWithManager sets up the controller with the Manager. Changes to the
+// base model of an adapter, its AgentClasses and their replicas reconcile
+// it.
+func (r *AdapterReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&neuronetes.Adapter{}).
+		Watches(&neuronetes.Model{}, handler.EnqueueRequestsFromMapFunc(r.adaptersForModel)).
+		Watches(&neuronetes.AgentClass{}, handler.EnqueueRequestsFromMapFunc(r.adaptersForAgentClass)).
+		Watches(&corev1.Pod{}, handler.EnqueueRequestsFromMapFunc(r.adaptersForPod)).
+		Complete(r)
+}
@@ -0,0 +1,167 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/lora"
+)
+
+// fakeLoader records the adapters loaded onto each pod
+type fakeLoader struct {
+	mu     sync.Mutex
+	loaded map[string]map[string]lora.Adapter
+	loads  int
+	fail   map[string]bool
+}
+
+func (l *fakeLoader) Load(_ context.Context, pod *corev1.Pod, adapter lora.Adapter) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.fail[pod.Name] {
+		return fmt.Errorf("connection refused")
+	}
+	if l.loaded[pod.Name] == nil {
+		l.loaded[pod.Name] = make(map[string]lora.Adapter)
+	}
+	l.loaded[pod.Name][adapter.Name] = adapter
+	l.loads++
+	return nil
+}
+
+func (l *fakeLoader) Unload(_ context.Context, pod *corev1.Pod, name string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.loaded[pod.Name], name)
+	return nil
+}
+
+func readyAgentPod(name string) *corev1.Pod {
+	pod := agentPod(name, true, 0)
+	pod.Labels[neuronetes.LabelAgentClass] = "chat-agent"
+	pod.Status.PodIP = "10.0.0.7"
+	pod.Status.Conditions = append(pod.Status.Conditions, corev1.PodCondition{Type: corev1.ContainersReady, Status: corev1.ConditionTrue})
+	return pod
+}
+
+func reconcileAdapter(t *testing.T, r *AdapterReconciler, key client.ObjectKey) (ctrl.Result, *neuronetes.Adapter) {
+	t.Helper()
+	result, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: key})
+	require.NoError(t, err)
+	var adapter neuronetes.Adapter
+	if err := r.Get(context.Background(), key, &adapter); apierrors.IsNotFound(err) {
+		return result, nil
+	}
+	return result, &adapter
+}
+
+func TestAdapterReconcilerLoadsOntoReplicas(t *testing.T) {
+	ctx := context.Background()
+	model := newTestModel()
+	class := &neuronetes.AgentClass{
+		ObjectMeta: metav1.ObjectMeta{Name: "chat-agent", Namespace: "default"},
+		Spec:       neuronetes.AgentClassSpec{ModelRef: neuronetes.ModelReference{Name: model.Name}},
+	}
+	scaling := float32(2)
+	adapter := &neuronetes.Adapter{
+		ObjectMeta: metav1.ObjectMeta{Name: "llama-3-8b-support", Namespace: "default"},
+		Spec: neuronetes.AdapterSpec{
+			BaseModelRef: corev1.LocalObjectReference{Name: model.Name},
+			WeightsURI:   "s3://models/adapters/llama-3-8b-support/",
+			Scaling:      &scaling,
+		},
+	}
+	key := client.ObjectKeyFromObject(adapter)
+	starting := agentPod("chat-pool-b", false, 1)
+	starting.Labels[neuronetes.LabelAgentClass] = "chat-agent"
+	c := newFakeClient(t, adapter, readyAgentPod("chat-pool-a"), starting)
+	loader := &fakeLoader{loaded: make(map[string]map[string]lora.Adapter)}
+	r := &AdapterReconciler{Client: c, Loader: loader}
+
+	// Adapters wait for their base model and replicas serving it
+	_, got := reconcileAdapter(t, r, key)
+	assert.Equal(t, neuronetes.AdapterPending, got.Status.Phase)
+	assert.Equal(t, "base model llama-3-8b not found", got.Status.Message)
+	assert.Contains(t, got.Finalizers, adapterFinalizer)
+	require.NoError(t, c.Create(ctx, model))
+	_, got = reconcileAdapter(t, r, key)
+	assert.Equal(t, "no replica serves model llama-3-8b", got.Status.Message)
+
+	// Only ready replicas load the adapter
+	require.NoError(t, c.Create(ctx, class))
+	_, got = reconcileAdapter(t, r, key)
+	assert.Equal(t, neuronetes.AdapterLoading, got.Status.Phase)
+	assert.Equal(t, "loaded onto 1 of 2 replicas", got.Status.Message)
+	assert.Equal(t, adapter.Spec.WeightsURI, got.Status.Path)
+	assert.Equal(t, map[string]map[string]lora.Adapter{
+		"chat-pool-a": {"llama-3-8b-support": {Name: "llama-3-8b-support", Path: adapter.Spec.WeightsURI, Scaling: &scaling}},
+	}, loader.loaded)
+
+	// Replicas load it as they become ready, once
+	require.NoError(t, c.Delete(ctx, starting))
+	require.NoError(t, c.Create(ctx, readyAgentPod("chat-pool-b")))
+	_, got = reconcileAdapter(t, r, key)
+	assert.Equal(t, neuronetes.AdapterReady, got.Status.Phase)
+	assert.Equal(t, int32(2), got.Status.LoadedReplicas)
+	reconcileAdapter(t, r, key)
+	assert.Equal(t, 2, loader.loads)
+	var pod corev1.Pod
+	require.NoError(t, c.Get(ctx, client.ObjectKey{Namespace: "default", Name: "chat-pool-b"}, &pod))
+	assert.Contains(t, loadedAdapters(&pod), "llama-3-8b-support")
+
+	// Changing the adapter reloads it
+	scaling = 4
+	got.Spec.Scaling = &scaling
+	require.NoError(t, c.Update(ctx, got))
+	reconcileAdapter(t, r, key)
+	assert.Equal(t, 4, loader.loads)
+	assert.Equal(t, float32(4), *loader.loaded["chat-pool-a"]["llama-3-8b-support"].Scaling)
+
+	// Deleting it unloads it from the replicas
+	require.NoError(t, c.Delete(ctx, got))
+	_, got = reconcileAdapter(t, r, key)
+	assert.Nil(t, got)
+	assert.Empty(t, loader.loaded["chat-pool-a"])
+	assert.Empty(t, loader.loaded["chat-pool-b"])
+	require.NoError(t, c.Get(ctx, client.ObjectKey{Namespace: "default", Name: "chat-pool-b"}, &pod))
+	assert.NotContains(t, pod.Annotations, neuronetes.AnnotationAdapters)
+}
+
+func TestAdapterReconcilerRetriesFailedLoads(t *testing.T) {
+	model := newTestModel()
+	class := &neuronetes.AgentClass{
+		ObjectMeta: metav1.ObjectMeta{Name: "chat-agent", Namespace: "default"},
+		Spec:       neuronetes.AgentClassSpec{ModelRef: neuronetes.ModelReference{Name: model.Name}},
+	}
+	adapter := &neuronetes.Adapter{
+		ObjectMeta: metav1.ObjectMeta{Name: "llama-3-8b-legal", Namespace: "default"},
+		Spec: neuronetes.AdapterSpec{
+			BaseModelRef: corev1.LocalObjectReference{Name: model.Name},
+			WeightsURI:   "/models/adapters/llama-3-8b-legal",
+		},
+	}
+	key := client.ObjectKeyFromObject(adapter)
+	c := newFakeClient(t, model, class, adapter, readyAgentPod("chat-pool-a"))
+	loader := &fakeLoader{loaded: make(map[string]map[string]lora.Adapter), fail: map[string]bool{"chat-pool-a": true}}
+	r := &AdapterReconciler{Client: c, Loader: loader}
+
+	result, got := reconcileAdapter(t, r, key)
+	assert.Equal(t, ctrl.Result{RequeueAfter: adapterRetryInterval}, result)
+	assert.Equal(t, "loaded onto 0 of 1 replicas, 1 failed", got.Status.Message)
+
+	loader.fail = nil
+	result, got = reconcileAdapter(t, r, key)
+	assert.Equal(t, ctrl.Result{}, result)
+	assert.Equal(t, neuronetes.AdapterReady, got.Status.Phase)
+}
@@ -0,0 +1,133 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+)
+
+// ConditionContextLengthValid reports whether the maxContextLength of an
+// AgentClass fits in the context window of its model, as read from the
+// model's weights
+const ConditionContextLengthValid = "ContextLengthValid"
+
+// AgentClassReconciler validates AgentClasses against their models
+type AgentClassReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+// +kubebuilder:rbac:groups=neuronetes.io,resources=agentclasses,verbs=get;list;watch
+// +kubebuilder:rbac:groups=neuronetes.io,resources=agentclasses/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=neuronetes.io,resources=models,verbs=get;list;watch
+
+// Reconcile reports in the ContextLengthValid condition of an AgentClass
+// whether its maxContextLength exceeds the context window of its model
+func (r *AgentClassReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	var class neuronetes.AgentClass
+	if err := r.Get(ctx, req.NamespacedName, &class); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	key := modelKey(&class)
+	model := &neuronetes.Model{}
+	if err := r.Get(ctx, key, model); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return ctrl.Result{}, err
+		}
+		model = nil
+	}
+	condition := contextLengthCondition(&class, model)
+	condition.ObservedGeneration = class.Generation
+	if last := meta.FindStatusCondition(class.Status.Conditions, ConditionContextLengthValid); last != nil &&
+		last.Status == condition.Status && last.Reason == condition.Reason && last.Message == condition.Message &&
+		last.ObservedGeneration == condition.ObservedGeneration && class.Status.ObservedGeneration == class.Generation {
+		return ctrl.Result{}, nil
+	}
+
+	// The SLO evaluator writes the conditions of the class too
+	patch := client.MergeFromWithOptions(class.DeepCopy(), client.MergeFromWithOptimisticLock{})
+	meta.SetStatusCondition(&class.Status.Conditions, condition)
+	class.Status.ObservedGeneration = class.Generation
+	if err := r.Status().Patch(ctx, &class, patch); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if condition.Status == metav1.ConditionFalse {
+		log.FromContext(ctx).Info("AgentClass exceeds the context window of its model", "model", key, "message", condition.Message)
+	}
+	return ctrl.Result{}, nil
+}
+
+// contextLengthCondition returns the ContextLengthValid condition of class
+// running model, which is nil if it does not exist
+func contextLengthCondition(class *neuronetes.AgentClass, model *neuronetes.Model) metav1.Condition {
+	condition := metav1.Condition{Type: ConditionContextLengthValid}
+	name := class.Spec.ModelRef.Name
+	var window int32
+	if model != nil && model.Status.Metadata != nil {
+		window = model.Status.Metadata.ContextLength
+	}
+	switch {
+	case class.Spec.MaxContextLength == 0:
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "ModelContextWindow"
+		condition.Message = fmt.Sprintf("sequences are bounded by the context window of model %s", name)
+	case model == nil:
+		condition.Status = metav1.ConditionUnknown
+		condition.Reason = "ModelNotFound"
+		condition.Message = fmt.Sprintf("model %s does not exist", name)
+	case window == 0:
+		condition.Status = metav1.ConditionUnknown
+		condition.Reason = "ContextWindowUnknown"
+		condition.Message = fmt.Sprintf("the context window of model %s is read once its weights are downloaded", name)
+	case class.Spec.MaxContextLength > window:
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "ExceedsContextWindow"
+		condition.Message = fmt.Sprintf("maxContextLength %d exceeds the context window of model %s, %d tokens", class.Spec.MaxContextLength, name, window)
+	default:
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "WithinContextWindow"
+		condition.Message = fmt.Sprintf("maxContextLength %d fits the context window of model %s, %d tokens", class.Spec.MaxContextLength, name, window)
+	}
+	return condition
+}
+
+// classesForModel maps a Model to the AgentClasses of it
+func (r *AgentClassReconciler) classesForModel(ctx context.Context, obj client.Object) []reconcile.Request {
+	key := client.ObjectKeyFromObject(obj)
+
+	var classes neuronetes.AgentClassList
+	if err := r.List(ctx, &classes); err != nil {
+		log.FromContext(ctx).Error(err, "failed to list agent classes", "model", key)
+		return nil
+	}
+	var requests []reconcile.Request
+	for i := range classes.Items {
+		if modelKey(&classes.Items[i]) == key {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&classes.Items[i])})
+		}
+	}
+	return requests
+}
+
+// SetupWithManager sets up the controller with the Manager. Changes to the
+// spec of a class or to its model reconcile it.
+func (r *AgentClassReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&neuronetes.AgentClass{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Watches(&neuronetes.Model{}, handler.EnqueueRequestsFromMapFunc(r.classesForModel)).
+		Complete(r)
+}
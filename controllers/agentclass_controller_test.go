@@ -0,0 +1,64 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+)
+
+func reconcileClass(t *testing.T, r *AgentClassReconciler, class *neuronetes.AgentClass) *metav1.Condition {
+	t.Helper()
+	key := client.ObjectKeyFromObject(class)
+	_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: key})
+	require.NoError(t, err)
+	var got neuronetes.AgentClass
+	require.NoError(t, r.Get(context.Background(), key, &got))
+	return meta.FindStatusCondition(got.Status.Conditions, ConditionContextLengthValid)
+}
+
+func TestAgentClassReconcilerValidatesContextLength(t *testing.T) {
+	ctx := context.Background()
+	class := &neuronetes.AgentClass{
+		ObjectMeta: metav1.ObjectMeta{Name: "chat-agent", Namespace: "default"},
+		Spec: neuronetes.AgentClassSpec{
+			ModelRef:         neuronetes.ModelReference{Name: "llama-3-8b"},
+			MaxContextLength: 16384,
+		},
+	}
+	c := newFakeClient(t, class)
+	r := &AgentClassReconciler{Client: c, Scheme: c.Scheme()}
+
+	condition := reconcileClass(t, r, class)
+	require.NotNil(t, condition)
+	assert.Equal(t, metav1.ConditionUnknown, condition.Status)
+	assert.Equal(t, "ModelNotFound", condition.Reason)
+
+	// The context window is known once the weights are read
+	model := newTestModel()
+	require.NoError(t, c.Create(ctx, model))
+	condition = reconcileClass(t, r, class)
+	assert.Equal(t, "ContextWindowUnknown", condition.Reason)
+	assert.Equal(t, []ctrl.Request{{NamespacedName: client.ObjectKeyFromObject(class)}}, r.classesForModel(ctx, model))
+
+	model.Status.Metadata = &neuronetes.ModelMetadata{ContextLength: 8192}
+	require.NoError(t, c.Status().Update(ctx, model))
+	condition = reconcileClass(t, r, class)
+	assert.Equal(t, metav1.ConditionFalse, condition.Status)
+	assert.Equal(t, "ExceedsContextWindow", condition.Reason)
+	assert.Equal(t, "maxContextLength 16384 exceeds the context window of model llama-3-8b, 8192 tokens", condition.Message)
+
+	require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(class), class))
+	class.Spec.MaxContextLength = 8192
+	require.NoError(t, c.Update(ctx, class))
+	condition = reconcileClass(t, r, class)
+	assert.Equal(t, metav1.ConditionTrue, condition.Status)
+	assert.Equal(t, "WithinContextWindow", condition.Reason)
+}
@@ -2,27 +2,120 @@ package controllers
 
 import (
 	"context"
+	"fmt"
+	"sort"
+	"strconv"
 	"time"
 
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
 
 	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/autoscaler"
+	"github.com/bowenislandsong/neuronetes/pkg/checkpoint"
+	"github.com/bowenislandsong/neuronetes/pkg/gpu"
+	"github.com/bowenislandsong/neuronetes/pkg/health"
+	"github.com/bowenislandsong/neuronetes/pkg/metrics"
+	"github.com/bowenislandsong/neuronetes/pkg/sharding"
+	"github.com/bowenislandsong/neuronetes/pkg/warmup"
+)
+
+const (
+	// DefaultAgentImage is the agent runtime image used when neither the
+	// AgentPool nor the reconciler specify one
+	DefaultAgentImage = "ghcr.io/bowenislandsong/neuronetes-agent:latest"
+
+	// ConditionReady reports whether all replicas of a pool are ready
+	ConditionReady = "Ready"
+
+	// ConditionPaused is set while a pool is paused by the paused annotation
+	ConditionPaused = "Paused"
+
+	// ConditionServiceHealthy reports the health of the agent service a pool
+	// serves, across the pool, its AgentClass and the class's Model
+	ConditionServiceHealthy = "ServiceHealthy"
+
+	// agentComponent is the LabelComponent value of agent replicas
+	agentComponent = "agent"
+
+	// modelCacheVolume is the volume of replicas reading the weights of
+	// their model from its cache volume
+	modelCacheVolume = "model-cache"
+
+	// agentPort is the port the agent runtime serves on
+	agentPort = 8080
+
+	// migResourcePrefix prefixes the extended resource of each MIG profile,
+	// e.g. nvidia.com/mig-1g.5gb
+	migResourcePrefix = "nvidia.com/mig-"
+
+	// podDeletionCost is the annotation ReplicaSets use to pick which pods
+	// to remove first on scale-down
+	podDeletionCost = "controller.kubernetes.io/pod-deletion-cost"
 )
 
 // AgentPoolReconciler reconciles an AgentPool object
 type AgentPoolReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
+
+	// AgentImage is the default agent runtime image for pools that do not
+	// set spec.image
+	AgentImage string
+
+	// SchedulerName is the scheduler profile that places agent replicas,
+	// typically the NeuroNetes GPU topology scheduler. Empty uses the
+	// cluster's default scheduler.
+	SchedulerName string
+
+	// RuleLabels are added to the PrometheusRule of each pool, so that the
+	// ruleSelector of a Prometheus picks them up
+	RuleLabels map[string]string
+
+	// Snapshots snapshots the GPU memory of idle warm replicas of pools
+	// that set spec.snapshot and restores it when they are activated. Nil
+	// disables snapshots.
+	Snapshots checkpoint.Snapshotter
+
+	// Metrics records snapshot restore times when set
+	Metrics *metrics.AgentMetrics
+
+	// WarmUp runs the warm-up sequence of the replicas of pools that set
+	// spec.warmUp before they become ready. Nil disables warm-ups.
+	WarmUp warmup.Runner
+
+	// warmUps tracks the warm-ups running in the background
+	warmUps warmUpTracker
+
+	// clock returns the current time. Defaults to time.Now.
+	clock func() time.Time
 }
 
 // +kubebuilder:rbac:groups=neuronetes.io,resources=agentpools,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=neuronetes.io,resources=agentpools/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=neuronetes.io,resources=agentpools/finalizers,verbs=update
+// +kubebuilder:rbac:groups=neuronetes.io,resources=agentclasses,verbs=get;list;watch
+// +kubebuilder:rbac:groups=neuronetes.io,resources=models,verbs=get;list;watch
 // +kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=core,resources=services,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch;update;patch;delete
+// +kubebuilder:rbac:groups=core,resources=pods/exec,verbs=create
+// +kubebuilder:rbac:groups=core,resources=pods/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=scheduling.k8s.io,resources=priorityclasses,verbs=get;list;watch;create
 
 // Reconcile is part of the main kubernetes reconciliation loop
 func (r *AgentPoolReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
@@ -35,18 +128,42 @@ func (r *AgentPoolReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
 
+	// A paused pool is frozen during an incident: its replicas, pods and
+	// Deployment are left alone and only its status is refreshed
+	if agentPool.Annotations[neuronetes.AnnotationPaused] == "true" {
+		if err := r.observeReplicas(ctx, &agentPool); err != nil {
+			log.Error(err, "failed to observe replicas")
+			return ctrl.Result{}, err
+		}
+		if err := r.updateStatus(ctx, &agentPool); err != nil {
+			log.Error(err, "failed to update status")
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
+	}
+
 	// Reconcile agent pool replicas
 	if err := r.reconcileReplicas(ctx, &agentPool); err != nil {
 		log.Error(err, "failed to reconcile replicas")
 		return ctrl.Result{}, err
 	}
 
-	// Reconcile warm pool
-	if agentPool.Spec.PrewarmPercent > 0 {
-		if err := r.reconcileWarmPool(ctx, &agentPool); err != nil {
-			log.Error(err, "failed to reconcile warm pool")
-			return ctrl.Result{}, err
-		}
+	// Reconcile warm pool. This also runs without prewarming so that
+	// replicas left warm by an earlier configuration are activated.
+	drained, err := r.reconcileWarmPool(ctx, &agentPool)
+	if err != nil {
+		log.Error(err, "failed to reconcile warm pool")
+		return ctrl.Result{}, err
+	}
+
+	// Size the Deployment, then remove replicas that finished draining
+	if err := r.reconcileDeployment(ctx, &agentPool); err != nil {
+		log.Error(err, "failed to reconcile deployment")
+		return ctrl.Result{}, err
+	}
+	if err := r.removeDrained(ctx, drained); err != nil {
+		log.Error(err, "failed to remove drained replicas")
+		return ctrl.Result{}, err
 	}
 
 	// Update status
@@ -55,6 +172,9 @@ func (r *AgentPoolReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 		return ctrl.Result{}, err
 	}
 
+	if r.warmUps.running(req.NamespacedName) {
+		return ctrl.Result{RequeueAfter: warmUpPollInterval}, nil
+	}
 	return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
 }
 
@@ -67,69 +187,702 @@ func (r *AgentPoolReconciler) reconcileReplicas(ctx context.Context, pool *neuro
 	// Calculate desired replicas based on autoscaling metrics
 	desiredReplicas := r.calculateDesiredReplicas(ctx, pool)
 
-	// Ensure within min/max bounds
-	if desiredReplicas < pool.Spec.MinReplicas {
-		desiredReplicas = pool.Spec.MinReplicas
-	}
-	if desiredReplicas > pool.Spec.MaxReplicas {
-		desiredReplicas = pool.Spec.MaxReplicas
+	// Ensure within min/max bounds, as overridden by active schedules
+	bounds, err := autoscaler.EffectiveBounds(pool, r.now())
+	if err != nil {
+		log.Error(err, "ignoring invalid scaling schedules")
 	}
+	desiredReplicas = bounds.Clamp(desiredReplicas)
 
 	if currentReplicas != desiredReplicas {
 		log.Info("Scaling agent pool",
 			"current", currentReplicas,
 			"desired", desiredReplicas)
-
-		// TODO: Implement actual scaling
-		// - Create/delete pods
-		// - Wait for readiness
-		// - Update routing
+		now := metav1.Now()
+		pool.Status.LastScaleTime = &now
 	}
+	pool.Status.Replicas = desiredReplicas
 
 	return nil
 }
 
-func (r *AgentPoolReconciler) reconcileWarmPool(ctx context.Context, pool *neuronetes.AgentPool) error {
+// reconcileDeployment sizes the Deployment backing pool. It runs serving,
+// warm and draining replicas alike; reconcileWarmPool decides which pods
+// serve. Pools with a queue run only the replicas Kueue admitted.
+func (r *AgentPoolReconciler) reconcileDeployment(ctx context.Context, pool *neuronetes.AgentPool) error {
 	log := log.FromContext(ctx)
 
-	// Calculate warm pool size
-	warmPoolSize := int32(float64(pool.Spec.MaxReplicas) * float64(pool.Spec.PrewarmPercent) / 100.0)
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      pool.Name,
+			Namespace: pool.Namespace,
+		},
+	}
+	class, model, err := r.workload(ctx, pool)
+	if err != nil {
+		return err
+	}
+	revision, err := revision(class, model)
+	if err != nil {
+		return err
+	}
+	if err := r.ensurePriorityClass(ctx, pool); err != nil {
+		return err
+	}
+	if err := r.reconcileGPUClaims(ctx, pool); err != nil {
+		return err
+	}
+	if err := r.reconcilePrometheusRule(ctx, pool, class); err != nil {
+		return err
+	}
+	total := pool.Status.Replicas + warmPoolSize(pool) + pool.Status.DrainingReplicas
+	total, err = r.reconcileQueueWorkloads(ctx, pool, total, gangSize(model))
+	if err != nil {
+		return err
+	}
+	result, err := controllerutil.CreateOrUpdate(ctx, r.Client, deployment, func() error {
+		r.buildDeployment(pool, deployment, total, revision, model)
+		return ctrl.SetControllerReference(pool, deployment, r.Scheme)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to reconcile deployment %s: %w", deployment.Name, err)
+	}
+	if result != controllerutil.OperationResultNone {
+		log.Info("Reconciled agent deployment", "deployment", deployment.Name, "operation", result)
+	}
+	return nil
+}
+
+// buildDeployment sets the desired state of the Deployment backing pool,
+// leaving fields defaulted by the API server untouched
+func (r *AgentPoolReconciler) buildDeployment(pool *neuronetes.AgentPool, deployment *appsv1.Deployment, replicas int32, revision string, model *neuronetes.Model) {
+	podLabels := agentLabels(pool)
+	gang := gangSize(model)
+	shards, sharded := shardingSpec(model)
+	vram, hasVRAM := vramFootprint(model)
+
+	if deployment.Labels == nil {
+		deployment.Labels = map[string]string{}
+	}
+	for k, v := range podLabels {
+		deployment.Labels[k] = v
+	}
+
+	deployment.Spec.Replicas = &replicas
+	deployment.Spec.Strategy = rolloutStrategy(pool, gang)
+	// The selector is immutable, so only set it on creation
+	if deployment.Spec.Selector == nil {
+		deployment.Spec.Selector = &metav1.LabelSelector{
+			MatchLabels: map[string]string{
+				neuronetes.LabelPool:      pool.Name,
+				neuronetes.LabelComponent: agentComponent,
+			},
+		}
+	}
+
+	template := &deployment.Spec.Template
+	if template.Labels == nil {
+		template.Labels = map[string]string{}
+	}
+	for k, v := range podLabels {
+		template.Labels[k] = v
+	}
+	if revision != "" || gang > 1 || sharded || hasVRAM || usesDRA(pool) {
+		if template.Annotations == nil {
+			template.Annotations = map[string]string{}
+		}
+	}
+	if revision != "" {
+		template.Annotations[neuronetes.AnnotationRevision] = revision
+	}
+	if gang > 1 {
+		template.Labels[neuronetes.LabelGang] = pool.Name
+		template.Annotations[neuronetes.AnnotationGangSize] = strconv.Itoa(int(gang))
+	} else {
+		delete(template.Labels, neuronetes.LabelGang)
+		delete(template.Annotations, neuronetes.AnnotationGangSize)
+	}
+	for _, key := range []string{neuronetes.AnnotationShardStrategy, neuronetes.AnnotationShardCount, neuronetes.AnnotationModelLayers} {
+		delete(template.Annotations, key)
+	}
+	if sharded {
+		// The scheduler plans the shard of each replica by these
+		for k, v := range shards.Annotations() {
+			template.Annotations[k] = v
+		}
+	}
+	if hasVRAM {
+		template.Annotations[neuronetes.AnnotationVRAM] = vram.String()
+	} else {
+		delete(template.Annotations, neuronetes.AnnotationVRAM)
+	}
+	template.Spec.ResourceClaims = nil
+	if usesDRA(pool) {
+		name, count := gpuRequest(pool)
+		template.Annotations[neuronetes.AnnotationGPUClaims] = fmt.Sprintf("%s=%d", name, count)
+		template.Spec.ResourceClaims = podClaims(pool)
+	} else {
+		delete(template.Annotations, neuronetes.AnnotationGPUClaims)
+	}
 
-	log.Info("Managing warm pool",
-		"target", warmPoolSize,
-		"current", pool.Status.PrewarmedReplicas)
+	template.Spec.NodeSelector = nodeSelector(pool)
+	template.Spec.TopologySpreadConstraints = topologySpreadConstraints(pool)
+	template.Spec.PriorityClassName = priorityClassName(pool)
+	template.Spec.ReadinessGates = r.readinessGates(pool)
+	if r.SchedulerName != "" {
+		template.Spec.SchedulerName = r.SchedulerName
+	}
 
-	// TODO: Implement warm pool management
-	// - Pre-load models
-	// - Keep pods warm but not serving
-	// - Fast activate on demand
+	container := corev1.Container{
+		Name:  agentComponent,
+		Image: r.image(pool),
+		Ports: []corev1.ContainerPort{{
+			Name:          "http",
+			ContainerPort: agentPort,
+			Protocol:      corev1.ProtocolTCP,
+		}},
+		Env: []corev1.EnvVar{
+			{Name: "NEURONETES_POOL", Value: pool.Name},
+			{Name: "NEURONETES_AGENT_CLASS", Value: pool.Spec.AgentClassRef.Name},
+			{
+				Name: "POD_NAME",
+				ValueFrom: &corev1.EnvVarSource{
+					FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.name"},
+				},
+			},
+		},
+	}
+	if sharded {
+		container.Env = append(container.Env, sharding.Env(shards)...)
+	}
+	cacheVersion := modelCacheVersion(model)
+	if cacheVersion != "" {
+		container.Env = append(container.Env, corev1.EnvVar{Name: "NEURONETES_MODEL_PATH", Value: ModelCacheMountPath + "/" + cacheVersion})
+	}
+	template.Spec.Volumes = withModelCacheVolume(template.Spec.Volumes, model)
+	if usesDRA(pool) {
+		for _, claim := range template.Spec.ResourceClaims {
+			container.Resources.Claims = append(container.Resources.Claims, corev1.ResourceClaim{Name: claim.Name})
+		}
+	} else if name, count := gpuRequest(pool); count > 0 {
+		quantity := *resource.NewQuantity(count, resource.DecimalSI)
+		container.Resources.Requests = corev1.ResourceList{name: quantity}
+		container.Resources.Limits = corev1.ResourceList{name: quantity}
+	}
 
+	// Merge into an existing container to keep API server defaults stable
+	for i := range template.Spec.Containers {
+		if template.Spec.Containers[i].Name == agentComponent {
+			existing := &template.Spec.Containers[i]
+			existing.Image = container.Image
+			existing.Ports = container.Ports
+			existing.Env = container.Env
+			existing.Resources = container.Resources
+			existing.VolumeMounts = withModelCacheMount(existing.VolumeMounts, cacheVersion != "")
+			return
+		}
+	}
+	container.VolumeMounts = withModelCacheMount(nil, cacheVersion != "")
+	template.Spec.Containers = append(template.Spec.Containers, container)
+}
+
+// modelCacheVersion returns the version of the weights of model replicas
+// read from its cache volume, or empty if it is not cached on a volume
+func modelCacheVersion(model *neuronetes.Model) string {
+	if model == nil || model.Status.CacheVolume == nil {
+		return ""
+	}
+	return model.Status.CacheVolume.Version
+}
+
+// withModelCacheVolume returns volumes with the cache volume of model if
+// its weights are populated, and without it otherwise
+func withModelCacheVolume(volumes []corev1.Volume, model *neuronetes.Model) []corev1.Volume {
+	var result []corev1.Volume
+	for _, volume := range volumes {
+		if volume.Name != modelCacheVolume {
+			result = append(result, volume)
+		}
+	}
+	if modelCacheVersion(model) == "" {
+		return result
+	}
+	return append(result, corev1.Volume{
+		Name: modelCacheVolume,
+		VolumeSource: corev1.VolumeSource{
+			PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+				ClaimName: model.Status.CacheVolume.ClaimName,
+				ReadOnly:  true,
+			},
+		},
+	})
+}
+
+// withModelCacheMount returns mounts with the read-only mount of the model
+// cache volume if cached, and without it otherwise
+func withModelCacheMount(mounts []corev1.VolumeMount, cached bool) []corev1.VolumeMount {
+	var result []corev1.VolumeMount
+	for _, mount := range mounts {
+		if mount.Name != modelCacheVolume {
+			result = append(result, mount)
+		}
+	}
+	if !cached {
+		return result
+	}
+	return append(result, corev1.VolumeMount{Name: modelCacheVolume, MountPath: ModelCacheMountPath, ReadOnly: true})
+}
+
+// gpuRequest returns the GPUs a replica of pool requests: slices of its MIG
+// profile, at least one, or whole GPUs of its vendor
+func gpuRequest(pool *neuronetes.AgentPool) (corev1.ResourceName, int64) {
+	var count int64
+	if gpu := pool.Spec.GPURequirements; gpu != nil {
+		count = int64(gpu.Count)
+	}
+	if pool.Spec.MIGProfile != "" {
+		if count < 1 {
+			count = 1
+		}
+		return corev1.ResourceName(migResourcePrefix + pool.Spec.MIGProfile), count
+	}
+	if req := pool.Spec.GPURequirements; req != nil && req.Vendor == neuronetes.GPUVendorAMD {
+		return gpu.AMDResource, count
+	}
+	return gpu.NVIDIAResource, count
+}
+
+// sharingStrategyLabels maps GPU sharing strategies to the values of
+// gpu.SharingStrategyLabel
+var sharingStrategyLabels = map[string]string{
+	neuronetes.GPUSharingTimeSlicing: "time-slicing",
+	neuronetes.GPUSharingMPS:         "mps",
+}
+
+// nodeSelector returns the node selector of the replicas of pool: the
+// pool's, and for pools sharing GPUs the sharing strategy GPU Feature
+// Discovery labels nodes with
+func nodeSelector(pool *neuronetes.AgentPool) map[string]string {
+	var selector map[string]string
+	if pool.Spec.Scheduling != nil {
+		selector = pool.Spec.Scheduling.NodeSelector
+	}
+	req := pool.Spec.GPURequirements
+	if req == nil || req.Sharing == nil {
+		return selector
+	}
+	shared := make(map[string]string, len(selector)+1)
+	for k, v := range selector {
+		shared[k] = v
+	}
+	shared[gpu.SharingStrategyLabel] = sharingStrategyLabels[req.Sharing.Strategy]
+	return shared
+}
+
+// topologySpreadConstraints returns the constraints spreading the replicas
+// of pool, serving and warm alike, across the topology domains it configures
+func topologySpreadConstraints(pool *neuronetes.AgentPool) []corev1.TopologySpreadConstraint {
+	if pool.Spec.Scheduling == nil || len(pool.Spec.Scheduling.TopologySpread) == 0 {
+		return nil
+	}
+	constraints := make([]corev1.TopologySpreadConstraint, 0, len(pool.Spec.Scheduling.TopologySpread))
+	for _, spread := range pool.Spec.Scheduling.TopologySpread {
+		constraint := corev1.TopologySpreadConstraint{
+			MaxSkew:           spread.MaxSkew,
+			TopologyKey:       spread.TopologyKey,
+			WhenUnsatisfiable: corev1.UnsatisfiableConstraintAction(spread.WhenUnsatisfiable),
+			LabelSelector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{
+					neuronetes.LabelPool:      pool.Name,
+					neuronetes.LabelComponent: agentComponent,
+				},
+			},
+		}
+		if constraint.MaxSkew < 1 {
+			constraint.MaxSkew = 1
+		}
+		if constraint.WhenUnsatisfiable == "" {
+			constraint.WhenUnsatisfiable = corev1.DoNotSchedule
+		}
+		constraints = append(constraints, constraint)
+	}
+	return constraints
+}
+
+func (r *AgentPoolReconciler) image(pool *neuronetes.AgentPool) string {
+	if pool.Spec.Image != "" {
+		return pool.Spec.Image
+	}
+	if r.AgentImage != "" {
+		return r.AgentImage
+	}
+	return DefaultAgentImage
+}
+
+// agentLabels returns the labels applied to a pool's replicas
+func agentLabels(pool *neuronetes.AgentPool) map[string]string {
+	return map[string]string{
+		neuronetes.LabelPool:       pool.Name,
+		neuronetes.LabelAgentClass: pool.Spec.AgentClassRef.Name,
+		neuronetes.LabelComponent:  agentComponent,
+	}
+}
+
+// reconcileWarmPool splits the pool's pods into serving, warm and draining
+// replicas, and returns those that finished draining and can be removed
+func (r *AgentPoolReconciler) reconcileWarmPool(ctx context.Context, pool *neuronetes.AgentPool) ([]*corev1.Pod, error) {
+	log := log.FromContext(ctx)
+
+	pods, err := r.agentPods(ctx, pool)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := make([]*corev1.Pod, 0, len(pods.Items))
+	var serving, draining []*corev1.Pod
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		switch {
+		case pod.DeletionTimestamp != nil:
+		case pod.Labels[neuronetes.LabelRole] == neuronetes.RoleDraining:
+			draining = append(draining, pod)
+		default:
+			if pod.Labels[neuronetes.LabelRole] == neuronetes.RoleServing {
+				serving = append(serving, pod)
+			}
+			candidates = append(candidates, pod)
+		}
+	}
+
+	// Drain serving replicas beyond the serving count rather than demoting
+	// them, so their sessions can finish
+	if surplus := len(serving) - int(pool.Status.Replicas); surplus > 0 {
+		now := r.now()
+		for _, pod := range drainVictims(serving, surplus) {
+			if err := r.startDrain(ctx, pod, now); err != nil {
+				return nil, err
+			}
+			draining = append(draining, pod)
+			candidates = removePod(candidates, pod)
+		}
+		log.Info("Draining replicas", "count", surplus)
+	}
+
+	drained, stillDraining := splitDrained(pool, draining, r.now())
+	if err := r.markDrained(ctx, drained); err != nil {
+		return nil, err
+	}
+	pool.Status.DrainingReplicas = int32(len(stillDraining))
+
+	if err := r.reconcileWarmUps(ctx, pool, candidates); err != nil {
+		return nil, err
+	}
+	sortForServing(candidates)
+	candidates, err = r.restoreReplicas(ctx, pool, candidates)
+	if err != nil {
+		return nil, err
+	}
+
+	var ready, warm, activated int32
+	var idle []*corev1.Pod
+	for i, pod := range candidates {
+		role := neuronetes.RoleWarm
+		// A snapshotted replica moved up by a failed restore is restored
+		// before it serves, on the next reconcile
+		if int32(i) < pool.Status.Replicas && !isSnapshotted(pod) {
+			role = neuronetes.RoleServing
+		}
+
+		if pod.Labels[neuronetes.LabelRole] == neuronetes.RoleWarm && role == neuronetes.RoleServing {
+			activated++
+		}
+		if err := r.setRole(ctx, pod, role); err != nil {
+			return nil, err
+		}
+
+		if !isPodReady(pod) {
+			continue
+		}
+		if role == neuronetes.RoleServing {
+			ready++
+		} else {
+			warm++
+			idle = append(idle, pod)
+		}
+	}
+
+	snapshotted, err := r.snapshotIdle(ctx, pool, idle)
+	if err != nil {
+		return nil, err
+	}
+
+	if activated > 0 {
+		log.Info("Activated warm replicas", "count", activated)
+	}
+	if target := warmPoolSize(pool); target > 0 || warm > 0 {
+		log.Info("Managing warm pool", "target", target, "current", warm)
+	}
+
+	pool.Status.ReadyReplicas = ready
+	pool.Status.PrewarmedReplicas = warm
+	pool.Status.SnapshottedReplicas = snapshotted
+	pool.Status.Selector = servingSelector(pool)
+	return drained, nil
+}
+
+// observeReplicas reports the replicas of pool in its status by the roles
+// they already have, without changing any of them
+func (r *AgentPoolReconciler) observeReplicas(ctx context.Context, pool *neuronetes.AgentPool) error {
+	pods, err := r.agentPods(ctx, pool)
+	if err != nil {
+		return err
+	}
+
+	var ready, warm, draining, snapshotted int32
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.DeletionTimestamp != nil {
+			continue
+		}
+		switch pod.Labels[neuronetes.LabelRole] {
+		case neuronetes.RoleDraining:
+			draining++
+		case neuronetes.RoleServing:
+			if isPodReady(pod) {
+				ready++
+			}
+		case neuronetes.RoleWarm:
+			if isPodReady(pod) {
+				warm++
+			}
+			if isSnapshotted(pod) {
+				snapshotted++
+			}
+		}
+	}
+
+	pool.Status.ReadyReplicas = ready
+	pool.Status.PrewarmedReplicas = warm
+	pool.Status.DrainingReplicas = draining
+	pool.Status.SnapshottedReplicas = snapshotted
+	pool.Status.Selector = servingSelector(pool)
 	return nil
 }
 
-func (r *AgentPoolReconciler) calculateDesiredReplicas(ctx context.Context, pool *neuronetes.AgentPool) int32 {
-	// TODO: Implement autoscaling logic
-	// - Fetch metrics from Prometheus
-	// - Evaluate against targets
-	// - Apply scaling policies
-	// - Return desired replica count
+// agentPods lists the agent replicas of pool
+func (r *AgentPoolReconciler) agentPods(ctx context.Context, pool *neuronetes.AgentPool) (*corev1.PodList, error) {
+	var pods corev1.PodList
+	if err := r.List(ctx, &pods,
+		client.InNamespace(pool.Namespace),
+		client.MatchingLabels{neuronetes.LabelPool: pool.Name, neuronetes.LabelComponent: agentComponent},
+	); err != nil {
+		return nil, fmt.Errorf("failed to list pods: %w", err)
+	}
+	return &pods, nil
+}
+
+// servingSelector returns the selector of the serving replicas of pool
+func servingSelector(pool *neuronetes.AgentPool) string {
+	return labels.SelectorFromSet(labels.Set{
+		neuronetes.LabelPool:      pool.Name,
+		neuronetes.LabelComponent: agentComponent,
+		neuronetes.LabelRole:      neuronetes.RoleServing,
+	}).String()
+}
+
+// setRole labels pod with role and sets its deletion cost so that the
+// Deployment removes warm replicas before serving and draining ones when it
+// shrinks. Warm replicas record since when they are warm, which decides
+// when they are snapshotted.
+func (r *AgentPoolReconciler) setRole(ctx context.Context, pod *corev1.Pod, role string) error {
+	cost := "0"
+	if role == neuronetes.RoleServing {
+		cost = "1"
+	}
+	_, warmSince := pod.Annotations[neuronetes.AnnotationWarmSince]
+	if pod.Labels[neuronetes.LabelRole] == role && pod.Annotations[podDeletionCost] == cost && warmSince == (role == neuronetes.RoleWarm) {
+		return nil
+	}
+
+	patch := client.MergeFrom(pod.DeepCopy())
+	if pod.Labels == nil {
+		pod.Labels = map[string]string{}
+	}
+	if pod.Annotations == nil {
+		pod.Annotations = map[string]string{}
+	}
+	pod.Labels[neuronetes.LabelRole] = role
+	pod.Annotations[podDeletionCost] = cost
+	if role != neuronetes.RoleWarm {
+		delete(pod.Annotations, neuronetes.AnnotationWarmSince)
+	} else if !warmSince {
+		pod.Annotations[neuronetes.AnnotationWarmSince] = r.now().UTC().Format(time.RFC3339)
+	}
+	if err := r.Patch(ctx, pod, patch); err != nil {
+		return fmt.Errorf("failed to set role of pod %s: %w", pod.Name, err)
+	}
+	return nil
+}
+
+// sortForServing orders pods by preference for serving: ready pods first,
+// then pods already serving, then pods not snapshotted, then the oldest
+func sortForServing(pods []*corev1.Pod) {
+	sort.SliceStable(pods, func(i, j int) bool {
+		ri, rj := isPodReady(pods[i]), isPodReady(pods[j])
+		if ri != rj {
+			return ri
+		}
+		si := pods[i].Labels[neuronetes.LabelRole] == neuronetes.RoleServing
+		sj := pods[j].Labels[neuronetes.LabelRole] == neuronetes.RoleServing
+		if si != sj {
+			return si
+		}
+		if ci, cj := isSnapshotted(pods[i]), isSnapshotted(pods[j]); ci != cj {
+			return cj
+		}
+		ti, tj := pods[i].CreationTimestamp, pods[j].CreationTimestamp
+		if !ti.Equal(&tj) {
+			return ti.Before(&tj)
+		}
+		return pods[i].Name < pods[j].Name
+	})
+}
+
+// warmPoolSize returns the number of warm replicas pool should keep. Warm
+// replicas run the agent with its model loaded but are excluded from
+// routing; activating one relabels it, which is much faster than starting a
+// new pod, and the Deployment then replaces it to refill the warm pool.
+func warmPoolSize(pool *neuronetes.AgentPool) int32 {
+	return int32(float64(pool.Spec.MaxReplicas) * float64(pool.Spec.PrewarmPercent) / 100.0)
+}
+
+func isPodReady(pod *corev1.Pod) bool {
+	if pod.Status.Phase != corev1.PodRunning {
+		return false
+	}
+	for _, c := range pod.Status.Conditions {
+		if c.Type == corev1.PodReady {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
 
-	// For now, return current replicas
+// calculateDesiredReplicas returns the requested replica count. The
+// autoscaler evaluates metrics out of process and records its decision in
+// spec.replicas through the scale subresource; without one the pool keeps
+// its current size.
+func (r *AgentPoolReconciler) calculateDesiredReplicas(ctx context.Context, pool *neuronetes.AgentPool) int32 {
+	if pool.Spec.Replicas != nil {
+		return *pool.Spec.Replicas
+	}
 	return pool.Status.Replicas
 }
 
 func (r *AgentPoolReconciler) updateStatus(ctx context.Context, pool *neuronetes.AgentPool) error {
-	// TODO: Update status with actual values
-	// - Query pod status
-	// - Calculate metrics
-	// - Update conditions
+	condition := metav1.Condition{
+		Type:               ConditionReady,
+		Status:             metav1.ConditionTrue,
+		Reason:             "ReplicasReady",
+		Message:            fmt.Sprintf("%d/%d replicas ready", pool.Status.ReadyReplicas, pool.Status.Replicas),
+		ObservedGeneration: pool.Generation,
+	}
+	if pool.Status.ReadyReplicas < pool.Status.Replicas {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "ReplicasNotReady"
+	}
+	meta.SetStatusCondition(&pool.Status.Conditions, condition)
+
+	serviceHealth, err := r.serviceHealth(ctx, pool)
+	if err != nil {
+		return err
+	}
+	meta.SetStatusCondition(&pool.Status.Conditions, serviceHealthCondition(pool, serviceHealth))
+
+	if pool.Annotations[neuronetes.AnnotationPaused] == "true" {
+		meta.SetStatusCondition(&pool.Status.Conditions, metav1.Condition{
+			Type:               ConditionPaused,
+			Status:             metav1.ConditionTrue,
+			Reason:             "PausedByAnnotation",
+			Message:            fmt.Sprintf("%s is set; replicas are not changed", neuronetes.AnnotationPaused),
+			ObservedGeneration: pool.Generation,
+		})
+	} else {
+		meta.RemoveStatusCondition(&pool.Status.Conditions, ConditionPaused)
+	}
 
 	return r.Status().Update(ctx, pool)
 }
 
-// SetupWithManager sets up the controller with the Manager
+// serviceHealth aggregates the health of pool, its AgentClass and the
+// class's Model. Missing references are reported as down.
+func (r *AgentPoolReconciler) serviceHealth(ctx context.Context, pool *neuronetes.AgentPool) (health.ServiceHealth, error) {
+	class := &neuronetes.AgentClass{}
+	if err := r.Get(ctx, agentClassKey(pool), class); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return health.ServiceHealth{}, fmt.Errorf("failed to get agent class: %w", err)
+		}
+		return health.AggregateStatus(pool, nil, nil), nil
+	}
+	model := &neuronetes.Model{}
+	if err := r.Get(ctx, modelKey(class), model); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return health.ServiceHealth{}, fmt.Errorf("failed to get model: %w", err)
+		}
+		model = nil
+	}
+	return health.AggregateStatus(pool, class, model), nil
+}
+
+// serviceHealthCondition reports serviceHealth as the ServiceHealthy
+// condition, with the first failing component as the reason
+func serviceHealthCondition(pool *neuronetes.AgentPool, serviceHealth health.ServiceHealth) metav1.Condition {
+	condition := metav1.Condition{
+		Type:               ConditionServiceHealthy,
+		Status:             metav1.ConditionTrue,
+		Reason:             string(health.StateReady),
+		Message:            "model, agent class and pool are ready",
+		ObservedGeneration: pool.Generation,
+	}
+	if cause := serviceHealth.Cause; cause != nil {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = cause.Reason
+		condition.Message = fmt.Sprintf("%s: %s %s", serviceHealth.State, cause.Kind, cause.Name)
+		if cause.Message != "" {
+			condition.Message += ": " + cause.Message
+		}
+	}
+	return condition
+}
+
+// SetupWithManager sets up the controller with the Manager. Spec changes to
+// an AgentClass or Model roll the pools running it, as do new versions of
+// the weights on the cache volume of a Model.
 func (r *AgentPoolReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&neuronetes.AgentPool{}).
+		Owns(&appsv1.Deployment{}).
+		Watches(&neuronetes.AgentClass{},
+			handler.EnqueueRequestsFromMapFunc(r.poolsForAgentClass),
+			builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Watches(&neuronetes.Model{},
+			handler.EnqueueRequestsFromMapFunc(r.poolsForModel),
+			builder.WithPredicates(predicate.Or(predicate.GenerationChangedPredicate{}, cacheVolumeChanged))).
 		Complete(r)
 }
+
+// cacheVolumeChanged passes updates of Models changing the version of the
+// weights replicas read from their cache volume
+var cacheVolumeChanged = predicate.Funcs{
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		old, ok := e.ObjectOld.(*neuronetes.Model)
+		if !ok {
+			return false
+		}
+		model, ok := e.ObjectNew.(*neuronetes.Model)
+		if !ok {
+			return false
+		}
+		return modelCacheVersion(old) != modelCacheVersion(model)
+	},
+}
@@ -0,0 +1,1064 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	resourcev1alpha2 "k8s.io/api/resource/v1alpha2"
+	schedulingv1 "k8s.io/api/scheduling/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/metrics"
+)
+
+func newTestAgentPool(min, max int32) *neuronetes.AgentPool {
+	return &neuronetes.AgentPool{
+		ObjectMeta: metav1.ObjectMeta{Name: "chat-pool", Namespace: "default", UID: "pool-uid"},
+		Spec: neuronetes.AgentPoolSpec{
+			AgentClassRef:   neuronetes.AgentClassReference{Name: "chat-agent"},
+			MinReplicas:     min,
+			MaxReplicas:     max,
+			GPURequirements: &neuronetes.GPURequirements{Count: 2},
+		},
+	}
+}
+
+// agentPod returns a pod of the test pool created age minutes after a fixed
+// point in time
+func agentPod(name string, ready bool, age int) *corev1.Pod {
+	status := corev1.ConditionFalse
+	if ready {
+		status = corev1.ConditionTrue
+	}
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+			Labels: map[string]string{
+				neuronetes.LabelPool:      "chat-pool",
+				neuronetes.LabelComponent: "agent",
+			},
+			CreationTimestamp: metav1.NewTime(time.Unix(1700000000, 0).Add(time.Duration(age) * time.Minute)),
+		},
+		Status: corev1.PodStatus{
+			Phase:      corev1.PodRunning,
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: status}},
+		},
+	}
+}
+
+func podRoles(t *testing.T, r *AgentPoolReconciler) map[string]string {
+	t.Helper()
+	var pods corev1.PodList
+	require.NoError(t, r.List(context.Background(), &pods))
+	roles := make(map[string]string, len(pods.Items))
+	for _, pod := range pods.Items {
+		roles[pod.Name] = pod.Labels[neuronetes.LabelRole]
+	}
+	return roles
+}
+
+func newTestPoolReconciler(t *testing.T, objs ...client.Object) *AgentPoolReconciler {
+	c := newFakeClient(t, objs...)
+	return &AgentPoolReconciler{Client: c, Scheme: c.Scheme()}
+}
+
+func reconcilePool(t *testing.T, r *AgentPoolReconciler, key types.NamespacedName) (*neuronetes.AgentPool, *appsv1.Deployment) {
+	t.Helper()
+	_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: key})
+	require.NoError(t, err)
+
+	var pool neuronetes.AgentPool
+	require.NoError(t, r.Get(context.Background(), key, &pool))
+	var deployment appsv1.Deployment
+	require.NoError(t, r.Get(context.Background(), key, &deployment))
+	return &pool, &deployment
+}
+
+func TestAgentPoolReconcilerCreatesDeployment(t *testing.T) {
+	pool := newTestAgentPool(2, 5)
+	key := client.ObjectKeyFromObject(pool)
+	r := newTestPoolReconciler(t, pool)
+
+	got, deployment := reconcilePool(t, r, key)
+
+	require.NotNil(t, deployment.Spec.Replicas)
+	assert.Equal(t, int32(2), *deployment.Spec.Replicas)
+	assert.Equal(t, "chat-pool", deployment.Spec.Selector.MatchLabels[neuronetes.LabelPool])
+	assert.Equal(t, "chat-agent", deployment.Spec.Template.Labels[neuronetes.LabelAgentClass])
+	require.Len(t, deployment.OwnerReferences, 1)
+	assert.Equal(t, "chat-pool", deployment.OwnerReferences[0].Name)
+
+	require.Len(t, deployment.Spec.Template.Spec.Containers, 1)
+	container := deployment.Spec.Template.Spec.Containers[0]
+	assert.Equal(t, DefaultAgentImage, container.Image)
+	gpus := container.Resources.Limits[corev1.ResourceName("nvidia.com/gpu")]
+	assert.Equal(t, int64(2), gpus.Value())
+
+	assert.Equal(t, int32(2), got.Status.Replicas)
+	assert.Equal(t, int32(0), got.Status.ReadyReplicas)
+	assert.NotNil(t, got.Status.LastScaleTime)
+	cond := meta.FindStatusCondition(got.Status.Conditions, ConditionReady)
+	require.NotNil(t, cond)
+	assert.Equal(t, metav1.ConditionFalse, cond.Status)
+}
+
+func TestAgentPoolReconcilerRequestsMIGSlices(t *testing.T) {
+	pool := newTestAgentPool(1, 3)
+	pool.Spec.MIGProfile = "1g.5gb"
+	pool.Spec.GPURequirements = nil
+	key := client.ObjectKeyFromObject(pool)
+
+	r := newTestPoolReconciler(t, pool)
+	_, deployment := reconcilePool(t, r, key)
+	resources := deployment.Spec.Template.Spec.Containers[0].Resources
+	slices := resources.Requests[corev1.ResourceName("nvidia.com/mig-1g.5gb")]
+	assert.Equal(t, int64(1), slices.Value())
+	assert.NotContains(t, resources.Limits, corev1.ResourceName("nvidia.com/gpu"))
+}
+
+func TestAgentPoolReconcilerRequestsAMDGPUs(t *testing.T) {
+	pool := newTestAgentPool(1, 3)
+	pool.Spec.GPURequirements = &neuronetes.GPURequirements{Count: 4, Type: "MI300X", Vendor: neuronetes.GPUVendorAMD}
+	key := client.ObjectKeyFromObject(pool)
+
+	r := newTestPoolReconciler(t, pool)
+	_, deployment := reconcilePool(t, r, key)
+	resources := deployment.Spec.Template.Spec.Containers[0].Resources
+	gpus := resources.Limits[corev1.ResourceName("amd.com/gpu")]
+	assert.Equal(t, int64(4), gpus.Value())
+	assert.NotContains(t, resources.Limits, corev1.ResourceName("nvidia.com/gpu"))
+}
+
+func TestAgentPoolReconcilerSelectsNodesSharingGPUs(t *testing.T) {
+	pool := newTestAgentPool(1, 3)
+	pool.Spec.GPURequirements = &neuronetes.GPURequirements{Count: 1, Sharing: &neuronetes.GPUSharing{Strategy: neuronetes.GPUSharingMPS}}
+	pool.Spec.Scheduling = &neuronetes.SchedulingConfig{NodeSelector: map[string]string{"topology.kubernetes.io/zone": "us-east-1a"}}
+	key := client.ObjectKeyFromObject(pool)
+
+	r := newTestPoolReconciler(t, pool)
+	_, deployment := reconcilePool(t, r, key)
+	assert.Equal(t, map[string]string{
+		"topology.kubernetes.io/zone":     "us-east-1a",
+		"nvidia.com/gpu.sharing-strategy": "mps",
+	}, deployment.Spec.Template.Spec.NodeSelector)
+	gpus := deployment.Spec.Template.Spec.Containers[0].Resources.Limits[corev1.ResourceName("nvidia.com/gpu")]
+	assert.Equal(t, int64(1), gpus.Value())
+}
+
+func TestAgentPoolReconcilerMapsPriorityToPriorityClass(t *testing.T) {
+	ctx := context.Background()
+	high, low := int32(1000), int32(-10)
+	critical := newTestAgentPool(1, 3)
+	critical.Spec.Scheduling = &neuronetes.SchedulingConfig{Priority: &high}
+	batch := newTestAgentPool(1, 3)
+	batch.Name = "batch-pool"
+	batch.UID = "batch-uid"
+	batch.Spec.Scheduling = &neuronetes.SchedulingConfig{Priority: &low}
+
+	r := newTestPoolReconciler(t, critical, batch)
+	critical, deployment := reconcilePool(t, r, client.ObjectKeyFromObject(critical))
+	assert.Equal(t, "neuronetes-priority-1000", deployment.Spec.Template.Spec.PriorityClassName)
+	_, deployment = reconcilePool(t, r, client.ObjectKeyFromObject(batch))
+	assert.Equal(t, "neuronetes-priority-minus-10", deployment.Spec.Template.Spec.PriorityClassName)
+
+	var priorityClass schedulingv1.PriorityClass
+	require.NoError(t, r.Get(ctx, types.NamespacedName{Name: "neuronetes-priority-1000"}, &priorityClass))
+	assert.Equal(t, int32(1000), priorityClass.Value)
+	assert.Equal(t, corev1.PreemptLowerPriority, *priorityClass.PreemptionPolicy)
+	assert.Empty(t, priorityClass.OwnerReferences)
+	require.NoError(t, r.Get(ctx, types.NamespacedName{Name: "neuronetes-priority-minus-10"}, &priorityClass))
+	assert.Equal(t, int32(-10), priorityClass.Value)
+
+	// A named PriorityClass is used as is
+	critical.Spec.Scheduling.PriorityClassName = "system-cluster-critical"
+	require.NoError(t, r.Update(ctx, critical))
+	_, deployment = reconcilePool(t, r, client.ObjectKeyFromObject(critical))
+	assert.Equal(t, "system-cluster-critical", deployment.Spec.Template.Spec.PriorityClassName)
+}
+
+func TestAgentPoolReconcilerSetsSchedulerName(t *testing.T) {
+	pool := newTestAgentPool(1, 3)
+	key := client.ObjectKeyFromObject(pool)
+
+	r := newTestPoolReconciler(t, pool)
+	_, deployment := reconcilePool(t, r, key)
+	assert.Empty(t, deployment.Spec.Template.Spec.SchedulerName)
+
+	r.SchedulerName = "neuronetes-scheduler"
+	_, deployment = reconcilePool(t, r, key)
+	assert.Equal(t, "neuronetes-scheduler", deployment.Spec.Template.Spec.SchedulerName)
+}
+
+func TestAgentPoolReconcilerSpreadsReplicasAcrossTopology(t *testing.T) {
+	ctx := context.Background()
+	pool := newTestAgentPool(1, 3)
+	pool.Spec.Scheduling = &neuronetes.SchedulingConfig{TopologySpread: []neuronetes.TopologySpreadConfig{
+		{TopologyKey: "topology.kubernetes.io/zone"},
+		{TopologyKey: "kubernetes.io/hostname", MaxSkew: 2, WhenUnsatisfiable: "ScheduleAnyway"},
+	}}
+	key := client.ObjectKeyFromObject(pool)
+
+	r := newTestPoolReconciler(t, pool)
+	pool, deployment := reconcilePool(t, r, key)
+	constraints := deployment.Spec.Template.Spec.TopologySpreadConstraints
+	require.Len(t, constraints, 2)
+	assert.Equal(t, "topology.kubernetes.io/zone", constraints[0].TopologyKey)
+	assert.Equal(t, int32(1), constraints[0].MaxSkew)
+	assert.Equal(t, corev1.DoNotSchedule, constraints[0].WhenUnsatisfiable)
+	assert.Equal(t, int32(2), constraints[1].MaxSkew)
+	assert.Equal(t, corev1.ScheduleAnyway, constraints[1].WhenUnsatisfiable)
+	// Warm replicas count towards the skew too
+	assert.Equal(t, map[string]string{
+		neuronetes.LabelPool:      "chat-pool",
+		neuronetes.LabelComponent: agentComponent,
+	}, constraints[0].LabelSelector.MatchLabels)
+
+	pool.Spec.Scheduling.TopologySpread = nil
+	require.NoError(t, r.Update(ctx, pool))
+	_, deployment = reconcilePool(t, r, key)
+	assert.Empty(t, deployment.Spec.Template.Spec.TopologySpreadConstraints)
+}
+
+func TestAgentPoolReconcilerTracksReadiness(t *testing.T) {
+	pool := newTestAgentPool(2, 5)
+	key := client.ObjectKeyFromObject(pool)
+	r := newTestPoolReconciler(t, pool,
+		agentPod("chat-pool-a", true, 0),
+		agentPod("chat-pool-b", true, 1),
+	)
+
+	got, _ := reconcilePool(t, r, key)
+	assert.Equal(t, int32(2), got.Status.ReadyReplicas)
+	cond := meta.FindStatusCondition(got.Status.Conditions, ConditionReady)
+	require.NotNil(t, cond)
+	assert.Equal(t, metav1.ConditionTrue, cond.Status)
+	assert.Equal(t, "ReplicasReady", cond.Reason)
+}
+
+func TestAgentPoolReconcilerScalesWithinBounds(t *testing.T) {
+	pool := newTestAgentPool(1, 5)
+	pool.Spec.Image = "example.com/agent:v2"
+	key := client.ObjectKeyFromObject(pool)
+	r := newTestPoolReconciler(t, pool)
+
+	got, deployment := reconcilePool(t, r, key)
+	assert.Equal(t, int32(1), *deployment.Spec.Replicas)
+	assert.Equal(t, "example.com/agent:v2", deployment.Spec.Template.Spec.Containers[0].Image)
+
+	// Raising the minimum scales the deployment up
+	got.Spec.MinReplicas = 3
+	require.NoError(t, r.Update(context.Background(), got))
+	got, deployment = reconcilePool(t, r, key)
+	assert.Equal(t, int32(3), *deployment.Spec.Replicas)
+	assert.Equal(t, int32(3), got.Status.Replicas)
+
+	// Lowering the maximum below the current count scales it down
+	got.Spec.MinReplicas = 1
+	got.Spec.MaxReplicas = 2
+	require.NoError(t, r.Update(context.Background(), got))
+	got, deployment = reconcilePool(t, r, key)
+	assert.Equal(t, int32(2), *deployment.Spec.Replicas)
+	assert.Equal(t, int32(2), got.Status.Replicas)
+	assert.Len(t, deployment.Spec.Template.Spec.Containers, 1)
+}
+
+func TestAgentPoolReconcilerKeepsWarmPool(t *testing.T) {
+	pool := newTestAgentPool(2, 10)
+	pool.Spec.PrewarmPercent = 20
+	key := client.ObjectKeyFromObject(pool)
+	r := newTestPoolReconciler(t, pool,
+		agentPod("chat-pool-a", true, 0),
+		agentPod("chat-pool-b", true, 1),
+		agentPod("chat-pool-c", true, 2),
+		agentPod("chat-pool-d", false, 3),
+	)
+
+	got, deployment := reconcilePool(t, r, key)
+
+	// 2 serving plus 20% of 10 warm
+	assert.Equal(t, int32(4), *deployment.Spec.Replicas)
+	assert.Equal(t, int32(2), got.Status.Replicas)
+	assert.Equal(t, int32(2), got.Status.ReadyReplicas)
+	// The pod still starting does not count as prewarmed
+	assert.Equal(t, int32(1), got.Status.PrewarmedReplicas)
+	assert.Equal(t, map[string]string{
+		"chat-pool-a": neuronetes.RoleServing,
+		"chat-pool-b": neuronetes.RoleServing,
+		"chat-pool-c": neuronetes.RoleWarm,
+		"chat-pool-d": neuronetes.RoleWarm,
+	}, podRoles(t, r))
+}
+
+func TestAgentPoolReconcilerActivatesWarmReplicas(t *testing.T) {
+	pool := newTestAgentPool(2, 10)
+	pool.Spec.PrewarmPercent = 20
+	key := client.ObjectKeyFromObject(pool)
+	r := newTestPoolReconciler(t, pool,
+		agentPod("chat-pool-a", true, 0),
+		agentPod("chat-pool-b", true, 1),
+		agentPod("chat-pool-c", true, 2),
+		agentPod("chat-pool-d", true, 3),
+	)
+
+	got, _ := reconcilePool(t, r, key)
+	require.Equal(t, int32(2), got.Status.PrewarmedReplicas)
+
+	// A load spike raises the serving count; warm replicas serve right away
+	got.Spec.MinReplicas = 4
+	require.NoError(t, r.Update(context.Background(), got))
+	got, deployment := reconcilePool(t, r, key)
+
+	assert.Equal(t, int32(6), *deployment.Spec.Replicas)
+	assert.Equal(t, int32(4), got.Status.ReadyReplicas)
+	assert.Equal(t, int32(0), got.Status.PrewarmedReplicas)
+	for name, role := range podRoles(t, r) {
+		assert.Equal(t, neuronetes.RoleServing, role, name)
+	}
+}
+
+func TestAgentPoolReconcilerPrefersReadyPodsForServing(t *testing.T) {
+	pool := newTestAgentPool(1, 10)
+	pool.Spec.PrewarmPercent = 10
+	key := client.ObjectKeyFromObject(pool)
+	serving := agentPod("chat-pool-a", false, 0)
+	serving.Labels[neuronetes.LabelRole] = neuronetes.RoleServing
+	r := newTestPoolReconciler(t, pool, serving, agentPod("chat-pool-b", true, 1))
+
+	got, _ := reconcilePool(t, r, key)
+
+	assert.Equal(t, int32(1), got.Status.ReadyReplicas)
+	assert.Equal(t, map[string]string{
+		"chat-pool-a": neuronetes.RoleWarm,
+		"chat-pool-b": neuronetes.RoleServing,
+	}, podRoles(t, r))
+}
+
+func TestAgentPoolReconcilerFollowsSpecReplicas(t *testing.T) {
+	pool := newTestAgentPool(1, 5)
+	replicas := int32(3)
+	pool.Spec.Replicas = &replicas
+	key := client.ObjectKeyFromObject(pool)
+	r := newTestPoolReconciler(t, pool)
+
+	got, deployment := reconcilePool(t, r, key)
+	assert.Equal(t, int32(3), *deployment.Spec.Replicas)
+	assert.Equal(t, int32(3), got.Status.Replicas)
+	assert.Equal(t, "neuronetes.io/component=agent,neuronetes.io/pool=chat-pool,neuronetes.io/role=serving", got.Status.Selector)
+
+	// Requests beyond maxReplicas are clamped
+	replicas = 20
+	got.Spec.Replicas = &replicas
+	require.NoError(t, r.Update(context.Background(), got))
+	got, deployment = reconcilePool(t, r, key)
+	assert.Equal(t, int32(5), *deployment.Spec.Replicas)
+	assert.Equal(t, int32(5), got.Status.Replicas)
+}
+
+func TestAgentPoolReconcilerPausedLeavesPoolAlone(t *testing.T) {
+	pool := newTestAgentPool(1, 5)
+	replicas := int32(2)
+	pool.Spec.Replicas = &replicas
+	key := client.ObjectKeyFromObject(pool)
+	serving := agentPod("chat-pool-a", true, 0)
+	serving.Labels[neuronetes.LabelRole] = neuronetes.RoleServing
+	r := newTestPoolReconciler(t, pool, serving, agentPod("chat-pool-b", true, 1))
+
+	got, deployment := reconcilePool(t, r, key)
+	assert.Equal(t, int32(2), *deployment.Spec.Replicas)
+
+	// Pause, then ask for more replicas
+	got.Annotations = map[string]string{neuronetes.AnnotationPaused: "true"}
+	replicas = 4
+	got.Spec.Replicas = &replicas
+	require.NoError(t, r.Update(context.Background(), got))
+	require.NoError(t, r.Delete(context.Background(), serving))
+
+	got, deployment = reconcilePool(t, r, key)
+	assert.Equal(t, int32(2), *deployment.Spec.Replicas)
+	assert.Equal(t, int32(2), got.Status.Replicas)
+	// Status still reflects the pods as they are
+	assert.Equal(t, int32(1), got.Status.ReadyReplicas)
+	assert.Equal(t, map[string]string{"chat-pool-b": neuronetes.RoleServing}, podRoles(t, r))
+	cond := meta.FindStatusCondition(got.Status.Conditions, ConditionPaused)
+	require.NotNil(t, cond)
+	assert.Equal(t, metav1.ConditionTrue, cond.Status)
+
+	// Resuming applies the pending change
+	delete(got.Annotations, neuronetes.AnnotationPaused)
+	require.NoError(t, r.Update(context.Background(), got))
+	got, deployment = reconcilePool(t, r, key)
+	assert.Equal(t, int32(4), *deployment.Spec.Replicas)
+	assert.Nil(t, meta.FindStatusCondition(got.Status.Conditions, ConditionPaused))
+}
+
+func TestAgentPoolReconcilerAppliesScheduleBounds(t *testing.T) {
+	pool := newTestAgentPool(1, 5)
+	minimum := int32(4)
+	pool.Spec.Autoscaling = &neuronetes.AutoscalingSpec{
+		Schedules: []neuronetes.ScalingSchedule{{Schedule: "* * * * *", MinReplicas: &minimum}},
+	}
+	key := client.ObjectKeyFromObject(pool)
+	r := newTestPoolReconciler(t, pool)
+
+	got, deployment := reconcilePool(t, r, key)
+	assert.Equal(t, int32(4), *deployment.Spec.Replicas)
+	assert.Equal(t, int32(4), got.Status.Replicas)
+}
+
+func TestAgentPoolReconcilerReportsServiceHealth(t *testing.T) {
+	pool := newTestAgentPool(0, 5)
+	key := client.ObjectKeyFromObject(pool)
+	r := newTestPoolReconciler(t, pool)
+	serviceHealth := func() *metav1.Condition {
+		got, _ := reconcilePool(t, r, key)
+		cond := meta.FindStatusCondition(got.Status.Conditions, ConditionServiceHealthy)
+		require.NotNil(t, cond)
+		return cond
+	}
+
+	// A missing class takes the service down
+	cond := serviceHealth()
+	assert.Equal(t, metav1.ConditionFalse, cond.Status)
+	assert.Equal(t, "NotFound", cond.Reason)
+	assert.Equal(t, `Down: AgentClass chat-agent: agent class "chat-agent" not found`, cond.Message)
+
+	// A loading model degrades it
+	class := &neuronetes.AgentClass{
+		ObjectMeta: metav1.ObjectMeta{Name: "chat-agent", Namespace: "default"},
+		Spec:       neuronetes.AgentClassSpec{ModelRef: neuronetes.ModelReference{Name: "llama-3-8b"}},
+	}
+	model := newTestModel()
+	model.Status.Phase = "Loading"
+	require.NoError(t, r.Create(context.Background(), class))
+	require.NoError(t, r.Create(context.Background(), model))
+	cond = serviceHealth()
+	assert.Equal(t, metav1.ConditionFalse, cond.Status)
+	assert.Equal(t, "ModelLoading", cond.Reason)
+	assert.Equal(t, "Degraded: Model llama-3-8b: model weights are loading", cond.Message)
+
+	model.Status.Phase = "Ready"
+	require.NoError(t, r.Status().Update(context.Background(), model))
+	cond = serviceHealth()
+	assert.Equal(t, metav1.ConditionTrue, cond.Status)
+}
+
+// servingPod returns a ready serving pod reporting sessions active sessions
+func servingPod(name string, age int, sessions string) *corev1.Pod {
+	pod := agentPod(name, true, age)
+	pod.Labels[neuronetes.LabelRole] = neuronetes.RoleServing
+	if sessions != "" {
+		pod.Annotations = map[string]string{neuronetes.AnnotationActiveSessions: sessions}
+	}
+	return pod
+}
+
+func newDrainTestPool(replicas int32) *neuronetes.AgentPool {
+	pool := newTestAgentPool(1, 5)
+	pool.Spec.Replicas = &replicas
+	pool.Spec.SessionAffinity = &neuronetes.SessionAffinityConfig{
+		Enabled:      true,
+		TTL:          &metav1.Duration{Duration: 10 * time.Minute},
+		DrainTimeout: &metav1.Duration{Duration: 15 * time.Minute},
+	}
+	return pool
+}
+
+func TestAgentPoolReconcilerDrainsOnScaleDown(t *testing.T) {
+	pool := newDrainTestPool(2)
+	key := client.ObjectKeyFromObject(pool)
+	r := newTestPoolReconciler(t, pool,
+		servingPod("chat-pool-a", 0, "3"),
+		servingPod("chat-pool-b", 1, "1"),
+	)
+	now := time.Unix(1700000000, 0)
+	r.clock = func() time.Time { return now }
+
+	got, _ := reconcilePool(t, r, key)
+	replicas := int32(1)
+	got.Spec.Replicas = &replicas
+	require.NoError(t, r.Update(context.Background(), got))
+
+	// The replica with the fewest sessions drains and keeps running
+	got, deployment := reconcilePool(t, r, key)
+	assert.Equal(t, int32(1), got.Status.Replicas)
+	assert.Equal(t, int32(1), got.Status.DrainingReplicas)
+	assert.Equal(t, int32(2), *deployment.Spec.Replicas)
+	assert.Equal(t, map[string]string{
+		"chat-pool-a": neuronetes.RoleServing,
+		"chat-pool-b": neuronetes.RoleDraining,
+	}, podRoles(t, r))
+
+	// Once its last session ends it is removed
+	var pod corev1.Pod
+	require.NoError(t, r.Get(context.Background(), types.NamespacedName{Namespace: "default", Name: "chat-pool-b"}, &pod))
+	pod.Annotations[neuronetes.AnnotationActiveSessions] = "0"
+	require.NoError(t, r.Update(context.Background(), &pod))
+
+	got, deployment = reconcilePool(t, r, key)
+	assert.Equal(t, int32(0), got.Status.DrainingReplicas)
+	assert.Equal(t, int32(1), *deployment.Spec.Replicas)
+	assert.Equal(t, map[string]string{"chat-pool-a": neuronetes.RoleServing}, podRoles(t, r))
+}
+
+func TestAgentPoolReconcilerBoundsDrain(t *testing.T) {
+	pool := newDrainTestPool(1)
+	key := client.ObjectKeyFromObject(pool)
+	r := newTestPoolReconciler(t, pool,
+		servingPod("chat-pool-a", 0, "5"),
+		servingPod("chat-pool-b", 1, ""),
+		servingPod("chat-pool-c", 2, "2"),
+	)
+	now := time.Unix(1700000000, 0)
+	r.clock = func() time.Time { return now }
+
+	got, _ := reconcilePool(t, r, key)
+	assert.Equal(t, int32(2), got.Status.DrainingReplicas)
+
+	// A replica that does not report sessions drains for the session TTL
+	now = now.Add(11 * time.Minute)
+	got, _ = reconcilePool(t, r, key)
+	assert.Equal(t, int32(1), got.Status.DrainingReplicas)
+	assert.NotContains(t, podRoles(t, r), "chat-pool-b")
+
+	// Sessions that outlive the drain timeout are cut off
+	now = now.Add(5 * time.Minute)
+	got, deployment := reconcilePool(t, r, key)
+	assert.Equal(t, int32(0), got.Status.DrainingReplicas)
+	assert.Equal(t, int32(1), *deployment.Spec.Replicas)
+	assert.Equal(t, map[string]string{"chat-pool-a": neuronetes.RoleServing}, podRoles(t, r))
+}
+
+// fakeSnapshotter records the pods whose GPU memory is snapshotted
+type fakeSnapshotter struct {
+	mu          sync.Mutex
+	snapshotted map[string]bool
+	failRestore bool
+}
+
+func (s *fakeSnapshotter) Snapshot(ctx context.Context, pod *corev1.Pod, config *neuronetes.SnapshotConfig) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.snapshotted[pod.Name] = true
+	return nil
+}
+
+func (s *fakeSnapshotter) Restore(ctx context.Context, pod *corev1.Pod, config *neuronetes.SnapshotConfig) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failRestore {
+		return fmt.Errorf("restore failed")
+	}
+	delete(s.snapshotted, pod.Name)
+	return nil
+}
+
+func TestAgentPoolReconcilerSnapshotsIdleWarmReplicas(t *testing.T) {
+	pool := newTestAgentPool(1, 10)
+	pool.Spec.PrewarmPercent = 20
+	pool.Spec.Snapshot = &neuronetes.SnapshotConfig{
+		Method:    neuronetes.SnapshotMethodCUDACheckpoint,
+		IdleAfter: &metav1.Duration{Duration: 10 * time.Minute},
+	}
+	key := client.ObjectKeyFromObject(pool)
+	r := newTestPoolReconciler(t, pool,
+		agentPod("chat-pool-a", true, 0),
+		agentPod("chat-pool-b", true, 1),
+		agentPod("chat-pool-c", true, 2),
+	)
+	snapshots := &fakeSnapshotter{snapshotted: map[string]bool{}}
+	r.Snapshots = snapshots
+	r.Metrics = metrics.NewAgentMetrics(prometheus.NewRegistry())
+	now := time.Unix(1700000000, 0)
+	r.clock = func() time.Time { return now }
+
+	got, _ := reconcilePool(t, r, key)
+	assert.Equal(t, int32(2), got.Status.PrewarmedReplicas)
+	assert.Equal(t, int32(0), got.Status.SnapshottedReplicas)
+	assert.Empty(t, snapshots.snapshotted)
+
+	// Warm replicas are snapshotted once idle long enough
+	now = now.Add(10 * time.Minute)
+	got, _ = reconcilePool(t, r, key)
+	assert.Equal(t, int32(2), got.Status.PrewarmedReplicas)
+	assert.Equal(t, int32(2), got.Status.SnapshottedReplicas)
+	assert.Equal(t, map[string]bool{"chat-pool-b": true, "chat-pool-c": true}, snapshots.snapshotted)
+
+	// Activated replicas are restored before they serve
+	got.Spec.MinReplicas = 2
+	require.NoError(t, r.Update(context.Background(), got))
+	got, _ = reconcilePool(t, r, key)
+	assert.Equal(t, int32(2), got.Status.ReadyReplicas)
+	assert.Equal(t, int32(1), got.Status.SnapshottedReplicas)
+	assert.Equal(t, map[string]bool{"chat-pool-c": true}, snapshots.snapshotted)
+	assert.Equal(t, neuronetes.RoleServing, podRoles(t, r)["chat-pool-b"])
+	var restored corev1.Pod
+	require.NoError(t, r.Get(context.Background(), types.NamespacedName{Namespace: "default", Name: "chat-pool-b"}, &restored))
+	assert.NotContains(t, restored.Annotations, neuronetes.AnnotationSnapshotted)
+	assert.NotContains(t, restored.Annotations, neuronetes.AnnotationWarmSince)
+	var restoreTimes dto.Metric
+	require.NoError(t, r.Metrics.SnapshotRestoreTime.Write(&restoreTimes))
+	assert.Equal(t, uint64(1), restoreTimes.GetHistogram().GetSampleCount())
+
+	// A replica that fails to restore is replaced rather than served
+	snapshots.failRestore = true
+	got.Spec.MinReplicas = 3
+	require.NoError(t, r.Update(context.Background(), got))
+	got, _ = reconcilePool(t, r, key)
+	assert.Equal(t, int32(0), got.Status.SnapshottedReplicas)
+	assert.NotContains(t, podRoles(t, r), "chat-pool-c")
+}
+
+// fakeWarmUpRunner fails the warm-ups of replicas until told otherwise
+type fakeWarmUpRunner struct {
+	mu   sync.Mutex
+	fail bool
+	runs int
+}
+
+func (w *fakeWarmUpRunner) WarmUp(ctx context.Context, pod *corev1.Pod, config *neuronetes.WarmUpConfig) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.runs++
+	if w.fail {
+		return fmt.Errorf("connection refused")
+	}
+	return nil
+}
+
+func TestAgentPoolReconcilerGatesReadinessOnWarmUp(t *testing.T) {
+	pool := newTestAgentPool(1, 5)
+	pool.Spec.WarmUp = &neuronetes.WarmUpConfig{BatchSizes: []int32{1, 8}}
+	key := client.ObjectKeyFromObject(pool)
+	pod := agentPod("chat-pool-a", false, 0)
+	pod.UID = "pod-a"
+	pod.Spec.ReadinessGates = []corev1.PodReadinessGate{{ConditionType: neuronetes.PodConditionWarmedUp}}
+	pod.Status.PodIP = "10.0.0.7"
+	pod.Status.Conditions = append(pod.Status.Conditions, corev1.PodCondition{Type: corev1.ContainersReady, Status: corev1.ConditionTrue})
+	r := newTestPoolReconciler(t, pool, pod)
+	runner := &fakeWarmUpRunner{fail: true}
+	r.WarmUp = runner
+
+	warmedUp := func() corev1.PodCondition {
+		t.Helper()
+		var got corev1.Pod
+		require.NoError(t, r.Get(context.Background(), client.ObjectKeyFromObject(pod), &got))
+		for _, c := range got.Status.Conditions {
+			if c.Type == neuronetes.PodConditionWarmedUp {
+				return c
+			}
+		}
+		return corev1.PodCondition{}
+	}
+	finished := func() bool {
+		run, ok := r.warmUps.result(pod.UID)
+		return ok && run.finished
+	}
+
+	// Replicas are gated on their warm-up
+	_, deployment := reconcilePool(t, r, key)
+	assert.Equal(t, []corev1.PodReadinessGate{{ConditionType: neuronetes.PodConditionWarmedUp}}, deployment.Spec.Template.Spec.ReadinessGates)
+	require.Eventually(t, finished, time.Second, time.Millisecond)
+	result, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: key})
+	require.NoError(t, err)
+	assert.Equal(t, 30*time.Second, result.RequeueAfter)
+	condition := warmedUp()
+	assert.Equal(t, corev1.ConditionFalse, condition.Status)
+	assert.Equal(t, "WarmUpFailed", condition.Reason)
+
+	// A failed warm-up is retried, and the pool polls until it finishes
+	runner.mu.Lock()
+	runner.fail = false
+	runner.mu.Unlock()
+	result, err = r.Reconcile(context.Background(), ctrl.Request{NamespacedName: key})
+	require.NoError(t, err)
+	assert.Equal(t, warmUpPollInterval, result.RequeueAfter)
+	require.Eventually(t, finished, time.Second, time.Millisecond)
+	got, _ := reconcilePool(t, r, key)
+	assert.Equal(t, corev1.ConditionTrue, warmedUp().Status)
+	assert.Equal(t, "WarmedUp", warmedUp().Reason)
+	require.NotNil(t, got.Status.LastWarmUpDuration)
+	assert.Equal(t, 2, runner.runs)
+
+	// Pools that stop warming up drop the gate
+	got.Spec.WarmUp = nil
+	require.NoError(t, r.Update(context.Background(), got))
+	_, deployment = reconcilePool(t, r, key)
+	assert.Empty(t, deployment.Spec.Template.Spec.ReadinessGates)
+}
+
+func TestAgentPoolReconcilerRollsOnModelChange(t *testing.T) {
+	pool := newTestAgentPool(2, 5)
+	key := client.ObjectKeyFromObject(pool)
+	class := &neuronetes.AgentClass{
+		ObjectMeta: metav1.ObjectMeta{Name: "chat-agent", Namespace: "default"},
+		Spec:       neuronetes.AgentClassSpec{ModelRef: neuronetes.ModelReference{Name: "llama-3-8b"}},
+	}
+	model := newTestModel()
+	r := newTestPoolReconciler(t, pool, class, model)
+
+	_, deployment := reconcilePool(t, r, key)
+	revision := deployment.Spec.Template.Annotations[neuronetes.AnnotationRevision]
+	assert.NotEmpty(t, revision)
+	// Replacements become ready before any replica is removed
+	require.NotNil(t, deployment.Spec.Strategy.RollingUpdate)
+	assert.Equal(t, "25%", deployment.Spec.Strategy.RollingUpdate.MaxSurge.String())
+	assert.Equal(t, "0", deployment.Spec.Strategy.RollingUpdate.MaxUnavailable.String())
+
+	// A new model version maps back to the pool and rolls its replicas
+	require.NoError(t, r.Get(context.Background(), client.ObjectKeyFromObject(model), model))
+	model.Spec.WeightsURI = "s3://models/llama-3.1-8b"
+	require.NoError(t, r.Update(context.Background(), model))
+	assert.Equal(t, []ctrl.Request{{NamespacedName: key}}, r.poolsForModel(context.Background(), model))
+
+	_, deployment = reconcilePool(t, r, key)
+	assert.NotEqual(t, revision, deployment.Spec.Template.Annotations[neuronetes.AnnotationRevision])
+	revision = deployment.Spec.Template.Annotations[neuronetes.AnnotationRevision]
+
+	// Scaling does not
+	var got neuronetes.AgentPool
+	require.NoError(t, r.Get(context.Background(), key, &got))
+	replicas := int32(4)
+	got.Spec.Replicas = &replicas
+	surge := intstr.FromInt32(2)
+	got.Spec.Rollout = &neuronetes.RolloutStrategy{MaxSurge: &surge}
+	require.NoError(t, r.Update(context.Background(), &got))
+	_, deployment = reconcilePool(t, r, key)
+	assert.Equal(t, revision, deployment.Spec.Template.Annotations[neuronetes.AnnotationRevision])
+	assert.Equal(t, "2", deployment.Spec.Strategy.RollingUpdate.MaxSurge.String())
+}
+
+func TestAgentPoolReconcilerMountsModelCacheVolume(t *testing.T) {
+	pool := newTestAgentPool(2, 5)
+	key := client.ObjectKeyFromObject(pool)
+	class := &neuronetes.AgentClass{
+		ObjectMeta: metav1.ObjectMeta{Name: "chat-agent", Namespace: "default"},
+		Spec:       neuronetes.AgentClassSpec{ModelRef: neuronetes.ModelReference{Name: "llama-3-8b"}},
+	}
+	model := newTestModel()
+	model.Status.CacheVolume = &neuronetes.CacheVolumeStatus{ClaimName: "llama-3-8b-weights", Phase: neuronetes.CacheVolumePopulating}
+	r := newTestPoolReconciler(t, pool, class, model)
+
+	// Replicas read the weights from the volume once populated
+	_, deployment := reconcilePool(t, r, key)
+	assert.Empty(t, deployment.Spec.Template.Spec.Volumes)
+
+	old := model.DeepCopy()
+	require.NoError(t, r.Get(context.Background(), client.ObjectKeyFromObject(model), model))
+	model.Status.CacheVolume.Version = "0a1b2c3d4e"
+	model.Status.CacheVolume.Phase = neuronetes.CacheVolumeReady
+	require.NoError(t, r.Status().Update(context.Background(), model))
+	assert.True(t, cacheVolumeChanged.Update(event.UpdateEvent{ObjectOld: old, ObjectNew: model}))
+
+	_, deployment = reconcilePool(t, r, key)
+	require.Len(t, deployment.Spec.Template.Spec.Volumes, 1)
+	volume := deployment.Spec.Template.Spec.Volumes[0]
+	require.NotNil(t, volume.PersistentVolumeClaim)
+	assert.Equal(t, "llama-3-8b-weights", volume.PersistentVolumeClaim.ClaimName)
+	assert.True(t, volume.PersistentVolumeClaim.ReadOnly)
+	container := deployment.Spec.Template.Spec.Containers[0]
+	assert.Equal(t, []corev1.VolumeMount{{Name: "model-cache", MountPath: ModelCacheMountPath, ReadOnly: true}}, container.VolumeMounts)
+	assert.Contains(t, container.Env, corev1.EnvVar{Name: "NEURONETES_MODEL_PATH", Value: ModelCacheMountPath + "/0a1b2c3d4e"})
+
+	// Other status changes do not reconcile the pool
+	old = model.DeepCopy()
+	model.Status.Phase = "Ready"
+	assert.False(t, cacheVolumeChanged.Update(event.UpdateEvent{ObjectOld: old, ObjectNew: model}))
+
+	// The volume is unmounted once the weights are cached on nodes again
+	model.Status.CacheVolume = nil
+	require.NoError(t, r.Status().Update(context.Background(), model))
+	_, deployment = reconcilePool(t, r, key)
+	assert.Empty(t, deployment.Spec.Template.Spec.Volumes)
+	assert.Empty(t, deployment.Spec.Template.Spec.Containers[0].VolumeMounts)
+}
+
+func TestAgentPoolReconcilerGangsTensorParallelShards(t *testing.T) {
+	pool := newTestAgentPool(4, 8)
+	key := client.ObjectKeyFromObject(pool)
+	class := &neuronetes.AgentClass{
+		ObjectMeta: metav1.ObjectMeta{Name: "chat-agent", Namespace: "default"},
+		Spec:       neuronetes.AgentClassSpec{ModelRef: neuronetes.ModelReference{Name: "llama-3-8b"}},
+	}
+	model := newTestModel()
+	model.Spec.ShardSpec = &neuronetes.ShardSpec{Count: 4, Strategy: "tensor-parallel"}
+	r := newTestPoolReconciler(t, pool, class, model)
+
+	_, deployment := reconcilePool(t, r, key)
+	template := deployment.Spec.Template
+	assert.Equal(t, "chat-pool", template.Labels[neuronetes.LabelGang])
+	assert.Equal(t, "4", template.Annotations[neuronetes.AnnotationGangSize])
+	// A rollout surges a whole gang
+	assert.Equal(t, "4", deployment.Spec.Strategy.RollingUpdate.MaxSurge.String())
+
+	// Pipeline-parallel shards start on their own
+	require.NoError(t, r.Get(context.Background(), client.ObjectKeyFromObject(model), model))
+	model.Spec.ShardSpec.Strategy = "pipeline-parallel"
+	require.NoError(t, r.Update(context.Background(), model))
+	_, deployment = reconcilePool(t, r, key)
+	assert.NotContains(t, deployment.Spec.Template.Labels, neuronetes.LabelGang)
+	assert.NotContains(t, deployment.Spec.Template.Annotations, neuronetes.AnnotationGangSize)
+	assert.Equal(t, "25%", deployment.Spec.Strategy.RollingUpdate.MaxSurge.String())
+}
+
+func TestAgentPoolReconcilerInjectsShardPlan(t *testing.T) {
+	pool := newTestAgentPool(2, 4)
+	key := client.ObjectKeyFromObject(pool)
+	class := &neuronetes.AgentClass{
+		ObjectMeta: metav1.ObjectMeta{Name: "chat-agent", Namespace: "default"},
+		Spec:       neuronetes.AgentClassSpec{ModelRef: neuronetes.ModelReference{Name: "llama-3-8b"}},
+	}
+	model := newTestModel()
+	model.Spec.ShardSpec = &neuronetes.ShardSpec{Count: 2, Strategy: "pipeline-parallel"}
+	model.Status.Metadata = &neuronetes.ModelMetadata{NumLayers: 32}
+	r := newTestPoolReconciler(t, pool, class, model)
+
+	_, deployment := reconcilePool(t, r, key)
+	template := deployment.Spec.Template
+	assert.Equal(t, "pipeline-parallel", template.Annotations[neuronetes.AnnotationShardStrategy])
+	assert.Equal(t, "2", template.Annotations[neuronetes.AnnotationShardCount])
+	assert.Equal(t, "32", template.Annotations[neuronetes.AnnotationModelLayers])
+	env := map[string]corev1.EnvVar{}
+	for _, v := range template.Spec.Containers[0].Env {
+		env[v.Name] = v
+	}
+	assert.Equal(t, "2", env["NEURONETES_SHARD_COUNT"].Value)
+	require.Contains(t, env, "NEURONETES_SHARD_LAYERS")
+	assert.Equal(t, "metadata.annotations['neuronetes.io/shard-layer-range']", env["NEURONETES_SHARD_LAYERS"].ValueFrom.FieldRef.FieldPath)
+
+	// Data-parallel replicas are whole copies of the model
+	require.NoError(t, r.Get(context.Background(), client.ObjectKeyFromObject(model), model))
+	model.Spec.ShardSpec.Strategy = "data-parallel"
+	require.NoError(t, r.Update(context.Background(), model))
+	_, deployment = reconcilePool(t, r, key)
+	assert.NotContains(t, deployment.Spec.Template.Annotations, neuronetes.AnnotationShardCount)
+	for _, v := range deployment.Spec.Template.Spec.Containers[0].Env {
+		assert.NotContains(t, v.Name, "NEURONETES_SHARD_")
+	}
+}
+
+func TestAgentPoolReconcilerAnnotatesVRAMFootprint(t *testing.T) {
+	pool := newTestAgentPool(1, 3)
+	key := client.ObjectKeyFromObject(pool)
+	class := &neuronetes.AgentClass{
+		ObjectMeta: metav1.ObjectMeta{Name: "chat-agent", Namespace: "default"},
+		Spec:       neuronetes.AgentClassSpec{ModelRef: neuronetes.ModelReference{Name: "llama-3-8b"}},
+	}
+	model := newTestModel()
+	r := newTestPoolReconciler(t, pool, class, model)
+
+	// 16Gi of weights plus 20% for the KV cache and activations
+	_, deployment := reconcilePool(t, r, key)
+	assert.Equal(t, "19661Mi", deployment.Spec.Template.Annotations[neuronetes.AnnotationVRAM])
+
+	// Tensor-parallel shards each hold a quarter of the weights
+	require.NoError(t, r.Get(context.Background(), client.ObjectKeyFromObject(model), model))
+	model.Spec.ShardSpec = &neuronetes.ShardSpec{Count: 4, Strategy: "tensor-parallel"}
+	require.NoError(t, r.Update(context.Background(), model))
+	_, deployment = reconcilePool(t, r, key)
+	assert.Equal(t, "4916Mi", deployment.Spec.Template.Annotations[neuronetes.AnnotationVRAM])
+}
+
+func TestAgentPoolReconcilerClaimsGPUsThroughDRA(t *testing.T) {
+	ctx := context.Background()
+	pool := newTestAgentPool(1, 3)
+	pool.Spec.GPURequirements.DRA = &neuronetes.DRAConfig{}
+	key := client.ObjectKeyFromObject(pool)
+	r := newTestPoolReconciler(t, pool)
+
+	_, deployment := reconcilePool(t, r, key)
+	spec := deployment.Spec.Template.Spec
+	require.Len(t, spec.ResourceClaims, 1)
+	require.NotNil(t, spec.ResourceClaims[0].Source.ResourceClaimTemplateName)
+	assert.Equal(t, "chat-pool-gpu", *spec.ResourceClaims[0].Source.ResourceClaimTemplateName)
+	assert.Equal(t, []corev1.ResourceClaim{{Name: "gpu-0"}}, spec.Containers[0].Resources.Claims)
+	assert.NotContains(t, spec.Containers[0].Resources.Requests, corev1.ResourceName("nvidia.com/gpu"))
+	assert.Equal(t, "nvidia.com/gpu=2", deployment.Spec.Template.Annotations[neuronetes.AnnotationGPUClaims])
+
+	var class resourcev1alpha2.ResourceClass
+	require.NoError(t, r.Get(ctx, types.NamespacedName{Name: "gpu.nvidia.com"}, &class))
+	assert.Equal(t, "gpu.resource.nvidia.com", class.DriverName)
+	var template resourcev1alpha2.ResourceClaimTemplate
+	require.NoError(t, r.Get(ctx, types.NamespacedName{Namespace: "default", Name: "chat-pool-gpu"}, &template))
+	assert.Equal(t, "gpu.nvidia.com", template.Spec.Spec.ResourceClassName)
+	assert.Equal(t, "GpuClaimParameters", template.Spec.Spec.ParametersRef.Kind)
+
+	params := &unstructured.Unstructured{}
+	params.SetGroupVersionKind(claimParametersVersion.WithKind("GpuClaimParameters"))
+	require.NoError(t, r.Get(ctx, types.NamespacedName{Namespace: "default", Name: "chat-pool-gpu"}, params))
+	count, _, err := unstructured.NestedInt64(params.Object, "spec", "count")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), count)
+
+	// Time-sliced MIG devices are claimed once and shared by all replicas
+	require.NoError(t, r.Get(ctx, key, pool))
+	pool.Spec.MIGProfile = "1g.5gb"
+	pool.Spec.GPURequirements.DRA.Sharing = neuronetes.DRASharingTimeSlicing
+	require.NoError(t, r.Update(ctx, pool))
+	_, deployment = reconcilePool(t, r, key)
+	spec = deployment.Spec.Template.Spec
+	assert.Equal(t, "nvidia.com/mig-1g.5gb=2", deployment.Spec.Template.Annotations[neuronetes.AnnotationGPUClaims])
+	require.Len(t, spec.ResourceClaims, 2)
+	for i, claim := range spec.ResourceClaims {
+		require.NotNil(t, claim.Source.ResourceClaimName)
+		var shared resourcev1alpha2.ResourceClaim
+		require.NoError(t, r.Get(ctx, types.NamespacedName{Namespace: "default", Name: *claim.Source.ResourceClaimName}, &shared), i)
+		assert.Equal(t, "mig.nvidia.com", shared.Spec.ResourceClassName)
+		assert.Equal(t, "MigDeviceClaimParameters", shared.Spec.ParametersRef.Kind)
+	}
+
+	// Without DRA the replicas request extended resources again
+	require.NoError(t, r.Get(ctx, key, pool))
+	pool.Spec.GPURequirements.DRA = nil
+	require.NoError(t, r.Update(ctx, pool))
+	_, deployment = reconcilePool(t, r, key)
+	assert.Empty(t, deployment.Spec.Template.Spec.ResourceClaims)
+	assert.Empty(t, deployment.Spec.Template.Spec.Containers[0].Resources.Claims)
+	assert.NotContains(t, deployment.Spec.Template.Annotations, neuronetes.AnnotationGPUClaims)
+}
+
+func TestAgentPoolReconcilerWaitsForQueueAdmission(t *testing.T) {
+	ctx := context.Background()
+	pool := newTestAgentPool(3, 5)
+	pool.Spec.Scheduling = &neuronetes.SchedulingConfig{QueueName: "team-a"}
+	key := client.ObjectKeyFromObject(pool)
+	r := newTestPoolReconciler(t, pool)
+
+	workload := func(i int) *unstructured.Unstructured {
+		t.Helper()
+		w := &unstructured.Unstructured{}
+		w.SetGroupVersionKind(workloadGVK)
+		require.NoError(t, r.Get(ctx, types.NamespacedName{Namespace: "default", Name: fmt.Sprintf("chat-pool-replica-%d", i)}, w))
+		return w
+	}
+
+	// Replicas wait until Kueue admits their Workloads
+	pool, deployment := reconcilePool(t, r, key)
+	assert.Equal(t, int32(0), *deployment.Spec.Replicas)
+	assert.True(t, meta.IsStatusConditionFalse(pool.Status.Conditions, ConditionQuotaAdmitted))
+	first := workload(0)
+	assert.Equal(t, "team-a", first.Object["spec"].(map[string]interface{})["queueName"])
+	podSets, _, _ := unstructured.NestedSlice(first.Object, "spec", "podSets")
+	require.Len(t, podSets, 1)
+	containers, _, _ := unstructured.NestedSlice(podSets[0].(map[string]interface{}), "template", "spec", "containers")
+	require.Len(t, containers, 1)
+	gpus, _, _ := unstructured.NestedString(containers[0].(map[string]interface{}), "resources", "requests", "nvidia.com/gpu")
+	assert.Equal(t, "2", gpus)
+	assert.Equal(t, "nvidia.com/gpu=2", first.GetAnnotations()[annotationQueueRequest])
+
+	for i := 0; i < 2; i++ {
+		w := workload(i)
+		require.NoError(t, unstructured.SetNestedSlice(w.Object, []interface{}{
+			map[string]interface{}{"type": "Admitted", "status": "True"},
+		}, "status", "conditions"))
+		require.NoError(t, r.Update(ctx, w))
+	}
+	pool, deployment = reconcilePool(t, r, key)
+	assert.Equal(t, int32(2), *deployment.Spec.Replicas)
+	assert.Equal(t, "2/3 replicas admitted by LocalQueue team-a", meta.FindStatusCondition(pool.Status.Conditions, ConditionQuotaAdmitted).Message)
+
+	// Workloads of a changed GPU request are submitted again
+	pool.Spec.GPURequirements.Count = 4
+	require.NoError(t, r.Update(ctx, pool))
+	pool, deployment = reconcilePool(t, r, key)
+	assert.Equal(t, int32(0), *deployment.Spec.Replicas)
+	assert.Equal(t, "nvidia.com/gpu=4", workload(0).GetAnnotations()[annotationQueueRequest])
+
+	// Without a queue the Workloads are removed and all replicas run
+	pool.Spec.Scheduling.QueueName = ""
+	require.NoError(t, r.Update(ctx, pool))
+	pool, deployment = reconcilePool(t, r, key)
+	assert.Equal(t, int32(3), *deployment.Spec.Replicas)
+	assert.Nil(t, meta.FindStatusCondition(pool.Status.Conditions, ConditionQuotaAdmitted))
+	w := &unstructured.Unstructured{}
+	w.SetGroupVersionKind(workloadGVK)
+	assert.True(t, apierrors.IsNotFound(r.Get(ctx, types.NamespacedName{Namespace: "default", Name: "chat-pool-replica-0"}, w)))
+}
+
+func TestAgentPoolReconcilerGeneratesPrometheusRule(t *testing.T) {
+	ctx := context.Background()
+	pool := newTestAgentPool(2, 5)
+	key := client.ObjectKeyFromObject(pool)
+	availability := float32(99.9)
+	tokensPerSecond := int32(40)
+	class := &neuronetes.AgentClass{
+		ObjectMeta: metav1.ObjectMeta{Name: "chat-agent", Namespace: "default"},
+		Spec: neuronetes.AgentClassSpec{
+			ModelRef: neuronetes.ModelReference{Name: "llama-3-8b"},
+			SLO: &neuronetes.ServiceLevelObjective{
+				TTFT:                &metav1.Duration{Duration: 350 * time.Millisecond},
+				TokensPerSecond:     &tokensPerSecond,
+				AvailabilityPercent: &availability,
+			},
+		},
+	}
+	r := newTestPoolReconciler(t, pool, class, newTestModel())
+	r.RuleLabels = map[string]string{"release": "kube-prometheus-stack"}
+
+	reconcilePool(t, r, key)
+	rule := &unstructured.Unstructured{}
+	rule.SetGroupVersionKind(prometheusRuleGVK)
+	require.NoError(t, r.Get(ctx, types.NamespacedName{Namespace: "default", Name: "chat-pool-slo"}, rule))
+	assert.Equal(t, "kube-prometheus-stack", rule.GetLabels()["release"])
+	assert.Equal(t, "chat-pool", rule.GetLabels()[neuronetes.LabelPool])
+	require.Len(t, rule.GetOwnerReferences(), 1)
+
+	rules := func() map[string]map[string]interface{} {
+		t.Helper()
+		require.NoError(t, r.Get(ctx, types.NamespacedName{Namespace: "default", Name: "chat-pool-slo"}, rule))
+		groups, _, _ := unstructured.NestedSlice(rule.Object, "spec", "groups")
+		require.Len(t, groups, 1)
+		items, _, _ := unstructured.NestedSlice(groups[0].(map[string]interface{}), "rules")
+		byName := map[string]map[string]interface{}{}
+		for _, item := range items {
+			rule := item.(map[string]interface{})
+			name, _ := rule["record"].(string)
+			if alert, ok := rule["alert"].(string); ok {
+				name = alert
+			}
+			byName[name] = rule
+		}
+		return byName
+	}
+	got := rules()
+	assert.Equal(t, `histogram_quantile(0.95, sum by (le) (rate(agent_ttft_ms_bucket{namespace="default",pool="chat-pool"}[5m])))`,
+		got["neuronetes:pool_ttft_p95:rate5m"]["expr"])
+	assert.Contains(t, got, "neuronetes:pool_output_tokens:rate5m")
+	assert.Contains(t, got, "neuronetes:pool_error_ratio:rate6h")
+	assert.Equal(t, `neuronetes:pool_ttft_p95:rate5m{namespace="default",pool="chat-pool"} > 350`,
+		got["AgentPoolTTFTSLOBreach"]["expr"])
+	assert.Equal(t, `avg(agent_tokens_out_per_s{namespace="default",pool="chat-pool"}) < 40`,
+		got["AgentPoolThroughputSLOBreach"]["expr"])
+	// 14.4 times the 0.1% budget
+	assert.Equal(t, `neuronetes:pool_error_ratio:rate1h{namespace="default",pool="chat-pool"} > 0.0144 and neuronetes:pool_error_ratio:rate5m{namespace="default",pool="chat-pool"} > 0.0144`,
+		got["AgentPoolErrorBudgetFastBurn"]["expr"])
+	assert.Contains(t, got, "AgentPoolErrorBudgetSlowBurn")
+	assert.NotContains(t, got, "AgentPoolLatencySLOBreach")
+
+	// Removing the SLO removes its alerts but keeps the recording rules
+	require.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(class), class))
+	class.Spec.SLO = nil
+	require.NoError(t, r.Update(ctx, class))
+	reconcilePool(t, r, key)
+	got = rules()
+	assert.Len(t, got, 7)
+	assert.NotContains(t, got, "AgentPoolTTFTSLOBreach")
+}
@@ -0,0 +1,409 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/modelcache"
+)
+
+const (
+	// DefaultCachePopulatorImage is the image of the Jobs populating cache
+	// volumes, running its /cache-agent
+	DefaultCachePopulatorImage = "ghcr.io/bowenislandsong/neuronetes:latest"
+
+	// ModelCacheMountPath is where replicas mount the cache volume of their
+	// model, read-only. NEURONETES_MODEL_PATH points at the version of the
+	// weights under it.
+	ModelCacheMountPath = "/var/cache/neuronetes/models"
+
+	// populatorBackoffLimit is how many times a failed population is
+	// retried
+	populatorBackoffLimit = 2
+
+	// populatorMountPath is where populate Jobs mount the cache volume
+	populatorMountPath = "/cache"
+
+	// populatorRequestPath is where populate Jobs mount the Secret holding
+	// their download request
+	populatorRequestPath = "/etc/neuronetes/populate"
+
+	// populatorRequestKey is the key of the download request, as JSON, in
+	// the Secret of a populate Job
+	populatorRequestKey = "request.json"
+
+	// jobReasonPodFailurePolicy is the reason of the Failed condition of
+	// Jobs failed by a rule of their pod failure policy
+	jobReasonPodFailurePolicy = "PodFailurePolicy"
+
+	// populatorUser is the user of the populator image, which the cache
+	// volume is made writable for
+	populatorUser = 65532
+)
+
+// CacheVolumeReconciler reconciles the cache volumes of Models. For a
+// Model whose CachePolicy sets a volume, it provisions a
+// PersistentVolumeClaim of the storage class and access mode of the
+// volume, and runs a Job populating it with each version of the weights,
+// a directory named after modelcache.VolumeVersion. The Job downloads the
+// request modelcache.DownloadRequest builds, so the weights are verified
+// and fail over to mirrors as they are on node disks. Populators lock the
+// version they download, so that Jobs of the same version on a shared
+// claim reuse each other's weights. The version in Model status, which
+// replicas read, switches once the new version is complete.
+type CacheVolumeReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+
+	// Image is the image of the populate Jobs. Defaults to
+	// DefaultCachePopulatorImage.
+	Image string
+}
+
+// +kubebuilder:rbac:groups=neuronetes.io,resources=models,verbs=get;list;watch
+// +kubebuilder:rbac:groups=neuronetes.io,resources=models/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=core,resources=persistentvolumeclaims,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;update;delete
+// +kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;delete
+
+// Reconcile provisions and populates the cache volume of a model
+func (r *CacheVolumeReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	var model neuronetes.Model
+	if err := r.Get(ctx, req.NamespacedName, &model); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	var volume *neuronetes.CacheVolume
+	if model.Spec.CachePolicy != nil {
+		volume = model.Spec.CachePolicy.Volume
+	}
+	if volume == nil {
+		if model.Status.CacheVolume == nil {
+			return ctrl.Result{}, nil
+		}
+		// The weights are cached on node disks again
+		if err := r.cleanup(ctx, &model, model.Status.CacheVolume.ClaimName, ""); err != nil {
+			return ctrl.Result{}, err
+		}
+		model.Status.CacheVolume = nil
+		return ctrl.Result{}, r.Status().Update(ctx, &model)
+	}
+
+	status := &neuronetes.CacheVolumeStatus{ClaimName: cacheClaimName(&model)}
+	if model.Status.CacheVolume != nil {
+		status = model.Status.CacheVolume.DeepCopy()
+		status.ClaimName = cacheClaimName(&model)
+	}
+	if err := r.reconcileVolume(ctx, &model, volume, status); err != nil {
+		return ctrl.Result{}, err
+	}
+	if !equality.Semantic.DeepEqual(status, model.Status.CacheVolume) {
+		model.Status.CacheVolume = status
+		if err := r.Status().Update(ctx, &model); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	return ctrl.Result{}, nil
+}
+
+// reconcileVolume provisions the claim of model and populates it with the
+// current version of its weights, recording the progress in status
+func (r *CacheVolumeReconciler) reconcileVolume(ctx context.Context, model *neuronetes.Model, volume *neuronetes.CacheVolume, status *neuronetes.CacheVolumeStatus) error {
+	size, err := cacheVolumeSize(model, volume)
+	if err != nil {
+		status.Phase = neuronetes.CacheVolumeFailed
+		status.Message = err.Error()
+		return nil
+	}
+	claim := &corev1.PersistentVolumeClaim{}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: model.Namespace, Name: status.ClaimName}, claim); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return err
+		}
+		if err := r.createClaim(ctx, model, volume, status.ClaimName, size); err != nil {
+			return err
+		}
+	} else {
+		if !metav1.IsControlledBy(claim, model) {
+			status.Phase = neuronetes.CacheVolumeFailed
+			status.Message = fmt.Sprintf("claim %s exists and is not owned by the model", claim.Name)
+			return nil
+		}
+		// Claims can only grow, and only the size of the volume changes
+		// once provisioned
+		if current := claim.Spec.Resources.Requests[corev1.ResourceStorage]; size.Cmp(current) > 0 {
+			patch := client.MergeFrom(claim.DeepCopy())
+			claim.Spec.Resources.Requests[corev1.ResourceStorage] = size
+			if err := r.Patch(ctx, claim, patch); err != nil {
+				return fmt.Errorf("failed to resize claim %s: %w", claim.Name, err)
+			}
+			log.FromContext(ctx).Info("Resized cache volume", "claim", claim.Name, "size", size.String())
+		}
+	}
+
+	version := modelcache.VolumeVersion(model)
+	if status.Version == version && status.Phase == neuronetes.CacheVolumeReady {
+		return nil
+	}
+
+	job := &batchv1.Job{}
+	name := populateJobName(model, version)
+	if err := r.Get(ctx, types.NamespacedName{Namespace: model.Namespace, Name: name}, job); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return err
+		}
+		if err := r.reconcilePopulateRequest(ctx, model, version, name); err != nil {
+			return err
+		}
+		job = r.buildPopulateJob(model, status.ClaimName, name)
+		if err := ctrl.SetControllerReference(model, job, r.Scheme); err != nil {
+			return err
+		}
+		if err := r.Create(ctx, job); err != nil {
+			return fmt.Errorf("failed to create populate job %s: %w", name, err)
+		}
+		log.FromContext(ctx).Info("Started populate job", "job", name, "claim", status.ClaimName, "version", version)
+	}
+
+	// Failed populations are retried when the weights change or their Job
+	// is deleted. Replicas keep reading the previous version meanwhile.
+	switch failed := jobCondition(job, batchv1.JobFailed); {
+	case failed != nil && failed.Reason == jobReasonPodFailurePolicy:
+		status.Phase = neuronetes.CacheVolumeFailed
+		status.Message = fmt.Sprintf("populate job %s failed: the weights failed integrity verification", name)
+	case failed != nil:
+		status.Phase = neuronetes.CacheVolumeFailed
+		status.Message = fmt.Sprintf("populate job %s failed: %s", name, failed.Message)
+	case jobCondition(job, batchv1.JobComplete) != nil:
+		log.FromContext(ctx).Info("Populated cache volume", "claim", status.ClaimName, "version", version)
+		status.Version = version
+		status.Phase = neuronetes.CacheVolumeReady
+		status.Message = ""
+		return r.cleanup(ctx, model, "", name)
+	default:
+		status.Phase = neuronetes.CacheVolumePopulating
+		status.Message = fmt.Sprintf("populating %s with the weights of version %s", status.ClaimName, version)
+	}
+	return nil
+}
+
+// createClaim creates the claim of the cache volume of model
+func (r *CacheVolumeReconciler) createClaim(ctx context.Context, model *neuronetes.Model, volume *neuronetes.CacheVolume, name string, size resource.Quantity) error {
+	accessMode := corev1.PersistentVolumeAccessMode(volume.AccessMode)
+	if accessMode == "" {
+		accessMode = corev1.ReadWriteMany
+	}
+	claim := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: model.Namespace,
+			Labels:    map[string]string{neuronetes.LabelModel: model.Name},
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes:      []corev1.PersistentVolumeAccessMode{accessMode},
+			StorageClassName: volume.StorageClassName,
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceStorage: size},
+			},
+		},
+	}
+	if err := ctrl.SetControllerReference(model, claim, r.Scheme); err != nil {
+		return err
+	}
+	if err := r.Create(ctx, claim); err != nil {
+		return fmt.Errorf("failed to create claim %s: %w", name, err)
+	}
+	log.FromContext(ctx).Info("Created cache volume", "claim", name, "accessMode", accessMode, "size", size.String())
+	return nil
+}
+
+// reconcilePopulateRequest writes the request downloading version of the
+// weights of model, with the credentials, mirrors and integrity of the
+// model, to the Secret name the populate Job of the version reads
+func (r *CacheVolumeReconciler) reconcilePopulateRequest(ctx context.Context, model *neuronetes.Model, version, name string) error {
+	req, err := modelcache.DownloadRequest(ctx, r, model, populatorMountPath+"/"+version)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: model.Namespace}}
+	if _, err := controllerutil.CreateOrUpdate(ctx, r.Client, secret, func() error {
+		secret.Labels = populatorLabels(model)
+		secret.Data = map[string][]byte{populatorRequestKey: data}
+		return ctrl.SetControllerReference(model, secret, r.Scheme)
+	}); err != nil {
+		return fmt.Errorf("failed to write populate request %s: %w", name, err)
+	}
+	return nil
+}
+
+// buildPopulateJob returns the Job name populating the claim of model with
+// the request of the Secret of the same name. Weights failing integrity
+// verification fail the Job without retries.
+func (r *CacheVolumeReconciler) buildPopulateJob(model *neuronetes.Model, claimName, name string) *batchv1.Job {
+	image := r.Image
+	if image == "" {
+		image = DefaultCachePopulatorImage
+	}
+	labels := populatorLabels(model)
+	backoffLimit := int32(populatorBackoffLimit)
+	fsGroup := int64(populatorUser)
+	container := "populator"
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: model.Namespace, Labels: labels},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			PodFailurePolicy: &batchv1.PodFailurePolicy{Rules: []batchv1.PodFailurePolicyRule{{
+				Action: batchv1.PodFailurePolicyActionFailJob,
+				OnExitCodes: &batchv1.PodFailurePolicyOnExitCodesRequirement{
+					ContainerName: &container,
+					Operator:      batchv1.PodFailurePolicyOnExitCodesOpIn,
+					Values:        []int32{modelcache.IntegrityExitCode},
+				},
+			}}},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: corev1.PodSpec{
+					RestartPolicy:   corev1.RestartPolicyNever,
+					SecurityContext: &corev1.PodSecurityContext{FSGroup: &fsGroup},
+					Containers: []corev1.Container{{
+						Name:    container,
+						Image:   image,
+						Command: []string{"/cache-agent"},
+						Args:    []string{"--populate-request=" + populatorRequestPath + "/" + populatorRequestKey},
+						VolumeMounts: []corev1.VolumeMount{
+							{Name: "cache", MountPath: populatorMountPath},
+							{Name: "request", MountPath: populatorRequestPath, ReadOnly: true},
+						},
+					}},
+					Volumes: []corev1.Volume{
+						{
+							Name: "cache",
+							VolumeSource: corev1.VolumeSource{
+								PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: claimName},
+							},
+						},
+						{
+							Name:         "request",
+							VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: name}},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// cleanup deletes the populate Jobs of model but keep along with their
+// Secrets, and its claim if claimName is set and the model owns it
+func (r *CacheVolumeReconciler) cleanup(ctx context.Context, model *neuronetes.Model, claimName, keep string) error {
+	var jobs batchv1.JobList
+	if err := r.List(ctx, &jobs, client.InNamespace(model.Namespace), client.MatchingLabels(populatorLabels(model))); err != nil {
+		return err
+	}
+	for i := range jobs.Items {
+		if jobs.Items[i].Name == keep {
+			continue
+		}
+		if err := r.Delete(ctx, &jobs.Items[i], client.PropagationPolicy(metav1.DeletePropagationBackground)); client.IgnoreNotFound(err) != nil {
+			return fmt.Errorf("failed to delete populate job %s: %w", jobs.Items[i].Name, err)
+		}
+	}
+	var secrets corev1.SecretList
+	if err := r.List(ctx, &secrets, client.InNamespace(model.Namespace), client.MatchingLabels(populatorLabels(model))); err != nil {
+		return err
+	}
+	for i := range secrets.Items {
+		if secrets.Items[i].Name == keep {
+			continue
+		}
+		if err := r.Delete(ctx, &secrets.Items[i]); client.IgnoreNotFound(err) != nil {
+			return fmt.Errorf("failed to delete populate request %s: %w", secrets.Items[i].Name, err)
+		}
+	}
+	if claimName == "" {
+		return nil
+	}
+
+	claim := &corev1.PersistentVolumeClaim{}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: model.Namespace, Name: claimName}, claim); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if !metav1.IsControlledBy(claim, model) {
+		return nil
+	}
+	if err := r.Delete(ctx, claim); client.IgnoreNotFound(err) != nil {
+		return fmt.Errorf("failed to delete claim %s: %w", claimName, err)
+	}
+	log.FromContext(ctx).Info("Deleted cache volume", "claim", claimName)
+	return nil
+}
+
+// cacheVolumeSize returns the capacity of the claim of model, by default
+// twice the size of the model so that a new version of the weights fits
+// beside the one replicas read
+func cacheVolumeSize(model *neuronetes.Model, volume *neuronetes.CacheVolume) (resource.Quantity, error) {
+	if volume.Size != nil {
+		return volume.Size.DeepCopy(), nil
+	}
+	if model.Spec.Size.IsZero() {
+		return resource.Quantity{}, errors.New("spec.cachePolicy.volume.size is required for models of unknown size")
+	}
+	size := model.Spec.Size.DeepCopy()
+	size.Add(model.Spec.Size)
+	return size, nil
+}
+
+// populatorLabels returns the labels of the populate Jobs of model and of
+// their Secrets
+func populatorLabels(model *neuronetes.Model) map[string]string {
+	return map[string]string{
+		neuronetes.LabelModel:     model.Name,
+		neuronetes.LabelComponent: "cache-populator",
+	}
+}
+
+// cacheClaimName returns the name of the claim of the cache volume of model
+func cacheClaimName(model *neuronetes.Model) string {
+	return model.Name + "-weights"
+}
+
+// populateJobName returns the name of the Job populating version of the
+// weights of model, within the length of a label value
+func populateJobName(model *neuronetes.Model, version string) string {
+	suffix := "-populate-" + version
+	name := model.Name
+	if len(name)+len(suffix) > 63 {
+		name = name[:63-len(suffix)]
+	}
+	return name + suffix
+}
+
+// SetupWithManager sets up the controller with the Manager
+func (r *CacheVolumeReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("cachevolume").
+		For(&neuronetes.Model{}).
+		Owns(&corev1.PersistentVolumeClaim{}).
+		Owns(&batchv1.Job{}).
+		Complete(r)
+}
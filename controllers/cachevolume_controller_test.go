@@ -0,0 +1,195 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/downloader"
+	"github.com/bowenislandsong/neuronetes/pkg/modelcache"
+)
+
+func reconcileCacheVolume(t *testing.T, r *CacheVolumeReconciler, key types.NamespacedName) *neuronetes.Model {
+	t.Helper()
+	_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: key})
+	require.NoError(t, err)
+	var model neuronetes.Model
+	require.NoError(t, r.Get(context.Background(), key, &model))
+	return &model
+}
+
+// finishJob sets condition on the Job name
+func finishJob(t *testing.T, c client.Client, name string, condition batchv1.JobConditionType, reason, message string) {
+	t.Helper()
+	job := &batchv1.Job{}
+	require.NoError(t, c.Get(context.Background(), types.NamespacedName{Namespace: "default", Name: name}, job))
+	job.Status.Conditions = []batchv1.JobCondition{{Type: condition, Status: corev1.ConditionTrue, Reason: reason, Message: message}}
+	require.NoError(t, c.Status().Update(context.Background(), job))
+}
+
+// populateRequest returns the download request of the populate Job name
+func populateRequest(t *testing.T, c client.Client, name string) downloader.Request {
+	t.Helper()
+	var secret corev1.Secret
+	require.NoError(t, c.Get(context.Background(), types.NamespacedName{Namespace: "default", Name: name}, &secret))
+	var req downloader.Request
+	require.NoError(t, json.Unmarshal(secret.Data["request.json"], &req))
+	return req
+}
+
+func TestCacheVolumeReconcilerPopulatesClaim(t *testing.T) {
+	ctx := context.Background()
+	model := newTestModel()
+	model.UID = "model-uid"
+	model.Spec.CredentialsSecretRef = &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "s3-token"}, Key: "token"}
+	model.Spec.FilePatterns = []string{"*.safetensors"}
+	storageClass := "fast-rwx"
+	model.Spec.CachePolicy = &neuronetes.CachePolicy{
+		Priority: "high",
+		Volume:   &neuronetes.CacheVolume{StorageClassName: &storageClass, AccessMode: neuronetes.CacheVolumeShared},
+	}
+	key := client.ObjectKeyFromObject(model)
+	token := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "s3-token", Namespace: "default"},
+		Data:       map[string][]byte{"token": []byte("secret")},
+	}
+	c := newFakeClient(t, model, token)
+	r := &CacheVolumeReconciler{Client: c, Scheme: c.Scheme()}
+
+	got := reconcileCacheVolume(t, r, key)
+	require.NotNil(t, got.Status.CacheVolume)
+	assert.Equal(t, "llama-3-8b-weights", got.Status.CacheVolume.ClaimName)
+	assert.Equal(t, neuronetes.CacheVolumePopulating, got.Status.CacheVolume.Phase)
+	assert.Empty(t, got.Status.CacheVolume.Version)
+
+	// The claim fits two versions of the weights
+	var claim corev1.PersistentVolumeClaim
+	require.NoError(t, c.Get(ctx, types.NamespacedName{Namespace: "default", Name: "llama-3-8b-weights"}, &claim))
+	assert.Equal(t, []corev1.PersistentVolumeAccessMode{corev1.ReadWriteMany}, claim.Spec.AccessModes)
+	assert.Equal(t, &storageClass, claim.Spec.StorageClassName)
+	size := claim.Spec.Resources.Requests[corev1.ResourceStorage]
+	assert.Equal(t, "32Gi", size.String())
+
+	version := modelcache.VolumeVersion(model)
+	name := "llama-3-8b-populate-" + version
+	var job batchv1.Job
+	require.NoError(t, c.Get(ctx, types.NamespacedName{Namespace: "default", Name: name}, &job))
+	container := job.Spec.Template.Spec.Containers[0]
+	assert.Equal(t, DefaultCachePopulatorImage, container.Image)
+	assert.Equal(t, []string{"--populate-request=/etc/neuronetes/populate/request.json"}, container.Args)
+	assert.Empty(t, container.Env)
+	assert.Equal(t, "llama-3-8b-weights", job.Spec.Template.Spec.Volumes[0].PersistentVolumeClaim.ClaimName)
+	assert.Equal(t, name, job.Spec.Template.Spec.Volumes[1].Secret.SecretName)
+	assert.Equal(t, downloader.Request{
+		URI:      "s3://models/llama-3-8b",
+		Dir:      "/cache/" + version,
+		Patterns: []string{"*.safetensors"},
+		Token:    "secret",
+	}, populateRequest(t, c, name))
+
+	finishJob(t, c, name, batchv1.JobComplete, "", "")
+	got = reconcileCacheVolume(t, r, key)
+	assert.Equal(t, neuronetes.CacheVolumeReady, got.Status.CacheVolume.Phase)
+	assert.Equal(t, version, got.Status.CacheVolume.Version)
+
+	// New weights are populated beside the current ones, which replicas
+	// keep reading until they are complete. The claim grows with the size
+	// of the model.
+	got.Spec.WeightsURI = "s3://models/llama-3.1-8b"
+	got.Spec.Size = resource.MustParse("20Gi")
+	require.NoError(t, c.Update(ctx, got))
+	got = reconcileCacheVolume(t, r, key)
+	assert.Equal(t, neuronetes.CacheVolumePopulating, got.Status.CacheVolume.Phase)
+	assert.Equal(t, version, got.Status.CacheVolume.Version)
+	require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(&claim), &claim))
+	size = claim.Spec.Resources.Requests[corev1.ResourceStorage]
+	assert.Equal(t, "40Gi", size.String())
+
+	next := "llama-3-8b-populate-" + modelcache.VolumeVersion(got)
+	finishJob(t, c, next, batchv1.JobFailed, "BackoffLimitExceeded", "Job has reached the specified backoff limit")
+	got = reconcileCacheVolume(t, r, key)
+	assert.Equal(t, neuronetes.CacheVolumeFailed, got.Status.CacheVolume.Phase)
+	assert.Contains(t, got.Status.CacheVolume.Message, "backoff limit")
+	assert.Equal(t, version, got.Status.CacheVolume.Version)
+
+	// Completed populations replace the Jobs of earlier versions and their
+	// requests
+	finishJob(t, c, next, batchv1.JobComplete, "", "")
+	got = reconcileCacheVolume(t, r, key)
+	assert.Equal(t, modelcache.VolumeVersion(got), got.Status.CacheVolume.Version)
+	assert.True(t, apierrors.IsNotFound(c.Get(ctx, types.NamespacedName{Namespace: "default", Name: name}, &job)))
+	assert.True(t, apierrors.IsNotFound(c.Get(ctx, types.NamespacedName{Namespace: "default", Name: name}, &corev1.Secret{})))
+
+	// Removing the volume deletes the claim
+	got.Spec.CachePolicy.Volume = nil
+	require.NoError(t, c.Update(ctx, got))
+	got = reconcileCacheVolume(t, r, key)
+	assert.Nil(t, got.Status.CacheVolume)
+	assert.True(t, apierrors.IsNotFound(c.Get(ctx, client.ObjectKeyFromObject(&claim), &claim)))
+}
+
+func TestCacheVolumeReconcilerRequiresSizeOfUnknownModels(t *testing.T) {
+	model := newTestModel()
+	model.Spec.Size = resource.Quantity{}
+	model.Spec.CachePolicy = &neuronetes.CachePolicy{Priority: "high", Volume: &neuronetes.CacheVolume{AccessMode: neuronetes.CacheVolumePerNode}}
+	key := client.ObjectKeyFromObject(model)
+	c := newFakeClient(t, model)
+	r := &CacheVolumeReconciler{Client: c, Scheme: c.Scheme()}
+
+	got := reconcileCacheVolume(t, r, key)
+	assert.Equal(t, neuronetes.CacheVolumeFailed, got.Status.CacheVolume.Phase)
+	assert.Contains(t, got.Status.CacheVolume.Message, "size is required")
+
+	size := resource.MustParse("50Gi")
+	got.Spec.CachePolicy.Volume.Size = &size
+	require.NoError(t, c.Update(context.Background(), got))
+	got = reconcileCacheVolume(t, r, key)
+	assert.Equal(t, neuronetes.CacheVolumePopulating, got.Status.CacheVolume.Phase)
+	var claim corev1.PersistentVolumeClaim
+	require.NoError(t, c.Get(context.Background(), types.NamespacedName{Namespace: "default", Name: "llama-3-8b-weights"}, &claim))
+	assert.Equal(t, []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce}, claim.Spec.AccessModes)
+}
+
+func TestCacheVolumeReconcilerFailsWeightsFailingIntegrity(t *testing.T) {
+	model := newTestModel()
+	model.Spec.Mirrors = []neuronetes.WeightsMirror{{URI: "gs://mirror/llama-3-8b", Priority: -1}}
+	model.Spec.Integrity = &neuronetes.ModelIntegrity{SHA256: map[string]string{"model.safetensors": "0a1b"}}
+	model.Spec.CachePolicy = &neuronetes.CachePolicy{Priority: "high", Volume: &neuronetes.CacheVolume{}}
+	key := client.ObjectKeyFromObject(model)
+	c := newFakeClient(t, model)
+	r := &CacheVolumeReconciler{Client: c, Scheme: c.Scheme()}
+	reconcileCacheVolume(t, r, key)
+
+	// The populator verifies the digests and fails over to the mirror
+	name := "llama-3-8b-populate-" + modelcache.VolumeVersion(model)
+	req := populateRequest(t, c, name)
+	assert.Equal(t, &downloader.Integrity{SHA256: map[string]string{"model.safetensors": "0a1b"}}, req.Integrity)
+	assert.Equal(t, []downloader.Mirror{{URI: "gs://mirror/llama-3-8b", Priority: -1}}, req.Mirrors)
+
+	// and exits with IntegrityExitCode on a mismatch, which fails the Job
+	// at once
+	var job batchv1.Job
+	require.NoError(t, c.Get(context.Background(), types.NamespacedName{Namespace: "default", Name: name}, &job))
+	require.NotNil(t, job.Spec.PodFailurePolicy)
+	rule := job.Spec.PodFailurePolicy.Rules[0]
+	assert.Equal(t, batchv1.PodFailurePolicyActionFailJob, rule.Action)
+	assert.Equal(t, []int32{modelcache.IntegrityExitCode}, rule.OnExitCodes.Values)
+
+	finishJob(t, c, name, batchv1.JobFailed, "PodFailurePolicy", "Container populator for pod default/x failed with exit code 3 matching FailJob rule at index 0")
+	got := reconcileCacheVolume(t, r, key)
+	assert.Equal(t, neuronetes.CacheVolumeFailed, got.Status.CacheVolume.Phase)
+	assert.Contains(t, got.Status.CacheVolume.Message, "failed integrity verification")
+	assert.Empty(t, got.Status.CacheVolume.Version)
+}
@@ -0,0 +1,225 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	resourcev1alpha2 "k8s.io/api/resource/v1alpha2"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+)
+
+const (
+	// draDriverName is the NVIDIA DRA driver, which allocates the claims of
+	// the ResourceClasses created for pools
+	draDriverName = "gpu.resource.nvidia.com"
+
+	// gpuResourceClass and migResourceClass are the default ResourceClasses
+	// of whole GPUs and MIG devices, as installed by the NVIDIA DRA driver
+	gpuResourceClass = "gpu.nvidia.com"
+	migResourceClass = "mig.nvidia.com"
+
+	// gpuClaimName names the claims of a replica in its pod spec, suffixed
+	// with the index of each MIG device
+	gpuClaimName = "gpu"
+)
+
+// claimParametersVersion is the API version of the NVIDIA DRA driver's claim
+// parameters
+var claimParametersVersion = schema.GroupVersion{Group: draDriverName, Version: "v1alpha1"}
+
+// +kubebuilder:rbac:groups=resource.k8s.io,resources=resourceclasses,verbs=get;list;watch;create
+// +kubebuilder:rbac:groups=resource.k8s.io,resources=resourceclaims;resourceclaimtemplates,verbs=get;list;watch;create;delete
+// +kubebuilder:rbac:groups=gpu.resource.nvidia.com,resources=gpuclaimparameters;migdeviceclaimparameters,verbs=get;list;watch;create;update;patch
+
+// usesDRA reports whether the replicas of pool claim their GPUs through
+// Dynamic Resource Allocation, with ResourceClaims rather than extended
+// resources
+func usesDRA(pool *neuronetes.AgentPool) bool {
+	return pool.Spec.GPURequirements != nil && pool.Spec.GPURequirements.DRA != nil
+}
+
+// resourceClassName returns the ResourceClass the claims of pool are
+// allocated from
+func resourceClassName(pool *neuronetes.AgentPool) string {
+	if name := pool.Spec.GPURequirements.DRA.ResourceClassName; name != "" {
+		return name
+	}
+	if pool.Spec.MIGProfile != "" {
+		return migResourceClass
+	}
+	return gpuResourceClass
+}
+
+// claimParameters returns the NVIDIA DRA driver parameters of each claim of
+// pool: the count of whole GPUs, or the MIG profile of a single device, and
+// how they are shared
+func claimParameters(pool *neuronetes.AgentPool) *unstructured.Unstructured {
+	params := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	params.SetName(pool.Name + "-gpu")
+	params.SetNamespace(pool.Namespace)
+
+	spec := map[string]interface{}{}
+	if pool.Spec.MIGProfile != "" {
+		params.SetGroupVersionKind(claimParametersVersion.WithKind("MigDeviceClaimParameters"))
+		spec["profile"] = pool.Spec.MIGProfile
+	} else {
+		params.SetGroupVersionKind(claimParametersVersion.WithKind("GpuClaimParameters"))
+		_, count := gpuRequest(pool)
+		spec["count"] = count
+	}
+	if sharing := pool.Spec.GPURequirements.DRA.Sharing; sharing != "" {
+		spec["sharing"] = map[string]interface{}{"strategy": sharing}
+	}
+	params.Object["spec"] = spec
+	return params
+}
+
+// claimSpec returns the spec of the claims of pool
+func claimSpec(pool *neuronetes.AgentPool, params *unstructured.Unstructured) resourcev1alpha2.ResourceClaimSpec {
+	gvk := params.GroupVersionKind()
+	return resourcev1alpha2.ResourceClaimSpec{
+		ResourceClassName: resourceClassName(pool),
+		ParametersRef: &resourcev1alpha2.ResourceClaimParametersReference{
+			APIGroup: gvk.Group,
+			Kind:     gvk.Kind,
+			Name:     params.GetName(),
+		},
+	}
+}
+
+// claimCount returns the number of claims of each replica: one for its whole
+// GPUs, or one per MIG device
+func claimCount(pool *neuronetes.AgentPool) int {
+	if pool.Spec.MIGProfile == "" {
+		return 1
+	}
+	_, count := gpuRequest(pool)
+	return int(count)
+}
+
+// sharedClaimName returns the name of the i-th ResourceClaim shared by the
+// replicas of pool
+func sharedClaimName(pool *neuronetes.AgentPool, i int) string {
+	return fmt.Sprintf("%s-gpu-%d", pool.Name, i)
+}
+
+// podClaims returns the claims a replica of pool references: the claims all
+// replicas share, or claims generated per replica from the pool's template
+func podClaims(pool *neuronetes.AgentPool) []corev1.PodResourceClaim {
+	shared := pool.Spec.GPURequirements.DRA.Sharing != ""
+	template := pool.Name + "-gpu"
+
+	claims := make([]corev1.PodResourceClaim, claimCount(pool))
+	for i := range claims {
+		claims[i].Name = fmt.Sprintf("%s-%d", gpuClaimName, i)
+		if shared {
+			name := sharedClaimName(pool, i)
+			claims[i].Source.ResourceClaimName = &name
+		} else {
+			claims[i].Source.ResourceClaimTemplateName = &template
+		}
+	}
+	return claims
+}
+
+// reconcileGPUClaims creates the ResourceClass, claim parameters and the
+// shared ResourceClaims or the ResourceClaimTemplate replicas of pool claim
+// their GPUs with. Claim specs are immutable: a changed template is
+// replaced, while shared claims, which running replicas hold, are kept.
+func (r *AgentPoolReconciler) reconcileGPUClaims(ctx context.Context, pool *neuronetes.AgentPool) error {
+	if !usesDRA(pool) {
+		return nil
+	}
+	if err := r.ensureResourceClass(ctx, resourceClassName(pool)); err != nil {
+		return err
+	}
+
+	params := claimParameters(pool)
+	desired := params.Object["spec"]
+	if _, err := controllerutil.CreateOrUpdate(ctx, r.Client, params, func() error {
+		params.Object["spec"] = desired
+		return ctrl.SetControllerReference(pool, params, r.Scheme)
+	}); err != nil {
+		return fmt.Errorf("failed to reconcile claim parameters %s: %w", params.GetName(), err)
+	}
+	spec := claimSpec(pool, params)
+
+	if pool.Spec.GPURequirements.DRA.Sharing != "" {
+		for i := 0; i < claimCount(pool); i++ {
+			claim := &resourcev1alpha2.ResourceClaim{
+				ObjectMeta: metav1.ObjectMeta{Name: sharedClaimName(pool, i), Namespace: pool.Namespace},
+				Spec:       spec,
+			}
+			if err := r.createOwned(ctx, pool, claim); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	template := &resourcev1alpha2.ResourceClaimTemplate{
+		ObjectMeta: metav1.ObjectMeta{Name: pool.Name + "-gpu", Namespace: pool.Namespace},
+		Spec:       resourcev1alpha2.ResourceClaimTemplateSpec{Spec: spec},
+	}
+	var existing resourcev1alpha2.ResourceClaimTemplate
+	err := r.Get(ctx, types.NamespacedName{Namespace: template.Namespace, Name: template.Name}, &existing)
+	switch {
+	case apierrors.IsNotFound(err):
+	case err != nil:
+		return fmt.Errorf("failed to get resource claim template %s: %w", template.Name, err)
+	case apiequality.Semantic.DeepEqual(existing.Spec, template.Spec):
+		return nil
+	default:
+		if err := r.Delete(ctx, &existing); err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to replace resource claim template %s: %w", template.Name, err)
+		}
+	}
+	return r.createOwned(ctx, pool, template)
+}
+
+// createOwned creates obj owned by pool unless it exists
+func (r *AgentPoolReconciler) createOwned(ctx context.Context, pool *neuronetes.AgentPool, obj client.Object) error {
+	if err := ctrl.SetControllerReference(pool, obj, r.Scheme); err != nil {
+		return err
+	}
+	if err := r.Create(ctx, obj); err != nil && !apierrors.IsAlreadyExists(err) {
+		return fmt.Errorf("failed to create %T %s: %w", obj, obj.GetName(), err)
+	}
+	return nil
+}
+
+// ensureResourceClass creates the ResourceClass name for the NVIDIA DRA
+// driver if it does not exist. Like PriorityClasses it is cluster-scoped and
+// shared, so it is not owned by the pool.
+func (r *AgentPoolReconciler) ensureResourceClass(ctx context.Context, name string) error {
+	var existing resourcev1alpha2.ResourceClass
+	err := r.Get(ctx, types.NamespacedName{Name: name}, &existing)
+	if err == nil {
+		return nil
+	}
+	if !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to get resource class %s: %w", name, err)
+	}
+
+	class := &resourcev1alpha2.ResourceClass{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		DriverName: draDriverName,
+	}
+	if err := r.Create(ctx, class); err != nil && !apierrors.IsAlreadyExists(err) {
+		return fmt.Errorf("failed to create resource class %s: %w", name, err)
+	}
+	log.FromContext(ctx).Info("Created resource class", "resourceClass", name, "driver", draDriverName)
+	return nil
+}
@@ -0,0 +1,174 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/autoscaler"
+)
+
+// DefaultDrainTimeout bounds how long a replica drains when the pool does
+// not set sessionAffinity.drainTimeout
+const DefaultDrainTimeout = 5 * time.Minute
+
+// drainedDeletionCost makes the Deployment remove replicas that finished
+// draining before any other when it shrinks
+const drainedDeletionCost = "-1"
+
+func (r *AgentPoolReconciler) now() time.Time {
+	if r.clock != nil {
+		return r.clock()
+	}
+	return time.Now()
+}
+
+// startDrain marks a serving pod as draining. The router keeps its sticky
+// sessions on it but sends it no new ones.
+func (r *AgentPoolReconciler) startDrain(ctx context.Context, pod *corev1.Pod, now time.Time) error {
+	patch := client.MergeFrom(pod.DeepCopy())
+	if pod.Annotations == nil {
+		pod.Annotations = map[string]string{}
+	}
+	pod.Labels[neuronetes.LabelRole] = neuronetes.RoleDraining
+	pod.Annotations[neuronetes.AnnotationDrainStarted] = now.UTC().Format(time.RFC3339)
+	if err := r.Patch(ctx, pod, patch); err != nil {
+		return fmt.Errorf("failed to drain pod %s: %w", pod.Name, err)
+	}
+	return nil
+}
+
+// markDrained gives pods that finished draining the lowest deletion cost, so
+// the ReplicaSet removes them first when the Deployment shrinks
+func (r *AgentPoolReconciler) markDrained(ctx context.Context, pods []*corev1.Pod) error {
+	for _, pod := range pods {
+		if pod.Annotations[podDeletionCost] == drainedDeletionCost {
+			continue
+		}
+		patch := client.MergeFrom(pod.DeepCopy())
+		if pod.Annotations == nil {
+			pod.Annotations = map[string]string{}
+		}
+		pod.Annotations[podDeletionCost] = drainedDeletionCost
+		if err := r.Patch(ctx, pod, patch); err != nil {
+			return fmt.Errorf("failed to mark pod %s drained: %w", pod.Name, err)
+		}
+	}
+	return nil
+}
+
+// removeDrained deletes pods that finished draining. The Deployment has
+// already been shrunk to exclude them, so they are not replaced.
+func (r *AgentPoolReconciler) removeDrained(ctx context.Context, pods []*corev1.Pod) error {
+	for _, pod := range pods {
+		if err := r.Delete(ctx, pod); client.IgnoreNotFound(err) != nil {
+			return fmt.Errorf("failed to remove drained pod %s: %w", pod.Name, err)
+		}
+	}
+	return nil
+}
+
+// splitDrained separates draining pods that are done from those still
+// serving sessions
+func splitDrained(pool *neuronetes.AgentPool, pods []*corev1.Pod, now time.Time) (drained, draining []*corev1.Pod) {
+	for _, pod := range pods {
+		if drainComplete(pool, pod, now) {
+			drained = append(drained, pod)
+		} else {
+			draining = append(draining, pod)
+		}
+	}
+	return drained, draining
+}
+
+// drainComplete reports whether a draining pod can be removed: it has no
+// sessions left, or, when it does not report sessions, the session TTL has
+// passed since it started draining. The drain timeout bounds either wait.
+func drainComplete(pool *neuronetes.AgentPool, pod *corev1.Pod, now time.Time) bool {
+	if !isPodReady(pod) {
+		return true
+	}
+	sessions, reported := activeSessions(pod)
+	if reported && sessions == 0 {
+		return true
+	}
+
+	started, err := time.Parse(time.RFC3339, pod.Annotations[neuronetes.AnnotationDrainStarted])
+	if err != nil {
+		// Without a start time the drain could never time out
+		return true
+	}
+	elapsed := now.Sub(started)
+
+	ttl, timeout := drainWindows(pool)
+	if !reported && elapsed >= ttl {
+		return true
+	}
+	return elapsed >= timeout
+}
+
+// drainWindows returns the session TTL, which is zero without session
+// affinity, and the drain timeout of pool
+func drainWindows(pool *neuronetes.AgentPool) (ttl, timeout time.Duration) {
+	timeout = DefaultDrainTimeout
+	affinity := pool.Spec.SessionAffinity
+	if affinity == nil {
+		return 0, timeout
+	}
+	if affinity.DrainTimeout != nil {
+		timeout = affinity.DrainTimeout.Duration
+	}
+	if affinity.Enabled && affinity.TTL != nil {
+		ttl = affinity.TTL.Duration
+	}
+	return ttl, timeout
+}
+
+// activeSessions returns the session count a pod reports, if any
+func activeSessions(pod *corev1.Pod) (int, bool) {
+	value, ok := pod.Annotations[neuronetes.AnnotationActiveSessions]
+	if !ok {
+		return 0, false
+	}
+	sessions, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, false
+	}
+	return sessions, true
+}
+
+// drainVictims returns the count serving pods scale-down removes, chosen by
+// autoscaler.SelectScaleDownVictims from the sessions they report
+func drainVictims(pods []*corev1.Pod, count int) []*corev1.Pod {
+	candidates := make([]autoscaler.ScaleDownCandidate, len(pods))
+	byName := make(map[string]*corev1.Pod, len(pods))
+	for i, pod := range pods {
+		sessions, _ := activeSessions(pod)
+		candidates[i] = autoscaler.ScaleDownCandidate{
+			Name:     pod.Name,
+			Sessions: sessions,
+			Ready:    isPodReady(pod),
+			Created:  pod.CreationTimestamp.Time,
+		}
+		byName[pod.Name] = pod
+	}
+	victims := make([]*corev1.Pod, 0, count)
+	for _, name := range autoscaler.SelectScaleDownVictims(candidates, count) {
+		victims = append(victims, byName[name])
+	}
+	return victims
+}
+
+func removePod(pods []*corev1.Pod, pod *corev1.Pod) []*corev1.Pod {
+	for i, p := range pods {
+		if p == pod {
+			return append(pods[:i], pods[i+1:]...)
+		}
+	}
+	return pods
+}
@@ -0,0 +1,39 @@
+package controllers
+
+import (
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/sharding"
+)
+
+// gangSize returns how many replicas of a pool serving model have to be
+// scheduled together: the shard count of a tensor-parallel model, otherwise
+// one. Pipeline- and data-parallel shards can start independently.
+func gangSize(model *neuronetes.Model) int32 {
+	if model == nil || model.Spec.ShardSpec == nil {
+		return 1
+	}
+	shards := model.Spec.ShardSpec
+	if shards.Strategy != sharding.StrategyTensorParallel || shards.Count < 1 {
+		return 1
+	}
+	return shards.Count
+}
+
+// shardingSpec returns how the replicas of a pool serving model shard it,
+// or false unless each replica serves a tensor- or pipeline-parallel shard.
+// The layers are those read from the weights unless the spec sets them.
+// The scheduler plans the shard of each replica by it, and replicas read
+// their plan from their environment.
+func shardingSpec(model *neuronetes.Model) (sharding.Spec, bool) {
+	if model == nil || model.Spec.ShardSpec == nil {
+		return sharding.Spec{}, false
+	}
+	spec := sharding.Spec{
+		Strategy: model.Spec.ShardSpec.Strategy,
+		Shards:   int(model.Spec.ShardSpec.Count),
+	}
+	if serving := defaultedSpec(model).Serving; serving != nil {
+		spec.Layers = int(serving.NumLayers)
+	}
+	return spec, spec.Sharded()
+}
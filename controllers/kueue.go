@@ -0,0 +1,218 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+)
+
+const (
+	// ConditionQuotaAdmitted reports whether Kueue admitted all replicas of a
+	// pool with a queue
+	ConditionQuotaAdmitted = "QuotaAdmitted"
+
+	// annotationQueueRequest records the GPUs a replica Workload requests,
+	// so Workloads are replaced when the pool's request changes
+	annotationQueueRequest = "neuronetes.io/queue-request"
+
+	// workloadPodSet names the single pod set of a replica Workload
+	workloadPodSet = "main"
+
+	// workloadPriorityClassSource marks a Workload's priority class as a
+	// PriorityClass rather than a WorkloadPriorityClass
+	workloadPriorityClassSource = "scheduling.k8s.io/priorityclass"
+)
+
+// workloadGVK is the Kueue Workload kind
+var workloadGVK = schema.GroupVersionKind{Group: "kueue.x-k8s.io", Version: "v1beta1", Kind: "Workload"}
+
+// +kubebuilder:rbac:groups=kueue.x-k8s.io,resources=workloads,verbs=get;list;watch;create;delete
+
+// reconcileQueueWorkloads submits a Kueue Workload for each of the total
+// replicas of a pool with a queue and returns how many of them may run:
+// those whose Workload is admitted, rounded down to whole gangs. Workloads
+// are per replica so that scaling up queues only the new replicas, scaling
+// down releases quota at once, and Kueue can preempt single replicas.
+// Without a queue it returns total, after removing Workloads left by an
+// earlier queue.
+func (r *AgentPoolReconciler) reconcileQueueWorkloads(ctx context.Context, pool *neuronetes.AgentPool, total, gang int32) (int32, error) {
+	queue := queueName(pool)
+	existing, err := r.queueWorkloads(ctx, pool)
+	if err != nil {
+		if queue == "" && meta.IsNoMatchError(err) {
+			// Kueue is not installed
+			return total, nil
+		}
+		return 0, err
+	}
+
+	request := queueRequest(pool)
+	var admitted int32
+	for i := int32(0); i < total && queue != ""; i++ {
+		name := workloadName(pool, i)
+		workload, ok := existing[name]
+		delete(existing, name)
+		if ok && workload.GetAnnotations()[annotationQueueRequest] != request {
+			if err := r.deleteWorkload(ctx, workload); err != nil {
+				return 0, err
+			}
+			ok = false
+		}
+		if !ok {
+			if err := r.createOwned(ctx, pool, r.replicaWorkload(pool, name, request)); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		if workloadAdmitted(workload) {
+			admitted++
+		}
+	}
+	// Release the quota of replicas scaled away
+	for _, workload := range existing {
+		if err := r.deleteWorkload(ctx, workload); err != nil {
+			return 0, err
+		}
+	}
+
+	if queue == "" {
+		meta.RemoveStatusCondition(&pool.Status.Conditions, ConditionQuotaAdmitted)
+		return total, nil
+	}
+	if gang > 1 {
+		admitted -= admitted % gang
+	}
+	condition := metav1.Condition{
+		Type:               ConditionQuotaAdmitted,
+		Status:             metav1.ConditionTrue,
+		Reason:             "QuotaReserved",
+		Message:            fmt.Sprintf("%d/%d replicas admitted by LocalQueue %s", admitted, total, queue),
+		ObservedGeneration: pool.Generation,
+	}
+	if admitted < total {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "PendingAdmission"
+		log.FromContext(ctx).Info("Waiting for queue admission", "queue", queue, "admitted", admitted, "replicas", total)
+	}
+	meta.SetStatusCondition(&pool.Status.Conditions, condition)
+	return admitted, nil
+}
+
+// queueName returns the Kueue LocalQueue of pool, if any
+func queueName(pool *neuronetes.AgentPool) string {
+	if pool.Spec.Scheduling == nil {
+		return ""
+	}
+	return pool.Spec.Scheduling.QueueName
+}
+
+// workloadName names the Workload of the i-th replica of pool
+func workloadName(pool *neuronetes.AgentPool, i int32) string {
+	return fmt.Sprintf("%s-replica-%d", pool.Name, i)
+}
+
+// queueRequest returns the GPUs a replica of pool is admitted for, as
+// name=count
+func queueRequest(pool *neuronetes.AgentPool) string {
+	name, count := gpuRequest(pool)
+	return fmt.Sprintf("%s=%d", name, count)
+}
+
+// queueWorkloads returns the Workloads of pool by name
+func (r *AgentPoolReconciler) queueWorkloads(ctx context.Context, pool *neuronetes.AgentPool) (map[string]*unstructured.Unstructured, error) {
+	list := &unstructured.UnstructuredList{}
+	list.SetGroupVersionKind(workloadGVK.GroupVersion().WithKind(workloadGVK.Kind + "List"))
+	if err := r.List(ctx, list, client.InNamespace(pool.Namespace), client.MatchingLabels{neuronetes.LabelPool: pool.Name}); err != nil {
+		return nil, fmt.Errorf("failed to list workloads: %w", err)
+	}
+	workloads := make(map[string]*unstructured.Unstructured, len(list.Items))
+	for i := range list.Items {
+		if metav1.IsControlledBy(&list.Items[i], pool) {
+			workloads[list.Items[i].GetName()] = &list.Items[i]
+		}
+	}
+	return workloads, nil
+}
+
+// replicaWorkload returns the Workload admitting one replica of pool. Its
+// pod set requests the replica's GPUs, so ClusterQueue quotas are charged
+// for them even when they are claimed through DRA, with the pool's node
+// selector and priority.
+func (r *AgentPoolReconciler) replicaWorkload(pool *neuronetes.AgentPool, name, request string) *unstructured.Unstructured {
+	workload := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	workload.SetGroupVersionKind(workloadGVK)
+	workload.SetName(name)
+	workload.SetNamespace(pool.Namespace)
+	workload.SetLabels(map[string]string{neuronetes.LabelPool: pool.Name})
+	workload.SetAnnotations(map[string]string{annotationQueueRequest: request})
+
+	container := map[string]interface{}{
+		"name":  agentComponent,
+		"image": r.image(pool),
+	}
+	if resourceName, count := gpuRequest(pool); count > 0 {
+		container["resources"] = map[string]interface{}{
+			"requests": map[string]interface{}{
+				string(resourceName): resource.NewQuantity(count, resource.DecimalSI).String(),
+			},
+		}
+	}
+	podSpec := map[string]interface{}{
+		"containers":    []interface{}{container},
+		"restartPolicy": string(corev1.RestartPolicyAlways),
+	}
+	if pool.Spec.Scheduling != nil && len(pool.Spec.Scheduling.NodeSelector) > 0 {
+		selector := map[string]interface{}{}
+		for k, v := range pool.Spec.Scheduling.NodeSelector {
+			selector[k] = v
+		}
+		podSpec["nodeSelector"] = selector
+	}
+
+	spec := map[string]interface{}{
+		"queueName": queueName(pool),
+		"podSets": []interface{}{map[string]interface{}{
+			"name":     workloadPodSet,
+			"count":    int64(1),
+			"template": map[string]interface{}{"spec": podSpec},
+		}},
+	}
+	if priorityClass := priorityClassName(pool); priorityClass != "" {
+		spec["priorityClassName"] = priorityClass
+		spec["priorityClassSource"] = workloadPriorityClassSource
+	}
+	workload.Object["spec"] = spec
+	return workload
+}
+
+// workloadAdmitted reports whether Kueue admitted workload. Evicted
+// Workloads lose their admission until they are admitted again.
+func workloadAdmitted(workload *unstructured.Unstructured) bool {
+	conditions, _, _ := unstructured.NestedSlice(workload.Object, "status", "conditions")
+	for _, c := range conditions {
+		condition, ok := c.(map[string]interface{})
+		if ok && condition["type"] == "Admitted" {
+			return condition["status"] == string(metav1.ConditionTrue)
+		}
+	}
+	return false
+}
+
+// deleteWorkload deletes a replica Workload, releasing its quota
+func (r *AgentPoolReconciler) deleteWorkload(ctx context.Context, workload *unstructured.Unstructured) error {
+	if err := r.Delete(ctx, workload); err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete workload %s: %w", workload.GetName(), err)
+	}
+	return nil
+}
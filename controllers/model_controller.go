@@ -2,8 +2,12 @@ package controllers
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"time"
 
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	ctrl "sigs.k8s.io/controller-runtime"
@@ -11,17 +15,51 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/log"
 
 	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/capacity"
+	"github.com/bowenislandsong/neuronetes/pkg/downloader"
+	"github.com/bowenislandsong/neuronetes/pkg/introspection"
+	"github.com/bowenislandsong/neuronetes/pkg/metrics"
+	"github.com/bowenislandsong/neuronetes/pkg/modelcache"
+	"github.com/bowenislandsong/neuronetes/pkg/plugins"
 )
 
+// ConditionProgressing reports whether a model load is in progress
+const ConditionProgressing = "Progressing"
+
+// ConditionWeightsVerified reports whether the weights of a model match
+// spec.integrity
+const ConditionWeightsVerified = "WeightsVerified"
+
 // ModelReconciler reconciles a Model object
 type ModelReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
+
+	// Plugins supplies model loader plugins. Without a loader able to load
+	// the model, loading completes immediately.
+	Plugins *plugins.PluginRegistry
+
+	// Downloader downloads the weights of models into CacheDir before
+	// they are loaded, for the weights URIs it supports. Without it,
+	// fetching weights is left to loader plugins.
+	Downloader *downloader.Downloader
+
+	// CacheDir is the directory of the model cache, usually a volume
+	// shared with the nodes serving the models. Weights are downloaded
+	// into <CacheDir>/<namespace>/<name>.
+	CacheDir string
+
+	// Metrics records the load times of models when set
+	Metrics *metrics.AgentMetrics
+
+	loads     loadTracker
+	downloads downloadTracker
 }
 
 // +kubebuilder:rbac:groups=neuronetes.io,resources=models,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=neuronetes.io,resources=models/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=neuronetes.io,resources=models/finalizers,verbs=update
+// +kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch
 
 // Reconcile is part of the main kubernetes reconciliation loop
 func (r *ModelReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
@@ -34,9 +72,14 @@ func (r *ModelReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
 
-	// Handle model lifecycle
-	if model.Status.Phase == "" {
-		model.Status.Phase = "Pending"
+	// Handle model lifecycle, reporting the GPU memory of a replica in
+	// every phase
+	estimate := capacity.Estimate(defaultedSpec(&model))
+	if model.Status.Phase == "" || !equality.Semantic.DeepEqual(estimate, model.Status.VRAM) {
+		if model.Status.Phase == "" {
+			model.Status.Phase = "Pending"
+		}
+		model.Status.VRAM = estimate
 		if err := r.Status().Update(ctx, &model); err != nil {
 			log.Error(err, "unable to update Model status")
 			return ctrl.Result{}, err
@@ -61,46 +104,331 @@ func (r *ModelReconciler) reconcilePending(ctx context.Context, model *neuronete
 	log := log.FromContext(ctx)
 	log.Info("Model in Pending state, initiating loading")
 
-	// Update status to Loading
+	if model.Spec.Integrity != nil && (r.Downloader == nil || !downloader.Supported(model.Spec.WeightsURI)) {
+		// Only downloaded weights can be verified, and unverified weights
+		// are never loaded
+		model.Status.Phase = "Failed"
+		meta.SetStatusCondition(&model.Status.Conditions, metav1.Condition{
+			Type:    ConditionWeightsVerified,
+			Status:  metav1.ConditionFalse,
+			Reason:  "Unverifiable",
+			Message: "spec.integrity requires the controller to download the weights, but the model cache is disabled or the weights URI is not supported",
+		})
+		if err := r.Status().Update(ctx, model); err != nil {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, nil
+	}
+
+	downloading, err := r.startDownload(ctx, model)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	reason, message := "Downloading", "downloading the weights"
+	if !downloading {
+		if _, err := r.startLoad(ctx, model); err != nil {
+			return ctrl.Result{}, err
+		}
+		reason, message = "Loading", "loading onto nodes"
+	}
+
+	// Update status to Loading. The transition of the Progressing
+	// condition marks when the load started.
 	model.Status.Phase = "Loading"
+	meta.SetStatusCondition(&model.Status.Conditions, metav1.Condition{
+		Type:    ConditionProgressing,
+		Status:  metav1.ConditionTrue,
+		Reason:  reason,
+		Message: message,
+	})
 	if err := r.Status().Update(ctx, model); err != nil {
 		return ctrl.Result{}, err
 	}
 
-	// TODO: Trigger model loading workflow
-	// - Download weights from weightsURI
-	// - Cache on appropriate nodes
-	// - Validate model format
-
 	return ctrl.Result{RequeueAfter: 10 * time.Second}, nil
 }
 
+// startLoad starts loading the model onto its preload nodes, if a loader
+// plugin can handle it. It returns false when there is nothing to track.
+func (r *ModelReconciler) startLoad(ctx context.Context, model *neuronetes.Model) (bool, error) {
+	loader := selectLoader(ctx, r.Plugins, model)
+	if loader == nil {
+		return false, nil
+	}
+	nodes, err := r.loadNodes(ctx, model)
+	if err != nil || len(nodes) == 0 {
+		return false, err
+	}
+
+	r.loads.start(client.ObjectKeyFromObject(model), model.DeepCopy(), loader, nodes)
+	return true, nil
+}
+
+// needsDownload returns true if the weights of model are to be downloaded
+// into the model cache and are not downloaded yet
+func (r *ModelReconciler) needsDownload(model *neuronetes.Model) bool {
+	if r.Downloader == nil || !downloader.Supported(model.Spec.WeightsURI) {
+		return false
+	}
+	return model.Status.Download == nil || model.Status.Download.CompletedAt == nil
+}
+
+// startDownload starts downloading the weights of model into the model
+// cache if they need to be. It returns false when there is nothing to
+// download.
+func (r *ModelReconciler) startDownload(ctx context.Context, model *neuronetes.Model) (bool, error) {
+	if !r.needsDownload(model) {
+		return false, nil
+	}
+	key := client.ObjectKeyFromObject(model)
+	req, err := modelcache.DownloadRequest(ctx, r, model, cachePath(r.CacheDir, key))
+	if err != nil {
+		return false, err
+	}
+	r.downloads.start(key, r.Downloader, req)
+	return true, nil
+}
+
 func (r *ModelReconciler) reconcileLoading(ctx context.Context, model *neuronetes.Model) (ctrl.Result, error) {
 	log := log.FromContext(ctx)
 	log.Info("Model in Loading state, checking progress")
 
-	// TODO: Check loading progress
-	// - Query cache controller
-	// - Verify weights loaded
-	// - Measure load time
+	key := client.ObjectKeyFromObject(model)
+	if r.needsDownload(model) {
+		download, ok := r.downloads.snapshot(key)
+		if !ok {
+			// The download was lost (e.g. controller restart); it resumes
+			// from the partial files in the cache
+			if _, err := r.startDownload(ctx, model); err != nil {
+				return ctrl.Result{}, err
+			}
+			download, _ = r.downloads.snapshot(key)
+		}
+		return r.reconcileDownloadProgress(ctx, model, download)
+	}
 
-	// Simulate loading completion
-	loadComplete := true // Replace with actual check
+	snap, ok := r.loads.snapshot(key)
+	if !ok {
+		// The load was lost (e.g. controller restart); it is restarted
+		if _, err := r.startLoad(ctx, model); err != nil {
+			return ctrl.Result{}, err
+		}
+		snap, ok = r.loads.snapshot(key)
+	}
+	if ok {
+		return r.reconcileLoadProgress(ctx, model, snap)
+	}
 
-	if loadComplete {
-		model.Status.Phase = "Ready"
-		loadTime := 30 * time.Second // Replace with actual measurement
-		model.Status.LoadTime = &metav1.Duration{Duration: loadTime}
+	// Nothing loads the model onto nodes, so it is ready once downloaded.
+	// No load took place, so none is recorded.
+	model.Status.Phase = "Ready"
+	meta.SetStatusCondition(&model.Status.Conditions, metav1.Condition{
+		Type:    ConditionProgressing,
+		Status:  metav1.ConditionFalse,
+		Reason:  "LoadComplete",
+		Message: "no loader plugin loads the model onto nodes",
+	})
+	if err := r.Status().Update(ctx, model); err != nil {
+		return ctrl.Result{}, err
+	}
+	log.Info("Model ready without loading onto nodes")
 
-		if err := r.Status().Update(ctx, model); err != nil {
+	return ctrl.Result{RequeueAfter: 5 * time.Second}, nil
+}
+
+// reconcileDownloadProgress surfaces the progress of the download of the
+// weights in status, and starts loading them onto nodes once downloaded
+func (r *ModelReconciler) reconcileDownloadProgress(ctx context.Context, model *neuronetes.Model, snap downloadSnapshot) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	model.Status.Download = &neuronetes.DownloadStatus{
+		Path:            snap.path,
+		Revision:        snap.result.Revision,
+		Source:          snap.result.Source,
+		BytesTotal:      snap.total,
+		BytesDownloaded: snap.done,
+		ProgressPercent: snap.percent(),
+	}
+
+	key := client.ObjectKeyFromObject(model)
+	switch {
+	case snap.finished && snap.err != nil:
+		model.Status.Phase = "Failed"
+		reason := "DownloadFailed"
+		if errors.Is(snap.err, downloader.ErrIntegrity) {
+			reason = "IntegrityCheckFailed"
+			meta.SetStatusCondition(&model.Status.Conditions, metav1.Condition{
+				Type:    ConditionWeightsVerified,
+				Status:  metav1.ConditionFalse,
+				Reason:  reason,
+				Message: snap.err.Error(),
+			})
+		}
+		meta.SetStatusCondition(&model.Status.Conditions, metav1.Condition{
+			Type:    ConditionProgressing,
+			Status:  metav1.ConditionFalse,
+			Reason:  reason,
+			Message: snap.err.Error(),
+		})
+		r.downloads.forget(key)
+		log.Error(snap.err, "Model download failed")
+	case snap.finished:
+		now := metav1.Now()
+		model.Status.Download.Files = int32(snap.result.Files)
+		model.Status.Download.CompletedAt = &now
+		r.downloads.forget(key)
+		log.Info("Model downloaded", "files", snap.result.Files, "bytes", snap.result.Size, "downloaded", snap.result.Downloaded, "source", snap.result.Source)
+		if metadata, err := introspection.Inspect(snap.path); err != nil {
+			log.Info("Unable to read the metadata of the weights", "path", snap.path, "reason", err.Error())
+		} else {
+			model.Status.Metadata = modelMetadata(metadata)
+			model.Status.VRAM = capacity.Estimate(defaultedSpec(model))
+		}
+		if integrity := model.Spec.Integrity; integrity != nil {
+			message := fmt.Sprintf("the SHA-256 digests of %d files match", snap.result.Files)
+			if integrity.Signature != nil {
+				message += " and are signed by the public key"
+			}
+			meta.SetStatusCondition(&model.Status.Conditions, metav1.Condition{
+				Type:    ConditionWeightsVerified,
+				Status:  metav1.ConditionTrue,
+				Reason:  "Verified",
+				Message: message,
+			})
+		}
+
+		loading, err := r.startLoad(ctx, model)
+		if err != nil {
 			return ctrl.Result{}, err
 		}
-		log.Info("Model loaded successfully")
+		if loading {
+			meta.SetStatusCondition(&model.Status.Conditions, metav1.Condition{
+				Type:    ConditionProgressing,
+				Status:  metav1.ConditionTrue,
+				Reason:  "Loading",
+				Message: fmt.Sprintf("downloaded %d files, loading onto nodes", snap.result.Files),
+			})
+			break
+		}
+		model.Status.Phase = "Ready"
+		r.recordLoad(ctx, model, loadStarted(model, snap.started), snap.result.Downloaded == 0)
+		meta.SetStatusCondition(&model.Status.Conditions, metav1.Condition{
+			Type:    ConditionProgressing,
+			Status:  metav1.ConditionFalse,
+			Reason:  "LoadComplete",
+			Message: fmt.Sprintf("downloaded %d files from %s to %s", snap.result.Files, snap.result.Source, snap.path),
+		})
+	default:
+		meta.SetStatusCondition(&model.Status.Conditions, metav1.Condition{
+			Type:    ConditionProgressing,
+			Status:  metav1.ConditionTrue,
+			Reason:  "Downloading",
+			Message: fmt.Sprintf("%d%% downloaded, %d of %d bytes", snap.percent(), snap.done, snap.total),
+		})
+	}
+
+	if err := r.Status().Update(ctx, model); err != nil {
+		return ctrl.Result{}, err
 	}
 
 	return ctrl.Result{RequeueAfter: 5 * time.Second}, nil
 }
 
+// reconcileLoadProgress surfaces per-node progress in status and completes
+// or fails the load once every node has finished
+func (r *ModelReconciler) reconcileLoadProgress(ctx context.Context, model *neuronetes.Model, snap loadSnapshot) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	now := metav1.Now()
+	var total int64
+	var done, failed int
+
+	cached := make([]neuronetes.NodeCacheStatus, 0, len(snap.nodes))
+	for _, node := range snap.sortedNodes() {
+		nl := snap.nodes[node]
+		percent := nl.percent
+		status := neuronetes.NodeCacheStatus{
+			NodeName:        node,
+			Status:          "loading",
+			ProgressPercent: &percent,
+		}
+		switch {
+		case nl.done && nl.err != nil:
+			status.Status = "failed"
+			failed++
+		case nl.done:
+			status.Status = "ready"
+			status.CachedAt = &now
+			done++
+		}
+		total += int64(percent)
+		cached = append(cached, status)
+	}
+	model.Status.CachedNodes = cached
+	aggregate := total / int64(len(cached))
+
+	key := client.ObjectKeyFromObject(model)
+	switch {
+	case failed > 0:
+		model.Status.Phase = "Failed"
+		meta.SetStatusCondition(&model.Status.Conditions, metav1.Condition{
+			Type:    ConditionProgressing,
+			Status:  metav1.ConditionFalse,
+			Reason:  "LoadFailed",
+			Message: fmt.Sprintf("load failed on %d of %d nodes", failed, len(cached)),
+		})
+		r.loads.forget(key)
+	case done == len(cached):
+		model.Status.Phase = "Ready"
+		// Weights downloaded first were loaded from the model cache
+		r.recordLoad(ctx, model, loadStarted(model, snap.started), model.Status.Download != nil)
+		meta.SetStatusCondition(&model.Status.Conditions, metav1.Condition{
+			Type:    ConditionProgressing,
+			Status:  metav1.ConditionFalse,
+			Reason:  "LoadComplete",
+			Message: fmt.Sprintf("loaded on %d nodes", len(cached)),
+		})
+		r.loads.forget(key)
+		log.Info("Model loaded successfully", "nodes", len(cached), "loadTime", model.Status.LoadTime.Duration)
+	default:
+		meta.SetStatusCondition(&model.Status.Conditions, metav1.Condition{
+			Type:    ConditionProgressing,
+			Status:  metav1.ConditionTrue,
+			Reason:  "Loading",
+			Message: fmt.Sprintf("%d%% loaded, %d of %d nodes ready", aggregate, done, len(cached)),
+		})
+	}
+
+	if err := r.Status().Update(ctx, model); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	return ctrl.Result{RequeueAfter: 5 * time.Second}, nil
+}
+
+// recordLoad records in status and metrics that model finished loading,
+// having started at started
+func (r *ModelReconciler) recordLoad(ctx context.Context, model *neuronetes.Model, started time.Time, fromCache bool) {
+	loadTime := time.Since(started)
+	model.Status.LoadTime = &metav1.Duration{Duration: loadTime}
+	if r.Metrics != nil {
+		r.Metrics.RecordModelLoad(ctx, model.Name, loadTime, fromCache)
+	}
+}
+
+// loadStarted returns when the load of model started: when its Progressing
+// condition turned true, if that is before started. The in-memory
+// trackers of downloads and loads restart with the controller, and the
+// load of downloaded weights starts after their download.
+func loadStarted(model *neuronetes.Model, started time.Time) time.Time {
+	condition := meta.FindStatusCondition(model.Status.Conditions, ConditionProgressing)
+	// Condition times have a resolution of a second
+	if condition != nil && condition.Status == metav1.ConditionTrue && condition.LastTransitionTime.Time.Before(started.Truncate(time.Second)) {
+		return condition.LastTransitionTime.Time
+	}
+	return started
+}
+
 func (r *ModelReconciler) reconcileReady(ctx context.Context, model *neuronetes.Model) (ctrl.Result, error) {
 	log := log.FromContext(ctx)
 	log.Info("Model in Ready state, monitoring")
@@ -0,0 +1,459 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/downloader"
+	"github.com/bowenislandsong/neuronetes/pkg/metrics"
+	"github.com/bowenislandsong/neuronetes/pkg/plugins"
+)
+
+func testScheme(t *testing.T) *runtime.Scheme {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	require.NoError(t, neuronetes.AddToScheme(scheme))
+	return scheme
+}
+
+func newFakeClient(t *testing.T, objs ...client.Object) client.Client {
+	return fake.NewClientBuilder().
+		WithScheme(testScheme(t)).
+		WithObjects(objs...).
+		WithStatusSubresource(&neuronetes.Model{}, &neuronetes.AgentPool{}, &neuronetes.AgentClass{}, &neuronetes.ToolBinding{}, &neuronetes.ModelRollout{}, &neuronetes.ModelQuantization{}, &neuronetes.Adapter{}, &neuronetes.ModelRegistry{}, &corev1.Pod{}).
+		Build()
+}
+
+// steppedLoader reports progress values sent on its channel and finishes
+// when the channel is closed
+type steppedLoader struct {
+	steps chan int32
+	err   error
+}
+
+func (l *steppedLoader) Name() string                                              { return "stepped" }
+func (l *steppedLoader) CanLoad(ctx context.Context, model *neuronetes.Model) bool { return true }
+func (l *steppedLoader) Priority() int                                             { return 1 }
+func (l *steppedLoader) Unload(ctx context.Context, model *neuronetes.Model, node string) error {
+	return nil
+}
+
+func (l *steppedLoader) Load(ctx context.Context, model *neuronetes.Model, node string, progress plugins.ProgressFunc) error {
+	for p := range l.steps {
+		progress(p)
+	}
+	return l.err
+}
+
+func newTestModel(nodes ...string) *neuronetes.Model {
+	return &neuronetes.Model{
+		ObjectMeta: metav1.ObjectMeta{Name: "llama-3-8b", Namespace: "default"},
+		Spec: neuronetes.ModelSpec{
+			WeightsURI:  "s3://models/llama-3-8b",
+			Size:        resource.MustParse("16Gi"),
+			CachePolicy: &neuronetes.CachePolicy{Priority: "high", PreloadNodes: nodes},
+		},
+	}
+}
+
+func reconcileModel(t *testing.T, r *ModelReconciler, key types.NamespacedName) *neuronetes.Model {
+	t.Helper()
+	_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: key})
+	require.NoError(t, err)
+	var model neuronetes.Model
+	require.NoError(t, r.Get(context.Background(), key, &model))
+	return &model
+}
+
+func TestModelReconcilerReportsLoadProgress(t *testing.T) {
+	loader := &steppedLoader{steps: make(chan int32)}
+	registry := plugins.NewPluginRegistry()
+	registry.RegisterModelLoader(loader)
+
+	model := newTestModel("node-a")
+	key := client.ObjectKeyFromObject(model)
+	r := &ModelReconciler{Client: newFakeClient(t, model), Plugins: registry}
+
+	got := reconcileModel(t, r, key)
+	require.Equal(t, "Loading", got.Status.Phase)
+
+	var observed []int32
+	for _, step := range []int32{10, 45, 80, 100} {
+		loader.steps <- step
+		require.Eventually(t, func() bool {
+			got = reconcileModel(t, r, key)
+			return len(got.Status.CachedNodes) == 1 &&
+				*got.Status.CachedNodes[0].ProgressPercent == step
+		}, time.Second, 5*time.Millisecond)
+
+		observed = append(observed, *got.Status.CachedNodes[0].ProgressPercent)
+		assert.Equal(t, "loading", got.Status.CachedNodes[0].Status)
+		assert.Equal(t, "Loading", got.Status.Phase)
+
+		cond := meta.FindStatusCondition(got.Status.Conditions, ConditionProgressing)
+		require.NotNil(t, cond)
+		assert.Equal(t, metav1.ConditionTrue, cond.Status)
+	}
+	assert.Equal(t, []int32{10, 45, 80, 100}, observed)
+
+	close(loader.steps)
+	require.Eventually(t, func() bool {
+		got = reconcileModel(t, r, key)
+		return got.Status.Phase == "Ready"
+	}, time.Second, 5*time.Millisecond)
+
+	assert.Equal(t, "ready", got.Status.CachedNodes[0].Status)
+	assert.Equal(t, int32(100), *got.Status.CachedNodes[0].ProgressPercent)
+	assert.NotNil(t, got.Status.CachedNodes[0].CachedAt)
+	assert.NotNil(t, got.Status.LoadTime)
+	cond := meta.FindStatusCondition(got.Status.Conditions, ConditionProgressing)
+	require.NotNil(t, cond)
+	assert.Equal(t, "LoadComplete", cond.Reason)
+}
+
+func TestModelReconcilerLoadFailure(t *testing.T) {
+	loader := &steppedLoader{steps: make(chan int32), err: errors.New("disk full")}
+	close(loader.steps)
+	registry := plugins.NewPluginRegistry()
+	registry.RegisterModelLoader(loader)
+
+	model := newTestModel("node-a", "node-b")
+	key := client.ObjectKeyFromObject(model)
+	r := &ModelReconciler{Client: newFakeClient(t, model), Plugins: registry}
+
+	reconcileModel(t, r, key)
+	var got *neuronetes.Model
+	require.Eventually(t, func() bool {
+		got = reconcileModel(t, r, key)
+		return got.Status.Phase == "Failed"
+	}, time.Second, 5*time.Millisecond)
+
+	cond := meta.FindStatusCondition(got.Status.Conditions, ConditionProgressing)
+	require.NotNil(t, cond)
+	assert.Equal(t, "LoadFailed", cond.Reason)
+}
+
+func TestModelReconcilerWithoutLoaderCompletes(t *testing.T) {
+	model := newTestModel()
+	key := client.ObjectKeyFromObject(model)
+	r := &ModelReconciler{Client: newFakeClient(t, model), Metrics: metrics.NewAgentMetrics(prometheus.NewRegistry())}
+
+	reconcileModel(t, r, key)
+	got := reconcileModel(t, r, key)
+	assert.Equal(t, "Ready", got.Status.Phase)
+
+	// Nothing was downloaded or loaded, so no load is recorded
+	assert.Nil(t, got.Status.LoadTime)
+	var loadTimes dto.Metric
+	require.NoError(t, r.Metrics.ModelLoadTime.WithLabelValues("llama-3-8b").(prometheus.Histogram).Write(&loadTimes))
+	assert.Zero(t, loadTimes.GetHistogram().GetSampleCount())
+}
+
+func TestModelReconcilerRecordsLoadTime(t *testing.T) {
+	loader := &steppedLoader{steps: make(chan int32)}
+	close(loader.steps)
+	registry := plugins.NewPluginRegistry()
+	registry.RegisterModelLoader(loader)
+
+	// A load that started before the controller restarted
+	model := newTestModel("node-a")
+	model.Status.Phase = "Loading"
+	model.Status.Conditions = []metav1.Condition{{
+		Type:               ConditionProgressing,
+		Status:             metav1.ConditionTrue,
+		Reason:             "Loading",
+		LastTransitionTime: metav1.NewTime(time.Now().Add(-90 * time.Second)),
+	}}
+	key := client.ObjectKeyFromObject(model)
+	r := &ModelReconciler{Client: newFakeClient(t, model), Plugins: registry, Metrics: metrics.NewAgentMetrics(prometheus.NewRegistry())}
+
+	var got *neuronetes.Model
+	require.Eventually(t, func() bool {
+		got = reconcileModel(t, r, key)
+		return got.Status.Phase == "Ready"
+	}, time.Second, 5*time.Millisecond)
+	require.NotNil(t, got.Status.LoadTime)
+	assert.GreaterOrEqual(t, got.Status.LoadTime.Duration, 89*time.Second)
+	assert.Less(t, got.Status.LoadTime.Duration, 2*time.Minute)
+	cond := meta.FindStatusCondition(got.Status.Conditions, ConditionProgressing)
+	require.NotNil(t, cond)
+	assert.Equal(t, "LoadComplete", cond.Reason)
+
+	var loadTimes dto.Metric
+	require.NoError(t, r.Metrics.ModelLoadTime.WithLabelValues("llama-3-8b").(prometheus.Histogram).Write(&loadTimes))
+	assert.Equal(t, uint64(1), loadTimes.GetHistogram().GetSampleCount())
+	assert.InDelta(t, got.Status.LoadTime.Seconds(), loadTimes.GetHistogram().GetSampleSum(), 0.01)
+}
+
+func TestModelReconcilerDownloadsWeights(t *testing.T) {
+	weights := bytes.Repeat([]byte("weights"), 1000)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "model.gguf", time.Time{}, bytes.NewReader(weights))
+	}))
+	defer server.Close()
+
+	loader := &steppedLoader{steps: make(chan int32)}
+	close(loader.steps)
+	registry := plugins.NewPluginRegistry()
+	registry.RegisterModelLoader(loader)
+
+	model := newTestModel("node-a")
+	model.Spec.WeightsURI = server.URL + "/llama-3-8b/model.gguf"
+	key := client.ObjectKeyFromObject(model)
+	cacheDir := t.TempDir()
+	r := &ModelReconciler{
+		Client:     newFakeClient(t, model),
+		Plugins:    registry,
+		Downloader: downloader.New(downloader.Options{ChunkSize: 1024}),
+		CacheDir:   cacheDir,
+	}
+
+	got := reconcileModel(t, r, key)
+	require.Equal(t, "Loading", got.Status.Phase)
+	require.Eventually(t, func() bool {
+		got = reconcileModel(t, r, key)
+		return got.Status.Download != nil && got.Status.Download.CompletedAt != nil
+	}, 5*time.Second, 5*time.Millisecond)
+
+	path := filepath.Join(cacheDir, "default", "llama-3-8b")
+	assert.Equal(t, neuronetes.DownloadStatus{
+		Path:            path,
+		Source:          model.Spec.WeightsURI,
+		Files:           1,
+		BytesTotal:      7000,
+		BytesDownloaded: 7000,
+		ProgressPercent: 100,
+		CompletedAt:     got.Status.Download.CompletedAt,
+	}, *got.Status.Download)
+	data, err := os.ReadFile(filepath.Join(path, "model.gguf"))
+	require.NoError(t, err)
+	assert.Equal(t, weights, data)
+
+	// The downloaded weights are then loaded onto the preload nodes
+	require.Eventually(t, func() bool {
+		got = reconcileModel(t, r, key)
+		return got.Status.Phase == "Ready"
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, "ready", got.Status.CachedNodes[0].Status)
+}
+
+func TestModelReconcilerDownloadFailure(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	model := newTestModel()
+	model.Spec.WeightsURI = server.URL + "/missing.gguf"
+	key := client.ObjectKeyFromObject(model)
+	r := &ModelReconciler{
+		Client:     newFakeClient(t, model),
+		Downloader: downloader.New(downloader.Options{}),
+		CacheDir:   t.TempDir(),
+	}
+
+	reconcileModel(t, r, key)
+	var got *neuronetes.Model
+	require.Eventually(t, func() bool {
+		got = reconcileModel(t, r, key)
+		return got.Status.Phase == "Failed"
+	}, time.Second, 5*time.Millisecond)
+
+	cond := meta.FindStatusCondition(got.Status.Conditions, ConditionProgressing)
+	require.NotNil(t, cond)
+	assert.Equal(t, "DownloadFailed", cond.Reason)
+	assert.Contains(t, cond.Message, "unexpected status 404")
+}
+
+func TestModelReconcilerDownloadsWithCredentials(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer hf_secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		http.ServeContent(w, r, "model.gguf", time.Time{}, bytes.NewReader([]byte("weights")))
+	}))
+	defer server.Close()
+
+	model := newTestModel()
+	model.Spec.WeightsURI = server.URL + "/model.gguf"
+	model.Spec.CredentialsSecretRef = &corev1.SecretKeySelector{
+		LocalObjectReference: corev1.LocalObjectReference{Name: "weights-token"},
+		Key:                  "token",
+	}
+	key := client.ObjectKeyFromObject(model)
+	r := &ModelReconciler{
+		Client:     newFakeClient(t, model),
+		Downloader: downloader.New(downloader.Options{}),
+		CacheDir:   t.TempDir(),
+	}
+
+	// The model waits for its Secret
+	_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: key})
+	assert.ErrorContains(t, err, "failed to get credentials secret weights-token")
+
+	require.NoError(t, r.Create(context.Background(), &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "weights-token", Namespace: "default"},
+		Data:       map[string][]byte{"token": []byte("hf_secret\n")},
+	}))
+	var got *neuronetes.Model
+	require.Eventually(t, func() bool {
+		got = reconcileModel(t, r, key)
+		return got.Status.Phase == "Ready"
+	}, 5*time.Second, 5*time.Millisecond)
+	assert.Equal(t, int64(7), got.Status.Download.BytesTotal)
+}
+
+func TestModelReconcilerVerifiesWeights(t *testing.T) {
+	weights := []byte("weights")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "model.gguf", time.Time{}, bytes.NewReader(weights))
+	}))
+	defer server.Close()
+
+	digest := sha256.Sum256(weights)
+	for name, tc := range map[string]struct {
+		digest string
+		phase  string
+		status metav1.ConditionStatus
+		reason string
+	}{
+		"match":    {hex.EncodeToString(digest[:]), "Ready", metav1.ConditionTrue, "Verified"},
+		"mismatch": {strings.Repeat("0", 64), "Failed", metav1.ConditionFalse, "IntegrityCheckFailed"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			model := newTestModel()
+			model.Spec.WeightsURI = server.URL + "/model.gguf"
+			model.Spec.Integrity = &neuronetes.ModelIntegrity{SHA256: map[string]string{"model.gguf": tc.digest}}
+			key := client.ObjectKeyFromObject(model)
+			r := &ModelReconciler{
+				Client:     newFakeClient(t, model),
+				Downloader: downloader.New(downloader.Options{}),
+				CacheDir:   t.TempDir(),
+			}
+
+			reconcileModel(t, r, key)
+			var got *neuronetes.Model
+			require.Eventually(t, func() bool {
+				got = reconcileModel(t, r, key)
+				return got.Status.Phase == tc.phase
+			}, 5*time.Second, 5*time.Millisecond)
+			cond := meta.FindStatusCondition(got.Status.Conditions, ConditionWeightsVerified)
+			require.NotNil(t, cond)
+			assert.Equal(t, tc.status, cond.Status)
+			assert.Equal(t, tc.reason, cond.Reason)
+		})
+	}
+}
+
+func TestModelReconcilerRejectsUnverifiableWeights(t *testing.T) {
+	model := newTestModel()
+	model.Spec.Integrity = &neuronetes.ModelIntegrity{SHA256: map[string]string{"model.gguf": strings.Repeat("0", 64)}}
+	key := client.ObjectKeyFromObject(model)
+	r := &ModelReconciler{Client: newFakeClient(t, model)}
+
+	reconcileModel(t, r, key)
+	got := reconcileModel(t, r, key)
+	assert.Equal(t, "Failed", got.Status.Phase)
+	cond := meta.FindStatusCondition(got.Status.Conditions, ConditionWeightsVerified)
+	require.NotNil(t, cond)
+	assert.Equal(t, "Unverifiable", cond.Reason)
+}
+
+func TestModelReconcilerEstimatesVRAM(t *testing.T) {
+	model := newTestModel()
+	key := client.ObjectKeyFromObject(model)
+	r := &ModelReconciler{Client: newFakeClient(t, model)}
+
+	// 16Gi of weights plus 20% for the KV cache and activations
+	got := reconcileModel(t, r, key)
+	require.NotNil(t, got.Status.VRAM)
+	assert.Equal(t, "19661Mi", got.Status.VRAM.PerGPU.String())
+
+	// The estimate follows the parameters and serving settings, in any phase
+	got = reconcileModel(t, r, key)
+	require.Equal(t, "Ready", got.Status.Phase)
+	got.Spec.ParameterCount = "8B"
+	got.Spec.Serving = &neuronetes.ServingSpec{ContextLength: 8192, MaxSequences: 16, NumLayers: 32, NumKVHeads: 8, HeadDim: 128}
+	got.Spec.ShardSpec = &neuronetes.ShardSpec{Count: 2, Strategy: "tensor-parallel"}
+	require.NoError(t, r.Update(context.Background(), got))
+	got = reconcileModel(t, r, key)
+	assert.Equal(t, "Ready", got.Status.Phase)
+	assert.Equal(t, "16Gi", got.Status.VRAM.KVCache.String())
+	assert.Equal(t, "33169Mi", got.Status.VRAM.Total.String())
+	assert.Equal(t, int32(2), got.Status.VRAM.GPUs)
+	assert.Equal(t, "16585Mi", got.Status.VRAM.PerGPU.String())
+}
+
+func TestModelReconcilerReadsMetadata(t *testing.T) {
+	config := []byte(`{
+		"architectures": ["LlamaForCausalLM"],
+		"model_type": "llama",
+		"hidden_size": 4096,
+		"max_position_embeddings": 131072,
+		"num_attention_heads": 32,
+		"num_hidden_layers": 32,
+		"num_key_value_heads": 8
+	}`)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "config.json", time.Time{}, bytes.NewReader(config))
+	}))
+	defer server.Close()
+
+	model := newTestModel()
+	model.Spec.WeightsURI = server.URL + "/llama-3-8b/config.json"
+	model.Spec.ParameterCount = "8B"
+	key := client.ObjectKeyFromObject(model)
+	r := &ModelReconciler{
+		Client:     newFakeClient(t, model),
+		Downloader: downloader.New(downloader.Options{}),
+		CacheDir:   t.TempDir(),
+	}
+
+	// Without the layer dimensions, the KV cache is estimated from the
+	// parameter count
+	got := reconcileModel(t, r, key)
+	assert.Equal(t, "2450Mi", got.Status.VRAM.KVCache.String())
+	require.Eventually(t, func() bool {
+		got = reconcileModel(t, r, key)
+		return got.Status.Phase == "Ready"
+	}, 5*time.Second, 5*time.Millisecond)
+
+	// The metadata of the weights is reported, and defaults the dimensions
+	// the spec leaves unset without changing it
+	assert.Equal(t, &neuronetes.ModelMetadata{
+		Architecture:  "llama",
+		ContextLength: 131072,
+		NumLayers:     32,
+		NumKVHeads:    8,
+		HeadDim:       128,
+	}, got.Status.Metadata)
+	assert.Equal(t, "512Mi", got.Status.VRAM.KVCache.String())
+	assert.Nil(t, got.Spec.Serving)
+	assert.Empty(t, got.Spec.Architecture)
+}
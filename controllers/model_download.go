@@ -0,0 +1,124 @@
+package controllers
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/bowenislandsong/neuronetes/pkg/downloader"
+)
+
+// modelDownload tracks an in-flight download of the weights of one model
+type modelDownload struct {
+	started time.Time
+	path    string
+	done    int64
+	total   int64
+	result  downloader.Result
+	err     error
+	// finished is set once the download returned
+	finished bool
+	cancel   context.CancelFunc
+}
+
+// downloadSnapshot is a point-in-time copy of a modelDownload
+type downloadSnapshot struct {
+	started  time.Time
+	path     string
+	done     int64
+	total    int64
+	result   downloader.Result
+	err      error
+	finished bool
+}
+
+// percent returns the progress of the download from 0 to 100
+func (s downloadSnapshot) percent() int32 {
+	if s.total <= 0 {
+		return 0
+	}
+	return int32(s.done * 100 / s.total)
+}
+
+// downloadTracker runs weight downloads in the background and records
+// their progress for the reconciler to surface in Model status
+type downloadTracker struct {
+	mu        sync.Mutex
+	downloads map[types.NamespacedName]*modelDownload
+}
+
+// start begins downloading the weights of req unless a download is
+// already running
+func (t *downloadTracker) start(key types.NamespacedName, d *downloader.Downloader, req downloader.Request) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.downloads == nil {
+		t.downloads = make(map[types.NamespacedName]*modelDownload)
+	}
+	if _, ok := t.downloads[key]; ok {
+		return
+	}
+
+	// Downloads outlive a single reconcile, so they are not tied to its context
+	ctx, cancel := context.WithCancel(context.Background())
+	download := &modelDownload{started: time.Now(), path: req.Dir, cancel: cancel}
+	t.downloads[key] = download
+
+	go func() {
+		result, err := d.Download(ctx, req, func(done, total int64) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			download.done, download.total = done, total
+		})
+
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		download.finished = true
+		download.result = result
+		download.err = err
+		if err == nil {
+			download.done, download.total = result.Size, result.Size
+		}
+	}()
+}
+
+// snapshot returns the current progress of the download for key
+func (t *downloadTracker) snapshot(key types.NamespacedName) (downloadSnapshot, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	download, ok := t.downloads[key]
+	if !ok {
+		return downloadSnapshot{}, false
+	}
+	return downloadSnapshot{
+		started:  download.started,
+		path:     download.path,
+		done:     download.done,
+		total:    download.total,
+		result:   download.result,
+		err:      download.err,
+		finished: download.finished,
+	}, true
+}
+
+// forget cancels and drops the download for key
+func (t *downloadTracker) forget(key types.NamespacedName) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if download, ok := t.downloads[key]; ok {
+		download.cancel()
+		delete(t.downloads, key)
+	}
+}
+
+// cachePath returns the directory of the weights of the model key in the
+// model cache
+func cachePath(cacheDir string, key types.NamespacedName) string {
+	return filepath.Join(cacheDir, key.Namespace, key.Name)
+}
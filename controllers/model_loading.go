@@ -0,0 +1,179 @@
+package controllers
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/plugins"
+)
+
+// nodeLoad is the in-memory progress of a model load on one node
+type nodeLoad struct {
+	percent int32
+	done    bool
+	err     error
+}
+
+// modelLoad tracks an in-flight load of one model across nodes
+type modelLoad struct {
+	started time.Time
+	nodes   map[string]*nodeLoad
+	cancel  context.CancelFunc
+}
+
+// loadSnapshot is a point-in-time copy of a modelLoad
+type loadSnapshot struct {
+	started time.Time
+	nodes   map[string]nodeLoad
+}
+
+// loadTracker runs model loader plugins in the background and records
+// their progress for the reconciler to surface in Model status
+type loadTracker struct {
+	mu    sync.Mutex
+	loads map[types.NamespacedName]*modelLoad
+}
+
+// start begins loading model onto nodes unless a load is already running
+func (t *loadTracker) start(key types.NamespacedName, model *neuronetes.Model, loader plugins.ModelLoaderPlugin, nodes []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.loads == nil {
+		t.loads = make(map[types.NamespacedName]*modelLoad)
+	}
+	if _, ok := t.loads[key]; ok {
+		return
+	}
+
+	// Loads outlive a single reconcile, so they are not tied to its context
+	ctx, cancel := context.WithCancel(context.Background())
+	load := &modelLoad{
+		started: time.Now(),
+		nodes:   make(map[string]*nodeLoad, len(nodes)),
+		cancel:  cancel,
+	}
+	for _, node := range nodes {
+		load.nodes[node] = &nodeLoad{}
+	}
+	t.loads[key] = load
+
+	for _, node := range nodes {
+		go t.run(ctx, load, model, loader, node)
+	}
+}
+
+func (t *loadTracker) run(ctx context.Context, load *modelLoad, model *neuronetes.Model, loader plugins.ModelLoaderPlugin, node string) {
+	err := loader.Load(ctx, model, node, func(percent int32) {
+		if percent < 0 {
+			percent = 0
+		}
+		if percent > 100 {
+			percent = 100
+		}
+
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		// Progress never goes backwards
+		if nl := load.nodes[node]; percent > nl.percent {
+			nl.percent = percent
+		}
+	})
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	nl := load.nodes[node]
+	nl.done = true
+	nl.err = err
+	if err == nil {
+		nl.percent = 100
+	}
+}
+
+// snapshot returns the current progress of the load for key
+func (t *loadTracker) snapshot(key types.NamespacedName) (loadSnapshot, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	load, ok := t.loads[key]
+	if !ok {
+		return loadSnapshot{}, false
+	}
+
+	snap := loadSnapshot{
+		started: load.started,
+		nodes:   make(map[string]nodeLoad, len(load.nodes)),
+	}
+	for node, nl := range load.nodes {
+		snap.nodes[node] = *nl
+	}
+	return snap, true
+}
+
+// forget cancels and drops the load for key
+func (t *loadTracker) forget(key types.NamespacedName) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if load, ok := t.loads[key]; ok {
+		load.cancel()
+		delete(t.loads, key)
+	}
+}
+
+// sortedNodes returns the node names of a snapshot in order
+func (s loadSnapshot) sortedNodes() []string {
+	nodes := make([]string, 0, len(s.nodes))
+	for node := range s.nodes {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	return nodes
+}
+
+// selectLoader returns the highest-priority loader plugin able to load model
+func selectLoader(ctx context.Context, registry *plugins.PluginRegistry, model *neuronetes.Model) plugins.ModelLoaderPlugin {
+	if registry == nil {
+		return nil
+	}
+
+	loaders := append([]plugins.ModelLoaderPlugin(nil), registry.GetModelLoaders()...)
+	sort.SliceStable(loaders, func(i, j int) bool {
+		return loaders[i].Priority() > loaders[j].Priority()
+	})
+
+	for _, loader := range loaders {
+		if loader.CanLoad(ctx, model) {
+			return loader
+		}
+	}
+	return nil
+}
+
+// loadNodes returns the sorted names of the nodes a model should be loaded
+// onto, matching the label selectors among its preload nodes
+func (r *ModelReconciler) loadNodes(ctx context.Context, model *neuronetes.Model) ([]string, error) {
+	if model.Spec.CachePolicy == nil || len(model.Spec.CachePolicy.PreloadNodes) == 0 {
+		return nil, nil
+	}
+	var nodes corev1.NodeList
+	if err := r.List(ctx, &nodes); err != nil {
+		return nil, err
+	}
+	targets, err := preloadTargets(model.Spec.CachePolicy, nodes.Items)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(targets))
+	for name := range targets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
@@ -0,0 +1,71 @@
+package controllers
+
+import (
+	"math"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/capacity"
+	"github.com/bowenislandsong/neuronetes/pkg/introspection"
+)
+
+// modelMetadata converts the metadata read from the weights of a model for
+// its status
+func modelMetadata(metadata *introspection.Metadata) *neuronetes.ModelMetadata {
+	status := &neuronetes.ModelMetadata{
+		Format:        metadata.Format,
+		Architecture:  metadata.Architecture,
+		ContextLength: clampInt32(metadata.ContextLength),
+		Tokenizer:     metadata.Tokenizer,
+		NumLayers:     clampInt32(metadata.NumLayers),
+		NumKVHeads:    clampInt32(metadata.NumKVHeads),
+		HeadDim:       clampInt32(metadata.HeadDim),
+	}
+	if metadata.ParameterCount > 0 {
+		status.ParameterCount = capacity.FormatParameterCount(float64(metadata.ParameterCount))
+	}
+	return status
+}
+
+// defaultedSpec returns the spec of model with the parameter count,
+// architecture, format and layer dimensions it leaves unset read from its
+// weights. The spec itself is left as written, as changing it would roll
+// the pools serving the model.
+func defaultedSpec(model *neuronetes.Model) *neuronetes.ModelSpec {
+	metadata := model.Status.Metadata
+	if metadata == nil {
+		return &model.Spec
+	}
+	spec := model.Spec.DeepCopy()
+	if spec.ParameterCount == "" {
+		spec.ParameterCount = metadata.ParameterCount
+	}
+	if spec.Architecture == "" {
+		spec.Architecture = metadata.Architecture
+	}
+	if spec.Format == "" {
+		spec.Format = metadata.Format
+	}
+	if metadata.NumLayers > 0 || metadata.NumKVHeads > 0 || metadata.HeadDim > 0 {
+		if spec.Serving == nil {
+			spec.Serving = &neuronetes.ServingSpec{}
+		}
+		if spec.Serving.NumLayers == 0 {
+			spec.Serving.NumLayers = metadata.NumLayers
+		}
+		if spec.Serving.NumKVHeads == 0 {
+			spec.Serving.NumKVHeads = metadata.NumKVHeads
+		}
+		if spec.Serving.HeadDim == 0 {
+			spec.Serving.HeadDim = metadata.HeadDim
+		}
+	}
+	return spec
+}
+
+// clampInt32 returns n, or the largest int32 if n is larger
+func clampInt32(n int64) int32 {
+	if n > math.MaxInt32 {
+		return math.MaxInt32
+	}
+	return int32(n)
+}
@@ -0,0 +1,234 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/modelcache"
+)
+
+// ModelCacheReconciler reconciles the CachePolicy of Models against the
+// node cache agents. It assigns each model to the nodes its policy preloads
+// it on, keeps pinned models assigned, and reports the caches of the nodes
+// and when the model was last used in Model status. The agents evict idle
+// models under disk pressure. Models the garbage collector evicted are
+// assigned to no node.
+type ModelCacheReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+// +kubebuilder:rbac:groups=neuronetes.io,resources=models,verbs=get;list;watch
+// +kubebuilder:rbac:groups=neuronetes.io,resources=models/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=core,resources=nodes,verbs=get;list;watch;patch
+
+// Reconcile assigns a model to node caches and surfaces where it is cached
+func (r *ModelCacheReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+	key := modelcache.Key(req.NamespacedName)
+
+	var nodes corev1.NodeList
+	if err := r.List(ctx, &nodes); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	var model neuronetes.Model
+	if err := r.Get(ctx, req.NamespacedName, &model); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return ctrl.Result{}, err
+		}
+		// Deleted models are evicted from every node
+		for i := range nodes.Items {
+			if err := r.assign(ctx, &nodes.Items[i], key, false); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+		return ctrl.Result{}, nil
+	}
+
+	targets, err := preloadTargets(model.Spec.CachePolicy, nodes.Items)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	evicted := cacheEvicted(&model)
+	if evicted {
+		targets = nil
+	}
+
+	now := time.Now()
+	var requeue time.Duration
+	var cached []neuronetes.NodeCacheStatus
+	lastUsed := model.Status.LastUsed
+	for i := range nodes.Items {
+		node := &nodes.Items[i]
+		report, _, err := modelcache.ParseReport(node)
+		if err != nil {
+			log.Error(err, "ignoring model cache report")
+		}
+		entry, inCache := report.Models[key]
+
+		want := targets[node.Name]
+		if !want && !evicted && inCache && entry.State == modelcache.StateReady {
+			// Pinned models stay cached on nodes they are no longer preloaded on
+			var until time.Duration
+			want, until = modelcache.Pinned(model.Spec.CachePolicy, entry.CachedAt, now)
+			if until > 0 && (requeue == 0 || until < requeue) {
+				requeue = until
+			}
+		}
+		if err := r.assign(ctx, node, key, want); err != nil {
+			return ctrl.Result{}, err
+		}
+		if inCache {
+			cached = append(cached, nodeCacheStatus(node.Name, entry))
+			if entry.LastUsed != nil && (lastUsed == nil || lastUsed.Before(entry.LastUsed)) {
+				lastUsed = entry.LastUsed
+			}
+		}
+	}
+
+	// Loader plugins report the nodes of models they load
+	if model.Status.Phase != "Loading" && (!equality.Semantic.DeepEqual(cached, model.Status.CachedNodes) || lastUsed != model.Status.LastUsed) {
+		model.Status.CachedNodes = cached
+		model.Status.LastUsed = lastUsed
+		if err := r.Status().Update(ctx, &model); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	return ctrl.Result{RequeueAfter: requeue}, nil
+}
+
+// assign adds the model key to or removes it from the assignments of node
+func (r *ModelCacheReconciler) assign(ctx context.Context, node *corev1.Node, key string, want bool) error {
+	keys := modelcache.Assignments(node)
+	sort.Strings(keys)
+	i := sort.SearchStrings(keys, key)
+	assigned := i < len(keys) && keys[i] == key
+	if assigned == want {
+		return nil
+	}
+	if want {
+		keys = append(keys, key)
+	} else {
+		keys = append(keys[:i:i], keys[i+1:]...)
+	}
+
+	patch := client.MergeFromWithOptions(node.DeepCopy(), client.MergeFromWithOptimisticLock{})
+	if node.Annotations == nil {
+		node.Annotations = make(map[string]string)
+	}
+	if len(keys) == 0 {
+		delete(node.Annotations, modelcache.AnnotationAssignments)
+	} else {
+		node.Annotations[modelcache.AnnotationAssignments] = modelcache.JoinKeys(keys)
+	}
+	if err := r.Patch(ctx, node, patch); err != nil {
+		return fmt.Errorf("failed to assign model %s to node %s: %w", key, node.Name, err)
+	}
+	log.FromContext(ctx).Info("Updated model cache assignment", "node", node.Name, "assigned", want)
+	return nil
+}
+
+// preloadTargets returns the names of the nodes policy preloads its model
+// on. An entry of PreloadNodes is either the name of a node or a label
+// selector, such as nvidia.com/gpu.product=NVIDIA-H100-80GB-HBM3.
+func preloadTargets(policy *neuronetes.CachePolicy, nodes []corev1.Node) (map[string]bool, error) {
+	targets := make(map[string]bool)
+	if policy == nil {
+		return targets, nil
+	}
+	for _, entry := range policy.PreloadNodes {
+		if !strings.ContainsAny(entry, "=!(, ") {
+			targets[entry] = true
+			continue
+		}
+		selector, err := labels.Parse(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid preload node selector %q: %w", entry, err)
+		}
+		for i := range nodes {
+			if selector.Matches(labels.Set(nodes[i].Labels)) {
+				targets[nodes[i].Name] = true
+			}
+		}
+	}
+	return targets, nil
+}
+
+// nodeCacheStatus returns the status of a model in the cache of node
+func nodeCacheStatus(node string, entry modelcache.ModelReport) neuronetes.NodeCacheStatus {
+	progress := entry.ProgressPercent
+	status := neuronetes.NodeCacheStatus{
+		NodeName:        node,
+		Status:          entry.State,
+		CachedAt:        entry.CachedAt,
+		ProgressPercent: &progress,
+		LastUsed:        entry.LastUsed,
+	}
+	if entry.Bytes > 0 {
+		status.Size = resource.NewQuantity(entry.Bytes, resource.BinarySI)
+	}
+	return status
+}
+
+// modelsForNode maps a Node to the models assigned to or cached on it, and
+// the models with a CachePolicy that may preload on it
+func (r *ModelCacheReconciler) modelsForNode(ctx context.Context, obj client.Object) []reconcile.Request {
+	node, ok := obj.(*corev1.Node)
+	if !ok {
+		return nil
+	}
+	keys := make(map[string]bool)
+	for _, key := range modelcache.Assignments(node) {
+		keys[key] = true
+	}
+	if report, _, err := modelcache.ParseReport(node); err == nil {
+		for key := range report.Models {
+			keys[key] = true
+		}
+	}
+
+	var models neuronetes.ModelList
+	if err := r.List(ctx, &models); err != nil {
+		log.FromContext(ctx).Error(err, "failed to list models", "node", node.Name)
+	}
+	for i := range models.Items {
+		if policy := models.Items[i].Spec.CachePolicy; policy != nil && len(policy.PreloadNodes) > 0 {
+			keys[modelcache.Key(client.ObjectKeyFromObject(&models.Items[i]))] = true
+		}
+	}
+
+	requests := make([]reconcile.Request, 0, len(keys))
+	for key := range keys {
+		if name, ok := modelcache.ParseKey(key); ok {
+			requests = append(requests, reconcile.Request{NamespacedName: name})
+		}
+	}
+	sort.Slice(requests, func(i, j int) bool { return requests[i].String() < requests[j].String() })
+	return requests
+}
+
+// SetupWithManager sets up the controller with the Manager
+func (r *ModelCacheReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("modelcache").
+		For(&neuronetes.Model{}).
+		Watches(&corev1.Node{}, handler.EnqueueRequestsFromMapFunc(r.modelsForNode)).
+		Complete(r)
+}
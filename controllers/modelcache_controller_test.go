@@ -0,0 +1,160 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/modelcache"
+)
+
+// cacheNode returns a node whose cache agent reports models
+func cacheNode(t *testing.T, name string, nodeLabels map[string]string, models map[string]modelcache.ModelReport) *corev1.Node {
+	t.Helper()
+	report, err := json.Marshal(modelcache.Report{CapacityBytes: 1 << 40, FreeBytes: 1 << 39, Models: models})
+	require.NoError(t, err)
+	return &corev1.Node{ObjectMeta: metav1.ObjectMeta{
+		Name:        name,
+		Labels:      nodeLabels,
+		Annotations: map[string]string{modelcache.AnnotationReport: string(report)},
+	}}
+}
+
+func reconcileCache(t *testing.T, r *ModelCacheReconciler, key types.NamespacedName) ctrl.Result {
+	t.Helper()
+	result, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: key})
+	require.NoError(t, err)
+	return result
+}
+
+func assignments(t *testing.T, c client.Client, node string) string {
+	t.Helper()
+	var n corev1.Node
+	require.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: node}, &n))
+	return n.Annotations[modelcache.AnnotationAssignments]
+}
+
+func TestModelCacheReconcilerAssignsPreloadNodes(t *testing.T) {
+	cachedAt := metav1.NewTime(time.Now().Add(-time.Minute).Truncate(time.Second))
+	model := newTestModel("node-a", "nvidia.com/gpu.product=H100")
+	model.Status.Phase = "Ready"
+	key := client.ObjectKeyFromObject(model)
+	c := newFakeClient(t, model,
+		cacheNode(t, "node-a", nil, map[string]modelcache.ModelReport{
+			"default/llama-3-8b": {State: modelcache.StateReady, Bytes: 16 << 30, ProgressPercent: 100, CachedAt: &cachedAt},
+		}),
+		cacheNode(t, "node-b", map[string]string{"nvidia.com/gpu.product": "H100"}, map[string]modelcache.ModelReport{
+			"default/llama-3-8b": {State: modelcache.StateLoading, Bytes: 16 << 30, ProgressPercent: 40},
+		}),
+		cacheNode(t, "node-c", map[string]string{"nvidia.com/gpu.product": "A100"}, nil),
+	)
+	r := &ModelCacheReconciler{Client: c}
+
+	assert.Equal(t, ctrl.Result{}, reconcileCache(t, r, key))
+	assert.Equal(t, "default/llama-3-8b", assignments(t, c, "node-a"))
+	assert.Equal(t, "default/llama-3-8b", assignments(t, c, "node-b"))
+	assert.Empty(t, assignments(t, c, "node-c"))
+
+	var got neuronetes.Model
+	require.NoError(t, c.Get(context.Background(), key, &got))
+	require.Len(t, got.Status.CachedNodes, 2)
+	assert.Equal(t, "node-a", got.Status.CachedNodes[0].NodeName)
+	assert.Equal(t, "ready", got.Status.CachedNodes[0].Status)
+	assert.True(t, cachedAt.Equal(got.Status.CachedNodes[0].CachedAt))
+	assert.Equal(t, resource.NewQuantity(16<<30, resource.BinarySI).String(), got.Status.CachedNodes[0].Size.String())
+	assert.Equal(t, "loading", got.Status.CachedNodes[1].Status)
+	assert.Equal(t, int32(40), *got.Status.CachedNodes[1].ProgressPercent)
+
+	// Models no longer preloaded on a node are unassigned
+	got.Spec.CachePolicy.PreloadNodes = []string{"node-a"}
+	require.NoError(t, c.Update(context.Background(), &got))
+	reconcileCache(t, r, key)
+	assert.Empty(t, assignments(t, c, "node-b"))
+
+	// Deleted models are unassigned from every node
+	require.NoError(t, c.Delete(context.Background(), &got))
+	reconcileCache(t, r, key)
+	assert.Empty(t, assignments(t, c, "node-a"))
+}
+
+func TestModelCacheReconcilerKeepsPinnedModels(t *testing.T) {
+	cachedAt := metav1.NewTime(time.Now().Add(-time.Minute))
+	reports := map[string]modelcache.ModelReport{
+		"default/llama-3-8b": {State: modelcache.StateReady, CachedAt: &cachedAt},
+	}
+
+	for name, tc := range map[string]struct {
+		policy   neuronetes.CachePolicy
+		assigned bool
+		requeue  bool
+	}{
+		"pinned":   {neuronetes.CachePolicy{Priority: "high", PinDuration: &metav1.Duration{Duration: time.Hour}}, true, true},
+		"expired":  {neuronetes.CachePolicy{Priority: "high", PinDuration: &metav1.Duration{Duration: time.Second}}, false, false},
+		"never":    {neuronetes.CachePolicy{Priority: "high", EvictionPolicy: "never"}, true, false},
+		"unpinned": {neuronetes.CachePolicy{Priority: "high"}, false, false},
+	} {
+		t.Run(name, func(t *testing.T) {
+			model := newTestModel()
+			model.Spec.CachePolicy = &tc.policy
+			node := cacheNode(t, "node-a", nil, reports)
+			node.Annotations[modelcache.AnnotationAssignments] = "default/llama-3-8b"
+			c := newFakeClient(t, model, node)
+			r := &ModelCacheReconciler{Client: c}
+
+			result := reconcileCache(t, r, client.ObjectKeyFromObject(model))
+			assert.Equal(t, tc.assigned, assignments(t, c, "node-a") == "default/llama-3-8b")
+			assert.Equal(t, tc.requeue, result.RequeueAfter > 0)
+		})
+	}
+}
+
+func TestModelCacheReconcilerReportsLastUsed(t *testing.T) {
+	used := metav1.NewTime(time.Now().Add(-time.Hour).Truncate(time.Second))
+	recent := metav1.NewTime(time.Now().Add(-time.Minute).Truncate(time.Second))
+	model := newTestModel("node-a", "node-b")
+	model.Status.Phase = "Ready"
+	c := newFakeClient(t, model,
+		cacheNode(t, "node-a", nil, map[string]modelcache.ModelReport{
+			"default/llama-3-8b": {State: modelcache.StateReady, LastUsed: &used},
+		}),
+		cacheNode(t, "node-b", nil, map[string]modelcache.ModelReport{
+			"default/llama-3-8b": {State: modelcache.StateEvicted, Bytes: 16 << 30, LastUsed: &recent, Error: "evicted under disk pressure"},
+		}),
+	)
+	r := &ModelCacheReconciler{Client: c}
+	reconcileCache(t, r, client.ObjectKeyFromObject(model))
+
+	var got neuronetes.Model
+	require.NoError(t, c.Get(context.Background(), client.ObjectKeyFromObject(model), &got))
+	require.Len(t, got.Status.CachedNodes, 2)
+	assert.True(t, used.Equal(got.Status.CachedNodes[0].LastUsed))
+	assert.Equal(t, "evicted", got.Status.CachedNodes[1].Status)
+	assert.True(t, recent.Equal(got.Status.LastUsed), "the model was last used on node-b")
+	assert.Equal(t, "default/llama-3-8b", assignments(t, c, "node-b"), "evicted models stay assigned")
+}
+
+func TestModelsForNode(t *testing.T) {
+	preloaded := newTestModel("node-z")
+	other := newTestModel()
+	other.Name = "mistral-7b"
+	node := cacheNode(t, "node-a", nil, map[string]modelcache.ModelReport{"prod/gemma": {State: modelcache.StateReady}})
+	node.Annotations[modelcache.AnnotationAssignments] = "prod/qwen"
+	r := &ModelCacheReconciler{Client: newFakeClient(t, preloaded, other)}
+
+	assert.Equal(t, []ctrl.Request{
+		{NamespacedName: types.NamespacedName{Namespace: "default", Name: "llama-3-8b"}},
+		{NamespacedName: types.NamespacedName{Namespace: "prod", Name: "gemma"}},
+		{NamespacedName: types.NamespacedName{Namespace: "prod", Name: "qwen"}},
+	}, r.modelsForNode(context.Background(), node))
+}
@@ -0,0 +1,257 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+)
+
+// ConditionUnused reports whether nothing references a model, and whether
+// the garbage collector evicted it from the node caches
+const ConditionUnused = "Unused"
+
+// reasonCacheEvicted is the reason of the Unused condition of models
+// evicted from the node caches
+const reasonCacheEvicted = "CacheEvicted"
+
+// ModelGCReconciler garbage collects the Models no AgentClass, ModelRollout
+// or ModelQuantization references. Once such a model has not been used for
+// Retention, it is evicted from the node caches and, with DeleteUnused,
+// deleted. Models annotated neuronetes.io/keep-always are never collected.
+type ModelGCReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+
+	// Retention is how long an unreferenced model stays cached after it
+	// was last used
+	Retention time.Duration
+
+	// DeleteUnused deletes the models evicted from the node caches
+	DeleteUnused bool
+
+	// CacheDir is the directory of the model cache ModelReconciler
+	// downloads weights into. The weights of deleted models are removed
+	// from it.
+	CacheDir string
+
+	// clock overrides time.Now in tests
+	clock func() time.Time
+}
+
+// +kubebuilder:rbac:groups=neuronetes.io,resources=models,verbs=get;list;watch;delete
+// +kubebuilder:rbac:groups=neuronetes.io,resources=models/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=neuronetes.io,resources=agentclasses,verbs=get;list;watch
+// +kubebuilder:rbac:groups=neuronetes.io,resources=modelrollouts,verbs=get;list;watch
+// +kubebuilder:rbac:groups=neuronetes.io,resources=modelquantizations,verbs=get;list;watch
+
+// Reconcile tracks how long a model has been unused and collects it once
+// its retention has passed
+func (r *ModelGCReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	var model neuronetes.Model
+	if err := r.Get(ctx, req.NamespacedName, &model); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, r.removeWeights(ctx, req.NamespacedName)
+	}
+	if !model.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, nil
+	}
+
+	if model.Annotations[neuronetes.AnnotationKeepAlways] == "true" {
+		// Kept models are cached again if they were evicted
+		if meta.FindStatusCondition(model.Status.Conditions, ConditionUnused) != nil {
+			meta.RemoveStatusCondition(&model.Status.Conditions, ConditionUnused)
+			if err := r.Status().Update(ctx, &model); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+		return ctrl.Result{}, nil
+	}
+
+	referrer, err := r.referrer(ctx, &model)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	now := r.now()
+	if referrer != "" {
+		return ctrl.Result{}, r.setUnused(ctx, &model, metav1.ConditionFalse, "Referenced", fmt.Sprintf("referenced by %s", referrer), now)
+	}
+
+	idle := now.Sub(unusedSince(&model, now))
+	if idle < r.Retention {
+		if err := r.setUnused(ctx, &model, metav1.ConditionTrue, "Unreferenced", "no AgentClass, ModelRollout or ModelQuantization references the model", now); err != nil {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{RequeueAfter: r.Retention - idle}, nil
+	}
+
+	if !cacheEvicted(&model) {
+		message := fmt.Sprintf("unused for %s, evicted from the node caches", idle.Round(time.Second))
+		if err := r.setUnused(ctx, &model, metav1.ConditionTrue, reasonCacheEvicted, message, now); err != nil {
+			return ctrl.Result{}, err
+		}
+		log.Info("Evicted unused model from node caches", "idle", idle.Round(time.Second))
+	}
+	if r.DeleteUnused {
+		if err := r.Delete(ctx, &model); client.IgnoreNotFound(err) != nil {
+			return ctrl.Result{}, fmt.Errorf("failed to delete unused model: %w", err)
+		}
+		log.Info("Deleted unused model", "idle", idle.Round(time.Second))
+	}
+	return ctrl.Result{}, nil
+}
+
+// referrer returns a resource referencing model, or an empty string if
+// none does
+func (r *ModelGCReconciler) referrer(ctx context.Context, model *neuronetes.Model) (string, error) {
+	key := client.ObjectKeyFromObject(model)
+
+	var classes neuronetes.AgentClassList
+	if err := r.List(ctx, &classes); err != nil {
+		return "", fmt.Errorf("failed to list agent classes: %w", err)
+	}
+	for i := range classes.Items {
+		if modelKey(&classes.Items[i]) == key {
+			return "AgentClass " + client.ObjectKeyFromObject(&classes.Items[i]).String(), nil
+		}
+	}
+
+	var rollouts neuronetes.ModelRolloutList
+	if err := r.List(ctx, &rollouts, client.InNamespace(model.Namespace)); err != nil {
+		return "", fmt.Errorf("failed to list model rollouts: %w", err)
+	}
+	for i := range rollouts.Items {
+		rollout := &rollouts.Items[i]
+		if rollout.Spec.ModelRef.Name == model.Name || rollout.Name == model.Labels[neuronetes.LabelRollout] {
+			return "ModelRollout " + client.ObjectKeyFromObject(rollout).String(), nil
+		}
+	}
+
+	var quantizations neuronetes.ModelQuantizationList
+	if err := r.List(ctx, &quantizations, client.InNamespace(model.Namespace)); err != nil {
+		return "", fmt.Errorf("failed to list model quantizations: %w", err)
+	}
+	for i := range quantizations.Items {
+		quantization := &quantizations.Items[i]
+		if quantization.Spec.SourceModelRef.Name == model.Name || targetModelName(quantization) == model.Name {
+			return "ModelQuantization " + client.ObjectKeyFromObject(quantization).String(), nil
+		}
+	}
+	return "", nil
+}
+
+// setUnused sets the Unused condition of model if it changed
+func (r *ModelGCReconciler) setUnused(ctx context.Context, model *neuronetes.Model, status metav1.ConditionStatus, reason, message string, now time.Time) error {
+	if c := meta.FindStatusCondition(model.Status.Conditions, ConditionUnused); c != nil && c.Status == status && c.Reason == reason && c.Message == message {
+		return nil
+	}
+	meta.SetStatusCondition(&model.Status.Conditions, metav1.Condition{
+		Type:               ConditionUnused,
+		Status:             status,
+		LastTransitionTime: metav1.NewTime(now),
+		Reason:             reason,
+		Message:            message,
+	})
+	return r.Status().Update(ctx, model)
+}
+
+// removeWeights removes the weights of the deleted model key from the
+// model cache
+func (r *ModelGCReconciler) removeWeights(ctx context.Context, key types.NamespacedName) error {
+	if r.CacheDir == "" {
+		return nil
+	}
+	path := cachePath(r.CacheDir, key)
+	if _, err := os.Stat(path); err != nil {
+		return nil
+	}
+	if err := os.RemoveAll(path); err != nil {
+		return fmt.Errorf("failed to remove weights of deleted model %s: %w", key, err)
+	}
+	log.FromContext(ctx).Info("Removed weights of deleted model", "path", path)
+	return nil
+}
+
+func (r *ModelGCReconciler) now() time.Time {
+	if r.clock != nil {
+		return r.clock()
+	}
+	return time.Now()
+}
+
+// unusedSince returns when model was last used or referenced, now if it
+// was referenced when last reconciled
+func unusedSince(model *neuronetes.Model, now time.Time) time.Time {
+	since := now
+	if c := meta.FindStatusCondition(model.Status.Conditions, ConditionUnused); c != nil && c.Status == metav1.ConditionTrue {
+		since = c.LastTransitionTime.Time
+	}
+	if lastUsed := model.Status.LastUsed; lastUsed != nil && lastUsed.Time.After(since) {
+		since = lastUsed.Time
+	}
+	return since
+}
+
+// cacheEvicted reports whether the garbage collector evicted model from the
+// node caches
+func cacheEvicted(model *neuronetes.Model) bool {
+	c := meta.FindStatusCondition(model.Status.Conditions, ConditionUnused)
+	return c != nil && c.Status == metav1.ConditionTrue && c.Reason == reasonCacheEvicted
+}
+
+// modelsForReferrer maps an AgentClass, ModelRollout or ModelQuantization
+// to the models it references, so that they are reconciled as soon as it is
+// deleted
+func (r *ModelGCReconciler) modelsForReferrer(ctx context.Context, obj client.Object) []reconcile.Request {
+	var names []types.NamespacedName
+	switch referrer := obj.(type) {
+	case *neuronetes.AgentClass:
+		names = append(names, modelKey(referrer))
+	case *neuronetes.ModelRollout:
+		names = append(names, types.NamespacedName{Namespace: referrer.Namespace, Name: referrer.Spec.ModelRef.Name})
+		var models neuronetes.ModelList
+		if err := r.List(ctx, &models, client.InNamespace(referrer.Namespace), client.MatchingLabels{neuronetes.LabelRollout: referrer.Name}); err != nil {
+			log.FromContext(ctx).Error(err, "failed to list models of rollout", "rollout", referrer.Name)
+		}
+		for i := range models.Items {
+			names = append(names, client.ObjectKeyFromObject(&models.Items[i]))
+		}
+	case *neuronetes.ModelQuantization:
+		names = append(names,
+			types.NamespacedName{Namespace: referrer.Namespace, Name: referrer.Spec.SourceModelRef.Name},
+			types.NamespacedName{Namespace: referrer.Namespace, Name: targetModelName(referrer)})
+	}
+	requests := make([]reconcile.Request, 0, len(names))
+	for _, name := range names {
+		requests = append(requests, reconcile.Request{NamespacedName: name})
+	}
+	return requests
+}
+
+// SetupWithManager sets up the controller with the Manager
+func (r *ModelGCReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("modelgc").
+		For(&neuronetes.Model{}).
+		Watches(&neuronetes.AgentClass{}, handler.EnqueueRequestsFromMapFunc(r.modelsForReferrer)).
+		Watches(&neuronetes.ModelRollout{}, handler.EnqueueRequestsFromMapFunc(r.modelsForReferrer)).
+		Watches(&neuronetes.ModelQuantization{}, handler.EnqueueRequestsFromMapFunc(r.modelsForReferrer)).
+		Complete(r)
+}
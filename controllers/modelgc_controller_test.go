@@ -0,0 +1,131 @@
+package controllers
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/modelcache"
+)
+
+func reconcileGC(t *testing.T, r *ModelGCReconciler, key types.NamespacedName) (ctrl.Result, *metav1.Condition) {
+	t.Helper()
+	result, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: key})
+	require.NoError(t, err)
+	var model neuronetes.Model
+	if err := r.Get(context.Background(), key, &model); apierrors.IsNotFound(err) {
+		return result, nil
+	}
+	return result, meta.FindStatusCondition(model.Status.Conditions, ConditionUnused)
+}
+
+func TestModelGCReconcilerEvictsUnusedModels(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	cachedAt := metav1.NewTime(now.Add(-time.Hour))
+	model := newTestModel("node-a")
+	model.Status.Phase = "Ready"
+	key := client.ObjectKeyFromObject(model)
+	class := &neuronetes.AgentClass{
+		ObjectMeta: metav1.ObjectMeta{Name: "chat", Namespace: "default"},
+		Spec:       neuronetes.AgentClassSpec{ModelRef: neuronetes.ModelReference{Name: model.Name}},
+	}
+	c := newFakeClient(t, model, class, cacheNode(t, "node-a", nil, map[string]modelcache.ModelReport{
+		"default/llama-3-8b": {State: modelcache.StateReady, Bytes: 16 << 30, ProgressPercent: 100, CachedAt: &cachedAt},
+	}))
+	r := &ModelGCReconciler{Client: c, Retention: 24 * time.Hour}
+	r.clock = func() time.Time { return now }
+	cache := &ModelCacheReconciler{Client: c}
+
+	// Referenced models are kept
+	result, condition := reconcileGC(t, r, key)
+	assert.Equal(t, ctrl.Result{}, result)
+	require.NotNil(t, condition)
+	assert.Equal(t, metav1.ConditionFalse, condition.Status)
+	assert.Equal(t, "referenced by AgentClass default/chat", condition.Message)
+
+	// The retention starts once no AgentClass references the model
+	require.NoError(t, c.Delete(context.Background(), class))
+	now = now.Add(time.Hour)
+	result, condition = reconcileGC(t, r, key)
+	assert.Equal(t, ctrl.Result{RequeueAfter: 24 * time.Hour}, result)
+	assert.Equal(t, metav1.ConditionTrue, condition.Status)
+	assert.Equal(t, "Unreferenced", condition.Reason)
+	reconcileCache(t, cache, key)
+	assert.Equal(t, "default/llama-3-8b", assignments(t, c, "node-a"))
+
+	// A use of the model restarts it
+	var got neuronetes.Model
+	require.NoError(t, c.Get(context.Background(), key, &got))
+	lastUsed := metav1.NewTime(now.Add(6 * time.Hour))
+	got.Status.LastUsed = &lastUsed
+	require.NoError(t, c.Status().Update(context.Background(), &got))
+	now = now.Add(12 * time.Hour)
+	result, _ = reconcileGC(t, r, key)
+	assert.Equal(t, ctrl.Result{RequeueAfter: 18 * time.Hour}, result)
+
+	// Once the retention passed, the model is evicted from the node caches,
+	// even those it is preloaded on
+	now = now.Add(18 * time.Hour)
+	result, condition = reconcileGC(t, r, key)
+	assert.Equal(t, ctrl.Result{}, result)
+	assert.Equal(t, "CacheEvicted", condition.Reason)
+	assert.Equal(t, "unused for 24h0m0s, evicted from the node caches", condition.Message)
+	reconcileCache(t, cache, key)
+	assert.Empty(t, assignments(t, c, "node-a"))
+
+	// Kept models are cached again
+	require.NoError(t, c.Get(context.Background(), key, &got))
+	got.Annotations = map[string]string{neuronetes.AnnotationKeepAlways: "true"}
+	require.NoError(t, c.Update(context.Background(), &got))
+	_, condition = reconcileGC(t, r, key)
+	assert.Nil(t, condition)
+	reconcileCache(t, cache, key)
+	assert.Equal(t, "default/llama-3-8b", assignments(t, c, "node-a"))
+}
+
+func TestModelGCReconcilerDeletesUnusedModels(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	model := newTestModel()
+	key := client.ObjectKeyFromObject(model)
+	quantization := &neuronetes.ModelQuantization{
+		ObjectMeta: metav1.ObjectMeta{Name: "llama-int4", Namespace: "default"},
+		Spec:       neuronetes.ModelQuantizationSpec{SourceModelRef: corev1.LocalObjectReference{Name: model.Name}},
+	}
+	c := newFakeClient(t, model, quantization)
+	cacheDir := t.TempDir()
+	weights := filepath.Join(cacheDir, "default", "llama-3-8b")
+	require.NoError(t, os.MkdirAll(weights, 0o755))
+	r := &ModelGCReconciler{Client: c, Retention: time.Hour, DeleteUnused: true, CacheDir: cacheDir}
+	r.clock = func() time.Time { return now }
+
+	// Source models of quantizations are kept
+	_, condition := reconcileGC(t, r, key)
+	assert.Equal(t, "referenced by ModelQuantization default/llama-int4", condition.Message)
+
+	require.NoError(t, c.Delete(context.Background(), quantization))
+	_, condition = reconcileGC(t, r, key)
+	assert.Equal(t, "Unreferenced", condition.Reason)
+
+	now = now.Add(time.Hour)
+	_, condition = reconcileGC(t, r, key)
+	assert.Nil(t, condition)
+	assert.True(t, apierrors.IsNotFound(c.Get(context.Background(), key, &neuronetes.Model{})))
+
+	// The weights of the deleted model are removed from the model cache
+	reconcileGC(t, r, key)
+	assert.NoDirExists(t, weights)
+	assert.DirExists(t, cacheDir)
+}
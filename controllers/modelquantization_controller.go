@@ -0,0 +1,463 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/capacity"
+	"github.com/bowenislandsong/neuronetes/pkg/gpu"
+)
+
+const (
+	// DefaultQuantizerImage is the image converting the weights of
+	// ModelQuantizations that do not set one
+	DefaultQuantizerImage = "ghcr.io/bowenislandsong/neuronetes-quantizer:latest"
+
+	// quantizerBackoffLimit is how many times a failed conversion is retried
+	quantizerBackoffLimit = 2
+
+	// quantizerWorkDir is the scratch volume of the quantizer, holding the
+	// source and quantized weights
+	quantizerWorkDir = "/work"
+)
+
+// quantizationReport is the termination message of the quantizer container,
+// in JSON, describing the weights it uploaded
+type quantizationReport struct {
+	// Size is the total bytes of the quantized weights
+	Size int64 `json:"size,omitempty"`
+}
+
+// ModelQuantizationReconciler reconciles a ModelQuantization object. It runs
+// a conversion Job on a GPU node for each revision of the source model and
+// creates or updates the derived Model serving the uploaded weights.
+//
+// The quantizer is started with --source, --quantization, --output and
+// --work-dir, and --method, --format and --file-pattern when set, with the
+// tokens of the source and output in SOURCE_TOKEN and OUTPUT_TOKEN. It
+// reports the weights it uploaded as its termination message.
+type ModelQuantizationReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+
+	// Image is the quantizer image of quantizations that do not set one.
+	// Defaults to DefaultQuantizerImage.
+	Image string
+
+	// clock overrides time.Now in tests
+	clock func() time.Time
+}
+
+// +kubebuilder:rbac:groups=neuronetes.io,resources=modelquantizations,verbs=get;list;watch
+// +kubebuilder:rbac:groups=neuronetes.io,resources=modelquantizations/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=neuronetes.io,resources=models,verbs=get;list;watch;create;update;patch
+// +kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;delete
+// +kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch
+
+// Reconcile converts the weights of the source model and keeps the derived
+// model in step with it
+func (r *ModelQuantizationReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	var quantization neuronetes.ModelQuantization
+	if err := r.Get(ctx, req.NamespacedName, &quantization); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	original := quantization.Status.DeepCopy()
+	if err := r.reconcileQuantization(ctx, &quantization); err != nil {
+		return ctrl.Result{}, err
+	}
+	if !equality.Semantic.DeepEqual(original, &quantization.Status) {
+		if err := r.Status().Update(ctx, &quantization); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	return ctrl.Result{}, nil
+}
+
+// reconcileQuantization advances quantization, recording its progress in
+// its status
+func (r *ModelQuantizationReconciler) reconcileQuantization(ctx context.Context, quantization *neuronetes.ModelQuantization) error {
+	if quantization.Status.Phase == "" {
+		quantization.Status.Phase = neuronetes.QuantizationPending
+	}
+	quantization.Status.Model = targetModelName(quantization)
+
+	var source neuronetes.Model
+	key := types.NamespacedName{Namespace: quantization.Namespace, Name: quantization.Spec.SourceModelRef.Name}
+	if err := r.Get(ctx, key, &source); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return err
+		}
+		if quantization.Status.Phase != neuronetes.QuantizationSucceeded {
+			quantization.Status.Phase = neuronetes.QuantizationPending
+			quantization.Status.Message = fmt.Sprintf("waiting for model %s", key.Name)
+		}
+		return nil
+	}
+	if source.Spec.Quantization == quantization.Spec.Quantization {
+		quantization.Status.Phase = neuronetes.QuantizationFailed
+		quantization.Status.Message = fmt.Sprintf("model %s is already %s", key.Name, quantization.Spec.Quantization)
+		return nil
+	}
+
+	rev, err := r.sourceRevision(quantization, &source)
+	if err != nil {
+		return err
+	}
+	if quantization.Status.SourceRevision == rev && quantization.Status.Phase == neuronetes.QuantizationSucceeded {
+		// Converted already: only the settings of the source model beside
+		// its weights change the derived model. A deleted derived model is
+		// converted again.
+		derived := &neuronetes.Model{}
+		err := r.Get(ctx, types.NamespacedName{Namespace: quantization.Namespace, Name: quantization.Status.Model}, derived)
+		if err == nil {
+			return r.reconcileDerivedModel(ctx, quantization, &source, rev, derived.Spec.WeightsURI, derived.Spec.Size)
+		}
+		if !apierrors.IsNotFound(err) {
+			return err
+		}
+	}
+
+	job := &batchv1.Job{}
+	name := quantizationJobName(quantization, rev)
+	if err := r.Get(ctx, types.NamespacedName{Namespace: quantization.Namespace, Name: name}, job); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return err
+		}
+		return r.startJob(ctx, quantization, &source, rev, name)
+	}
+
+	// Failed conversions are retried when the source changes or their Job
+	// is deleted
+	quantization.Status.JobName = name
+	if failed := jobCondition(job, batchv1.JobFailed); failed != nil {
+		if quantization.Status.Phase != neuronetes.QuantizationFailed {
+			now := metav1.NewTime(r.now())
+			quantization.Status.CompletionTime = &now
+		}
+		quantization.Status.Phase = neuronetes.QuantizationFailed
+		quantization.Status.Message = fmt.Sprintf("conversion job %s failed: %s", name, failed.Message)
+		return nil
+	}
+	if jobCondition(job, batchv1.JobComplete) == nil {
+		quantization.Status.Phase = neuronetes.QuantizationRunning
+		quantization.Status.Message = fmt.Sprintf("converting the weights of model %s to %s", key.Name, quantization.Spec.Quantization)
+		return nil
+	}
+
+	report, err := r.jobReport(ctx, job)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "ignoring quantizer report", "job", name)
+	}
+	size := capacity.QuantizedSize(defaultedSpec(&source), quantization.Spec.Quantization)
+	if report.Size > 0 {
+		size = *resource.NewQuantity(report.Size, resource.BinarySI)
+	}
+	uri := quantizationOutputURI(quantization, rev)
+	if err := r.reconcileDerivedModel(ctx, quantization, &source, rev, uri, size); err != nil {
+		return err
+	}
+
+	if quantization.Status.SourceRevision != rev || quantization.Status.Phase != neuronetes.QuantizationSucceeded {
+		log.FromContext(ctx).Info("Quantized model", "model", quantization.Status.Model, "weightsURI", uri)
+		now := metav1.NewTime(r.now())
+		quantization.Status.CompletionTime = &now
+	}
+	quantization.Status.Phase = neuronetes.QuantizationSucceeded
+	quantization.Status.SourceRevision = rev
+	quantization.Status.Size = &size
+	quantization.Status.Message = fmt.Sprintf("quantized weights uploaded to %s", uri)
+	return nil
+}
+
+// startJob creates the conversion Job of revision rev of source, and
+// deletes the Jobs of earlier revisions
+func (r *ModelQuantizationReconciler) startJob(ctx context.Context, quantization *neuronetes.ModelQuantization, source *neuronetes.Model, rev, name string) error {
+	job := r.buildJob(quantization, source, rev, name)
+	if err := ctrl.SetControllerReference(quantization, job, r.Scheme); err != nil {
+		return err
+	}
+	if err := r.Create(ctx, job); err != nil {
+		return fmt.Errorf("failed to create conversion job %s: %w", name, err)
+	}
+	log.FromContext(ctx).Info("Started conversion job", "job", name, "model", source.Name, "quantization", quantization.Spec.Quantization)
+
+	var jobs batchv1.JobList
+	if err := r.List(ctx, &jobs, client.InNamespace(quantization.Namespace), client.MatchingLabels{neuronetes.LabelQuantization: quantization.Name}); err != nil {
+		return err
+	}
+	for i := range jobs.Items {
+		if jobs.Items[i].Name == name {
+			continue
+		}
+		if err := r.Delete(ctx, &jobs.Items[i], client.PropagationPolicy(metav1.DeletePropagationBackground)); client.IgnoreNotFound(err) != nil {
+			return fmt.Errorf("failed to delete conversion job %s: %w", jobs.Items[i].Name, err)
+		}
+	}
+
+	now := metav1.NewTime(r.now())
+	quantization.Status.Phase = neuronetes.QuantizationRunning
+	quantization.Status.JobName = name
+	quantization.Status.StartTime = &now
+	quantization.Status.CompletionTime = nil
+	quantization.Status.Message = fmt.Sprintf("converting the weights of model %s to %s", source.Name, quantization.Spec.Quantization)
+	return nil
+}
+
+// buildJob returns the Job converting the weights of source
+func (r *ModelQuantizationReconciler) buildJob(quantization *neuronetes.ModelQuantization, source *neuronetes.Model, rev, name string) *batchv1.Job {
+	args := []string{
+		"--source=" + source.Spec.WeightsURI,
+		"--quantization=" + quantization.Spec.Quantization,
+		"--output=" + quantizationOutputURI(quantization, rev),
+		"--work-dir=" + quantizerWorkDir,
+	}
+	if quantization.Spec.Method != "" {
+		args = append(args, "--method="+quantization.Spec.Method)
+	}
+	if source.Spec.Format != "" {
+		args = append(args, "--format="+source.Spec.Format)
+	}
+	for _, pattern := range source.Spec.FilePatterns {
+		args = append(args, "--file-pattern="+pattern)
+	}
+
+	var env []corev1.EnvVar
+	if ref := source.Spec.CredentialsSecretRef; ref != nil {
+		env = append(env, corev1.EnvVar{Name: "SOURCE_TOKEN", ValueFrom: &corev1.EnvVarSource{SecretKeyRef: ref.DeepCopy()}})
+	}
+	if ref := quantization.Spec.OutputCredentialsSecretRef; ref != nil {
+		env = append(env, corev1.EnvVar{Name: "OUTPUT_TOKEN", ValueFrom: &corev1.EnvVarSource{SecretKeyRef: ref.DeepCopy()}})
+	}
+
+	gpus := quantization.Spec.GPUs
+	if gpus < 1 {
+		gpus = 1
+	}
+	labels := map[string]string{
+		neuronetes.LabelQuantization: quantization.Name,
+		neuronetes.LabelComponent:    "quantizer",
+	}
+	backoffLimit := int32(quantizerBackoffLimit)
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: quantization.Namespace, Labels: labels},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: corev1.PodSpec{
+					RestartPolicy:      corev1.RestartPolicyNever,
+					ServiceAccountName: quantization.Spec.ServiceAccountName,
+					NodeSelector:       quantization.Spec.NodeSelector,
+					Containers: []corev1.Container{{
+						Name:  "quantizer",
+						Image: r.image(quantization),
+						Args:  args,
+						Env:   env,
+						Resources: corev1.ResourceRequirements{
+							Limits: corev1.ResourceList{gpu.NVIDIAResource: *resource.NewQuantity(int64(gpus), resource.DecimalSI)},
+						},
+						VolumeMounts:             []corev1.VolumeMount{{Name: "work", MountPath: quantizerWorkDir}},
+						TerminationMessagePolicy: corev1.TerminationMessageReadFile,
+					}},
+					Volumes: []corev1.Volume{{
+						Name:         "work",
+						VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+					}},
+				},
+			},
+		},
+	}
+}
+
+// jobReport returns the report of the quantizer container of a succeeded
+// pod of job
+func (r *ModelQuantizationReconciler) jobReport(ctx context.Context, job *batchv1.Job) (quantizationReport, error) {
+	var pods corev1.PodList
+	if err := r.List(ctx, &pods, client.InNamespace(job.Namespace), client.MatchingLabels{batchv1.JobNameLabel: job.Name}); err != nil {
+		return quantizationReport{}, err
+	}
+	for _, pod := range pods.Items {
+		if pod.Status.Phase != corev1.PodSucceeded {
+			continue
+		}
+		for _, status := range pod.Status.ContainerStatuses {
+			if status.Name != "quantizer" || status.State.Terminated == nil || status.State.Terminated.Message == "" {
+				continue
+			}
+			var report quantizationReport
+			if err := json.Unmarshal([]byte(status.State.Terminated.Message), &report); err != nil {
+				return quantizationReport{}, fmt.Errorf("invalid report of pod %s: %w", pod.Name, err)
+			}
+			return report, nil
+		}
+	}
+	return quantizationReport{}, nil
+}
+
+// reconcileDerivedModel creates or updates the derived model of
+// quantization, a copy of source serving the quantized weights at uri. The
+// digests of the source weights do not apply to them.
+func (r *ModelQuantizationReconciler) reconcileDerivedModel(ctx context.Context, quantization *neuronetes.ModelQuantization, source *neuronetes.Model, rev, uri string, size resource.Quantity) error {
+	derived := &neuronetes.Model{ObjectMeta: metav1.ObjectMeta{
+		Name:      quantization.Status.Model,
+		Namespace: quantization.Namespace,
+	}}
+	if _, err := controllerutil.CreateOrUpdate(ctx, r.Client, derived, func() error {
+		if derived.Labels == nil {
+			derived.Labels = make(map[string]string)
+		}
+		derived.Labels[neuronetes.LabelQuantization] = quantization.Name
+		if derived.Annotations == nil {
+			derived.Annotations = make(map[string]string)
+		}
+		derived.Annotations[neuronetes.AnnotationSourceModel] = source.Name
+		derived.Annotations[neuronetes.AnnotationSourceWeights] = source.Spec.WeightsURI
+		derived.Annotations[neuronetes.AnnotationSourceRevision] = rev
+
+		derived.Spec = *source.Spec.DeepCopy()
+		derived.Spec.WeightsURI = uri
+		derived.Spec.CredentialsSecretRef = quantization.Spec.OutputCredentialsSecretRef.DeepCopy()
+		derived.Spec.FilePatterns = nil
+		derived.Spec.ImagePullSecrets = nil
+		derived.Spec.Integrity = nil
+		derived.Spec.Size = size
+		derived.Spec.Quantization = quantization.Spec.Quantization
+		return nil
+	}); err != nil {
+		return fmt.Errorf("failed to reconcile derived model %s: %w", derived.Name, err)
+	}
+	return nil
+}
+
+// sourceRevision hashes what the quantized weights of source are converted
+// from and how. A change runs a new conversion.
+func (r *ModelQuantizationReconciler) sourceRevision(quantization *neuronetes.ModelQuantization, source *neuronetes.Model) (string, error) {
+	data, err := json.Marshal(struct {
+		WeightsURI     string                     `json:"weightsURI"`
+		FilePatterns   []string                   `json:"filePatterns,omitempty"`
+		Integrity      *neuronetes.ModelIntegrity `json:"integrity,omitempty"`
+		Format         string                     `json:"format,omitempty"`
+		Architecture   string                     `json:"architecture,omitempty"`
+		ParameterCount string                     `json:"parameterCount,omitempty"`
+		From           string                     `json:"from,omitempty"`
+		To             string                     `json:"to"`
+		Method         string                     `json:"method,omitempty"`
+		Image          string                     `json:"image"`
+		OutputURI      string                     `json:"outputURI"`
+	}{
+		WeightsURI:     source.Spec.WeightsURI,
+		FilePatterns:   source.Spec.FilePatterns,
+		Integrity:      source.Spec.Integrity,
+		Format:         source.Spec.Format,
+		Architecture:   source.Spec.Architecture,
+		ParameterCount: source.Spec.ParameterCount,
+		From:           source.Spec.Quantization,
+		To:             quantization.Spec.Quantization,
+		Method:         quantization.Spec.Method,
+		Image:          r.image(quantization),
+		OutputURI:      quantization.Spec.OutputURI,
+	})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])[:10], nil
+}
+
+// image returns the quantizer image of quantization
+func (r *ModelQuantizationReconciler) image(quantization *neuronetes.ModelQuantization) string {
+	if quantization.Spec.Image != "" {
+		return quantization.Spec.Image
+	}
+	if r.Image != "" {
+		return r.Image
+	}
+	return DefaultQuantizerImage
+}
+
+func (r *ModelQuantizationReconciler) now() time.Time {
+	if r.clock != nil {
+		return r.clock()
+	}
+	return time.Now()
+}
+
+// targetModelName returns the name of the derived model of quantization
+func targetModelName(quantization *neuronetes.ModelQuantization) string {
+	if quantization.Spec.TargetModel != "" {
+		return quantization.Spec.TargetModel
+	}
+	return quantization.Spec.SourceModelRef.Name + "-" + quantization.Spec.Quantization
+}
+
+// quantizationJobName returns the name of the conversion Job of revision
+// rev
+func quantizationJobName(quantization *neuronetes.ModelQuantization, rev string) string {
+	return quantization.Name + "-" + rev
+}
+
+// quantizationOutputURI returns where the weights of revision rev are
+// uploaded
+func quantizationOutputURI(quantization *neuronetes.ModelQuantization, rev string) string {
+	return strings.TrimSuffix(quantization.Spec.OutputURI, "/") + "/" + rev
+}
+
+// jobCondition returns the condition of job of type t if it is true
+func jobCondition(job *batchv1.Job, t batchv1.JobConditionType) *batchv1.JobCondition {
+	for i := range job.Status.Conditions {
+		if job.Status.Conditions[i].Type == t && job.Status.Conditions[i].Status == corev1.ConditionTrue {
+			return &job.Status.Conditions[i]
+		}
+	}
+	return nil
+}
+
+// quantizationsForModel maps a Model to the quantizations of it, and to the
+// quantization it was derived by
+func (r *ModelQuantizationReconciler) quantizationsForModel(ctx context.Context, obj client.Object) []reconcile.Request {
+	var quantizations neuronetes.ModelQuantizationList
+	if err := r.List(ctx, &quantizations, client.InNamespace(obj.GetNamespace())); err != nil {
+		log.FromContext(ctx).Error(err, "failed to list model quantizations", "model", obj.GetName())
+		return nil
+	}
+	var requests []reconcile.Request
+	for i := range quantizations.Items {
+		quantization := &quantizations.Items[i]
+		if quantization.Spec.SourceModelRef.Name == obj.GetName() || quantization.Name == obj.GetLabels()[neuronetes.LabelQuantization] {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(quantization)})
+		}
+	}
+	return requests
+}
+
+// SetupWithManager sets up the controller with the Manager. Changes to the
+// source or derived model of a quantization reconcile it.
+func (r *ModelQuantizationReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&neuronetes.ModelQuantization{}).
+		Owns(&batchv1.Job{}).
+		Watches(&neuronetes.Model{}, handler.EnqueueRequestsFromMapFunc(r.quantizationsForModel)).
+		Complete(r)
+}
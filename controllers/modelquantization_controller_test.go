@@ -0,0 +1,205 @@
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/gpu"
+)
+
+func newTestQuantization() *neuronetes.ModelQuantization {
+	return &neuronetes.ModelQuantization{
+		ObjectMeta: metav1.ObjectMeta{Name: "llama-int4", Namespace: "default", UID: "quantization-uid"},
+		Spec: neuronetes.ModelQuantizationSpec{
+			SourceModelRef: corev1.LocalObjectReference{Name: "llama-3-8b"},
+			Quantization:   "int4",
+			Method:         "awq",
+			OutputURI:      "s3://models/quantized/llama-3-8b-int4/",
+			NodeSelector:   map[string]string{"nvidia.com/gpu.product": "NVIDIA-H100-80GB-HBM3"},
+		},
+	}
+}
+
+func reconcileQuantization(t *testing.T, r *ModelQuantizationReconciler, key types.NamespacedName) *neuronetes.ModelQuantization {
+	t.Helper()
+	_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: key})
+	require.NoError(t, err)
+	var quantization neuronetes.ModelQuantization
+	require.NoError(t, r.Get(context.Background(), key, &quantization))
+	return &quantization
+}
+
+// completeJob marks job as complete, with a succeeded pod reporting report
+func completeJob(t *testing.T, c client.Client, name, report string) {
+	t.Helper()
+	ctx := context.Background()
+	job := &batchv1.Job{}
+	require.NoError(t, c.Get(ctx, types.NamespacedName{Namespace: "default", Name: name}, job))
+	job.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}}
+	require.NoError(t, c.Status().Update(ctx, job))
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name + "-abcde",
+			Namespace: "default",
+			Labels:    map[string]string{batchv1.JobNameLabel: name},
+		},
+		Status: corev1.PodStatus{
+			Phase: corev1.PodSucceeded,
+			ContainerStatuses: []corev1.ContainerStatus{{
+				Name:  "quantizer",
+				State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Message: report}},
+			}},
+		},
+	}
+	require.NoError(t, c.Create(ctx, pod))
+}
+
+func TestModelQuantizationReconcilerDerivesModel(t *testing.T) {
+	source := newTestModel()
+	source.Spec.ParameterCount = "8B"
+	source.Spec.Quantization = "fp16"
+	source.Spec.CredentialsSecretRef = &corev1.SecretKeySelector{
+		LocalObjectReference: corev1.LocalObjectReference{Name: "hf-token"},
+		Key:                  "token",
+	}
+	source.Spec.Integrity = &neuronetes.ModelIntegrity{SHA256: map[string]string{"model.safetensors": "ab12"}}
+	quantization := newTestQuantization()
+	key := client.ObjectKeyFromObject(quantization)
+	c := newFakeClient(t, source, quantization)
+	r := &ModelQuantizationReconciler{Client: c, Scheme: c.Scheme()}
+	ctx := context.Background()
+
+	// A conversion Job runs on a GPU node
+	got := reconcileQuantization(t, r, key)
+	assert.Equal(t, neuronetes.QuantizationRunning, got.Status.Phase)
+	assert.Equal(t, "llama-3-8b-int4", got.Status.Model)
+	require.True(t, strings.HasPrefix(got.Status.JobName, "llama-int4-"))
+	rev := strings.TrimPrefix(got.Status.JobName, "llama-int4-")
+	require.Len(t, rev, 10)
+
+	job := &batchv1.Job{}
+	require.NoError(t, c.Get(ctx, types.NamespacedName{Namespace: "default", Name: got.Status.JobName}, job))
+	require.Len(t, job.OwnerReferences, 1)
+	assert.Equal(t, "llama-int4", job.OwnerReferences[0].Name)
+	pod := job.Spec.Template.Spec
+	assert.Equal(t, corev1.RestartPolicyNever, pod.RestartPolicy)
+	assert.Equal(t, "NVIDIA-H100-80GB-HBM3", pod.NodeSelector["nvidia.com/gpu.product"])
+	container := pod.Containers[0]
+	assert.Equal(t, DefaultQuantizerImage, container.Image)
+	assert.Equal(t, []string{
+		"--source=s3://models/llama-3-8b",
+		"--quantization=int4",
+		"--output=s3://models/quantized/llama-3-8b-int4/" + rev,
+		"--work-dir=/work",
+		"--method=awq",
+	}, container.Args)
+	require.Len(t, container.Env, 1)
+	assert.Equal(t, "SOURCE_TOKEN", container.Env[0].Name)
+	assert.Equal(t, "hf-token", container.Env[0].ValueFrom.SecretKeyRef.Name)
+	gpus := container.Resources.Limits[gpu.NVIDIAResource]
+	assert.Equal(t, int64(1), gpus.Value())
+
+	// Once it uploaded the weights, the derived model serves them
+	completeJob(t, c, got.Status.JobName, `{"size": 4508876800}`)
+	got = reconcileQuantization(t, r, key)
+	assert.Equal(t, neuronetes.QuantizationSucceeded, got.Status.Phase)
+	assert.Equal(t, rev, got.Status.SourceRevision)
+	assert.Equal(t, "4300Mi", got.Status.Size.String())
+	assert.NotNil(t, got.Status.CompletionTime)
+
+	derived := &neuronetes.Model{}
+	require.NoError(t, c.Get(ctx, types.NamespacedName{Namespace: "default", Name: "llama-3-8b-int4"}, derived))
+	assert.Equal(t, "s3://models/quantized/llama-3-8b-int4/"+rev, derived.Spec.WeightsURI)
+	assert.Equal(t, "int4", derived.Spec.Quantization)
+	assert.Equal(t, "4300Mi", derived.Spec.Size.String())
+	assert.Equal(t, "8B", derived.Spec.ParameterCount)
+	assert.Nil(t, derived.Spec.CredentialsSecretRef)
+	assert.Nil(t, derived.Spec.Integrity)
+	assert.Equal(t, "llama-int4", derived.Labels[neuronetes.LabelQuantization])
+	assert.Equal(t, "llama-3-8b", derived.Annotations[neuronetes.AnnotationSourceModel])
+	assert.Equal(t, "s3://models/llama-3-8b", derived.Annotations[neuronetes.AnnotationSourceWeights])
+	assert.Equal(t, rev, derived.Annotations[neuronetes.AnnotationSourceRevision])
+	assert.Equal(t, []ctrl.Request{{NamespacedName: key}}, r.quantizationsForModel(ctx, derived))
+
+	// Settings of the source beside its weights are copied without a
+	// conversion
+	require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(source), source))
+	source.Spec.CachePolicy = &neuronetes.CachePolicy{Priority: "critical"}
+	require.NoError(t, c.Update(ctx, source))
+	got = reconcileQuantization(t, r, key)
+	assert.Equal(t, neuronetes.QuantizationSucceeded, got.Status.Phase)
+	require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(derived), derived))
+	assert.Equal(t, "critical", derived.Spec.CachePolicy.Priority)
+	assert.Equal(t, "s3://models/quantized/llama-3-8b-int4/"+rev, derived.Spec.WeightsURI)
+
+	// New source weights are converted again, replacing the old Job, while
+	// the derived model keeps serving the old weights
+	oldJob := got.Status.JobName
+	source.Spec.WeightsURI = "s3://models/llama-3.1-8b"
+	require.NoError(t, c.Update(ctx, source))
+	got = reconcileQuantization(t, r, key)
+	assert.Equal(t, neuronetes.QuantizationRunning, got.Status.Phase)
+	assert.NotEqual(t, oldJob, got.Status.JobName)
+	assert.True(t, apierrors.IsNotFound(c.Get(ctx, types.NamespacedName{Namespace: "default", Name: oldJob}, &batchv1.Job{})))
+	require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(derived), derived))
+	assert.Equal(t, "s3://models/quantized/llama-3-8b-int4/"+rev, derived.Spec.WeightsURI)
+
+	// Failed conversions are reported
+	require.NoError(t, c.Get(ctx, types.NamespacedName{Namespace: "default", Name: got.Status.JobName}, job))
+	job.Status.Conditions = []batchv1.JobCondition{{
+		Type: batchv1.JobFailed, Status: corev1.ConditionTrue, Reason: "BackoffLimitExceeded",
+		Message: "Job has reached the specified backoff limit",
+	}}
+	require.NoError(t, c.Status().Update(ctx, job))
+	got = reconcileQuantization(t, r, key)
+	assert.Equal(t, neuronetes.QuantizationFailed, got.Status.Phase)
+	assert.Equal(t, "conversion job "+job.Name+" failed: Job has reached the specified backoff limit", got.Status.Message)
+}
+
+func TestModelQuantizationReconcilerWaitsForSource(t *testing.T) {
+	quantization := newTestQuantization()
+	key := client.ObjectKeyFromObject(quantization)
+	c := newFakeClient(t, quantization)
+	r := &ModelQuantizationReconciler{Client: c, Scheme: c.Scheme(), Image: "quantizer:v1"}
+
+	got := reconcileQuantization(t, r, key)
+	assert.Equal(t, neuronetes.QuantizationPending, got.Status.Phase)
+	assert.Equal(t, "waiting for model llama-3-8b", got.Status.Message)
+
+	// Without a parameter count the size is estimated from the source
+	source := newTestModel()
+	require.NoError(t, c.Create(context.Background(), source))
+	got = reconcileQuantization(t, r, key)
+	job := &batchv1.Job{}
+	require.NoError(t, c.Get(context.Background(), types.NamespacedName{Namespace: "default", Name: got.Status.JobName}, job))
+	assert.Equal(t, "quantizer:v1", job.Spec.Template.Spec.Containers[0].Image)
+	completeJob(t, c, got.Status.JobName, "")
+	got = reconcileQuantization(t, r, key)
+	assert.Equal(t, neuronetes.QuantizationSucceeded, got.Status.Phase)
+	assert.Equal(t, "4Gi", got.Status.Size.String())
+
+	// Models are not quantized to their own format
+	quantization = newTestQuantization()
+	quantization.Name = "llama-noop"
+	quantization.Spec.Quantization = "int8"
+	source = newTestModel()
+	source.Spec.Quantization = "int8"
+	c = newFakeClient(t, source, quantization)
+	r = &ModelQuantizationReconciler{Client: c, Scheme: c.Scheme()}
+	got = reconcileQuantization(t, r, client.ObjectKeyFromObject(quantization))
+	assert.Equal(t, neuronetes.QuantizationFailed, got.Status.Phase)
+	assert.Equal(t, "model llama-3-8b is already int8", got.Status.Message)
+}
@@ -0,0 +1,353 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/capacity"
+	"github.com/bowenislandsong/neuronetes/pkg/registry"
+)
+
+const (
+	// ConditionSynced reports whether the models of a ModelRegistry are in
+	// step with the registry
+	ConditionSynced = "Synced"
+
+	// DefaultRegistryInterval is the period between syncs of registries
+	// that do not set one
+	DefaultRegistryInterval = 10 * time.Minute
+)
+
+// defaultTagMappings set the fields of synced models without a mapping from
+// the tag named after them
+var defaultTagMappings = []neuronetes.TagMapping{
+	{Tag: "quantization", Field: "quantization"},
+	{Tag: "format", Field: "format"},
+	{Tag: "architecture", Field: "architecture"},
+	{Tag: "parameters", Field: "parameterCount"},
+	{Tag: "size", Field: "size"},
+}
+
+// quantizations are the values of the quantization of a Model
+var quantizations = map[string]bool{"fp32": true, "fp16": true, "int8": true, "int4": true, "none": true}
+
+// ModelRegistryReconciler reconciles a ModelRegistry object. It lists the
+// models of a registry namespace every interval and creates, updates and
+// deletes the Models synced from it to match.
+type ModelRegistryReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+
+	// NewLister creates the lister of the models of a registry. Defaults
+	// to registry.New.
+	NewLister func(typ, url, namespace, token string) (registry.Lister, error)
+
+	// clock overrides time.Now in tests
+	clock func() time.Time
+}
+
+// +kubebuilder:rbac:groups=neuronetes.io,resources=modelregistries,verbs=get;list;watch
+// +kubebuilder:rbac:groups=neuronetes.io,resources=modelregistries/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=neuronetes.io,resources=models,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch
+
+// Reconcile syncs the models of a registry once its interval passed or its
+// spec changed
+func (r *ModelRegistryReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	var reg neuronetes.ModelRegistry
+	if err := r.Get(ctx, req.NamespacedName, &reg); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	interval := DefaultRegistryInterval
+	if reg.Spec.Interval != nil && reg.Spec.Interval.Duration > 0 {
+		interval = reg.Spec.Interval.Duration
+	}
+	if reg.Status.ObservedGeneration == reg.Generation && reg.Status.LastSyncTime != nil {
+		if wait := reg.Status.LastSyncTime.Add(interval).Sub(r.now()); wait > 0 {
+			return ctrl.Result{RequeueAfter: wait}, nil
+		}
+	}
+
+	synced, skipped, err := r.sync(ctx, &reg)
+	if err != nil {
+		meta.SetStatusCondition(&reg.Status.Conditions, metav1.Condition{
+			Type:               ConditionSynced,
+			Status:             metav1.ConditionFalse,
+			Reason:             "SyncFailed",
+			Message:            err.Error(),
+			ObservedGeneration: reg.Generation,
+		})
+		if statusErr := r.Status().Update(ctx, &reg); statusErr != nil {
+			return ctrl.Result{}, statusErr
+		}
+		return ctrl.Result{}, err
+	}
+
+	message := fmt.Sprintf("synced %d models", synced)
+	if len(skipped) > 0 {
+		message += fmt.Sprintf(", skipped %d: %s", len(skipped), strings.Join(skipped, "; "))
+	}
+	now := metav1.NewTime(r.now())
+	reg.Status.ObservedGeneration = reg.Generation
+	reg.Status.Models = int32(synced)
+	reg.Status.LastSyncTime = &now
+	meta.SetStatusCondition(&reg.Status.Conditions, metav1.Condition{
+		Type:               ConditionSynced,
+		Status:             metav1.ConditionTrue,
+		Reason:             "Synced",
+		Message:            message,
+		ObservedGeneration: reg.Generation,
+	})
+	if err := r.Status().Update(ctx, &reg); err != nil {
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{RequeueAfter: interval}, nil
+}
+
+// sync creates or updates a Model for each model of the registry and deletes
+// the Models of models no longer in it. It returns how many models are
+// synced and why the others were skipped.
+func (r *ModelRegistryReconciler) sync(ctx context.Context, reg *neuronetes.ModelRegistry) (int, []string, error) {
+	log := log.FromContext(ctx)
+
+	token, err := r.token(ctx, reg)
+	if err != nil {
+		return 0, nil, err
+	}
+	newLister := r.NewLister
+	if newLister == nil {
+		newLister = registry.New
+	}
+	lister, err := newLister(reg.Spec.Type, reg.Spec.URL, reg.Spec.Namespace, token)
+	if err != nil {
+		return 0, nil, err
+	}
+	published, err := lister.List(ctx)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to list registry models: %w", err)
+	}
+
+	var existing neuronetes.ModelList
+	if err := r.List(ctx, &existing, client.InNamespace(reg.Namespace), client.MatchingLabels{neuronetes.LabelRegistry: reg.Name}); err != nil {
+		return 0, nil, fmt.Errorf("failed to list models: %w", err)
+	}
+	owned := make(map[string]bool, len(existing.Items))
+	for i := range existing.Items {
+		owned[existing.Items[i].Name] = true
+	}
+
+	sort.Slice(published, func(i, j int) bool { return published[i].Name < published[j].Name })
+	synced := make(map[string]bool, len(published))
+	var skipped []string
+	for _, entry := range published {
+		name := registryModelName(entry.Name)
+		switch {
+		case name == "":
+			skipped = append(skipped, fmt.Sprintf("%s: no valid model name", entry.Name))
+			continue
+		case synced[name]:
+			skipped = append(skipped, fmt.Sprintf("%s: model %s is synced from another registry model", entry.Name, name))
+			continue
+		}
+		spec, err := registryModelSpec(reg, entry)
+		if err != nil {
+			skipped = append(skipped, fmt.Sprintf("%s: %v", entry.Name, err))
+			continue
+		}
+		if !owned[name] {
+			err := r.Get(ctx, types.NamespacedName{Namespace: reg.Namespace, Name: name}, &neuronetes.Model{})
+			if err == nil {
+				skipped = append(skipped, fmt.Sprintf("%s: model %s exists and is not synced from the registry", entry.Name, name))
+				continue
+			}
+			if !apierrors.IsNotFound(err) {
+				return 0, nil, err
+			}
+		}
+
+		model := &neuronetes.Model{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: reg.Namespace}}
+		result, err := controllerutil.CreateOrUpdate(ctx, r.Client, model, func() error {
+			if model.Labels == nil {
+				model.Labels = make(map[string]string)
+			}
+			if model.Annotations == nil {
+				model.Annotations = make(map[string]string)
+			}
+			if reg.Spec.Template != nil {
+				for k, v := range reg.Spec.Template.Labels {
+					model.Labels[k] = v
+				}
+				for k, v := range reg.Spec.Template.Annotations {
+					model.Annotations[k] = v
+				}
+			}
+			model.Labels[neuronetes.LabelRegistry] = reg.Name
+			model.Annotations[neuronetes.AnnotationRegistryModel] = entry.Name
+			model.Annotations[neuronetes.AnnotationRegistryVersion] = entry.Version
+			model.Spec = *spec
+			return nil
+		})
+		if err != nil {
+			return 0, nil, fmt.Errorf("failed to sync model %s: %w", name, err)
+		}
+		if result != controllerutil.OperationResultNone {
+			log.Info("Synced model from registry", "model", name, "registryModel", entry.Name, "version", entry.Version, "operation", result)
+		}
+		synced[name] = true
+	}
+
+	// Models removed from the registry are deleted, unless kept
+	for i := range existing.Items {
+		model := &existing.Items[i]
+		if synced[model.Name] || model.Annotations[neuronetes.AnnotationKeepAlways] == "true" {
+			continue
+		}
+		if err := r.Delete(ctx, model); client.IgnoreNotFound(err) != nil {
+			return 0, nil, fmt.Errorf("failed to delete model %s: %w", model.Name, err)
+		}
+		log.Info("Deleted model removed from registry", "model", model.Name)
+	}
+	return len(synced), skipped, nil
+}
+
+// token returns the token of the registry, if it has credentials
+func (r *ModelRegistryReconciler) token(ctx context.Context, reg *neuronetes.ModelRegistry) (string, error) {
+	ref := reg.Spec.CredentialsSecretRef
+	if ref == nil {
+		return "", nil
+	}
+	var secret corev1.Secret
+	if err := r.Get(ctx, types.NamespacedName{Namespace: reg.Namespace, Name: ref.Name}, &secret); err != nil {
+		return "", fmt.Errorf("failed to get registry credentials: %w", err)
+	}
+	value, ok := secret.Data[ref.Key]
+	if !ok {
+		return "", fmt.Errorf("secret %s has no key %s", ref.Name, ref.Key)
+	}
+	return strings.TrimSpace(string(value)), nil
+}
+
+func (r *ModelRegistryReconciler) now() time.Time {
+	if r.clock != nil {
+		return r.clock()
+	}
+	return time.Now()
+}
+
+// registryModelSpec returns the spec of the Model synced from entry: its
+// weights, with the fields its tags map to and those of the template
+func registryModelSpec(reg *neuronetes.ModelRegistry, entry registry.Model) (*neuronetes.ModelSpec, error) {
+	spec := &neuronetes.ModelSpec{
+		WeightsURI:           entry.WeightsURI,
+		CredentialsSecretRef: reg.Spec.CredentialsSecretRef.DeepCopy(),
+	}
+	if entry.Size > 0 {
+		spec.Size = *resource.NewQuantity(entry.Size, resource.BinarySI)
+	}
+	if entry.ParameterCount > 0 {
+		spec.ParameterCount = capacity.FormatParameterCount(float64(entry.ParameterCount))
+	}
+	if template := reg.Spec.Template; template != nil {
+		if template.FilePatterns != nil {
+			spec.FilePatterns = append([]string(nil), template.FilePatterns...)
+		}
+		spec.CachePolicy = template.CachePolicy.DeepCopy()
+		spec.ShardSpec = template.ShardSpec.DeepCopy()
+		spec.Serving = template.Serving.DeepCopy()
+	}
+
+	mapped := make(map[string]bool)
+	for _, mapping := range reg.Spec.TagMappings {
+		mapped[mapping.Field] = true
+	}
+	mappings := append([]neuronetes.TagMapping(nil), reg.Spec.TagMappings...)
+	for _, mapping := range defaultTagMappings {
+		if !mapped[mapping.Field] {
+			mappings = append(mappings, mapping)
+		}
+	}
+	for _, mapping := range mappings {
+		value, ok := entry.Tags[mapping.Tag]
+		if !ok {
+			continue
+		}
+		if mapping.Value != "" {
+			value = mapping.Value
+		}
+		if value == "" {
+			continue
+		}
+		switch mapping.Field {
+		case "quantization":
+			if !quantizations[strings.ToLower(value)] {
+				return nil, fmt.Errorf("unsupported quantization %q", value)
+			}
+			spec.Quantization = strings.ToLower(value)
+		case "format":
+			spec.Format = value
+		case "architecture":
+			spec.Architecture = value
+		case "parameterCount":
+			spec.ParameterCount = value
+		case "size":
+			size, err := resource.ParseQuantity(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid size %q", value)
+			}
+			spec.Size = size
+		}
+	}
+	if spec.Size.IsZero() {
+		return nil, fmt.Errorf("unknown size")
+	}
+	return spec, nil
+}
+
+// registryModelName returns the name of the Model synced from the registry
+// model name: its last path segment as a DNS label, e.g. llama-3-1-8b for
+// meta-llama/Llama-3.1-8B
+func registryModelName(name string) string {
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	var b strings.Builder
+	for _, c := range strings.ToLower(name) {
+		if (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') {
+			b.WriteRune(c)
+		} else {
+			b.WriteByte('-')
+		}
+	}
+	s := b.String()
+	for strings.Contains(s, "--") {
+		s = strings.ReplaceAll(s, "--", "-")
+	}
+	if len(s) > 63 {
+		s = s[:63]
+	}
+	return strings.Trim(s, "-")
+}
+
+// SetupWithManager sets up the controller with the Manager
+func (r *ModelRegistryReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&neuronetes.ModelRegistry{}).
+		Complete(r)
+}
@@ -0,0 +1,163 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/registry"
+)
+
+// fakeRegistry lists a fixed set of models
+type fakeRegistry struct {
+	models []registry.Model
+	lists  int
+}
+
+func (f *fakeRegistry) List(context.Context) ([]registry.Model, error) {
+	f.lists++
+	return append([]registry.Model(nil), f.models...), nil
+}
+
+func registryModels(t *testing.T, c client.Client) map[string]neuronetes.Model {
+	t.Helper()
+	var models neuronetes.ModelList
+	require.NoError(t, c.List(context.Background(), &models))
+	byName := make(map[string]neuronetes.Model)
+	for _, model := range models.Items {
+		byName[model.Name] = model
+	}
+	return byName
+}
+
+func TestModelRegistryReconcilerSyncsModels(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now().Truncate(time.Second)
+	reg := &neuronetes.ModelRegistry{
+		ObjectMeta: metav1.ObjectMeta{Name: "acme-hub", Namespace: "default", Generation: 1},
+		Spec: neuronetes.ModelRegistrySpec{
+			Type:                 registry.TypeHuggingFace,
+			Namespace:            "acme-ai",
+			CredentialsSecretRef: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "hf-token"}, Key: "token"},
+			TagMappings:          []neuronetes.TagMapping{{Tag: "gguf", Field: "format", Value: "gguf"}},
+			Template: &neuronetes.RegistryModelTemplate{
+				Labels:      map[string]string{"team": "support"},
+				CachePolicy: &neuronetes.CachePolicy{Priority: "medium"},
+			},
+		},
+	}
+	key := client.ObjectKeyFromObject(reg)
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "hf-token", Namespace: "default"},
+		Data:       map[string][]byte{"token": []byte("hf_token\n")},
+	}
+	// A model of the same name not synced from the registry is left alone
+	manual := newTestModel()
+	manual.Name = "support-70b"
+	c := newFakeClient(t, reg, secret, manual)
+	published := &fakeRegistry{models: []registry.Model{
+		{Name: "acme-ai/Support-8B", Version: "abc123", WeightsURI: "hf://acme-ai/Support-8B@abc123", Size: 16 << 30, ParameterCount: 8030261248, Tags: map[string]string{"quantization": "fp16"}},
+		{Name: "acme-ai/Support-8B.GGUF", Version: "def456", WeightsURI: "hf://acme-ai/Support-8B.GGUF@def456", Size: 5 << 30, Tags: map[string]string{"gguf": "", "quantization": "q4_k_m"}},
+		{Name: "acme-ai/Support-3B", Version: "0f0f0f", WeightsURI: "hf://acme-ai/Support-3B@0f0f0f", Tags: map[string]string{"size": "6Gi", "gguf": ""}},
+		{Name: "acme-ai/Support-70B", Version: "777777", WeightsURI: "hf://acme-ai/Support-70B@777777", Size: 140 << 30},
+	}}
+	r := &ModelRegistryReconciler{
+		Client: c,
+		NewLister: func(typ, url, namespace, token string) (registry.Lister, error) {
+			assert.Equal(t, registry.TypeHuggingFace, typ)
+			assert.Equal(t, "acme-ai", namespace)
+			assert.Equal(t, "hf_token", token)
+			return published, nil
+		},
+	}
+	r.clock = func() time.Time { return now }
+	sync := func() (ctrl.Result, *neuronetes.ModelRegistry) {
+		t.Helper()
+		result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+		require.NoError(t, err)
+		var got neuronetes.ModelRegistry
+		require.NoError(t, c.Get(ctx, key, &got))
+		return result, &got
+	}
+
+	result, got := sync()
+	assert.Equal(t, ctrl.Result{RequeueAfter: DefaultRegistryInterval}, result)
+	assert.Equal(t, int32(2), got.Status.Models)
+	condition := meta.FindStatusCondition(got.Status.Conditions, ConditionSynced)
+	require.NotNil(t, condition)
+	assert.Equal(t, metav1.ConditionTrue, condition.Status)
+	assert.Equal(t, "synced 2 models, skipped 2: acme-ai/Support-70B: model support-70b exists and is not synced from the registry; "+
+		"acme-ai/Support-8B.GGUF: unsupported quantization \"q4_k_m\"", condition.Message)
+
+	models := registryModels(t, c)
+	require.Contains(t, models, "support-8b")
+	model := models["support-8b"]
+	assert.Equal(t, "acme-hub", model.Labels[neuronetes.LabelRegistry])
+	assert.Equal(t, "support", model.Labels["team"])
+	assert.Equal(t, "acme-ai/Support-8B", model.Annotations[neuronetes.AnnotationRegistryModel])
+	assert.Equal(t, "abc123", model.Annotations[neuronetes.AnnotationRegistryVersion])
+	assert.Equal(t, "hf://acme-ai/Support-8B@abc123", model.Spec.WeightsURI)
+	assert.Equal(t, "16Gi", model.Spec.Size.String())
+	assert.Equal(t, "8.03B", model.Spec.ParameterCount)
+	assert.Equal(t, "fp16", model.Spec.Quantization)
+	assert.Equal(t, "hf-token", model.Spec.CredentialsSecretRef.Name)
+	assert.Equal(t, "medium", model.Spec.CachePolicy.Priority)
+	// Tags map to fields
+	assert.Equal(t, "gguf", models["support-3b"].Spec.Format)
+	assert.Equal(t, resource.MustParse("6Gi"), models["support-3b"].Spec.Size)
+	assert.Equal(t, "s3://models/llama-3-8b", models["support-70b"].Spec.WeightsURI)
+
+	// The registry is not listed again before its interval passed
+	now = now.Add(4 * time.Minute)
+	result, _ = sync()
+	assert.Equal(t, ctrl.Result{RequeueAfter: 6 * time.Minute}, result)
+	assert.Equal(t, 1, published.lists)
+
+	// New versions update their model, and models removed from the
+	// registry are deleted unless kept
+	published.models[0].Version = "bcd234"
+	published.models[0].WeightsURI = "hf://acme-ai/Support-8B@bcd234"
+	published.models = published.models[:1]
+	now = now.Add(6 * time.Minute)
+	_, got = sync()
+	assert.Equal(t, int32(1), got.Status.Models)
+	models = registryModels(t, c)
+	assert.Equal(t, "hf://acme-ai/Support-8B@bcd234", models["support-8b"].Spec.WeightsURI)
+	assert.NotContains(t, models, "support-3b")
+	assert.Contains(t, models, "support-70b")
+
+	// Spec changes sync right away
+	published.models = nil
+	kept := models["support-8b"]
+	kept.Annotations[neuronetes.AnnotationKeepAlways] = "true"
+	require.NoError(t, c.Update(ctx, &kept))
+	got.Generation = 2
+	got.Spec.Interval = &metav1.Duration{Duration: time.Hour}
+	require.NoError(t, c.Update(ctx, got))
+	result, got = sync()
+	assert.Equal(t, ctrl.Result{RequeueAfter: time.Hour}, result)
+	assert.Equal(t, 3, published.lists)
+	assert.Equal(t, int32(0), got.Status.Models)
+	assert.Contains(t, registryModels(t, c), "support-8b")
+}
+
+func TestRegistryModelName(t *testing.T) {
+	for in, want := range map[string]string{
+		"meta-llama/Llama-3.1-8B-Instruct": "llama-3-1-8b-instruct",
+		"support_llama":                    "support-llama",
+		"org/team/__Draft__":               "draft",
+		"???":                              "",
+	} {
+		assert.Equal(t, want, registryModelName(in), in)
+	}
+}
@@ -0,0 +1,698 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/canary"
+)
+
+// DefaultRolloutInterval is how often a rollout is analyzed when its
+// analysis does not set an interval
+const DefaultRolloutInterval = 5 * time.Minute
+
+// rolloutPollInterval is how often a promoting rollout checks whether the
+// pools rolled to the new weights
+const rolloutPollInterval = 15 * time.Second
+
+// defaultRolloutSteps shift a tenth, then half of the traffic of a canary
+// rollout to the new weights
+var defaultRolloutSteps = []int32{10, 50}
+
+// defaultRolloutThresholds judge rollouts whose analysis leaves them unset
+var defaultRolloutThresholds = canary.Thresholds{
+	MaxErrorRate:    0.05,
+	MaxLatencyRatio: 1.5,
+	MinRequests:     100,
+	PromoteAfter:    2,
+}
+
+// ModelRolloutReconciler reconciles a ModelRollout object. It loads the new
+// weights as a copy of the Model, runs a canary of every pool serving the
+// model on them, and shifts the traffic of the pools to their canaries step
+// by step. Each step lasts until the canaries are healthy against the pools
+// for enough analyses; the new weights are then set on the Model, or rolled
+// back as soon as an analysis fails.
+type ModelRolloutReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+
+	// Stats reports the requests served by the pools and their canaries.
+	// Without it rollouts shift traffic to the first step and wait.
+	Stats canary.PoolStatsProvider
+
+	// clock overrides time.Now in tests
+	clock func() time.Time
+}
+
+// rolloutPair is a pool taking part in a rollout and its canary
+type rolloutPair struct {
+	baseline *neuronetes.AgentPool
+	canary   *neuronetes.AgentPool
+}
+
+// +kubebuilder:rbac:groups=neuronetes.io,resources=modelrollouts,verbs=get;list;watch;update;patch
+// +kubebuilder:rbac:groups=neuronetes.io,resources=modelrollouts/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=neuronetes.io,resources=models;agentclasses;agentpools,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch
+
+// Reconcile shifts the traffic of a rollout to the new weights, then
+// promotes or rolls them back
+func (r *ModelRolloutReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	var rollout neuronetes.ModelRollout
+	if err := r.Get(ctx, req.NamespacedName, &rollout); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return ctrl.Result{}, err
+		}
+		// The canaries of deleted rollouts are garbage collected, but the
+		// pools they split must route all traffic back to themselves
+		return ctrl.Result{}, r.removeSplits(ctx, req.NamespacedName)
+	}
+
+	switch rollout.Status.Phase {
+	case neuronetes.RolloutPromoted, neuronetes.RolloutRolledBack, neuronetes.RolloutFailed:
+		return ctrl.Result{}, nil
+	}
+
+	original := rollout.Status.DeepCopy()
+	result, err := r.reconcileRollout(ctx, &rollout)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if !equality.Semantic.DeepEqual(original, &rollout.Status) {
+		if err := r.Status().Update(ctx, &rollout); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	return result, nil
+}
+
+// reconcileRollout advances rollout, recording its progress in its status
+func (r *ModelRolloutReconciler) reconcileRollout(ctx context.Context, rollout *neuronetes.ModelRollout) (ctrl.Result, error) {
+	if rollout.Status.Phase == "" {
+		rollout.Status.Phase = neuronetes.RolloutPending
+	}
+
+	var model neuronetes.Model
+	key := types.NamespacedName{Namespace: rollout.Namespace, Name: rollout.Spec.ModelRef.Name}
+	if err := r.Get(ctx, key, &model); err != nil {
+		if apierrors.IsNotFound(err) {
+			return r.finish(ctx, rollout, neuronetes.RolloutFailed, fmt.Sprintf("model %s not found", key.Name))
+		}
+		return ctrl.Result{}, err
+	}
+
+	if rollout.Status.Phase == neuronetes.RolloutPromoting {
+		return r.reconcilePromoting(ctx, rollout)
+	}
+
+	if other, err := r.activeRollout(ctx, rollout); err != nil {
+		return ctrl.Result{}, err
+	} else if other != "" {
+		return r.finish(ctx, rollout, neuronetes.RolloutFailed, fmt.Sprintf("model %s is being rolled out by %s", key.Name, other))
+	}
+
+	candidate, err := r.reconcileCandidate(ctx, rollout, &model)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	rollout.Status.CanaryModel = candidate.Name
+	switch candidate.Status.Phase {
+	case "Ready":
+	case "Failed":
+		return r.finish(ctx, rollout, neuronetes.RolloutFailed, failedLoadMessage(candidate))
+	default:
+		rollout.Status.Message = "waiting for the new weights to load"
+		return ctrl.Result{}, nil
+	}
+
+	steps := rolloutSteps(rollout)
+	if rollout.Status.Phase == neuronetes.RolloutPending {
+		rollout.Status.Phase = neuronetes.RolloutProgressing
+		rollout.Status.Step = 0
+	}
+	if int(rollout.Status.Step) >= len(steps) {
+		rollout.Status.Step = int32(len(steps) - 1)
+	}
+	weight := steps[rollout.Status.Step]
+
+	pairs, err := r.reconcileCanaries(ctx, rollout, &model, candidate, weight)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	rollout.Status.Pools = make([]neuronetes.RolloutPool, 0, len(pairs))
+	for _, pair := range pairs {
+		rollout.Status.Pools = append(rollout.Status.Pools, neuronetes.RolloutPool{Name: pair.baseline.Name, Canary: pair.canary.Name})
+	}
+	if len(pairs) == 0 {
+		return r.finish(ctx, rollout, neuronetes.RolloutFailed, fmt.Sprintf("no pools serve model %s", key.Name))
+	}
+
+	// Traffic shifts once the canaries can take it
+	for _, pair := range pairs {
+		if !canaryReady(rollout, pair) {
+			rollout.Status.Message = fmt.Sprintf("waiting for the replicas of canary %s", pair.canary.Name)
+			return ctrl.Result{}, nil
+		}
+	}
+	now := r.now()
+	for _, pair := range pairs {
+		if err := r.setSplit(ctx, pair.baseline, pair.canary.Name, weight); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	if rollout.Status.Weight != weight || rollout.Status.StepStartTime == nil {
+		log.FromContext(ctx).Info("Shifted traffic to the new weights", "rollout", rollout.Name, "weight", weight)
+		rollout.Status.Weight = weight
+		rollout.Status.StepStartTime = &metav1.Time{Time: now}
+		rollout.Status.HealthyAnalyses = 0
+	}
+
+	interval := rolloutInterval(rollout)
+	last := rollout.Status.StepStartTime.Time
+	if analysis := rollout.Status.LastAnalysis; analysis != nil && analysis.Time.After(last) {
+		last = analysis.Time.Time
+	}
+	if wait := last.Add(interval).Sub(now); wait > 0 {
+		rollout.Status.Message = fmt.Sprintf("routing %d%% of traffic to the new weights", weight)
+		return ctrl.Result{RequeueAfter: wait}, nil
+	}
+	if r.Stats == nil {
+		rollout.Status.Message = "no metrics provider to analyze the rollout with"
+		return ctrl.Result{}, nil
+	}
+
+	return r.analyze(ctx, rollout, &model, pairs, steps, interval)
+}
+
+// analyze compares the canaries of rollout with their pools over interval,
+// and moves rollout to the next step, promotes or rolls it back
+func (r *ModelRolloutReconciler) analyze(ctx context.Context, rollout *neuronetes.ModelRollout, model *neuronetes.Model, pairs []rolloutPair, steps []int32, interval time.Duration) (ctrl.Result, error) {
+	var baselines, canaries []*neuronetes.AgentPool
+	for _, pair := range pairs {
+		baselines = append(baselines, pair.baseline)
+		canaries = append(canaries, pair.canary)
+	}
+	baselineStats, err := r.poolStats(ctx, baselines, interval)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	canaryStats, err := r.poolStats(ctx, canaries, interval)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	thresholds := rolloutThresholds(rollout.Spec.Analysis)
+	result := canary.Analyze(baselineStats, canaryStats, thresholds)
+	rollout.Status.LastAnalysis = analysisResult(r.now(), result, baselineStats, canaryStats)
+	rollout.Status.Message = result.Reason
+	log.FromContext(ctx).Info("Analyzed rollout", "rollout", rollout.Name, "verdict", result.Verdict, "reason", result.Reason)
+
+	if result.Verdict == canary.VerdictRollback {
+		return r.finish(ctx, rollout, neuronetes.RolloutRolledBack, result.Reason)
+	}
+	if !result.Healthy {
+		return ctrl.Result{RequeueAfter: interval}, nil
+	}
+
+	rollout.Status.HealthyAnalyses++
+	if int(rollout.Status.HealthyAnalyses) < thresholds.PromoteAfter {
+		return ctrl.Result{RequeueAfter: interval}, nil
+	}
+	if int(rollout.Status.Step) < len(steps)-1 {
+		rollout.Status.Step++
+		rollout.Status.HealthyAnalyses = 0
+		return ctrl.Result{Requeue: true}, nil
+	}
+	return r.promote(ctx, rollout, model)
+}
+
+// promote sets the new weights on the Model, rolling the pools serving it
+func (r *ModelRolloutReconciler) promote(ctx context.Context, rollout *neuronetes.ModelRollout, model *neuronetes.Model) (ctrl.Result, error) {
+	model.Spec.WeightsURI = rollout.Spec.WeightsURI
+	if rollout.Spec.Size != nil {
+		model.Spec.Size = rollout.Spec.Size.DeepCopy()
+	}
+	model.Spec.Integrity = rollout.Spec.Integrity.DeepCopy()
+	if err := r.Update(ctx, model); err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to promote model %s: %w", model.Name, err)
+	}
+	log.FromContext(ctx).Info("Promoted new weights", "rollout", rollout.Name, "model", model.Name)
+
+	rollout.Status.Phase = neuronetes.RolloutPromoting
+	rollout.Status.Message = "waiting for the pools to roll to the new weights"
+	return ctrl.Result{RequeueAfter: rolloutPollInterval}, nil
+}
+
+// reconcilePromoting removes the canaries of rollout once every pool rolled
+// to the weights set on the Model. The canaries keep serving their share of
+// the traffic until then.
+func (r *ModelRolloutReconciler) reconcilePromoting(ctx context.Context, rollout *neuronetes.ModelRollout) (ctrl.Result, error) {
+	for _, p := range rollout.Status.Pools {
+		var pool neuronetes.AgentPool
+		if err := r.Get(ctx, types.NamespacedName{Namespace: rollout.Namespace, Name: p.Name}, &pool); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return ctrl.Result{}, err
+		}
+		rolled, err := r.rolledOut(ctx, &pool)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if !rolled {
+			rollout.Status.Message = fmt.Sprintf("waiting for pool %s to roll to the new weights", pool.Name)
+			return ctrl.Result{RequeueAfter: rolloutPollInterval}, nil
+		}
+	}
+	return r.finish(ctx, rollout, neuronetes.RolloutPromoted, "new weights promoted")
+}
+
+// rolledOut returns whether the replicas of pool all run its current
+// AgentClass and Model
+func (r *ModelRolloutReconciler) rolledOut(ctx context.Context, pool *neuronetes.AgentPool) (bool, error) {
+	class := &neuronetes.AgentClass{}
+	if err := r.Get(ctx, agentClassKey(pool), class); err != nil {
+		return false, client.IgnoreNotFound(err)
+	}
+	model := &neuronetes.Model{}
+	if err := r.Get(ctx, modelKey(class), model); err != nil {
+		return false, client.IgnoreNotFound(err)
+	}
+	want, err := revision(class, model)
+	if err != nil {
+		return false, err
+	}
+
+	var deployment appsv1.Deployment
+	if err := r.Get(ctx, client.ObjectKeyFromObject(pool), &deployment); err != nil {
+		return false, client.IgnoreNotFound(err)
+	}
+	if deployment.Spec.Template.Annotations[neuronetes.AnnotationRevision] != want {
+		return false, nil
+	}
+	replicas := int32(1)
+	if deployment.Spec.Replicas != nil {
+		replicas = *deployment.Spec.Replicas
+	}
+	status := deployment.Status
+	return status.ObservedGeneration >= deployment.Generation &&
+		status.UpdatedReplicas == replicas &&
+		status.Replicas == replicas &&
+		status.AvailableReplicas == replicas, nil
+}
+
+// finish ends rollout in phase. Rolled back and failed rollouts route all
+// traffic back to the pools; every rollout removes its canaries.
+func (r *ModelRolloutReconciler) finish(ctx context.Context, rollout *neuronetes.ModelRollout, phase, message string) (ctrl.Result, error) {
+	if err := r.removeSplits(ctx, client.ObjectKeyFromObject(rollout)); err != nil {
+		return ctrl.Result{}, err
+	}
+	if err := r.removeCanaries(ctx, rollout); err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to remove the canaries of rollout %s: %w", rollout.Name, err)
+	}
+
+	log.FromContext(ctx).Info("Rollout finished", "rollout", rollout.Name, "phase", phase, "message", message)
+	now := metav1.NewTime(r.now())
+	rollout.Status.Phase = phase
+	rollout.Status.Message = message
+	rollout.Status.CompletionTime = &now
+	if phase != neuronetes.RolloutPromoted {
+		rollout.Status.Weight = 0
+	}
+	return ctrl.Result{}, nil
+}
+
+// removeCanaries deletes the canary pools, classes and models rollout
+// controls
+func (r *ModelRolloutReconciler) removeCanaries(ctx context.Context, rollout *neuronetes.ModelRollout) error {
+	opts := []client.ListOption{
+		client.InNamespace(rollout.Namespace),
+		client.MatchingLabels{neuronetes.LabelRollout: rollout.Name},
+	}
+	var pools neuronetes.AgentPoolList
+	if err := r.List(ctx, &pools, opts...); err != nil {
+		return err
+	}
+	var classes neuronetes.AgentClassList
+	if err := r.List(ctx, &classes, opts...); err != nil {
+		return err
+	}
+	var models neuronetes.ModelList
+	if err := r.List(ctx, &models, opts...); err != nil {
+		return err
+	}
+
+	var canaries []client.Object
+	for i := range pools.Items {
+		canaries = append(canaries, &pools.Items[i])
+	}
+	for i := range classes.Items {
+		canaries = append(canaries, &classes.Items[i])
+	}
+	for i := range models.Items {
+		canaries = append(canaries, &models.Items[i])
+	}
+	for _, obj := range canaries {
+		if !metav1.IsControlledBy(obj, rollout) {
+			continue
+		}
+		if err := r.Delete(ctx, obj); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// activeRollout returns the name of an earlier rollout of the model of
+// rollout still in progress, or "" if there is none
+func (r *ModelRolloutReconciler) activeRollout(ctx context.Context, rollout *neuronetes.ModelRollout) (string, error) {
+	var rollouts neuronetes.ModelRolloutList
+	if err := r.List(ctx, &rollouts, client.InNamespace(rollout.Namespace)); err != nil {
+		return "", err
+	}
+	for i := range rollouts.Items {
+		other := &rollouts.Items[i]
+		if other.Name == rollout.Name || other.Spec.ModelRef.Name != rollout.Spec.ModelRef.Name {
+			continue
+		}
+		switch other.Status.Phase {
+		case neuronetes.RolloutPromoted, neuronetes.RolloutRolledBack, neuronetes.RolloutFailed:
+			continue
+		}
+		// The earlier rollout goes first, or the one named first if both
+		// were created in the same second
+		if other.CreationTimestamp.Before(&rollout.CreationTimestamp) ||
+			(other.CreationTimestamp.Equal(&rollout.CreationTimestamp) && other.Name < rollout.Name) {
+			return other.Name, nil
+		}
+	}
+	return "", nil
+}
+
+// reconcileCandidate creates the Model loading the new weights of rollout,
+// a copy of model
+func (r *ModelRolloutReconciler) reconcileCandidate(ctx context.Context, rollout *neuronetes.ModelRollout, model *neuronetes.Model) (*neuronetes.Model, error) {
+	candidate := &neuronetes.Model{ObjectMeta: metav1.ObjectMeta{
+		Name:      model.Name + "-" + rollout.Name,
+		Namespace: rollout.Namespace,
+	}}
+	if _, err := controllerutil.CreateOrUpdate(ctx, r.Client, candidate, func() error {
+		setRolloutLabel(&candidate.ObjectMeta, rollout)
+		candidate.Spec = *model.Spec.DeepCopy()
+		candidate.Spec.WeightsURI = rollout.Spec.WeightsURI
+		if rollout.Spec.Size != nil {
+			candidate.Spec.Size = rollout.Spec.Size.DeepCopy()
+		}
+		candidate.Spec.Integrity = rollout.Spec.Integrity.DeepCopy()
+		return ctrl.SetControllerReference(rollout, candidate, r.Scheme)
+	}); err != nil {
+		return nil, fmt.Errorf("failed to reconcile model %s: %w", candidate.Name, err)
+	}
+	return candidate, nil
+}
+
+// reconcileCanaries creates a copy of every AgentClass of model running the
+// candidate model, and a canary of every pool of those classes sized for
+// weight percent of its traffic
+func (r *ModelRolloutReconciler) reconcileCanaries(ctx context.Context, rollout *neuronetes.ModelRollout, model, candidate *neuronetes.Model, weight int32) ([]rolloutPair, error) {
+	var classes neuronetes.AgentClassList
+	if err := r.List(ctx, &classes, client.InNamespace(rollout.Namespace)); err != nil {
+		return nil, err
+	}
+	var pools neuronetes.AgentPoolList
+	if err := r.List(ctx, &pools, client.InNamespace(rollout.Namespace)); err != nil {
+		return nil, err
+	}
+
+	modelName := client.ObjectKeyFromObject(model)
+	var pairs []rolloutPair
+	for i := range classes.Items {
+		class := &classes.Items[i]
+		if _, ok := class.Labels[neuronetes.LabelRollout]; ok || modelKey(class) != modelName {
+			continue
+		}
+		canaryClass := &neuronetes.AgentClass{ObjectMeta: metav1.ObjectMeta{
+			Name:      class.Name + "-" + rollout.Name,
+			Namespace: rollout.Namespace,
+		}}
+		if _, err := controllerutil.CreateOrUpdate(ctx, r.Client, canaryClass, func() error {
+			setRolloutLabel(&canaryClass.ObjectMeta, rollout)
+			canaryClass.Spec = *class.Spec.DeepCopy()
+			canaryClass.Spec.ModelRef = neuronetes.ModelReference{Name: candidate.Name}
+			return ctrl.SetControllerReference(rollout, canaryClass, r.Scheme)
+		}); err != nil {
+			return nil, fmt.Errorf("failed to reconcile agent class %s: %w", canaryClass.Name, err)
+		}
+
+		for j := range pools.Items {
+			pool := &pools.Items[j]
+			if _, ok := pool.Labels[neuronetes.LabelRollout]; ok || agentClassKey(pool) != client.ObjectKeyFromObject(class) {
+				continue
+			}
+			canaryPool, err := r.reconcileCanaryPool(ctx, rollout, pool, canaryClass.Name, weight)
+			if err != nil {
+				return nil, err
+			}
+			pairs = append(pairs, rolloutPair{baseline: pool, canary: canaryPool})
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].baseline.Name < pairs[j].baseline.Name })
+	return pairs, nil
+}
+
+// reconcileCanaryPool creates the canary of pool, running class. Canaries
+// of canary rollouts scale within weight percent of the bounds of pool,
+// with at least one replica; those of blue-green rollouts start with as
+// many replicas as pool. The autoscaler scales canaries from there.
+func (r *ModelRolloutReconciler) reconcileCanaryPool(ctx context.Context, rollout *neuronetes.ModelRollout, pool *neuronetes.AgentPool, class string, weight int32) (*neuronetes.AgentPool, error) {
+	canaryPool := &neuronetes.AgentPool{ObjectMeta: metav1.ObjectMeta{
+		Name:      canaryName(pool.Name, rollout.Name),
+		Namespace: rollout.Namespace,
+	}}
+	if _, err := controllerutil.CreateOrUpdate(ctx, r.Client, canaryPool, func() error {
+		setRolloutLabel(&canaryPool.ObjectMeta, rollout)
+		replicas := canaryPool.Spec.Replicas
+		canaryPool.Spec = *pool.Spec.DeepCopy()
+		canaryPool.Spec.AgentClassRef = neuronetes.AgentClassReference{Name: class}
+		canaryPool.Spec.Replicas = replicas
+		if rollout.Spec.Strategy == neuronetes.RolloutStrategyBlueGreen {
+			if canaryPool.Spec.Replicas == nil {
+				current := pool.Status.Replicas
+				canaryPool.Spec.Replicas = &current
+			}
+		} else {
+			canaryPool.Spec.MinReplicas = max32(1, percentOf(pool.Spec.MinReplicas, weight))
+			canaryPool.Spec.MaxReplicas = max32(canaryPool.Spec.MinReplicas, percentOf(pool.Spec.MaxReplicas, weight))
+		}
+		return ctrl.SetControllerReference(rollout, canaryPool, r.Scheme)
+	}); err != nil {
+		return nil, fmt.Errorf("failed to reconcile agent pool %s: %w", canaryPool.Name, err)
+	}
+	return canaryPool, nil
+}
+
+// canaryReady returns whether the canary of pair can take its share of the
+// traffic of the pool: a ready replica, or as many as the pool for
+// blue-green rollouts
+func canaryReady(rollout *neuronetes.ModelRollout, pair rolloutPair) bool {
+	ready := pair.canary.Status.ReadyReplicas
+	if rollout.Spec.Strategy == neuronetes.RolloutStrategyBlueGreen {
+		return ready >= max32(1, pair.baseline.Status.ReadyReplicas)
+	}
+	return ready >= 1
+}
+
+// setSplit routes weight percent of the traffic of pool to canary
+func (r *ModelRolloutReconciler) setSplit(ctx context.Context, pool *neuronetes.AgentPool, canary string, weight int32) error {
+	value := strconv.Itoa(int(weight))
+	if pool.Annotations[neuronetes.AnnotationCanary] == canary && pool.Annotations[neuronetes.AnnotationCanaryWeight] == value {
+		return nil
+	}
+	patch := client.MergeFrom(pool.DeepCopy())
+	if pool.Annotations == nil {
+		pool.Annotations = make(map[string]string)
+	}
+	pool.Annotations[neuronetes.AnnotationCanary] = canary
+	pool.Annotations[neuronetes.AnnotationCanaryWeight] = value
+	if err := r.Patch(ctx, pool, patch); err != nil {
+		return fmt.Errorf("failed to split the traffic of pool %s: %w", pool.Name, err)
+	}
+	return nil
+}
+
+// removeSplits routes all traffic of the pools split by the rollout named
+// key back to the pools
+func (r *ModelRolloutReconciler) removeSplits(ctx context.Context, key types.NamespacedName) error {
+	var pools neuronetes.AgentPoolList
+	if err := r.List(ctx, &pools, client.InNamespace(key.Namespace)); err != nil {
+		return err
+	}
+	for i := range pools.Items {
+		pool := &pools.Items[i]
+		if pool.Annotations[neuronetes.AnnotationCanary] != canaryName(pool.Name, key.Name) {
+			continue
+		}
+		patch := client.MergeFrom(pool.DeepCopy())
+		delete(pool.Annotations, neuronetes.AnnotationCanary)
+		delete(pool.Annotations, neuronetes.AnnotationCanaryWeight)
+		if err := r.Patch(ctx, pool, patch); err != nil {
+			return fmt.Errorf("failed to remove the traffic split of pool %s: %w", pool.Name, err)
+		}
+	}
+	return nil
+}
+
+// poolStats sums the requests and errors of pools over window, with the
+// highest p95 latency and the lowest quality win rate among them
+func (r *ModelRolloutReconciler) poolStats(ctx context.Context, pools []*neuronetes.AgentPool, window time.Duration) (canary.Stats, error) {
+	var total canary.Stats
+	for _, pool := range pools {
+		stats, err := r.Stats.PoolStats(ctx, pool, window)
+		if err != nil {
+			return canary.Stats{}, fmt.Errorf("failed to get the stats of pool %s: %w", pool.Name, err)
+		}
+		total.Requests += stats.Requests
+		total.Errors += stats.Errors
+		if stats.P95Latency > total.P95Latency {
+			total.P95Latency = stats.P95Latency
+		}
+		if rate := stats.QualityWinRate; rate != nil && (total.QualityWinRate == nil || *rate < *total.QualityWinRate) {
+			total.QualityWinRate = rate
+		}
+	}
+	return total, nil
+}
+
+// analysisResult records result of an analysis at now
+func analysisResult(now time.Time, result canary.Result, baseline, canaryStats canary.Stats) *neuronetes.RolloutAnalysisResult {
+	analysis := &neuronetes.RolloutAnalysisResult{
+		Time:     metav1.NewTime(now),
+		Verdict:  string(result.Verdict),
+		Reason:   result.Reason,
+		Requests: canaryStats.Requests,
+	}
+	if canaryStats.Requests > 0 {
+		analysis.ErrorRate = strconv.FormatFloat(canaryStats.ErrorRate(), 'f', 3, 64)
+	}
+	if baseline.P95Latency > 0 && canaryStats.P95Latency > 0 {
+		analysis.LatencyRatio = strconv.FormatFloat(float64(canaryStats.P95Latency)/float64(baseline.P95Latency), 'f', 2, 64)
+	}
+	if rate := canaryStats.QualityWinRate; rate != nil {
+		analysis.QualityWinRate = strconv.FormatFloat(*rate, 'f', 3, 64)
+	}
+	return analysis
+}
+
+// failedLoadMessage explains why a candidate model failed to load, from
+// its failed conditions
+func failedLoadMessage(model *neuronetes.Model) string {
+	for _, condition := range model.Status.Conditions {
+		if condition.Status == metav1.ConditionFalse && condition.Message != "" {
+			return "failed to load the new weights: " + condition.Message
+		}
+	}
+	return "failed to load the new weights"
+}
+
+// rolloutSteps returns the traffic percentages rollout shifts through
+func rolloutSteps(rollout *neuronetes.ModelRollout) []int32 {
+	switch {
+	case len(rollout.Spec.Steps) > 0:
+		return rollout.Spec.Steps
+	case rollout.Spec.Strategy == neuronetes.RolloutStrategyBlueGreen:
+		return []int32{100}
+	default:
+		return defaultRolloutSteps
+	}
+}
+
+// rolloutInterval returns how often rollout is analyzed
+func rolloutInterval(rollout *neuronetes.ModelRollout) time.Duration {
+	if analysis := rollout.Spec.Analysis; analysis != nil && analysis.Interval != nil && analysis.Interval.Duration > 0 {
+		return analysis.Interval.Duration
+	}
+	return DefaultRolloutInterval
+}
+
+// rolloutThresholds returns the thresholds analysis judges canaries by
+func rolloutThresholds(analysis *neuronetes.RolloutAnalysis) canary.Thresholds {
+	thresholds := defaultRolloutThresholds
+	if analysis == nil {
+		return thresholds
+	}
+	if analysis.MinRequests != nil {
+		thresholds.MinRequests = int64(*analysis.MinRequests)
+	}
+	if analysis.MaxErrorRate != nil {
+		thresholds.MaxErrorRate = float64(*analysis.MaxErrorRate)
+	}
+	if analysis.MaxLatencyRatio != nil {
+		thresholds.MaxLatencyRatio = float64(*analysis.MaxLatencyRatio)
+	}
+	if analysis.MinQualityWinRate != nil {
+		thresholds.MinQualityWinRate = float64(*analysis.MinQualityWinRate)
+	}
+	if analysis.HealthyAnalyses > 0 {
+		thresholds.PromoteAfter = int(analysis.HealthyAnalyses)
+	}
+	return thresholds
+}
+
+// canaryName returns the name of the canary of pool in rollout
+func canaryName(pool, rollout string) string {
+	return pool + "-" + rollout
+}
+
+// setRolloutLabel marks obj as belonging to rollout
+func setRolloutLabel(obj *metav1.ObjectMeta, rollout *neuronetes.ModelRollout) {
+	if obj.Labels == nil {
+		obj.Labels = make(map[string]string)
+	}
+	obj.Labels[neuronetes.LabelRollout] = rollout.Name
+}
+
+// percentOf returns percent of n, rounded up
+func percentOf(n, percent int32) int32 {
+	return (n*percent + 99) / 100
+}
+
+func max32(a, b int32) int32 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func (r *ModelRolloutReconciler) now() time.Time {
+	if r.clock != nil {
+		return r.clock()
+	}
+	return time.Now()
+}
+
+// SetupWithManager sets up the controller with the Manager. Changes to the
+// candidate model and canaries of a rollout advance it.
+func (r *ModelRolloutReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&neuronetes.ModelRollout{}).
+		Owns(&neuronetes.Model{}).
+		Owns(&neuronetes.AgentClass{}).
+		Owns(&neuronetes.AgentPool{}).
+		Complete(r)
+}
@@ -0,0 +1,295 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/canary"
+)
+
+// fakePoolStats returns fixed stats for each pool
+type fakePoolStats map[string]canary.Stats
+
+func (f fakePoolStats) PoolStats(ctx context.Context, pool *neuronetes.AgentPool, window time.Duration) (canary.Stats, error) {
+	return f[pool.Name], nil
+}
+
+// newTestRollout returns objects serving the test model from the test pool,
+// and a rollout of new weights for them
+func newTestRollout() (*neuronetes.ModelRollout, []client.Object) {
+	model := newTestModel()
+	model.Status.Phase = "Ready"
+	class := &neuronetes.AgentClass{
+		ObjectMeta: metav1.ObjectMeta{Name: "chat-agent", Namespace: "default"},
+		Spec:       neuronetes.AgentClassSpec{ModelRef: neuronetes.ModelReference{Name: "llama-3-8b"}},
+	}
+	pool := newTestAgentPool(2, 10)
+	pool.Status.Replicas = 2
+	pool.Status.ReadyReplicas = 2
+
+	rollout := &neuronetes.ModelRollout{
+		ObjectMeta: metav1.ObjectMeta{Name: "v2", Namespace: "default", UID: "rollout-uid"},
+		Spec: neuronetes.ModelRolloutSpec{
+			ModelRef:   corev1.LocalObjectReference{Name: "llama-3-8b"},
+			WeightsURI: "s3://models/llama-3.1-8b",
+			Strategy:   neuronetes.RolloutStrategyCanary,
+			Analysis:   &neuronetes.RolloutAnalysis{HealthyAnalyses: 1},
+		},
+	}
+	return rollout, []client.Object{model, class, pool, rollout}
+}
+
+func reconcileRollout(t *testing.T, r *ModelRolloutReconciler, key types.NamespacedName) (*neuronetes.ModelRollout, ctrl.Result) {
+	t.Helper()
+	result, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: key})
+	require.NoError(t, err)
+	var rollout neuronetes.ModelRollout
+	require.NoError(t, r.Get(context.Background(), key, &rollout))
+	return &rollout, result
+}
+
+// setReady marks obj, a Model or AgentPool, as ready
+func setReady(t *testing.T, c client.Client, obj client.Object) {
+	t.Helper()
+	require.NoError(t, c.Get(context.Background(), client.ObjectKeyFromObject(obj), obj))
+	switch obj := obj.(type) {
+	case *neuronetes.Model:
+		obj.Status.Phase = "Ready"
+	case *neuronetes.AgentPool:
+		obj.Status.Replicas = obj.Spec.MinReplicas
+		obj.Status.ReadyReplicas = obj.Spec.MinReplicas
+	}
+	require.NoError(t, c.Status().Update(context.Background(), obj))
+}
+
+func TestModelRolloutReconcilerPromotesHealthyCanary(t *testing.T) {
+	rollout, objs := newTestRollout()
+	key := client.ObjectKeyFromObject(rollout)
+	c := newFakeClient(t, objs...)
+	stats := fakePoolStats{
+		"chat-pool":    {Requests: 1000, Errors: 5, P95Latency: 200 * time.Millisecond},
+		"chat-pool-v2": {Requests: 120, Errors: 1, P95Latency: 220 * time.Millisecond},
+	}
+	now := time.Unix(1700000000, 0)
+	r := &ModelRolloutReconciler{Client: c, Scheme: c.Scheme(), Stats: stats}
+	r.clock = func() time.Time { return now }
+	ctx := context.Background()
+
+	// The new weights load as a copy of the model first
+	got, _ := reconcileRollout(t, r, key)
+	assert.Equal(t, neuronetes.RolloutPending, got.Status.Phase)
+	assert.Equal(t, "llama-3-8b-v2", got.Status.CanaryModel)
+	candidate := &neuronetes.Model{}
+	require.NoError(t, c.Get(ctx, types.NamespacedName{Namespace: "default", Name: "llama-3-8b-v2"}, candidate))
+	assert.Equal(t, "s3://models/llama-3.1-8b", candidate.Spec.WeightsURI)
+	assert.Equal(t, "16Gi", candidate.Spec.Size.String())
+	assert.Equal(t, "v2", candidate.Labels[neuronetes.LabelRollout])
+	require.Len(t, candidate.OwnerReferences, 1)
+	assert.Equal(t, "v2", candidate.OwnerReferences[0].Name)
+
+	// Then each pool gets a canary sized for a tenth of its traffic
+	setReady(t, c, candidate)
+	got, _ = reconcileRollout(t, r, key)
+	assert.Equal(t, neuronetes.RolloutProgressing, got.Status.Phase)
+	assert.Equal(t, []neuronetes.RolloutPool{{Name: "chat-pool", Canary: "chat-pool-v2"}}, got.Status.Pools)
+	assert.Equal(t, int32(0), got.Status.Weight)
+	class := &neuronetes.AgentClass{}
+	require.NoError(t, c.Get(ctx, types.NamespacedName{Namespace: "default", Name: "chat-agent-v2"}, class))
+	assert.Equal(t, "llama-3-8b-v2", class.Spec.ModelRef.Name)
+	canaryPool := &neuronetes.AgentPool{}
+	require.NoError(t, c.Get(ctx, types.NamespacedName{Namespace: "default", Name: "chat-pool-v2"}, canaryPool))
+	assert.Equal(t, "chat-agent-v2", canaryPool.Spec.AgentClassRef.Name)
+	assert.Equal(t, int32(1), canaryPool.Spec.MinReplicas)
+	assert.Equal(t, int32(1), canaryPool.Spec.MaxReplicas)
+
+	// Traffic shifts once the canary is ready
+	setReady(t, c, canaryPool)
+	got, result := reconcileRollout(t, r, key)
+	assert.Equal(t, int32(10), got.Status.Weight)
+	assert.Equal(t, DefaultRolloutInterval, result.RequeueAfter)
+	pool := &neuronetes.AgentPool{}
+	require.NoError(t, c.Get(ctx, types.NamespacedName{Namespace: "default", Name: "chat-pool"}, pool))
+	assert.Equal(t, "chat-pool-v2", pool.Annotations[neuronetes.AnnotationCanary])
+	assert.Equal(t, "10", pool.Annotations[neuronetes.AnnotationCanaryWeight])
+
+	// A healthy analysis moves to the next step
+	now = now.Add(DefaultRolloutInterval)
+	got, _ = reconcileRollout(t, r, key)
+	assert.Equal(t, int32(1), got.Status.Step)
+	require.NotNil(t, got.Status.LastAnalysis)
+	assert.Equal(t, "Continue", got.Status.LastAnalysis.Verdict)
+	assert.Equal(t, int64(120), got.Status.LastAnalysis.Requests)
+	assert.Equal(t, "0.008", got.Status.LastAnalysis.ErrorRate)
+	assert.Equal(t, "1.10", got.Status.LastAnalysis.LatencyRatio)
+
+	got, _ = reconcileRollout(t, r, key)
+	assert.Equal(t, int32(50), got.Status.Weight)
+	require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(canaryPool), canaryPool))
+	assert.Equal(t, int32(5), canaryPool.Spec.MaxReplicas)
+	require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(pool), pool))
+	assert.Equal(t, "50", pool.Annotations[neuronetes.AnnotationCanaryWeight])
+
+	// The last step promotes the new weights to the model
+	now = now.Add(DefaultRolloutInterval)
+	got, _ = reconcileRollout(t, r, key)
+	assert.Equal(t, neuronetes.RolloutPromoting, got.Status.Phase)
+	model := &neuronetes.Model{}
+	require.NoError(t, c.Get(ctx, types.NamespacedName{Namespace: "default", Name: "llama-3-8b"}, model))
+	assert.Equal(t, "s3://models/llama-3.1-8b", model.Spec.WeightsURI)
+
+	// The canary keeps serving until the pool rolled to them
+	pools := &AgentPoolReconciler{Client: c, Scheme: c.Scheme()}
+	_, deployment := reconcilePool(t, pools, client.ObjectKeyFromObject(pool))
+	got, result = reconcileRollout(t, r, key)
+	assert.Equal(t, neuronetes.RolloutPromoting, got.Status.Phase)
+	assert.Equal(t, rolloutPollInterval, result.RequeueAfter)
+
+	deployment.Status = appsv1.DeploymentStatus{
+		ObservedGeneration: deployment.Generation,
+		Replicas:           *deployment.Spec.Replicas,
+		UpdatedReplicas:    *deployment.Spec.Replicas,
+		AvailableReplicas:  *deployment.Spec.Replicas,
+	}
+	require.NoError(t, c.Status().Update(ctx, deployment))
+	got, _ = reconcileRollout(t, r, key)
+	assert.Equal(t, neuronetes.RolloutPromoted, got.Status.Phase)
+	assert.Equal(t, int32(50), got.Status.Weight)
+	assert.NotNil(t, got.Status.CompletionTime)
+
+	require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(pool), pool))
+	assert.NotContains(t, pool.Annotations, neuronetes.AnnotationCanary)
+	assert.True(t, apierrors.IsNotFound(c.Get(ctx, client.ObjectKeyFromObject(canaryPool), &neuronetes.AgentPool{})))
+	assert.True(t, apierrors.IsNotFound(c.Get(ctx, client.ObjectKeyFromObject(class), &neuronetes.AgentClass{})))
+	assert.True(t, apierrors.IsNotFound(c.Get(ctx, client.ObjectKeyFromObject(candidate), &neuronetes.Model{})))
+}
+
+func TestModelRolloutReconcilerRollsBackUnhealthyCanary(t *testing.T) {
+	rollout, objs := newTestRollout()
+	winRate := float32(0.5)
+	rollout.Spec.Analysis.MinQualityWinRate = &winRate
+	key := client.ObjectKeyFromObject(rollout)
+	c := newFakeClient(t, objs...)
+	quality := 0.42
+	stats := fakePoolStats{
+		"chat-pool":    {Requests: 1000, P95Latency: 200 * time.Millisecond},
+		"chat-pool-v2": {Requests: 150, P95Latency: 210 * time.Millisecond, QualityWinRate: &quality},
+	}
+	now := time.Unix(1700000000, 0)
+	r := &ModelRolloutReconciler{Client: c, Scheme: c.Scheme(), Stats: stats}
+	r.clock = func() time.Time { return now }
+	ctx := context.Background()
+
+	reconcileRollout(t, r, key)
+	setReady(t, c, &neuronetes.Model{ObjectMeta: metav1.ObjectMeta{Name: "llama-3-8b-v2", Namespace: "default"}})
+	reconcileRollout(t, r, key)
+	setReady(t, c, &neuronetes.AgentPool{ObjectMeta: metav1.ObjectMeta{Name: "chat-pool-v2", Namespace: "default"}})
+	got, _ := reconcileRollout(t, r, key)
+	require.Equal(t, int32(10), got.Status.Weight)
+
+	// The new weights lose on quality, so all traffic returns to the pool
+	now = now.Add(DefaultRolloutInterval)
+	got, _ = reconcileRollout(t, r, key)
+	assert.Equal(t, neuronetes.RolloutRolledBack, got.Status.Phase)
+	assert.Equal(t, "canary quality win rate 0.420 is below 0.500", got.Status.Message)
+	assert.Equal(t, "0.420", got.Status.LastAnalysis.QualityWinRate)
+	assert.Equal(t, int32(0), got.Status.Weight)
+
+	pool := &neuronetes.AgentPool{}
+	require.NoError(t, c.Get(ctx, types.NamespacedName{Namespace: "default", Name: "chat-pool"}, pool))
+	assert.NotContains(t, pool.Annotations, neuronetes.AnnotationCanary)
+	assert.NotContains(t, pool.Annotations, neuronetes.AnnotationCanaryWeight)
+	assert.True(t, apierrors.IsNotFound(c.Get(ctx, types.NamespacedName{Namespace: "default", Name: "chat-pool-v2"}, &neuronetes.AgentPool{})))
+	model := &neuronetes.Model{}
+	require.NoError(t, c.Get(ctx, types.NamespacedName{Namespace: "default", Name: "llama-3-8b"}, model))
+	assert.Equal(t, "s3://models/llama-3-8b", model.Spec.WeightsURI)
+
+	// Finished rollouts are left alone
+	_, result := reconcileRollout(t, r, key)
+	assert.Zero(t, result)
+}
+
+func TestModelRolloutReconcilerBlueGreen(t *testing.T) {
+	rollout, objs := newTestRollout()
+	rollout.Spec.Strategy = neuronetes.RolloutStrategyBlueGreen
+	key := client.ObjectKeyFromObject(rollout)
+	c := newFakeClient(t, objs...)
+	r := &ModelRolloutReconciler{Client: c, Scheme: c.Scheme()}
+	ctx := context.Background()
+
+	reconcileRollout(t, r, key)
+	setReady(t, c, &neuronetes.Model{ObjectMeta: metav1.ObjectMeta{Name: "llama-3-8b-v2", Namespace: "default"}})
+	reconcileRollout(t, r, key)
+
+	// The canary is a full copy of the pool and takes all traffic at once
+	canaryPool := &neuronetes.AgentPool{}
+	require.NoError(t, c.Get(ctx, types.NamespacedName{Namespace: "default", Name: "chat-pool-v2"}, canaryPool))
+	assert.Equal(t, int32(2), canaryPool.Spec.MinReplicas)
+	assert.Equal(t, int32(10), canaryPool.Spec.MaxReplicas)
+	require.NotNil(t, canaryPool.Spec.Replicas)
+	assert.Equal(t, int32(2), *canaryPool.Spec.Replicas)
+
+	setReady(t, c, canaryPool)
+	got, _ := reconcileRollout(t, r, key)
+	assert.Equal(t, int32(100), got.Status.Weight)
+	// It is analyzed after an interval of traffic
+	assert.Equal(t, "routing 100% of traffic to the new weights", got.Status.Message)
+
+	// Deleting the rollout routes the traffic back to the pool
+	require.NoError(t, c.Delete(ctx, got))
+	_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: key})
+	require.NoError(t, err)
+	pool := &neuronetes.AgentPool{}
+	require.NoError(t, c.Get(ctx, types.NamespacedName{Namespace: "default", Name: "chat-pool"}, pool))
+	assert.NotContains(t, pool.Annotations, neuronetes.AnnotationCanary)
+}
+
+func TestModelRolloutReconcilerFails(t *testing.T) {
+	rollout, objs := newTestRollout()
+	rollout.Spec.ModelRef.Name = "missing"
+	c := newFakeClient(t, objs...)
+	r := &ModelRolloutReconciler{Client: c, Scheme: c.Scheme()}
+
+	got, _ := reconcileRollout(t, r, client.ObjectKeyFromObject(rollout))
+	assert.Equal(t, neuronetes.RolloutFailed, got.Status.Phase)
+	assert.Equal(t, "model missing not found", got.Status.Message)
+
+	// A model is rolled out once at a time
+	first, objs := newTestRollout()
+	second := first.DeepCopy()
+	second.Name = "v3"
+	second.UID = "second-uid"
+	c = newFakeClient(t, append(objs, second)...)
+	r = &ModelRolloutReconciler{Client: c, Scheme: c.Scheme()}
+
+	got, _ = reconcileRollout(t, r, client.ObjectKeyFromObject(second))
+	assert.Equal(t, neuronetes.RolloutFailed, got.Status.Phase)
+	assert.Equal(t, "model llama-3-8b is being rolled out by v2", got.Status.Message)
+	got, _ = reconcileRollout(t, r, client.ObjectKeyFromObject(first))
+	assert.Equal(t, neuronetes.RolloutPending, got.Status.Phase)
+
+	// Weights that fail to load fail the rollout
+	candidate := &neuronetes.Model{}
+	require.NoError(t, c.Get(context.Background(), types.NamespacedName{Namespace: "default", Name: "llama-3-8b-v2"}, candidate))
+	candidate.Status.Phase = "Failed"
+	candidate.Status.Conditions = []metav1.Condition{{
+		Type: ConditionWeightsVerified, Status: metav1.ConditionFalse, Reason: "IntegrityCheckFailed",
+		Message: "digest mismatch", LastTransitionTime: metav1.Now(),
+	}}
+	require.NoError(t, c.Status().Update(context.Background(), candidate))
+	got, _ = reconcileRollout(t, r, client.ObjectKeyFromObject(first))
+	assert.Equal(t, neuronetes.RolloutFailed, got.Status.Phase)
+	assert.Equal(t, "failed to load the new weights: digest mismatch", got.Status.Message)
+	assert.True(t, apierrors.IsNotFound(c.Get(context.Background(), client.ObjectKeyFromObject(candidate), &neuronetes.Model{})))
+}
@@ -0,0 +1,71 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	schedulingv1 "k8s.io/api/scheduling/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+)
+
+// priorityClassPrefix names the PriorityClasses created for pool priorities,
+// one per value shared by all pools of that priority
+const priorityClassPrefix = "neuronetes-priority-"
+
+// priorityClassName returns the PriorityClass replicas of pool run with: the
+// one the pool names, the one for its priority, or none
+func priorityClassName(pool *neuronetes.AgentPool) string {
+	scheduling := pool.Spec.Scheduling
+	if scheduling == nil {
+		return ""
+	}
+	if scheduling.PriorityClassName != "" {
+		return scheduling.PriorityClassName
+	}
+	if scheduling.Priority == nil {
+		return ""
+	}
+	value := strconv.Itoa(int(*scheduling.Priority))
+	return priorityClassPrefix + strings.Replace(value, "-", "minus-", 1)
+}
+
+// ensurePriorityClass creates the PriorityClass for the pool's priority. The
+// class preempts replicas of lower priority pools when GPUs are scarce. It is
+// cluster-scoped and shared, so it is not owned by the pool and outlives it.
+func (r *AgentPoolReconciler) ensurePriorityClass(ctx context.Context, pool *neuronetes.AgentPool) error {
+	scheduling := pool.Spec.Scheduling
+	if scheduling == nil || scheduling.Priority == nil || scheduling.PriorityClassName != "" {
+		return nil
+	}
+
+	name := priorityClassName(pool)
+	var existing schedulingv1.PriorityClass
+	err := r.Get(ctx, types.NamespacedName{Name: name}, &existing)
+	if err == nil {
+		return nil
+	}
+	if !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to get priority class %s: %w", name, err)
+	}
+
+	preemptLowerPriority := corev1.PreemptLowerPriority
+	priorityClass := &schedulingv1.PriorityClass{
+		ObjectMeta:       metav1.ObjectMeta{Name: name},
+		Value:            *scheduling.Priority,
+		PreemptionPolicy: &preemptLowerPriority,
+		Description:      fmt.Sprintf("Priority %d of NeuroNetes agent pools", *scheduling.Priority),
+	}
+	if err := r.Create(ctx, priorityClass); err != nil && !apierrors.IsAlreadyExists(err) {
+		return fmt.Errorf("failed to create priority class %s: %w", name, err)
+	}
+	log.FromContext(ctx).Info("Created priority class", "priorityClass", name, "priority", *scheduling.Priority)
+	return nil
+}
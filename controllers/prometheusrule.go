@@ -0,0 +1,198 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/autoscaler"
+)
+
+// prometheusRuleGVK is the prometheus-operator PrometheusRule kind
+var prometheusRuleGVK = schema.GroupVersionKind{Group: "monitoring.coreos.com", Version: "v1", Kind: "PrometheusRule"}
+
+// Series recorded for each pool
+const (
+	recordTTFTP95      = "neuronetes:pool_ttft_p95:rate5m"
+	recordLatencyP95   = "neuronetes:pool_latency_p95:rate5m"
+	recordInputTokens  = "neuronetes:pool_input_tokens:rate5m"
+	recordOutputTokens = "neuronetes:pool_output_tokens:rate5m"
+	recordErrorRatio   = "neuronetes:pool_error_ratio:rate"
+)
+
+// +kubebuilder:rbac:groups=monitoring.coreos.com,resources=prometheusrules,verbs=get;list;watch;create;update;patch;delete
+
+// reconcilePrometheusRule keeps a PrometheusRule for pool recording its
+// p95 TTFT and latency, token rates and error ratios, and alerting when the
+// SLOs of its AgentClass are breached or their error budget burns too fast.
+// It is skipped when the prometheus-operator CRDs are not installed.
+func (r *AgentPoolReconciler) reconcilePrometheusRule(ctx context.Context, pool *neuronetes.AgentPool, class *neuronetes.AgentClass) error {
+	rule := &unstructured.Unstructured{}
+	rule.SetGroupVersionKind(prometheusRuleGVK)
+	rule.SetName(prometheusRuleName(pool))
+	rule.SetNamespace(pool.Namespace)
+
+	result, err := controllerutil.CreateOrUpdate(ctx, r.Client, rule, func() error {
+		ruleLabels := rule.GetLabels()
+		if ruleLabels == nil {
+			ruleLabels = map[string]string{}
+		}
+		for k, v := range r.RuleLabels {
+			ruleLabels[k] = v
+		}
+		ruleLabels[neuronetes.LabelPool] = pool.Name
+		rule.SetLabels(ruleLabels)
+		rule.Object["spec"] = map[string]interface{}{
+			"groups": []interface{}{map[string]interface{}{
+				"name":  fmt.Sprintf("neuronetes.%s.%s", pool.Namespace, pool.Name),
+				"rules": poolRules(pool, class),
+			}},
+		}
+		return ctrl.SetControllerReference(pool, rule, r.Scheme)
+	})
+	if meta.IsNoMatchError(err) {
+		// prometheus-operator is not installed
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to reconcile prometheus rule %s: %w", rule.GetName(), err)
+	}
+	if result != controllerutil.OperationResultNone {
+		log.FromContext(ctx).Info("Reconciled prometheus rule", "rule", rule.GetName(), "operation", result)
+	}
+	return nil
+}
+
+// prometheusRuleName names the PrometheusRule of pool
+func prometheusRuleName(pool *neuronetes.AgentPool) string {
+	return pool.Name + "-slo"
+}
+
+// poolSelector returns the PromQL label matchers selecting the series of
+// pool, as overridden by its metrics-selector annotation
+func poolSelector(pool *neuronetes.AgentPool) string {
+	if selector := pool.Annotations[neuronetes.AnnotationMetricsSelector]; selector != "" {
+		return selector
+	}
+	return fmt.Sprintf("namespace=%q,pool=%q", pool.Namespace, pool.Name)
+}
+
+// poolRules returns the recording rules of pool, followed by alerting rules
+// for each SLO class sets
+func poolRules(pool *neuronetes.AgentPool, class *neuronetes.AgentClass) []interface{} {
+	sel := poolSelector(pool)
+	recorded := fmt.Sprintf("namespace=%q,pool=%q", pool.Namespace, pool.Name)
+	ruleLabels := map[string]interface{}{"namespace": pool.Namespace, "pool": pool.Name}
+
+	rules := []interface{}{
+		recordingRule(recordTTFTP95, ruleLabels,
+			fmt.Sprintf(`histogram_quantile(0.95, sum by (le) (rate(agent_ttft_ms_bucket{%s}[5m])))`, sel)),
+		recordingRule(recordLatencyP95, ruleLabels,
+			fmt.Sprintf(`histogram_quantile(0.95, sum by (le) (rate(agent_latency_ms_bucket{%s}[5m])))`, sel)),
+		recordingRule(recordInputTokens, ruleLabels,
+			fmt.Sprintf(`sum(rate(agent_input_tokens_total{%s}[5m]))`, sel)),
+		recordingRule(recordOutputTokens, ruleLabels,
+			fmt.Sprintf(`sum(rate(agent_output_tokens_total{%s}[5m]))`, sel)),
+	}
+	for _, window := range autoscaler.SLOWindows {
+		w := model.Duration(window).String()
+		rules = append(rules, recordingRule(recordErrorRatio+w, ruleLabels,
+			fmt.Sprintf(`(sum(rate(agent_turn_errors_total{%s}[%s])) or vector(0)) / sum(rate(agent_latency_ms_count{%s}[%s]))`, sel, w, sel, w)))
+	}
+
+	if class == nil || class.Spec.SLO == nil {
+		return rules
+	}
+	slo := class.Spec.SLO
+	subject := fmt.Sprintf("AgentPool %s/%s", pool.Namespace, pool.Name)
+
+	if slo.TTFT != nil && slo.TTFT.Duration > 0 {
+		target := milliseconds(slo.TTFT.Duration)
+		rules = append(rules, alertingRule("AgentPoolTTFTSLOBreach", "warning", "5m",
+			fmt.Sprintf("%s{%s} > %s", recordTTFTP95, recorded, target),
+			"TTFT P95 above the SLO",
+			fmt.Sprintf("%s TTFT P95 is {{ $value }}ms, above its %sms SLO", subject, target)))
+	}
+	if slo.P95Latency != nil && slo.P95Latency.Duration > 0 {
+		target := milliseconds(slo.P95Latency.Duration)
+		rules = append(rules, alertingRule("AgentPoolLatencySLOBreach", "warning", "5m",
+			fmt.Sprintf("%s{%s} > %s", recordLatencyP95, recorded, target),
+			"Turn latency P95 above the SLO",
+			fmt.Sprintf("%s latency P95 is {{ $value }}ms, above its %sms SLO", subject, target)))
+	}
+	if slo.TokensPerSecond != nil && *slo.TokensPerSecond > 0 {
+		target := strconv.Itoa(int(*slo.TokensPerSecond))
+		rules = append(rules, alertingRule("AgentPoolThroughputSLOBreach", "warning", "10m",
+			fmt.Sprintf("avg(agent_tokens_out_per_s{%s}) < %s", sel, target),
+			"Generation throughput below the SLO",
+			fmt.Sprintf("%s generates {{ $value }} tokens/s, below its %s tokens/s SLO", subject, target)))
+	}
+	if budget, ok := autoscaler.AvailabilityBudget(slo); ok {
+		rules = append(rules,
+			burnRateAlert("AgentPoolErrorBudgetFastBurn", "critical", "2m", recorded, budget,
+				autoscaler.FastBurnRate, time.Hour, 5*time.Minute, subject),
+			burnRateAlert("AgentPoolErrorBudgetSlowBurn", "warning", "15m", recorded, budget,
+				autoscaler.SlowBurnRate, 6*time.Hour, time.Hour, subject))
+	}
+	return rules
+}
+
+// burnRateAlert alerts when the error ratios of a pool over both the long
+// and short windows spend its error budget faster than burnRate
+func burnRateAlert(name, severity, pending, recorded string, budget, burnRate float64, long, short time.Duration, subject string) interface{} {
+	threshold := formatFloat(burnRate * budget)
+	l, s := model.Duration(long).String(), model.Duration(short).String()
+	return alertingRule(name, severity, pending,
+		fmt.Sprintf("%s%s{%s} > %s and %s%s{%s} > %s",
+			recordErrorRatio, l, recorded, threshold, recordErrorRatio, s, recorded, threshold),
+		"Error budget burning faster than sustainable rate",
+		fmt.Sprintf("%s error ratio is {{ $value | humanizePercentage }} over %s, burning its error budget more than %sx as fast as sustainable",
+			subject, l, formatFloat(burnRate)))
+}
+
+// recordingRule returns a rule recording expr as record with labels
+func recordingRule(record string, labels map[string]interface{}, expr string) interface{} {
+	return map[string]interface{}{
+		"record": record,
+		"expr":   expr,
+		"labels": labels,
+	}
+}
+
+// alertingRule returns a rule firing alert when expr holds for pending
+func alertingRule(alert, severity, pending, expr, summary, description string) interface{} {
+	return map[string]interface{}{
+		"alert": alert,
+		"expr":  expr,
+		"for":   pending,
+		"labels": map[string]interface{}{
+			"severity": severity,
+			"category": "slo",
+		},
+		"annotations": map[string]interface{}{
+			"summary":     summary,
+			"description": description,
+		},
+	}
+}
+
+// milliseconds formats d in milliseconds
+func milliseconds(d time.Duration) string {
+	return formatFloat(float64(d) / float64(time.Millisecond))
+}
+
+// formatFloat formats v with up to six significant digits, hiding the
+// rounding errors of float32 SLO targets
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', 6, 64)
+}
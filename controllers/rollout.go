@@ -0,0 +1,153 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+)
+
+var (
+	// defaultMaxSurge starts a quarter of the pool's replicas ahead of the
+	// ones they replace
+	defaultMaxSurge = intstr.FromString("25%")
+
+	// defaultMaxUnavailable keeps every replica serving until its
+	// replacement is ready
+	defaultMaxUnavailable = intstr.FromInt32(0)
+)
+
+// agentClassKey returns the key of the AgentClass pool references
+func agentClassKey(pool *neuronetes.AgentPool) types.NamespacedName {
+	namespace := pool.Spec.AgentClassRef.Namespace
+	if namespace == "" {
+		namespace = pool.Namespace
+	}
+	return types.NamespacedName{Namespace: namespace, Name: pool.Spec.AgentClassRef.Name}
+}
+
+// modelKey returns the key of the Model class references
+func modelKey(class *neuronetes.AgentClass) types.NamespacedName {
+	namespace := class.Spec.ModelRef.Namespace
+	if namespace == "" {
+		namespace = class.Namespace
+	}
+	return types.NamespacedName{Namespace: namespace, Name: class.Spec.ModelRef.Name}
+}
+
+// workload returns the AgentClass pool runs and its Model. A class or model
+// that does not exist yet is returned as nil.
+func (r *AgentPoolReconciler) workload(ctx context.Context, pool *neuronetes.AgentPool) (*neuronetes.AgentClass, *neuronetes.Model, error) {
+	class := &neuronetes.AgentClass{}
+	if err := r.Get(ctx, agentClassKey(pool), class); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil, nil
+		}
+		return nil, nil, fmt.Errorf("failed to get agent class %s: %w", pool.Spec.AgentClassRef.Name, err)
+	}
+
+	model := &neuronetes.Model{}
+	if err := r.Get(ctx, modelKey(class), model); err != nil {
+		if apierrors.IsNotFound(err) {
+			return class, nil, nil
+		}
+		return nil, nil, fmt.Errorf("failed to get model %s: %w", class.Spec.ModelRef.Name, err)
+	}
+	return class, model, nil
+}
+
+// revision hashes the specs of the AgentClass and Model a pool runs.
+// Replicas load both at startup, so a change to either rolls them. A missing
+// class or model is left out of the hash.
+func revision(class *neuronetes.AgentClass, model *neuronetes.Model) (string, error) {
+	if class == nil {
+		return "", nil
+	}
+	var specs struct {
+		AgentClass *neuronetes.AgentClassSpec `json:"agentClass,omitempty"`
+		Model      *neuronetes.ModelSpec      `json:"model,omitempty"`
+	}
+	specs.AgentClass = &class.Spec
+	if model != nil {
+		specs.Model = &model.Spec
+	}
+
+	data, err := json.Marshal(specs)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])[:10], nil
+}
+
+// rolloutStrategy returns the Deployment strategy replacing pool's replicas.
+// Replicas scheduled as a gang surge a whole gang at a time by default, as
+// fewer replacements could never be placed.
+func rolloutStrategy(pool *neuronetes.AgentPool, gang int32) appsv1.DeploymentStrategy {
+	maxSurge, maxUnavailable := defaultMaxSurge, defaultMaxUnavailable
+	if gang > 1 {
+		maxSurge = intstr.FromInt32(gang)
+	}
+	if rollout := pool.Spec.Rollout; rollout != nil {
+		if rollout.MaxSurge != nil {
+			maxSurge = *rollout.MaxSurge
+		}
+		if rollout.MaxUnavailable != nil {
+			maxUnavailable = *rollout.MaxUnavailable
+		}
+	}
+	return appsv1.DeploymentStrategy{
+		Type: appsv1.RollingUpdateDeploymentStrategyType,
+		RollingUpdate: &appsv1.RollingUpdateDeployment{
+			MaxSurge:       &maxSurge,
+			MaxUnavailable: &maxUnavailable,
+		},
+	}
+}
+
+// poolsForAgentClass maps an AgentClass to the pools that run it
+func (r *AgentPoolReconciler) poolsForAgentClass(ctx context.Context, obj client.Object) []reconcile.Request {
+	key := client.ObjectKeyFromObject(obj)
+
+	var pools neuronetes.AgentPoolList
+	if err := r.List(ctx, &pools); err != nil {
+		log.FromContext(ctx).Error(err, "failed to list agent pools", "agentClass", key)
+		return nil
+	}
+	var requests []reconcile.Request
+	for i := range pools.Items {
+		if agentClassKey(&pools.Items[i]) == key {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&pools.Items[i])})
+		}
+	}
+	return requests
+}
+
+// poolsForModel maps a Model to the pools running an AgentClass of it
+func (r *AgentPoolReconciler) poolsForModel(ctx context.Context, obj client.Object) []reconcile.Request {
+	key := client.ObjectKeyFromObject(obj)
+
+	var classes neuronetes.AgentClassList
+	if err := r.List(ctx, &classes); err != nil {
+		log.FromContext(ctx).Error(err, "failed to list agent classes", "model", key)
+		return nil
+	}
+	var requests []reconcile.Request
+	for i := range classes.Items {
+		if modelKey(&classes.Items[i]) == key {
+			requests = append(requests, r.poolsForAgentClass(ctx, &classes.Items[i])...)
+		}
+	}
+	return requests
+}
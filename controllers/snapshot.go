@@ -0,0 +1,136 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/checkpoint"
+)
+
+// NewSnapshotter creates a snapshotter for the agent container of the
+// replicas AgentPoolReconciler creates, running cuda-checkpoint through exec
+func NewSnapshotter(exec checkpoint.Executor) *checkpoint.Client {
+	return checkpoint.NewClient(exec, agentComponent, agentPort)
+}
+
+// isSnapshotted reports whether the GPU memory of pod is snapshotted
+func isSnapshotted(pod *corev1.Pod) bool {
+	_, ok := pod.Annotations[neuronetes.AnnotationSnapshotted]
+	return ok
+}
+
+// restoreConfig returns the configuration a snapshotted pod is restored
+// with: its pool's, unless the pool no longer snapshots by the method the
+// pod was snapshotted with
+func restoreConfig(pool *neuronetes.AgentPool, pod *corev1.Pod) *neuronetes.SnapshotConfig {
+	method := pod.Annotations[neuronetes.AnnotationSnapshotted]
+	if pool.Spec.Snapshot != nil && pool.Spec.Snapshot.Method == method {
+		return pool.Spec.Snapshot
+	}
+	return &neuronetes.SnapshotConfig{Method: method}
+}
+
+// restoreReplicas restores the snapshotted pods among candidates, sorted for
+// serving, that are about to serve, and every snapshotted pod of a pool that
+// no longer snapshots. Restores run concurrently, so activating several
+// replicas takes as long as the slowest restore. Pods that fail to restore
+// are deleted for the Deployment to replace and dropped from candidates.
+func (r *AgentPoolReconciler) restoreReplicas(ctx context.Context, pool *neuronetes.AgentPool, candidates []*corev1.Pod) ([]*corev1.Pod, error) {
+	if r.Snapshots == nil {
+		return candidates, nil
+	}
+	var restore []*corev1.Pod
+	for i, pod := range candidates {
+		if isSnapshotted(pod) && (int32(i) < pool.Status.Replicas || pool.Spec.Snapshot == nil) {
+			restore = append(restore, pod)
+		}
+	}
+	if len(restore) == 0 {
+		return candidates, nil
+	}
+
+	errs := make([]error, len(restore))
+	durations := make([]time.Duration, len(restore))
+	var wg sync.WaitGroup
+	for i, pod := range restore {
+		wg.Add(1)
+		go func(i int, pod *corev1.Pod) {
+			defer wg.Done()
+			start := time.Now()
+			errs[i] = r.Snapshots.Restore(ctx, pod, restoreConfig(pool, pod))
+			durations[i] = time.Since(start)
+		}(i, pod)
+	}
+	wg.Wait()
+
+	log := log.FromContext(ctx)
+	for i, pod := range restore {
+		if errs[i] != nil {
+			log.Error(errs[i], "failed to restore replica, replacing it", "pod", pod.Name)
+			if err := r.Delete(ctx, pod); client.IgnoreNotFound(err) != nil {
+				return nil, fmt.Errorf("failed to replace pod %s: %w", pod.Name, err)
+			}
+			candidates = removePod(candidates, pod)
+			continue
+		}
+		if r.Metrics != nil {
+			r.Metrics.SnapshotRestoreTime.Observe(durations[i].Seconds())
+		}
+		log.Info("Restored replica", "pod", pod.Name, "duration", durations[i])
+
+		patch := client.MergeFrom(pod.DeepCopy())
+		delete(pod.Annotations, neuronetes.AnnotationSnapshotted)
+		if err := r.Patch(ctx, pod, patch); err != nil {
+			return nil, fmt.Errorf("failed to mark pod %s restored: %w", pod.Name, err)
+		}
+	}
+	return candidates, nil
+}
+
+// snapshotIdle snapshots the ready warm pods that have been warm for the
+// idle period of their pool, and returns how many warm pods are snapshotted.
+// A pod that fails to snapshot keeps its GPU memory and is retried later.
+func (r *AgentPoolReconciler) snapshotIdle(ctx context.Context, pool *neuronetes.AgentPool, warm []*corev1.Pod) (int32, error) {
+	log := log.FromContext(ctx)
+	config := pool.Spec.Snapshot
+	now := r.now()
+
+	var snapshotted int32
+	for _, pod := range warm {
+		if isSnapshotted(pod) {
+			snapshotted++
+			continue
+		}
+		if r.Snapshots == nil || config == nil {
+			continue
+		}
+		since, err := time.Parse(time.RFC3339, pod.Annotations[neuronetes.AnnotationWarmSince])
+		if err != nil || now.Sub(since) < checkpoint.IdleAfter(config) {
+			continue
+		}
+
+		if err := r.Snapshots.Snapshot(ctx, pod, config); err != nil {
+			log.Error(err, "failed to snapshot idle replica", "pod", pod.Name)
+			continue
+		}
+		patch := client.MergeFrom(pod.DeepCopy())
+		pod.Annotations[neuronetes.AnnotationSnapshotted] = config.Method
+		if err := r.Patch(ctx, pod, patch); err != nil {
+			// An unmarked snapshot would never be restored before serving
+			if restoreErr := r.Snapshots.Restore(ctx, pod, config); restoreErr != nil {
+				log.Error(restoreErr, "failed to restore unmarked snapshot", "pod", pod.Name)
+			}
+			return snapshotted, fmt.Errorf("failed to mark pod %s snapshotted: %w", pod.Name, err)
+		}
+		log.Info("Snapshotted idle replica", "pod", pod.Name, "method", config.Method)
+		snapshotted++
+	}
+	return snapshotted, nil
+}
@@ -0,0 +1,23 @@
+package controllers
+
+import (
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/capacity"
+)
+
+// vramFootprint returns the GPU memory a replica serving model uses on each
+// of its GPUs, as estimated by the capacity planner from its spec defaulted
+// by the metadata of its weights. Replicas are annotated with it for the
+// scheduler to pack them by.
+func vramFootprint(model *neuronetes.Model) (resource.Quantity, bool) {
+	if model == nil {
+		return resource.Quantity{}, false
+	}
+	estimate := capacity.Estimate(defaultedSpec(model))
+	if estimate == nil {
+		return resource.Quantity{}, false
+	}
+	return estimate.PerGPU, true
+}
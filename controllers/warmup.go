@@ -0,0 +1,234 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/warmup"
+)
+
+// warmUpPollInterval is how often a pool is reconciled while replicas warm
+// up, so they become ready soon after their warm-up finishes
+const warmUpPollInterval = 5 * time.Second
+
+// NewWarmUpRunner creates a runner warming up the agent runtime of the
+// replicas AgentPoolReconciler creates
+func NewWarmUpRunner() *warmup.Client {
+	return warmup.NewClient(agentPort)
+}
+
+// replicaWarmUp tracks the warm-up of one replica
+type replicaWarmUp struct {
+	pool     types.NamespacedName
+	duration time.Duration
+	err      error
+	// finished is set once the warm-up returned
+	finished bool
+}
+
+// warmUpTracker runs the warm-up sequences of replicas in the background,
+// as they outlive a single reconcile
+type warmUpTracker struct {
+	mu      sync.Mutex
+	warmUps map[types.UID]*replicaWarmUp
+}
+
+// start begins warming up pod unless its warm-up is already running
+func (t *warmUpTracker) start(pool types.NamespacedName, runner warmup.Runner, pod *corev1.Pod, config *neuronetes.WarmUpConfig) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.warmUps == nil {
+		t.warmUps = make(map[types.UID]*replicaWarmUp)
+	}
+	if _, ok := t.warmUps[pod.UID]; ok {
+		return
+	}
+	run := &replicaWarmUp{pool: pool}
+	t.warmUps[pod.UID] = run
+
+	go func() {
+		start := time.Now()
+		err := runner.WarmUp(context.Background(), pod, config)
+
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		run.finished = true
+		run.duration = time.Since(start)
+		run.err = err
+	}()
+}
+
+// result returns the state of the warm-up of the pod uid
+func (t *warmUpTracker) result(uid types.UID) (replicaWarmUp, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	run, ok := t.warmUps[uid]
+	if !ok {
+		return replicaWarmUp{}, false
+	}
+	return *run, true
+}
+
+// running reports whether replicas of pool are warming up
+func (t *warmUpTracker) running(pool types.NamespacedName) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, run := range t.warmUps {
+		if run.pool == pool {
+			return true
+		}
+	}
+	return false
+}
+
+// forget drops the warm-up of the pod uid. A warm-up still running is left
+// to time out.
+func (t *warmUpTracker) forget(uid types.UID) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.warmUps, uid)
+}
+
+// retain forgets the warm-ups of the replicas of pool other than pods,
+// which were deleted
+func (t *warmUpTracker) retain(pool types.NamespacedName, pods []*corev1.Pod) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	keep := make(map[types.UID]bool, len(pods))
+	for _, pod := range pods {
+		keep[pod.UID] = true
+	}
+	for uid, run := range t.warmUps {
+		if run.pool == pool && !keep[uid] {
+			delete(t.warmUps, uid)
+		}
+	}
+}
+
+// warmsUp reports whether the replicas of pool are gated on their warm-up
+func (r *AgentPoolReconciler) warmsUp(pool *neuronetes.AgentPool) bool {
+	return r.WarmUp != nil && pool.Spec.WarmUp != nil
+}
+
+// readinessGates returns the readiness gates of the replicas of pool,
+// which gate the replicas of pools that warm up on their warm-up
+func (r *AgentPoolReconciler) readinessGates(pool *neuronetes.AgentPool) []corev1.PodReadinessGate {
+	if !r.warmsUp(pool) {
+		return nil
+	}
+	return []corev1.PodReadinessGate{{ConditionType: neuronetes.PodConditionWarmedUp}}
+}
+
+// reconcileWarmUps warms up the replicas of pool whose containers are
+// ready, and opens their readiness gate once the warm-up sequence finished.
+// A failed warm-up is retried on the next reconcile. Replicas created
+// before the pool stopped warming up are let through without one.
+func (r *AgentPoolReconciler) reconcileWarmUps(ctx context.Context, pool *neuronetes.AgentPool, pods []*corev1.Pod) error {
+	log := log.FromContext(ctx)
+	key := client.ObjectKeyFromObject(pool)
+	r.warmUps.retain(key, pods)
+
+	for _, pod := range pods {
+		if !hasWarmUpGate(pod) || podCondition(pod, neuronetes.PodConditionWarmedUp) == corev1.ConditionTrue {
+			continue
+		}
+		if !r.warmsUp(pool) {
+			if err := r.setWarmedUp(ctx, pod, corev1.ConditionTrue, "WarmUpDisabled", "The pool does not warm up replicas"); err != nil {
+				return err
+			}
+			continue
+		}
+		if podCondition(pod, corev1.ContainersReady) != corev1.ConditionTrue || pod.Status.PodIP == "" {
+			continue
+		}
+
+		run, ok := r.warmUps.result(pod.UID)
+		if !ok {
+			r.warmUps.start(key, r.WarmUp, pod.DeepCopy(), pool.Spec.WarmUp.DeepCopy())
+			log.Info("Warming up replica", "pod", pod.Name)
+			continue
+		}
+		if !run.finished {
+			continue
+		}
+		r.warmUps.forget(pod.UID)
+
+		if run.err != nil {
+			log.Error(run.err, "failed to warm up replica, retrying", "pod", pod.Name)
+			if err := r.setWarmedUp(ctx, pod, corev1.ConditionFalse, "WarmUpFailed", run.err.Error()); err != nil {
+				return err
+			}
+			continue
+		}
+		duration := run.duration.Round(time.Millisecond)
+		if err := r.setWarmedUp(ctx, pod, corev1.ConditionTrue, "WarmedUp", fmt.Sprintf("Warmed up in %s", duration)); err != nil {
+			return err
+		}
+		pool.Status.LastWarmUpDuration = &metav1.Duration{Duration: duration}
+		log.Info("Warmed up replica", "pod", pod.Name, "duration", duration)
+	}
+	return nil
+}
+
+// setWarmedUp sets the warmed-up condition of pod
+func (r *AgentPoolReconciler) setWarmedUp(ctx context.Context, pod *corev1.Pod, status corev1.ConditionStatus, reason, message string) error {
+	patch := client.StrategicMergeFrom(pod.DeepCopy())
+	condition := corev1.PodCondition{
+		Type:               neuronetes.PodConditionWarmedUp,
+		Status:             status,
+		LastTransitionTime: metav1.NewTime(r.now()),
+		Reason:             reason,
+		Message:            message,
+	}
+	found := false
+	for i := range pod.Status.Conditions {
+		if pod.Status.Conditions[i].Type == condition.Type {
+			if pod.Status.Conditions[i].Status == status {
+				condition.LastTransitionTime = pod.Status.Conditions[i].LastTransitionTime
+			}
+			pod.Status.Conditions[i] = condition
+			found = true
+		}
+	}
+	if !found {
+		pod.Status.Conditions = append(pod.Status.Conditions, condition)
+	}
+	if err := r.Status().Patch(ctx, pod, patch); err != nil {
+		return fmt.Errorf("failed to set warm-up condition of pod %s: %w", pod.Name, err)
+	}
+	return nil
+}
+
+// hasWarmUpGate reports whether the readiness of pod waits for its warm-up
+func hasWarmUpGate(pod *corev1.Pod) bool {
+	for _, gate := range pod.Spec.ReadinessGates {
+		if gate.ConditionType == neuronetes.PodConditionWarmedUp {
+			return true
+		}
+	}
+	return false
+}
+
+// podCondition returns the status of the condition of type t of pod
+func podCondition(pod *corev1.Pod, t corev1.PodConditionType) corev1.ConditionStatus {
+	for _, c := range pod.Status.Conditions {
+		if c.Type == t {
+			return c.Status
+		}
+	}
+	return corev1.ConditionUnknown
+}
@@ -0,0 +1,417 @@
+package accounting
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/metrics"
+	"github.com/bowenislandsong/neuronetes/pkg/pricing"
+)
+
+// memoryStore is a Store keeping the saved totals in memory
+type memoryStore struct {
+	usage []Usage
+	saves int
+	err   error
+}
+
+func (s *memoryStore) Load(ctx context.Context) ([]Usage, error) {
+	return s.usage, s.err
+}
+
+func (s *memoryStore) Save(ctx context.Context, usage []Usage) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.usage = usage
+	s.saves++
+	return nil
+}
+
+func TestLedgerSurvivesRestarts(t *testing.T) {
+	ctx := context.Background()
+	store := &memoryStore{}
+	ledger := NewLedger(store, 0)
+
+	// Tokens recorded before restoring are kept, but not saved
+	ledger.Record("tenant-1", "llama-3-8b", 100, 50)
+	assert.ErrorIs(t, ledger.Checkpoint(ctx), ErrNotRestored)
+	require.NoError(t, ledger.Restore(ctx))
+	require.NoError(t, ledger.Checkpoint(ctx))
+	assert.Equal(t, []Usage{{Tenant: "tenant-1", Model: "llama-3-8b", InputTokens: 100, OutputTokens: 50}}, store.usage)
+
+	// Unchanged totals are not saved again
+	require.NoError(t, ledger.Checkpoint(ctx))
+	assert.Equal(t, 1, store.saves)
+
+	// A failed checkpoint is retried by the next one
+	ledger.Record("tenant-2", "llama-3-70b", 10, 5)
+	store.err = errors.New("unavailable")
+	assert.Error(t, ledger.Checkpoint(ctx))
+	store.err = nil
+	require.NoError(t, ledger.Checkpoint(ctx))
+	assert.Len(t, store.usage, 2)
+
+	// A restarted process continues from the saved totals
+	restarted := NewLedger(store, 0)
+	restarted.Record("tenant-1", "llama-3-8b", 1, 1)
+	require.NoError(t, restarted.Restore(ctx))
+	assert.Equal(t, []Usage{{Tenant: "tenant-1", Model: "llama-3-8b", InputTokens: 101, OutputTokens: 51}},
+		restarted.Usage("tenant-1", ""))
+	assert.Equal(t, []Usage{{Tenant: "tenant-2", Model: "llama-3-70b", InputTokens: 10, OutputTokens: 5}},
+		restarted.Usage("", "llama-3-70b"))
+	assert.Len(t, restarted.Usage("", ""), 2)
+}
+
+func TestLedgerStartCheckpointsOnStop(t *testing.T) {
+	store := &memoryStore{usage: []Usage{{Tenant: "tenant-1", Model: "m", InputTokens: 1}}}
+	ledger := NewLedger(store, time.Hour)
+	ledger.Record("tenant-1", "m", 1, 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- ledger.Start(ctx) }()
+	require.Eventually(t, func() bool { return len(ledger.Usage("", "")) == 1 && ledger.Usage("", "")[0].InputTokens == 2 },
+		5*time.Second, 10*time.Millisecond)
+	cancel()
+	require.NoError(t, <-done)
+	assert.Equal(t, []Usage{{Tenant: "tenant-1", Model: "m", InputTokens: 2}}, store.usage)
+}
+
+func TestHandler(t *testing.T) {
+	ledger := NewLedger(&memoryStore{}, 0)
+	ledger.Record("tenant-1", "llama-3-8b", 100, 50)
+	ledger.Record("tenant-1", "llama-3-70b", 10, 5)
+	ledger.Record("tenant-2", "llama-3-8b", 1, 1)
+	require.NoError(t, ledger.Restore(context.Background()))
+	server := httptest.NewServer(ledger.Handler())
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + UsagePath + "?tenant=tenant-1")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var report UsageReport
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&report))
+	assert.Equal(t, []Usage{
+		{Tenant: "tenant-1", Model: "llama-3-70b", InputTokens: 10, OutputTokens: 5},
+		{Tenant: "tenant-1", Model: "llama-3-8b", InputTokens: 100, OutputTokens: 50},
+	}, report.Usage)
+	assert.Equal(t, int64(110), report.InputTokens)
+	assert.Equal(t, int64(55), report.OutputTokens)
+	assert.Nil(t, report.CheckpointedAt)
+
+	require.NoError(t, ledger.Checkpoint(context.Background()))
+	resp, err = http.Get(server.URL + UsagePath + "?model=llama-3-8b")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	report = UsageReport{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&report))
+	assert.Len(t, report.Usage, 2)
+	assert.NotNil(t, report.CheckpointedAt)
+
+	resp, err = http.Post(server.URL+UsagePath, "application/json", nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
+
+func TestConfigMapStore(t *testing.T) {
+	ctx := context.Background()
+	store := &ConfigMapStore{Client: fake.NewClientBuilder().Build(), Namespace: "neuronetes-system", Name: "ledger-0"}
+
+	usage, err := store.Load(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, usage)
+
+	saved := []Usage{{Tenant: "tenant-1", Model: "llama-3-8b", InputTokens: 100, OutputTokens: 50}}
+	require.NoError(t, store.Save(ctx, saved))
+	saved[0].InputTokens = 200
+	require.NoError(t, store.Save(ctx, saved))
+	usage, err = store.Load(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, saved, usage)
+}
+
+func TestSQLStore(t *testing.T) {
+	ctx := context.Background()
+	db := sql.OpenDB(&fakeConnector{db: &fakeDB{rows: map[string][]driver.Value{}}})
+	defer db.Close()
+	store := &SQLStore{DB: db, Ledger: "ledger-0"}
+	require.NoError(t, store.CreateTable(ctx))
+
+	saved := []Usage{
+		{Tenant: "tenant-1", Model: "llama-3-8b", InputTokens: 100, OutputTokens: 50},
+		{Tenant: "tenant-2", Model: "llama-3-70b", InputTokens: 10, OutputTokens: 5},
+	}
+	require.NoError(t, store.Save(ctx, saved))
+	saved[0].OutputTokens = 60
+	require.NoError(t, store.Save(ctx, saved))
+
+	usage, err := store.Load(ctx)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, saved, usage)
+
+	// Other ledgers' rows are not loaded
+	usage, err = (&SQLStore{DB: db, Ledger: "ledger-1"}).Load(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, usage)
+
+	_, err = (&SQLStore{DB: db, Table: "usage; DROP TABLE usage"}).Load(ctx)
+	assert.Error(t, err)
+}
+
+func TestS3Store(t *testing.T) {
+	var mu sync.Mutex
+	objects := map[string][]byte{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Contains(t, r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/")
+		assert.Contains(t, r.Header.Get("Authorization"), "/us-west-2/s3/aws4_request")
+		assert.NotEmpty(t, r.Header.Get("X-Amz-Content-Sha256"))
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.Method {
+		case http.MethodGet:
+			data, ok := objects[r.URL.Path]
+			if !ok {
+				http.Error(w, "<Error><Code>NoSuchKey</Code></Error>", http.StatusNotFound)
+				return
+			}
+			_, _ = w.Write(data)
+		case http.MethodPut:
+			objects[r.URL.Path], _ = io.ReadAll(r.Body)
+		}
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	store := newS3Store(aws.CredentialsProviderFunc(func(ctx context.Context) (aws.Credentials, error) {
+		return aws.Credentials{AccessKeyID: "AKID", SecretAccessKey: "SECRET"}, nil
+	}), "billing", "neuronetes/ledger-0.json", "us-west-2")
+	store.endpoint = server.URL + "/billing"
+
+	usage, err := store.Load(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, usage)
+
+	saved := []Usage{{Tenant: "tenant-1", Model: "llama-3-8b", InputTokens: 100, OutputTokens: 50}}
+	require.NoError(t, store.Save(ctx, saved))
+	assert.Contains(t, objects, "/billing/neuronetes/ledger-0.json")
+	usage, err = store.Load(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, saved, usage)
+}
+
+// fakeDB is an in-memory database/sql driver understanding the statements
+// of SQLStore, with rows keyed by ledger, tenant and model
+type fakeDB struct {
+	mu   sync.Mutex
+	rows map[string][]driver.Value
+}
+
+type fakeConnector struct{ db *fakeDB }
+
+func (c *fakeConnector) Connect(context.Context) (driver.Conn, error) {
+	return &fakeConn{db: c.db}, nil
+}
+func (c *fakeConnector) Driver() driver.Driver { return nil }
+
+type fakeConn struct{ db *fakeDB }
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{db: c.db, query: query}, nil
+}
+func (c *fakeConn) Close() error              { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error) { return fakeTx{}, nil }
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+type fakeStmt struct {
+	db    *fakeDB
+	query string
+}
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	switch {
+	case strings.HasPrefix(s.query, "CREATE TABLE"):
+	case strings.HasPrefix(s.query, "INSERT INTO token_ledger") && strings.Contains(s.query, "ON CONFLICT"):
+		s.db.rows[args[0].(string)+"/"+args[1].(string)+"/"+args[2].(string)] = args
+	default:
+		return nil, errors.New("unexpected statement: " + s.query)
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	if !strings.HasPrefix(s.query, "SELECT tenant, model, input_tokens, output_tokens FROM token_ledger WHERE ledger = $1") {
+		return nil, errors.New("unexpected query: " + s.query)
+	}
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	rows := &fakeRows{}
+	for _, row := range s.db.rows {
+		if row[0] == args[0] {
+			rows.values = append(rows.values, row[1:5])
+		}
+	}
+	return rows, nil
+}
+
+type fakeRows struct{ values [][]driver.Value }
+
+func (r *fakeRows) Columns() []string {
+	return []string{"tenant", "model", "input_tokens", "output_tokens"}
+}
+func (r *fakeRows) Close() error { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
+
+type priceFunc func(instance pricing.Instance) (float64, error)
+
+func (f priceFunc) GPUHourPrice(ctx context.Context, instance pricing.Instance) (float64, error) {
+	return f(instance)
+}
+
+// replica returns a running replica of pool holding gpus GPUs on node
+func replica(name, pool, node string, gpus int64) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "prod", Labels: map[string]string{neuronetes.LabelPool: pool}},
+		Spec: corev1.PodSpec{
+			NodeName: node,
+			Containers: []corev1.Container{{Name: "agent", Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{"nvidia.com/gpu": *resource.NewQuantity(gpus, resource.DecimalSI)},
+			}}},
+		},
+		Status: corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+}
+
+func TestCostEngineAttributesPoolCost(t *testing.T) {
+	ctx := context.Background()
+	node := func(name, instanceType string) *corev1.Node {
+		return &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{
+			corev1.LabelInstanceTypeStable: instanceType,
+		}}}
+	}
+	pending := replica("chat-2", "chat", "gpu-1", 1)
+	pending.Status.Phase = corev1.PodPending
+	objects := []client.Object{
+		node("gpu-1", "p4d.24xlarge"), node("gpu-2", "unknown"),
+		replica("chat-0", "chat", "gpu-1", 2), replica("chat-1", "chat", "gpu-2", 1), pending,
+		replica("batch-0", "batch", "gpu-1", 1),
+	}
+	registry := prometheus.NewRegistry()
+	m := metrics.NewAgentMetrics(registry)
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	engine := &CostEngine{
+		Client: fake.NewClientBuilder().WithObjects(objects...).Build(),
+		Pricing: priceFunc(func(instance pricing.Instance) (float64, error) {
+			if instance.Type == "p4d.24xlarge" {
+				return 4, nil
+			}
+			return 0, pricing.ErrNoPrice
+		}),
+		Metrics: m,
+		now:     func() time.Time { return now },
+	}
+
+	chat := types.NamespacedName{Namespace: "prod", Name: "chat"}
+	engine.RecordTokens(chat, "tenant-1", "s1", "llama-3-70b", 600, 150)
+	engine.RecordTokens(chat, "tenant-2", "s2", "llama-3-70b", 200, 50)
+	require.NoError(t, engine.Sample(ctx, 30*time.Minute))
+	engine.Settle(ctx)
+
+	// chat held 2 priced and 1 unpriced GPUs for half an hour: $4, split 3:1
+	report := engine.Report("")
+	require.Len(t, report.Pools, 2)
+	assert.Equal(t, PoolCost{Namespace: "prod", Pool: "batch", GPUHours: 0.5, CostUSD: 2, IdleCostUSD: 2}, report.Pools[0])
+	assert.Equal(t, PoolCost{Namespace: "prod", Pool: "chat", GPUHours: 1.5, CostUSD: 4}, report.Pools[1])
+	assert.Equal(t, []TenantCost{
+		{Tenant: "tenant-1", Model: "llama-3-70b", InputTokens: 600, OutputTokens: 150, CostUSD: 3, CostPer1KTokensUSD: 4},
+		{Tenant: "tenant-2", Model: "llama-3-70b", InputTokens: 200, OutputTokens: 50, CostUSD: 1, CostPer1KTokensUSD: 4},
+	}, report.Tenants)
+	assert.Equal(t, 3.0, testutil.ToFloat64(m.TenantCost.WithLabelValues("prod", "chat", "tenant-1")))
+	assert.Equal(t, 4.0, testutil.ToFloat64(m.CostPer1KTokens.WithLabelValues("llama-3-70b", "tenant-2")))
+	assert.Equal(t, 1.5, testutil.ToFloat64(m.PoolGPUHours.WithLabelValues("prod", "chat")))
+	assert.Equal(t, 2.0, testutil.ToFloat64(m.GPUHours))
+
+	// Ended and idle sessions are reported once their cost is attributed
+	require.Len(t, engine.Report("tenant-1").Sessions, 1)
+	engine.EndSession("tenant-1", "s1")
+	engine.Settle(ctx)
+	assert.Equal(t, []SessionCost{{Tenant: "tenant-2", Session: "s2", Tokens: 250, CostUSD: 1, LastActive: now}}, engine.Report("").Sessions)
+	now = now.Add(DefaultSessionTTL)
+	engine.Settle(ctx)
+	assert.Empty(t, engine.Report("").Sessions)
+	assert.Equal(t, 2, testutil.CollectAndCount(m.SessionCost))
+
+	server := httptest.NewServer(engine.Handler())
+	defer server.Close()
+	resp, err := http.Get(server.URL + CostPath + "?tenant=tenant-2")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	var decoded CostReport
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&decoded))
+	assert.Len(t, decoded.Pools, 2)
+	require.Len(t, decoded.Tenants, 1)
+	assert.Equal(t, 1.0, decoded.Tenants[0].CostUSD)
+}
+
+func TestCostEngineCountsClaimedGPUs(t *testing.T) {
+	ctx := context.Background()
+	// The GPUs of chat-0 are allocated through DRA rather than requested
+	claimed := replica("chat-0", "chat", "gpu-1", 0)
+	claimed.Annotations = map[string]string{neuronetes.AnnotationGPUClaims: "nvidia.com/gpu=2"}
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "gpu-1", Labels: map[string]string{
+		corev1.LabelInstanceTypeStable: "p4d.24xlarge",
+	}}}
+	m := metrics.NewAgentMetrics(prometheus.NewRegistry())
+	engine := &CostEngine{
+		Client:  fake.NewClientBuilder().WithObjects(node, claimed).Build(),
+		Pricing: priceFunc(func(pricing.Instance) (float64, error) { return 4, nil }),
+		Metrics: m,
+		now:     time.Now,
+	}
+
+	require.NoError(t, engine.Sample(ctx, time.Hour))
+	engine.Settle(ctx)
+	assert.Equal(t, []PoolCost{{Namespace: "prod", Pool: "chat", GPUHours: 2, CostUSD: 8, IdleCostUSD: 8}}, engine.Report("").Pools)
+}
@@ -0,0 +1,71 @@
+package accounting
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// configMapKey is the key of the ConfigMap holding the totals
+const configMapKey = "usage.json"
+
+// +kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;create;update
+
+// ConfigMapStore saves the totals of a ledger as JSON in a ConfigMap. It
+// suits small deployments: ConfigMaps are limited to 1MiB, about ten
+// thousand tenant and model pairs.
+type ConfigMapStore struct {
+	Client    client.Client
+	Namespace string
+	Name      string
+}
+
+// Load implements Store
+func (s *ConfigMapStore) Load(ctx context.Context) ([]Usage, error) {
+	var cm corev1.ConfigMap
+	if err := s.Client.Get(ctx, types.NamespacedName{Namespace: s.Namespace, Name: s.Name}, &cm); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	data, ok := cm.Data[configMapKey]
+	if !ok {
+		return nil, nil
+	}
+	var usage []Usage
+	if err := json.Unmarshal([]byte(data), &usage); err != nil {
+		return nil, fmt.Errorf("failed to parse %s of ConfigMap %s/%s: %w", configMapKey, s.Namespace, s.Name, err)
+	}
+	return usage, nil
+}
+
+// Save implements Store
+func (s *ConfigMapStore) Save(ctx context.Context, usage []Usage) error {
+	data, err := json.Marshal(usage)
+	if err != nil {
+		return err
+	}
+
+	var cm corev1.ConfigMap
+	err = s.Client.Get(ctx, types.NamespacedName{Namespace: s.Namespace, Name: s.Name}, &cm)
+	if apierrors.IsNotFound(err) {
+		cm.Namespace = s.Namespace
+		cm.Name = s.Name
+		cm.Data = map[string]string{configMapKey: string(data)}
+		return s.Client.Create(ctx, &cm)
+	}
+	if err != nil {
+		return err
+	}
+	if cm.Data == nil {
+		cm.Data = make(map[string]string)
+	}
+	cm.Data[configMapKey] = string(data)
+	return s.Client.Update(ctx, &cm)
+}
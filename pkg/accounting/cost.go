@@ -0,0 +1,407 @@
+package accounting
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/gpu"
+	"github.com/bowenislandsong/neuronetes/pkg/metrics"
+	"github.com/bowenislandsong/neuronetes/pkg/pricing"
+)
+
+const (
+	// DefaultCostInterval is how often the GPUs of pools are sampled and
+	// their cost attributed
+	DefaultCostInterval = time.Minute
+
+	// DefaultSessionTTL is how long a session without tokens is kept
+	// before it is reported as ended
+	DefaultSessionTTL = time.Hour
+)
+
+// PoolCost is the GPU cost of an AgentPool
+type PoolCost struct {
+	Namespace string  `json:"namespace"`
+	Pool      string  `json:"pool"`
+	GPUHours  float64 `json:"gpuHours"`
+	CostUSD   float64 `json:"costUSD"`
+
+	// IdleCostUSD is the part of CostUSD spent while the pool served no
+	// tokens, which is attributed to no tenant
+	IdleCostUSD float64 `json:"idleCostUSD"`
+}
+
+// TenantCost is the cost attributed to a tenant on a model
+type TenantCost struct {
+	Tenant             string  `json:"tenant"`
+	Model              string  `json:"model"`
+	InputTokens        int64   `json:"inputTokens"`
+	OutputTokens       int64   `json:"outputTokens"`
+	CostUSD            float64 `json:"costUSD"`
+	CostPer1KTokensUSD float64 `json:"costPer1KTokensUSD"`
+}
+
+// SessionCost is the cost attributed to a session of a tenant
+type SessionCost struct {
+	Tenant     string    `json:"tenant"`
+	Session    string    `json:"session"`
+	Tokens     int64     `json:"tokens"`
+	CostUSD    float64   `json:"costUSD"`
+	LastActive time.Time `json:"lastActive"`
+
+	ended bool
+}
+
+// CostReport is the response of the cost report API
+type CostReport struct {
+	Pools    []PoolCost    `json:"pools"`
+	Tenants  []TenantCost  `json:"tenants"`
+	Sessions []SessionCost `json:"sessions"`
+}
+
+// attribution is who tokens of a pool were served to
+type attribution struct {
+	tenant  string
+	session string
+	model   string
+}
+
+type tenantModel struct {
+	tenant string
+	model  string
+}
+
+type tenantSession struct {
+	tenant  string
+	session string
+}
+
+// poolAccount is the cost of a pool and what it accrued since the last
+// settlement
+type poolAccount struct {
+	cost          PoolCost
+	pendingCost   float64
+	pendingTokens map[attribution]int64
+}
+
+// +kubebuilder:rbac:groups=core,resources=pods;nodes,verbs=get;list;watch
+
+// CostEngine attributes the GPU cost of each AgentPool to the tenants and
+// sessions it served. Every interval it samples the GPUs held by the
+// running replicas of each pool, prices them with the provider of the node
+// they run on, and splits the cost of the interval over the tokens the pool
+// served in it. Intervals without tokens are idle cost of the pool.
+//
+// Tokens are recorded with RecordTokens by whoever serves the turns, so the
+// engine runs in the process seeing the traffic of the pools, e.g. the
+// gateway.
+type CostEngine struct {
+	client.Client
+	Pricing pricing.Provider
+
+	// Metrics exports the costs as labeled metrics. Optional.
+	Metrics *metrics.AgentMetrics
+
+	// Interval is how often the GPUs of pools are sampled
+	Interval time.Duration
+
+	// SessionTTL is how long a session without tokens is kept
+	SessionTTL time.Duration
+
+	now func() time.Time
+
+	mu       sync.Mutex
+	pools    map[types.NamespacedName]*poolAccount
+	tenants  map[tenantModel]*TenantCost
+	sessions map[tenantSession]*SessionCost
+}
+
+// init allocates the accounts of the engine. The caller must hold mu.
+func (e *CostEngine) init() {
+	if e.pools == nil {
+		e.pools = make(map[types.NamespacedName]*poolAccount)
+		e.tenants = make(map[tenantModel]*TenantCost)
+		e.sessions = make(map[tenantSession]*SessionCost)
+	}
+	if e.now == nil {
+		e.now = time.Now
+	}
+}
+
+// pool returns the account of pool. The caller must hold mu.
+func (e *CostEngine) pool(pool types.NamespacedName) *poolAccount {
+	account, ok := e.pools[pool]
+	if !ok {
+		account = &poolAccount{
+			cost:          PoolCost{Namespace: pool.Namespace, Pool: pool.Name},
+			pendingTokens: make(map[attribution]int64),
+		}
+		e.pools[pool] = account
+	}
+	return account
+}
+
+// RecordTokens records the tokens of a turn pool served to a session of
+// tenant on model. session may be empty for turns outside of sessions.
+func (e *CostEngine) RecordTokens(pool types.NamespacedName, tenant, session, model string, inputTokens, outputTokens int64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.init()
+
+	e.pool(pool).pendingTokens[attribution{tenant: tenant, session: session, model: model}] += inputTokens + outputTokens
+
+	key := tenantModel{tenant: tenant, model: model}
+	t, ok := e.tenants[key]
+	if !ok {
+		t = &TenantCost{Tenant: tenant, Model: model}
+		e.tenants[key] = t
+	}
+	t.InputTokens += inputTokens
+	t.OutputTokens += outputTokens
+
+	if session == "" {
+		return
+	}
+	s := e.session(tenant, session)
+	s.Tokens += inputTokens + outputTokens
+	s.LastActive = e.now()
+}
+
+// session returns the cost of a session. The caller must hold mu.
+func (e *CostEngine) session(tenant, session string) *SessionCost {
+	key := tenantSession{tenant: tenant, session: session}
+	s, ok := e.sessions[key]
+	if !ok {
+		s = &SessionCost{Tenant: tenant, Session: session, LastActive: e.now()}
+		e.sessions[key] = s
+	}
+	return s
+}
+
+// EndSession reports the cost of a session once the cost of its last
+// tokens is attributed
+func (e *CostEngine) EndSession(tenant, session string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if s, ok := e.sessions[tenantSession{tenant: tenant, session: session}]; ok {
+		s.ended = true
+	}
+}
+
+// RecordGPUTime records that pool held gpus GPUs priced pricePerGPUHour
+// for d
+func (e *CostEngine) RecordGPUTime(ctx context.Context, pool types.NamespacedName, gpus int64, pricePerGPUHour float64, d time.Duration) {
+	gpuHours := float64(gpus) * d.Hours()
+	cost := gpuHours * pricePerGPUHour
+
+	e.mu.Lock()
+	e.init()
+	account := e.pool(pool)
+	account.cost.GPUHours += gpuHours
+	account.cost.CostUSD += cost
+	account.pendingCost += cost
+	e.mu.Unlock()
+
+	if e.Metrics != nil {
+		e.Metrics.RecordPoolCost(ctx, pool.Namespace, pool.Name, gpuHours, cost)
+	}
+}
+
+// Sample records the GPU time of the running replicas of each pool over
+// the last d. GPUs without a price are counted at no cost.
+func (e *CostEngine) Sample(ctx context.Context, d time.Duration) error {
+	var pods corev1.PodList
+	if err := e.List(ctx, &pods, client.HasLabels{neuronetes.LabelPool}); err != nil {
+		return fmt.Errorf("failed to list replicas: %w", err)
+	}
+
+	var errs []error
+	prices := map[string]float64{}
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		gpus := gpu.PodGPUs(pod)
+		if gpus == 0 || pod.Spec.NodeName == "" || pod.Status.Phase != corev1.PodRunning {
+			continue
+		}
+		price, ok := prices[pod.Spec.NodeName]
+		if !ok {
+			var err error
+			price, err = e.nodePrice(ctx, pod.Spec.NodeName)
+			if err != nil {
+				errs = append(errs, err)
+			}
+			prices[pod.Spec.NodeName] = price
+		}
+		pool := types.NamespacedName{Namespace: pod.Namespace, Name: pod.Labels[neuronetes.LabelPool]}
+		e.RecordGPUTime(ctx, pool, gpus, price, d)
+	}
+	return errors.Join(errs...)
+}
+
+// nodePrice returns the GPU hour price of the node called name, or zero if
+// its provider has no price
+func (e *CostEngine) nodePrice(ctx context.Context, name string) (float64, error) {
+	if e.Pricing == nil {
+		return 0, nil
+	}
+	var node corev1.Node
+	if err := e.Get(ctx, types.NamespacedName{Name: name}, &node); err != nil {
+		return 0, fmt.Errorf("failed to get node %s: %w", name, err)
+	}
+	price, err := e.Pricing.GPUHourPrice(ctx, pricing.InstanceOf(&node))
+	if errors.Is(err, pricing.ErrNoPrice) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to price node %s: %w", name, err)
+	}
+	return price, nil
+}
+
+// Settle splits the cost each pool accrued since the last settlement over
+// the tokens it served in proportion, then reports the cost of sessions
+// that ended or expired
+func (e *CostEngine) Settle(ctx context.Context) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.init()
+
+	touched := map[tenantModel]bool{}
+	for name, account := range e.pools {
+		var total int64
+		for _, tokens := range account.pendingTokens {
+			total += tokens
+		}
+		switch {
+		case account.pendingCost == 0:
+		case total == 0:
+			account.cost.IdleCostUSD += account.pendingCost
+		default:
+			byTenant := map[string]float64{}
+			for a, tokens := range account.pendingTokens {
+				share := account.pendingCost * float64(tokens) / float64(total)
+				key := tenantModel{tenant: a.tenant, model: a.model}
+				e.tenants[key].CostUSD += share
+				touched[key] = true
+				byTenant[a.tenant] += share
+				if a.session != "" {
+					e.session(a.tenant, a.session).CostUSD += share
+				}
+			}
+			if e.Metrics != nil {
+				for tenant, cost := range byTenant {
+					e.Metrics.RecordTenantCost(ctx, name.Namespace, name.Name, tenant, cost)
+				}
+			}
+		}
+		account.pendingCost = 0
+		account.pendingTokens = make(map[attribution]int64)
+	}
+
+	for key := range touched {
+		t := e.tenants[key]
+		if tokens := t.InputTokens + t.OutputTokens; tokens > 0 {
+			t.CostPer1KTokensUSD = t.CostUSD / float64(tokens) * 1000
+		}
+		if e.Metrics != nil {
+			e.Metrics.SetCostPer1KTokens(t.Model, t.Tenant, t.CostPer1KTokensUSD)
+		}
+	}
+
+	ttl := e.SessionTTL
+	if ttl <= 0 {
+		ttl = DefaultSessionTTL
+	}
+	for key, s := range e.sessions {
+		if !s.ended && e.now().Sub(s.LastActive) < ttl {
+			continue
+		}
+		if e.Metrics != nil {
+			e.Metrics.RecordSessionCost(ctx, s.Tenant, s.CostUSD)
+		}
+		delete(e.sessions, key)
+	}
+}
+
+// Report returns the costs of all pools and of the tenants and active
+// sessions matching tenant, or of all of them if tenant is empty
+func (e *CostEngine) Report(tenant string) CostReport {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	report := CostReport{Pools: []PoolCost{}, Tenants: []TenantCost{}, Sessions: []SessionCost{}}
+	for _, account := range e.pools {
+		report.Pools = append(report.Pools, account.cost)
+	}
+	for _, t := range e.tenants {
+		if tenant == "" || t.Tenant == tenant {
+			report.Tenants = append(report.Tenants, *t)
+		}
+	}
+	for _, s := range e.sessions {
+		if tenant == "" || s.Tenant == tenant {
+			report.Sessions = append(report.Sessions, *s)
+		}
+	}
+
+	sort.Slice(report.Pools, func(i, j int) bool {
+		if report.Pools[i].Namespace != report.Pools[j].Namespace {
+			return report.Pools[i].Namespace < report.Pools[j].Namespace
+		}
+		return report.Pools[i].Pool < report.Pools[j].Pool
+	})
+	sort.Slice(report.Tenants, func(i, j int) bool {
+		if report.Tenants[i].Tenant != report.Tenants[j].Tenant {
+			return report.Tenants[i].Tenant < report.Tenants[j].Tenant
+		}
+		return report.Tenants[i].Model < report.Tenants[j].Model
+	})
+	sort.Slice(report.Sessions, func(i, j int) bool {
+		if report.Sessions[i].Tenant != report.Sessions[j].Tenant {
+			return report.Sessions[i].Tenant < report.Sessions[j].Tenant
+		}
+		return report.Sessions[i].Session < report.Sessions[j].Session
+	})
+	return report
+}
+
+// SetupWithManager runs the engine while the manager is leader
+func (e *CostEngine) SetupWithManager(mgr ctrl.Manager) error {
+	return mgr.Add(e)
+}
+
+// Start samples and settles the cost of pools every interval until ctx is
+// done
+func (e *CostEngine) Start(ctx context.Context) error {
+	logger := log.FromContext(ctx).WithName("cost-engine")
+	interval := e.Interval
+	if interval <= 0 {
+		interval = DefaultCostInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	last := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case now := <-ticker.C:
+			if err := e.Sample(ctx, now.Sub(last)); err != nil {
+				logger.Error(err, "failed to sample the GPUs of pools")
+			}
+			last = now
+			e.Settle(ctx)
+		}
+	}
+}
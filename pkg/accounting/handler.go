@@ -0,0 +1,74 @@
+package accounting
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// UsagePath is the path of the usage query API
+const UsagePath = "/v1/usage"
+
+// UsageReport is the response of the usage query API
+type UsageReport struct {
+	// Usage are the totals of each tenant and model matching the query
+	Usage []Usage `json:"usage"`
+
+	// InputTokens and OutputTokens sum Usage
+	InputTokens  int64 `json:"inputTokens"`
+	OutputTokens int64 `json:"outputTokens"`
+
+	// CheckpointedAt is when the totals were last saved. Tokens recorded
+	// since are lost if the process stops before the next checkpoint.
+	CheckpointedAt *time.Time `json:"checkpointedAt,omitempty"`
+}
+
+// Handler returns the usage query API of the ledger for billing. GET
+// /v1/usage returns the totals of all tenants and models as a UsageReport;
+// the tenant and model query parameters narrow them down. Totals are
+// cumulative, so billing periods are the difference between two reports.
+func (l *Ledger) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(UsagePath, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		query := r.URL.Query()
+		report := UsageReport{Usage: l.Usage(query.Get("tenant"), query.Get("model"))}
+		for _, u := range report.Usage {
+			report.InputTokens += u.InputTokens
+			report.OutputTokens += u.OutputTokens
+		}
+		if at := l.CheckpointedAt(); !at.IsZero() {
+			report.CheckpointedAt = &at
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(report)
+	})
+	return mux
+}
+
+// CostPath is the path of the cost report API
+const CostPath = "/v1/costs"
+
+// Handler returns the cost report API of the engine. GET /v1/costs returns
+// the costs of all pools, tenants and active sessions as a CostReport; the
+// tenant query parameter narrows the tenants and sessions down.
+func (e *CostEngine) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(CostPath, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(e.Report(r.URL.Query().Get("tenant")))
+	})
+	return mux
+}
@@ -0,0 +1,228 @@
+// Package accounting keeps the token totals of each tenant and model beyond
+// the lifetime of a process, for chargeback. Prometheus counters reset when
+// a process restarts; a Ledger restores its totals from a Store on start and
+// checkpoints them back periodically.
+package accounting
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// DefaultCheckpointInterval is how often a ledger saves its totals
+const DefaultCheckpointInterval = time.Minute
+
+// ErrNotRestored is returned when checkpointing a ledger that has not
+// restored its totals yet, which would overwrite the stored ones
+var ErrNotRestored = errors.New("ledger not restored")
+
+// Usage is the token total of a tenant on a model
+type Usage struct {
+	Tenant       string `json:"tenant"`
+	Model        string `json:"model"`
+	InputTokens  int64  `json:"inputTokens"`
+	OutputTokens int64  `json:"outputTokens"`
+}
+
+// TotalTokens returns the input and output tokens of u
+func (u Usage) TotalTokens() int64 {
+	return u.InputTokens + u.OutputTokens
+}
+
+// Store persists the totals of a ledger. Each ledger owns its record in a
+// store; ledgers of different processes must use different records.
+type Store interface {
+	// Load returns the saved totals, or none if nothing was saved yet
+	Load(ctx context.Context) ([]Usage, error)
+
+	// Save replaces the saved totals
+	Save(ctx context.Context, usage []Usage) error
+}
+
+type usageKey struct {
+	tenant string
+	model  string
+}
+
+// Ledger accumulates the tokens of each tenant and model and checkpoints
+// the totals to a Store
+type Ledger struct {
+	store    Store
+	interval time.Duration
+	now      func() time.Time
+
+	mu    sync.Mutex
+	usage map[usageKey]*Usage
+
+	// restored is set once the stored totals were added to usage
+	restored bool
+
+	// changes counts the records since start, saved the count at the last
+	// checkpoint
+	changes int64
+	saved   int64
+
+	checkpointedAt time.Time
+}
+
+// NewLedger creates a ledger checkpointing to store every interval, or
+// every DefaultCheckpointInterval if interval is zero
+func NewLedger(store Store, interval time.Duration) *Ledger {
+	if interval <= 0 {
+		interval = DefaultCheckpointInterval
+	}
+	return &Ledger{
+		store:    store,
+		interval: interval,
+		now:      time.Now,
+		usage:    make(map[usageKey]*Usage),
+	}
+}
+
+// Record adds the tokens of a turn of tenant on model
+func (l *Ledger) Record(tenant, model string, inputTokens, outputTokens int64) {
+	if inputTokens == 0 && outputTokens == 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	key := usageKey{tenant: tenant, model: model}
+	u, ok := l.usage[key]
+	if !ok {
+		u = &Usage{Tenant: tenant, Model: model}
+		l.usage[key] = u
+	}
+	u.InputTokens += inputTokens
+	u.OutputTokens += outputTokens
+	l.changes++
+}
+
+// Restore adds the stored totals to the tokens recorded so far. Tokens can
+// be recorded before the ledger is restored, but it is only checkpointed
+// after.
+func (l *Ledger) Restore(ctx context.Context) error {
+	stored, err := l.store.Load(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load token totals: %w", err)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.restored {
+		return nil
+	}
+	for _, s := range stored {
+		key := usageKey{tenant: s.Tenant, model: s.Model}
+		u, ok := l.usage[key]
+		if !ok {
+			u = &Usage{Tenant: s.Tenant, Model: s.Model}
+			l.usage[key] = u
+		}
+		u.InputTokens += s.InputTokens
+		u.OutputTokens += s.OutputTokens
+	}
+	l.restored = true
+	return nil
+}
+
+// Checkpoint saves the totals, unless nothing was recorded since the last
+// checkpoint
+func (l *Ledger) Checkpoint(ctx context.Context) error {
+	l.mu.Lock()
+	if !l.restored {
+		l.mu.Unlock()
+		return ErrNotRestored
+	}
+	if l.changes == l.saved {
+		l.mu.Unlock()
+		return nil
+	}
+	changes := l.changes
+	usage := l.snapshot("", "")
+	l.mu.Unlock()
+
+	if err := l.store.Save(ctx, usage); err != nil {
+		return fmt.Errorf("failed to save token totals: %w", err)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if changes > l.saved {
+		l.saved = changes
+		l.checkpointedAt = l.now()
+	}
+	return nil
+}
+
+// Usage returns the totals of tenant on model, sorted by tenant and model.
+// Empty tenant or model match all.
+func (l *Ledger) Usage(tenant, model string) []Usage {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.snapshot(tenant, model)
+}
+
+// CheckpointedAt returns when the totals were last saved, or the zero time
+// if they never were
+func (l *Ledger) CheckpointedAt() time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.checkpointedAt
+}
+
+// snapshot copies the totals matching tenant and model. The caller must
+// hold mu.
+func (l *Ledger) snapshot(tenant, model string) []Usage {
+	usage := make([]Usage, 0, len(l.usage))
+	for key, u := range l.usage {
+		if (tenant != "" && key.tenant != tenant) || (model != "" && key.model != model) {
+			continue
+		}
+		usage = append(usage, *u)
+	}
+	sort.Slice(usage, func(i, j int) bool {
+		if usage[i].Tenant != usage[j].Tenant {
+			return usage[i].Tenant < usage[j].Tenant
+		}
+		return usage[i].Model < usage[j].Model
+	})
+	return usage
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable. Every
+// process keeps its own ledger.
+func (l *Ledger) NeedLeaderElection() bool {
+	return false
+}
+
+// Start restores the totals and checkpoints them every interval until ctx
+// is cancelled, then checkpoints them one last time
+func (l *Ledger) Start(ctx context.Context) error {
+	log := log.FromContext(ctx).WithName("ledger")
+
+	if err := l.Restore(ctx); err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(l.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			return l.Checkpoint(shutdownCtx)
+		case <-ticker.C:
+			if err := l.Checkpoint(ctx); err != nil {
+				log.Error(err, "failed to checkpoint token totals")
+			}
+		}
+	}
+}
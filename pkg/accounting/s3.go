@@ -0,0 +1,137 @@
+package accounting
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/config"
+)
+
+// S3Store saves the totals of a ledger as a JSON object in an S3 bucket. It
+// needs the s3:GetObject and s3:PutObject permissions on the object.
+type S3Store struct {
+	bucket string
+	key    string
+	region string
+
+	credentials aws.CredentialsProvider
+	client      *http.Client
+	signer      *v4.Signer
+	now         func() time.Time
+
+	// endpoint is the URL of the bucket, overridden by tests
+	endpoint string
+}
+
+// NewS3Store creates a store saving to key in bucket with the default
+// credentials of the AWS SDK, such as those of the service account's IAM
+// role. region defaults to the region of the SDK configuration.
+func NewS3Store(ctx context.Context, bucket, key, region string) (*S3Store, error) {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS credentials: %w", err)
+	}
+	if region == "" {
+		region = cfg.Region
+	}
+	if region == "" {
+		return nil, fmt.Errorf("no AWS region configured for bucket %s", bucket)
+	}
+	return newS3Store(cfg.Credentials, bucket, key, region), nil
+}
+
+func newS3Store(credentials aws.CredentialsProvider, bucket, key, region string) *S3Store {
+	return &S3Store{
+		bucket:      bucket,
+		key:         key,
+		region:      region,
+		credentials: credentials,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		// S3 signs paths as they are sent
+		signer:   v4.NewSigner(func(o *v4.SignerOptions) { o.DisableURIPathEscaping = true }),
+		now:      time.Now,
+		endpoint: "https://" + bucket + ".s3." + region + ".amazonaws.com",
+	}
+}
+
+// Load implements Store
+func (s *S3Store) Load(ctx context.Context) ([]Usage, error) {
+	status, data, err := s.do(ctx, http.MethodGet, nil)
+	if err != nil {
+		return nil, err
+	}
+	if status == http.StatusNotFound {
+		return nil, nil
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("failed to get s3://%s/%s: unexpected status %d: %s", s.bucket, s.key, status, bytes.TrimSpace(data))
+	}
+	var usage []Usage
+	if err := json.Unmarshal(data, &usage); err != nil {
+		return nil, fmt.Errorf("failed to parse s3://%s/%s: %w", s.bucket, s.key, err)
+	}
+	return usage, nil
+}
+
+// Save implements Store
+func (s *S3Store) Save(ctx context.Context, usage []Usage) error {
+	body, err := json.Marshal(usage)
+	if err != nil {
+		return err
+	}
+	status, data, err := s.do(ctx, http.MethodPut, body)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("failed to put s3://%s/%s: unexpected status %d: %s", s.bucket, s.key, status, bytes.TrimSpace(data))
+	}
+	return nil
+}
+
+// do sends a request for the object signed with the store's credentials and
+// returns the response status and body
+func (s *S3Store) do(ctx context.Context, method string, body []byte) (int, []byte, error) {
+	credentials, err := s.credentials.Retrieve(ctx)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to retrieve AWS credentials: %w", err)
+	}
+	endpoint, err := url.JoinPath(s.endpoint, s.key)
+	if err != nil {
+		return 0, nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return 0, nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	hash := sha256.Sum256(body)
+	payloadHash := hex.EncodeToString(hash[:])
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if err := s.signer.SignHTTP(ctx, credentials, req, payloadHash, "s3", s.region, s.now()); err != nil {
+		return 0, nil, err
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, err
+	}
+	return resp.StatusCode, data, nil
+}
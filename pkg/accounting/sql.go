@@ -0,0 +1,111 @@
+package accounting
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+)
+
+// DefaultSQLTable is the table SQLStore keeps totals in
+const DefaultSQLTable = "token_ledger"
+
+// sqlIdentifier matches the table names SQLStore accepts, since they are
+// interpolated into statements
+var sqlIdentifier = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*(\.[a-zA-Z_][a-zA-Z0-9_]*)?$`)
+
+// SQLStore saves the totals of a ledger as rows of a PostgreSQL table,
+// keyed by the ledger name so that the ledgers of several processes can
+// share the table and billing can sum them with SQL. The caller opens DB
+// with the driver of its choice, e.g. pgx's stdlib.
+type SQLStore struct {
+	DB *sql.DB
+
+	// Ledger names the rows of this ledger, e.g. the pod name
+	Ledger string
+
+	// Table defaults to DefaultSQLTable
+	Table string
+}
+
+func (s *SQLStore) table() (string, error) {
+	if s.Table == "" {
+		return DefaultSQLTable, nil
+	}
+	if !sqlIdentifier.MatchString(s.Table) {
+		return "", fmt.Errorf("invalid table name %q", s.Table)
+	}
+	return s.Table, nil
+}
+
+// CreateTable creates the table of the store if it does not exist
+func (s *SQLStore) CreateTable(ctx context.Context) error {
+	table, err := s.table()
+	if err != nil {
+		return err
+	}
+	_, err = s.DB.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+table+` (
+	ledger text NOT NULL,
+	tenant text NOT NULL,
+	model text NOT NULL,
+	input_tokens bigint NOT NULL,
+	output_tokens bigint NOT NULL,
+	updated_at timestamptz NOT NULL DEFAULT now(),
+	PRIMARY KEY (ledger, tenant, model)
+)`)
+	return err
+}
+
+// Load implements Store
+func (s *SQLStore) Load(ctx context.Context) ([]Usage, error) {
+	table, err := s.table()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.DB.QueryContext(ctx,
+		`SELECT tenant, model, input_tokens, output_tokens FROM `+table+` WHERE ledger = $1`, s.Ledger)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var usage []Usage
+	for rows.Next() {
+		var u Usage
+		if err := rows.Scan(&u.Tenant, &u.Model, &u.InputTokens, &u.OutputTokens); err != nil {
+			return nil, err
+		}
+		usage = append(usage, u)
+	}
+	return usage, rows.Err()
+}
+
+// Save implements Store. Totals only grow, so saving upserts the rows of
+// the ledger in one transaction.
+func (s *SQLStore) Save(ctx context.Context, usage []Usage) error {
+	table, err := s.table()
+	if err != nil {
+		return err
+	}
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	stmt, err := tx.PrepareContext(ctx, `INSERT INTO `+table+` (ledger, tenant, model, input_tokens, output_tokens, updated_at)
+VALUES ($1, $2, $3, $4, $5, now())
+ON CONFLICT (ledger, tenant, model) DO UPDATE
+SET input_tokens = EXCLUDED.input_tokens, output_tokens = EXCLUDED.output_tokens, updated_at = EXCLUDED.updated_at`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, u := range usage {
+		if _, err := stmt.ExecContext(ctx, s.Ledger, u.Tenant, u.Model, u.InputTokens, u.OutputTokens); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
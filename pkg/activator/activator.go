@@ -0,0 +1,153 @@
+// Package activator holds requests for AgentPools that are scaled to zero
+// until the autoscaler has brought up a replica to serve them.
+package activator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/metrics"
+	"github.com/bowenislandsong/neuronetes/pkg/router"
+	"github.com/bowenislandsong/neuronetes/pkg/tracing"
+)
+
+const (
+	// DefaultTimeout is how long a request is held waiting for activation
+	DefaultTimeout = 2 * time.Minute
+
+	// DefaultPollInterval is how often a held request checks for a replica
+	DefaultPollInterval = 100 * time.Millisecond
+
+	// DefaultRefreshInterval is how often the activation request of a pool
+	// is renewed while requests are waiting
+	DefaultRefreshInterval = 30 * time.Second
+)
+
+// ErrActivationTimeout is returned when no replica became ready in time
+var ErrActivationTimeout = errors.New("timed out waiting for pool activation")
+
+// Activator routes requests through a pool's router, holding them while the
+// pool has no ready replicas. Holding a request marks the pool with the
+// activation-requested annotation, which the autoscaler turns into a
+// scale-up from zero.
+type Activator struct {
+	client  client.Client
+	metrics *metrics.AgentMetrics
+
+	// Timeout bounds how long a request is held
+	Timeout time.Duration
+
+	// PollInterval is how often a held request checks for a replica
+	PollInterval time.Duration
+
+	// RefreshInterval limits how often a pool's activation request is renewed
+	RefreshInterval time.Duration
+
+	mu        sync.Mutex
+	requested map[types.NamespacedName]time.Time
+	now       func() time.Time
+}
+
+// NewActivator creates an activator. m may be nil.
+func NewActivator(c client.Client, m *metrics.AgentMetrics) *Activator {
+	return &Activator{
+		client:          c,
+		metrics:         m,
+		Timeout:         DefaultTimeout,
+		PollInterval:    DefaultPollInterval,
+		RefreshInterval: DefaultRefreshInterval,
+		requested:       make(map[types.NamespacedName]time.Time),
+		now:             time.Now,
+	}
+}
+
+// Route returns the replica for a request to pool. If the pool has no ready
+// replicas, the request is held until one becomes ready, the timeout
+// expires or ctx is cancelled. Held requests are traced as a child span of
+// the request's span in ctx.
+func (a *Activator) Route(ctx context.Context, pool *neuronetes.AgentPool, r *router.Router, sessionKey string) (rep *router.Replica, err error) {
+	if _, ready := r.Backpressure(); ready > 0 {
+		rep, err := r.Route(sessionKey)
+		if err == nil {
+			a.record(ctx, false, 0)
+		}
+		return rep, err
+	}
+
+	ctx, span := tracing.StartActivation(ctx, pool.Namespace, pool.Name)
+	defer func() { tracing.End(span, err) }()
+
+	start := a.now()
+	ctx, cancel := context.WithTimeout(ctx, a.Timeout)
+	defer cancel()
+
+	ticker := time.NewTicker(a.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := a.requestActivation(ctx, pool); err != nil {
+			return nil, err
+		}
+
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return nil, fmt.Errorf("%w: %s/%s", ErrActivationTimeout, pool.Namespace, pool.Name)
+			}
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+
+		if _, ready := r.Backpressure(); ready == 0 {
+			continue
+		}
+		rep, err := r.Route(sessionKey)
+		if err != nil {
+			return nil, err
+		}
+		a.record(ctx, true, a.now().Sub(start))
+		return rep, nil
+	}
+}
+
+// requestActivation marks pool as having waiting requests, at most once per
+// RefreshInterval
+func (a *Activator) requestActivation(ctx context.Context, pool *neuronetes.AgentPool) error {
+	key := types.NamespacedName{Namespace: pool.Namespace, Name: pool.Name}
+	now := a.now()
+
+	a.mu.Lock()
+	last, ok := a.requested[key]
+	if ok && now.Sub(last) < a.RefreshInterval {
+		a.mu.Unlock()
+		return nil
+	}
+	a.requested[key] = now
+	a.mu.Unlock()
+
+	patch := fmt.Sprintf(`{"metadata":{"annotations":{%q:%q}}}`,
+		neuronetes.AnnotationActivationRequested, now.UTC().Format(time.RFC3339))
+	target := &neuronetes.AgentPool{}
+	target.Namespace = pool.Namespace
+	target.Name = pool.Name
+	if err := a.client.Patch(ctx, target, client.RawPatch(types.MergePatchType, []byte(patch))); err != nil {
+		a.mu.Lock()
+		delete(a.requested, key)
+		a.mu.Unlock()
+		return fmt.Errorf("failed to request activation of %s: %w", key, err)
+	}
+	return nil
+}
+
+func (a *Activator) record(ctx context.Context, cold bool, wait time.Duration) {
+	if a.metrics != nil {
+		a.metrics.RecordActivation(ctx, cold, wait)
+	}
+}
@@ -0,0 +1,126 @@
+package activator
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/metrics"
+	"github.com/bowenislandsong/neuronetes/pkg/router"
+)
+
+func newTestActivator(t *testing.T) (*Activator, *neuronetes.AgentPool, *metrics.AgentMetrics) {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	require.NoError(t, neuronetes.AddToScheme(scheme))
+
+	pool := &neuronetes.AgentPool{
+		ObjectMeta: metav1.ObjectMeta{Name: "chat-pool", Namespace: "default"},
+		Spec:       neuronetes.AgentPoolSpec{MinReplicas: 0, MaxReplicas: 3},
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(pool).Build()
+	m := metrics.NewAgentMetrics(prometheus.NewRegistry())
+
+	a := NewActivator(c, m)
+	a.PollInterval = time.Millisecond
+	return a, pool, m
+}
+
+func TestActivatorRoutesWarmPoolDirectly(t *testing.T) {
+	a, pool, m := newTestActivator(t)
+	r := router.NewRouter(nil)
+	r.UpdateReplica(router.Replica{Name: "agent-a", Ready: true})
+
+	rep, err := a.Route(context.Background(), pool, r, "")
+	require.NoError(t, err)
+	assert.Equal(t, "agent-a", rep.Name)
+	assert.Equal(t, 0.0, testutil.ToFloat64(m.ColdStartRate))
+
+	var got neuronetes.AgentPool
+	require.NoError(t, a.client.Get(context.Background(), client.ObjectKeyFromObject(pool), &got))
+	assert.NotContains(t, got.Annotations, neuronetes.AnnotationActivationRequested)
+}
+
+func TestActivatorHoldsRequestUntilActivated(t *testing.T) {
+	a, pool, m := newTestActivator(t)
+	r := router.NewRouter(nil)
+
+	type result struct {
+		rep *router.Replica
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		rep, err := a.Route(context.Background(), pool, r, "session-1")
+		done <- result{rep, err}
+	}()
+
+	// The held request asks the autoscaler for a replica
+	require.Eventually(t, func() bool {
+		var got neuronetes.AgentPool
+		if err := a.client.Get(context.Background(), client.ObjectKeyFromObject(pool), &got); err != nil {
+			return false
+		}
+		_, ok := got.Annotations[neuronetes.AnnotationActivationRequested]
+		return ok
+	}, time.Second, time.Millisecond)
+
+	select {
+	case <-done:
+		t.Fatal("request was not held")
+	default:
+	}
+
+	r.UpdateReplica(router.Replica{Name: "agent-a", Ready: true})
+	res := <-done
+	require.NoError(t, res.err)
+	assert.Equal(t, "agent-a", res.rep.Name)
+	assert.Equal(t, 1, r.ActiveSessions()["agent-a"])
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.ColdStartRate))
+	assert.Equal(t, 1, testutil.CollectAndCount(m.ColdStartLatency))
+}
+
+func TestActivatorTimesOut(t *testing.T) {
+	a, pool, _ := newTestActivator(t)
+	a.Timeout = 20 * time.Millisecond
+
+	_, err := a.Route(context.Background(), pool, router.NewRouter(nil), "")
+	assert.ErrorIs(t, err, ErrActivationTimeout)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = a.Route(ctx, pool, router.NewRouter(nil), "")
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestActivatorRateLimitsActivationRequests(t *testing.T) {
+	a, pool, _ := newTestActivator(t)
+	now := time.Unix(1700000000, 0)
+	a.now = func() time.Time { return now }
+
+	require.NoError(t, a.requestActivation(context.Background(), pool))
+	var first neuronetes.AgentPool
+	require.NoError(t, a.client.Get(context.Background(), client.ObjectKeyFromObject(pool), &first))
+
+	now = now.Add(time.Second)
+	require.NoError(t, a.requestActivation(context.Background(), pool))
+	var second neuronetes.AgentPool
+	require.NoError(t, a.client.Get(context.Background(), client.ObjectKeyFromObject(pool), &second))
+	assert.Equal(t, first.ResourceVersion, second.ResourceVersion)
+
+	now = now.Add(DefaultRefreshInterval)
+	require.NoError(t, a.requestActivation(context.Background(), pool))
+	var third neuronetes.AgentPool
+	require.NoError(t, a.client.Get(context.Background(), client.ObjectKeyFromObject(pool), &third))
+	assert.Equal(t, now.UTC().Format(time.RFC3339), third.Annotations[neuronetes.AnnotationActivationRequested])
+}
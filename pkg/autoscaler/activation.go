@@ -0,0 +1,34 @@
+package autoscaler
+
+import (
+	"time"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+)
+
+// ActivationWindow is how long an activation request keeps a pool from
+// scaling back to zero
+const ActivationWindow = 5 * time.Minute
+
+// activationPending reports whether requests were recently held waiting for
+// pool to activate
+func activationPending(pool *neuronetes.AgentPool, now time.Time) bool {
+	value, ok := pool.Annotations[neuronetes.AnnotationActivationRequested]
+	if !ok {
+		return false
+	}
+	requested, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return false
+	}
+	return now.Sub(requested) < ActivationWindow
+}
+
+// activationReplicas returns the replica count that activates a pool from
+// zero within bounds
+func activationReplicas(bounds ReplicaBounds) int32 {
+	if bounds.Min > 1 {
+		return bounds.Min
+	}
+	return 1
+}
@@ -0,0 +1,124 @@
+package autoscaler
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+)
+
+// metricRatio is the ratio of one metric's value to its target
+type metricRatio struct {
+	metricType string
+	ratio      float64
+	weight     float64
+
+	// tolerance is the distance from 1 within which the ratio does not
+	// scale the pool
+	tolerance float64
+}
+
+// aggregateRatios combines per-metric ratios into the ratio that drives
+// scaling, using the given aggregation mode. It also returns a description
+// of what determined the ratio, for the decision reason.
+func aggregateRatios(mode string, ratios []metricRatio) (float64, string, error) {
+	if len(ratios) == 0 {
+		return 0, "", nil
+	}
+
+	switch mode {
+	case "", neuronetes.AggregationMax:
+		return maxRatio(ratios)
+
+	case neuronetes.AggregationAverage:
+		var sum float64
+		for _, r := range ratios {
+			sum += r.ratio
+		}
+		return sum / float64(len(ratios)), "average of " + metricTypes(ratios), nil
+
+	case neuronetes.AggregationWeighted:
+		var sum, weights float64
+		for _, r := range ratios {
+			sum += r.ratio * r.weight
+			weights += r.weight
+		}
+		if weights == 0 {
+			return 0, "", fmt.Errorf("metric weights sum to zero")
+		}
+		return sum / weights, "weighted average of " + metricTypes(ratios), nil
+
+	case neuronetes.AggregationAllMustExceed:
+		// Scale up by the smallest overload every metric agrees on;
+		// otherwise only scale down, as far as the busiest metric allows
+		lowest := ratios[0]
+		for _, r := range ratios[1:] {
+			if r.ratio < lowest.ratio {
+				lowest = r
+			}
+		}
+		if lowest.ratio > 1 {
+			return lowest.ratio, lowest.metricType, nil
+		}
+		ratio, metricType, _ := maxRatio(ratios)
+		if ratio > 1 {
+			ratio = 1
+		}
+		return ratio, metricType, nil
+
+	default:
+		return 0, "", fmt.Errorf("unknown metric aggregation %q", mode)
+	}
+}
+
+func maxRatio(ratios []metricRatio) (float64, string, error) {
+	var ratio float64
+	var metricType string
+	for _, r := range ratios {
+		if r.ratio > ratio {
+			ratio = r.ratio
+			metricType = r.metricType
+		}
+	}
+	return ratio, metricType, nil
+}
+
+func metricTypes(ratios []metricRatio) string {
+	types := make([]string, len(ratios))
+	for i, r := range ratios {
+		types[i] = r.metricType
+	}
+	return strings.Join(types, ",")
+}
+
+// applyTolerance returns ratios with those within their tolerance of the
+// target set to exactly 1, so that small fluctuations around the target
+// leave the pool as it is
+func applyTolerance(ratios []metricRatio) []metricRatio {
+	banded := make([]metricRatio, len(ratios))
+	for i, r := range ratios {
+		if math.Abs(r.ratio-1) <= r.tolerance {
+			r.ratio = 1
+		}
+		banded[i] = r
+	}
+	return banded
+}
+
+// metricTolerance returns the tolerance band of metric as a fraction of its
+// target
+func metricTolerance(metric *neuronetes.AutoscalingMetric) float64 {
+	if metric.TolerancePercent == nil {
+		return 0
+	}
+	return float64(*metric.TolerancePercent) / 100
+}
+
+// metricWeight returns the weight of metric under weighted aggregation
+func metricWeight(metric *neuronetes.AutoscalingMetric) float64 {
+	if metric.Weight == nil {
+		return 1
+	}
+	return float64(*metric.Weight)
+}
@@ -0,0 +1,129 @@
+package autoscaler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+)
+
+func TestAggregateRatios(t *testing.T) {
+	ratios := []metricRatio{
+		{metricType: "tokens-in-queue", ratio: 3.0, weight: 1},
+		{metricType: "ttft-p95", ratio: 0.5, weight: 3},
+		{metricType: "concurrent-sessions", ratio: 1.0, weight: 1},
+	}
+
+	tests := []struct {
+		mode   string
+		ratio  float64
+		metric string
+	}{
+		{mode: "", ratio: 3.0, metric: "tokens-in-queue"},
+		{mode: neuronetes.AggregationMax, ratio: 3.0, metric: "tokens-in-queue"},
+		{mode: neuronetes.AggregationAverage, ratio: 1.5, metric: "average of tokens-in-queue,ttft-p95,concurrent-sessions"},
+		{mode: neuronetes.AggregationWeighted, ratio: 1.1, metric: "weighted average of tokens-in-queue,ttft-p95,concurrent-sessions"},
+		{mode: neuronetes.AggregationAllMustExceed, ratio: 1.0, metric: "tokens-in-queue"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			ratio, metric, err := aggregateRatios(tt.mode, ratios)
+			require.NoError(t, err)
+			assert.InDelta(t, tt.ratio, ratio, 1e-9)
+			assert.Equal(t, tt.metric, metric)
+		})
+	}
+
+	_, _, err := aggregateRatios("median", ratios)
+	assert.Error(t, err)
+}
+
+func TestAggregateAllMustExceed(t *testing.T) {
+	// Every metric over target: scale by the smallest overload
+	ratio, metric, err := aggregateRatios(neuronetes.AggregationAllMustExceed, []metricRatio{
+		{metricType: "tokens-in-queue", ratio: 3.0},
+		{metricType: "ttft-p95", ratio: 1.5},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 1.5, ratio)
+	assert.Equal(t, "ttft-p95", metric)
+
+	// Every metric under target: scale down as far as the busiest allows
+	ratio, metric, err = aggregateRatios(neuronetes.AggregationAllMustExceed, []metricRatio{
+		{metricType: "tokens-in-queue", ratio: 0.4},
+		{metricType: "ttft-p95", ratio: 0.6},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 0.6, ratio)
+	assert.Equal(t, "ttft-p95", metric)
+}
+
+func TestEvaluateAggregationDampsNoisyMetric(t *testing.T) {
+	provider := NewMockMetricsProvider()
+	provider.SetMetric("tokens-in-queue", 400)
+	provider.SetMetric("ttft-p95", 500)
+	weight := int32(3)
+	metrics := []neuronetes.AutoscalingMetric{
+		{Type: "tokens-in-queue", Target: "100"},
+		{Type: "ttft-p95", Target: "1000", Weight: &weight},
+	}
+
+	// A spike on one metric drives the whole pool under max aggregation
+	pool := newTestPool(2, metrics...)
+	decision, err := newTestAutoscaler(provider).Evaluate(context.Background(), pool)
+	require.NoError(t, err)
+	assert.Equal(t, int32(8), decision.DesiredReplicas)
+
+	pool = newTestPool(2, metrics...)
+	pool.Spec.Autoscaling.Aggregation = neuronetes.AggregationWeighted
+	decision, err = newTestAutoscaler(provider).Evaluate(context.Background(), pool)
+	require.NoError(t, err)
+	// (4*1 + 0.5*3) / 4 = 1.375
+	assert.Equal(t, int32(2), decision.DesiredReplicas)
+	assert.Contains(t, decision.Reason, "weighted average")
+
+	pool = newTestPool(2, metrics...)
+	pool.Spec.Autoscaling.Aggregation = neuronetes.AggregationAllMustExceed
+	decision, err = newTestAutoscaler(provider).Evaluate(context.Background(), pool)
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), decision.DesiredReplicas)
+}
+
+func TestEvaluateToleranceBand(t *testing.T) {
+	tolerance := int32(10)
+	tests := []struct {
+		name   string
+		load   float64
+		want   int32
+		reason string
+	}{
+		{"above within band", 108, 10, "tokens-in-queue within tolerance of target (ratio: 1.08)"},
+		{"below within band", 91, 10, "tokens-in-queue within tolerance of target (ratio: 0.91)"},
+		{"outside band", 120, 12, "scaled based on tokens-in-queue (ratio: 1.20)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := NewMockMetricsProvider()
+			provider.SetMetric("tokens-in-queue", tt.load)
+			pool := newTestPool(10, neuronetes.AutoscalingMetric{Type: "tokens-in-queue", Target: "100", TolerancePercent: &tolerance})
+			pool.Spec.MaxReplicas = 20
+
+			decision, err := newTestAutoscaler(provider).Evaluate(context.Background(), pool)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, decision.DesiredReplicas)
+			assert.Equal(t, tt.reason, decision.Reason)
+		})
+	}
+
+	// Without a band, an 8% dip removes a replica
+	provider := NewMockMetricsProvider()
+	provider.SetMetric("tokens-in-queue", 92)
+	pool := newTestPool(10, neuronetes.AutoscalingMetric{Type: "tokens-in-queue", Target: "100"})
+	pool.Spec.MaxReplicas = 20
+	decision, err := newTestAutoscaler(provider).Evaluate(context.Background(), pool)
+	require.NoError(t, err)
+	assert.Equal(t, int32(9), decision.DesiredReplicas)
+}
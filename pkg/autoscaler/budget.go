@@ -0,0 +1,57 @@
+package autoscaler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+)
+
+// tokensPerSecondMetric is the per-replica throughput metric
+const tokensPerSecondMetric = "tokens-per-second"
+
+// budgetReplicas returns the most replicas pool can run while its aggregate
+// throughput stays within TokensPerSecondBudget, estimated from the observed
+// per-replica throughput. ok is false when the pool has no budget or no
+// throughput has been observed yet.
+func (a *TokenAwareAutoscaler) budgetReplicas(ctx context.Context, pool *neuronetes.AgentPool, observed map[string]float64) (replicas int32, perReplica float64, ok bool, err error) {
+	budget := pool.Spec.TokensPerSecondBudget
+	if budget == nil {
+		return 0, 0, false, nil
+	}
+
+	perReplica, collected := observed[tokensPerSecondMetric]
+	if !collected {
+		perReplica, err = a.metricsProvider.GetMetric(ctx, pool, tokensPerSecondMetric)
+		if errors.Is(err, ErrNoData) {
+			return 0, 0, false, nil
+		}
+		if err != nil {
+			return 0, 0, false, fmt.Errorf("failed to get metric %s: %w", tokensPerSecondMetric, err)
+		}
+	}
+	if perReplica <= 0 {
+		return 0, 0, false, nil
+	}
+
+	// Every pool may run one replica, however small its budget
+	replicas = int32(math.Floor(float64(*budget) / perReplica))
+	if replicas < 1 {
+		replicas = 1
+	}
+	return replicas, perReplica, true, nil
+}
+
+// capToBudget lowers the maximum of bounds to the budget's replica limit.
+// The minimum still wins, as with schedules.
+func capToBudget(bounds ReplicaBounds, replicas int32) ReplicaBounds {
+	if replicas < bounds.Max {
+		bounds.Max = replicas
+	}
+	if bounds.Max < bounds.Min {
+		bounds.Max = bounds.Min
+	}
+	return bounds
+}
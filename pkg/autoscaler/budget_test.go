@@ -0,0 +1,52 @@
+package autoscaler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+)
+
+func TestEvaluateCapsReplicasToBudget(t *testing.T) {
+	provider := NewMockMetricsProvider()
+	provider.SetMetric("tokens-in-queue", 400)
+	provider.SetMetric("tokens-per-second", 250)
+	pool := newTestPool(2, neuronetes.AutoscalingMetric{Type: "tokens-in-queue", Target: "100"})
+
+	// Unbudgeted, the queue drives the pool to 8 replicas
+	decision, err := newTestAutoscaler(provider).Evaluate(context.Background(), pool)
+	require.NoError(t, err)
+	assert.Equal(t, int32(8), decision.DesiredReplicas)
+
+	// 1500 tokens/s at 250 tokens/s per replica allows 6
+	budget := int32(1500)
+	pool.Spec.TokensPerSecondBudget = &budget
+	decision, err = newTestAutoscaler(provider).Evaluate(context.Background(), pool)
+	require.NoError(t, err)
+	assert.Equal(t, int32(6), decision.DesiredReplicas)
+	assert.Contains(t, decision.Reason, "budget")
+	assert.Equal(t, 6.0, decision.Metrics["budget-replicas"])
+
+	// The minimum still wins over the budget
+	pool.Spec.MinReplicas = 7
+	decision, err = newTestAutoscaler(provider).Evaluate(context.Background(), pool)
+	require.NoError(t, err)
+	assert.Equal(t, int32(7), decision.DesiredReplicas)
+}
+
+func TestEvaluateBudgetWithoutThroughputData(t *testing.T) {
+	provider := NewMockMetricsProvider()
+	provider.SetMetric("tokens-in-queue", 400)
+	provider.SetMetric("tokens-per-second", 0)
+	pool := newTestPool(2, neuronetes.AutoscalingMetric{Type: "tokens-in-queue", Target: "100"})
+	budget := int32(100)
+	pool.Spec.TokensPerSecondBudget = &budget
+
+	// Without observed throughput the budget cannot be translated to replicas
+	decision, err := newTestAutoscaler(provider).Evaluate(context.Background(), pool)
+	require.NoError(t, err)
+	assert.Equal(t, int32(8), decision.DesiredReplicas)
+}
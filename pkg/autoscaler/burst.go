@@ -0,0 +1,49 @@
+package autoscaler
+
+import (
+	"math"
+	"strings"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+)
+
+// DefaultBurstThresholdMultiplier is how many times its target a queue metric
+// must reach to trigger a burst when thresholdMultiplier is unset
+const DefaultBurstThresholdMultiplier = 10
+
+// burstMetrics are the metric types measuring queued work. The lag of queue
+// and topic bindings counts too.
+var burstMetrics = map[string]bool{
+	"tokens-in-queue": true,
+	"queue-depth":     true,
+}
+
+// burstRatio returns the highest ratio of a queue metric to its target if it
+// reaches the pool's burst threshold
+func burstRatio(pool *neuronetes.AgentPool, ratios []metricRatio) (metricRatio, bool) {
+	behavior := pool.Spec.Autoscaling.Behavior
+	if behavior == nil || behavior.Burst == nil {
+		return metricRatio{}, false
+	}
+	threshold := float64(DefaultBurstThresholdMultiplier)
+	if m := behavior.Burst.ThresholdMultiplier; m != nil && *m > 1 {
+		threshold = float64(*m)
+	}
+
+	var burst metricRatio
+	for _, r := range ratios {
+		if !burstMetrics[r.metricType] && !strings.HasPrefix(r.metricType, lagMetricPrefix) {
+			continue
+		}
+		if r.ratio >= threshold && r.ratio > burst.ratio {
+			burst = r
+		}
+	}
+	return burst, burst.ratio > 0
+}
+
+// burstReplicas sizes a pool for the full queue of a burst, rather than the
+// aggregate of all its metrics
+func burstReplicas(current int32, burst metricRatio) int32 {
+	return int32(math.Ceil(float64(current) * burst.ratio))
+}
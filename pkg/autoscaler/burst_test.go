@@ -0,0 +1,86 @@
+package autoscaler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+)
+
+func newBurstPool(current int32, burst *neuronetes.BurstPolicy) *neuronetes.AgentPool {
+	pool := newPolicyPool(current, &neuronetes.ScalingBehavior{
+		ScaleUp: &neuronetes.ScalingPolicy{MaxChangeAbsolute: int32Ptr(2)},
+		Burst:   burst,
+	})
+	pool.Spec.Autoscaling.CooldownPeriod = &metav1.Duration{Duration: 5 * time.Minute}
+	return pool
+}
+
+func TestBurstBypassesScaleUpLimits(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	lastScale := metav1.NewTime(now.Add(-time.Minute))
+
+	// A spike under the threshold is rate limited as usual
+	provider := NewMockMetricsProvider()
+	pool := newBurstPool(2, &neuronetes.BurstPolicy{})
+	decision := evaluateAt(t, newTestAutoscaler(provider), provider, pool, now, 750)
+	assert.Equal(t, int32(4), decision.DesiredReplicas)
+
+	// Past 10x its target the queue is scaled for at once, despite cooldown
+	provider = NewMockMetricsProvider()
+	pool = newBurstPool(2, &neuronetes.BurstPolicy{})
+	pool.Status.LastScaleTime = &lastScale
+	decision = evaluateAt(t, newTestAutoscaler(provider), provider, pool, now, 1250)
+	assert.Equal(t, int32(25), decision.DesiredReplicas)
+	assert.Equal(t, "burst on tokens-in-queue at 12.5x target", decision.Reason)
+
+	// Bounds still apply
+	provider = NewMockMetricsProvider()
+	pool = newBurstPool(2, &neuronetes.BurstPolicy{ThresholdMultiplier: int32Ptr(5)})
+	pool.Spec.MaxReplicas = 12
+	decision = evaluateAt(t, newTestAutoscaler(provider), provider, pool, now, 1500)
+	assert.Equal(t, int32(12), decision.DesiredReplicas)
+
+	// Without a burst policy the spike is rate limited
+	provider = NewMockMetricsProvider()
+	pool = newBurstPool(2, nil)
+	decision = evaluateAt(t, newTestAutoscaler(provider), provider, pool, now, 1250)
+	assert.Equal(t, int32(4), decision.DesiredReplicas)
+}
+
+func TestBurstOnQueueMetricsOnly(t *testing.T) {
+	pool := newBurstPool(2, &neuronetes.BurstPolicy{})
+	ratios := []metricRatio{
+		{metricType: "ttft-p95", ratio: 20},
+		{metricType: "queue-depth", ratio: 11},
+		{metricType: lagMetricPrefix + "tasks", ratio: 15},
+	}
+	burst, ok := burstRatio(pool, ratios)
+	assert.True(t, ok)
+	assert.Equal(t, lagMetricPrefix+"tasks", burst.metricType)
+
+	_, ok = burstRatio(pool, ratios[:1])
+	assert.False(t, ok)
+}
+
+func TestBurstReentersStabilization(t *testing.T) {
+	provider := NewMockMetricsProvider()
+	a := newTestAutoscaler(provider)
+	now := time.Unix(1700000000, 0)
+	pool := newBurstPool(2, &neuronetes.BurstPolicy{})
+
+	decision := evaluateAt(t, a, provider, pool, now, 1250)
+	assert.Equal(t, int32(25), decision.DesiredReplicas)
+
+	// Once the spike drains, scale-down waits out the stabilization window
+	pool.Status.Replicas = 25
+	decision = evaluateAt(t, a, provider, pool, now.Add(30*time.Second), 20)
+	assert.Equal(t, int32(25), decision.DesiredReplicas)
+	assert.Contains(t, decision.Reason, "stabilized")
+
+	decision = evaluateAt(t, a, provider, pool, now.Add(2*time.Minute), 20)
+	assert.Equal(t, int32(5), decision.DesiredReplicas)
+}
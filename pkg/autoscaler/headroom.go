@@ -0,0 +1,26 @@
+package autoscaler
+
+import "math"
+
+// SLOHeadroomPercent returns the headroom left below a metric target given
+// the metric's value/target ratio. 25 means the metric sits 25% below its
+// target; negative values mean the target is already exceeded.
+func SLOHeadroomPercent(ratio float64) float64 {
+	return (1.0 - ratio) * 100.0
+}
+
+// ReplicasForHeadroom returns the replica count needed to bring the load
+// currently spread over current replicas back to minHeadroomPercent below
+// target, assuming load divides evenly across replicas
+func ReplicasForHeadroom(current int32, ratio float64, minHeadroomPercent int32) int32 {
+	if current <= 0 || ratio <= 0 {
+		return current
+	}
+
+	allowed := 1.0 - float64(minHeadroomPercent)/100.0
+	if allowed <= 0 {
+		return current
+	}
+
+	return int32(math.Ceil(float64(current) * ratio / allowed))
+}
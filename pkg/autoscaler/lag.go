@@ -0,0 +1,137 @@
+package autoscaler
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+)
+
+// DefaultMaxLagThreshold is the lag per replica a pool is scaled to hold when
+// a binding does not set maxLagThreshold
+const DefaultMaxLagThreshold = 100
+
+// lagMetricPrefix prefixes the metric reporting a binding's consumer lag
+const lagMetricPrefix = "queue-lag/"
+
+// LagCollector reports the consumer lag of a queue or topic binding
+type LagCollector interface {
+	Lag(ctx context.Context, binding *neuronetes.ToolBinding) (float64, error)
+}
+
+// LagSource reports the consumer lag of the bindings that scale a pool
+type LagSource interface {
+	BindingLag(ctx context.Context, pool *neuronetes.AgentPool) ([]BindingLag, error)
+}
+
+// BindingLag is the consumer lag of one ToolBinding
+type BindingLag struct {
+	// Binding is the ToolBinding name
+	Binding string
+
+	// Lag is the number of messages waiting to be consumed
+	Lag float64
+
+	// Threshold is the lag per replica the pool is scaled to hold
+	Threshold int32
+}
+
+// ToolBindingLagSource reports the lag of ToolBindings that reference a pool
+// and enable autoscaleOnLag
+type ToolBindingLagSource struct {
+	Reader    client.Reader
+	Collector LagCollector
+}
+
+// BindingLag implements LagSource
+func (s *ToolBindingLagSource) BindingLag(ctx context.Context, pool *neuronetes.AgentPool) ([]BindingLag, error) {
+	var bindings neuronetes.ToolBindingList
+	if err := s.Reader.List(ctx, &bindings); err != nil {
+		return nil, fmt.Errorf("failed to list tool bindings: %w", err)
+	}
+
+	var lags []BindingLag
+	for i := range bindings.Items {
+		binding := &bindings.Items[i]
+		if !bindsPool(binding, pool) {
+			continue
+		}
+		threshold, ok := lagThreshold(binding)
+		if !ok {
+			continue
+		}
+		lag, err := s.Collector.Lag(ctx, binding)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get lag of binding %s: %w", binding.Name, err)
+		}
+		lags = append(lags, BindingLag{Binding: binding.Name, Lag: lag, Threshold: threshold})
+	}
+	sort.Slice(lags, func(i, j int) bool { return lags[i].Binding < lags[j].Binding })
+	return lags, nil
+}
+
+// bindsPool reports whether binding references pool. A reference without a
+// namespace is to the binding's own namespace.
+func bindsPool(binding *neuronetes.ToolBinding, pool *neuronetes.AgentPool) bool {
+	namespace := binding.Spec.AgentPoolRef.Namespace
+	if namespace == "" {
+		namespace = binding.Namespace
+	}
+	return binding.Spec.AgentPoolRef.Name == pool.Name && namespace == pool.Namespace
+}
+
+// lagThreshold returns the per-replica lag threshold of binding, or false if
+// it does not scale on lag
+func lagThreshold(binding *neuronetes.ToolBinding) (int32, bool) {
+	var threshold *int32
+	switch {
+	case binding.Spec.QueueConfig != nil && binding.Spec.QueueConfig.AutoscaleOnLag:
+		threshold = binding.Spec.QueueConfig.MaxLagThreshold
+	case binding.Spec.TopicConfig != nil && binding.Spec.TopicConfig.AutoscaleOnLag:
+		threshold = binding.Spec.TopicConfig.MaxLagThreshold
+	default:
+		return 0, false
+	}
+	if threshold == nil || *threshold <= 0 {
+		return DefaultMaxLagThreshold, true
+	}
+	return *threshold, true
+}
+
+// SetLagSource scales pools on the consumer lag of their queue and topic
+// bindings, alongside the configured metrics
+func (a *TokenAwareAutoscaler) SetLagSource(source LagSource) {
+	a.lag = source
+}
+
+// lagRatios returns a ratio per lag-driven binding of pool. Lag is spread
+// over the current replicas, so a pool holds at most its threshold each; a
+// pool at zero is treated as one replica so any lag activates it.
+func (a *TokenAwareAutoscaler) lagRatios(ctx context.Context, pool *neuronetes.AgentPool, metrics map[string]float64) ([]metricRatio, error) {
+	if a.lag == nil {
+		return nil, nil
+	}
+	lags, err := a.lag.BindingLag(ctx, pool)
+	if err != nil {
+		return nil, err
+	}
+
+	replicas := float64(pool.Status.Replicas)
+	if replicas < 1 {
+		replicas = 1
+	}
+	ratios := make([]metricRatio, 0, len(lags))
+	for _, lag := range lags {
+		metricType := lagMetricPrefix + lag.Binding
+		metrics[metricType] = lag.Lag
+		ratios = append(ratios, metricRatio{
+			metricType: metricType,
+			ratio:      lag.Lag / replicas / float64(lag.Threshold),
+			weight:     1,
+		})
+	}
+	return ratios, nil
+}
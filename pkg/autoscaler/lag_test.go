@@ -0,0 +1,161 @@
+package autoscaler
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+)
+
+// staticLag reports a fixed lag per queue or topic name
+type staticLag map[string]float64
+
+func (s staticLag) Lag(ctx context.Context, binding *neuronetes.ToolBinding) (float64, error) {
+	name := ""
+	if binding.Spec.QueueConfig != nil {
+		name = binding.Spec.QueueConfig.QueueName
+	} else if binding.Spec.TopicConfig != nil {
+		name = binding.Spec.TopicConfig.TopicName
+	}
+	lag, ok := s[name]
+	if !ok {
+		return 0, errors.New("no lag for " + name)
+	}
+	return lag, nil
+}
+
+func queueBinding(name, pool string, threshold *int32) *neuronetes.ToolBinding {
+	return &neuronetes.ToolBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec: neuronetes.ToolBindingSpec{
+			AgentPoolRef: neuronetes.AgentPoolReference{Name: pool},
+			Type:         "queue",
+			QueueConfig: &neuronetes.QueueConfig{
+				Provider:        "nats",
+				QueueName:       name,
+				AutoscaleOnLag:  true,
+				MaxLagThreshold: threshold,
+			},
+		},
+	}
+}
+
+func TestToolBindingLagSource(t *testing.T) {
+	threshold := int32(50)
+	topic := &neuronetes.ToolBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: "events", Namespace: "other"},
+		Spec: neuronetes.ToolBindingSpec{
+			AgentPoolRef: neuronetes.AgentPoolReference{Name: "chat-pool", Namespace: "default"},
+			Type:         "topic",
+			TopicConfig: &neuronetes.TopicConfig{
+				Provider:       "kafka",
+				TopicName:      "events",
+				AutoscaleOnLag: true,
+			},
+		},
+	}
+	noLag := queueBinding("audit", "chat-pool", nil)
+	noLag.Spec.QueueConfig.AutoscaleOnLag = false
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, neuronetes.AddToScheme(scheme))
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		queueBinding("tasks", "chat-pool", &threshold),
+		queueBinding("batch", "batch-pool", nil),
+		topic,
+		noLag,
+	).Build()
+
+	source := &ToolBindingLagSource{Reader: c, Collector: staticLag{"tasks": 300, "events": 40}}
+	lags, err := source.BindingLag(context.Background(), newTestPool(1))
+	require.NoError(t, err)
+	assert.Equal(t, []BindingLag{
+		{Binding: "events", Lag: 40, Threshold: DefaultMaxLagThreshold},
+		{Binding: "tasks", Lag: 300, Threshold: 50},
+	}, lags)
+}
+
+type staticLagSource []BindingLag
+
+func (s staticLagSource) BindingLag(ctx context.Context, pool *neuronetes.AgentPool) ([]BindingLag, error) {
+	return s, nil
+}
+
+func TestEvaluateScalesOnQueueLag(t *testing.T) {
+	provider := NewMockMetricsProvider()
+	provider.SetMetric("tokens-in-queue", 50)
+	pool := newTestPool(2, neuronetes.AutoscalingMetric{Type: "tokens-in-queue", Target: "100"})
+
+	scaler := newTestAutoscaler(provider)
+	scaler.SetLagSource(staticLagSource{{Binding: "tasks", Lag: 600, Threshold: 100}})
+
+	decision, err := scaler.Evaluate(context.Background(), pool)
+	require.NoError(t, err)
+	// 600 messages at 100 per replica
+	assert.Equal(t, int32(6), decision.DesiredReplicas)
+	assert.Contains(t, decision.Reason, "queue-lag/tasks")
+	assert.Equal(t, 600.0, decision.Metrics["queue-lag/tasks"])
+}
+
+func TestEvaluateLagOnlyPool(t *testing.T) {
+	pool := newTestPool(0)
+	pool.Spec.MinReplicas = 0
+
+	scaler := newTestAutoscaler(NewMockMetricsProvider())
+	scaler.SetLagSource(staticLagSource{{Binding: "tasks", Lag: 5, Threshold: 100}})
+
+	// Any lag activates a pool at zero
+	decision, err := scaler.Evaluate(context.Background(), pool)
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), decision.DesiredReplicas)
+
+	// Without lag-driven bindings there is nothing to scale on
+	scaler.SetLagSource(staticLagSource{})
+	decision, err = scaler.Evaluate(context.Background(), pool)
+	require.NoError(t, err)
+	assert.Equal(t, "no autoscaling configured", decision.Reason)
+}
+
+func TestPrometheusMetricsProviderLag(t *testing.T) {
+	fake := &fakePrometheus{body: vectorResponse("1200")}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	provider, err := NewPrometheusMetricsProvider(PrometheusConfig{
+		Address:    server.URL,
+		LagQueries: map[string]string{"rabbitmq": `sum(rabbitmq_queue_messages_ready{queue="{{.Name}}"})`},
+	})
+	require.NoError(t, err)
+
+	binding := queueBinding("tasks", "chat-pool", nil)
+	lag, err := provider.Lag(context.Background(), binding)
+	require.NoError(t, err)
+	assert.Equal(t, 1200.0, lag)
+	assert.Equal(t, `sum(jetstream_consumer_num_pending{stream_name="tasks"})`, fake.query)
+
+	binding.Spec.QueueConfig.Provider = "rabbitmq"
+	query, err := provider.LagQuery(binding)
+	require.NoError(t, err)
+	assert.Equal(t, `sum(rabbitmq_queue_messages_ready{queue="tasks"})`, query)
+
+	topic := &neuronetes.ToolBinding{Spec: neuronetes.ToolBindingSpec{TopicConfig: &neuronetes.TopicConfig{
+		Provider:      "kafka",
+		TopicName:     "events",
+		ConsumerGroup: "agents",
+	}}}
+	query, err = provider.LagQuery(topic)
+	require.NoError(t, err)
+	assert.Equal(t, `sum(kafka_consumergroup_lag{topic="events",consumergroup="agents"})`, query)
+
+	topic.Spec.TopicConfig.Provider = "pubsub"
+	_, err = provider.LagQuery(topic)
+	assert.Error(t, err)
+}
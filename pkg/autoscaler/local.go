@@ -0,0 +1,81 @@
+package autoscaler
+
+import (
+	"context"
+	"time"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+)
+
+// QuantileSource estimates latency quantiles of the turns served in
+// process, as metrics.AgentMetrics does
+type QuantileSource interface {
+	TTFTQuantile(model string, q float64) (time.Duration, bool)
+}
+
+// LocalMetricsProvider implements MetricsProvider for autoscalers embedded
+// in the process serving a pool, such as the gateway: ttft-p95 is read from
+// the in-process quantiles, without a round-trip to Prometheus, and other
+// metric types from Fallback
+type LocalMetricsProvider struct {
+	// Quantiles estimates the TTFT of the turns of this process
+	Quantiles QuantileSource
+
+	// Model returns the model whose TTFT scales pool. Nil means all the
+	// models of this process.
+	Model func(pool *neuronetes.AgentPool) string
+
+	// Fallback serves the other metric types. Nil means they have no data.
+	Fallback MetricsProvider
+}
+
+// GetMetric implements MetricsProvider. ttft-p95 is in milliseconds, as
+// when queried from Prometheus, and ErrNoData without turns in the window.
+func (p *LocalMetricsProvider) GetMetric(ctx context.Context, pool *neuronetes.AgentPool, metricType string) (float64, error) {
+	if metricType != "ttft-p95" {
+		if p.Fallback == nil {
+			return 0, ErrNoData
+		}
+		return p.Fallback.GetMetric(ctx, pool, metricType)
+	}
+
+	var model string
+	if p.Model != nil {
+		model = p.Model(pool)
+	}
+	ttft, ok := p.Quantiles.TTFTQuantile(model, 0.95)
+	if !ok {
+		return 0, ErrNoData
+	}
+	return float64(ttft) / float64(time.Millisecond), nil
+}
+
+// PoolGPUSource reports the live GPU utilization of the replicas of a
+// pool, as dcgm.Bridge does
+type PoolGPUSource interface {
+	PoolGPUUtilization(namespace, name string) (float64, bool)
+}
+
+// GPUMetricsProvider implements MetricsProvider by serving gpu-utilization
+// from the GPUs of the pool's replicas as reported by Source, such as the
+// dcgm-exporter bridge, and other metric types from Fallback
+type GPUMetricsProvider struct {
+	Source PoolGPUSource
+
+	// Fallback serves the other metric types, and gpu-utilization of pools
+	// Source has no data for. Nil means they have no data.
+	Fallback MetricsProvider
+}
+
+// GetMetric implements MetricsProvider. gpu-utilization is in percent.
+func (p *GPUMetricsProvider) GetMetric(ctx context.Context, pool *neuronetes.AgentPool, metricType string) (float64, error) {
+	if metricType == gpuUtilizationMetric {
+		if utilization, ok := p.Source.PoolGPUUtilization(pool.Namespace, pool.Name); ok {
+			return utilization, nil
+		}
+	}
+	if p.Fallback == nil {
+		return 0, ErrNoData
+	}
+	return p.Fallback.GetMetric(ctx, pool, metricType)
+}
@@ -0,0 +1,87 @@
+package autoscaler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/metrics"
+)
+
+func TestLocalMetricsProvider(t *testing.T) {
+	m := metrics.NewAgentMetrics(prometheus.NewRegistry())
+	fallback := NewMockMetricsProvider()
+	fallback.SetMetric("tokens-in-queue", 42)
+	provider := &LocalMetricsProvider{
+		Quantiles: m,
+		Model:     func(pool *neuronetes.AgentPool) string { return pool.Name },
+		Fallback:  fallback,
+	}
+	pool := &neuronetes.AgentPool{}
+	pool.Name = "llama-3-70b"
+	ctx := context.Background()
+
+	_, err := provider.GetMetric(ctx, pool, "ttft-p95")
+	assert.ErrorIs(t, err, ErrNoData)
+
+	for i := 1; i <= 100; i++ {
+		m.RecordTTFT(ctx, time.Duration(i)*time.Millisecond, "llama-3-70b", "/chat")
+		m.RecordTTFT(ctx, time.Second, "llama-3-8b", "/chat")
+	}
+	ttft, err := provider.GetMetric(ctx, pool, "ttft-p95")
+	require.NoError(t, err)
+	assert.InDelta(t, 95, ttft, 1)
+
+	// Without Model, all the models of the process count
+	provider.Model = nil
+	ttft, err = provider.GetMetric(ctx, pool, "ttft-p95")
+	require.NoError(t, err)
+	assert.Equal(t, 1000.0, ttft)
+
+	queued, err := provider.GetMetric(ctx, pool, "tokens-in-queue")
+	require.NoError(t, err)
+	assert.Equal(t, 42.0, queued)
+
+	provider.Fallback = nil
+	_, err = provider.GetMetric(ctx, pool, "tokens-in-queue")
+	assert.ErrorIs(t, err, ErrNoData)
+}
+
+// poolGPUs is a PoolGPUSource keyed by pool name
+type poolGPUs map[string]float64
+
+func (p poolGPUs) PoolGPUUtilization(namespace, name string) (float64, bool) {
+	utilization, ok := p[name]
+	return utilization, ok
+}
+
+func TestGPUMetricsProvider(t *testing.T) {
+	fallback := NewMockMetricsProvider()
+	fallback.SetMetric(gpuUtilizationMetric, 30)
+	fallback.SetMetric("tokens-in-queue", 42)
+	provider := &GPUMetricsProvider{Source: poolGPUs{"chat": 75}, Fallback: fallback}
+	ctx := context.Background()
+	chat := &neuronetes.AgentPool{}
+	chat.Name = "chat"
+	batch := &neuronetes.AgentPool{}
+	batch.Name = "batch"
+
+	utilization, err := provider.GetMetric(ctx, chat, gpuUtilizationMetric)
+	require.NoError(t, err)
+	assert.Equal(t, 75.0, utilization)
+	utilization, err = provider.GetMetric(ctx, batch, gpuUtilizationMetric)
+	require.NoError(t, err)
+	assert.Equal(t, 30.0, utilization)
+	queued, err := provider.GetMetric(ctx, chat, "tokens-in-queue")
+	require.NoError(t, err)
+	assert.Equal(t, 42.0, queued)
+
+	provider.Fallback = nil
+	_, err = provider.GetMetric(ctx, batch, gpuUtilizationMetric)
+	assert.ErrorIs(t, err, ErrNoData)
+}
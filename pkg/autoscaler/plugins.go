@@ -0,0 +1,148 @@
+package autoscaler
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/plugins"
+)
+
+// pluginMetricPrefix prefixes the metric reporting a plugin's recommendation
+const pluginMetricPrefix = "plugin/"
+
+// metricsSource names the recommendation computed from the pool's metrics
+const metricsSource = "metrics"
+
+// PluginRecommendation is the replica count an autoscaler plugin recommended
+type PluginRecommendation struct {
+	// Plugin is the plugin name
+	Plugin string
+
+	// Priority is the plugin priority
+	Priority int
+
+	// Replicas is the recommended replica count
+	Replicas int32
+
+	// Err is set if the plugin failed, in which case it is ignored
+	Err error
+}
+
+// SetPlugins runs the autoscaler plugins of registry on every evaluation,
+// alongside the configured metrics
+func (a *TokenAwareAutoscaler) SetPlugins(registry *plugins.PluginRegistry) {
+	a.plugins = registry
+}
+
+// autoscalerPlugins returns the registered plugins, highest priority first
+func (a *TokenAwareAutoscaler) autoscalerPlugins() []plugins.AutoscalerPlugin {
+	if a.plugins == nil {
+		return nil
+	}
+	registered := append([]plugins.AutoscalerPlugin(nil), a.plugins.GetAutoscalers()...)
+	sort.SliceStable(registered, func(i, j int) bool {
+		return registered[i].Priority() > registered[j].Priority()
+	})
+	return registered
+}
+
+// runPlugins asks each plugin for a recommendation in priority order. The
+// metrics a plugin needs are collected into metrics first. A plugin that
+// fails, or whose metrics are unavailable, is reported but does not fail the
+// evaluation.
+func (a *TokenAwareAutoscaler) runPlugins(ctx context.Context, pool *neuronetes.AgentPool, metrics map[string]float64) []PluginRecommendation {
+	registered := a.autoscalerPlugins()
+	if len(registered) == 0 {
+		return nil
+	}
+
+	results := make([]PluginRecommendation, 0, len(registered))
+	for _, plugin := range registered {
+		result := PluginRecommendation{Plugin: plugin.Name(), Priority: plugin.Priority()}
+		for _, name := range plugin.GetMetricNames() {
+			if _, ok := metrics[name]; ok {
+				continue
+			}
+			value, err := a.metricsProvider.GetMetric(ctx, pool, name)
+			if err != nil {
+				result.Err = fmt.Errorf("failed to get metric %s: %w", name, err)
+				break
+			}
+			metrics[name] = value
+		}
+		if result.Err == nil {
+			result.Replicas, result.Err = plugin.CalculateReplicas(ctx, pool, metrics)
+		}
+		if result.Err == nil {
+			metrics[pluginMetricPrefix+result.Plugin] = float64(result.Replicas)
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// combineRecommendations combines the recommendation computed from the
+// metrics, if any, with those of the plugins that succeeded, according to
+// policy. Unless the metrics' recommendation prevailed it also returns the
+// decision reason.
+func combineRecommendations(policy string, desired int32, fromMetrics bool, results []PluginRecommendation) (int32, string, error) {
+	type candidate struct {
+		source   string
+		replicas int32
+	}
+	var candidates []candidate
+	for _, r := range results {
+		if r.Err == nil {
+			candidates = append(candidates, candidate{r.Plugin, r.Replicas})
+		}
+	}
+	if len(candidates) == 0 {
+		return desired, "", nil
+	}
+	if fromMetrics {
+		// Plugins are ordered by priority, so the metrics come last
+		candidates = append(candidates, candidate{metricsSource, desired})
+	}
+
+	best := candidates[0]
+	switch policy {
+	case "", neuronetes.PluginPolicyMax:
+		for _, c := range candidates[1:] {
+			if c.replicas > best.replicas {
+				best = c
+			}
+		}
+
+	case neuronetes.PluginPolicyMin:
+		for _, c := range candidates[1:] {
+			if c.replicas < best.replicas {
+				best = c
+			}
+		}
+
+	case neuronetes.PluginPolicyAverage:
+		var sum float64
+		sources := make([]string, len(candidates))
+		for i, c := range candidates {
+			sum += float64(c.replicas)
+			sources[i] = c.source
+		}
+		replicas := int32(math.Ceil(sum / float64(len(candidates))))
+		return replicas, fmt.Sprintf("averaged %d replicas recommended by %s", replicas, strings.Join(sources, ",")), nil
+
+	case neuronetes.PluginPolicyPriority:
+		// The highest-priority plugin prevails
+
+	default:
+		return 0, "", fmt.Errorf("unknown plugin policy %q", policy)
+	}
+
+	if best.source == metricsSource {
+		return best.replicas, "", nil
+	}
+	return best.replicas, fmt.Sprintf("plugin %s recommended %d replicas", best.source, best.replicas), nil
+}
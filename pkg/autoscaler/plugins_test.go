@@ -0,0 +1,120 @@
+package autoscaler
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/plugins"
+)
+
+// fixedPlugin recommends a fixed replica count
+type fixedPlugin struct {
+	name     string
+	priority int
+	replicas int32
+	metrics  []string
+	err      error
+
+	// seen is the metrics the plugin was last given
+	seen map[string]float64
+}
+
+func (p *fixedPlugin) Name() string { return p.name }
+
+func (p *fixedPlugin) CalculateReplicas(ctx context.Context, pool *neuronetes.AgentPool, currentMetrics map[string]float64) (int32, error) {
+	p.seen = currentMetrics
+	return p.replicas, p.err
+}
+
+func (p *fixedPlugin) GetMetricNames() []string { return p.metrics }
+
+func (p *fixedPlugin) Priority() int { return p.priority }
+
+func newPluginAutoscaler(provider MetricsProvider, registered ...plugins.AutoscalerPlugin) *TokenAwareAutoscaler {
+	registry := plugins.NewPluginRegistry()
+	for _, plugin := range registered {
+		registry.RegisterAutoscaler(plugin)
+	}
+	a := newTestAutoscaler(provider)
+	a.SetPlugins(registry)
+	return a
+}
+
+func TestEvaluateRunsPluginsInPriorityOrder(t *testing.T) {
+	provider := NewMockMetricsProvider()
+	provider.SetMetric("tokens-in-queue", 200)
+	provider.SetMetric("custom-load", 90)
+	pool := newTestPool(2, neuronetes.AutoscalingMetric{Type: "tokens-in-queue", Target: "100"})
+
+	low := &fixedPlugin{name: "low", priority: 10, replicas: 6}
+	high := &fixedPlugin{name: "high", priority: 100, replicas: 3, metrics: []string{"custom-load"}}
+	broken := &fixedPlugin{name: "broken", priority: 50, err: errors.New("model unavailable")}
+	a := newPluginAutoscaler(provider, low, high, broken)
+
+	decision, err := a.Evaluate(context.Background(), pool)
+	require.NoError(t, err)
+	require.Len(t, decision.Plugins, 3)
+	assert.Equal(t, "high", decision.Plugins[0].Plugin)
+	assert.Equal(t, "broken", decision.Plugins[1].Plugin)
+	assert.Error(t, decision.Plugins[1].Err)
+	assert.Equal(t, "low", decision.Plugins[2].Plugin)
+
+	// Plugins see the metrics they asked for
+	assert.Equal(t, 90.0, high.seen["custom-load"])
+	assert.Equal(t, 6.0, decision.Metrics["plugin/low"])
+
+	// The largest recommendation wins by default
+	assert.Equal(t, int32(6), decision.DesiredReplicas)
+	assert.Equal(t, "plugin low recommended 6 replicas", decision.Reason)
+}
+
+func TestEvaluatePluginPolicies(t *testing.T) {
+	tests := []struct {
+		policy string
+		want   int32
+		reason string
+	}{
+		{neuronetes.PluginPolicyMax, 6, "plugin low recommended 6 replicas"},
+		{neuronetes.PluginPolicyMin, 3, "plugin high recommended 3 replicas"},
+		{neuronetes.PluginPolicyAverage, 5, "averaged 5 replicas recommended by high,low,metrics"},
+		{neuronetes.PluginPolicyPriority, 3, "plugin high recommended 3 replicas"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			provider := NewMockMetricsProvider()
+			provider.SetMetric("tokens-in-queue", 200)
+			pool := newTestPool(2, neuronetes.AutoscalingMetric{Type: "tokens-in-queue", Target: "100"})
+			pool.Spec.Autoscaling.PluginPolicy = tt.policy
+			a := newPluginAutoscaler(provider,
+				&fixedPlugin{name: "low", priority: 10, replicas: 6},
+				&fixedPlugin{name: "high", priority: 100, replicas: 3},
+			)
+
+			decision, err := a.Evaluate(context.Background(), pool)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, decision.DesiredReplicas)
+			assert.Equal(t, tt.reason, decision.Reason)
+		})
+	}
+}
+
+func TestEvaluatePluginOnlyPool(t *testing.T) {
+	provider := NewMockMetricsProvider()
+	pool := newTestPool(2)
+	plugin := &fixedPlugin{name: "custom", priority: 1, replicas: 5}
+
+	decision, err := newPluginAutoscaler(provider, plugin).Evaluate(context.Background(), pool)
+	require.NoError(t, err)
+	assert.Equal(t, int32(5), decision.DesiredReplicas)
+
+	// A failing plugin leaves the pool as it is
+	plugin.err = errors.New("unavailable")
+	decision, err = newPluginAutoscaler(provider, plugin).Evaluate(context.Background(), pool)
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), decision.DesiredReplicas)
+}
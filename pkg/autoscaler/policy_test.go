@@ -0,0 +1,104 @@
+package autoscaler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+)
+
+func newPolicyPool(current int32, behavior *neuronetes.ScalingBehavior) *neuronetes.AgentPool {
+	pool := newTestPool(current, neuronetes.AutoscalingMetric{Type: "tokens-in-queue", Target: "100"})
+	pool.Spec.MaxReplicas = 50
+	pool.Spec.Autoscaling.Behavior = behavior
+	return pool
+}
+
+func TestScalingPoliciesLimitEachEvaluation(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	noWindow := &metav1.Duration{}
+	tests := []struct {
+		name     string
+		current  int32
+		load     float64
+		behavior *neuronetes.ScalingBehavior
+		want     int32
+	}{
+		{"absolute scale-up", 4, 500, &neuronetes.ScalingBehavior{
+			ScaleUp: &neuronetes.ScalingPolicy{MaxChangeAbsolute: int32Ptr(3)},
+		}, 7},
+		{"percent scale-up", 4, 500, &neuronetes.ScalingBehavior{
+			ScaleUp: &neuronetes.ScalingPolicy{MaxChangePercent: int32Ptr(50)},
+		}, 6},
+		{"stricter limit wins", 10, 500, &neuronetes.ScalingBehavior{
+			ScaleUp: &neuronetes.ScalingPolicy{MaxChangePercent: int32Ptr(100), MaxChangeAbsolute: int32Ptr(4)},
+		}, 14},
+		{"absolute scale-down", 10, 10, &neuronetes.ScalingBehavior{
+			ScaleDown: &neuronetes.ScalingPolicy{StabilizationWindow: noWindow, MaxChangeAbsolute: int32Ptr(2)},
+		}, 8},
+		{"percent scale-down", 10, 10, &neuronetes.ScalingBehavior{
+			ScaleDown: &neuronetes.ScalingPolicy{StabilizationWindow: noWindow, MaxChangePercent: int32Ptr(30)},
+		}, 7},
+		{"within limits", 4, 150, &neuronetes.ScalingBehavior{
+			ScaleUp: &neuronetes.ScalingPolicy{MaxChangeAbsolute: int32Ptr(3)},
+		}, 6},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := NewMockMetricsProvider()
+			decision := evaluateAt(t, newTestAutoscaler(provider), provider, newPolicyPool(tt.current, tt.behavior), now, tt.load)
+			assert.Equal(t, tt.want, decision.DesiredReplicas)
+		})
+	}
+}
+
+// scaledAt returns an applied scale recorded in the scaling history
+func scaledAt(at time.Time, from, to int32) neuronetes.ScalingRecommendation {
+	return neuronetes.ScalingRecommendation{CurrentReplicas: from, DesiredReplicas: to, Time: metav1.NewTime(at)}
+}
+
+func TestScalingPoliciesLimitEachPeriod(t *testing.T) {
+	provider := NewMockMetricsProvider()
+	a := newTestAutoscaler(provider)
+	now := time.Unix(1700000000, 0)
+	pool := newPolicyPool(6, &neuronetes.ScalingBehavior{
+		ScaleUp: &neuronetes.ScalingPolicy{MaxChangeAbsolute: int32Ptr(2), PeriodSeconds: int32Ptr(60)},
+	})
+	// The pool grew from 4 to 6 replicas 30 seconds ago
+	pool.Status.ScalingHistory = []neuronetes.ScalingRecommendation{
+		scaledAt(now.Add(-10*time.Minute), 2, 4),
+		scaledAt(now.Add(-30*time.Second), 4, 6),
+		// Dry-run recommendations were never applied
+		{CurrentReplicas: 6, DesiredReplicas: 9, DryRun: true, Time: metav1.NewTime(now.Add(-10 * time.Second))},
+	}
+
+	// The period's budget of 2 replicas is spent
+	decision := evaluateAt(t, a, provider, pool, now, 300)
+	assert.Equal(t, int32(6), decision.DesiredReplicas)
+	assert.Contains(t, decision.Reason, "rate limited to 6 replicas")
+
+	// Once the scale leaves the period the budget is available again
+	decision = evaluateAt(t, a, provider, pool, now.Add(31*time.Second), 300)
+	assert.Equal(t, int32(8), decision.DesiredReplicas)
+}
+
+func TestScalingPoliciesLimitScaleDownPerPeriod(t *testing.T) {
+	provider := NewMockMetricsProvider()
+	a := newTestAutoscaler(provider)
+	now := time.Unix(1700000000, 0)
+	pool := newPolicyPool(8, &neuronetes.ScalingBehavior{
+		ScaleDown: &neuronetes.ScalingPolicy{
+			StabilizationWindow: &metav1.Duration{},
+			MaxChangePercent:    int32Ptr(50),
+			PeriodSeconds:       int32Ptr(300),
+		},
+	})
+	pool.Status.ScalingHistory = []neuronetes.ScalingRecommendation{scaledAt(now.Add(-time.Minute), 12, 8)}
+
+	// Half of the 12 replicas at the start of the period may go
+	decision := evaluateAt(t, a, provider, pool, now, 10)
+	assert.Equal(t, int32(6), decision.DesiredReplicas)
+}
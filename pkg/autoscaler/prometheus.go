@@ -0,0 +1,537 @@
+package autoscaler
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	promv1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/canary"
+)
+
+// ErrNoData is returned when a query matches no series
+var ErrNoData = errors.New("query returned no data")
+
+const (
+	// DefaultPoolSelector selects a pool's series by namespace and pool label
+	DefaultPoolSelector = `namespace="{{.Namespace}}",pool="{{.Name}}"`
+
+	// DefaultClassSelector selects an AgentClass's series by namespace and
+	// agent_class label
+	DefaultClassSelector = `namespace="{{.Namespace}}",agent_class="{{.Name}}"`
+
+	// DefaultAveragingWindow is the range used by rate and quantile queries
+	// when a metric does not set averagingWindow
+	DefaultAveragingWindow = time.Minute
+)
+
+// DefaultQueries are the PromQL templates for each autoscaling metric type.
+// Templates are rendered with .Selector (the pool's label matchers) and
+// .Window (the metric's averaging window). Queue and load metrics are
+// averaged per replica so they compose with the ratio-based scaling formula;
+// those labeled by model or route are first summed per replica.
+var DefaultQueries = map[string]string{
+	"tokens-in-queue":     `avg(agent_tokens_in_queue{ {{.Selector}} })`,
+	"ttft-p95":            `histogram_quantile(0.95, sum by (le) (rate(agent_ttft_ms_bucket{ {{.Selector}} }[{{.Window}}])))`,
+	"concurrent-sessions": `avg(agent_active_sessions{ {{.Selector}} })`,
+	"tokens-per-second":   `avg(sum by (instance) (rate(agent_output_tokens_total{ {{.Selector}} }[{{.Window}}])))`,
+	"queue-depth":         `avg(sum by (instance) (agent_queue_depth{ {{.Selector}} }))`,
+	"context-length":      `avg(agent_ctx_len_p95{ {{.Selector}} })`,
+	"tool-call-rate":      `sum(rate(agent_tool_calls_per_turn_sum{ {{.Selector}} }[{{.Window}}])) * 60`,
+	gpuUtilizationMetric:  `avg(gpu_util_pct{ {{.Selector}} })`,
+}
+
+// DefaultLagQueries are the PromQL templates reporting the consumer lag of a
+// ToolBinding, per queue or topic provider, over the metrics of the standard
+// exporters: prometheus-nats-exporter, kafka-exporter and
+// yet-another-cloudwatch-exporter. Templates are rendered with .Name (the
+// queue or topic), .ConsumerGroup and .Namespace (the binding's namespace).
+var DefaultLagQueries = map[string]string{
+	"nats":  `sum(jetstream_consumer_num_pending{stream_name="{{.Name}}"{{if .ConsumerGroup}},consumer_name="{{.ConsumerGroup}}"{{end}}})`,
+	"kafka": `sum(kafka_consumergroup_lag{topic="{{.Name}}"{{if .ConsumerGroup}},consumergroup="{{.ConsumerGroup}}"{{end}}})`,
+	"sqs":   `max(aws_sqs_approximate_number_of_messages_visible_maximum{dimension_QueueName="{{.Name}}"})`,
+}
+
+// DefaultUsageQueries are the PromQL templates reporting a pool's peak GPU
+// usage for vertical recommendations. Templates are rendered with .Selector
+// and .Window, the observation window.
+var DefaultUsageQueries = map[string]string{
+	usageVRAM:          `max(max_over_time(gpu_vram_used_gb{ {{.Selector}} }[{{.Window}}]))`,
+	usageContextLength: `max(max_over_time(agent_ctx_len_p95{ {{.Selector}} }[{{.Window}}]))`,
+	usageBatchSize:     `max(max_over_time(agent_batch_size{ {{.Selector}} }[{{.Window}}]))`,
+}
+
+// DefaultSLOQueries are the PromQL templates reporting the share of bad
+// events of each SLO of an AgentClass. Templates are rendered with .Selector
+// (the class's label matchers), .Window and .Threshold, the bucket bound in
+// milliseconds above which a turn misses a latency objective. Bucket bounds
+// are matched with and without the trailing .0 of the OpenMetrics format.
+var DefaultSLOQueries = map[string]string{
+	SLOTTFT:         `1 - sum(rate(agent_ttft_ms_bucket{ {{.Selector}},le=~"{{.Threshold}}(\\.0)?" }[{{.Window}}])) / sum(rate(agent_ttft_ms_count{ {{.Selector}} }[{{.Window}}]))`,
+	SLOLatency:      `1 - sum(rate(agent_latency_ms_bucket{ {{.Selector}},le=~"{{.Threshold}}(\\.0)?" }[{{.Window}}])) / sum(rate(agent_latency_ms_count{ {{.Selector}} }[{{.Window}}]))`,
+	SLOAvailability: `(sum(rate(agent_turn_errors_total{ {{.Selector}} }[{{.Window}}])) or vector(0)) / sum(rate(agent_latency_ms_count{ {{.Selector}} }[{{.Window}}]))`,
+}
+
+// Canary stats query names
+const (
+	statsRequests       = "requests"
+	statsErrors         = "errors"
+	statsP95Latency     = "p95-latency"
+	statsQualityWinRate = "quality-winrate"
+)
+
+// DefaultStatsQueries are the PromQL templates reporting the requests,
+// errors, p95 latency in milliseconds and quality win rate of a pool's
+// replicas, which canaries are analyzed by. Templates are rendered with
+// .Selector and .Window, the analysis interval.
+var DefaultStatsQueries = map[string]string{
+	statsRequests:       `sum(increase(agent_latency_ms_count{ {{.Selector}} }[{{.Window}}]))`,
+	statsErrors:         `sum(increase(agent_turn_errors_total{ {{.Selector}} }[{{.Window}}])) or vector(0)`,
+	statsP95Latency:     `histogram_quantile(0.95, sum by (le) (rate(agent_latency_ms_bucket{ {{.Selector}} }[{{.Window}}])))`,
+	statsQualityWinRate: `avg(avg_over_time(agent_quality_winrate{ {{.Selector}} }[{{.Window}}]))`,
+}
+
+// PrometheusConfig configures the Prometheus metrics provider
+type PrometheusConfig struct {
+	// Address is the Prometheus server URL
+	Address string
+
+	// BearerToken is sent as an Authorization header
+	BearerToken string
+
+	// BearerTokenFile is read on every request, so rotated tokens are
+	// picked up. It takes precedence over BearerToken.
+	BearerTokenFile string
+
+	// Username and Password enable basic authentication
+	Username string
+	Password string
+
+	// Timeout bounds each query. Zero means no timeout beyond the context.
+	Timeout time.Duration
+
+	// PoolSelector is a template for the label matchers selecting a pool's
+	// series, rendered with the AgentPool. Defaults to DefaultPoolSelector.
+	// An AgentPool can override it with the metrics-selector annotation.
+	PoolSelector string
+
+	// ClassSelector is a template for the label matchers selecting an
+	// AgentClass's series, rendered with the AgentClass. Defaults to
+	// DefaultClassSelector. An AgentClass can override it with the
+	// metrics-selector annotation.
+	ClassSelector string
+
+	// Queries overrides DefaultQueries per metric type
+	Queries map[string]string
+
+	// LagQueries overrides DefaultLagQueries per provider
+	LagQueries map[string]string
+
+	// SLOQueries overrides DefaultSLOQueries per SLO
+	SLOQueries map[string]string
+
+	// StatsQueries overrides DefaultStatsQueries per statistic
+	StatsQueries map[string]string
+
+	// RoundTripper is the base transport. Defaults to http.DefaultTransport.
+	RoundTripper http.RoundTripper
+}
+
+// PrometheusMetricsProvider implements MetricsProvider by querying Prometheus
+type PrometheusMetricsProvider struct {
+	api           promv1.API
+	timeout       time.Duration
+	selector      *template.Template
+	classSelector *template.Template
+	queries       map[string]*template.Template
+	lag           map[string]*template.Template
+	usage         map[string]*template.Template
+	slo           map[string]*template.Template
+	stats         map[string]*template.Template
+}
+
+// NewPrometheusMetricsProvider creates a provider for the given config
+func NewPrometheusMetricsProvider(config PrometheusConfig) (*PrometheusMetricsProvider, error) {
+	if config.Address == "" {
+		return nil, errors.New("prometheus address is required")
+	}
+
+	base := config.RoundTripper
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	client, err := api.NewClient(api.Config{
+		Address: config.Address,
+		RoundTripper: &authRoundTripper{
+			base:      base,
+			token:     config.BearerToken,
+			tokenFile: config.BearerTokenFile,
+			username:  config.Username,
+			password:  config.Password,
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create prometheus client: %w", err)
+	}
+
+	selector := config.PoolSelector
+	if selector == "" {
+		selector = DefaultPoolSelector
+	}
+	selectorTmpl, err := template.New("selector").Parse(selector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pool selector: %w", err)
+	}
+	classSelector := config.ClassSelector
+	if classSelector == "" {
+		classSelector = DefaultClassSelector
+	}
+	classSelectorTmpl, err := template.New("class-selector").Parse(classSelector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid class selector: %w", err)
+	}
+
+	queries, err := parseQueries(DefaultQueries, config.Queries)
+	if err != nil {
+		return nil, err
+	}
+	lag, err := parseQueries(DefaultLagQueries, config.LagQueries)
+	if err != nil {
+		return nil, err
+	}
+	usage, err := parseQueries(DefaultUsageQueries, nil)
+	if err != nil {
+		return nil, err
+	}
+	slo, err := parseQueries(DefaultSLOQueries, config.SLOQueries)
+	if err != nil {
+		return nil, err
+	}
+	stats, err := parseQueries(DefaultStatsQueries, config.StatsQueries)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PrometheusMetricsProvider{
+		api:           promv1.NewAPI(client),
+		timeout:       config.Timeout,
+		selector:      selectorTmpl,
+		classSelector: classSelectorTmpl,
+		queries:       queries,
+		lag:           lag,
+		usage:         usage,
+		slo:           slo,
+		stats:         stats,
+	}, nil
+}
+
+// parseQueries parses defaults with overrides applied
+func parseQueries(defaults, overrides map[string]string) (map[string]*template.Template, error) {
+	sources := make(map[string]string, len(defaults)+len(overrides))
+	for key, query := range defaults {
+		sources[key] = query
+	}
+	for key, query := range overrides {
+		sources[key] = query
+	}
+
+	queries := make(map[string]*template.Template, len(sources))
+	for key, query := range sources {
+		tmpl, err := template.New(key).Parse(query)
+		if err != nil {
+			return nil, fmt.Errorf("invalid query for %s: %w", key, err)
+		}
+		queries[key] = tmpl
+	}
+	return queries, nil
+}
+
+// API returns the Prometheus API the provider queries, for callers issuing
+// their own queries with the provider's address and credentials
+func (p *PrometheusMetricsProvider) API() promv1.API {
+	return p.api
+}
+
+// GetMetric implements MetricsProvider
+func (p *PrometheusMetricsProvider) GetMetric(ctx context.Context, pool *neuronetes.AgentPool, metricType string) (float64, error) {
+	query, err := p.Query(pool, metricType)
+	if err != nil {
+		return 0, err
+	}
+	return p.instantQuery(ctx, query)
+}
+
+// Lag implements LagCollector
+func (p *PrometheusMetricsProvider) Lag(ctx context.Context, binding *neuronetes.ToolBinding) (float64, error) {
+	query, err := p.LagQuery(binding)
+	if err != nil {
+		return 0, err
+	}
+	return p.instantQuery(ctx, query)
+}
+
+// LagQuery renders the PromQL query for the consumer lag of binding
+func (p *PrometheusMetricsProvider) LagQuery(binding *neuronetes.ToolBinding) (string, error) {
+	var provider string
+	data := struct {
+		Name          string
+		ConsumerGroup string
+		Namespace     string
+	}{Namespace: binding.Namespace}
+	switch {
+	case binding.Spec.QueueConfig != nil:
+		provider = binding.Spec.QueueConfig.Provider
+		data.Name = binding.Spec.QueueConfig.QueueName
+	case binding.Spec.TopicConfig != nil:
+		provider = binding.Spec.TopicConfig.Provider
+		data.Name = binding.Spec.TopicConfig.TopicName
+		data.ConsumerGroup = binding.Spec.TopicConfig.ConsumerGroup
+	default:
+		return "", fmt.Errorf("binding %s has no queue or topic", binding.Name)
+	}
+
+	tmpl, ok := p.lag[provider]
+	if !ok {
+		return "", fmt.Errorf("no lag query configured for provider %s", provider)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("failed to render lag query for %s: %w", provider, err)
+	}
+	return buf.String(), nil
+}
+
+// instantQuery evaluates query and returns its single value
+func (p *PrometheusMetricsProvider) instantQuery(ctx context.Context, query string) (float64, error) {
+	if p.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.timeout)
+		defer cancel()
+	}
+
+	result, _, err := p.api.Query(ctx, query, time.Now())
+	if err != nil {
+		return 0, fmt.Errorf("prometheus query %q failed: %w", query, err)
+	}
+
+	value, err := scalarValue(result)
+	if err != nil {
+		return 0, fmt.Errorf("prometheus query %q: %w", query, err)
+	}
+	return value, nil
+}
+
+// GPUUsage implements UsageProvider. Context length and batch size are
+// optional; VRAM usage is required.
+func (p *PrometheusMetricsProvider) GPUUsage(ctx context.Context, pool *neuronetes.AgentPool, window time.Duration) (GPUUsage, error) {
+	var usage GPUUsage
+	for _, u := range []struct {
+		name     string
+		value    *float64
+		required bool
+	}{
+		{usageVRAM, &usage.PeakVRAMGB, true},
+		{usageContextLength, &usage.ContextLength, false},
+		{usageBatchSize, &usage.BatchSize, false},
+	} {
+		query, err := p.render(p.usage[u.name], pool, window)
+		if err != nil {
+			return GPUUsage{}, err
+		}
+		value, err := p.instantQuery(ctx, query)
+		if errors.Is(err, ErrNoData) && !u.required {
+			continue
+		}
+		if err != nil {
+			return GPUUsage{}, err
+		}
+		*u.value = value
+	}
+	return usage, nil
+}
+
+// PoolStats implements canary.PoolStatsProvider. Pools without requests in
+// window have no latency, and the quality win rate is optional.
+func (p *PrometheusMetricsProvider) PoolStats(ctx context.Context, pool *neuronetes.AgentPool, window time.Duration) (canary.Stats, error) {
+	values := make(map[string]float64, len(p.stats))
+	for _, name := range []string{statsRequests, statsErrors, statsP95Latency, statsQualityWinRate} {
+		query, err := p.render(p.stats[name], pool, window)
+		if err != nil {
+			return canary.Stats{}, err
+		}
+		value, err := p.instantQuery(ctx, query)
+		if errors.Is(err, ErrNoData) {
+			continue
+		}
+		if err != nil {
+			return canary.Stats{}, err
+		}
+		values[name] = value
+	}
+
+	stats := canary.Stats{
+		Requests:   int64(math.Round(values[statsRequests])),
+		Errors:     int64(math.Round(values[statsErrors])),
+		P95Latency: time.Duration(values[statsP95Latency] * float64(time.Millisecond)),
+	}
+	if rate, ok := values[statsQualityWinRate]; ok {
+		stats.QualityWinRate = &rate
+	}
+	return stats, nil
+}
+
+// Query renders the PromQL query for metricType scoped to pool
+func (p *PrometheusMetricsProvider) Query(pool *neuronetes.AgentPool, metricType string) (string, error) {
+	tmpl, ok := p.queries[metricType]
+	if !ok {
+		return "", fmt.Errorf("no query configured for metric %s", metricType)
+	}
+	return p.render(tmpl, pool, averagingWindow(pool, metricType))
+}
+
+// render renders tmpl for pool over window
+func (p *PrometheusMetricsProvider) render(tmpl *template.Template, pool *neuronetes.AgentPool, window time.Duration) (string, error) {
+	selector := pool.Annotations[neuronetes.AnnotationMetricsSelector]
+	if selector == "" {
+		var buf bytes.Buffer
+		if err := p.selector.Execute(&buf, pool); err != nil {
+			return "", fmt.Errorf("failed to render pool selector: %w", err)
+		}
+		selector = buf.String()
+	}
+
+	var buf bytes.Buffer
+	err := tmpl.Execute(&buf, struct {
+		Selector string
+		Window   string
+	}{
+		Selector: selector,
+		Window:   model.Duration(window).String(),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to render query for %s: %w", tmpl.Name(), err)
+	}
+	return buf.String(), nil
+}
+
+// ErrorRatio implements ErrorRatioProvider
+func (p *PrometheusMetricsProvider) ErrorRatio(ctx context.Context, class *neuronetes.AgentClass, objective Objective, window time.Duration) (float64, error) {
+	query, err := p.SLOQuery(class, objective, window)
+	if err != nil {
+		return 0, err
+	}
+	return p.instantQuery(ctx, query)
+}
+
+// SLOQuery renders the PromQL query for the share of bad events of
+// objective of class over window
+func (p *PrometheusMetricsProvider) SLOQuery(class *neuronetes.AgentClass, objective Objective, window time.Duration) (string, error) {
+	tmpl, ok := p.slo[objective.Name]
+	if !ok {
+		return "", fmt.Errorf("no query configured for SLO %s", objective.Name)
+	}
+
+	selector := class.Annotations[neuronetes.AnnotationMetricsSelector]
+	if selector == "" {
+		var buf bytes.Buffer
+		if err := p.classSelector.Execute(&buf, class); err != nil {
+			return "", fmt.Errorf("failed to render class selector: %w", err)
+		}
+		selector = buf.String()
+	}
+
+	var buf bytes.Buffer
+	err := tmpl.Execute(&buf, struct {
+		Selector  string
+		Window    string
+		Threshold string
+	}{
+		Selector:  selector,
+		Window:    model.Duration(window).String(),
+		Threshold: strconv.FormatFloat(objective.Threshold, 'f', -1, 64),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to render query for SLO %s: %w", objective.Name, err)
+	}
+	return buf.String(), nil
+}
+
+// averagingWindow returns the averaging window configured for metricType
+func averagingWindow(pool *neuronetes.AgentPool, metricType string) time.Duration {
+	if pool.Spec.Autoscaling != nil {
+		for _, m := range pool.Spec.Autoscaling.Metrics {
+			if m.Type == metricType && m.AveragingWindow != nil && m.AveragingWindow.Duration > 0 {
+				return m.AveragingWindow.Duration
+			}
+		}
+	}
+	return DefaultAveragingWindow
+}
+
+// scalarValue extracts a single value from an instant query result
+func scalarValue(result model.Value) (float64, error) {
+	var value float64
+	switch v := result.(type) {
+	case *model.Scalar:
+		value = float64(v.Value)
+	case model.Vector:
+		if len(v) == 0 {
+			return 0, ErrNoData
+		}
+		if len(v) > 1 {
+			return 0, fmt.Errorf("expected a single series, got %d", len(v))
+		}
+		value = float64(v[0].Value)
+	default:
+		return 0, fmt.Errorf("unsupported result type %s", result.Type())
+	}
+
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return 0, ErrNoData
+	}
+	return value, nil
+}
+
+// authRoundTripper adds bearer or basic authentication to requests
+type authRoundTripper struct {
+	base      http.RoundTripper
+	token     string
+	tokenFile string
+	username  string
+	password  string
+}
+
+func (rt *authRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	token := rt.token
+	if rt.tokenFile != "" {
+		data, err := os.ReadFile(rt.tokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read bearer token file: %w", err)
+		}
+		token = strings.TrimSpace(string(data))
+	}
+
+	if token == "" && rt.username == "" {
+		return rt.base.RoundTrip(req)
+	}
+
+	req = req.Clone(req.Context())
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else {
+		req.SetBasicAuth(rt.username, rt.password)
+	}
+	return rt.base.RoundTrip(req)
+}
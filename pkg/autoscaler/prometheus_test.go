@@ -0,0 +1,193 @@
+package autoscaler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+)
+
+// fakePrometheus answers instant queries with a fixed response body and
+// records the last query and Authorization header it received
+type fakePrometheus struct {
+	body  string
+	query string
+	auth  string
+}
+
+func (f *fakePrometheus) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	_ = r.ParseForm()
+	f.query = r.Form.Get("query")
+	f.auth = r.Header.Get("Authorization")
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write([]byte(f.body))
+}
+
+func vectorResponse(values ...string) string {
+	result := ""
+	for i, v := range values {
+		if i > 0 {
+			result += ","
+		}
+		result += `{"metric":{},"value":[1700000000,"` + v + `"]}`
+	}
+	return `{"status":"success","data":{"resultType":"vector","result":[` + result + `]}}`
+}
+
+func newPrometheusPool() *neuronetes.AgentPool {
+	return &neuronetes.AgentPool{
+		ObjectMeta: metav1.ObjectMeta{Name: "chat-pool", Namespace: "prod"},
+		Spec: neuronetes.AgentPoolSpec{
+			Autoscaling: &neuronetes.AutoscalingSpec{
+				Metrics: []neuronetes.AutoscalingMetric{
+					{Type: "ttft-p95", Target: "500ms", AveragingWindow: &metav1.Duration{Duration: 5 * time.Minute}},
+				},
+			},
+		},
+	}
+}
+
+func TestPrometheusMetricsProviderGetMetric(t *testing.T) {
+	fake := &fakePrometheus{body: vectorResponse("420.5")}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	provider, err := NewPrometheusMetricsProvider(PrometheusConfig{
+		Address:     server.URL,
+		BearerToken: "secret",
+	})
+	require.NoError(t, err)
+
+	value, err := provider.GetMetric(context.Background(), newPrometheusPool(), "ttft-p95")
+	require.NoError(t, err)
+	assert.Equal(t, 420.5, value)
+	assert.Equal(t, `histogram_quantile(0.95, sum by (le) (rate(agent_ttft_ms_bucket{ namespace="prod",pool="chat-pool" }[5m])))`, fake.query)
+	assert.Equal(t, "Bearer secret", fake.auth)
+}
+
+func TestPrometheusMetricsProviderQueries(t *testing.T) {
+	provider, err := NewPrometheusMetricsProvider(PrometheusConfig{
+		Address:      "http://prometheus:9090",
+		PoolSelector: `kubernetes_namespace="{{.Namespace}}",neuronetes_io_pool="{{.Name}}"`,
+		Queries: map[string]string{
+			"queue-depth":  `max(custom_queue{ {{.Selector}} })`,
+			"custom-depth": `sum(custom_depth{ {{.Selector}} })`,
+		},
+	})
+	require.NoError(t, err)
+	pool := newPrometheusPool()
+
+	query, err := provider.Query(pool, "queue-depth")
+	require.NoError(t, err)
+	assert.Equal(t, `max(custom_queue{ kubernetes_namespace="prod",neuronetes_io_pool="chat-pool" })`, query)
+
+	query, err = provider.Query(pool, "custom-depth")
+	require.NoError(t, err)
+	assert.Equal(t, `sum(custom_depth{ kubernetes_namespace="prod",neuronetes_io_pool="chat-pool" })`, query)
+
+	// Metrics without an averaging window use the default
+	query, err = provider.Query(pool, "tokens-per-second")
+	require.NoError(t, err)
+	assert.Contains(t, query, "[1m]")
+
+	// A pool can override the selector entirely
+	pool.Annotations = map[string]string{neuronetes.AnnotationMetricsSelector: `app="chat"`}
+	query, err = provider.Query(pool, "concurrent-sessions")
+	require.NoError(t, err)
+	assert.Equal(t, `avg(agent_active_sessions{ app="chat" })`, query)
+
+	_, err = provider.Query(pool, "unknown")
+	assert.Error(t, err)
+}
+
+func TestPrometheusMetricsProviderNoData(t *testing.T) {
+	fake := &fakePrometheus{body: vectorResponse()}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	provider, err := NewPrometheusMetricsProvider(PrometheusConfig{Address: server.URL})
+	require.NoError(t, err)
+
+	_, err = provider.GetMetric(context.Background(), newPrometheusPool(), "queue-depth")
+	assert.ErrorIs(t, err, ErrNoData)
+
+	fake.body = vectorResponse("NaN")
+	_, err = provider.GetMetric(context.Background(), newPrometheusPool(), "ttft-p95")
+	assert.ErrorIs(t, err, ErrNoData)
+
+	fake.body = vectorResponse("1", "2")
+	_, err = provider.GetMetric(context.Background(), newPrometheusPool(), "queue-depth")
+	assert.Error(t, err)
+}
+
+func TestPrometheusMetricsProviderAuth(t *testing.T) {
+	fake := &fakePrometheus{body: vectorResponse("1")}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(tokenFile, []byte("from-file\n"), 0o600))
+
+	provider, err := NewPrometheusMetricsProvider(PrometheusConfig{
+		Address:         server.URL,
+		BearerToken:     "ignored",
+		BearerTokenFile: tokenFile,
+	})
+	require.NoError(t, err)
+	_, err = provider.GetMetric(context.Background(), newPrometheusPool(), "queue-depth")
+	require.NoError(t, err)
+	assert.Equal(t, "Bearer from-file", fake.auth)
+
+	provider, err = NewPrometheusMetricsProvider(PrometheusConfig{
+		Address:  server.URL,
+		Username: "admin",
+		Password: "hunter2",
+	})
+	require.NoError(t, err)
+	_, err = provider.GetMetric(context.Background(), newPrometheusPool(), "queue-depth")
+	require.NoError(t, err)
+	assert.Equal(t, "Basic YWRtaW46aHVudGVyMg==", fake.auth)
+}
+
+func TestNewPrometheusMetricsProviderValidation(t *testing.T) {
+	_, err := NewPrometheusMetricsProvider(PrometheusConfig{})
+	assert.Error(t, err)
+
+	_, err = NewPrometheusMetricsProvider(PrometheusConfig{Address: "http://p", PoolSelector: "{{"})
+	assert.Error(t, err)
+
+	_, err = NewPrometheusMetricsProvider(PrometheusConfig{Address: "http://p", Queries: map[string]string{"x": "{{.Missing"}})
+	assert.Error(t, err)
+}
+
+func TestPrometheusMetricsProviderPoolStats(t *testing.T) {
+	fake := &fakePrometheus{body: vectorResponse("320")}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	provider, err := NewPrometheusMetricsProvider(PrometheusConfig{Address: server.URL})
+	require.NoError(t, err)
+
+	stats, err := provider.PoolStats(context.Background(), newPrometheusPool(), 5*time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, int64(320), stats.Requests)
+	assert.Equal(t, 320*time.Millisecond, stats.P95Latency)
+	require.NotNil(t, stats.QualityWinRate)
+	assert.Equal(t, `avg(avg_over_time(agent_quality_winrate{ namespace="prod",pool="chat-pool" }[5m]))`, fake.query)
+
+	// Pools without requests have empty stats
+	fake.body = vectorResponse()
+	stats, err = provider.PoolStats(context.Background(), newPrometheusPool(), 5*time.Minute)
+	require.NoError(t, err)
+	assert.Zero(t, stats.Requests)
+	assert.Nil(t, stats.QualityWinRate)
+}
@@ -0,0 +1,195 @@
+package autoscaler
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+)
+
+const (
+	// DefaultDecisionInterval is how often each pool is evaluated when the
+	// autoscaler config does not set DecisionInterval
+	DefaultDecisionInterval = 15 * time.Second
+
+	// ScalingHistoryLimit is how many scaling decisions are kept in an
+	// AgentPool's status
+	ScalingHistoryLimit = 10
+)
+
+// PoolReconciler periodically evaluates every autoscaled AgentPool and
+// applies the decision through a Scaler
+type PoolReconciler struct {
+	client.Client
+	Autoscaler *TokenAwareAutoscaler
+	Scaler     Scaler
+
+	// Recorder emits Events for scaling decisions. Optional.
+	Recorder record.EventRecorder
+
+	// DryRun records decisions for every pool without changing replicas.
+	// Individual pools opt in with the autoscaler-dry-run annotation.
+	DryRun bool
+}
+
+// +kubebuilder:rbac:groups=neuronetes.io,resources=agentpools,verbs=get;list;watch
+// +kubebuilder:rbac:groups=neuronetes.io,resources=agentpools/scale,verbs=get;update;patch
+// +kubebuilder:rbac:groups=neuronetes.io,resources=agentpools/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=neuronetes.io,resources=toolbindings,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+
+// Reconcile evaluates one AgentPool and requeues it for the next decision
+func (r *PoolReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	var pool neuronetes.AgentPool
+	if err := r.Get(ctx, req.NamespacedName, &pool); err != nil {
+		if apierrors.IsNotFound(err) {
+			r.Autoscaler.Forget(req.NamespacedName)
+		}
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if !pool.DeletionTimestamp.IsZero() || pool.Spec.Autoscaling == nil {
+		r.Autoscaler.Forget(req.NamespacedName)
+		// Pools without autoscaling are picked up again when their spec changes
+		return ctrl.Result{}, nil
+	}
+
+	interval := r.interval()
+	decision, err := r.Autoscaler.Evaluate(ctx, &pool)
+	if err != nil {
+		// Retry on the regular cadence rather than backing off quickly, so a
+		// metrics outage does not hammer the metrics backend
+		log.Error(err, "failed to evaluate autoscaling", "pool", req.NamespacedName)
+		return ctrl.Result{RequeueAfter: interval}, nil
+	}
+	for _, plugin := range decision.Plugins {
+		if plugin.Err != nil {
+			log.Error(plugin.Err, "autoscaler plugin failed", "pool", req.NamespacedName, "plugin", plugin.Plugin)
+		}
+	}
+
+	dryRun := r.DryRun || pool.Annotations[neuronetes.AnnotationAutoscalerDryRun] == "true"
+	scale := decision.DesiredReplicas != decision.CurrentReplicas &&
+		(pool.Spec.Replicas == nil || *pool.Spec.Replicas != decision.DesiredReplicas)
+	rec := r.recommendation(decision, dryRun)
+
+	// A paused pool keeps reporting its recommendation but is never scaled
+	if pool.Annotations[neuronetes.AnnotationPaused] == "true" {
+		rec.Reason = "paused: " + rec.Reason
+		scale = false
+	}
+	changed := recommendationChanged(pool.Status.Recommendation, rec)
+
+	if scale && !dryRun {
+		log.Info("Scaling agent pool",
+			"pool", req.NamespacedName,
+			"current", decision.CurrentReplicas,
+			"desired", decision.DesiredReplicas,
+			"reason", decision.Reason)
+		if err := r.Scaler.Scale(ctx, &pool, decision.DesiredReplicas); err != nil {
+			return ctrl.Result{}, err
+		}
+		r.event(&pool, "Scaled", "Scaled from %d to %d replicas: %s",
+			decision.CurrentReplicas, decision.DesiredReplicas, decision.Reason)
+	}
+	if scale && dryRun && changed {
+		log.Info("Dry run: not scaling agent pool",
+			"pool", req.NamespacedName,
+			"current", decision.CurrentReplicas,
+			"desired", decision.DesiredReplicas,
+			"reason", decision.Reason)
+		r.event(&pool, "ScalingRecommended", "Dry run: would scale from %d to %d replicas: %s",
+			decision.CurrentReplicas, decision.DesiredReplicas, decision.Reason)
+	}
+
+	// Every scale operation, and each new dry-run recommendation, goes into
+	// the audit trail
+	audit := scale && (!dryRun || changed)
+	if changed || audit {
+		if err := r.recordStatus(ctx, &pool, rec, audit); err != nil {
+			// The status is informational; keep scaling without it
+			log.Error(err, "failed to record scaling decision", "pool", req.NamespacedName)
+		}
+	}
+
+	return ctrl.Result{RequeueAfter: interval}, nil
+}
+
+// recommendation converts decision into its status form
+func (r *PoolReconciler) recommendation(decision *ScalingDecision, dryRun bool) *neuronetes.ScalingRecommendation {
+	rec := &neuronetes.ScalingRecommendation{
+		CurrentReplicas: decision.CurrentReplicas,
+		DesiredReplicas: decision.DesiredReplicas,
+		Reason:          decision.Reason,
+		DryRun:          dryRun,
+		Time:            metav1.NewTime(r.Autoscaler.now()),
+	}
+	if len(decision.Metrics) > 0 {
+		rec.Metrics = make(map[string]string, len(decision.Metrics))
+		for name, value := range decision.Metrics {
+			rec.Metrics[name] = strconv.FormatFloat(value, 'g', 6, 64)
+		}
+	}
+	return rec
+}
+
+// recommendationChanged reports whether rec recommends different replicas
+// than last. Reasons and metrics change on nearly every evaluation, so they
+// are not compared, to avoid writing status each time.
+func recommendationChanged(last, rec *neuronetes.ScalingRecommendation) bool {
+	return last == nil || last.CurrentReplicas != rec.CurrentReplicas ||
+		last.DesiredReplicas != rec.DesiredReplicas || last.DryRun != rec.DryRun
+}
+
+// recordStatus stores rec as the pool's latest recommendation and, if audit
+// is set, appends it to the scaling history
+func (r *PoolReconciler) recordStatus(ctx context.Context, pool *neuronetes.AgentPool, rec *neuronetes.ScalingRecommendation, audit bool) error {
+	patch := client.MergeFrom(pool.DeepCopy())
+	pool.Status.Recommendation = rec
+	if audit {
+		history := append(pool.Status.ScalingHistory, *rec.DeepCopy())
+		if len(history) > ScalingHistoryLimit {
+			history = history[len(history)-ScalingHistoryLimit:]
+		}
+		pool.Status.ScalingHistory = history
+	}
+	return r.Status().Patch(ctx, pool, patch)
+}
+
+func (r *PoolReconciler) event(pool *neuronetes.AgentPool, reason, messageFmt string, args ...interface{}) {
+	if r.Recorder != nil {
+		r.Recorder.Eventf(pool, corev1.EventTypeNormal, reason, messageFmt, args...)
+	}
+}
+
+func (r *PoolReconciler) interval() time.Duration {
+	if r.Autoscaler.config != nil && r.Autoscaler.config.DecisionInterval > 0 {
+		return r.Autoscaler.config.DecisionInterval
+	}
+	return DefaultDecisionInterval
+}
+
+// SetupWithManager sets up the autoscaler with the Manager. Status-only
+// updates are ignored; pools are re-evaluated on their decision interval,
+// or immediately when the activator annotates them.
+func (r *PoolReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("autoscaler").
+		For(&neuronetes.AgentPool{}, builder.WithPredicates(predicate.Or(
+			predicate.GenerationChangedPredicate{},
+			predicate.AnnotationChangedPredicate{},
+		))).
+		Complete(r)
+}
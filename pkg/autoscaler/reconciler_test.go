@@ -0,0 +1,269 @@
+package autoscaler
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	autoscalingv1 "k8s.io/api/autoscaling/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	fakescale "k8s.io/client-go/scale/fake"
+	clienttesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+)
+
+// recordingScaler records the replica counts it is asked to apply
+type recordingScaler struct {
+	calls []int32
+	err   error
+}
+
+func (s *recordingScaler) Scale(ctx context.Context, pool *neuronetes.AgentPool, replicas int32) error {
+	s.calls = append(s.calls, replicas)
+	return s.err
+}
+
+func newTestPoolReconciler(t *testing.T, provider MetricsProvider, scaler Scaler, pool *neuronetes.AgentPool) *PoolReconciler {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	require.NoError(t, neuronetes.AddToScheme(scheme))
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(pool).WithStatusSubresource(pool).Build()
+	return &PoolReconciler{
+		Client:     c,
+		Autoscaler: newTestAutoscaler(provider),
+		Scaler:     scaler,
+	}
+}
+
+func reconcileRequest(pool *neuronetes.AgentPool) ctrl.Request {
+	return ctrl.Request{NamespacedName: client.ObjectKeyFromObject(pool)}
+}
+
+func TestPoolReconcilerScalesOnDecision(t *testing.T) {
+	provider := NewMockMetricsProvider()
+	provider.SetMetric("tokens-in-queue", 200)
+	pool := newTestPool(2, neuronetes.AutoscalingMetric{Type: "tokens-in-queue", Target: "100"})
+	scaler := &recordingScaler{}
+	r := newTestPoolReconciler(t, provider, scaler, pool)
+
+	result, err := r.Reconcile(context.Background(), reconcileRequest(pool))
+	require.NoError(t, err)
+	assert.Equal(t, []int32{4}, scaler.calls)
+	assert.Equal(t, r.Autoscaler.config.DecisionInterval, result.RequeueAfter)
+}
+
+func TestPoolReconcilerSkipsUnchangedDecision(t *testing.T) {
+	provider := NewMockMetricsProvider()
+	provider.SetMetric("tokens-in-queue", 100)
+	pool := newTestPool(2, neuronetes.AutoscalingMetric{Type: "tokens-in-queue", Target: "100"})
+	scaler := &recordingScaler{}
+	r := newTestPoolReconciler(t, provider, scaler, pool)
+
+	_, err := r.Reconcile(context.Background(), reconcileRequest(pool))
+	require.NoError(t, err)
+	assert.Empty(t, scaler.calls)
+
+	// A decision already recorded in spec.replicas is not re-applied
+	provider.SetMetric("tokens-in-queue", 200)
+	var got neuronetes.AgentPool
+	require.NoError(t, r.Get(context.Background(), client.ObjectKeyFromObject(pool), &got))
+	replicas := int32(4)
+	got.Spec.Replicas = &replicas
+	require.NoError(t, r.Update(context.Background(), &got))
+
+	_, err = r.Reconcile(context.Background(), reconcileRequest(pool))
+	require.NoError(t, err)
+	assert.Empty(t, scaler.calls)
+}
+
+func TestPoolReconcilerIgnoresPoolsWithoutAutoscaling(t *testing.T) {
+	pool := newTestPool(2)
+	pool.Spec.Autoscaling = nil
+	scaler := &recordingScaler{}
+	r := newTestPoolReconciler(t, NewMockMetricsProvider(), scaler, pool)
+
+	result, err := r.Reconcile(context.Background(), reconcileRequest(pool))
+	require.NoError(t, err)
+	assert.Zero(t, result.RequeueAfter)
+	assert.Empty(t, scaler.calls)
+
+	_, err = r.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Name: "missing", Namespace: "default"}})
+	assert.NoError(t, err)
+}
+
+func TestPoolReconcilerRequeuesOnMetricsError(t *testing.T) {
+	// The mock provider has no value for the metric
+	pool := newTestPool(2, neuronetes.AutoscalingMetric{Type: "tokens-in-queue", Target: "100"})
+	scaler := &recordingScaler{}
+	r := newTestPoolReconciler(t, NewMockMetricsProvider(), scaler, pool)
+
+	result, err := r.Reconcile(context.Background(), reconcileRequest(pool))
+	require.NoError(t, err)
+	assert.Equal(t, r.Autoscaler.config.DecisionInterval, result.RequeueAfter)
+	assert.Empty(t, scaler.calls)
+}
+
+func TestPoolReconcilerReturnsScaleErrors(t *testing.T) {
+	provider := NewMockMetricsProvider()
+	provider.SetMetric("tokens-in-queue", 200)
+	pool := newTestPool(2, neuronetes.AutoscalingMetric{Type: "tokens-in-queue", Target: "100"})
+	r := newTestPoolReconciler(t, provider, &recordingScaler{err: errors.New("conflict")}, pool)
+
+	_, err := r.Reconcile(context.Background(), reconcileRequest(pool))
+	assert.Error(t, err)
+}
+
+func TestPoolReconcilerDryRunRecordsWithoutScaling(t *testing.T) {
+	provider := NewMockMetricsProvider()
+	provider.SetMetric("tokens-in-queue", 200)
+	pool := newTestPool(2, neuronetes.AutoscalingMetric{Type: "tokens-in-queue", Target: "100"})
+	pool.Annotations = map[string]string{neuronetes.AnnotationAutoscalerDryRun: "true"}
+	scaler := &recordingScaler{}
+	r := newTestPoolReconciler(t, provider, scaler, pool)
+	recorder := record.NewFakeRecorder(10)
+	r.Recorder = recorder
+
+	for i := 0; i < 2; i++ {
+		_, err := r.Reconcile(context.Background(), reconcileRequest(pool))
+		require.NoError(t, err)
+	}
+	assert.Empty(t, scaler.calls)
+
+	var got neuronetes.AgentPool
+	require.NoError(t, r.Get(context.Background(), client.ObjectKeyFromObject(pool), &got))
+	require.NotNil(t, got.Status.Recommendation)
+	assert.Equal(t, int32(2), got.Status.Recommendation.CurrentReplicas)
+	assert.Equal(t, int32(4), got.Status.Recommendation.DesiredReplicas)
+	assert.True(t, got.Status.Recommendation.DryRun)
+
+	// An unchanged recommendation is only reported once
+	require.Len(t, recorder.Events, 1)
+	assert.Contains(t, <-recorder.Events, "ScalingRecommended")
+}
+
+func TestPoolReconcilerPausedReportsWithoutScaling(t *testing.T) {
+	provider := NewMockMetricsProvider()
+	provider.SetMetric("tokens-in-queue", 200)
+	pool := newTestPool(2, neuronetes.AutoscalingMetric{Type: "tokens-in-queue", Target: "100"})
+	pool.Annotations = map[string]string{neuronetes.AnnotationPaused: "true"}
+	scaler := &recordingScaler{}
+	r := newTestPoolReconciler(t, provider, scaler, pool)
+	recorder := record.NewFakeRecorder(10)
+	r.Recorder = recorder
+
+	result, err := r.Reconcile(context.Background(), reconcileRequest(pool))
+	require.NoError(t, err)
+	assert.Equal(t, r.Autoscaler.config.DecisionInterval, result.RequeueAfter)
+	assert.Empty(t, scaler.calls)
+	assert.Empty(t, recorder.Events)
+
+	var got neuronetes.AgentPool
+	require.NoError(t, r.Get(context.Background(), client.ObjectKeyFromObject(pool), &got))
+	require.NotNil(t, got.Status.Recommendation)
+	assert.Equal(t, int32(4), got.Status.Recommendation.DesiredReplicas)
+	assert.Equal(t, "paused: scaled based on tokens-in-queue (ratio: 2.00)", got.Status.Recommendation.Reason)
+	assert.Empty(t, got.Status.ScalingHistory)
+
+	// Unpausing resumes scaling
+	delete(got.Annotations, neuronetes.AnnotationPaused)
+	require.NoError(t, r.Update(context.Background(), &got))
+	_, err = r.Reconcile(context.Background(), reconcileRequest(pool))
+	require.NoError(t, err)
+	assert.Equal(t, []int32{4}, scaler.calls)
+}
+
+func TestPoolReconcilerRecordsAppliedRecommendation(t *testing.T) {
+	provider := NewMockMetricsProvider()
+	provider.SetMetric("tokens-in-queue", 200)
+	pool := newTestPool(2, neuronetes.AutoscalingMetric{Type: "tokens-in-queue", Target: "100"})
+	scaler := &recordingScaler{}
+	r := newTestPoolReconciler(t, provider, scaler, pool)
+	r.DryRun = true
+
+	_, err := r.Reconcile(context.Background(), reconcileRequest(pool))
+	require.NoError(t, err)
+	assert.Empty(t, scaler.calls)
+
+	// Leaving dry-run applies the same recommendation
+	r.DryRun = false
+	_, err = r.Reconcile(context.Background(), reconcileRequest(pool))
+	require.NoError(t, err)
+	assert.Equal(t, []int32{4}, scaler.calls)
+
+	var got neuronetes.AgentPool
+	require.NoError(t, r.Get(context.Background(), client.ObjectKeyFromObject(pool), &got))
+	require.NotNil(t, got.Status.Recommendation)
+	assert.False(t, got.Status.Recommendation.DryRun)
+}
+
+func TestPoolReconcilerRecordsScalingAuditTrail(t *testing.T) {
+	provider := NewMockMetricsProvider()
+	provider.SetMetric("tokens-in-queue", 200)
+	pool := newTestPool(2, neuronetes.AutoscalingMetric{Type: "tokens-in-queue", Target: "100"})
+	scaler := &recordingScaler{}
+	r := newTestPoolReconciler(t, provider, scaler, pool)
+	recorder := record.NewFakeRecorder(10)
+	r.Recorder = recorder
+
+	_, err := r.Reconcile(context.Background(), reconcileRequest(pool))
+	require.NoError(t, err)
+	require.Equal(t, []int32{4}, scaler.calls)
+	require.Len(t, recorder.Events, 1)
+	assert.Equal(t, "Normal Scaled Scaled from 2 to 4 replicas: scaled based on tokens-in-queue (ratio: 2.00)", <-recorder.Events)
+
+	var got neuronetes.AgentPool
+	require.NoError(t, r.Get(context.Background(), client.ObjectKeyFromObject(pool), &got))
+	require.Len(t, got.Status.ScalingHistory, 1)
+	entry := got.Status.ScalingHistory[0]
+	assert.Equal(t, int32(2), entry.CurrentReplicas)
+	assert.Equal(t, int32(4), entry.DesiredReplicas)
+	assert.Equal(t, "200", entry.Metrics["tokens-in-queue"])
+	assert.False(t, entry.Time.IsZero())
+}
+
+func TestRecordStatusBoundsScalingHistory(t *testing.T) {
+	pool := newTestPool(2, neuronetes.AutoscalingMetric{Type: "tokens-in-queue", Target: "100"})
+	r := newTestPoolReconciler(t, NewMockMetricsProvider(), &recordingScaler{}, pool)
+
+	for i := 0; i < ScalingHistoryLimit+3; i++ {
+		rec := &neuronetes.ScalingRecommendation{CurrentReplicas: int32(i), DesiredReplicas: int32(i + 1)}
+		require.NoError(t, r.recordStatus(context.Background(), pool, rec, true))
+	}
+
+	var got neuronetes.AgentPool
+	require.NoError(t, r.Get(context.Background(), client.ObjectKeyFromObject(pool), &got))
+	require.Len(t, got.Status.ScalingHistory, ScalingHistoryLimit)
+	assert.Equal(t, int32(3), got.Status.ScalingHistory[0].CurrentReplicas)
+	assert.Equal(t, int32(ScalingHistoryLimit+2), got.Status.ScalingHistory[ScalingHistoryLimit-1].CurrentReplicas)
+}
+
+func TestSubresourceScalerPatchesScale(t *testing.T) {
+	scales := &fakescale.FakeScaleClient{}
+	var patch clienttesting.PatchAction
+	scales.AddReactor("patch", "agentpools", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		patch = action.(clienttesting.PatchAction)
+		return true, &autoscalingv1.Scale{
+			ObjectMeta: metav1.ObjectMeta{Name: patch.GetName(), Namespace: patch.GetNamespace()},
+			Spec:       autoscalingv1.ScaleSpec{Replicas: 5},
+		}, nil
+	})
+
+	scaler := &SubresourceScaler{Scales: scales}
+	require.NoError(t, scaler.Scale(context.Background(), newTestPool(2), 5))
+
+	require.NotNil(t, patch)
+	assert.Equal(t, "scale", patch.GetSubresource())
+	assert.Equal(t, "chat-pool", patch.GetName())
+	assert.Equal(t, "default", patch.GetNamespace())
+	assert.Equal(t, "neuronetes.io", patch.GetResource().Group)
+	assert.JSONEq(t, `{"spec":{"replicas":5}}`, string(patch.GetPatch()))
+}
@@ -0,0 +1,127 @@
+package autoscaler
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+)
+
+// SessionRouter exposes the per-replica sticky-session state the router
+// keeps, so that scale-down can avoid breaking active conversations
+type SessionRouter interface {
+	ActiveSessions() map[string]int
+	SetDraining(name string, draining bool)
+	MigrateSessions(name string) (int, error)
+}
+
+// ScaleDownPlan describes which replicas are removed and what happens to the
+// sticky sessions they hold
+type ScaleDownPlan struct {
+	// Victims are the replicas to remove, in removal order
+	Victims []string
+
+	// MigratedSessions is the number of sessions moved to surviving replicas
+	MigratedSessions int
+
+	// DroppedSessions is the number of sessions on victims that could not be
+	// migrated because the AgentClass keeps memory in the replica
+	DroppedSessions int
+}
+
+// ScaleDownCandidate is a replica scale-down may remove
+type ScaleDownCandidate struct {
+	Name string
+
+	// Sessions is the number of active sticky sessions on the replica
+	Sessions int
+
+	// Ready is unset for replicas that serve nothing, which go first
+	Ready bool
+
+	// Created breaks ties between replicas with as many sessions in favor
+	// of removing the youngest, which has the least warm state
+	Created time.Time
+}
+
+// SelectScaleDownVictims picks count replicas to remove: replicas that are
+// not ready first, then those with the fewest active sticky sessions, then
+// the youngest. Remaining ties are broken by name so the choice is stable
+// across evaluations.
+func SelectScaleDownVictims(candidates []ScaleDownCandidate, count int) []string {
+	if count <= 0 {
+		return nil
+	}
+
+	ordered := append([]ScaleDownCandidate(nil), candidates...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		a, b := ordered[i], ordered[j]
+		if a.Ready != b.Ready {
+			return !a.Ready
+		}
+		if a.Sessions != b.Sessions {
+			return a.Sessions < b.Sessions
+		}
+		if !a.Created.Equal(b.Created) {
+			return a.Created.After(b.Created)
+		}
+		return a.Name < b.Name
+	})
+
+	if count > len(ordered) {
+		count = len(ordered)
+	}
+	victims := make([]string, count)
+	for i := range victims {
+		victims[i] = ordered[i].Name
+	}
+	return victims
+}
+
+// MemoryExternalized reports whether the AgentClass keeps session memory
+// outside the replica, so that a session can resume on another replica
+func MemoryExternalized(class *neuronetes.AgentClass) bool {
+	if class == nil || class.Spec.MemoryConfig == nil {
+		return false
+	}
+	switch class.Spec.MemoryConfig.Type {
+	case "", "ephemeral":
+		return false
+	default:
+		return true
+	}
+}
+
+// DrainVictims drains the victims of a scale-down in router. Victims that
+// still hold sessions have those sessions migrated to surviving replicas
+// when the class externalizes memory; otherwise the sessions end with the
+// replica.
+func DrainVictims(router SessionRouter, class *neuronetes.AgentClass, victims []string) (*ScaleDownPlan, error) {
+	sessions := router.ActiveSessions()
+	plan := &ScaleDownPlan{Victims: victims}
+
+	// Drain every victim first so migrated sessions only land on survivors
+	for _, name := range victims {
+		router.SetDraining(name, true)
+	}
+
+	migrate := MemoryExternalized(class)
+	for _, name := range victims {
+		active := sessions[name]
+		if active == 0 {
+			continue
+		}
+		if !migrate {
+			plan.DroppedSessions += active
+			continue
+		}
+		moved, err := router.MigrateSessions(name)
+		plan.MigratedSessions += moved
+		if err != nil {
+			return plan, fmt.Errorf("failed to migrate sessions from %s: %w", name, err)
+		}
+	}
+
+	return plan, nil
+}
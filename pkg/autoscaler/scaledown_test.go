@@ -0,0 +1,132 @@
+package autoscaler
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/router"
+)
+
+func newSessionRouter(t *testing.T, sessionsPerReplica map[string]int) *router.Router {
+	t.Helper()
+
+	r := router.NewRouter(nil)
+	for name := range sessionsPerReplica {
+		r.UpdateReplica(router.Replica{Name: name, Ready: true})
+	}
+	// Bind sessions to specific replicas by draining every other one
+	for name, count := range sessionsPerReplica {
+		for other := range sessionsPerReplica {
+			r.SetDraining(other, other != name)
+		}
+		for i := 0; i < count; i++ {
+			rep, err := r.Route(fmt.Sprintf("%s-session-%d", name, i))
+			require.NoError(t, err)
+			require.Equal(t, name, rep.Name)
+		}
+	}
+	for name := range sessionsPerReplica {
+		r.SetDraining(name, false)
+	}
+	return r
+}
+
+func memoryClass(memoryType string) *neuronetes.AgentClass {
+	return &neuronetes.AgentClass{
+		Spec: neuronetes.AgentClassSpec{
+			MemoryConfig: &neuronetes.MemoryConfig{Type: memoryType},
+		},
+	}
+}
+
+// readyCandidates returns ready candidates of the same age with sessions
+func readyCandidates(sessions map[string]int, names ...string) []ScaleDownCandidate {
+	candidates := make([]ScaleDownCandidate, 0, len(names))
+	for _, name := range names {
+		candidates = append(candidates, ScaleDownCandidate{Name: name, Sessions: sessions[name], Ready: true})
+	}
+	return candidates
+}
+
+func TestSelectScaleDownVictimsSparesStickySessions(t *testing.T) {
+	sessions := map[string]int{"agent-a": 3, "agent-b": 0, "agent-c": 1}
+
+	victims := SelectScaleDownVictims(readyCandidates(sessions, "agent-a", "agent-b", "agent-c"), 1)
+	assert.Equal(t, []string{"agent-b"}, victims)
+
+	victims = SelectScaleDownVictims(readyCandidates(sessions, "agent-a", "agent-b", "agent-c"), 2)
+	assert.Equal(t, []string{"agent-b", "agent-c"}, victims)
+
+	assert.Nil(t, SelectScaleDownVictims(readyCandidates(sessions, "agent-a"), 0))
+	assert.Len(t, SelectScaleDownVictims(readyCandidates(sessions, "agent-a"), 5), 1)
+}
+
+func TestSelectScaleDownVictimsPrefersUnreadyAndYoung(t *testing.T) {
+	created := time.Unix(1700000000, 0)
+	candidates := []ScaleDownCandidate{
+		{Name: "agent-a", Sessions: 0, Ready: true, Created: created},
+		{Name: "agent-b", Sessions: 0, Ready: true, Created: created.Add(time.Hour)},
+		{Name: "agent-c", Sessions: 4, Ready: false, Created: created},
+	}
+
+	// Replicas that are not ready serve nothing, whatever they last reported
+	assert.Equal(t, []string{"agent-c", "agent-b", "agent-a"}, SelectScaleDownVictims(candidates, 3))
+}
+
+func TestDrainVictimsKeepsSurvivorSessions(t *testing.T) {
+	r := newSessionRouter(t, map[string]int{"agent-a": 2, "agent-b": 0})
+
+	victims := SelectScaleDownVictims(readyCandidates(r.ActiveSessions(), "agent-a", "agent-b"), 1)
+	plan, err := DrainVictims(r, memoryClass("ephemeral"), victims)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"agent-b"}, plan.Victims)
+	assert.Zero(t, plan.MigratedSessions)
+	assert.Zero(t, plan.DroppedSessions)
+
+	// The sticky replica keeps serving its sessions
+	rep, err := r.Route("agent-a-session-0")
+	require.NoError(t, err)
+	assert.Equal(t, "agent-a", rep.Name)
+}
+
+func TestDrainVictimsMigratesExternalizedMemory(t *testing.T) {
+	r := newSessionRouter(t, map[string]int{"agent-a": 2, "agent-b": 1, "agent-c": 3})
+
+	victims := SelectScaleDownVictims(readyCandidates(r.ActiveSessions(), "agent-a", "agent-b", "agent-c"), 2)
+	plan, err := DrainVictims(r, memoryClass("redis"), victims)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"agent-b", "agent-a"}, plan.Victims)
+	assert.Equal(t, 3, plan.MigratedSessions)
+	assert.Zero(t, plan.DroppedSessions)
+
+	counts := r.ActiveSessions()
+	assert.Equal(t, 6, counts["agent-c"])
+	assert.Zero(t, counts["agent-a"])
+	assert.Zero(t, counts["agent-b"])
+}
+
+func TestDrainVictimsDropsEphemeralSessions(t *testing.T) {
+	r := newSessionRouter(t, map[string]int{"agent-a": 2, "agent-b": 1})
+
+	plan, err := DrainVictims(r, memoryClass("ephemeral"), []string{"agent-b"})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"agent-b"}, plan.Victims)
+	assert.Zero(t, plan.MigratedSessions)
+	assert.Equal(t, 1, plan.DroppedSessions)
+}
+
+func TestMemoryExternalized(t *testing.T) {
+	assert.False(t, MemoryExternalized(nil))
+	assert.False(t, MemoryExternalized(&neuronetes.AgentClass{}))
+	assert.False(t, MemoryExternalized(memoryClass("ephemeral")))
+	assert.True(t, MemoryExternalized(memoryClass("redis")))
+	assert.True(t, MemoryExternalized(memoryClass("postgres")))
+}
@@ -0,0 +1,62 @@
+package autoscaler
+
+import (
+	"context"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+	"k8s.io/client-go/scale"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+)
+
+// agentPoolResource is the resource the scale subresource is patched on
+var agentPoolResource = schema.GroupVersionResource{
+	Group:    neuronetes.GroupVersion.Group,
+	Version:  neuronetes.GroupVersion.Version,
+	Resource: "agentpools",
+}
+
+// Scaler applies a scaling decision to an AgentPool
+type Scaler interface {
+	Scale(ctx context.Context, pool *neuronetes.AgentPool, replicas int32) error
+}
+
+// SubresourceScaler scales AgentPools through their scale subresource
+type SubresourceScaler struct {
+	Scales scale.ScalesGetter
+}
+
+// NewSubresourceScaler creates a SubresourceScaler for the cluster at config
+func NewSubresourceScaler(config *rest.Config) (*SubresourceScaler, error) {
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create discovery client: %w", err)
+	}
+	cached := memory.NewMemCacheClient(discoveryClient)
+	mapper := restmapper.NewDeferredDiscoveryRESTMapper(cached)
+	resolver := scale.NewDiscoveryScaleKindResolver(cached)
+
+	scales, err := scale.NewForConfig(config, mapper, dynamic.LegacyAPIPathResolverFunc, resolver)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create scale client: %w", err)
+	}
+	return &SubresourceScaler{Scales: scales}, nil
+}
+
+// Scale implements Scaler
+func (s *SubresourceScaler) Scale(ctx context.Context, pool *neuronetes.AgentPool, replicas int32) error {
+	patch := []byte(fmt.Sprintf(`{"spec":{"replicas":%d}}`, replicas))
+	_, err := s.Scales.Scales(pool.Namespace).Patch(ctx, agentPoolResource, pool.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to scale %s/%s to %d: %w", pool.Namespace, pool.Name, replicas, err)
+	}
+	return nil
+}
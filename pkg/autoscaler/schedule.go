@@ -0,0 +1,214 @@
+package autoscaler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+)
+
+// ReplicaBounds are the replica limits in effect for a pool at a point in time
+type ReplicaBounds struct {
+	Min int32
+	Max int32
+
+	// Schedules names the schedules whose windows are active
+	Schedules []string
+}
+
+// EffectiveBounds returns the replica bounds of pool at now. Active
+// schedules replace the spec bounds; when several overlap, the highest
+// minimum and the lowest maximum win, and the minimum takes precedence if
+// they conflict.
+func EffectiveBounds(pool *neuronetes.AgentPool, now time.Time) (ReplicaBounds, error) {
+	bounds := ReplicaBounds{Min: pool.Spec.MinReplicas, Max: pool.Spec.MaxReplicas}
+	if pool.Spec.Autoscaling == nil {
+		return bounds, nil
+	}
+
+	var minOverride, maxOverride *int32
+	for i, s := range pool.Spec.Autoscaling.Schedules {
+		active, err := scheduleActive(&s, now)
+		if err != nil {
+			return bounds, fmt.Errorf("schedule %s: %w", scheduleName(&s, i), err)
+		}
+		if !active {
+			continue
+		}
+		bounds.Schedules = append(bounds.Schedules, scheduleName(&s, i))
+
+		if s.MinReplicas != nil && (minOverride == nil || *s.MinReplicas > *minOverride) {
+			minOverride = s.MinReplicas
+		}
+		if s.MaxReplicas != nil && (maxOverride == nil || *s.MaxReplicas < *maxOverride) {
+			maxOverride = s.MaxReplicas
+		}
+	}
+
+	if minOverride != nil {
+		bounds.Min = *minOverride
+	}
+	if maxOverride != nil {
+		bounds.Max = *maxOverride
+	}
+	if bounds.Max < bounds.Min {
+		bounds.Max = bounds.Min
+	}
+	return bounds, nil
+}
+
+// Clamp limits replicas to the bounds
+func (b ReplicaBounds) Clamp(replicas int32) int32 {
+	if replicas < b.Min {
+		return b.Min
+	}
+	if replicas > b.Max {
+		return b.Max
+	}
+	return replicas
+}
+
+func scheduleName(s *neuronetes.ScalingSchedule, index int) string {
+	if s.Name != "" {
+		return s.Name
+	}
+	return fmt.Sprintf("#%d", index)
+}
+
+// scheduleActive reports whether the window of s is active at now
+func scheduleActive(s *neuronetes.ScalingSchedule, now time.Time) (bool, error) {
+	cron, err := parseCron(s.Schedule)
+	if err != nil {
+		return false, err
+	}
+
+	loc := time.UTC
+	if s.TimeZone != "" {
+		if loc, err = time.LoadLocation(s.TimeZone); err != nil {
+			return false, fmt.Errorf("invalid time zone %q: %w", s.TimeZone, err)
+		}
+	}
+	now = now.In(loc).Truncate(time.Minute)
+
+	if s.Duration == nil || s.Duration.Duration <= 0 {
+		return cron.matches(now), nil
+	}
+
+	// Look back for a match within the duration, one minute at a time
+	for t := now; now.Sub(t) < s.Duration.Duration; t = t.Add(-time.Minute) {
+		if cron.matches(t) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// cronSchedule is a parsed five-field cron expression
+type cronSchedule struct {
+	minute, hour, dom, month, dow map[int]bool
+
+	// domAny and dowAny record wildcard fields, which change how day of
+	// month and day of week combine
+	domAny, dowAny bool
+}
+
+func parseCron(expr string) (*cronSchedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression %q must have 5 fields", expr)
+	}
+
+	c := &cronSchedule{}
+	var err error
+	if c.minute, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, fmt.Errorf("minute: %w", err)
+	}
+	if c.hour, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, fmt.Errorf("hour: %w", err)
+	}
+	if c.dom, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, fmt.Errorf("day of month: %w", err)
+	}
+	if c.month, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, fmt.Errorf("month: %w", err)
+	}
+	if c.dow, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, fmt.Errorf("day of week: %w", err)
+	}
+	// Sunday may be written as 0 or 7
+	if c.dow[7] {
+		c.dow[0] = true
+	}
+	c.domAny = fields[2] == "*"
+	c.dowAny = fields[4] == "*"
+	return c, nil
+}
+
+// parseCronField parses a comma-separated list of values, ranges (a-b),
+// wildcards and steps (*/n, a-b/n)
+func parseCronField(field string, min, max int) (map[int]bool, error) {
+	values := make(map[int]bool)
+	for _, part := range strings.Split(field, ",") {
+		rangePart, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return nil, fmt.Errorf("invalid step in %q", part)
+			}
+			rangePart = part[:i]
+		}
+
+		lo, hi := min, max
+		switch {
+		case rangePart == "*":
+		case strings.Contains(rangePart, "-"):
+			bounds := strings.SplitN(rangePart, "-", 2)
+			var err1, err2 error
+			lo, err1 = strconv.Atoi(bounds[0])
+			hi, err2 = strconv.Atoi(bounds[1])
+			if err1 != nil || err2 != nil {
+				return nil, fmt.Errorf("invalid range %q", rangePart)
+			}
+		default:
+			v, err := strconv.Atoi(rangePart)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value %q", rangePart)
+			}
+			lo, hi = v, v
+			if step > 1 {
+				hi = max
+			}
+		}
+
+		if lo < min || hi > max || lo > hi {
+			return nil, fmt.Errorf("%q out of range %d-%d", rangePart, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			values[v] = true
+		}
+	}
+	return values, nil
+}
+
+// matches reports whether t matches the schedule. As in standard cron, when
+// both day of month and day of week are restricted, either may match.
+func (c *cronSchedule) matches(t time.Time) bool {
+	if !c.minute[t.Minute()] || !c.hour[t.Hour()] || !c.month[int(t.Month())] {
+		return false
+	}
+
+	domMatch := c.dom[t.Day()]
+	dowMatch := c.dow[int(t.Weekday())]
+	switch {
+	case c.domAny && c.dowAny:
+		return true
+	case c.domAny:
+		return dowMatch
+	case c.dowAny:
+		return domMatch
+	default:
+		return domMatch || dowMatch
+	}
+}
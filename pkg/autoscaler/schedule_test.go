@@ -0,0 +1,144 @@
+package autoscaler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+)
+
+func int32Ptr(v int32) *int32 { return &v }
+
+// monday10am is Monday 2024-01-15 10:30 UTC
+var monday10am = time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)
+
+func TestCronMatches(t *testing.T) {
+	tests := []struct {
+		expr string
+		time time.Time
+		want bool
+	}{
+		{"* * * * *", monday10am, true},
+		{"30 10 * * *", monday10am, true},
+		{"0 10 * * *", monday10am, false},
+		{"* 9-17 * * 1-5", monday10am, true},
+		{"* 9-17 * * 1-5", monday10am.AddDate(0, 0, 5), false}, // Saturday
+		{"* 9-17 * * 0,6", monday10am.AddDate(0, 0, 6), true},  // Sunday as 0
+		{"* 9-17 * * 7", monday10am.AddDate(0, 0, 6), true},    // Sunday as 7
+		{"*/15 * * * *", monday10am, true},
+		{"*/20 * * * *", monday10am, false},
+		{"10-40/10 * * * *", monday10am, true},
+		{"* * 15 1 *", monday10am, true},
+		{"* * 1 * *", monday10am, false},
+		// Day of month and day of week combine with OR when both are set
+		{"* * 1 * 1", monday10am, true},
+	}
+	for _, tt := range tests {
+		cron, err := parseCron(tt.expr)
+		require.NoError(t, err, tt.expr)
+		assert.Equal(t, tt.want, cron.matches(tt.time), "%s at %s", tt.expr, tt.time)
+	}
+}
+
+func TestParseCronRejectsInvalidExpressions(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+	} {
+		_, err := parseCron(expr)
+		assert.Error(t, err, expr)
+	}
+}
+
+func TestEffectiveBounds(t *testing.T) {
+	pool := newTestPool(2)
+	pool.Spec.MinReplicas = 2
+	pool.Spec.MaxReplicas = 10
+	pool.Spec.Autoscaling.Schedules = []neuronetes.ScalingSchedule{
+		{
+			Name:        "business-hours",
+			Schedule:    "0 9 * * 1-5",
+			Duration:    &metav1.Duration{Duration: 9 * time.Hour},
+			MinReplicas: int32Ptr(6),
+		},
+		{
+			Name:        "night",
+			Schedule:    "* 0-5 * * *",
+			MinReplicas: int32Ptr(0),
+			MaxReplicas: int32Ptr(3),
+		},
+	}
+
+	bounds, err := EffectiveBounds(pool, monday10am)
+	require.NoError(t, err)
+	assert.Equal(t, ReplicaBounds{Min: 6, Max: 10, Schedules: []string{"business-hours"}}, bounds)
+
+	// After the 9h window closes the spec bounds apply again
+	bounds, err = EffectiveBounds(pool, time.Date(2024, 1, 15, 18, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), bounds.Min)
+	assert.Empty(t, bounds.Schedules)
+
+	bounds, err = EffectiveBounds(pool, time.Date(2024, 1, 16, 3, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	assert.Equal(t, ReplicaBounds{Min: 0, Max: 3, Schedules: []string{"night"}}, bounds)
+}
+
+func TestEffectiveBoundsOverlapAndTimeZone(t *testing.T) {
+	pool := newTestPool(2)
+	pool.Spec.Autoscaling.Schedules = []neuronetes.ScalingSchedule{
+		// 10:30 UTC is 05:30 in New York
+		{Schedule: "* 5 * * *", TimeZone: "America/New_York", MinReplicas: int32Ptr(4), MaxReplicas: int32Ptr(8)},
+		{Schedule: "* * * * *", MinReplicas: int32Ptr(6), MaxReplicas: int32Ptr(5)},
+	}
+
+	bounds, err := EffectiveBounds(pool, monday10am)
+	require.NoError(t, err)
+	// Highest minimum and lowest maximum win; the minimum takes precedence
+	assert.Equal(t, int32(6), bounds.Min)
+	assert.Equal(t, int32(6), bounds.Max)
+	assert.Equal(t, []string{"#0", "#1"}, bounds.Schedules)
+
+	pool.Spec.Autoscaling.Schedules[0].TimeZone = "Mars/Olympus"
+	_, err = EffectiveBounds(pool, monday10am)
+	assert.Error(t, err)
+}
+
+func TestEvaluateAppliesScheduleMinimum(t *testing.T) {
+	provider := NewMockMetricsProvider()
+	provider.SetMetric("tokens-in-queue", 50)
+	pool := newTestPool(2, neuronetes.AutoscalingMetric{Type: "tokens-in-queue", Target: "100"})
+	pool.Spec.Autoscaling.Schedules = []neuronetes.ScalingSchedule{
+		{Name: "business-hours", Schedule: "* 9-17 * * 1-5", MinReplicas: int32Ptr(5)},
+	}
+	// Scale-up limits do not hold the pool below the scheduled minimum
+	pool.Spec.Autoscaling.Behavior = &neuronetes.ScalingBehavior{
+		ScaleUp: &neuronetes.ScalingPolicy{MaxChangeAbsolute: int32Ptr(1)},
+	}
+
+	a := newTestAutoscaler(provider)
+	a.now = func() time.Time { return monday10am }
+	decision, err := a.Evaluate(context.Background(), pool)
+	require.NoError(t, err)
+	assert.Equal(t, int32(5), decision.DesiredReplicas)
+	assert.Contains(t, decision.Reason, "business-hours")
+
+	// Outside the window the metrics scale the pool down to the spec minimum
+	a.now = func() time.Time { return monday10am.AddDate(0, 0, 5) }
+	decision, err = a.Evaluate(context.Background(), pool)
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), decision.DesiredReplicas)
+}
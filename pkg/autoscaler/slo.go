@@ -0,0 +1,276 @@
+package autoscaler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/metrics"
+)
+
+// SLO names
+const (
+	SLOTTFT         = "ttft"
+	SLOLatency      = "latency"
+	SLOAvailability = "availability"
+)
+
+const (
+	// DefaultSLOInterval is how often the burn rates of each AgentClass are
+	// recomputed
+	DefaultSLOInterval = time.Minute
+
+	// ConditionSLOCompliant reports whether an AgentClass burns the error
+	// budgets of its SLOs at a sustainable rate
+	ConditionSLOCompliant = "SLOCompliant"
+
+	// FastBurnRate is the burn rate over both the short and medium windows
+	// that spends 2% of a 30-day budget in an hour
+	FastBurnRate = 14.4
+
+	// SlowBurnRate is the burn rate over both the medium and long windows
+	// that spends 5% of a 30-day budget in six hours
+	SlowBurnRate = 6
+
+	// latencyBudget is the share of turns latency objectives allow above
+	// their target, since targets are p95s
+	latencyBudget = 0.05
+)
+
+// Burn rate windows, shortest first
+var (
+	sloShortWindow  = 5 * time.Minute
+	sloMediumWindow = time.Hour
+	sloLongWindow   = 6 * time.Hour
+
+	// SLOWindows are the windows burn rates are computed over
+	SLOWindows = []time.Duration{sloShortWindow, sloMediumWindow, sloLongWindow}
+)
+
+// Objective is an SLO of an AgentClass expressed as an error budget
+type Objective struct {
+	// Name is the SLO, e.g. ttft
+	Name string
+
+	// Threshold is the bucket bound in milliseconds above which a turn
+	// misses a latency objective
+	Threshold float64
+
+	// Budget is the share of turns allowed to miss the objective
+	Budget float64
+}
+
+// ErrorRatioProvider reports the share of turns of an AgentClass that
+// missed an objective over a window
+type ErrorRatioProvider interface {
+	ErrorRatio(ctx context.Context, class *neuronetes.AgentClass, objective Objective, window time.Duration) (float64, error)
+}
+
+// Objectives returns the objectives of class that can be measured from the
+// recorded metrics. Latency targets are rounded up to the next bound of the
+// buckets histograms records turns with, since turns are only counted per
+// bucket. histograms must match the histogram options of the processes
+// recording turns, or the thresholds are bounds no series has.
+func Objectives(class *neuronetes.AgentClass, histograms metrics.HistogramOptions) []Objective {
+	slo := class.Spec.SLO
+	if slo == nil {
+		return nil
+	}
+
+	var objectives []Objective
+	if slo.TTFT != nil && slo.TTFT.Duration > 0 {
+		objectives = append(objectives, Objective{
+			Name:      SLOTTFT,
+			Threshold: bucketBound(histograms.BucketsOf("agent_ttft_ms", metrics.TTFTBuckets), slo.TTFT.Duration),
+			Budget:    latencyBudget,
+		})
+	}
+	if slo.P95Latency != nil && slo.P95Latency.Duration > 0 {
+		objectives = append(objectives, Objective{
+			Name:      SLOLatency,
+			Threshold: bucketBound(histograms.BucketsOf("agent_latency_ms", metrics.LatencyBuckets), slo.P95Latency.Duration),
+			Budget:    latencyBudget,
+		})
+	}
+	if budget, ok := AvailabilityBudget(slo); ok {
+		objectives = append(objectives, Objective{Name: SLOAvailability, Budget: budget})
+	}
+	return objectives
+}
+
+// AvailabilityBudget returns the share of turns slo allows to fail, if it
+// sets an availability target below 100%. A 100% target leaves no budget to
+// burn.
+func AvailabilityBudget(slo *neuronetes.ServiceLevelObjective) (float64, bool) {
+	if slo == nil || slo.AvailabilityPercent == nil {
+		return 0, false
+	}
+	// Round to float32 precision, so 99.9 leaves a budget of 0.001
+	pct, err := strconv.ParseFloat(strconv.FormatFloat(float64(*slo.AvailabilityPercent), 'g', -1, 32), 64)
+	if err != nil || pct <= 0 || pct >= 100 {
+		return 0, false
+	}
+	return 1 - pct/100, true
+}
+
+// bucketBound returns the smallest of buckets at or above target, or the
+// largest when target is beyond them all
+func bucketBound(buckets []float64, target time.Duration) float64 {
+	ms := float64(target) / float64(time.Millisecond)
+	i := sort.SearchFloat64s(buckets, ms)
+	if i == len(buckets) {
+		i--
+	}
+	return buckets[i]
+}
+
+// burnRates are the burn rates of an objective by window
+type burnRates map[time.Duration]float64
+
+// exceeds reports whether the burn rates over both windows exceed threshold
+func (b burnRates) exceeds(threshold float64, long, short time.Duration) bool {
+	l, lok := b[long]
+	s, sok := b[short]
+	return lok && sok && l > threshold && s > threshold
+}
+
+// sloCondition returns the SLOCompliant condition of the burn rates of each
+// objective. Following the multi-window alerts of the Google SRE workbook,
+// a budget is burning fast when both the 1h and 5m burn rates exceed
+// FastBurnRate, and slowly when both the 6h and 1h ones exceed SlowBurnRate;
+// the short window clears the condition soon after the burn stops.
+func sloCondition(objectives []Objective, rates map[string]burnRates) metav1.Condition {
+	var fast, slow []string
+	for _, objective := range objectives {
+		b := rates[objective.Name]
+		switch {
+		case b.exceeds(FastBurnRate, sloMediumWindow, sloShortWindow):
+			fast = append(fast, fmt.Sprintf("%s burning %.1fx over 1h and %.1fx over 5m",
+				objective.Name, b[sloMediumWindow], b[sloShortWindow]))
+		case b.exceeds(SlowBurnRate, sloLongWindow, sloMediumWindow):
+			slow = append(slow, fmt.Sprintf("%s burning %.1fx over 6h and %.1fx over 1h",
+				objective.Name, b[sloLongWindow], b[sloMediumWindow]))
+		}
+	}
+
+	switch {
+	case len(fast) > 0:
+		return metav1.Condition{Type: ConditionSLOCompliant, Status: metav1.ConditionFalse,
+			Reason: "FastBurn", Message: strings.Join(append(fast, slow...), "; ")}
+	case len(slow) > 0:
+		return metav1.Condition{Type: ConditionSLOCompliant, Status: metav1.ConditionFalse,
+			Reason: "SlowBurn", Message: strings.Join(slow, "; ")}
+	case len(rates) == 0:
+		return metav1.Condition{Type: ConditionSLOCompliant, Status: metav1.ConditionUnknown,
+			Reason: "NoData", Message: "No turns observed"}
+	}
+	return metav1.Condition{Type: ConditionSLOCompliant, Status: metav1.ConditionTrue,
+		Reason: "WithinBudget", Message: "Error budgets are burning at a sustainable rate"}
+}
+
+// SLOEvaluator periodically computes the error budget burn rates of the
+// SLOs of each AgentClass over SLOWindows, publishes them as the
+// error_budget_burn_rate gauge and reports them in the SLOCompliant
+// condition of the class.
+type SLOEvaluator struct {
+	client.Client
+	ErrorRatios ErrorRatioProvider
+
+	// Metrics publishes the burn rates. Optional.
+	Metrics *metrics.AgentMetrics
+
+	// Interval is how often each class is re-evaluated
+	Interval time.Duration
+
+	// Histograms are the histogram options turns are recorded with, whose
+	// buckets latency objectives are measured at
+	Histograms metrics.HistogramOptions
+}
+
+// Reconcile evaluates the SLOs of one AgentClass
+func (r *SLOEvaluator) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	var class neuronetes.AgentClass
+	if err := r.Get(ctx, req.NamespacedName, &class); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	objectives := Objectives(&class, r.Histograms)
+	if !class.DeletionTimestamp.IsZero() || len(objectives) == 0 {
+		return ctrl.Result{}, nil
+	}
+
+	interval := r.Interval
+	if interval <= 0 {
+		interval = DefaultSLOInterval
+	}
+
+	rates := make(map[string]burnRates)
+	for _, objective := range objectives {
+		for _, window := range SLOWindows {
+			ratio, err := r.ErrorRatios.ErrorRatio(ctx, &class, objective, window)
+			if errors.Is(err, ErrNoData) {
+				// No turns in the window
+				continue
+			}
+			if err != nil {
+				log.Error(err, "failed to observe SLO", "agentClass", req.NamespacedName, "slo", objective.Name)
+				return ctrl.Result{RequeueAfter: interval}, nil
+			}
+
+			rate := ratio / objective.Budget
+			if rates[objective.Name] == nil {
+				rates[objective.Name] = make(burnRates)
+			}
+			rates[objective.Name][window] = rate
+			if r.Metrics != nil {
+				r.Metrics.RecordBurnRate(ctx, class.Namespace, class.Name, objective.Name, window, rate)
+			}
+		}
+	}
+
+	condition := sloCondition(objectives, rates)
+	condition.ObservedGeneration = class.Generation
+	if last := meta.FindStatusCondition(class.Status.Conditions, ConditionSLOCompliant); last != nil &&
+		last.Status == condition.Status && last.Reason == condition.Reason && last.Message == condition.Message &&
+		last.ObservedGeneration == condition.ObservedGeneration {
+		return ctrl.Result{RequeueAfter: interval}, nil
+	}
+
+	patch := client.MergeFrom(class.DeepCopy())
+	meta.SetStatusCondition(&class.Status.Conditions, condition)
+	if err := r.Status().Patch(ctx, &class, patch); err != nil {
+		if apierrors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+	log.Info("Evaluated SLOs",
+		"agentClass", req.NamespacedName,
+		"status", condition.Status,
+		"reason", condition.Reason,
+		"message", condition.Message)
+	return ctrl.Result{RequeueAfter: interval}, nil
+}
+
+// SetupWithManager sets up the evaluator with the Manager
+func (r *SLOEvaluator) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("slo-evaluator").
+		For(&neuronetes.AgentClass{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Complete(r)
+}
@@ -0,0 +1,185 @@
+package autoscaler
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/metrics"
+)
+
+func newSLOClass() *neuronetes.AgentClass {
+	availability := float32(99.9)
+	return &neuronetes.AgentClass{
+		ObjectMeta: metav1.ObjectMeta{Name: "chat-agent", Namespace: "prod", Generation: 1},
+		Spec: neuronetes.AgentClassSpec{
+			SLO: &neuronetes.ServiceLevelObjective{
+				TTFT:                &metav1.Duration{Duration: 400 * time.Millisecond},
+				P95Latency:          &metav1.Duration{Duration: 2 * time.Second},
+				AvailabilityPercent: &availability,
+			},
+		},
+	}
+}
+
+// staticErrorRatios reports fixed error ratios by SLO and window
+type staticErrorRatios map[string]map[time.Duration]float64
+
+func (s staticErrorRatios) ErrorRatio(ctx context.Context, class *neuronetes.AgentClass, objective Objective, window time.Duration) (float64, error) {
+	ratio, ok := s[objective.Name][window]
+	if !ok {
+		return 0, ErrNoData
+	}
+	return ratio, nil
+}
+
+func TestObjectives(t *testing.T) {
+	objectives := Objectives(newSLOClass(), metrics.HistogramOptions{})
+	require.Len(t, objectives, 3)
+	// Targets are rounded up to bucket bounds
+	assert.Equal(t, Objective{Name: SLOTTFT, Threshold: 500, Budget: 0.05}, objectives[0])
+	assert.Equal(t, Objective{Name: SLOLatency, Threshold: 2500, Budget: 0.05}, objectives[1])
+	assert.Equal(t, SLOAvailability, objectives[2].Name)
+	assert.InDelta(t, 0.001, objectives[2].Budget, 1e-12)
+
+	// Targets beyond the buckets use the largest, and a 100% availability
+	// target has no budget
+	class := newSLOClass()
+	class.Spec.SLO.TTFT.Duration = time.Minute
+	full := float32(100)
+	class.Spec.SLO.AvailabilityPercent = &full
+	objectives = Objectives(class, metrics.HistogramOptions{})
+	require.Len(t, objectives, 2)
+	assert.Equal(t, 5000.0, objectives[0].Threshold)
+
+	class.Spec.SLO = nil
+	assert.Empty(t, Objectives(class, metrics.HistogramOptions{}))
+}
+
+func TestObjectivesUseOverriddenBuckets(t *testing.T) {
+	histograms := metrics.HistogramOptions{Buckets: map[string][]float64{
+		"agent_ttft_ms":    {25, 50, 100, 250, 450, 800},
+		"agent_latency_ms": {1000, 3000, 6000},
+	}}
+	objectives := Objectives(newSLOClass(), histograms)
+	require.Len(t, objectives, 3)
+	assert.Equal(t, 450.0, objectives[0].Threshold)
+	assert.Equal(t, 3000.0, objectives[1].Threshold)
+
+	// The evaluator measures its objectives at the bounds turns are
+	// recorded with
+	var measured []float64
+	ratios := errorRatioFunc(func(objective Objective) { measured = append(measured, objective.Threshold) })
+	class := newSLOClass()
+	scheme := runtime.NewScheme()
+	require.NoError(t, neuronetes.AddToScheme(scheme))
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(class).WithStatusSubresource(class).Build()
+	r := &SLOEvaluator{Client: c, ErrorRatios: ratios, Histograms: histograms}
+	_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKeyFromObject(class)})
+	require.NoError(t, err)
+	assert.Contains(t, measured, 450.0)
+	assert.Contains(t, measured, 3000.0)
+}
+
+// errorRatioFunc observes the objectives it is asked for, with no turns
+type errorRatioFunc func(objective Objective)
+
+func (f errorRatioFunc) ErrorRatio(ctx context.Context, class *neuronetes.AgentClass, objective Objective, window time.Duration) (float64, error) {
+	f(objective)
+	return 0, ErrNoData
+}
+
+func TestSLOEvaluatorReportsBurnRates(t *testing.T) {
+	ctx := context.Background()
+	class := newSLOClass()
+	scheme := runtime.NewScheme()
+	require.NoError(t, neuronetes.AddToScheme(scheme))
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(class).WithStatusSubresource(class).Build()
+	ratios := staticErrorRatios{}
+	m := metrics.NewAgentMetrics(prometheus.NewRegistry())
+	r := &SLOEvaluator{Client: c, ErrorRatios: ratios, Metrics: m, Interval: time.Minute}
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(class)}
+
+	condition := func() *metav1.Condition {
+		var got neuronetes.AgentClass
+		require.NoError(t, c.Get(ctx, req.NamespacedName, &got))
+		return meta.FindStatusCondition(got.Status.Conditions, ConditionSLOCompliant)
+	}
+
+	// Without turns the budgets are unknown
+	result, err := r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	assert.Equal(t, time.Minute, result.RequeueAfter)
+	require.NotNil(t, condition())
+	assert.Equal(t, metav1.ConditionUnknown, condition().Status)
+	assert.Equal(t, "NoData", condition().Reason)
+
+	// 1% of turns over the TTFT target burns its 5% budget at 0.2x
+	ratios[SLOTTFT] = map[time.Duration]float64{5 * time.Minute: 0.01, time.Hour: 0.01, 6 * time.Hour: 0.01}
+	ratios[SLOAvailability] = map[time.Duration]float64{5 * time.Minute: 0, time.Hour: 0.0005}
+	_, err = r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	assert.Equal(t, metav1.ConditionTrue, condition().Status)
+	assert.Equal(t, "WithinBudget", condition().Reason)
+	assert.InDelta(t, 0.2, testutil.ToFloat64(m.ErrorBudgetBurnRate.WithLabelValues("prod", "chat-agent", SLOTTFT, "1h")), 1e-9)
+	assert.InDelta(t, 0.5, testutil.ToFloat64(m.ErrorBudgetBurnRate.WithLabelValues("prod", "chat-agent", SLOAvailability, "1h")), 1e-9)
+
+	// A sustained burn over 6h and 1h is slow
+	ratios[SLOTTFT] = map[time.Duration]float64{5 * time.Minute: 0.02, time.Hour: 0.4, 6 * time.Hour: 0.35}
+	_, err = r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	assert.Equal(t, metav1.ConditionFalse, condition().Status)
+	assert.Equal(t, "SlowBurn", condition().Reason)
+	assert.Equal(t, "ttft burning 7.0x over 6h and 8.0x over 1h", condition().Message)
+
+	// A burn over 1h that continues over 5m is fast
+	ratios[SLOAvailability] = map[time.Duration]float64{5 * time.Minute: 0.05, time.Hour: 0.02, 6 * time.Hour: 0.005}
+	_, err = r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	assert.Equal(t, "FastBurn", condition().Reason)
+	assert.Equal(t, "availability burning 20.0x over 1h and 50.0x over 5m; ttft burning 7.0x over 6h and 8.0x over 1h", condition().Message)
+
+	// Once the short window recovers the fast burn clears
+	ratios[SLOAvailability][5*time.Minute] = 0
+	_, err = r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	assert.Equal(t, "SlowBurn", condition().Reason)
+}
+
+func TestPrometheusMetricsProviderSLOQueries(t *testing.T) {
+	fake := &fakePrometheus{body: vectorResponse("0.02")}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	provider, err := NewPrometheusMetricsProvider(PrometheusConfig{Address: server.URL})
+	require.NoError(t, err)
+	class := newSLOClass()
+	objectives := Objectives(class, metrics.HistogramOptions{})
+
+	ratio, err := provider.ErrorRatio(context.Background(), class, objectives[0], time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 0.02, ratio)
+	assert.Equal(t, `1 - sum(rate(agent_ttft_ms_bucket{ namespace="prod",agent_class="chat-agent",le=~"500(\\.0)?" }[1h])) / sum(rate(agent_ttft_ms_count{ namespace="prod",agent_class="chat-agent" }[1h]))`, fake.query)
+
+	// A class can override the selector
+	class.Annotations = map[string]string{neuronetes.AnnotationMetricsSelector: `app="chat"`}
+	query, err := provider.SLOQuery(class, objectives[2], 5*time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, `(sum(rate(agent_turn_errors_total{ app="chat" }[5m])) or vector(0)) / sum(rate(agent_latency_ms_count{ app="chat" }[5m]))`, query)
+
+	_, err = provider.SLOQuery(class, Objective{Name: "unknown"}, time.Hour)
+	assert.Error(t, err)
+}
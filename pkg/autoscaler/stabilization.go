@@ -0,0 +1,107 @@
+package autoscaler
+
+import (
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+)
+
+// recommendation is a replica count recommended by one evaluation
+type recommendation struct {
+	at       time.Time
+	replicas int32
+}
+
+// stabilize records desired as the latest recommendation for pool and
+// returns the stabilized replica count. As with the HorizontalPodAutoscaler,
+// a scale-down goes no lower than the highest recommendation within the
+// scale-down window, and a scale-up goes no higher than the lowest
+// recommendation within the scale-up window, so a pool oscillating between
+// two recommendations holds its current size.
+func (a *TokenAwareAutoscaler) stabilize(pool *neuronetes.AgentPool, now time.Time, current, desired int32) int32 {
+	upWindow, downWindow := a.stabilizationWindows(pool)
+	retain := upWindow
+	if downWindow > retain {
+		retain = downWindow
+	}
+
+	key := types.NamespacedName{Namespace: pool.Namespace, Name: pool.Name}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	history := append(a.history[key], recommendation{at: now, replicas: desired})
+	kept := history[:0]
+	for _, r := range history {
+		if now.Sub(r.at) <= retain {
+			kept = append(kept, r)
+		}
+	}
+	a.history[key] = kept
+
+	upLimit, downLimit := desired, desired
+	for _, r := range kept {
+		age := now.Sub(r.at)
+		if age <= upWindow && r.replicas < upLimit {
+			upLimit = r.replicas
+		}
+		if age <= downWindow && r.replicas > downLimit {
+			downLimit = r.replicas
+		}
+	}
+
+	stabilized := current
+	if stabilized < upLimit {
+		stabilized = upLimit
+	}
+	if stabilized > downLimit {
+		stabilized = downLimit
+	}
+	return stabilized
+}
+
+// stabilizationWindows returns the scale-up and scale-down windows of pool.
+// Scale-down falls back to the autoscaler's StabilizationWindow; scale-up is
+// immediate unless configured.
+func (a *TokenAwareAutoscaler) stabilizationWindows(pool *neuronetes.AgentPool) (up, down time.Duration) {
+	if a.config != nil {
+		down = a.config.StabilizationWindow
+	}
+	if behavior := pool.Spec.Autoscaling.Behavior; behavior != nil {
+		if behavior.ScaleUp != nil && behavior.ScaleUp.StabilizationWindow != nil {
+			up = behavior.ScaleUp.StabilizationWindow.Duration
+		}
+		if behavior.ScaleDown != nil && behavior.ScaleDown.StabilizationWindow != nil {
+			down = behavior.ScaleDown.StabilizationWindow.Duration
+		}
+	}
+	return up, down
+}
+
+// inCooldown reports whether pool was scaled within its cooldown period, and
+// if so how long ago
+func inCooldown(pool *neuronetes.AgentPool, now time.Time) (bool, time.Duration) {
+	cooldown := pool.Spec.Autoscaling.CooldownPeriod
+	if cooldown == nil || cooldown.Duration <= 0 || pool.Status.LastScaleTime == nil {
+		return false, 0
+	}
+	since := now.Sub(pool.Status.LastScaleTime.Time)
+	return since < cooldown.Duration, since
+}
+
+// cooldownReason describes a decision held by the cooldown period
+func cooldownReason(pool *neuronetes.AgentPool, since time.Duration) string {
+	return fmt.Sprintf("cooldown: last scaled %s ago, cooldown period %s",
+		since.Round(time.Second), pool.Spec.Autoscaling.CooldownPeriod.Duration)
+}
+
+// Forget drops the recommendation history of a pool, for example once it
+// has been deleted
+func (a *TokenAwareAutoscaler) Forget(pool types.NamespacedName) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.history, pool)
+}
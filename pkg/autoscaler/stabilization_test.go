@@ -0,0 +1,108 @@
+package autoscaler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+)
+
+// evaluateAt evaluates pool at now with the given queue load
+func evaluateAt(t *testing.T, a *TokenAwareAutoscaler, provider *MockMetricsProvider, pool *neuronetes.AgentPool, now time.Time, load float64) *ScalingDecision {
+	t.Helper()
+	provider.SetMetric("tokens-in-queue", load)
+	a.now = func() time.Time { return now }
+	decision, err := a.Evaluate(context.Background(), pool)
+	require.NoError(t, err)
+	return decision
+}
+
+func TestStabilizationSuppressesFlapping(t *testing.T) {
+	provider := NewMockMetricsProvider()
+	a := newTestAutoscaler(provider)
+	pool := newTestPool(6, neuronetes.AutoscalingMetric{Type: "tokens-in-queue", Target: "100"})
+	window := metav1.Duration{Duration: 2 * time.Minute}
+	pool.Spec.Autoscaling.Behavior = &neuronetes.ScalingBehavior{
+		ScaleUp:   &neuronetes.ScalingPolicy{StabilizationWindow: &window},
+		ScaleDown: &neuronetes.ScalingPolicy{StabilizationWindow: &window},
+	}
+
+	// Raw recommendations alternate between 4 and 9 every evaluation
+	start := time.Unix(1700000000, 0)
+	loads := []float64{100 * 4.0 / 6, 100 * 9.0 / 6}
+	var got []int32
+	for i := 0; i < 8; i++ {
+		d := evaluateAt(t, a, provider, pool, start.Add(time.Duration(i)*15*time.Second), loads[i%2])
+		got = append(got, d.DesiredReplicas)
+	}
+
+	// The first evaluation has no history; after that the pool holds
+	assert.Equal(t, int32(4), got[0])
+	for _, replicas := range got[1:] {
+		assert.Equal(t, int32(6), replicas)
+	}
+}
+
+func TestStabilizationScaleDownWaitsForWindow(t *testing.T) {
+	provider := NewMockMetricsProvider()
+	a := newTestAutoscaler(provider)
+	pool := newTestPool(8, neuronetes.AutoscalingMetric{Type: "tokens-in-queue", Target: "100"})
+	start := time.Unix(1700000000, 0)
+
+	// High load recorded, then load drops; the default window is a minute
+	assert.Equal(t, int32(8), evaluateAt(t, a, provider, pool, start, 100).DesiredReplicas)
+
+	d := evaluateAt(t, a, provider, pool, start.Add(30*time.Second), 50)
+	assert.Equal(t, int32(8), d.DesiredReplicas)
+	assert.Contains(t, d.Reason, "stabilized")
+
+	d = evaluateAt(t, a, provider, pool, start.Add(90*time.Second), 50)
+	assert.Equal(t, int32(4), d.DesiredReplicas)
+
+	// Scale-up is immediate by default
+	d = evaluateAt(t, a, provider, pool, start.Add(100*time.Second), 150)
+	assert.Equal(t, int32(10), d.DesiredReplicas)
+}
+
+func TestCooldownHoldsReplicas(t *testing.T) {
+	provider := NewMockMetricsProvider()
+	a := newTestAutoscaler(provider)
+	pool := newTestPool(4, neuronetes.AutoscalingMetric{Type: "tokens-in-queue", Target: "100"})
+	start := time.Unix(1700000000, 0)
+	pool.Spec.Autoscaling.CooldownPeriod = &metav1.Duration{Duration: 3 * time.Minute}
+	pool.Status.LastScaleTime = &metav1.Time{Time: start}
+
+	d := evaluateAt(t, a, provider, pool, start.Add(time.Minute), 200)
+	assert.Equal(t, int32(4), d.DesiredReplicas)
+	assert.Contains(t, d.Reason, "cooldown")
+
+	// Schedules are hard limits and still apply during cooldown
+	pool.Spec.Autoscaling.Schedules = []neuronetes.ScalingSchedule{{
+		Name: "always", Schedule: "* * * * *", MinReplicas: int32Ptr(6),
+	}}
+	d = evaluateAt(t, a, provider, pool, start.Add(time.Minute), 200)
+	assert.Equal(t, int32(6), d.DesiredReplicas)
+
+	pool.Spec.Autoscaling.Schedules = nil
+	d = evaluateAt(t, a, provider, pool, start.Add(3*time.Minute), 200)
+	assert.Equal(t, int32(8), d.DesiredReplicas)
+}
+
+func TestForgetDropsHistory(t *testing.T) {
+	provider := NewMockMetricsProvider()
+	a := newTestAutoscaler(provider)
+	pool := newTestPool(8, neuronetes.AutoscalingMetric{Type: "tokens-in-queue", Target: "100"})
+	start := time.Unix(1700000000, 0)
+
+	evaluateAt(t, a, provider, pool, start, 100)
+	a.Forget(types.NamespacedName{Namespace: pool.Namespace, Name: pool.Name})
+
+	d := evaluateAt(t, a, provider, pool, start.Add(time.Second), 50)
+	assert.Equal(t, int32(4), d.DesiredReplicas)
+}
@@ -0,0 +1,97 @@
+package autoscaler
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// targetUnit is the kind of value a metric's target is written in
+type targetUnit int
+
+const (
+	// unitAny accepts every kind, for metric types added through query
+	// overrides
+	unitAny targetUnit = iota
+	unitDuration
+	unitQuantity
+	unitPercent
+)
+
+func (u targetUnit) String() string {
+	switch u {
+	case unitDuration:
+		return "a duration such as 500ms"
+	case unitQuantity:
+		return "a number or quantity such as 2k"
+	case unitPercent:
+		return "a percentage such as 75%"
+	default:
+		return "a number, duration, percentage or quantity"
+	}
+}
+
+// metricTargetUnits is the unit of each built-in metric type. Latency
+// metrics are reported in milliseconds; the rest are counts or rates.
+var metricTargetUnits = map[string]targetUnit{
+	"ttft-p95":            unitDuration,
+	"ttft-p99":            unitDuration,
+	"tokens-in-queue":     unitQuantity,
+	"concurrent-sessions": unitQuantity,
+	"tokens-per-second":   unitQuantity,
+	"queue-depth":         unitQuantity,
+	"context-length":      unitQuantity,
+	"tool-call-rate":      unitQuantity,
+}
+
+// ParseMetricTarget parses the target of a metric of metricType into the
+// units the metric is reported in. Durations such as 500ms become
+// milliseconds, percentages such as 75% become 75, and quantities such as 2k
+// or 32Ki their value. Plain numbers are accepted for every metric type.
+func ParseMetricTarget(metricType, target string) (float64, error) {
+	target = strings.TrimSpace(target)
+	unit := metricTargetUnits[metricType]
+
+	value, err := parseTarget(unit, target)
+	if err != nil {
+		return 0, fmt.Errorf("invalid target %q for %s: expected %s", target, metricType, unit)
+	}
+	if math.IsNaN(value) || math.IsInf(value, 0) || value <= 0 {
+		return 0, fmt.Errorf("invalid target %q for %s: must be positive", target, metricType)
+	}
+	return value, nil
+}
+
+func parseTarget(unit targetUnit, target string) (float64, error) {
+	if value, err := strconv.ParseFloat(target, 64); err == nil {
+		return value, nil
+	}
+
+	if number, ok := strings.CutSuffix(target, "%"); ok {
+		if unit != unitPercent && unit != unitAny {
+			return 0, fmt.Errorf("percentage not allowed")
+		}
+		return strconv.ParseFloat(strings.TrimSpace(number), 64)
+	}
+
+	if unit == unitDuration || unit == unitAny {
+		if d, err := time.ParseDuration(target); err == nil {
+			return float64(d) / float64(time.Millisecond), nil
+		} else if unit == unitDuration {
+			return 0, err
+		}
+	}
+
+	if unit == unitQuantity || unit == unitAny {
+		q, err := resource.ParseQuantity(target)
+		if err != nil {
+			return 0, err
+		}
+		return q.AsApproximateFloat64(), nil
+	}
+	return 0, fmt.Errorf("unsupported unit")
+}
@@ -0,0 +1,126 @@
+package autoscaler
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apiextensions-apiserver/pkg/apis/apiextensions"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	structuralschema "k8s.io/apiextensions-apiserver/pkg/apiserver/schema"
+	"k8s.io/apiextensions-apiserver/pkg/apiserver/schema/cel"
+	apiservervalidation "k8s.io/apiextensions-apiserver/pkg/apiserver/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	celconfig "k8s.io/apiserver/pkg/apis/cel"
+	"sigs.k8s.io/yaml"
+)
+
+func TestParseMetricTarget(t *testing.T) {
+	tests := []struct {
+		metricType string
+		target     string
+		want       float64
+	}{
+		{"tokens-in-queue", "1000", 1000},
+		{"tokens-in-queue", "2k", 2000},
+		{"context-length", "32Ki", 32768},
+		{"tokens-per-second", "1.5e2", 150},
+		{"ttft-p95", "500ms", 500},
+		{"ttft-p95", "1.5s", 1500},
+		{"ttft-p95", "800", 800},
+		{"ttft-p99", " 2s ", 2000},
+		{"custom-utilization", "75%", 75},
+		{"custom-size", "2Gi", 2 << 30},
+		{"custom-latency", "1m", 60000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.metricType+"/"+tt.target, func(t *testing.T) {
+			got, err := ParseMetricTarget(tt.metricType, tt.target)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestParseMetricTargetRejectsWrongUnits(t *testing.T) {
+	for _, tt := range []struct {
+		metricType string
+		target     string
+	}{
+		{"ttft-p95", "2Gi"},
+		{"ttft-p95", "75%"},
+		{"tokens-in-queue", "500ms"},
+		{"queue-depth", "50%"},
+		{"queue-depth", ""},
+		{"queue-depth", "0"},
+		{"queue-depth", "-5"},
+		{"queue-depth", "NaN"},
+		{"custom", "fast"},
+	} {
+		_, err := ParseMetricTarget(tt.metricType, tt.target)
+		assert.Error(t, err, "%s %q", tt.metricType, tt.target)
+	}
+}
+
+// TestAgentPoolSchemaMatchesParseMetricTarget keeps the validation of
+// metric targets by the AgentPool CRD in step with ParseMetricTarget: the
+// API server accepts exactly the targets the autoscaler parses
+func TestAgentPoolSchemaMatchesParseMetricTarget(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("..", "..", "config", "crd", "neuronetes.io_agentpools.yaml"))
+	require.NoError(t, err)
+	var crd apiextensionsv1.CustomResourceDefinition
+	require.NoError(t, yaml.Unmarshal(data, &crd))
+	require.NotEmpty(t, crd.Spec.Versions)
+	metric := crd.Spec.Versions[0].Schema.OpenAPIV3Schema.
+		Properties["spec"].Properties["autoscaling"].Properties["metrics"].Items.Schema
+
+	var internal apiextensions.JSONSchemaProps
+	require.NoError(t, apiextensionsv1.Convert_v1_JSONSchemaProps_To_apiextensions_JSONSchemaProps(metric, &internal, nil))
+	structural, err := structuralschema.NewStructural(&internal)
+	require.NoError(t, err)
+	schemaValidator, _, err := apiservervalidation.NewSchemaValidator(&internal)
+	require.NoError(t, err)
+	celValidator := cel.NewValidator(structural, false, celconfig.PerCallLimit)
+	admitted := func(metricType, target string) field.ErrorList {
+		obj := map[string]interface{}{"type": metricType, "target": target}
+		errs := apiservervalidation.ValidateCustomResource(nil, obj, schemaValidator)
+		celErrs, _ := celValidator.Validate(context.Background(), nil, structural, obj, nil, celconfig.RuntimeCELCostBudget)
+		return append(errs, celErrs...)
+	}
+
+	for _, tt := range []struct {
+		metricType string
+		target     string
+	}{
+		{"tokens-in-queue", "1000"},
+		{"tokens-in-queue", "2k"},
+		{"context-length", "32Ki"},
+		{"tokens-per-second", "1.5e2"},
+		{"tool-call-rate", "3"},
+		{"ttft-p95", "500ms"},
+		{"ttft-p95", "800"},
+		{"ttft-p99", "2s"},
+		{"custom-utilization", "75%"},
+		{"custom-size", "2Gi"},
+		{"custom-latency", "1m"},
+		{"ttft-p95", "2Gi"},
+		{"ttft-p99", "75%"},
+		{"tokens-in-queue", "500ms"},
+		{"queue-depth", "50%"},
+		{"queue-depth", "0"},
+		{"queue-depth", "-5"},
+		{"custom", "fast"},
+		{"custom", "0%"},
+	} {
+		_, parseErr := ParseMetricTarget(tt.metricType, tt.target)
+		errs := admitted(tt.metricType, tt.target)
+		assert.Equal(t, parseErr == nil, len(errs) == 0,
+			"%s %q: parser error %v, schema errors %v", tt.metricType, tt.target, parseErr, errs)
+	}
+
+	// Types follow the names of metrics
+	assert.NotEmpty(t, admitted("Custom_Type", "1"))
+}
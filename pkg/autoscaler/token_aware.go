@@ -2,16 +2,30 @@ package autoscaler
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
+	"sync"
 	"time"
 
+	"k8s.io/apimachinery/pkg/types"
+
 	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/plugins"
 )
 
 // TokenAwareAutoscaler implements token-based autoscaling
 type TokenAwareAutoscaler struct {
 	metricsProvider MetricsProvider
 	config          *AutoscalerConfig
+	backpressure    BackpressureReporter
+	lag             LagSource
+	plugins         *plugins.PluginRegistry
+	now             func() time.Time
+
+	// history holds recent recommendations per pool for stabilization
+	mu      sync.Mutex
+	history map[types.NamespacedName][]recommendation
 }
 
 // AutoscalerConfig defines autoscaler configuration
@@ -22,7 +36,8 @@ type AutoscalerConfig struct {
 	// Decision interval
 	DecisionInterval time.Duration
 
-	// Stabilization window
+	// StabilizationWindow is the default scale-down stabilization window,
+	// used when a pool's behavior does not set one
 	StabilizationWindow time.Duration
 }
 
@@ -31,85 +46,256 @@ type MetricsProvider interface {
 	GetMetric(ctx context.Context, pool *neuronetes.AgentPool, metricType string) (float64, error)
 }
 
+// BackpressureReporter reports how many of a pool's ready replicas are
+// currently backpressured
+type BackpressureReporter interface {
+	Backpressure(ctx context.Context, pool *neuronetes.AgentPool) (backpressured, ready int, err error)
+}
+
 // NewTokenAwareAutoscaler creates a new autoscaler
 func NewTokenAwareAutoscaler(provider MetricsProvider, config *AutoscalerConfig) *TokenAwareAutoscaler {
 	return &TokenAwareAutoscaler{
 		metricsProvider: provider,
 		config:          config,
+		now:             time.Now,
+		history:         make(map[types.NamespacedName][]recommendation),
 	}
 }
 
+// SetBackpressureReporter enables scale-up when every replica of a pool is
+// backpressured, regardless of the configured metrics
+func (a *TokenAwareAutoscaler) SetBackpressureReporter(reporter BackpressureReporter) {
+	a.backpressure = reporter
+}
+
 // ScalingDecision represents an autoscaling decision
 type ScalingDecision struct {
 	CurrentReplicas int32
 	DesiredReplicas int32
 	Reason          string
 	Metrics         map[string]float64
+
+	// Plugins holds the recommendation of each autoscaler plugin, highest
+	// priority first
+	Plugins []PluginRecommendation
 }
 
 // Evaluate calculates desired replicas for an AgentPool
 func (a *TokenAwareAutoscaler) Evaluate(ctx context.Context, pool *neuronetes.AgentPool) (*ScalingDecision, error) {
-	if pool.Spec.Autoscaling == nil || len(pool.Spec.Autoscaling.Metrics) == 0 {
+	noAutoscaling := &ScalingDecision{
+		CurrentReplicas: pool.Status.Replicas,
+		DesiredReplicas: pool.Status.Replicas,
+		Reason:          "no autoscaling configured",
+	}
+	if pool.Spec.Autoscaling == nil ||
+		(len(pool.Spec.Autoscaling.Metrics) == 0 && a.lag == nil && len(a.autoscalerPlugins()) == 0) {
+		return noAutoscaling, nil
+	}
+
+	now := a.now()
+	bounds, err := EffectiveBounds(pool, now)
+	if err != nil {
+		return nil, fmt.Errorf("invalid scaling schedule: %w", err)
+	}
+
+	// A scaled-to-zero pool exports no metrics, so requests held by the
+	// activator are the only signal to bring it back
+	pending := activationPending(pool, now)
+	if pool.Status.Replicas == 0 && pending {
 		return &ScalingDecision{
-			CurrentReplicas: pool.Status.Replicas,
-			DesiredReplicas: pool.Status.Replicas,
-			Reason:          "no autoscaling configured",
+			CurrentReplicas: 0,
+			DesiredReplicas: activationReplicas(bounds),
+			Reason:          "activation requested",
 		}, nil
 	}
 
 	// Collect metrics
 	metrics := make(map[string]float64)
-	var maxRatio float64
-	var primaryMetric string
+	ratios := make([]metricRatio, 0, len(pool.Spec.Autoscaling.Metrics))
 
-	for _, metric := range pool.Spec.Autoscaling.Metrics {
+	for i := range pool.Spec.Autoscaling.Metrics {
+		metric := &pool.Spec.Autoscaling.Metrics[i]
 		value, err := a.metricsProvider.GetMetric(ctx, pool, metric.Type)
-		if err != nil {
+		// A pool at zero has no replicas exporting metrics; no data is no load
+		if err != nil && !(pool.Status.Replicas == 0 && errors.Is(err, ErrNoData)) {
 			return nil, fmt.Errorf("failed to get metric %s: %w", metric.Type, err)
 		}
 
 		metrics[metric.Type] = value
 
-		// Parse target
-		target, err := parseMetricTarget(metric.Target)
+		target, err := ParseMetricTarget(metric.Type, metric.Target)
 		if err != nil {
-			return nil, fmt.Errorf("invalid target for %s: %w", metric.Type, err)
+			return nil, err
 		}
 
-		// Calculate ratio
-		ratio := value / target
-		if ratio > maxRatio {
-			maxRatio = ratio
-			primaryMetric = metric.Type
-		}
+		ratios = append(ratios, metricRatio{
+			metricType: metric.Type,
+			ratio:      value / target,
+			weight:     metricWeight(metric),
+			tolerance:  metricTolerance(metric),
+		})
+	}
+
+	// Queue and topic bindings scaling on lag act as further metrics
+	lagRatios, err := a.lagRatios(ctx, pool, metrics)
+	if err != nil {
+		return nil, err
+	}
+	ratios = append(ratios, lagRatios...)
+
+	// Plugins recommend replica counts of their own
+	pluginResults := a.runPlugins(ctx, pool, metrics)
+	if len(ratios) == 0 && len(pluginResults) == 0 {
+		return noAutoscaling, nil
+	}
+
+	ratio, primaryMetric, err := aggregateRatios(pool.Spec.Autoscaling.Aggregation, ratios)
+	if err != nil {
+		return nil, err
+	}
+
+	// Keep the pool's aggregate throughput within its budget
+	budgetMax, perReplica, budgeted, err := a.budgetReplicas(ctx, pool, metrics)
+	if err != nil {
+		return nil, err
+	}
+	if budgeted {
+		metrics["budget-replicas"] = float64(budgetMax)
+		bounds = capToBudget(bounds, budgetMax)
 	}
 
 	// Calculate desired replicas
 	currentReplicas := pool.Status.Replicas
-	desiredReplicas := int32(float64(currentReplicas) * maxRatio)
+	// Metrics within their tolerance of the target do not scale the pool
+	scaleRatio, _, _ := aggregateRatios(pool.Spec.Autoscaling.Aggregation, applyTolerance(ratios))
 
-	// Apply min/max bounds
-	if desiredReplicas < pool.Spec.MinReplicas {
-		desiredReplicas = pool.Spec.MinReplicas
+	desiredReplicas := int32(float64(currentReplicas) * scaleRatio)
+	reason := fmt.Sprintf("scaled based on %s (ratio: %.2f)", primaryMetric, ratio)
+	if scaleRatio == 1 && ratio != 1 {
+		reason = fmt.Sprintf("%s within tolerance of target (ratio: %.2f)", primaryMetric, ratio)
 	}
-	if desiredReplicas > pool.Spec.MaxReplicas {
-		desiredReplicas = pool.Spec.MaxReplicas
+	if len(ratios) == 0 {
+		desiredReplicas = currentReplicas
+		reason = "no recommendation from autoscaler plugins"
 	}
 
-	// Apply scaling policies
-	desiredReplicas = a.applyScalingPolicies(pool, currentReplicas, desiredReplicas)
+	// Keep headroom below target for SLO-critical pools
+	if minHeadroom := pool.Spec.Autoscaling.MinHeadroomPercent; minHeadroom != nil {
+		headroom := SLOHeadroomPercent(ratio)
+		if headroom < float64(*minHeadroom) {
+			needed := ReplicasForHeadroom(currentReplicas, ratio, *minHeadroom)
+			if needed > desiredReplicas {
+				desiredReplicas = needed
+				reason = fmt.Sprintf("headroom on %s at %.0f%% below minimum %d%%", primaryMetric, headroom, *minHeadroom)
+			}
+		}
+	}
 
-	reason := fmt.Sprintf("scaled based on %s (ratio: %.2f)", primaryMetric, maxRatio)
+	// Combine the recommendations of the plugins with the metrics'
+	if len(pluginResults) > 0 {
+		combined, pluginReason, err := combineRecommendations(pool.Spec.Autoscaling.PluginPolicy, desiredReplicas, len(ratios) > 0, pluginResults)
+		if err != nil {
+			return nil, err
+		}
+		if pluginReason != "" {
+			reason = pluginReason
+		}
+		desiredReplicas = combined
+	}
+
+	// A fully backpressured pool cannot absorb more load; add a replica
+	if a.backpressure != nil {
+		backpressured, ready, err := a.backpressure.Backpressure(ctx, pool)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get backpressure: %w", err)
+		}
+		metrics["backpressured-replicas"] = float64(backpressured)
+		if ready > 0 && backpressured == ready && desiredReplicas <= currentReplicas {
+			desiredReplicas = currentReplicas + 1
+			reason = fmt.Sprintf("all %d replicas backpressured", ready)
+		}
+	}
+
+	// A queue spike far past its target is scaled for all at once
+	burst, bursting := burstRatio(pool, ratios)
+	if bursting {
+		if needed := burstReplicas(currentReplicas, burst); needed > desiredReplicas {
+			desiredReplicas = needed
+		}
+		reason = fmt.Sprintf("burst on %s at %.1fx target", burst.metricType, burst.ratio)
+	}
+
+	// Apply min/max bounds, as overridden by active schedules
+	if len(bounds.Schedules) > 0 && desiredReplicas < bounds.Min {
+		reason = fmt.Sprintf("schedule %s requires at least %d replicas", strings.Join(bounds.Schedules, ","), bounds.Min)
+	}
+	if budgeted && desiredReplicas > bounds.Max && bounds.Max == budgetMax {
+		reason = fmt.Sprintf("capped at %d replicas by tokens-per-second budget %d (%.0f tokens/s per replica)",
+			budgetMax, *pool.Spec.TokensPerSecondBudget, perReplica)
+	}
+	desiredReplicas = bounds.Clamp(desiredReplicas)
+	bursting = bursting && desiredReplicas > currentReplicas
+
+	// Damp oscillating recommendations, then hold the pool during cooldown.
+	// A burst skips both, but is still recorded so the scale-down after it
+	// is stabilized as usual.
+	stabilized := a.stabilize(pool, now, currentReplicas, desiredReplicas)
+	if !bursting {
+		if stabilized != desiredReplicas {
+			desiredReplicas = stabilized
+			reason = fmt.Sprintf("stabilized at %d replicas (%s)", stabilized, reason)
+		}
+		if cooling, since := inCooldown(pool, now); cooling && desiredReplicas != currentReplicas {
+			desiredReplicas = currentReplicas
+			reason = cooldownReason(pool, since)
+		}
+
+		// Apply scaling policies. Bounds are hard limits, so re-apply them in
+		// case a rate limit or cooldown held the pool outside its window's
+		// bounds.
+		if limited := a.applyScalingPolicies(pool, now, currentReplicas, desiredReplicas); limited != desiredReplicas {
+			desiredReplicas = limited
+			reason = fmt.Sprintf("rate limited to %d replicas (%s)", limited, reason)
+		}
+		desiredReplicas = bounds.Clamp(desiredReplicas)
+	}
+
+	// Keep the GPUs of the remaining replicas below their utilization ceiling
+	guarded, utilization, err := a.guardGPUUtilization(ctx, pool, metrics, currentReplicas, desiredReplicas)
+	if err != nil {
+		return nil, err
+	}
+	if guarded != desiredReplicas {
+		desiredReplicas = bounds.Clamp(guarded)
+		reason = fmt.Sprintf("scale-down limited to %d replicas: GPU utilization %.0f%% would exceed the %d%% ceiling",
+			desiredReplicas, utilization, *pool.Spec.Autoscaling.MaxGPUUtilizationPercent)
+	}
+
+	// Never scale to zero while there is demand. Percentage limits cannot
+	// move a pool off zero, so this is applied after the policies.
+	if desiredReplicas == 0 && (ratio > 0 || pending) {
+		desiredReplicas = activationReplicas(bounds)
+		if currentReplicas == 0 {
+			reason = fmt.Sprintf("activating from zero on %s", primaryMetric)
+		} else {
+			reason = "activation requested"
+		}
+	}
 
 	return &ScalingDecision{
 		CurrentReplicas: currentReplicas,
 		DesiredReplicas: desiredReplicas,
 		Reason:          reason,
 		Metrics:         metrics,
+		Plugins:         pluginResults,
 	}, nil
 }
 
-func (a *TokenAwareAutoscaler) applyScalingPolicies(pool *neuronetes.AgentPool, current, desired int32) int32 {
+// applyScalingPolicies limits the change from current to desired. As with
+// the HorizontalPodAutoscaler, limits are relative to the replica count at
+// the start of the policy's period, so several scales within one period
+// share its budget.
+func (a *TokenAwareAutoscaler) applyScalingPolicies(pool *neuronetes.AgentPool, now time.Time, current, desired int32) int32 {
 	if pool.Spec.Autoscaling.Behavior == nil {
 		return desired
 	}
@@ -119,51 +305,76 @@ func (a *TokenAwareAutoscaler) applyScalingPolicies(pool *neuronetes.AgentPool,
 	// Scale up
 	if desired > current {
 		if behavior.ScaleUp != nil {
+			start := periodStartReplicas(pool, behavior.ScaleUp, now, current)
+
 			// Apply max change limits
 			if behavior.ScaleUp.MaxChangeAbsolute != nil {
-				maxIncrease := current + *behavior.ScaleUp.MaxChangeAbsolute
+				maxIncrease := start + *behavior.ScaleUp.MaxChangeAbsolute
 				if desired > maxIncrease {
 					desired = maxIncrease
 				}
 			}
 
 			if behavior.ScaleUp.MaxChangePercent != nil {
-				maxIncrease := int32(float64(current) * (1.0 + float64(*behavior.ScaleUp.MaxChangePercent)/100.0))
+				maxIncrease := int32(float64(start) * (1.0 + float64(*behavior.ScaleUp.MaxChangePercent)/100.0))
 				if desired > maxIncrease {
 					desired = maxIncrease
 				}
 			}
+
+			// The period's budget may already be spent
+			if desired < current {
+				desired = current
+			}
 		}
 	}
 
 	// Scale down
 	if desired < current {
 		if behavior.ScaleDown != nil {
+			start := periodStartReplicas(pool, behavior.ScaleDown, now, current)
+
 			// Apply max change limits
 			if behavior.ScaleDown.MaxChangeAbsolute != nil {
-				maxDecrease := current - *behavior.ScaleDown.MaxChangeAbsolute
+				maxDecrease := start - *behavior.ScaleDown.MaxChangeAbsolute
 				if desired < maxDecrease {
 					desired = maxDecrease
 				}
 			}
 
 			if behavior.ScaleDown.MaxChangePercent != nil {
-				maxDecrease := int32(float64(current) * (1.0 - float64(*behavior.ScaleDown.MaxChangePercent)/100.0))
+				maxDecrease := int32(float64(start) * (1.0 - float64(*behavior.ScaleDown.MaxChangePercent)/100.0))
 				if desired < maxDecrease {
 					desired = maxDecrease
 				}
 			}
+
+			if desired > current {
+				desired = current
+			}
 		}
 	}
 
 	return desired
 }
 
-func parseMetricTarget(target string) (float64, error) {
-	// Simple parser - in production, handle units properly
-	var value float64
-	_, err := fmt.Sscanf(target, "%f", &value)
-	return value, err
+// periodStartReplicas returns the replica count pool had at the start of
+// policy's period, by undoing the scales recorded in its scaling history
+// since. Without a period it is the current count.
+func periodStartReplicas(pool *neuronetes.AgentPool, policy *neuronetes.ScalingPolicy, now time.Time, current int32) int32 {
+	if policy.PeriodSeconds == nil || *policy.PeriodSeconds <= 0 {
+		return current
+	}
+	period := time.Duration(*policy.PeriodSeconds) * time.Second
+
+	start := current
+	for _, scale := range pool.Status.ScalingHistory {
+		if scale.DryRun || now.Sub(scale.Time.Time) > period {
+			continue
+		}
+		start -= scale.DesiredReplicas - scale.CurrentReplicas
+	}
+	return start
 }
 
 // MockMetricsProvider for testing
@@ -0,0 +1,195 @@
+package autoscaler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+)
+
+func newTestPool(current int32, metrics ...neuronetes.AutoscalingMetric) *neuronetes.AgentPool {
+	return &neuronetes.AgentPool{
+		ObjectMeta: metav1.ObjectMeta{Name: "chat-pool", Namespace: "default"},
+		Spec: neuronetes.AgentPoolSpec{
+			MinReplicas: 1,
+			MaxReplicas: 10,
+			Autoscaling: &neuronetes.AutoscalingSpec{Metrics: metrics},
+		},
+		Status: neuronetes.AgentPoolStatus{Replicas: current},
+	}
+}
+
+func newTestAutoscaler(provider MetricsProvider) *TokenAwareAutoscaler {
+	return NewTokenAwareAutoscaler(provider, &AutoscalerConfig{
+		MetricsInterval:     10 * time.Second,
+		DecisionInterval:    30 * time.Second,
+		StabilizationWindow: time.Minute,
+	})
+}
+
+type staticBackpressure struct {
+	backpressured, ready int
+}
+
+func (s staticBackpressure) Backpressure(ctx context.Context, pool *neuronetes.AgentPool) (int, int, error) {
+	return s.backpressured, s.ready, nil
+}
+
+func TestEvaluateScalesUpWhenAllReplicasBackpressured(t *testing.T) {
+	provider := NewMockMetricsProvider()
+	provider.SetMetric("tokens-in-queue", 50)
+	pool := newTestPool(3, neuronetes.AutoscalingMetric{Type: "tokens-in-queue", Target: "100"})
+
+	scaler := newTestAutoscaler(provider)
+	scaler.SetBackpressureReporter(staticBackpressure{backpressured: 3, ready: 3})
+
+	decision, err := scaler.Evaluate(context.Background(), pool)
+	require.NoError(t, err)
+	assert.Equal(t, int32(4), decision.DesiredReplicas)
+	assert.Contains(t, decision.Reason, "backpressured")
+	assert.Equal(t, 3.0, decision.Metrics["backpressured-replicas"])
+}
+
+func TestEvaluatePartialBackpressureDoesNotScale(t *testing.T) {
+	provider := NewMockMetricsProvider()
+	provider.SetMetric("tokens-in-queue", 100)
+	pool := newTestPool(3, neuronetes.AutoscalingMetric{Type: "tokens-in-queue", Target: "100"})
+
+	scaler := newTestAutoscaler(provider)
+	scaler.SetBackpressureReporter(staticBackpressure{backpressured: 2, ready: 3})
+
+	decision, err := scaler.Evaluate(context.Background(), pool)
+	require.NoError(t, err)
+	assert.Equal(t, int32(3), decision.DesiredReplicas)
+}
+
+func TestEvaluateMinHeadroomScalesEarlierThanRatio(t *testing.T) {
+	metric := neuronetes.AutoscalingMetric{Type: "tokens-in-queue", Target: "100"}
+	headroom := int32(20)
+
+	provider := NewMockMetricsProvider()
+	ratioOnly := newTestAutoscaler(provider)
+	withHeadroom := newTestAutoscaler(provider)
+
+	// Load climbs toward the target; headroom shrinks each step
+	var ratioFirstScale, headroomFirstScale float64
+	for _, load := range []float64{50, 70, 85, 95, 110, 130} {
+		provider.SetMetric("tokens-in-queue", load)
+
+		plain := newTestPool(4, metric)
+		d, err := ratioOnly.Evaluate(context.Background(), plain)
+		require.NoError(t, err)
+		if ratioFirstScale == 0 && d.DesiredReplicas > 4 {
+			ratioFirstScale = load
+		}
+
+		slo := newTestPool(4, metric)
+		slo.Spec.Autoscaling.MinHeadroomPercent = &headroom
+		d, err = withHeadroom.Evaluate(context.Background(), slo)
+		require.NoError(t, err)
+		if headroomFirstScale == 0 && d.DesiredReplicas > 4 {
+			headroomFirstScale = load
+			assert.Contains(t, d.Reason, "headroom")
+		}
+	}
+
+	require.NotZero(t, ratioFirstScale)
+	require.NotZero(t, headroomFirstScale)
+	assert.Less(t, headroomFirstScale, ratioFirstScale)
+	assert.Equal(t, 85.0, headroomFirstScale)
+}
+
+func TestEvaluateMinHeadroomTakesLargerDesired(t *testing.T) {
+	provider := NewMockMetricsProvider()
+	provider.SetMetric("tokens-in-queue", 200)
+	headroom := int32(10)
+	pool := newTestPool(2, neuronetes.AutoscalingMetric{Type: "tokens-in-queue", Target: "100"})
+	pool.Spec.Autoscaling.MinHeadroomPercent = &headroom
+
+	decision, err := newTestAutoscaler(provider).Evaluate(context.Background(), pool)
+	require.NoError(t, err)
+	// ratio alone gives 4; keeping 10% headroom needs ceil(2*2/0.9) = 5
+	assert.Equal(t, int32(5), decision.DesiredReplicas)
+}
+
+func TestReplicasForHeadroom(t *testing.T) {
+	assert.Equal(t, int32(5), ReplicasForHeadroom(4, 0.85, 20))
+	assert.Equal(t, int32(4), ReplicasForHeadroom(4, 0.8, 20))
+	assert.Equal(t, int32(0), ReplicasForHeadroom(0, 0.9, 20))
+	assert.InDelta(t, 15.0, SLOHeadroomPercent(0.85), 1e-9)
+}
+
+// noDataProvider reports no series for every metric, as Prometheus does for
+// a pool with no replicas
+type noDataProvider struct{}
+
+func (noDataProvider) GetMetric(ctx context.Context, pool *neuronetes.AgentPool, metricType string) (float64, error) {
+	return 0, ErrNoData
+}
+
+func TestEvaluateActivatesFromZeroOnRequest(t *testing.T) {
+	pool := newTestPool(0, neuronetes.AutoscalingMetric{Type: "tokens-in-queue", Target: "100"})
+	pool.Spec.MinReplicas = 0
+	a := newTestAutoscaler(noDataProvider{})
+	now := time.Unix(1700000000, 0)
+	a.now = func() time.Time { return now }
+
+	// Without demand a pool at zero stays there, even with no metrics
+	decision, err := a.Evaluate(context.Background(), pool)
+	require.NoError(t, err)
+	assert.Equal(t, int32(0), decision.DesiredReplicas)
+
+	pool.Annotations = map[string]string{
+		neuronetes.AnnotationActivationRequested: now.Add(-time.Second).Format(time.RFC3339),
+	}
+	decision, err = a.Evaluate(context.Background(), pool)
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), decision.DesiredReplicas)
+	assert.Equal(t, "activation requested", decision.Reason)
+
+	// Stale activation requests are ignored
+	now = now.Add(ActivationWindow)
+	decision, err = a.Evaluate(context.Background(), pool)
+	require.NoError(t, err)
+	assert.Equal(t, int32(0), decision.DesiredReplicas)
+}
+
+func TestEvaluateActivatesFromZeroOnMetrics(t *testing.T) {
+	provider := NewMockMetricsProvider()
+	provider.SetMetric("tokens-in-queue", 30)
+	pool := newTestPool(0, neuronetes.AutoscalingMetric{Type: "tokens-in-queue", Target: "100"})
+	pool.Spec.MinReplicas = 0
+	percent := int32(100)
+	pool.Spec.Autoscaling.Behavior = &neuronetes.ScalingBehavior{
+		ScaleUp: &neuronetes.ScalingPolicy{MaxChangePercent: &percent},
+	}
+
+	decision, err := newTestAutoscaler(provider).Evaluate(context.Background(), pool)
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), decision.DesiredReplicas)
+	assert.Contains(t, decision.Reason, "activating from zero")
+}
+
+func TestEvaluateKeepsReplicaWhileActivationPending(t *testing.T) {
+	provider := NewMockMetricsProvider()
+	provider.SetMetric("tokens-in-queue", 0)
+	pool := newTestPool(1, neuronetes.AutoscalingMetric{Type: "tokens-in-queue", Target: "100"})
+	pool.Spec.MinReplicas = 0
+	a := newTestAutoscaler(provider)
+
+	decision, err := a.Evaluate(context.Background(), pool)
+	require.NoError(t, err)
+	assert.Equal(t, int32(0), decision.DesiredReplicas)
+
+	pool.Annotations = map[string]string{
+		neuronetes.AnnotationActivationRequested: a.now().Format(time.RFC3339),
+	}
+	decision, err = a.Evaluate(context.Background(), pool)
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), decision.DesiredReplicas)
+}
@@ -0,0 +1,47 @@
+package autoscaler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+)
+
+// gpuUtilizationMetric is the metric type of the average GPU utilization of
+// a pool's replicas, in percent
+const gpuUtilizationMetric = "gpu-utilization"
+
+// guardGPUUtilization limits a scale-down from current to desired replicas
+// so that the load of the removed replicas, spread over the remaining ones,
+// keeps their average GPU utilization at or below the pool's
+// maxGPUUtilizationPercent. Token-based ratios do not see compute saturation,
+// so without it a scale-down can push the remaining GPUs off a latency cliff.
+// It returns the guarded replica count and the current utilization.
+func (a *TokenAwareAutoscaler) guardGPUUtilization(ctx context.Context, pool *neuronetes.AgentPool, metrics map[string]float64, current, desired int32) (int32, float64, error) {
+	ceiling := pool.Spec.Autoscaling.MaxGPUUtilizationPercent
+	if ceiling == nil || *ceiling <= 0 || desired >= current {
+		return desired, 0, nil
+	}
+
+	utilization, err := a.metricsProvider.GetMetric(ctx, pool, gpuUtilizationMetric)
+	if errors.Is(err, ErrNoData) {
+		// Nothing to protect without GPU metrics
+		return desired, 0, nil
+	}
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to get metric %s: %w", gpuUtilizationMetric, err)
+	}
+	metrics[gpuUtilizationMetric] = utilization
+
+	// The fewest replicas that keep the same work under the ceiling
+	needed := int32(math.Ceil(utilization * float64(current) / float64(*ceiling)))
+	if needed <= desired {
+		return desired, 0, nil
+	}
+	if needed > current {
+		needed = current
+	}
+	return needed, utilization, nil
+}
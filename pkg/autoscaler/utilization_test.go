@@ -0,0 +1,55 @@
+package autoscaler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+)
+
+func TestGPUUtilizationGuardsScaleDown(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	tests := []struct {
+		name        string
+		utilization float64
+		want        int32
+	}{
+		// 8 replicas at 60% would run 4 replicas at 120%; 6 stay at 80%
+		{"limited", 60, 6},
+		// 8 replicas at 40% fit in 4 at 80%
+		{"allowed", 40, 4},
+		// Already above the ceiling, no replica may go
+		{"held", 90, 8},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := NewMockMetricsProvider()
+			provider.SetMetric(gpuUtilizationMetric, tt.utilization)
+			pool := newTestPool(8, neuronetes.AutoscalingMetric{Type: "tokens-in-queue", Target: "100"})
+			pool.Spec.Autoscaling.MaxGPUUtilizationPercent = int32Ptr(85)
+			a := newTestAutoscaler(provider)
+			decision := evaluateAt(t, a, provider, pool, now, 50)
+			assert.Equal(t, tt.want, decision.DesiredReplicas)
+			assert.Equal(t, tt.utilization, decision.Metrics[gpuUtilizationMetric])
+			if tt.want != 4 {
+				assert.Contains(t, decision.Reason, "GPU utilization")
+			}
+		})
+	}
+}
+
+func TestGPUUtilizationGuardIgnoresScaleUp(t *testing.T) {
+	// GPU utilization is not even read when scaling up
+	provider := NewMockMetricsProvider()
+	provider.SetMetric("tokens-in-queue", 200)
+	pool := newTestPool(2, neuronetes.AutoscalingMetric{Type: "tokens-in-queue", Target: "100"})
+	pool.Spec.Autoscaling.MaxGPUUtilizationPercent = int32Ptr(85)
+
+	decision, err := newTestAutoscaler(provider).Evaluate(context.Background(), pool)
+	require.NoError(t, err)
+	assert.Equal(t, int32(4), decision.DesiredReplicas)
+}
@@ -0,0 +1,291 @@
+package autoscaler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+)
+
+const (
+	// DefaultRecommendationWindow is how far back GPU usage is observed
+	DefaultRecommendationWindow = 24 * time.Hour
+
+	// DefaultRecommendationInterval is how often GPU sizing is recomputed
+	DefaultRecommendationInterval = 15 * time.Minute
+
+	// DefaultVRAMMargin is the headroom added above peak VRAM usage
+	DefaultVRAMMargin = 0.15
+
+	// defaultGPUMemoryGiB is assumed for GPU types of unknown memory
+	defaultGPUMemoryGiB = 80
+)
+
+// Usage query names
+const (
+	usageVRAM          = "vram-used-gb"
+	usageContextLength = "context-length"
+	usageBatchSize     = "batch-size"
+)
+
+// gpuMemoryGiB is the memory of common GPU types
+var gpuMemoryGiB = map[string]float64{
+	"A100":      80,
+	"A100-40GB": 40,
+	"A30":       24,
+	"A10G":      24,
+	"H100":      80,
+	"H200":      141,
+	"L4":        24,
+	"L40S":      48,
+	"T4":        16,
+}
+
+// migProfile is a MIG slice and its memory
+type migProfile struct {
+	name      string
+	memoryGiB float64
+}
+
+// migProfiles lists the MIG slices of MIG-capable GPU types, smallest first.
+// Full-GPU profiles are omitted since they gain nothing over the whole GPU.
+var migProfiles = map[string][]migProfile{
+	"A100":      {{"1g.10gb", 10}, {"2g.20gb", 20}, {"3g.40gb", 40}},
+	"A100-40GB": {{"1g.5gb", 5}, {"2g.10gb", 10}, {"3g.20gb", 20}},
+	"A30":       {{"1g.6gb", 6}, {"2g.12gb", 12}},
+	"H100":      {{"1g.10gb", 10}, {"2g.20gb", 20}, {"3g.40gb", 40}},
+	"H200":      {{"1g.18gb", 18}, {"2g.35gb", 35}, {"3g.71gb", 71}},
+}
+
+// GPUUsage is the peak GPU usage of a pool's replicas over a window
+type GPUUsage struct {
+	// PeakVRAMGB is the most GPU memory a replica used, in GB
+	PeakVRAMGB float64
+
+	// ContextLength is the highest p95 context length, in tokens
+	ContextLength float64
+
+	// BatchSize is the largest decode batch
+	BatchSize float64
+}
+
+// UsageProvider reports the GPU usage of a pool
+type UsageProvider interface {
+	GPUUsage(ctx context.Context, pool *neuronetes.AgentPool, window time.Duration) (GPUUsage, error)
+}
+
+// RecommendGPU sizes the GPUs of each replica of pool to fit its peak VRAM
+// usage plus margin. Replicas use as few GPUs of the pool's type as fit, and
+// a MIG profile is suggested when a replica fits in a slice of one GPU.
+func RecommendGPU(pool *neuronetes.AgentPool, usage GPUUsage, margin float64) *neuronetes.GPURecommendation {
+	rec := sizeGPU(pool, usage.PeakVRAMGB*(1+margin), 1)
+	rec.PeakVRAM = *resource.NewQuantity(int64(math.Ceil(usage.PeakVRAMGB*1024))<<20, resource.BinarySI)
+	rec.ContextLength = int32(usage.ContextLength)
+	rec.BatchSize = int32(usage.BatchSize)
+
+	reason := fmt.Sprintf("peak VRAM %.1fGi", usage.PeakVRAMGB)
+	if usage.ContextLength > 0 || usage.BatchSize > 0 {
+		reason += fmt.Sprintf(" at p95 context %.0f tokens and batch size %.0f", usage.ContextLength, usage.BatchSize)
+	}
+	rec.Reason = reason + fmt.Sprintf(", plus %.0f%% margin", margin*100)
+	return rec
+}
+
+// EstimateGPU sizes the GPUs of each replica of pool to fit the estimated
+// VRAM of its model plus margin, before its usage is observed. Replicas use
+// at least as many GPUs as the model has tensor- or pipeline-parallel
+// shards.
+func EstimateGPU(pool *neuronetes.AgentPool, estimate *neuronetes.VRAMEstimate, margin float64) *neuronetes.GPURecommendation {
+	gib := float64(estimate.Total.Value()) / (1 << 30)
+	rec := sizeGPU(pool, gib*(1+margin), estimate.GPUs)
+	rec.Reason = fmt.Sprintf("estimated VRAM %.1fGi, plus %.0f%% margin", gib, margin*100)
+	return rec
+}
+
+// sizeGPU recommends as few GPUs of the pool's type as fit required GiB,
+// and at least minCount
+func sizeGPU(pool *neuronetes.AgentPool, required float64, minCount int32) *neuronetes.GPURecommendation {
+	var gpuType string
+	if pool.Spec.GPURequirements != nil {
+		gpuType = pool.Spec.GPURequirements.Type
+	}
+	capacity := gpuCapacity(pool.Spec.GPURequirements)
+
+	count := int32(math.Ceil(required / capacity))
+	if count < minCount {
+		count = minCount
+	}
+	if count < 1 {
+		count = 1
+	}
+	perGPU := int64(math.Ceil(required / float64(count)))
+	if perGPU < 1 {
+		perGPU = 1
+	}
+
+	var mig string
+	if count == 1 {
+		for _, profile := range migProfiles[gpuType] {
+			if profile.memoryGiB >= required {
+				mig = profile.name
+				break
+			}
+		}
+	}
+
+	return &neuronetes.GPURecommendation{
+		Target: neuronetes.GPURequirements{
+			Count:  count,
+			Memory: fmt.Sprintf("%dGi", perGPU),
+			Type:   gpuType,
+		},
+		MIGProfile: mig,
+	}
+}
+
+// gpuCapacity returns the memory of one GPU in GiB: that of a known type,
+// else the requested memory, else a default
+func gpuCapacity(req *neuronetes.GPURequirements) float64 {
+	if req == nil {
+		return defaultGPUMemoryGiB
+	}
+	if memory, ok := gpuMemoryGiB[req.Type]; ok {
+		return memory
+	}
+	if q, err := resource.ParseQuantity(req.Memory); err == nil && q.Sign() > 0 {
+		return float64(q.Value()) / (1 << 30)
+	}
+	return defaultGPUMemoryGiB
+}
+
+// gpuRecommendationChanged reports whether rec suggests different sizing
+// than last, or is the first based on observed usage
+func gpuRecommendationChanged(last, rec *neuronetes.GPURecommendation) bool {
+	return last == nil || last.PeakVRAM.IsZero() != rec.PeakVRAM.IsZero() || last.Target.Count != rec.Target.Count ||
+		last.Target.Memory != rec.Target.Memory || last.Target.Type != rec.Target.Type ||
+		last.MIGProfile != rec.MIGProfile
+}
+
+// GPURecommender periodically records suggested GPU sizing in the status of
+// GPU-backed AgentPools. Like the Vertical Pod Autoscaler's recommender it
+// never changes the pool itself. Until usage is observed, pools are sized by
+// the VRAM estimate of their model.
+type GPURecommender struct {
+	client.Client
+	Usage UsageProvider
+
+	// Window is how far back usage is observed
+	Window time.Duration
+
+	// Interval is how often each pool is re-evaluated
+	Interval time.Duration
+
+	// Margin is the headroom added above peak VRAM usage, as a fraction
+	Margin float64
+}
+
+// +kubebuilder:rbac:groups=neuronetes.io,resources=agentclasses;models,verbs=get;list;watch
+
+// Reconcile records a GPU recommendation for one AgentPool
+func (r *GPURecommender) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	var pool neuronetes.AgentPool
+	if err := r.Get(ctx, req.NamespacedName, &pool); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if !pool.DeletionTimestamp.IsZero() || pool.Spec.GPURequirements == nil {
+		return ctrl.Result{}, nil
+	}
+
+	interval := r.Interval
+	if interval <= 0 {
+		interval = DefaultRecommendationInterval
+	}
+	window := r.Window
+	if window <= 0 {
+		window = DefaultRecommendationWindow
+	}
+
+	var rec *neuronetes.GPURecommendation
+	usage, err := r.Usage.GPUUsage(ctx, &pool, window)
+	switch {
+	case errors.Is(err, ErrNoData):
+		// Nothing observed yet, so the pool is sized by its model
+		estimate, err := r.modelEstimate(ctx, &pool)
+		if err != nil {
+			log.Error(err, "failed to get the VRAM estimate of the model", "pool", req.NamespacedName)
+		}
+		if estimate == nil {
+			return ctrl.Result{RequeueAfter: interval}, nil
+		}
+		rec = EstimateGPU(&pool, estimate, r.Margin)
+	case err != nil:
+		log.Error(err, "failed to observe GPU usage", "pool", req.NamespacedName)
+		return ctrl.Result{RequeueAfter: interval}, nil
+	default:
+		rec = RecommendGPU(&pool, usage, r.Margin)
+	}
+	if !gpuRecommendationChanged(pool.Status.GPURecommendation, rec) {
+		return ctrl.Result{RequeueAfter: interval}, nil
+	}
+	rec.Time = metav1.Now()
+
+	patch := client.MergeFrom(pool.DeepCopy())
+	pool.Status.GPURecommendation = rec
+	if err := r.Status().Patch(ctx, &pool, patch); err != nil {
+		if apierrors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+	log.Info("Recommended GPU sizing",
+		"pool", req.NamespacedName,
+		"count", rec.Target.Count,
+		"memory", rec.Target.Memory,
+		"migProfile", rec.MIGProfile,
+		"reason", rec.Reason)
+	return ctrl.Result{RequeueAfter: interval}, nil
+}
+
+// modelEstimate returns the VRAM estimate in the status of the model pool
+// serves, or nil if it is not estimated yet
+func (r *GPURecommender) modelEstimate(ctx context.Context, pool *neuronetes.AgentPool) (*neuronetes.VRAMEstimate, error) {
+	classKey := client.ObjectKey{Namespace: pool.Spec.AgentClassRef.Namespace, Name: pool.Spec.AgentClassRef.Name}
+	if classKey.Namespace == "" {
+		classKey.Namespace = pool.Namespace
+	}
+	var class neuronetes.AgentClass
+	if err := r.Get(ctx, classKey, &class); err != nil {
+		return nil, client.IgnoreNotFound(err)
+	}
+	modelKey := client.ObjectKey{Namespace: class.Spec.ModelRef.Namespace, Name: class.Spec.ModelRef.Name}
+	if modelKey.Namespace == "" {
+		modelKey.Namespace = class.Namespace
+	}
+	var model neuronetes.Model
+	if err := r.Get(ctx, modelKey, &model); err != nil {
+		return nil, client.IgnoreNotFound(err)
+	}
+	return model.Status.VRAM, nil
+}
+
+// SetupWithManager sets up the recommender with the Manager
+func (r *GPURecommender) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("gpu-recommender").
+		For(&neuronetes.AgentPool{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Complete(r)
+}
@@ -0,0 +1,151 @@
+package autoscaler
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+)
+
+func newGPUPool(gpuType, memory string) *neuronetes.AgentPool {
+	pool := newTestPool(2)
+	pool.Spec.GPURequirements = &neuronetes.GPURequirements{Count: 2, Type: gpuType, Memory: memory}
+	return pool
+}
+
+func TestRecommendGPU(t *testing.T) {
+	// A small model on an H100 fits in a MIG slice
+	rec := RecommendGPU(newGPUPool("H100", ""), GPUUsage{PeakVRAMGB: 16, ContextLength: 4096, BatchSize: 8}, 0.15)
+	assert.Equal(t, int32(1), rec.Target.Count)
+	assert.Equal(t, "19Gi", rec.Target.Memory)
+	assert.Equal(t, "H100", rec.Target.Type)
+	assert.Equal(t, "2g.20gb", rec.MIGProfile)
+	assert.Equal(t, "16Gi", rec.PeakVRAM.String())
+	assert.Equal(t, int32(4096), rec.ContextLength)
+	assert.Equal(t, int32(8), rec.BatchSize)
+	assert.Equal(t, "peak VRAM 16.0Gi at p95 context 4096 tokens and batch size 8, plus 15% margin", rec.Reason)
+
+	// Beyond one GPU, memory is split over as few GPUs as fit
+	rec = RecommendGPU(newGPUPool("A100", ""), GPUUsage{PeakVRAMGB: 100}, 0.15)
+	assert.Equal(t, int32(2), rec.Target.Count)
+	assert.Equal(t, "58Gi", rec.Target.Memory)
+	assert.Empty(t, rec.MIGProfile)
+
+	// Unknown types are sized by their requested memory and get no MIG slice
+	rec = RecommendGPU(newGPUPool("custom", "24Gi"), GPUUsage{PeakVRAMGB: 40}, 0)
+	assert.Equal(t, int32(2), rec.Target.Count)
+	assert.Equal(t, "20Gi", rec.Target.Memory)
+	assert.Empty(t, rec.MIGProfile)
+}
+
+func TestEstimateGPU(t *testing.T) {
+	estimate := &neuronetes.VRAMEstimate{Total: resource.MustParse("20Gi"), GPUs: 1}
+	rec := EstimateGPU(newGPUPool("H100", ""), estimate, 0.15)
+	assert.Equal(t, int32(1), rec.Target.Count)
+	assert.Equal(t, "23Gi", rec.Target.Memory)
+	assert.Equal(t, "3g.40gb", rec.MIGProfile)
+	assert.True(t, rec.PeakVRAM.IsZero())
+	assert.Equal(t, "estimated VRAM 20.0Gi, plus 15% margin", rec.Reason)
+
+	// Replicas keep a GPU for each tensor-parallel shard
+	estimate.GPUs = 4
+	rec = EstimateGPU(newGPUPool("H100", ""), estimate, 0.15)
+	assert.Equal(t, int32(4), rec.Target.Count)
+	assert.Equal(t, "6Gi", rec.Target.Memory)
+	assert.Empty(t, rec.MIGProfile)
+}
+
+type staticUsage struct {
+	usage GPUUsage
+	err   error
+}
+
+func (s *staticUsage) GPUUsage(ctx context.Context, pool *neuronetes.AgentPool, window time.Duration) (GPUUsage, error) {
+	return s.usage, s.err
+}
+
+func TestGPURecommenderRecordsStatus(t *testing.T) {
+	pool := newGPUPool("A100", "")
+	pool.Spec.AgentClassRef.Name = "chat-agent"
+	scheme := runtime.NewScheme()
+	require.NoError(t, neuronetes.AddToScheme(scheme))
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(pool).WithStatusSubresource(pool).Build()
+	usage := &staticUsage{err: ErrNoData}
+	r := &GPURecommender{Client: c, Usage: usage, Interval: time.Minute, Margin: DefaultVRAMMargin}
+
+	// Without observations there is nothing to recommend
+	result, err := r.Reconcile(context.Background(), reconcileRequest(pool))
+	require.NoError(t, err)
+	assert.Equal(t, time.Minute, result.RequeueAfter)
+	var got neuronetes.AgentPool
+	require.NoError(t, c.Get(context.Background(), reconcileRequest(pool).NamespacedName, &got))
+	assert.Nil(t, got.Status.GPURecommendation)
+
+	// Until usage is observed the pool is sized by the estimate of its model
+	class := &neuronetes.AgentClass{
+		ObjectMeta: metav1.ObjectMeta{Name: "chat-agent", Namespace: "default"},
+		Spec:       neuronetes.AgentClassSpec{ModelRef: neuronetes.ModelReference{Name: "llama-3-70b"}},
+	}
+	model := &neuronetes.Model{
+		ObjectMeta: metav1.ObjectMeta{Name: "llama-3-70b", Namespace: "default"},
+		Status: neuronetes.ModelStatus{
+			Phase: "Ready",
+			VRAM:  &neuronetes.VRAMEstimate{Total: resource.MustParse("150Gi"), GPUs: 2},
+		},
+	}
+	require.NoError(t, c.Create(context.Background(), class))
+	require.NoError(t, c.Create(context.Background(), model))
+	_, err = r.Reconcile(context.Background(), reconcileRequest(pool))
+	require.NoError(t, err)
+	require.NoError(t, c.Get(context.Background(), reconcileRequest(pool).NamespacedName, &got))
+	require.NotNil(t, got.Status.GPURecommendation)
+	assert.Equal(t, int32(3), got.Status.GPURecommendation.Target.Count)
+	assert.True(t, got.Status.GPURecommendation.PeakVRAM.IsZero())
+
+	usage.usage, usage.err = GPUUsage{PeakVRAMGB: 30}, nil
+	_, err = r.Reconcile(context.Background(), reconcileRequest(pool))
+	require.NoError(t, err)
+	require.NoError(t, c.Get(context.Background(), reconcileRequest(pool).NamespacedName, &got))
+	require.NotNil(t, got.Status.GPURecommendation)
+	assert.Equal(t, int32(1), got.Status.GPURecommendation.Target.Count)
+	assert.Equal(t, "3g.40gb", got.Status.GPURecommendation.MIGProfile)
+	// The pool's own requirements are left alone
+	assert.Equal(t, int32(2), got.Spec.GPURequirements.Count)
+	recorded := got.Status.GPURecommendation.Time
+
+	// Unchanged sizing is not rewritten
+	usage.usage.PeakVRAMGB = 30.2
+	_, err = r.Reconcile(context.Background(), reconcileRequest(pool))
+	require.NoError(t, err)
+	require.NoError(t, c.Get(context.Background(), reconcileRequest(pool).NamespacedName, &got))
+	assert.Equal(t, recorded, got.Status.GPURecommendation.Time)
+	assert.Equal(t, "30Gi", got.Status.GPURecommendation.PeakVRAM.String())
+}
+
+func TestPrometheusMetricsProviderGPUUsage(t *testing.T) {
+	fake := &fakePrometheus{body: vectorResponse("12")}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	provider, err := NewPrometheusMetricsProvider(PrometheusConfig{Address: server.URL})
+	require.NoError(t, err)
+
+	usage, err := provider.GPUUsage(context.Background(), newPrometheusPool(), 6*time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, GPUUsage{PeakVRAMGB: 12, ContextLength: 12, BatchSize: 12}, usage)
+	assert.Equal(t, `max(max_over_time(agent_batch_size{ namespace="prod",pool="chat-pool" }[6h]))`, fake.query)
+
+	// VRAM usage is required
+	fake.body = vectorResponse()
+	_, err = provider.GPUUsage(context.Background(), newPrometheusPool(), time.Hour)
+	assert.ErrorIs(t, err, ErrNoData)
+}
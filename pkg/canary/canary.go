@@ -0,0 +1,208 @@
+// Package canary splits live traffic between a baseline and a canary
+// AgentPool and decides, from observed error rates, latency and quality,
+// whether the canary should be promoted to all traffic or rolled back. The
+// ModelRollout controller runs the analysis and sets the split in the
+// canary annotations of the baseline pool, which the gateway routes by.
+package canary
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"sync"
+	"time"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+)
+
+// Thresholds define when a canary is promoted or rolled back
+type Thresholds struct {
+	// MaxErrorRate is the highest canary error rate (0-1) tolerated
+	MaxErrorRate float64
+
+	// MaxLatencyRatio is the highest canary/baseline p95 latency ratio
+	// tolerated. Zero disables the latency check.
+	MaxLatencyRatio float64
+
+	// MinQualityWinRate is the lowest quality win rate (0-1) of the canary
+	// against the baseline tolerated. Zero disables the quality check.
+	MinQualityWinRate float64
+
+	// MinRequests is the number of canary requests needed before a verdict
+	MinRequests int64
+
+	// PromoteAfter is the number of consecutive healthy analyses required
+	// before the canary is promoted
+	PromoteAfter int
+}
+
+// Stats are the request statistics observed for one pool
+type Stats struct {
+	Requests   int64
+	Errors     int64
+	P95Latency time.Duration
+
+	// QualityWinRate is the share (0-1) of turns the pool won against the
+	// other version, nil if not evaluated
+	QualityWinRate *float64
+}
+
+// ErrorRate returns errors / requests, or 0 without requests
+func (s Stats) ErrorRate() float64 {
+	if s.Requests == 0 {
+		return 0
+	}
+	return float64(s.Errors) / float64(s.Requests)
+}
+
+// Verdict is the outcome of a canary analysis
+type Verdict string
+
+const (
+	// VerdictContinue keeps the current split
+	VerdictContinue Verdict = "Continue"
+
+	// VerdictPromote shifts all traffic to the canary
+	VerdictPromote Verdict = "Promote"
+
+	// VerdictRollback shifts all traffic back to the baseline
+	VerdictRollback Verdict = "Rollback"
+)
+
+// Result is a verdict with the reason it was reached
+type Result struct {
+	Verdict Verdict
+	Reason  string
+	Healthy bool
+}
+
+// Analyze compares canary stats against the baseline. A healthy result
+// with VerdictContinue counts toward promotion.
+func Analyze(baseline, canary Stats, t Thresholds) Result {
+	if canary.Requests < t.MinRequests {
+		return Result{
+			Verdict: VerdictContinue,
+			Reason:  fmt.Sprintf("waiting for %d canary requests, have %d", t.MinRequests, canary.Requests),
+		}
+	}
+
+	if rate := canary.ErrorRate(); rate > t.MaxErrorRate {
+		return Result{
+			Verdict: VerdictRollback,
+			Reason:  fmt.Sprintf("canary error rate %.3f exceeds %.3f", rate, t.MaxErrorRate),
+		}
+	}
+
+	if t.MaxLatencyRatio > 0 && baseline.P95Latency > 0 {
+		ratio := float64(canary.P95Latency) / float64(baseline.P95Latency)
+		if ratio > t.MaxLatencyRatio {
+			return Result{
+				Verdict: VerdictRollback,
+				Reason:  fmt.Sprintf("canary p95 latency %.2fx baseline exceeds %.2fx", ratio, t.MaxLatencyRatio),
+			}
+		}
+	}
+
+	if t.MinQualityWinRate > 0 {
+		if canary.QualityWinRate == nil {
+			return Result{
+				Verdict: VerdictContinue,
+				Reason:  "waiting for the quality win rate of the canary",
+			}
+		}
+		if rate := *canary.QualityWinRate; rate < t.MinQualityWinRate {
+			return Result{
+				Verdict: VerdictRollback,
+				Reason:  fmt.Sprintf("canary quality win rate %.3f is below %.3f", rate, t.MinQualityWinRate),
+			}
+		}
+	}
+
+	return Result{
+		Verdict: VerdictContinue,
+		Reason:  "canary healthy",
+		Healthy: true,
+	}
+}
+
+// Splitter routes requests between the baseline and canary pools. Routing
+// is keyed so that a session or conversation always lands on the same pool
+// for a given percentage.
+type Splitter struct {
+	mu       sync.RWMutex
+	baseline string
+	canary   string
+	percent  int32
+}
+
+// NewSplitter creates a splitter sending percent of traffic to canary
+func NewSplitter(baseline, canary string, percent int32) *Splitter {
+	s := &Splitter{baseline: baseline, canary: canary}
+	s.SetPercent(percent)
+	return s
+}
+
+// SetPercent updates the canary share, clamped to 0-100
+func (s *Splitter) SetPercent(percent int32) {
+	if percent < 0 {
+		percent = 0
+	}
+	if percent > 100 {
+		percent = 100
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.percent = percent
+}
+
+// Percent returns the current canary share
+func (s *Splitter) Percent() int32 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.percent
+}
+
+// Route returns the pool that should serve the request identified by key
+func (s *Splitter) Route(key string) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	if int32(h.Sum32()%100) < s.percent {
+		return s.canary
+	}
+	return s.baseline
+}
+
+// PoolSplit returns the canary of pool and the percentage of its traffic
+// routed to the canary, from the canary annotations of pool
+func PoolSplit(pool *neuronetes.AgentPool) (string, int32, bool) {
+	canary := pool.Annotations[neuronetes.AnnotationCanary]
+	if canary == "" {
+		return "", 0, false
+	}
+	percent, err := strconv.ParseInt(pool.Annotations[neuronetes.AnnotationCanaryWeight], 10, 32)
+	if err != nil {
+		percent = 0
+	}
+	return canary, int32(percent), true
+}
+
+// SplitterForPool returns a splitter routing the traffic of pool to its
+// canary, or nil if pool has no canary
+func SplitterForPool(pool *neuronetes.AgentPool) *Splitter {
+	canary, percent, ok := PoolSplit(pool)
+	if !ok {
+		return nil
+	}
+	return NewSplitter(pool.Name, canary, percent)
+}
+
+// PoolStatsProvider returns the request statistics of the replicas of an
+// AgentPool over a window
+type PoolStatsProvider interface {
+	PoolStats(ctx context.Context, pool *neuronetes.AgentPool, window time.Duration) (Stats, error)
+}
@@ -0,0 +1,100 @@
+package canary
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+)
+
+func TestSplitterRatioAccuracy(t *testing.T) {
+	for _, percent := range []int32{0, 5, 20, 50, 90, 100} {
+		t.Run(fmt.Sprintf("%d%%", percent), func(t *testing.T) {
+			s := NewSplitter("baseline", "canary", percent)
+
+			const n = 100000
+			canary := 0
+			for i := 0; i < n; i++ {
+				if s.Route(fmt.Sprintf("conversation-%d", i)) == "canary" {
+					canary++
+				}
+			}
+
+			assert.InDelta(t, float64(percent)/100, float64(canary)/n, 0.01)
+		})
+	}
+}
+
+func TestSplitterIsStickyPerKey(t *testing.T) {
+	s := NewSplitter("baseline", "canary", 30)
+	first := s.Route("conversation-42")
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, first, s.Route("conversation-42"))
+	}
+}
+
+func TestAnalyzeErrorRateRollback(t *testing.T) {
+	baseline := Stats{Requests: 1000, Errors: 5, P95Latency: 300 * time.Millisecond}
+	thresholds := Thresholds{MaxErrorRate: 0.02, MaxLatencyRatio: 1.2, MinRequests: 100}
+
+	// Not enough data yet
+	result := Analyze(baseline, Stats{Requests: 50, Errors: 20}, thresholds)
+	assert.Equal(t, VerdictContinue, result.Verdict)
+	assert.False(t, result.Healthy)
+
+	result = Analyze(baseline, Stats{Requests: 200, Errors: 20, P95Latency: 310 * time.Millisecond}, thresholds)
+	assert.Equal(t, VerdictRollback, result.Verdict)
+	assert.Contains(t, result.Reason, "error rate")
+
+	result = Analyze(baseline, Stats{Requests: 500, Errors: 2, P95Latency: 320 * time.Millisecond}, thresholds)
+	assert.Equal(t, VerdictContinue, result.Verdict)
+	assert.True(t, result.Healthy)
+}
+
+func TestAnalyzeLatencyRollback(t *testing.T) {
+	result := Analyze(
+		Stats{Requests: 1000, P95Latency: 300 * time.Millisecond},
+		Stats{Requests: 1000, P95Latency: 450 * time.Millisecond},
+		Thresholds{MaxErrorRate: 0.05, MaxLatencyRatio: 1.2},
+	)
+	assert.Equal(t, VerdictRollback, result.Verdict)
+}
+
+func TestAnalyzeQualityWinRate(t *testing.T) {
+	baseline := Stats{Requests: 1000, P95Latency: 300 * time.Millisecond}
+	canary := Stats{Requests: 1000, P95Latency: 300 * time.Millisecond}
+	thresholds := Thresholds{MaxErrorRate: 0.05, MinQualityWinRate: 0.45}
+
+	// Quality is waited for, not assumed
+	result := Analyze(baseline, canary, thresholds)
+	assert.Equal(t, VerdictContinue, result.Verdict)
+	assert.False(t, result.Healthy)
+
+	rate := 0.52
+	canary.QualityWinRate = &rate
+	result = Analyze(baseline, canary, thresholds)
+	assert.True(t, result.Healthy)
+
+	rate = 0.3
+	result = Analyze(baseline, canary, thresholds)
+	assert.Equal(t, VerdictRollback, result.Verdict)
+	assert.Equal(t, "canary quality win rate 0.300 is below 0.450", result.Reason)
+}
+
+func TestSplitterForPool(t *testing.T) {
+	pool := &neuronetes.AgentPool{ObjectMeta: metav1.ObjectMeta{Name: "chat-pool"}}
+	assert.Nil(t, SplitterForPool(pool))
+
+	pool.Annotations = map[string]string{
+		neuronetes.AnnotationCanary:       "chat-pool-llama-3-1",
+		neuronetes.AnnotationCanaryWeight: "100",
+	}
+	s := SplitterForPool(pool)
+	require.NotNil(t, s)
+	assert.Equal(t, "chat-pool-llama-3-1", s.Route("conversation-42"))
+}
@@ -0,0 +1,220 @@
+// Package capacity estimates the GPU memory replicas of models need, so
+// that they are bin packed onto GPUs by what they hold rather than by the
+// size of their weights on disk. A replica holds its weights, sized by the
+// parameter count and quantization of the model, the KV cache of the
+// sequences it serves, and the activations of the runtime.
+package capacity
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+)
+
+const (
+	// DefaultContextLength is the context length of models serving
+	// sequences of unknown length
+	DefaultContextLength = 4096
+
+	// activationOverhead is the memory of activations and the runtime, as a
+	// fraction of the weights
+	activationOverhead = 0.1
+
+	// unknownKVCacheOverhead is the memory beyond the weights of replicas
+	// of models of unknown dimensions, for the KV cache and activations, as
+	// a fraction of the weights
+	unknownKVCacheOverhead = 0.2
+
+	// paramsPerLayerCubed relates the parameters of a model to its layers
+	// L, with a hidden size of 128L and 12 hidden size squared parameters
+	// per layer as in GPT-3 and Llama 2 7B
+	paramsPerLayerCubed = 12 * 128 * 128
+
+	// hiddenPerLayer is the hidden size of a model per layer
+	hiddenPerLayer = 128
+
+	// shardStrategyDataParallel replicates the whole model on every shard
+	shardStrategyDataParallel = "data-parallel"
+)
+
+// bytesPerParameter is the size of a weight of each quantization
+var bytesPerParameter = map[string]float64{
+	"fp32": 4,
+	"fp16": 2,
+	"int8": 1,
+	"int4": 0.5,
+}
+
+// bytesPerKVCacheValue is the size of a value of the KV cache of each type
+var bytesPerKVCacheValue = map[string]float64{
+	"fp32": 4,
+	"fp16": 2,
+	"fp8":  1,
+	"int8": 1,
+}
+
+// Estimate returns the GPU memory of a replica of the model of spec, or nil
+// if neither its parameter count nor its size is known. The weights are
+// sized by ParameterCount and Quantization, or by Size if the parameter
+// count is missing or invalid. The KV cache is sized by spec.serving and the
+// dimensions of the model, estimated from the parameter count if not set;
+// if neither is known it is folded into the overhead, 20% of the weights.
+// Tensor- and pipeline-parallel shards each hold their share.
+func Estimate(spec *neuronetes.ModelSpec) *neuronetes.VRAMEstimate {
+	params, hasParams := ParseParameterCount(spec.ParameterCount)
+	var weights float64
+	switch {
+	case hasParams:
+		perParameter, ok := bytesPerParameter[spec.Quantization]
+		if !ok {
+			// Unquantized weights are usually served in half precision
+			perParameter = bytesPerParameter["fp16"]
+		}
+		weights = params * perParameter
+	case spec.Size.Sign() > 0:
+		weights = float64(spec.Size.Value())
+	default:
+		return nil
+	}
+
+	estimate := &neuronetes.VRAMEstimate{GPUs: 1}
+	overhead := weights * unknownKVCacheOverhead
+	var kvCache float64
+	if perToken, ok := kvCachePerToken(spec, params); ok {
+		kvCache = perToken * float64(contextLength(spec.Serving)) * float64(maxSequences(spec.Serving))
+		overhead = weights * activationOverhead
+		estimate.KVCache = mebibytes(kvCache)
+	}
+	total := weights + kvCache + overhead
+	if shards := spec.ShardSpec; shards != nil && shards.Count > 1 && shards.Strategy != shardStrategyDataParallel {
+		estimate.GPUs = shards.Count
+	}
+
+	estimate.Weights = *mebibytes(weights)
+	estimate.Overhead = *mebibytes(overhead)
+	estimate.Total = *mebibytes(total)
+	estimate.PerGPU = *mebibytes(total / float64(estimate.GPUs))
+	return estimate
+}
+
+// QuantizedSize returns the size of the weights of the model of spec
+// quantized to quantization: its parameters at the bytes of a weight of
+// quantization, or if the parameter count is missing or invalid, its Size
+// scaled from the bytes of a weight of its own quantization
+func QuantizedSize(spec *neuronetes.ModelSpec, quantization string) resource.Quantity {
+	perParameter, ok := bytesPerParameter[quantization]
+	if !ok {
+		perParameter = bytesPerParameter["fp16"]
+	}
+	if params, ok := ParseParameterCount(spec.ParameterCount); ok {
+		return *mebibytes(params * perParameter)
+	}
+	current, ok := bytesPerParameter[spec.Quantization]
+	if !ok {
+		current = bytesPerParameter["fp16"]
+	}
+	return *mebibytes(float64(spec.Size.Value()) * perParameter / current)
+}
+
+// ParseParameterCount parses a parameter count such as 70B, 1.5B, 350M or
+// 7000000000, with a K, M, B or T suffix case-insensitively
+func ParseParameterCount(s string) (float64, bool) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, false
+	}
+	scale := 1.0
+	switch s[len(s)-1] {
+	case 'K', 'k':
+		scale = 1e3
+	case 'M', 'm':
+		scale = 1e6
+	case 'B', 'b':
+		scale = 1e9
+	case 'T', 't':
+		scale = 1e12
+	}
+	if scale > 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n <= 0 || math.IsInf(n, 0) {
+		return 0, false
+	}
+	return n * scale, true
+}
+
+// FormatParameterCount formats a parameter count as ParseParameterCount
+// parses it, with the largest suffix it is at least one of and two decimals,
+// e.g. 8.03B or 350M
+func FormatParameterCount(n float64) string {
+	for _, unit := range []struct {
+		suffix string
+		scale  float64
+	}{{"T", 1e12}, {"B", 1e9}, {"M", 1e6}, {"K", 1e3}} {
+		if n >= unit.scale {
+			return strconv.FormatFloat(math.Round(n/unit.scale*100)/100, 'f', -1, 64) + unit.suffix
+		}
+	}
+	return strconv.FormatFloat(math.Round(n), 'f', -1, 64)
+}
+
+// kvCachePerToken returns the bytes of the KV cache of a token: a key and a
+// value for each KV head of each layer. Dimensions missing from
+// spec.serving are estimated from params as those of a model without
+// grouped query attention.
+func kvCachePerToken(spec *neuronetes.ModelSpec, params float64) (float64, bool) {
+	var layers, width float64
+	if params > 0 {
+		layers = math.Ceil(math.Cbrt(params / paramsPerLayerCubed))
+		width = layers * hiddenPerLayer
+	}
+	serving := spec.Serving
+	if serving == nil {
+		serving = &neuronetes.ServingSpec{}
+	}
+	if serving.NumLayers > 0 {
+		layers = float64(serving.NumLayers)
+	}
+	if serving.NumKVHeads > 0 && serving.HeadDim > 0 {
+		width = float64(serving.NumKVHeads) * float64(serving.HeadDim)
+	}
+	if layers == 0 || width == 0 {
+		return 0, false
+	}
+
+	perValue, ok := bytesPerKVCacheValue[serving.KVCacheType]
+	if !ok {
+		perValue = bytesPerKVCacheValue["fp16"]
+		if spec.Quantization == "fp32" {
+			perValue = bytesPerKVCacheValue["fp32"]
+		}
+	}
+	return 2 * layers * width * perValue, true
+}
+
+// contextLength returns the context length of the sequences of serving
+func contextLength(serving *neuronetes.ServingSpec) int32 {
+	if serving == nil || serving.ContextLength <= 0 {
+		return DefaultContextLength
+	}
+	return serving.ContextLength
+}
+
+// maxSequences returns the sequences a replica holds in its KV cache
+func maxSequences(serving *neuronetes.ServingSpec) int32 {
+	if serving == nil || serving.MaxSequences <= 0 {
+		return 1
+	}
+	return serving.MaxSequences
+}
+
+// mebibytes returns bytes rounded up to a MiB
+func mebibytes(bytes float64) *resource.Quantity {
+	mib := int64(math.Ceil(bytes / (1 << 20)))
+	return resource.NewQuantity(mib<<20, resource.BinarySI)
+}
@@ -0,0 +1,130 @@
+package capacity
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+)
+
+func TestEstimate(t *testing.T) {
+	// Without a parameter count the weights are sized by their files, with
+	// the KV cache folded into 20% of overhead
+	estimate := Estimate(&neuronetes.ModelSpec{Size: resource.MustParse("16Gi")})
+	require.NotNil(t, estimate)
+	assert.Equal(t, "16Gi", estimate.Weights.String())
+	assert.Nil(t, estimate.KVCache)
+	assert.Equal(t, "3277Mi", estimate.Overhead.String())
+	assert.Equal(t, "19661Mi", estimate.Total.String())
+	assert.Equal(t, int32(1), estimate.GPUs)
+	assert.Equal(t, "19661Mi", estimate.PerGPU.String())
+
+	// Llama 3 8B with grouped query attention serving 16 sequences of 8K
+	// tokens: 128KiB of KV cache per token
+	spec := &neuronetes.ModelSpec{
+		Size:           resource.MustParse("15Gi"),
+		ParameterCount: "8B",
+		Quantization:   "fp16",
+		Serving: &neuronetes.ServingSpec{
+			ContextLength: 8192,
+			MaxSequences:  16,
+			NumLayers:     32,
+			NumKVHeads:    8,
+			HeadDim:       128,
+		},
+	}
+	estimate = Estimate(spec)
+	assert.Equal(t, "15259Mi", estimate.Weights.String())
+	require.NotNil(t, estimate.KVCache)
+	assert.Equal(t, "16Gi", estimate.KVCache.String())
+	assert.Equal(t, "1526Mi", estimate.Overhead.String())
+	assert.Equal(t, "33169Mi", estimate.Total.String())
+
+	// An fp8 KV cache halves it
+	spec.Serving.KVCacheType = "fp8"
+	assert.Equal(t, "8Gi", Estimate(spec).KVCache.String())
+
+	// Without dimensions a 70B model is estimated as 71 layers of 9088
+	// hidden size, and tensor-parallel shards each hold a quarter
+	estimate = Estimate(&neuronetes.ModelSpec{
+		Size:           resource.MustParse("35Gi"),
+		ParameterCount: "70B",
+		Quantization:   "int4",
+		ShardSpec:      &neuronetes.ShardSpec{Count: 4, Strategy: "tensor-parallel"},
+	})
+	assert.Equal(t, "33379Mi", estimate.Weights.String())
+	assert.Equal(t, "10082Mi", estimate.KVCache.String())
+	assert.Equal(t, "46799Mi", estimate.Total.String())
+	assert.Equal(t, int32(4), estimate.GPUs)
+	assert.Equal(t, "11700Mi", estimate.PerGPU.String())
+
+	// Data-parallel shards each hold the whole model
+	estimate = Estimate(&neuronetes.ModelSpec{
+		Size:      resource.MustParse("16Gi"),
+		ShardSpec: &neuronetes.ShardSpec{Count: 4, Strategy: "data-parallel"},
+	})
+	assert.Equal(t, int32(1), estimate.GPUs)
+	assert.Equal(t, "19661Mi", estimate.PerGPU.String())
+
+	// Invalid parameter counts fall back to the size
+	estimate = Estimate(&neuronetes.ModelSpec{Size: resource.MustParse("16Gi"), ParameterCount: "eight billion"})
+	assert.Equal(t, "16Gi", estimate.Weights.String())
+
+	assert.Nil(t, Estimate(&neuronetes.ModelSpec{}))
+}
+
+func TestQuantizedSize(t *testing.T) {
+	// Parameter counts size the quantized weights
+	spec := &neuronetes.ModelSpec{Size: resource.MustParse("15Gi"), ParameterCount: "8B", Quantization: "fp16"}
+	size := QuantizedSize(spec, "int4")
+	assert.Equal(t, "3815Mi", size.String())
+
+	// Otherwise the size is scaled from the current quantization
+	spec = &neuronetes.ModelSpec{Size: resource.MustParse("16Gi")}
+	size = QuantizedSize(spec, "int8")
+	assert.Equal(t, "8Gi", size.String())
+	spec = &neuronetes.ModelSpec{Size: resource.MustParse("16Gi"), Quantization: "int8"}
+	size = QuantizedSize(spec, "int4")
+	assert.Equal(t, "8Gi", size.String())
+}
+
+func TestParseParameterCount(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want float64
+		ok   bool
+	}{
+		{in: "70B", want: 70e9, ok: true},
+		{in: "1.5b", want: 1.5e9, ok: true},
+		{in: "350M", want: 350e6, ok: true},
+		{in: "1T", want: 1e12, ok: true},
+		{in: " 7000000000 ", want: 7e9, ok: true},
+		{in: ""},
+		{in: "B"},
+		{in: "-7B"},
+		{in: "7 billion"},
+	} {
+		got, ok := ParseParameterCount(tc.in)
+		assert.Equal(t, tc.ok, ok, tc.in)
+		assert.InDelta(t, tc.want, got, 1, tc.in)
+	}
+}
+
+func TestFormatParameterCount(t *testing.T) {
+	for in, want := range map[float64]string{
+		8030261248: "8.03B",
+		70e9:       "70B",
+		1.5e12:     "1.5T",
+		355e6:      "355M",
+		125000:     "125K",
+		42:         "42",
+	} {
+		assert.Equal(t, want, FormatParameterCount(in))
+		got, ok := ParseParameterCount(want)
+		assert.True(t, ok, want)
+		assert.InEpsilon(t, in, got, 0.01, want)
+	}
+}
@@ -0,0 +1,211 @@
+// Package carbon estimates the energy and emissions of the tokens a node
+// generates, from the power draw of its GPUs at their utilization and the
+// carbon intensity of the grid of its region.
+package carbon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"sigs.k8s.io/yaml"
+)
+
+// ErrNoIntensity is returned for regions a provider has no carbon intensity
+// for
+var ErrNoIntensity = errors.New("no carbon intensity")
+
+// Provider reports the carbon intensity of electricity
+type Provider interface {
+	// Intensity returns the carbon intensity of the grid of a cloud region
+	// in gCO2e/kWh. It returns ErrNoIntensity if the region is unknown to
+	// the provider.
+	Intensity(ctx context.Context, region string) (float64, error)
+}
+
+// DefaultIntensity is the approximate yearly average carbon intensity in
+// gCO2e/kWh of the grids of common GPU regions. Load measured values with
+// LoadStatic, or read live ones with ElectricityMaps.
+var DefaultIntensity = Static{
+	// AWS
+	"us-east-1":      379,
+	"us-east-2":      411,
+	"us-west-2":      322,
+	"eu-west-1":      279,
+	"eu-central-1":   338,
+	"eu-north-1":     9,
+	"ap-northeast-1": 466,
+	// GCP
+	"us-central1":  455,
+	"us-east4":     379,
+	"europe-west4": 328,
+	"asia-east1":   509,
+	// Azure
+	"eastus":     379,
+	"westus2":    322,
+	"westeurope": 328,
+}
+
+// Static reports the carbon intensity of regions from a table
+type Static map[string]float64
+
+// LoadStatic reads a table of regions from a YAML or JSON file, e.g.
+//
+//	us-east-1: 379
+//	on-prem-dc1: 120
+func LoadStatic(path string) (Static, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	table := Static{}
+	if err := yaml.Unmarshal(data, &table); err != nil {
+		return nil, fmt.Errorf("failed to parse carbon intensity table %s: %w", path, err)
+	}
+	return table, nil
+}
+
+// Intensity reports the intensity of region from the table
+func (s Static) Intensity(ctx context.Context, region string) (float64, error) {
+	intensity, ok := s[region]
+	if !ok {
+		return 0, fmt.Errorf("%w: region %q is not in the carbon intensity table", ErrNoIntensity, region)
+	}
+	return intensity, nil
+}
+
+// Chain returns a provider asking each of providers in turn, until one has
+// an intensity
+func Chain(providers ...Provider) Provider {
+	return chain(providers)
+}
+
+type chain []Provider
+
+func (c chain) Intensity(ctx context.Context, region string) (float64, error) {
+	for _, provider := range c {
+		intensity, err := provider.Intensity(ctx, region)
+		if !errors.Is(err, ErrNoIntensity) {
+			return intensity, err
+		}
+	}
+	return 0, ErrNoIntensity
+}
+
+// Cache remembers the intensities of a provider for ttl. Errors are not
+// cached.
+type Cache struct {
+	provider Provider
+	ttl      time.Duration
+	now      func() time.Time
+
+	mu          sync.Mutex
+	intensities map[string]cachedIntensity
+}
+
+type cachedIntensity struct {
+	intensity float64
+	fetched   time.Time
+}
+
+// NewCache caches the intensities of provider for ttl
+func NewCache(provider Provider, ttl time.Duration) *Cache {
+	return &Cache{
+		provider:    provider,
+		ttl:         ttl,
+		now:         time.Now,
+		intensities: make(map[string]cachedIntensity),
+	}
+}
+
+// Intensity returns the cached intensity of region, asking the provider
+// once the cached one expired
+func (c *Cache) Intensity(ctx context.Context, region string) (float64, error) {
+	c.mu.Lock()
+	cached, ok := c.intensities[region]
+	c.mu.Unlock()
+	if ok && c.now().Sub(cached.fetched) < c.ttl {
+		return cached.intensity, nil
+	}
+
+	intensity, err := c.provider.Intensity(ctx, region)
+	if err != nil {
+		return 0, err
+	}
+	c.mu.Lock()
+	c.intensities[region] = cachedIntensity{intensity: intensity, fetched: c.now()}
+	c.mu.Unlock()
+	return intensity, nil
+}
+
+// DefaultCacheTTL is how long intensities are reused by providers created
+// with New. Grid operators publish intensities hourly.
+const DefaultCacheTTL = 15 * time.Minute
+
+// Providers that can be configured
+const (
+	ProviderStatic          = "static"
+	ProviderElectricityMaps = "electricitymaps"
+)
+
+// Config configures the provider created by New
+type Config struct {
+	// Provider is where intensities are read from: static to only use
+	// Table and DefaultIntensity, or electricitymaps for live intensities
+	Provider string
+
+	// Table is a carbon intensity table file, see LoadStatic. Its
+	// intensities take precedence over the provider's.
+	Table string
+
+	// ElectricityMapsToken is the API token of Electricity Maps
+	ElectricityMapsToken string
+
+	// Zones maps regions to Electricity Maps zones, in addition to
+	// DefaultZones
+	Zones map[string]string
+
+	// CacheTTL defaults to DefaultCacheTTL
+	CacheTTL time.Duration
+}
+
+// New creates the configured provider, caching its intensities. Regions
+// the provider has no intensity for fall back to DefaultIntensity.
+func New(config Config) (Provider, error) {
+	var providers chain
+	if config.Table != "" {
+		table, err := LoadStatic(config.Table)
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, table)
+	}
+
+	switch config.Provider {
+	case ProviderStatic:
+	case ProviderElectricityMaps:
+		if config.ElectricityMapsToken == "" {
+			return nil, fmt.Errorf("the electricitymaps carbon provider requires an API token")
+		}
+		zones := make(map[string]string, len(DefaultZones)+len(config.Zones))
+		for region, zone := range DefaultZones {
+			zones[region] = zone
+		}
+		for region, zone := range config.Zones {
+			zones[region] = zone
+		}
+		providers = append(providers, NewElectricityMaps(config.ElectricityMapsToken, zones))
+	default:
+		return nil, fmt.Errorf("unknown carbon provider %q", config.Provider)
+	}
+	providers = append(providers, DefaultIntensity)
+
+	ttl := config.CacheTTL
+	if ttl <= 0 {
+		ttl = DefaultCacheTTL
+	}
+	return NewCache(providers, ttl), nil
+}
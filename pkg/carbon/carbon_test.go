@@ -0,0 +1,155 @@
+package carbon
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/bowenislandsong/neuronetes/pkg/gpu"
+	"github.com/bowenislandsong/neuronetes/pkg/metrics"
+)
+
+func gpuNode(labels map[string]string, gpus string) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "gpu-node", Labels: labels},
+		Status: corev1.NodeStatus{Allocatable: corev1.ResourceList{
+			"nvidia.com/gpu": resource.MustParse(gpus),
+		}},
+	}
+}
+
+func TestGPUPower(t *testing.T) {
+	for _, tt := range []struct {
+		labels map[string]string
+		want   GPUPower
+	}{
+		{map[string]string{gfdProductLabel: "NVIDIA-A100-SXM4-80GB"}, DefaultGPUPower["A100"]},
+		{map[string]string{gfdProductLabel: "NVIDIA-L40S"}, DefaultGPUPower["L40S"]},
+		{map[string]string{gpu.GKEAcceleratorLabel: "nvidia-l4"}, DefaultGPUPower["L4"]},
+		{map[string]string{corev1.LabelInstanceTypeStable: "p5.48xlarge"}, DefaultGPUPower["H100"]},
+		{map[string]string{corev1.LabelInstanceTypeStable: "a2-highgpu-1g"}, DefaultGPUPower["A100"]},
+	} {
+		power, ok := PowerOf(DefaultGPUPower, GPUModelOf(gpuNode(tt.labels, "1")))
+		assert.True(t, ok, "%v", tt.labels)
+		assert.Equal(t, tt.want, power, "%v", tt.labels)
+	}
+	_, ok := PowerOf(DefaultGPUPower, GPUModelOf(gpuNode(map[string]string{corev1.LabelInstanceTypeStable: "m5.large"}, "1")))
+	assert.False(t, ok)
+
+	power := GPUPower{IdleWatts: 50, MaxWatts: 400}
+	assert.Equal(t, 50.0, power.Watts(0))
+	assert.Equal(t, 225.0, power.Watts(50))
+	assert.Equal(t, 400.0, power.Watts(120))
+}
+
+func TestStatic(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "intensity.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("on-prem-dc1: 120\n"), 0o600))
+	table, err := LoadStatic(path)
+	require.NoError(t, err)
+
+	intensity, err := Chain(table, DefaultIntensity).Intensity(context.Background(), "on-prem-dc1")
+	require.NoError(t, err)
+	assert.Equal(t, 120.0, intensity)
+	intensity, err = Chain(table, DefaultIntensity).Intensity(context.Background(), "eu-north-1")
+	require.NoError(t, err)
+	assert.Equal(t, 9.0, intensity)
+	_, err = Chain(table, DefaultIntensity).Intensity(context.Background(), "mars-1")
+	assert.ErrorIs(t, err, ErrNoIntensity)
+}
+
+func TestElectricityMaps(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		assert.Equal(t, "secret", r.Header.Get("auth-token"))
+		switch r.URL.Query().Get("zone") {
+		case "DE":
+			_, _ = w.Write([]byte(`{"zone":"DE","carbonIntensity":302,"datetime":"2024-05-01T12:00:00.000Z"}`))
+		default:
+			http.Error(w, "unknown zone", http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	provider := NewElectricityMaps("secret", map[string]string{"eu-central-1": "DE", "nowhere-1": "XX"})
+	provider.endpoint = server.URL
+	cache := NewCache(provider, time.Minute)
+
+	for i := 0; i < 2; i++ {
+		intensity, err := cache.Intensity(context.Background(), "eu-central-1")
+		require.NoError(t, err)
+		assert.Equal(t, 302.0, intensity)
+	}
+	assert.Equal(t, 1, requests)
+
+	_, err := cache.Intensity(context.Background(), "nowhere-1")
+	assert.Error(t, err)
+	assert.False(t, errors.Is(err, ErrNoIntensity))
+	_, err = cache.Intensity(context.Background(), "us-east-1")
+	assert.ErrorIs(t, err, ErrNoIntensity)
+}
+
+func TestNew(t *testing.T) {
+	_, err := New(Config{Provider: ProviderElectricityMaps})
+	assert.Error(t, err)
+	_, err = New(Config{Provider: "watttime"})
+	assert.Error(t, err)
+
+	provider, err := New(Config{Provider: ProviderStatic})
+	require.NoError(t, err)
+	intensity, err := provider.Intensity(context.Background(), "us-east-1")
+	require.NoError(t, err)
+	assert.Equal(t, 379.0, intensity)
+}
+
+func TestEstimator(t *testing.T) {
+	m := metrics.NewAgentMetrics(prometheus.NewRegistry())
+	node := gpuNode(map[string]string{
+		gfdProductLabel:            "NVIDIA-A100-SXM4-80GB",
+		corev1.LabelTopologyRegion: "on-prem-dc1",
+	}, "8")
+	provider := Static{"on-prem-dc1": 100}
+	e, err := NewEstimator(node, 2, provider, m, 0)
+	require.NoError(t, err)
+	now := time.Unix(1700000000, 0)
+	e.now = func() time.Time { return now }
+	e.last = now
+	ctx := context.Background()
+
+	// Two A100s at 50% for an hour draw 2 * 225W
+	m.RecordGPUMetrics(ctx, "gpu-node", 50, 40, 80)
+	m.RecordTokens(ctx, 300_000, 150_000, "llama-3-70b")
+	now = now.Add(time.Hour)
+	require.NoError(t, e.Estimate(ctx))
+
+	assert.InDelta(t, 0.45, testutil.ToFloat64(m.EnergyKWH), 1e-9)
+	assert.InDelta(t, 45, testutil.ToFloat64(m.CarbonGrams), 1e-9)
+	assert.InDelta(t, 0.001, testutil.ToFloat64(m.EnergyKWHPer1KTokens), 1e-9)
+	assert.InDelta(t, 0.1, testutil.ToFloat64(m.CarbonGramsPer1KTokens), 1e-9)
+	assert.Equal(t, 100.0, testutil.ToFloat64(m.CarbonIntensity))
+
+	// Without an intensity the last known one is used
+	delete(provider, "on-prem-dc1")
+	m.RecordTokens(ctx, 450_000, 0, "llama-3-70b")
+	now = now.Add(time.Hour)
+	assert.ErrorIs(t, e.Estimate(ctx), ErrNoIntensity)
+	assert.InDelta(t, 0.9, testutil.ToFloat64(m.EnergyKWH), 1e-9)
+	assert.InDelta(t, 90, testutil.ToFloat64(m.CarbonGrams), 1e-9)
+
+	_, err = NewEstimator(gpuNode(map[string]string{gfdProductLabel: "Unknown-GPU"}, "1"), 0, provider, m, 0)
+	assert.Error(t, err)
+}
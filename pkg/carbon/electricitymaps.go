@@ -0,0 +1,83 @@
+package carbon
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const electricityMapsEndpoint = "https://api.electricitymap.org/v3/carbon-intensity/latest"
+
+// DefaultZones maps the regions of DefaultIntensity to the Electricity Maps
+// zones of their grids
+var DefaultZones = map[string]string{
+	"us-east-1":      "US-MIDA-PJM",
+	"us-east-2":      "US-MIDA-PJM",
+	"us-west-2":      "US-NW-BPAT",
+	"eu-west-1":      "IE",
+	"eu-central-1":   "DE",
+	"eu-north-1":     "SE-SE3",
+	"ap-northeast-1": "JP-TK",
+	"us-central1":    "US-MIDW-MISO",
+	"us-east4":       "US-MIDA-PJM",
+	"europe-west4":   "NL",
+	"asia-east1":     "TW",
+	"eastus":         "US-MIDA-PJM",
+	"westus2":        "US-NW-PACW",
+	"westeurope":     "NL",
+}
+
+// ElectricityMaps reports the live carbon intensity of the grid of regions
+// from the Electricity Maps API
+type ElectricityMaps struct {
+	client   *http.Client
+	endpoint string
+	token    string
+	zones    map[string]string
+}
+
+// NewElectricityMaps creates a provider for the Electricity Maps API,
+// mapping regions to zones with zones
+func NewElectricityMaps(token string, zones map[string]string) *ElectricityMaps {
+	return &ElectricityMaps{
+		client:   &http.Client{Timeout: 10 * time.Second},
+		endpoint: electricityMapsEndpoint,
+		token:    token,
+		zones:    zones,
+	}
+}
+
+// Intensity reports the latest intensity of the zone of region
+func (e *ElectricityMaps) Intensity(ctx context.Context, region string) (float64, error) {
+	zone, ok := e.zones[region]
+	if !ok {
+		return 0, fmt.Errorf("%w: region %q has no Electricity Maps zone", ErrNoIntensity, region)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, e.endpoint+"?"+url.Values{"zone": {zone}}.Encode(), nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("auth-token", e.token)
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get carbon intensity of %s: %w", zone, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("failed to get carbon intensity of %s: unexpected status %s", zone, resp.Status)
+	}
+
+	var latest struct {
+		CarbonIntensity *float64 `json:"carbonIntensity"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&latest); err != nil {
+		return 0, fmt.Errorf("failed to parse carbon intensity of %s: %w", zone, err)
+	}
+	if latest.CarbonIntensity == nil {
+		return 0, fmt.Errorf("%w: zone %s reported no carbon intensity", ErrNoIntensity, zone)
+	}
+	return *latest.CarbonIntensity, nil
+}
@@ -0,0 +1,131 @@
+package carbon
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/bowenislandsong/neuronetes/pkg/metrics"
+	"github.com/bowenislandsong/neuronetes/pkg/pricing"
+)
+
+// DefaultEstimateInterval is how often an Estimator updates the energy
+// metrics
+const DefaultEstimateInterval = time.Minute
+
+// Estimator continuously estimates the energy and emissions of the GPUs of
+// an agent runtime and of the tokens they generate. It is the EnergyMeter
+// of the runtime's AgentMetrics, so the tokens recorded and the GPU
+// utilization reported by the dcgm Recorder feed it; every interval it
+// turns them into energy_kwh_per_1k_tokens and carbon_grams_per_1k_tokens.
+type Estimator struct {
+	power     GPUPower
+	gpus      int64
+	region    string
+	provider  Provider
+	metrics   *metrics.AgentMetrics
+	interval  time.Duration
+	now       func() time.Time
+	intensity float64
+
+	mu          sync.Mutex
+	tokens      int64
+	utilization float64
+	last        time.Time
+}
+
+// NewEstimator creates an estimator for gpus GPUs of node, or all of them
+// if gpus is zero, priced in carbon by provider, and sets it as the
+// EnergyMeter of agentMetrics. The power draw of the GPUs is looked up in
+// DefaultGPUPower. A zero interval uses DefaultEstimateInterval.
+func NewEstimator(node *corev1.Node, gpus int64, provider Provider, agentMetrics *metrics.AgentMetrics, interval time.Duration) (*Estimator, error) {
+	model := GPUModelOf(node)
+	power, ok := PowerOf(DefaultGPUPower, model)
+	if !ok {
+		return nil, fmt.Errorf("unknown power draw of GPU model %q of node %s", model, node.Name)
+	}
+	instance := pricing.InstanceOf(node)
+	if gpus <= 0 {
+		gpus = instance.GPUs
+	}
+	if gpus <= 0 {
+		return nil, fmt.Errorf("node %s has no GPUs", node.Name)
+	}
+	if interval <= 0 {
+		interval = DefaultEstimateInterval
+	}
+
+	e := &Estimator{
+		power:    power,
+		gpus:     gpus,
+		region:   instance.Region,
+		provider: provider,
+		metrics:  agentMetrics,
+		interval: interval,
+		now:      time.Now,
+	}
+	e.last = e.now()
+	agentMetrics.SetEnergyMeter(e)
+	return e, nil
+}
+
+// RecordTokens implements metrics.EnergyMeter
+func (e *Estimator) RecordTokens(tokens int64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.tokens += tokens
+}
+
+// RecordUtilization implements metrics.EnergyMeter
+func (e *Estimator) RecordUtilization(utilization float64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.utilization = utilization
+}
+
+// Estimate records the energy and emissions since the last estimate, at
+// the last GPU utilization reported. Emissions use the last intensity
+// known when the provider fails, and the error is returned.
+func (e *Estimator) Estimate(ctx context.Context) error {
+	e.mu.Lock()
+	now := e.now()
+	elapsed := now.Sub(e.last)
+	tokens := e.tokens
+	utilization := e.utilization
+	e.last = now
+	e.tokens = 0
+	e.mu.Unlock()
+
+	energyKWH := e.power.Watts(utilization) * float64(e.gpus) * elapsed.Hours() / 1000
+	intensity, err := e.provider.Intensity(ctx, e.region)
+	if err == nil {
+		e.intensity = intensity
+		e.metrics.SetCarbonIntensity(intensity)
+	}
+	e.metrics.RecordEnergy(ctx, energyKWH, energyKWH*e.intensity, tokens)
+	if err != nil {
+		return fmt.Errorf("failed to get carbon intensity of %s: %w", e.region, err)
+	}
+	return nil
+}
+
+// Start estimates every interval until ctx is done
+func (e *Estimator) Start(ctx context.Context) error {
+	logger := log.FromContext(ctx).WithValues("region", e.region)
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := e.Estimate(ctx); err != nil {
+				logger.Error(err, "failed to estimate carbon emissions")
+			}
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package carbon
+
+import (
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/bowenislandsong/neuronetes/pkg/gpu"
+)
+
+// Node labels naming the GPU model of a node
+const (
+	// gfdProductLabel is set by GPU Feature Discovery, e.g.
+	// NVIDIA-A100-SXM4-80GB
+	gfdProductLabel = "nvidia.com/gpu.product"
+)
+
+// GPUPower is the power draw of a GPU model in watts
+type GPUPower struct {
+	// IdleWatts is drawn by an idle GPU
+	IdleWatts float64 `json:"idleWatts"`
+
+	// MaxWatts is drawn at full utilization, the board power limit
+	MaxWatts float64 `json:"maxWatts"`
+}
+
+// Watts returns the power drawn at utilization, in percent, interpolating
+// linearly between idle and full utilization
+func (p GPUPower) Watts(utilization float64) float64 {
+	if utilization < 0 {
+		utilization = 0
+	} else if utilization > 100 {
+		utilization = 100
+	}
+	return p.IdleWatts + (p.MaxWatts-p.IdleWatts)*utilization/100
+}
+
+// DefaultGPUPower is the power draw of common GPU models, keyed by the
+// model name found in their product labels
+var DefaultGPUPower = map[string]GPUPower{
+	"H100": {IdleWatts: 70, MaxWatts: 700},
+	"A100": {IdleWatts: 50, MaxWatts: 400},
+	"L40S": {IdleWatts: 35, MaxWatts: 350},
+	"L40":  {IdleWatts: 35, MaxWatts: 300},
+	"A10G": {IdleWatts: 30, MaxWatts: 300},
+	"L4":   {IdleWatts: 16, MaxWatts: 72},
+	"V100": {IdleWatts: 40, MaxWatts: 300},
+	"T4":   {IdleWatts: 10, MaxWatts: 70},
+}
+
+// instanceGPUs is the GPU model of instance families whose nodes lack
+// GPU product labels
+var instanceGPUs = map[string]string{
+	"p5":   "H100",
+	"p4d":  "A100",
+	"p4de": "A100",
+	"g6e":  "L40S",
+	"g6":   "L4",
+	"g5":   "A10G",
+	"p3":   "V100",
+	"g4dn": "T4",
+	"a3":   "H100",
+	"a2":   "A100",
+	"g2":   "L4",
+}
+
+// GPUModelOf returns the GPU model of node as labeled by GPU Feature
+// Discovery or GKE, or implied by its instance family, and "" if unknown
+func GPUModelOf(node *corev1.Node) string {
+	if product := node.Labels[gfdProductLabel]; product != "" {
+		return product
+	}
+	if accelerator := node.Labels[gpu.GKEAcceleratorLabel]; accelerator != "" {
+		return accelerator
+	}
+	// AWS instance types, e.g. p4d.24xlarge, or GCE machine types, e.g.
+	// a2-highgpu-1g
+	family, _, _ := strings.Cut(node.Labels[corev1.LabelInstanceTypeStable], ".")
+	family, _, _ = strings.Cut(family, "-")
+	return instanceGPUs[family]
+}
+
+// PowerOf returns the power draw of the GPU model named by model from
+// table, matching the longest model name model contains, e.g. A100 for
+// NVIDIA-A100-SXM4-80GB
+func PowerOf(table map[string]GPUPower, model string) (GPUPower, bool) {
+	model = strings.ToUpper(model)
+	var match string
+	for name := range table {
+		if strings.Contains(model, strings.ToUpper(name)) && len(name) > len(match) {
+			match = name
+		}
+	}
+	if match == "" {
+		return GPUPower{}, false
+	}
+	return table[match], true
+}
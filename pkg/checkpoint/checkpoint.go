@@ -0,0 +1,184 @@
+// Package checkpoint snapshots the GPU memory of idle agent replicas and
+// restores it, so that activating a warm replica takes seconds instead of a
+// cold start that loads its model again. GPU memory is snapshotted with
+// NVIDIA's cuda-checkpoint, which suspends the CUDA state of a process and
+// moves its device memory to the host, or through the snapshot API of the
+// agent runtime, such as vLLM's sleep mode. Whole-process checkpoints with
+// CRIU are not taken, as the kubelet cannot restore them into a running pod;
+// CRIU's own CUDA support is built on cuda-checkpoint.
+package checkpoint
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+)
+
+const (
+	// DefaultIdleAfter is how long a replica stays warm before it is
+	// snapshotted unless configured otherwise
+	DefaultIdleAfter = 5 * time.Minute
+
+	// DefaultProcessID is the process cuda-checkpoint suspends unless
+	// configured otherwise, the entrypoint of the agent container
+	DefaultProcessID = 1
+
+	// DefaultSnapshotPath and DefaultRestorePath are the snapshot API of
+	// vLLM's sleep mode
+	DefaultSnapshotPath = "/sleep"
+	DefaultRestorePath  = "/wake_up"
+
+	// defaultTimeout bounds a snapshot or restore, which moves the GPU
+	// memory of a replica to or from the host
+	defaultTimeout = 2 * time.Minute
+)
+
+// CUDA states reported by cuda-checkpoint --get-state
+const (
+	cudaRunning      = "running"
+	cudaCheckpointed = "checkpointed"
+)
+
+// Snapshotter snapshots and restores the GPU memory of agent replicas
+type Snapshotter interface {
+	// Snapshot releases the GPU memory of pod, keeping its state to restore
+	Snapshot(ctx context.Context, pod *corev1.Pod, config *neuronetes.SnapshotConfig) error
+
+	// Restore brings the GPU memory of a snapshotted pod back
+	Restore(ctx context.Context, pod *corev1.Pod, config *neuronetes.SnapshotConfig) error
+}
+
+// Executor runs a command in a container of a pod and returns its output
+type Executor interface {
+	Exec(ctx context.Context, pod *corev1.Pod, container string, command []string) (string, error)
+}
+
+// Client snapshots replicas by the method of their pool: cuda-checkpoint
+// is run in the agent container through exec, and the snapshot API of the
+// runtime is called on the pod's IP
+type Client struct {
+	exec      Executor
+	http      *http.Client
+	container string
+	port      int32
+}
+
+var _ Snapshotter = &Client{}
+
+// NewClient creates a client snapshotting the agent container of replicas,
+// whose runtime serves on port unless its pool configures another
+func NewClient(exec Executor, container string, port int32) *Client {
+	return &Client{
+		exec:      exec,
+		http:      &http.Client{Timeout: defaultTimeout},
+		container: container,
+		port:      port,
+	}
+}
+
+// Snapshot implements Snapshotter
+func (c *Client) Snapshot(ctx context.Context, pod *corev1.Pod, config *neuronetes.SnapshotConfig) error {
+	if config.Method == neuronetes.SnapshotMethodRuntime {
+		return c.post(ctx, pod, config, snapshotPath(config))
+	}
+	return c.cudaCheckpoint(ctx, pod, config, cudaCheckpointed)
+}
+
+// Restore implements Snapshotter
+func (c *Client) Restore(ctx context.Context, pod *corev1.Pod, config *neuronetes.SnapshotConfig) error {
+	if config.Method == neuronetes.SnapshotMethodRuntime {
+		return c.post(ctx, pod, config, restorePath(config))
+	}
+	return c.cudaCheckpoint(ctx, pod, config, cudaRunning)
+}
+
+// cudaCheckpoint toggles the CUDA state of the agent process to want,
+// unless it is already there. Toggling is not idempotent, so the state is
+// read first.
+func (c *Client) cudaCheckpoint(ctx context.Context, pod *corev1.Pod, config *neuronetes.SnapshotConfig, want string) error {
+	if c.exec == nil {
+		return fmt.Errorf("cuda-checkpoint requires exec into pods")
+	}
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
+
+	pid := strconv.Itoa(int(processID(config)))
+	out, err := c.exec.Exec(ctx, pod, c.container, []string{"cuda-checkpoint", "--get-state", "--pid", pid})
+	if err != nil {
+		return fmt.Errorf("failed to get the CUDA state of process %s of pod %s: %w", pid, pod.Name, err)
+	}
+	switch state := strings.TrimSpace(out); state {
+	case want:
+		return nil
+	case cudaRunning, cudaCheckpointed:
+	default:
+		return fmt.Errorf("process %s of pod %s is %q", pid, pod.Name, state)
+	}
+	if _, err := c.exec.Exec(ctx, pod, c.container, []string{"cuda-checkpoint", "--toggle", "--pid", pid}); err != nil {
+		return fmt.Errorf("failed to toggle the CUDA state of process %s of pod %s: %w", pid, pod.Name, err)
+	}
+	return nil
+}
+
+// post calls path of the snapshot API of the runtime of pod
+func (c *Client) post(ctx context.Context, pod *corev1.Pod, config *neuronetes.SnapshotConfig, path string) error {
+	if pod.Status.PodIP == "" {
+		return fmt.Errorf("pod %s has no IP", pod.Name)
+	}
+	port := c.port
+	if config.Runtime != nil && config.Runtime.Port != nil {
+		port = *config.Runtime.Port
+	}
+	url := "http://" + net.JoinHostPort(pod.Status.PodIP, strconv.Itoa(int(port))) + path
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call %s of pod %s: %w", path, pod.Name, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("%s of pod %s returned %s", path, pod.Name, resp.Status)
+	}
+	return nil
+}
+
+// IdleAfter returns how long a replica of config stays warm before it is
+// snapshotted
+func IdleAfter(config *neuronetes.SnapshotConfig) time.Duration {
+	if config.IdleAfter != nil {
+		return config.IdleAfter.Duration
+	}
+	return DefaultIdleAfter
+}
+
+func processID(config *neuronetes.SnapshotConfig) int32 {
+	if config.ProcessID != nil {
+		return *config.ProcessID
+	}
+	return DefaultProcessID
+}
+
+func snapshotPath(config *neuronetes.SnapshotConfig) string {
+	if config.Runtime != nil && config.Runtime.SnapshotPath != "" {
+		return config.Runtime.SnapshotPath
+	}
+	return DefaultSnapshotPath
+}
+
+func restorePath(config *neuronetes.SnapshotConfig) string {
+	if config.Runtime != nil && config.Runtime.RestorePath != "" {
+		return config.Runtime.RestorePath
+	}
+	return DefaultRestorePath
+}
@@ -0,0 +1,96 @@
+package checkpoint
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+)
+
+// fakeExecutor runs cuda-checkpoint against a process of a given state
+type fakeExecutor struct {
+	state    string
+	commands []string
+}
+
+func (e *fakeExecutor) Exec(ctx context.Context, pod *corev1.Pod, container string, command []string) (string, error) {
+	e.commands = append(e.commands, container+": "+strings.Join(command, " "))
+	if command[1] == "--toggle" {
+		if e.state == cudaRunning {
+			e.state = cudaCheckpointed
+		} else {
+			e.state = cudaRunning
+		}
+		return "", nil
+	}
+	return e.state + "\n", nil
+}
+
+func testPod() *corev1.Pod {
+	return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "chat-pool-0", Namespace: "default"}}
+}
+
+func TestClientCUDACheckpoint(t *testing.T) {
+	ctx := context.Background()
+	exec := &fakeExecutor{state: cudaRunning}
+	client := NewClient(exec, "agent", 8080)
+	pid := int32(7)
+	config := &neuronetes.SnapshotConfig{Method: neuronetes.SnapshotMethodCUDACheckpoint, ProcessID: &pid}
+
+	require.NoError(t, client.Snapshot(ctx, testPod(), config))
+	assert.Equal(t, cudaCheckpointed, exec.state)
+	assert.Equal(t, []string{
+		"agent: cuda-checkpoint --get-state --pid 7",
+		"agent: cuda-checkpoint --toggle --pid 7",
+	}, exec.commands)
+
+	// A snapshotted process is not toggled back by a second snapshot
+	require.NoError(t, client.Snapshot(ctx, testPod(), config))
+	assert.Equal(t, cudaCheckpointed, exec.state)
+
+	require.NoError(t, client.Restore(ctx, testPod(), config))
+	assert.Equal(t, cudaRunning, exec.state)
+
+	exec.state = "failed"
+	assert.Error(t, client.Restore(ctx, testPod(), config))
+	assert.Error(t, NewClient(nil, "agent", 8080).Snapshot(ctx, testPod(), config))
+}
+
+func TestClientRuntimeAPI(t *testing.T) {
+	var calls []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, r.Method+" "+r.URL.Path)
+		if r.URL.Path == "/broken" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+	host, portString, err := net.SplitHostPort(strings.TrimPrefix(server.URL, "http://"))
+	require.NoError(t, err)
+	port, err := strconv.Atoi(portString)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	pod := testPod()
+	pod.Status.PodIP = host
+	client := NewClient(nil, "agent", int32(port))
+	config := &neuronetes.SnapshotConfig{Method: neuronetes.SnapshotMethodRuntime}
+
+	require.NoError(t, client.Snapshot(ctx, pod, config))
+	require.NoError(t, client.Restore(ctx, pod, config))
+	assert.Equal(t, []string{"POST /sleep", "POST /wake_up"}, calls)
+
+	config.Runtime = &neuronetes.RuntimeSnapshotAPI{RestorePath: "/broken"}
+	assert.Error(t, client.Restore(ctx, pod, config))
+	assert.Error(t, client.Snapshot(ctx, testPod(), config))
+}
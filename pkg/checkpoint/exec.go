@@ -0,0 +1,62 @@
+package checkpoint
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// PodExecutor runs commands in containers through the exec subresource of
+// pods, as kubectl exec does
+type PodExecutor struct {
+	config *rest.Config
+	client kubernetes.Interface
+}
+
+var _ Executor = &PodExecutor{}
+
+// NewPodExecutor creates an executor connecting to the API server of config
+func NewPodExecutor(config *rest.Config) (*PodExecutor, error) {
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return &PodExecutor{config: config, client: client}, nil
+}
+
+// Exec implements Executor. The output of a failed command is included in
+// its error.
+func (e *PodExecutor) Exec(ctx context.Context, pod *corev1.Pod, container string, command []string) (string, error) {
+	req := e.client.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(pod.Namespace).
+		Name(pod.Name).
+		SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			Container: container,
+			Command:   command,
+			Stdout:    true,
+			Stderr:    true,
+		}, scheme.ParameterCodec)
+	executor, err := remotecommand.NewSPDYExecutor(e.config, http.MethodPost, req.URL())
+	if err != nil {
+		return "", err
+	}
+
+	var stdout, stderr bytes.Buffer
+	if err := executor.StreamWithContext(ctx, remotecommand.StreamOptions{Stdout: &stdout, Stderr: &stderr}); err != nil {
+		if output := strings.TrimSpace(stderr.String() + stdout.String()); output != "" {
+			return "", fmt.Errorf("%w: %s", err, output)
+		}
+		return "", err
+	}
+	return stdout.String(), nil
+}
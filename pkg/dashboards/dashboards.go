@@ -0,0 +1,404 @@
+// Package dashboards generates Grafana dashboards for the metric families of
+// NeuroNetes. Dashboards are parameterized by namespace, pool and model
+// template variables, and rendered as dashboard JSON for file provisioning
+// or as GrafanaDashboard resources for the Grafana operator.
+package dashboards
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// Dashboard names
+const (
+	PoolOverview  = "pool-overview"
+	GPUEfficiency = "gpu-efficiency"
+	Cost          = "cost"
+	RAGQuality    = "rag-quality"
+)
+
+// schemaVersion is the Grafana dashboard schema the dashboards are written in
+const schemaVersion = 38
+
+// Template variable selectors. Pool and namespace labels are attached to an
+// agent's series by the scrape configuration, like the autoscaler's
+// DefaultPoolSelector expects; model labels are set by the agents.
+const (
+	poolSelector  = `namespace=~"$namespace",pool=~"$pool"`
+	modelSelector = poolSelector + `,model=~"$model"`
+)
+
+// Panel layout
+const (
+	panelWidth  = 12
+	panelHeight = 8
+	gridWidth   = 24
+)
+
+// Dashboard is a Grafana dashboard model
+type Dashboard struct {
+	UID           string     `json:"uid"`
+	Title         string     `json:"title"`
+	Description   string     `json:"description,omitempty"`
+	Tags          []string   `json:"tags"`
+	Timezone      string     `json:"timezone"`
+	SchemaVersion int        `json:"schemaVersion"`
+	Refresh       string     `json:"refresh"`
+	Time          TimeRange  `json:"time"`
+	Templating    Templating `json:"templating"`
+	Panels        []Panel    `json:"panels"`
+}
+
+// TimeRange is the default time range of a dashboard
+type TimeRange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// Templating holds the template variables of a dashboard
+type Templating struct {
+	List []Variable `json:"list"`
+}
+
+// Variable is a dashboard template variable
+type Variable struct {
+	Name       string         `json:"name"`
+	Label      string         `json:"label"`
+	Type       string         `json:"type"`
+	Query      string         `json:"query"`
+	Datasource *DatasourceRef `json:"datasource,omitempty"`
+	Refresh    int            `json:"refresh,omitempty"`
+	IncludeAll bool           `json:"includeAll,omitempty"`
+	Multi      bool           `json:"multi,omitempty"`
+	AllValue   string         `json:"allValue,omitempty"`
+	Sort       int            `json:"sort,omitempty"`
+}
+
+// DatasourceRef references the data source of a panel, target or variable
+type DatasourceRef struct {
+	Type string `json:"type"`
+	UID  string `json:"uid"`
+}
+
+// Panel is a dashboard panel
+type Panel struct {
+	ID          int            `json:"id"`
+	Title       string         `json:"title"`
+	Type        string         `json:"type"`
+	Datasource  *DatasourceRef `json:"datasource,omitempty"`
+	GridPos     GridPos        `json:"gridPos"`
+	FieldConfig FieldConfig    `json:"fieldConfig"`
+	Targets     []Target       `json:"targets"`
+}
+
+// GridPos places a panel on the dashboard grid
+type GridPos struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+	W int `json:"w"`
+	H int `json:"h"`
+}
+
+// FieldConfig configures how the values of a panel are displayed
+type FieldConfig struct {
+	Defaults  FieldDefaults `json:"defaults"`
+	Overrides []interface{} `json:"overrides"`
+}
+
+// FieldDefaults are the display options of all fields of a panel
+type FieldDefaults struct {
+	Unit string   `json:"unit,omitempty"`
+	Min  *float64 `json:"min,omitempty"`
+	Max  *float64 `json:"max,omitempty"`
+}
+
+// Target is a PromQL query of a panel
+type Target struct {
+	RefID        string         `json:"refId"`
+	Expr         string         `json:"expr"`
+	LegendFormat string         `json:"legendFormat,omitempty"`
+	Datasource   *DatasourceRef `json:"datasource,omitempty"`
+
+	// Exemplar shows the exemplars of the histogram queried, linking to
+	// the traces and requests that landed in its buckets
+	Exemplar bool `json:"exemplar,omitempty"`
+}
+
+// datasource references the data source picked by the datasource variable
+var datasource = &DatasourceRef{Type: "prometheus", UID: "${datasource}"}
+
+// query returns a target for expr with legend
+func query(expr, legend string) Target {
+	return Target{Expr: expr, LegendFormat: legend}
+}
+
+// exemplarQuery returns a target for expr with legend showing exemplars
+func exemplarQuery(expr, legend string) Target {
+	return Target{Expr: expr, LegendFormat: legend, Exemplar: true}
+}
+
+// board builds a dashboard, laying panels out in two columns
+type board struct {
+	dashboard Dashboard
+}
+
+func newBoard(name, title, description string, variables ...Variable) *board {
+	list := []Variable{{
+		Name:  "datasource",
+		Label: "Data source",
+		Type:  "datasource",
+		Query: "prometheus",
+	}}
+	return &board{dashboard: Dashboard{
+		UID:           "neuronetes-" + name,
+		Title:         title,
+		Description:   description,
+		Tags:          []string{"neuronetes"},
+		Timezone:      "browser",
+		SchemaVersion: schemaVersion,
+		Refresh:       "30s",
+		Time:          TimeRange{From: "now-6h", To: "now"},
+		Templating:    Templating{List: append(list, variables...)},
+	}}
+}
+
+// panel adds a time series panel of targets shown in unit
+func (b *board) panel(title, unit string, targets ...Target) *Panel {
+	i := len(b.dashboard.Panels)
+	for j := range targets {
+		targets[j].RefID = string(rune('A' + j))
+		targets[j].Datasource = datasource
+	}
+	b.dashboard.Panels = append(b.dashboard.Panels, Panel{
+		ID:         i + 1,
+		Title:      title,
+		Type:       "timeseries",
+		Datasource: datasource,
+		GridPos: GridPos{
+			X: (i * panelWidth) % gridWidth,
+			Y: (i * panelWidth / gridWidth) * panelHeight,
+			W: panelWidth,
+			H: panelHeight,
+		},
+		FieldConfig: FieldConfig{Defaults: FieldDefaults{Unit: unit}, Overrides: []interface{}{}},
+		Targets:     targets,
+	})
+	return &b.dashboard.Panels[i]
+}
+
+// ratioPanel adds a panel of ratios between 0 and 1
+func (b *board) ratioPanel(title string, targets ...Target) {
+	p := b.panel(title, "percentunit", targets...)
+	zero, one := 0.0, 1.0
+	p.FieldConfig.Defaults.Min, p.FieldConfig.Defaults.Max = &zero, &one
+}
+
+// percentPanel adds a panel of percentages between 0 and 100
+func (b *board) percentPanel(title string, targets ...Target) {
+	p := b.panel(title, "percent", targets...)
+	zero, hundred := 0.0, 100.0
+	p.FieldConfig.Defaults.Min, p.FieldConfig.Defaults.Max = &zero, &hundred
+}
+
+// labelVariable returns a multi-value variable over the values of label on
+// metric, filtered by the variables before it
+func labelVariable(name, label, metric, filter string) Variable {
+	selector := metric
+	if filter != "" {
+		selector = fmt.Sprintf("%s{%s}", metric, filter)
+	}
+	return Variable{
+		Name:       name,
+		Label:      label,
+		Type:       "query",
+		Query:      fmt.Sprintf("label_values(%s, %s)", selector, name),
+		Datasource: datasource,
+		Refresh:    2,
+		IncludeAll: true,
+		Multi:      true,
+		AllValue:   ".*",
+		Sort:       1,
+	}
+}
+
+// poolVariables are the namespace and pool variables, whose values are
+// read from metric
+func poolVariables(metric string) []Variable {
+	return []Variable{
+		labelVariable("namespace", "Namespace", metric, ""),
+		labelVariable("pool", "Pool", metric, `namespace=~"$namespace"`),
+	}
+}
+
+// modelVariable is the model variable, whose values are read from metric
+func modelVariable(metric string) Variable {
+	return labelVariable("model", "Model", metric, poolSelector)
+}
+
+// quantile returns the PromQL for quantile q of histogram over its
+// buckets selected by selector, by the labels in by
+func quantile(q float64, histogram, selector, by string) string {
+	labels := "le"
+	if by != "" {
+		labels = by + ", le"
+	}
+	return fmt.Sprintf("histogram_quantile(%g, sum by (%s) (rate(%s_bucket{%s}[$__rate_interval])))", q, labels, histogram, selector)
+}
+
+// Generate returns the dashboard called name
+func Generate(name string) (*Dashboard, error) {
+	generate, ok := generators[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown dashboard %q", name)
+	}
+	return generate(), nil
+}
+
+// Names returns the names of all dashboards, sorted
+func Names() []string {
+	names := make([]string, 0, len(generators))
+	for name := range generators {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// JSON returns the dashboard JSON model of d, as loaded by Grafana's file
+// provisioning and dashboard sidecars
+func (d *Dashboard) JSON() ([]byte, error) {
+	return json.MarshalIndent(d, "", "  ")
+}
+
+var generators = map[string]func() *Dashboard{
+	PoolOverview:  poolOverview,
+	GPUEfficiency: gpuEfficiency,
+	Cost:          cost,
+	RAGQuality:    ragQuality,
+}
+
+// poolOverview shows the user-facing latency, load and errors of pools
+func poolOverview() *Dashboard {
+	b := newBoard(PoolOverview, "NeuroNetes Pool Overview",
+		"Latency, throughput, load and errors of AgentPools",
+		append(poolVariables("agent_ttft_ms_count"), modelVariable("agent_ttft_ms_count"))...)
+
+	b.panel("TTFT P95", "ms",
+		exemplarQuery(quantile(0.95, "agent_ttft_ms", modelSelector, "model"), "{{model}}"))
+	b.panel("Turn Latency P95", "ms",
+		exemplarQuery(quantile(0.95, "agent_latency_ms", modelSelector, "model"), "{{model}}"))
+	b.panel("Output Tokens/s", "short",
+		query(fmt.Sprintf("sum by (model) (rate(agent_output_tokens_total{%s}[$__rate_interval]))", modelSelector), "{{model}}"))
+	b.panel("Input Tokens/s", "short",
+		query(fmt.Sprintf("sum by (model) (rate(agent_input_tokens_total{%s}[$__rate_interval]))", modelSelector), "{{model}}"))
+	b.panel("Active Sessions", "short",
+		query(fmt.Sprintf("sum by (pool) (agent_active_sessions{%s})", poolSelector), "{{pool}}"))
+	b.panel("Queue Depth", "short",
+		query(fmt.Sprintf("sum by (pool) (agent_queue_depth{%s})", poolSelector), "{{pool}}"),
+		query(fmt.Sprintf("sum by (pool) (agent_tokens_in_queue{%s})", poolSelector), "{{pool}} tokens"))
+	b.ratioPanel("Error Ratio",
+		query(fmt.Sprintf("sum by (error_type) (rate(agent_turn_errors_total{%s}[$__rate_interval])) / ignoring (error_type) group_left sum(rate(agent_latency_ms_count{%s}[$__rate_interval]))",
+			modelSelector, modelSelector), "{{error_type}}"))
+	b.panel("Admission Rejects/s", "short",
+		query(fmt.Sprintf("sum by (pool) (rate(agent_admission_rejects_total{%s}[$__rate_interval]))", poolSelector), "{{pool}}"))
+	b.panel("Cold Start P95", "s",
+		query(quantile(0.95, "agent_cold_start_seconds", poolSelector, "pool"), "{{pool}}"))
+	b.panel("Scaling Lag P95", "s",
+		query(quantile(0.95, "agent_scaling_lag_seconds", poolSelector, "pool"), "{{pool}}"))
+	b.panel("Tool Latency P95", "ms",
+		query(quantile(0.95, "agent_tool_latency_ms", poolSelector, "tool"), "{{tool}}"))
+	b.panel("Error Budget Burn Rate", "short",
+		query(`max by (agentclass, slo, window) (error_budget_burn_rate{namespace=~"$namespace"})`, "{{agentclass}} {{slo}} {{window}}"))
+	return &b.dashboard
+}
+
+// gpuEfficiency shows how well pools use their GPUs
+func gpuEfficiency() *Dashboard {
+	b := newBoard(GPUEfficiency, "NeuroNetes GPU Efficiency",
+		"GPU utilization, memory and batching of AgentPools",
+		poolVariables("gpu_util_pct")...)
+
+	b.percentPanel("GPU Utilization",
+		query(fmt.Sprintf("avg by (pool) (gpu_util_pct{%s})", poolSelector), "{{pool}}"))
+	b.percentPanel("SM Utilization",
+		query(fmt.Sprintf("avg by (pool) (gpu_sm_util_pct{%s})", poolSelector), "{{pool}}"))
+	b.percentPanel("Memory Bandwidth Utilization",
+		query(fmt.Sprintf("avg by (pool) (gpu_mem_bw_util_pct{%s})", poolSelector), "{{pool}}"))
+	b.percentPanel("MIG Slice Utilization",
+		query(fmt.Sprintf("avg by (pool) (gpu_mig_slice_util_pct{%s})", poolSelector), "{{pool}}"))
+	b.panel("VRAM Used", "decgbytes",
+		query(fmt.Sprintf("sum by (pool) (gpu_vram_used_gb{%s})", poolSelector), "{{pool}}"))
+	b.percentPanel("VRAM Fragmentation",
+		query(fmt.Sprintf("avg by (pool) (gpu_vram_frag_pct{%s})", poolSelector), "{{pool}}"))
+	b.panel("Output Tokens/s per Busy GPU", "short",
+		query(fmt.Sprintf("sum by (pool) (rate(agent_output_tokens_total{%s}[$__rate_interval])) / (sum by (pool) (gpu_util_pct{%s}) / 100)",
+			poolSelector, poolSelector), "{{pool}}"))
+	b.panel("Batch Size", "short",
+		query(fmt.Sprintf("avg by (pool) (agent_batch_size{%s})", poolSelector), "{{pool}}"))
+	b.ratioPanel("KV Cache Hit Ratio",
+		query(fmt.Sprintf("avg by (pool) (agent_kv_cache_hit_ratio{%s})", poolSelector), "{{pool}}"))
+	b.ratioPanel("Model Cache Hit Ratio",
+		query(fmt.Sprintf("avg by (pool) (model_cache_hit_ratio{%s})", poolSelector), "{{pool}}"))
+	b.panel("Model Load Time P95", "s",
+		query(quantile(0.95, "model_load_time_seconds", poolSelector, "model"), "{{model}}"))
+	b.panel("Context Length P95", "short",
+		query(fmt.Sprintf("max by (pool) (agent_ctx_len_p95{%s})", poolSelector), "{{pool}}"))
+	return &b.dashboard
+}
+
+// cost shows what pools spend on tokens and infrastructure
+func cost() *Dashboard {
+	b := newBoard(Cost, "NeuroNetes Cost",
+		"Token and infrastructure cost of AgentPools",
+		append(poolVariables("agent_output_tokens_total"), modelVariable("agent_output_tokens_total"))...)
+
+	b.panel("Cost per 1K Tokens", "currencyUSD",
+		query(fmt.Sprintf("avg by (model, tenant) (cost_usd_per_1k_tokens{%s})", modelSelector), "{{model}} {{tenant}}"))
+	b.panel("Cost per Session", "currencyUSD",
+		query(fmt.Sprintf("avg by (pool) (cost_usd_per_session{%s})", poolSelector), "{{pool}}"))
+	b.panel("Tokens per Hour", "short",
+		query(fmt.Sprintf("sum by (model) (increase(agent_total_tokens{%s}[1h]))", modelSelector), "{{model}}"))
+	b.panel("Estimated Token Spend per Hour", "currencyUSD",
+		query(fmt.Sprintf("sum by (model) (increase(agent_total_tokens{%s}[1h]) / 1000 * on (model) group_left avg by (model) (cost_usd_per_1k_tokens{%s}))",
+			modelSelector, modelSelector), "{{model}}"))
+	b.panel("GPU Hours per Hour", "short",
+		query(fmt.Sprintf("sum by (pool) (increase(pool_gpu_hours_total{%s}[1h]))", poolSelector), "{{pool}}"))
+	b.panel("GPU Cost per Hour", "currencyUSD",
+		query(fmt.Sprintf("sum by (pool) (increase(pool_cost_usd_total{%s}[1h]))", poolSelector), "{{pool}}"))
+	b.panel("Tenant Cost per Hour", "currencyUSD",
+		query(fmt.Sprintf("sum by (tenant) (increase(tenant_cost_usd_total{%s}[1h]))", poolSelector), "{{tenant}}"))
+	b.panel("CPU Hours per Hour", "short",
+		query(fmt.Sprintf("sum by (pool) (increase(cpu_hours_total{%s}[1h]))", poolSelector), "{{pool}}"))
+	b.panel("Egress per Hour", "decgbytes",
+		query(fmt.Sprintf("sum by (pool) (increase(egress_gb_total{%s}[1h]))", poolSelector), "{{pool}}"))
+	b.panel("Spot Interruptions", "short",
+		query(fmt.Sprintf("sum by (pool) (increase(spot_interruptions_total{%s}[1h]))", poolSelector), "{{pool}}"))
+	return &b.dashboard
+}
+
+// ragQuality shows the retrieval and grounding quality of agents
+func ragQuality() *Dashboard {
+	b := newBoard(RAGQuality, "NeuroNetes RAG Quality",
+		"Retrieval, grounding and tool quality of agents",
+		poolVariables("rag_retrieval_latency_ms_count")...)
+
+	b.panel("Retrieval Latency P95", "ms",
+		query(quantile(0.95, "rag_retrieval_latency_ms", poolSelector, "pool"), "{{pool}}"))
+	b.ratioPanel("Retrieval Cache Hit Ratio",
+		query(fmt.Sprintf("avg by (pool) (rag_retrieval_cache_hit_ratio{%s})", poolSelector), "{{pool}}"))
+	b.ratioPanel("Hit@K",
+		query(fmt.Sprintf("avg by (pool) (rag_hit_at_k{%s})", poolSelector), "{{pool}}"))
+	b.ratioPanel("MRR",
+		query(fmt.Sprintf("avg by (pool) (rag_mrr{%s})", poolSelector), "{{pool}}"))
+	b.ratioPanel("Grounding Coverage",
+		query(fmt.Sprintf("avg by (pool) (agent_grounding_coverage{%s})", poolSelector), "{{pool}}"))
+	b.ratioPanel("Citation Validity",
+		query(fmt.Sprintf("avg by (pool) (agent_citation_validity_rate{%s})", poolSelector), "{{pool}}"))
+	b.ratioPanel("Hallucination Rate",
+		query(fmt.Sprintf("avg by (pool) (agent_hallucination_rate{%s})", poolSelector), "{{pool}}"))
+	b.ratioPanel("Tool Success Rate",
+		query(fmt.Sprintf("avg by (pool) (agent_tool_success_rate{%s})", poolSelector), "{{pool}}"),
+		query(fmt.Sprintf("avg by (pool) (agent_tool_timeout_rate{%s})", poolSelector), "{{pool}} timeouts"))
+	return &b.dashboard
+}
@@ -0,0 +1,134 @@
+package dashboards
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bowenislandsong/neuronetes/pkg/metrics"
+)
+
+var (
+	fqNamePattern = regexp.MustCompile(`fqName: "([^"]+)"`)
+	metricPattern = regexp.MustCompile(`([a-zA-Z_:][a-zA-Z0-9_:]*)\{`)
+)
+
+// agentMetricNames returns the names of the metric families of AgentMetrics
+func agentMetricNames(t *testing.T) map[string]bool {
+	t.Helper()
+	m := metrics.NewAgentMetrics(prometheus.NewRegistry())
+	descs := make(chan *prometheus.Desc, 1024)
+	v := reflect.ValueOf(m).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if !v.Field(i).CanInterface() {
+			continue
+		}
+		if c, ok := v.Field(i).Interface().(prometheus.Collector); ok {
+			c.Describe(descs)
+		}
+	}
+	close(descs)
+
+	names := map[string]bool{}
+	for desc := range descs {
+		match := fqNamePattern.FindStringSubmatch(desc.String())
+		require.Len(t, match, 2)
+		names[match[1]] = true
+	}
+	return names
+}
+
+func TestDashboardsQueryKnownMetrics(t *testing.T) {
+	known := agentMetricNames(t)
+	for _, name := range Names() {
+		d, err := Generate(name)
+		require.NoError(t, err)
+		assert.Equal(t, "neuronetes-"+name, d.UID)
+		require.NotEmpty(t, d.Panels, name)
+
+		queries := []string{}
+		for _, v := range d.Templating.List {
+			queries = append(queries, v.Query)
+		}
+		seen := map[GridPos]bool{}
+		for i, p := range d.Panels {
+			assert.Equal(t, i+1, p.ID)
+			assert.False(t, seen[p.GridPos], "%s: panel %q overlaps another", name, p.Title)
+			seen[p.GridPos] = true
+			require.NotEmpty(t, p.Targets, p.Title)
+			for _, target := range p.Targets {
+				assert.Equal(t, datasource, target.Datasource)
+				queries = append(queries, target.Expr)
+			}
+		}
+
+		for _, q := range queries {
+			for _, match := range metricPattern.FindAllStringSubmatch(q, -1) {
+				metric := match[1]
+				for _, suffix := range []string{"_bucket", "_count", "_sum"} {
+					if base := strings.TrimSuffix(metric, suffix); known[base] {
+						metric = base
+					}
+				}
+				assert.True(t, known[metric], "%s: unknown metric %s in %s", name, metric, q)
+			}
+		}
+	}
+
+	_, err := Generate("unknown")
+	assert.Error(t, err)
+}
+
+func TestDashboardsFilterByTemplateVariables(t *testing.T) {
+	d, err := Generate(PoolOverview)
+	require.NoError(t, err)
+	var variables []string
+	for _, v := range d.Templating.List {
+		variables = append(variables, v.Name)
+	}
+	assert.Equal(t, []string{"datasource", "namespace", "pool", "model"}, variables)
+	assert.Equal(t, `label_values(agent_ttft_ms_count{namespace=~"$namespace"}, pool)`, d.Templating.List[2].Query)
+	assert.Equal(t,
+		`histogram_quantile(0.95, sum by (model, le) (rate(agent_ttft_ms_bucket{namespace=~"$namespace",pool=~"$pool",model=~"$model"}[$__rate_interval])))`,
+		d.Panels[0].Targets[0].Expr)
+	// Latency panels link to the traces and requests of slow buckets
+	assert.True(t, d.Panels[0].Targets[0].Exemplar)
+	assert.False(t, d.Panels[2].Targets[0].Exemplar)
+}
+
+func TestGeneratedDashboardsUpToDate(t *testing.T) {
+	for _, name := range Names() {
+		d, err := Generate(name)
+		require.NoError(t, err)
+		data, err := d.JSON()
+		require.NoError(t, err)
+		committed, err := os.ReadFile(filepath.Join("..", "..", "config", "grafana", "dashboards", name+".json"))
+		require.NoError(t, err)
+		assert.Equal(t, string(data)+"\n", string(committed),
+			"%s.json is stale, regenerate it with make dashboards", name)
+	}
+}
+
+func TestResources(t *testing.T) {
+	cm, err := ConfigMap(Cost, "monitoring")
+	require.NoError(t, err)
+	assert.Equal(t, "neuronetes-cost", cm.Name)
+	assert.Equal(t, "1", cm.Labels[SidecarLabel])
+	require.Contains(t, cm.Data, "cost.json")
+
+	dashboard, err := GrafanaDashboard(Cost, "monitoring", map[string]string{"dashboards": "grafana"})
+	require.NoError(t, err)
+	assert.Equal(t, GrafanaDashboardGVK, dashboard.GroupVersionKind())
+	assert.Equal(t, "grafana", dashboard.Object["spec"].(map[string]interface{})["instanceSelector"].(map[string]interface{})["matchLabels"].(map[string]interface{})["dashboards"])
+	var model Dashboard
+	require.NoError(t, json.Unmarshal([]byte(dashboard.Object["spec"].(map[string]interface{})["json"].(string)), &model))
+	assert.Equal(t, "NeuroNetes Cost", model.Title)
+}
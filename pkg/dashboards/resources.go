@@ -0,0 +1,72 @@
+package dashboards
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// SidecarLabel is the label the Grafana dashboard sidecar of the Grafana
+// Helm chart and kube-prometheus-stack loads ConfigMaps by
+const SidecarLabel = "grafana_dashboard"
+
+// GrafanaDashboardGVK is the Grafana operator's GrafanaDashboard kind
+var GrafanaDashboardGVK = schema.GroupVersionKind{Group: "grafana.integreatly.org", Version: "v1beta1", Kind: "GrafanaDashboard"}
+
+// resourceName names the resources holding the dashboard called name
+func resourceName(name string) string {
+	return "neuronetes-" + name
+}
+
+// ConfigMap returns a ConfigMap in namespace holding the dashboard called
+// name, labeled for the Grafana dashboard sidecar
+func ConfigMap(name, namespace string) (*corev1.ConfigMap, error) {
+	d, err := Generate(name)
+	if err != nil {
+		return nil, err
+	}
+	data, err := d.JSON()
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode dashboard %s: %w", name, err)
+	}
+	return &corev1.ConfigMap{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      resourceName(name),
+			Namespace: namespace,
+			Labels:    map[string]string{SidecarLabel: "1"},
+		},
+		Data: map[string]string{name + ".json": string(data)},
+	}, nil
+}
+
+// GrafanaDashboard returns a GrafanaDashboard in namespace holding the
+// dashboard called name, imported into the Grafana instances matching
+// instanceSelector
+func GrafanaDashboard(name, namespace string, instanceSelector map[string]string) (*unstructured.Unstructured, error) {
+	d, err := Generate(name)
+	if err != nil {
+		return nil, err
+	}
+	data, err := d.JSON()
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode dashboard %s: %w", name, err)
+	}
+
+	matchLabels := make(map[string]interface{}, len(instanceSelector))
+	for k, v := range instanceSelector {
+		matchLabels[k] = v
+	}
+	dashboard := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	dashboard.SetGroupVersionKind(GrafanaDashboardGVK)
+	dashboard.SetName(resourceName(name))
+	dashboard.SetNamespace(namespace)
+	dashboard.Object["spec"] = map[string]interface{}{
+		"instanceSelector": map[string]interface{}{"matchLabels": matchLabels},
+		"json":             string(data),
+	}
+	return dashboard, nil
+}
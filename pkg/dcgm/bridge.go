@@ -0,0 +1,271 @@
+package dcgm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/gpu"
+	"github.com/bowenislandsong/neuronetes/pkg/metrics"
+)
+
+const (
+	// DefaultBridgeInterval is how often a Bridge scrapes the exporters
+	DefaultBridgeInterval = 15 * time.Second
+
+	// bridgeConcurrency bounds the exporters a Bridge scrapes at once
+	bridgeConcurrency = 10
+)
+
+// PoolTelemetry is the state of the GPUs allocated to the replicas of a
+// pool
+type PoolTelemetry struct {
+	Telemetry
+
+	// Model is the model the replicas serve
+	Model string
+
+	// Replicas is the number of replicas holding GPUs
+	Replicas int
+}
+
+// +kubebuilder:rbac:groups=core,resources=pods;nodes,verbs=get;list;watch
+
+// Bridge maps the metrics of the dcgm-exporters already running in a
+// cluster to the AgentPools whose replicas hold the GPUs, through the
+// pod labels dcgm-exporter adds with Kubernetes mapping enabled. It records
+// the GPU usage of each pool and model in AgentMetrics, serves it to the
+// autoscaler, and serves the telemetry of each node to the scheduler.
+type Bridge struct {
+	reader   client.Reader
+	client   *Client
+	metrics  *metrics.AgentMetrics
+	interval time.Duration
+	now      func() time.Time
+
+	mu        sync.RWMutex
+	pools     map[types.NamespacedName]*PoolTelemetry
+	nodes     map[string]*Telemetry
+	collected time.Time
+}
+
+// NewBridge creates a bridge listing pods and nodes with reader and
+// scraping their exporters with dcgmClient every interval. agentMetrics
+// may be nil to only serve telemetry. A zero interval uses
+// DefaultBridgeInterval.
+func NewBridge(reader client.Reader, dcgmClient *Client, agentMetrics *metrics.AgentMetrics, interval time.Duration) *Bridge {
+	if interval <= 0 {
+		interval = DefaultBridgeInterval
+	}
+	return &Bridge{
+		reader:   reader,
+		client:   dcgmClient,
+		metrics:  agentMetrics,
+		interval: interval,
+		now:      time.Now,
+		pools:    map[types.NamespacedName]*PoolTelemetry{},
+		nodes:    map[string]*Telemetry{},
+	}
+}
+
+// SetupWithManager adds the bridge to mgr
+func (b *Bridge) SetupWithManager(mgr ctrl.Manager) error {
+	return mgr.Add(b)
+}
+
+// Start collects every interval until ctx is done. Failed scrapes are
+// retried on the next tick.
+func (b *Bridge) Start(ctx context.Context) error {
+	logger := log.FromContext(ctx).WithName("dcgm-bridge")
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+	for {
+		if err := b.Collect(ctx); err != nil {
+			logger.Error(err, "failed to collect GPU telemetry")
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// replica is a running replica of a pool
+type replica struct {
+	pool  types.NamespacedName
+	model string
+}
+
+// Collect scrapes the exporters of the GPU nodes running replicas of
+// pools and attributes their GPUs to the pools. Nodes whose exporter fails
+// are left out until the next collection.
+func (b *Bridge) Collect(ctx context.Context) error {
+	var pods corev1.PodList
+	if err := b.reader.List(ctx, &pods, client.HasLabels{neuronetes.LabelPool}); err != nil {
+		return fmt.Errorf("failed to list replicas: %w", err)
+	}
+	var nodeList corev1.NodeList
+	if err := b.reader.List(ctx, &nodeList); err != nil {
+		return fmt.Errorf("failed to list nodes: %w", err)
+	}
+
+	replicas := map[types.NamespacedName]replica{}
+	scraped := map[string]bool{}
+	for _, pod := range pods.Items {
+		if pod.Spec.NodeName == "" || pod.Status.Phase != corev1.PodRunning {
+			continue
+		}
+		replicas[types.NamespacedName{Namespace: pod.Namespace, Name: pod.Name}] = replica{
+			pool:  types.NamespacedName{Namespace: pod.Namespace, Name: pod.Labels[neuronetes.LabelPool]},
+			model: pod.Labels[neuronetes.LabelModel],
+		}
+		scraped[pod.Spec.NodeName] = true
+	}
+	var nodes []*corev1.Node
+	for i := range nodeList.Items {
+		node := &nodeList.Items[i]
+		gpus := node.Status.Allocatable[gpu.NVIDIAResource]
+		if scraped[node.Name] && !gpus.IsZero() {
+			nodes = append(nodes, node)
+		}
+	}
+
+	results, errs := b.scrape(ctx, nodes)
+
+	nodeTelemetry := make(map[string]*Telemetry, len(results))
+	poolGPUs := map[types.NamespacedName][]GPU{}
+	poolModels := map[types.NamespacedName]string{}
+	poolReplicas := map[types.NamespacedName]map[string]bool{}
+	for node, gpus := range results {
+		if t := Aggregate(gpus); t != nil {
+			nodeTelemetry[node] = t
+		}
+		for _, gpu := range gpus {
+			r, ok := replicas[types.NamespacedName{Namespace: gpu.Namespace, Name: gpu.Pod}]
+			if !ok {
+				continue
+			}
+			// GPU indices repeat across nodes
+			gpu.ID = node + "/" + gpu.ID
+			poolGPUs[r.pool] = append(poolGPUs[r.pool], gpu)
+			poolModels[r.pool] = r.model
+			if poolReplicas[r.pool] == nil {
+				poolReplicas[r.pool] = map[string]bool{}
+			}
+			poolReplicas[r.pool][gpu.Pod] = true
+		}
+	}
+	pools := make(map[types.NamespacedName]*PoolTelemetry, len(poolGPUs))
+	for key, gpus := range poolGPUs {
+		pools[key] = &PoolTelemetry{
+			Telemetry: *Aggregate(gpus),
+			Model:     poolModels[key],
+			Replicas:  len(poolReplicas[key]),
+		}
+	}
+
+	b.mu.Lock()
+	previous := b.pools
+	b.pools = pools
+	b.nodes = nodeTelemetry
+	b.collected = b.now()
+	b.mu.Unlock()
+
+	if b.metrics != nil {
+		for key, old := range previous {
+			if current, ok := pools[key]; !ok || current.Model != old.Model {
+				b.metrics.DeletePoolGPUMetrics(key.Namespace, key.Name)
+			}
+		}
+		for key, t := range pools {
+			b.metrics.RecordPoolGPUMetrics(key.Namespace, key.Name, t.Model, metrics.PoolGPUStats{
+				GPUs:            t.GPUs,
+				Utilization:     t.Utilization,
+				SMActive:        t.SMActive,
+				MemoryBandwidth: t.MemoryBandwidth,
+				VRAMUsedGB:      float64(t.VRAMUsed) / bytesPerGB,
+				VRAMTotalGB:     float64(t.VRAMTotal()) / bytesPerGB,
+			})
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// scrape scrapes the exporters of nodes concurrently and returns their GPUs
+// by node
+func (b *Bridge) scrape(ctx context.Context, nodes []*corev1.Node) (map[string][]GPU, []error) {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var errs []error
+	results := make(map[string][]GPU, len(nodes))
+	slots := make(chan struct{}, bridgeConcurrency)
+	for _, node := range nodes {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(node *corev1.Node) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			gpus, err := b.scrapeNode(ctx, node)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("node %s: %w", node.Name, err))
+				return
+			}
+			results[node.Name] = gpus
+		}(node)
+	}
+	wg.Wait()
+	return results, errs
+}
+
+func (b *Bridge) scrapeNode(ctx context.Context, node *corev1.Node) ([]GPU, error) {
+	endpoint, err := b.client.Endpoint(node)
+	if err != nil {
+		return nil, err
+	}
+	return b.client.ScrapeGPUs(ctx, endpoint)
+}
+
+// PoolTelemetry returns the state of the GPUs of the replicas of a pool at
+// the last collection
+func (b *Bridge) PoolTelemetry(namespace, name string) (*PoolTelemetry, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	t, ok := b.pools[types.NamespacedName{Namespace: namespace, Name: name}]
+	return t, ok
+}
+
+// PoolGPUUtilization returns the average GPU utilization percentage of the
+// replicas of a pool, for autoscaler.GPUMetricsProvider
+func (b *Bridge) PoolGPUUtilization(namespace, name string) (float64, bool) {
+	t, ok := b.PoolTelemetry(namespace, name)
+	if !ok {
+		return 0, false
+	}
+	return t.Utilization, true
+}
+
+// NodeTelemetry returns the telemetry of a node from the last collection,
+// if it is recent, or scrapes its exporter otherwise. It implements the
+// scheduler's TelemetrySource.
+func (b *Bridge) NodeTelemetry(ctx context.Context, node *corev1.Node) (*Telemetry, error) {
+	b.mu.RLock()
+	t, ok := b.nodes[node.Name]
+	fresh := b.now().Sub(b.collected) < 2*b.interval
+	b.mu.RUnlock()
+	if ok && fresh {
+		return t, nil
+	}
+	return b.client.NodeTelemetry(ctx, node)
+}
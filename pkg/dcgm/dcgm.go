@@ -0,0 +1,363 @@
+// Package dcgm reads live GPU telemetry from NVIDIA dcgm-exporter, which
+// serves the DCGM hardware counters of a node's GPUs in the Prometheus text
+// format.
+package dcgm
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// DefaultPort is the port dcgm-exporter serves on
+	DefaultPort = 9400
+
+	// DefaultCacheTTL is how long a node's telemetry is reused before the
+	// exporter is scraped again
+	DefaultCacheTTL = 15 * time.Second
+
+	// DefaultTimeout bounds a scrape
+	DefaultTimeout = 2 * time.Second
+
+	mib = 1 << 20
+)
+
+// DCGM fields read from the exporter
+const (
+	fieldGPUUtil    = "DCGM_FI_DEV_GPU_UTIL"
+	fieldMemCopy    = "DCGM_FI_DEV_MEM_COPY_UTIL"
+	fieldFBUsed     = "DCGM_FI_DEV_FB_USED"
+	fieldFBFree     = "DCGM_FI_DEV_FB_FREE"
+	fieldSMActive   = "DCGM_FI_PROF_SM_ACTIVE"
+	fieldDRAMActive = "DCGM_FI_PROF_DRAM_ACTIVE"
+)
+
+// Telemetry is the state of a node's GPUs. Percentages are averaged over the
+// GPUs, memory is summed.
+type Telemetry struct {
+	// GPUs is the number of GPUs reporting
+	GPUs int
+
+	// Utilization is the percentage of time a kernel was running
+	Utilization float64
+
+	// SMActive is the percentage of time the streaming multiprocessors were
+	// busy. It falls back to Utilization when DCGM profiling metrics are not
+	// enabled.
+	SMActive float64
+
+	// MemoryBandwidth is the percentage of time device memory was being read
+	// or written
+	MemoryBandwidth float64
+
+	// VRAMUsed and VRAMFree are framebuffer memory in bytes
+	VRAMUsed int64
+	VRAMFree int64
+}
+
+// VRAMTotal returns the framebuffer memory of the node's GPUs in bytes
+func (t *Telemetry) VRAMTotal() int64 {
+	return t.VRAMUsed + t.VRAMFree
+}
+
+// GPU is the state of one GPU in a dcgm-exporter scrape
+type GPU struct {
+	// ID is the UUID of the GPU, or its index on the node
+	ID string
+
+	// ModelName is the product name, e.g. NVIDIA A100-SXM4-40GB
+	ModelName string
+
+	// Namespace, Pod and Container are the container the GPU is allocated
+	// to, reported by dcgm-exporter with Kubernetes mapping enabled. GPUs
+	// shared by several containers appear once for each.
+	Namespace string
+	Pod       string
+	Container string
+
+	// Utilization, SMActive and MemoryBandwidth are percentages, as in
+	// Telemetry
+	Utilization     float64
+	SMActive        float64
+	MemoryBandwidth float64
+
+	// VRAMUsed and VRAMFree are framebuffer memory in bytes
+	VRAMUsed int64
+	VRAMFree int64
+}
+
+// gpuKey identifies a GPU sample: a GPU, and the container it is
+// allocated to
+type gpuKey struct {
+	id, namespace, pod, container string
+}
+
+// ParseGPUs reads the GPUs of one node from a dcgm-exporter scrape, in the
+// order they appear
+func ParseGPUs(r io.Reader) ([]GPU, error) {
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse dcgm-exporter metrics: %w", err)
+	}
+
+	var keys []gpuKey
+	gpus := map[gpuKey]*GPU{}
+	fields := map[gpuKey]map[string]float64{}
+	for _, field := range []string{fieldGPUUtil, fieldMemCopy, fieldFBUsed, fieldFBFree, fieldSMActive, fieldDRAMActive} {
+		family, ok := families[field]
+		if !ok {
+			continue
+		}
+		for _, m := range family.Metric {
+			key := gpuKey{
+				id:        gpuID(m),
+				namespace: label(m, "namespace", "exported_namespace"),
+				pod:       label(m, "pod", "exported_pod"),
+				container: label(m, "container", "exported_container"),
+			}
+			gpu, ok := gpus[key]
+			if !ok {
+				gpu = &GPU{ID: key.id, Namespace: key.namespace, Pod: key.pod, Container: key.container}
+				gpus[key] = gpu
+				fields[key] = map[string]float64{}
+				keys = append(keys, key)
+			}
+			if name := label(m, "modelName"); name != "" {
+				gpu.ModelName = name
+			}
+			fields[key][field] = value(m)
+		}
+	}
+
+	out := make([]GPU, 0, len(keys))
+	for _, key := range keys {
+		gpu, values := gpus[key], fields[key]
+		gpu.Utilization = values[fieldGPUUtil]
+		gpu.VRAMUsed = int64(values[fieldFBUsed] * mib)
+		gpu.VRAMFree = int64(values[fieldFBFree] * mib)
+		// Profiling metrics are ratios; they fall back to the device
+		// counters when DCGM profiling is not enabled
+		if sm, ok := values[fieldSMActive]; ok {
+			gpu.SMActive = sm * 100
+		} else {
+			gpu.SMActive = gpu.Utilization
+		}
+		if dram, ok := values[fieldDRAMActive]; ok {
+			gpu.MemoryBandwidth = dram * 100
+		} else {
+			gpu.MemoryBandwidth = values[fieldMemCopy]
+		}
+		out = append(out, *gpu)
+	}
+	return out, nil
+}
+
+// Aggregate returns the telemetry of gpus, counting GPUs shared by several
+// containers once. It returns nil without GPUs.
+func Aggregate(gpus []GPU) *Telemetry {
+	seen := map[string]bool{}
+	t := &Telemetry{}
+	for _, gpu := range gpus {
+		if seen[gpu.ID] {
+			continue
+		}
+		seen[gpu.ID] = true
+		t.GPUs++
+		t.Utilization += gpu.Utilization
+		t.SMActive += gpu.SMActive
+		t.MemoryBandwidth += gpu.MemoryBandwidth
+		t.VRAMUsed += gpu.VRAMUsed
+		t.VRAMFree += gpu.VRAMFree
+	}
+	if t.GPUs == 0 {
+		return nil
+	}
+	t.Utilization /= float64(t.GPUs)
+	t.SMActive /= float64(t.GPUs)
+	t.MemoryBandwidth /= float64(t.GPUs)
+	return t
+}
+
+// Parse reads the telemetry of one node from a dcgm-exporter scrape
+func Parse(r io.Reader) (*Telemetry, error) {
+	gpus, err := ParseGPUs(r)
+	if err != nil {
+		return nil, err
+	}
+	t := Aggregate(gpus)
+	if t == nil {
+		return nil, fmt.Errorf("no GPU metrics found")
+	}
+	return t, nil
+}
+
+// value returns the value of a gauge or counter sample
+func value(m *dto.Metric) float64 {
+	switch {
+	case m.Gauge != nil:
+		return m.Gauge.GetValue()
+	case m.Counter != nil:
+		return m.Counter.GetValue()
+	case m.Untyped != nil:
+		return m.Untyped.GetValue()
+	}
+	return 0
+}
+
+// label returns the value of the first of names set on m
+func label(m *dto.Metric, names ...string) string {
+	for _, name := range names {
+		for _, l := range m.Label {
+			if l.GetName() == name && l.GetValue() != "" {
+				return l.GetValue()
+			}
+		}
+	}
+	return ""
+}
+
+// gpuID identifies the GPU a sample belongs to
+func gpuID(m *dto.Metric) string {
+	return label(m, "UUID", "gpu")
+}
+
+// Config configures a Client
+type Config struct {
+	// Port is the port dcgm-exporter serves on each node. Defaults to
+	// DefaultPort.
+	Port int
+
+	// Path is the metrics path. Defaults to /metrics.
+	Path string
+
+	// CacheTTL is how long telemetry is reused. Defaults to DefaultCacheTTL.
+	CacheTTL time.Duration
+
+	// Timeout bounds each scrape. Defaults to DefaultTimeout.
+	Timeout time.Duration
+
+	// HTTPClient defaults to http.DefaultClient
+	HTTPClient *http.Client
+}
+
+// Client scrapes dcgm-exporter on each node, caching the results
+type Client struct {
+	config Config
+	now    func() time.Time
+
+	mu    sync.Mutex
+	cache map[string]cachedTelemetry
+}
+
+type cachedTelemetry struct {
+	telemetry *Telemetry
+	scraped   time.Time
+}
+
+// NewClient creates a client for the given config
+func NewClient(config Config) *Client {
+	if config.Port == 0 {
+		config.Port = DefaultPort
+	}
+	if config.Path == "" {
+		config.Path = "/metrics"
+	}
+	if config.CacheTTL == 0 {
+		config.CacheTTL = DefaultCacheTTL
+	}
+	if config.Timeout == 0 {
+		config.Timeout = DefaultTimeout
+	}
+	if config.HTTPClient == nil {
+		config.HTTPClient = http.DefaultClient
+	}
+	return &Client{
+		config: config,
+		now:    time.Now,
+		cache:  make(map[string]cachedTelemetry),
+	}
+}
+
+// Endpoint returns the dcgm-exporter URL of a node, served on its internal
+// address as the exporter's DaemonSet runs on the host network or behind a
+// hostPort
+func (c *Client) Endpoint(node *corev1.Node) (string, error) {
+	for _, addressType := range []corev1.NodeAddressType{corev1.NodeInternalIP, corev1.NodeHostName} {
+		for _, address := range node.Status.Addresses {
+			if address.Type == addressType && address.Address != "" {
+				host := net.JoinHostPort(address.Address, strconv.Itoa(c.config.Port))
+				return "http://" + host + c.config.Path, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("node %s has no internal address", node.Name)
+}
+
+// NodeTelemetry returns the telemetry of a node, scraping its exporter at
+// most once per cache TTL
+func (c *Client) NodeTelemetry(ctx context.Context, node *corev1.Node) (*Telemetry, error) {
+	c.mu.Lock()
+	cached, ok := c.cache[node.Name]
+	c.mu.Unlock()
+	if ok && c.now().Sub(cached.scraped) < c.config.CacheTTL {
+		return cached.telemetry, nil
+	}
+
+	endpoint, err := c.Endpoint(node)
+	if err != nil {
+		return nil, err
+	}
+	telemetry, err := c.Scrape(ctx, endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scrape GPU telemetry of node %s: %w", node.Name, err)
+	}
+
+	c.mu.Lock()
+	c.cache[node.Name] = cachedTelemetry{telemetry: telemetry, scraped: c.now()}
+	c.mu.Unlock()
+	return telemetry, nil
+}
+
+// Scrape reads the telemetry served at endpoint
+func (c *Client) Scrape(ctx context.Context, endpoint string) (*Telemetry, error) {
+	gpus, err := c.ScrapeGPUs(ctx, endpoint)
+	if err != nil {
+		return nil, err
+	}
+	t := Aggregate(gpus)
+	if t == nil {
+		return nil, fmt.Errorf("no GPU metrics found at %s", endpoint)
+	}
+	return t, nil
+}
+
+// ScrapeGPUs reads the GPUs served at endpoint
+func (c *Client) ScrapeGPUs(ctx context.Context, endpoint string) ([]GPU, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.config.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.config.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s from %s", resp.Status, endpoint)
+	}
+	return ParseGPUs(resp.Body)
+}
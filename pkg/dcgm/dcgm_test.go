@@ -0,0 +1,256 @@
+package dcgm
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/gpu"
+	"github.com/bowenislandsong/neuronetes/pkg/metrics"
+)
+
+// exporterOutput is a dcgm-exporter scrape of two GPUs with profiling
+// metrics enabled
+const exporterOutput = `# HELP DCGM_FI_DEV_GPU_UTIL GPU utilization (in %).
+# TYPE DCGM_FI_DEV_GPU_UTIL gauge
+DCGM_FI_DEV_GPU_UTIL{gpu="0",UUID="GPU-a",device="nvidia0",modelName="NVIDIA A100-SXM4-40GB",Hostname="node-a"} 80
+DCGM_FI_DEV_GPU_UTIL{gpu="1",UUID="GPU-b",device="nvidia1",modelName="NVIDIA A100-SXM4-40GB",Hostname="node-a"} 40
+# HELP DCGM_FI_DEV_FB_FREE Framebuffer memory free (in MiB).
+# TYPE DCGM_FI_DEV_FB_FREE gauge
+DCGM_FI_DEV_FB_FREE{gpu="0",UUID="GPU-a"} 10240
+DCGM_FI_DEV_FB_FREE{gpu="1",UUID="GPU-b"} 30720
+# HELP DCGM_FI_DEV_FB_USED Framebuffer memory used (in MiB).
+# TYPE DCGM_FI_DEV_FB_USED gauge
+DCGM_FI_DEV_FB_USED{gpu="0",UUID="GPU-a"} 30720
+DCGM_FI_DEV_FB_USED{gpu="1",UUID="GPU-b"} 10240
+# HELP DCGM_FI_DEV_MEM_COPY_UTIL Memory utilization (in %).
+# TYPE DCGM_FI_DEV_MEM_COPY_UTIL gauge
+DCGM_FI_DEV_MEM_COPY_UTIL{gpu="0",UUID="GPU-a"} 50
+DCGM_FI_DEV_MEM_COPY_UTIL{gpu="1",UUID="GPU-b"} 30
+# HELP DCGM_FI_PROF_SM_ACTIVE The ratio of cycles an SM has at least 1 warp assigned.
+# TYPE DCGM_FI_PROF_SM_ACTIVE gauge
+DCGM_FI_PROF_SM_ACTIVE{gpu="0",UUID="GPU-a"} 0.7
+DCGM_FI_PROF_SM_ACTIVE{gpu="1",UUID="GPU-b"} 0.3
+# HELP DCGM_FI_PROF_DRAM_ACTIVE Ratio of cycles the device memory interface is active.
+# TYPE DCGM_FI_PROF_DRAM_ACTIVE gauge
+DCGM_FI_PROF_DRAM_ACTIVE{gpu="0",UUID="GPU-a"} 0.6
+DCGM_FI_PROF_DRAM_ACTIVE{gpu="1",UUID="GPU-b"} 0.2
+`
+
+func TestParse(t *testing.T) {
+	telemetry, err := Parse(strings.NewReader(exporterOutput))
+	require.NoError(t, err)
+	assert.Equal(t, 2, telemetry.GPUs)
+	assert.InDelta(t, 60, telemetry.Utilization, 1e-9)
+	assert.InDelta(t, 50, telemetry.SMActive, 1e-9)
+	assert.InDelta(t, 40, telemetry.MemoryBandwidth, 1e-9)
+	assert.Equal(t, int64(40<<30), telemetry.VRAMUsed)
+	assert.Equal(t, int64(80<<30), telemetry.VRAMTotal())
+}
+
+func TestParseWithoutProfilingMetrics(t *testing.T) {
+	var basic []string
+	for _, line := range strings.Split(exporterOutput, "\n") {
+		if !strings.Contains(line, "DCGM_FI_PROF_") {
+			basic = append(basic, line)
+		}
+	}
+	telemetry, err := Parse(strings.NewReader(strings.Join(basic, "\n")))
+	require.NoError(t, err)
+	// SM activity and bandwidth fall back to the device counters
+	assert.InDelta(t, 60, telemetry.SMActive, 1e-9)
+	assert.InDelta(t, 40, telemetry.MemoryBandwidth, 1e-9)
+
+	_, err = Parse(strings.NewReader("# no GPUs\n"))
+	assert.Error(t, err)
+}
+
+// exporterNode returns a node whose exporter is served by server
+func exporterNode(t *testing.T, server *httptest.Server) (*Client, *corev1.Node) {
+	t.Helper()
+	host, port, err := net.SplitHostPort(strings.TrimPrefix(server.URL, "http://"))
+	require.NoError(t, err)
+	portNumber, err := strconv.Atoi(port)
+	require.NoError(t, err)
+	client := NewClient(Config{Port: portNumber})
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-a"},
+		Status: corev1.NodeStatus{Addresses: []corev1.NodeAddress{
+			{Type: corev1.NodeExternalIP, Address: "203.0.113.1"},
+			{Type: corev1.NodeInternalIP, Address: host},
+		}},
+	}
+	return client, node
+}
+
+func TestClientCachesNodeTelemetry(t *testing.T) {
+	scrapes := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/metrics", r.URL.Path)
+		scrapes++
+		_, _ = w.Write([]byte(exporterOutput))
+	}))
+	defer server.Close()
+
+	client, node := exporterNode(t, server)
+	now := time.Now()
+	client.now = func() time.Time { return now }
+
+	telemetry, err := client.NodeTelemetry(context.Background(), node)
+	require.NoError(t, err)
+	assert.Equal(t, 2, telemetry.GPUs)
+	_, err = client.NodeTelemetry(context.Background(), node)
+	require.NoError(t, err)
+	assert.Equal(t, 1, scrapes)
+
+	// Telemetry is scraped again once the cache expires
+	now = now.Add(DefaultCacheTTL)
+	_, err = client.NodeTelemetry(context.Background(), node)
+	require.NoError(t, err)
+	assert.Equal(t, 2, scrapes)
+}
+
+func TestClientReportsScrapeFailures(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "exporter starting", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	client, node := exporterNode(t, server)
+	_, err := client.NodeTelemetry(context.Background(), node)
+	assert.ErrorContains(t, err, "503")
+
+	_, err = client.NodeTelemetry(context.Background(), &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-b"}})
+	assert.ErrorContains(t, err, "no internal address")
+}
+
+func TestRecorderDrivesGPUMetrics(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(exporterOutput))
+	}))
+	defer server.Close()
+
+	agentMetrics := metrics.NewAgentMetrics(prometheus.NewRegistry())
+	recorder := NewRecorder(NewClient(Config{}), "node-a", server.URL+"/metrics", agentMetrics, 0)
+	require.NoError(t, recorder.Record(context.Background()))
+
+	assert.InDelta(t, 60, testutil.ToFloat64(agentMetrics.GPUUtilization), 1e-9)
+	assert.InDelta(t, 50, testutil.ToFloat64(agentMetrics.SMUtilization), 1e-9)
+	assert.InDelta(t, 40, testutil.ToFloat64(agentMetrics.MemoryBWUtilization), 1e-9)
+	assert.InDelta(t, 40, testutil.ToFloat64(agentMetrics.VRAMUsed), 1e-9)
+	assert.InDelta(t, 50, testutil.ToFloat64(agentMetrics.VRAMFragmentation), 1e-9)
+}
+
+// mappedOutput is a dcgm-exporter scrape with Kubernetes mapping: GPU-a is
+// allocated to a replica of the chat pool, GPU-b to a pod of no pool, and
+// GPU-c is shared by two replicas of the chat pool
+const mappedOutput = `# TYPE DCGM_FI_DEV_GPU_UTIL gauge
+DCGM_FI_DEV_GPU_UTIL{gpu="0",UUID="GPU-a",modelName="NVIDIA A100-SXM4-40GB",namespace="prod",pod="chat-0",container="agent"} 80
+DCGM_FI_DEV_GPU_UTIL{gpu="1",UUID="GPU-b",modelName="NVIDIA A100-SXM4-40GB",namespace="prod",pod="notebook",container="jupyter"} 10
+DCGM_FI_DEV_GPU_UTIL{gpu="2",UUID="GPU-c",modelName="NVIDIA A100-SXM4-40GB",namespace="prod",pod="chat-1",container="agent"} 40
+DCGM_FI_DEV_GPU_UTIL{gpu="2",UUID="GPU-c",modelName="NVIDIA A100-SXM4-40GB",namespace="prod",pod="chat-2",container="agent"} 40
+# TYPE DCGM_FI_DEV_FB_USED gauge
+DCGM_FI_DEV_FB_USED{gpu="0",UUID="GPU-a",namespace="prod",pod="chat-0",container="agent"} 30720
+DCGM_FI_DEV_FB_USED{gpu="1",UUID="GPU-b",namespace="prod",pod="notebook",container="jupyter"} 1024
+DCGM_FI_DEV_FB_USED{gpu="2",UUID="GPU-c",namespace="prod",pod="chat-1",container="agent"} 10240
+DCGM_FI_DEV_FB_USED{gpu="2",UUID="GPU-c",namespace="prod",pod="chat-2",container="agent"} 10240
+# TYPE DCGM_FI_DEV_FB_FREE gauge
+DCGM_FI_DEV_FB_FREE{gpu="0",UUID="GPU-a",namespace="prod",pod="chat-0",container="agent"} 10240
+DCGM_FI_DEV_FB_FREE{gpu="1",UUID="GPU-b",namespace="prod",pod="notebook",container="jupyter"} 39936
+DCGM_FI_DEV_FB_FREE{gpu="2",UUID="GPU-c",namespace="prod",pod="chat-1",container="agent"} 30720
+DCGM_FI_DEV_FB_FREE{gpu="2",UUID="GPU-c",namespace="prod",pod="chat-2",container="agent"} 30720
+`
+
+func TestParseGPUs(t *testing.T) {
+	gpus, err := ParseGPUs(strings.NewReader(mappedOutput))
+	require.NoError(t, err)
+	require.Len(t, gpus, 4)
+	assert.Equal(t, GPU{
+		ID:              "GPU-a",
+		ModelName:       "NVIDIA A100-SXM4-40GB",
+		Namespace:       "prod",
+		Pod:             "chat-0",
+		Container:       "agent",
+		Utilization:     80,
+		SMActive:        80,
+		MemoryBandwidth: 0,
+		VRAMUsed:        30 << 30,
+		VRAMFree:        10 << 30,
+	}, gpus[0])
+	assert.Equal(t, "chat-2", gpus[3].Pod)
+
+	// Shared GPUs count once on the node
+	telemetry := Aggregate(gpus)
+	assert.Equal(t, 3, telemetry.GPUs)
+	assert.Equal(t, int64(41<<30), telemetry.VRAMUsed)
+	assert.Nil(t, Aggregate(nil))
+}
+
+func TestBridgeMapsGPUsToPools(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(mappedOutput))
+	}))
+	defer server.Close()
+	dcgmClient, node := exporterNode(t, server)
+	node.Status.Allocatable = corev1.ResourceList{gpu.NVIDIAResource: resource.MustParse("3")}
+
+	replica := func(name string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "prod", Name: name, Labels: map[string]string{
+				neuronetes.LabelPool:  "chat",
+				neuronetes.LabelModel: "llama-3-8b",
+			}},
+			Spec:   corev1.PodSpec{NodeName: node.Name},
+			Status: corev1.PodStatus{Phase: corev1.PodRunning},
+		}
+	}
+	reader := fake.NewClientBuilder().WithObjects(node, replica("chat-0"), replica("chat-1"), replica("chat-2")).Build()
+	agentMetrics := metrics.NewAgentMetrics(prometheus.NewRegistry())
+	bridge := NewBridge(reader, dcgmClient, agentMetrics, 0)
+
+	require.NoError(t, bridge.Collect(context.Background()))
+	pool, ok := bridge.PoolTelemetry("prod", "chat")
+	require.True(t, ok)
+	assert.Equal(t, "llama-3-8b", pool.Model)
+	assert.Equal(t, 3, pool.Replicas)
+	assert.Equal(t, 2, pool.GPUs)
+	assert.InDelta(t, 60, pool.Utilization, 1e-9)
+	assert.Equal(t, int64(40<<30), pool.VRAMUsed)
+	utilization, ok := bridge.PoolGPUUtilization("prod", "chat")
+	assert.True(t, ok)
+	assert.InDelta(t, 60, utilization, 1e-9)
+	_, ok = bridge.PoolGPUUtilization("prod", "batch")
+	assert.False(t, ok)
+
+	assert.InDelta(t, 60, testutil.ToFloat64(agentMetrics.PoolGPUUtilization.WithLabelValues("prod", "chat", "llama-3-8b")), 1e-9)
+	assert.Equal(t, 2.0, testutil.ToFloat64(agentMetrics.PoolGPUs.WithLabelValues("prod", "chat", "llama-3-8b")))
+	assert.Equal(t, 80.0, testutil.ToFloat64(agentMetrics.PoolVRAMTotal.WithLabelValues("prod", "chat", "llama-3-8b")))
+
+	// The scheduler reads node telemetry from the last collection
+	telemetry, err := bridge.NodeTelemetry(context.Background(), node)
+	require.NoError(t, err)
+	assert.Equal(t, 3, telemetry.GPUs)
+
+	// Pools whose replicas are gone are no longer reported
+	require.NoError(t, reader.DeleteAllOf(context.Background(), &corev1.Pod{}, client.InNamespace("prod")))
+	require.NoError(t, bridge.Collect(context.Background()))
+	_, ok = bridge.PoolTelemetry("prod", "chat")
+	assert.False(t, ok)
+	assert.Equal(t, 0, testutil.CollectAndCount(agentMetrics.PoolGPUUtilization))
+}
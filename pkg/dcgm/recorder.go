@@ -0,0 +1,74 @@
+package dcgm
+
+import (
+	"context"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/bowenislandsong/neuronetes/pkg/metrics"
+)
+
+// DefaultRecordInterval is how often a Recorder scrapes its node
+const DefaultRecordInterval = 15 * time.Second
+
+// bytesPerGB converts VRAM to the GB reported by the GPU gauges
+const bytesPerGB = 1 << 30
+
+// Recorder drives the GPU gauges of AgentMetrics from the hardware counters
+// of the node it runs on. Agent runtimes start one with the node name and
+// host address from the downward API.
+type Recorder struct {
+	client   *Client
+	node     string
+	endpoint string
+	metrics  *metrics.AgentMetrics
+	interval time.Duration
+}
+
+// NewRecorder creates a recorder scraping the dcgm-exporter at endpoint, the
+// exporter of node, every interval. A zero interval uses
+// DefaultRecordInterval.
+func NewRecorder(client *Client, node, endpoint string, agentMetrics *metrics.AgentMetrics, interval time.Duration) *Recorder {
+	if interval <= 0 {
+		interval = DefaultRecordInterval
+	}
+	return &Recorder{
+		client:   client,
+		node:     node,
+		endpoint: endpoint,
+		metrics:  agentMetrics,
+		interval: interval,
+	}
+}
+
+// Record scrapes the node once and updates the gauges
+func (r *Recorder) Record(ctx context.Context) error {
+	telemetry, err := r.client.Scrape(ctx, r.endpoint)
+	if err != nil {
+		return err
+	}
+	r.metrics.RecordGPUMetrics(ctx, r.node, telemetry.Utilization,
+		float64(telemetry.VRAMUsed)/bytesPerGB, float64(telemetry.VRAMTotal())/bytesPerGB)
+	r.metrics.SMUtilization.Set(telemetry.SMActive)
+	r.metrics.MemoryBWUtilization.Set(telemetry.MemoryBandwidth)
+	return nil
+}
+
+// Start records every interval until ctx is done. Failed scrapes leave the
+// previous values in place and are retried on the next tick.
+func (r *Recorder) Start(ctx context.Context) error {
+	logger := log.FromContext(ctx).WithValues("node", r.node, "endpoint", r.endpoint)
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		if err := r.Record(ctx); err != nil {
+			logger.Error(err, "failed to record GPU telemetry")
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,484 @@
+// Package descheduler consolidates AgentPool replicas off GPU nodes they
+// leave mostly idle. Over time replicas spread across many partially used
+// nodes, and no node has enough free GPUs for a large model; evicting the
+// replicas of the least used nodes onto the free GPUs of the others frees
+// whole nodes again.
+package descheduler
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	corev1helpers "k8s.io/component-helpers/scheduling/corev1"
+	"k8s.io/component-helpers/scheduling/corev1/nodeaffinity"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/gpu"
+)
+
+const (
+	// DefaultInterval is how often nodes are consolidated
+	DefaultInterval = 10 * time.Minute
+
+	// DefaultUtilizationThreshold is the share of a node's GPUs below which
+	// its replicas are moved
+	DefaultUtilizationThreshold = 0.5
+
+	// DefaultMaxNodesPerCycle bounds the nodes drained per cycle
+	DefaultMaxNodesPerCycle = 1
+
+	// TaintConsolidating is set with the PreferNoSchedule effect on nodes
+	// whose replicas were evicted, so that their replacements are scheduled
+	// onto other nodes. It is removed on the next cycle.
+	TaintConsolidating = "neuronetes.io/consolidating"
+
+	// gpuTypeLabel is the GPU model of a node
+	gpuTypeLabel = "neuronetes.io/gpu-type"
+)
+
+// +kubebuilder:rbac:groups=core,resources=nodes,verbs=get;list;watch;patch
+// +kubebuilder:rbac:groups=core,resources=pods/eviction,verbs=create
+// +kubebuilder:rbac:groups=policy,resources=poddisruptionbudgets,verbs=get;list;watch
+
+// Descheduler periodically evicts the replicas of GPU nodes used below a
+// threshold when all of them fit onto the free GPUs of other partially used
+// nodes. Replicas are evicted through the Eviction API, so
+// PodDisruptionBudgets are honored, and replicas serving sticky sessions,
+// gang members and replicas of paused pools are left in place.
+type Descheduler struct {
+	client.Client
+	Recorder record.EventRecorder
+
+	// Interval is how often nodes are consolidated
+	Interval time.Duration
+
+	// UtilizationThreshold is the share of a node's GPUs below which its
+	// replicas are moved (0.0-1.0)
+	UtilizationThreshold float64
+
+	// MaxNodesPerCycle bounds the nodes drained per cycle
+	MaxNodesPerCycle int
+}
+
+// SetupWithManager runs the descheduler while the manager is leader
+func (d *Descheduler) SetupWithManager(mgr ctrl.Manager) error {
+	return mgr.Add(d)
+}
+
+// Start consolidates every interval until ctx is done
+func (d *Descheduler) Start(ctx context.Context) error {
+	logger := log.FromContext(ctx).WithName("descheduler")
+	interval := d.Interval
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		drained, err := d.Consolidate(ctx)
+		if err != nil {
+			logger.Error(err, "failed to consolidate GPU nodes")
+			continue
+		}
+		if len(drained) > 0 {
+			logger.Info("consolidated GPU nodes", "nodes", drained)
+		}
+	}
+}
+
+// gpuNode is the GPUs of a schedulable node and the pods holding them
+type gpuNode struct {
+	node *corev1.Node
+	gpus int64
+	used int64
+	pods []*corev1.Pod
+}
+
+func (n *gpuNode) free() int64 {
+	return n.gpus - n.used
+}
+
+// Consolidate drains the least used nodes whose replicas fit elsewhere and
+// returns their names
+func (d *Descheduler) Consolidate(ctx context.Context) ([]string, error) {
+	if err := d.untaintNodes(ctx); err != nil {
+		return nil, err
+	}
+	nodes, err := d.gpuNodes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	threshold := d.UtilizationThreshold
+	if threshold <= 0 {
+		threshold = DefaultUtilizationThreshold
+	}
+	maxNodes := d.MaxNodesPerCycle
+	if maxNodes <= 0 {
+		maxNodes = DefaultMaxNodesPerCycle
+	}
+
+	// Drain the least used nodes first: they take the fewest evictions to
+	// free
+	var candidates []*gpuNode
+	for _, n := range nodes {
+		if n.used > 0 && float64(n.used) < threshold*float64(n.gpus) {
+			candidates = append(candidates, n)
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].used < candidates[j].used })
+
+	var drained []string
+	// receiving nodes are planned to take replicas, so are not drained
+	receiving := map[string]bool{}
+	for _, source := range candidates {
+		if len(drained) >= maxNodes {
+			break
+		}
+		if receiving[source.node.Name] {
+			continue
+		}
+		plan, ok, err := d.plan(ctx, source, nodes)
+		if err != nil {
+			return drained, err
+		}
+		if !ok {
+			continue
+		}
+		complete, err := d.drain(ctx, source, plan)
+		if err != nil {
+			return drained, err
+		}
+		if !complete {
+			continue
+		}
+		for pod, target := range plan {
+			target.used += gpu.PodGPUs(pod)
+			receiving[target.node.Name] = true
+		}
+		source.used = 0
+		drained = append(drained, source.node.Name)
+	}
+	return drained, nil
+}
+
+// gpuNodes returns the ready, schedulable GPU nodes and the GPUs held on
+// them
+func (d *Descheduler) gpuNodes(ctx context.Context) ([]*gpuNode, error) {
+	var nodeList corev1.NodeList
+	if err := d.List(ctx, &nodeList); err != nil {
+		return nil, fmt.Errorf("failed to list nodes: %w", err)
+	}
+	byName := map[string]*gpuNode{}
+	var nodes []*gpuNode
+	for i := range nodeList.Items {
+		node := &nodeList.Items[i]
+		gpus := nodeGPUs(node)
+		if gpus == 0 || node.Spec.Unschedulable || !isNodeReady(node) {
+			continue
+		}
+		n := &gpuNode{node: node, gpus: gpus}
+		byName[node.Name] = n
+		nodes = append(nodes, n)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].node.Name < nodes[j].node.Name })
+
+	var podList corev1.PodList
+	if err := d.List(ctx, &podList); err != nil {
+		return nil, fmt.Errorf("failed to list pods: %w", err)
+	}
+	for i := range podList.Items {
+		pod := &podList.Items[i]
+		n, ok := byName[pod.Spec.NodeName]
+		if !ok || pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		if gpus := gpu.PodGPUs(pod); gpus > 0 {
+			n.used += gpus
+			n.pods = append(n.pods, pod)
+		}
+	}
+	return nodes, nil
+}
+
+// plan assigns each GPU pod of source a node with room for it, preferring
+// nodes that already host its AgentClass and so have its model cached, then
+// the fullest. It reports false if a pod cannot be moved or does not fit.
+func (d *Descheduler) plan(ctx context.Context, source *gpuNode, nodes []*gpuNode) (map[*corev1.Pod]*gpuNode, bool, error) {
+	pods := append([]*corev1.Pod(nil), source.pods...)
+	sort.SliceStable(pods, func(i, j int) bool { return gpu.PodGPUs(pods[i]) > gpu.PodGPUs(pods[j]) })
+
+	free := map[*gpuNode]int64{}
+	for _, n := range nodes {
+		// Replicas are only moved onto nodes already in use, not onto the
+		// empty nodes consolidation is meant to free
+		if n != source && n.used > 0 && n.free() > 0 {
+			free[n] = n.free()
+		}
+	}
+
+	plan := map[*corev1.Pod]*gpuNode{}
+	for _, pod := range pods {
+		pool, ok, err := d.movable(ctx, pod)
+		if err != nil || !ok {
+			return nil, false, err
+		}
+		var best *gpuNode
+		for _, n := range nodes {
+			if free[n] < gpu.PodGPUs(pod) || !fits(pod, pool, n.node) {
+				continue
+			}
+			if best == nil || betterTarget(n, best, free, pool) {
+				best = n
+			}
+		}
+		if best == nil {
+			return nil, false, nil
+		}
+		free[best] -= gpu.PodGPUs(pod)
+		plan[pod] = best
+	}
+
+	ok, err := d.disruptionsAllowed(ctx, pods)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	return plan, true, nil
+}
+
+// betterTarget reports whether n is a better target for a replica of pool
+// than best
+func betterTarget(n, best *gpuNode, free map[*gpuNode]int64, pool *neuronetes.AgentPool) bool {
+	class := pool.Spec.AgentClassRef.Name
+	if hn, hb := hostsClass(n, class), hostsClass(best, class); hn != hb {
+		return hn
+	}
+	return free[n] < free[best]
+}
+
+// hostsClass reports whether a replica of class runs on n
+func hostsClass(n *gpuNode, class string) bool {
+	for _, pod := range n.pods {
+		if pod.Labels[neuronetes.LabelAgentClass] == class {
+			return true
+		}
+	}
+	return false
+}
+
+// movable returns the pool of a replica that may be evicted: a replica of an
+// active pool, recreated by its ReplicaSet, that is not draining, not part
+// of a gang and not serving sticky sessions
+func (d *Descheduler) movable(ctx context.Context, pod *corev1.Pod) (*neuronetes.AgentPool, bool, error) {
+	name, ok := pod.Labels[neuronetes.LabelPool]
+	if !ok || metav1.GetControllerOf(pod) == nil || !pod.DeletionTimestamp.IsZero() {
+		return nil, false, nil
+	}
+	if pod.Labels[neuronetes.LabelRole] == neuronetes.RoleDraining || pod.Labels[neuronetes.LabelGang] != "" {
+		return nil, false, nil
+	}
+
+	pool := &neuronetes.AgentPool{}
+	if err := d.Get(ctx, types.NamespacedName{Namespace: pod.Namespace, Name: name}, pool); err != nil {
+		return nil, false, client.IgnoreNotFound(err)
+	}
+	if pool.Annotations[neuronetes.AnnotationPaused] == "true" || holdsSessions(pool, pod) {
+		return nil, false, nil
+	}
+	return pool, true, nil
+}
+
+// holdsSessions reports whether evicting pod would break sticky sessions:
+// its pool has session affinity and it serves sessions, or does not report
+// how many it serves
+func holdsSessions(pool *neuronetes.AgentPool, pod *corev1.Pod) bool {
+	if pool.Spec.SessionAffinity == nil || !pool.Spec.SessionAffinity.Enabled {
+		return false
+	}
+	value, ok := pod.Annotations[neuronetes.AnnotationActiveSessions]
+	return !ok || value != "0"
+}
+
+// fits reports whether a replica of pool may be scheduled onto node: the
+// node matches its node selector and affinity, has its GPU type and has no
+// taint it does not tolerate
+func fits(pod *corev1.Pod, pool *neuronetes.AgentPool, node *corev1.Node) bool {
+	if match, err := nodeaffinity.GetRequiredNodeAffinity(pod).Match(node); err != nil || !match {
+		return false
+	}
+	if req := pool.Spec.GPURequirements; req != nil && req.Type != "" && node.Labels[gpuTypeLabel] != req.Type {
+		return false
+	}
+	if req := pool.Spec.GPURequirements; (req == nil || req.Sharing == nil) && sharesGPUs(node) {
+		// Replicas of whole GPUs stay off shared ones; the node selector of
+		// replicas of shared GPUs keeps them on GPUs shared alike
+		return false
+	}
+	for _, name := range gpu.Resources {
+		// Replicas only move to nodes of their GPU vendor
+		if requested := podResourceGPUs(pod, name); requested > 0 {
+			if gpus := node.Status.Allocatable[name]; gpus.Value() == 0 {
+				return false
+			}
+		}
+	}
+	_, untolerated := corev1helpers.FindMatchingUntoleratedTaint(node.Spec.Taints, pod.Spec.Tolerations, func(t *corev1.Taint) bool {
+		return t.Effect == corev1.TaintEffectNoSchedule || t.Effect == corev1.TaintEffectNoExecute
+	})
+	return !untolerated
+}
+
+// disruptionsAllowed reports whether the PodDisruptionBudgets covering pods
+// allow evicting all of them, so that a node is not left half drained
+func (d *Descheduler) disruptionsAllowed(ctx context.Context, pods []*corev1.Pod) (bool, error) {
+	budgets := map[string][]policyv1.PodDisruptionBudget{}
+	evictions := map[types.NamespacedName]int32{}
+	allowed := map[types.NamespacedName]int32{}
+	for _, pod := range pods {
+		if _, ok := budgets[pod.Namespace]; !ok {
+			var list policyv1.PodDisruptionBudgetList
+			if err := d.List(ctx, &list, client.InNamespace(pod.Namespace)); err != nil {
+				return false, fmt.Errorf("failed to list PodDisruptionBudgets: %w", err)
+			}
+			budgets[pod.Namespace] = list.Items
+		}
+		for _, pdb := range budgets[pod.Namespace] {
+			selector, err := metav1.LabelSelectorAsSelector(pdb.Spec.Selector)
+			if err != nil || selector.Empty() || !selector.Matches(labels.Set(pod.Labels)) {
+				continue
+			}
+			key := types.NamespacedName{Namespace: pdb.Namespace, Name: pdb.Name}
+			evictions[key]++
+			allowed[key] = pdb.Status.DisruptionsAllowed
+		}
+	}
+	for key, n := range evictions {
+		if n > allowed[key] {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// drain taints source so that replacements are scheduled elsewhere, then
+// evicts the pods of plan. Evictions the API server refuses, because a
+// PodDisruptionBudget changed since the plan, stop the drain; it reports
+// whether every pod was evicted.
+func (d *Descheduler) drain(ctx context.Context, source *gpuNode, plan map[*corev1.Pod]*gpuNode) (bool, error) {
+	if err := d.taint(ctx, source.node); err != nil {
+		return false, err
+	}
+	for _, pod := range source.pods {
+		if _, ok := plan[pod]; !ok {
+			continue
+		}
+		eviction := &policyv1.Eviction{ObjectMeta: metav1.ObjectMeta{Name: pod.Name, Namespace: pod.Namespace}}
+		if err := d.SubResource("eviction").Create(ctx, pod, eviction); err != nil {
+			if apierrors.IsTooManyRequests(err) || apierrors.IsNotFound(err) {
+				log.FromContext(ctx).V(1).Info("stopped draining node", "node", source.node.Name, "pod", pod.Name, "reason", err.Error())
+				return false, nil
+			}
+			return false, fmt.Errorf("failed to evict pod %s: %w", pod.Name, err)
+		}
+		if d.Recorder != nil {
+			d.Recorder.Eventf(pod, corev1.EventTypeNormal, "Consolidating",
+				"Evicted from node %s to free its GPUs for larger replicas", source.node.Name)
+		}
+	}
+	return true, nil
+}
+
+// taint adds TaintConsolidating to node
+func (d *Descheduler) taint(ctx context.Context, node *corev1.Node) error {
+	for _, taint := range node.Spec.Taints {
+		if taint.Key == TaintConsolidating {
+			return nil
+		}
+	}
+	patch := client.MergeFrom(node.DeepCopy())
+	node.Spec.Taints = append(node.Spec.Taints, corev1.Taint{Key: TaintConsolidating, Effect: corev1.TaintEffectPreferNoSchedule})
+	if err := d.Patch(ctx, node, patch); err != nil {
+		return fmt.Errorf("failed to taint node %s: %w", node.Name, err)
+	}
+	return nil
+}
+
+// untaintNodes removes TaintConsolidating from the nodes drained by the
+// previous cycle, whose replicas have been rescheduled since
+func (d *Descheduler) untaintNodes(ctx context.Context) error {
+	var nodeList corev1.NodeList
+	if err := d.List(ctx, &nodeList); err != nil {
+		return fmt.Errorf("failed to list nodes: %w", err)
+	}
+	for i := range nodeList.Items {
+		node := &nodeList.Items[i]
+		var taints []corev1.Taint
+		for _, taint := range node.Spec.Taints {
+			if taint.Key != TaintConsolidating {
+				taints = append(taints, taint)
+			}
+		}
+		if len(taints) == len(node.Spec.Taints) {
+			continue
+		}
+		patch := client.MergeFrom(node.DeepCopy())
+		node.Spec.Taints = taints
+		if err := d.Patch(ctx, node, patch); err != nil {
+			return fmt.Errorf("failed to untaint node %s: %w", node.Name, err)
+		}
+	}
+	return nil
+}
+
+func isNodeReady(node *corev1.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == corev1.NodeReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// sharesGPUs reports whether the device plugin of node shares its GPUs
+func sharesGPUs(node *corev1.Node) bool {
+	strategy := node.Labels[gpu.SharingStrategyLabel]
+	return strategy != "" && strategy != "none"
+}
+
+// nodeGPUs returns the allocatable whole GPUs of node of either vendor
+func nodeGPUs(node *corev1.Node) int64 {
+	var gpus int64
+	for _, name := range gpu.Resources {
+		q := node.Status.Allocatable[name]
+		gpus += q.Value()
+	}
+	return gpus
+}
+
+// podResourceGPUs returns the GPUs of resource name a pod requests
+func podResourceGPUs(pod *corev1.Pod, name corev1.ResourceName) int64 {
+	var gpus int64
+	for _, container := range pod.Spec.Containers {
+		if q, ok := container.Resources.Requests[name]; ok {
+			gpus += q.Value()
+		}
+	}
+	return gpus
+}
@@ -0,0 +1,226 @@
+package descheduler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/gpu"
+)
+
+func gpuNodeObject(name string, gpus int64) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Status: corev1.NodeStatus{
+			Allocatable: corev1.ResourceList{gpu.NVIDIAResource: *resource.NewQuantity(gpus, resource.DecimalSI)},
+			Conditions:  []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}},
+		},
+	}
+}
+
+// replica returns a running replica of pool on node, owned by a ReplicaSet
+func replica(name, pool, class, node string, gpus int64) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+			Labels:    map[string]string{neuronetes.LabelPool: pool, neuronetes.LabelAgentClass: class},
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: "apps/v1", Kind: "ReplicaSet", Name: pool + "-rs", UID: "rs", Controller: pointer.Bool(true),
+			}},
+		},
+		Spec: corev1.PodSpec{
+			NodeName: node,
+			Containers: []corev1.Container{{
+				Name: "agent",
+				Resources: corev1.ResourceRequirements{Requests: corev1.ResourceList{
+					gpu.NVIDIAResource: *resource.NewQuantity(gpus, resource.DecimalSI),
+				}},
+			}},
+		},
+		Status: corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+}
+
+func pool(name, class string) *neuronetes.AgentPool {
+	return &neuronetes.AgentPool{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec:       neuronetes.AgentPoolSpec{AgentClassRef: neuronetes.AgentClassReference{Name: class}},
+	}
+}
+
+// fragmented returns three 8-GPU nodes: node-a holds one GPU of chat, node-b
+// six GPUs of chat and llm, node-c three GPUs of llm
+func fragmented() []client.Object {
+	return []client.Object{
+		gpuNodeObject("node-a", 8), gpuNodeObject("node-b", 8), gpuNodeObject("node-c", 8),
+		pool("chat", "chat-agent"), pool("llm", "llm-agent"),
+		replica("chat-0", "chat", "chat-agent", "node-a", 1),
+		replica("chat-1", "chat", "chat-agent", "node-b", 2),
+		replica("llm-0", "llm", "llm-agent", "node-b", 4),
+		replica("llm-1", "llm", "llm-agent", "node-c", 3),
+	}
+}
+
+func newTestDescheduler(t *testing.T, objects ...client.Object) *Descheduler {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(neuronetes.AddToScheme(scheme))
+	return &Descheduler{
+		Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build(),
+	}
+}
+
+func exists(t *testing.T, d *Descheduler, name string) bool {
+	t.Helper()
+	err := d.Get(context.Background(), types.NamespacedName{Namespace: "default", Name: name}, &corev1.Pod{})
+	if apierrors.IsNotFound(err) {
+		return false
+	}
+	require.NoError(t, err)
+	return true
+}
+
+func tainted(t *testing.T, d *Descheduler, name string) bool {
+	t.Helper()
+	node := &corev1.Node{}
+	require.NoError(t, d.Get(context.Background(), types.NamespacedName{Name: name}, node))
+	for _, taint := range node.Spec.Taints {
+		if taint.Key == TaintConsolidating {
+			return true
+		}
+	}
+	return false
+}
+
+func TestConsolidateDrainsLeastUsedNode(t *testing.T) {
+	ctx := context.Background()
+	d := newTestDescheduler(t, fragmented()...)
+
+	drained, err := d.Consolidate(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"node-a"}, drained)
+	assert.False(t, exists(t, d, "chat-0"))
+	assert.True(t, exists(t, d, "llm-1"))
+	assert.True(t, tainted(t, d, "node-a"))
+
+	// The taint is lifted on the next cycle, which drains the next node
+	require.NoError(t, d.Delete(ctx, &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "chat-1", Namespace: "default"}}))
+	require.NoError(t, d.Create(ctx, replica("chat-2", "chat", "chat-agent", "node-b", 3)))
+	d.MaxNodesPerCycle = 2
+	drained, err = d.Consolidate(ctx)
+	require.NoError(t, err)
+	assert.False(t, tainted(t, d, "node-a"))
+	assert.Empty(t, drained, "llm-1 does not fit onto node-b's free GPU")
+}
+
+func TestPlanPrefersNodesHostingTheClass(t *testing.T) {
+	ctx := context.Background()
+	d := newTestDescheduler(t,
+		gpuNodeObject("node-a", 8), gpuNodeObject("node-b", 8), gpuNodeObject("node-c", 8),
+		pool("chat", "chat-agent"), pool("llm", "llm-agent"),
+		replica("chat-0", "chat", "chat-agent", "node-a", 1),
+		replica("chat-1", "chat", "chat-agent", "node-b", 4),
+		replica("llm-0", "llm", "llm-agent", "node-c", 6),
+	)
+	nodes, err := d.gpuNodes(ctx)
+	require.NoError(t, err)
+
+	plan, ok, err := d.plan(ctx, nodes[0], nodes)
+	require.NoError(t, err)
+	require.True(t, ok)
+	for pod, target := range plan {
+		assert.Equal(t, "chat-0", pod.Name)
+		assert.Equal(t, "node-b", target.node.Name, "node-b has the chat model cached")
+	}
+}
+
+func TestConsolidateRespectsSessionsAndBudgets(t *testing.T) {
+	t.Run("replicas serving sticky sessions stay", func(t *testing.T) {
+		objects := fragmented()
+		chat := objects[3].(*neuronetes.AgentPool)
+		chat.Spec.SessionAffinity = &neuronetes.SessionAffinityConfig{Enabled: true}
+		objects[5].(*corev1.Pod).Annotations = map[string]string{neuronetes.AnnotationActiveSessions: "2"}
+		d := newTestDescheduler(t, objects...)
+
+		drained, err := d.Consolidate(context.Background())
+		require.NoError(t, err)
+		// node-c is drained instead; its replica fits onto node-a
+		assert.Equal(t, []string{"node-c"}, drained)
+		assert.True(t, exists(t, d, "chat-0"))
+	})
+
+	t.Run("replicas without sessions move", func(t *testing.T) {
+		objects := fragmented()
+		objects[3].(*neuronetes.AgentPool).Spec.SessionAffinity = &neuronetes.SessionAffinityConfig{Enabled: true}
+		objects[5].(*corev1.Pod).Annotations = map[string]string{neuronetes.AnnotationActiveSessions: "0"}
+		d := newTestDescheduler(t, objects...)
+
+		drained, err := d.Consolidate(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, []string{"node-a"}, drained)
+	})
+
+	t.Run("disruption budgets are honored", func(t *testing.T) {
+		budget := &policyv1.PodDisruptionBudget{
+			ObjectMeta: metav1.ObjectMeta{Name: "chat", Namespace: "default"},
+			Spec: policyv1.PodDisruptionBudgetSpec{
+				Selector: &metav1.LabelSelector{MatchLabels: map[string]string{neuronetes.LabelPool: "chat"}},
+			},
+			Status: policyv1.PodDisruptionBudgetStatus{DisruptionsAllowed: 0},
+		}
+		objects := append(fragmented(), budget)
+		// Only the chat replica on node-a is below the threshold
+		objects[2] = gpuNodeObject("node-c", 4)
+		d := newTestDescheduler(t, objects...)
+
+		drained, err := d.Consolidate(context.Background())
+		require.NoError(t, err)
+		assert.Empty(t, drained)
+		assert.True(t, exists(t, d, "chat-0"))
+		assert.False(t, tainted(t, d, "node-a"))
+	})
+
+	t.Run("paused pools and gangs stay", func(t *testing.T) {
+		objects := fragmented()
+		objects[3].(*neuronetes.AgentPool).Annotations = map[string]string{neuronetes.AnnotationPaused: "true"}
+		objects[8].(*corev1.Pod).Labels[neuronetes.LabelGang] = "llm"
+		d := newTestDescheduler(t, objects...)
+
+		drained, err := d.Consolidate(context.Background())
+		require.NoError(t, err)
+		assert.Empty(t, drained)
+	})
+}
+
+func TestConsolidateCountsClaimedGPUs(t *testing.T) {
+	// llm-0 holds all of node-b, seven of its GPUs through DRA
+	claimed := replica("llm-0", "llm", "llm-agent", "node-b", 1)
+	claimed.Annotations = map[string]string{neuronetes.AnnotationGPUClaims: "nvidia.com/gpu=7"}
+	d := newTestDescheduler(t,
+		gpuNodeObject("node-a", 8), gpuNodeObject("node-b", 8),
+		pool("chat", "chat-agent"), pool("llm", "llm-agent"),
+		replica("chat-0", "chat", "chat-agent", "node-a", 1), claimed,
+	)
+
+	drained, err := d.Consolidate(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, drained, "node-b has no free GPU")
+	assert.True(t, exists(t, d, "chat-0"))
+}
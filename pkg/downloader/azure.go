@@ -0,0 +1,249 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+const (
+	// azureStorageVersion is the version of the Blob service API used
+	azureStorageVersion = "2021-08-06"
+
+	// azureStorageResource is the audience of tokens for Azure Storage
+	azureStorageResource = "https://storage.azure.com/"
+
+	// azureIMDSEndpoint issues tokens of the managed identity of the node
+	azureIMDSEndpoint = "http://169.254.169.254/metadata/identity/oauth2/token"
+)
+
+// AzureSource reads weights from an Azure Blob Storage container. It needs
+// the Storage Blob Data Reader role on the container, or a SAS token with
+// read and list permissions.
+type AzureSource struct {
+	account   string
+	container string
+	prefix    string
+	sas       url.Values
+	client    *http.Client
+
+	// endpoint is the URL of the storage account, overridden by tests
+	endpoint string
+}
+
+// NewAzureSource creates a source reading under prefix in a container of
+// account. It authenticates with the SAS token in AZURE_STORAGE_SAS_TOKEN
+// if set, otherwise with workload identity if AZURE_FEDERATED_TOKEN_FILE
+// is set, otherwise with the managed identity of the node, selected by
+// AZURE_CLIENT_ID if the node has several.
+func NewAzureSource(ctx context.Context, account, container, prefix string) (*AzureSource, error) {
+	if token := os.Getenv("AZURE_STORAGE_SAS_TOKEN"); token != "" {
+		sas, err := url.ParseQuery(strings.TrimPrefix(token, "?"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid AZURE_STORAGE_SAS_TOKEN: %w", err)
+		}
+		return newAzureSource(&http.Client{}, sas, account, container, prefix), nil
+	}
+
+	var source oauth2.TokenSource = &azureIMDSTokenSource{clientID: os.Getenv("AZURE_CLIENT_ID")}
+	if file := os.Getenv("AZURE_FEDERATED_TOKEN_FILE"); file != "" {
+		authority := os.Getenv("AZURE_AUTHORITY_HOST")
+		if authority == "" {
+			authority = "https://login.microsoftonline.com/"
+		}
+		source = &azureWorkloadTokenSource{
+			endpoint: strings.TrimSuffix(authority, "/") + "/" + os.Getenv("AZURE_TENANT_ID") + "/oauth2/v2.0/token",
+			clientID: os.Getenv("AZURE_CLIENT_ID"),
+			file:     file,
+		}
+	}
+	client := oauth2.NewClient(ctx, oauth2.ReuseTokenSource(nil, source))
+	return newAzureSource(client, nil, account, container, prefix), nil
+}
+
+func newAzureSource(client *http.Client, sas url.Values, account, container, prefix string) *AzureSource {
+	return &AzureSource{
+		account:   account,
+		container: container,
+		prefix:    prefix,
+		sas:       sas,
+		client:    client,
+		endpoint:  "https://" + account + ".blob.core.windows.net",
+	}
+}
+
+// azureListResult is the response of List Blobs
+type azureListResult struct {
+	Blobs []struct {
+		Name       string `xml:"Name"`
+		Properties struct {
+			ContentLength int64  `xml:"Content-Length"`
+			Etag          string `xml:"Etag"`
+		} `xml:"Properties"`
+	} `xml:"Blobs>Blob"`
+	NextMarker string `xml:"NextMarker"`
+}
+
+// List implements Source
+func (a *AzureSource) List(ctx context.Context) ([]File, error) {
+	var files []File
+	query := url.Values{"restype": {"container"}, "comp": {"list"}, "prefix": {a.prefix}}
+	for {
+		req, err := a.newRequest(ctx, "", query)
+		if err != nil {
+			return nil, err
+		}
+		status, body, err := send(a.client, req)
+		if err != nil {
+			return nil, err
+		}
+		if status != http.StatusOK {
+			return nil, fmt.Errorf("failed to list az://%s/%s/%s: unexpected status %d: %s", a.account, a.container, a.prefix, status, bytes.TrimSpace(body))
+		}
+		var result azureListResult
+		if err := xml.Unmarshal(body, &result); err != nil {
+			return nil, fmt.Errorf("failed to parse listing of az://%s/%s/%s: %w", a.account, a.container, a.prefix, err)
+		}
+		for _, blob := range result.Blobs {
+			if path, ok := relativePath(a.prefix, blob.Name); ok {
+				files = append(files, File{Path: path, Key: blob.Name, Size: blob.Properties.ContentLength, Version: blob.Properties.Etag, Ranged: true})
+			}
+		}
+		if result.NextMarker == "" {
+			return files, nil
+		}
+		query.Set("marker", result.NextMarker)
+	}
+}
+
+// ReadRange implements Source
+func (a *AzureSource) ReadRange(ctx context.Context, file File, offset, length int64) (io.ReadCloser, error) {
+	req, err := a.newRequest(ctx, file.Key, nil)
+	if err != nil {
+		return nil, err
+	}
+	setRange(req, offset, length)
+	if file.Version != "" {
+		req.Header.Set("If-Match", file.Version)
+	}
+	return openRange(a.client, req, offset, length)
+}
+
+// newRequest returns a GET request for the blob name, or the container if
+// name is empty
+func (a *AzureSource) newRequest(ctx context.Context, name string, query url.Values) (*http.Request, error) {
+	endpoint, err := url.JoinPath(a.endpoint, a.container, name)
+	if err != nil {
+		return nil, err
+	}
+	params := url.Values{}
+	for key, values := range query {
+		params[key] = values
+	}
+	for key, values := range a.sas {
+		params[key] = values
+	}
+	if len(params) > 0 {
+		endpoint += "?" + params.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("x-ms-version", azureStorageVersion)
+	return req, nil
+}
+
+// azureIMDSTokenSource issues tokens of the managed identity of the node
+type azureIMDSTokenSource struct {
+	clientID string
+}
+
+// Token implements oauth2.TokenSource
+func (s *azureIMDSTokenSource) Token() (*oauth2.Token, error) {
+	query := url.Values{"api-version": {"2018-02-01"}, "resource": {azureStorageResource}}
+	if s.clientID != "" {
+		query.Set("client_id", s.clientID)
+	}
+	req, err := http.NewRequest(http.MethodGet, azureIMDSEndpoint+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Metadata", "true")
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresOn   string `json:"expires_on"`
+	}
+	if err := fetchToken(req, &token); err != nil {
+		return nil, fmt.Errorf("failed to get managed identity token: %w", err)
+	}
+	expiresOn, err := strconv.ParseInt(token.ExpiresOn, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid expiry of managed identity token: %w", err)
+	}
+	return &oauth2.Token{AccessToken: token.AccessToken, TokenType: "Bearer", Expiry: time.Unix(expiresOn, 0)}, nil
+}
+
+// azureWorkloadTokenSource exchanges the service account token of workload
+// identity for tokens of its Entra ID application
+type azureWorkloadTokenSource struct {
+	endpoint string
+	clientID string
+	file     string
+}
+
+// Token implements oauth2.TokenSource
+func (s *azureWorkloadTokenSource) Token() (*oauth2.Token, error) {
+	// The projected token is rotated, so it is read for every exchange
+	assertion, err := os.ReadFile(s.file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read federated token: %w", err)
+	}
+	form := url.Values{
+		"client_id":             {s.clientID},
+		"scope":                 {azureStorageResource + ".default"},
+		"grant_type":            {"client_credentials"},
+		"client_assertion_type": {"urn:ietf:params:oauth:client-assertion-type:jwt-bearer"},
+		"client_assertion":      {strings.TrimSpace(string(assertion))},
+	}
+	req, err := http.NewRequest(http.MethodPost, s.endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := fetchToken(req, &token); err != nil {
+		return nil, fmt.Errorf("failed to exchange workload identity token: %w", err)
+	}
+	return &oauth2.Token{
+		AccessToken: token.AccessToken,
+		TokenType:   "Bearer",
+		Expiry:      time.Now().Add(time.Duration(token.ExpiresIn) * time.Second),
+	}, nil
+}
+
+// fetchToken sends the token request req and decodes the response into v
+func fetchToken(req *http.Request, v interface{}) error {
+	status, body, err := send(&http.Client{Timeout: 30 * time.Second}, req)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("unexpected status %d: %s", status, bytes.TrimSpace(body))
+	}
+	return json.Unmarshal(body, v)
+}
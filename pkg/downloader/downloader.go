@@ -0,0 +1,630 @@
+// Package downloader downloads the weights of models from object storage
+// and HTTP servers into a local cache. Files are downloaded in parallel
+// ranged chunks, and the chunks already written are recorded next to the
+// partial file so that an interrupted download resumes where it stopped.
+package downloader
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultChunkSize is the size of the chunks files are downloaded in
+	DefaultChunkSize = 64 << 20
+
+	// DefaultConcurrency bounds the chunks downloaded at once
+	DefaultConcurrency = 8
+
+	// DefaultRetries is the number of times a failed chunk is retried
+	DefaultRetries = 3
+
+	// DefaultProgressInterval is how often progress is reported
+	DefaultProgressInterval = time.Second
+
+	// partialSuffix is appended to the path of files being downloaded
+	partialSuffix = ".partial"
+
+	// stateSuffix is appended to the path of partial files for the record
+	// of their written chunks
+	stateSuffix = ".state"
+)
+
+// ProgressFunc receives the bytes downloaded out of the total size of the
+// weights. It is called from one goroutine at a time.
+type ProgressFunc func(done, total int64)
+
+// Options configures a Downloader
+type Options struct {
+	// ChunkSize is the size of the ranges files are downloaded in.
+	// Defaults to DefaultChunkSize.
+	ChunkSize int64
+
+	// Concurrency bounds the chunks downloaded at once, across files.
+	// Defaults to DefaultConcurrency.
+	Concurrency int
+
+	// Retries is the number of times a failed chunk is retried before the
+	// download fails. Defaults to DefaultRetries.
+	Retries int
+
+	// ProgressInterval is how often progress is reported. Defaults to
+	// DefaultProgressInterval.
+	ProgressInterval time.Duration
+
+	// Shuffle downloads the chunks of files in random order rather than in
+	// sequence, so that peers downloading the same weights at once fetch
+	// different chunks and can share them
+	Shuffle bool
+}
+
+// Request describes the weights to download
+type Request struct {
+	// URI is the location of the weights, see NewSource
+	URI string
+
+	// Dir is the directory the files are downloaded into, keeping their
+	// paths relative to URI
+	Dir string
+
+	// Patterns select the files to download by glob pattern, matched
+	// against their path or base name, e.g. *.safetensors. Empty selects
+	// all files, or DefaultHuggingFacePatterns for hf:// URIs.
+	Patterns []string
+
+	// Token authenticates to sources taking bearer tokens
+	Token string
+
+	// DockerConfigs hold the credentials of registries for oci:// URIs
+	DockerConfigs [][]byte
+
+	// Integrity, if not nil, pins the content of the files. Its signature
+	// is verified before downloading and the files once downloaded.
+	Integrity *Integrity
+
+	// Peers, if not nil, are asked for each chunk before the source
+	Peers *Peers
+
+	// Mirrors are further locations of the same weights. Download tries
+	// URI and its mirrors by descending priority, URI having priority 0,
+	// and fails over to the next when one fails.
+	Mirrors []Mirror
+}
+
+// Mirror is a further location of the weights of a request
+type Mirror struct {
+	// URI is the location of the weights in the mirror, see NewSource
+	URI string
+
+	// Priority orders the mirror among the sources of the weights
+	Priority int32
+
+	// Token authenticates to the mirror. The token of the request is not
+	// sent to mirrors, which may be of other hosts.
+	Token string
+}
+
+// sources returns the locations of the weights of req in the order they
+// are tried. Locations of the same priority keep their order, URI first.
+func (req Request) sources() []Mirror {
+	sources := append([]Mirror{{URI: req.URI, Token: req.Token}}, req.Mirrors...)
+	sort.SliceStable(sources, func(i, j int) bool {
+		return sources[i].Priority > sources[j].Priority
+	})
+	return sources
+}
+
+// Result describes a completed download
+type Result struct {
+	// Files is the number of files of the weights
+	Files int
+
+	// Size is the total size of the weights in bytes
+	Size int64
+
+	// Downloaded is the number of bytes downloaded, less than Size when
+	// the download resumed or files were already cached
+	Downloaded int64
+
+	// FromPeers is the number of bytes downloaded from peers rather than
+	// the source
+	FromPeers int64
+
+	// Revision is the immutable revision the URI resolved to, such as the
+	// commit of a HuggingFace repository, if the source has one
+	Revision string
+
+	// Source is the URI the weights were downloaded from, the URI of the
+	// request or of one of its mirrors
+	Source string
+}
+
+// Downloader downloads the weights of models into local directories
+type Downloader struct {
+	opts Options
+
+	// newSource opens weights URIs, overridden by tests
+	newSource func(ctx context.Context, uri string, opts SourceOptions) (Source, error)
+}
+
+// New creates a downloader opening weights URIs with NewSource
+func New(opts Options) *Downloader {
+	if opts.ChunkSize <= 0 {
+		opts.ChunkSize = DefaultChunkSize
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = DefaultConcurrency
+	}
+	if opts.Retries < 0 {
+		opts.Retries = 0
+	} else if opts.Retries == 0 {
+		opts.Retries = DefaultRetries
+	}
+	if opts.ProgressInterval <= 0 {
+		opts.ProgressInterval = DefaultProgressInterval
+	}
+	return &Downloader{opts: opts, newSource: NewSource}
+}
+
+// Download downloads the files of the weights of req. Complete files
+// already in the directory are kept and partial files resumed. When a
+// source fails, the download fails over to the next mirror, resuming the
+// chunks already written; weights failing the integrity checks of req are
+// not retried from another source, and their errors wrap ErrIntegrity.
+// progress, if not nil, is called periodically and once the download
+// completes.
+func (d *Downloader) Download(ctx context.Context, req Request, progress ProgressFunc) (Result, error) {
+	if req.Integrity != nil {
+		if err := req.Integrity.VerifySignature(); err != nil {
+			return Result{}, err
+		}
+	}
+	sources := req.sources()
+	errs := make([]error, 0, len(sources))
+	for _, mirror := range sources {
+		result, err := d.download(ctx, req, mirror, progress)
+		if err == nil {
+			result.Source = mirror.URI
+			return result, nil
+		}
+		if len(sources) == 1 || ctx.Err() != nil || errors.Is(err, ErrIntegrity) {
+			return Result{}, err
+		}
+		errs = append(errs, err)
+	}
+	return Result{}, fmt.Errorf("all %d sources of %s failed: %w", len(sources), req.URI, errors.Join(errs...))
+}
+
+// download downloads the files of the weights of req from mirror
+func (d *Downloader) download(ctx context.Context, req Request, mirror Mirror, progress ProgressFunc) (Result, error) {
+	uri, dir := mirror.URI, req.Dir
+	source, err := d.newSource(ctx, uri, SourceOptions{Token: mirror.Token, DockerConfigs: req.DockerConfigs})
+	if err != nil {
+		return Result{}, err
+	}
+	files, err := source.List(ctx)
+	if err != nil {
+		return Result{}, err
+	}
+	patterns := req.Patterns
+	if len(patterns) == 0 && strings.HasPrefix(uri, "hf://") {
+		patterns = DefaultHuggingFacePatterns
+	}
+	files, err = selectFiles(files, patterns)
+	if err != nil {
+		return Result{}, err
+	}
+	if len(files) == 0 {
+		return Result{}, fmt.Errorf("no files found at %s", uri)
+	}
+
+	result := Result{Files: len(files)}
+	if revisioned, ok := source.(Revisioned); ok {
+		result.Revision = revisioned.Revision()
+	}
+	var done atomic.Int64
+	var downloads []*fileDownload
+	defer func() {
+		for _, fd := range downloads {
+			fd.close()
+		}
+	}()
+	for _, file := range files {
+		if !filepath.IsLocal(filepath.FromSlash(file.Path)) {
+			return Result{}, fmt.Errorf("file %q of %s escapes the download directory", file.Path, uri)
+		}
+		result.Size += file.Size
+		fd, err := d.prepare(file, filepath.Join(dir, filepath.FromSlash(file.Path)))
+		if err != nil {
+			return Result{}, err
+		}
+		done.Add(fd.written)
+		if fd.file != nil {
+			downloads = append(downloads, fd)
+		}
+	}
+	resumed := done.Load()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var peers *peerSource
+	if req.Peers != nil {
+		// Peers share the weights by the URI of the request, whichever
+		// mirror they download them from
+		peers = newPeerSource(source, req.URI, req.Peers)
+		source = peers
+	}
+	stopProgress := d.reportProgress(ctx, progress, &done, result.Size)
+	err = d.run(ctx, source, downloads, &done)
+	stopProgress()
+	if peers != nil {
+		result.FromPeers = peers.fromPeers()
+	}
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to download %s: %w", uri, err)
+	}
+	if req.Integrity != nil {
+		paths := make([]string, len(files))
+		for i, file := range files {
+			paths[i] = file.Path
+		}
+		if err := req.Integrity.VerifyFiles(ctx, dir, paths); err != nil {
+			return Result{}, fmt.Errorf("failed to verify %s: %w", uri, err)
+		}
+	}
+	if progress != nil {
+		progress(result.Size, result.Size)
+	}
+	result.Downloaded = done.Load() - resumed
+	return result, nil
+}
+
+// selectFiles returns the files whose path or base name matches one of
+// patterns, or all files if there are no patterns
+func selectFiles(files []File, patterns []string) ([]File, error) {
+	if len(patterns) == 0 {
+		return files, nil
+	}
+	var selected []File
+	for _, file := range files {
+		for _, pattern := range patterns {
+			matched, err := path.Match(pattern, file.Path)
+			if err != nil {
+				return nil, fmt.Errorf("invalid file pattern %q: %w", pattern, err)
+			}
+			if !matched {
+				matched, _ = path.Match(pattern, path.Base(file.Path))
+			}
+			if matched {
+				selected = append(selected, file)
+				break
+			}
+		}
+	}
+	return selected, nil
+}
+
+// reportProgress calls progress every interval until the returned function
+// is called
+func (d *Downloader) reportProgress(ctx context.Context, progress ProgressFunc, done *atomic.Int64, total int64) func() {
+	if progress == nil {
+		return func() {}
+	}
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(d.opts.ProgressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				progress(done.Load(), total)
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-stopped
+	}
+}
+
+// chunk is a range of a file to download
+type chunk struct {
+	fd     *fileDownload
+	index  int
+	offset int64
+	length int64
+}
+
+// run downloads the missing chunks of downloads with up to Concurrency
+// workers, stopping at the first chunk failing all its retries
+func (d *Downloader) run(ctx context.Context, source Source, downloads []*fileDownload, done *atomic.Int64) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	chunks := make(chan chunk)
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+	fail := func(err error) {
+		once.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
+
+	for i := 0; i < d.opts.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c := range chunks {
+				if err := d.fetch(ctx, source, c, done); err != nil {
+					fail(err)
+					continue
+				}
+				if err := c.fd.complete(c.index); err != nil {
+					fail(err)
+				}
+			}
+		}()
+	}
+
+	var missing []chunk
+	for _, fd := range downloads {
+		missing = append(missing, fd.missing()...)
+	}
+	if d.opts.Shuffle {
+		rand.Shuffle(len(missing), func(i, j int) { missing[i], missing[j] = missing[j], missing[i] })
+	}
+feed:
+	for _, c := range missing {
+		select {
+		case chunks <- c:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(chunks)
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
+
+// fetch downloads chunk c, retrying failures with a linear backoff. Bytes
+// of failed attempts are taken back from done.
+func (d *Downloader) fetch(ctx context.Context, source Source, c chunk, done *atomic.Int64) error {
+	var err error
+	for attempt := 0; attempt <= d.opts.Retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Duration(attempt) * time.Second):
+			}
+		}
+
+		var written int64
+		written, err = c.fd.write(ctx, source, c, done)
+		if err == nil {
+			return nil
+		}
+		done.Add(-written)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+	return fmt.Errorf("%s: %w", c.fd.Path, err)
+}
+
+// chunkState is the record of the chunks written to a partial file
+type chunkState struct {
+	Size      int64  `json:"size"`
+	Version   string `json:"version,omitempty"`
+	ChunkSize int64  `json:"chunkSize"`
+	Done      []int  `json:"done"`
+}
+
+// fileDownload is the download of one file into its partial file
+type fileDownload struct {
+	File
+	path string
+
+	mu        sync.Mutex
+	file      *os.File
+	state     chunkState
+	done      map[int]bool
+	remaining int
+
+	// written is the size of the chunks written before the download
+	written int64
+}
+
+// prepare opens the partial file of file at path, resuming the chunks
+// recorded in its state. The file of the returned download is nil if file
+// is already complete.
+func (d *Downloader) prepare(file File, path string) (*fileDownload, error) {
+	fd := &fileDownload{File: file, path: path, done: map[int]bool{}}
+	if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() && info.Size() == file.Size {
+		fd.written = file.Size
+		return fd, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create directory of %s: %w", file.Path, err)
+	}
+
+	chunkSize := d.opts.ChunkSize
+	if !file.Ranged || file.Size == 0 {
+		// Sources without ranges are read whole, from the start
+		chunkSize = max(file.Size, 1)
+	}
+	fd.state = chunkState{Size: file.Size, Version: file.Version, ChunkSize: chunkSize}
+
+	var previous chunkState
+	if data, err := os.ReadFile(path + partialSuffix + stateSuffix); err == nil && json.Unmarshal(data, &previous) == nil &&
+		previous.Size == fd.state.Size && previous.Version == fd.state.Version && previous.ChunkSize == fd.state.ChunkSize {
+		fd.state.Done = previous.Done
+	}
+
+	f, err := os.OpenFile(path+partialSuffix, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open partial file of %s: %w", file.Path, err)
+	}
+	if err := f.Truncate(file.Size); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to allocate %s: %w", file.Path, err)
+	}
+	fd.file = f
+
+	chunks := fd.chunks()
+	for _, index := range fd.state.Done {
+		if index >= 0 && index < chunks && !fd.done[index] {
+			fd.done[index] = true
+			fd.written += fd.chunkLength(index)
+		}
+	}
+	fd.remaining = chunks - len(fd.done)
+	if fd.remaining == 0 {
+		return fd, fd.finish()
+	}
+	return fd, nil
+}
+
+// chunks returns the number of chunks of the file
+func (fd *fileDownload) chunks() int {
+	if fd.Size == 0 {
+		return 1
+	}
+	return int((fd.Size + fd.state.ChunkSize - 1) / fd.state.ChunkSize)
+}
+
+// chunkLength returns the length of chunk index
+func (fd *fileDownload) chunkLength(index int) int64 {
+	offset := int64(index) * fd.state.ChunkSize
+	return min(fd.state.ChunkSize, fd.Size-offset)
+}
+
+// missing returns the chunks that are not written yet
+func (fd *fileDownload) missing() []chunk {
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+	var missing []chunk
+	for index := 0; index < fd.chunks(); index++ {
+		if !fd.done[index] {
+			missing = append(missing, chunk{fd: fd, index: index, offset: int64(index) * fd.state.ChunkSize, length: fd.chunkLength(index)})
+		}
+	}
+	return missing
+}
+
+// write downloads chunk c into the partial file, adding the bytes written
+// to done as they are written
+func (fd *fileDownload) write(ctx context.Context, source Source, c chunk, done *atomic.Int64) (int64, error) {
+	if c.length == 0 {
+		return 0, nil
+	}
+	body, err := source.ReadRange(ctx, fd.File, c.offset, c.length)
+	if err != nil {
+		return 0, err
+	}
+	defer body.Close()
+
+	w := &countingWriter{w: io.NewOffsetWriter(fd.file, c.offset), done: done}
+	n, err := io.Copy(w, io.LimitReader(body, c.length))
+	if err != nil {
+		return n, err
+	}
+	if n != c.length {
+		return n, fmt.Errorf("short read of %d bytes at offset %d, want %d", n, c.offset, c.length)
+	}
+	return n, nil
+}
+
+// complete records chunk index as written, and finishes the file when it
+// was the last one
+func (fd *fileDownload) complete(index int) error {
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+
+	fd.done[index] = true
+	fd.state.Done = append(fd.state.Done, index)
+	fd.remaining--
+	if fd.remaining == 0 {
+		return fd.finish()
+	}
+
+	data, err := json.Marshal(fd.state)
+	if err != nil {
+		return err
+	}
+	// Written aside and renamed, so that a crash never leaves a state
+	// recording chunks that are not written
+	if err := fd.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync %s: %w", fd.Path, err)
+	}
+	state := fd.path + partialSuffix + stateSuffix
+	if err := os.WriteFile(state+".tmp", data, 0o644); err != nil {
+		return fmt.Errorf("failed to record progress of %s: %w", fd.Path, err)
+	}
+	return os.Rename(state+".tmp", state)
+}
+
+// finish moves the complete partial file to its path
+func (fd *fileDownload) finish() error {
+	if err := fd.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync %s: %w", fd.Path, err)
+	}
+	if err := fd.file.Close(); err != nil {
+		return fmt.Errorf("failed to close %s: %w", fd.Path, err)
+	}
+	fd.file = nil
+	if err := os.Rename(fd.path+partialSuffix, fd.path); err != nil {
+		return fmt.Errorf("failed to complete %s: %w", fd.Path, err)
+	}
+	if err := os.Remove(fd.path + partialSuffix + stateSuffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove progress of %s: %w", fd.Path, err)
+	}
+	return nil
+}
+
+// close closes the partial file if the download did not finish
+func (fd *fileDownload) close() {
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+	if fd.file != nil {
+		fd.file.Close()
+		fd.file = nil
+	}
+}
+
+// countingWriter adds the bytes written through it to done
+type countingWriter struct {
+	w    io.Writer
+	done *atomic.Int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.done.Add(int64(n))
+	return n, err
+}
@@ -0,0 +1,840 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	crand "crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func randomBytes(n int) []byte {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(int64(n))).Read(data)
+	return data
+}
+
+// memSource serves files from memory, failing reads for which fail
+// returns an error
+type memSource struct {
+	files map[string][]byte
+
+	mu    sync.Mutex
+	reads []string
+	fail  func(path string, offset int64) error
+}
+
+func (s *memSource) List(ctx context.Context) ([]File, error) {
+	var files []File
+	for path, data := range s.files {
+		files = append(files, File{Path: path, Key: path, Size: int64(len(data)), Version: "v1", Ranged: true})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	return files, nil
+}
+
+func (s *memSource) ReadRange(ctx context.Context, file File, offset, length int64) (io.ReadCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reads = append(s.reads, fmt.Sprintf("%s@%d", file.Key, offset))
+	if s.fail != nil {
+		if err := s.fail(file.Key, offset); err != nil {
+			return nil, err
+		}
+	}
+	return io.NopCloser(bytes.NewReader(s.files[file.Key][offset : offset+length])), nil
+}
+
+func newTestDownloader(source Source, opts Options) *Downloader {
+	d := New(opts)
+	d.newSource = func(ctx context.Context, uri string, opts SourceOptions) (Source, error) { return source, nil }
+	return d
+}
+
+func TestDownloadHTTPInChunks(t *testing.T) {
+	data := randomBytes(1000)
+	var ranges []string
+	var mu sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		if r.Method == http.MethodGet {
+			ranges = append(ranges, r.Header.Get("Range"))
+		}
+		mu.Unlock()
+		w.Header().Set("ETag", `"abc"`)
+		http.ServeContent(w, r, "model.gguf", time.Time{}, bytes.NewReader(data))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	var last [2]int64
+	d := New(Options{ChunkSize: 300, Concurrency: 2})
+	result, err := d.Download(context.Background(), Request{URI: server.URL + "/llama/model.gguf", Dir: dir}, func(done, total int64) {
+		last = [2]int64{done, total}
+	})
+	require.NoError(t, err)
+	assert.Equal(t, Result{Files: 1, Size: 1000, Downloaded: 1000, Source: server.URL + "/llama/model.gguf"}, result)
+	assert.Equal(t, [2]int64{1000, 1000}, last)
+
+	got, err := os.ReadFile(filepath.Join(dir, "model.gguf"))
+	require.NoError(t, err)
+	assert.Equal(t, data, got)
+	sort.Strings(ranges)
+	assert.Equal(t, []string{"bytes=0-299", "bytes=300-599", "bytes=600-899", "bytes=900-999"}, ranges)
+	assert.NoFileExists(t, filepath.Join(dir, "model.gguf"+partialSuffix))
+
+	// Complete files are kept
+	ranges = nil
+	result, err = d.Download(context.Background(), Request{URI: server.URL + "/llama/model.gguf", Dir: dir}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), result.Downloaded)
+	assert.Empty(t, ranges)
+}
+
+func TestDownloadResumes(t *testing.T) {
+	source := &memSource{files: map[string][]byte{
+		"config.json":                []byte(`{"architectures":["LlamaForCausalLM"]}`),
+		"model-00001.safetensors":    randomBytes(1000),
+		"tokenizer/tokenizer.json":   randomBytes(150),
+		"tokenizer/special_tokens":   {},
+		"model-00002.safetensors":    randomBytes(450),
+		"model.safetensors.index.js": []byte("{}"),
+	}}
+	source.fail = func(path string, offset int64) error {
+		if path == "model-00001.safetensors" && offset == 500 {
+			return errors.New("connection reset")
+		}
+		return nil
+	}
+
+	dir := t.TempDir()
+	d := newTestDownloader(source, Options{ChunkSize: 100, Concurrency: 1, Retries: -1})
+	_, err := d.Download(context.Background(), Request{URI: "s3://models/llama", Dir: dir}, nil)
+	require.ErrorContains(t, err, "model-00001.safetensors: connection reset")
+	assert.FileExists(t, filepath.Join(dir, "config.json"))
+	assert.FileExists(t, filepath.Join(dir, "model-00001.safetensors"+partialSuffix+stateSuffix))
+
+	source.fail = nil
+	source.reads = nil
+	result, err := d.Download(context.Background(), Request{URI: "s3://models/llama", Dir: dir}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 6, result.Files)
+	assert.Equal(t, int64(1640), result.Size)
+	assert.Less(t, result.Downloaded, result.Size)
+	for _, read := range source.reads {
+		assert.NotEqual(t, "model-00001.safetensors@0", read, "written chunks are not downloaded again")
+		assert.False(t, strings.HasPrefix(read, "config.json"), "complete files are not downloaded again")
+	}
+
+	for path, data := range source.files {
+		got, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(path)))
+		require.NoError(t, err)
+		assert.Equal(t, data, got, path)
+	}
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	for _, entry := range entries {
+		assert.NotContains(t, entry.Name(), partialSuffix)
+	}
+}
+
+func TestDownloadRetriesChunks(t *testing.T) {
+	failures := 0
+	source := &memSource{files: map[string][]byte{"model.gguf": randomBytes(200)}}
+	source.fail = func(path string, offset int64) error {
+		if offset == 100 && failures < 1 {
+			failures++
+			return errors.New("503 slow down")
+		}
+		return nil
+	}
+	d := newTestDownloader(source, Options{ChunkSize: 100})
+	result, err := d.Download(context.Background(), Request{URI: "gs://models/llama", Dir: t.TempDir()}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(200), result.Downloaded)
+	assert.Equal(t, 1, failures)
+}
+
+func TestDownloadFailsOverToMirrors(t *testing.T) {
+	data := randomBytes(300)
+	regional := &memSource{files: map[string][]byte{"model.gguf": data}}
+	regional.fail = func(path string, offset int64) error {
+		if offset == 200 {
+			return errors.New("503 slow down")
+		}
+		return nil
+	}
+	primary := &memSource{files: map[string][]byte{"model.gguf": data}}
+	sources := map[string]*memSource{"s3://models-eu/llama": regional, "s3://models/llama": primary}
+	var opened []string
+	d := New(Options{ChunkSize: 100, Concurrency: 1, Retries: -1})
+	d.newSource = func(ctx context.Context, uri string, opts SourceOptions) (Source, error) {
+		opened = append(opened, uri+" "+opts.Token)
+		if source, ok := sources[uri]; ok {
+			return source, nil
+		}
+		return nil, fmt.Errorf("no such bucket")
+	}
+
+	// The regional mirror is tried first; the download resumes from the
+	// source after it fails
+	dir := t.TempDir()
+	req := Request{
+		URI:   "s3://models/llama",
+		Dir:   dir,
+		Token: "secret",
+		Mirrors: []Mirror{
+			{URI: "s3://fallback/llama", Priority: -1},
+			{URI: "s3://models-eu/llama", Priority: 10, Token: "eu"},
+		},
+	}
+	result, err := d.Download(context.Background(), req, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "s3://models/llama", result.Source)
+	assert.Equal(t, []string{"s3://models-eu/llama eu", "s3://models/llama secret"}, opened)
+	assert.Equal(t, []string{"model.gguf@200"}, primary.reads)
+	got, err := os.ReadFile(filepath.Join(dir, "model.gguf"))
+	require.NoError(t, err)
+	assert.Equal(t, data, got)
+
+	// Every source failing fails the download
+	opened = nil
+	primary.fail = regional.fail
+	_, err = d.Download(context.Background(), Request{URI: "s3://models/llama", Dir: t.TempDir(), Mirrors: req.Mirrors}, nil)
+	assert.ErrorContains(t, err, "all 3 sources of s3://models/llama failed")
+	assert.ErrorContains(t, err, "no such bucket")
+	assert.Len(t, opened, 3)
+}
+
+func TestDownloadRejectsEscapingPaths(t *testing.T) {
+	source := &memSource{files: map[string][]byte{"../etc/passwd": []byte("x")}}
+	_, err := newTestDownloader(source, Options{}).Download(context.Background(), Request{URI: "s3://models/llama", Dir: t.TempDir()}, nil)
+	assert.ErrorContains(t, err, "escapes the download directory")
+
+	_, err = newTestDownloader(&memSource{}, Options{}).Download(context.Background(), Request{URI: "s3://models/llama", Dir: t.TempDir()}, nil)
+	assert.ErrorContains(t, err, "no files found")
+}
+
+func TestS3Source(t *testing.T) {
+	objects := map[string][]byte{
+		"llama/config.json":            []byte("{}"),
+		"llama/model.safetensors":      randomBytes(64),
+		"llama/":                       nil,
+		"llama-2/model.safetensors":    []byte("other"),
+		"mistral/model.safetensors":    []byte("other"),
+		"llama/tokenizer/vocab.json":   []byte("[]"),
+		"single/weights.gguf":          randomBytes(10),
+		"single/weights.gguf.sha256":   []byte("x"),
+		"single/weights.gguf/ignored/": nil,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Contains(t, r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/")
+		assert.Equal(t, emptyPayloadHash, r.Header.Get("X-Amz-Content-Sha256"))
+		key := strings.TrimPrefix(r.URL.Path, "/models/")
+		if key == "" {
+			prefix := r.URL.Query().Get("prefix")
+			var keys []string
+			for key := range objects {
+				if strings.HasPrefix(key, prefix) {
+					keys = append(keys, key)
+				}
+			}
+			sort.Strings(keys)
+			fmt.Fprint(w, "<ListBucketResult>")
+			for _, key := range keys {
+				fmt.Fprintf(w, `<Contents><Key>%s</Key><Size>%d</Size><ETag>"%s"</ETag></Contents>`, key, len(objects[key]), key)
+			}
+			fmt.Fprint(w, "</ListBucketResult>")
+			return
+		}
+		assert.Equal(t, `"`+key+`"`, r.Header.Get("If-Match"))
+		w.Header().Set("ETag", `"`+key+`"`)
+		http.ServeContent(w, r, key, time.Time{}, bytes.NewReader(objects[key]))
+	}))
+	defer server.Close()
+
+	credentials := aws.CredentialsProviderFunc(func(ctx context.Context) (aws.Credentials, error) {
+		return aws.Credentials{AccessKeyID: "AKID", SecretAccessKey: "SECRET"}, nil
+	})
+	source := newS3Source(credentials, "models", "llama", "us-west-2")
+	source.endpoint = server.URL + "/models"
+
+	ctx := context.Background()
+	files, err := source.List(ctx)
+	require.NoError(t, err)
+	var paths []string
+	for _, file := range files {
+		paths = append(paths, file.Path)
+	}
+	assert.Equal(t, []string{"config.json", "model.safetensors", "tokenizer/vocab.json"}, paths)
+
+	body, err := source.ReadRange(ctx, files[1], 16, 8)
+	require.NoError(t, err)
+	got, err := io.ReadAll(body)
+	require.NoError(t, err)
+	body.Close()
+	assert.Equal(t, objects["llama/model.safetensors"][16:24], got)
+
+	source = newS3Source(credentials, "models", "single/weights.gguf", "us-west-2")
+	source.endpoint = server.URL + "/models"
+	files, err = source.List(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []File{{Path: "weights.gguf", Key: "single/weights.gguf", Size: 10, Version: `"single/weights.gguf"`, Ranged: true}}, files)
+}
+
+func TestGCSSource(t *testing.T) {
+	data := randomBytes(32)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/storage/v1/b/models/o":
+			assert.Equal(t, "llama", r.URL.Query().Get("prefix"))
+			if r.URL.Query().Get("pageToken") == "" {
+				fmt.Fprint(w, `{"items":[{"name":"llama/config.json","size":"2","generation":"1"}],"nextPageToken":"p2"}`)
+				return
+			}
+			fmt.Fprint(w, `{"items":[{"name":"llama/model.safetensors","size":"32","generation":"7"}]}`)
+		case "/storage/v1/b/models/o/llama/model.safetensors":
+			assert.Equal(t, "llama%2Fmodel.safetensors", strings.Split(r.URL.RawPath, "/")[6])
+			assert.Equal(t, "media", r.URL.Query().Get("alt"))
+			assert.Equal(t, "7", r.URL.Query().Get("generation"))
+			http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+		case "/storage/v1/b/models/o/llama/config.json":
+			http.ServeContent(w, r, "", time.Time{}, strings.NewReader("{}"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	source := newGCSSource(server.Client(), "models", "llama")
+	source.endpoint = server.URL
+	d := newTestDownloader(source, Options{ChunkSize: 10})
+	dir := t.TempDir()
+	result, err := d.Download(context.Background(), Request{URI: "gs://models/llama", Dir: dir}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, Result{Files: 2, Size: 34, Downloaded: 34, Source: "gs://models/llama"}, result)
+	got, err := os.ReadFile(filepath.Join(dir, "model.safetensors"))
+	require.NoError(t, err)
+	assert.Equal(t, data, got)
+}
+
+func TestAzureSource(t *testing.T) {
+	data := randomBytes(48)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, azureStorageVersion, r.Header.Get("x-ms-version"))
+		assert.Equal(t, "sig", r.URL.Query().Get("sig"))
+		switch r.URL.Path {
+		case "/weights":
+			assert.Equal(t, "list", r.URL.Query().Get("comp"))
+			if r.URL.Query().Get("marker") == "" {
+				fmt.Fprint(w, `<EnumerationResults><Blobs><Blob><Name>llama/model.gguf</Name><Properties><Content-Length>48</Content-Length><Etag>&quot;0x1&quot;</Etag></Properties></Blob></Blobs><NextMarker>m2</NextMarker></EnumerationResults>`)
+				return
+			}
+			fmt.Fprint(w, `<EnumerationResults><Blobs><Blob><Name>llama-3/model.gguf</Name><Properties><Content-Length>1</Content-Length></Properties></Blob></Blobs><NextMarker/></EnumerationResults>`)
+		case "/weights/llama/model.gguf":
+			assert.Equal(t, `"0x1"`, r.Header.Get("If-Match"))
+			w.Header().Set("ETag", `"0x1"`)
+			http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	t.Setenv("AZURE_STORAGE_SAS_TOKEN", "?sv=2021-08-06&sig=sig")
+	source, err := NewAzureSource(context.Background(), "account", "weights", "llama")
+	require.NoError(t, err)
+	source.endpoint = server.URL
+	d := newTestDownloader(source, Options{ChunkSize: 16})
+	dir := t.TempDir()
+	result, err := d.Download(context.Background(), Request{URI: "az://account/weights/llama", Dir: dir}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, Result{Files: 1, Size: 48, Downloaded: 48, Source: "az://account/weights/llama"}, result)
+	got, err := os.ReadFile(filepath.Join(dir, "model.gguf"))
+	require.NoError(t, err)
+	assert.Equal(t, data, got)
+}
+
+func TestNewSource(t *testing.T) {
+	for _, uri := range []string{"s3://models/llama", "gs://models/llama", "az://account/weights/llama", "hf://meta-llama/Llama-3-8B", "oci://ghcr.io/acme/llama3:8b", "https://example.com/model.gguf"} {
+		assert.True(t, Supported(uri), uri)
+	}
+	for _, uri := range []string{"ftp://models/llama3", "/models/llama", "s3:///llama"} {
+		assert.False(t, Supported(uri), uri)
+		_, err := NewSource(context.Background(), uri, SourceOptions{})
+		assert.Error(t, err, uri)
+	}
+	_, err := NewSource(context.Background(), "az://account", SourceOptions{})
+	assert.ErrorContains(t, err, "no container")
+}
+
+// huggingFaceHub serves the files of a repository at one commit,
+// redirecting LFS files to a CDN
+func huggingFaceHub(t *testing.T, files map[string][]byte, lfs map[string]bool) *httptest.Server {
+	const sha = "0123456789abcdef0123456789abcdef01234567"
+	cdn := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Empty(t, r.Header.Get("Authorization"), "tokens are not sent to the CDN")
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(files[strings.TrimPrefix(r.URL.Path, "/")]))
+	}))
+	t.Cleanup(cdn.Close)
+
+	hub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer hf_token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path == "/api/models/meta-llama/Llama-3-8B/revision/v1.0" {
+			assert.Equal(t, "true", r.URL.Query().Get("blobs"))
+			type sibling struct {
+				Filename string            `json:"rfilename"`
+				Size     int               `json:"size"`
+				BlobID   string            `json:"blobId"`
+				LFS      map[string]string `json:"lfs,omitempty"`
+			}
+			var siblings []sibling
+			for name, data := range files {
+				s := sibling{Filename: name, Size: len(data), BlobID: "blob-" + name}
+				if lfs[name] {
+					s.LFS = map[string]string{"sha256": "sha-" + name}
+				}
+				siblings = append(siblings, s)
+			}
+			require.NoError(t, json.NewEncoder(w).Encode(map[string]interface{}{"sha": sha, "siblings": siblings}))
+			return
+		}
+		name, ok := strings.CutPrefix(r.URL.Path, "/meta-llama/Llama-3-8B/resolve/"+sha+"/")
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if lfs[name] {
+			http.Redirect(w, r, cdn.URL+"/"+name, http.StatusFound)
+			return
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(files[name]))
+	}))
+	t.Cleanup(hub.Close)
+	t.Setenv("HF_ENDPOINT", hub.URL)
+	return hub
+}
+
+func TestHuggingFaceSource(t *testing.T) {
+	files := map[string][]byte{
+		"config.json":                      []byte(`{"model_type":"llama"}`),
+		"model-00001-of-00002.safetensors": randomBytes(300),
+		"model-00002-of-00002.safetensors": randomBytes(120),
+		"pytorch_model.bin":                randomBytes(50),
+		"original/consolidated.00.pth":     randomBytes(40),
+		"tokenizer.json":                   []byte("{}"),
+		"modeling_llama.py":                []byte("import os"),
+	}
+	huggingFaceHub(t, files, map[string]bool{
+		"model-00001-of-00002.safetensors": true,
+		"model-00002-of-00002.safetensors": true,
+		"pytorch_model.bin":                true,
+	})
+
+	dir := t.TempDir()
+	d := New(Options{ChunkSize: 100})
+	result, err := d.Download(context.Background(), Request{URI: "hf://meta-llama/Llama-3-8B@v1.0", Dir: dir, Token: "hf_token"}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, Result{Files: 4, Size: 444, Downloaded: 444, Revision: "0123456789abcdef0123456789abcdef01234567", Source: "hf://meta-llama/Llama-3-8B@v1.0"}, result)
+	for _, name := range []string{"config.json", "model-00001-of-00002.safetensors", "model-00002-of-00002.safetensors", "tokenizer.json"} {
+		got, err := os.ReadFile(filepath.Join(dir, name))
+		require.NoError(t, err)
+		assert.Equal(t, files[name], got, name)
+	}
+	for _, name := range []string{"pytorch_model.bin", "original", "modeling_llama.py"} {
+		assert.NoFileExists(t, filepath.Join(dir, name))
+	}
+
+	// Patterns replace the defaults
+	dir = t.TempDir()
+	result, err = d.Download(context.Background(), Request{URI: "hf://meta-llama/Llama-3-8B@v1.0", Dir: dir, Token: "hf_token", Patterns: []string{"original/*"}}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 1, result.Files)
+	assert.FileExists(t, filepath.Join(dir, "original", "consolidated.00.pth"))
+
+	_, err = d.Download(context.Background(), Request{URI: "hf://meta-llama/Llama-3-8B@v1.0", Dir: t.TempDir()}, nil)
+	assert.ErrorContains(t, err, "need a token with access")
+}
+
+func TestParseHuggingFaceURI(t *testing.T) {
+	for uri, want := range map[string][2]string{
+		"hf://meta-llama/Llama-3-8B@main":    {"meta-llama/Llama-3-8B", "main"},
+		"hf://meta-llama/Llama-3-8B":         {"meta-llama/Llama-3-8B", ""},
+		"hf://gpt2@e7da7f2":                  {"gpt2", "e7da7f2"},
+		"hf://org/model@refs/pr/12":          {"org/model", "refs/pr/12"},
+		"hf://mistralai/Mistral-7B-v0.3@v2/": {"mistralai/Mistral-7B-v0.3", "v2"},
+	} {
+		repo, revision, err := parseHuggingFaceURI(uri)
+		require.NoError(t, err, uri)
+		assert.Equal(t, want, [2]string{repo, revision}, uri)
+	}
+	_, _, err := parseHuggingFaceURI("hf://org/model/extra@main")
+	assert.Error(t, err)
+}
+
+// ociRegistry serves an artifact with a layer per file through the token
+// flow of registries, expiring the token once the manifest is read
+func ociRegistry(t *testing.T, files map[string][]byte) (*httptest.Server, string) {
+	storage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Empty(t, r.Header.Get("Authorization"), "tokens are not sent to blob storage")
+		for _, data := range files {
+			if digestOf(data) == strings.TrimPrefix(r.URL.Path, "/") {
+				http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+				return
+			}
+		}
+		http.NotFound(w, r)
+	}))
+	t.Cleanup(storage.Close)
+
+	type layer struct {
+		MediaType   string            `json:"mediaType"`
+		Digest      string            `json:"digest"`
+		Size        int               `json:"size"`
+		Annotations map[string]string `json:"annotations,omitempty"`
+	}
+	layers := []layer{{MediaType: "application/vnd.oci.image.layer.v1.tar", Digest: "sha256:untitled", Size: 10}}
+	for name, data := range files {
+		layers = append(layers, layer{MediaType: "application/octet-stream", Digest: digestOf(data), Size: len(data), Annotations: map[string]string{ociTitleAnnotation: name}})
+	}
+	manifest, err := json.Marshal(map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     "application/vnd.oci.image.manifest.v1+json",
+		"config":        layer{MediaType: "application/vnd.oci.empty.v1+json", Digest: "sha256:empty", Size: 2},
+		"layers":        layers,
+	})
+	require.NoError(t, err)
+
+	var (
+		mu     sync.Mutex
+		issued int
+		valid  string
+	)
+	var registry *httptest.Server
+	registry = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.URL.Path == "/token" {
+			username, password, ok := r.BasicAuth()
+			if !ok || username != "robot" || password != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			assert.Equal(t, "registry.example.com", r.URL.Query().Get("service"))
+			assert.Equal(t, "repository:models/llama3:pull", r.URL.Query().Get("scope"))
+			issued++
+			valid = fmt.Sprintf("token-%d", issued)
+			require.NoError(t, json.NewEncoder(w).Encode(map[string]string{"token": valid}))
+			return
+		}
+		if valid == "" || r.Header.Get("Authorization") != "Bearer "+valid {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="registry.example.com",scope="repository:models/llama3:pull"`, registry.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case r.URL.Path == "/v2/models/llama3/manifests/70b-int4":
+			assert.Contains(t, r.Header.Get("Accept"), "application/vnd.oci.image.manifest.v1+json")
+			w.Header().Set("Content-Type", "application/vnd.oci.image.manifest.v1+json")
+			_, _ = w.Write(manifest)
+			valid = ""
+		case strings.HasPrefix(r.URL.Path, "/v2/models/llama3/blobs/"):
+			http.Redirect(w, r, storage.URL+"/"+strings.TrimPrefix(r.URL.Path, "/v2/models/llama3/blobs/"), http.StatusTemporaryRedirect)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(registry.Close)
+	return registry, digestOf(manifest)
+}
+
+func digestOf(data []byte) string {
+	hash := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(hash[:])
+}
+
+func TestOCISource(t *testing.T) {
+	files := map[string][]byte{
+		"config.json":       []byte(`{"model_type":"llama"}`),
+		"model.safetensors": randomBytes(500),
+	}
+	registry, digest := ociRegistry(t, files)
+
+	other := []byte(`{"auths":{"ghcr.io":{"username":"someone","password":"else"}}}`)
+	config := []byte(`{"auths":{"https://registry.example.com/v1/":{"auth":"` + base64.StdEncoding.EncodeToString([]byte("robot:secret")) + `"}}}`)
+	source, err := NewOCISource("oci://registry.example.com/models/llama3:70b-int4", [][]byte{other, config})
+	require.NoError(t, err)
+	source.endpoint = registry.URL
+
+	dir := t.TempDir()
+	result, err := newTestDownloader(source, Options{ChunkSize: 64}).Download(context.Background(), Request{URI: "oci://registry.example.com/models/llama3:70b-int4", Dir: dir}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, Result{Files: 2, Size: 522, Downloaded: 522, Revision: digest, Source: "oci://registry.example.com/models/llama3:70b-int4"}, result)
+	for name, data := range files {
+		got, err := os.ReadFile(filepath.Join(dir, name))
+		require.NoError(t, err)
+		assert.Equal(t, data, got, name)
+	}
+
+	// Digest references must match the manifest
+	source, err = NewOCISource("oci://registry.example.com/models/llama3@sha256:0000", [][]byte{config})
+	require.NoError(t, err)
+	source.endpoint = registry.URL
+	_, err = source.List(context.Background())
+	assert.Error(t, err)
+
+	source, err = NewOCISource("oci://registry.example.com/models/llama3:70b-int4", nil)
+	require.NoError(t, err)
+	source.endpoint = registry.URL
+	_, err = source.List(context.Background())
+	assert.ErrorContains(t, err, "unexpected status 401")
+}
+
+func TestParseOCIReference(t *testing.T) {
+	for ref, want := range map[string][3]string{
+		"oci://ghcr.io/acme/models/llama3:70b-int4": {"ghcr.io", "acme/models/llama3", "70b-int4"},
+		"oci://localhost:5000/llama3":               {"localhost:5000", "llama3", "latest"},
+		"oci://docker.io/mistral:7b":                {"docker.io", "library/mistral", "7b"},
+		"oci://ghcr.io/acme/llama3@sha256:abc":      {"ghcr.io", "acme/llama3", "sha256:abc"},
+	} {
+		registry, repository, reference, err := parseOCIReference(ref)
+		require.NoError(t, err, ref)
+		assert.Equal(t, want, [3]string{registry, repository, reference}, ref)
+	}
+	for _, ref := range []string{"oci://ghcr.io", "oci://ghcr.io/Acme/Llama3:8b"} {
+		_, _, _, err := parseOCIReference(ref)
+		assert.Error(t, err, ref)
+	}
+}
+
+func TestParseChallenge(t *testing.T) {
+	scheme, params := parseChallenge(`Bearer realm="https://auth.docker.io/token",service="registry.docker.io",scope="repository:library/mistral:pull"`)
+	assert.Equal(t, "Bearer", scheme)
+	assert.Equal(t, map[string]string{"realm": "https://auth.docker.io/token", "service": "registry.docker.io", "scope": "repository:library/mistral:pull"}, params)
+}
+
+// signedIntegrity returns the integrity of files signed with a new key
+func signedIntegrity(t *testing.T, files map[string][]byte) (*Integrity, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), crand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	require.NoError(t, err)
+
+	integrity := &Integrity{SHA256: map[string]string{}, PublicKey: pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})}
+	for path, data := range files {
+		integrity.SHA256[path] = strings.TrimPrefix(digestOf(data), "sha256:")
+	}
+	sign(t, integrity, key)
+	return integrity, key
+}
+
+func sign(t *testing.T, integrity *Integrity, key *ecdsa.PrivateKey) {
+	digest := sha256.Sum256(integrity.Checksums())
+	signature, err := ecdsa.SignASN1(crand.Reader, key, digest[:])
+	require.NoError(t, err)
+	integrity.Signature = base64.StdEncoding.EncodeToString(signature)
+}
+
+func TestDownloadVerifiesIntegrity(t *testing.T) {
+	files := map[string][]byte{"config.json": []byte("{}"), "model.safetensors": randomBytes(200)}
+	source := &memSource{files: files}
+	d := newTestDownloader(source, Options{ChunkSize: 64})
+
+	integrity, key := signedIntegrity(t, files)
+	assert.Equal(t, fmt.Sprintf("%s  config.json\n%s  model.safetensors\n", integrity.SHA256["config.json"], integrity.SHA256["model.safetensors"]), string(integrity.Checksums()))
+	_, err := d.Download(context.Background(), Request{URI: "s3://models/llama", Dir: t.TempDir(), Integrity: integrity}, nil)
+	require.NoError(t, err)
+
+	// Signatures are verified before downloading
+	source.reads = nil
+	tampered := *integrity
+	tampered.SHA256 = map[string]string{"config.json": integrity.SHA256["config.json"], "model.safetensors": strings.Repeat("0", 64)}
+	_, err = d.Download(context.Background(), Request{URI: "s3://models/llama", Dir: t.TempDir(), Integrity: &tampered}, nil)
+	assert.ErrorIs(t, err, ErrIntegrity)
+	assert.ErrorContains(t, err, "signature does not match")
+	assert.Empty(t, source.reads)
+
+	// Files not matching their digest are removed
+	sign(t, &tampered, key)
+	dir := t.TempDir()
+	_, err = d.Download(context.Background(), Request{URI: "s3://models/llama", Dir: dir, Integrity: &tampered}, nil)
+	assert.ErrorIs(t, err, ErrIntegrity)
+	assert.ErrorContains(t, err, "model.safetensors has digest "+integrity.SHA256["model.safetensors"]+", want "+strings.Repeat("0", 64))
+	assert.NoFileExists(t, filepath.Join(dir, "model.safetensors"))
+	assert.FileExists(t, filepath.Join(dir, "config.json"))
+
+	// Every file needs a digest, and every digest a file
+	_, err = d.Download(context.Background(), Request{URI: "s3://models/llama", Dir: t.TempDir(), Integrity: &Integrity{SHA256: map[string]string{
+		"model.safetensors": integrity.SHA256["model.safetensors"],
+		"tokenizer.json":    integrity.SHA256["config.json"],
+	}}}, nil)
+	assert.ErrorContains(t, err, "config.json has no digest; tokenizer.json is missing")
+}
+
+func TestVerifySignatureKeyTypes(t *testing.T) {
+	integrity := &Integrity{SHA256: map[string]string{"model.gguf": strings.Repeat("a", 64)}}
+	message := integrity.Checksums()
+	digest := sha256.Sum256(message)
+
+	edPublic, edPrivate, err := ed25519.GenerateKey(crand.Reader)
+	require.NoError(t, err)
+	rsaKey, err := rsa.GenerateKey(crand.Reader, 2048)
+	require.NoError(t, err)
+	rsaSignature, err := rsa.SignPKCS1v15(crand.Reader, rsaKey, crypto.SHA256, digest[:])
+	require.NoError(t, err)
+
+	for name, key := range map[string]struct {
+		public    interface{}
+		signature []byte
+	}{
+		"ed25519": {edPublic, ed25519.Sign(edPrivate, message)},
+		"rsa":     {&rsaKey.PublicKey, rsaSignature},
+	} {
+		der, err := x509.MarshalPKIXPublicKey(key.public)
+		require.NoError(t, err, name)
+		integrity.PublicKey = pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+		integrity.Signature = base64.StdEncoding.EncodeToString(key.signature)
+		assert.NoError(t, integrity.VerifySignature(), name)
+		integrity.Signature = base64.StdEncoding.EncodeToString(append(key.signature[1:], key.signature[0]))
+		assert.ErrorIs(t, integrity.VerifySignature(), ErrIntegrity, name)
+	}
+}
+
+func TestDownloadFromPeers(t *testing.T) {
+	files := map[string][]byte{
+		"model-00001.safetensors": randomBytes(1000),
+		"tokenizer/vocab.json":    randomBytes(100),
+	}
+
+	// The peer downloaded the tokenizer and stopped halfway through the
+	// weights
+	peerDir := t.TempDir()
+	seed := &memSource{files: files}
+	_, err := newTestDownloader(seed, Options{ChunkSize: 100}).
+		Download(context.Background(), Request{URI: "s3://models/llama", Dir: peerDir, Patterns: []string{"tokenizer/*"}}, nil)
+	require.NoError(t, err)
+	seed.fail = func(path string, offset int64) error {
+		if offset >= 500 {
+			return errors.New("connection reset")
+		}
+		return nil
+	}
+	_, err = newTestDownloader(seed, Options{ChunkSize: 100, Concurrency: 1, Retries: -1}).
+		Download(context.Background(), Request{URI: "s3://models/llama", Dir: peerDir}, nil)
+	require.Error(t, err)
+
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, ok := strings.CutPrefix(r.URL.Path, "/llama/files/")
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		ServeDownloaded(w, r, path, "s3://models/llama", peerDir)
+	}))
+	defer peer.Close()
+	unreachable := httptest.NewServer(http.NotFoundHandler())
+	unreachable.Close()
+
+	var mu sync.Mutex
+	observed := map[string]int64{}
+	peers := &Peers{
+		URLs: func() []string { return []string{peer.URL + "/llama", unreachable.URL} },
+		Observe: func(from string, bytes int64, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case errors.Is(err, ErrNotDownloaded):
+				observed["miss"]++
+			case err != nil:
+				observed["error"]++
+			case from == "":
+				observed["origin"] += bytes
+			default:
+				observed["peer"] += bytes
+			}
+		},
+	}
+
+	source := &memSource{files: files}
+	dir := t.TempDir()
+	d := newTestDownloader(source, Options{ChunkSize: 100, Concurrency: 1, Shuffle: true})
+	result, err := d.Download(context.Background(), Request{URI: "s3://models/llama", Dir: dir, Peers: peers}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1100), result.Downloaded)
+	assert.Equal(t, int64(600), result.FromPeers)
+	for path, data := range files {
+		got, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(path)))
+		require.NoError(t, err)
+		assert.Equal(t, data, got, path)
+	}
+
+	sort.Strings(source.reads)
+	assert.Equal(t, []string{
+		"model-00001.safetensors@500", "model-00001.safetensors@600", "model-00001.safetensors@700",
+		"model-00001.safetensors@800", "model-00001.safetensors@900",
+	}, source.reads, "only the chunks the peer lacks are read from the source")
+	assert.Equal(t, int64(600), observed["peer"])
+	assert.Equal(t, int64(500), observed["origin"])
+	assert.Equal(t, int64(5), observed["miss"])
+	assert.Equal(t, int64(1), observed["error"], "unreachable peers back off")
+}
+
+func TestServeDownloaded(t *testing.T) {
+	dir := t.TempDir()
+	data := randomBytes(300)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "model.gguf"+partialSuffix), data, 0o644))
+	state, err := json.Marshal(chunkState{Size: 300, Version: "v1", ChunkSize: 100, Done: []int{1}})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "model.gguf"+partialSuffix+stateSuffix), state, 0o644))
+
+	serve := func(path, query, rangeHeader string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/files/"+path+"?"+query, nil)
+		req.Header.Set("Range", rangeHeader)
+		w := httptest.NewRecorder()
+		ServeDownloaded(w, req, path, "s3://models/llama", dir)
+		return w
+	}
+	query := "uri=s3%3A%2F%2Fmodels%2Fllama&size=300&version=v1"
+
+	w := serve("model.gguf", query, "bytes=100-199")
+	require.Equal(t, http.StatusPartialContent, w.Code)
+	assert.Equal(t, "bytes 100-199/300", w.Header().Get("Content-Range"))
+	assert.Equal(t, data[100:200], w.Body.Bytes())
+
+	assert.Equal(t, http.StatusNotFound, serve("model.gguf", query, "bytes=100-200").Code, "chunk 2 is not written")
+	assert.Equal(t, http.StatusNotFound, serve("model.gguf", "uri=s3%3A%2F%2Fmodels%2Fllama&size=300&version=v2", "bytes=100-199").Code)
+	assert.Equal(t, http.StatusNotFound, serve("model.gguf", "uri=s3%3A%2F%2Fmodels%2Fmistral&size=300&version=v1", "bytes=100-199").Code)
+	assert.Equal(t, http.StatusNotFound, serve("../model.gguf", query, "bytes=100-199").Code)
+	assert.Equal(t, http.StatusNotFound, serve("model.gguf"+partialSuffix, query, "bytes=100-199").Code)
+	assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, serve("model.gguf", query, "bytes=200-300").Code)
+	assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, serve("model.gguf", query, "bytes=-100").Code)
+}
@@ -0,0 +1,101 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"golang.org/x/oauth2/google"
+)
+
+// gcsScope allows reading objects
+const gcsScope = "https://www.googleapis.com/auth/devstorage.read_only"
+
+// GCSSource reads weights from a Cloud Storage bucket through its JSON API.
+// It needs the storage.objects.get and list permissions.
+type GCSSource struct {
+	bucket string
+	prefix string
+	client *http.Client
+
+	// endpoint is the URL of the API, overridden by tests
+	endpoint string
+}
+
+// NewGCSSource creates a source reading under prefix in bucket with the
+// application default credentials of Google Cloud
+func NewGCSSource(ctx context.Context, bucket, prefix string) (*GCSSource, error) {
+	client, err := google.DefaultClient(ctx, gcsScope)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load Google Cloud credentials: %w", err)
+	}
+	return newGCSSource(client, bucket, prefix), nil
+}
+
+func newGCSSource(client *http.Client, bucket, prefix string) *GCSSource {
+	return &GCSSource{
+		bucket:   bucket,
+		prefix:   prefix,
+		client:   client,
+		endpoint: "https://storage.googleapis.com",
+	}
+}
+
+// List implements Source
+func (g *GCSSource) List(ctx context.Context) ([]File, error) {
+	var files []File
+	query := url.Values{"prefix": {g.prefix}, "fields": {"items(name,size,generation),nextPageToken"}}
+	for {
+		endpoint := fmt.Sprintf("%s/storage/v1/b/%s/o?%s", g.endpoint, url.PathEscape(g.bucket), query.Encode())
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+		if err != nil {
+			return nil, err
+		}
+		status, body, err := send(g.client, req)
+		if err != nil {
+			return nil, err
+		}
+		if status != http.StatusOK {
+			return nil, fmt.Errorf("failed to list gs://%s/%s: unexpected status %d: %s", g.bucket, g.prefix, status, bytes.TrimSpace(body))
+		}
+		var page struct {
+			Items []struct {
+				Name       string `json:"name"`
+				Size       int64  `json:"size,string"`
+				Generation string `json:"generation"`
+			} `json:"items"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		if err := json.Unmarshal(body, &page); err != nil {
+			return nil, fmt.Errorf("failed to parse listing of gs://%s/%s: %w", g.bucket, g.prefix, err)
+		}
+		for _, item := range page.Items {
+			if path, ok := relativePath(g.prefix, item.Name); ok {
+				files = append(files, File{Path: path, Key: item.Name, Size: item.Size, Version: item.Generation, Ranged: true})
+			}
+		}
+		if page.NextPageToken == "" {
+			return files, nil
+		}
+		query.Set("pageToken", page.NextPageToken)
+	}
+}
+
+// ReadRange implements Source
+func (g *GCSSource) ReadRange(ctx context.Context, file File, offset, length int64) (io.ReadCloser, error) {
+	query := url.Values{"alt": {"media"}}
+	if file.Version != "" {
+		query.Set("generation", file.Version)
+	}
+	endpoint := fmt.Sprintf("%s/storage/v1/b/%s/o/%s?%s", g.endpoint, url.PathEscape(g.bucket), url.PathEscape(file.Key), query.Encode())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	setRange(req, offset, length)
+	return openRange(g.client, req, offset, length)
+}
@@ -0,0 +1,168 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// DefaultHuggingFaceEndpoint is the HuggingFace Hub, overridden by the
+// HF_ENDPOINT environment variable for mirrors
+const DefaultHuggingFaceEndpoint = "https://huggingface.co"
+
+// DefaultHuggingFacePatterns select the safetensors weights of hf:// models
+// and the configuration and tokenizer files needed to serve them, leaving
+// out pickled PyTorch checkpoints, other formats and remote code
+var DefaultHuggingFacePatterns = []string{"*.safetensors", "*.json", "tokenizer.model", "*.tiktoken", "merges.txt", "vocab.txt"}
+
+// HuggingFaceSource reads weights from a model repository of the
+// HuggingFace Hub, pinned to the commit its revision resolves to when
+// listed
+type HuggingFaceSource struct {
+	repo     string
+	revision string
+	token    string
+	client   *http.Client
+
+	// sha is the commit the revision resolved to
+	sha string
+
+	// endpoint is the URL of the Hub, overridden by tests
+	endpoint string
+}
+
+// NewHuggingFaceSource creates a source reading the revision of repo, e.g.
+// meta-llama/Meta-Llama-3-8B at main, authenticated with token if not
+// empty as gated and private repositories require
+func NewHuggingFaceSource(repo, revision, token string) *HuggingFaceSource {
+	endpoint := os.Getenv("HF_ENDPOINT")
+	if endpoint == "" {
+		endpoint = DefaultHuggingFaceEndpoint
+	}
+	if revision == "" {
+		revision = "main"
+	}
+	return &HuggingFaceSource{
+		repo:     repo,
+		revision: revision,
+		token:    token,
+		client:   &http.Client{CheckRedirect: dropTokenOnRedirect},
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+	}
+}
+
+// dropTokenOnRedirect removes the token from redirects to other hosts,
+// such as the CDN serving LFS files from signed URLs. The HTTP client
+// would otherwise keep it for subdomains of the Hub.
+func dropTokenOnRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+	if req.URL.Host != via[0].URL.Host {
+		req.Header.Del("Authorization")
+	}
+	return nil
+}
+
+// parseHuggingFaceURI returns the repository and revision of an
+// hf://org/model@revision URI. It is parsed by hand since URL parsing
+// takes the model of hf://model@revision for user information.
+func parseHuggingFaceURI(uri string) (string, string, error) {
+	repo, revision, _ := strings.Cut(strings.Trim(strings.TrimPrefix(uri, "hf://"), "/"), "@")
+	if repo == "" || strings.Contains(repo, "//") || strings.Count(repo, "/") > 1 {
+		return "", "", fmt.Errorf("invalid HuggingFace repository %q: want hf://org/model@revision", repo)
+	}
+	return repo, revision, nil
+}
+
+// List implements Source
+func (h *HuggingFaceSource) List(ctx context.Context) ([]File, error) {
+	endpoint := fmt.Sprintf("%s/api/models/%s/revision/%s?blobs=true", h.endpoint, h.repo, url.PathEscape(h.revision))
+	req, err := h.newRequest(ctx, endpoint)
+	if err != nil {
+		return nil, err
+	}
+	status, body, err := send(h.client, req)
+	if err != nil {
+		return nil, err
+	}
+	switch status {
+	case http.StatusOK:
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return nil, fmt.Errorf("access to hf://%s denied with status %d; gated and private models need a token with access", h.repo, status)
+	default:
+		return nil, fmt.Errorf("failed to resolve hf://%s@%s: unexpected status %d: %s", h.repo, h.revision, status, bytes.TrimSpace(body))
+	}
+
+	var info struct {
+		SHA      string `json:"sha"`
+		Siblings []struct {
+			Filename string `json:"rfilename"`
+			Size     int64  `json:"size"`
+			BlobID   string `json:"blobId"`
+			LFS      *struct {
+				SHA256 string `json:"sha256"`
+			} `json:"lfs"`
+		} `json:"siblings"`
+	}
+	if err := json.Unmarshal(body, &info); err != nil {
+		return nil, fmt.Errorf("failed to parse hf://%s@%s: %w", h.repo, h.revision, err)
+	}
+	if info.SHA == "" {
+		return nil, fmt.Errorf("hf://%s@%s resolved to no commit", h.repo, h.revision)
+	}
+	h.sha = info.SHA
+
+	files := make([]File, 0, len(info.Siblings))
+	for _, sibling := range info.Siblings {
+		version := sibling.BlobID
+		if sibling.LFS != nil {
+			version = sibling.LFS.SHA256
+		}
+		files = append(files, File{Path: sibling.Filename, Key: sibling.Filename, Size: sibling.Size, Version: version, Ranged: true})
+	}
+	return files, nil
+}
+
+// Revision returns the commit SHA the revision resolved to when listed
+func (h *HuggingFaceSource) Revision() string {
+	return h.sha
+}
+
+// ReadRange implements Source
+func (h *HuggingFaceSource) ReadRange(ctx context.Context, file File, offset, length int64) (io.ReadCloser, error) {
+	if h.sha == "" {
+		return nil, fmt.Errorf("hf://%s@%s is not resolved", h.repo, h.revision)
+	}
+	segments := strings.Split(file.Key, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	// Files are read at the resolved commit, so that a revision moving
+	// during the download does not mix two versions of the model
+	req, err := h.newRequest(ctx, fmt.Sprintf("%s/%s/resolve/%s/%s", h.endpoint, h.repo, h.sha, strings.Join(segments, "/")))
+	if err != nil {
+		return nil, err
+	}
+	setRange(req, offset, length)
+	return openRange(h.client, req, offset, length)
+}
+
+// newRequest returns a GET request for endpoint with the source's token
+func (h *HuggingFaceSource) newRequest(ctx context.Context, endpoint string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	if h.token != "" {
+		req.Header.Set("Authorization", "Bearer "+h.token)
+	}
+	return req, nil
+}
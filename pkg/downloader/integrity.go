@@ -0,0 +1,163 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ErrIntegrity is wrapped by the errors of weights failing verification
+var ErrIntegrity = errors.New("integrity check failed")
+
+// Integrity pins the content of the weights of a download
+type Integrity struct {
+	// SHA256 maps the path of each file to its hex-encoded SHA-256 digest.
+	// Every downloaded file must have one.
+	SHA256 map[string]string
+
+	// PublicKey is the PEM-encoded public key of a cosign key pair. If set,
+	// Signature must sign the digests.
+	PublicKey []byte
+
+	// Signature is the base64-encoded signature of Checksums, as cosign
+	// sign-blob prints it
+	Signature string
+}
+
+// Checksums returns the digests of the files as sha256sum prints them,
+// one "<digest>  <path>" line per file sorted by path. This is the content
+// signed by the signature.
+func (i *Integrity) Checksums() []byte {
+	paths := make([]string, 0, len(i.SHA256))
+	for path := range i.SHA256 {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	var b bytes.Buffer
+	for _, path := range paths {
+		fmt.Fprintf(&b, "%s  %s\n", strings.ToLower(i.SHA256[path]), path)
+	}
+	return b.Bytes()
+}
+
+// VerifySignature verifies that the signature signs the digests with the
+// public key. It succeeds without a public key.
+func (i *Integrity) VerifySignature() error {
+	if len(i.PublicKey) == 0 {
+		return nil
+	}
+	if len(i.SHA256) == 0 {
+		return fmt.Errorf("%w: a signature needs the digests it signs", ErrIntegrity)
+	}
+	block, _ := pem.Decode(i.PublicKey)
+	if block == nil {
+		return fmt.Errorf("%w: public key is not PEM-encoded", ErrIntegrity)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("%w: invalid public key: %v", ErrIntegrity, err)
+	}
+	signature, err := base64.StdEncoding.DecodeString(strings.TrimSpace(i.Signature))
+	if err != nil {
+		return fmt.Errorf("%w: signature is not base64-encoded: %v", ErrIntegrity, err)
+	}
+
+	message := i.Checksums()
+	digest := sha256.Sum256(message)
+	var valid bool
+	switch key := key.(type) {
+	case *ecdsa.PublicKey:
+		valid = ecdsa.VerifyASN1(key, digest[:], signature)
+	case *rsa.PublicKey:
+		valid = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) == nil
+	case ed25519.PublicKey:
+		valid = ed25519.Verify(key, message, signature)
+	default:
+		return fmt.Errorf("%w: unsupported public key type %T", ErrIntegrity, key)
+	}
+	if !valid {
+		return fmt.Errorf("%w: signature does not match the digests", ErrIntegrity)
+	}
+	return nil
+}
+
+// VerifyFiles verifies that the files at paths in dir, the files of a
+// download, are exactly the files with digests and match them. Files not
+// matching their digest are removed, so that they are downloaded again
+// rather than loaded.
+func (i *Integrity) VerifyFiles(ctx context.Context, dir string, paths []string) error {
+	var problems []string
+	seen := make(map[string]bool, len(paths))
+	for _, path := range paths {
+		seen[path] = true
+		want, ok := i.SHA256[path]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("%s has no digest", path))
+			continue
+		}
+		name := filepath.Join(dir, filepath.FromSlash(path))
+		got, err := fileSHA256(ctx, name)
+		if err != nil {
+			return err
+		}
+		if !strings.EqualFold(got, want) {
+			problems = append(problems, fmt.Sprintf("%s has digest %s, want %s", path, got, want))
+			if err := os.Remove(name); err != nil {
+				return err
+			}
+		}
+	}
+	for path := range i.SHA256 {
+		if !seen[path] {
+			problems = append(problems, fmt.Sprintf("%s is missing", path))
+		}
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("%w: %s", ErrIntegrity, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// fileSHA256 returns the hex-encoded SHA-256 digest of the file name
+func fileSHA256(ctx context.Context, name string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, contextReader{ctx: ctx, r: f}); err != nil {
+		return "", fmt.Errorf("failed to hash %s: %w", name, err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// contextReader stops reading once its context is done, so that hashing
+// large files can be canceled
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
@@ -0,0 +1,354 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+const (
+	// ociTitleAnnotation names the file of a layer, as oras push sets it
+	ociTitleAnnotation = "org.opencontainers.image.title"
+
+	// ociManifestTypes are the manifests accepted for artifacts
+	ociManifestTypes = "application/vnd.oci.image.manifest.v1+json, application/vnd.docker.distribution.manifest.v2+json"
+)
+
+// OCISource reads weights packaged as an OCI artifact, such as one pushed
+// with oras push, from a registry. Each layer annotated with a title is a
+// file of the weights.
+type OCISource struct {
+	registry   string
+	repository string
+	reference  string
+	client     *http.Client
+
+	// digest is the digest of the manifest the reference resolved to
+	digest string
+
+	mu sync.Mutex
+	// username and password are the credentials of the registry, and
+	// authorization the header authenticating requests with them
+	username      string
+	password      string
+	authorization string
+
+	// endpoint is the URL of the registry, overridden by tests
+	endpoint string
+}
+
+// NewOCISource creates the source of the artifact at ref, e.g.
+// ghcr.io/acme/models/llama3:70b-int4 or a digest reference, with the
+// credentials of the first Docker config in dockerConfigs with an entry for
+// its registry. Docker configs are the contents of image pull secrets.
+func NewOCISource(ref string, dockerConfigs [][]byte) (*OCISource, error) {
+	registry, repository, reference, err := parseOCIReference(ref)
+	if err != nil {
+		return nil, err
+	}
+	s := &OCISource{
+		registry:   registry,
+		repository: repository,
+		reference:  reference,
+		client:     &http.Client{CheckRedirect: dropTokenOnRedirect},
+		endpoint:   "https://" + registry,
+	}
+	if registry == "docker.io" {
+		s.endpoint = "https://registry-1.docker.io"
+	}
+	for _, config := range dockerConfigs {
+		username, password, ok, err := dockerCredentials(config, registry)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			s.username, s.password = username, password
+			break
+		}
+	}
+	return s, nil
+}
+
+// parseOCIReference returns the registry, repository and tag or digest of
+// an artifact reference. The tag defaults to latest.
+func parseOCIReference(ref string) (string, string, string, error) {
+	ref = strings.TrimPrefix(ref, "oci://")
+	registry, name, ok := strings.Cut(ref, "/")
+	if !ok || registry == "" || name == "" {
+		return "", "", "", fmt.Errorf("invalid OCI reference %q: want oci://registry/repository:tag", ref)
+	}
+	repository, reference := name, "latest"
+	if i := strings.Index(name, "@"); i >= 0 {
+		repository, reference = name[:i], name[i+1:]
+	} else if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		repository, reference = name[:i], name[i+1:]
+	}
+	if repository == "" || reference == "" || repository != strings.ToLower(repository) {
+		return "", "", "", fmt.Errorf("invalid OCI reference %q", ref)
+	}
+	if registry == "docker.io" && !strings.Contains(repository, "/") {
+		repository = "library/" + repository
+	}
+	return registry, repository, reference, nil
+}
+
+// dockerCredentials returns the credentials of registry in a Docker config,
+// either a .dockerconfigjson with auths or a legacy .dockercfg
+func dockerCredentials(config []byte, registry string) (string, string, bool, error) {
+	var parsed struct {
+		Auths map[string]dockerAuth `json:"auths"`
+	}
+	if err := json.Unmarshal(config, &parsed); err != nil {
+		return "", "", false, fmt.Errorf("invalid docker config: %w", err)
+	}
+	auths := parsed.Auths
+	if auths == nil {
+		// Legacy configs are the map of auths itself
+		if err := json.Unmarshal(config, &auths); err != nil {
+			return "", "", false, fmt.Errorf("invalid docker config: %w", err)
+		}
+	}
+
+	for server, auth := range auths {
+		if dockerServer(server) != registry && !(registry == "docker.io" && dockerServer(server) == "index.docker.io") {
+			continue
+		}
+		username, password := auth.Username, auth.Password
+		if auth.Auth != "" {
+			decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+			if err != nil {
+				return "", "", false, fmt.Errorf("invalid auth of %s in docker config: %w", server, err)
+			}
+			username, password, _ = strings.Cut(string(decoded), ":")
+		}
+		if auth.IdentityToken != "" {
+			// Identity tokens are refresh tokens exchanged for access tokens
+			username, password = "<token>", auth.IdentityToken
+		}
+		return username, password, true, nil
+	}
+	return "", "", false, nil
+}
+
+// dockerAuth is the entry of a registry in a Docker config
+type dockerAuth struct {
+	Username      string `json:"username"`
+	Password      string `json:"password"`
+	Auth          string `json:"auth"`
+	IdentityToken string `json:"identitytoken"`
+}
+
+// dockerServer returns the host of a server of a Docker config, which may
+// be a URL such as https://index.docker.io/v1/
+func dockerServer(server string) string {
+	server = strings.TrimPrefix(strings.TrimPrefix(server, "https://"), "http://")
+	host, _, _ := strings.Cut(server, "/")
+	return host
+}
+
+// ociManifest is an image manifest
+type ociManifest struct {
+	MediaType string `json:"mediaType"`
+	Layers    []struct {
+		Digest      string            `json:"digest"`
+		Size        int64             `json:"size"`
+		Annotations map[string]string `json:"annotations"`
+	} `json:"layers"`
+}
+
+// List implements Source
+func (s *OCISource) List(ctx context.Context) ([]File, error) {
+	resp, err := s.do(ctx, fmt.Sprintf("/v2/%s/manifests/%s", s.repository, s.reference), func(req *http.Request) {
+		req.Header.Set("Accept", ociManifestTypes)
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to get manifest of %s: unexpected status %d: %s", s, resp.StatusCode, bytes.TrimSpace(body))
+	}
+
+	hash := sha256.Sum256(body)
+	digest := "sha256:" + hex.EncodeToString(hash[:])
+	if strings.HasPrefix(s.reference, "sha256:") && s.reference != digest {
+		return nil, fmt.Errorf("manifest of %s has digest %s", s, digest)
+	}
+	s.digest = digest
+
+	var manifest ociManifest
+	if err := json.Unmarshal(body, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest of %s: %w", s, err)
+	}
+	if strings.Contains(manifest.MediaType, "index") || strings.Contains(manifest.MediaType, "manifest.list") {
+		return nil, fmt.Errorf("%s is an image index, not an artifact", s)
+	}
+	files := make([]File, 0, len(manifest.Layers))
+	for _, layer := range manifest.Layers {
+		title := layer.Annotations[ociTitleAnnotation]
+		if title == "" {
+			continue
+		}
+		files = append(files, File{Path: title, Key: layer.Digest, Size: layer.Size, Version: layer.Digest, Ranged: true})
+	}
+	return files, nil
+}
+
+// Revision returns the digest of the manifest the reference resolved to
+// when listed
+func (s *OCISource) Revision() string {
+	return s.digest
+}
+
+// ReadRange implements Source
+func (s *OCISource) ReadRange(ctx context.Context, file File, offset, length int64) (io.ReadCloser, error) {
+	resp, err := s.do(ctx, fmt.Sprintf("/v2/%s/blobs/%s", s.repository, file.Key), func(req *http.Request) {
+		setRange(req, offset, length)
+	})
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case resp.StatusCode == http.StatusPartialContent:
+		return resp.Body, nil
+	case resp.StatusCode == http.StatusOK && offset == 0:
+		return limitedBody{Reader: io.LimitReader(resp.Body, length), Closer: resp.Body}, nil
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+	return nil, fmt.Errorf("failed to read blob %s of %s: unexpected status %d: %s", file.Key, s, resp.StatusCode, bytes.TrimSpace(body))
+}
+
+// String returns the reference of the artifact
+func (s *OCISource) String() string {
+	separator := ":"
+	if strings.HasPrefix(s.reference, "sha256:") {
+		separator = "@"
+	}
+	return "oci://" + s.registry + "/" + s.repository + separator + s.reference
+}
+
+// do sends a GET request for path to the registry, authenticating and
+// retrying once when the registry challenges it, as when tokens expire
+// during long downloads
+func (s *OCISource) do(ctx context.Context, path string, header func(*http.Request)) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.endpoint+path, nil)
+		if err != nil {
+			return nil, err
+		}
+		header(req)
+		s.mu.Lock()
+		authorization := s.authorization
+		s.mu.Unlock()
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+
+		resp, err := s.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusUnauthorized || attempt > 0 {
+			return resp, nil
+		}
+		challenge := resp.Header.Get("WWW-Authenticate")
+		resp.Body.Close()
+		if err := s.authenticate(ctx, challenge, authorization); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// authenticate answers the challenge of the registry, unless another
+// request already replaced the authorization that was challenged
+func (s *OCISource) authenticate(ctx context.Context, challenge, challenged string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.authorization != challenged {
+		return nil
+	}
+
+	scheme, params := parseChallenge(challenge)
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if s.username == "" && s.password == "" {
+			return fmt.Errorf("registry %s requires credentials; add an image pull secret", s.registry)
+		}
+		s.authorization = "Basic " + base64.StdEncoding.EncodeToString([]byte(s.username+":"+s.password))
+		return nil
+	case "bearer":
+	default:
+		return fmt.Errorf("unsupported authentication challenge %q of registry %s", challenge, s.registry)
+	}
+
+	query := url.Values{"scope": {"repository:" + s.repository + ":pull"}}
+	if service := params["service"]; service != "" {
+		query.Set("service", service)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, params["realm"]+"?"+query.Encode(), nil)
+	if err != nil {
+		return fmt.Errorf("invalid token realm of registry %s: %w", s.registry, err)
+	}
+	if s.username != "" || s.password != "" {
+		req.SetBasicAuth(s.username, s.password)
+	}
+	status, body, err := send(s.client, req)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("failed to get token of registry %s: unexpected status %d: %s", s.registry, status, bytes.TrimSpace(body))
+	}
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.Unmarshal(body, &token); err != nil {
+		return fmt.Errorf("failed to parse token of registry %s: %w", s.registry, err)
+	}
+	if token.Token == "" {
+		token.Token = token.AccessToken
+	}
+	s.authorization = "Bearer " + token.Token
+	return nil
+}
+
+// parseChallenge returns the scheme and parameters of a WWW-Authenticate
+// header, e.g. Bearer realm="https://auth.docker.io/token",service="registry.docker.io"
+func parseChallenge(challenge string) (string, map[string]string) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(challenge), " ")
+	params := map[string]string{}
+	for rest != "" {
+		var param string
+		rest = strings.TrimLeft(rest, " ,")
+		key, value, ok := strings.Cut(rest, "=")
+		if !ok {
+			break
+		}
+		if strings.HasPrefix(value, `"`) {
+			end := strings.Index(value[1:], `"`)
+			if end < 0 {
+				break
+			}
+			param, rest = value[1:end+1], value[end+2:]
+		} else {
+			param, rest, _ = strings.Cut(value, ",")
+		}
+		params[strings.ToLower(strings.TrimSpace(key))] = param
+	}
+	return scheme, params
+}
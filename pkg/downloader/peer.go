@@ -0,0 +1,342 @@
+package downloader
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxPeerAttempts bounds the peers asked for a chunk before it is read
+	// from the source
+	maxPeerAttempts = 3
+
+	// peerTimeout bounds how long a peer takes to start serving a chunk
+	peerTimeout = 10 * time.Second
+
+	// peerBackoff is how long a peer that failed is not asked for chunks
+	peerBackoff = 30 * time.Second
+)
+
+// ErrNotDownloaded is wrapped by the errors of reads of ranges a download
+// has not written yet
+var ErrNotDownloaded = errors.New("range not downloaded")
+
+// PeerFunc receives the outcome of each read of a chunk, from the peer
+// with base URL peer or, if peer is empty, from the source of the weights.
+// bytes is the number of bytes read. err wraps ErrNotDownloaded if the
+// peer does not have the chunk yet.
+type PeerFunc func(peer string, bytes int64, err error)
+
+// Peers are the nodes a download reads chunks from rather than the source
+// of the weights, such as the model cache agents of other nodes caching
+// the same weights. Peers serve the files of the weights under their base
+// URL with ServeDownloaded.
+type Peers struct {
+	// URLs returns the base URLs of the peers. It is called for every
+	// chunk, so that peers starting to download the same weights are used
+	// as they appear.
+	URLs func() []string
+
+	// Token, if not empty, is sent to peers as a bearer token with each
+	// read of a chunk
+	Token string
+
+	// Observe, if not nil, receives the outcome of each read of a chunk
+	Observe PeerFunc
+}
+
+// peerSource reads chunks from peers in random order, so that concurrent
+// downloads spread over them, and from the source of the weights if none
+// of the peers asked has the chunk
+type peerSource struct {
+	Source
+	uri    string
+	peers  *Peers
+	client *http.Client
+
+	mu        sync.Mutex
+	failed    map[string]time.Time
+	peerBytes int64
+}
+
+// newPeerSource wraps source, the source of the weights at uri, to read
+// from peers
+func newPeerSource(source Source, uri string, peers *Peers) *peerSource {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// Peers are in the cluster, never behind the proxy of the source
+	transport.Proxy = nil
+	transport.ResponseHeaderTimeout = peerTimeout
+	return &peerSource{
+		Source: source,
+		uri:    uri,
+		peers:  peers,
+		client: &http.Client{Transport: transport},
+		failed: make(map[string]time.Time),
+	}
+}
+
+// ReadRange implements Source
+func (s *peerSource) ReadRange(ctx context.Context, file File, offset, length int64) (io.ReadCloser, error) {
+	var urls []string
+	if s.peers.URLs != nil {
+		urls = s.peers.URLs()
+	}
+	attempts := 0
+	for _, i := range rand.Perm(len(urls)) {
+		peer := urls[i]
+		if attempts == maxPeerAttempts {
+			break
+		}
+		if !s.available(peer) {
+			continue
+		}
+		attempts++
+		body, err := s.readPeer(ctx, peer, file, offset, length)
+		if err == nil {
+			return &observedBody{ReadCloser: body, source: s, peer: peer}, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if !errors.Is(err, ErrNotDownloaded) {
+			s.fail(peer)
+		}
+		s.observe(peer, 0, err)
+	}
+
+	body, err := s.Source.ReadRange(ctx, file, offset, length)
+	if err != nil {
+		s.observe("", 0, err)
+		return nil, err
+	}
+	return &observedBody{ReadCloser: body, source: s}, nil
+}
+
+// readPeer requests length bytes at offset of file from peer
+func (s *peerSource) readPeer(ctx context.Context, peer string, file File, offset, length int64) (io.ReadCloser, error) {
+	segments := strings.Split(file.Path, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	query := url.Values{
+		"uri":     {s.uri},
+		"size":    {strconv.FormatInt(file.Size, 10)},
+		"version": {file.Version},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		strings.TrimSuffix(peer, "/")+"/files/"+strings.Join(segments, "/")+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	setRange(req, offset, length)
+	if s.peers.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.peers.Token)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, fmt.Errorf("peer %s: %s: %w", peer, file.Path, ErrNotDownloaded)
+	default:
+		resp.Body.Close()
+		return nil, fmt.Errorf("peer %s: %s: unexpected status %d", peer, file.Path, resp.StatusCode)
+	}
+	want := fmt.Sprintf("bytes %d-%d/%d", offset, offset+length-1, file.Size)
+	if got := resp.Header.Get("Content-Range"); got != want {
+		resp.Body.Close()
+		return nil, fmt.Errorf("peer %s: %s: served range %q, want %q", peer, file.Path, got, want)
+	}
+	return resp.Body, nil
+}
+
+// available returns false while peer is backing off after a failure
+func (s *peerSource) available(peer string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	failed, ok := s.failed[peer]
+	return !ok || time.Since(failed) >= peerBackoff
+}
+
+// fail backs off from peer
+func (s *peerSource) fail(peer string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failed[peer] = time.Now()
+}
+
+// observe counts the bytes read from peers and passes a read on to the
+// observer of the peers
+func (s *peerSource) observe(peer string, bytes int64, err error) {
+	if peer != "" && err == nil {
+		s.mu.Lock()
+		s.peerBytes += bytes
+		s.mu.Unlock()
+	}
+	if s.peers.Observe != nil {
+		s.peers.Observe(peer, bytes, err)
+	}
+}
+
+// fromPeers returns the bytes read from peers
+func (s *peerSource) fromPeers() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.peerBytes
+}
+
+// observedBody reports the bytes read from a chunk once it is closed
+type observedBody struct {
+	io.ReadCloser
+	source *peerSource
+	peer   string
+	read   int64
+	err    error
+}
+
+func (b *observedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.read += int64(n)
+	if err != nil && err != io.EOF && b.err == nil {
+		b.err = err
+	}
+	return n, err
+}
+
+func (b *observedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.source.observe(b.peer, b.read, b.err)
+	return err
+}
+
+// ReadDownloaded returns length bytes at offset of file from its download
+// at path, which is either complete or partial with the chunks covering
+// the range written. It fails with ErrNotDownloaded otherwise, or if the
+// partial file is of another version of file. Complete files only need to
+// have the size of file, as their version is not recorded.
+func ReadDownloaded(path string, file File, offset, length int64) (io.ReadCloser, error) {
+	if offset < 0 || length < 0 || offset+length > file.Size {
+		return nil, fmt.Errorf("range %d+%d is outside %s of %d bytes", offset, length, file.Path, file.Size)
+	}
+	if f, err := os.Open(path); err == nil {
+		info, err := f.Stat()
+		if err != nil || !info.Mode().IsRegular() || info.Size() != file.Size {
+			f.Close()
+			return nil, fmt.Errorf("%s: %w", file.Path, ErrNotDownloaded)
+		}
+		return limitedBody{Reader: io.NewSectionReader(f, offset, length), Closer: f}, nil
+	}
+
+	data, err := os.ReadFile(path + partialSuffix + stateSuffix)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%s: %w", file.Path, ErrNotDownloaded)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var state chunkState
+	if err := json.Unmarshal(data, &state); err != nil || state.Size != file.Size ||
+		state.Version != file.Version || state.ChunkSize <= 0 {
+		return nil, fmt.Errorf("%s: %w", file.Path, ErrNotDownloaded)
+	}
+	done := make(map[int64]bool, len(state.Done))
+	for _, index := range state.Done {
+		done[int64(index)] = true
+	}
+	for index := offset / state.ChunkSize; index*state.ChunkSize < offset+length; index++ {
+		if !done[index] {
+			return nil, fmt.Errorf("%s: %w", file.Path, ErrNotDownloaded)
+		}
+	}
+
+	// The partial file is renamed once complete, which the open file
+	// outlives
+	f, err := os.Open(path + partialSuffix)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%s: %w", file.Path, ErrNotDownloaded)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return limitedBody{Reader: io.NewSectionReader(f, offset, length), Closer: f}, nil
+}
+
+// ServeDownloaded serves the read of a chunk of the file at path by a peer
+// from the download of the weights at uri into dir, and returns the bytes
+// served. It responds 404 Not Found if the chunk is not downloaded, or if
+// the peer downloads other weights.
+func ServeDownloaded(w http.ResponseWriter, r *http.Request, path, uri, dir string) int64 {
+	query := r.URL.Query()
+	size, err := strconv.ParseInt(query.Get("size"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid size", http.StatusBadRequest)
+		return 0
+	}
+	offset, length, ok := parseRange(r.Header.Get("Range"), size)
+	if !ok {
+		http.Error(w, "invalid range", http.StatusRequestedRangeNotSatisfiable)
+		return 0
+	}
+	if query.Get("uri") != uri || !filepath.IsLocal(filepath.FromSlash(path)) ||
+		strings.HasSuffix(path, partialSuffix) || strings.HasSuffix(path, stateSuffix) {
+		http.NotFound(w, r)
+		return 0
+	}
+
+	file := File{Path: path, Size: size, Version: query.Get("version")}
+	body, err := ReadDownloaded(filepath.Join(dir, filepath.FromSlash(path)), file, offset, length)
+	if errors.Is(err, ErrNotDownloaded) {
+		http.NotFound(w, r)
+		return 0
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return 0
+	}
+	defer body.Close()
+
+	w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+length-1, size))
+	w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.WriteHeader(http.StatusPartialContent)
+	n, _ := io.Copy(w, body)
+	return n
+}
+
+// parseRange returns the range of a Range header of a single range within
+// size bytes, as peers request chunks
+func parseRange(header string, size int64) (offset, length int64, ok bool) {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok {
+		return 0, 0, false
+	}
+	first, last, ok := strings.Cut(spec, "-")
+	if !ok {
+		return 0, 0, false
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	end, err := strconv.ParseInt(last, 10, 64)
+	if err != nil || start < 0 || end < start || end >= size {
+		return 0, 0, false
+	}
+	return start, end - start + 1, true
+}
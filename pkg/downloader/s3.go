@@ -0,0 +1,154 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/config"
+)
+
+// emptyPayloadHash is the SHA-256 of an empty body, signed for GET requests
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// S3Source reads weights from an S3 bucket. It needs the s3:GetObject and
+// s3:ListBucket permissions on the prefix.
+type S3Source struct {
+	bucket string
+	prefix string
+	region string
+
+	credentials aws.CredentialsProvider
+	client      *http.Client
+	signer      *v4.Signer
+	now         func() time.Time
+
+	// endpoint is the URL of the bucket, overridden by tests
+	endpoint string
+}
+
+// NewS3Source creates a source reading under prefix in bucket with the
+// default credentials of the AWS SDK. region defaults to the region of the
+// SDK configuration.
+func NewS3Source(ctx context.Context, bucket, prefix, region string) (*S3Source, error) {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS credentials: %w", err)
+	}
+	if region == "" {
+		region = cfg.Region
+	}
+	if region == "" {
+		return nil, fmt.Errorf("no AWS region configured for bucket %s", bucket)
+	}
+	return newS3Source(cfg.Credentials, bucket, prefix, region), nil
+}
+
+func newS3Source(credentials aws.CredentialsProvider, bucket, prefix, region string) *S3Source {
+	return &S3Source{
+		bucket:      bucket,
+		prefix:      prefix,
+		region:      region,
+		credentials: credentials,
+		client:      &http.Client{},
+		// S3 signs paths as they are sent
+		signer:   v4.NewSigner(func(o *v4.SignerOptions) { o.DisableURIPathEscaping = true }),
+		now:      time.Now,
+		endpoint: "https://" + bucket + ".s3." + region + ".amazonaws.com",
+	}
+}
+
+// s3ListResult is the response of ListObjectsV2
+type s3ListResult struct {
+	Contents []struct {
+		Key  string `xml:"Key"`
+		Size int64  `xml:"Size"`
+		ETag string `xml:"ETag"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// List implements Source
+func (s *S3Source) List(ctx context.Context) ([]File, error) {
+	var files []File
+	query := url.Values{"list-type": {"2"}, "prefix": {s.prefix}}
+	for {
+		req, err := s.newRequest(ctx, "", query)
+		if err != nil {
+			return nil, err
+		}
+		status, body, err := send(s.client, req)
+		if err != nil {
+			return nil, err
+		}
+		if status != http.StatusOK {
+			return nil, fmt.Errorf("failed to list s3://%s/%s: unexpected status %d: %s", s.bucket, s.prefix, status, bytes.TrimSpace(body))
+		}
+		var result s3ListResult
+		if err := xml.Unmarshal(body, &result); err != nil {
+			return nil, fmt.Errorf("failed to parse listing of s3://%s/%s: %w", s.bucket, s.prefix, err)
+		}
+		for _, content := range result.Contents {
+			if path, ok := relativePath(s.prefix, content.Key); ok {
+				files = append(files, File{Path: path, Key: content.Key, Size: content.Size, Version: content.ETag, Ranged: true})
+			}
+		}
+		if !result.IsTruncated {
+			return files, nil
+		}
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+}
+
+// ReadRange implements Source
+func (s *S3Source) ReadRange(ctx context.Context, file File, offset, length int64) (io.ReadCloser, error) {
+	req, err := s.newRequest(ctx, file.Key, nil, func(req *http.Request) {
+		setRange(req, offset, length)
+		if file.Version != "" {
+			req.Header.Set("If-Match", file.Version)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return openRange(s.client, req, offset, length)
+}
+
+// newRequest returns a GET request for key, or the bucket if key is empty,
+// signed with the source's credentials after applying headers
+func (s *S3Source) newRequest(ctx context.Context, key string, query url.Values, headers ...func(*http.Request)) (*http.Request, error) {
+	credentials, err := s.credentials.Retrieve(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve AWS credentials: %w", err)
+	}
+	endpoint, err := url.JoinPath(s.endpoint, key)
+	if err != nil {
+		return nil, err
+	}
+	if key == "" {
+		endpoint += "/"
+	}
+	if query != nil {
+		endpoint += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, header := range headers {
+		header(req)
+	}
+	req.Header.Set("X-Amz-Content-Sha256", emptyPayloadHash)
+	if err := s.signer.SignHTTP(ctx, credentials, req, emptyPayloadHash, "s3", s.region, s.now()); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
@@ -0,0 +1,262 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// File is a file of the weights of a model
+type File struct {
+	// Path is the path of the file relative to the weights URI, with
+	// slashes as separators
+	Path string
+
+	// Key locates the file in its source, such as its object key
+	Key string
+
+	// Size is the size of the file in bytes
+	Size int64
+
+	// Version identifies the content of the file, such as an ETag, so that
+	// partial downloads of a file that changed are not resumed. Empty if
+	// the source has none.
+	Version string
+
+	// Ranged is true if the source can read parts of the file, so that it
+	// can be downloaded in parallel chunks
+	Ranged bool
+}
+
+// Source reads the files of the weights of a model
+type Source interface {
+	// List returns the files under the weights URI
+	List(ctx context.Context) ([]File, error)
+
+	// ReadRange returns length bytes of file starting at offset
+	ReadRange(ctx context.Context, file File, offset, length int64) (io.ReadCloser, error)
+}
+
+// Revisioned is implemented by sources resolving their URI to an immutable
+// revision, such as a commit, when listed
+type Revisioned interface {
+	// Revision returns the revision the URI resolved to
+	Revision() string
+}
+
+// SourceOptions configures the source of a weights URI
+type SourceOptions struct {
+	// Token authenticates to sources taking bearer tokens, such as the
+	// HuggingFace Hub and HTTPS servers
+	Token string
+
+	// DockerConfigs are Docker configs, as stored in image pull secrets,
+	// holding the credentials of registries for oci:// URIs. The first
+	// with an entry for the registry of a URI is used.
+	DockerConfigs [][]byte
+}
+
+// NewSource creates the source of uri with the default credentials of its
+// cloud, or the credentials of opts:
+//
+//   - s3://bucket/prefix, with the credentials of the AWS SDK. The region
+//     can be set with a region query parameter.
+//   - gs://bucket/prefix, with the application default credentials.
+//   - az://account/container/prefix, with the SAS token in
+//     AZURE_STORAGE_SAS_TOKEN, workload identity or the managed identity
+//     of the node.
+//   - hf://org/model@revision, a HuggingFace Hub repository at a branch,
+//     tag or commit, defaulting to main, with the token of opts.
+//   - oci://registry/repository:tag or @digest, an artifact whose layers
+//     are titled files, with the credentials of the Docker configs of opts.
+//   - https:// or http:// URLs of a single file, with the token of opts.
+//
+// A prefix names either a single object or a directory of objects.
+func NewSource(ctx context.Context, uri string, opts SourceOptions) (Source, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("invalid weights URI %q: %w", uri, err)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("weights URI %q has no host", uri)
+	}
+	prefix := strings.Trim(u.Path, "/")
+	switch u.Scheme {
+	case "s3":
+		return NewS3Source(ctx, u.Host, prefix, u.Query().Get("region"))
+	case "gs":
+		return NewGCSSource(ctx, u.Host, prefix)
+	case "az":
+		container, prefix, _ := strings.Cut(prefix, "/")
+		if container == "" {
+			return nil, fmt.Errorf("weights URI %q has no container", uri)
+		}
+		return NewAzureSource(ctx, u.Host, container, prefix)
+	case "hf":
+		repo, revision, err := parseHuggingFaceURI(uri)
+		if err != nil {
+			return nil, err
+		}
+		return NewHuggingFaceSource(repo, revision, opts.Token), nil
+	case "oci":
+		return NewOCISource(uri, opts.DockerConfigs)
+	case "http", "https":
+		return NewHTTPSource(u.String(), opts.Token), nil
+	default:
+		return nil, fmt.Errorf("unsupported weights URI %q: want s3://, gs://, az://, hf://, oci:// or https://", uri)
+	}
+}
+
+// Supported returns true if NewSource can open uri
+func Supported(uri string) bool {
+	u, err := url.Parse(uri)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	switch u.Scheme {
+	case "s3", "gs", "az", "hf", "oci", "http", "https":
+		return true
+	}
+	return false
+}
+
+// relativePath returns the path of the object key under prefix, which is
+// either the object itself or a directory of objects. ok is false for keys
+// outside prefix and directory markers.
+func relativePath(prefix, key string) (string, bool) {
+	switch {
+	case strings.HasSuffix(key, "/"):
+		return "", false
+	case prefix == "":
+		return key, true
+	case key == prefix:
+		return path.Base(key), true
+	case strings.HasPrefix(key, prefix+"/"):
+		return strings.TrimPrefix(key, prefix+"/"), true
+	}
+	return "", false
+}
+
+// setRange sets the header of req requesting length bytes at offset
+func setRange(req *http.Request, offset, length int64) {
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+}
+
+// openRange sends req, which requests length bytes at offset, and returns
+// the response body. Servers ignoring the range are accepted for reads
+// from the start of the file.
+func openRange(client *http.Client, req *http.Request, offset, length int64) (io.ReadCloser, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case resp.StatusCode == http.StatusPartialContent:
+		return resp.Body, nil
+	case resp.StatusCode == http.StatusOK && offset == 0:
+		return limitedBody{Reader: io.LimitReader(resp.Body, length), Closer: resp.Body}, nil
+	case resp.StatusCode == http.StatusOK:
+		resp.Body.Close()
+		return nil, fmt.Errorf("failed to read %s: server ignored the range request", req.URL.Redacted())
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+	return nil, fmt.Errorf("failed to read %s: unexpected status %d: %s", req.URL.Redacted(), resp.StatusCode, bytes.TrimSpace(body))
+}
+
+// limitedBody closes the body of a response read through a limit
+type limitedBody struct {
+	io.Reader
+	io.Closer
+}
+
+// send sends req and returns the response status and body
+func send(client *http.Client, req *http.Request) (int, []byte, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, err
+	}
+	return resp.StatusCode, data, nil
+}
+
+// HTTPSource reads a single file from an HTTP server
+type HTTPSource struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+// NewHTTPSource creates the source of the file at url, sending token as a
+// bearer token if not empty
+func NewHTTPSource(url, token string) *HTTPSource {
+	// Downloads of large files take long, so they are bounded by their
+	// context rather than a client timeout
+	return &HTTPSource{url: url, token: token, client: &http.Client{}}
+}
+
+// newRequest returns a request for the file with the source's token
+func (s *HTTPSource) newRequest(ctx context.Context, method string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, s.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+	return req, nil
+}
+
+// List implements Source
+func (s *HTTPSource) List(ctx context.Context) ([]File, error) {
+	req, err := s.newRequest(ctx, http.MethodHead)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to stat %s: unexpected status %d", req.URL.Redacted(), resp.StatusCode)
+	}
+	if resp.ContentLength < 0 {
+		return nil, fmt.Errorf("failed to stat %s: unknown size", req.URL.Redacted())
+	}
+
+	name := path.Base(req.URL.Path)
+	if name == "/" || name == "." {
+		name = "model"
+	}
+	return []File{{
+		Path:    name,
+		Key:     s.url,
+		Size:    resp.ContentLength,
+		Version: resp.Header.Get("ETag"),
+		Ranged:  resp.Header.Get("Accept-Ranges") == "bytes",
+	}}, nil
+}
+
+// ReadRange implements Source
+func (s *HTTPSource) ReadRange(ctx context.Context, file File, offset, length int64) (io.ReadCloser, error) {
+	req, err := s.newRequest(ctx, http.MethodGet)
+	if err != nil {
+		return nil, err
+	}
+	setRange(req, offset, length)
+	if file.Version != "" {
+		// Fail rather than mix the parts of two versions of the file
+		req.Header.Set("If-Range", file.Version)
+	}
+	return openRange(s.client, req, offset, length)
+}
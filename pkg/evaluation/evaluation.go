@@ -0,0 +1,123 @@
+// Package evaluation scores the quality of retrieval-augmented turns after
+// the fact: the relevance of the documents retrieved and the grounding of
+// the answer in them. A Worker evaluates a sample of turns off the request
+// path and feeds the RAG quality gauges of AgentMetrics.
+package evaluation
+
+import (
+	"context"
+)
+
+// Document is a document retrieved for a turn
+type Document struct {
+	// ID identifies the document in its index
+	ID string `json:"id"`
+
+	// Content is the text of the document given to the model
+	Content string `json:"content,omitempty"`
+}
+
+// Citation is a reference of an answer to a retrieved document
+type Citation struct {
+	// DocumentID is the ID of the cited document
+	DocumentID string `json:"documentID"`
+
+	// Quote is the text of the document the citation relies on, if the
+	// answer quotes it
+	Quote string `json:"quote,omitempty"`
+}
+
+// Turn is a retrieval-augmented turn of an agent
+type Turn struct {
+	// ID identifies the turn. Turns are sampled by ID, so that replicas
+	// sampling the same turn agree.
+	ID string `json:"id"`
+
+	// Model served the turn
+	Model string `json:"model,omitempty"`
+
+	// Query is the query documents were retrieved for
+	Query string `json:"query"`
+
+	// Retrieved are the documents retrieved, best ranked first
+	Retrieved []Document `json:"retrieved"`
+
+	// Relevant are the IDs of the documents relevant to the query, when
+	// known, e.g. from labeled evaluation traffic
+	Relevant []string `json:"relevant,omitempty"`
+
+	// Answer is the answer of the model
+	Answer string `json:"answer"`
+
+	// Citations are the references of the answer to documents
+	Citations []Citation `json:"citations,omitempty"`
+}
+
+// RetrievalResult is the judged relevance of the documents of a turn
+type RetrievalResult struct {
+	// Hit is set if a relevant document was within the top k retrieved
+	Hit bool
+
+	// ReciprocalRank is 1/rank of the first relevant document retrieved,
+	// 0 if none was
+	ReciprocalRank float64
+}
+
+// GroundingResult is the check of the answer of a turn against its
+// documents
+type GroundingResult struct {
+	// Hallucinated is set if spans of the answer are supported by no
+	// document
+	Hallucinated bool
+
+	// Citations is the number of citations checked, of which
+	// ValidCitations refer to a retrieved document that supports them
+	Citations      int
+	ValidCitations int
+}
+
+// Result is the evaluation of a turn. Parts an evaluator did not judge are
+// nil.
+type Result struct {
+	Retrieval *RetrievalResult
+	Grounding *GroundingResult
+}
+
+// Evaluator scores turns, e.g. with heuristics or an LLM judge
+type Evaluator interface {
+	Evaluate(ctx context.Context, turn Turn) (Result, error)
+}
+
+// EvaluatorFunc adapts a function to Evaluator
+type EvaluatorFunc func(ctx context.Context, turn Turn) (Result, error)
+
+// Evaluate implements Evaluator
+func (f EvaluatorFunc) Evaluate(ctx context.Context, turn Turn) (Result, error) {
+	return f(ctx, turn)
+}
+
+// Chain returns an evaluator running each of evaluators in turn; the parts
+// of the result an evaluator judges replace those of the evaluators before
+// it, so the most trusted evaluator goes last
+func Chain(evaluators ...Evaluator) Evaluator {
+	return chain(evaluators)
+}
+
+type chain []Evaluator
+
+func (c chain) Evaluate(ctx context.Context, turn Turn) (Result, error) {
+	var result Result
+	for _, evaluator := range c {
+		r, err := evaluator.Evaluate(ctx, turn)
+		if err != nil {
+			return Result{}, err
+		}
+		if r.Retrieval != nil {
+			result.Retrieval = r.Retrieval
+		}
+		if r.Grounding != nil {
+			result.Grounding = r.Grounding
+		}
+	}
+	return result, nil
+}
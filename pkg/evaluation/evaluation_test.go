@@ -0,0 +1,143 @@
+package evaluation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bowenislandsong/neuronetes/pkg/metrics"
+)
+
+var refundTurn = Turn{
+	ID:    "turn-1",
+	Query: "What is the refund window?",
+	Retrieved: []Document{
+		{ID: "shipping", Content: "Orders ship within two business days."},
+		{ID: "refunds", Content: "Customers may request a refund within thirty days of delivery."},
+	},
+	Relevant:  []string{"refunds"},
+	Answer:    "Customers can request a refund within thirty days of delivery.",
+	Citations: []Citation{{DocumentID: "refunds", Quote: "within thirty  days"}},
+}
+
+func TestRetrievalEvaluator(t *testing.T) {
+	result, err := RetrievalEvaluator{}.Evaluate(context.Background(), refundTurn)
+	require.NoError(t, err)
+	assert.Equal(t, &RetrievalResult{Hit: true, ReciprocalRank: 0.5}, result.Retrieval)
+
+	result, err = RetrievalEvaluator{K: 1}.Evaluate(context.Background(), refundTurn)
+	require.NoError(t, err)
+	assert.Equal(t, &RetrievalResult{Hit: false, ReciprocalRank: 0.5}, result.Retrieval)
+
+	turn := refundTurn
+	turn.Relevant = []string{"warranty"}
+	result, _ = RetrievalEvaluator{}.Evaluate(context.Background(), turn)
+	assert.Equal(t, &RetrievalResult{}, result.Retrieval)
+
+	turn.Relevant = nil
+	result, _ = RetrievalEvaluator{}.Evaluate(context.Background(), turn)
+	assert.Nil(t, result.Retrieval)
+}
+
+func TestGroundingEvaluator(t *testing.T) {
+	result, err := GroundingEvaluator{}.Evaluate(context.Background(), refundTurn)
+	require.NoError(t, err)
+	assert.Equal(t, &GroundingResult{Citations: 1, ValidCitations: 1}, result.Grounding)
+
+	turn := refundTurn
+	turn.Answer += " Refunds are paid in bitcoin to offshore accounts."
+	turn.Citations = []Citation{
+		{DocumentID: "refunds", Quote: "paid in bitcoin"},
+		{DocumentID: "warranty"},
+		{DocumentID: "shipping"},
+	}
+	result, err = GroundingEvaluator{}.Evaluate(context.Background(), turn)
+	require.NoError(t, err)
+	assert.Equal(t, &GroundingResult{Hallucinated: true, Citations: 3, ValidCitations: 1}, result.Grounding)
+
+	turn.Retrieved = nil
+	result, _ = GroundingEvaluator{}.Evaluate(context.Background(), turn)
+	assert.Nil(t, result.Grounding)
+}
+
+func TestChain(t *testing.T) {
+	judge := EvaluatorFunc(func(ctx context.Context, turn Turn) (Result, error) {
+		return Result{Grounding: &GroundingResult{Hallucinated: true}}, nil
+	})
+	result, err := Chain(RetrievalEvaluator{}, GroundingEvaluator{}, judge).Evaluate(context.Background(), refundTurn)
+	require.NoError(t, err)
+	assert.True(t, result.Retrieval.Hit)
+	assert.True(t, result.Grounding.Hallucinated)
+
+	failing := EvaluatorFunc(func(ctx context.Context, turn Turn) (Result, error) {
+		return Result{}, errors.New("judge unavailable")
+	})
+	_, err = Chain(RetrievalEvaluator{}, failing).Evaluate(context.Background(), refundTurn)
+	assert.Error(t, err)
+}
+
+func TestWorkerSampling(t *testing.T) {
+	m := metrics.NewAgentMetrics(prometheus.NewRegistry())
+	w := NewWorker(RetrievalEvaluator{}, m, WorkerOptions{SampleRate: 0.2, QueueSize: 10000})
+
+	sampled := 0
+	for i := 0; i < 10000; i++ {
+		if w.Submit(Turn{ID: fmt.Sprintf("turn-%d", i)}) {
+			sampled++
+		}
+	}
+	assert.InDelta(t, 2000, sampled, 200)
+	// The same turn is sampled the same way every time
+	for i := 0; i < 10; i++ {
+		turn := Turn{ID: fmt.Sprintf("turn-%d", i)}
+		assert.Equal(t, w.sampled(turn), w.sampled(turn))
+	}
+
+	full := NewWorker(RetrievalEvaluator{}, m, WorkerOptions{SampleRate: 1, QueueSize: 1})
+	assert.True(t, full.Submit(refundTurn))
+	assert.False(t, full.Submit(refundTurn))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.RAGEvaluations.WithLabelValues(OutcomeDropped)))
+}
+
+func TestWorkerFeedsMetrics(t *testing.T) {
+	m := metrics.NewAgentMetrics(prometheus.NewRegistry())
+	w := NewWorker(Chain(RetrievalEvaluator{}, GroundingEvaluator{}), m, WorkerOptions{SampleRate: 1, Concurrency: 2})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		_ = w.Start(ctx)
+		close(done)
+	}()
+
+	missed := refundTurn
+	missed.ID = "turn-2"
+	missed.Retrieved = missed.Retrieved[:1]
+	missed.Answer = "Refunds are paid in bitcoin."
+	missed.Citations = []Citation{{DocumentID: "refunds"}}
+	require.True(t, w.Submit(refundTurn))
+	require.True(t, w.Submit(missed))
+
+	require.Eventually(t, func() bool {
+		return testutil.ToFloat64(m.RAGEvaluations.WithLabelValues(OutcomeEvaluated)) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	cancel()
+	<-done
+
+	assert.Equal(t, 0.5, testutil.ToFloat64(m.RetrievalHitAtK))
+	assert.Equal(t, 0.25, testutil.ToFloat64(m.RetrievalMRR))
+	assert.Equal(t, 0.5, testutil.ToFloat64(m.HallucinationRate))
+	assert.Equal(t, 0.5, testutil.ToFloat64(m.CitationValidityRate))
+
+	failing := NewWorker(EvaluatorFunc(func(ctx context.Context, turn Turn) (Result, error) {
+		return Result{}, errors.New("judge unavailable")
+	}), m, WorkerOptions{})
+	assert.Error(t, failing.Evaluate(context.Background(), refundTurn))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.RAGEvaluations.WithLabelValues(OutcomeFailed)))
+}
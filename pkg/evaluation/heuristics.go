@@ -0,0 +1,146 @@
+package evaluation
+
+import (
+	"context"
+	"strings"
+	"unicode"
+)
+
+// DefaultK is the cutoff of hit@k of a RetrievalEvaluator
+const DefaultK = 5
+
+// DefaultMinSupport is the share of the words of a sentence that must
+// appear in the retrieved documents for a GroundingEvaluator to take it as
+// supported
+const DefaultMinSupport = 0.5
+
+// RetrievalEvaluator judges retrieval against the relevant documents of a
+// turn. Turns without relevant documents are not judged.
+type RetrievalEvaluator struct {
+	// K is the cutoff of hit@k. Defaults to DefaultK.
+	K int
+}
+
+// Evaluate implements Evaluator
+func (e RetrievalEvaluator) Evaluate(ctx context.Context, turn Turn) (Result, error) {
+	if len(turn.Relevant) == 0 {
+		return Result{}, nil
+	}
+	k := e.K
+	if k <= 0 {
+		k = DefaultK
+	}
+	relevant := make(map[string]bool, len(turn.Relevant))
+	for _, id := range turn.Relevant {
+		relevant[id] = true
+	}
+
+	result := &RetrievalResult{}
+	for i, doc := range turn.Retrieved {
+		if relevant[doc.ID] {
+			result.Hit = i < k
+			result.ReciprocalRank = 1 / float64(i+1)
+			break
+		}
+	}
+	return Result{Retrieval: result}, nil
+}
+
+// GroundingEvaluator checks the answer of a turn against the text of its
+// retrieved documents by word overlap, a cheap proxy for an LLM judge: a
+// sentence no document shares enough words with is taken as hallucinated,
+// and a citation is valid if it cites a retrieved document containing its
+// quote. Turns without an answer or documents are not judged.
+type GroundingEvaluator struct {
+	// MinSupport is the share of the words of a sentence that must appear
+	// in a document. Defaults to DefaultMinSupport.
+	MinSupport float64
+}
+
+// Evaluate implements Evaluator
+func (e GroundingEvaluator) Evaluate(ctx context.Context, turn Turn) (Result, error) {
+	if strings.TrimSpace(turn.Answer) == "" || len(turn.Retrieved) == 0 {
+		return Result{}, nil
+	}
+	minSupport := e.MinSupport
+	if minSupport <= 0 {
+		minSupport = DefaultMinSupport
+	}
+
+	documents := make(map[string]string, len(turn.Retrieved))
+	vocabulary := make([]map[string]bool, 0, len(turn.Retrieved))
+	for _, doc := range turn.Retrieved {
+		documents[doc.ID] = normalize(doc.Content)
+		vocabulary = append(vocabulary, wordSet(doc.Content))
+	}
+
+	result := &GroundingResult{}
+	for _, sentence := range sentences(turn.Answer) {
+		if !supported(words(sentence), vocabulary, minSupport) {
+			result.Hallucinated = true
+			break
+		}
+	}
+	for _, citation := range turn.Citations {
+		result.Citations++
+		content, ok := documents[citation.DocumentID]
+		if ok && strings.Contains(content, normalize(citation.Quote)) {
+			result.ValidCitations++
+		}
+	}
+	return Result{Grounding: result}, nil
+}
+
+// supported reports whether a document contains minSupport of words
+func supported(words []string, vocabulary []map[string]bool, minSupport float64) bool {
+	if len(words) == 0 {
+		return true
+	}
+	for _, vocab := range vocabulary {
+		found := 0
+		for _, word := range words {
+			if vocab[word] {
+				found++
+			}
+		}
+		if float64(found)/float64(len(words)) >= minSupport {
+			return true
+		}
+	}
+	return false
+}
+
+// sentences splits text at sentence punctuation
+func sentences(text string) []string {
+	return strings.FieldsFunc(text, func(r rune) bool {
+		return r == '.' || r == '!' || r == '?' || r == '\n'
+	})
+}
+
+// words returns the lowercased words of text, skipping words of three
+// letters or fewer, mostly stop words
+func words(text string) []string {
+	var out []string
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if len(word) > 3 {
+			out = append(out, word)
+		}
+	}
+	return out
+}
+
+func wordSet(text string) map[string]bool {
+	set := map[string]bool{}
+	for _, word := range words(text) {
+		set[word] = true
+	}
+	return set
+}
+
+// normalize lowercases text and collapses its whitespace, for matching
+// quotes
+func normalize(text string) string {
+	return strings.Join(strings.Fields(strings.ToLower(text)), " ")
+}
@@ -0,0 +1,164 @@
+package evaluation
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/bowenislandsong/neuronetes/pkg/metrics"
+)
+
+const (
+	// DefaultSampleRate is the share of turns a Worker evaluates
+	DefaultSampleRate = 0.05
+
+	// DefaultQueueSize is the number of sampled turns a Worker holds
+	// before dropping new ones
+	DefaultQueueSize = 256
+
+	// DefaultTimeout bounds the evaluation of a turn
+	DefaultTimeout = 30 * time.Second
+)
+
+// Outcomes of the turns sampled for evaluation
+const (
+	OutcomeEvaluated = "evaluated"
+	OutcomeFailed    = "failed"
+	OutcomeDropped   = "dropped"
+)
+
+// Worker evaluates a sample of turns in the background and records the
+// results in the RAG quality gauges: rag_hit_at_k, rag_mrr,
+// agent_hallucination_rate and agent_citation_validity_rate. The serving
+// path hands every turn to Submit, which never blocks.
+type Worker struct {
+	evaluator   Evaluator
+	metrics     *metrics.AgentMetrics
+	sampleRate  float64
+	timeout     time.Duration
+	concurrency int
+	queue       chan Turn
+
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+// WorkerOptions configures a Worker. Zero values use the defaults.
+type WorkerOptions struct {
+	// SampleRate is the share of turns evaluated, between 0 and 1
+	SampleRate float64
+
+	// QueueSize bounds the sampled turns waiting for evaluation
+	QueueSize int
+
+	// Concurrency is the number of turns evaluated at once. Defaults to 1.
+	Concurrency int
+
+	// Timeout bounds the evaluation of a turn
+	Timeout time.Duration
+}
+
+// NewWorker creates a worker scoring turns with evaluator and recording
+// the results in agentMetrics
+func NewWorker(evaluator Evaluator, agentMetrics *metrics.AgentMetrics, opts WorkerOptions) *Worker {
+	if opts.SampleRate <= 0 {
+		opts.SampleRate = DefaultSampleRate
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = DefaultQueueSize
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 1
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+	return &Worker{
+		evaluator:   evaluator,
+		metrics:     agentMetrics,
+		sampleRate:  math.Min(opts.SampleRate, 1),
+		timeout:     opts.Timeout,
+		concurrency: opts.Concurrency,
+		queue:       make(chan Turn, opts.QueueSize),
+		rand:        rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Submit queues turn for evaluation if it is sampled, and reports whether
+// it was queued. Sampled turns are dropped while the queue is full.
+func (w *Worker) Submit(turn Turn) bool {
+	if !w.sampled(turn) {
+		return false
+	}
+	select {
+	case w.queue <- turn:
+		return true
+	default:
+		w.metrics.RAGEvaluations.WithLabelValues(OutcomeDropped).Inc()
+		return false
+	}
+}
+
+// sampled reports whether turn is in the sample: by a hash of its ID, or
+// at random for turns without one
+func (w *Worker) sampled(turn Turn) bool {
+	if w.sampleRate >= 1 {
+		return true
+	}
+	if turn.ID == "" {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		return w.rand.Float64() < w.sampleRate
+	}
+	sum := sha256.Sum256([]byte(turn.ID))
+	return float64(binary.BigEndian.Uint64(sum[:8]))/math.MaxUint64 < w.sampleRate
+}
+
+// Evaluate scores turn and records the result
+func (w *Worker) Evaluate(ctx context.Context, turn Turn) error {
+	ctx, cancel := context.WithTimeout(ctx, w.timeout)
+	defer cancel()
+	result, err := w.evaluator.Evaluate(ctx, turn)
+	if err != nil {
+		w.metrics.RAGEvaluations.WithLabelValues(OutcomeFailed).Inc()
+		return err
+	}
+	w.metrics.RAGEvaluations.WithLabelValues(OutcomeEvaluated).Inc()
+	if r := result.Retrieval; r != nil {
+		w.metrics.RecordRetrievalEvaluation(ctx, r.Hit, r.ReciprocalRank)
+	}
+	if g := result.Grounding; g != nil {
+		w.metrics.RecordGroundingEvaluation(ctx, g.Hallucinated, g.Citations, g.ValidCitations)
+	}
+	return nil
+}
+
+// Start evaluates the queued turns until ctx is done
+func (w *Worker) Start(ctx context.Context) error {
+	logger := log.FromContext(ctx)
+	var wg sync.WaitGroup
+	for i := 0; i < w.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case turn := <-w.queue:
+					if err := w.Evaluate(ctx, turn); err != nil {
+						logger.Error(err, "failed to evaluate turn", "turn", turn.ID)
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return nil
+}
@@ -0,0 +1,236 @@
+// Package externalmetrics serves AgentPool metrics through the Kubernetes
+// External Metrics API (external.metrics.k8s.io/v1beta1), so standard
+// HorizontalPodAutoscalers can scale AgentPools on token-aware metrics
+// without the NeuroNetes autoscaler.
+package externalmetrics
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/autoscaler"
+)
+
+const (
+	// GroupName is the API group of the External Metrics API
+	GroupName = "external.metrics.k8s.io"
+
+	// Version is the served version of the External Metrics API
+	Version = "v1beta1"
+
+	groupVersionPath = "/apis/" + GroupName + "/" + Version
+)
+
+// ExternalMetricValue mirrors external.metrics.k8s.io/v1beta1
+// ExternalMetricValue
+type ExternalMetricValue struct {
+	MetricName    string            `json:"metricName"`
+	MetricLabels  map[string]string `json:"metricLabels"`
+	Timestamp     metav1.Time       `json:"timestamp"`
+	WindowSeconds *int64            `json:"window,omitempty"`
+	Value         resource.Quantity `json:"value"`
+}
+
+// ExternalMetricValueList mirrors external.metrics.k8s.io/v1beta1
+// ExternalMetricValueList
+type ExternalMetricValueList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ExternalMetricValue `json:"items"`
+}
+
+// Adapter serves the External Metrics API. Each metric is one of the
+// autoscaling metric types (tokens-in-queue, ttft-p95, ...). The request's
+// label selector picks AgentPools in the namespace; it is matched against
+// the pool's labels plus neuronetes.io/pool=<name>, so an HPA can select a
+// single pool by name. One value is returned per matching pool.
+type Adapter struct {
+	client   client.Reader
+	provider autoscaler.MetricsProvider
+	metrics  []string
+	now      func() time.Time
+}
+
+// NewAdapter creates an adapter serving metrics from provider for the
+// AgentPools read through c
+func NewAdapter(c client.Reader, provider autoscaler.MetricsProvider) *Adapter {
+	metrics := make([]string, 0, len(autoscaler.DefaultQueries))
+	for metricType := range autoscaler.DefaultQueries {
+		metrics = append(metrics, metricType)
+	}
+	sort.Strings(metrics)
+
+	return &Adapter{
+		client:   c,
+		provider: provider,
+		metrics:  metrics,
+		now:      time.Now,
+	}
+}
+
+// ServeHTTP implements http.Handler
+func (a *Adapter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeStatus(w, http.StatusMethodNotAllowed, metav1.StatusReasonMethodNotAllowed, "only GET is supported")
+		return
+	}
+
+	path := strings.TrimSuffix(r.URL.Path, "/")
+	switch {
+	case path == "/apis":
+		a.serveGroupList(w)
+	case path == "/apis/"+GroupName:
+		writeJSON(w, http.StatusOK, apiGroup())
+	case path == groupVersionPath:
+		a.serveResourceList(w)
+	case strings.HasPrefix(path, groupVersionPath+"/namespaces/"):
+		// namespaces/<namespace>/<metric>
+		parts := strings.Split(strings.TrimPrefix(path, groupVersionPath+"/namespaces/"), "/")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			writeStatus(w, http.StatusNotFound, metav1.StatusReasonNotFound, "not found")
+			return
+		}
+		a.serveMetric(w, r, parts[0], parts[1])
+	default:
+		writeStatus(w, http.StatusNotFound, metav1.StatusReasonNotFound, "not found")
+	}
+}
+
+func (a *Adapter) serveGroupList(w http.ResponseWriter) {
+	writeJSON(w, http.StatusOK, &metav1.APIGroupList{
+		TypeMeta: metav1.TypeMeta{Kind: "APIGroupList", APIVersion: "v1"},
+		Groups:   []metav1.APIGroup{*apiGroup()},
+	})
+}
+
+func (a *Adapter) serveResourceList(w http.ResponseWriter) {
+	list := &metav1.APIResourceList{
+		TypeMeta:     metav1.TypeMeta{Kind: "APIResourceList", APIVersion: "v1"},
+		GroupVersion: GroupName + "/" + Version,
+	}
+	for _, metricType := range a.metrics {
+		list.APIResources = append(list.APIResources, metav1.APIResource{
+			Name:       metricType,
+			Namespaced: true,
+			Kind:       "ExternalMetricValueList",
+			Verbs:      metav1.Verbs{"get"},
+		})
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (a *Adapter) serveMetric(w http.ResponseWriter, r *http.Request, namespace, metricType string) {
+	if !a.serves(metricType) {
+		writeStatus(w, http.StatusNotFound, metav1.StatusReasonNotFound,
+			fmt.Sprintf("metric %s is not served", metricType))
+		return
+	}
+
+	selector, err := labels.Parse(r.URL.Query().Get("labelSelector"))
+	if err != nil {
+		writeStatus(w, http.StatusBadRequest, metav1.StatusReasonBadRequest,
+			fmt.Sprintf("invalid label selector: %v", err))
+		return
+	}
+
+	list, err := a.values(r.Context(), namespace, metricType, selector)
+	if err != nil {
+		log.FromContext(r.Context()).Error(err, "failed to get external metric",
+			"namespace", namespace, "metric", metricType)
+		writeStatus(w, http.StatusInternalServerError, metav1.StatusReasonInternalError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+// values returns metricType for each AgentPool in namespace matching selector
+func (a *Adapter) values(ctx context.Context, namespace, metricType string, selector labels.Selector) (*ExternalMetricValueList, error) {
+	var pools neuronetes.AgentPoolList
+	if err := a.client.List(ctx, &pools, client.InNamespace(namespace)); err != nil {
+		return nil, fmt.Errorf("failed to list agent pools: %w", err)
+	}
+
+	list := &ExternalMetricValueList{
+		TypeMeta: metav1.TypeMeta{Kind: "ExternalMetricValueList", APIVersion: GroupName + "/" + Version},
+		Items:    []ExternalMetricValue{},
+	}
+	now := metav1.NewTime(a.now())
+	for i := range pools.Items {
+		pool := &pools.Items[i]
+		if !selector.Matches(poolLabels(pool)) {
+			continue
+		}
+
+		value, err := a.provider.GetMetric(ctx, pool, metricType)
+		if errors.Is(err, autoscaler.ErrNoData) {
+			// A pool without replicas reports no load
+			value, err = 0, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("pool %s: %w", pool.Name, err)
+		}
+
+		list.Items = append(list.Items, ExternalMetricValue{
+			MetricName:   metricType,
+			MetricLabels: map[string]string{neuronetes.LabelPool: pool.Name},
+			Timestamp:    now,
+			Value:        *resource.NewMilliQuantity(int64(math.Round(value*1000)), resource.DecimalSI),
+		})
+	}
+	return list, nil
+}
+
+func (a *Adapter) serves(metricType string) bool {
+	i := sort.SearchStrings(a.metrics, metricType)
+	return i < len(a.metrics) && a.metrics[i] == metricType
+}
+
+// poolLabels returns the labels a selector is matched against for pool
+func poolLabels(pool *neuronetes.AgentPool) labels.Set {
+	set := make(labels.Set, len(pool.Labels)+1)
+	for k, v := range pool.Labels {
+		set[k] = v
+	}
+	set[neuronetes.LabelPool] = pool.Name
+	return set
+}
+
+func apiGroup() *metav1.APIGroup {
+	version := metav1.GroupVersionForDiscovery{GroupVersion: GroupName + "/" + Version, Version: Version}
+	return &metav1.APIGroup{
+		TypeMeta:         metav1.TypeMeta{Kind: "APIGroup", APIVersion: "v1"},
+		Name:             GroupName,
+		Versions:         []metav1.GroupVersionForDiscovery{version},
+		PreferredVersion: version,
+	}
+}
+
+func writeStatus(w http.ResponseWriter, code int, reason metav1.StatusReason, message string) {
+	writeJSON(w, code, &metav1.Status{
+		TypeMeta: metav1.TypeMeta{Kind: "Status", APIVersion: "v1"},
+		Status:   metav1.StatusFailure,
+		Message:  message,
+		Reason:   reason,
+		Code:     int32(code),
+	})
+}
+
+func writeJSON(w http.ResponseWriter, code int, obj interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(obj)
+}
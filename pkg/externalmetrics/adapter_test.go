@@ -0,0 +1,117 @@
+package externalmetrics
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/autoscaler"
+)
+
+func newTestAdapter(t *testing.T, provider autoscaler.MetricsProvider) *Adapter {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	require.NoError(t, neuronetes.AddToScheme(scheme))
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		&neuronetes.AgentPool{ObjectMeta: metav1.ObjectMeta{
+			Name: "chat", Namespace: "default", Labels: map[string]string{"tier": "interactive"},
+		}},
+		&neuronetes.AgentPool{ObjectMeta: metav1.ObjectMeta{
+			Name: "batch", Namespace: "default", Labels: map[string]string{"tier": "batch"},
+		}},
+		&neuronetes.AgentPool{ObjectMeta: metav1.ObjectMeta{Name: "chat", Namespace: "other"}},
+	).Build()
+
+	a := NewAdapter(c, provider)
+	a.now = func() time.Time { return time.Unix(1700000000, 0) }
+	return a
+}
+
+func get(t *testing.T, h http.Handler, url string) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, url, nil))
+	return rec
+}
+
+func TestAdapterServesMetricPerPool(t *testing.T) {
+	provider := autoscaler.NewMockMetricsProvider()
+	provider.SetMetric("tokens-in-queue", 123.4567)
+	a := newTestAdapter(t, provider)
+
+	rec := get(t, a, "/apis/external.metrics.k8s.io/v1beta1/namespaces/default/tokens-in-queue?labelSelector=neuronetes.io%2Fpool%3Dchat")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	var list ExternalMetricValueList
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &list))
+	assert.Equal(t, "ExternalMetricValueList", list.Kind)
+	require.Len(t, list.Items, 1)
+	assert.Equal(t, "tokens-in-queue", list.Items[0].MetricName)
+	assert.Equal(t, map[string]string{neuronetes.LabelPool: "chat"}, list.Items[0].MetricLabels)
+	assert.Equal(t, int64(123457), list.Items[0].Value.MilliValue())
+
+	// Selectors also match pool labels
+	rec = get(t, a, "/apis/external.metrics.k8s.io/v1beta1/namespaces/default/tokens-in-queue?labelSelector=tier+in+(batch,interactive)")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &list))
+	assert.Len(t, list.Items, 2)
+}
+
+func TestAdapterTreatsNoDataAsZero(t *testing.T) {
+	a := newTestAdapter(t, noDataProvider{})
+
+	rec := get(t, a, "/apis/external.metrics.k8s.io/v1beta1/namespaces/other/ttft-p95")
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var list ExternalMetricValueList
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &list))
+	require.Len(t, list.Items, 1)
+	assert.True(t, list.Items[0].Value.IsZero())
+}
+
+func TestAdapterErrors(t *testing.T) {
+	a := newTestAdapter(t, autoscaler.NewMockMetricsProvider())
+
+	assert.Equal(t, http.StatusNotFound, get(t, a, "/apis/external.metrics.k8s.io/v1beta1/namespaces/default/gpu-temperature").Code)
+	assert.Equal(t, http.StatusBadRequest, get(t, a, "/apis/external.metrics.k8s.io/v1beta1/namespaces/default/queue-depth?labelSelector=%3D%3D").Code)
+	// The mock provider has no value for the metric
+	assert.Equal(t, http.StatusInternalServerError, get(t, a, "/apis/external.metrics.k8s.io/v1beta1/namespaces/default/queue-depth").Code)
+
+	rec := httptest.NewRecorder()
+	a.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/apis", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
+
+func TestAdapterDiscovery(t *testing.T) {
+	a := newTestAdapter(t, autoscaler.NewMockMetricsProvider())
+
+	rec := get(t, a, "/apis/external.metrics.k8s.io/v1beta1")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var resources metav1.APIResourceList
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resources))
+	assert.Equal(t, "external.metrics.k8s.io/v1beta1", resources.GroupVersion)
+	assert.Len(t, resources.APIResources, len(autoscaler.DefaultQueries))
+
+	rec = get(t, a, "/apis")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var groups metav1.APIGroupList
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &groups))
+	require.Len(t, groups.Groups, 1)
+	assert.Equal(t, GroupName, groups.Groups[0].Name)
+}
+
+type noDataProvider struct{}
+
+func (noDataProvider) GetMetric(ctx context.Context, pool *neuronetes.AgentPool, metricType string) (float64, error) {
+	return 0, autoscaler.ErrNoData
+}
@@ -0,0 +1,97 @@
+package externalmetrics
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"time"
+)
+
+// Server serves an Adapter over HTTPS, as the API aggregation layer requires
+type Server struct {
+	// Addr is the address to listen on
+	Addr string
+
+	// CertFile and KeyFile hold the serving certificate. When unset, a
+	// self-signed certificate is generated, which requires the APIService
+	// to set insecureSkipTLSVerify.
+	CertFile string
+	KeyFile  string
+
+	// Handler serves the External Metrics API
+	Handler http.Handler
+}
+
+// Start serves until ctx is cancelled. It implements manager.Runnable.
+func (s *Server) Start(ctx context.Context) error {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if s.CertFile == "" || s.KeyFile == "" {
+		cert, err := selfSignedCertificate()
+		if err != nil {
+			return err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/apis", s.Handler)
+	mux.Handle("/apis/", s.Handler)
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	srv := &http.Server{
+		Addr:              s.Addr,
+		Handler:           mux,
+		TLSConfig:         tlsConfig,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.ListenAndServeTLS(s.CertFile, s.KeyFile)
+	}()
+
+	select {
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return srv.Shutdown(shutdownCtx)
+	case err := <-errCh:
+		if errors.Is(err, http.ErrServerClosed) {
+			return nil
+		}
+		return err
+	}
+}
+
+// selfSignedCertificate generates a serving certificate valid for a year
+func selfSignedCertificate() (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to generate key: %w", err)
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(now.UnixNano()),
+		Subject:      pkix.Name{CommonName: "neuronetes-metrics-adapter"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(365 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to create certificate: %w", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
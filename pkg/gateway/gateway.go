@@ -0,0 +1,939 @@
+// Package gateway serves HTTP, WebSocket and gRPC ToolBindings. Requests on
+// the path or authority of a binding are proxied to a replica of its
+// AgentPool, held by the activator while the pool is scaled to zero, and
+// bounded by the binding's timeouts.
+package gateway
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/activator"
+	"github.com/bowenislandsong/neuronetes/pkg/autoscaler"
+	"github.com/bowenislandsong/neuronetes/pkg/canary"
+	"github.com/bowenislandsong/neuronetes/pkg/evaluation"
+	"github.com/bowenislandsong/neuronetes/pkg/metrics"
+	"github.com/bowenislandsong/neuronetes/pkg/router"
+	"github.com/bowenislandsong/neuronetes/pkg/tracing"
+)
+
+const (
+	// DefaultReplicaPort is the port agent replicas serve on
+	DefaultReplicaPort = 8080
+
+	// SessionHeader carries the session of a request, which sticks to the
+	// replica that served the session first
+	SessionHeader = "X-Session-ID"
+
+	// TenantHeader carries the tenant a request is accounted to
+	TenantHeader = "X-Tenant-ID"
+
+	// ToolTimeoutHeader tells replicas the toolTimeout of the binding, as a
+	// Go duration, so that they bound the tool calls of the request
+	ToolTimeoutHeader = "X-Tool-Timeout"
+
+	// ExpectedTokensHeader carries the tokens a request expects to consume,
+	// which raise what it is charged against the tokensPerSecondBudget of
+	// its pool up front. It cannot lower the charge below
+	// DefaultExpectedTokens.
+	ExpectedTokensHeader = "X-Expected-Tokens"
+
+	// DefaultExpectedTokens is the least a request is charged up front,
+	// before its usage is known
+	DefaultExpectedTokens = 256
+
+	// InputTokensHeader and OutputTokensHeader carry the tokens a request
+	// consumed, set by replicas on the response, or as trailers of streamed
+	// responses. The charge of the request is trued up to them.
+	InputTokensHeader  = "X-Input-Tokens"
+	OutputTokensHeader = "X-Output-Tokens"
+
+	// TurnHeader carries the retrieval-augmented turn a response answers, as
+	// the base64-encoded JSON of an evaluation.Turn, set by replicas on the
+	// response or as a trailer of streamed responses. The turn is submitted
+	// for quality evaluation.
+	TurnHeader = "X-RAG-Turn"
+
+	// UnmatchedRoute is the route of requests on no binding's path
+	UnmatchedRoute = "unmatched"
+
+	// DefaultHeartbeatInterval is how long the event stream of a streaming
+	// binding may be quiet before a heartbeat is sent
+	DefaultHeartbeatInterval = 15 * time.Second
+)
+
+var (
+	// ErrRequestTimeout is the cause of requests cancelled after the
+	// requestTimeout of their binding
+	ErrRequestTimeout = errors.New("request timed out")
+
+	// ErrIdleTimeout is the cause of requests cancelled after their
+	// response was idle for the idleTimeout of their binding
+	ErrIdleTimeout = errors.New("response idle timed out")
+)
+
+// CostRecorder attributes the cost of pools to the tokens they served, e.g.
+// an accounting.CostEngine
+type CostRecorder interface {
+	RecordTokens(pool types.NamespacedName, tenant, session, model string, inputTokens, outputTokens int64)
+}
+
+// TurnSubmitter evaluates a sample of the turns it is handed off the request
+// path, e.g. an evaluation.Worker
+type TurnSubmitter interface {
+	Submit(turn evaluation.Turn) bool
+}
+
+// Gateway is an http.Handler routing the requests of HTTP ToolBindings to
+// the replicas of their AgentPools. The calls of gRPC ToolBindings are
+// routed the same way by a GRPCServer.
+type Gateway struct {
+	activator *activator.Activator
+	metrics   *metrics.AgentMetrics
+	proxy     *httputil.ReverseProxy
+
+	// streamProxy flushes every write of the response, for streaming
+	// bindings
+	streamProxy *httputil.ReverseProxy
+
+	// Port is the port replicas serve on
+	Port int
+
+	// HeartbeatInterval is how long the event stream of a streaming binding
+	// may be quiet before a heartbeat comment is sent, so that clients and
+	// proxies in between keep the connection open. Zero disables heartbeats.
+	HeartbeatInterval time.Duration
+
+	// Ledger accounts the tokens replicas report for each request to its
+	// tenant and the model of its pool. Optional.
+	Ledger metrics.TokenLedger
+
+	// Costs attributes the cost of pools to the tokens replicas report for
+	// each request. Optional.
+	Costs CostRecorder
+
+	// Evaluations is handed the turns replicas report for each request.
+	// Optional.
+	Evaluations TurnSubmitter
+
+	mu          sync.RWMutex
+	bindings    map[types.NamespacedName]*route
+	paths       map[string]*route
+	authorities map[string]*route
+	pools       map[types.NamespacedName]*pool
+	now         func() time.Time
+}
+
+// route is the config of a served binding. Updating the binding replaces
+// its route but keeps its stats.
+type route struct {
+	key     types.NamespacedName
+	path    string
+	methods []string
+	pool    *pool
+
+	// streaming routes flush each chunk of the response as it arrives and
+	// send heartbeats on quiet event streams
+	streaming bool
+
+	// websocket routes relay WebSocket connections, see relay
+	websocket      bool
+	origins        []string
+	pingInterval   time.Duration
+	migrationGrace time.Duration
+
+	// replicaConns and sessionConns limit the WebSocket connections per
+	// replica and per session, zero is unlimited
+	replicaConns int
+	sessionConns int
+
+	// grpc routes serve the calls on their authority, see GRPCServer. The
+	// default route has no authority.
+	grpc      bool
+	authority string
+
+	requestTimeout time.Duration
+	idleTimeout    time.Duration
+	toolTimeout    time.Duration
+
+	stats *stats
+}
+
+// pool routes across the replicas of an AgentPool shared by its bindings
+type pool struct {
+	key      types.NamespacedName
+	pool     *neuronetes.AgentPool
+	router   *router.Router
+	replicas map[string]bool
+
+	// budget admits requests within the tokensPerSecondBudget of the pool,
+	// nil if it has none
+	budget *router.TokenBudget
+
+	// canary receives the share of the sessions of the pool splitter
+	// routes to it, nil while the pool has no canary
+	canary   *pool
+	splitter *canary.Splitter
+
+	// model is the Model the replicas of the pool serve, which their
+	// tokens are accounted to
+	model string
+
+	// class is the AgentClass of the pool, nil until it is set
+	class *neuronetes.AgentClass
+
+	// draining are the replicas being removed by scale-down
+	draining []string
+
+	// mu guards the WebSocket connections relayed to the pool
+	mu       sync.Mutex
+	conns    map[string]int
+	sessions map[string]int
+	relays   map[*relay]bool
+
+	// clients are the gRPC connections to the replicas by name, guarded by
+	// mu
+	clients map[string]*grpc.ClientConn
+}
+
+// NewGateway creates a gateway. Requests for pools without ready replicas
+// are held by a if not nil, and fail right away otherwise. m may be nil.
+func NewGateway(a *activator.Activator, m *metrics.AgentMetrics) *Gateway {
+	g := &Gateway{
+		activator:         a,
+		metrics:           m,
+		Port:              DefaultReplicaPort,
+		HeartbeatInterval: DefaultHeartbeatInterval,
+		bindings:          make(map[types.NamespacedName]*route),
+		paths:             make(map[string]*route),
+		authorities:       make(map[string]*route),
+		pools:             make(map[types.NamespacedName]*pool),
+		now:               time.Now,
+	}
+	g.proxy = g.newProxy()
+	g.streamProxy = g.newProxy()
+	// A negative interval flushes right after each write
+	g.streamProxy.FlushInterval = -1
+	g.proxy.ModifyResponse = keepResponse
+	g.streamProxy.ModifyResponse = func(res *http.Response) error {
+		if err := keepResponse(res); err != nil {
+			return err
+		}
+		return streamResponse(res)
+	}
+	return g
+}
+
+// newProxy returns a proxy to the replica a request was routed to
+func (g *Gateway) newProxy() *httputil.ReverseProxy {
+	return &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(pr.In.Context().Value(targetKey{}).(*url.URL))
+			pr.SetXForwarded()
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			g.fail(r.Context(), w, r.Context().Value(routeKey{}).(*route), err)
+		},
+	}
+}
+
+type targetKey struct{}
+
+type routeKey struct{}
+
+// responseKey holds where keepResponse keeps the response of the replica
+type responseKey struct{}
+
+// keepResponse keeps the response of the replica for the request it
+// answers, whose usage is read once the proxy copied the response
+func keepResponse(res *http.Response) error {
+	if kept, ok := res.Request.Context().Value(responseKey{}).(**http.Response); ok {
+		*kept = res
+	}
+	return nil
+}
+
+// Serve routes the requests on the path or authority of binding to the
+// replicas of pool, replacing the previous config of binding
+func (g *Gateway) Serve(binding *neuronetes.ToolBinding, agentPool *neuronetes.AgentPool, replicas []router.Replica) error {
+	key := types.NamespacedName{Namespace: binding.Namespace, Name: binding.Name}
+	rt := &route{key: key}
+	switch binding.Spec.Type {
+	case neuronetes.ToolBindingGRPC:
+		rt.grpc = true
+		if config := binding.Spec.GRPCConfig; config != nil {
+			rt.authority = strings.ToLower(config.Authority)
+		}
+	case neuronetes.ToolBindingWebSocket:
+		config := binding.Spec.WebSocketConfig
+		if config == nil {
+			return errors.New("websocketConfig is required for websocket bindings")
+		}
+		if !strings.HasPrefix(config.Path, "/") {
+			return fmt.Errorf("websocketConfig.path %q must start with /", config.Path)
+		}
+		rt.path = cleanPath(config.Path)
+		rt.websocket = true
+		rt.origins = config.AllowedOrigins
+		rt.pingInterval = DefaultPingInterval
+		if d := duration(config.PingInterval); d > 0 {
+			rt.pingInterval = d
+		}
+		rt.migrationGrace = DefaultMigrationGracePeriod
+		if d := duration(config.MigrationGracePeriod); d > 0 {
+			rt.migrationGrace = d
+		}
+		if concurrency := binding.Spec.Concurrency; concurrency != nil {
+			rt.replicaConns = limit(concurrency.MaxConcurrentRequests)
+			rt.sessionConns = limit(concurrency.PerSessionLimit)
+		}
+	default:
+		config := binding.Spec.HTTPConfig
+		if config == nil {
+			return errors.New("httpConfig is required for http bindings")
+		}
+		if !strings.HasPrefix(config.Path, "/") {
+			return fmt.Errorf("httpConfig.path %q must start with /", config.Path)
+		}
+		rt.path = cleanPath(config.Path)
+		rt.streaming = config.StreamingEnabled
+		for _, method := range config.Methods {
+			rt.methods = append(rt.methods, strings.ToUpper(method))
+		}
+	}
+	if timeouts := binding.Spec.Timeouts; timeouts != nil {
+		rt.requestTimeout = duration(timeouts.RequestTimeout)
+		rt.idleTimeout = duration(timeouts.IdleTimeout)
+		rt.toolTimeout = duration(timeouts.ToolTimeout)
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if rt.grpc {
+		if other, ok := g.authorities[rt.authority]; ok && other.key != key {
+			if rt.authority == "" {
+				return fmt.Errorf("calls without a grpcConfig.authority are served by ToolBinding %s", other.key)
+			}
+			return fmt.Errorf("authority %s is served by ToolBinding %s", rt.authority, other.key)
+		}
+	} else if other, ok := g.paths[rt.path]; ok && other.key != key {
+		return fmt.Errorf("path %s is served by ToolBinding %s", rt.path, other.key)
+	}
+	if prev, ok := g.bindings[key]; ok {
+		rt.stats = prev.stats
+		g.unindex(prev)
+	} else {
+		rt.stats = &stats{sampled: g.now()}
+	}
+
+	rt.pool = g.syncPool(agentPool, replicas)
+	if rt.pool.canary != nil {
+		// Follow the weight of the canary, or stop splitting the pool once
+		// it is promoted or rolled back
+		if rt.pool.splitter = canary.SplitterForPool(rt.pool.pool); rt.pool.splitter == nil {
+			rt.pool.canary = nil
+		}
+	}
+
+	g.bindings[key] = rt
+	g.index(rt)
+	g.prunePools()
+	return nil
+}
+
+// Split routes the share of the sessions of the pool key its canary
+// annotations set to the replicas of canaryPool, or stops splitting the
+// pool if canaryPool is nil
+func (g *Gateway) Split(key types.NamespacedName, canaryPool *neuronetes.AgentPool, replicas []router.Replica) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	p, ok := g.pools[key]
+	if !ok {
+		return
+	}
+	if canaryPool == nil {
+		p.canary, p.splitter = nil, nil
+	} else {
+		p.canary = g.syncPool(canaryPool, replicas)
+		p.splitter = canary.SplitterForPool(p.pool)
+	}
+	g.prunePools()
+}
+
+// SetClass accounts the tokens served by the pool key, or by the canary
+// pool key, to the Model of class, and migrates the sessions of its
+// draining replicas if class keeps session memory outside replicas
+func (g *Gateway) SetClass(key types.NamespacedName, class *neuronetes.AgentClass) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if p, ok := g.pools[key]; ok {
+		p.model = class.Spec.ModelRef.Name
+		p.class = class.DeepCopy()
+		p.drainSessions()
+	}
+}
+
+// syncPool returns the pool of agentPool with its replicas, creating it if
+// the gateway has none. Callers must hold mu.
+func (g *Gateway) syncPool(agentPool *neuronetes.AgentPool, replicas []router.Replica) *pool {
+	key := types.NamespacedName{Namespace: agentPool.Namespace, Name: agentPool.Name}
+	p, ok := g.pools[key]
+	if !ok {
+		p = &pool{
+			key:      key,
+			router:   router.NewRouter(g.metrics),
+			replicas: make(map[string]bool),
+			conns:    make(map[string]int),
+			sessions: make(map[string]int),
+			relays:   make(map[*relay]bool),
+			clients:  make(map[string]*grpc.ClientConn),
+		}
+		g.pools[key] = p
+	}
+	if p.pool == nil || !reflect.DeepEqual(p.pool.Spec.TokensPerSecondBudget, agentPool.Spec.TokensPerSecondBudget) {
+		// A new budget starts full
+		p.budget = router.BudgetForPool(agentPool, g.metrics)
+	}
+	p.pool = agentPool.DeepCopy()
+	p.sync(replicas)
+	return p
+}
+
+// index makes rt the route of its path or authority. Callers must hold mu.
+func (g *Gateway) index(rt *route) {
+	if rt.grpc {
+		g.authorities[rt.authority] = rt
+	} else {
+		g.paths[rt.path] = rt
+	}
+}
+
+// unindex drops rt as the route of its path or authority. Callers must hold
+// mu.
+func (g *Gateway) unindex(rt *route) {
+	if rt.grpc {
+		if g.authorities[rt.authority] == rt {
+			delete(g.authorities, rt.authority)
+		}
+	} else if g.paths[rt.path] == rt {
+		delete(g.paths, rt.path)
+	}
+}
+
+// Remove stops serving binding
+func (g *Gateway) Remove(key types.NamespacedName) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	rt, ok := g.bindings[key]
+	if !ok {
+		return
+	}
+	delete(g.bindings, key)
+	g.unindex(rt)
+	g.prunePools()
+}
+
+// prunePools drops the pools no binding routes to, directly or as the
+// canary of its pool. Callers must hold mu.
+func (g *Gateway) prunePools() {
+	used := make(map[types.NamespacedName]bool, len(g.pools))
+	for _, rt := range g.bindings {
+		used[rt.pool.key] = true
+		if rt.pool.canary != nil {
+			used[rt.pool.canary.key] = true
+		}
+	}
+	for key, p := range g.pools {
+		if !used[key] {
+			p.closeClients(nil)
+			delete(g.pools, key)
+		}
+	}
+}
+
+// sync replaces the replicas of p and moves the connections and sessions
+// of draining replicas to the others. Callers must hold the gateway's mu.
+func (p *pool) sync(replicas []router.Replica) {
+	current := make(map[string]bool, len(replicas))
+	for _, rep := range replicas {
+		p.router.UpdateReplica(rep)
+		current[rep.Name] = true
+	}
+	p.draining = p.draining[:0]
+	for _, rep := range replicas {
+		if rep.Draining {
+			p.drain(rep.Name)
+			p.draining = append(p.draining, rep.Name)
+		}
+	}
+	for name := range p.replicas {
+		if !current[name] {
+			p.router.RemoveReplica(name)
+		}
+	}
+	p.closeClients(current)
+	p.replicas = current
+	p.drainSessions()
+}
+
+// drainSessions migrates the sticky sessions of draining replicas to the
+// others if the class of p keeps session memory outside replicas; otherwise
+// they stay until their replica is removed. Sessions that find no other
+// replica are migrated on a later sync. Callers must hold the gateway's mu.
+func (p *pool) drainSessions() {
+	if len(p.draining) > 0 && autoscaler.MemoryExternalized(p.class) {
+		_, _ = autoscaler.DrainVictims(p.router, p.class, p.draining)
+	}
+}
+
+// Route returns the path of the binding serving r, for labelling metrics
+func (g *Gateway) Route(r *http.Request) string {
+	if rt := g.match(r.URL.Path); rt != nil {
+		return rt.path
+	}
+	return UnmatchedRoute
+}
+
+// match returns the route of the longest binding path that is path or one
+// of its parents
+func (g *Gateway) match(path string) *route {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	for p := cleanPath(path); ; {
+		if rt, ok := g.paths[p]; ok {
+			return rt
+		}
+		i := strings.LastIndex(p, "/")
+		if i < 0 || p == "/" {
+			return nil
+		}
+		if p = p[:i]; p == "" {
+			p = "/"
+		}
+	}
+}
+
+// ServeHTTP implements http.Handler
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rt := g.match(r.URL.Path)
+	if rt == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if !rt.allows(r.Method) {
+		w.Header().Set("Allow", strings.Join(rt.methods, ", "))
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if rt.websocket {
+		g.serveWebSocket(w, r, rt)
+		return
+	}
+
+	start := g.now()
+	rt.stats.begin()
+	defer func() { rt.stats.end(g.now().Sub(start)) }()
+
+	ctx := tracing.Extract(r.Context(), r.Header)
+	if rt.requestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, rt.requestTimeout, ErrRequestTimeout)
+		defer cancel()
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	rw := &responseWriter{ResponseWriter: w}
+	defer rw.stop()
+	if rt.idleTimeout > 0 {
+		rw.idleTimeout = rt.idleTimeout
+		rw.idle = time.AfterFunc(rt.idleTimeout, func() { cancel(ErrIdleTimeout) })
+	}
+	proxy := g.proxy
+	if rt.streaming {
+		proxy = g.streamProxy
+		rw.heartbeatInterval = g.HeartbeatInterval
+	}
+	defer func() {
+		// The proxy aborts the response when its client goes away mid-stream,
+		// which leaves nothing to report but the cancellation the metrics
+		// middleware records from the failed write
+		if p := recover(); p != nil {
+			if p != http.ErrAbortHandler || (r.Context().Err() == nil && rw.writeErr() == nil) {
+				panic(p)
+			}
+		}
+	}()
+
+	charged, err := g.admit(rt.pool, expectedTokens(r.Header.Get(ExpectedTokensHeader), 0))
+	if err != nil {
+		g.fail(ctx, rw, rt, err)
+		return
+	}
+	target, rep, err := g.pick(ctx, rt.pool, r.Header.Get(SessionHeader))
+	if err != nil {
+		g.fail(ctx, rw, rt, err)
+		return
+	}
+	served := turn{pool: target, tenant: r.Header.Get(TenantHeader), session: r.Header.Get(SessionHeader)}
+
+	ctx = context.WithValue(ctx, targetKey{}, &url.URL{Scheme: "http", Host: net.JoinHostPort(rep.Address, strconv.Itoa(g.Port))})
+	ctx = context.WithValue(ctx, routeKey{}, rt)
+	var res *http.Response
+	ctx = context.WithValue(ctx, responseKey{}, &res)
+	out := r.Clone(ctx)
+	if rt.toolTimeout > 0 {
+		out.Header.Set(ToolTimeoutHeader, rt.toolTimeout.String())
+	}
+	if rt.streaming && out.Header.Get("Accept") == "" {
+		out.Header.Set("Accept", eventStream)
+	}
+	proxy.ServeHTTP(rw, out)
+	if res != nil {
+		if used, ok := responseUsage(res); ok {
+			g.complete(charged, served, used)
+		}
+		g.evaluate(ctx, served, res)
+	}
+}
+
+// pick returns the replica for a request to p, and the pool it picked it
+// from: p, or the canary of p for the share of sessions routed to it
+func (g *Gateway) pick(ctx context.Context, p *pool, sessionKey string) (*pool, *router.Replica, error) {
+	g.mu.RLock()
+	if p.canary != nil {
+		key := sessionKey
+		if key == "" {
+			// Requests without a session are split by chance
+			key = strconv.FormatInt(rand.Int63(), 36)
+		}
+		if p.splitter.Route(key) == p.canary.key.Name {
+			p = p.canary
+		}
+	}
+	agentPool := p.pool
+	g.mu.RUnlock()
+
+	var rep *router.Replica
+	var err error
+	if g.activator != nil {
+		rep, err = g.activator.Route(ctx, agentPool, p.router, sessionKey)
+	} else {
+		rep, err = p.router.Route(sessionKey)
+	}
+	return p, rep, err
+}
+
+// admit charges tokens, the estimate of a request, against the budget of
+// p, if it has one, or returns router.ErrBudgetExhausted. Estimates beyond
+// the budget are charged the whole budget, the rest is taken when the
+// charge is settled.
+func (g *Gateway) admit(p *pool, tokens int) (charge, error) {
+	g.mu.RLock()
+	budget := p.budget
+	g.mu.RUnlock()
+
+	if budget == nil {
+		return charge{}, nil
+	}
+	tokens = min(tokens, budget.Rate())
+	if err := budget.Admit(tokens); err != nil {
+		return charge{}, err
+	}
+	return charge{budget: budget, tokens: tokens}, nil
+}
+
+// complete settles the charge of a request served for t and accounts the
+// usage its replica reported
+func (g *Gateway) complete(charged charge, t turn, used usage) {
+	charged.settle(used)
+	if g.Ledger == nil && g.Costs == nil {
+		return
+	}
+	g.mu.RLock()
+	model := t.pool.model
+	g.mu.RUnlock()
+	if g.Ledger != nil {
+		g.Ledger.Record(t.tenant, model, int64(used.input), int64(used.output))
+	}
+	if g.Costs != nil {
+		g.Costs.RecordTokens(t.pool.key, t.tenant, t.session, model, int64(used.input), int64(used.output))
+	}
+}
+
+// evaluate submits the turn of the TurnHeader of res, if any, for
+// evaluation. Turns default to the model of the pool that served them.
+func (g *Gateway) evaluate(ctx context.Context, t turn, res *http.Response) {
+	if g.Evaluations == nil {
+		return
+	}
+	value := res.Trailer.Get(TurnHeader)
+	if value == "" {
+		value = res.Header.Get(TurnHeader)
+	}
+	if value == "" {
+		return
+	}
+	evaluated, err := responseTurn(value)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "ignoring invalid turn", "pool", t.pool.key)
+		return
+	}
+	if evaluated.Model == "" {
+		g.mu.RLock()
+		evaluated.Model = t.pool.model
+		g.mu.RUnlock()
+	}
+	g.Evaluations.Submit(evaluated)
+}
+
+// responseTurn decodes the value of a TurnHeader
+func responseTurn(value string) (evaluation.Turn, error) {
+	var t evaluation.Turn
+	data, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return t, fmt.Errorf("failed to decode %s: %w", TurnHeader, err)
+	}
+	if err := json.Unmarshal(data, &t); err != nil {
+		return t, fmt.Errorf("failed to parse %s: %w", TurnHeader, err)
+	}
+	return t, nil
+}
+
+// turn is who a request was served for
+type turn struct {
+	// pool is the pool of the replica that served the request, the canary
+	// of the pool of its binding or the pool itself
+	pool    *pool
+	tenant  string
+	session string
+}
+
+// charge is what a request was charged against the budget of its pool up
+// front
+type charge struct {
+	budget *router.TokenBudget
+	tokens int
+}
+
+// settle trues the charge up to the usage the request reported
+func (c charge) settle(used usage) {
+	if c.budget != nil {
+		c.budget.Settle(c.tokens, used.input+used.output)
+	}
+}
+
+// usage is the tokens a request consumed, as its replica reported them
+type usage struct {
+	input  int
+	output int
+}
+
+// expectedTokens returns the tokens a request generating up to maxTokens is
+// charged up front: maxTokens, at least DefaultExpectedTokens, raised to
+// the ExpectedTokensHeader value if higher. Clients cannot lower the
+// estimate, which is trued up to the usage the replica reports.
+func expectedTokens(value string, maxTokens int) int {
+	tokens := max(maxTokens, DefaultExpectedTokens)
+	if n, err := strconv.Atoi(value); err == nil && n > tokens {
+		tokens = n
+	}
+	return tokens
+}
+
+// responseUsage returns the usage of the InputTokensHeader and
+// OutputTokensHeader trailers of res, or of its headers, or false if the
+// replica reported none
+func responseUsage(res *http.Response) (usage, bool) {
+	for _, header := range []http.Header{res.Trailer, res.Header} {
+		input, inputOK := tokenCount(header.Get(InputTokensHeader))
+		output, outputOK := tokenCount(header.Get(OutputTokensHeader))
+		if inputOK || outputOK {
+			return usage{input: input, output: output}, true
+		}
+	}
+	return usage{}, false
+}
+
+// tokenCount parses a count of tokens, or returns false if value is not one
+func tokenCount(value string) (int, bool) {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return n, true
+}
+
+// fail responds to a request the gateway could not complete and records err
+// as the last error of its binding
+func (g *Gateway) fail(ctx context.Context, w http.ResponseWriter, rt *route, err error) {
+	status := http.StatusBadGateway
+	cause := context.Cause(ctx)
+	switch {
+	case errors.Is(cause, ErrRequestTimeout), errors.Is(cause, ErrIdleTimeout):
+		status = http.StatusGatewayTimeout
+		err = cause
+	case ctx.Err() != nil:
+		// The client went away, nobody reads the response
+		return
+	case errors.Is(err, router.ErrNoReplicas), errors.Is(err, router.ErrAllBackpressured),
+		errors.Is(err, activator.ErrActivationTimeout), errors.Is(err, ErrReplicasFull):
+		status = http.StatusServiceUnavailable
+		w.Header().Set("Retry-After", "1")
+	case errors.Is(err, router.ErrBudgetExhausted):
+		status = http.StatusTooManyRequests
+		w.Header().Set("Retry-After", "1")
+	}
+	rt.stats.setError(err)
+	http.Error(w, err.Error(), status)
+}
+
+// allows reports whether the binding accepts method, any if it lists none
+func (rt *route) allows(method string) bool {
+	if len(rt.methods) == 0 {
+		return true
+	}
+	for _, m := range rt.methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// Stats is the traffic of a binding since the previous call to Stats
+type Stats struct {
+	// ActiveConnections is the number of requests in flight
+	ActiveConnections int32
+
+	// RequestsPerSecond is the rate of completed requests, including
+	// closed WebSocket connections
+	RequestsPerSecond float64
+
+	// AverageLatency is the average duration of completed requests, which
+	// WebSocket connections do not count towards
+	AverageLatency time.Duration
+
+	// LastError is the last error the gateway responded with
+	LastError string
+}
+
+// Stats returns the traffic of the binding, or false if it is not served
+func (g *Gateway) Stats(key types.NamespacedName) (Stats, bool) {
+	g.mu.RLock()
+	rt, ok := g.bindings[key]
+	g.mu.RUnlock()
+	if !ok {
+		return Stats{}, false
+	}
+	return rt.stats.sample(g.now()), true
+}
+
+// stats counts the traffic of a binding between samples
+type stats struct {
+	active atomic.Int32
+
+	mu        sync.Mutex
+	requests  int64
+	timed     int64
+	latency   time.Duration
+	lastError string
+	sampled   time.Time
+}
+
+func (s *stats) begin() {
+	s.active.Add(1)
+}
+
+func (s *stats) end(latency time.Duration) {
+	s.active.Add(-1)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests++
+	s.timed++
+	s.latency += latency
+}
+
+// endConnection ends a WebSocket connection, whose duration is not a
+// latency
+func (s *stats) endConnection() {
+	s.active.Add(-1)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests++
+}
+
+func (s *stats) setError(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastError = err.Error()
+}
+
+// sample returns the stats since the previous sample and starts a new one
+func (s *stats) sample(now time.Time) Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := Stats{ActiveConnections: s.active.Load(), LastError: s.lastError}
+	if window := now.Sub(s.sampled); window > 0 {
+		out.RequestsPerSecond = float64(s.requests) / window.Seconds()
+	}
+	if s.timed > 0 {
+		out.AverageLatency = s.latency / time.Duration(s.timed)
+	}
+	s.requests = 0
+	s.timed = 0
+	s.latency = 0
+	s.sampled = now
+	return out
+}
+
+// cleanPath drops the trailing slash of path, so that /tools/ and /tools
+// are the same binding path
+func cleanPath(path string) string {
+	if len(path) > 1 {
+		return strings.TrimSuffix(path, "/")
+	}
+	return path
+}
+
+// duration returns d, or zero if unset
+func duration(d *metav1.Duration) time.Duration {
+	if d == nil {
+		return 0
+	}
+	return d.Duration
+}
+
+// limit returns n, or zero (unlimited) if unset
+func limit(n *int32) int {
+	if n == nil || *n < 0 {
+		return 0
+	}
+	return int(*n)
+}
@@ -0,0 +1,414 @@
+package gateway
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/accounting"
+	"github.com/bowenislandsong/neuronetes/pkg/evaluation"
+	"github.com/bowenislandsong/neuronetes/pkg/metrics"
+	"github.com/bowenislandsong/neuronetes/pkg/router"
+)
+
+func newTestBinding(name, path string) *neuronetes.ToolBinding {
+	return &neuronetes.ToolBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec: neuronetes.ToolBindingSpec{
+			AgentPoolRef: neuronetes.AgentPoolReference{Name: "chat-pool"},
+			Type:         neuronetes.ToolBindingHTTP,
+			HTTPConfig:   &neuronetes.HTTPConfig{Path: path},
+		},
+	}
+}
+
+func newTestPool() *neuronetes.AgentPool {
+	return &neuronetes.AgentPool{ObjectMeta: metav1.ObjectMeta{Name: "chat-pool", Namespace: "default"}}
+}
+
+// newTestGateway returns a gateway whose replicas are served by handler
+func newTestGateway(t *testing.T, handler http.HandlerFunc) (*Gateway, router.Replica) {
+	t.Helper()
+	backend := httptest.NewServer(handler)
+	t.Cleanup(backend.Close)
+	u, err := url.Parse(backend.URL)
+	require.NoError(t, err)
+	port, err := strconv.Atoi(u.Port())
+	require.NoError(t, err)
+
+	g := NewGateway(nil, nil)
+	g.Port = port
+	return g, router.Replica{Name: "agent-0", Address: u.Hostname(), Ready: true}
+}
+
+func TestGatewayRoutesBindingPathToReplicas(t *testing.T) {
+	var got *http.Request
+	g, rep := newTestGateway(t, func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		_, _ = io.WriteString(w, "ok")
+	})
+	binding := newTestBinding("search", "/tools/search/")
+	binding.Spec.HTTPConfig.Methods = []string{"post"}
+	binding.Spec.Timeouts = &neuronetes.TimeoutConfig{ToolTimeout: &metav1.Duration{Duration: 30 * time.Second}}
+	require.NoError(t, g.Serve(binding, newTestPool(), []router.Replica{rep}))
+
+	// Subpaths of the binding path are routed with their path
+	w := httptest.NewRecorder()
+	g.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/tools/search/web?q=go", strings.NewReader("{}")))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "ok", w.Body.String())
+	require.NotNil(t, got)
+	assert.Equal(t, "/tools/search/web", got.URL.Path)
+	assert.Equal(t, "q=go", got.URL.RawQuery)
+	assert.Equal(t, "30s", got.Header.Get(ToolTimeoutHeader))
+	assert.Equal(t, "/tools/search", g.Route(httptest.NewRequest(http.MethodPost, "/tools/search/web", nil)))
+
+	w = httptest.NewRecorder()
+	g.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/tools/search", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	assert.Equal(t, "POST", w.Header().Get("Allow"))
+
+	w = httptest.NewRecorder()
+	g.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/tools/searches", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, UnmatchedRoute, g.Route(httptest.NewRequest(http.MethodPost, "/tools/searches", nil)))
+
+	// Only routed requests count towards the traffic of the binding
+	key := types.NamespacedName{Namespace: "default", Name: "search"}
+	now := time.Now()
+	g.now = func() time.Time { return now.Add(500 * time.Millisecond) }
+	g.bindings[key].stats.sampled = now
+	stats, ok := g.Stats(key)
+	require.True(t, ok)
+	assert.Equal(t, int32(0), stats.ActiveConnections)
+	assert.Equal(t, 2.0, stats.RequestsPerSecond)
+	assert.Empty(t, stats.LastError)
+
+	g.Remove(key)
+	_, ok = g.Stats(key)
+	assert.False(t, ok)
+	assert.Empty(t, g.pools)
+	w = httptest.NewRecorder()
+	g.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/tools/search", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestGatewayEnforcesTimeouts(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	g, rep := newTestGateway(t, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	})
+	request := newTestBinding("slow", "/slow")
+	request.Spec.Timeouts = &neuronetes.TimeoutConfig{RequestTimeout: &metav1.Duration{Duration: 20 * time.Millisecond}}
+	require.NoError(t, g.Serve(request, newTestPool(), []router.Replica{rep}))
+	idle := newTestBinding("idle", "/idle")
+	idle.Spec.Timeouts = &neuronetes.TimeoutConfig{IdleTimeout: &metav1.Duration{Duration: 20 * time.Millisecond}}
+	require.NoError(t, g.Serve(idle, newTestPool(), []router.Replica{rep}))
+
+	w := httptest.NewRecorder()
+	g.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/slow", nil))
+	assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+	stats, _ := g.Stats(types.NamespacedName{Namespace: "default", Name: "slow"})
+	assert.Equal(t, ErrRequestTimeout.Error(), stats.LastError)
+
+	w = httptest.NewRecorder()
+	g.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/idle", nil))
+	assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+	stats, _ = g.Stats(types.NamespacedName{Namespace: "default", Name: "idle"})
+	assert.Equal(t, ErrIdleTimeout.Error(), stats.LastError)
+}
+
+func TestGatewayWithoutReadyReplicas(t *testing.T) {
+	g, rep := newTestGateway(t, func(w http.ResponseWriter, r *http.Request) {})
+	rep.Ready = false
+	require.NoError(t, g.Serve(newTestBinding("search", "/search"), newTestPool(), []router.Replica{rep}))
+
+	w := httptest.NewRecorder()
+	g.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/search", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+
+	// Replicas missing from an update stop receiving requests
+	rep.Ready = true
+	require.NoError(t, g.Serve(newTestBinding("search", "/search"), newTestPool(), []router.Replica{rep}))
+	w = httptest.NewRecorder()
+	g.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/search", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, g.Serve(newTestBinding("search", "/search"), newTestPool(), nil))
+	w = httptest.NewRecorder()
+	g.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/search", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
+
+func TestGatewayEnforcesTokenBudget(t *testing.T) {
+	g, rep := newTestGateway(t, func(w http.ResponseWriter, r *http.Request) {})
+	m := metrics.NewAgentMetrics(prometheus.NewRegistry())
+	g.metrics = m
+	agentPool := newTestPool()
+	budget := int32(100)
+	agentPool.Spec.TokensPerSecondBudget = &budget
+	require.NoError(t, g.Serve(newTestBinding("search", "/search"), agentPool, []router.Replica{rep}))
+
+	request := func(tokens string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/search", nil)
+		r.Header.Set(ExpectedTokensHeader, tokens)
+		return r
+	}
+	w := httptest.NewRecorder()
+	g.ServeHTTP(w, request("80"))
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = httptest.NewRecorder()
+	g.ServeHTTP(w, request("80"))
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.AdmissionRejects))
+	stats, _ := g.Stats(types.NamespacedName{Namespace: "default", Name: "search"})
+	assert.Equal(t, router.ErrBudgetExhausted.Error(), stats.LastError)
+
+	// Updates keep the budget unless it changes, then it starts full
+	require.NoError(t, g.Serve(newTestBinding("search", "/search"), agentPool, []router.Replica{rep}))
+	w = httptest.NewRecorder()
+	g.ServeHTTP(w, request("80"))
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	budget = 1000
+	require.NoError(t, g.Serve(newTestBinding("search", "/search"), agentPool, []router.Replica{rep}))
+	w = httptest.NewRecorder()
+	g.ServeHTTP(w, request("800"))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestGatewayChargesTokenEstimatesAndUsage(t *testing.T) {
+	// The replica reports the usage it is asked to as a trailer
+	g, rep := newTestGateway(t, func(w http.ResponseWriter, r *http.Request) {
+		if used := r.URL.Query().Get("used"); used != "" {
+			w.Header().Set("Trailer", OutputTokensHeader)
+			_, _ = io.WriteString(w, "ok")
+			w.Header().Set(OutputTokensHeader, used)
+		}
+	})
+	agentPool := newTestPool()
+	budget := int32(300)
+	agentPool.Spec.TokensPerSecondBudget = &budget
+	require.NoError(t, g.Serve(newTestBinding("search", "/search"), agentPool, []router.Replica{rep}))
+	request := func(tokens, used string) int {
+		r := httptest.NewRequest(http.MethodGet, "/search?used="+used, nil)
+		if tokens != "" {
+			r.Header.Set(ExpectedTokensHeader, tokens)
+		}
+		w := httptest.NewRecorder()
+		g.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	// Requests without or understating their tokens are charged the default
+	assert.Equal(t, http.StatusOK, request("", ""))
+	assert.Equal(t, http.StatusTooManyRequests, request("1", ""))
+
+	// The charge is trued up to the usage the replica reports
+	budget = 1000
+	require.NoError(t, g.Serve(newTestBinding("search", "/search"), agentPool, []router.Replica{rep}))
+	assert.Equal(t, http.StatusOK, request("1", "900"))
+	assert.InDelta(t, 100, g.pools[types.NamespacedName{Namespace: "default", Name: "chat-pool"}].budget.Available(), 10)
+	assert.Equal(t, http.StatusTooManyRequests, request("", ""))
+}
+
+// recordingLedger keeps the tokens recorded by tenant and model
+type recordingLedger struct {
+	mu     sync.Mutex
+	tokens map[string]int64
+}
+
+func (l *recordingLedger) Record(tenant, model string, inputTokens, outputTokens int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.tokens == nil {
+		l.tokens = make(map[string]int64)
+	}
+	l.tokens[tenant+"/"+model] += inputTokens + outputTokens
+}
+
+func (l *recordingLedger) get(tenant, model string) int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.tokens[tenant+"/"+model]
+}
+
+func TestGatewayAccountsUsageToTenants(t *testing.T) {
+	g, rep := newTestGateway(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(InputTokensHeader, "100")
+		w.Header().Set(OutputTokensHeader, "20")
+	})
+	ledger := &recordingLedger{}
+	g.Ledger = ledger
+	costs := &accounting.CostEngine{}
+	g.Costs = costs
+	require.NoError(t, g.Serve(newTestBinding("search", "/search"), newTestPool(), []router.Replica{rep}))
+	g.SetClass(types.NamespacedName{Namespace: "default", Name: "chat-pool"}, newTestClass("chat", "llama-3-8b"))
+
+	r := httptest.NewRequest(http.MethodGet, "/search", nil)
+	r.Header.Set(TenantHeader, "acme")
+	r.Header.Set(SessionHeader, "session-1")
+	g.ServeHTTP(httptest.NewRecorder(), r)
+	g.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/search", nil))
+	assert.Equal(t, int64(120), ledger.get("acme", "llama-3-8b"))
+	assert.Equal(t, int64(120), ledger.get("", "llama-3-8b"))
+
+	// The cost of the pool is attributed to the tenants and sessions it
+	// served
+	report := costs.Report("acme")
+	require.Len(t, report.Tenants, 1)
+	assert.Equal(t, accounting.TenantCost{Tenant: "acme", Model: "llama-3-8b", InputTokens: 100, OutputTokens: 20}, report.Tenants[0])
+	require.Len(t, report.Sessions, 1)
+	assert.Equal(t, "session-1", report.Sessions[0].Session)
+	assert.Equal(t, int64(120), report.Sessions[0].Tokens)
+}
+
+type recordingSubmitter struct {
+	mu    sync.Mutex
+	turns []evaluation.Turn
+}
+
+func (s *recordingSubmitter) Submit(turn evaluation.Turn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.turns = append(s.turns, turn)
+	return true
+}
+
+func TestGatewaySubmitsTurnsForEvaluation(t *testing.T) {
+	reported := evaluation.Turn{
+		ID:        "turn-1",
+		Query:     "who maintains the gateway",
+		Retrieved: []evaluation.Document{{ID: "owners", Content: "the platform team maintains the gateway"}},
+		Answer:    "The platform team maintains the gateway.",
+	}
+	data, err := json.Marshal(reported)
+	require.NoError(t, err)
+	g, rep := newTestGateway(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Trailer", TurnHeader)
+		_, _ = io.WriteString(w, "answer")
+		switch r.URL.Query().Get("turn") {
+		case "valid":
+			w.Header().Set(TurnHeader, base64.StdEncoding.EncodeToString(data))
+		case "invalid":
+			w.Header().Set(TurnHeader, "not base64")
+		}
+	})
+	submitted := &recordingSubmitter{}
+	g.Evaluations = submitted
+	require.NoError(t, g.Serve(newTestBinding("search", "/search"), newTestPool(), []router.Replica{rep}))
+	g.SetClass(types.NamespacedName{Namespace: "default", Name: "chat-pool"}, newTestClass("chat", "llama-3-8b"))
+
+	for _, query := range []string{"valid", "invalid", ""} {
+		w := httptest.NewRecorder()
+		g.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/search?turn="+query, nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+
+	// Only the reported turn is submitted, served by the model of its pool
+	reported.Model = "llama-3-8b"
+	assert.Equal(t, []evaluation.Turn{reported}, submitted.turns)
+}
+
+func TestGatewayMigratesSessionsOffDrainingReplicas(t *testing.T) {
+	g := NewGateway(nil, nil)
+	key := types.NamespacedName{Namespace: "default", Name: "chat-pool"}
+	replicas := []router.Replica{{Name: "agent-0", Ready: true}, {Name: "agent-1", Ready: true}}
+	require.NoError(t, g.Serve(newTestBinding("search", "/search"), newTestPool(), replicas))
+	sessions := g.pools[key].router
+	rep, err := sessions.Route("session-1")
+	require.NoError(t, err)
+
+	// Without externalized memory sessions finish on their draining replica
+	for i := range replicas {
+		replicas[i].Draining = replicas[i].Name == rep.Name
+	}
+	require.NoError(t, g.Serve(newTestBinding("search", "/search"), newTestPool(), replicas))
+	g.SetClass(key, newTestClass("chat", "llama-3-8b"))
+	assert.Equal(t, 1, sessions.ActiveSessions()[rep.Name])
+
+	// With it they move to the other replicas
+	class := newTestClass("chat", "llama-3-8b")
+	class.Spec.MemoryConfig = &neuronetes.MemoryConfig{Type: "redis"}
+	g.SetClass(key, class)
+	assert.Equal(t, 0, sessions.ActiveSessions()[rep.Name])
+	moved, err := sessions.Route("session-1")
+	require.NoError(t, err)
+	assert.NotEqual(t, rep.Name, moved.Name)
+}
+
+func TestGatewaySplitsTrafficToCanaries(t *testing.T) {
+	g, rep := newTestGateway(t, func(w http.ResponseWriter, r *http.Request) {})
+	rep.Name = "chat-pool-canary-0"
+	agentPool := newTestPool()
+	agentPool.Annotations = map[string]string{
+		neuronetes.AnnotationCanary:       "chat-pool-canary",
+		neuronetes.AnnotationCanaryWeight: "100",
+	}
+	canaryPool := &neuronetes.AgentPool{ObjectMeta: metav1.ObjectMeta{Name: "chat-pool-canary", Namespace: "default"}}
+	poolKey := types.NamespacedName{Namespace: "default", Name: "chat-pool"}
+
+	// The pool has no replicas, only its canary can serve
+	require.NoError(t, g.Serve(newTestBinding("search", "/search"), agentPool, nil))
+	request := func(session string) int {
+		r := httptest.NewRequest(http.MethodGet, "/search", nil)
+		r.Header.Set(SessionHeader, session)
+		w := httptest.NewRecorder()
+		g.ServeHTTP(w, r)
+		return w.Code
+	}
+	assert.Equal(t, http.StatusServiceUnavailable, request("a"))
+	g.Split(poolKey, canaryPool, []router.Replica{rep})
+	for _, session := range []string{"a", "b", "c", ""} {
+		assert.Equal(t, http.StatusOK, request(session), "session %q", session)
+	}
+
+	// Sessions the weight leaves on the pool stay there
+	agentPool.Annotations[neuronetes.AnnotationCanaryWeight] = "0"
+	require.NoError(t, g.Serve(newTestBinding("search", "/search"), agentPool, nil))
+	assert.Equal(t, http.StatusServiceUnavailable, request("a"))
+
+	// Promoted or rolled back canaries stop receiving traffic
+	agentPool.Annotations[neuronetes.AnnotationCanaryWeight] = "100"
+	require.NoError(t, g.Serve(newTestBinding("search", "/search"), agentPool, nil))
+	assert.Equal(t, http.StatusOK, request("a"))
+	delete(agentPool.Annotations, neuronetes.AnnotationCanary)
+	require.NoError(t, g.Serve(newTestBinding("search", "/search"), agentPool, nil))
+	assert.Equal(t, http.StatusServiceUnavailable, request("a"))
+}
+
+func TestGatewayRejectsInvalidBindings(t *testing.T) {
+	g := NewGateway(nil, nil)
+	require.NoError(t, g.Serve(newTestBinding("search", "/search"), newTestPool(), nil))
+
+	err := g.Serve(newTestBinding("other", "/search/"), newTestPool(), nil)
+	assert.ErrorContains(t, err, "served by ToolBinding default/search")
+	assert.ErrorContains(t, g.Serve(newTestBinding("relative", "search"), newTestPool(), nil), "must start with /")
+	noConfig := newTestBinding("none", "/none")
+	noConfig.Spec.HTTPConfig = nil
+	assert.Error(t, g.Serve(noConfig, newTestPool(), nil))
+
+	// Moving a binding frees its previous path
+	require.NoError(t, g.Serve(newTestBinding("search", "/find"), newTestPool(), nil))
+	require.NoError(t, g.Serve(newTestBinding("other", "/search"), newTestPool(), nil))
+}
@@ -0,0 +1,397 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+
+	inferencev1 "github.com/bowenislandsong/neuronetes/api/inference/v1"
+	"github.com/bowenislandsong/neuronetes/pkg/activator"
+	"github.com/bowenislandsong/neuronetes/pkg/router"
+	"github.com/bowenislandsong/neuronetes/pkg/tracing"
+)
+
+// ErrToolTimeout is the cause of tool calls cancelled after the toolTimeout
+// of their binding
+var ErrToolTimeout = errors.New("tool call timed out")
+
+// callMetadata are the incoming metadata keys that are not forwarded to
+// replicas, since the connection to the replica sets its own
+var callMetadata = map[string]bool{
+	":authority":   true,
+	"content-type": true,
+	"user-agent":   true,
+	"te":           true,
+	"grpc-timeout": true,
+}
+
+// GRPCServer serves the gRPC ToolBindings of a Gateway, along with gRPC
+// health checking and server reflection. Calls are routed to the binding
+// whose authority they are made on, or the binding without an authority.
+type GRPCServer struct {
+	// Addr is the address to listen on
+	Addr string
+
+	// Gateway routes the calls
+	Gateway *Gateway
+
+	// Options are passed to the gRPC server, e.g. for interceptors
+	Options []grpc.ServerOption
+}
+
+// Start serves until ctx is cancelled. It implements manager.Runnable.
+func (s *GRPCServer) Start(ctx context.Context) error {
+	lis, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	return s.serve(ctx, lis)
+}
+
+func (s *GRPCServer) serve(ctx context.Context, lis net.Listener) error {
+	srv := grpc.NewServer(s.Options...)
+	inferencev1.RegisterInferenceServer(srv, &inferenceServer{g: s.Gateway})
+	hs := health.NewServer()
+	hs.SetServingStatus(inferencev1.Inference_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(srv, hs)
+	reflection.Register(srv)
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.Serve(lis)
+	}()
+
+	select {
+	case <-ctx.Done():
+		// Health checks fail while the calls in flight complete
+		hs.Shutdown()
+		stopped := make(chan struct{})
+		go func() {
+			srv.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-time.After(shutdownTimeout):
+			srv.Stop()
+		}
+		return nil
+	case err := <-errCh:
+		return err
+	}
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable, so that
+// every gateway replica serves
+func (s *GRPCServer) NeedLeaderElection() bool {
+	return false
+}
+
+// inferenceServer forwards the calls of the Inference service to replicas
+type inferenceServer struct {
+	inferencev1.UnimplementedInferenceServer
+	g *Gateway
+}
+
+// Infer implements inferencev1.InferenceServer
+func (s *inferenceServer) Infer(ctx context.Context, req *inferencev1.InferRequest) (*inferencev1.InferResponse, error) {
+	var res *inferencev1.InferResponse
+	err := s.g.call(ctx, req.GetSessionId(), int(req.GetMaxTokens()), false, func(ctx context.Context, client inferencev1.InferenceClient) (*inferencev1.Usage, error) {
+		var err error
+		res, err = client.Infer(ctx, req)
+		return res.GetUsage(), err
+	})
+	return res, err
+}
+
+// CallTool implements inferencev1.InferenceServer
+func (s *inferenceServer) CallTool(ctx context.Context, req *inferencev1.ToolRequest) (*inferencev1.ToolResponse, error) {
+	var res *inferencev1.ToolResponse
+	err := s.g.call(ctx, req.GetSessionId(), 0, true, func(ctx context.Context, client inferencev1.InferenceClient) (*inferencev1.Usage, error) {
+		var err error
+		res, err = client.CallTool(ctx, req)
+		return nil, err
+	})
+	return res, err
+}
+
+// StreamInfer implements inferencev1.InferenceServer. The idleTimeout of
+// the binding bounds the wait for each chunk rather than the call.
+func (s *inferenceServer) StreamInfer(req *inferencev1.InferRequest, stream inferencev1.Inference_StreamInferServer) error {
+	g := s.g
+	ctx := stream.Context()
+	rt, err := g.matchAuthority(ctx)
+	if err != nil {
+		return err
+	}
+	start := g.now()
+	rt.stats.begin()
+	defer func() { rt.stats.end(g.now().Sub(start)) }()
+
+	ctx, cancel := rt.grpcContext(ctx, false)
+	defer cancel()
+	ctx, cancelCause := context.WithCancelCause(ctx)
+	defer cancelCause(nil)
+	var idle *time.Timer
+	if rt.idleTimeout > 0 {
+		idle = time.AfterFunc(rt.idleTimeout, func() { cancelCause(ErrIdleTimeout) })
+		defer idle.Stop()
+	}
+
+	charged, err := g.admit(rt.pool, callTokens(ctx, int(req.GetMaxTokens())))
+	if err != nil {
+		return g.grpcFail(ctx, rt, err)
+	}
+	client, target, err := g.replicaClient(ctx, rt, req.GetSessionId())
+	if err != nil {
+		return g.grpcFail(ctx, rt, err)
+	}
+	served := turn{pool: target, tenant: incomingValue(ctx, TenantHeader), session: req.GetSessionId()}
+	chunks, err := client.StreamInfer(ctx, req)
+	if err != nil {
+		return g.grpcFail(ctx, rt, err)
+	}
+	for {
+		chunk, err := chunks.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if used, ok := callUsage(chunk.GetUsage()); ok {
+			// Usage comes with the last chunk
+			g.complete(charged, served, used)
+		}
+		if err != nil {
+			return g.grpcFail(ctx, rt, err)
+		}
+		if idle != nil {
+			idle.Reset(rt.idleTimeout)
+		}
+		if err := stream.Send(chunk); err != nil {
+			// The client went away, which cancels the call to the replica
+			return err
+		}
+	}
+}
+
+// call forwards a unary call of session to a replica of the binding the
+// call was made on. Calls generating up to maxTokens are charged against
+// the budget of the pool, trued up to the usage invoke returns, while tool
+// calls, which generate none, are bounded by the toolTimeout of the
+// binding.
+func (g *Gateway) call(ctx context.Context, session string, maxTokens int, tool bool, invoke func(context.Context, inferencev1.InferenceClient) (*inferencev1.Usage, error)) error {
+	rt, err := g.matchAuthority(ctx)
+	if err != nil {
+		return err
+	}
+	start := g.now()
+	rt.stats.begin()
+	defer func() { rt.stats.end(g.now().Sub(start)) }()
+
+	ctx, cancel := rt.grpcContext(ctx, tool)
+	defer cancel()
+	if rt.idleTimeout > 0 {
+		// Unary calls are idle until the response arrives
+		var cancelIdle context.CancelFunc
+		ctx, cancelIdle = context.WithTimeoutCause(ctx, rt.idleTimeout, ErrIdleTimeout)
+		defer cancelIdle()
+	}
+
+	var charged charge
+	if !tool {
+		if charged, err = g.admit(rt.pool, callTokens(ctx, maxTokens)); err != nil {
+			return g.grpcFail(ctx, rt, err)
+		}
+	}
+	client, target, err := g.replicaClient(ctx, rt, session)
+	if err != nil {
+		return g.grpcFail(ctx, rt, err)
+	}
+	reported, err := invoke(ctx, client)
+	if err != nil {
+		return g.grpcFail(ctx, rt, err)
+	}
+	if used, ok := callUsage(reported); ok {
+		g.complete(charged, turn{pool: target, tenant: incomingValue(ctx, TenantHeader), session: session}, used)
+	}
+	return nil
+}
+
+// matchAuthority returns the route of the binding the call of ctx was made
+// on: the binding of its authority, or of its host without the port, or
+// the binding without an authority
+func (g *Gateway) matchAuthority(ctx context.Context) (*route, error) {
+	var authority string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(":authority"); len(values) > 0 {
+			authority = strings.ToLower(values[0])
+		}
+	}
+
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	if rt, ok := g.authorities[authority]; ok {
+		return rt, nil
+	}
+	if host, _, err := net.SplitHostPort(authority); err == nil {
+		if rt, ok := g.authorities[host]; ok {
+			return rt, nil
+		}
+	}
+	if rt, ok := g.authorities[""]; ok {
+		return rt, nil
+	}
+	return nil, status.Errorf(codes.NotFound, "no ToolBinding serves authority %q", authority)
+}
+
+// callTokens returns the tokens a call generating up to maxTokens is
+// charged up front, see expectedTokens
+func callTokens(ctx context.Context, maxTokens int) int {
+	return expectedTokens(incomingValue(ctx, ExpectedTokensHeader), maxTokens)
+}
+
+// incomingValue returns the first value of the metadata key of the call of
+// ctx. Metadata keys are lower case.
+func incomingValue(ctx context.Context, key string) string {
+	if values := metadata.ValueFromIncomingContext(ctx, strings.ToLower(key)); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// callUsage returns the usage a replica reported for a call, or false if it
+// reported none
+func callUsage(reported *inferencev1.Usage) (usage, bool) {
+	if reported == nil {
+		return usage{}, false
+	}
+	return usage{input: int(reported.GetInputTokens()), output: int(reported.GetOutputTokens())}, true
+}
+
+// grpcContext returns the context of a call forwarded on rt: bounded by the
+// requestTimeout, and the toolTimeout for tool calls, carrying the trace and
+// the metadata of the incoming call. gRPC passes the deadline on to the
+// replica.
+func (rt *route) grpcContext(ctx context.Context, tool bool) (context.Context, context.CancelFunc) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	out := make(metadata.MD, len(md))
+	header := make(http.Header, len(md))
+	for key, values := range md {
+		if callMetadata[key] {
+			continue
+		}
+		out[key] = values
+		for _, value := range values {
+			header.Add(key, value)
+		}
+	}
+	if rt.toolTimeout > 0 {
+		out.Set(ToolTimeoutHeader, rt.toolTimeout.String())
+	}
+	ctx = metadata.NewOutgoingContext(tracing.Extract(ctx, header), out)
+
+	var cancels []context.CancelFunc
+	if rt.requestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, rt.requestTimeout, ErrRequestTimeout)
+		cancels = append(cancels, cancel)
+	}
+	if tool && rt.toolTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, rt.toolTimeout, ErrToolTimeout)
+		cancels = append(cancels, cancel)
+	}
+	return ctx, func() {
+		for _, cancel := range cancels {
+			cancel()
+		}
+	}
+}
+
+// replicaClient picks the replica for a call of session on rt, and returns
+// it with the pool it picked it from
+func (g *Gateway) replicaClient(ctx context.Context, rt *route, session string) (inferencev1.InferenceClient, *pool, error) {
+	p, rep, err := g.pick(ctx, rt.pool, session)
+	if err != nil {
+		return nil, nil, err
+	}
+	conn, err := p.client(rep, g.Port)
+	if err != nil {
+		return nil, nil, err
+	}
+	return inferencev1.NewInferenceClient(conn), p, nil
+}
+
+// grpcFail returns the status of a call the gateway could not complete.
+// Errors of the gateway are recorded as the last error of the binding,
+// while statuses the replica returned are passed on as they are.
+func (g *Gateway) grpcFail(ctx context.Context, rt *route, err error) error {
+	code := codes.Internal
+	cause := context.Cause(ctx)
+	switch {
+	case errors.Is(cause, ErrRequestTimeout), errors.Is(cause, ErrIdleTimeout), errors.Is(cause, ErrToolTimeout):
+		code = codes.DeadlineExceeded
+		err = cause
+	case ctx.Err() != nil:
+		// The client cancelled the call or its deadline passed
+		return status.FromContextError(ctx.Err()).Err()
+	case errors.Is(err, router.ErrNoReplicas), errors.Is(err, router.ErrAllBackpressured),
+		errors.Is(err, activator.ErrActivationTimeout):
+		code = codes.Unavailable
+	case errors.Is(err, router.ErrBudgetExhausted):
+		code = codes.ResourceExhausted
+	default:
+		if _, ok := status.FromError(err); ok {
+			return err
+		}
+	}
+	rt.stats.setError(err)
+	return status.Error(code, err.Error())
+}
+
+// client returns the gRPC connection to rep on port, which connects lazily
+func (p *pool) client(rep *router.Replica, port int) (*grpc.ClientConn, error) {
+	target := net.JoinHostPort(rep.Address, strconv.Itoa(port))
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if conn, ok := p.clients[rep.Name]; ok {
+		if conn.Target() == target {
+			return conn, nil
+		}
+		// The replica moved to another address
+		conn.Close()
+	}
+	conn, err := grpc.Dial(target, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, err
+	}
+	p.clients[rep.Name] = conn
+	return conn, nil
+}
+
+// closeClients closes the gRPC connections to the replicas missing from
+// keep, all of them if keep is nil
+func (p *pool) closeClients(keep map[string]bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for name, conn := range p.clients {
+		if !keep[name] {
+			delete(p.clients, name)
+			conn.Close()
+		}
+	}
+}
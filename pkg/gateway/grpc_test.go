@@ -0,0 +1,333 @@
+package gateway
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/grpc/status"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	inferencev1 "github.com/bowenislandsong/neuronetes/api/inference/v1"
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/router"
+)
+
+// fakeReplica answers inferences with its name, the session, and the tool
+// timeout and tenant metadata it was passed, and tool calls with whether
+// their deadline is within a second. It streams two words, waiting delay
+// before each. Inferences report usage, if set.
+type fakeReplica struct {
+	inferencev1.UnimplementedInferenceServer
+	name  string
+	delay time.Duration
+	usage *inferencev1.Usage
+}
+
+func (f *fakeReplica) Infer(ctx context.Context, req *inferencev1.InferRequest) (*inferencev1.InferResponse, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	text := f.name + ":" + req.GetSessionId() + ":" + metadataValue(md, "x-tool-timeout") + ":" + metadataValue(md, "x-tenant")
+	return &inferencev1.InferResponse{Text: text, FinishReason: "stop", Usage: f.usage}, nil
+}
+
+func (f *fakeReplica) CallTool(ctx context.Context, req *inferencev1.ToolRequest) (*inferencev1.ToolResponse, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return nil, status.Error(codes.FailedPrecondition, "no deadline")
+	}
+	if req.GetName() == "slow" {
+		<-ctx.Done()
+		return nil, status.FromContextError(ctx.Err()).Err()
+	}
+	if req.GetName() == "invalid" {
+		return nil, status.Error(codes.InvalidArgument, "unknown tool")
+	}
+	return &inferencev1.ToolResponse{Result: []byte(strconv.FormatBool(time.Until(deadline) <= time.Second))}, nil
+}
+
+func (f *fakeReplica) StreamInfer(req *inferencev1.InferRequest, stream inferencev1.Inference_StreamInferServer) error {
+	for _, word := range []string{"hello", "world"} {
+		select {
+		case <-time.After(f.delay):
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		}
+		if err := stream.Send(&inferencev1.InferChunk{Text: word}); err != nil {
+			return err
+		}
+	}
+	return stream.Send(&inferencev1.InferChunk{FinishReason: "stop", Usage: &inferencev1.Usage{OutputTokens: 2}})
+}
+
+func metadataValue(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func newTestGRPCBinding(name, authority string) *neuronetes.ToolBinding {
+	return &neuronetes.ToolBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec: neuronetes.ToolBindingSpec{
+			AgentPoolRef: neuronetes.AgentPoolReference{Name: "chat-pool"},
+			Type:         neuronetes.ToolBindingGRPC,
+			GRPCConfig:   &neuronetes.GRPCConfig{Authority: authority},
+		},
+	}
+}
+
+// newTestGRPCGateway returns a gateway whose replicas are served by replica
+// and a connection to its gRPC server
+func newTestGRPCGateway(t *testing.T, replica *fakeReplica) (*Gateway, router.Replica, *grpc.ClientConn) {
+	t.Helper()
+	backend, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := grpc.NewServer()
+	inferencev1.RegisterInferenceServer(srv, replica)
+	go func() { _ = srv.Serve(backend) }()
+	t.Cleanup(srv.Stop)
+
+	g := NewGateway(nil, nil)
+	g.Port = backend.Addr().(*net.TCPAddr).Port
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = (&GRPCServer{Gateway: g}).serve(ctx, lis)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return g, router.Replica{Name: "agent-0", Address: "127.0.0.1", Ready: true}, conn
+}
+
+// withAuthority returns a connection to the gateway of conn on authority
+func withAuthority(t *testing.T, conn *grpc.ClientConn, authority string) inferencev1.InferenceClient {
+	t.Helper()
+	other, err := grpc.Dial(conn.Target(), grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithAuthority(authority))
+	require.NoError(t, err)
+	t.Cleanup(func() { other.Close() })
+	return inferencev1.NewInferenceClient(other)
+}
+
+func TestGRPCGatewayRoutesCallsByAuthority(t *testing.T) {
+	g, rep, conn := newTestGRPCGateway(t, &fakeReplica{name: "agent-0"})
+	chat := newTestGRPCBinding("chat", "chat.example.com")
+	chat.Spec.Timeouts = &neuronetes.TimeoutConfig{ToolTimeout: &metav1.Duration{Duration: 30 * time.Second}}
+	require.NoError(t, g.Serve(chat, newTestPool(), []router.Replica{rep}))
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-tenant", "acme")
+	res, err := withAuthority(t, conn, "chat.example.com:443").Infer(ctx, &inferencev1.InferRequest{SessionId: "s1"})
+	require.NoError(t, err)
+	assert.Equal(t, "agent-0:s1:30s:acme", res.GetText())
+
+	// Calls on the authority of no binding fail until a binding without an
+	// authority serves them
+	_, err = inferencev1.NewInferenceClient(conn).Infer(context.Background(), &inferencev1.InferRequest{})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	require.NoError(t, g.Serve(newTestGRPCBinding("default", ""), newTestPool(), []router.Replica{rep}))
+	_, err = inferencev1.NewInferenceClient(conn).Infer(context.Background(), &inferencev1.InferRequest{})
+	assert.NoError(t, err)
+
+	now := time.Now()
+	g.now = func() time.Time { return now.Add(time.Second) }
+	g.bindings[types.NamespacedName{Namespace: "default", Name: "chat"}].stats.sampled = now
+	stats, ok := g.Stats(types.NamespacedName{Namespace: "default", Name: "chat"})
+	require.True(t, ok)
+	assert.Equal(t, 1.0, stats.RequestsPerSecond)
+
+	// gRPC bindings take no paths, and neither authorities nor paths of
+	// other bindings
+	assert.Equal(t, UnmatchedRoute, g.Route(httptest.NewRequest(http.MethodGet, "/", nil)))
+	err = g.Serve(newTestGRPCBinding("other", "CHAT.example.com"), newTestPool(), nil)
+	assert.ErrorContains(t, err, "authority chat.example.com is served by ToolBinding default/chat")
+	err = g.Serve(newTestGRPCBinding("other", ""), newTestPool(), nil)
+	assert.ErrorContains(t, err, "served by ToolBinding default/default")
+
+	g.Remove(types.NamespacedName{Namespace: "default", Name: "chat"})
+	g.Remove(types.NamespacedName{Namespace: "default", Name: "default"})
+	assert.Empty(t, g.pools)
+	_, err = withAuthority(t, conn, "chat.example.com").Infer(context.Background(), &inferencev1.InferRequest{})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestGRPCGatewayEnforcesDeadlines(t *testing.T) {
+	g, rep, conn := newTestGRPCGateway(t, &fakeReplica{name: "agent-0", delay: 50 * time.Millisecond})
+	binding := newTestGRPCBinding("chat", "")
+	binding.Spec.Timeouts = &neuronetes.TimeoutConfig{ToolTimeout: &metav1.Duration{Duration: 100 * time.Millisecond}}
+	require.NoError(t, g.Serve(binding, newTestPool(), []router.Replica{rep}))
+	client := inferencev1.NewInferenceClient(conn)
+	key := types.NamespacedName{Namespace: "default", Name: "chat"}
+
+	// Tool calls get the toolTimeout as deadline, which the replica sees
+	res, err := client.CallTool(context.Background(), &inferencev1.ToolRequest{Name: "search"})
+	require.NoError(t, err)
+	assert.Equal(t, "true", string(res.GetResult()))
+	_, err = client.CallTool(context.Background(), &inferencev1.ToolRequest{Name: "slow"})
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+	stats, _ := g.Stats(key)
+	assert.Equal(t, ErrToolTimeout.Error(), stats.LastError)
+
+	// Statuses of replicas are passed on without being recorded
+	_, err = client.CallTool(context.Background(), &inferencev1.ToolRequest{Name: "invalid"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	stats, _ = g.Stats(key)
+	assert.Equal(t, ErrToolTimeout.Error(), stats.LastError)
+
+	// The idleTimeout bounds the wait for each chunk of a stream
+	binding.Spec.Timeouts.IdleTimeout = &metav1.Duration{Duration: time.Second}
+	require.NoError(t, g.Serve(binding, newTestPool(), []router.Replica{rep}))
+	stream, err := client.StreamInfer(context.Background(), &inferencev1.InferRequest{})
+	require.NoError(t, err)
+	var text []string
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		text = append(text, chunk.GetText())
+	}
+	assert.Equal(t, []string{"hello", "world", ""}, text)
+
+	binding.Spec.Timeouts.IdleTimeout = &metav1.Duration{Duration: 20 * time.Millisecond}
+	require.NoError(t, g.Serve(binding, newTestPool(), []router.Replica{rep}))
+	stream, err = client.StreamInfer(context.Background(), &inferencev1.InferRequest{})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+	stats, _ = g.Stats(key)
+	assert.Equal(t, ErrIdleTimeout.Error(), stats.LastError)
+
+	// The requestTimeout bounds the whole call
+	binding.Spec.Timeouts = &neuronetes.TimeoutConfig{RequestTimeout: &metav1.Duration{Duration: 70 * time.Millisecond}}
+	require.NoError(t, g.Serve(binding, newTestPool(), []router.Replica{rep}))
+	stream, err = client.StreamInfer(context.Background(), &inferencev1.InferRequest{})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+	stats, _ = g.Stats(key)
+	assert.Equal(t, ErrRequestTimeout.Error(), stats.LastError)
+}
+
+func TestGRPCGatewayWithoutReadyReplicas(t *testing.T) {
+	g, rep, conn := newTestGRPCGateway(t, &fakeReplica{name: "agent-0"})
+	rep.Ready = false
+	require.NoError(t, g.Serve(newTestGRPCBinding("chat", ""), newTestPool(), []router.Replica{rep}))
+
+	_, err := inferencev1.NewInferenceClient(conn).Infer(context.Background(), &inferencev1.InferRequest{})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+}
+
+func TestGRPCGatewayEnforcesTokenBudget(t *testing.T) {
+	g, rep, conn := newTestGRPCGateway(t, &fakeReplica{name: "agent-0"})
+	agentPool := newTestPool()
+	budget := int32(100)
+	agentPool.Spec.TokensPerSecondBudget = &budget
+	binding := newTestGRPCBinding("chat", "")
+	binding.Spec.Timeouts = &neuronetes.TimeoutConfig{ToolTimeout: &metav1.Duration{Duration: time.Second}}
+	require.NoError(t, g.Serve(binding, agentPool, []router.Replica{rep}))
+	client := inferencev1.NewInferenceClient(conn)
+
+	// Calls are charged their maxTokens or the tokens they expect, at least
+	// the default, up to the budget
+	_, err := client.Infer(context.Background(), &inferencev1.InferRequest{MaxTokens: 80})
+	require.NoError(t, err)
+	_, err = client.Infer(context.Background(), &inferencev1.InferRequest{MaxTokens: 80})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	ctx := metadata.AppendToOutgoingContext(context.Background(), ExpectedTokensHeader, "80")
+	stream, err := client.StreamInfer(ctx, &inferencev1.InferRequest{})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	// Tool calls generate no tokens
+	_, err = client.CallTool(context.Background(), &inferencev1.ToolRequest{Name: "search"})
+	assert.NoError(t, err)
+}
+
+func TestGRPCGatewayTruesUpTokenUsage(t *testing.T) {
+	replica := &fakeReplica{name: "agent-0", usage: &inferencev1.Usage{InputTokens: 500, OutputTokens: 400}}
+	g, rep, conn := newTestGRPCGateway(t, replica)
+	ledger := &recordingLedger{}
+	g.Ledger = ledger
+	agentPool := newTestPool()
+	budget := int32(1000)
+	agentPool.Spec.TokensPerSecondBudget = &budget
+	require.NoError(t, g.Serve(newTestGRPCBinding("chat", ""), agentPool, []router.Replica{rep}))
+	g.SetClass(types.NamespacedName{Namespace: "default", Name: "chat-pool"}, newTestClass("chat", "llama-3-8b"))
+	client := inferencev1.NewInferenceClient(conn)
+
+	// A call charged the default that consumed more leaves too little for
+	// another
+	ctx := metadata.AppendToOutgoingContext(context.Background(), ExpectedTokensHeader, "1", TenantHeader, "acme")
+	_, err := client.Infer(ctx, &inferencev1.InferRequest{MaxTokens: 1})
+	require.NoError(t, err)
+	_, err = client.Infer(ctx, &inferencev1.InferRequest{MaxTokens: 1})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Equal(t, int64(900), ledger.get("acme", "llama-3-8b"))
+
+	// Streams are trued up to the usage of their last chunk
+	budget = 300
+	require.NoError(t, g.Serve(newTestGRPCBinding("chat", ""), agentPool, []router.Replica{rep}))
+	stream, err := client.StreamInfer(context.Background(), &inferencev1.InferRequest{})
+	require.NoError(t, err)
+	for {
+		if _, err := stream.Recv(); err != nil {
+			require.ErrorIs(t, err, io.EOF)
+			break
+		}
+	}
+	_, err = client.Infer(context.Background(), &inferencev1.InferRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(902), ledger.get("", "llama-3-8b"))
+}
+
+func TestGRPCServerHealthAndReflection(t *testing.T) {
+	_, _, conn := newTestGRPCGateway(t, &fakeReplica{name: "agent-0"})
+
+	for _, service := range []string{"", "neuronetes.inference.v1.Inference"} {
+		res, err := healthpb.NewHealthClient(conn).Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+		require.NoError(t, err)
+		assert.Equal(t, healthpb.HealthCheckResponse_SERVING, res.GetStatus())
+	}
+
+	stream, err := reflectionpb.NewServerReflectionClient(conn).ServerReflectionInfo(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&reflectionpb.ServerReflectionRequest{
+		MessageRequest: &reflectionpb.ServerReflectionRequest_ListServices{},
+	}))
+	res, err := stream.Recv()
+	require.NoError(t, err)
+	var services []string
+	for _, service := range res.GetListServicesResponse().GetService() {
+		services = append(services, service.GetName())
+	}
+	assert.Contains(t, services, "neuronetes.inference.v1.Inference")
+	assert.Contains(t, services, "grpc.health.v1.Health")
+}
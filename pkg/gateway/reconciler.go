@@ -0,0 +1,282 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/canary"
+	"github.com/bowenislandsong/neuronetes/pkg/router"
+)
+
+// DefaultStatusInterval is how often the traffic of bindings is reported in
+// their status
+const DefaultStatusInterval = 10 * time.Second
+
+// BindingReconciler configures the gateway with the HTTP, WebSocket and
+// gRPC ToolBindings of the cluster and the replicas of their AgentPools, and
+// reports their traffic in their status
+type BindingReconciler struct {
+	client.Client
+	Gateway *Gateway
+
+	// StatusInterval is how often the traffic of bindings is reported.
+	// Defaults to DefaultStatusInterval.
+	StatusInterval time.Duration
+}
+
+// +kubebuilder:rbac:groups=neuronetes.io,resources=toolbindings,verbs=get;list;watch
+// +kubebuilder:rbac:groups=neuronetes.io,resources=toolbindings/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=neuronetes.io,resources=agentpools,verbs=get;list;watch;patch
+// +kubebuilder:rbac:groups=neuronetes.io,resources=agentclasses,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch
+
+// Reconcile serves one ToolBinding and requeues it to report its traffic
+func (r *BindingReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	var binding neuronetes.ToolBinding
+	if err := r.Get(ctx, req.NamespacedName, &binding); err != nil {
+		if apierrors.IsNotFound(err) {
+			r.Gateway.Remove(req.NamespacedName)
+		}
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if !binding.DeletionTimestamp.IsZero() || !servedByGateway(&binding) {
+		// Other types of bindings are served by their own consumers
+		r.Gateway.Remove(req.NamespacedName)
+		return ctrl.Result{}, nil
+	}
+
+	status := binding.Status.DeepCopy()
+	poolKey := poolOf(&binding)
+	var pool neuronetes.AgentPool
+	err := r.Get(ctx, poolKey, &pool)
+	switch {
+	case apierrors.IsNotFound(err):
+		r.Gateway.Remove(req.NamespacedName)
+		status.Phase = neuronetes.ToolBindingPending
+		status.LastError = fmt.Sprintf("AgentPool %s not found", poolKey)
+	case err != nil:
+		return ctrl.Result{}, err
+	default:
+		replicas, err := r.replicas(ctx, &pool)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if err := r.Gateway.Serve(&binding, &pool, replicas); err != nil {
+			r.Gateway.Remove(req.NamespacedName)
+			status.Phase = neuronetes.ToolBindingFailed
+			status.LastError = err.Error()
+			break
+		}
+		if err := r.setClass(ctx, &pool); err != nil {
+			return ctrl.Result{}, err
+		}
+		if err := r.split(ctx, &pool); err != nil {
+			return ctrl.Result{}, err
+		}
+		if status.Phase != neuronetes.ToolBindingActive {
+			status.LastError = ""
+		}
+		status.Phase = neuronetes.ToolBindingActive
+		if stats, ok := r.Gateway.Stats(req.NamespacedName); ok {
+			setTraffic(status, stats)
+		}
+	}
+
+	if status.Phase != binding.Status.Phase {
+		log.Info("ToolBinding phase changed", "binding", req.NamespacedName,
+			"from", binding.Status.Phase, "to", status.Phase)
+	}
+	if !equalStatus(&binding.Status, status) {
+		binding.Status = *status
+		if err := r.Status().Update(ctx, &binding); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	return ctrl.Result{RequeueAfter: r.interval()}, nil
+}
+
+// replicas returns the routable replicas of pool from its pods
+func (r *BindingReconciler) replicas(ctx context.Context, pool *neuronetes.AgentPool) ([]router.Replica, error) {
+	var pods corev1.PodList
+	if err := r.List(ctx, &pods, client.InNamespace(pool.Namespace),
+		client.MatchingLabels{neuronetes.LabelPool: pool.Name}); err != nil {
+		return nil, fmt.Errorf("failed to list pods of pool %s: %w", pool.Name, err)
+	}
+	replicas := make([]router.Replica, 0, len(pods.Items))
+	for i := range pods.Items {
+		replicas = append(replicas, router.ReplicaFromPod(&pods.Items[i]))
+	}
+	return replicas, nil
+}
+
+// split routes the share of the traffic of pool its canary annotations set
+// to the replicas of the canary
+func (r *BindingReconciler) split(ctx context.Context, pool *neuronetes.AgentPool) error {
+	key := client.ObjectKeyFromObject(pool)
+	name, _, ok := canary.PoolSplit(pool)
+	if !ok {
+		r.Gateway.Split(key, nil, nil)
+		return nil
+	}
+	var canaryPool neuronetes.AgentPool
+	if err := r.Get(ctx, types.NamespacedName{Namespace: pool.Namespace, Name: name}, &canaryPool); err != nil {
+		if apierrors.IsNotFound(err) {
+			// All the traffic stays on the pool until its canary exists
+			r.Gateway.Split(key, nil, nil)
+			return nil
+		}
+		return err
+	}
+	replicas, err := r.replicas(ctx, &canaryPool)
+	if err != nil {
+		return err
+	}
+	r.Gateway.Split(key, &canaryPool, replicas)
+	return r.setClass(ctx, &canaryPool)
+}
+
+// setClass accounts the tokens served by pool to the Model of its
+// AgentClass, or to no model while the class does not exist, and has the
+// sessions of its draining replicas migrated if the class allows it
+func (r *BindingReconciler) setClass(ctx context.Context, pool *neuronetes.AgentPool) error {
+	key := types.NamespacedName{Namespace: pool.Spec.AgentClassRef.Namespace, Name: pool.Spec.AgentClassRef.Name}
+	if key.Namespace == "" {
+		key.Namespace = pool.Namespace
+	}
+	var class neuronetes.AgentClass
+	if key.Name != "" {
+		if err := r.Get(ctx, key, &class); err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+	}
+	r.Gateway.SetClass(client.ObjectKeyFromObject(pool), &class)
+	return nil
+}
+
+func (r *BindingReconciler) interval() time.Duration {
+	if r.StatusInterval > 0 {
+		return r.StatusInterval
+	}
+	return DefaultStatusInterval
+}
+
+// setTraffic reports stats in status
+func setTraffic(status *neuronetes.ToolBindingStatus, stats Stats) {
+	active := stats.ActiveConnections
+	status.ActiveConnections = &active
+	// Rounded so that idle noise does not update the status every interval
+	throughput := &neuronetes.ThroughputMetrics{
+		RequestsPerSecond: float32(math.Round(stats.RequestsPerSecond*100) / 100),
+	}
+	if stats.AverageLatency > 0 {
+		throughput.AverageLatency = &metav1.Duration{Duration: stats.AverageLatency.Round(time.Millisecond)}
+	}
+	status.ThroughputMetrics = throughput
+	if stats.LastError != "" {
+		status.LastError = stats.LastError
+	}
+}
+
+// equalStatus reports whether the fields the reconciler sets are unchanged
+func equalStatus(a, b *neuronetes.ToolBindingStatus) bool {
+	if a.Phase != b.Phase || a.LastError != b.LastError {
+		return false
+	}
+	if (a.ActiveConnections == nil) != (b.ActiveConnections == nil) ||
+		a.ActiveConnections != nil && *a.ActiveConnections != *b.ActiveConnections {
+		return false
+	}
+	ta, tb := a.ThroughputMetrics, b.ThroughputMetrics
+	if ta == nil || tb == nil {
+		return ta == tb
+	}
+	if ta.RequestsPerSecond != tb.RequestsPerSecond {
+		return false
+	}
+	if ta.AverageLatency == nil || tb.AverageLatency == nil {
+		return ta.AverageLatency == tb.AverageLatency
+	}
+	return ta.AverageLatency.Duration == tb.AverageLatency.Duration
+}
+
+// poolOf returns the AgentPool binding references. A reference without a
+// namespace is to the binding's own namespace.
+func poolOf(binding *neuronetes.ToolBinding) types.NamespacedName {
+	namespace := binding.Spec.AgentPoolRef.Namespace
+	if namespace == "" {
+		namespace = binding.Namespace
+	}
+	return types.NamespacedName{Namespace: namespace, Name: binding.Spec.AgentPoolRef.Name}
+}
+
+// bindingsOfPool maps an AgentPool, or a pod labelled with its name, to the
+// HTTP bindings routing to it or to the pools it is the canary of
+func (r *BindingReconciler) bindingsOfPool(ctx context.Context, obj client.Object) []reconcile.Request {
+	name := obj.GetName()
+	if _, ok := obj.(*corev1.Pod); ok {
+		name = obj.GetLabels()[neuronetes.LabelPool]
+	}
+	if name == "" {
+		return nil
+	}
+
+	pools := map[types.NamespacedName]bool{{Namespace: obj.GetNamespace(), Name: name}: true}
+	var agentPools neuronetes.AgentPoolList
+	if err := r.List(ctx, &agentPools, client.InNamespace(obj.GetNamespace())); err != nil {
+		log.FromContext(ctx).Error(err, "failed to list agent pools")
+		return nil
+	}
+	for i := range agentPools.Items {
+		if c, _, ok := canary.PoolSplit(&agentPools.Items[i]); ok && c == name {
+			pools[client.ObjectKeyFromObject(&agentPools.Items[i])] = true
+		}
+	}
+
+	var bindings neuronetes.ToolBindingList
+	if err := r.List(ctx, &bindings); err != nil {
+		log.FromContext(ctx).Error(err, "failed to list tool bindings")
+		return nil
+	}
+	var requests []reconcile.Request
+	for i := range bindings.Items {
+		binding := &bindings.Items[i]
+		if servedByGateway(binding) && pools[poolOf(binding)] {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(binding)})
+		}
+	}
+	return requests
+}
+
+// SetupWithManager sets up the reconciler with the Manager
+func (r *BindingReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("gateway").
+		For(&neuronetes.ToolBinding{}).
+		Watches(&neuronetes.AgentPool{}, handler.EnqueueRequestsFromMapFunc(r.bindingsOfPool)).
+		Watches(&corev1.Pod{}, handler.EnqueueRequestsFromMapFunc(r.bindingsOfPool)).
+		Complete(r)
+}
+
+// servedByGateway reports whether the gateway serves binding
+func servedByGateway(binding *neuronetes.ToolBinding) bool {
+	switch binding.Spec.Type {
+	case neuronetes.ToolBindingHTTP, neuronetes.ToolBindingWebSocket, neuronetes.ToolBindingGRPC:
+		return true
+	}
+	return false
+}
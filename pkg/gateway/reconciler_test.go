@@ -0,0 +1,146 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+)
+
+func newTestReconciler(t *testing.T, objs ...client.Object) *BindingReconciler {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	require.NoError(t, neuronetes.AddToScheme(scheme))
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).
+		WithStatusSubresource(&neuronetes.ToolBinding{}).Build()
+	return &BindingReconciler{Client: c, Gateway: NewGateway(nil, nil)}
+}
+
+func reconcileBinding(t *testing.T, r *BindingReconciler, name string) *neuronetes.ToolBinding {
+	t.Helper()
+	key := client.ObjectKey{Namespace: "default", Name: name}
+	result, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: key})
+	require.NoError(t, err)
+	assert.Equal(t, DefaultStatusInterval, result.RequeueAfter)
+	var binding neuronetes.ToolBinding
+	require.NoError(t, r.Get(context.Background(), key, &binding))
+	return &binding
+}
+
+func newTestPod(name string, ready bool) *corev1.Pod {
+	status := corev1.ConditionFalse
+	if ready {
+		status = corev1.ConditionTrue
+	}
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Labels: map[string]string{neuronetes.LabelPool: "chat-pool"}},
+		Status: corev1.PodStatus{
+			Phase:      corev1.PodRunning,
+			PodIP:      "10.0.0.1",
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: status}},
+		},
+	}
+}
+
+func newTestClass(name, model string) *neuronetes.AgentClass {
+	return &neuronetes.AgentClass{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec:       neuronetes.AgentClassSpec{ModelRef: neuronetes.ModelReference{Name: model}},
+	}
+}
+
+func TestBindingReconcilerServesHTTPBindings(t *testing.T) {
+	ctx := context.Background()
+	r := newTestReconciler(t, newTestBinding("search", "/search"), newTestPod("chat-pool-0", true))
+
+	// Bindings wait for their pool
+	got := reconcileBinding(t, r, "search")
+	assert.Equal(t, neuronetes.ToolBindingPending, got.Status.Phase)
+	assert.Contains(t, got.Status.LastError, "AgentPool default/chat-pool not found")
+	assert.Equal(t, UnmatchedRoute, r.Gateway.Route(httptest.NewRequest(http.MethodGet, "/search", nil)))
+
+	require.NoError(t, r.Create(ctx, newTestPool()))
+	got = reconcileBinding(t, r, "search")
+	assert.Equal(t, neuronetes.ToolBindingActive, got.Status.Phase)
+	assert.Empty(t, got.Status.LastError)
+	require.NotNil(t, got.Status.ActiveConnections)
+	assert.Equal(t, int32(0), *got.Status.ActiveConnections)
+	require.NotNil(t, got.Status.ThroughputMetrics)
+	assert.Equal(t, "/search", r.Gateway.Route(httptest.NewRequest(http.MethodGet, "/search", nil)))
+	_, ready := r.Gateway.pools[poolOf(got)].router.Backpressure()
+	assert.Equal(t, 1, ready)
+
+	// Pods of the pool are watched through its bindings
+	requests := r.bindingsOfPool(ctx, newTestPod("chat-pool-1", false))
+	require.Len(t, requests, 1)
+	assert.Equal(t, "search", requests[0].Name)
+
+	// A second binding cannot take the path
+	require.NoError(t, r.Create(ctx, newTestBinding("other", "/search")))
+	got = reconcileBinding(t, r, "other")
+	assert.Equal(t, neuronetes.ToolBindingFailed, got.Status.Phase)
+	assert.Contains(t, got.Status.LastError, "served by ToolBinding default/search")
+
+	// Bindings of other types are left to their consumers
+	got.Spec.Type = neuronetes.ToolBindingQueue
+	require.NoError(t, r.Update(ctx, got))
+	_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(got)})
+	require.NoError(t, err)
+	assert.Len(t, r.bindingsOfPool(ctx, newTestPool()), 1)
+
+	require.NoError(t, r.Delete(ctx, newTestBinding("search", "/search")))
+	_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKey{Namespace: "default", Name: "search"}})
+	require.NoError(t, err)
+	assert.Equal(t, UnmatchedRoute, r.Gateway.Route(httptest.NewRequest(http.MethodGet, "/search", nil)))
+	assert.Empty(t, r.Gateway.pools)
+}
+
+func TestBindingReconcilerSplitsTrafficToCanaries(t *testing.T) {
+	ctx := context.Background()
+	pool := newTestPool()
+	pool.Annotations = map[string]string{
+		neuronetes.AnnotationCanary:       "chat-pool-canary",
+		neuronetes.AnnotationCanaryWeight: "10",
+	}
+	pool.Spec.AgentClassRef.Name = "chat"
+	canaryPod := newTestPod("chat-pool-canary-0", true)
+	canaryPod.Labels[neuronetes.LabelPool] = "chat-pool-canary"
+	r := newTestReconciler(t, newTestBinding("search", "/search"), pool, canaryPod,
+		newTestClass("chat", "llama-3-8b"), newTestClass("chat-canary", "llama-3.1-8b"))
+
+	// Traffic stays on the pool until its canary exists
+	reconcileBinding(t, r, "search")
+	assert.Nil(t, r.Gateway.pools[poolOf(newTestBinding("search", "/search"))].canary)
+
+	require.NoError(t, r.Create(ctx, &neuronetes.AgentPool{
+		ObjectMeta: metav1.ObjectMeta{Name: "chat-pool-canary", Namespace: "default"},
+		Spec:       neuronetes.AgentPoolSpec{AgentClassRef: neuronetes.AgentClassReference{Name: "chat-canary"}},
+	}))
+	reconcileBinding(t, r, "search")
+	p := r.Gateway.pools[poolOf(newTestBinding("search", "/search"))]
+	require.NotNil(t, p.canary)
+	_, ready := p.canary.router.Backpressure()
+	assert.Equal(t, 1, ready)
+
+	// Tokens are accounted to the model each pool serves
+	assert.Equal(t, "llama-3-8b", p.model)
+	assert.Equal(t, "llama-3.1-8b", p.canary.model)
+
+	// Pods of the canary are watched through the bindings of its pool
+	requests := r.bindingsOfPool(ctx, canaryPod)
+	require.Len(t, requests, 1)
+	assert.Equal(t, "search", requests[0].Name)
+}
@@ -0,0 +1,53 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// shutdownTimeout is how long requests in flight may complete on shutdown
+const shutdownTimeout = 30 * time.Second
+
+// Server serves a Gateway over HTTP
+type Server struct {
+	// Addr is the address to listen on
+	Addr string
+
+	// Handler serves the bindings, usually the Gateway wrapped in metrics
+	// instrumentation
+	Handler http.Handler
+}
+
+// Start serves until ctx is cancelled. It implements manager.Runnable.
+func (s *Server) Start(ctx context.Context) error {
+	srv := &http.Server{
+		Addr:              s.Addr,
+		Handler:           s.Handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.ListenAndServe()
+	}()
+
+	select {
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		return srv.Shutdown(shutdownCtx)
+	case err := <-errCh:
+		if errors.Is(err, http.ErrServerClosed) {
+			return nil
+		}
+		return err
+	}
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable, so that
+// every gateway replica serves
+func (s *Server) NeedLeaderElection() bool {
+	return false
+}
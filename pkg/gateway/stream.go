@@ -0,0 +1,138 @@
+package gateway
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// eventStream is the media type of server-sent events
+const eventStream = "text/event-stream"
+
+// heartbeat is the comment sent on quiet event streams, which clients
+// ignore
+var heartbeat = []byte(": heartbeat\n\n")
+
+// isEventStream reports whether header describes a server-sent event stream
+func isEventStream(header http.Header) bool {
+	return strings.HasPrefix(header.Get("Content-Type"), eventStream)
+}
+
+// streamResponse keeps caches and buffering proxies between the gateway and
+// its clients from holding back the events of a streamed response
+func streamResponse(res *http.Response) error {
+	if isEventStream(res.Header) {
+		res.Header.Set("Cache-Control", "no-cache")
+		res.Header.Set("X-Accel-Buffering", "no")
+		res.Header.Del("Content-Length")
+	}
+	return nil
+}
+
+// responseWriter writes the response of a routed request. Each write
+// restarts the idle timer of the request. When heartbeats are enabled and
+// the response is an event stream, a heartbeat is sent between events
+// whenever the stream was quiet for the heartbeat interval.
+type responseWriter struct {
+	http.ResponseWriter
+
+	idle        *time.Timer
+	idleTimeout time.Duration
+
+	heartbeatInterval time.Duration
+
+	mu        sync.Mutex
+	heartbeat *time.Timer
+	// boundary is whether the stream is between events, where a heartbeat
+	// does not split an event
+	boundary bool
+	stopped  bool
+	err      error
+}
+
+// WriteHeader implements http.ResponseWriter
+func (w *responseWriter) WriteHeader(status int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.ResponseWriter.WriteHeader(status)
+	if w.heartbeatInterval > 0 && w.heartbeat == nil && status == http.StatusOK && isEventStream(w.Header()) {
+		w.boundary = true
+		w.heartbeat = time.AfterFunc(w.heartbeatInterval, w.beat)
+	}
+}
+
+// Write implements http.ResponseWriter
+func (w *responseWriter) Write(p []byte) (int, error) {
+	if w.idle != nil {
+		w.idle.Reset(w.idleTimeout)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	n, err := w.ResponseWriter.Write(p)
+	if err != nil && w.err == nil {
+		w.err = err
+	}
+	if w.heartbeat != nil && len(p) > 0 {
+		w.boundary = bytes.HasSuffix(p, []byte("\n\n")) || bytes.HasSuffix(p, []byte("\r\n\r\n"))
+		w.heartbeat.Reset(w.heartbeatInterval)
+	}
+	return n, err
+}
+
+// FlushError flushes the response to the client. It implements the
+// interface http.ResponseController flushes through.
+func (w *responseWriter) FlushError() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Unwrap returns the wrapped writer for http.ResponseController
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// beat sends a heartbeat if the stream is between events, and schedules
+// the next one
+func (w *responseWriter) beat() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.stopped || w.err != nil {
+		return
+	}
+	if w.boundary {
+		if _, err := w.ResponseWriter.Write(heartbeat); err != nil {
+			w.err = err
+			return
+		}
+		_ = http.NewResponseController(w.ResponseWriter).Flush()
+	}
+	w.heartbeat.Reset(w.heartbeatInterval)
+}
+
+// writeErr returns the first error writing to the client
+func (w *responseWriter) writeErr() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+// stop stops the timers of the request once it is served
+func (w *responseWriter) stop() {
+	if w.idle != nil {
+		w.idle.Stop()
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.stopped = true
+	if w.heartbeat != nil {
+		w.heartbeat.Stop()
+	}
+}
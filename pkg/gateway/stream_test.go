@@ -0,0 +1,119 @@
+package gateway
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bowenislandsong/neuronetes/pkg/metrics"
+	"github.com/bowenislandsong/neuronetes/pkg/router"
+)
+
+// newStreamingGateway serves a streaming binding on /chat through the
+// metrics middleware, with replicas served by handler
+func newStreamingGateway(t *testing.T, handler http.HandlerFunc) (*httptest.Server, *metrics.AgentMetrics) {
+	t.Helper()
+	g, rep := newTestGateway(t, handler)
+	g.HeartbeatInterval = 5 * time.Millisecond
+	binding := newTestBinding("chat", "/chat")
+	binding.Spec.HTTPConfig.StreamingEnabled = true
+	require.NoError(t, g.Serve(binding, newTestPool(), []router.Replica{rep}))
+
+	m := metrics.NewAgentMetrics(prometheus.NewRegistry())
+	srv := httptest.NewServer(m.InstrumentHandler(g, metrics.InstrumentOptions{Route: g.Route}))
+	t.Cleanup(srv.Close)
+	return srv, m
+}
+
+// writeEvent writes an event and flushes it to the gateway
+func writeEvent(w http.ResponseWriter, data string) {
+	_, _ = io.WriteString(w, "data: "+data+"\n\n")
+	w.(http.Flusher).Flush()
+}
+
+// readUntil reads lines of the stream until one has prefix
+func readUntil(t *testing.T, r *bufio.Reader, prefix string) {
+	t.Helper()
+	for {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		if strings.HasPrefix(line, prefix) {
+			return
+		}
+	}
+}
+
+func streamInits(t *testing.T, m *metrics.AgentMetrics) uint64 {
+	var out dto.Metric
+	require.NoError(t, m.StreamInitLatency.(prometheus.Metric).Write(&out))
+	return out.GetHistogram().GetSampleCount()
+}
+
+func TestGatewayStreamsServerSentEvents(t *testing.T) {
+	next := make(chan struct{})
+	accept := make(chan string, 1)
+	srv, m := newStreamingGateway(t, func(w http.ResponseWriter, r *http.Request) {
+		accept <- r.Header.Get("Accept")
+		w.Header().Set("Content-Type", "text/event-stream")
+		writeEvent(w, "first")
+		<-next
+		writeEvent(w, "second")
+	})
+
+	res, err := http.Get(srv.URL + "/chat")
+	require.NoError(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, "text/event-stream", <-accept)
+	assert.Equal(t, "no-cache", res.Header.Get("Cache-Control"))
+
+	// Events arrive as the replica writes them, with heartbeats in between
+	body := bufio.NewReader(res.Body)
+	readUntil(t, body, "data: first")
+	readUntil(t, body, ": heartbeat")
+	close(next)
+	readUntil(t, body, "data: second")
+	_, err = io.ReadAll(body)
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool { return streamInits(t, m) == 1 }, time.Second, time.Millisecond)
+	assert.Equal(t, 0.0, testutil.ToFloat64(m.StreamCancelRate))
+}
+
+func TestGatewayCancelsStreamsOfGoneClients(t *testing.T) {
+	cancelled := make(chan struct{})
+	srv, m := newStreamingGateway(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		writeEvent(w, "first")
+		<-r.Context().Done()
+		close(cancelled)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/chat", nil)
+	require.NoError(t, err)
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	readUntil(t, bufio.NewReader(res.Body), "data: first")
+	cancel()
+
+	// The replica stops generating for the client that went away
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("replica request was not cancelled")
+	}
+	require.Eventually(t, func() bool { return testutil.ToFloat64(m.StreamCancelRate) == 1 }, 5*time.Second, time.Millisecond)
+	assert.Equal(t, uint64(1), streamInits(t, m))
+}
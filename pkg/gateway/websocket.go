@@ -0,0 +1,586 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/bowenislandsong/neuronetes/pkg/router"
+	"github.com/bowenislandsong/neuronetes/pkg/tracing"
+)
+
+const (
+	// DefaultPingInterval is how often both ends of a WebSocket connection
+	// are pinged
+	DefaultPingInterval = 30 * time.Second
+
+	// DefaultMigrationGracePeriod is how long a draining replica may finish
+	// sending after its WebSocket sessions moved to another replica
+	DefaultMigrationGracePeriod = 10 * time.Second
+
+	// MigratedFromHeader tells the replica a WebSocket session moved to
+	// which replica served the session before, so that it can restore it
+	MigratedFromHeader = "X-Migrated-From"
+
+	// handshakeTimeout bounds the handshakes with replicas of bindings
+	// without a requestTimeout
+	handshakeTimeout = 30 * time.Second
+
+	// closeTimeout bounds the writes of close frames
+	closeTimeout = time.Second
+)
+
+var (
+	// ErrSessionLimit is returned for WebSocket connections beyond the
+	// perSessionLimit of their binding
+	ErrSessionLimit = errors.New("session connection limit reached")
+
+	// ErrReplicasFull is returned for WebSocket connections when every
+	// routable replica holds the maxConcurrentRequests of their binding
+	ErrReplicasFull = errors.New("all replicas at connection limit")
+)
+
+// handshakeHeaders are the request headers that are not forwarded to
+// replicas, since the dialer sets its own
+var handshakeHeaders = map[string]bool{
+	"Connection":               true,
+	"Keep-Alive":               true,
+	"Proxy-Connection":         true,
+	"Te":                       true,
+	"Trailer":                  true,
+	"Transfer-Encoding":        true,
+	"Upgrade":                  true,
+	"Sec-Websocket-Key":        true,
+	"Sec-Websocket-Version":    true,
+	"Sec-Websocket-Extensions": true,
+	"Sec-Websocket-Protocol":   true,
+}
+
+// serveWebSocket relays a WebSocket connection to a replica of the pool of
+// rt until either end closes it. The requestTimeout of the binding bounds
+// the handshake with the replica rather than the connection.
+func (g *Gateway) serveWebSocket(w http.ResponseWriter, r *http.Request, rt *route) {
+	if r.Method != http.MethodGet || !websocket.IsWebSocketUpgrade(r) {
+		w.Header().Set("Upgrade", "websocket")
+		http.Error(w, http.StatusText(http.StatusUpgradeRequired), http.StatusUpgradeRequired)
+		return
+	}
+	if !rt.allowsOrigin(r) {
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return
+	}
+
+	rt.stats.begin()
+	defer rt.stats.endConnection()
+
+	p := rt.pool
+	session := r.Header.Get(SessionHeader)
+	if session != "" {
+		if !p.acquireSession(session, rt.sessionConns) {
+			rt.stats.setError(ErrSessionLimit)
+			http.Error(w, ErrSessionLimit.Error(), http.StatusTooManyRequests)
+			return
+		}
+		defer p.releaseSession(session)
+	}
+
+	ctx := tracing.Extract(r.Context(), r.Header)
+	if rt.requestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, rt.requestTimeout, ErrRequestTimeout)
+		defer cancel()
+	}
+
+	// Connections count against the replicas of the pool they were picked
+	// from, the canary of the pool of the binding for some sessions
+	target, rep, err := g.pick(ctx, p, session)
+	if err == nil {
+		rep, err = target.reserve(rep, rt.replicaConns)
+	}
+	if err != nil {
+		g.fail(ctx, w, rt, err)
+		return
+	}
+	upstream, res, err := g.dial(ctx, r, rt, rep, websocket.Subprotocols(r), "")
+	if err != nil {
+		target.releaseReplica(rep.Name)
+		if errors.Is(err, websocket.ErrBadHandshake) && res != nil {
+			// The replica turned the connection down, e.g. unauthorized
+			rejectHandshake(w, res)
+			return
+		}
+		g.fail(ctx, w, rt, err)
+		return
+	}
+
+	upgrader := websocket.Upgrader{
+		// Origins are checked before dialling the replica
+		CheckOrigin: func(*http.Request) bool { return true },
+	}
+	if sub := upstream.Subprotocol(); sub != "" {
+		upgrader.Subprotocols = []string{sub}
+	}
+	client, err := upgrader.Upgrade(w, r, http.Header{"Set-Cookie": res.Header.Values("Set-Cookie")})
+	if err != nil {
+		// The upgrader responded to the client
+		upstream.Close()
+		target.releaseReplica(rep.Name)
+		return
+	}
+
+	rl := newRelay(g, rt, target, r, session, client, upstream, rep.Name)
+	target.addRelay(rl)
+	defer target.removeRelay(rl)
+	rl.run(context.WithoutCancel(ctx))
+}
+
+// dial opens a WebSocket connection to rep for r
+func (g *Gateway) dial(ctx context.Context, r *http.Request, rt *route, rep *router.Replica, subprotocols []string, migratedFrom string) (*websocket.Conn, *http.Response, error) {
+	target := url.URL{
+		Scheme:   "ws",
+		Host:     net.JoinHostPort(rep.Address, strconv.Itoa(g.Port)),
+		Path:     r.URL.Path,
+		RawQuery: r.URL.RawQuery,
+	}
+
+	header := make(http.Header, len(r.Header))
+	for name, values := range r.Header {
+		if !handshakeHeaders[name] {
+			header[name] = values
+		}
+	}
+	tracing.Inject(ctx, header)
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		if prior := header.Get("X-Forwarded-For"); prior != "" {
+			host = prior + ", " + host
+		}
+		header.Set("X-Forwarded-For", host)
+	}
+	header.Set("X-Forwarded-Host", r.Host)
+	if r.TLS != nil {
+		header.Set("X-Forwarded-Proto", "https")
+	} else {
+		header.Set("X-Forwarded-Proto", "http")
+	}
+	if rt.toolTimeout > 0 {
+		header.Set(ToolTimeoutHeader, rt.toolTimeout.String())
+	}
+	if migratedFrom != "" {
+		header.Set(MigratedFromHeader, migratedFrom)
+	}
+
+	timeout := rt.requestTimeout
+	if timeout <= 0 {
+		timeout = handshakeTimeout
+	}
+	dialer := websocket.Dialer{Subprotocols: subprotocols, HandshakeTimeout: timeout}
+	return dialer.DialContext(ctx, target.String(), header)
+}
+
+// rejectHandshake relays the response of a replica that turned a
+// WebSocket handshake down
+func rejectHandshake(w http.ResponseWriter, res *http.Response) {
+	for name, values := range res.Header {
+		if !handshakeHeaders[name] && name != "Content-Length" {
+			w.Header()[name] = values
+		}
+	}
+	w.WriteHeader(res.StatusCode)
+	_, _ = io.Copy(w, res.Body)
+}
+
+// allowsOrigin reports whether browsers on the origin of r may open
+// WebSockets on rt. Requests without an origin are not from browsers.
+func (rt *route) allowsOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	for _, allowed := range rt.origins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// acquireSession counts a connection of session, or returns false if the
+// session holds limit connections already
+func (p *pool) acquireSession(session string, limit int) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if limit > 0 && p.sessions[session] >= limit {
+		return false
+	}
+	p.sessions[session]++
+	return true
+}
+
+func (p *pool) releaseSession(session string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.sessions[session]--; p.sessions[session] <= 0 {
+		delete(p.sessions, session)
+	}
+}
+
+// reserve counts a connection on rep, or on the next replica below limit
+// connections if rep is full, and returns the replica it counted on
+func (p *pool) reserve(rep *router.Replica, limit int) (*router.Replica, error) {
+	if p.acquireReplica(rep.Name, limit) {
+		return rep, nil
+	}
+	seen := map[string]bool{rep.Name: true}
+	for {
+		next, err := p.router.Pick()
+		if err != nil {
+			return nil, err
+		}
+		if seen[next.Name] {
+			return nil, ErrReplicasFull
+		}
+		seen[next.Name] = true
+		if p.acquireReplica(next.Name, limit) {
+			return next, nil
+		}
+	}
+}
+
+func (p *pool) acquireReplica(name string, limit int) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if limit > 0 && p.conns[name] >= limit {
+		return false
+	}
+	p.conns[name]++
+	return true
+}
+
+func (p *pool) releaseReplica(name string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conns[name]--; p.conns[name] <= 0 {
+		delete(p.conns, name)
+	}
+}
+
+func (p *pool) addRelay(rl *relay) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.relays[rl] = true
+}
+
+func (p *pool) removeRelay(rl *relay) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.relays, rl)
+}
+
+// drain tells the WebSocket connections on a draining replica to migrate.
+// Connections whose migration fails stay on the replica and are retried on
+// the next drain.
+func (p *pool) drain(name string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for rl := range p.relays {
+		if rl.onReplica(name) {
+			select {
+			case rl.migrate <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// relay relays the messages of a WebSocket connection between its client
+// and a replica. Both ends are pinged every ping interval and disconnected
+// when they answer nothing for two. Migration moves the connection to
+// another replica while the previous one finishes what it is sending.
+type relay struct {
+	g  *Gateway
+	rt *route
+	// pool is the pool the replicas of the relay are picked from
+	pool    *pool
+	req     *http.Request
+	session string
+
+	// clientMu serializes the writes to client, which replicas being
+	// migrated from share with the current one
+	clientMu sync.Mutex
+	client   *websocket.Conn
+
+	// writeMu serializes the writes of client messages with migration, so
+	// that none is written to a replica after it was migrated from
+	writeMu sync.Mutex
+
+	// mu guards the replica messages of the client are written to
+	mu       sync.Mutex
+	upstream *websocket.Conn
+	replica  string
+	previous []*websocket.Conn
+
+	migrate chan struct{}
+	// done is closed when the relay ends
+	done    chan struct{}
+	idle    *time.Timer
+	readers sync.WaitGroup
+
+	once sync.Once
+	// toClient and toUpstream are the close frames sent when the relay
+	// ends, none for the end that closed
+	toClient   []byte
+	toUpstream []byte
+}
+
+func newRelay(g *Gateway, rt *route, p *pool, r *http.Request, session string,
+	client, upstream *websocket.Conn, replica string) *relay {
+	return &relay{
+		g:        g,
+		rt:       rt,
+		pool:     p,
+		req:      r,
+		session:  session,
+		client:   client,
+		upstream: upstream,
+		replica:  replica,
+		migrate:  make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+}
+
+// run relays until either end closes or ctx is done
+func (rl *relay) run(ctx context.Context) {
+	upstream, replica := rl.upstream, rl.replica
+	if rl.rt.idleTimeout > 0 {
+		rl.idle = time.AfterFunc(rl.rt.idleTimeout, func() {
+			rl.rt.stats.setError(ErrIdleTimeout)
+			idle := websocket.FormatCloseMessage(websocket.CloseNormalClosure, ErrIdleTimeout.Error())
+			rl.end(idle, idle)
+		})
+	}
+
+	rl.keepalive(rl.client)
+	rl.keepalive(upstream)
+	rl.readers.Add(2)
+	go rl.readClient()
+	go rl.readUpstream(upstream, replica)
+
+	ticker := time.NewTicker(rl.rt.pingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			rl.end(nil, nil)
+			rl.close()
+			return
+		case <-rl.done:
+			rl.close()
+			return
+		case <-ticker.C:
+			rl.ping()
+		case <-rl.migrate:
+			rl.move(ctx)
+		}
+	}
+}
+
+// keepalive extends the read deadline of conn whenever it answers a ping
+func (rl *relay) keepalive(conn *websocket.Conn) {
+	_ = conn.SetReadDeadline(time.Now().Add(2 * rl.rt.pingInterval))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(2 * rl.rt.pingInterval))
+	})
+}
+
+// received extends the read deadline of conn after a message and restarts
+// the idle timer
+func (rl *relay) received(conn *websocket.Conn) {
+	_ = conn.SetReadDeadline(time.Now().Add(2 * rl.rt.pingInterval))
+	if rl.idle != nil {
+		rl.idle.Reset(rl.rt.idleTimeout)
+	}
+}
+
+func (rl *relay) ping() {
+	deadline := time.Now().Add(rl.rt.pingInterval)
+	_ = rl.client.WriteControl(websocket.PingMessage, nil, deadline)
+	_ = rl.current().WriteControl(websocket.PingMessage, nil, deadline)
+}
+
+// current returns the connection to the replica the relay writes to
+func (rl *relay) current() *websocket.Conn {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	return rl.upstream
+}
+
+// readClient writes the messages of the client to the current replica
+func (rl *relay) readClient() {
+	defer rl.readers.Done()
+	for {
+		kind, msg, err := rl.client.ReadMessage()
+		if err != nil {
+			// The client closed or went away
+			rl.end(nil, closeFrame(err, "client went away"))
+			return
+		}
+		rl.received(rl.client)
+
+		rl.writeMu.Lock()
+		err = rl.current().WriteMessage(kind, msg)
+		rl.writeMu.Unlock()
+		if err != nil {
+			rl.end(websocket.FormatCloseMessage(websocket.CloseGoingAway, "replica went away"), nil)
+			return
+		}
+	}
+}
+
+// readUpstream writes the messages of a replica to the client. It ends the
+// relay when the current replica closes, and returns quietly when a
+// replica that was migrated from does.
+func (rl *relay) readUpstream(conn *websocket.Conn, replica string) {
+	defer rl.readers.Done()
+	defer rl.pool.releaseReplica(replica)
+	for {
+		kind, msg, err := conn.ReadMessage()
+		if err != nil {
+			if rl.current() == conn {
+				rl.end(closeFrame(err, "replica went away"), nil)
+			} else {
+				conn.Close()
+			}
+			return
+		}
+		rl.received(conn)
+
+		rl.clientMu.Lock()
+		err = rl.client.WriteMessage(kind, msg)
+		rl.clientMu.Unlock()
+		if err != nil {
+			rl.end(nil, websocket.FormatCloseMessage(websocket.CloseGoingAway, "client went away"))
+			return
+		}
+	}
+}
+
+// onReplica reports whether the relay writes to the named replica
+func (rl *relay) onReplica(name string) bool {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	return rl.replica == name
+}
+
+// move migrates the relay and its session to another replica. The replica
+// migrated from is sent a going away close and given the grace period to
+// finish sending; messages of the client go to the new replica.
+func (rl *relay) move(ctx context.Context) {
+	p := rl.pool
+	rl.mu.Lock()
+	from := rl.replica
+	rl.mu.Unlock()
+
+	if _, err := p.router.Pick(); err != nil {
+		// Nowhere to move to, the session stays on the draining replica
+		return
+	}
+	if rl.session != "" {
+		p.router.EndSession(rl.session)
+	}
+	rep, err := p.router.Route(rl.session)
+	if err != nil || rep.Name == from {
+		return
+	}
+	if rep, err = p.reserve(rep, rl.rt.replicaConns); err != nil {
+		rl.rt.stats.setError(fmt.Errorf("failed to migrate session from %s: %w", from, err))
+		return
+	}
+	var subprotocols []string
+	if sub := rl.client.Subprotocol(); sub != "" {
+		subprotocols = []string{sub}
+	}
+	conn, _, err := rl.g.dial(ctx, rl.req, rl.rt, rep, subprotocols, from)
+	if err != nil {
+		p.releaseReplica(rep.Name)
+		rl.rt.stats.setError(fmt.Errorf("failed to migrate session from %s: %w", from, err))
+		return
+	}
+	rl.keepalive(conn)
+
+	rl.writeMu.Lock()
+	rl.mu.Lock()
+	prev := rl.upstream
+	rl.upstream = conn
+	rl.replica = rep.Name
+	rl.previous = append(rl.previous, prev)
+	rl.mu.Unlock()
+	rl.writeMu.Unlock()
+
+	rl.readers.Add(1)
+	go rl.readUpstream(conn, rep.Name)
+	_ = prev.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseGoingAway, "session migrated"), time.Now().Add(closeTimeout))
+	time.AfterFunc(rl.rt.migrationGrace, func() { prev.Close() })
+}
+
+// end ends the relay once, sending the given close frames
+func (rl *relay) end(toClient, toUpstream []byte) {
+	rl.once.Do(func() {
+		rl.toClient = toClient
+		rl.toUpstream = toUpstream
+		close(rl.done)
+	})
+}
+
+// close sends the close frames of the ended relay, closes every connection
+// and waits for the readers to return
+func (rl *relay) close() {
+	if rl.idle != nil {
+		rl.idle.Stop()
+	}
+	deadline := time.Now().Add(closeTimeout)
+	if rl.toClient != nil {
+		_ = rl.client.WriteControl(websocket.CloseMessage, rl.toClient, deadline)
+	}
+
+	rl.mu.Lock()
+	conns := append([]*websocket.Conn{rl.upstream}, rl.previous...)
+	rl.mu.Unlock()
+	if rl.toUpstream != nil {
+		_ = conns[0].WriteControl(websocket.CloseMessage, rl.toUpstream, deadline)
+	}
+
+	rl.client.Close()
+	for _, conn := range conns {
+		conn.Close()
+	}
+	rl.readers.Wait()
+}
+
+// closeFrame returns the close frame relaying err, the read error of one
+// end, to the other end
+func closeFrame(err error, gone string) []byte {
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) {
+		return websocket.FormatCloseMessage(websocket.CloseGoingAway, gone)
+	}
+	switch closeErr.Code {
+	case websocket.CloseNoStatusReceived:
+		return websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+	case websocket.CloseAbnormalClosure, websocket.CloseTLSHandshake:
+		// Reserved codes that are never sent
+		return websocket.FormatCloseMessage(websocket.CloseGoingAway, gone)
+	}
+	return websocket.FormatCloseMessage(closeErr.Code, closeErr.Text)
+}
@@ -0,0 +1,168 @@
+package gateway
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/router"
+)
+
+func newTestWebSocketBinding(name, path string) *neuronetes.ToolBinding {
+	return &neuronetes.ToolBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec: neuronetes.ToolBindingSpec{
+			AgentPoolRef:    neuronetes.AgentPoolReference{Name: "chat-pool"},
+			Type:            neuronetes.ToolBindingWebSocket,
+			WebSocketConfig: &neuronetes.WebSocketConfig{Path: path},
+		},
+	}
+}
+
+// echo answers every message with the name of the replica and the message
+func echo(name string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			kind, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			reply := name + ":" + r.Header.Get(MigratedFromHeader) + ":" + string(msg)
+			if err := conn.WriteMessage(kind, []byte(reply)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// dialGateway opens a WebSocket on path through a server for g
+func dialGateway(t *testing.T, g *Gateway, path string, header http.Header) (*websocket.Conn, *http.Response, error) {
+	t.Helper()
+	srv := httptest.NewServer(g)
+	t.Cleanup(srv.Close)
+	conn, res, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+path, header)
+	if conn != nil {
+		t.Cleanup(func() { conn.Close() })
+	}
+	return conn, res, err
+}
+
+func roundTrip(t *testing.T, conn *websocket.Conn, msg string) string {
+	t.Helper()
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(msg)))
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, reply, err := conn.ReadMessage()
+	require.NoError(t, err)
+	return string(reply)
+}
+
+func TestGatewayRelaysWebSockets(t *testing.T) {
+	g, rep := newTestGateway(t, echo("agent-0"))
+	require.NoError(t, g.Serve(newTestWebSocketBinding("voice", "/voice"), newTestPool(), []router.Replica{rep}))
+
+	conn, _, err := dialGateway(t, g, "/voice", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "agent-0::hello", roundTrip(t, conn, "hello"))
+	stats, _ := g.Stats(types.NamespacedName{Namespace: "default", Name: "voice"})
+	assert.Equal(t, int32(1), stats.ActiveConnections)
+
+	// Plain requests are told to upgrade
+	w := httptest.NewRecorder()
+	g.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/voice", nil))
+	assert.Equal(t, http.StatusUpgradeRequired, w.Code)
+
+	// Browsers on other origins are turned away
+	_, res, err := dialGateway(t, g, "/voice", http.Header{"Origin": {"https://evil.example"}})
+	require.Error(t, err)
+	assert.Equal(t, http.StatusForbidden, res.StatusCode)
+
+	require.NoError(t, conn.WriteMessage(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")))
+	assert.Eventually(t, func() bool {
+		stats, _ := g.Stats(types.NamespacedName{Namespace: "default", Name: "voice"})
+		return stats.ActiveConnections == 0
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestGatewayLimitsWebSocketConnections(t *testing.T) {
+	g, rep := newTestGateway(t, echo("agent-0"))
+	binding := newTestWebSocketBinding("voice", "/voice")
+	binding.Spec.Concurrency = &neuronetes.ConcurrencyConfig{
+		MaxConcurrentRequests: int32Ptr(2),
+		PerSessionLimit:       int32Ptr(1),
+	}
+	require.NoError(t, g.Serve(binding, newTestPool(), []router.Replica{rep}))
+
+	first, _, err := dialGateway(t, g, "/voice", http.Header{SessionHeader: {"a"}})
+	require.NoError(t, err)
+	assert.Equal(t, "agent-0::hi", roundTrip(t, first, "hi"))
+
+	_, res, err := dialGateway(t, g, "/voice", http.Header{SessionHeader: {"a"}})
+	require.Error(t, err)
+	assert.Equal(t, http.StatusTooManyRequests, res.StatusCode)
+
+	_, _, err = dialGateway(t, g, "/voice", http.Header{SessionHeader: {"b"}})
+	require.NoError(t, err)
+	_, res, err = dialGateway(t, g, "/voice", http.Header{SessionHeader: {"c"}})
+	require.Error(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, res.StatusCode)
+	stats, _ := g.Stats(types.NamespacedName{Namespace: "default", Name: "voice"})
+	assert.Equal(t, ErrReplicasFull.Error(), stats.LastError)
+}
+
+func TestGatewayMigratesWebSocketsOffDrainingReplicas(t *testing.T) {
+	// Both replicas listen on the port of the gateway, on two loopback
+	// addresses
+	first := httptest.NewServer(echo("agent-0"))
+	t.Cleanup(first.Close)
+	_, port, err := net.SplitHostPort(first.Listener.Addr().String())
+	require.NoError(t, err)
+	listener, err := net.Listen("tcp", net.JoinHostPort("127.0.0.2", port))
+	if err != nil {
+		t.Skipf("second loopback address unavailable: %v", err)
+	}
+	second := httptest.NewUnstartedServer(echo("agent-1"))
+	second.Listener.Close()
+	second.Listener = listener
+	second.Start()
+	t.Cleanup(second.Close)
+
+	g := NewGateway(nil, nil)
+	g.Port, err = strconv.Atoi(port)
+	require.NoError(t, err)
+	binding := newTestWebSocketBinding("voice", "/voice")
+	binding.Spec.WebSocketConfig.MigrationGracePeriod = &metav1.Duration{Duration: 10 * time.Millisecond}
+	rep := router.Replica{Name: "agent-0", Address: "127.0.0.1", Ready: true}
+	require.NoError(t, g.Serve(binding, newTestPool(), []router.Replica{rep}))
+
+	conn, _, err := dialGateway(t, g, "/voice", http.Header{SessionHeader: {"call"}})
+	require.NoError(t, err)
+	assert.Equal(t, "agent-0::hello", roundTrip(t, conn, "hello"))
+
+	rep.Draining = true
+	next := router.Replica{Name: "agent-1", Address: "127.0.0.2", Ready: true}
+	require.NoError(t, g.Serve(binding, newTestPool(), []router.Replica{rep, next}))
+	assert.Eventually(t, func() bool {
+		return roundTrip(t, conn, "again") == "agent-1:agent-0:again"
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func int32Ptr(n int32) *int32 {
+	return &n
+}
@@ -0,0 +1,73 @@
+// Package gpu holds the extended resources and node labels GPUs are
+// advertised with, and counts the GPUs of pods for the components that
+// schedule, move or price them.
+package gpu
+
+import (
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+)
+
+const (
+	// NVIDIAResource is the extended resource of whole NVIDIA GPUs
+	NVIDIAResource corev1.ResourceName = "nvidia.com/gpu"
+
+	// AMDResource is the extended resource of AMD GPUs, advertised by the
+	// ROCm device plugin
+	AMDResource corev1.ResourceName = "amd.com/gpu"
+
+	// SharingStrategyLabel is how the NVIDIA device plugin shares the GPUs
+	// of a node, as labeled by GPU Feature Discovery: none, time-slicing or
+	// mps
+	SharingStrategyLabel = "nvidia.com/gpu.sharing-strategy"
+
+	// GKEAcceleratorLabel is the GPU model of a GKE node pool, e.g.
+	// nvidia-tesla-a100
+	GKEAcceleratorLabel = "cloud.google.com/gke-accelerator"
+)
+
+// Resources are the extended resources of whole GPUs of each vendor
+var Resources = []corev1.ResourceName{NVIDIAResource, AMDResource}
+
+// PodGPUs returns the whole GPUs of either vendor a pod requests or claims
+func PodGPUs(pod *corev1.Pod) int64 {
+	var gpus int64
+	claimed := ClaimedResources(pod)
+	for _, name := range Resources {
+		if q, ok := claimed[name]; ok {
+			gpus += q.Value()
+		}
+		for _, container := range pod.Spec.Containers {
+			if q, ok := container.Resources.Requests[name]; ok {
+				gpus += q.Value()
+			}
+		}
+	}
+	return gpus
+}
+
+// ClaimedResources returns the extended resource equivalent of the devices
+// a pod claims through DRA, from its neuronetes.io/gpu-claims annotation
+func ClaimedResources(pod *corev1.Pod) corev1.ResourceList {
+	value, ok := pod.Annotations[neuronetes.AnnotationGPUClaims]
+	if !ok {
+		return nil
+	}
+	claimed := corev1.ResourceList{}
+	for _, entry := range strings.Split(value, ",") {
+		name, count, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok {
+			continue
+		}
+		q, err := resource.ParseQuantity(count)
+		if err != nil || q.Sign() <= 0 {
+			continue
+		}
+		claimed[corev1.ResourceName(name)] = q
+	}
+	return claimed
+}
@@ -0,0 +1,32 @@
+package gpu
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+)
+
+func TestPodGPUs(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+			neuronetes.AnnotationGPUClaims: "nvidia.com/gpu=2, invalid, nvidia.com/mig-1g.5gb=1, amd.com/gpu=x",
+		}},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{
+			{Resources: corev1.ResourceRequirements{Requests: corev1.ResourceList{AMDResource: resource.MustParse("4")}}},
+			{Resources: corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("8")}}},
+		}},
+	}
+	assert.Equal(t, corev1.ResourceList{
+		NVIDIAResource:          resource.MustParse("2"),
+		"nvidia.com/mig-1g.5gb": resource.MustParse("1"),
+	}, ClaimedResources(pod))
+	assert.Equal(t, int64(6), PodGPUs(pod), "MIG slices are not whole GPUs")
+
+	assert.Nil(t, ClaimedResources(&corev1.Pod{}))
+	assert.Zero(t, PodGPUs(&corev1.Pod{}))
+}
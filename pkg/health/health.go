@@ -0,0 +1,217 @@
+// Package health aggregates the status of an agent service across the
+// Model, AgentClass and AgentPool resources that make it up.
+package health
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+)
+
+// State is the health of a component or of the whole service
+type State string
+
+const (
+	// StateReady means the component is serving normally
+	StateReady State = "Ready"
+
+	// StateDegraded means the component is serving partially or is still converging
+	StateDegraded State = "Degraded"
+
+	// StateDown means the component cannot serve
+	StateDown State = "Down"
+)
+
+// Component kinds reported in ComponentHealth
+const (
+	KindModel      = "Model"
+	KindAgentClass = "AgentClass"
+	KindAgentPool  = "AgentPool"
+)
+
+// ComponentHealth is the health of one resource in the chain
+type ComponentHealth struct {
+	Kind    string
+	Name    string
+	State   State
+	Reason  string
+	Message string
+}
+
+// ServiceHealth is the combined health of an agent service
+type ServiceHealth struct {
+	// State is the worst state across all components
+	State State
+
+	// Cause is the first unhealthy component in dependency order
+	// (Model, AgentClass, AgentPool). Nil when the service is Ready.
+	Cause *ComponentHealth
+
+	// Components lists every component in dependency order
+	Components []ComponentHealth
+}
+
+// AggregateStatus combines the status of a pool, its AgentClass and the
+// class's Model. class and model may be nil when they could not be found.
+// The references between the three objects are checked, so passing an
+// unrelated class or model reports the reference as broken.
+func AggregateStatus(pool *neuronetes.AgentPool, class *neuronetes.AgentClass, model *neuronetes.Model) ServiceHealth {
+	var components []ComponentHealth
+
+	if pool == nil {
+		components = append(components, ComponentHealth{
+			Kind:   KindAgentPool,
+			State:  StateDown,
+			Reason: "NotFound",
+		})
+		return combine(components)
+	}
+
+	// The model can only be resolved through a valid class reference
+	classHealth := classHealth(pool, class)
+	if classHealth.Reason != "NotFound" && classHealth.Reason != "ReferenceMismatch" {
+		components = append(components, modelHealth(class, model))
+	}
+
+	components = append(components, classHealth, poolHealth(pool))
+	return combine(components)
+}
+
+func combine(components []ComponentHealth) ServiceHealth {
+	health := ServiceHealth{
+		State:      StateReady,
+		Components: components,
+	}
+
+	for i := range components {
+		c := &components[i]
+		if c.State == StateReady {
+			continue
+		}
+		if health.Cause == nil {
+			health.Cause = c
+		}
+		if severity(c.State) > severity(health.State) {
+			health.State = c.State
+		}
+	}
+
+	return health
+}
+
+func severity(s State) int {
+	switch s {
+	case StateDown:
+		return 2
+	case StateDegraded:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func modelHealth(class *neuronetes.AgentClass, model *neuronetes.Model) ComponentHealth {
+	h := ComponentHealth{
+		Kind: KindModel,
+		Name: class.Spec.ModelRef.Name,
+	}
+
+	switch {
+	case model == nil:
+		h.State = StateDown
+		h.Reason = "NotFound"
+		h.Message = fmt.Sprintf("model %q not found", h.Name)
+		return h
+	case model.Name != class.Spec.ModelRef.Name:
+		h.State = StateDown
+		h.Reason = "ReferenceMismatch"
+		h.Message = fmt.Sprintf("agent class references model %q, got %q", class.Spec.ModelRef.Name, model.Name)
+		return h
+	}
+
+	switch model.Status.Phase {
+	case "Ready":
+		h.State = StateReady
+	case "Failed":
+		h.State = StateDown
+		h.Reason = "ModelFailed"
+		h.Message = "model failed to load"
+	case "Loading":
+		h.State = StateDegraded
+		h.Reason = "ModelLoading"
+		h.Message = "model weights are loading"
+	default:
+		h.State = StateDegraded
+		h.Reason = "ModelPending"
+		h.Message = "model has not started loading"
+	}
+
+	return applyConditions(h, model.Status.Conditions)
+}
+
+func classHealth(pool *neuronetes.AgentPool, class *neuronetes.AgentClass) ComponentHealth {
+	h := ComponentHealth{
+		Kind:  KindAgentClass,
+		Name:  pool.Spec.AgentClassRef.Name,
+		State: StateReady,
+	}
+
+	switch {
+	case class == nil:
+		h.State = StateDown
+		h.Reason = "NotFound"
+		h.Message = fmt.Sprintf("agent class %q not found", h.Name)
+		return h
+	case class.Name != pool.Spec.AgentClassRef.Name:
+		h.State = StateDown
+		h.Reason = "ReferenceMismatch"
+		h.Message = fmt.Sprintf("agent pool references agent class %q, got %q", pool.Spec.AgentClassRef.Name, class.Name)
+		return h
+	}
+
+	return applyConditions(h, class.Status.Conditions)
+}
+
+func poolHealth(pool *neuronetes.AgentPool) ComponentHealth {
+	h := ComponentHealth{
+		Kind:  KindAgentPool,
+		Name:  pool.Name,
+		State: StateReady,
+	}
+
+	status := pool.Status
+	switch {
+	case status.Replicas == 0 && pool.Spec.MinReplicas == 0:
+		// Scaled to zero by design
+	case status.ReadyReplicas == 0:
+		h.State = StateDown
+		h.Reason = "NoReadyReplicas"
+		h.Message = fmt.Sprintf("0/%d replicas ready", status.Replicas)
+	case status.ReadyReplicas < status.Replicas:
+		h.State = StateDegraded
+		h.Reason = "ReplicasNotReady"
+		h.Message = fmt.Sprintf("%d/%d replicas ready", status.ReadyReplicas, status.Replicas)
+	}
+
+	return applyConditions(h, status.Conditions)
+}
+
+// applyConditions degrades a healthy component whose Ready condition is False
+func applyConditions(h ComponentHealth, conditions []metav1.Condition) ComponentHealth {
+	if h.State != StateReady {
+		return h
+	}
+
+	cond := meta.FindStatusCondition(conditions, "Ready")
+	if cond == nil || cond.Status != metav1.ConditionFalse {
+		return h
+	}
+
+	h.State = StateDegraded
+	h.Reason = cond.Reason
+	h.Message = cond.Message
+	return h
+}
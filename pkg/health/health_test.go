@@ -0,0 +1,128 @@
+package health
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+)
+
+func fixtures() (*neuronetes.AgentPool, *neuronetes.AgentClass, *neuronetes.Model) {
+	model := &neuronetes.Model{
+		ObjectMeta: metav1.ObjectMeta{Name: "llama-3-8b", Namespace: "default"},
+		Status:     neuronetes.ModelStatus{Phase: "Ready"},
+	}
+	class := &neuronetes.AgentClass{
+		ObjectMeta: metav1.ObjectMeta{Name: "chat-agent", Namespace: "default"},
+		Spec: neuronetes.AgentClassSpec{
+			ModelRef: neuronetes.ModelReference{Name: "llama-3-8b"},
+		},
+	}
+	pool := &neuronetes.AgentPool{
+		ObjectMeta: metav1.ObjectMeta{Name: "chat-pool", Namespace: "default"},
+		Spec: neuronetes.AgentPoolSpec{
+			AgentClassRef: neuronetes.AgentClassReference{Name: "chat-agent"},
+			MinReplicas:   1,
+			MaxReplicas:   4,
+		},
+		Status: neuronetes.AgentPoolStatus{Replicas: 2, ReadyReplicas: 2},
+	}
+	return pool, class, model
+}
+
+func TestAggregateStatusAllReady(t *testing.T) {
+	pool, class, model := fixtures()
+
+	health := AggregateStatus(pool, class, model)
+
+	assert.Equal(t, StateReady, health.State)
+	assert.Nil(t, health.Cause)
+	require.Len(t, health.Components, 3)
+	assert.Equal(t, KindModel, health.Components[0].Kind)
+	assert.Equal(t, KindAgentClass, health.Components[1].Kind)
+	assert.Equal(t, KindAgentPool, health.Components[2].Kind)
+}
+
+func TestAggregateStatusModelLoading(t *testing.T) {
+	pool, class, model := fixtures()
+	model.Status.Phase = "Loading"
+	pool.Status.ReadyReplicas = 1
+
+	health := AggregateStatus(pool, class, model)
+
+	assert.Equal(t, StateDegraded, health.State)
+	require.NotNil(t, health.Cause)
+	assert.Equal(t, KindModel, health.Cause.Kind)
+	assert.Equal(t, "llama-3-8b", health.Cause.Name)
+	assert.Equal(t, "ModelLoading", health.Cause.Reason)
+}
+
+func TestAggregateStatusMissingClass(t *testing.T) {
+	pool, _, model := fixtures()
+
+	health := AggregateStatus(pool, nil, model)
+
+	assert.Equal(t, StateDown, health.State)
+	require.NotNil(t, health.Cause)
+	assert.Equal(t, KindAgentClass, health.Cause.Kind)
+	assert.Equal(t, "chat-agent", health.Cause.Name)
+	assert.Equal(t, "NotFound", health.Cause.Reason)
+	assert.Len(t, health.Components, 2)
+}
+
+func TestAggregateStatusEdgeCases(t *testing.T) {
+	t.Run("missing pool", func(t *testing.T) {
+		health := AggregateStatus(nil, nil, nil)
+		assert.Equal(t, StateDown, health.State)
+		assert.Equal(t, KindAgentPool, health.Cause.Kind)
+	})
+
+	t.Run("mismatched model reference", func(t *testing.T) {
+		pool, class, model := fixtures()
+		model.Name = "other-model"
+		health := AggregateStatus(pool, class, model)
+		assert.Equal(t, StateDown, health.State)
+		assert.Equal(t, "ReferenceMismatch", health.Cause.Reason)
+	})
+
+	t.Run("model failed outranks pool", func(t *testing.T) {
+		pool, class, model := fixtures()
+		model.Status.Phase = "Failed"
+		pool.Status.ReadyReplicas = 0
+		health := AggregateStatus(pool, class, model)
+		assert.Equal(t, StateDown, health.State)
+		assert.Equal(t, KindModel, health.Cause.Kind)
+	})
+
+	t.Run("no ready replicas", func(t *testing.T) {
+		pool, class, model := fixtures()
+		pool.Status.ReadyReplicas = 0
+		health := AggregateStatus(pool, class, model)
+		assert.Equal(t, StateDown, health.State)
+		assert.Equal(t, "NoReadyReplicas", health.Cause.Reason)
+	})
+
+	t.Run("scaled to zero is ready", func(t *testing.T) {
+		pool, class, model := fixtures()
+		pool.Spec.MinReplicas = 0
+		pool.Status = neuronetes.AgentPoolStatus{}
+		health := AggregateStatus(pool, class, model)
+		assert.Equal(t, StateReady, health.State)
+	})
+
+	t.Run("false ready condition degrades", func(t *testing.T) {
+		pool, class, model := fixtures()
+		class.Status.Conditions = []metav1.Condition{{
+			Type:   "Ready",
+			Status: metav1.ConditionFalse,
+			Reason: "GuardrailUnavailable",
+		}}
+		health := AggregateStatus(pool, class, model)
+		assert.Equal(t, StateDegraded, health.State)
+		assert.Equal(t, KindAgentClass, health.Cause.Kind)
+		assert.Equal(t, "GuardrailUnavailable", health.Cause.Reason)
+	})
+}
@@ -0,0 +1,255 @@
+package introspection
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+const (
+	// ggufMagic starts every GGUF file, "GGUF" in little-endian
+	ggufMagic = 0x46554747
+
+	// maxGGUFString bounds the strings of GGUF headers, the longest being
+	// chat templates
+	maxGGUFString = 16 << 20
+
+	// maxGGUFDims is the most dimensions of a GGUF tensor
+	maxGGUFDims = 4
+)
+
+// Types of GGUF metadata values
+const (
+	ggufUint8 uint32 = iota
+	ggufInt8
+	ggufUint16
+	ggufInt16
+	ggufUint32
+	ggufInt32
+	ggufFloat32
+	ggufBool
+	ggufString
+	ggufArray
+	ggufUint64
+	ggufInt64
+	ggufFloat64
+)
+
+// errInvalidGGUF is returned for files that are not GGUF v2 or v3
+var errInvalidGGUF = errors.New("not a GGUF v2 or v3 file")
+
+// ggufFile is the header of a GGUF file: the scalar and string values of
+// its metadata, and the elements of its tensors
+type ggufFile struct {
+	values     map[string]interface{}
+	parameters int64
+}
+
+// readGGUF reads the header of the GGUF file at path. Arrays of the
+// metadata, such as the vocabulary, are skipped.
+func readGGUF(path string) (*ggufFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := &ggufReader{r: bufio.NewReader(f)}
+
+	if magic := r.uint32(); r.err == nil && magic != ggufMagic {
+		return nil, errInvalidGGUF
+	}
+	if version := r.uint32(); r.err == nil && version != 2 && version != 3 {
+		return nil, errInvalidGGUF
+	}
+	tensors := r.uint64()
+	kvs := r.uint64()
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	file := &ggufFile{values: make(map[string]interface{})}
+	for i := uint64(0); i < kvs && r.err == nil; i++ {
+		key := r.string()
+		value := r.value(r.uint32())
+		if value != nil {
+			file.values[key] = value
+		}
+	}
+	for i := uint64(0); i < tensors && r.err == nil; i++ {
+		r.skipString()
+		dims := r.uint32()
+		if dims > maxGGUFDims {
+			return nil, fmt.Errorf("tensor %d has %d dimensions", i, dims)
+		}
+		elements := int64(1)
+		for d := uint32(0); d < dims; d++ {
+			elements *= int64(r.uint64())
+		}
+		r.uint32() // type
+		r.uint64() // offset
+		file.parameters += elements
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return file, nil
+}
+
+// apply sets the fields of metadata the metadata of the file describes
+func (f *ggufFile) apply(metadata *Metadata) {
+	architecture := f.string("general.architecture")
+	setString(&metadata.Architecture, architecture)
+	setString(&metadata.Tokenizer, f.string("tokenizer.ggml.model"))
+	if architecture == "" {
+		return
+	}
+
+	heads := f.int(architecture + ".attention.head_count")
+	headDim := f.int(architecture + ".attention.key_length")
+	if embedding := f.int(architecture + ".embedding_length"); headDim == 0 && heads > 0 {
+		headDim = embedding / heads
+	}
+	setInt(&metadata.ContextLength, f.int(architecture+".context_length"))
+	setInt(&metadata.NumLayers, f.int(architecture+".block_count"))
+	setInt(&metadata.NumKVHeads, first(f.int(architecture+".attention.head_count_kv"), heads))
+	setInt(&metadata.HeadDim, headDim)
+}
+
+// string returns the string value of key
+func (f *ggufFile) string(key string) string {
+	s, _ := f.values[key].(string)
+	return s
+}
+
+// int returns the integer value of key
+func (f *ggufFile) int(key string) int64 {
+	switch v := f.values[key].(type) {
+	case int64:
+		return v
+	case uint64:
+		if v > math.MaxInt64 {
+			return 0
+		}
+		return int64(v)
+	}
+	return 0
+}
+
+// ggufReader reads little-endian GGUF values, keeping the first error
+type ggufReader struct {
+	r   *bufio.Reader
+	err error
+}
+
+func (r *ggufReader) read(v interface{}) {
+	if r.err == nil {
+		r.err = binary.Read(r.r, binary.LittleEndian, v)
+	}
+}
+
+func (r *ggufReader) uint32() uint32 {
+	var v uint32
+	r.read(&v)
+	return v
+}
+
+func (r *ggufReader) uint64() uint64 {
+	var v uint64
+	r.read(&v)
+	return v
+}
+
+// length reads the length of a string
+func (r *ggufReader) length() int {
+	n := r.uint64()
+	if r.err == nil && n > maxGGUFString {
+		r.err = fmt.Errorf("string of %d bytes", n)
+	}
+	return int(n)
+}
+
+func (r *ggufReader) string() string {
+	n := r.length()
+	if r.err != nil {
+		return ""
+	}
+	buf := make([]byte, n)
+	_, r.err = io.ReadFull(r.r, buf)
+	return string(buf)
+}
+
+func (r *ggufReader) skipString() {
+	n := r.length()
+	if r.err == nil {
+		_, r.err = r.r.Discard(n)
+	}
+}
+
+// value reads a metadata value of type t. Integers are returned as int64
+// or uint64 and strings as string; other values are skipped and returned
+// as nil.
+func (r *ggufReader) value(t uint32) interface{} {
+	switch t {
+	case ggufUint8:
+		var v uint8
+		r.read(&v)
+		return uint64(v)
+	case ggufInt8:
+		var v int8
+		r.read(&v)
+		return int64(v)
+	case ggufUint16:
+		var v uint16
+		r.read(&v)
+		return uint64(v)
+	case ggufInt16:
+		var v int16
+		r.read(&v)
+		return int64(v)
+	case ggufUint32:
+		return uint64(r.uint32())
+	case ggufInt32:
+		var v int32
+		r.read(&v)
+		return int64(v)
+	case ggufUint64:
+		return r.uint64()
+	case ggufInt64:
+		var v int64
+		r.read(&v)
+		return v
+	case ggufString:
+		return r.string()
+	case ggufBool:
+		r.skip(1)
+	case ggufFloat32:
+		r.skip(4)
+	case ggufFloat64:
+		r.skip(8)
+	case ggufArray:
+		elem := r.uint32()
+		n := r.uint64()
+		for i := uint64(0); i < n && r.err == nil; i++ {
+			if elem == ggufString {
+				r.skipString()
+			} else {
+				r.value(elem)
+			}
+		}
+	default:
+		if r.err == nil {
+			r.err = fmt.Errorf("unknown metadata type %d", t)
+		}
+	}
+	return nil
+}
+
+func (r *ggufReader) skip(n int) {
+	if r.err == nil {
+		_, r.err = r.r.Discard(n)
+	}
+}
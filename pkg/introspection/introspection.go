@@ -0,0 +1,272 @@
+// Package introspection reads what a model is from its downloaded weights:
+// the metadata of GGUF files, the tensor headers of safetensors files, and
+// the HuggingFace configuration and tokenizer files beside them. Only
+// headers are read, never the tensors themselves.
+package introspection
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Formats of weights
+const (
+	FormatGGUF        = "gguf"
+	FormatSafetensors = "safetensors"
+)
+
+// ErrNoMetadata is returned for directories without GGUF, safetensors or
+// config.json files
+var ErrNoMetadata = errors.New("no GGUF, safetensors or config.json files")
+
+// Metadata describes the model of a directory of weights. Fields that could
+// not be read are zero.
+type Metadata struct {
+	// Format is FormatGGUF or FormatSafetensors
+	Format string
+
+	// Architecture is the architecture of the model, e.g. llama
+	Architecture string
+
+	// ParameterCount is the number of parameters of the model, the elements
+	// of its tensors
+	ParameterCount int64
+
+	// ContextLength is the context window the model was trained with
+	ContextLength int64
+
+	// Tokenizer is the tokenizer of the model, e.g. BPE or llama
+	Tokenizer string
+
+	// NumLayers is the number of transformer layers
+	NumLayers int64
+
+	// NumKVHeads is the number of key-value attention heads of each layer
+	NumKVHeads int64
+
+	// HeadDim is the dimension of each attention head
+	HeadDim int64
+}
+
+// files are the files of a directory of weights metadata is read from
+type files struct {
+	gguf            []string
+	safetensors     []string
+	config          string
+	tokenizer       string
+	tokenizerConfig string
+}
+
+// Inspect reads the metadata of the weights downloaded into dir. GGUF files
+// describe themselves; the metadata of safetensors weights is read from the
+// config.json and tokenizer files of the model, and their parameters are
+// counted from the tensor headers.
+func Inspect(dir string) (*Metadata, error) {
+	found, err := find(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := &Metadata{}
+	switch {
+	case len(found.gguf) > 0 && len(found.safetensors) == 0:
+		metadata.Format = FormatGGUF
+		for i, path := range found.gguf {
+			// Split GGUF files each hold the metadata and their share of
+			// the tensors
+			file, err := readGGUF(path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read %s: %w", path, err)
+			}
+			if i == 0 {
+				file.apply(metadata)
+			}
+			metadata.ParameterCount += file.parameters
+		}
+	case len(found.safetensors) > 0:
+		metadata.Format = FormatSafetensors
+		for _, path := range found.safetensors {
+			parameters, err := countSafetensors(path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read %s: %w", path, err)
+			}
+			metadata.ParameterCount += parameters
+		}
+	case found.config == "":
+		return nil, ErrNoMetadata
+	}
+
+	if found.config != "" {
+		var config hfConfig
+		if err := readJSON(found.config, &config); err != nil {
+			return nil, err
+		}
+		if config.quantized() && metadata.Format == FormatSafetensors {
+			// The tensors of quantized weights are packed, so their
+			// elements are not the parameters
+			metadata.ParameterCount = 0
+		}
+		config.apply(metadata)
+	}
+	if metadata.Tokenizer == "" {
+		metadata.Tokenizer = found.readTokenizer()
+	}
+	return metadata, nil
+}
+
+// find returns the files of dir metadata is read from. Of the configuration
+// and tokenizer files, the shallowest are used.
+func find(dir string) (*files, error) {
+	found := &files{}
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		switch name := entry.Name(); {
+		case strings.HasSuffix(name, ".gguf"):
+			found.gguf = append(found.gguf, path)
+		case strings.HasSuffix(name, ".safetensors"):
+			found.safetensors = append(found.safetensors, path)
+		case name == "config.json":
+			found.config = shallowest(found.config, path)
+		case name == "tokenizer.json":
+			found.tokenizer = shallowest(found.tokenizer, path)
+		case name == "tokenizer_config.json":
+			found.tokenizerConfig = shallowest(found.tokenizerConfig, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(found.gguf)
+	sort.Strings(found.safetensors)
+	return found, nil
+}
+
+// shallowest returns whichever of current and path is nearer the root
+func shallowest(current, path string) string {
+	if current == "" || strings.Count(path, string(filepath.Separator)) < strings.Count(current, string(filepath.Separator)) {
+		return path
+	}
+	return current
+}
+
+// readTokenizer returns the type of the tokenizer model of tokenizer.json,
+// or else the tokenizer class of tokenizer_config.json
+func (f *files) readTokenizer() string {
+	if f.tokenizer != "" {
+		var tokenizer struct {
+			Model struct {
+				Type string `json:"type"`
+			} `json:"model"`
+		}
+		if err := readJSON(f.tokenizer, &tokenizer); err == nil && tokenizer.Model.Type != "" {
+			return tokenizer.Model.Type
+		}
+	}
+	if f.tokenizerConfig != "" {
+		var config struct {
+			TokenizerClass string `json:"tokenizer_class"`
+		}
+		if err := readJSON(f.tokenizerConfig, &config); err == nil {
+			return config.TokenizerClass
+		}
+	}
+	return ""
+}
+
+// hfConfig is the config.json of a HuggingFace transformers model. Older
+// architectures name the same settings differently.
+type hfConfig struct {
+	ModelType             string          `json:"model_type"`
+	Architectures         []string        `json:"architectures"`
+	MaxPositionEmbeddings int64           `json:"max_position_embeddings"`
+	NPositions            int64           `json:"n_positions"`
+	SeqLength             int64           `json:"seq_length"`
+	NumHiddenLayers       int64           `json:"num_hidden_layers"`
+	NLayer                int64           `json:"n_layer"`
+	NumAttentionHeads     int64           `json:"num_attention_heads"`
+	NHead                 int64           `json:"n_head"`
+	NumKeyValueHeads      int64           `json:"num_key_value_heads"`
+	HiddenSize            int64           `json:"hidden_size"`
+	NEmbd                 int64           `json:"n_embd"`
+	HeadDim               int64           `json:"head_dim"`
+	QuantizationConfig    json.RawMessage `json:"quantization_config"`
+
+	// TextConfig holds the settings of the language model of multimodal
+	// models
+	TextConfig *hfConfig `json:"text_config"`
+}
+
+// quantized returns true if the weights of config are quantized
+func (c *hfConfig) quantized() bool {
+	return len(c.QuantizationConfig) > 0 && string(c.QuantizationConfig) != "null"
+}
+
+// apply sets the fields of metadata config describes and metadata does not
+func (c *hfConfig) apply(metadata *Metadata) {
+	architecture := c.ModelType
+	if architecture == "" && len(c.Architectures) > 0 {
+		architecture = c.Architectures[0]
+	}
+	setString(&metadata.Architecture, architecture)
+	if c.TextConfig != nil {
+		c.TextConfig.apply(metadata)
+	}
+
+	layers := first(c.NumHiddenLayers, c.NLayer)
+	heads := first(c.NumAttentionHeads, c.NHead)
+	headDim := c.HeadDim
+	if hidden := first(c.HiddenSize, c.NEmbd); headDim == 0 && heads > 0 {
+		headDim = hidden / heads
+	}
+
+	setInt(&metadata.ContextLength, first(c.MaxPositionEmbeddings, c.NPositions, c.SeqLength))
+	setInt(&metadata.NumLayers, layers)
+	setInt(&metadata.NumKVHeads, first(c.NumKeyValueHeads, heads))
+	setInt(&metadata.HeadDim, headDim)
+}
+
+// readJSON decodes the JSON file at path into v
+func readJSON(path string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("invalid %s: %w", path, err)
+	}
+	return nil
+}
+
+// first returns the first positive value
+func first(values ...int64) int64 {
+	for _, v := range values {
+		if v > 0 {
+			return v
+		}
+	}
+	return 0
+}
+
+func setString(field *string, value string) {
+	if *field == "" {
+		*field = value
+	}
+}
+
+func setInt(field *int64, value int64) {
+	if *field == 0 && value > 0 {
+		*field = value
+	}
+}
@@ -0,0 +1,177 @@
+package introspection
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ggufWriter builds GGUF v3 files
+type ggufWriter struct {
+	kvs     bytes.Buffer
+	nkv     uint64
+	tensors bytes.Buffer
+	ntensor uint64
+}
+
+func (w *ggufWriter) string(buf *bytes.Buffer, s string) {
+	_ = binary.Write(buf, binary.LittleEndian, uint64(len(s)))
+	buf.WriteString(s)
+}
+
+func (w *ggufWriter) kv(key string, t uint32, value interface{}) {
+	w.nkv++
+	w.string(&w.kvs, key)
+	_ = binary.Write(&w.kvs, binary.LittleEndian, t)
+	if s, ok := value.(string); ok {
+		w.string(&w.kvs, s)
+		return
+	}
+	_ = binary.Write(&w.kvs, binary.LittleEndian, value)
+}
+
+func (w *ggufWriter) tokens(key string, tokens ...string) {
+	w.nkv++
+	w.string(&w.kvs, key)
+	_ = binary.Write(&w.kvs, binary.LittleEndian, ggufArray)
+	_ = binary.Write(&w.kvs, binary.LittleEndian, ggufString)
+	_ = binary.Write(&w.kvs, binary.LittleEndian, uint64(len(tokens)))
+	for _, token := range tokens {
+		w.string(&w.kvs, token)
+	}
+}
+
+func (w *ggufWriter) tensor(name string, dims ...uint64) {
+	w.ntensor++
+	w.string(&w.tensors, name)
+	_ = binary.Write(&w.tensors, binary.LittleEndian, uint32(len(dims)))
+	_ = binary.Write(&w.tensors, binary.LittleEndian, dims)
+	_ = binary.Write(&w.tensors, binary.LittleEndian, uint32(12)) // Q4_K
+	_ = binary.Write(&w.tensors, binary.LittleEndian, uint64(0))
+}
+
+func (w *ggufWriter) write(t *testing.T, path string) {
+	var buf bytes.Buffer
+	_ = binary.Write(&buf, binary.LittleEndian, []uint32{ggufMagic, 3})
+	_ = binary.Write(&buf, binary.LittleEndian, []uint64{w.ntensor, w.nkv})
+	buf.Write(w.kvs.Bytes())
+	buf.Write(w.tensors.Bytes())
+	require.NoError(t, os.WriteFile(path, buf.Bytes(), 0o644))
+}
+
+func writeSafetensors(t *testing.T, path, header string) {
+	var buf bytes.Buffer
+	_ = binary.Write(&buf, binary.LittleEndian, uint64(len(header)))
+	buf.WriteString(header)
+	buf.Write(make([]byte, 64))
+	require.NoError(t, os.WriteFile(path, buf.Bytes(), 0o644))
+}
+
+func TestInspectGGUF(t *testing.T) {
+	dir := t.TempDir()
+	w := &ggufWriter{}
+	w.kv("general.architecture", ggufString, "llama")
+	w.kv("general.file_type", ggufUint32, uint32(15))
+	w.kv("general.quantization_version", ggufUint32, uint32(2))
+	w.kv("llama.rope.freq_base", ggufFloat32, float32(500000))
+	w.kv("llama.context_length", ggufUint32, uint32(131072))
+	w.kv("llama.block_count", ggufUint32, uint32(32))
+	w.kv("llama.embedding_length", ggufUint32, uint32(4096))
+	w.kv("llama.attention.head_count", ggufUint32, uint32(32))
+	w.kv("llama.attention.head_count_kv", ggufUint32, uint32(8))
+	w.kv("tokenizer.ggml.model", ggufString, "gpt2")
+	w.tokens("tokenizer.ggml.tokens", "<|begin_of_text|>", "hello", "world")
+	w.kv("tokenizer.ggml.add_bos_token", ggufBool, true)
+	w.tensor("token_embd.weight", 4096, 128256)
+	w.tensor("blk.0.attn_q.weight", 4096, 4096)
+	w.tensor("output_norm.weight", 4096)
+	w.write(t, filepath.Join(dir, "Meta-Llama-3.1-8B-Instruct-Q4_K_M.gguf"))
+
+	metadata, err := Inspect(dir)
+	require.NoError(t, err)
+	assert.Equal(t, &Metadata{
+		Format:         FormatGGUF,
+		Architecture:   "llama",
+		ParameterCount: 4096*128256 + 4096*4096 + 4096,
+		ContextLength:  131072,
+		Tokenizer:      "gpt2",
+		NumLayers:      32,
+		NumKVHeads:     8,
+		HeadDim:        128,
+	}, metadata)
+}
+
+func TestInspectSafetensors(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "config.json"), []byte(`{
+		"architectures": ["Qwen2ForCausalLM"],
+		"model_type": "qwen2",
+		"hidden_size": 896,
+		"max_position_embeddings": 32768,
+		"num_attention_heads": 14,
+		"num_hidden_layers": 24,
+		"num_key_value_heads": 2,
+		"torch_dtype": "bfloat16",
+		"rope_scaling": null
+	}`), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "tokenizer.json"), []byte(`{"model": {"type": "BPE", "vocab": {}}}`), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "tokenizer_config.json"), []byte(`{"tokenizer_class": "Qwen2Tokenizer"}`), 0o644))
+	writeSafetensors(t, filepath.Join(dir, "model-00001-of-00002.safetensors"),
+		`{"__metadata__": {"format": "pt"}, "model.embed_tokens.weight": {"dtype": "BF16", "shape": [151936, 896], "data_offsets": [0, 0]}}`)
+	writeSafetensors(t, filepath.Join(dir, "model-00002-of-00002.safetensors"),
+		`{"model.norm.weight": {"dtype": "BF16", "shape": [896], "data_offsets": [0, 0]}}`)
+
+	metadata, err := Inspect(dir)
+	require.NoError(t, err)
+	assert.Equal(t, &Metadata{
+		Format:         FormatSafetensors,
+		Architecture:   "qwen2",
+		ParameterCount: 151936*896 + 896,
+		ContextLength:  32768,
+		Tokenizer:      "BPE",
+		NumLayers:      24,
+		NumKVHeads:     2,
+		HeadDim:        64,
+	}, metadata)
+
+	// The packed tensors of quantized weights are not counted
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "config.json"), []byte(`{
+		"model_type": "gpt2",
+		"n_positions": 1024,
+		"n_layer": 12,
+		"n_head": 12,
+		"n_embd": 768,
+		"quantization_config": {"quant_method": "gptq", "bits": 4}
+	}`), 0o644))
+	metadata, err = Inspect(dir)
+	require.NoError(t, err)
+	assert.Equal(t, &Metadata{
+		Format:        FormatSafetensors,
+		Architecture:  "gpt2",
+		ContextLength: 1024,
+		Tokenizer:     "BPE",
+		NumLayers:     12,
+		NumKVHeads:    12,
+		HeadDim:       64,
+	}, metadata)
+}
+
+func TestInspectInvalid(t *testing.T) {
+	_, err := Inspect(t.TempDir())
+	assert.ErrorIs(t, err, ErrNoMetadata)
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "model.gguf"), bytes.Repeat([]byte("weights"), 100), 0o644))
+	_, err = Inspect(dir)
+	assert.ErrorIs(t, err, errInvalidGGUF)
+
+	dir = t.TempDir()
+	writeSafetensors(t, filepath.Join(dir, "model.safetensors"), `{"weight": {"shape": "4096"}}`)
+	_, err = Inspect(dir)
+	assert.Error(t, err)
+}
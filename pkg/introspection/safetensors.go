@@ -0,0 +1,61 @@
+package introspection
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// maxSafetensorsHeader bounds the JSON header of safetensors files
+const maxSafetensorsHeader = 100 << 20
+
+// safetensorsMetadataKey is the key of the free-form metadata of a
+// safetensors header, the only one that is not a tensor
+const safetensorsMetadataKey = "__metadata__"
+
+// countSafetensors returns the elements of the tensors of the safetensors
+// file at path, read from its header
+func countSafetensors(path string) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	var n uint64
+	if err := binary.Read(f, binary.LittleEndian, &n); err != nil {
+		return 0, err
+	}
+	if n > maxSafetensorsHeader {
+		return 0, fmt.Errorf("header of %d bytes", n)
+	}
+	header := make([]byte, n)
+	if _, err := io.ReadFull(f, header); err != nil {
+		return 0, err
+	}
+
+	var tensors map[string]json.RawMessage
+	if err := json.Unmarshal(header, &tensors); err != nil {
+		return 0, fmt.Errorf("invalid header: %w", err)
+	}
+	var parameters int64
+	for name, raw := range tensors {
+		if name == safetensorsMetadataKey {
+			continue
+		}
+		var tensor struct {
+			Shape []int64 `json:"shape"`
+		}
+		if err := json.Unmarshal(raw, &tensor); err != nil {
+			return 0, fmt.Errorf("invalid tensor %s: %w", name, err)
+		}
+		elements := int64(1)
+		for _, dim := range tensor.Shape {
+			elements *= dim
+		}
+		parameters += elements
+	}
+	return parameters, nil
+}
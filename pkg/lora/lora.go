@@ -0,0 +1,142 @@
+// Package lora loads LoRA adapters onto the runtime of agent replicas. A
+// runtime serving a base model applies any number of adapters to it per
+// request, so that one replica serves many fine-tunes, and adapters come
+// and go without restarting it.
+package lora
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// LoadPath is the endpoint of OpenAI-compatible runtimes, such as
+	// vLLM, loading an adapter
+	LoadPath = "/v1/load_lora_adapter"
+
+	// UnloadPath is the endpoint of OpenAI-compatible runtimes unloading
+	// an adapter
+	UnloadPath = "/v1/unload_lora_adapter"
+
+	// DefaultTimeout bounds loading or unloading an adapter
+	DefaultTimeout = 2 * time.Minute
+)
+
+// Adapter is an adapter loaded onto a runtime
+type Adapter struct {
+	// Name is the model name requests select the adapter by
+	Name string
+
+	// Path is where the runtime reads the weights of the adapter from
+	Path string
+
+	// Scaling, if not nil, overrides the scaling of the adapter
+	Scaling *float32
+}
+
+// Loader loads adapters onto the runtime of agent replicas
+type Loader interface {
+	// Load loads adapter onto the runtime of pod, replacing an adapter of
+	// the same name
+	Load(ctx context.Context, pod *corev1.Pod, adapter Adapter) error
+
+	// Unload unloads the adapter name from the runtime of pod. Unloading
+	// an adapter the runtime does not have succeeds.
+	Unload(ctx context.Context, pod *corev1.Pod, name string) error
+}
+
+// Client loads adapters through the LoRA endpoints of the runtime serving
+// on their pod's IP
+type Client struct {
+	http *http.Client
+	port int32
+}
+
+var _ Loader = &Client{}
+
+// NewClient creates a client loading adapters onto runtimes serving on port
+func NewClient(port int32) *Client {
+	return &Client{http: &http.Client{Timeout: DefaultTimeout}, port: port}
+}
+
+// adapterRequest is the body of the LoRA endpoints of vLLM
+type adapterRequest struct {
+	Name    string   `json:"lora_name"`
+	Path    string   `json:"lora_path,omitempty"`
+	Scaling *float32 `json:"scaling,omitempty"`
+}
+
+// statusError is the error of a request the runtime refused
+type statusError struct {
+	code    int
+	message string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.code, http.StatusText(e.code), e.message)
+}
+
+// Load implements Loader. Runtimes refuse to load an adapter under a name
+// already loaded, so it is unloaded first, which also picks up changed
+// weights.
+func (c *Client) Load(ctx context.Context, pod *corev1.Pod, adapter Adapter) error {
+	if err := c.Unload(ctx, pod, adapter.Name); err != nil {
+		return err
+	}
+	req := adapterRequest{Name: adapter.Name, Path: adapter.Path, Scaling: adapter.Scaling}
+	if err := c.post(ctx, pod, LoadPath, req); err != nil {
+		return fmt.Errorf("failed to load adapter %s onto pod %s: %w", adapter.Name, pod.Name, err)
+	}
+	return nil
+}
+
+// Unload implements Loader
+func (c *Client) Unload(ctx context.Context, pod *corev1.Pod, name string) error {
+	err := c.post(ctx, pod, UnloadPath, adapterRequest{Name: name})
+	var status *statusError
+	if errors.As(err, &status) && (status.code == http.StatusNotFound || status.code == http.StatusBadRequest) {
+		// vLLM refuses to unload adapters it does not have
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to unload adapter %s from pod %s: %w", name, pod.Name, err)
+	}
+	return nil
+}
+
+func (c *Client) post(ctx context.Context, pod *corev1.Pod, path string, body adapterRequest) error {
+	if pod.Status.PodIP == "" {
+		return fmt.Errorf("pod %s has no IP", pod.Name)
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	url := "http://" + net.JoinHostPort(pod.Status.PodIP, strconv.Itoa(int(c.port))) + path
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return &statusError{code: resp.StatusCode, message: string(bytes.TrimSpace(message))}
+	}
+	_, err = io.Copy(io.Discard, resp.Body)
+	return err
+}
@@ -0,0 +1,75 @@
+package lora
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// fakeRuntime serves the LoRA endpoints of vLLM
+type fakeRuntime struct {
+	loaded map[string]adapterRequest
+}
+
+func (f *fakeRuntime) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req adapterRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	_, ok := f.loaded[req.Name]
+	switch {
+	case r.URL.Path == LoadPath && ok:
+		http.Error(w, "adapter already loaded", http.StatusBadRequest)
+	case r.URL.Path == LoadPath && req.Path == "broken":
+		http.Error(w, "no adapter_config.json", http.StatusInternalServerError)
+	case r.URL.Path == LoadPath:
+		f.loaded[req.Name] = req
+	case r.URL.Path == UnloadPath && !ok:
+		http.Error(w, "adapter not found", http.StatusNotFound)
+	case r.URL.Path == UnloadPath:
+		delete(f.loaded, req.Name)
+	}
+}
+
+func TestClientLoadsAndUnloadsAdapters(t *testing.T) {
+	runtime := &fakeRuntime{loaded: make(map[string]adapterRequest)}
+	server := httptest.NewServer(runtime)
+	defer server.Close()
+	host, portString, err := net.SplitHostPort(strings.TrimPrefix(server.URL, "http://"))
+	require.NoError(t, err)
+	port, err := strconv.Atoi(portString)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "chat-pool-0"}, Status: corev1.PodStatus{PodIP: host}}
+	client := NewClient(int32(port))
+	scaling := float32(2)
+
+	require.NoError(t, client.Load(ctx, pod, Adapter{Name: "support", Path: "/cache/support", Scaling: &scaling}))
+	assert.Equal(t, map[string]adapterRequest{"support": {Name: "support", Path: "/cache/support", Scaling: &scaling}}, runtime.loaded)
+
+	// Loading an adapter again replaces it
+	require.NoError(t, client.Load(ctx, pod, Adapter{Name: "support", Path: "/cache/support-v2"}))
+	assert.Equal(t, "/cache/support-v2", runtime.loaded["support"].Path)
+
+	err = client.Load(ctx, pod, Adapter{Name: "legal", Path: "broken"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "no adapter_config.json")
+
+	require.NoError(t, client.Unload(ctx, pod, "support"))
+	assert.Empty(t, runtime.loaded)
+	require.NoError(t, client.Unload(ctx, pod, "support"))
+
+	assert.Error(t, client.Unload(ctx, &corev1.Pod{}, "support"))
+}
@@ -0,0 +1,87 @@
+/*
+Copyright 2024 NeuroNetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// DefaultMaxLabelValues is the number of distinct values kept for each
+	// label before further values are folded into OverflowLabelValue
+	DefaultMaxLabelValues = 100
+
+	// OverflowLabelValue replaces the values of a label past its bound
+	OverflowLabelValue = "other"
+
+	// UnknownLabelValue replaces empty label values
+	UnknownLabelValue = "unknown"
+)
+
+// cardinalityGuard bounds the distinct values of each label, so that
+// clients sending arbitrary models, routes or tenants cannot grow the
+// number of series without limit
+type cardinalityGuard struct {
+	mu        sync.Mutex
+	max       int
+	seen      map[string]map[string]struct{}
+	overflows *prometheus.CounterVec
+	values    *prometheus.GaugeVec
+}
+
+func newCardinalityGuard(max int, overflows *prometheus.CounterVec, values *prometheus.GaugeVec) *cardinalityGuard {
+	return &cardinalityGuard{
+		max:       max,
+		seen:      map[string]map[string]struct{}{},
+		overflows: overflows,
+		values:    values,
+	}
+}
+
+// value returns the value to record for label: value itself while the label
+// has fewer than max distinct values, or OverflowLabelValue past them
+func (g *cardinalityGuard) value(label, value string) string {
+	if value == "" {
+		return UnknownLabelValue
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	values, ok := g.seen[label]
+	if !ok {
+		values = map[string]struct{}{}
+		g.seen[label] = values
+	}
+	if _, ok := values[value]; ok {
+		return value
+	}
+	if g.max > 0 && len(values) >= g.max {
+		g.overflows.WithLabelValues(label).Inc()
+		return OverflowLabelValue
+	}
+	values[value] = struct{}{}
+	g.values.WithLabelValues(label).Set(float64(len(values)))
+	return value
+}
+
+// setMax changes the bound of each label. Values already seen are kept.
+func (g *cardinalityGuard) setMax(max int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.max = max
+}
@@ -0,0 +1,270 @@
+/*
+Copyright 2024 NeuroNetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+const (
+	// CustomMetricPrefix namespaces the metrics of plugins: a metric name
+	// of plugin foo is published as plugin_foo_name
+	CustomMetricPrefix = "plugin_"
+
+	// MaxCustomMetricLabels bounds the labels of a custom metric
+	MaxCustomMetricLabels = 5
+)
+
+// CustomMetricKind is the type of a custom metric
+type CustomMetricKind string
+
+const (
+	// CustomCounter only increases; its name must end in _total
+	CustomCounter CustomMetricKind = "counter"
+
+	// CustomGauge is set to the latest value
+	CustomGauge CustomMetricKind = "gauge"
+
+	// CustomHistogram counts observations in buckets
+	CustomHistogram CustomMetricKind = "histogram"
+)
+
+// customNameRE matches plugin and metric names
+var customNameRE = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// CustomMetricOpts describes a metric published by a plugin
+type CustomMetricOpts struct {
+	// Plugin is the name of the plugin, e.g. pii-guardrail. Dashes are
+	// replaced by underscores in the metric name.
+	Plugin string
+
+	// Name is the name of the metric within the plugin, in snake case
+	Name string
+
+	// Help describes the metric
+	Help string
+
+	Kind CustomMetricKind
+
+	// Labels are the label names of the metric. Their values are bounded
+	// like those of the core metrics, see SetMaxLabelValues.
+	Labels []string
+
+	// Buckets are the bucket bounds of histograms. Defaults to
+	// prometheus.DefBuckets.
+	Buckets []float64
+}
+
+// FullName returns the name the metric is published as
+func (o CustomMetricOpts) FullName() string {
+	return CustomMetricPrefix + strings.ReplaceAll(o.Plugin, "-", "_") + "_" + o.Name
+}
+
+// validate checks the names of the metric and its labels
+func (o CustomMetricOpts) validate() error {
+	plugin := strings.ReplaceAll(o.Plugin, "-", "_")
+	if !customNameRE.MatchString(plugin) {
+		return fmt.Errorf("invalid plugin name %q: want lowercase letters, digits, dashes and underscores", o.Plugin)
+	}
+	if !customNameRE.MatchString(o.Name) {
+		return fmt.Errorf("invalid metric name %q: want lowercase letters, digits and underscores", o.Name)
+	}
+	if o.Help == "" {
+		return fmt.Errorf("metric %s has no help", o.FullName())
+	}
+	switch o.Kind {
+	case CustomCounter:
+		if !strings.HasSuffix(o.Name, "_total") {
+			return fmt.Errorf("counter %s must end in _total", o.FullName())
+		}
+	case CustomGauge, CustomHistogram:
+		for _, suffix := range []string{"_total", "_bucket", "_count", "_sum"} {
+			if strings.HasSuffix(o.Name, suffix) {
+				return fmt.Errorf("%s %s must not end in %s", o.Kind, o.FullName(), suffix)
+			}
+		}
+	default:
+		return fmt.Errorf("metric %s has unknown kind %q", o.FullName(), o.Kind)
+	}
+	if len(o.Labels) > MaxCustomMetricLabels {
+		return fmt.Errorf("metric %s has %d labels, more than %d", o.FullName(), len(o.Labels), MaxCustomMetricLabels)
+	}
+	seen := map[string]bool{}
+	for _, label := range o.Labels {
+		if !customNameRE.MatchString(label) {
+			return fmt.Errorf("metric %s has invalid label %q", o.FullName(), label)
+		}
+		if label == "le" || label == "quantile" {
+			return fmt.Errorf("metric %s has reserved label %q", o.FullName(), label)
+		}
+		if seen[label] {
+			return fmt.Errorf("metric %s has duplicate label %q", o.FullName(), label)
+		}
+		seen[label] = true
+	}
+	return nil
+}
+
+// CustomMetric is a metric registered by a plugin. It is exported to
+// Prometheus and, for counters and histograms, OpenTelemetry like the core
+// metrics.
+type CustomMetric struct {
+	opts   CustomMetricOpts
+	name   string
+	labels *cardinalityGuard
+
+	counter   *prometheus.CounterVec
+	gauge     *prometheus.GaugeVec
+	histogram *prometheus.HistogramVec
+
+	otelCounter   metric.Float64Counter
+	otelHistogram metric.Float64Histogram
+}
+
+// Name returns the name the metric is published as
+func (c *CustomMetric) Name() string {
+	return c.name
+}
+
+// Kind returns the type of the metric
+func (c *CustomMetric) Kind() CustomMetricKind {
+	return c.opts.Kind
+}
+
+// Record adds value to a counter, sets a gauge to it or observes it in a
+// histogram. labelValues are matched to the labels of the metric in order;
+// missing values are recorded as UnknownLabelValue and extra ones are
+// ignored. Negative values are ignored by counters.
+func (c *CustomMetric) Record(ctx context.Context, value float64, labelValues ...string) {
+	values := make([]string, len(c.opts.Labels))
+	attrs := make([]attribute.KeyValue, len(c.opts.Labels))
+	for i, label := range c.opts.Labels {
+		var value string
+		if i < len(labelValues) {
+			value = labelValues[i]
+		}
+		values[i] = c.labels.value(c.name+":"+label, value)
+		attrs[i] = attribute.String(label, values[i])
+	}
+
+	switch c.opts.Kind {
+	case CustomCounter:
+		if value < 0 {
+			return
+		}
+		c.counter.WithLabelValues(values...).Add(value)
+		c.otelCounter.Add(ctx, value, metric.WithAttributes(attrs...))
+	case CustomGauge:
+		c.gauge.WithLabelValues(values...).Set(value)
+	case CustomHistogram:
+		observe(ctx, c.histogram.WithLabelValues(values...), value)
+		c.otelHistogram.Record(ctx, value, metric.WithAttributes(attrs...))
+	}
+}
+
+// customMetrics are the custom metrics of an AgentMetrics by name
+type customMetrics struct {
+	mu      sync.Mutex
+	metrics map[string]*CustomMetric
+}
+
+// RegisterCustomMetric registers a metric published by a plugin, named
+// plugin_<plugin>_<name>. Registering a metric again with the same kind and
+// labels returns the metric already registered, so plugins may register
+// their metrics each time they are set up; registering it with other ones
+// fails. OpenTelemetry instruments are created from the meter provider set
+// at registration.
+func (m *AgentMetrics) RegisterCustomMetric(opts CustomMetricOpts) (*CustomMetric, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	name := opts.FullName()
+
+	m.custom.mu.Lock()
+	defer m.custom.mu.Unlock()
+	if existing, ok := m.custom.metrics[name]; ok {
+		if existing.opts.Kind != opts.Kind || !equalLabels(existing.opts.Labels, opts.Labels) {
+			return nil, fmt.Errorf("metric %s is already registered as a %s with labels %v", name, existing.opts.Kind, existing.opts.Labels)
+		}
+		return existing, nil
+	}
+
+	c := &CustomMetric{opts: opts, name: name, labels: m.labels}
+	opts.Labels = append([]string(nil), opts.Labels...)
+	var collector prometheus.Collector
+	var err error
+	switch opts.Kind {
+	case CustomCounter:
+		c.counter = prometheus.NewCounterVec(prometheus.CounterOpts{Name: name, Help: opts.Help}, opts.Labels)
+		collector = c.counter
+		// OpenTelemetry exporters add the _total suffix of counters
+		c.otelCounter, err = m.otelMeter.Float64Counter(strings.TrimSuffix(name, "_total"), metric.WithDescription(opts.Help))
+	case CustomGauge:
+		c.gauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: name, Help: opts.Help}, opts.Labels)
+		collector = c.gauge
+	case CustomHistogram:
+		buckets := opts.Buckets
+		if len(buckets) == 0 {
+			buckets = prometheus.DefBuckets
+		}
+		c.histogram = prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: name, Help: opts.Help, Buckets: buckets}, opts.Labels)
+		collector = c.histogram
+		c.otelHistogram, err = m.otelMeter.Float64Histogram(name, metric.WithDescription(opts.Help))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OpenTelemetry instrument for %s: %w", name, err)
+	}
+	if err := m.factory.register(name, opts.Help, opts.Labels, collector); err != nil {
+		return nil, fmt.Errorf("failed to register metric %s: %w", name, err)
+	}
+	c.opts = opts
+	m.custom.metrics[name] = c
+	return c, nil
+}
+
+// CustomMetrics returns the names of the custom metrics registered
+func (m *AgentMetrics) CustomMetrics() []string {
+	m.custom.mu.Lock()
+	defer m.custom.mu.Unlock()
+	names := make([]string, 0, len(m.custom.metrics))
+	for name := range m.custom.metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func equalLabels(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,141 @@
+/*
+Copyright 2024 NeuroNetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegisterCustomMetric(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	m := NewAgentMetrics(registry)
+	ctx := context.Background()
+
+	blocks, err := m.RegisterCustomMetric(CustomMetricOpts{
+		Plugin: "pii-guardrail",
+		Name:   "blocks_total",
+		Help:   "Requests blocked by the PII guardrail",
+		Kind:   CustomCounter,
+		Labels: []string{"entity"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "plugin_pii_guardrail_blocks_total", blocks.Name())
+	blocks.Record(ctx, 1, "email")
+	blocks.Record(ctx, 2, "email")
+	blocks.Record(ctx, -1, "email")
+	blocks.Record(ctx, 1)
+	assert.Equal(t, 3.0, testutil.ToFloat64(blocks.counter.WithLabelValues("email")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(blocks.counter.WithLabelValues(UnknownLabelValue)))
+
+	score, err := m.RegisterCustomMetric(CustomMetricOpts{
+		Plugin:  "bin-packing",
+		Name:    "node_score",
+		Help:    "Scores given to nodes",
+		Kind:    CustomHistogram,
+		Buckets: []float64{25, 50, 75, 100},
+	})
+	require.NoError(t, err)
+	score.Record(ctx, 60)
+	require.NoError(t, testutil.CollectAndCompare(registry, strings.NewReader(`
+# HELP plugin_bin_packing_node_score Scores given to nodes
+# TYPE plugin_bin_packing_node_score histogram
+plugin_bin_packing_node_score_bucket{le="25"} 0
+plugin_bin_packing_node_score_bucket{le="50"} 0
+plugin_bin_packing_node_score_bucket{le="75"} 1
+plugin_bin_packing_node_score_bucket{le="100"} 1
+plugin_bin_packing_node_score_bucket{le="+Inf"} 1
+plugin_bin_packing_node_score_sum 60
+plugin_bin_packing_node_score_count 1
+`), "plugin_bin_packing_node_score"))
+
+	queue, err := m.RegisterCustomMetric(CustomMetricOpts{
+		Plugin: "bin-packing", Name: "queue", Help: "Pods waiting", Kind: CustomGauge,
+	})
+	require.NoError(t, err)
+	queue.Record(ctx, 4)
+	queue.Record(ctx, 2)
+	assert.Equal(t, 2.0, testutil.ToFloat64(queue.gauge))
+
+	// Plugins set up again get their metrics back
+	again, err := m.RegisterCustomMetric(CustomMetricOpts{
+		Plugin: "pii-guardrail", Name: "blocks_total", Help: "Blocked", Kind: CustomCounter, Labels: []string{"entity"},
+	})
+	require.NoError(t, err)
+	assert.Same(t, blocks, again)
+	_, err = m.RegisterCustomMetric(CustomMetricOpts{
+		Plugin: "pii-guardrail", Name: "blocks_total", Help: "Blocked", Kind: CustomCounter, Labels: []string{"route"},
+	})
+	assert.ErrorContains(t, err, "already registered")
+
+	assert.Equal(t, []string{
+		"plugin_bin_packing_node_score",
+		"plugin_bin_packing_queue",
+		"plugin_pii_guardrail_blocks_total",
+	}, m.CustomMetrics())
+}
+
+func TestCustomMetricValidation(t *testing.T) {
+	m := NewAgentMetrics(prometheus.NewRegistry())
+	valid := CustomMetricOpts{Plugin: "guard", Name: "checks_total", Help: "Checks", Kind: CustomCounter}
+
+	for name, mutate := range map[string]func(*CustomMetricOpts){
+		"plugin name":        func(o *CustomMetricOpts) { o.Plugin = "Guard!" },
+		"metric name":        func(o *CustomMetricOpts) { o.Name = "checks-total" },
+		"help":               func(o *CustomMetricOpts) { o.Help = "" },
+		"kind":               func(o *CustomMetricOpts) { o.Kind = "summary" },
+		"counter suffix":     func(o *CustomMetricOpts) { o.Name = "checks" },
+		"gauge suffix":       func(o *CustomMetricOpts) { o.Kind = CustomGauge },
+		"too many labels":    func(o *CustomMetricOpts) { o.Labels = []string{"a", "b", "c", "d", "e", "f"} },
+		"invalid label":      func(o *CustomMetricOpts) { o.Labels = []string{"__name__"} },
+		"reserved label":     func(o *CustomMetricOpts) { o.Labels = []string{"le"} },
+		"duplicate label":    func(o *CustomMetricOpts) { o.Labels = []string{"entity", "entity"} },
+		"histogram suffixes": func(o *CustomMetricOpts) { o.Kind, o.Name = CustomHistogram, "wait_count" },
+	} {
+		opts := valid
+		mutate(&opts)
+		_, err := m.RegisterCustomMetric(opts)
+		assert.Error(t, err, name)
+	}
+	assert.Empty(t, m.CustomMetrics())
+
+	_, err := m.RegisterCustomMetric(valid)
+	assert.NoError(t, err)
+}
+
+func TestCustomMetricLabelBound(t *testing.T) {
+	m := NewAgentMetrics(prometheus.NewRegistry())
+	m.SetMaxLabelValues(2)
+	checks, err := m.RegisterCustomMetric(CustomMetricOpts{
+		Plugin: "guard", Name: "checks_total", Help: "Checks", Kind: CustomCounter, Labels: []string{"tenant"},
+	})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	for _, tenant := range []string{"a", "b", "c", "d"} {
+		checks.Record(ctx, 1, tenant)
+	}
+	assert.Equal(t, 3, testutil.CollectAndCount(checks.counter))
+	assert.Equal(t, 2.0, testutil.ToFloat64(checks.counter.WithLabelValues(OverflowLabelValue)))
+	assert.Equal(t, 2.0, testutil.ToFloat64(m.LabelOverflows.WithLabelValues("plugin_guard_checks_total:tenant")))
+}
@@ -0,0 +1,163 @@
+/*
+Copyright 2024 NeuroNetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// DefaultNativeBucketFactor is the growth factor between the buckets
+	// of native histograms
+	DefaultNativeBucketFactor = 1.1
+
+	// nativeMaxBuckets bounds the buckets of a native histogram series;
+	// past it the resolution is halved
+	nativeMaxBuckets = 160
+
+	// nativeMinResetDuration is how long a native histogram series keeps
+	// its buckets before it may be reset to get back within bounds
+	nativeMinResetDuration = time.Hour
+)
+
+// histogramNames are the names of the histograms of AgentMetrics
+var histogramNames = map[string]bool{
+	"agent_ttft_ms":                  true,
+	"agent_latency_ms":               true,
+	"agent_scaling_lag_seconds":      true,
+	"agent_tool_calls_per_turn":      true,
+	"agent_tool_latency_ms":          true,
+	"rag_retrieval_latency_ms":       true,
+	"model_load_time_seconds":        true,
+	"model_snapshot_restore_seconds": true,
+	"agent_cold_start_seconds":       true,
+	"stream_init_ms":                 true,
+	"token_delivery_jitter_ms":       true,
+	"gang_schedule_wait_seconds":     true,
+	"scheduler_plugin_score":         true,
+	"failover_time_seconds":          true,
+	"session_cost_usd":               true,
+}
+
+// nativeHistogramNames are the histograms exposed as native histograms
+// when enabled
+var nativeHistogramNames = map[string]bool{
+	"agent_ttft_ms":    true,
+	"agent_latency_ms": true,
+}
+
+// HistogramOptions configures the histograms of AgentMetrics
+type HistogramOptions struct {
+	// Buckets overrides the bucket bounds of histograms by metric name,
+	// e.g. agent_ttft_ms. Bounds must increase.
+	Buckets map[string][]float64 `json:"buckets,omitempty"`
+
+	// NativeHistograms also exposes agent_ttft_ms and agent_latency_ms as
+	// Prometheus native histograms, for high-resolution percentiles
+	// without long bucket lists. The classic buckets are kept for rules
+	// and scrapers that do not support native histograms.
+	NativeHistograms bool `json:"nativeHistograms,omitempty"`
+
+	// NativeBucketFactor is the growth factor between native buckets.
+	// Defaults to DefaultNativeBucketFactor.
+	NativeBucketFactor float64 `json:"nativeBucketFactor,omitempty"`
+}
+
+// LoadHistogramOptions reads HistogramOptions from a YAML or JSON file, e.g.
+//
+//	buckets:
+//	  agent_ttft_ms: [25, 50, 100, 200, 350, 500, 1000]
+//	nativeHistograms: true
+func LoadHistogramOptions(path string) (HistogramOptions, error) {
+	var options HistogramOptions
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return options, fmt.Errorf("failed to read histogram options: %w", err)
+	}
+	if err := yaml.UnmarshalStrict(data, &options); err != nil {
+		return options, fmt.Errorf("failed to parse histogram options %s: %w", path, err)
+	}
+	return options, options.validate()
+}
+
+// validate checks that buckets are overridden for known histograms with
+// increasing bounds
+func (h *HistogramOptions) validate() error {
+	names := make([]string, 0, len(h.Buckets))
+	for name := range h.Buckets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var errs []string
+	for _, name := range names {
+		buckets := h.Buckets[name]
+		if !histogramNames[name] {
+			errs = append(errs, fmt.Sprintf("unknown histogram %q", name))
+			continue
+		}
+		if len(buckets) == 0 {
+			errs = append(errs, fmt.Sprintf("no buckets for %s", name))
+			continue
+		}
+		for i := 1; i < len(buckets); i++ {
+			if buckets[i] <= buckets[i-1] {
+				errs = append(errs, fmt.Sprintf("buckets of %s do not increase at %g", name, buckets[i]))
+				break
+			}
+		}
+	}
+	if h.NativeBucketFactor != 0 && h.NativeBucketFactor <= 1 {
+		errs = append(errs, fmt.Sprintf("native bucket factor %g must be above 1", h.NativeBucketFactor))
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid histogram options: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// BucketsOf returns the bucket bounds histogram name is recorded with
+// under h: its override, or defaults
+func (h *HistogramOptions) BucketsOf(name string, defaults []float64) []float64 {
+	if buckets, ok := h.Buckets[name]; ok {
+		return buckets
+	}
+	return defaults
+}
+
+// apply returns opts with the buckets and native histogram settings of h
+func (h *HistogramOptions) apply(opts prometheus.HistogramOpts) prometheus.HistogramOpts {
+	if buckets, ok := h.Buckets[opts.Name]; ok {
+		opts.Buckets = buckets
+	}
+	if h.NativeHistograms && nativeHistogramNames[opts.Name] {
+		opts.NativeHistogramBucketFactor = h.NativeBucketFactor
+		if opts.NativeHistogramBucketFactor == 0 {
+			opts.NativeHistogramBucketFactor = DefaultNativeBucketFactor
+		}
+		opts.NativeHistogramMaxBucketNumber = nativeMaxBuckets
+		opts.NativeHistogramMinResetDuration = nativeMinResetDuration
+	}
+	return opts
+}
@@ -0,0 +1,104 @@
+/*
+Copyright 2024 NeuroNetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistogramNamesCoverAllHistograms(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	m := NewAgentMetrics(registry)
+	m.RecordTTFT(context.Background(), time.Second, "m", "r")
+	m.RecordLatency(context.Background(), time.Second, "m", "r")
+	m.RecordToolCall(context.Background(), "t", time.Second, true)
+	m.RecordModelLoad(context.Background(), "m", time.Second, false)
+	m.RecordPluginScore(context.Background(), "p", 1)
+	m.RecordSessionCost(context.Background(), "t", 1)
+
+	families, err := registry.Gather()
+	require.NoError(t, err)
+	histograms := map[string]bool{}
+	for _, family := range families {
+		if family.GetType().String() == "HISTOGRAM" {
+			histograms[family.GetName()] = true
+		}
+	}
+	assert.Equal(t, histogramNames, histograms)
+}
+
+func TestNewAgentMetricsWithOptions(t *testing.T) {
+	m, err := NewAgentMetricsWithOptions(prometheus.NewRegistry(), HistogramOptions{
+		Buckets:          map[string][]float64{"agent_ttft_ms": {25, 50, 100}},
+		NativeHistograms: true,
+	})
+	require.NoError(t, err)
+	m.RecordTTFT(context.Background(), 40*time.Millisecond, "llama-3-70b", "/chat")
+	m.RecordTTFT(context.Background(), 41*time.Millisecond, "llama-3-70b", "/chat")
+	m.RecordLatency(context.Background(), time.Second, "llama-3-70b", "/chat")
+
+	ttft := histogram(t, m.TTFTHistogram.WithLabelValues("llama-3-70b", "/chat"))
+	require.Len(t, ttft.GetBucket(), 3)
+	assert.Equal(t, 50.0, ttft.GetBucket()[1].GetUpperBound())
+	assert.Equal(t, uint64(2), ttft.GetBucket()[1].GetCumulativeCount())
+	// Native buckets are recorded alongside the classic ones
+	assert.Equal(t, int32(3), ttft.GetSchema())
+	assert.NotEmpty(t, ttft.GetPositiveSpan())
+	assert.NotEmpty(t, histogram(t, m.LatencyHistogram.WithLabelValues("llama-3-70b", "/chat")).GetPositiveSpan())
+
+	// Other histograms stay classic
+	m.RecordToolCall(context.Background(), "search", time.Second, true)
+	assert.Empty(t, histogram(t, m.ToolLatency.WithLabelValues("search")).GetPositiveSpan())
+
+	for _, options := range []HistogramOptions{
+		{Buckets: map[string][]float64{"agent_ttft": {1}}},
+		{Buckets: map[string][]float64{"agent_ttft_ms": {100, 50}}},
+		{Buckets: map[string][]float64{"agent_ttft_ms": {}}},
+		{NativeHistograms: true, NativeBucketFactor: 1},
+	} {
+		_, err := NewAgentMetricsWithOptions(prometheus.NewRegistry(), options)
+		assert.Error(t, err, "%+v", options)
+	}
+}
+
+func TestLoadHistogramOptions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "histograms.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`buckets:
+  agent_latency_ms: [250, 500, 1000, 2500]
+nativeHistograms: true
+nativeBucketFactor: 1.05
+`), 0o600))
+	options, err := LoadHistogramOptions(path)
+	require.NoError(t, err)
+	assert.Equal(t, HistogramOptions{
+		Buckets:            map[string][]float64{"agent_latency_ms": {250, 500, 1000, 2500}},
+		NativeHistograms:   true,
+		NativeBucketFactor: 1.05,
+	}, options)
+
+	require.NoError(t, os.WriteFile(path, []byte("nativeHistogram: true\n"), 0o600))
+	_, err = LoadHistogramOptions(path)
+	assert.Error(t, err)
+}
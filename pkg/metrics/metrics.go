@@ -21,42 +21,63 @@ import (
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promauto"
-	"go.opentelemetry.io/otel"
+	"github.com/prometheus/common/model"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/bowenislandsong/neuronetes/pkg/metrics/window"
+)
+
+const (
+	// RatioWindow is the sliding window used by rolling-ratio gauges
+	RatioWindow = 5 * time.Minute
+
+	// RatioGranularity is the bucket size of rolling-ratio windows
+	RatioGranularity = 10 * time.Second
+)
+
+var (
+	// TTFTBuckets are the bucket bounds of agent_ttft_ms, in milliseconds
+	TTFTBuckets = []float64{50, 100, 200, 350, 500, 750, 1000, 2000, 5000}
+
+	// LatencyBuckets are the bucket bounds of agent_latency_ms, in
+	// milliseconds
+	LatencyBuckets = []float64{100, 250, 500, 1000, 2500, 5000, 10000, 30000}
 )
 
 // AgentMetrics defines all agent-native metrics for NeuroNetes
 type AgentMetrics struct {
 	// UX & Quality (SLO-facing)
-	TTFTHistogram    prometheus.Histogram
-	LatencyHistogram prometheus.Histogram
+	TTFTHistogram    *prometheus.HistogramVec
+	LatencyHistogram *prometheus.HistogramVec
 	RTFRatio         prometheus.Gauge
 	TokensOutRate    prometheus.Gauge
 	CSATScore        prometheus.Gauge
 	ThumbsUpRate     prometheus.Gauge
-	TurnErrorRate    prometheus.Counter
+	TurnErrorRate    *prometheus.CounterVec
 	QualityWinRate   prometheus.Gauge
 
 	// Load & Concurrency
 	ActiveSessions   prometheus.Gauge
-	QueueDepth       prometheus.Gauge
+	QueueDepth       *prometheus.GaugeVec
+	TokensInQueue    prometheus.Gauge
 	AdmissionRejects prometheus.Counter
 	ScalingLag       prometheus.Histogram
 
 	// Token & Context Dynamics
-	InputTokens          prometheus.Counter
-	OutputTokens         prometheus.Counter
-	TotalTokens          prometheus.Counter
+	InputTokens          *prometheus.CounterVec
+	OutputTokens         *prometheus.CounterVec
+	TotalTokens          *prometheus.CounterVec
 	ContextLengthP95     prometheus.Gauge
 	ContextTruncations   prometheus.Counter
 	KVCacheHitRatio      prometheus.Gauge
 	BatchMergeEfficiency prometheus.Gauge
+	BatchSize            prometheus.Gauge
 
 	// Tooling / Function Calls
 	ToolCallsPerTurn  prometheus.Histogram
-	ToolLatency       prometheus.Histogram
+	ToolLatency       *prometheus.HistogramVec
 	ToolSuccessRate   prometheus.Gauge
 	ToolTimeoutRate   prometheus.Gauge
 	ToolRetryRate     prometheus.Gauge
@@ -69,6 +90,7 @@ type AgentMetrics struct {
 	RetrievalMRR         prometheus.Gauge
 	HallucinationRate    prometheus.Gauge
 	CitationValidityRate prometheus.Gauge
+	RAGEvaluations       *prometheus.CounterVec
 
 	// GPU & System Efficiency
 	GPUUtilization      prometheus.Gauge
@@ -78,9 +100,26 @@ type AgentMetrics struct {
 	VRAMFragmentation   prometheus.Gauge
 	MIGSliceUtilization prometheus.Gauge
 	NodeModelCacheHit   prometheus.Gauge
-	ModelLoadTime       prometheus.Histogram
+	ModelLoadTime       *prometheus.HistogramVec
 	SnapshotRestoreTime prometheus.Histogram
+	ModelDownloadBytes  *prometheus.CounterVec
+	ModelDownloadRate   *prometheus.GaugeVec
 	ColdStartRate       prometheus.Gauge
+	ColdStartLatency    prometheus.Histogram
+
+	// Distribution of model weights between the node caches
+	ModelDistributionBytes *prometheus.CounterVec
+	ModelPeerRequests      *prometheus.CounterVec
+	ModelPeerServedBytes   *prometheus.CounterVec
+
+	// GPU usage of the replicas of each pool, from the dcgm-exporter
+	// bridge
+	PoolGPUs                *prometheus.GaugeVec
+	PoolGPUUtilization      *prometheus.GaugeVec
+	PoolSMUtilization       *prometheus.GaugeVec
+	PoolMemoryBWUtilization *prometheus.GaugeVec
+	PoolVRAMUsed            *prometheus.GaugeVec
+	PoolVRAMTotal           *prometheus.GaugeVec
 
 	// Network & Streaming
 	StreamInitLatency   prometheus.Histogram
@@ -94,6 +133,8 @@ type AgentMetrics struct {
 	TopologyPenaltyScore   prometheus.Gauge
 	SessionAffinityHitRate prometheus.Gauge
 	DataLocalityRate       prometheus.Gauge
+	SchedulerPluginScore   *prometheus.HistogramVec
+	NodeProvisions         prometheus.Counter
 
 	// Autoscaling & Reliability
 	HPADecisions        prometheus.Counter
@@ -101,7 +142,7 @@ type AgentMetrics struct {
 	ReplicaEvictions    prometheus.Counter
 	SpotInterruptions   prometheus.Counter
 	FailoverTime        prometheus.Histogram
-	ErrorBudgetBurnRate prometheus.Gauge
+	ErrorBudgetBurnRate *prometheus.GaugeVec
 
 	// Security, Safety, Policy
 	PolicyBlocks    prometheus.Counter
@@ -109,366 +150,717 @@ type AgentMetrics struct {
 	AuthzDenials    prometheus.Counter
 
 	// Cost & Carbon
-	CostPer1KTokens      prometheus.Gauge
-	CostPerSession       prometheus.Gauge
-	GPUHours             prometheus.Counter
-	CPUHours             prometheus.Counter
-	EgressGB             prometheus.Counter
-	EnergyKWHPer1KTokens prometheus.Gauge
-	SpotSavings          prometheus.Counter
+	CostPer1KTokens        *prometheus.GaugeVec
+	CostPerSession         prometheus.Gauge
+	GPUHours               prometheus.Counter
+	CPUHours               prometheus.Counter
+	EgressGB               prometheus.Counter
+	EnergyKWHPer1KTokens   prometheus.Gauge
+	EnergyKWH              prometheus.Counter
+	CarbonGrams            prometheus.Counter
+	CarbonGramsPer1KTokens prometheus.Gauge
+	CarbonIntensity        prometheus.Gauge
+	SpotSavings            prometheus.Counter
+	PoolGPUHours           *prometheus.CounterVec
+	PoolCost               *prometheus.CounterVec
+	TenantCost             *prometheus.CounterVec
+	SessionCost            *prometheus.HistogramVec
+
+	// Label values folded into OverflowLabelValue by the cardinality guard
+	LabelOverflows *prometheus.CounterVec
+
+	// Distinct values recorded per bounded label
+	LabelValues *prometheus.GaugeVec
+
+	// factory registers the custom metrics of plugins
+	factory *factory
+	custom  customMetrics
 
 	// OpenTelemetry metrics
 	otelMeter metric.Meter
+	otel      *otelInstruments
+
+	// Rolling windows backing ratio gauges
+	toolSuccess     *window.RollingRatio
+	toolTimeouts    *window.RollingRatio
+	coldStarts      *window.RollingRatio
+	sessionAffinity *window.RollingRatio
+	dataLocality    *window.RollingRatio
+	streamCancels   *window.RollingRatio
+	streamDrops     *window.RollingRatio
+	retrievalHits   *window.RollingRatio
+	hallucinations  *window.RollingRatio
+	citations       *window.RollingRatio
+	retrievalRanks  *window.RollingMean
+
+	// Sliding windows backing in-process quantiles
+	ttftQuantiles    *durationQuantiles
+	latencyQuantiles *durationQuantiles
+
+	// labels bounds the values of the labels of the core metrics
+	labels *cardinalityGuard
+
+	// tenants bounds the values of the tenant label instead of labels, if
+	// set
+	tenants *TenantRegistry
+
+	// ledger keeps the token totals of each tenant for chargeback
+	ledger TokenLedger
+
+	// energy estimates the energy of the tokens and GPU utilization
+	// recorded
+	energy EnergyMeter
+}
+
+// TokenLedger accumulates the tokens of each tenant and model beyond the
+// lifetime of the process, e.g. an accounting.Ledger
+type TokenLedger interface {
+	Record(tenant, model string, inputTokens, outputTokens int64)
+}
+
+// EnergyMeter estimates energy and carbon from the tokens and GPU
+// utilization of the process, e.g. a carbon.Estimator
+type EnergyMeter interface {
+	RecordTokens(tokens int64)
+	RecordUtilization(utilization float64)
 }
 
 // NewAgentMetrics creates and registers all Prometheus metrics
 func NewAgentMetrics(registry prometheus.Registerer) *AgentMetrics {
+	m, _ := newAgentMetrics(registry, &Config{})
+	return m
+}
+
+// NewAgentMetricsWithOptions creates and registers all Prometheus metrics,
+// with the histograms configured by options
+func NewAgentMetricsWithOptions(registry prometheus.Registerer, options HistogramOptions) (*AgentMetrics, error) {
+	return NewAgentMetricsWithConfig(registry, Config{HistogramOptions: options})
+}
+
+// NewAgentMetricsWithConfig creates all Prometheus metrics and registers
+// those config does not disable, with the histograms and labels it
+// configures
+func NewAgentMetricsWithConfig(registry prometheus.Registerer, config Config) (*AgentMetrics, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	return newAgentMetrics(registry, &config)
+}
+
+func newAgentMetrics(registry prometheus.Registerer, config *Config) (*AgentMetrics, error) {
 	if registry == nil {
 		registry = prometheus.DefaultRegisterer
 	}
+	f := newFactory(registry, config)
+	h := &config.HistogramOptions
 
 	m := &AgentMetrics{
 		// UX & Quality metrics
-		TTFTHistogram: promauto.With(registry).NewHistogram(prometheus.HistogramOpts{
+		TTFTHistogram: f.NewHistogramVec(h.apply(prometheus.HistogramOpts{
 			Name:    "agent_ttft_ms",
 			Help:    "Time to first token in milliseconds",
-			Buckets: []float64{50, 100, 200, 350, 500, 750, 1000, 2000, 5000},
-		}),
-		LatencyHistogram: promauto.With(registry).NewHistogram(prometheus.HistogramOpts{
+			Buckets: TTFTBuckets,
+		}), []string{"model", "route"}),
+		LatencyHistogram: f.NewHistogramVec(h.apply(prometheus.HistogramOpts{
 			Name:    "agent_latency_ms",
 			Help:    "End-to-end turn latency in milliseconds",
-			Buckets: []float64{100, 250, 500, 1000, 2500, 5000, 10000, 30000},
-		}),
-		RTFRatio: promauto.With(registry).NewGauge(prometheus.GaugeOpts{
+			Buckets: LatencyBuckets,
+		}), []string{"model", "route"}),
+		RTFRatio: f.NewGauge(prometheus.GaugeOpts{
 			Name: "agent_rtf_ratio",
 			Help: "Real-time factor (generation time / output seconds)",
 		}),
-		TokensOutRate: promauto.With(registry).NewGauge(prometheus.GaugeOpts{
+		TokensOutRate: f.NewGauge(prometheus.GaugeOpts{
 			Name: "agent_tokens_out_per_s",
 			Help: "Token generation rate (tokens/second)",
 		}),
-		CSATScore: promauto.With(registry).NewGauge(prometheus.GaugeOpts{
+		CSATScore: f.NewGauge(prometheus.GaugeOpts{
 			Name: "agent_csat_score",
 			Help: "Customer satisfaction score (0-5)",
 		}),
-		ThumbsUpRate: promauto.With(registry).NewGauge(prometheus.GaugeOpts{
+		ThumbsUpRate: f.NewGauge(prometheus.GaugeOpts{
 			Name: "agent_thumbs_up_rate",
 			Help: "Thumbs up rate (0-1)",
 		}),
-		TurnErrorRate: promauto.With(registry).NewCounter(prometheus.CounterOpts{
+		TurnErrorRate: f.NewCounterVec(prometheus.CounterOpts{
 			Name: "agent_turn_errors_total",
 			Help: "Total number of turn errors (5xx + aborted)",
-		}),
-		QualityWinRate: promauto.With(registry).NewGauge(prometheus.GaugeOpts{
+		}, []string{"model", "error_type"}),
+		QualityWinRate: f.NewGauge(prometheus.GaugeOpts{
 			Name: "agent_quality_winrate",
 			Help: "Quality win rate for canary vs baseline",
 		}),
 
 		// Load & Concurrency
-		ActiveSessions: promauto.With(registry).NewGauge(prometheus.GaugeOpts{
+		ActiveSessions: f.NewGauge(prometheus.GaugeOpts{
 			Name: "agent_active_sessions",
 			Help: "Number of active sessions",
 		}),
-		QueueDepth: promauto.With(registry).NewGauge(prometheus.GaugeOpts{
+		QueueDepth: f.NewGaugeVec(prometheus.GaugeOpts{
 			Name: "agent_queue_depth",
 			Help: "Current queue depth per route/topic",
+		}, []string{"route"}),
+		TokensInQueue: f.NewGauge(prometheus.GaugeOpts{
+			Name: "agent_tokens_in_queue",
+			Help: "Input tokens of requests waiting in the queue",
 		}),
-		AdmissionRejects: promauto.With(registry).NewCounter(prometheus.CounterOpts{
+		AdmissionRejects: f.NewCounter(prometheus.CounterOpts{
 			Name: "agent_admission_rejects_total",
 			Help: "Total admission rejections due to SLO/capacity",
 		}),
-		ScalingLag: promauto.With(registry).NewHistogram(prometheus.HistogramOpts{
+		ScalingLag: f.NewHistogram(h.apply(prometheus.HistogramOpts{
 			Name:    "agent_scaling_lag_seconds",
 			Help:    "Time from load spike to replica ready",
 			Buckets: []float64{1, 5, 10, 30, 60, 120, 300, 600},
-		}),
+		})),
 
 		// Token & Context Dynamics
-		InputTokens: promauto.With(registry).NewCounter(prometheus.CounterOpts{
+		InputTokens: f.NewCounterVec(prometheus.CounterOpts{
 			Name: "agent_input_tokens_total",
 			Help: "Total input tokens processed",
-		}),
-		OutputTokens: promauto.With(registry).NewCounter(prometheus.CounterOpts{
+		}, []string{"model"}),
+		OutputTokens: f.NewCounterVec(prometheus.CounterOpts{
 			Name: "agent_output_tokens_total",
 			Help: "Total output tokens generated",
-		}),
-		TotalTokens: promauto.With(registry).NewCounter(prometheus.CounterOpts{
+		}, []string{"model"}),
+		TotalTokens: f.NewCounterVec(prometheus.CounterOpts{
 			Name: "agent_total_tokens",
 			Help: "Total tokens (input + output)",
-		}),
-		ContextLengthP95: promauto.With(registry).NewGauge(prometheus.GaugeOpts{
+		}, []string{"model"}),
+		ContextLengthP95: f.NewGauge(prometheus.GaugeOpts{
 			Name: "agent_ctx_len_p95",
 			Help: "95th percentile context length",
 		}),
-		ContextTruncations: promauto.With(registry).NewCounter(prometheus.CounterOpts{
+		ContextTruncations: f.NewCounter(prometheus.CounterOpts{
 			Name: "agent_ctx_truncations_total",
 			Help: "Total context truncations",
 		}),
-		KVCacheHitRatio: promauto.With(registry).NewGauge(prometheus.GaugeOpts{
+		KVCacheHitRatio: f.NewGauge(prometheus.GaugeOpts{
 			Name: "agent_kv_cache_hit_ratio",
 			Help: "KV cache hit ratio",
 		}),
-		BatchMergeEfficiency: promauto.With(registry).NewGauge(prometheus.GaugeOpts{
+		BatchMergeEfficiency: f.NewGauge(prometheus.GaugeOpts{
 			Name: "agent_batch_merge_efficiency",
 			Help: "Batch merge efficiency (effective / ideal)",
 		}),
+		BatchSize: f.NewGauge(prometheus.GaugeOpts{
+			Name: "agent_batch_size",
+			Help: "Number of sequences in the current decode batch",
+		}),
 
 		// Tooling / Function Calls
-		ToolCallsPerTurn: promauto.With(registry).NewHistogram(prometheus.HistogramOpts{
+		ToolCallsPerTurn: f.NewHistogram(h.apply(prometheus.HistogramOpts{
 			Name:    "agent_tool_calls_per_turn",
 			Help:    "Number of tool calls per turn",
 			Buckets: []float64{0, 1, 2, 3, 5, 10, 20},
-		}),
-		ToolLatency: promauto.With(registry).NewHistogram(prometheus.HistogramOpts{
+		})),
+		ToolLatency: f.NewHistogramVec(h.apply(prometheus.HistogramOpts{
 			Name:    "agent_tool_latency_ms",
 			Help:    "Tool call latency in milliseconds",
 			Buckets: []float64{10, 50, 100, 200, 500, 800, 1000, 2000, 5000},
-		}),
-		ToolSuccessRate: promauto.With(registry).NewGauge(prometheus.GaugeOpts{
+		}), []string{"tool"}),
+		ToolSuccessRate: f.NewGauge(prometheus.GaugeOpts{
 			Name: "agent_tool_success_rate",
 			Help: "Tool call success rate",
 		}),
-		ToolTimeoutRate: promauto.With(registry).NewGauge(prometheus.GaugeOpts{
+		ToolTimeoutRate: f.NewGauge(prometheus.GaugeOpts{
 			Name: "agent_tool_timeout_rate",
 			Help: "Tool call timeout rate",
 		}),
-		ToolRetryRate: promauto.With(registry).NewGauge(prometheus.GaugeOpts{
+		ToolRetryRate: f.NewGauge(prometheus.GaugeOpts{
 			Name: "agent_tool_retry_rate",
 			Help: "Tool call retry rate",
 		}),
-		RetrievalLatency: promauto.With(registry).NewHistogram(prometheus.HistogramOpts{
+		RetrievalLatency: f.NewHistogram(h.apply(prometheus.HistogramOpts{
 			Name:    "rag_retrieval_latency_ms",
 			Help:    "RAG retrieval latency in milliseconds",
 			Buckets: []float64{5, 10, 25, 50, 100, 200, 500, 1000},
-		}),
-		RetrievalCacheHit: promauto.With(registry).NewGauge(prometheus.GaugeOpts{
+		})),
+		RetrievalCacheHit: f.NewGauge(prometheus.GaugeOpts{
 			Name: "rag_retrieval_cache_hit_ratio",
 			Help: "RAG retrieval cache hit ratio",
 		}),
-		GroundingCoverage: promauto.With(registry).NewGauge(prometheus.GaugeOpts{
+		GroundingCoverage: f.NewGauge(prometheus.GaugeOpts{
 			Name: "agent_grounding_coverage",
 			Help: "Percentage of turns with citations",
 		}),
 
 		// RAG Quality
-		RetrievalHitAtK: promauto.With(registry).NewGauge(prometheus.GaugeOpts{
+		RetrievalHitAtK: f.NewGauge(prometheus.GaugeOpts{
 			Name: "rag_hit_at_k",
 			Help: "Retrieval hit@k metric",
 		}),
-		RetrievalMRR: promauto.With(registry).NewGauge(prometheus.GaugeOpts{
+		RetrievalMRR: f.NewGauge(prometheus.GaugeOpts{
 			Name: "rag_mrr",
 			Help: "Retrieval Mean Reciprocal Rank",
 		}),
-		HallucinationRate: promauto.With(registry).NewGauge(prometheus.GaugeOpts{
+		HallucinationRate: f.NewGauge(prometheus.GaugeOpts{
 			Name: "agent_hallucination_rate",
 			Help: "Hallucination proxy rate (no-source spans)",
 		}),
-		CitationValidityRate: promauto.With(registry).NewGauge(prometheus.GaugeOpts{
+		CitationValidityRate: f.NewGauge(prometheus.GaugeOpts{
 			Name: "agent_citation_validity_rate",
 			Help: "Citation validity rate (post-hoc check)",
 		}),
+		RAGEvaluations: f.NewCounterVec(prometheus.CounterOpts{
+			Name: "rag_evaluations_total",
+			Help: "Turns sampled for RAG quality evaluation by outcome (evaluated, failed, dropped)",
+		}, []string{"outcome"}),
 
 		// GPU & System Efficiency
-		GPUUtilization: promauto.With(registry).NewGauge(prometheus.GaugeOpts{
+		GPUUtilization: f.NewGauge(prometheus.GaugeOpts{
 			Name: "gpu_util_pct",
 			Help: "GPU utilization percentage",
 		}),
-		SMUtilization: promauto.With(registry).NewGauge(prometheus.GaugeOpts{
+		SMUtilization: f.NewGauge(prometheus.GaugeOpts{
 			Name: "gpu_sm_util_pct",
 			Help: "GPU SM utilization percentage",
 		}),
-		MemoryBWUtilization: promauto.With(registry).NewGauge(prometheus.GaugeOpts{
+		MemoryBWUtilization: f.NewGauge(prometheus.GaugeOpts{
 			Name: "gpu_mem_bw_util_pct",
 			Help: "GPU memory bandwidth utilization percentage",
 		}),
-		VRAMUsed: promauto.With(registry).NewGauge(prometheus.GaugeOpts{
+		VRAMUsed: f.NewGauge(prometheus.GaugeOpts{
 			Name: "gpu_vram_used_gb",
 			Help: "GPU VRAM used in GB",
 		}),
-		VRAMFragmentation: promauto.With(registry).NewGauge(prometheus.GaugeOpts{
+		VRAMFragmentation: f.NewGauge(prometheus.GaugeOpts{
 			Name: "gpu_vram_frag_pct",
 			Help: "GPU VRAM fragmentation percentage",
 		}),
-		MIGSliceUtilization: promauto.With(registry).NewGauge(prometheus.GaugeOpts{
+		MIGSliceUtilization: f.NewGauge(prometheus.GaugeOpts{
 			Name: "gpu_mig_slice_util_pct",
 			Help: "MIG slice utilization percentage",
 		}),
-		NodeModelCacheHit: promauto.With(registry).NewGauge(prometheus.GaugeOpts{
+		PoolGPUs: f.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "pool_gpus",
+			Help: "GPUs allocated to the replicas of an AgentPool",
+		}, []string{"namespace", "pool", "model"}),
+		PoolGPUUtilization: f.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "pool_gpu_util_pct",
+			Help: "Average GPU utilization percentage of the replicas of an AgentPool",
+		}, []string{"namespace", "pool", "model"}),
+		PoolSMUtilization: f.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "pool_gpu_sm_util_pct",
+			Help: "Average GPU SM utilization percentage of the replicas of an AgentPool",
+		}, []string{"namespace", "pool", "model"}),
+		PoolMemoryBWUtilization: f.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "pool_gpu_mem_bw_util_pct",
+			Help: "Average GPU memory bandwidth utilization percentage of the replicas of an AgentPool",
+		}, []string{"namespace", "pool", "model"}),
+		PoolVRAMUsed: f.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "pool_gpu_vram_used_gb",
+			Help: "GPU VRAM used by the replicas of an AgentPool in GB",
+		}, []string{"namespace", "pool", "model"}),
+		PoolVRAMTotal: f.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "pool_gpu_vram_total_gb",
+			Help: "GPU VRAM of the GPUs allocated to the replicas of an AgentPool in GB",
+		}, []string{"namespace", "pool", "model"}),
+		NodeModelCacheHit: f.NewGauge(prometheus.GaugeOpts{
 			Name: "model_cache_hit_ratio",
 			Help: "Node model cache hit ratio",
 		}),
-		ModelLoadTime: promauto.With(registry).NewHistogram(prometheus.HistogramOpts{
+		ModelLoadTime: f.NewHistogramVec(h.apply(prometheus.HistogramOpts{
 			Name:    "model_load_time_seconds",
 			Help:    "Model loading time in seconds",
 			Buckets: []float64{1, 5, 10, 30, 60, 120, 300, 600},
-		}),
-		SnapshotRestoreTime: promauto.With(registry).NewHistogram(prometheus.HistogramOpts{
+		}), []string{"model"}),
+		SnapshotRestoreTime: f.NewHistogram(h.apply(prometheus.HistogramOpts{
 			Name:    "model_snapshot_restore_seconds",
 			Help:    "Model snapshot restore time in seconds",
 			Buckets: []float64{0.5, 1, 2, 5, 10, 30, 60},
-		}),
-		ColdStartRate: promauto.With(registry).NewGauge(prometheus.GaugeOpts{
+		})),
+		ModelDownloadBytes: f.NewCounterVec(prometheus.CounterOpts{
+			Name: "model_download_bytes_total",
+			Help: "Total bytes of model weights downloaded",
+		}, []string{"model"}),
+		ModelDownloadRate: f.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "model_download_bytes_per_second",
+			Help: "Throughput of the last model download in bytes per second",
+		}, []string{"model"}),
+		ModelDistributionBytes: f.NewCounterVec(prometheus.CounterOpts{
+			Name: "model_distribution_bytes_total",
+			Help: "Total bytes of model weights node caches read, by source (peer or origin)",
+		}, []string{"model", "source"}),
+		ModelPeerRequests: f.NewCounterVec(prometheus.CounterOpts{
+			Name: "model_peer_requests_total",
+			Help: "Total chunks of model weights requested from peers, by result (hit, miss or error)",
+		}, []string{"model", "result"}),
+		ModelPeerServedBytes: f.NewCounterVec(prometheus.CounterOpts{
+			Name: "model_peer_served_bytes_total",
+			Help: "Total bytes of model weights served to peers",
+		}, []string{"model"}),
+		ColdStartRate: f.NewGauge(prometheus.GaugeOpts{
 			Name: "agent_cold_start_rate",
 			Help: "Replica cold start rate",
 		}),
+		ColdStartLatency: f.NewHistogram(h.apply(prometheus.HistogramOpts{
+			Name:    "agent_cold_start_seconds",
+			Help:    "Time a request waited for a scaled-to-zero pool to activate",
+			Buckets: []float64{0.5, 1, 2, 5, 10, 30, 60, 120, 300},
+		})),
 
 		// Network & Streaming
-		StreamInitLatency: promauto.With(registry).NewHistogram(prometheus.HistogramOpts{
+		StreamInitLatency: f.NewHistogram(h.apply(prometheus.HistogramOpts{
 			Name:    "stream_init_ms",
 			Help:    "Stream initialization latency in milliseconds",
 			Buckets: []float64{5, 10, 25, 50, 100, 200, 500},
-		}),
-		StreamBackpressure: promauto.With(registry).NewCounter(prometheus.CounterOpts{
+		})),
+		StreamBackpressure: f.NewCounter(prometheus.CounterOpts{
 			Name: "stream_backpressure_events_total",
 			Help: "Total stream backpressure events",
 		}),
-		StreamDropRate: promauto.With(registry).NewGauge(prometheus.GaugeOpts{
+		StreamDropRate: f.NewGauge(prometheus.GaugeOpts{
 			Name: "stream_drop_rate",
 			Help: "Stream drop rate",
 		}),
-		StreamCancelRate: promauto.With(registry).NewGauge(prometheus.GaugeOpts{
+		StreamCancelRate: f.NewGauge(prometheus.GaugeOpts{
 			Name: "stream_cancel_rate",
 			Help: "Stream cancellation rate",
 		}),
-		TokenDeliveryJitter: promauto.With(registry).NewHistogram(prometheus.HistogramOpts{
+		TokenDeliveryJitter: f.NewHistogram(h.apply(prometheus.HistogramOpts{
 			Name:    "token_delivery_jitter_ms",
 			Help:    "Token delivery jitter in milliseconds",
 			Buckets: []float64{1, 5, 10, 25, 50, 100, 200},
-		}),
+		})),
 
 		// Scheduler & Placement
-		GangScheduleWait: promauto.With(registry).NewHistogram(prometheus.HistogramOpts{
+		GangScheduleWait: f.NewHistogram(h.apply(prometheus.HistogramOpts{
 			Name:    "gang_schedule_wait_seconds",
 			Help:    "Gang scheduling wait time in seconds",
 			Buckets: []float64{1, 5, 10, 30, 60, 120, 300},
-		}),
-		TopologyPenaltyScore: promauto.With(registry).NewGauge(prometheus.GaugeOpts{
+		})),
+		TopologyPenaltyScore: f.NewGauge(prometheus.GaugeOpts{
 			Name: "topology_penalty_score",
 			Help: "Topology penalty score for suboptimal placement",
 		}),
-		SessionAffinityHitRate: promauto.With(registry).NewGauge(prometheus.GaugeOpts{
+		SessionAffinityHitRate: f.NewGauge(prometheus.GaugeOpts{
 			Name: "session_affinity_hit_ratio",
-			Help: "Session affinity hit ratio",
+			Help: "Share of requests of established sessions routed to the replica holding the session",
 		}),
-		DataLocalityRate: promauto.With(registry).NewGauge(prometheus.GaugeOpts{
+		DataLocalityRate: f.NewGauge(prometheus.GaugeOpts{
 			Name: "data_locality_rate",
-			Help: "Data locality rate (agent colocated with shard)",
+			Help: "Share of replicas with vector store affinity placed on the node of their stores",
+		}),
+		SchedulerPluginScore: f.NewHistogramVec(h.apply(prometheus.HistogramOpts{
+			Name:    "scheduler_plugin_score",
+			Help:    "Scores (0-100) given to nodes by registered scheduler plugins",
+			Buckets: []float64{10, 20, 30, 40, 50, 60, 70, 80, 90, 100},
+		}), []string{"plugin"}),
+		NodeProvisions: f.NewCounter(prometheus.CounterOpts{
+			Name: "scheduler_node_provisions_total",
+			Help: "Total nodes provisioned through Karpenter for replicas that fit no node",
 		}),
 
 		// Autoscaling & Reliability
-		HPADecisions: promauto.With(registry).NewCounter(prometheus.CounterOpts{
+		HPADecisions: f.NewCounter(prometheus.CounterOpts{
 			Name: "hpa_decisions_total",
 			Help: "Total HPA/KEDA decisions",
 		}),
-		ReplicaPreemptions: promauto.With(registry).NewCounter(prometheus.CounterOpts{
+		ReplicaPreemptions: f.NewCounter(prometheus.CounterOpts{
 			Name: "replica_preemptions_total",
 			Help: "Total replica preemptions",
 		}),
-		ReplicaEvictions: promauto.With(registry).NewCounter(prometheus.CounterOpts{
+		ReplicaEvictions: f.NewCounter(prometheus.CounterOpts{
 			Name: "replica_evictions_total",
 			Help: "Total replica evictions",
 		}),
-		SpotInterruptions: promauto.With(registry).NewCounter(prometheus.CounterOpts{
+		SpotInterruptions: f.NewCounter(prometheus.CounterOpts{
 			Name: "spot_interruptions_total",
 			Help: "Total spot instance interruptions",
 		}),
-		FailoverTime: promauto.With(registry).NewHistogram(prometheus.HistogramOpts{
+		FailoverTime: f.NewHistogram(h.apply(prometheus.HistogramOpts{
 			Name:    "failover_time_seconds",
 			Help:    "Failover time in seconds",
 			Buckets: []float64{1, 5, 10, 30, 60, 120},
-		}),
-		ErrorBudgetBurnRate: promauto.With(registry).NewGauge(prometheus.GaugeOpts{
+		})),
+		ErrorBudgetBurnRate: f.NewGaugeVec(prometheus.GaugeOpts{
 			Name: "error_budget_burn_rate",
-			Help: "Error budget burn rate per SLO",
-		}),
+			Help: "Error budget burn rate of each SLO of an AgentClass over a window",
+		}, []string{"namespace", "agentclass", "slo", "window"}),
 
 		// Security, Safety, Policy
-		PolicyBlocks: promauto.With(registry).NewCounter(prometheus.CounterOpts{
+		PolicyBlocks: f.NewCounter(prometheus.CounterOpts{
 			Name: "policy_blocks_total",
 			Help: "Total policy blocks (safety/PII filters)",
 		}),
-		RedactionEvents: promauto.With(registry).NewCounter(prometheus.CounterOpts{
+		RedactionEvents: f.NewCounter(prometheus.CounterOpts{
 			Name: "redaction_events_total",
 			Help: "Total redaction events",
 		}),
-		AuthzDenials: promauto.With(registry).NewCounter(prometheus.CounterOpts{
+		AuthzDenials: f.NewCounter(prometheus.CounterOpts{
 			Name: "authz_denials_total",
 			Help: "Total authorization denials (tool scope violations)",
 		}),
 
 		// Cost & Carbon
-		CostPer1KTokens: promauto.With(registry).NewGauge(prometheus.GaugeOpts{
+		CostPer1KTokens: f.NewGaugeVec(prometheus.GaugeOpts{
 			Name: "cost_usd_per_1k_tokens",
 			Help: "Cost per 1000 tokens in USD",
-		}),
-		CostPerSession: promauto.With(registry).NewGauge(prometheus.GaugeOpts{
+		}, []string{"model", "tenant"}),
+		CostPerSession: f.NewGauge(prometheus.GaugeOpts{
 			Name: "cost_usd_per_session",
 			Help: "Cost per session in USD",
 		}),
-		GPUHours: promauto.With(registry).NewCounter(prometheus.CounterOpts{
+		GPUHours: f.NewCounter(prometheus.CounterOpts{
 			Name: "gpu_hours_total",
 			Help: "Total GPU hours consumed",
 		}),
-		CPUHours: promauto.With(registry).NewCounter(prometheus.CounterOpts{
+		CPUHours: f.NewCounter(prometheus.CounterOpts{
 			Name: "cpu_hours_total",
 			Help: "Total CPU hours consumed",
 		}),
-		EgressGB: promauto.With(registry).NewCounter(prometheus.CounterOpts{
+		EgressGB: f.NewCounter(prometheus.CounterOpts{
 			Name: "egress_gb_total",
 			Help: "Total egress in GB",
 		}),
-		EnergyKWHPer1KTokens: promauto.With(registry).NewGauge(prometheus.GaugeOpts{
+		EnergyKWHPer1KTokens: f.NewGauge(prometheus.GaugeOpts{
 			Name: "energy_kwh_per_1k_tokens",
 			Help: "Energy consumption per 1000 tokens in kWh",
 		}),
-		SpotSavings: promauto.With(registry).NewCounter(prometheus.CounterOpts{
+		EnergyKWH: f.NewCounter(prometheus.CounterOpts{
+			Name: "energy_kwh_total",
+			Help: "Total estimated GPU energy consumption in kWh",
+		}),
+		CarbonGrams: f.NewCounter(prometheus.CounterOpts{
+			Name: "carbon_grams_total",
+			Help: "Total estimated emissions of GPU energy consumption in gCO2e",
+		}),
+		CarbonGramsPer1KTokens: f.NewGauge(prometheus.GaugeOpts{
+			Name: "carbon_grams_per_1k_tokens",
+			Help: "Estimated emissions per 1000 tokens in gCO2e",
+		}),
+		CarbonIntensity: f.NewGauge(prometheus.GaugeOpts{
+			Name: "carbon_intensity_grams_per_kwh",
+			Help: "Carbon intensity of the electricity grid of the node in gCO2e/kWh",
+		}),
+		SpotSavings: f.NewCounter(prometheus.CounterOpts{
 			Name: "spot_savings_usd_total",
 			Help: "Total spot instance savings in USD (vs on-demand)",
 		}),
+		PoolGPUHours: f.NewCounterVec(prometheus.CounterOpts{
+			Name: "pool_gpu_hours_total",
+			Help: "GPU hours consumed by the replicas of an AgentPool",
+		}, []string{"namespace", "pool"}),
+		PoolCost: f.NewCounterVec(prometheus.CounterOpts{
+			Name: "pool_cost_usd_total",
+			Help: "Cost in USD of the GPUs of an AgentPool",
+		}, []string{"namespace", "pool"}),
+		TenantCost: f.NewCounterVec(prometheus.CounterOpts{
+			Name: "tenant_cost_usd_total",
+			Help: "Cost in USD of an AgentPool attributed to a tenant by its share of the pool's tokens",
+		}, []string{"namespace", "pool", "tenant"}),
+		SessionCost: f.NewHistogramVec(h.apply(prometheus.HistogramOpts{
+			Name:    "session_cost_usd",
+			Help:    "Cost in USD of ended sessions",
+			Buckets: prometheus.ExponentialBuckets(0.001, 4, 8),
+		}), []string{"tenant"}),
+
+		LabelOverflows: f.NewCounterVec(prometheus.CounterOpts{
+			Name: "metrics_label_overflows_total",
+			Help: "Label values recorded as \"other\" because the label reached its bound of distinct values",
+		}, []string{"label"}),
+		LabelValues: f.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "metrics_label_values",
+			Help: "Distinct values recorded for a bounded label",
+		}, []string{"label"}),
 	}
 
-	// Initialize OpenTelemetry meter
-	m.otelMeter = otel.Meter("neuronetes.ai/metrics")
+	// Initialize OpenTelemetry instruments on the global meter provider
+	m.otelMeter, m.otel = defaultOTelInstruments()
 
-	return m
+	m.toolSuccess = window.NewRollingRatio(RatioWindow, RatioGranularity)
+	m.toolTimeouts = window.NewRollingRatio(RatioWindow, RatioGranularity)
+	m.coldStarts = window.NewRollingRatio(RatioWindow, RatioGranularity)
+	m.sessionAffinity = window.NewRollingRatio(RatioWindow, RatioGranularity)
+	m.dataLocality = window.NewRollingRatio(RatioWindow, RatioGranularity)
+	m.streamCancels = window.NewRollingRatio(RatioWindow, RatioGranularity)
+	m.streamDrops = window.NewRollingRatio(RatioWindow, RatioGranularity)
+	m.retrievalHits = window.NewRollingRatio(RatioWindow, RatioGranularity)
+	m.hallucinations = window.NewRollingRatio(RatioWindow, RatioGranularity)
+	m.citations = window.NewRollingRatio(RatioWindow, RatioGranularity)
+	m.retrievalRanks = window.NewRollingMean(RatioWindow, RatioGranularity)
+	m.ttftQuantiles = newDurationQuantiles()
+	m.latencyQuantiles = newDurationQuantiles()
+	m.labels = newCardinalityGuard(DefaultMaxLabelValues, m.LabelOverflows, m.LabelValues)
+	m.factory = f
+	m.custom.metrics = map[string]*CustomMetric{}
+
+	return m, f.err()
+}
+
+// SetMaxLabelValues bounds the distinct values recorded for each of the
+// model, route, tenant, tool and error_type labels. Further values are
+// recorded as OverflowLabelValue. Zero leaves the labels unbounded.
+func (m *AgentMetrics) SetMaxLabelValues(max int) {
+	m.labels.setMax(max)
+}
+
+// SetTenantRegistry bounds the tenant label by the tenant budget of
+// registry rather than by SetMaxLabelValues. It must be set before
+// recording.
+func (m *AgentMetrics) SetTenantRegistry(registry *TenantRegistry) {
+	m.tenants = registry
+}
+
+// tenant returns the value of the tenant label to record for tenant
+func (m *AgentMetrics) tenant(tenant string) string {
+	if m.tenants != nil {
+		return m.tenants.Tenant(tenant)
+	}
+	return m.labels.value("tenant", tenant)
+}
+
+// SetEnergyMeter sets the meter RecordTokens and RecordGPUMetrics feed. It
+// must be set before recording.
+func (m *AgentMetrics) SetEnergyMeter(meter EnergyMeter) {
+	m.energy = meter
+}
+
+// SetTokenLedger sets the ledger RecordTenantTokens accounts tokens in. It
+// must be set before recording.
+func (m *AgentMetrics) SetTokenLedger(ledger TokenLedger) {
+	m.ledger = ledger
 }
 
 // RecordTTFT records time-to-first-token metric
 func (m *AgentMetrics) RecordTTFT(ctx context.Context, ttft time.Duration, model, route string) {
-	m.TTFTHistogram.Observe(float64(ttft.Milliseconds()))
+	labels := MetricsLabels{Model: m.labels.value("model", model), Route: m.labels.value("route", route)}
+	observe(ctx, m.TTFTHistogram.WithLabelValues(labels.Model, labels.Route), float64(ttft.Milliseconds()))
+	m.otel.ttft.Record(ctx, float64(ttft.Milliseconds()), otelAttributes(labels))
+	m.ttftQuantiles.observe(labels.Model, ttft)
 }
 
 // RecordLatency records end-to-end latency
 func (m *AgentMetrics) RecordLatency(ctx context.Context, latency time.Duration, model, route string) {
-	m.LatencyHistogram.Observe(float64(latency.Milliseconds()))
+	labels := MetricsLabels{Model: m.labels.value("model", model), Route: m.labels.value("route", route)}
+	observe(ctx, m.LatencyHistogram.WithLabelValues(labels.Model, labels.Route), float64(latency.Milliseconds()))
+	m.otel.latency.Record(ctx, float64(latency.Milliseconds()), otelAttributes(labels))
+	m.latencyQuantiles.observe(labels.Model, latency)
 }
 
 // RecordTokens records token usage
 func (m *AgentMetrics) RecordTokens(ctx context.Context, inputTokens, outputTokens int64, model string) {
-	m.InputTokens.Add(float64(inputTokens))
-	m.OutputTokens.Add(float64(outputTokens))
-	m.TotalTokens.Add(float64(inputTokens + outputTokens))
+	labels := MetricsLabels{Model: m.labels.value("model", model)}
+	m.InputTokens.WithLabelValues(labels.Model).Add(float64(inputTokens))
+	m.OutputTokens.WithLabelValues(labels.Model).Add(float64(outputTokens))
+	m.TotalTokens.WithLabelValues(labels.Model).Add(float64(inputTokens + outputTokens))
+
+	attrs := otelAttributes(labels)
+	m.otel.inputTokens.Add(ctx, inputTokens, attrs)
+	m.otel.outputTokens.Add(ctx, outputTokens, attrs)
+	m.otel.totalTokens.Add(ctx, inputTokens+outputTokens, attrs)
+	if m.energy != nil {
+		m.energy.RecordTokens(inputTokens + outputTokens)
+	}
 }
 
-// RecordToolCall records tool call metrics
+// RecordTenantTokens records the token usage of a turn of tenant and
+// accounts it in the token ledger, if any. The ledger gets the tenant and
+// model as is, since billing cannot use values folded by the cardinality
+// guard.
+func (m *AgentMetrics) RecordTenantTokens(ctx context.Context, inputTokens, outputTokens int64, model, tenant string) {
+	m.RecordTokens(ctx, inputTokens, outputTokens, model)
+	if m.ledger != nil {
+		m.ledger.Record(tenant, model, inputTokens, outputTokens)
+	}
+}
+
+// RecordToolCall records tool call metrics. Failed calls count toward the
+// timeout rate, since replicas bound tool calls with the toolTimeout of
+// their binding.
 func (m *AgentMetrics) RecordToolCall(ctx context.Context, toolName string, latency time.Duration, success bool) {
-	m.ToolLatency.Observe(float64(latency.Milliseconds()))
-	if !success {
-		m.ToolTimeoutRate.Inc()
+	labels := MetricsLabels{Tool: m.labels.value("tool", toolName)}
+	observe(ctx, m.ToolLatency.WithLabelValues(labels.Tool), float64(latency.Milliseconds()))
+	m.otel.toolLatency.Record(ctx, float64(latency.Milliseconds()),
+		otelAttributes(labels, attribute.Bool("success", success)))
+	m.toolTimeouts.Record(!success)
+	m.ToolTimeoutRate.Set(m.toolTimeouts.Ratio())
+	m.toolSuccess.Record(success)
+	m.ToolSuccessRate.Set(m.toolSuccess.Ratio())
+}
+
+// RecordRetrievalEvaluation records the judged relevance of the documents
+// retrieved for a turn: whether a relevant one was within the top k, and
+// the reciprocal rank of the first relevant one, 0 if none was retrieved
+func (m *AgentMetrics) RecordRetrievalEvaluation(ctx context.Context, hit bool, reciprocalRank float64) {
+	m.retrievalHits.Record(hit)
+	m.RetrievalHitAtK.Set(m.retrievalHits.Ratio())
+	m.retrievalRanks.Observe(reciprocalRank)
+	m.RetrievalMRR.Set(m.retrievalRanks.Mean())
+}
+
+// RecordGroundingEvaluation records the post-hoc check of the answer of a
+// turn against its sources: whether it had spans no source supports, and
+// how many of its citations were checked and found valid
+func (m *AgentMetrics) RecordGroundingEvaluation(ctx context.Context, hallucinated bool, citations, validCitations int) {
+	m.hallucinations.Record(hallucinated)
+	m.HallucinationRate.Set(m.hallucinations.Ratio())
+	for i := 0; i < citations; i++ {
+		m.citations.Record(i < validCitations)
+	}
+	if m.citations.Total() > 0 {
+		m.CitationValidityRate.Set(m.citations.Ratio())
 	}
 }
 
 // RecordError records error metrics
 func (m *AgentMetrics) RecordError(ctx context.Context, errorType, model string) {
-	m.TurnErrorRate.Inc()
+	labels := MetricsLabels{Model: m.labels.value("model", model)}
+	errorType = m.labels.value("error_type", errorType)
+	m.TurnErrorRate.WithLabelValues(labels.Model, errorType).Inc()
+	m.otel.turnErrors.Add(ctx, 1, otelAttributes(labels, attribute.String("error_type", errorType)))
 }
 
 // RecordCost records cost metrics
 func (m *AgentMetrics) RecordCost(ctx context.Context, costUSD float64, tokens int64, model, tenant string) {
+	labels := MetricsLabels{Model: m.labels.value("model", model), Tenant: m.tenant(tenant)}
 	if tokens > 0 {
 		costPer1K := (costUSD / float64(tokens)) * 1000
-		m.CostPer1KTokens.Set(costPer1K)
+		m.CostPer1KTokens.WithLabelValues(labels.Model, labels.Tenant).Set(costPer1K)
 	}
+	m.otel.cost.Add(ctx, costUSD, otelAttributes(labels))
+}
+
+// RecordPoolCost records the GPU hours of an AgentPool and their cost
+func (m *AgentMetrics) RecordPoolCost(ctx context.Context, namespace, pool string, gpuHours, costUSD float64) {
+	m.GPUHours.Add(gpuHours)
+	m.PoolGPUHours.WithLabelValues(namespace, pool).Add(gpuHours)
+	m.PoolCost.WithLabelValues(namespace, pool).Add(costUSD)
+}
+
+// RecordTenantCost records the cost of an AgentPool attributed to tenant
+func (m *AgentMetrics) RecordTenantCost(ctx context.Context, namespace, pool, tenant string, costUSD float64) {
+	m.TenantCost.WithLabelValues(namespace, pool, m.tenant(tenant)).Add(costUSD)
+}
+
+// RecordSessionCost records the cost of an ended session of tenant
+func (m *AgentMetrics) RecordSessionCost(ctx context.Context, tenant string, costUSD float64) {
+	m.CostPerSession.Set(costUSD)
+	m.SessionCost.WithLabelValues(m.tenant(tenant)).Observe(costUSD)
+}
+
+// SetCostPer1KTokens sets the cost per 1000 tokens of tenant on model
+func (m *AgentMetrics) SetCostPer1KTokens(model, tenant string, costUSD float64) {
+	m.CostPer1KTokens.WithLabelValues(m.labels.value("model", model), m.tenant(tenant)).Set(costUSD)
+}
+
+// RecordEnergy records the energy in kWh and emissions in gCO2e estimated
+// for an interval in which tokens were generated, and their intensity per
+// 1000 tokens if any
+func (m *AgentMetrics) RecordEnergy(ctx context.Context, energyKWH, carbonGrams float64, tokens int64) {
+	m.EnergyKWH.Add(energyKWH)
+	m.CarbonGrams.Add(carbonGrams)
+	if tokens > 0 {
+		m.EnergyKWHPer1KTokens.Set(energyKWH / float64(tokens) * 1000)
+		m.CarbonGramsPer1KTokens.Set(carbonGrams / float64(tokens) * 1000)
+	}
+}
+
+// SetCarbonIntensity sets the carbon intensity of the grid of the node in
+// gCO2e/kWh
+func (m *AgentMetrics) SetCarbonIntensity(gramsPerKWH float64) {
+	m.CarbonIntensity.Set(gramsPerKWH)
 }
 
 // SetActiveSessions updates active session count
@@ -478,7 +870,12 @@ func (m *AgentMetrics) SetActiveSessions(count int) {
 
 // SetQueueDepth updates queue depth
 func (m *AgentMetrics) SetQueueDepth(depth int, route string) {
-	m.QueueDepth.Set(float64(depth))
+	m.QueueDepth.WithLabelValues(m.labels.value("route", route)).Set(float64(depth))
+}
+
+// SetTokensInQueue updates the number of queued input tokens
+func (m *AgentMetrics) SetTokensInQueue(tokens int) {
+	m.TokensInQueue.Set(float64(tokens))
 }
 
 // RecordGPUMetrics records GPU utilization metrics
@@ -488,11 +885,54 @@ func (m *AgentMetrics) RecordGPUMetrics(ctx context.Context, node string, gpuUti
 	if vramTotal > 0 {
 		m.VRAMFragmentation.Set((vramTotal - vramUsed) / vramTotal * 100)
 	}
+	if m.energy != nil {
+		m.energy.RecordUtilization(gpuUtil)
+	}
+}
+
+// PoolGPUStats is the GPU usage of the replicas of a pool. Percentages are
+// averaged over the GPUs, memory is summed.
+type PoolGPUStats struct {
+	GPUs            int
+	Utilization     float64
+	SMActive        float64
+	MemoryBandwidth float64
+	VRAMUsedGB      float64
+	VRAMTotalGB     float64
+}
+
+// RecordPoolGPUMetrics records the GPU usage of the replicas of a pool
+// serving model
+func (m *AgentMetrics) RecordPoolGPUMetrics(namespace, pool, model string, stats PoolGPUStats) {
+	model = m.labels.value("model", model)
+	m.PoolGPUs.WithLabelValues(namespace, pool, model).Set(float64(stats.GPUs))
+	m.PoolGPUUtilization.WithLabelValues(namespace, pool, model).Set(stats.Utilization)
+	m.PoolSMUtilization.WithLabelValues(namespace, pool, model).Set(stats.SMActive)
+	m.PoolMemoryBWUtilization.WithLabelValues(namespace, pool, model).Set(stats.MemoryBandwidth)
+	m.PoolVRAMUsed.WithLabelValues(namespace, pool, model).Set(stats.VRAMUsedGB)
+	m.PoolVRAMTotal.WithLabelValues(namespace, pool, model).Set(stats.VRAMTotalGB)
+}
+
+// DeletePoolGPUMetrics removes the GPU usage of a pool without replicas on
+// GPUs
+func (m *AgentMetrics) DeletePoolGPUMetrics(namespace, pool string) {
+	labels := prometheus.Labels{"namespace": namespace, "pool": pool}
+	for _, gauge := range []*prometheus.GaugeVec{m.PoolGPUs, m.PoolGPUUtilization, m.PoolSMUtilization,
+		m.PoolMemoryBWUtilization, m.PoolVRAMUsed, m.PoolVRAMTotal} {
+		gauge.DeletePartialMatch(labels)
+	}
+}
+
+// RecordPluginScore records the score a scheduler plugin gave a node
+func (m *AgentMetrics) RecordPluginScore(ctx context.Context, plugin string, score float64) {
+	m.SchedulerPluginScore.WithLabelValues(plugin).Observe(score)
 }
 
 // RecordModelLoad records model loading time
 func (m *AgentMetrics) RecordModelLoad(ctx context.Context, modelName string, loadTime time.Duration, fromCache bool) {
-	m.ModelLoadTime.Observe(loadTime.Seconds())
+	labels := MetricsLabels{Model: m.labels.value("model", modelName)}
+	observe(ctx, m.ModelLoadTime.WithLabelValues(labels.Model), loadTime.Seconds())
+	m.otel.modelLoadTime.Record(ctx, loadTime.Seconds(), otelAttributes(labels, attribute.Bool("from_cache", fromCache)))
 	if fromCache {
 		m.NodeModelCacheHit.Set(1.0)
 	} else {
@@ -500,20 +940,144 @@ func (m *AgentMetrics) RecordModelLoad(ctx context.Context, modelName string, lo
 	}
 }
 
+// RecordModelDownload records a download of size bytes of the weights of a
+// model that took elapsed
+func (m *AgentMetrics) RecordModelDownload(ctx context.Context, modelName string, size int64, elapsed time.Duration) {
+	labels := MetricsLabels{Model: m.labels.value("model", modelName)}
+	m.ModelDownloadBytes.WithLabelValues(labels.Model).Add(float64(size))
+	m.otel.modelDownload.Add(ctx, size, otelAttributes(labels))
+	if elapsed > 0 {
+		m.ModelDownloadRate.WithLabelValues(labels.Model).Set(float64(size) / elapsed.Seconds())
+	}
+}
+
+// RecordModelDistribution records bytes of the weights of a model read
+// into a node cache from a peer or, if fromPeer is false, from the origin
+func (m *AgentMetrics) RecordModelDistribution(ctx context.Context, modelName string, fromPeer bool, bytes int64) {
+	source := "origin"
+	if fromPeer {
+		source = "peer"
+	}
+	m.ModelDistributionBytes.WithLabelValues(m.labels.value("model", modelName), source).Add(float64(bytes))
+}
+
+// RecordPeerRequest records a request for a chunk of the weights of a
+// model to a peer, with result hit, miss or error
+func (m *AgentMetrics) RecordPeerRequest(ctx context.Context, modelName, result string) {
+	m.ModelPeerRequests.WithLabelValues(m.labels.value("model", modelName), result).Inc()
+}
+
+// RecordPeerServe records bytes of the weights of a model served to a peer
+func (m *AgentMetrics) RecordPeerServe(ctx context.Context, modelName string, bytes int64) {
+	m.ModelPeerServedBytes.WithLabelValues(m.labels.value("model", modelName)).Add(float64(bytes))
+}
+
 // RecordScalingEvent records autoscaling event
 func (m *AgentMetrics) RecordScalingEvent(ctx context.Context, reason string, lagSeconds float64) {
 	m.HPADecisions.Inc()
 	m.ScalingLag.Observe(lagSeconds)
+
+	attrs := metric.WithAttributes(attribute.String("reason", reason))
+	m.otel.hpaDecisions.Add(ctx, 1, attrs)
+	m.otel.scalingLag.Record(ctx, lagSeconds, attrs)
+}
+
+// RecordActivation records whether a request had to wait for its pool to
+// activate from zero replicas, and how long it waited if so
+func (m *AgentMetrics) RecordActivation(ctx context.Context, cold bool, wait time.Duration) {
+	m.coldStarts.Record(cold)
+	m.ColdStartRate.Set(m.coldStarts.Ratio())
+	if cold {
+		observe(ctx, m.ColdStartLatency, wait.Seconds())
+		m.otel.coldStartLatency.Record(ctx, wait.Seconds())
+	}
+}
+
+// RecordSessionRoute records whether a request of an established session
+// was routed to the replica holding the session, or had to be rebound to
+// another replica because its own was gone or not ready
+func (m *AgentMetrics) RecordSessionRoute(ctx context.Context, hit bool) {
+	m.sessionAffinity.Record(hit)
+	m.SessionAffinityHitRate.Set(m.sessionAffinity.Ratio())
+}
+
+// RecordStreamChunk records whether a chunk of a stream was dropped rather
+// than delivered to a client that could not keep up
+func (m *AgentMetrics) RecordStreamChunk(ctx context.Context, dropped bool) {
+	m.streamDrops.Record(dropped)
+	m.StreamDropRate.Set(m.streamDrops.Ratio())
+}
+
+// RecordDataLocality records whether a replica of a pool with vector store
+// affinity was placed on the same node as its stores
+func (m *AgentMetrics) RecordDataLocality(ctx context.Context, colocated bool) {
+	m.dataLocality.Record(colocated)
+	m.DataLocalityRate.Set(m.dataLocality.Ratio())
+}
+
+// RecordBurnRate records the rate at which the SLO slo of an AgentClass
+// burns its error budget over window: 1 spends the budget exactly over the
+// SLO period
+func (m *AgentMetrics) RecordBurnRate(ctx context.Context, namespace, agentClass, slo string, window time.Duration, rate float64) {
+	m.ErrorBudgetBurnRate.WithLabelValues(namespace, agentClass, slo, model.Duration(window).String()).Set(rate)
 }
 
 // RecordPolicyBlock records policy enforcement
 func (m *AgentMetrics) RecordPolicyBlock(ctx context.Context, policyType, reason string) {
 	m.PolicyBlocks.Inc()
+	m.otel.policyBlocks.Add(ctx, 1, metric.WithAttributes(attribute.String("policy_type", policyType)))
 }
 
 // RecordRedaction records PII redaction
 func (m *AgentMetrics) RecordRedaction(ctx context.Context, fieldType string) {
 	m.RedactionEvents.Inc()
+	m.otel.redactionEvents.Add(ctx, 1, metric.WithAttributes(attribute.String("field_type", fieldType)))
+}
+
+// requestIDKey is the context key of the request ID
+type requestIDKey struct{}
+
+// WithRequestID returns ctx carrying the ID of the request it serves, which
+// histograms record as exemplar
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFromContext returns the request ID carried by ctx, if any
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// observe records value in observer with the sampled trace and the request
+// ID of ctx, if any, as exemplar, linking histogram buckets to the traces
+// and requests that landed in them
+func observe(ctx context.Context, observer prometheus.Observer, value float64) {
+	exemplarObserver, ok := observer.(prometheus.ExemplarObserver)
+	if !ok {
+		observer.Observe(value)
+		return
+	}
+
+	exemplar := prometheus.Labels{}
+	runes := 0
+	if spanContext := trace.SpanContextFromContext(ctx); spanContext.IsSampled() {
+		exemplar["trace_id"] = spanContext.TraceID().String()
+		exemplar["span_id"] = spanContext.SpanID().String()
+		runes = len("trace_id") + len(exemplar["trace_id"]) + len("span_id") + len(exemplar["span_id"])
+	}
+	if id := []rune(RequestIDFromContext(ctx)); len(id) > 0 {
+		// Exemplars over the rune limit are rejected, so long IDs are cut
+		if max := prometheus.ExemplarMaxRunes - runes - len("request_id"); len(id) > max {
+			id = id[:max]
+		}
+		exemplar["request_id"] = string(id)
+	}
+	if len(exemplar) == 0 {
+		observer.Observe(value)
+		return
+	}
+	exemplarObserver.ObserveWithExemplar(value, exemplar)
 }
 
 // MetricsLabels defines common label structure
@@ -116,18 +116,42 @@ func TestRecordTokens(t *testing.T) {
 			ctx := context.Background()
 			metrics.RecordTokens(ctx, tt.inputTokens, tt.outputTokens, tt.model)
 
-			// Verify metrics were recorded
-			inputVal := testutil.ToFloat64(metrics.InputTokens)
-			outputVal := testutil.ToFloat64(metrics.OutputTokens)
-			totalVal := testutil.ToFloat64(metrics.TotalTokens)
-
-			assert.Greater(t, inputVal, float64(0))
-			assert.Greater(t, outputVal, float64(0))
-			assert.Greater(t, totalVal, float64(0))
+			// Verify metrics were recorded for the model
+			inputVal := testutil.ToFloat64(metrics.InputTokens.WithLabelValues(tt.model))
+			outputVal := testutil.ToFloat64(metrics.OutputTokens.WithLabelValues(tt.model))
+			totalVal := testutil.ToFloat64(metrics.TotalTokens.WithLabelValues(tt.model))
+
+			assert.Equal(t, float64(tt.inputTokens), inputVal)
+			assert.Equal(t, float64(tt.outputTokens), outputVal)
+			assert.Equal(t, float64(tt.expectedTotal), totalVal)
 		})
 	}
 }
 
+type ledgerFunc func(tenant, model string, inputTokens, outputTokens int64)
+
+func (f ledgerFunc) Record(tenant, model string, inputTokens, outputTokens int64) {
+	f(tenant, model, inputTokens, outputTokens)
+}
+
+func TestRecordTenantTokens(t *testing.T) {
+	metrics := NewAgentMetrics(prometheus.NewRegistry())
+	metrics.SetMaxLabelValues(1)
+
+	recorded := map[string]int64{}
+	metrics.SetTokenLedger(ledgerFunc(func(tenant, model string, inputTokens, outputTokens int64) {
+		recorded[tenant+"/"+model] += inputTokens + outputTokens
+	}))
+	ctx := context.Background()
+	metrics.RecordTenantTokens(ctx, 100, 50, "llama-3-8b", "tenant-1")
+	metrics.RecordTenantTokens(ctx, 10, 5, "llama-3-70b", "tenant-2")
+
+	// The ledger gets model names the cardinality guard folds
+	assert.Equal(t, map[string]int64{"tenant-1/llama-3-8b": 150, "tenant-2/llama-3-70b": 15}, recorded)
+	assert.Equal(t, 100.0, testutil.ToFloat64(metrics.InputTokens.WithLabelValues("llama-3-8b")))
+	assert.Equal(t, 10.0, testutil.ToFloat64(metrics.InputTokens.WithLabelValues(OverflowLabelValue)))
+}
+
 func TestRecordToolCall(t *testing.T) {
 	registry := prometheus.NewRegistry()
 	metrics := NewAgentMetrics(registry)
@@ -163,6 +187,20 @@ func TestRecordToolCall(t *testing.T) {
 	}
 }
 
+func TestRecordToolCallSuccessRate(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	metrics := NewAgentMetrics(registry)
+	ctx := context.Background()
+
+	metrics.RecordToolCall(ctx, "code_search", 100*time.Millisecond, true)
+	metrics.RecordToolCall(ctx, "code_search", 100*time.Millisecond, true)
+	metrics.RecordToolCall(ctx, "code_search", 100*time.Millisecond, true)
+	metrics.RecordToolCall(ctx, "web_search", 5*time.Second, false)
+
+	assert.InDelta(t, 0.75, testutil.ToFloat64(metrics.ToolSuccessRate), 1e-9)
+	assert.InDelta(t, 0.25, testutil.ToFloat64(metrics.ToolTimeoutRate), 1e-9)
+}
+
 func TestRecordCost(t *testing.T) {
 	registry := prometheus.NewRegistry()
 	metrics := NewAgentMetrics(registry)
@@ -198,7 +236,7 @@ func TestRecordCost(t *testing.T) {
 			ctx := context.Background()
 			metrics.RecordCost(ctx, tt.costUSD, tt.tokens, tt.model, tt.tenant)
 
-			costPer1K := testutil.ToFloat64(metrics.CostPer1KTokens)
+			costPer1K := testutil.ToFloat64(metrics.CostPer1KTokens.WithLabelValues(tt.model, tt.tenant))
 			assert.InDelta(t, tt.expectedCostPer1K, costPer1K, 0.01)
 		})
 	}
@@ -228,6 +266,29 @@ func TestSetQueueDepth(t *testing.T) {
 	assert.Equal(t, float64(100), value)
 }
 
+func TestSetTokensInQueue(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	metrics := NewAgentMetrics(registry)
+
+	metrics.SetTokensInQueue(4096)
+
+	value := testutil.ToFloat64(metrics.TokensInQueue)
+	assert.Equal(t, float64(4096), value)
+}
+
+func TestRecordActivation(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	metrics := NewAgentMetrics(registry)
+
+	metrics.RecordActivation(context.Background(), false, 0)
+	metrics.RecordActivation(context.Background(), false, 0)
+	metrics.RecordActivation(context.Background(), false, 0)
+	metrics.RecordActivation(context.Background(), true, 8*time.Second)
+
+	assert.InDelta(t, 0.25, testutil.ToFloat64(metrics.ColdStartRate), 1e-9)
+	assert.Equal(t, 1, testutil.CollectAndCount(metrics.ColdStartLatency))
+}
+
 func TestRecordGPUMetrics(t *testing.T) {
 	registry := prometheus.NewRegistry()
 	metrics := NewAgentMetrics(registry)
@@ -351,6 +412,46 @@ func TestRecordRedaction(t *testing.T) {
 	assert.Greater(t, redactions, float64(0))
 }
 
+func TestRecordRAGEvaluation(t *testing.T) {
+	m := NewAgentMetrics(prometheus.NewRegistry())
+	ctx := context.Background()
+
+	m.RecordRetrievalEvaluation(ctx, true, 1)
+	m.RecordRetrievalEvaluation(ctx, true, 0.5)
+	m.RecordRetrievalEvaluation(ctx, false, 0)
+	m.RecordRetrievalEvaluation(ctx, false, 0.5)
+	assert.Equal(t, 0.5, testutil.ToFloat64(m.RetrievalHitAtK))
+	assert.Equal(t, 0.5, testutil.ToFloat64(m.RetrievalMRR))
+
+	m.RecordGroundingEvaluation(ctx, false, 0, 0)
+	assert.Equal(t, 0.0, testutil.ToFloat64(m.HallucinationRate))
+	assert.Equal(t, 0.0, testutil.ToFloat64(m.CitationValidityRate))
+	m.RecordGroundingEvaluation(ctx, true, 4, 3)
+	assert.Equal(t, 0.5, testutil.ToFloat64(m.HallucinationRate))
+	assert.Equal(t, 0.75, testutil.ToFloat64(m.CitationValidityRate))
+}
+
+func TestCardinalityGuard(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	m := NewAgentMetrics(registry)
+	m.SetMaxLabelValues(2)
+	ctx := context.Background()
+
+	for _, model := range []string{"llama-3-8b", "llama-3-70b", "mistral-7b", "mixtral-8x7b"} {
+		m.RecordTokens(ctx, 100, 50, model)
+	}
+	m.RecordTokens(ctx, 100, 50, "llama-3-8b")
+	m.RecordTokens(ctx, 100, 50, "")
+
+	assert.Equal(t, 4, testutil.CollectAndCount(m.TotalTokens))
+	assert.Equal(t, float64(300), testutil.ToFloat64(m.TotalTokens.WithLabelValues("llama-3-8b")))
+	assert.Equal(t, float64(150), testutil.ToFloat64(m.TotalTokens.WithLabelValues("llama-3-70b")))
+	assert.Equal(t, float64(300), testutil.ToFloat64(m.TotalTokens.WithLabelValues(OverflowLabelValue)))
+	assert.Equal(t, float64(150), testutil.ToFloat64(m.TotalTokens.WithLabelValues(UnknownLabelValue)))
+	assert.Equal(t, float64(2), testutil.ToFloat64(m.LabelOverflows.WithLabelValues("model")))
+	assert.Equal(t, float64(2), testutil.ToFloat64(m.LabelValues.WithLabelValues("model")))
+}
+
 func TestMetricsLabels(t *testing.T) {
 	labels := &MetricsLabels{
 		Model:      "llama-3-70b",
@@ -0,0 +1,211 @@
+/*
+Copyright 2024 NeuroNetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// RequestIDHeader is the header InstrumentHandler reads request IDs from
+// by default
+const RequestIDHeader = "X-Request-ID"
+
+// clock returns the current time, overridden by tests
+var clock = time.Now
+
+// InstrumentOptions configures InstrumentHandler
+type InstrumentOptions struct {
+	// Route names the route of a request. Defaults to its URL path, which
+	// should be overridden for paths carrying IDs.
+	Route func(r *http.Request) string
+
+	// Model names the model serving a request, e.g. from a header. Requests
+	// are recorded without a model if nil.
+	Model func(r *http.Request) string
+
+	// RequestID returns the ID of a request, recorded as exemplar of the
+	// TTFT and latency histograms. Defaults to the X-Request-ID header.
+	RequestID func(r *http.Request) string
+}
+
+// InstrumentHandler returns next recording the metrics of each request it
+// serves, so that the gateway and HTTP ToolBindings need no Record* calls:
+//   - stream_init_ms, until the response headers are written
+//   - agent_ttft_ms, until the first bytes of the body are written
+//   - token_delivery_jitter_ms, how much the gap between consecutive writes
+//     of a streamed body changes from one write to the next
+//   - agent_latency_ms, until next returns
+//   - stream_cancel_rate, the share of requests whose client went away
+//     before the response completed
+//   - agent_turn_errors_total, for 5xx responses
+//
+// Each write of the body is taken as a chunk of tokens, as when streaming
+// server-sent events, except for the comments of event streams, such as
+// heartbeats, which carry no tokens. Connections next hijacks, such as
+// WebSockets, only record stream_init_ms, when they are hijacked. Wrap next
+// in the tracing middleware first, so that histograms get the trace of the
+// request as exemplar along with its ID.
+func (m *AgentMetrics) InstrumentHandler(next http.Handler, opts InstrumentOptions) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := r.URL.Path
+		if opts.Route != nil {
+			route = opts.Route(r)
+		}
+		var model string
+		if opts.Model != nil {
+			model = opts.Model(r)
+		}
+		requestID := r.Header.Get(RequestIDHeader)
+		if opts.RequestID != nil {
+			requestID = opts.RequestID(r)
+		}
+		ctx := r.Context()
+		if requestID != "" {
+			ctx = WithRequestID(ctx, requestID)
+			r = r.WithContext(ctx)
+		}
+
+		iw := &instrumentedWriter{
+			ResponseWriter: w,
+			ctx:            ctx,
+			metrics:        m,
+			model:          model,
+			route:          route,
+			start:          clock(),
+		}
+		next.ServeHTTP(iw, r)
+		if iw.hijacked {
+			// The connection outlived the request, its duration is no turn
+			return
+		}
+
+		cancelled := ctx.Err() != nil || iw.writeErr != nil
+		m.streamCancels.Record(cancelled)
+		m.StreamCancelRate.Set(m.streamCancels.Ratio())
+		if cancelled {
+			// The latency of abandoned requests says nothing about turns
+			return
+		}
+		if iw.status >= http.StatusInternalServerError {
+			m.RecordError(ctx, "http_5xx", model)
+		}
+		m.RecordLatency(ctx, clock().Sub(iw.start), model, route)
+	})
+}
+
+// instrumentedWriter records the stream metrics of a response as it is
+// written
+type instrumentedWriter struct {
+	http.ResponseWriter
+
+	ctx     context.Context
+	metrics *AgentMetrics
+	model   string
+	route   string
+
+	start     time.Time
+	status    int
+	lastWrite time.Time
+	lastGap   time.Duration
+	writes    int
+	writeErr  error
+	hijacked  bool
+}
+
+// WriteHeader implements http.ResponseWriter
+func (w *instrumentedWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+		observe(w.ctx, w.metrics.StreamInitLatency, float64(clock().Sub(w.start).Milliseconds()))
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Write implements http.ResponseWriter
+func (w *instrumentedWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	n, err := w.ResponseWriter.Write(p)
+	if err != nil && w.writeErr == nil {
+		w.writeErr = err
+	}
+	if len(p) == 0 || isEventStreamComment(w.Header(), p) {
+		return n, err
+	}
+
+	now := clock()
+	switch {
+	case w.writes == 0:
+		w.metrics.RecordTTFT(w.ctx, now.Sub(w.start), w.model, w.route)
+	case w.writes == 1:
+		w.lastGap = now.Sub(w.lastWrite)
+	default:
+		gap := now.Sub(w.lastWrite)
+		jitter := gap - w.lastGap
+		if jitter < 0 {
+			jitter = -jitter
+		}
+		observe(w.ctx, w.metrics.TokenDeliveryJitter, float64(jitter.Milliseconds()))
+		w.lastGap = gap
+	}
+	w.lastWrite = now
+	w.writes++
+	return n, err
+}
+
+// Flush implements http.Flusher, so that handlers can stream through the
+// writer
+func (w *instrumentedWriter) Flush() {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker, so that handlers can upgrade connections
+// through the writer
+func (w *instrumentedWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	w.hijacked = true
+	if w.status == 0 {
+		w.status = http.StatusSwitchingProtocols
+		observe(w.ctx, w.metrics.StreamInitLatency, float64(clock().Sub(w.start).Milliseconds()))
+	}
+	return conn, rw, nil
+}
+
+// Unwrap returns the wrapped writer for http.ResponseController
+func (w *instrumentedWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// isEventStreamComment reports whether p is a comment line of a server-sent
+// event stream
+func isEventStreamComment(header http.Header, p []byte) bool {
+	return p[0] == ':' && strings.HasPrefix(header.Get("Content-Type"), "text/event-stream")
+}
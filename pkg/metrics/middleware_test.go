@@ -0,0 +1,162 @@
+/*
+Copyright 2024 NeuroNetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// histogram returns the samples of a histogram
+func histogram(t *testing.T, observer interface{}) *dto.Histogram {
+	t.Helper()
+	var out dto.Metric
+	require.NoError(t, observer.(prometheus.Metric).Write(&out))
+	return out.GetHistogram()
+}
+
+func TestInstrumentHandler(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	clock = func() time.Time { return now }
+	defer func() { clock = time.Now }()
+	advance := func(d time.Duration) { now = now.Add(d) }
+
+	m := NewAgentMetrics(prometheus.NewRegistry())
+	handler := m.InstrumentHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/chat":
+			advance(20 * time.Millisecond)
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.(http.Flusher).Flush()
+			// Tokens 100ms, then 30ms and 50ms apart
+			for _, gap := range []time.Duration{100, 30, 50} {
+				advance(gap * time.Millisecond)
+				_, _ = w.Write([]byte("data: token\n\n"))
+			}
+		case "/fail":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}), InstrumentOptions{Model: func(r *http.Request) string { return r.Header.Get("X-Model") }})
+
+	req := httptest.NewRequest(http.MethodPost, "/chat", nil)
+	req.Header.Set("X-Model", "llama-3-70b")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.True(t, rec.Flushed)
+	assert.Equal(t, "text/event-stream", rec.Header().Get("Content-Type"))
+
+	assert.Equal(t, 20.0, histogram(t, m.StreamInitLatency).GetSampleSum())
+	assert.Equal(t, 120.0, histogram(t, m.TTFTHistogram.WithLabelValues("llama-3-70b", "/chat")).GetSampleSum())
+	assert.Equal(t, 200.0, histogram(t, m.LatencyHistogram.WithLabelValues("llama-3-70b", "/chat")).GetSampleSum())
+	jitter := histogram(t, m.TokenDeliveryJitter)
+	assert.Equal(t, uint64(1), jitter.GetSampleCount())
+	assert.Equal(t, 20.0, jitter.GetSampleSum())
+	assert.Equal(t, 0.0, testutil.ToFloat64(m.StreamCancelRate))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/fail", nil))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.TurnErrorRate.WithLabelValues(UnknownLabelValue, "http_5xx")))
+
+	// Requests abandoned by their client count as cancelled, without latency
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/fail", nil).WithContext(ctx))
+	assert.InDelta(t, 1.0/3, testutil.ToFloat64(m.StreamCancelRate), 1e-9)
+	assert.Equal(t, uint64(1), histogram(t, m.LatencyHistogram.WithLabelValues(UnknownLabelValue, "/fail")).GetSampleCount())
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.TurnErrorRate.WithLabelValues(UnknownLabelValue, "http_5xx")))
+}
+
+func TestInstrumentHandlerIgnoresEventStreamComments(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	clock = func() time.Time { return now }
+	defer func() { clock = time.Now }()
+
+	m := NewAgentMetrics(prometheus.NewRegistry())
+	handler := m.InstrumentHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		now = now.Add(40 * time.Millisecond)
+		_, _ = w.Write([]byte(": heartbeat\n\n"))
+		now = now.Add(60 * time.Millisecond)
+		_, _ = w.Write([]byte("data: token\n\n"))
+	}), InstrumentOptions{})
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/chat", nil))
+	assert.Equal(t, 40.0, histogram(t, m.StreamInitLatency).GetSampleSum())
+	assert.Equal(t, 100.0, histogram(t, m.TTFTHistogram.WithLabelValues(UnknownLabelValue, "/chat")).GetSampleSum())
+}
+
+// exemplarLabels returns the exemplar labels of the buckets of a histogram
+func exemplarLabels(t *testing.T, observer interface{}) map[string]string {
+	t.Helper()
+	labels := map[string]string{}
+	for _, bucket := range histogram(t, observer).GetBucket() {
+		for _, label := range bucket.GetExemplar().GetLabel() {
+			labels[label.GetName()] = label.GetValue()
+		}
+	}
+	return labels
+}
+
+func TestInstrumentHandlerRecordsRequestIDs(t *testing.T) {
+	m := NewAgentMetrics(prometheus.NewRegistry())
+	var seen string
+	handler := m.InstrumentHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = RequestIDFromContext(r.Context())
+		_, _ = w.Write([]byte("data: token\n\n"))
+	}), InstrumentOptions{Route: func(r *http.Request) string { return "/chat" }})
+
+	req := httptest.NewRequest(http.MethodPost, "/chat", nil)
+	req.Header.Set(RequestIDHeader, "req-7f3a")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.Equal(t, "req-7f3a", seen)
+	assert.Equal(t, map[string]string{"request_id": "req-7f3a"}, exemplarLabels(t, m.TTFTHistogram.WithLabelValues(UnknownLabelValue, "/chat")))
+	assert.Equal(t, map[string]string{"request_id": "req-7f3a"}, exemplarLabels(t, m.LatencyHistogram.WithLabelValues(UnknownLabelValue, "/chat")))
+}
+
+func TestInstrumentHandlerHijackedConnections(t *testing.T) {
+	m := NewAgentMetrics(prometheus.NewRegistry())
+	srv := httptest.NewServer(m.InstrumentHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, rw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer conn.Close()
+		_, _ = rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+		_ = rw.Flush()
+	}), InstrumentOptions{Route: func(r *http.Request) string { return "/voice" }}))
+	defer srv.Close()
+
+	res, err := http.Get(srv.URL + "/voice")
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusSwitchingProtocols, res.StatusCode)
+
+	// Only the upgrade is recorded, the connection is no turn
+	assert.Equal(t, uint64(1), histogram(t, m.StreamInitLatency).GetSampleCount())
+	assert.Equal(t, uint64(0), histogram(t, m.LatencyHistogram.WithLabelValues(UnknownLabelValue, "/voice")).GetSampleCount())
+	assert.Equal(t, 0.0, testutil.ToFloat64(m.StreamCancelRate))
+}
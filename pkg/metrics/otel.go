@@ -0,0 +1,201 @@
+/*
+Copyright 2024 NeuroNetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+)
+
+const (
+	// MeterName is the name of the OpenTelemetry meter of AgentMetrics
+	MeterName = "neuronetes.ai/metrics"
+
+	// DefaultOTLPInterval is how often metrics are pushed to the OTLP
+	// collector when OTLPConfig.Interval is zero
+	DefaultOTLPInterval = 30 * time.Second
+)
+
+// otelInstruments are the OpenTelemetry counterparts of the Prometheus
+// counters and histograms of AgentMetrics. Gauges are left to Prometheus.
+type otelInstruments struct {
+	ttft             metric.Float64Histogram
+	latency          metric.Float64Histogram
+	inputTokens      metric.Int64Counter
+	outputTokens     metric.Int64Counter
+	totalTokens      metric.Int64Counter
+	toolLatency      metric.Float64Histogram
+	turnErrors       metric.Int64Counter
+	cost             metric.Float64Counter
+	modelLoadTime    metric.Float64Histogram
+	modelDownload    metric.Int64Counter
+	hpaDecisions     metric.Int64Counter
+	scalingLag       metric.Float64Histogram
+	coldStartLatency metric.Float64Histogram
+	policyBlocks     metric.Int64Counter
+	redactionEvents  metric.Int64Counter
+}
+
+// newOTelInstruments creates the instruments from meter. Names follow the
+// Prometheus metrics they mirror, without the _total suffix exporters add
+// to counters.
+func newOTelInstruments(meter metric.Meter) (*otelInstruments, error) {
+	var errs []error
+	float64Histogram := func(name, unit, description string) metric.Float64Histogram {
+		h, err := meter.Float64Histogram(name, metric.WithUnit(unit), metric.WithDescription(description))
+		errs = append(errs, err)
+		return h
+	}
+	int64Counter := func(name, description string) metric.Int64Counter {
+		c, err := meter.Int64Counter(name, metric.WithDescription(description))
+		errs = append(errs, err)
+		return c
+	}
+
+	i := &otelInstruments{
+		ttft:             float64Histogram("agent_ttft_ms", "ms", "Time to first token in milliseconds"),
+		latency:          float64Histogram("agent_latency_ms", "ms", "End-to-end turn latency in milliseconds"),
+		inputTokens:      int64Counter("agent_input_tokens", "Total input tokens processed"),
+		outputTokens:     int64Counter("agent_output_tokens", "Total output tokens generated"),
+		totalTokens:      int64Counter("agent_total_tokens", "Total tokens (input + output)"),
+		toolLatency:      float64Histogram("agent_tool_latency_ms", "ms", "Tool call latency in milliseconds"),
+		turnErrors:       int64Counter("agent_turn_errors", "Total number of turn errors (5xx + aborted)"),
+		modelLoadTime:    float64Histogram("model_load_time_seconds", "s", "Model loading time in seconds"),
+		modelDownload:    int64Counter("model_download_bytes", "Total bytes of model weights downloaded"),
+		hpaDecisions:     int64Counter("hpa_decisions", "Total HPA/KEDA decisions"),
+		scalingLag:       float64Histogram("agent_scaling_lag_seconds", "s", "Time from load spike to replica ready"),
+		coldStartLatency: float64Histogram("agent_cold_start_seconds", "s", "Time a request waited for a scaled-to-zero pool to activate"),
+		policyBlocks:     int64Counter("policy_blocks", "Total policy blocks (safety/PII filters)"),
+		redactionEvents:  int64Counter("redaction_events", "Total redaction events"),
+	}
+	cost, err := meter.Float64Counter("cost_usd", metric.WithUnit("USD"), metric.WithDescription("Total cost of tokens in USD"))
+	errs = append(errs, err)
+	i.cost = cost
+
+	// Meters return no-op instruments along with errors, so i is usable
+	// either way
+	if err := errors.Join(errs...); err != nil {
+		return i, fmt.Errorf("failed to create OpenTelemetry instruments: %w", err)
+	}
+	return i, nil
+}
+
+// SetMeterProvider records the OpenTelemetry instruments of m through
+// provider instead of the global meter provider
+func (m *AgentMetrics) SetMeterProvider(provider metric.MeterProvider) error {
+	meter := provider.Meter(MeterName)
+	instruments, err := newOTelInstruments(meter)
+	if err != nil {
+		return err
+	}
+	m.otelMeter = meter
+	m.otel = instruments
+	return nil
+}
+
+// otelAttributes returns the OpenTelemetry attributes of labels, with extra
+// attributes added
+func otelAttributes(labels MetricsLabels, extra ...attribute.KeyValue) metric.MeasurementOption {
+	if len(extra) == 0 {
+		return metric.WithAttributeSet(labels.WithLabels())
+	}
+	set := labels.WithLabels()
+	return metric.WithAttributes(append(set.ToSlice(), extra...)...)
+}
+
+// OTLPConfig configures pushing the OpenTelemetry metrics to an OTLP
+// collector over gRPC
+type OTLPConfig struct {
+	// Endpoint is the host:port of the collector. When empty the
+	// OTEL_EXPORTER_OTLP_ENDPOINT environment variable or localhost:4317
+	// is used.
+	Endpoint string
+
+	// Insecure disables TLS to the collector
+	Insecure bool
+
+	// Headers are sent with every export, e.g. for authentication
+	Headers map[string]string
+
+	// Interval is how often metrics are pushed. Defaults to
+	// DefaultOTLPInterval.
+	Interval time.Duration
+
+	// ServiceName identifies the process in the collector
+	ServiceName string
+}
+
+// NewOTLPMeterProvider returns a meter provider pushing metrics to the OTLP
+// collector of config. Callers install it with otel.SetMeterProvider or
+// AgentMetrics.SetMeterProvider, and shut it down on exit to flush the last
+// metrics.
+func NewOTLPMeterProvider(ctx context.Context, config OTLPConfig) (*sdkmetric.MeterProvider, error) {
+	var options []otlpmetricgrpc.Option
+	if config.Endpoint != "" {
+		options = append(options, otlpmetricgrpc.WithEndpoint(config.Endpoint))
+	}
+	if config.Insecure {
+		options = append(options, otlpmetricgrpc.WithInsecure())
+	}
+	if len(config.Headers) > 0 {
+		options = append(options, otlpmetricgrpc.WithHeaders(config.Headers))
+	}
+	exporter, err := otlpmetricgrpc.New(ctx, options...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP metric exporter: %w", err)
+	}
+
+	interval := config.Interval
+	if interval <= 0 {
+		interval = DefaultOTLPInterval
+	}
+	serviceName := config.ServiceName
+	if serviceName == "" {
+		serviceName = "neuronetes"
+	}
+	res, err := resource.Merge(resource.Default(),
+		resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(serviceName)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build OTLP resource: %w", err)
+	}
+
+	return sdkmetric.NewMeterProvider(
+		sdkmetric.WithResource(res),
+		sdkmetric.WithReader(sdkmetric.NewPeriodicReader(exporter, sdkmetric.WithInterval(interval))),
+	), nil
+}
+
+// defaultOTelInstruments creates the instruments from the global meter
+// provider, handing errors to the global error handler
+func defaultOTelInstruments() (metric.Meter, *otelInstruments) {
+	meter := otel.Meter(MeterName)
+	instruments, err := newOTelInstruments(meter)
+	if err != nil {
+		otel.Handle(err)
+	}
+	return meter, instruments
+}
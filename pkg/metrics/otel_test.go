@@ -0,0 +1,137 @@
+/*
+Copyright 2024 NeuroNetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// collectOTel returns the metrics read from reader by name
+func collectOTel(t *testing.T, reader sdkmetric.Reader) map[string]metricdata.Metrics {
+	var rm metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(context.Background(), &rm))
+	collected := map[string]metricdata.Metrics{}
+	for _, scope := range rm.ScopeMetrics {
+		for _, m := range scope.Metrics {
+			collected[m.Name] = m
+		}
+	}
+	return collected
+}
+
+func TestOTelInstrumentsMirrorPrometheus(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	m := NewAgentMetrics(prometheus.NewRegistry())
+	require.NoError(t, m.SetMeterProvider(provider))
+	ctx := context.Background()
+
+	m.RecordTTFT(ctx, 300*time.Millisecond, "llama-3-70b", "/chat")
+	m.RecordTTFT(ctx, 500*time.Millisecond, "llama-3-8b", "/chat")
+	m.RecordTokens(ctx, 1000, 500, "llama-3-70b")
+	m.RecordToolCall(ctx, "code_search", 100*time.Millisecond, false)
+	m.RecordCost(ctx, 0.15, 1500, "llama-3-70b", "tenant-1")
+	m.RecordError(ctx, "timeout", "llama-3-70b")
+
+	collected := collectOTel(t, reader)
+
+	ttft := collected["agent_ttft_ms"].Data.(metricdata.Histogram[float64])
+	require.Len(t, ttft.DataPoints, 2)
+	for _, point := range ttft.DataPoints {
+		model, _ := point.Attributes.Value("model")
+		route, _ := point.Attributes.Value("route")
+		assert.Equal(t, "/chat", route.AsString())
+		assert.Contains(t, []string{"llama-3-70b", "llama-3-8b"}, model.AsString())
+		assert.Equal(t, uint64(1), point.Count)
+	}
+
+	total := collected["agent_total_tokens"].Data.(metricdata.Sum[int64])
+	require.Len(t, total.DataPoints, 1)
+	assert.Equal(t, int64(1500), total.DataPoints[0].Value)
+	assert.Equal(t, testutil.ToFloat64(m.TotalTokens.WithLabelValues("llama-3-70b")), float64(total.DataPoints[0].Value))
+	assert.Equal(t, attribute.NewSet(attribute.String("model", "llama-3-70b")), total.DataPoints[0].Attributes)
+
+	tool := collected["agent_tool_latency_ms"].Data.(metricdata.Histogram[float64])
+	require.Len(t, tool.DataPoints, 1)
+	success, _ := tool.DataPoints[0].Attributes.Value("success")
+	assert.False(t, success.AsBool())
+
+	cost := collected["cost_usd"].Data.(metricdata.Sum[float64])
+	require.Len(t, cost.DataPoints, 1)
+	assert.InDelta(t, 0.15, cost.DataPoints[0].Value, 1e-9)
+	tenant, _ := cost.DataPoints[0].Attributes.Value("tenant")
+	assert.Equal(t, "tenant-1", tenant.AsString())
+
+	errs := collected["agent_turn_errors"].Data.(metricdata.Sum[int64])
+	require.Len(t, errs.DataPoints, 1)
+	errorType, _ := errs.DataPoints[0].Attributes.Value("error_type")
+	assert.Equal(t, "timeout", errorType.AsString())
+}
+
+func TestHistogramExemplarsLinkTraces(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	m := NewAgentMetrics(registry)
+	ctx, span := sdktrace.NewTracerProvider().Tracer("test").Start(context.Background(), "turn")
+	defer span.End()
+
+	m.RecordTTFT(ctx, 300*time.Millisecond, "llama-3-70b", "/chat")
+	// Observations outside of a trace carry no exemplar
+	m.RecordLatency(context.Background(), time.Second, "llama-3-70b", "/chat")
+
+	families, err := registry.Gather()
+	require.NoError(t, err)
+	exemplars := map[string][]string{}
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			for _, bucket := range metric.GetHistogram().GetBucket() {
+				for _, label := range bucket.GetExemplar().GetLabel() {
+					exemplars[family.GetName()] = append(exemplars[family.GetName()], label.GetName()+"="+label.GetValue())
+				}
+			}
+		}
+	}
+	assert.ElementsMatch(t, []string{
+		"trace_id=" + span.SpanContext().TraceID().String(),
+		"span_id=" + span.SpanContext().SpanID().String(),
+	}, exemplars["agent_ttft_ms"])
+	assert.Empty(t, exemplars["agent_latency_ms"])
+}
+
+func TestHistogramExemplarsLinkRequests(t *testing.T) {
+	m := NewAgentMetrics(prometheus.NewRegistry())
+	ctx, span := sdktrace.NewTracerProvider().Tracer("test").Start(context.Background(), "turn")
+	defer span.End()
+
+	// IDs too long for the exemplar are cut to fit
+	id := strings.Repeat("r", 100)
+	m.RecordTTFT(WithRequestID(ctx, id), 300*time.Millisecond, "llama-3-70b", "/chat")
+	labels := exemplarLabels(t, m.TTFTHistogram.WithLabelValues("llama-3-70b", "/chat"))
+	assert.Equal(t, span.SpanContext().TraceID().String(), labels["trace_id"])
+	assert.Equal(t, id[:128-len("trace_id")-32-len("span_id")-16-len("request_id")], labels["request_id"])
+}
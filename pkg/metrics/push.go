@@ -0,0 +1,157 @@
+/*
+Copyright 2024 NeuroNetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// PushgatewayURLEnv is the environment variable PushConfigFromEnv reads
+	// the Pushgateway URL from
+	PushgatewayURLEnv = "NEURONETES_PUSHGATEWAY_URL"
+
+	// DefaultPushTimeout bounds the final push of a Pusher
+	DefaultPushTimeout = 10 * time.Second
+)
+
+// PushConfig configures pushing metrics to a Prometheus Pushgateway
+type PushConfig struct {
+	// URL is the address of the Pushgateway, e.g. http://pushgateway:9091
+	URL string
+
+	// Job is the job label of the pushed series
+	Job string
+
+	// Grouping labels identify the pushed group within the job, so that
+	// concurrent runs of a job do not replace each other's series.
+	// Usually instance set to the pod name.
+	Grouping map[string]string
+
+	// Interval pushes periodically while Run runs, so that long jobs are
+	// visible before they finish. Zero only pushes when Run returns.
+	Interval time.Duration
+}
+
+// PushConfigFromEnv returns the configuration of job from the environment:
+// the URL from NEURONETES_PUSHGATEWAY_URL and the instance from POD_NAME,
+// falling back to the hostname. ok is false if no URL is set.
+func PushConfigFromEnv(job string) (PushConfig, bool) {
+	url := os.Getenv(PushgatewayURLEnv)
+	if url == "" {
+		return PushConfig{}, false
+	}
+	instance := os.Getenv("POD_NAME")
+	if instance == "" {
+		instance, _ = os.Hostname()
+	}
+	cfg := PushConfig{URL: url, Job: job}
+	if instance != "" {
+		cfg.Grouping = map[string]string{"instance": instance}
+	}
+	return cfg, true
+}
+
+// Pusher pushes the metrics of a gatherer to a Pushgateway, for short-lived
+// components such as model download and quantization jobs that finish
+// before Prometheus scrapes them
+type Pusher struct {
+	cfg    PushConfig
+	pusher *push.Pusher
+}
+
+// NewPusher creates a pusher of the metrics of gatherer, e.g. the registry
+// of an AgentMetrics
+func NewPusher(cfg PushConfig, gatherer prometheus.Gatherer) (*Pusher, error) {
+	if cfg.URL == "" {
+		return nil, errors.New("pushgateway URL is required")
+	}
+	if cfg.Job == "" {
+		return nil, errors.New("pushgateway job is required")
+	}
+	if cfg.Interval < 0 {
+		return nil, fmt.Errorf("invalid push interval %v", cfg.Interval)
+	}
+
+	pusher := push.New(cfg.URL, cfg.Job).Gatherer(gatherer)
+	// Sorted so that invalid groupings fail the same way every time
+	names := make([]string, 0, len(cfg.Grouping))
+	for name := range cfg.Grouping {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		pusher = pusher.Grouping(name, cfg.Grouping[name])
+	}
+	if err := pusher.Error(); err != nil {
+		return nil, fmt.Errorf("invalid pushgateway grouping: %w", err)
+	}
+	return &Pusher{cfg: cfg, pusher: pusher}, nil
+}
+
+// Push replaces the series of the group on the Pushgateway with the
+// current ones
+func (p *Pusher) Push(ctx context.Context) error {
+	if err := p.pusher.PushContext(ctx); err != nil {
+		return fmt.Errorf("failed to push metrics to %s: %w", p.cfg.URL, err)
+	}
+	return nil
+}
+
+// Delete removes the series of the group from the Pushgateway
+func (p *Pusher) Delete() error {
+	if err := p.pusher.Delete(); err != nil {
+		return fmt.Errorf("failed to delete metrics from %s: %w", p.cfg.URL, err)
+	}
+	return nil
+}
+
+// Run pushes every interval until ctx is done, then pushes once more so
+// that the final values of the job are kept. Failed periodic pushes are
+// logged and retried on the next tick; the error of the final push is
+// returned.
+func (p *Pusher) Run(ctx context.Context) error {
+	logger := log.FromContext(ctx).WithName("pusher")
+	var tick <-chan time.Time
+	if p.cfg.Interval > 0 {
+		ticker := time.NewTicker(p.cfg.Interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			// ctx is done, so the final push gets its own deadline
+			final, cancel := context.WithTimeout(context.Background(), DefaultPushTimeout)
+			defer cancel()
+			return p.Push(final)
+		case <-tick:
+			if err := p.Push(ctx); err != nil {
+				logger.Error(err, "periodic push failed")
+			}
+		}
+	}
+}
@@ -0,0 +1,126 @@
+/*
+Copyright 2024 NeuroNetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// pushgateway records the pushes it receives
+type pushgateway struct {
+	mu     sync.Mutex
+	pushes []string
+	bodies [][]byte
+}
+
+func (g *pushgateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.pushes = append(g.pushes, r.Method+" "+r.URL.Path)
+	g.bodies = append(g.bodies, body)
+	if r.Method == http.MethodDelete {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (g *pushgateway) count() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return len(g.pushes)
+}
+
+func TestPusherPushesModelDownloads(t *testing.T) {
+	gateway := &pushgateway{}
+	server := httptest.NewServer(gateway)
+	defer server.Close()
+
+	registry := prometheus.NewRegistry()
+	m := NewAgentMetrics(registry)
+	m.RecordModelDownload(context.Background(), "llama-3-70b", 4<<30, 8*time.Second)
+	m.RecordModelLoad(context.Background(), "llama-3-70b", 40*time.Second, false)
+	assert.Equal(t, float64(4<<30), testutil.ToFloat64(m.ModelDownloadBytes))
+	assert.Equal(t, float64(512<<20), testutil.ToFloat64(m.ModelDownloadRate))
+
+	pusher, err := NewPusher(PushConfig{
+		URL:      server.URL,
+		Job:      "model-download",
+		Grouping: map[string]string{"instance": "download-llama-abc12"},
+	}, registry)
+	require.NoError(t, err)
+	require.NoError(t, pusher.Push(context.Background()))
+	require.NoError(t, pusher.Delete())
+
+	require.Equal(t, []string{
+		"PUT /metrics/job/model-download/instance/download-llama-abc12",
+		"DELETE /metrics/job/model-download/instance/download-llama-abc12",
+	}, gateway.pushes)
+	assert.NotEmpty(t, gateway.bodies[0])
+}
+
+func TestPusherRunPushesOnStop(t *testing.T) {
+	gateway := &pushgateway{}
+	server := httptest.NewServer(gateway)
+	defer server.Close()
+
+	pusher, err := NewPusher(PushConfig{URL: server.URL, Job: "quantize", Interval: 10 * time.Millisecond}, prometheus.NewRegistry())
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- pusher.Run(ctx) }()
+	require.Eventually(t, func() bool { return gateway.count() >= 2 }, time.Second, 5*time.Millisecond)
+	cancel()
+	require.NoError(t, <-done)
+
+	pushes := gateway.count()
+	assert.Equal(t, "PUT /metrics/job/quantize", gateway.pushes[pushes-1])
+}
+
+func TestPushConfigFromEnv(t *testing.T) {
+	t.Setenv(PushgatewayURLEnv, "")
+	_, ok := PushConfigFromEnv("model-download")
+	assert.False(t, ok)
+
+	t.Setenv(PushgatewayURLEnv, "http://pushgateway:9091")
+	t.Setenv("POD_NAME", "download-llama-abc12")
+	cfg, ok := PushConfigFromEnv("model-download")
+	require.True(t, ok)
+	assert.Equal(t, PushConfig{
+		URL:      "http://pushgateway:9091",
+		Job:      "model-download",
+		Grouping: map[string]string{"instance": "download-llama-abc12"},
+	}, cfg)
+
+	_, err := NewPusher(PushConfig{URL: cfg.URL}, prometheus.NewRegistry())
+	assert.Error(t, err)
+	_, err = NewPusher(PushConfig{URL: cfg.URL, Job: "j", Grouping: map[string]string{"in valid": "x"}}, prometheus.NewRegistry())
+	assert.Error(t, err)
+}
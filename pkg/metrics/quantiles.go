@@ -0,0 +1,91 @@
+/*
+Copyright 2024 NeuroNetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"sync"
+	"time"
+
+	"github.com/bowenislandsong/neuronetes/pkg/metrics/window"
+)
+
+const (
+	// QuantileWindow is the sliding window of in-process quantiles
+	QuantileWindow = RatioWindow
+
+	// QuantileAgeBuckets is the number of streams of a quantile window;
+	// quantiles cover between 4/5 of the window and the whole window
+	QuantileAgeBuckets = 5
+)
+
+// durationQuantiles estimates quantiles of the durations recorded per
+// model, and over all models under the empty model
+type durationQuantiles struct {
+	mu     sync.Mutex
+	models map[string]*window.RollingQuantile
+}
+
+func newDurationQuantiles() *durationQuantiles {
+	return &durationQuantiles{models: map[string]*window.RollingQuantile{}}
+}
+
+// observe records d for model. model is a value of the model label, so the
+// models kept are bounded by the cardinality guard.
+func (d *durationQuantiles) observe(model string, duration time.Duration) {
+	ms := float64(duration) / float64(time.Millisecond)
+	d.get("").Observe(ms)
+	d.get(model).Observe(ms)
+}
+
+// quantile returns the q-quantile of the durations of model
+func (d *durationQuantiles) quantile(model string, q float64) (time.Duration, bool) {
+	d.mu.Lock()
+	estimator, ok := d.models[model]
+	d.mu.Unlock()
+	if !ok {
+		return 0, false
+	}
+	ms, ok := estimator.Quantile(q)
+	return time.Duration(ms * float64(time.Millisecond)), ok
+}
+
+func (d *durationQuantiles) get(model string) *window.RollingQuantile {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	estimator, ok := d.models[model]
+	if !ok {
+		estimator = window.NewRollingQuantile(QuantileWindow, QuantileAgeBuckets, nil)
+		d.models[model] = estimator
+	}
+	return estimator
+}
+
+// TTFTQuantile returns the q-quantile of the time to first token recorded
+// by this process over the last QuantileWindow, for model or for all models
+// if model is empty, and false if no turn was recorded. It lets controllers
+// read e.g. p95 TTFT without querying Prometheus; quantiles are accurate
+// for window.DefaultObjectives. Models past the cardinality bound are
+// recorded as OverflowLabelValue.
+func (m *AgentMetrics) TTFTQuantile(model string, q float64) (time.Duration, bool) {
+	return m.ttftQuantiles.quantile(model, q)
+}
+
+// LatencyQuantile returns the q-quantile of the end-to-end latency recorded
+// by this process over the last QuantileWindow, as TTFTQuantile
+func (m *AgentMetrics) LatencyQuantile(model string, q float64) (time.Duration, bool) {
+	return m.latencyQuantiles.quantile(model, q)
+}
@@ -0,0 +1,59 @@
+/*
+Copyright 2024 NeuroNetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInProcessQuantiles(t *testing.T) {
+	m := NewAgentMetrics(prometheus.NewRegistry())
+	ctx := context.Background()
+
+	_, ok := m.TTFTQuantile("", 0.95)
+	assert.False(t, ok)
+
+	for i := 1; i <= 1000; i++ {
+		m.RecordTTFT(ctx, time.Duration(i)*time.Millisecond, "llama-3-70b", "/chat")
+		m.RecordLatency(ctx, time.Duration(i)*10*time.Millisecond, "llama-3-70b", "/chat")
+	}
+	m.RecordTTFT(ctx, 5*time.Second, "mistral-7b", "/chat")
+
+	p95, ok := m.TTFTQuantile("llama-3-70b", 0.95)
+	assert.True(t, ok)
+	assert.InDelta(t, float64(950*time.Millisecond), float64(p95), float64(5*time.Millisecond))
+
+	p50, ok := m.LatencyQuantile("llama-3-70b", 0.5)
+	assert.True(t, ok)
+	assert.InDelta(t, float64(5*time.Second), float64(p50), float64(500*time.Millisecond))
+
+	p99, ok := m.TTFTQuantile("mistral-7b", 0.99)
+	assert.True(t, ok)
+	assert.Equal(t, 5*time.Second, p99)
+
+	max, ok := m.TTFTQuantile("", 1)
+	assert.True(t, ok)
+	assert.Equal(t, 5*time.Second, max)
+
+	_, ok = m.LatencyQuantile("mistral-7b", 0.5)
+	assert.False(t, ok)
+}
@@ -0,0 +1,344 @@
+/*
+Copyright 2024 NeuroNetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"sigs.k8s.io/yaml"
+)
+
+// MetricFamilies are the groups of metrics Config.Disable accepts by name,
+// as patterns matching the metric names
+var MetricFamilies = map[string][]string{
+	"rag":       {"rag_*", "agent_grounding_coverage", "agent_hallucination_rate", "agent_citation_validity_rate"},
+	"tools":     {"agent_tool_*"},
+	"gpu":       {"gpu_*_pct", "gpu_vram_used_gb", "pool_gpus", "pool_gpu_*_pct", "pool_gpu_vram_*"},
+	"streaming": {"stream_*", "token_delivery_jitter_ms"},
+	"security":  {"policy_blocks_total", "redaction_events_total", "authz_denials_total"},
+	"cost": {"cost_usd_*", "session_cost_usd", "gpu_hours_total", "cpu_hours_total", "egress_gb_total",
+		"spot_savings_usd_total", "pool_gpu_hours_total", "pool_cost_usd_total", "tenant_cost_usd_total"},
+	"carbon":  {"energy_*", "carbon_*"},
+	"plugins": {CustomMetricPrefix + "*"},
+}
+
+// Config configures AgentMetrics, usually read from a file with LoadConfig
+// to control the scrape cost of very large clusters
+type Config struct {
+	HistogramOptions
+
+	// Disable lists the metrics that are not exported, by family name
+	// (see MetricFamilies) or metric name pattern, e.g. rag or energy_*.
+	// Disabled metrics are still recorded in process, so in-process
+	// readers such as quantiles keep working.
+	Disable []string `json:"disable,omitempty"`
+
+	// DropLabels lists labels to remove from metrics by metric name. Series
+	// only differing by dropped labels are summed at collection, which
+	// suits counters, histograms and gauges counting things, but not gauges
+	// holding ratios. Exemplars and native histogram buckets of those
+	// metrics are not exported.
+	DropLabels map[string][]string `json:"dropLabels,omitempty"`
+}
+
+// LoadConfig reads Config from a YAML or JSON file, e.g.
+//
+//	disable: [rag, carbon]
+//	dropLabels:
+//	  agent_ttft_ms: [route]
+//	buckets:
+//	  agent_ttft_ms: [25, 50, 100, 200, 350, 500, 1000]
+func LoadConfig(path string) (Config, error) {
+	var config Config
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return config, fmt.Errorf("failed to read metrics config: %w", err)
+	}
+	if err := yaml.UnmarshalStrict(data, &config); err != nil {
+		return config, fmt.Errorf("failed to parse metrics config %s: %w", path, err)
+	}
+	return config, config.validate()
+}
+
+// validate checks the histogram options and disable patterns of c. Labels
+// to drop are checked as metrics are created.
+func (c *Config) validate() error {
+	if err := c.HistogramOptions.validate(); err != nil {
+		return err
+	}
+	for _, pattern := range c.Disable {
+		if _, ok := MetricFamilies[pattern]; ok {
+			continue
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid metric pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// disabled returns true if c disables the metric name
+func (c *Config) disabled(name string) bool {
+	for _, pattern := range c.Disable {
+		patterns, ok := MetricFamilies[pattern]
+		if !ok {
+			patterns = []string{pattern}
+		}
+		for _, p := range patterns {
+			if ok, _ := path.Match(p, name); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// factory creates the collectors of AgentMetrics and registers them as
+// configured, like promauto does otherwise
+type factory struct {
+	registry prometheus.Registerer
+	config   *Config
+
+	// created are the names of the metrics created, and errs the labels
+	// to drop that the metrics do not have
+	created map[string]bool
+	errs    []error
+}
+
+func newFactory(registry prometheus.Registerer, config *Config) *factory {
+	return &factory{registry: registry, config: config, created: map[string]bool{}}
+}
+
+// NewCounter creates and registers a counter
+func (f *factory) NewCounter(opts prometheus.CounterOpts) prometheus.Counter {
+	c := prometheus.NewCounter(opts)
+	f.mustRegister(opts.Name, opts.Help, nil, c)
+	return c
+}
+
+// NewCounterVec creates and registers a counter vector
+func (f *factory) NewCounterVec(opts prometheus.CounterOpts, labels []string) *prometheus.CounterVec {
+	c := prometheus.NewCounterVec(opts, labels)
+	f.mustRegister(opts.Name, opts.Help, labels, c)
+	return c
+}
+
+// NewGauge creates and registers a gauge
+func (f *factory) NewGauge(opts prometheus.GaugeOpts) prometheus.Gauge {
+	g := prometheus.NewGauge(opts)
+	f.mustRegister(opts.Name, opts.Help, nil, g)
+	return g
+}
+
+// NewGaugeVec creates and registers a gauge vector
+func (f *factory) NewGaugeVec(opts prometheus.GaugeOpts, labels []string) *prometheus.GaugeVec {
+	g := prometheus.NewGaugeVec(opts, labels)
+	f.mustRegister(opts.Name, opts.Help, labels, g)
+	return g
+}
+
+// NewHistogram creates and registers a histogram
+func (f *factory) NewHistogram(opts prometheus.HistogramOpts) prometheus.Histogram {
+	h := prometheus.NewHistogram(opts)
+	f.mustRegister(opts.Name, opts.Help, nil, h)
+	return h
+}
+
+// NewHistogramVec creates and registers a histogram vector
+func (f *factory) NewHistogramVec(opts prometheus.HistogramOpts, labels []string) *prometheus.HistogramVec {
+	h := prometheus.NewHistogramVec(opts, labels)
+	f.mustRegister(opts.Name, opts.Help, labels, h)
+	return h
+}
+
+// mustRegister registers the collector of the metric name, panicking like
+// promauto if registration fails. Labels to drop that the metric does not
+// have are left for err.
+func (f *factory) mustRegister(name, help string, labels []string, c prometheus.Collector) {
+	f.created[name] = true
+	collector, err := f.collector(name, help, labels, c)
+	if err != nil {
+		f.errs = append(f.errs, err)
+		return
+	}
+	if collector != nil {
+		f.registry.MustRegister(collector)
+	}
+}
+
+// register registers the collector of the metric name, returning the
+// error of registration or of labels to drop that the metric does not have
+func (f *factory) register(name, help string, labels []string, c prometheus.Collector) error {
+	collector, err := f.collector(name, help, labels, c)
+	if err != nil || collector == nil {
+		return err
+	}
+	return f.registry.Register(collector)
+}
+
+// collector returns the collector to register for c, or nil if the metric
+// is disabled
+func (f *factory) collector(name, help string, labels []string, c prometheus.Collector) (prometheus.Collector, error) {
+	if f.config.disabled(name) {
+		return nil, nil
+	}
+	drop := f.config.DropLabels[name]
+	if len(drop) == 0 {
+		return c, nil
+	}
+
+	dropped := map[string]bool{}
+	for _, label := range drop {
+		dropped[label] = true
+	}
+	var kept []string
+	for _, label := range labels {
+		if dropped[label] {
+			delete(dropped, label)
+			continue
+		}
+		kept = append(kept, label)
+	}
+	if len(dropped) > 0 {
+		missing := make([]string, 0, len(dropped))
+		for label := range dropped {
+			missing = append(missing, label)
+		}
+		sort.Strings(missing)
+		return nil, fmt.Errorf("metric %s has no labels %s to drop", name, strings.Join(missing, ", "))
+	}
+	return &labelDropper{
+		collector: c,
+		desc:      prometheus.NewDesc(name, help, kept, nil),
+		kept:      kept,
+	}, nil
+}
+
+// err returns the errors of labels to drop that their metric does not
+// have, or that are configured for core metrics that do not exist. Custom
+// metrics are checked as plugins register them.
+func (f *factory) err() error {
+	errs := append([]error(nil), f.errs...)
+	names := make([]string, 0, len(f.config.DropLabels))
+	for name := range f.config.DropLabels {
+		if !strings.HasPrefix(name, CustomMetricPrefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !f.created[name] {
+			errs = append(errs, fmt.Errorf("cannot drop labels of unknown metric %s", name))
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("invalid metrics config: %w", err)
+	}
+	return nil
+}
+
+// labelDropper exports the series of a vector without some of its labels,
+// summing the series left with the same labels
+type labelDropper struct {
+	collector prometheus.Collector
+	desc      *prometheus.Desc
+	kept      []string
+}
+
+// Describe implements prometheus.Collector
+func (d *labelDropper) Describe(ch chan<- *prometheus.Desc) {
+	ch <- d.desc
+}
+
+// aggregate is the sum of the series with the same kept labels
+type aggregate struct {
+	values    []string
+	valueType prometheus.ValueType
+	value     float64
+
+	histogram bool
+	count     uint64
+	sum       float64
+	buckets   map[float64]uint64
+}
+
+// Collect implements prometheus.Collector
+func (d *labelDropper) Collect(ch chan<- prometheus.Metric) {
+	metrics := make(chan prometheus.Metric)
+	go func() {
+		d.collector.Collect(metrics)
+		close(metrics)
+	}()
+
+	aggregates := map[string]*aggregate{}
+	var keys []string
+	for metric := range metrics {
+		var m dto.Metric
+		if err := metric.Write(&m); err != nil {
+			ch <- prometheus.NewInvalidMetric(d.desc, err)
+			continue
+		}
+		labels := make(map[string]string, len(m.Label))
+		for _, pair := range m.Label {
+			labels[pair.GetName()] = pair.GetValue()
+		}
+		values := make([]string, len(d.kept))
+		for i, label := range d.kept {
+			values[i] = labels[label]
+		}
+		key := strings.Join(values, "\xff")
+		a, ok := aggregates[key]
+		if !ok {
+			a = &aggregate{values: values, buckets: map[float64]uint64{}}
+			aggregates[key] = a
+			keys = append(keys, key)
+		}
+
+		switch {
+		case m.Counter != nil:
+			a.valueType = prometheus.CounterValue
+			a.value += m.Counter.GetValue()
+		case m.Gauge != nil:
+			a.valueType = prometheus.GaugeValue
+			a.value += m.Gauge.GetValue()
+		case m.Histogram != nil:
+			a.histogram = true
+			a.count += m.Histogram.GetSampleCount()
+			a.sum += m.Histogram.GetSampleSum()
+			for _, b := range m.Histogram.Bucket {
+				a.buckets[b.GetUpperBound()] += b.GetCumulativeCount()
+			}
+		}
+	}
+
+	sort.Strings(keys)
+	for _, key := range keys {
+		a := aggregates[key]
+		if a.histogram {
+			ch <- prometheus.MustNewConstHistogram(d.desc, a.count, a.sum, a.buckets, a.values...)
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(d.desc, a.valueType, a.value, a.values...)
+	}
+}
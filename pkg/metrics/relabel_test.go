@@ -0,0 +1,138 @@
+/*
+Copyright 2024 NeuroNetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigDisablesFamilies(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	m, err := NewAgentMetricsWithConfig(registry, Config{Disable: []string{"rag", "carbon", "agent_tool_timeout_rate"}})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	m.RecordRetrievalEvaluation(ctx, true, 1)
+	m.RecordEnergy(ctx, 0.5, 200, 1000)
+	m.RecordTTFT(ctx, 100*time.Millisecond, "llama-3-70b", "/chat")
+
+	families, err := registry.Gather()
+	require.NoError(t, err)
+	names := map[string]bool{}
+	for _, family := range families {
+		names[family.GetName()] = true
+	}
+	assert.True(t, names["agent_ttft_ms"])
+	assert.True(t, names["agent_tool_success_rate"])
+	for _, name := range []string{"rag_hit_at_k", "rag_mrr", "agent_hallucination_rate", "energy_kwh_total", "carbon_grams_total", "agent_tool_timeout_rate"} {
+		assert.False(t, names[name], name)
+	}
+
+	// Disabled metrics are still recorded in process
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.RetrievalHitAtK))
+
+	m, err = NewAgentMetricsWithConfig(prometheus.NewRegistry(), Config{Disable: []string{"plugins"}})
+	require.NoError(t, err)
+	checks, err := m.RegisterCustomMetric(CustomMetricOpts{Plugin: "guard", Name: "checks_total", Help: "Checks", Kind: CustomCounter})
+	require.NoError(t, err)
+	checks.Record(ctx, 1)
+	assert.Equal(t, 1.0, testutil.ToFloat64(checks.counter))
+}
+
+func TestConfigDropsLabels(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	m, err := NewAgentMetricsWithConfig(registry, Config{
+		HistogramOptions: HistogramOptions{Buckets: map[string][]float64{"agent_ttft_ms": {100, 500}}},
+		DropLabels: map[string][]string{
+			"agent_ttft_ms":           {"route"},
+			"agent_turn_errors_total": {"model", "error_type"},
+		},
+	})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	m.RecordTTFT(ctx, 50*time.Millisecond, "llama-3-70b", "/chat")
+	m.RecordTTFT(ctx, 300*time.Millisecond, "llama-3-70b", "/tools")
+	m.RecordTTFT(ctx, 300*time.Millisecond, "mistral-7b", "/chat")
+	m.RecordError(ctx, "timeout", "llama-3-70b")
+	m.RecordError(ctx, "http_5xx", "mistral-7b")
+
+	require.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(`
+# HELP agent_ttft_ms Time to first token in milliseconds
+# TYPE agent_ttft_ms histogram
+agent_ttft_ms_bucket{model="llama-3-70b",le="100"} 1
+agent_ttft_ms_bucket{model="llama-3-70b",le="500"} 2
+agent_ttft_ms_bucket{model="llama-3-70b",le="+Inf"} 2
+agent_ttft_ms_sum{model="llama-3-70b"} 350
+agent_ttft_ms_count{model="llama-3-70b"} 2
+agent_ttft_ms_bucket{model="mistral-7b",le="100"} 0
+agent_ttft_ms_bucket{model="mistral-7b",le="500"} 1
+agent_ttft_ms_bucket{model="mistral-7b",le="+Inf"} 1
+agent_ttft_ms_sum{model="mistral-7b"} 300
+agent_ttft_ms_count{model="mistral-7b"} 1
+# HELP agent_turn_errors_total Total number of turn errors (5xx + aborted)
+# TYPE agent_turn_errors_total counter
+agent_turn_errors_total 2
+`), "agent_ttft_ms", "agent_turn_errors_total"))
+}
+
+func TestConfigValidation(t *testing.T) {
+	_, err := NewAgentMetricsWithConfig(prometheus.NewRegistry(), Config{DropLabels: map[string][]string{"agent_ttft_ms": {"tenant"}}})
+	assert.ErrorContains(t, err, "agent_ttft_ms has no labels tenant")
+	_, err = NewAgentMetricsWithConfig(prometheus.NewRegistry(), Config{DropLabels: map[string][]string{"agent_ttfb_ms": {"route"}}})
+	assert.ErrorContains(t, err, "unknown metric agent_ttfb_ms")
+	_, err = NewAgentMetricsWithConfig(prometheus.NewRegistry(), Config{Disable: []string{"rag_["}})
+	assert.Error(t, err)
+
+	m, err := NewAgentMetricsWithConfig(prometheus.NewRegistry(), Config{DropLabels: map[string][]string{"plugin_guard_checks_total": {"route"}}})
+	require.NoError(t, err)
+	_, err = m.RegisterCustomMetric(CustomMetricOpts{
+		Plugin: "guard", Name: "checks_total", Help: "Checks", Kind: CustomCounter, Labels: []string{"tenant"},
+	})
+	assert.ErrorContains(t, err, "has no labels route")
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`disable: [rag, energy_*]
+dropLabels:
+  agent_latency_ms: [route]
+buckets:
+  agent_ttft_ms: [25, 50, 100]
+nativeHistograms: true
+`), 0o600))
+	config, err := LoadConfig(path)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"rag", "energy_*"}, config.Disable)
+	assert.Equal(t, map[string][]string{"agent_latency_ms": {"route"}}, config.DropLabels)
+	assert.Equal(t, []float64{25, 50, 100}, config.Buckets["agent_ttft_ms"])
+	assert.True(t, config.NativeHistograms)
+
+	require.NoError(t, os.WriteFile(path, []byte("disabled: [rag]\n"), 0o600))
+	_, err = LoadConfig(path)
+	assert.Error(t, err)
+}
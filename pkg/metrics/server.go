@@ -0,0 +1,202 @@
+/*
+Copyright 2024 NeuroNetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+)
+
+const (
+	// DefaultMetricsPath is the path serving the global registry
+	DefaultMetricsPath = "/metrics"
+
+	// TenantLabel is the label used to scope series to a tenant
+	TenantLabel = "tenant"
+)
+
+// Server exposes one or more Prometheus gatherers over HTTP, each on its
+// own path. The global gatherer is served on /metrics; tenant-scoped views
+// are served on /metrics/<tenant>.
+type Server struct {
+	addr   string
+	global prometheus.Gatherer
+
+	mu    sync.RWMutex
+	mux   *http.ServeMux
+	paths map[string]prometheus.Gatherer
+}
+
+// NewServer creates a metrics server bound to addr serving global on /metrics
+func NewServer(addr string, global prometheus.Gatherer) *Server {
+	if global == nil {
+		global = prometheus.DefaultGatherer
+	}
+
+	s := &Server{
+		addr:   addr,
+		global: global,
+		mux:    http.NewServeMux(),
+		paths:  make(map[string]prometheus.Gatherer),
+	}
+	// The global path can never collide on a fresh server
+	_ = s.RegisterGatherer(DefaultMetricsPath, global)
+
+	return s
+}
+
+// RegisterGatherer serves gatherer on path
+func (s *Server) RegisterGatherer(path string, gatherer prometheus.Gatherer) error {
+	if !strings.HasPrefix(path, "/") {
+		return fmt.Errorf("metrics path %q must start with /", path)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.paths[path]; ok {
+		return fmt.Errorf("metrics path %q already registered", path)
+	}
+
+	s.paths[path] = gatherer
+	// Exemplars are only exposed in the OpenMetrics format
+	s.mux.Handle(path, promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{EnableOpenMetrics: true}))
+	return nil
+}
+
+// RegisterTenant serves the global gatherer on /metrics/<tenant>, filtered
+// to series whose tenant label equals tenant
+func (s *Server) RegisterTenant(tenant string) error {
+	if tenant == "" || strings.Contains(tenant, "/") {
+		return fmt.Errorf("invalid tenant name %q", tenant)
+	}
+
+	path := DefaultMetricsPath + "/" + tenant
+	return s.RegisterGatherer(path, NewTenantGatherer(s.global, TenantLabel, tenant))
+}
+
+// Paths returns the registered metrics paths
+func (s *Server) Paths() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	paths := make([]string, 0, len(s.paths))
+	for p := range s.paths {
+		paths = append(paths, p)
+	}
+	return paths
+}
+
+// Handler returns the HTTP handler serving all registered paths
+func (s *Server) Handler() http.Handler {
+	return s.mux
+}
+
+// Start serves metrics until ctx is cancelled
+func (s *Server) Start(ctx context.Context) error {
+	srv := &http.Server{
+		Addr:              s.addr,
+		Handler:           s.mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.ListenAndServe()
+	}()
+
+	select {
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return srv.Shutdown(shutdownCtx)
+	case err := <-errCh:
+		if err == http.ErrServerClosed {
+			return nil
+		}
+		return err
+	}
+}
+
+// FilteringGatherer wraps a gatherer and only returns series carrying
+// label=value for one of the allowed values. Series without the label are
+// dropped, as are families left with no series.
+type FilteringGatherer struct {
+	gatherer prometheus.Gatherer
+	label    string
+	values   map[string]struct{}
+}
+
+// NewTenantGatherer returns a gatherer restricted to the given label values
+func NewTenantGatherer(gatherer prometheus.Gatherer, label string, values ...string) *FilteringGatherer {
+	allowed := make(map[string]struct{}, len(values))
+	for _, v := range values {
+		allowed[v] = struct{}{}
+	}
+	return &FilteringGatherer{
+		gatherer: gatherer,
+		label:    label,
+		values:   allowed,
+	}
+}
+
+// Gather implements prometheus.Gatherer
+func (g *FilteringGatherer) Gather() ([]*dto.MetricFamily, error) {
+	families, err := g.gatherer.Gather()
+	if err != nil {
+		return nil, err
+	}
+
+	filtered := make([]*dto.MetricFamily, 0, len(families))
+	for _, mf := range families {
+		var kept []*dto.Metric
+		for _, m := range mf.GetMetric() {
+			if g.matches(m) {
+				kept = append(kept, m)
+			}
+		}
+		if len(kept) == 0 {
+			continue
+		}
+		filtered = append(filtered, &dto.MetricFamily{
+			Name:   mf.Name,
+			Help:   mf.Help,
+			Type:   mf.Type,
+			Metric: kept,
+		})
+	}
+
+	return filtered, nil
+}
+
+func (g *FilteringGatherer) matches(m *dto.Metric) bool {
+	for _, lp := range m.GetLabel() {
+		if lp.GetName() == g.label {
+			_, ok := g.values[lp.GetValue()]
+			return ok
+		}
+	}
+	return false
+}
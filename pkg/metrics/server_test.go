@@ -0,0 +1,89 @@
+/*
+Copyright 2024 NeuroNetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func scrape(t *testing.T, handler http.Handler, path string) (int, string) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	body, err := io.ReadAll(rec.Body)
+	require.NoError(t, err)
+	return rec.Code, string(body)
+}
+
+func TestServerTenantScopedPaths(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	tokens := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "tenant_tokens_total",
+		Help: "Tokens per tenant",
+	}, []string{"tenant", "model"})
+	untenanted := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "cluster_gpu_count",
+		Help: "GPUs in the cluster",
+	})
+	registry.MustRegister(tokens, untenanted)
+
+	tokens.WithLabelValues("tenant-1", "llama-3-8b").Add(10)
+	tokens.WithLabelValues("tenant-2", "llama-3-70b").Add(20)
+	untenanted.Set(8)
+
+	server := NewServer(":0", registry)
+	require.NoError(t, server.RegisterTenant("tenant-1"))
+	require.NoError(t, server.RegisterTenant("tenant-2"))
+
+	code, global := scrape(t, server.Handler(), "/metrics")
+	require.Equal(t, http.StatusOK, code)
+	assert.Contains(t, global, `tenant="tenant-1"`)
+	assert.Contains(t, global, `tenant="tenant-2"`)
+	assert.Contains(t, global, "cluster_gpu_count")
+
+	code, tenant1 := scrape(t, server.Handler(), "/metrics/tenant-1")
+	require.Equal(t, http.StatusOK, code)
+	assert.Contains(t, tenant1, `tenant_tokens_total{model="llama-3-8b",tenant="tenant-1"} 10`)
+	assert.NotContains(t, tenant1, "tenant-2")
+	assert.NotContains(t, tenant1, "cluster_gpu_count")
+
+	code, tenant2 := scrape(t, server.Handler(), "/metrics/tenant-2")
+	require.Equal(t, http.StatusOK, code)
+	assert.Contains(t, tenant2, `tenant="tenant-2"`)
+	assert.NotContains(t, tenant2, "tenant-1")
+}
+
+func TestServerRegisterValidation(t *testing.T) {
+	server := NewServer(":0", prometheus.NewRegistry())
+
+	assert.Error(t, server.RegisterTenant(""))
+	assert.Error(t, server.RegisterTenant("a/b"))
+	assert.Error(t, server.RegisterGatherer("metrics", prometheus.NewRegistry()))
+	assert.Error(t, server.RegisterGatherer("/metrics", prometheus.NewRegistry()))
+
+	require.NoError(t, server.RegisterTenant("tenant-1"))
+	assert.Error(t, server.RegisterTenant("tenant-1"))
+	assert.ElementsMatch(t, []string{"/metrics", "/metrics/tenant-1"}, server.Paths())
+}
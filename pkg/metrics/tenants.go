@@ -0,0 +1,140 @@
+/*
+Copyright 2024 NeuroNetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// DefaultMaxTenants is the number of tenants a TenantRegistry admits by
+// default
+const DefaultMaxTenants = 50
+
+// TenantRegistry is a Prometheus registry for multi-tenant clusters with a
+// budget of tenants. The first tenants up to the budget keep their name in
+// the tenant label of metrics; later ones are aggregated into
+// OverflowLabelValue, so the series of tenant-labeled metrics grow with the
+// budget rather than with the tenants of the cluster. The registry reports
+// its usage as metrics_tenants, metrics_tenants_max and
+// metrics_tenant_overflows_total.
+type TenantRegistry struct {
+	*prometheus.Registry
+
+	mu      sync.Mutex
+	max     int
+	tenants map[string]struct{}
+
+	admitted  prometheus.Gauge
+	limit     prometheus.Gauge
+	overflows prometheus.Counter
+}
+
+// NewTenantRegistry creates a registry admitting up to maxTenants tenants,
+// or DefaultMaxTenants if maxTenants is zero
+func NewTenantRegistry(maxTenants int) *TenantRegistry {
+	if maxTenants <= 0 {
+		maxTenants = DefaultMaxTenants
+	}
+	r := &TenantRegistry{
+		Registry: prometheus.NewRegistry(),
+		max:      maxTenants,
+		tenants:  make(map[string]struct{}),
+		admitted: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "metrics_tenants",
+			Help: "Tenants with their own value of the tenant label",
+		}),
+		limit: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "metrics_tenants_max",
+			Help: "Tenants admitted before further tenants are recorded as \"other\"",
+		}),
+		overflows: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "metrics_tenant_overflows_total",
+			Help: "Tenant label values recorded as \"other\" because the tenant budget was spent",
+		}),
+	}
+	r.limit.Set(float64(maxTenants))
+	r.MustRegister(r.admitted, r.limit, r.overflows)
+	return r
+}
+
+// Tenant returns the value of the tenant label to record for tenant: its
+// name if it is admitted or the budget has room for it, OverflowLabelValue
+// otherwise, and UnknownLabelValue if it is empty
+func (r *TenantRegistry) Tenant(tenant string) string {
+	if tenant == "" {
+		return UnknownLabelValue
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.tenants[tenant]; ok {
+		return tenant
+	}
+	if len(r.tenants) >= r.max {
+		r.overflows.Inc()
+		return OverflowLabelValue
+	}
+	r.tenants[tenant] = struct{}{}
+	r.admitted.Set(float64(len(r.tenants)))
+	return tenant
+}
+
+// Tenants returns the admitted tenants, sorted
+func (r *TenantRegistry) Tenants() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	tenants := make([]string, 0, len(r.tenants))
+	for tenant := range r.tenants {
+		tenants = append(tenants, tenant)
+	}
+	sort.Strings(tenants)
+	return tenants
+}
+
+// admits reports whether tenant has a view: admitted tenants, and the
+// aggregate of the others
+func (r *TenantRegistry) admits(tenant string) bool {
+	if tenant == OverflowLabelValue {
+		return true
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.tenants[tenant]
+	return ok
+}
+
+// ServeTenants serves the view of each tenant of registry on
+// /metrics/<tenant> as soon as it is admitted, and the aggregate of the
+// tenants past the budget on /metrics/other. Paths registered with
+// RegisterTenant take precedence. It is called once per server.
+func (s *Server) ServeTenants(registry *TenantRegistry) {
+	prefix := DefaultMetricsPath + "/"
+	s.mux.HandleFunc(prefix, func(w http.ResponseWriter, r *http.Request) {
+		tenant := strings.TrimPrefix(r.URL.Path, prefix)
+		if tenant == "" || strings.Contains(tenant, "/") || !registry.admits(tenant) {
+			http.NotFound(w, r)
+			return
+		}
+		gatherer := NewTenantGatherer(registry, TenantLabel, tenant)
+		promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{EnableOpenMetrics: true}).ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,87 @@
+/*
+Copyright 2024 NeuroNetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTenantRegistryBudget(t *testing.T) {
+	registry := NewTenantRegistry(2)
+
+	assert.Equal(t, "tenant-1", registry.Tenant("tenant-1"))
+	assert.Equal(t, "tenant-2", registry.Tenant("tenant-2"))
+	assert.Equal(t, OverflowLabelValue, registry.Tenant("tenant-3"))
+	assert.Equal(t, OverflowLabelValue, registry.Tenant("tenant-4"))
+	assert.Equal(t, "tenant-1", registry.Tenant("tenant-1"))
+	assert.Equal(t, UnknownLabelValue, registry.Tenant(""))
+	assert.Equal(t, []string{"tenant-1", "tenant-2"}, registry.Tenants())
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(registry.admitted))
+	assert.Equal(t, 2.0, testutil.ToFloat64(registry.limit))
+	assert.Equal(t, 2.0, testutil.ToFloat64(registry.overflows))
+
+	assert.Equal(t, float64(DefaultMaxTenants), testutil.ToFloat64(NewTenantRegistry(0).limit))
+}
+
+func TestAgentMetricsTenantRegistry(t *testing.T) {
+	registry := NewTenantRegistry(1)
+	m := NewAgentMetrics(registry)
+	m.SetTenantRegistry(registry)
+	ctx := context.Background()
+
+	m.RecordCost(ctx, 1, 1000, "llama-3-8b", "tenant-1")
+	m.RecordCost(ctx, 2, 1000, "llama-3-8b", "tenant-2")
+	m.RecordCost(ctx, 3, 1000, "llama-3-8b", "tenant-3")
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.CostPer1KTokens.WithLabelValues("llama-3-8b", "tenant-1")))
+	assert.Equal(t, 3.0, testutil.ToFloat64(m.CostPer1KTokens.WithLabelValues("llama-3-8b", OverflowLabelValue)))
+	assert.Equal(t, 2, testutil.CollectAndCount(m.CostPer1KTokens))
+	// The tenant budget replaces the per-label bound of the guard
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.LabelValues.WithLabelValues("model")))
+	assert.Equal(t, 0, testutil.CollectAndCount(m.LabelOverflows))
+
+	server := NewServer(":0", registry)
+	server.ServeTenants(registry)
+
+	code, tenant1 := scrape(t, server.Handler(), "/metrics/tenant-1")
+	require.Equal(t, http.StatusOK, code)
+	assert.Contains(t, tenant1, `cost_usd_per_1k_tokens{model="llama-3-8b",tenant="tenant-1"} 1`)
+	assert.NotContains(t, tenant1, `tenant="other"`)
+
+	code, other := scrape(t, server.Handler(), "/metrics/other")
+	require.Equal(t, http.StatusOK, code)
+	assert.Contains(t, other, `cost_usd_per_1k_tokens{model="llama-3-8b",tenant="other"} 3`)
+	assert.NotContains(t, other, "tenant-1")
+
+	for _, path := range []string{"/metrics/tenant-2", "/metrics/", "/metrics/tenant-1/x"} {
+		code, _ := scrape(t, server.Handler(), path)
+		assert.Equal(t, http.StatusNotFound, code, path)
+	}
+
+	code, global := scrape(t, server.Handler(), "/metrics")
+	require.Equal(t, http.StatusOK, code)
+	assert.Contains(t, global, "metrics_tenants 1")
+	assert.Contains(t, global, "metrics_tenants_max 1")
+	assert.Contains(t, global, "metrics_tenant_overflows_total 2")
+}
@@ -0,0 +1,98 @@
+/*
+Copyright 2024 NeuroNetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package window
+
+import (
+	"sync"
+	"time"
+)
+
+// RollingMean tracks the mean of values over a sliding time window, in
+// buckets like RollingRatio. It is safe for concurrent use.
+type RollingMean struct {
+	mu          sync.Mutex
+	granularity time.Duration
+	buckets     []meanBucket
+	now         func() time.Time
+}
+
+type meanBucket struct {
+	epoch int64
+	sum   float64
+	count uint64
+}
+
+// NewRollingMean creates a RollingMean covering window, using buckets of
+// the given granularity, defaulted as by NewRollingRatio
+func NewRollingMean(window, granularity time.Duration) *RollingMean {
+	if window <= 0 {
+		window = time.Minute
+	}
+	if granularity <= 0 || granularity > window {
+		granularity = window
+	}
+
+	n := int((window + granularity - 1) / granularity)
+	buckets := make([]meanBucket, n)
+	for i := range buckets {
+		buckets[i].epoch = -1
+	}
+
+	return &RollingMean{
+		granularity: granularity,
+		buckets:     buckets,
+		now:         time.Now,
+	}
+}
+
+// Observe records v at the current time
+func (r *RollingMean) Observe(v float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	epoch := r.epoch()
+	b := &r.buckets[epoch%int64(len(r.buckets))]
+	if b.epoch != epoch {
+		*b = meanBucket{epoch: epoch}
+	}
+	b.sum += v
+	b.count++
+}
+
+// Mean returns the mean of the values within the window. It returns 0 when
+// no values have been recorded in the window.
+func (r *RollingMean) Mean() float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var sum float64
+	var count uint64
+	oldest := r.epoch() - int64(len(r.buckets)) + 1
+	for _, b := range r.buckets {
+		if b.epoch >= oldest {
+			sum += b.sum
+			count += b.count
+		}
+	}
+	if count == 0 {
+		return 0
+	}
+	return sum / float64(count)
+}
+
+func (r *RollingMean) epoch() int64 {
+	return r.now().UnixNano() / int64(r.granularity)
+}
@@ -0,0 +1,48 @@
+/*
+Copyright 2024 NeuroNetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package window
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRollingMeanOverMovingWindow(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(1700000000, 0)}
+	r := NewRollingMean(60*time.Second, 10*time.Second)
+	r.now = clock.Now
+	assert.Equal(t, 0.0, r.Mean())
+
+	r.Observe(1)
+	r.Observe(0.5)
+	assert.InDelta(t, 0.75, r.Mean(), 1e-9)
+
+	clock.Advance(30 * time.Second)
+	r.Observe(0)
+	assert.InDelta(t, 0.5, r.Mean(), 1e-9)
+
+	// The first values leave the window
+	clock.Advance(35 * time.Second)
+	assert.Equal(t, 0.0, r.Mean())
+	r.Observe(1)
+	assert.InDelta(t, 0.5, r.Mean(), 1e-9)
+
+	clock.Advance(time.Hour)
+	assert.Equal(t, 0.0, r.Mean())
+}
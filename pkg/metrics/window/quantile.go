@@ -0,0 +1,120 @@
+/*
+Copyright 2024 NeuroNetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package window
+
+import (
+	"sync"
+	"time"
+
+	"github.com/beorn7/perks/quantile"
+)
+
+// DefaultObjectives are the quantiles a RollingQuantile is accurate for,
+// mapped to their absolute error
+var DefaultObjectives = map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.95: 0.005, 0.99: 0.001}
+
+// RollingQuantile estimates quantiles of the values observed over a sliding
+// time window with CKMS streams, in bounded memory. Like Prometheus
+// summaries, it keeps a stream per age bucket, each covering a window
+// starting a bucket later than the previous one; values go into every
+// stream and quantiles are read from the oldest, which covers between
+// (ageBuckets-1)/ageBuckets of the window and the whole window. It is safe
+// for concurrent use.
+type RollingQuantile struct {
+	mu       sync.Mutex
+	interval time.Duration
+	streams  []*quantile.Stream
+	head     int
+	expires  time.Time
+	now      func() time.Time
+}
+
+// NewRollingQuantile creates a RollingQuantile covering window with
+// ageBuckets streams, accurate for objectives or DefaultObjectives if nil.
+// Non-positive arguments default to a minute and a single stream.
+func NewRollingQuantile(window time.Duration, ageBuckets int, objectives map[float64]float64) *RollingQuantile {
+	if window <= 0 {
+		window = time.Minute
+	}
+	if ageBuckets <= 0 {
+		ageBuckets = 1
+	}
+	if objectives == nil {
+		objectives = DefaultObjectives
+	}
+
+	streams := make([]*quantile.Stream, ageBuckets)
+	for i := range streams {
+		streams[i] = quantile.NewTargeted(objectives)
+	}
+	return &RollingQuantile{
+		interval: window / time.Duration(ageBuckets),
+		streams:  streams,
+		now:      time.Now,
+	}
+}
+
+// Observe records v at the current time
+func (r *RollingQuantile) Observe(v float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rotate()
+	for _, s := range r.streams {
+		s.Insert(v)
+	}
+}
+
+// Quantile returns the estimated q-quantile of the values within the
+// window, and false if there are none
+func (r *RollingQuantile) Quantile(q float64) (float64, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rotate()
+	head := r.streams[r.head]
+	if head.Count() == 0 {
+		return 0, false
+	}
+	return head.Query(q), true
+}
+
+// Count returns the number of values within the window
+func (r *RollingQuantile) Count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rotate()
+	return r.streams[r.head].Count()
+}
+
+// rotate resets the streams that have covered the whole window, oldest
+// first. Callers must hold mu.
+func (r *RollingQuantile) rotate() {
+	now := r.now()
+	if r.expires.IsZero() {
+		r.expires = now.Add(r.interval)
+		return
+	}
+	for i := 0; !now.Before(r.expires); i++ {
+		if i == len(r.streams) {
+			// Idle for longer than the window: start over
+			r.expires = now.Add(r.interval)
+			return
+		}
+		r.streams[r.head].Reset()
+		r.head = (r.head + 1) % len(r.streams)
+		r.expires = r.expires.Add(r.interval)
+	}
+}
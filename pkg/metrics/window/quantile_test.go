@@ -0,0 +1,84 @@
+/*
+Copyright 2024 NeuroNetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package window
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestQuantile(window time.Duration, ageBuckets int) (*RollingQuantile, *fakeClock) {
+	clock := &fakeClock{t: time.Unix(1700000000, 0)}
+	r := NewRollingQuantile(window, ageBuckets, nil)
+	r.now = clock.Now
+	return r, clock
+}
+
+func TestRollingQuantileEmpty(t *testing.T) {
+	r, _ := newTestQuantile(time.Minute, 6)
+	_, ok := r.Quantile(0.95)
+	assert.False(t, ok)
+	assert.Equal(t, 0, r.Count())
+}
+
+func TestRollingQuantileAccuracy(t *testing.T) {
+	r, _ := newTestQuantile(time.Minute, 6)
+	rng := rand.New(rand.NewSource(1))
+	for _, i := range rng.Perm(10000) {
+		r.Observe(float64(i + 1))
+	}
+
+	assert.Equal(t, 10000, r.Count())
+	for q, epsilon := range DefaultObjectives {
+		v, ok := r.Quantile(q)
+		assert.True(t, ok)
+		assert.InDelta(t, q*10000, v, epsilon*10000, "q=%g", q)
+	}
+}
+
+func TestRollingQuantileSlidingWindow(t *testing.T) {
+	r, clock := newTestQuantile(60*time.Second, 6)
+
+	// Slow turns first, then fast ones
+	for i := 0; i < 100; i++ {
+		r.Observe(1000)
+	}
+	clock.Advance(30 * time.Second)
+	for i := 0; i < 100; i++ {
+		r.Observe(100)
+	}
+	p95, _ := r.Quantile(0.95)
+	assert.Equal(t, 1000.0, p95)
+
+	// Once the window has passed the slow turns, only the fast ones count
+	clock.Advance(40 * time.Second)
+	p95, ok := r.Quantile(0.95)
+	assert.True(t, ok)
+	assert.Equal(t, 100.0, p95)
+	assert.Equal(t, 100, r.Count())
+
+	// After a whole idle window nothing is left
+	clock.Advance(5 * time.Minute)
+	_, ok = r.Quantile(0.95)
+	assert.False(t, ok)
+	r.Observe(50)
+	p95, _ = r.Quantile(0.95)
+	assert.Equal(t, 50.0, p95)
+}
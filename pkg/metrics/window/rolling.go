@@ -0,0 +1,137 @@
+/*
+Copyright 2024 NeuroNetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package window provides time-windowed counters shared by the rolling
+// ratio metrics (tool success, drop rate, cold-start rate, cache hits, ...),
+// the rolling means of RAG quality scores, and the quantile estimates
+// controllers read in process.
+package window
+
+import (
+	"sync"
+	"time"
+)
+
+// RollingRatio tracks successes and failures over a sliding time window.
+// The window is divided into fixed-size buckets; events older than the
+// window are discarded a bucket at a time. It is safe for concurrent use.
+type RollingRatio struct {
+	mu          sync.Mutex
+	granularity time.Duration
+	buckets     []bucket
+	now         func() time.Time
+}
+
+type bucket struct {
+	// epoch is the bucket index since the Unix epoch this slot holds
+	epoch   int64
+	success uint64
+	failure uint64
+}
+
+// NewRollingRatio creates a RollingRatio covering window, using buckets of
+// the given granularity. A granularity larger than the window, or a
+// non-positive one, collapses to a single bucket spanning the window.
+func NewRollingRatio(window, granularity time.Duration) *RollingRatio {
+	if window <= 0 {
+		window = time.Minute
+	}
+	if granularity <= 0 || granularity > window {
+		granularity = window
+	}
+
+	n := int((window + granularity - 1) / granularity)
+	buckets := make([]bucket, n)
+	for i := range buckets {
+		buckets[i].epoch = -1
+	}
+
+	return &RollingRatio{
+		granularity: granularity,
+		buckets:     buckets,
+		now:         time.Now,
+	}
+}
+
+// RecordSuccess records a successful event at the current time
+func (r *RollingRatio) RecordSuccess() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.current().success++
+}
+
+// RecordFailure records a failed event at the current time
+func (r *RollingRatio) RecordFailure() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.current().failure++
+}
+
+// Record records a success or failure depending on ok
+func (r *RollingRatio) Record(ok bool) {
+	if ok {
+		r.RecordSuccess()
+	} else {
+		r.RecordFailure()
+	}
+}
+
+// Ratio returns successes / (successes + failures) within the window.
+// It returns 0 when no events have been recorded in the window.
+func (r *RollingRatio) Ratio() float64 {
+	success, failure := r.Counts()
+	total := success + failure
+	if total == 0 {
+		return 0
+	}
+	return float64(success) / float64(total)
+}
+
+// Counts returns the number of successes and failures within the window
+func (r *RollingRatio) Counts() (success, failure uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	oldest := r.epoch() - int64(len(r.buckets)) + 1
+	for _, b := range r.buckets {
+		if b.epoch >= oldest {
+			success += b.success
+			failure += b.failure
+		}
+	}
+	return success, failure
+}
+
+// Total returns the number of events within the window
+func (r *RollingRatio) Total() uint64 {
+	success, failure := r.Counts()
+	return success + failure
+}
+
+func (r *RollingRatio) epoch() int64 {
+	return r.now().UnixNano() / int64(r.granularity)
+}
+
+// current returns the bucket for the current time, resetting it if it still
+// holds data from a previous pass around the ring. Callers must hold mu.
+func (r *RollingRatio) current() *bucket {
+	epoch := r.epoch()
+	b := &r.buckets[epoch%int64(len(r.buckets))]
+	if b.epoch != epoch {
+		*b = bucket{epoch: epoch}
+	}
+	return b
+}
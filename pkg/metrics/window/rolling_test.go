@@ -0,0 +1,143 @@
+/*
+Copyright 2024 NeuroNetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package window
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeClock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.t = c.t.Add(d)
+}
+
+func newTestRatio(window, granularity time.Duration) (*RollingRatio, *fakeClock) {
+	clock := &fakeClock{t: time.Unix(1700000000, 0)}
+	r := NewRollingRatio(window, granularity)
+	r.now = clock.Now
+	return r, clock
+}
+
+func TestRollingRatioEmpty(t *testing.T) {
+	r, _ := newTestRatio(time.Minute, 10*time.Second)
+	assert.Equal(t, 0.0, r.Ratio())
+	assert.Equal(t, uint64(0), r.Total())
+}
+
+func TestRollingRatioAccuracyOverMovingWindow(t *testing.T) {
+	r, clock := newTestRatio(60*time.Second, 10*time.Second)
+
+	// t=0s: 3 successes, 1 failure
+	for i := 0; i < 3; i++ {
+		r.RecordSuccess()
+	}
+	r.RecordFailure()
+	assert.InDelta(t, 0.75, r.Ratio(), 1e-9)
+
+	// t=30s: 1 success, 3 failures -> 4/8
+	clock.Advance(30 * time.Second)
+	r.RecordSuccess()
+	for i := 0; i < 3; i++ {
+		r.RecordFailure()
+	}
+	assert.InDelta(t, 0.5, r.Ratio(), 1e-9)
+
+	// t=50s: still inside the window of the first batch
+	clock.Advance(20 * time.Second)
+	s, f := r.Counts()
+	assert.Equal(t, uint64(4), s)
+	assert.Equal(t, uint64(4), f)
+
+	// t=60s: first batch slides out, only the 30s batch remains
+	clock.Advance(10 * time.Second)
+	assert.InDelta(t, 0.25, r.Ratio(), 1e-9)
+
+	// t=70s: 2 successes -> 3/6
+	clock.Advance(10 * time.Second)
+	r.RecordSuccess()
+	r.RecordSuccess()
+	assert.InDelta(t, 0.5, r.Ratio(), 1e-9)
+
+	// t=90s: 30s batch expires, only the 70s batch remains
+	clock.Advance(20 * time.Second)
+	assert.InDelta(t, 1.0, r.Ratio(), 1e-9)
+
+	// t=200s: everything has expired
+	clock.Advance(110 * time.Second)
+	assert.Equal(t, uint64(0), r.Total())
+	assert.Equal(t, 0.0, r.Ratio())
+}
+
+func TestRollingRatioReusesBucketsAcrossRing(t *testing.T) {
+	r, clock := newTestRatio(30*time.Second, 10*time.Second)
+
+	r.RecordFailure()
+	// Advance exactly one full ring so the same slot is reused
+	clock.Advance(30 * time.Second)
+	r.RecordSuccess()
+
+	s, f := r.Counts()
+	assert.Equal(t, uint64(1), s)
+	assert.Equal(t, uint64(0), f)
+}
+
+func TestRollingRatioGranularityDefaults(t *testing.T) {
+	r := NewRollingRatio(time.Minute, 0)
+	assert.Len(t, r.buckets, 1)
+
+	r = NewRollingRatio(time.Minute, 2*time.Minute)
+	assert.Len(t, r.buckets, 1)
+
+	r = NewRollingRatio(time.Minute, 7*time.Second)
+	assert.Len(t, r.buckets, 9)
+}
+
+func TestRollingRatioConcurrent(t *testing.T) {
+	r := NewRollingRatio(time.Minute, time.Second)
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				r.Record(g%2 == 0)
+				_ = r.Ratio()
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	assert.Equal(t, uint64(8000), r.Total())
+	assert.InDelta(t, 0.5, r.Ratio(), 1e-9)
+}
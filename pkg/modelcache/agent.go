@@ -0,0 +1,435 @@
+package modelcache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/downloader"
+	"github.com/bowenislandsong/neuronetes/pkg/metrics"
+)
+
+const (
+	// DefaultSyncInterval is how often an Agent syncs its cache with the
+	// models assigned to its node and reports it
+	DefaultSyncInterval = 30 * time.Second
+
+	// DefaultRetryInterval is how long a failed download waits before it
+	// is retried
+	DefaultRetryInterval = 5 * time.Minute
+
+	// markerFile records in the directory of a model that its weights are
+	// complete
+	markerFile = ".neuronetes-cache.json"
+)
+
+// marker is the content of the marker file of a cached model
+type marker struct {
+	URI      string       `json:"uri"`
+	Revision string       `json:"revision,omitempty"`
+	Source   string       `json:"source,omitempty"`
+	Bytes    int64        `json:"bytes"`
+	CachedAt metav1.Time  `json:"cachedAt"`
+	LastUsed *metav1.Time `json:"lastUsed,omitempty"`
+}
+
+// download is an in-flight download of a model into the cache
+type download struct {
+	uri      string
+	done     int64
+	total    int64
+	result   downloader.Result
+	err      error
+	finished time.Time
+	cancel   context.CancelFunc
+}
+
+// Agent manages the weights of models on the local disk of its node, such
+// as an NVMe drive mounted into its DaemonSet. It downloads the models the
+// cache controller assigns to the node into <Root>/<namespace>/<name>,
+// evicts the models no longer assigned, and reports the cache in the
+// annotations of the node. When the free space of the disk falls below a
+// minimum, it evicts the idle models the CachePolicy of which allows it.
+type Agent struct {
+	client     client.Client
+	node       string
+	root       string
+	downloader *downloader.Downloader
+	interval   time.Duration
+	retry      time.Duration
+
+	// minFreePercent is the free space of the disk below which models are
+	// evicted
+	minFreePercent int
+
+	mu        sync.Mutex
+	downloads map[string]*download
+
+	// evicted are the sizes of the assigned models evicted under disk
+	// pressure, downloaded again once they fit
+	evicted map[string]int64
+
+	// peerAddress is where the agent serves its cache to peers, if it
+	// shares it, peerToken the token peers authenticate with, peers the base
+	// URLs of the peers of each model and shared the models it may share
+	peerAddress string
+	peerToken   string
+	peers       map[string][]string
+	shared      map[string]bool
+	metrics     *metrics.AgentMetrics
+
+	// diskUsage defaults to the capacity and free space of the file system
+	diskUsage func(path string) (capacity, free int64, err error)
+}
+
+// NewAgent creates an agent caching models in root for node, syncing every
+// interval. A zero interval uses DefaultSyncInterval.
+func NewAgent(c client.Client, node, root string, d *downloader.Downloader, interval time.Duration) *Agent {
+	if interval <= 0 {
+		interval = DefaultSyncInterval
+	}
+	return &Agent{
+		client:         c,
+		node:           node,
+		root:           root,
+		downloader:     d,
+		interval:       interval,
+		retry:          DefaultRetryInterval,
+		minFreePercent: DefaultMinFreePercent,
+		downloads:      make(map[string]*download),
+		evicted:        make(map[string]int64),
+		diskUsage:      diskUsage,
+	}
+}
+
+// Sync downloads the models assigned to the node, evicts the others and
+// the idle ones under disk pressure, and publishes the report of the cache
+// once
+func (a *Agent) Sync(ctx context.Context) error {
+	var node corev1.Node
+	if err := a.client.Get(ctx, types.NamespacedName{Name: a.node}, &node); err != nil {
+		return fmt.Errorf("failed to get node %s: %w", a.node, err)
+	}
+
+	if a.peerAddress != "" {
+		if err := a.discoverPeers(ctx); err != nil {
+			return err
+		}
+	}
+
+	capacity, free, err := a.diskUsage(a.root)
+	if err != nil {
+		return fmt.Errorf("failed to get disk usage of %s: %w", a.root, err)
+	}
+	inUse, err := a.modelsInUse(ctx)
+	if err != nil {
+		return err
+	}
+	space := &disk{free: free - a.reserved(), minFree: capacity * int64(a.minFreePercent) / 100}
+	now := time.Now()
+
+	report := Report{Models: make(map[string]ModelReport), PeerAddress: a.peerAddress}
+	assigned := make(map[string]bool)
+	shared := make(map[string]bool)
+	models := make(map[string]*neuronetes.Model)
+	for _, key := range Assignments(&node) {
+		name, ok := ParseKey(key)
+		if !ok {
+			continue
+		}
+		assigned[key] = true
+		var model neuronetes.Model
+		if err := a.client.Get(ctx, name, &model); err != nil {
+			if apierrors.IsNotFound(err) {
+				// The controller unassigns deleted models
+				continue
+			}
+			return fmt.Errorf("failed to get model %s: %w", key, err)
+		}
+		models[key] = &model
+		shared[key] = shareable(&model)
+		report.Models[key] = a.sync(ctx, key, &model, inUse[key], space, now)
+	}
+	a.mu.Lock()
+	a.shared = shared
+	a.mu.Unlock()
+
+	if err := a.evict(ctx, assigned); err != nil {
+		return err
+	}
+	if err := a.relieve(ctx, report, models, inUse, space, now); err != nil {
+		return err
+	}
+	capacity, free, err = a.diskUsage(a.root)
+	if err != nil {
+		return fmt.Errorf("failed to get disk usage of %s: %w", a.root, err)
+	}
+	report.CapacityBytes, report.FreeBytes = capacity, free
+	return a.publish(ctx, &node, report)
+}
+
+// sync downloads model into the cache unless it is cached or evicted and
+// does not fit on the disk, and returns its state. inUse records that a
+// pod on the node serves the model at now.
+func (a *Agent) sync(ctx context.Context, key string, model *neuronetes.Model, inUse bool, space *disk, now time.Time) ModelReport {
+	logger := log.FromContext(ctx).WithValues("model", key)
+	dir := a.path(key)
+
+	m, err := readMarker(dir)
+	if err != nil {
+		logger.Error(err, "failed to read cache marker")
+	}
+	if m != nil && m.URI == model.Spec.WeightsURI {
+		if inUse && (m.LastUsed == nil || now.Sub(m.LastUsed.Time) >= lastUsedResolution) {
+			lastUsed := metav1.NewTime(now)
+			m.LastUsed = &lastUsed
+			if err := writeMarker(dir, m); err != nil {
+				logger.Error(err, "failed to record use of model")
+			}
+		}
+		cachedAt := m.CachedAt
+		return ModelReport{State: StateReady, Bytes: m.Bytes, ProgressPercent: 100, Revision: m.Revision, Source: m.Source, CachedAt: &cachedAt, LastUsed: m.LastUsed}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	d, ok := a.downloads[key]
+	if ok && d.uri != model.Spec.WeightsURI {
+		d.cancel()
+		delete(a.downloads, key)
+		ok = false
+	}
+	if !ok {
+		if bytes, evicted := a.evicted[key]; evicted {
+			// Downloading it again right away would evict another model
+			if !space.fits(bytes) {
+				return ModelReport{State: StateEvicted, Bytes: bytes, Error: evictedError}
+			}
+			delete(a.evicted, key)
+			space.free -= bytes
+		}
+		if m != nil {
+			// The weights URI changed, so the cached files are stale
+			if err := os.RemoveAll(dir); err != nil {
+				return ModelReport{State: StateFailed, Error: err.Error()}
+			}
+		}
+		req, err := DownloadRequest(ctx, a.client, model, dir)
+		if err != nil {
+			return ModelReport{State: StateFailed, Error: err.Error()}
+		}
+		if a.peerAddress != "" && shareable(model) {
+			req.Peers = a.downloadPeers(key)
+		}
+		d = a.start(key, req)
+		logger.Info("Caching model", "uri", req.URI)
+	}
+
+	switch {
+	case d.finished.IsZero():
+		return ModelReport{State: StateLoading, Bytes: d.total, ProgressPercent: percent(d.done, d.total)}
+	case d.err != nil:
+		if time.Since(d.finished) >= a.retry {
+			delete(a.downloads, key)
+		}
+		return ModelReport{State: StateFailed, Error: d.err.Error()}
+	}
+
+	delete(a.downloads, key)
+	m = &marker{URI: d.uri, Revision: d.result.Revision, Source: d.result.Source, Bytes: d.result.Size, CachedAt: metav1.NewTime(now)}
+	if inUse {
+		m.LastUsed = &m.CachedAt
+	}
+	if err := writeMarker(dir, m); err != nil {
+		return ModelReport{State: StateFailed, Error: err.Error()}
+	}
+	logger.Info("Cached model", "bytes", m.Bytes, "revision", m.Revision, "source", m.Source, "fromPeers", d.result.FromPeers)
+	return ModelReport{State: StateReady, Bytes: m.Bytes, ProgressPercent: 100, Revision: m.Revision, Source: m.Source, CachedAt: &m.CachedAt, LastUsed: m.LastUsed}
+}
+
+// start downloads req in the background. a.mu must be held.
+func (a *Agent) start(key string, req downloader.Request) *download {
+	// Downloads outlive a single sync, so they are not tied to its context
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &download{uri: req.URI, cancel: cancel}
+	a.downloads[key] = d
+
+	go func() {
+		result, err := a.downloader.Download(ctx, req, func(done, total int64) {
+			a.mu.Lock()
+			defer a.mu.Unlock()
+			d.done, d.total = done, total
+		})
+
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		d.result, d.err, d.finished = result, err, time.Now()
+	}()
+	return d
+}
+
+// evict cancels the downloads of models no longer assigned and removes
+// their weights from the cache
+func (a *Agent) evict(ctx context.Context, assigned map[string]bool) error {
+	a.mu.Lock()
+	for key, d := range a.downloads {
+		if !assigned[key] {
+			d.cancel()
+			delete(a.downloads, key)
+		}
+	}
+	for key := range a.evicted {
+		if !assigned[key] {
+			delete(a.evicted, key)
+		}
+	}
+	a.mu.Unlock()
+
+	namespaces, err := os.ReadDir(a.root)
+	if err != nil {
+		return fmt.Errorf("failed to read model cache %s: %w", a.root, err)
+	}
+	for _, namespace := range namespaces {
+		if !namespace.IsDir() {
+			continue
+		}
+		models, err := os.ReadDir(filepath.Join(a.root, namespace.Name()))
+		if err != nil {
+			return fmt.Errorf("failed to read model cache %s: %w", a.root, err)
+		}
+		for _, model := range models {
+			key := namespace.Name() + "/" + model.Name()
+			if !model.IsDir() || assigned[key] {
+				continue
+			}
+			if err := os.RemoveAll(a.path(key)); err != nil {
+				return fmt.Errorf("failed to evict model %s: %w", key, err)
+			}
+			log.FromContext(ctx).Info("Evicted model", "model", key)
+		}
+	}
+	return nil
+}
+
+// publish annotates the node with report if it changed
+func (a *Agent) publish(ctx context.Context, node *corev1.Node, report Report) error {
+	data, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	var ready []string
+	for key, model := range report.Models {
+		if model.State == StateReady {
+			ready = append(ready, key)
+		}
+	}
+	sort.Strings(ready)
+
+	annotations := map[string]string{
+		AnnotationReport:       string(data),
+		AnnotationCachedModels: JoinKeys(ready),
+	}
+	changed := false
+	for name, value := range annotations {
+		if node.Annotations[name] != value {
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{"annotations": annotations},
+	})
+	if err != nil {
+		return err
+	}
+	if err := a.client.Patch(ctx, node, client.RawPatch(types.MergePatchType, patch)); err != nil {
+		return fmt.Errorf("failed to annotate node %s: %w", a.node, err)
+	}
+	return nil
+}
+
+// Start syncs every interval until ctx is done, then cancels the
+// downloads. Failures are retried on the next tick.
+func (a *Agent) Start(ctx context.Context) error {
+	logger := log.FromContext(ctx).WithValues("node", a.node)
+	if err := os.MkdirAll(a.root, 0o755); err != nil {
+		return fmt.Errorf("failed to create model cache %s: %w", a.root, err)
+	}
+	defer func() {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		for _, d := range a.downloads {
+			d.cancel()
+		}
+	}()
+
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+	for {
+		if err := a.Sync(ctx); err != nil {
+			logger.Error(err, "failed to sync model cache")
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// path returns the directory of the weights of the model key
+func (a *Agent) path(key string) string {
+	return filepath.Join(a.root, filepath.FromSlash(key))
+}
+
+// readMarker returns the marker of the model in dir, or nil if its weights
+// are not complete
+func readMarker(dir string) (*marker, error) {
+	data, err := os.ReadFile(filepath.Join(dir, markerFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var m marker
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("invalid cache marker in %s: %w", dir, err)
+	}
+	return &m, nil
+}
+
+// writeMarker records that the weights in dir are complete
+func writeMarker(dir string, m *marker) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, markerFile), data, 0o644)
+}
+
+// percent returns done as a percentage of total
+func percent(done, total int64) int32 {
+	if total <= 0 {
+		return 0
+	}
+	return int32(done * 100 / total)
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package modelcache
+
+import "errors"
+
+// diskUsage is not supported off Unix, where the agent does not run
+func diskUsage(path string) (int64, int64, error) {
+	return 0, 0, errors.New("disk usage is only supported on Unix")
+}
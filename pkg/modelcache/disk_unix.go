@@ -0,0 +1,15 @@
+//go:build unix
+
+package modelcache
+
+import "syscall"
+
+// diskUsage returns the capacity and free space in bytes of the file
+// system holding path
+func diskUsage(path string) (int64, int64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, 0, err
+	}
+	return int64(stat.Blocks) * int64(stat.Bsize), int64(stat.Bavail) * int64(stat.Bsize), nil
+}
@@ -0,0 +1,173 @@
+package modelcache
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+)
+
+const (
+	// DefaultMinFreePercent is the free space of the disk of the cache, as
+	// a percentage of its capacity, below which an Agent evicts models
+	DefaultMinFreePercent = 10
+
+	// lastUsedResolution is how often the last use of a model in use is
+	// recorded
+	lastUsedResolution = time.Minute
+
+	// evictedError is the error of models evicted under disk pressure
+	evictedError = "evicted under disk pressure"
+)
+
+// priorities rank the priorities of CachePolicy, lowest evicted first
+var priorities = map[string]int{"low": 0, "medium": 1, "high": 2, "critical": 3}
+
+// disk is the space of the cache during a sync
+type disk struct {
+	// free is the free space less the space downloads still need
+	free    int64
+	minFree int64
+}
+
+// fits returns true if bytes more fit on the disk without pressure
+func (d *disk) fits(bytes int64) bool {
+	return d.free-bytes >= d.minFree
+}
+
+// SetMinFreePercent sets the free space of the disk of the cache, as a
+// percentage of its capacity, below which models are evicted. It must be
+// called before Start.
+func (a *Agent) SetMinFreePercent(percent int) {
+	a.minFreePercent = percent
+}
+
+// Pinned returns true if policy keeps a model cached since cachedAt in the
+// cache, with how long it stays pinned if that is limited
+func Pinned(policy *neuronetes.CachePolicy, cachedAt *metav1.Time, now time.Time) (bool, time.Duration) {
+	if policy == nil {
+		return false, 0
+	}
+	if policy.EvictionPolicy == "never" {
+		return true, 0
+	}
+	if policy.PinDuration == nil || cachedAt == nil {
+		return false, 0
+	}
+	if left := cachedAt.Add(policy.PinDuration.Duration).Sub(now); left > 0 {
+		return true, left
+	}
+	return false, 0
+}
+
+// modelsInUse returns the keys of the models served by the pods on the node
+func (a *Agent) modelsInUse(ctx context.Context) (map[string]bool, error) {
+	var pods corev1.PodList
+	if err := a.client.List(ctx, &pods, client.MatchingFields{"spec.nodeName": a.node}, client.HasLabels{neuronetes.LabelModel}); err != nil {
+		return nil, fmt.Errorf("failed to list pods of node %s: %w", a.node, err)
+	}
+	inUse := make(map[string]bool)
+	for _, pod := range pods.Items {
+		if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		inUse[Key(types.NamespacedName{Namespace: pod.Namespace, Name: pod.Labels[neuronetes.LabelModel]})] = true
+	}
+	return inUse, nil
+}
+
+// reserved returns the bytes the downloads in flight still need
+func (a *Agent) reserved() int64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var bytes int64
+	for _, d := range a.downloads {
+		if d.finished.IsZero() && d.total > d.done {
+			bytes += d.total - d.done
+		}
+	}
+	return bytes
+}
+
+// candidate is a cached model the agent may evict
+type candidate struct {
+	key         string
+	bytes       int64
+	lowPriority bool
+	priority    int
+	lastUsed    time.Time
+}
+
+// relieve evicts ready models from the cache while the disk is under
+// pressure: models with the low-priority eviction policy first, then by
+// priority, then the least recently used. Models in use, pinned or with
+// the never eviction policy are kept.
+func (a *Agent) relieve(ctx context.Context, report Report, models map[string]*neuronetes.Model, inUse map[string]bool, d *disk, now time.Time) error {
+	if d.fits(0) {
+		return nil
+	}
+	var candidates []candidate
+	for key, entry := range report.Models {
+		if entry.State != StateReady || inUse[key] {
+			continue
+		}
+		policy := models[key].Spec.CachePolicy
+		if pinned, _ := Pinned(policy, entry.CachedAt, now); pinned {
+			continue
+		}
+		c := candidate{key: key, bytes: entry.Bytes}
+		if policy != nil {
+			c.lowPriority = policy.EvictionPolicy == "low-priority"
+			c.priority = priorities[policy.Priority]
+		}
+		// Models never used since cached are as idle as their cache
+		if entry.LastUsed != nil {
+			c.lastUsed = entry.LastUsed.Time
+		} else if entry.CachedAt != nil {
+			c.lastUsed = entry.CachedAt.Time
+		}
+		candidates = append(candidates, c)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		x, y := candidates[i], candidates[j]
+		if x.lowPriority != y.lowPriority {
+			return x.lowPriority
+		}
+		if x.priority != y.priority {
+			return x.priority < y.priority
+		}
+		if !x.lastUsed.Equal(y.lastUsed) {
+			return x.lastUsed.Before(y.lastUsed)
+		}
+		return x.key < y.key
+	})
+
+	logger := log.FromContext(ctx)
+	for _, c := range candidates {
+		if d.fits(0) {
+			return nil
+		}
+		if err := os.RemoveAll(a.path(c.key)); err != nil {
+			return fmt.Errorf("failed to evict model %s: %w", c.key, err)
+		}
+		a.mu.Lock()
+		a.evicted[c.key] = c.bytes
+		a.mu.Unlock()
+		d.free += c.bytes
+		report.Models[c.key] = ModelReport{State: StateEvicted, Bytes: c.bytes, LastUsed: report.Models[c.key].LastUsed, Error: evictedError}
+		logger.Info("Evicted model under disk pressure", "model", c.key, "bytes", c.bytes)
+	}
+	if !d.fits(0) {
+		logger.Info("Disk of the model cache stays under pressure, no model can be evicted", "free", d.free, "minFree", d.minFree)
+	}
+	return nil
+}
@@ -0,0 +1,18 @@
+//go:build !unix
+
+package modelcache
+
+import (
+	"errors"
+	"os"
+)
+
+// lockFile is not supported off Unix, where the agent does not run
+func lockFile(path string, nonblocking bool) (*os.File, bool, error) {
+	return nil, false, errors.New("file locking is only supported on Unix")
+}
+
+// unlockFile is not supported off Unix, where the agent does not run
+func unlockFile(f *os.File) error {
+	return errors.New("file locking is only supported on Unix")
+}
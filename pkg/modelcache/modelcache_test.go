@@ -0,0 +1,507 @@
+package modelcache
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/downloader"
+	"github.com/bowenislandsong/neuronetes/pkg/metrics"
+)
+
+func newFakeClient(t *testing.T, objs ...client.Object) client.Client {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	require.NoError(t, neuronetes.AddToScheme(scheme))
+	// The API server selects pods by node natively
+	return fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).
+		WithIndex(&corev1.Pod{}, "spec.nodeName", func(obj client.Object) []string {
+			return []string{obj.(*corev1.Pod).Spec.NodeName}
+		}).
+		Build()
+}
+
+func newModel(name, uri string) *neuronetes.Model {
+	return &neuronetes.Model{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec:       neuronetes.ModelSpec{WeightsURI: uri, Size: resource.MustParse("1Gi")},
+	}
+}
+
+func TestAgentCachesAssignedModels(t *testing.T) {
+	weights := bytes.Repeat([]byte("weights"), 100)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "model.gguf", time.Time{}, bytes.NewReader(weights))
+	}))
+	defer server.Close()
+
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{
+		Name:        "gpu-node-1",
+		Annotations: map[string]string{AnnotationAssignments: "default/llama,default/missing"},
+	}}
+	c := newFakeClient(t, node, newModel("llama", server.URL+"/model.gguf"))
+	root := t.TempDir()
+	// A model cached before, no longer assigned
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "default", "mistral"), 0o755))
+
+	agent := NewAgent(c, "gpu-node-1", root, downloader.New(downloader.Options{}), 0)
+	agent.diskUsage = func(string) (int64, int64, error) { return 1000, 400, nil }
+
+	var report Report
+	require.Eventually(t, func() bool {
+		require.NoError(t, agent.Sync(context.Background()))
+		require.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "gpu-node-1"}, node))
+		var ok bool
+		var err error
+		report, ok, err = ParseReport(node)
+		require.NoError(t, err)
+		require.True(t, ok)
+		return report.Models["default/llama"].State == StateReady
+	}, 5*time.Second, 5*time.Millisecond)
+
+	llama := report.Models["default/llama"]
+	assert.Equal(t, int64(700), llama.Bytes)
+	assert.Equal(t, int32(100), llama.ProgressPercent)
+	assert.NotNil(t, llama.CachedAt)
+	assert.NotContains(t, report.Models, "default/missing")
+	assert.Equal(t, int64(1000), report.CapacityBytes)
+	assert.Equal(t, int64(400), report.FreeBytes)
+	assert.Equal(t, "default/llama", node.Annotations[AnnotationCachedModels])
+	data, err := os.ReadFile(filepath.Join(root, "default", "llama", "model.gguf"))
+	require.NoError(t, err)
+	assert.Equal(t, weights, data)
+	assert.NoDirExists(t, filepath.Join(root, "default", "mistral"))
+
+	// Cached models are ready without downloading them again, as after
+	// the agent restarts
+	server.Close()
+	restarted := NewAgent(c, "gpu-node-1", root, downloader.New(downloader.Options{}), 0)
+	restarted.diskUsage = agent.diskUsage
+	require.NoError(t, restarted.Sync(context.Background()))
+	require.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "gpu-node-1"}, node))
+	report, _, err = ParseReport(node)
+	require.NoError(t, err)
+	assert.Equal(t, StateReady, report.Models["default/llama"].State)
+
+	// Unassigned models are evicted
+	node.Annotations[AnnotationAssignments] = ""
+	require.NoError(t, c.Update(context.Background(), node))
+	require.NoError(t, restarted.Sync(context.Background()))
+	assert.NoDirExists(t, filepath.Join(root, "default", "llama"))
+	require.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "gpu-node-1"}, node))
+	assert.Empty(t, node.Annotations[AnnotationCachedModels])
+}
+
+func TestAgentReportsFailedDownloads(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{
+		Name:        "gpu-node-1",
+		Annotations: map[string]string{AnnotationAssignments: "default/llama"},
+	}}
+	model := newModel("llama", server.URL+"/model.gguf")
+	model.Spec.CredentialsSecretRef = &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "token"}, Key: "token"}
+	c := newFakeClient(t, node, model)
+	agent := NewAgent(c, "gpu-node-1", t.TempDir(), downloader.New(downloader.Options{Retries: -1}), 0)
+	agent.diskUsage = func(string) (int64, int64, error) { return 1000, 400, nil }
+
+	report := func() ModelReport {
+		require.NoError(t, agent.Sync(context.Background()))
+		require.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "gpu-node-1"}, node))
+		report, _, err := ParseReport(node)
+		require.NoError(t, err)
+		return report.Models["default/llama"]
+	}
+	got := report()
+	assert.Equal(t, StateFailed, got.State)
+	assert.Contains(t, got.Error, "failed to get credentials secret token")
+
+	require.NoError(t, c.Create(context.Background(), &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "token", Namespace: "default"},
+		Data:       map[string][]byte{"token": []byte("secret")},
+	}))
+	require.Eventually(t, func() bool {
+		got = report()
+		return got.State == StateFailed && got.Error != ""
+	}, 5*time.Second, 5*time.Millisecond)
+	assert.Contains(t, got.Error, "unexpected status 404")
+}
+
+func TestAgentSharesCacheWithPeers(t *testing.T) {
+	weights := bytes.Repeat([]byte("weights"), 100)
+	var mu sync.Mutex
+	originReads := 0
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			mu.Lock()
+			originReads++
+			mu.Unlock()
+		}
+		http.ServeContent(w, r, "model.gguf", time.Time{}, bytes.NewReader(weights))
+	}))
+	defer origin.Close()
+
+	assigned := map[string]string{AnnotationAssignments: "default/llama"}
+	c := newFakeClient(t,
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "gpu-node-1", Annotations: assigned}},
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "gpu-node-2", Annotations: assigned}},
+		newModel("llama", origin.URL+"/model.gguf"),
+	)
+	newPeer := func(node string) (*Agent, *metrics.AgentMetrics) {
+		m := metrics.NewAgentMetrics(prometheus.NewRegistry())
+		agent := NewAgent(c, node, t.TempDir(), downloader.New(downloader.Options{ChunkSize: 100, Shuffle: true}), 0)
+		agent.diskUsage = func(string) (int64, int64, error) { return 1000, 400, nil }
+		server := httptest.NewServer(agent.PeerHandler())
+		t.Cleanup(server.Close)
+		agent.EnablePeers(strings.TrimPrefix(server.URL, "http://"), "peer-token", m)
+		return agent, m
+	}
+	syncUntilReady := func(agent *Agent, node string) Report {
+		var report Report
+		require.Eventually(t, func() bool {
+			require.NoError(t, agent.Sync(context.Background()))
+			var n corev1.Node
+			require.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: node}, &n))
+			var err error
+			report, _, err = ParseReport(&n)
+			require.NoError(t, err)
+			return report.Models["default/llama"].State == StateReady
+		}, 5*time.Second, 5*time.Millisecond)
+		return report
+	}
+
+	first, firstMetrics := newPeer("gpu-node-1")
+	report := syncUntilReady(first, "gpu-node-1")
+	assert.NotEmpty(t, report.PeerAddress)
+	assert.Equal(t, float64(700), testutil.ToFloat64(firstMetrics.ModelDistributionBytes.WithLabelValues("default/llama", "origin")))
+	mu.Lock()
+	reads := originReads
+	mu.Unlock()
+	assert.Equal(t, 7, reads)
+
+	second, secondMetrics := newPeer("gpu-node-2")
+	syncUntilReady(second, "gpu-node-2")
+	mu.Lock()
+	assert.Equal(t, reads, originReads, "the second node reads the weights from the first")
+	mu.Unlock()
+	assert.Equal(t, float64(700), testutil.ToFloat64(secondMetrics.ModelDistributionBytes.WithLabelValues("default/llama", "peer")))
+	assert.Equal(t, float64(7), testutil.ToFloat64(secondMetrics.ModelPeerRequests.WithLabelValues("default/llama", "hit")))
+	assert.Equal(t, float64(700), testutil.ToFloat64(firstMetrics.ModelPeerServedBytes.WithLabelValues("default/llama")))
+
+	// Models not in the cache are not served
+	get := func(model, token string) int {
+		req, err := http.NewRequest(http.MethodGet, "http://"+report.PeerAddress+"/v1/models/default/"+model+"/files/model.gguf?size=700&uri="+url.QueryEscape(origin.URL+"/model.gguf"), nil)
+		require.NoError(t, err)
+		req.Header.Set("Range", "bytes=0-99")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	assert.Equal(t, http.StatusNotFound, get("mistral", "peer-token"))
+
+	// Only agents presenting the token of the peers are served
+	assert.Equal(t, http.StatusUnauthorized, get("llama", ""))
+	assert.Equal(t, http.StatusUnauthorized, get("llama", "other-token"))
+	assert.Equal(t, http.StatusPartialContent, get("llama", "peer-token"))
+
+	// Weights read with credentials are neither served to nor read from
+	// peers
+	require.NoError(t, c.Create(context.Background(), &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "token", Namespace: "default"},
+		Data:       map[string][]byte{"token": []byte("secret")},
+	}))
+	var model neuronetes.Model
+	require.NoError(t, c.Get(context.Background(), types.NamespacedName{Namespace: "default", Name: "llama"}, &model))
+	model.Spec.CredentialsSecretRef = &corev1.SecretKeySelector{
+		LocalObjectReference: corev1.LocalObjectReference{Name: "token"},
+		Key:                  "token",
+	}
+	require.NoError(t, c.Update(context.Background(), &model))
+	require.NoError(t, first.Sync(context.Background()))
+	assert.Equal(t, http.StatusNotFound, get("llama", "peer-token"))
+	assert.False(t, shareable(&model))
+}
+
+func TestAgentEvictsIdleModelsUnderDiskPressure(t *testing.T) {
+	weights := bytes.Repeat([]byte("w"), 100)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "model.gguf", time.Time{}, bytes.NewReader(weights))
+	}))
+	defer server.Close()
+
+	now := time.Now()
+	ago := func(d time.Duration) *metav1.Time {
+		t := metav1.NewTime(now.Add(-d))
+		return &t
+	}
+	root := t.TempDir()
+	var models []client.Object
+	var keys []string
+	for name, tc := range map[string]struct {
+		policy   neuronetes.CachePolicy
+		lastUsed *metav1.Time
+	}{
+		"low-idle":      {neuronetes.CachePolicy{Priority: "low"}, ago(time.Hour)},
+		"medium-old":    {neuronetes.CachePolicy{Priority: "medium"}, ago(2 * time.Hour)},
+		"medium-recent": {neuronetes.CachePolicy{Priority: "medium"}, ago(time.Minute)},
+		"critical":      {neuronetes.CachePolicy{Priority: "critical", EvictionPolicy: "low-priority"}, nil},
+		"in-use":        {neuronetes.CachePolicy{Priority: "low"}, nil},
+		"pinned":        {neuronetes.CachePolicy{Priority: "low", PinDuration: &metav1.Duration{Duration: 4 * time.Hour}}, nil},
+		"never":         {neuronetes.CachePolicy{Priority: "low", EvictionPolicy: "never"}, nil},
+	} {
+		model := newModel(name, server.URL+"/"+name+"/model.gguf")
+		policy := tc.policy
+		model.Spec.CachePolicy = &policy
+		models = append(models, model)
+		keys = append(keys, "default/"+name)
+
+		dir := filepath.Join(root, "default", name)
+		require.NoError(t, os.MkdirAll(dir, 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "model.gguf"), weights, 0o644))
+		require.NoError(t, writeMarker(dir, &marker{URI: model.Spec.WeightsURI, Bytes: 100, CachedAt: *ago(3 * time.Hour), LastUsed: tc.lastUsed}))
+	}
+	models = append(models,
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "gpu-node-1", Annotations: map[string]string{AnnotationAssignments: JoinKeys(keys)}}},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "in-use-0", Namespace: "default", Labels: map[string]string{neuronetes.LabelModel: "in-use"}},
+			Spec:       corev1.PodSpec{NodeName: "gpu-node-1"},
+			Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+		},
+	)
+	c := newFakeClient(t, models...)
+
+	// The disk holds 1000 bytes, of which the cache takes 700
+	var capacity int64 = 1000
+	agent := NewAgent(c, "gpu-node-1", root, downloader.New(downloader.Options{}), 0)
+	agent.SetMinFreePercent(50)
+	agent.diskUsage = func(path string) (int64, int64, error) {
+		var used int64
+		err := filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+			if err == nil && info.Mode().IsRegular() && info.Name() != markerFile {
+				used += info.Size()
+			}
+			return err
+		})
+		return capacity, capacity - used, err
+	}
+	sync := func() Report {
+		require.NoError(t, agent.Sync(context.Background()))
+		var node corev1.Node
+		require.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "gpu-node-1"}, &node))
+		report, _, err := ParseReport(&node)
+		require.NoError(t, err)
+		return report
+	}
+
+	report := sync()
+	states := map[string]string{}
+	for key, model := range report.Models {
+		states[key] = model.State
+	}
+	assert.Equal(t, map[string]string{
+		"default/critical":      StateEvicted,
+		"default/low-idle":      StateEvicted,
+		"default/medium-old":    StateReady,
+		"default/medium-recent": StateReady,
+		"default/in-use":        StateReady,
+		"default/pinned":        StateReady,
+		"default/never":         StateReady,
+	}, states, "low-priority policies go first, then low priorities")
+	assert.Equal(t, int64(500), report.FreeBytes)
+	assert.Equal(t, "evicted under disk pressure", report.Models["default/low-idle"].Error)
+	assert.NoDirExists(t, filepath.Join(root, "default", "low-idle"))
+	require.NotNil(t, report.Models["default/in-use"].LastUsed)
+	assert.WithinDuration(t, now, report.Models["default/in-use"].LastUsed.Time, time.Minute)
+
+	// Evicted models stay evicted while they do not fit
+	report = sync()
+	assert.Equal(t, StateEvicted, report.Models["default/low-idle"].State)
+
+	capacity = 2000
+	require.Eventually(t, func() bool {
+		report = sync()
+		return report.Models["default/low-idle"].State == StateReady && report.Models["default/critical"].State == StateReady
+	}, 5*time.Second, 5*time.Millisecond)
+}
+
+func TestDownloadRequestReadsImagePullSecrets(t *testing.T) {
+	config := []byte(`{"auths":{"ghcr.io":{"auth":"cm9ib3Q6c2VjcmV0"}}}`)
+	c := newFakeClient(t,
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "registry", Namespace: "default"},
+			Type:       corev1.SecretTypeDockerConfigJson,
+			Data:       map[string][]byte{corev1.DockerConfigJsonKey: config},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "opaque", Namespace: "default"},
+			Data:       map[string][]byte{"token": []byte("secret")},
+		},
+	)
+	model := newModel("llama", "oci://ghcr.io/acme/llama3:8b")
+
+	model.Spec.ImagePullSecrets = []corev1.LocalObjectReference{{Name: "registry"}}
+	req, err := DownloadRequest(context.Background(), c, model, "/cache")
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{config}, req.DockerConfigs)
+
+	model.Spec.ImagePullSecrets = []corev1.LocalObjectReference{{Name: "opaque"}}
+	_, err = DownloadRequest(context.Background(), c, model, "/cache")
+	assert.ErrorContains(t, err, "has no .dockerconfigjson or .dockercfg key")
+	model.Spec.ImagePullSecrets = []corev1.LocalObjectReference{{Name: "missing"}}
+	_, err = DownloadRequest(context.Background(), c, model, "/cache")
+	assert.ErrorContains(t, err, "failed to get image pull secret missing")
+}
+
+func TestDownloadRequestIncludesMirrors(t *testing.T) {
+	c := newFakeClient(t, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "mirror", Namespace: "default"},
+		Data:       map[string][]byte{"token": []byte("secret")},
+	})
+	model := newModel("llama", "https://weights.example.com/llama.gguf")
+	model.Spec.Mirrors = []neuronetes.WeightsMirror{
+		{URI: "https://eu.example.com/llama.gguf", Priority: 10},
+		{
+			URI:                  "s3://fallback/llama.gguf",
+			Priority:             -1,
+			CredentialsSecretRef: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "mirror"}, Key: "token"},
+		},
+	}
+
+	req, err := DownloadRequest(context.Background(), c, model, "/cache")
+	require.NoError(t, err)
+	assert.Equal(t, "https://weights.example.com/llama.gguf", req.URI)
+	assert.Equal(t, []downloader.Mirror{
+		{URI: "https://eu.example.com/llama.gguf", Priority: 10},
+		{URI: "s3://fallback/llama.gguf", Priority: -1, Token: "secret"},
+	}, req.Mirrors)
+
+	model.Spec.Mirrors[1].CredentialsSecretRef.Name = "missing"
+	_, err = DownloadRequest(context.Background(), c, model, "/cache")
+	assert.Error(t, err)
+}
+
+func TestPopulateSharesVersionsOfVolume(t *testing.T) {
+	weights := bytes.Repeat([]byte("weights"), 100)
+	var mu sync.Mutex
+	downloads := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			mu.Lock()
+			downloads++
+			mu.Unlock()
+		}
+		http.ServeContent(w, r, "model.gguf", time.Time{}, bytes.NewReader(weights))
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	root := t.TempDir()
+	model := newModel("llama", server.URL+"/model.gguf")
+	version := VolumeVersion(model)
+	req := downloader.Request{URI: model.Spec.WeightsURI, Dir: filepath.Join(root, version)}
+	d := downloader.New(downloader.Options{})
+
+	// Concurrent populators of a version download it once
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, err := Populate(ctx, d, req, 0)
+			assert.NoError(t, err)
+			assert.Equal(t, int64(len(weights)), result.Size)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 1, downloads)
+	data, err := os.ReadFile(filepath.Join(root, version, "model.gguf"))
+	require.NoError(t, err)
+	assert.Equal(t, weights, data)
+
+	// Older versions beyond the kept ones are pruned, unless being
+	// populated
+	now := time.Now()
+	for name, cachedAt := range map[string]time.Time{"old": now.Add(-2 * time.Hour), "previous": now.Add(-time.Hour)} {
+		dir := filepath.Join(root, name)
+		require.NoError(t, os.MkdirAll(dir, 0o755))
+		require.NoError(t, writeMarker(dir, &marker{URI: "s3://models/" + name, CachedAt: metav1.NewTime(cachedAt)}))
+	}
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "partial"), 0o755))
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "busy"), 0o755))
+	busy, ok, err := lockFile(filepath.Join(root, "busy")+lockSuffix, true)
+	require.NoError(t, err)
+	require.True(t, ok)
+	defer unlockFile(busy)
+
+	_, err = Populate(ctx, d, req, 2)
+	require.NoError(t, err)
+	assert.Equal(t, 1, downloads)
+	for name, kept := range map[string]bool{version: true, "previous": true, "busy": true, "old": false, "partial": false} {
+		_, err := os.Stat(filepath.Join(root, name))
+		assert.Equal(t, kept, err == nil, name)
+	}
+
+	// New weights are populated into a new version
+	model.Spec.WeightsURI = server.URL + "/model.gguf?rev=2"
+	assert.NotEqual(t, version, VolumeVersion(model))
+}
+
+func TestPopulateFailsOnDigestMismatch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "model.gguf", time.Time{}, strings.NewReader("tampered"))
+	}))
+	defer server.Close()
+
+	dir := filepath.Join(t.TempDir(), "v1")
+	req := downloader.Request{
+		URI:       server.URL + "/model.gguf",
+		Dir:       dir,
+		Integrity: &downloader.Integrity{SHA256: map[string]string{"model.gguf": strings.Repeat("0", 64)}},
+	}
+	_, err := Populate(context.Background(), downloader.New(downloader.Options{}), req, 0)
+	require.ErrorIs(t, err, downloader.ErrIntegrity)
+
+	// The version is not marked populated, so it is downloaded again
+	m, err := readMarker(dir)
+	require.NoError(t, err)
+	assert.Nil(t, m)
+}
+
+func TestParseKey(t *testing.T) {
+	name, ok := ParseKey("default/llama")
+	assert.True(t, ok)
+	assert.Equal(t, types.NamespacedName{Namespace: "default", Name: "llama"}, name)
+	for _, key := range []string{"llama", "/llama", "default/"} {
+		_, ok := ParseKey(key)
+		assert.False(t, ok, key)
+	}
+	assert.Equal(t, "a/x,b/y", JoinKeys([]string{"b/y", "a/x"}))
+}
@@ -0,0 +1,212 @@
+package modelcache
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/downloader"
+	"github.com/bowenislandsong/neuronetes/pkg/metrics"
+)
+
+// peerPathPrefix prefixes the paths of the models an agent serves to peers,
+// /v1/models/<namespace>/<name>/files/<path>
+const peerPathPrefix = "/v1/models/"
+
+// EnablePeers shares the cache with the agents of other nodes: the agent
+// downloads chunks of models from the peers caching or downloading them
+// before their weights URI, and serves its own cache with PeerHandler at
+// address, the host:port other agents reach it at. Agents authenticate to
+// each other with token, a secret they all share; PeerHandler serves no
+// one without it. m, if not nil, records where the weights are read from.
+// It must be called before Start.
+func (a *Agent) EnablePeers(address, token string, m *metrics.AgentMetrics) {
+	a.peerAddress = address
+	a.peerToken = token
+	a.metrics = m
+}
+
+// shareable reports whether the weights of model may be shared between
+// peers. Weights read with credentials are private to the nodes that read
+// them, as any agent could otherwise read them from a peer.
+func shareable(model *neuronetes.Model) bool {
+	if model.Spec.CredentialsSecretRef != nil || len(model.Spec.ImagePullSecrets) > 0 {
+		return false
+	}
+	for _, mirror := range model.Spec.Mirrors {
+		if mirror.CredentialsSecretRef != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// discoverPeers records the agents of other nodes sharing the models in
+// their cache, from the reports of their nodes
+func (a *Agent) discoverPeers(ctx context.Context) error {
+	var nodes corev1.NodeList
+	if err := a.client.List(ctx, &nodes); err != nil {
+		return fmt.Errorf("failed to list nodes: %w", err)
+	}
+	peers := make(map[string][]string)
+	for i := range nodes.Items {
+		node := &nodes.Items[i]
+		if node.Name == a.node {
+			continue
+		}
+		report, _, err := ParseReport(node)
+		if err != nil || report.PeerAddress == "" {
+			continue
+		}
+		for key, model := range report.Models {
+			if model.State == StateReady || model.State == StateLoading {
+				peers[key] = append(peers[key], "http://"+report.PeerAddress+peerPathPrefix+key)
+			}
+		}
+	}
+	for key := range peers {
+		sort.Strings(peers[key])
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.peers = peers
+	return nil
+}
+
+// downloadPeers returns the peers of the download of the model key, which
+// follow the peers discovered by later syncs
+func (a *Agent) downloadPeers(key string) *downloader.Peers {
+	return &downloader.Peers{
+		Token: a.peerToken,
+		URLs: func() []string {
+			a.mu.Lock()
+			defer a.mu.Unlock()
+			return a.peers[key]
+		},
+		Observe: func(peer string, bytes int64, err error) {
+			if a.metrics == nil {
+				return
+			}
+			ctx := context.Background()
+			if bytes > 0 {
+				a.metrics.RecordModelDistribution(ctx, key, peer != "", bytes)
+			}
+			switch {
+			case peer == "":
+			case err == nil:
+				a.metrics.RecordPeerRequest(ctx, key, "hit")
+			case errors.Is(err, downloader.ErrNotDownloaded):
+				a.metrics.RecordPeerRequest(ctx, key, "miss")
+			default:
+				a.metrics.RecordPeerRequest(ctx, key, "error")
+			}
+		},
+	}
+}
+
+// PeerHandler returns the handler serving the chunks of the shareable
+// models in the cache, complete or being downloaded, to the agents of other
+// nodes presenting the token of the peers
+func (a *Agent) PeerHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !a.authenticated(r) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		rest, ok := strings.CutPrefix(r.URL.Path, peerPathPrefix)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		parts := strings.SplitN(rest, "/", 4)
+		if len(parts) != 4 || parts[2] != "files" || parts[3] == markerFile {
+			http.NotFound(w, r)
+			return
+		}
+		key := parts[0] + "/" + parts[1]
+		if _, ok := ParseKey(key); !ok || !a.sharing(key) {
+			http.NotFound(w, r)
+			return
+		}
+
+		uri := a.cachedURI(key)
+		if uri == "" {
+			http.NotFound(w, r)
+			return
+		}
+		served := downloader.ServeDownloaded(w, r, parts[3], uri, a.path(key))
+		if served > 0 && a.metrics != nil {
+			a.metrics.RecordPeerServe(r.Context(), key, served)
+		}
+	})
+}
+
+// authenticated reports whether r carries the token of the peers
+func (a *Agent) authenticated(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && a.peerToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(a.peerToken)) == 1
+}
+
+// sharing reports whether the model key was shareable at the last sync
+func (a *Agent) sharing(key string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.shared[key]
+}
+
+// cachedURI returns the weights URI of the model key being downloaded or
+// cached, or an empty URI if it is not in the cache
+func (a *Agent) cachedURI(key string) string {
+	a.mu.Lock()
+	d, ok := a.downloads[key]
+	a.mu.Unlock()
+	if ok {
+		return d.uri
+	}
+	m, err := readMarker(a.path(key))
+	if err != nil || m == nil {
+		return ""
+	}
+	return m.URI
+}
+
+// Serve serves the cache to peers at addr until ctx is done
+func (a *Agent) Serve(ctx context.Context, addr string) error {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           a.PeerHandler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.ListenAndServe()
+	}()
+	log.FromContext(ctx).Info("Serving model cache to peers", "address", addr)
+
+	select {
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return srv.Shutdown(shutdownCtx)
+	case err := <-errCh:
+		if err == http.ErrServerClosed {
+			return nil
+		}
+		return err
+	}
+}
@@ -0,0 +1,129 @@
+// Package modelcache caches the weights of models on the local disks of
+// nodes. An agent on each node downloads the models the cache controller
+// assigns to the node and reports what the node caches, both through
+// annotations of the node.
+package modelcache
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// AnnotationAssignments is the node annotation listing the models the
+	// cache controller assigns to the node, as sorted namespace/name keys
+	// separated by commas
+	AnnotationAssignments = "neuronetes.io/model-cache-assignments"
+
+	// AnnotationReport is the node annotation holding the Report of the
+	// agent of the node
+	AnnotationReport = "neuronetes.io/model-cache"
+
+	// AnnotationCachedModels is the node annotation listing the models
+	// ready in the cache of the node, read by the scheduler
+	AnnotationCachedModels = "neuronetes.io/cached-models"
+)
+
+// States of a model in the cache of a node, as NodeCacheStatus reports them
+const (
+	StateLoading = "loading"
+	StateReady   = "ready"
+	StateEvicted = "evicted"
+	StateFailed  = "failed"
+)
+
+// Report is the state of the cache of a node
+type Report struct {
+	// CapacityBytes and FreeBytes are the size and free space of the disk
+	// of the cache
+	CapacityBytes int64 `json:"capacityBytes"`
+	FreeBytes     int64 `json:"freeBytes"`
+
+	// PeerAddress is the host:port the agent serves its cache to the
+	// agents of other nodes at, if it shares it
+	PeerAddress string `json:"peerAddress,omitempty"`
+
+	// Models are the models in the cache by namespace/name key
+	Models map[string]ModelReport `json:"models,omitempty"`
+}
+
+// ModelReport is the state of a model in the cache of a node
+type ModelReport struct {
+	// State is loading, ready, evicted or failed
+	State string `json:"state"`
+
+	// Bytes is the size of the weights, once known
+	Bytes int64 `json:"bytes,omitempty"`
+
+	// ProgressPercent is the download progress (0-100)
+	ProgressPercent int32 `json:"progressPercent,omitempty"`
+
+	// Revision is the immutable revision the weights URI resolved to
+	Revision string `json:"revision,omitempty"`
+
+	// Source is the URI the weights were downloaded from, the weights URI
+	// or one of its mirrors
+	Source string `json:"source,omitempty"`
+
+	// CachedAt is when the model became ready in the cache
+	CachedAt *metav1.Time `json:"cachedAt,omitempty"`
+
+	// LastUsed is when a pod on the node last served the model
+	LastUsed *metav1.Time `json:"lastUsed,omitempty"`
+
+	// Error is why the model failed to download or was evicted
+	Error string `json:"error,omitempty"`
+}
+
+// Key returns the namespace/name key of a model in annotations
+func Key(name types.NamespacedName) string {
+	return name.Namespace + "/" + name.Name
+}
+
+// ParseKey returns the model of a namespace/name key
+func ParseKey(key string) (types.NamespacedName, bool) {
+	namespace, name, ok := strings.Cut(key, "/")
+	if !ok || namespace == "" || name == "" {
+		return types.NamespacedName{}, false
+	}
+	return types.NamespacedName{Namespace: namespace, Name: name}, true
+}
+
+// Assignments returns the keys of the models assigned to node
+func Assignments(node *corev1.Node) []string {
+	return splitKeys(node.Annotations[AnnotationAssignments])
+}
+
+// JoinKeys returns keys sorted and joined as annotations list them
+func JoinKeys(keys []string) string {
+	sorted := append([]string(nil), keys...)
+	sort.Strings(sorted)
+	return strings.Join(sorted, ",")
+}
+
+// splitKeys returns the keys of an annotation listing them
+func splitKeys(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, ",")
+}
+
+// ParseReport returns the report of the agent of node. ok is false if the
+// node has none, as when no agent runs on it.
+func ParseReport(node *corev1.Node) (report Report, ok bool, err error) {
+	value, ok := node.Annotations[AnnotationReport]
+	if !ok {
+		return Report{}, false, nil
+	}
+	if err := json.Unmarshal([]byte(value), &report); err != nil {
+		return Report{}, true, fmt.Errorf("invalid model cache report of node %s: %w", node.Name, err)
+	}
+	return report, true, nil
+}
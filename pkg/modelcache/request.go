@@ -0,0 +1,108 @@
+package modelcache
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/downloader"
+)
+
+// DownloadRequest returns the request downloading the weights of model
+// into dir, from its weights URI or mirrors, with the credentials of the
+// Secrets it references and its integrity. It fails if a Secret the model
+// requires does not exist.
+func DownloadRequest(ctx context.Context, c client.Reader, model *neuronetes.Model, dir string) (downloader.Request, error) {
+	req := downloader.Request{
+		URI:      model.Spec.WeightsURI,
+		Dir:      dir,
+		Patterns: model.Spec.FilePatterns,
+	}
+	if ref := model.Spec.CredentialsSecretRef; ref != nil {
+		token, err := secretValue(ctx, c, model.Namespace, ref)
+		if err != nil {
+			return downloader.Request{}, err
+		}
+		req.Token = token
+	}
+	for _, mirror := range model.Spec.Mirrors {
+		m := downloader.Mirror{URI: mirror.URI, Priority: mirror.Priority}
+		if ref := mirror.CredentialsSecretRef; ref != nil {
+			token, err := secretValue(ctx, c, model.Namespace, ref)
+			if err != nil {
+				return downloader.Request{}, err
+			}
+			m.Token = token
+		}
+		req.Mirrors = append(req.Mirrors, m)
+	}
+	for _, ref := range model.Spec.ImagePullSecrets {
+		config, err := dockerConfig(ctx, c, model.Namespace, ref.Name)
+		if err != nil {
+			return downloader.Request{}, err
+		}
+		req.DockerConfigs = append(req.DockerConfigs, config)
+	}
+	if integrity := model.Spec.Integrity; integrity != nil {
+		req.Integrity = &downloader.Integrity{SHA256: integrity.SHA256}
+		if integrity.Signature != nil {
+			req.Integrity.PublicKey = []byte(integrity.Signature.PublicKey)
+			req.Integrity.Signature = integrity.Signature.Signature
+		}
+	}
+	return req, nil
+}
+
+// AdapterDownloadRequest returns the request downloading the weights of
+// adapter into dir, with the credentials of the Secret it references
+func AdapterDownloadRequest(ctx context.Context, c client.Reader, adapter *neuronetes.Adapter, dir string) (downloader.Request, error) {
+	req := downloader.Request{URI: adapter.Spec.WeightsURI, Dir: dir}
+	if ref := adapter.Spec.CredentialsSecretRef; ref != nil {
+		token, err := secretValue(ctx, c, adapter.Namespace, ref)
+		if err != nil {
+			return downloader.Request{}, err
+		}
+		req.Token = token
+	}
+	return req, nil
+}
+
+// secretValue returns the value of the key of a Secret selected by ref,
+// or an empty value if the optional Secret or key does not exist
+func secretValue(ctx context.Context, c client.Reader, namespace string, ref *corev1.SecretKeySelector) (string, error) {
+	optional := ref.Optional != nil && *ref.Optional
+	var secret corev1.Secret
+	if err := c.Get(ctx, types.NamespacedName{Namespace: namespace, Name: ref.Name}, &secret); err != nil {
+		if apierrors.IsNotFound(err) && optional {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to get credentials secret %s: %w", ref.Name, err)
+	}
+	value, ok := secret.Data[ref.Key]
+	if !ok && !optional {
+		return "", fmt.Errorf("credentials secret %s has no key %s", ref.Name, ref.Key)
+	}
+	return strings.TrimSpace(string(value)), nil
+}
+
+// dockerConfig returns the Docker config of an image pull secret, of type
+// kubernetes.io/dockerconfigjson or the legacy kubernetes.io/dockercfg
+func dockerConfig(ctx context.Context, c client.Reader, namespace, name string) ([]byte, error) {
+	var secret corev1.Secret
+	if err := c.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, &secret); err != nil {
+		return nil, fmt.Errorf("failed to get image pull secret %s: %w", name, err)
+	}
+	if config, ok := secret.Data[corev1.DockerConfigJsonKey]; ok {
+		return config, nil
+	}
+	if config, ok := secret.Data[corev1.DockerConfigKey]; ok {
+		return config, nil
+	}
+	return nil, fmt.Errorf("image pull secret %s has no %s or %s key", name, corev1.DockerConfigJsonKey, corev1.DockerConfigKey)
+}
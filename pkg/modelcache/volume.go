@@ -0,0 +1,154 @@
+package modelcache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/downloader"
+)
+
+const (
+	// DefaultKeepVersions is how many versions of the weights Populate
+	// keeps on a cache volume, so that replicas still reading the previous
+	// version are not cut off while they are replaced
+	DefaultKeepVersions = 2
+
+	// IntegrityExitCode is the exit code of populators whose weights fail
+	// integrity verification. Their Jobs fail on it without retrying, as
+	// the same weights would fail again.
+	IntegrityExitCode = 3
+
+	// lockSuffix is appended to the directory of a version for the file
+	// locking it. Lock files are kept when versions are pruned, so that
+	// every process locking a version locks the same file.
+	lockSuffix = ".lock"
+)
+
+// VolumeVersion returns the version of the weights of model on a cache
+// volume, the directory they are populated into under the root of the
+// volume. It changes with the weights URI and file patterns.
+func VolumeVersion(model *neuronetes.Model) string {
+	h := sha256.New()
+	h.Write([]byte(model.Spec.WeightsURI))
+	for _, pattern := range model.Spec.FilePatterns {
+		h.Write([]byte{0})
+		h.Write([]byte(pattern))
+	}
+	return hex.EncodeToString(h.Sum(nil))[:10]
+}
+
+// Populate downloads the weights of req into req.Dir, a version directory
+// of a cache volume that several populators may write at once, such as a
+// ReadWriteMany claim. It holds an exclusive lock on the version while it
+// downloads, so concurrent populators of the same version wait for the
+// first and reuse its weights. Once the weights are complete, it removes
+// the versions beside req.Dir beyond the keep most recent, skipping the
+// versions being populated. A keep below 1 uses DefaultKeepVersions.
+func Populate(ctx context.Context, d *downloader.Downloader, req downloader.Request, keep int) (downloader.Result, error) {
+	logger := log.FromContext(ctx).WithValues("dir", req.Dir)
+	if keep < 1 {
+		keep = DefaultKeepVersions
+	}
+	root := filepath.Dir(req.Dir)
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return downloader.Result{}, err
+	}
+	lock, _, err := lockFile(req.Dir+lockSuffix, false)
+	if err != nil {
+		return downloader.Result{}, fmt.Errorf("failed to lock %s: %w", req.Dir, err)
+	}
+	defer func() {
+		if err := unlockFile(lock); err != nil {
+			logger.Error(err, "failed to unlock cache version")
+		}
+	}()
+
+	m, err := readMarker(req.Dir)
+	if err != nil {
+		return downloader.Result{}, err
+	}
+	if m != nil && m.URI == req.URI {
+		logger.Info("Reusing cached weights", "uri", req.URI)
+		return downloader.Result{Size: m.Bytes, Revision: m.Revision, Source: m.Source}, pruneVersions(ctx, root, filepath.Base(req.Dir), keep)
+	}
+	if m != nil {
+		if err := os.RemoveAll(req.Dir); err != nil {
+			return downloader.Result{}, err
+		}
+	}
+
+	logger.Info("Populating cache volume", "uri", req.URI)
+	result, err := d.Download(ctx, req, nil)
+	if err != nil {
+		return downloader.Result{}, err
+	}
+	m = &marker{URI: req.URI, Revision: result.Revision, Source: result.Source, Bytes: result.Size, CachedAt: metav1.NewTime(time.Now())}
+	if err := writeMarker(req.Dir, m); err != nil {
+		return downloader.Result{}, err
+	}
+	logger.Info("Populated cache volume", "bytes", result.Size, "revision", result.Revision, "source", result.Source)
+	return result, pruneVersions(ctx, root, filepath.Base(req.Dir), keep)
+}
+
+// pruneVersions removes the version directories in root beyond the keep
+// most recently cached, current among them, and the incomplete ones no
+// populator holds
+func pruneVersions(ctx context.Context, root, current string, keep int) error {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return err
+	}
+	type version struct {
+		name     string
+		cachedAt time.Time
+	}
+	var versions []version
+	for _, entry := range entries {
+		if !entry.IsDir() || entry.Name() == current || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		v := version{name: entry.Name()}
+		if m, err := readMarker(filepath.Join(root, entry.Name())); err == nil && m != nil {
+			v.cachedAt = m.CachedAt.Time
+		}
+		versions = append(versions, v)
+	}
+	// Most recent first. Incomplete versions sort last and are removed
+	// unless being populated.
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].cachedAt.After(versions[j].cachedAt)
+	})
+	for i, v := range versions {
+		if i < keep-1 && !v.cachedAt.IsZero() {
+			continue
+		}
+		dir := filepath.Join(root, v.name)
+		lock, ok, err := lockFile(dir+lockSuffix, true)
+		if err != nil {
+			return fmt.Errorf("failed to lock %s: %w", dir, err)
+		}
+		if !ok {
+			continue
+		}
+		err = os.RemoveAll(dir)
+		if unlockErr := unlockFile(lock); err == nil {
+			err = unlockErr
+		}
+		if err != nil {
+			return err
+		}
+		log.FromContext(ctx).Info("Pruned cached weights", "dir", dir)
+	}
+	return nil
+}
@@ -129,10 +129,11 @@ func (p *ExampleModelLoaderPlugin) CanLoad(ctx context.Context, model *neuronete
 	return model.Spec.Format == "custom-format"
 }
 
-func (p *ExampleModelLoaderPlugin) Load(ctx context.Context, model *neuronetes.Model, node string) error {
+func (p *ExampleModelLoaderPlugin) Load(ctx context.Context, model *neuronetes.Model, node string, progress ProgressFunc) error {
 	// Example: Custom loading logic
 	fmt.Printf("Loading model %s on node %s\n", model.Name, node)
 	// Implement actual loading logic here
+	progress(100)
 	return nil
 }
 
@@ -2,9 +2,13 @@ package plugins
 
 import (
 	"context"
+	"errors"
+	"fmt"
 
 	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
 	corev1 "k8s.io/api/core/v1"
+
+	"github.com/bowenislandsong/neuronetes/pkg/metrics"
 )
 
 // SchedulerPlugin is the interface for custom scheduling algorithms
@@ -37,6 +41,9 @@ type AutoscalerPlugin interface {
 	Priority() int
 }
 
+// ProgressFunc receives load progress as a percentage (0-100)
+type ProgressFunc func(percent int32)
+
 // ModelLoaderPlugin is the interface for custom model loading strategies
 type ModelLoaderPlugin interface {
 	// Name returns the plugin name
@@ -45,8 +52,9 @@ type ModelLoaderPlugin interface {
 	// CanLoad returns true if this plugin can load the model
 	CanLoad(ctx context.Context, model *neuronetes.Model) bool
 
-	// Load loads the model and returns the loading time
-	Load(ctx context.Context, model *neuronetes.Model, node string) error
+	// Load loads the model onto the node, reporting progress as it goes.
+	// progress may be called from any goroutine and is never nil.
+	Load(ctx context.Context, model *neuronetes.Model, node string, progress ProgressFunc) error
 
 	// Unload unloads the model from the node
 	Unload(ctx context.Context, model *neuronetes.Model, node string) error
@@ -79,6 +87,19 @@ type GuardrailPlugin interface {
 	GetType() string
 }
 
+// MetricsRegistrar registers the metrics plugins publish, e.g. an
+// AgentMetrics
+type MetricsRegistrar interface {
+	RegisterCustomMetric(opts metrics.CustomMetricOpts) (*metrics.CustomMetric, error)
+}
+
+// InstrumentedPlugin is implemented by plugins of any kind publishing their
+// own metrics. RegisterMetrics may be called more than once with the same
+// registrar, which returns the metrics already registered.
+type InstrumentedPlugin interface {
+	RegisterMetrics(registrar MetricsRegistrar) error
+}
+
 // GuardrailRequest represents a request to evaluate
 type GuardrailRequest struct {
 	Content    string
@@ -167,6 +188,39 @@ func (r *PluginRegistry) GetGuardrails() []GuardrailPlugin {
 	return r.guardrails
 }
 
+// RegisterMetrics registers the metrics of the plugins implementing
+// InstrumentedPlugin with registrar
+func (r *PluginRegistry) RegisterMetrics(registrar MetricsRegistrar) error {
+	var plugins []interface{ Name() string }
+	for _, p := range r.schedulers {
+		plugins = append(plugins, p)
+	}
+	for _, p := range r.autoscalers {
+		plugins = append(plugins, p)
+	}
+	for _, p := range r.modelLoaders {
+		plugins = append(plugins, p)
+	}
+	for _, p := range r.metricsProviders {
+		plugins = append(plugins, p)
+	}
+	for _, p := range r.guardrails {
+		plugins = append(plugins, p)
+	}
+
+	var errs []error
+	for _, p := range plugins {
+		instrumented, ok := p.(InstrumentedPlugin)
+		if !ok {
+			continue
+		}
+		if err := instrumented.RegisterMetrics(registrar); err != nil {
+			errs = append(errs, fmt.Errorf("plugin %s: %w", p.Name(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
 // Global registry instance
 var globalRegistry = NewPluginRegistry()
 
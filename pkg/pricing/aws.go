@@ -0,0 +1,220 @@
+package pricing
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/config"
+)
+
+const (
+	// awsPricingRegion hosts the AWS Price List Query API
+	awsPricingRegion = "us-east-1"
+
+	awsPricingEndpoint = "https://api.pricing." + awsPricingRegion + ".amazonaws.com/"
+)
+
+// AWS prices EC2 instances: on-demand instances from the AWS Price List
+// Query API and spot instances from the EC2 spot price history of their
+// zone. It needs the pricing:GetProducts and ec2:DescribeSpotPriceHistory
+// permissions.
+type AWS struct {
+	credentials aws.CredentialsProvider
+	client      *http.Client
+	signer      *v4.Signer
+	now         func() time.Time
+
+	// pricingEndpoint and ec2Endpoint are overridden by tests
+	pricingEndpoint string
+	ec2Endpoint     func(region string) string
+}
+
+// NewAWS creates a provider with the default credentials of the AWS SDK,
+// such as those of the service account's IAM role
+func NewAWS(ctx context.Context) (*AWS, error) {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS credentials: %w", err)
+	}
+	return newAWS(cfg.Credentials), nil
+}
+
+func newAWS(credentials aws.CredentialsProvider) *AWS {
+	return &AWS{
+		credentials:     credentials,
+		client:          &http.Client{Timeout: 10 * time.Second},
+		signer:          v4.NewSigner(),
+		now:             time.Now,
+		pricingEndpoint: awsPricingEndpoint,
+		ec2Endpoint: func(region string) string {
+			return "https://ec2." + region + ".amazonaws.com/"
+		},
+	}
+}
+
+// GPUHourPrice prices an EC2 instance, on demand or on the spot market
+func (a *AWS) GPUHourPrice(ctx context.Context, instance Instance) (float64, error) {
+	if instance.Type == "" || instance.Region == "" {
+		return 0, fmt.Errorf("%w: node has no instance type or region", ErrNoPrice)
+	}
+	var price float64
+	var err error
+	if instance.Spot {
+		price, err = a.spotPrice(ctx, instance)
+	} else {
+		price, err = a.onDemandPrice(ctx, instance)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return perGPU(price, instance)
+}
+
+// onDemandPrice returns the hourly on-demand price of Linux instances
+func (a *AWS) onDemandPrice(ctx context.Context, instance Instance) (float64, error) {
+	filter := func(field, value string) map[string]string {
+		return map[string]string{"Type": "TERM_MATCH", "Field": field, "Value": value}
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"ServiceCode": "AmazonEC2",
+		"Filters": []map[string]string{
+			filter("instanceType", instance.Type),
+			filter("regionCode", instance.Region),
+			filter("operatingSystem", "Linux"),
+			filter("tenancy", "Shared"),
+			filter("preInstalledSw", "NA"),
+			filter("capacitystatus", "Used"),
+		},
+		"FormatVersion": "aws_v1",
+		"MaxResults":    10,
+	})
+	if err != nil {
+		return 0, err
+	}
+	header := http.Header{
+		"Content-Type": {"application/x-amz-json-1.1"},
+		"X-Amz-Target": {"AWSPriceListService.GetProducts"},
+	}
+	data, err := a.do(ctx, a.pricingEndpoint, body, header, "pricing", awsPricingRegion)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get on-demand price of %s: %w", instance.Type, err)
+	}
+
+	var response struct {
+		PriceList []string
+	}
+	if err := json.Unmarshal(data, &response); err != nil {
+		return 0, fmt.Errorf("failed to parse AWS price list: %w", err)
+	}
+	for _, item := range response.PriceList {
+		var product struct {
+			Terms struct {
+				OnDemand map[string]struct {
+					PriceDimensions map[string]struct {
+						Unit         string            `json:"unit"`
+						PricePerUnit map[string]string `json:"pricePerUnit"`
+					} `json:"priceDimensions"`
+				} `json:"OnDemand"`
+			} `json:"terms"`
+		}
+		if err := json.Unmarshal([]byte(item), &product); err != nil {
+			return 0, fmt.Errorf("failed to parse AWS price list: %w", err)
+		}
+		for _, term := range product.Terms.OnDemand {
+			for _, dimension := range term.PriceDimensions {
+				price, err := strconv.ParseFloat(dimension.PricePerUnit["USD"], 64)
+				if err == nil && dimension.Unit == "Hrs" && price > 0 {
+					return price, nil
+				}
+			}
+		}
+	}
+	return 0, fmt.Errorf("%w: no on-demand price for %s in %s", ErrNoPrice, instance.Type, instance.Region)
+}
+
+// spotPrice returns the current spot price of Linux instances in the
+// instance's zone, or the lowest of its region if the zone is unknown
+func (a *AWS) spotPrice(ctx context.Context, instance Instance) (float64, error) {
+	form := url.Values{
+		"Action":               {"DescribeSpotPriceHistory"},
+		"Version":              {"2016-11-15"},
+		"InstanceType.1":       {instance.Type},
+		"ProductDescription.1": {"Linux/UNIX"},
+		"StartTime":            {a.now().UTC().Format(time.RFC3339)},
+	}
+	header := http.Header{"Content-Type": {"application/x-www-form-urlencoded; charset=utf-8"}}
+	data, err := a.do(ctx, a.ec2Endpoint(instance.Region), []byte(form.Encode()), header, "ec2", instance.Region)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get spot price of %s: %w", instance.Type, err)
+	}
+
+	var response struct {
+		Items []struct {
+			AvailabilityZone string `xml:"availabilityZone"`
+			SpotPrice        string `xml:"spotPrice"`
+		} `xml:"spotPriceHistorySet>item"`
+	}
+	if err := xml.Unmarshal(data, &response); err != nil {
+		return 0, fmt.Errorf("failed to parse spot price history: %w", err)
+	}
+	lowest := math.Inf(1)
+	for _, item := range response.Items {
+		price, err := strconv.ParseFloat(item.SpotPrice, 64)
+		if err != nil {
+			continue
+		}
+		if item.AvailabilityZone == instance.Zone {
+			return price, nil
+		}
+		lowest = math.Min(lowest, price)
+	}
+	if math.IsInf(lowest, 1) {
+		return 0, fmt.Errorf("%w: no spot price for %s in %s", ErrNoPrice, instance.Type, instance.Region)
+	}
+	return lowest, nil
+}
+
+// do sends a request signed with the provider's credentials and returns the
+// response body
+func (a *AWS) do(ctx context.Context, endpoint string, body []byte, header http.Header, service, region string) ([]byte, error) {
+	credentials, err := a.credentials.Retrieve(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve AWS credentials: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header = header
+	hash := sha256.Sum256(body)
+	if err := a.signer.SignHTTP(ctx, credentials, req, hex.EncodeToString(hash[:]), service, region, a.now()); err != nil {
+		return nil, err
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s: %s", resp.Status, bytes.TrimSpace(data))
+	}
+	return data, nil
+}
@@ -0,0 +1,81 @@
+package pricing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const azurePricesEndpoint = "https://prices.azure.com/api/retail/prices"
+
+// Azure prices virtual machines from the public Azure Retail Prices API,
+// which needs no credentials
+type Azure struct {
+	client   *http.Client
+	endpoint string
+}
+
+// NewAzure creates a provider for the Azure Retail Prices API
+func NewAzure() *Azure {
+	return &Azure{
+		client:   &http.Client{Timeout: 10 * time.Second},
+		endpoint: azurePricesEndpoint,
+	}
+}
+
+// azurePrice is an item of the Retail Prices API
+type azurePrice struct {
+	RetailPrice   float64 `json:"retailPrice"`
+	UnitOfMeasure string  `json:"unitOfMeasure"`
+	SkuName       string  `json:"skuName"`
+	ProductName   string  `json:"productName"`
+}
+
+// GPUHourPrice prices a Linux virtual machine, pay-as-you-go or spot
+func (a *Azure) GPUHourPrice(ctx context.Context, instance Instance) (float64, error) {
+	if instance.Type == "" || instance.Region == "" {
+		return 0, fmt.Errorf("%w: node has no instance type or region", ErrNoPrice)
+	}
+	filter := fmt.Sprintf("serviceName eq 'Virtual Machines' and priceType eq 'Consumption' and armRegionName eq '%s' and armSkuName eq '%s'",
+		instance.Region, instance.Type)
+	next := a.endpoint + "?" + url.Values{"$filter": {filter}}.Encode()
+
+	for next != "" {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, next, nil)
+		if err != nil {
+			return 0, err
+		}
+		resp, err := a.client.Do(req)
+		if err != nil {
+			return 0, fmt.Errorf("failed to get price of %s: %w", instance.Type, err)
+		}
+		var page struct {
+			Items        []azurePrice `json:"Items"`
+			NextPageLink string       `json:"NextPageLink"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return 0, fmt.Errorf("failed to get price of %s: unexpected status %s", instance.Type, resp.Status)
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to parse Azure retail prices: %w", err)
+		}
+
+		for _, item := range page.Items {
+			if strings.Contains(item.ProductName, "Windows") || strings.Contains(item.SkuName, "Low Priority") {
+				continue
+			}
+			if item.UnitOfMeasure != "1 Hour" || strings.HasSuffix(item.SkuName, " Spot") != instance.Spot {
+				continue
+			}
+			return perGPU(item.RetailPrice, instance)
+		}
+		next = page.NextPageLink
+	}
+	return 0, fmt.Errorf("%w: no price for %s in %s", ErrNoPrice, instance.Type, instance.Region)
+}
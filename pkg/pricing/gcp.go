@@ -0,0 +1,202 @@
+package pricing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// gcpComputeSKUs lists the SKUs of Compute Engine in the Cloud Billing
+	// Catalog API
+	gcpComputeSKUs = "https://cloudbilling.googleapis.com/v1/services/6F81-5844-456A/skus"
+
+	// gcpCatalogTTL is how long the GPU SKUs are reused; list prices change
+	// rarely and the catalog is thousands of SKUs
+	gcpCatalogTTL = 24 * time.Hour
+)
+
+// GCP prices the GPUs of GKE nodes from the Cloud Billing Catalog API.
+// GPUs are billed on their own on Google Cloud, so only the GPUs of a node
+// are priced, not its machine type.
+type GCP struct {
+	apiKey   string
+	client   *http.Client
+	endpoint string
+	now      func() time.Time
+
+	mu      sync.Mutex
+	prices  map[gcpSKU]float64
+	fetched time.Time
+}
+
+// gcpSKU identifies the price of a GPU model in a region
+type gcpSKU struct {
+	model  string
+	region string
+	spot   bool
+}
+
+// NewGCP creates a provider reading the catalog with an API key
+func NewGCP(apiKey string) *GCP {
+	return &GCP{
+		apiKey:   apiKey,
+		client:   &http.Client{Timeout: 30 * time.Second},
+		endpoint: gcpComputeSKUs,
+		now:      time.Now,
+	}
+}
+
+// GPUHourPrice prices a GPU of the node's accelerator, on demand or spot
+func (g *GCP) GPUHourPrice(ctx context.Context, instance Instance) (float64, error) {
+	if instance.Accelerator == "" || instance.Region == "" {
+		return 0, fmt.Errorf("%w: node has no accelerator or region", ErrNoPrice)
+	}
+	prices, err := g.catalog(ctx)
+	if err != nil {
+		return 0, err
+	}
+	model := strings.TrimPrefix(strings.TrimPrefix(instance.Accelerator, "nvidia-"), "tesla-")
+	price, ok := prices[gcpSKU{model: model, region: instance.Region, spot: instance.Spot}]
+	if !ok {
+		return 0, fmt.Errorf("%w: no price for %s in %s", ErrNoPrice, instance.Accelerator, instance.Region)
+	}
+	return price, nil
+}
+
+// catalog returns the hourly GPU prices, listing them at most once per
+// gcpCatalogTTL
+func (g *GCP) catalog(ctx context.Context) (map[gcpSKU]float64, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.prices != nil && g.now().Sub(g.fetched) < gcpCatalogTTL {
+		return g.prices, nil
+	}
+
+	prices := map[gcpSKU]float64{}
+	token := ""
+	for {
+		page, err := g.listSKUs(ctx, token)
+		if err != nil {
+			return nil, err
+		}
+		for _, sku := range page.SKUs {
+			model, spot, ok := gpuModel(sku.Description)
+			if !ok || sku.Category.ResourceGroup != "GPU" || len(sku.PricingInfo) == 0 {
+				continue
+			}
+			if sku.Category.UsageType != "OnDemand" && sku.Category.UsageType != "Preemptible" {
+				continue
+			}
+			rates := sku.PricingInfo[0].PricingExpression.TieredRates
+			if sku.PricingInfo[0].PricingExpression.UsageUnit != "h" || len(rates) == 0 {
+				continue
+			}
+			price := rates[len(rates)-1].UnitPrice.value()
+			spot = spot || sku.Category.UsageType == "Preemptible"
+			for _, region := range sku.ServiceRegions {
+				prices[gcpSKU{model: model, region: region, spot: spot}] = price
+			}
+		}
+		if page.NextPageToken == "" {
+			break
+		}
+		token = page.NextPageToken
+	}
+	g.prices, g.fetched = prices, g.now()
+	return prices, nil
+}
+
+// gcpSKUPage is a page of the SKUs of a service
+type gcpSKUPage struct {
+	SKUs []struct {
+		Description string `json:"description"`
+		Category    struct {
+			ResourceGroup string `json:"resourceGroup"`
+			UsageType     string `json:"usageType"`
+		} `json:"category"`
+		ServiceRegions []string `json:"serviceRegions"`
+		PricingInfo    []struct {
+			PricingExpression struct {
+				UsageUnit   string `json:"usageUnit"`
+				TieredRates []struct {
+					UnitPrice gcpMoney `json:"unitPrice"`
+				} `json:"tieredRates"`
+			} `json:"pricingExpression"`
+		} `json:"pricingInfo"`
+	} `json:"skus"`
+	NextPageToken string `json:"nextPageToken"`
+}
+
+// gcpMoney is an amount of the catalog's currency
+type gcpMoney struct {
+	Units string `json:"units"`
+	Nanos int64  `json:"nanos"`
+}
+
+func (m gcpMoney) value() float64 {
+	units, _ := strconv.ParseInt(m.Units, 10, 64)
+	return float64(units) + float64(m.Nanos)/1e9
+}
+
+func (g *GCP) listSKUs(ctx context.Context, token string) (*gcpSKUPage, error) {
+	query := url.Values{"currencyCode": {"USD"}, "pageSize": {"5000"}}
+	if g.apiKey != "" {
+		query.Set("key", g.apiKey)
+	}
+	if token != "" {
+		query.Set("pageToken", token)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, g.endpoint+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list Compute Engine SKUs: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to list Compute Engine SKUs: unexpected status %s", resp.Status)
+	}
+	page := &gcpSKUPage{}
+	if err := json.NewDecoder(resp.Body).Decode(page); err != nil {
+		return nil, fmt.Errorf("failed to parse Compute Engine SKUs: %w", err)
+	}
+	return page, nil
+}
+
+// gpuModel returns the GPU model of a SKU description as in the node's
+// accelerator label without its nvidia- and tesla- prefixes, e.g. a100-80gb
+// for "Nvidia A100 80GB GPU running in Americas", and whether it is priced
+// for spot VMs
+func gpuModel(description string) (string, bool, bool) {
+	words := strings.Fields(strings.ToLower(description))
+	spot := false
+	for len(words) > 0 && (words[0] == "spot" || words[0] == "preemptible") {
+		spot = true
+		words = words[1:]
+	}
+	if len(words) == 0 || words[0] != "nvidia" {
+		return "", false, false
+	}
+	words = words[1:]
+	if len(words) > 0 && words[0] == "tesla" {
+		words = words[1:]
+	}
+	for i, word := range words {
+		if word == "gpu" {
+			if i == 0 {
+				return "", false, false
+			}
+			return strings.Join(words[:i], "-"), spot, true
+		}
+	}
+	return "", false, false
+}
@@ -0,0 +1,223 @@
+// Package pricing prices the GPUs of nodes by the hour, from the list prices
+// of the cloud a node runs in or from a static table, for cost-aware
+// scheduling.
+package pricing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/bowenislandsong/neuronetes/pkg/gpu"
+)
+
+// ErrNoPrice is returned for instances a provider has no price for
+var ErrNoPrice = errors.New("no price")
+
+// Node labels describing the instance behind a node
+const (
+	// gfdCountLabel is the number of GPUs labeled by GPU Feature Discovery,
+	// used when GPUs are advertised as MIG slices
+	gfdCountLabel = "nvidia.com/gpu.count"
+)
+
+// spotLabels mark spot and preemptible nodes on each cloud
+var spotLabels = map[string]string{
+	"karpenter.sh/capacity-type":            "spot",
+	"eks.amazonaws.com/capacityType":        "SPOT",
+	"cloud.google.com/gke-spot":             "true",
+	"cloud.google.com/gke-preemptible":      "true",
+	"kubernetes.azure.com/scalesetpriority": "spot",
+}
+
+// Instance is the cloud instance behind a node
+type Instance struct {
+	// Type is the instance type, e.g. p4d.24xlarge
+	Type string
+
+	// Region and Zone are where the instance runs
+	Region string
+	Zone   string
+
+	// Spot is set for spot and preemptible instances
+	Spot bool
+
+	// GPUs is the number of GPUs of the instance
+	GPUs int64
+
+	// Accelerator is the GPU model of GKE nodes
+	Accelerator string
+}
+
+// InstanceOf describes the instance behind node from its well-known labels
+func InstanceOf(node *corev1.Node) Instance {
+	instance := Instance{
+		Type:        node.Labels[corev1.LabelInstanceTypeStable],
+		Region:      node.Labels[corev1.LabelTopologyRegion],
+		Zone:        node.Labels[corev1.LabelTopologyZone],
+		Accelerator: node.Labels[gpu.GKEAcceleratorLabel],
+	}
+	for label, value := range spotLabels {
+		if node.Labels[label] == value {
+			instance.Spot = true
+		}
+	}
+	if gpus, ok := node.Status.Allocatable[gpu.NVIDIAResource]; ok && gpus.Value() > 0 {
+		instance.GPUs = gpus.Value()
+	} else if gpus, ok := node.Status.Allocatable[gpu.AMDResource]; ok && gpus.Value() > 0 {
+		instance.GPUs = gpus.Value()
+	} else if count, err := strconv.ParseInt(node.Labels[gfdCountLabel], 10, 64); err == nil {
+		instance.GPUs = count
+	}
+	return instance
+}
+
+// Provider prices GPUs
+type Provider interface {
+	// GPUHourPrice returns the price in USD of one GPU of instance for an
+	// hour, including its share of the rest of the instance. It returns
+	// ErrNoPrice if the instance is unknown to the provider.
+	GPUHourPrice(ctx context.Context, instance Instance) (float64, error)
+}
+
+// perGPU splits the hourly price of an instance over its GPUs
+func perGPU(price float64, instance Instance) (float64, error) {
+	if instance.GPUs <= 0 {
+		return 0, fmt.Errorf("%w: instance %s has no GPUs", ErrNoPrice, instance.Type)
+	}
+	return price / float64(instance.GPUs), nil
+}
+
+// Chain returns a provider asking each of providers in turn, until one has
+// a price
+func Chain(providers ...Provider) Provider {
+	return chain(providers)
+}
+
+type chain []Provider
+
+func (c chain) GPUHourPrice(ctx context.Context, instance Instance) (float64, error) {
+	for _, provider := range c {
+		price, err := provider.GPUHourPrice(ctx, instance)
+		if !errors.Is(err, ErrNoPrice) {
+			return price, err
+		}
+	}
+	return 0, ErrNoPrice
+}
+
+// Cache remembers the prices of a provider for ttl. Errors are not cached.
+type Cache struct {
+	provider Provider
+	ttl      time.Duration
+	now      func() time.Time
+
+	mu     sync.Mutex
+	prices map[Instance]cachedPrice
+}
+
+type cachedPrice struct {
+	price   float64
+	fetched time.Time
+}
+
+// NewCache caches the prices of provider for ttl
+func NewCache(provider Provider, ttl time.Duration) *Cache {
+	return &Cache{
+		provider: provider,
+		ttl:      ttl,
+		now:      time.Now,
+		prices:   make(map[Instance]cachedPrice),
+	}
+}
+
+// GPUHourPrice returns the cached price of instance, asking the provider
+// once the cached one expired
+func (c *Cache) GPUHourPrice(ctx context.Context, instance Instance) (float64, error) {
+	c.mu.Lock()
+	cached, ok := c.prices[instance]
+	c.mu.Unlock()
+	if ok && c.now().Sub(cached.fetched) < c.ttl {
+		return cached.price, nil
+	}
+
+	price, err := c.provider.GPUHourPrice(ctx, instance)
+	if err != nil {
+		return 0, err
+	}
+	c.mu.Lock()
+	c.prices[instance] = cachedPrice{price: price, fetched: c.now()}
+	c.mu.Unlock()
+	return price, nil
+}
+
+// DefaultCacheTTL is how long prices are reused by providers created with
+// New
+const DefaultCacheTTL = time.Hour
+
+// Providers that can be configured
+const (
+	ProviderAWS    = "aws"
+	ProviderGCP    = "gcp"
+	ProviderAzure  = "azure"
+	ProviderStatic = "static"
+)
+
+// Config configures the provider created by New
+type Config struct {
+	// Provider is the cloud prices are read from: aws, gcp, azure, or static
+	// to only use Table
+	Provider string
+
+	// Table is a pricing table file, see LoadStatic. Its prices take
+	// precedence over the cloud's.
+	Table string
+
+	// GCPAPIKey is the API key the Cloud Billing Catalog API is read with
+	GCPAPIKey string
+
+	// CacheTTL defaults to DefaultCacheTTL
+	CacheTTL time.Duration
+}
+
+// New creates the configured provider, caching its prices
+func New(ctx context.Context, config Config) (Provider, error) {
+	var providers chain
+	if config.Table != "" {
+		table, err := LoadStatic(config.Table)
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, table)
+	}
+
+	switch config.Provider {
+	case ProviderAWS:
+		aws, err := NewAWS(ctx)
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, aws)
+	case ProviderGCP:
+		providers = append(providers, NewGCP(config.GCPAPIKey))
+	case ProviderAzure:
+		providers = append(providers, NewAzure())
+	case ProviderStatic:
+		if config.Table == "" {
+			return nil, fmt.Errorf("the static pricing provider requires a pricing table")
+		}
+	default:
+		return nil, fmt.Errorf("unknown pricing provider %q", config.Provider)
+	}
+
+	ttl := config.CacheTTL
+	if ttl <= 0 {
+		ttl = DefaultCacheTTL
+	}
+	return NewCache(providers, ttl), nil
+}
@@ -0,0 +1,310 @@
+package pricing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/bowenislandsong/neuronetes/pkg/gpu"
+)
+
+var p4d = Instance{Type: "p4d.24xlarge", Region: "us-east-1", Zone: "us-east-1a", GPUs: 8}
+
+func TestInstanceOf(t *testing.T) {
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{
+			corev1.LabelInstanceTypeStable: "a2-highgpu-2g",
+			corev1.LabelTopologyRegion:     "us-central1",
+			corev1.LabelTopologyZone:       "us-central1-a",
+			gpu.GKEAcceleratorLabel:        "nvidia-tesla-a100",
+			"cloud.google.com/gke-spot":    "true",
+		}},
+		Status: corev1.NodeStatus{Allocatable: corev1.ResourceList{
+			gpu.NVIDIAResource: resource.MustParse("2"),
+		}},
+	}
+	assert.Equal(t, Instance{
+		Type:        "a2-highgpu-2g",
+		Region:      "us-central1",
+		Zone:        "us-central1-a",
+		Spot:        true,
+		GPUs:        2,
+		Accelerator: "nvidia-tesla-a100",
+	}, InstanceOf(node))
+
+	// GPUs advertised as MIG slices are counted from the GFD label
+	node.Status.Allocatable = nil
+	node.Labels[gfdCountLabel] = "4"
+	assert.Equal(t, int64(4), InstanceOf(node).GPUs)
+}
+
+func TestStatic(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "prices.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("p4d.24xlarge:\n  onDemand: 32\n  spot: 12\ng5.xlarge:\n  onDemand: 1\n"), 0o600))
+	table, err := LoadStatic(path)
+	require.NoError(t, err)
+
+	price, err := table.GPUHourPrice(context.Background(), p4d)
+	require.NoError(t, err)
+	assert.Equal(t, 4.0, price)
+
+	spot := p4d
+	spot.Spot = true
+	price, err = table.GPUHourPrice(context.Background(), spot)
+	require.NoError(t, err)
+	assert.Equal(t, 1.5, price)
+
+	// Spot instances of a type without a spot price are priced on demand
+	price, err = table.GPUHourPrice(context.Background(), Instance{Type: "g5.xlarge", Spot: true, GPUs: 1})
+	require.NoError(t, err)
+	assert.Equal(t, 1.0, price)
+
+	_, err = table.GPUHourPrice(context.Background(), Instance{Type: "m5.large", GPUs: 1})
+	assert.ErrorIs(t, err, ErrNoPrice)
+}
+
+// priceFunc is a Provider counting its calls
+type priceFunc struct {
+	price float64
+	err   error
+	calls int
+}
+
+func (p *priceFunc) GPUHourPrice(ctx context.Context, instance Instance) (float64, error) {
+	p.calls++
+	return p.price, p.err
+}
+
+func TestChain(t *testing.T) {
+	unknown := &priceFunc{err: ErrNoPrice}
+	known := &priceFunc{price: 3}
+	price, err := Chain(unknown, known).GPUHourPrice(context.Background(), p4d)
+	require.NoError(t, err)
+	assert.Equal(t, 3.0, price)
+
+	// Failures other than unknown instances are not hidden by later providers
+	failing := &priceFunc{err: errors.New("throttled")}
+	_, err = Chain(failing, known).GPUHourPrice(context.Background(), p4d)
+	assert.EqualError(t, err, "throttled")
+
+	_, err = Chain(unknown).GPUHourPrice(context.Background(), p4d)
+	assert.ErrorIs(t, err, ErrNoPrice)
+}
+
+func TestCache(t *testing.T) {
+	provider := &priceFunc{price: 2}
+	cache := NewCache(provider, time.Hour)
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		price, err := cache.GPUHourPrice(context.Background(), p4d)
+		require.NoError(t, err)
+		assert.Equal(t, 2.0, price)
+	}
+	assert.Equal(t, 1, provider.calls)
+
+	now = now.Add(time.Hour)
+	_, err := cache.GPUHourPrice(context.Background(), p4d)
+	require.NoError(t, err)
+	assert.Equal(t, 2, provider.calls)
+
+	// Errors are retried
+	provider.err = errors.New("throttled")
+	_, err = cache.GPUHourPrice(context.Background(), Instance{Type: "g5.xlarge", GPUs: 1})
+	require.Error(t, err)
+	_, err = cache.GPUHourPrice(context.Background(), Instance{Type: "g5.xlarge", GPUs: 1})
+	require.Error(t, err)
+	assert.Equal(t, 4, provider.calls)
+}
+
+func TestAWS(t *testing.T) {
+	product, err := json.Marshal(map[string]interface{}{
+		"product": map[string]interface{}{"attributes": map[string]string{"instanceType": "p4d.24xlarge"}},
+		"terms": map[string]interface{}{"OnDemand": map[string]interface{}{"term": map[string]interface{}{
+			"priceDimensions": map[string]interface{}{"dimension": map[string]interface{}{
+				"unit":         "Hrs",
+				"pricePerUnit": map[string]string{"USD": "32.7726000000"},
+			}},
+		}}},
+	})
+	require.NoError(t, err)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Contains(t, r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/")
+		body, _ := io.ReadAll(r.Body)
+		switch {
+		case r.URL.Path == "/pricing":
+			assert.Equal(t, "AWSPriceListService.GetProducts", r.Header.Get("X-Amz-Target"))
+			assert.Contains(t, string(body), `"Value":"p4d.24xlarge"`)
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"PriceList": []string{string(product)}})
+		case r.URL.Path == "/ec2/us-east-1":
+			assert.Contains(t, string(body), "Action=DescribeSpotPriceHistory")
+			_, _ = w.Write([]byte(`<DescribeSpotPriceHistoryResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/">
+  <spotPriceHistorySet>
+    <item><availabilityZone>us-east-1b</availabilityZone><spotPrice>8.000000</spotPrice></item>
+    <item><availabilityZone>us-east-1a</availabilityZone><spotPrice>12.000000</spotPrice></item>
+  </spotPriceHistorySet>
+</DescribeSpotPriceHistoryResponse>`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	provider := newAWS(aws.CredentialsProviderFunc(func(ctx context.Context) (aws.Credentials, error) {
+		return aws.Credentials{AccessKeyID: "AKID", SecretAccessKey: "SECRET"}, nil
+	}))
+	provider.pricingEndpoint = server.URL + "/pricing"
+	provider.ec2Endpoint = func(region string) string { return server.URL + "/ec2/" + region }
+
+	price, err := provider.GPUHourPrice(context.Background(), p4d)
+	require.NoError(t, err)
+	assert.InDelta(t, 4.0966, price, 1e-4)
+
+	// Spot instances are priced in their zone, or the cheapest one
+	spot := p4d
+	spot.Spot = true
+	price, err = provider.GPUHourPrice(context.Background(), spot)
+	require.NoError(t, err)
+	assert.Equal(t, 1.5, price)
+
+	spot.Zone = ""
+	price, err = provider.GPUHourPrice(context.Background(), spot)
+	require.NoError(t, err)
+	assert.Equal(t, 1.0, price)
+
+	_, err = provider.GPUHourPrice(context.Background(), Instance{Type: "p4d.24xlarge", GPUs: 8})
+	assert.ErrorIs(t, err, ErrNoPrice)
+}
+
+func TestAzure(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("page") == "2" {
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"Items": []azurePrice{
+				{RetailPrice: 27.197, UnitOfMeasure: "1 Hour", SkuName: "ND96asr v4", ProductName: "Virtual Machines NDasrA100v4 Series"},
+				{RetailPrice: 10.88, UnitOfMeasure: "1 Hour", SkuName: "ND96asr v4 Spot", ProductName: "Virtual Machines NDasrA100v4 Series"},
+			}})
+			return
+		}
+		assert.Contains(t, r.URL.Query().Get("$filter"), "armSkuName eq 'Standard_ND96asr_v4'")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"Items": []azurePrice{
+				{RetailPrice: 31.2, UnitOfMeasure: "1 Hour", SkuName: "ND96asr v4", ProductName: "Virtual Machines NDasrA100v4 Series Windows"},
+				{RetailPrice: 5.44, UnitOfMeasure: "1 Hour", SkuName: "ND96asr v4 Low Priority", ProductName: "Virtual Machines NDasrA100v4 Series"},
+			},
+			"NextPageLink": server.URL + "?page=2",
+		})
+	}))
+	defer server.Close()
+
+	provider := NewAzure()
+	provider.endpoint = server.URL
+	instance := Instance{Type: "Standard_ND96asr_v4", Region: "eastus", GPUs: 8}
+	price, err := provider.GPUHourPrice(context.Background(), instance)
+	require.NoError(t, err)
+	assert.InDelta(t, 3.3996, price, 1e-4)
+
+	instance.Spot = true
+	price, err = provider.GPUHourPrice(context.Background(), instance)
+	require.NoError(t, err)
+	assert.InDelta(t, 1.36, price, 1e-9)
+}
+
+func TestGCP(t *testing.T) {
+	sku := func(description, usageType string, units string, nanos int64, regions ...string) map[string]interface{} {
+		return map[string]interface{}{
+			"description":    description,
+			"category":       map[string]string{"resourceGroup": "GPU", "usageType": usageType},
+			"serviceRegions": regions,
+			"pricingInfo": []interface{}{map[string]interface{}{"pricingExpression": map[string]interface{}{
+				"usageUnit":   "h",
+				"tieredRates": []interface{}{map[string]interface{}{"unitPrice": map[string]interface{}{"units": units, "nanos": nanos}}},
+			}}},
+		}
+	}
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		assert.Equal(t, "secret", r.URL.Query().Get("key"))
+		if r.URL.Query().Get("pageToken") == "" {
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{
+				"skus":          []interface{}{sku("Nvidia Tesla A100 GPU running in Americas", "OnDemand", "2", 933908000, "us-central1", "us-east1")},
+				"nextPageToken": "next",
+			})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"skus": []interface{}{
+			sku("Spot Preemptible Nvidia Tesla A100 GPU running in Americas", "Preemptible", "0", 880000000, "us-central1"),
+			sku("Nvidia A100 80GB GPU running in Americas", "OnDemand", "3", 927470000, "us-central1"),
+			sku("Commitment v1: Nvidia Tesla A100 GPU in Americas for 1 Year", "Commit1Yr", "1", 848000000, "us-central1"),
+		}})
+	}))
+	defer server.Close()
+
+	provider := NewGCP("secret")
+	provider.endpoint = server.URL
+	instance := Instance{Accelerator: "nvidia-tesla-a100", Region: "us-central1", GPUs: 2}
+	price, err := provider.GPUHourPrice(context.Background(), instance)
+	require.NoError(t, err)
+	assert.InDelta(t, 2.933908, price, 1e-9)
+
+	instance.Spot = true
+	price, err = provider.GPUHourPrice(context.Background(), instance)
+	require.NoError(t, err)
+	assert.InDelta(t, 0.88, price, 1e-9)
+
+	price, err = provider.GPUHourPrice(context.Background(), Instance{Accelerator: "nvidia-a100-80gb", Region: "us-central1"})
+	require.NoError(t, err)
+	assert.InDelta(t, 3.92747, price, 1e-9)
+
+	_, err = provider.GPUHourPrice(context.Background(), Instance{Accelerator: "nvidia-l4", Region: "us-central1"})
+	assert.ErrorIs(t, err, ErrNoPrice)
+
+	// The catalog is listed once
+	assert.Equal(t, 2, requests)
+}
+
+func TestGPUModel(t *testing.T) {
+	for description, want := range map[string]string{
+		"Nvidia Tesla T4 GPU running in Americas":    "t4",
+		"Nvidia H100 80GB GPU running in Americas":   "h100-80gb",
+		"Spot Preemptible Nvidia L4 GPU in Virginia": "l4",
+		"Licensing Fee for Windows Server":           "",
+	} {
+		model, _, _ := gpuModel(description)
+		assert.Equal(t, want, model, description)
+	}
+	_, spot, _ := gpuModel("Spot Preemptible Nvidia L4 GPU in Virginia")
+	assert.True(t, spot)
+}
+
+func TestNew(t *testing.T) {
+	_, err := New(context.Background(), Config{Provider: "oracle"})
+	assert.ErrorContains(t, err, "unknown pricing provider")
+	_, err = New(context.Background(), Config{Provider: ProviderStatic})
+	assert.Error(t, err)
+
+	path := filepath.Join(t.TempDir(), "prices.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`{"p4d.24xlarge": {"onDemand": 16}}`), 0o600))
+	provider, err := New(context.Background(), Config{Provider: ProviderStatic, Table: path})
+	require.NoError(t, err)
+	price, err := provider.GPUHourPrice(context.Background(), p4d)
+	require.NoError(t, err)
+	assert.Equal(t, 2.0, price)
+}
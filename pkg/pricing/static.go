@@ -0,0 +1,52 @@
+package pricing
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"sigs.k8s.io/yaml"
+)
+
+// InstancePrice is the hourly price in USD of an instance type
+type InstancePrice struct {
+	OnDemand float64 `json:"onDemand"`
+
+	// Spot is the price of spot instances. Spot instances of a type without
+	// one are priced on demand.
+	Spot float64 `json:"spot,omitempty"`
+}
+
+// Static prices instances from a table of instance types, for clusters
+// without a pricing API such as on-premises ones, or to override list
+// prices with negotiated ones
+type Static map[string]InstancePrice
+
+// LoadStatic reads a table of instance types from a YAML or JSON file, e.g.
+//
+//	p4d.24xlarge:
+//	  onDemand: 32.77
+//	  spot: 12.5
+func LoadStatic(path string) (Static, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	table := Static{}
+	if err := yaml.Unmarshal(data, &table); err != nil {
+		return nil, fmt.Errorf("failed to parse pricing table %s: %w", path, err)
+	}
+	return table, nil
+}
+
+// GPUHourPrice prices instance from the table
+func (s Static) GPUHourPrice(ctx context.Context, instance Instance) (float64, error) {
+	price, ok := s[instance.Type]
+	if !ok {
+		return 0, fmt.Errorf("%w: instance type %q is not in the pricing table", ErrNoPrice, instance.Type)
+	}
+	if instance.Spot && price.Spot > 0 {
+		return perGPU(price.Spot, instance)
+	}
+	return perGPU(price.OnDemand, instance)
+}
@@ -0,0 +1,82 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// DefaultHuggingFaceEndpoint is the HuggingFace Hub
+const DefaultHuggingFaceEndpoint = "https://huggingface.co"
+
+// nextLink matches the URL of the next page in a Link header
+var nextLink = regexp.MustCompile(`<([^>]+)>;\s*rel="next"`)
+
+// HuggingFace lists the models of an author or organization of the
+// HuggingFace Hub, pinned to their latest commit
+type HuggingFace struct {
+	endpoint string
+	author   string
+	token    string
+	client   *http.Client
+}
+
+// huggingFaceModel is a model of the model listing API of the Hub
+type huggingFaceModel struct {
+	ID          string   `json:"id"`
+	SHA         string   `json:"sha"`
+	Tags        []string `json:"tags"`
+	UsedStorage int64    `json:"usedStorage"`
+	Safetensors *struct {
+		Total int64 `json:"total"`
+	} `json:"safetensors"`
+}
+
+// List implements Lister. Tags of the form key:value, such as
+// license:apache-2.0, are split into their key and value. Sizes are the
+// storage of the whole repository, which may hold more than one format of
+// the weights.
+func (h *HuggingFace) List(ctx context.Context) ([]Model, error) {
+	query := url.Values{"author": {h.author}, "limit": {"1000"}}
+	for _, field := range []string{"sha", "tags", "usedStorage", "safetensors"} {
+		query.Add("expand[]", field)
+	}
+	endpoint := h.endpoint + "/api/models?" + query.Encode()
+
+	var models []Model
+	for endpoint != "" {
+		var page []huggingFaceModel
+		header, err := get(ctx, h.client, endpoint, h.token, &page)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range page {
+			if m.SHA == "" {
+				return nil, fmt.Errorf("hf://%s has no commit", m.ID)
+			}
+			model := Model{
+				Name:       m.ID,
+				Version:    m.SHA,
+				WeightsURI: fmt.Sprintf("hf://%s@%s", m.ID, m.SHA),
+				Size:       m.UsedStorage,
+				Tags:       make(map[string]string, len(m.Tags)),
+			}
+			if m.Safetensors != nil {
+				model.ParameterCount = m.Safetensors.Total
+			}
+			for _, tag := range m.Tags {
+				key, value, _ := strings.Cut(tag, ":")
+				model.Tags[key] = value
+			}
+			models = append(models, model)
+		}
+		endpoint = ""
+		if match := nextLink.FindStringSubmatch(header.Get("Link")); match != nil {
+			endpoint = match[1]
+		}
+	}
+	return models, nil
+}
@@ -0,0 +1,89 @@
+package registry
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// MLflow lists the registered models of an MLflow tracking server whose
+// names start with a prefix, at their latest version
+type MLflow struct {
+	endpoint string
+	prefix   string
+	token    string
+	client   *http.Client
+}
+
+// mlflowTag is a tag of a registered model or model version
+type mlflowTag struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// mlflowSearch is a page of the registered model search API
+type mlflowSearch struct {
+	RegisteredModels []struct {
+		Name           string      `json:"name"`
+		Tags           []mlflowTag `json:"tags"`
+		LatestVersions []struct {
+			Version string      `json:"version"`
+			Source  string      `json:"source"`
+			Tags    []mlflowTag `json:"tags"`
+		} `json:"latest_versions"`
+	} `json:"registered_models"`
+	NextPageToken string `json:"next_page_token"`
+}
+
+// List implements Lister. The latest version of a model is the highest of
+// its latest versions per stage, and its weights are the source of that
+// version. Tags of the version override those of the registered model.
+// Registered models without versions are left out.
+func (m *MLflow) List(ctx context.Context) ([]Model, error) {
+	query := url.Values{"max_results": {"1000"}}
+	if m.prefix != "" {
+		query.Set("filter", "name LIKE '"+strings.ReplaceAll(m.prefix, "'", `\'`)+"%'")
+	}
+
+	var models []Model
+	for {
+		var page mlflowSearch
+		if _, err := get(ctx, m.client, m.endpoint+"/api/2.0/mlflow/registered-models/search?"+query.Encode(), m.token, &page); err != nil {
+			return nil, err
+		}
+		for _, registered := range page.RegisteredModels {
+			latest := -1
+			for i, version := range registered.LatestVersions {
+				if latest < 0 || versionNumber(version.Version) > versionNumber(registered.LatestVersions[latest].Version) {
+					latest = i
+				}
+			}
+			if latest < 0 {
+				continue
+			}
+			version := registered.LatestVersions[latest]
+			model := Model{
+				Name:       registered.Name,
+				Version:    version.Version,
+				WeightsURI: version.Source,
+				Tags:       make(map[string]string),
+			}
+			for _, tag := range append(registered.Tags, version.Tags...) {
+				model.Tags[tag.Key] = tag.Value
+			}
+			models = append(models, model)
+		}
+		if page.NextPageToken == "" {
+			return models, nil
+		}
+		query.Set("page_token", page.NextPageToken)
+	}
+}
+
+// versionNumber parses the number of an MLflow model version
+func versionNumber(version string) int64 {
+	n, _ := strconv.ParseInt(version, 10, 64)
+	return n
+}
@@ -0,0 +1,145 @@
+// Package registry lists the models published in a model registry, such as
+// the models of a HuggingFace organization or the registered models of an
+// MLflow tracking server, for the cluster to sync into Model resources.
+package registry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Types of registry
+const (
+	// TypeHuggingFace lists the models of an author or organization of the
+	// HuggingFace Hub
+	TypeHuggingFace = "HuggingFace"
+
+	// TypeMLflow lists the registered models of an MLflow tracking server
+	TypeMLflow = "MLflow"
+
+	// TypeHTTP lists the models of a custom registry serving them as JSON
+	TypeHTTP = "HTTP"
+)
+
+// DefaultTimeout bounds listing the models of a registry
+const DefaultTimeout = time.Minute
+
+// Model is a model published in a registry
+type Model struct {
+	// Name is the name of the model in the registry, e.g.
+	// meta-llama/Meta-Llama-3-8B
+	Name string `json:"name"`
+
+	// Version is the published version of the model, such as a commit or
+	// model version number
+	Version string `json:"version,omitempty"`
+
+	// WeightsURI is where the weights of the version are downloaded from
+	WeightsURI string `json:"weightsURI"`
+
+	// Size is the total size of the weights in bytes, or 0 if unknown
+	Size int64 `json:"size,omitempty"`
+
+	// ParameterCount is the number of parameters of the model, or 0 if
+	// unknown
+	ParameterCount int64 `json:"parameterCount,omitempty"`
+
+	// Tags are the tags of the model by key. Bare tags, such as the
+	// HuggingFace tag gguf, have an empty value.
+	Tags map[string]string `json:"tags,omitempty"`
+}
+
+// Lister lists the models of a registry namespace
+type Lister interface {
+	List(ctx context.Context) ([]Model, error)
+}
+
+// New creates a lister of the models of the registry of type typ at
+// endpoint, in its namespace, authenticated with token if not empty
+func New(typ, endpoint, namespace, token string) (Lister, error) {
+	client := &http.Client{Timeout: DefaultTimeout}
+	switch typ {
+	case TypeHuggingFace:
+		if endpoint == "" {
+			endpoint = DefaultHuggingFaceEndpoint
+		}
+		return &HuggingFace{endpoint: strings.TrimSuffix(endpoint, "/"), author: namespace, token: token, client: client}, nil
+	case TypeMLflow:
+		if endpoint == "" {
+			return nil, fmt.Errorf("MLflow registries need the URL of their tracking server")
+		}
+		return &MLflow{endpoint: strings.TrimSuffix(endpoint, "/"), prefix: namespace, token: token, client: client}, nil
+	case TypeHTTP:
+		if endpoint == "" {
+			return nil, fmt.Errorf("HTTP registries need the URL listing their models")
+		}
+		return &HTTP{endpoint: endpoint, namespace: namespace, token: token, client: client}, nil
+	}
+	return nil, fmt.Errorf("unsupported registry type %q", typ)
+}
+
+// HTTP lists the models of a custom registry. A GET of its endpoint with
+// the namespace query parameter returns {"models": [...]} of Model.
+type HTTP struct {
+	endpoint  string
+	namespace string
+	token     string
+	client    *http.Client
+}
+
+// List implements Lister
+func (h *HTTP) List(ctx context.Context) ([]Model, error) {
+	endpoint, err := url.Parse(h.endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid registry URL: %w", err)
+	}
+	query := endpoint.Query()
+	query.Set("namespace", h.namespace)
+	endpoint.RawQuery = query.Encode()
+
+	var list struct {
+		Models []Model `json:"models"`
+	}
+	if _, err := get(ctx, h.client, endpoint.String(), h.token, &list); err != nil {
+		return nil, err
+	}
+	for _, model := range list.Models {
+		if model.Name == "" || model.WeightsURI == "" {
+			return nil, fmt.Errorf("registry listed a model without name or weightsURI")
+		}
+	}
+	return list.Models, nil
+}
+
+// get sends a GET request for endpoint, authenticated with token, and
+// decodes the JSON response into v. It returns the response headers.
+func get(ctx context.Context, client *http.Client, endpoint, token string, v interface{}) (http.Header, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("failed to list %s: unexpected status %d: %s", req.URL.Redacted(), resp.StatusCode, bytes.TrimSpace(message))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", req.URL.Redacted(), err)
+	}
+	return resp.Header, nil
+}
@@ -0,0 +1,102 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHuggingFaceListsOrganizationModels(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/models", r.URL.Path)
+		assert.Equal(t, "acme-ai", r.URL.Query().Get("author"))
+		assert.Equal(t, "Bearer hf_token", r.Header.Get("Authorization"))
+		if r.URL.Query().Get("cursor") == "" {
+			w.Header().Set("Link", `<`+server.URL+`/api/models?author=acme-ai&cursor=2>; rel="next"`)
+			_, _ = w.Write([]byte(`[{"id": "acme-ai/Support-8B", "sha": "abc123", "tags": ["safetensors", "license:apache-2.0"], "usedStorage": 16060522240, "safetensors": {"total": 8030261248}}]`))
+			return
+		}
+		_, _ = w.Write([]byte(`[{"id": "acme-ai/Support-8B-GGUF", "sha": "def456", "tags": ["gguf"], "usedStorage": 4920734720}]`))
+	}))
+	defer server.Close()
+
+	lister, err := New(TypeHuggingFace, server.URL, "acme-ai", "hf_token")
+	require.NoError(t, err)
+	models, err := lister.List(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []Model{
+		{
+			Name:           "acme-ai/Support-8B",
+			Version:        "abc123",
+			WeightsURI:     "hf://acme-ai/Support-8B@abc123",
+			Size:           16060522240,
+			ParameterCount: 8030261248,
+			Tags:           map[string]string{"safetensors": "", "license": "apache-2.0"},
+		},
+		{
+			Name:       "acme-ai/Support-8B-GGUF",
+			Version:    "def456",
+			WeightsURI: "hf://acme-ai/Support-8B-GGUF@def456",
+			Size:       4920734720,
+			Tags:       map[string]string{"gguf": ""},
+		},
+	}, models)
+}
+
+func TestMLflowListsLatestVersions(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/2.0/mlflow/registered-models/search", r.URL.Path)
+		assert.Equal(t, "name LIKE 'support%'", r.URL.Query().Get("filter"))
+		if r.URL.Query().Get("page_token") == "" {
+			_, _ = w.Write([]byte(`{"registered_models": [{
+				"name": "support-llama",
+				"tags": [{"key": "quantization", "value": "fp16"}, {"key": "size", "value": "16Gi"}],
+				"latest_versions": [
+					{"version": "9", "source": "s3://mlflow/9/artifacts/model", "tags": [{"key": "quantization", "value": "int8"}]},
+					{"version": "12", "source": "s3://mlflow/12/artifacts/model"}
+				]
+			}], "next_page_token": "2"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"registered_models": [{"name": "support-draft"}]}`))
+	}))
+	defer server.Close()
+
+	lister, err := New(TypeMLflow, server.URL, "support", "")
+	require.NoError(t, err)
+	models, err := lister.List(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []Model{{
+		Name:       "support-llama",
+		Version:    "12",
+		WeightsURI: "s3://mlflow/12/artifacts/model",
+		Tags:       map[string]string{"quantization": "fp16", "size": "16Gi"},
+	}}, models)
+}
+
+func TestHTTPListsCustomRegistry(t *testing.T) {
+	published := []Model{{Name: "legal-70b", Version: "3", WeightsURI: "s3://models/legal-70b/3", Size: 140 << 30, Tags: map[string]string{"format": "safetensors"}}}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "legal", r.URL.Query().Get("namespace"))
+		assert.Equal(t, "v1", r.URL.Query().Get("api"))
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"models": published})
+	}))
+	defer server.Close()
+
+	lister, err := New(TypeHTTP, server.URL+"/models?api=v1", "legal", "")
+	require.NoError(t, err)
+	models, err := lister.List(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, published, models)
+
+	_, err = New(TypeMLflow, "", "legal", "")
+	assert.Error(t, err)
+	_, err = New("Artifactory", server.URL, "legal", "")
+	assert.Error(t, err)
+}
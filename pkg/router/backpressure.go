@@ -0,0 +1,370 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bowenislandsong/neuronetes/pkg/metrics"
+)
+
+const (
+	// DefaultStreamBufferBytes is the default buffer of each stream
+	DefaultStreamBufferBytes = 64 << 10
+
+	// DefaultStreamStallTimeout is how long a paused stream waits for its
+	// client by default
+	DefaultStreamStallTimeout = 30 * time.Second
+)
+
+// ErrStreamStalled is returned by the writes of a paused stream whose
+// client consumed nothing for the stall timeout
+var ErrStreamStalled = errors.New("stream stalled: client is not consuming")
+
+// BackpressurePolicy is what a stream does with chunks written while its
+// buffer is full
+type BackpressurePolicy string
+
+const (
+	// BackpressurePause blocks the writer until the client catches up, so
+	// no chunk is lost but generation slows down to the client's pace
+	BackpressurePause BackpressurePolicy = "pause"
+
+	// BackpressureDrop discards the chunk, so generation keeps its pace and
+	// the client misses chunks, e.g. for progress or partial results that
+	// later chunks supersede
+	BackpressureDrop BackpressurePolicy = "drop"
+)
+
+// BackpressureOptions configures a BackpressureManager
+type BackpressureOptions struct {
+	// BufferBytes bounds the bytes written to a stream but not yet
+	// consumed by its client. A chunk is always accepted into an empty
+	// buffer, so chunks larger than the buffer pass one at a time.
+	// Defaults to DefaultStreamBufferBytes.
+	BufferBytes int
+
+	// Policy applies to chunks written while the buffer is full. Defaults
+	// to BackpressurePause.
+	Policy BackpressurePolicy
+
+	// StallTimeout fails the writes of a paused stream, with
+	// ErrStreamStalled, once its client consumed nothing for that long.
+	// Defaults to DefaultStreamStallTimeout.
+	StallTimeout time.Duration
+}
+
+// StreamStats is the state of a stream being served
+type StreamStats struct {
+	// Path is the URL path of the request
+	Path string
+
+	// Buffered is the number of bytes waiting for the client
+	Buffered int
+
+	// Delivered is the number of bytes the client consumed
+	Delivered int64
+
+	// Dropped is the number of chunks dropped
+	Dropped int
+
+	// Rate is the rate at which the client consumed, in bytes per second
+	Rate float64
+
+	// Backpressured is true while the buffer is full
+	Backpressured bool
+}
+
+// BackpressureManager decouples streaming handlers of the gateway from
+// their clients. Each stream writes into a bounded buffer that is drained
+// to the client in the background; when a slow client lets the buffer fill
+// up, the stream pauses or drops chunks according to the policy. Each time
+// a stream fills up is counted in stream_backpressure_events_total and the
+// share of dropped chunks is reported as stream_drop_rate.
+type BackpressureManager struct {
+	opts    BackpressureOptions
+	metrics *metrics.AgentMetrics
+	now     func() time.Time
+
+	mu      sync.Mutex
+	streams map[*stream]struct{}
+}
+
+// NewBackpressureManager creates a manager. m may be nil.
+func NewBackpressureManager(opts BackpressureOptions, m *metrics.AgentMetrics) (*BackpressureManager, error) {
+	if opts.BufferBytes == 0 {
+		opts.BufferBytes = DefaultStreamBufferBytes
+	}
+	if opts.Policy == "" {
+		opts.Policy = BackpressurePause
+	}
+	if opts.StallTimeout == 0 {
+		opts.StallTimeout = DefaultStreamStallTimeout
+	}
+	if opts.BufferBytes < 0 {
+		return nil, fmt.Errorf("invalid stream buffer of %d bytes", opts.BufferBytes)
+	}
+	if opts.Policy != BackpressurePause && opts.Policy != BackpressureDrop {
+		return nil, fmt.Errorf("unknown backpressure policy %q", opts.Policy)
+	}
+	return &BackpressureManager{
+		opts:    opts,
+		metrics: m,
+		now:     time.Now,
+		streams: map[*stream]struct{}{},
+	}, nil
+}
+
+// Handler returns next writing its responses through a bounded stream
+// buffer. Wrap it in InstrumentHandler so that the stream metrics measure
+// what clients receive.
+func (b *BackpressureManager) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := &stream{
+			ResponseWriter: w,
+			ctx:            r.Context(),
+			manager:        b,
+			path:           r.URL.Path,
+			start:          b.now(),
+			ready:          make(chan struct{}, 1),
+			space:          make(chan struct{}, 1),
+			done:           make(chan struct{}),
+		}
+		b.mu.Lock()
+		b.streams[s] = struct{}{}
+		b.mu.Unlock()
+		defer func() {
+			b.mu.Lock()
+			delete(b.streams, s)
+			b.mu.Unlock()
+		}()
+
+		go s.pump()
+		next.ServeHTTP(s, r)
+		s.close()
+	})
+}
+
+// Streams returns the streams being served, slowest client first
+func (b *BackpressureManager) Streams() []StreamStats {
+	b.mu.Lock()
+	streams := make([]*stream, 0, len(b.streams))
+	for s := range b.streams {
+		streams = append(streams, s)
+	}
+	b.mu.Unlock()
+
+	stats := make([]StreamStats, 0, len(streams))
+	for _, s := range streams {
+		stats = append(stats, s.stats())
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Rate < stats[j].Rate })
+	return stats
+}
+
+func (b *BackpressureManager) recordBackpressure() {
+	if b.metrics != nil {
+		b.metrics.StreamBackpressure.Inc()
+	}
+}
+
+func (b *BackpressureManager) recordChunk(ctx context.Context, dropped bool) {
+	if b.metrics != nil {
+		b.metrics.RecordStreamChunk(ctx, dropped)
+	}
+}
+
+// stream is the writer handed to a streaming handler. The handler's
+// goroutine writes headers and queues chunks; pump writes the chunks to the
+// client. The client's writer is not used after the handler returns, as
+// close waits for pump.
+type stream struct {
+	http.ResponseWriter
+
+	ctx     context.Context
+	manager *BackpressureManager
+	path    string
+	start   time.Time
+
+	wroteHeader bool
+
+	mu            sync.Mutex
+	queue         [][]byte
+	buffered      int
+	delivered     int64
+	dropped       int
+	backpressured bool
+	closed        bool
+	err           error
+
+	// ready signals pump that chunks were queued or the stream closed;
+	// space signals writers that chunks were consumed
+	ready chan struct{}
+	space chan struct{}
+	done  chan struct{}
+}
+
+// WriteHeader implements http.ResponseWriter. Headers are written before
+// any chunk is queued, so they never race with pump.
+func (s *stream) WriteHeader(status int) {
+	if s.wroteHeader {
+		return
+	}
+	s.wroteHeader = true
+	s.ResponseWriter.WriteHeader(status)
+}
+
+// Write implements http.ResponseWriter. It queues p, or applies the
+// backpressure policy when the buffer is full.
+func (s *stream) Write(p []byte) (int, error) {
+	if !s.wroteHeader {
+		s.WriteHeader(http.StatusOK)
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	opts := s.manager.opts
+	for {
+		s.mu.Lock()
+		if s.err != nil {
+			err := s.err
+			s.mu.Unlock()
+			return 0, err
+		}
+		if s.buffered == 0 || s.buffered+len(p) <= opts.BufferBytes {
+			s.queue = append(s.queue, append([]byte(nil), p...))
+			s.buffered += len(p)
+			s.backpressured = false
+			s.mu.Unlock()
+			signal(s.ready)
+			s.manager.recordChunk(s.ctx, false)
+			return len(p), nil
+		}
+		if !s.backpressured {
+			s.backpressured = true
+			s.manager.recordBackpressure()
+		}
+		if opts.Policy == BackpressureDrop {
+			s.dropped++
+			s.mu.Unlock()
+			s.manager.recordChunk(s.ctx, true)
+			return len(p), nil
+		}
+		s.mu.Unlock()
+
+		timer := time.NewTimer(opts.StallTimeout)
+		select {
+		case <-s.space:
+			timer.Stop()
+		case <-s.ctx.Done():
+			timer.Stop()
+			return 0, s.ctx.Err()
+		case <-timer.C:
+			return 0, ErrStreamStalled
+		}
+	}
+}
+
+// Flush implements http.Flusher, so that handlers can stream through the
+// writer. pump flushes each chunk it writes, so there is nothing to do.
+func (s *stream) Flush() {
+	if !s.wroteHeader {
+		s.WriteHeader(http.StatusOK)
+	}
+}
+
+// pump writes queued chunks to the client until the stream is closed and
+// drained, the client goes away, or a write fails
+func (s *stream) pump() {
+	defer close(s.done)
+	flusher, _ := s.ResponseWriter.(http.Flusher)
+	for {
+		chunk, ok := s.next()
+		if !ok {
+			return
+		}
+		_, err := s.ResponseWriter.Write(chunk)
+		if err == nil && flusher != nil {
+			flusher.Flush()
+		}
+
+		s.mu.Lock()
+		s.buffered -= len(chunk)
+		if err == nil {
+			s.delivered += int64(len(chunk))
+		} else {
+			s.err = err
+		}
+		s.mu.Unlock()
+		signal(s.space)
+		if err != nil {
+			return
+		}
+	}
+}
+
+// next returns the next queued chunk, waiting for one, or false once the
+// stream is closed and drained or the client went away
+func (s *stream) next() ([]byte, bool) {
+	for {
+		s.mu.Lock()
+		if len(s.queue) > 0 {
+			chunk := s.queue[0]
+			s.queue[0] = nil
+			s.queue = s.queue[1:]
+			s.mu.Unlock()
+			return chunk, true
+		}
+		closed := s.closed
+		s.mu.Unlock()
+		if closed {
+			return nil, false
+		}
+
+		select {
+		case <-s.ready:
+		case <-s.ctx.Done():
+			return nil, false
+		}
+	}
+}
+
+// close waits for pump to deliver the chunks still queued
+func (s *stream) close() {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+	signal(s.ready)
+	<-s.done
+}
+
+func (s *stream) stats() StreamStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var rate float64
+	if elapsed := s.manager.now().Sub(s.start).Seconds(); elapsed > 0 {
+		rate = float64(s.delivered) / elapsed
+	}
+	return StreamStats{
+		Path:          s.path,
+		Buffered:      s.buffered,
+		Delivered:     s.delivered,
+		Dropped:       s.dropped,
+		Rate:          rate,
+		Backpressured: s.backpressured,
+	}
+}
+
+// signal wakes up the receiver of c without blocking
+func signal(c chan struct{}) {
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,121 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/bowenislandsong/neuronetes/pkg/metrics"
+)
+
+// slowClient is a response writer whose writes block until released
+type slowClient struct {
+	*httptest.ResponseRecorder
+	release chan struct{}
+}
+
+func (c *slowClient) Write(p []byte) (int, error) {
+	<-c.release
+	return c.ResponseRecorder.Write(p)
+}
+
+func serveStream(t *testing.T, b *BackpressureManager, client http.ResponseWriter, handler http.HandlerFunc) chan struct{} {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		b.Handler(handler).ServeHTTP(client, httptest.NewRequest(http.MethodGet, "/v1/chat", nil))
+	}()
+	return done
+}
+
+func TestBackpressurePausesWriter(t *testing.T) {
+	m := metrics.NewAgentMetrics(prometheus.NewRegistry())
+	b, err := NewBackpressureManager(BackpressureOptions{BufferBytes: 10}, m)
+	require.NoError(t, err)
+	client := &slowClient{ResponseRecorder: httptest.NewRecorder(), release: make(chan struct{})}
+
+	written := make(chan error, 3)
+	done := serveStream(t, b, client, func(w http.ResponseWriter, r *http.Request) {
+		for _, chunk := range []string{"aaaaaaaa", "bbbbbbbb", "cc"} {
+			_, err := w.Write([]byte(chunk))
+			written <- err
+		}
+	})
+
+	// The first chunk fills the buffer while the client is not reading
+	require.NoError(t, <-written)
+	select {
+	case <-written:
+		t.Fatal("writer was not paused")
+	case <-time.After(50 * time.Millisecond):
+	}
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.StreamBackpressure))
+	streams := b.Streams()
+	require.Len(t, streams, 1)
+	assert.Equal(t, "/v1/chat", streams[0].Path)
+	assert.Equal(t, 8, streams[0].Buffered)
+	assert.True(t, streams[0].Backpressured)
+
+	close(client.release)
+	require.NoError(t, <-written)
+	require.NoError(t, <-written)
+	<-done
+	assert.Equal(t, "aaaaaaaabbbbbbbbcc", client.Body.String())
+	assert.Equal(t, 0.0, testutil.ToFloat64(m.StreamDropRate))
+	assert.Empty(t, b.Streams())
+}
+
+func TestBackpressureDropsChunks(t *testing.T) {
+	m := metrics.NewAgentMetrics(prometheus.NewRegistry())
+	b, err := NewBackpressureManager(BackpressureOptions{BufferBytes: 10, Policy: BackpressureDrop}, m)
+	require.NoError(t, err)
+	client := &slowClient{ResponseRecorder: httptest.NewRecorder(), release: make(chan struct{})}
+
+	done := serveStream(t, b, client, func(w http.ResponseWriter, r *http.Request) {
+		for _, chunk := range []string{"aaaaaaaa", "bbbbbbbb", "cccccccc"} {
+			_, err := w.Write([]byte(chunk))
+			assert.NoError(t, err)
+		}
+		close(client.release)
+	})
+	<-done
+
+	assert.Equal(t, "aaaaaaaa", client.Body.String())
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.StreamBackpressure))
+	assert.InDelta(t, 2.0/3, testutil.ToFloat64(m.StreamDropRate), 1e-9)
+}
+
+func TestBackpressureFailsStalledStreams(t *testing.T) {
+	b, err := NewBackpressureManager(BackpressureOptions{BufferBytes: 4, StallTimeout: 20 * time.Millisecond}, nil)
+	require.NoError(t, err)
+	client := &slowClient{ResponseRecorder: httptest.NewRecorder(), release: make(chan struct{})}
+
+	done := serveStream(t, b, client, func(w http.ResponseWriter, r *http.Request) {
+		_, err := w.Write([]byte("aaaa"))
+		assert.NoError(t, err)
+		_, err = w.Write([]byte("bbbb"))
+		assert.ErrorIs(t, err, ErrStreamStalled)
+		close(client.release)
+	})
+	<-done
+	assert.Equal(t, "aaaa", client.Body.String())
+}
+
+func TestNewBackpressureManagerValidates(t *testing.T) {
+	_, err := NewBackpressureManager(BackpressureOptions{Policy: "block"}, nil)
+	assert.Error(t, err)
+	_, err = NewBackpressureManager(BackpressureOptions{BufferBytes: -1}, nil)
+	assert.Error(t, err)
+
+	b, err := NewBackpressureManager(BackpressureOptions{}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, DefaultStreamBufferBytes, b.opts.BufferBytes)
+	assert.Equal(t, BackpressurePause, b.opts.Policy)
+}
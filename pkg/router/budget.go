@@ -0,0 +1,157 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/metrics"
+)
+
+// ErrBudgetExhausted is returned when admitting a request would exceed the
+// pool's tokens-per-second budget
+var ErrBudgetExhausted = errors.New("tokens-per-second budget exhausted")
+
+// TokenBudget admits requests while a pool's throughput stays within its
+// tokens-per-second budget. It is a token bucket refilled at the budget
+// rate, holding at most one second of budget, so short bursts are absorbed
+// but sustained overload is not.
+type TokenBudget struct {
+	mu      sync.Mutex
+	rate    float64
+	tokens  float64
+	last    time.Time
+	now     func() time.Time
+	metrics *metrics.AgentMetrics
+}
+
+// NewTokenBudget creates a budget of tokensPerSecond. m may be nil.
+func NewTokenBudget(tokensPerSecond int32, m *metrics.AgentMetrics) *TokenBudget {
+	b := &TokenBudget{
+		rate:    float64(tokensPerSecond),
+		tokens:  float64(tokensPerSecond),
+		now:     time.Now,
+		metrics: m,
+	}
+	b.last = b.now()
+	return b
+}
+
+// BudgetForPool returns the budget configured on pool, or nil if it has none
+func BudgetForPool(pool *neuronetes.AgentPool, m *metrics.AgentMetrics) *TokenBudget {
+	if pool.Spec.TokensPerSecondBudget == nil {
+		return nil
+	}
+	return NewTokenBudget(*pool.Spec.TokensPerSecondBudget, m)
+}
+
+// Admit charges tokens, the request's expected token count, against the
+// budget, or returns ErrBudgetExhausted without charging anything
+func (b *TokenBudget) Admit(tokens int) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, err := b.reserve(float64(tokens), false); err != nil {
+		b.recordReject()
+		return err
+	}
+	return nil
+}
+
+// Wait queues until tokens can be charged against the budget, ctx is done,
+// or the request is larger than the budget can ever admit
+func (b *TokenBudget) Wait(ctx context.Context, tokens int) error {
+	for {
+		b.mu.Lock()
+		wait, err := b.reserve(float64(tokens), true)
+		b.mu.Unlock()
+		if err != nil {
+			b.recordReject()
+			return err
+		}
+		if wait == 0 {
+			return nil
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			b.recordReject()
+			return fmt.Errorf("%w: %v", ErrBudgetExhausted, ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
+
+// Settle trues up a request admitted with charged tokens once it reports
+// the used tokens it consumed. Unused tokens are returned to the budget and
+// tokens beyond the charge are taken from it, leaving it in debt until it
+// refills if need be.
+func (b *TokenBudget) Settle(charged, used int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill()
+	b.tokens += float64(charged - used)
+	if b.tokens > b.rate {
+		b.tokens = b.rate
+	}
+}
+
+// Rate returns the tokens per second of the budget, which is also the
+// most a request can be charged at once
+func (b *TokenBudget) Rate() int {
+	return int(b.rate)
+}
+
+// Available returns the tokens that can be admitted right now, negative
+// while the budget is in debt
+func (b *TokenBudget) Available() float64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill()
+	return b.tokens
+}
+
+// reserve charges tokens if available. Otherwise it returns how long until
+// they will be, when queueing, or ErrBudgetExhausted. Callers must hold mu.
+func (b *TokenBudget) reserve(tokens float64, queue bool) (time.Duration, error) {
+	if tokens > b.rate {
+		return 0, fmt.Errorf("%w: request of %.0f tokens exceeds budget of %.0f tokens/s", ErrBudgetExhausted, tokens, b.rate)
+	}
+
+	b.refill()
+	if b.tokens >= tokens {
+		b.tokens -= tokens
+		return 0, nil
+	}
+	if !queue {
+		return 0, ErrBudgetExhausted
+	}
+	wait := time.Duration((tokens - b.tokens) / b.rate * float64(time.Second))
+	if wait < time.Millisecond {
+		wait = time.Millisecond
+	}
+	return wait, nil
+}
+
+// refill adds the budget accrued since the last refill. Callers must hold mu.
+func (b *TokenBudget) refill() {
+	now := b.now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.rate {
+		b.tokens = b.rate
+	}
+	b.last = now
+}
+
+func (b *TokenBudget) recordReject() {
+	if b.metrics != nil {
+		b.metrics.AdmissionRejects.Inc()
+	}
+}
@@ -0,0 +1,96 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/metrics"
+)
+
+func TestTokenBudgetAdmitsWithinBudget(t *testing.T) {
+	m := metrics.NewAgentMetrics(prometheus.NewRegistry())
+	b := NewTokenBudget(1000, m)
+	now := time.Unix(1700000000, 0)
+	b.now = func() time.Time { return now }
+	b.last = now
+
+	require.NoError(t, b.Admit(600))
+	require.NoError(t, b.Admit(400))
+	assert.ErrorIs(t, b.Admit(1), ErrBudgetExhausted)
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.AdmissionRejects))
+
+	// Half a second refills half the budget
+	now = now.Add(500 * time.Millisecond)
+	assert.InDelta(t, 500, b.Available(), 1e-6)
+	require.NoError(t, b.Admit(500))
+
+	// Idle time never accrues more than one second of budget
+	now = now.Add(time.Minute)
+	assert.InDelta(t, 1000, b.Available(), 1e-6)
+
+	// Requests larger than the budget can never be admitted
+	assert.ErrorIs(t, b.Admit(1001), ErrBudgetExhausted)
+}
+
+func TestTokenBudgetSettlesUsage(t *testing.T) {
+	b := NewTokenBudget(1000, nil)
+	now := time.Unix(1700000000, 0)
+	b.now = func() time.Time { return now }
+	b.last = now
+
+	// Unused tokens are returned, up to the budget
+	require.NoError(t, b.Admit(600))
+	b.Settle(600, 100)
+	assert.InDelta(t, 900, b.Available(), 1e-6)
+	b.Settle(600, 0)
+	assert.InDelta(t, 1000, b.Available(), 1e-6)
+
+	// Tokens beyond the charge leave the budget in debt until it refills
+	require.NoError(t, b.Admit(800))
+	b.Settle(800, 1500)
+	assert.InDelta(t, -500, b.Available(), 1e-6)
+	assert.ErrorIs(t, b.Admit(1), ErrBudgetExhausted)
+	now = now.Add(600 * time.Millisecond)
+	assert.InDelta(t, 100, b.Available(), 1e-6)
+	require.NoError(t, b.Admit(100))
+}
+
+func TestTokenBudgetWaitQueuesUntilRefilled(t *testing.T) {
+	b := NewTokenBudget(1000, nil)
+	require.NoError(t, b.Admit(1000))
+
+	start := time.Now()
+	require.NoError(t, b.Wait(context.Background(), 50))
+	assert.GreaterOrEqual(t, time.Since(start), 40*time.Millisecond)
+}
+
+func TestTokenBudgetWaitHonorsContext(t *testing.T) {
+	m := metrics.NewAgentMetrics(prometheus.NewRegistry())
+	b := NewTokenBudget(10, m)
+	require.NoError(t, b.Admit(10))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := b.Wait(ctx, 10)
+	assert.True(t, errors.Is(err, ErrBudgetExhausted))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.AdmissionRejects))
+}
+
+func TestBudgetForPool(t *testing.T) {
+	pool := &neuronetes.AgentPool{}
+	assert.Nil(t, BudgetForPool(pool, nil))
+
+	budget := int32(200)
+	pool.Spec.TokensPerSecondBudget = &budget
+	b := BudgetForPool(pool, nil)
+	require.NotNil(t, b)
+	assert.InDelta(t, 200, b.Available(), 1)
+}
@@ -0,0 +1,313 @@
+// Package router selects the replica of an AgentPool that serves a new
+// request. Admission consults it so that replicas reporting backpressure
+// stop receiving new work until they recover.
+package router
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/metrics"
+)
+
+var (
+	// ErrNoReplicas is returned when the pool has no ready replicas
+	ErrNoReplicas = errors.New("no ready replicas")
+
+	// ErrAllBackpressured is returned when every ready replica is backpressured
+	ErrAllBackpressured = errors.New("all replicas backpressured")
+)
+
+// Replica is a routable replica of an AgentPool
+type Replica struct {
+	Name          string
+	Address       string
+	Ready         bool
+	Backpressured bool
+
+	// Draining replicas keep their sessions but receive no new ones
+	Draining bool
+}
+
+// Router routes requests across the replicas of one AgentPool. Requests
+// carrying a session key stick to the replica that served the session first.
+type Router struct {
+	mu       sync.Mutex
+	replicas map[string]*Replica
+	sessions map[string]string
+	next     int
+	metrics  *metrics.AgentMetrics
+}
+
+// NewRouter creates a router. m may be nil.
+func NewRouter(m *metrics.AgentMetrics) *Router {
+	return &Router{
+		replicas: make(map[string]*Replica),
+		sessions: make(map[string]string),
+		metrics:  m,
+	}
+}
+
+// UpdateReplica adds or replaces a replica
+func (r *Router) UpdateReplica(replica Replica) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	prev, ok := r.replicas[replica.Name]
+	if replica.Backpressured && (!ok || !prev.Backpressured) {
+		r.recordBackpressure()
+	}
+
+	rep := replica
+	r.replicas[replica.Name] = &rep
+}
+
+// RemoveReplica removes a replica from routing along with its sessions
+func (r *Router) RemoveReplica(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.replicas, name)
+	for key, replica := range r.sessions {
+		if replica == name {
+			delete(r.sessions, key)
+		}
+	}
+}
+
+// SetDraining stops or resumes routing new sessions to a replica
+func (r *Router) SetDraining(name string, draining bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if rep, ok := r.replicas[name]; ok {
+		rep.Draining = draining
+	}
+}
+
+// SetBackpressure marks a replica as backpressured or recovered
+func (r *Router) SetBackpressure(name string, backpressured bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rep, ok := r.replicas[name]
+	if !ok {
+		return
+	}
+	if !rep.Backpressured && backpressured {
+		r.recordBackpressure()
+	}
+	rep.Backpressured = backpressured
+}
+
+// Pick returns the next replica for a new request, round-robin across
+// ready replicas that are neither backpressured nor draining
+func (r *Router) Pick() (*Replica, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.pick()
+}
+
+// Route returns the replica for a request in the given session. The first
+// request of a session picks a replica; later requests stick to it while it
+// stays ready, and are counted as session affinity hits or, when rebound,
+// misses. An empty session key behaves like Pick.
+func (r *Router) Route(sessionKey string) (*Replica, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if sessionKey != "" {
+		if name, ok := r.sessions[sessionKey]; ok {
+			rep, ok := r.replicas[name]
+			hit := ok && rep.Ready
+			r.recordSessionRoute(hit)
+			if hit {
+				out := *rep
+				return &out, nil
+			}
+		}
+	}
+
+	rep, err := r.pick()
+	if err != nil {
+		return nil, err
+	}
+	if sessionKey != "" {
+		r.sessions[sessionKey] = rep.Name
+	}
+	return rep, nil
+}
+
+// EndSession releases the affinity of a session
+func (r *Router) EndSession(sessionKey string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.sessions, sessionKey)
+}
+
+// ActiveSessions returns the number of sticky sessions bound to each replica
+func (r *Router) ActiveSessions() map[string]int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	counts := make(map[string]int, len(r.replicas))
+	for name := range r.replicas {
+		counts[name] = 0
+	}
+	for _, name := range r.sessions {
+		counts[name]++
+	}
+	return counts
+}
+
+// MigrateSessions rebinds every session on the named replica to other
+// routable replicas and returns how many sessions moved. The replica should
+// be draining first so that sessions are not rebound to it.
+func (r *Router) MigrateSessions(name string) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	moved := 0
+	for key, replica := range r.sessions {
+		if replica != name {
+			continue
+		}
+		rep, err := r.pick()
+		if err != nil {
+			return moved, err
+		}
+		if rep.Name == name {
+			return moved, fmt.Errorf("replica %s must be draining before migration", name)
+		}
+		r.sessions[key] = rep.Name
+		moved++
+	}
+	return moved, nil
+}
+
+// pick returns the next routable replica. Callers must hold mu.
+func (r *Router) pick() (*Replica, error) {
+	candidates, ready := r.routable()
+	if ready == 0 {
+		r.recordReject()
+		return nil, ErrNoReplicas
+	}
+	if len(candidates) == 0 {
+		r.recordReject()
+		return nil, ErrAllBackpressured
+	}
+
+	rep := *candidates[r.next%len(candidates)]
+	r.next++
+	return &rep, nil
+}
+
+// Backpressure returns the number of backpressured and total ready replicas
+func (r *Router) Backpressure() (backpressured, ready int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	candidates, ready := r.routable()
+	return ready - len(candidates), ready
+}
+
+// routable returns ready replicas that are neither backpressured nor
+// draining, in name order, along with the number of ready, non-draining
+// replicas. Callers must hold mu.
+func (r *Router) routable() ([]*Replica, int) {
+	names := make([]string, 0, len(r.replicas))
+	for name := range r.replicas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var candidates []*Replica
+	ready := 0
+	for _, name := range names {
+		rep := r.replicas[name]
+		if !rep.Ready || rep.Draining {
+			continue
+		}
+		ready++
+		if !rep.Backpressured {
+			candidates = append(candidates, rep)
+		}
+	}
+	return candidates, ready
+}
+
+func (r *Router) recordBackpressure() {
+	if r.metrics != nil {
+		r.metrics.StreamBackpressure.Inc()
+	}
+}
+
+func (r *Router) recordSessionRoute(hit bool) {
+	if r.metrics != nil {
+		r.metrics.RecordSessionRoute(context.Background(), hit)
+	}
+}
+
+func (r *Router) recordReject() {
+	if r.metrics != nil {
+		r.metrics.AdmissionRejects.Inc()
+	}
+}
+
+// ReplicaFromPod builds a Replica from a pool pod, reading readiness from
+// the pod conditions and backpressure from the pod annotation
+func ReplicaFromPod(pod *corev1.Pod) Replica {
+	return Replica{
+		Name:    pod.Name,
+		Address: pod.Status.PodIP,
+		// Warm replicas stay out of routing until they are activated
+		Ready:         isPodReady(pod) && pod.Labels[neuronetes.LabelRole] != neuronetes.RoleWarm,
+		Backpressured: pod.Annotations[neuronetes.AnnotationBackpressure] == "true",
+		// Draining replicas finish their sessions before scale-down removes them
+		Draining: pod.Labels[neuronetes.LabelRole] == neuronetes.RoleDraining,
+	}
+}
+
+func isPodReady(pod *corev1.Pod) bool {
+	if pod.DeletionTimestamp != nil || pod.Status.Phase != corev1.PodRunning {
+		return false
+	}
+	for _, c := range pod.Status.Conditions {
+		if c.Type == corev1.PodReady {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// PodBackpressureReporter reports pool backpressure from pod annotations
+type PodBackpressureReporter struct {
+	Client kubernetes.Interface
+}
+
+// Backpressure returns the number of backpressured and total ready replicas of pool
+func (p *PodBackpressureReporter) Backpressure(ctx context.Context, pool *neuronetes.AgentPool) (int, int, error) {
+	selector := labels.SelectorFromSet(labels.Set{neuronetes.LabelPool: pool.Name})
+	pods, err := p.Client.CoreV1().Pods(pool.Namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: selector.String(),
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	r := NewRouter(nil)
+	for i := range pods.Items {
+		r.UpdateReplica(ReplicaFromPod(&pods.Items[i]))
+	}
+	backpressured, ready := r.Backpressure()
+	return backpressured, ready, nil
+}
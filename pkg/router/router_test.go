@@ -0,0 +1,210 @@
+package router
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/metrics"
+)
+
+func TestRouterExcludesBackpressuredReplica(t *testing.T) {
+	m := metrics.NewAgentMetrics(prometheus.NewRegistry())
+	r := NewRouter(m)
+	r.UpdateReplica(Replica{Name: "replica-0", Ready: true})
+	r.UpdateReplica(Replica{Name: "replica-1", Ready: true})
+	r.UpdateReplica(Replica{Name: "replica-2", Ready: true})
+
+	r.SetBackpressure("replica-1", true)
+
+	for i := 0; i < 10; i++ {
+		rep, err := r.Pick()
+		require.NoError(t, err)
+		assert.NotEqual(t, "replica-1", rep.Name)
+	}
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.StreamBackpressure))
+
+	// Recovery puts the replica back into rotation
+	r.SetBackpressure("replica-1", false)
+	seen := map[string]bool{}
+	for i := 0; i < 3; i++ {
+		rep, err := r.Pick()
+		require.NoError(t, err)
+		seen[rep.Name] = true
+	}
+	assert.True(t, seen["replica-1"])
+}
+
+func TestRouterAllBackpressured(t *testing.T) {
+	m := metrics.NewAgentMetrics(prometheus.NewRegistry())
+	r := NewRouter(m)
+	r.UpdateReplica(Replica{Name: "replica-0", Ready: true, Backpressured: true})
+	r.UpdateReplica(Replica{Name: "replica-1", Ready: true, Backpressured: true})
+	r.UpdateReplica(Replica{Name: "replica-2", Ready: false})
+
+	_, err := r.Pick()
+	assert.ErrorIs(t, err, ErrAllBackpressured)
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.AdmissionRejects))
+
+	backpressured, ready := r.Backpressure()
+	assert.Equal(t, 2, backpressured)
+	assert.Equal(t, 2, ready)
+}
+
+func TestRouterNoReplicas(t *testing.T) {
+	r := NewRouter(nil)
+	_, err := r.Pick()
+	assert.ErrorIs(t, err, ErrNoReplicas)
+}
+
+func readyPod(name string, annotations map[string]string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   "default",
+			Labels:      map[string]string{neuronetes.LabelPool: "chat-pool"},
+			Annotations: annotations,
+		},
+		Status: corev1.PodStatus{
+			Phase: corev1.PodRunning,
+			PodIP: "10.0.0.1",
+			Conditions: []corev1.PodCondition{
+				{Type: corev1.PodReady, Status: corev1.ConditionTrue},
+			},
+		},
+	}
+}
+
+func TestPodBackpressureReporter(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		readyPod("chat-0", map[string]string{neuronetes.AnnotationBackpressure: "true"}),
+		readyPod("chat-1", nil),
+	)
+	reporter := &PodBackpressureReporter{Client: client}
+	pool := &neuronetes.AgentPool{ObjectMeta: metav1.ObjectMeta{Name: "chat-pool", Namespace: "default"}}
+
+	backpressured, ready, err := reporter.Backpressure(context.Background(), pool)
+	require.NoError(t, err)
+	assert.Equal(t, 1, backpressured)
+	assert.Equal(t, 2, ready)
+
+	rep := ReplicaFromPod(readyPod("chat-0", map[string]string{neuronetes.AnnotationBackpressure: "true"}))
+	assert.True(t, rep.Ready)
+	assert.True(t, rep.Backpressured)
+	assert.Equal(t, "10.0.0.1", rep.Address)
+}
+
+func TestRouteStickySessions(t *testing.T) {
+	r := NewRouter(nil)
+	r.UpdateReplica(Replica{Name: "agent-a", Ready: true})
+	r.UpdateReplica(Replica{Name: "agent-b", Ready: true})
+
+	first, err := r.Route("session-1")
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		rep, err := r.Route("session-1")
+		require.NoError(t, err)
+		assert.Equal(t, first.Name, rep.Name)
+	}
+	assert.Equal(t, 1, r.ActiveSessions()[first.Name])
+
+	// A draining replica keeps existing sessions but takes no new ones
+	r.SetDraining(first.Name, true)
+	rep, err := r.Route("session-1")
+	require.NoError(t, err)
+	assert.Equal(t, first.Name, rep.Name)
+	rep, err = r.Route("session-2")
+	require.NoError(t, err)
+	assert.NotEqual(t, first.Name, rep.Name)
+
+	moved, err := r.MigrateSessions(first.Name)
+	require.NoError(t, err)
+	assert.Equal(t, 1, moved)
+	assert.Equal(t, 0, r.ActiveSessions()[first.Name])
+
+	r.EndSession("session-1")
+	r.EndSession("session-2")
+	for _, n := range r.ActiveSessions() {
+		assert.Zero(t, n)
+	}
+}
+
+func TestRouteRecordsSessionAffinityHits(t *testing.T) {
+	m := metrics.NewAgentMetrics(prometheus.NewRegistry())
+	r := NewRouter(m)
+	r.UpdateReplica(Replica{Name: "agent-a", Ready: true})
+	r.UpdateReplica(Replica{Name: "agent-b", Ready: true})
+
+	// The first request of a session neither hits nor misses
+	first, err := r.Route("session-1")
+	require.NoError(t, err)
+	assert.Zero(t, testutil.ToFloat64(m.SessionAffinityHitRate))
+	for i := 0; i < 3; i++ {
+		_, err := r.Route("session-1")
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.SessionAffinityHitRate))
+
+	// A session whose replica is not ready is rebound, a miss
+	r.UpdateReplica(Replica{Name: first.Name, Ready: false})
+	_, err = r.Route("session-1")
+	require.NoError(t, err)
+	assert.InDelta(t, 0.75, testutil.ToFloat64(m.SessionAffinityHitRate), 1e-9)
+}
+
+func TestMigrateSessionsRequiresDraining(t *testing.T) {
+	r := NewRouter(nil)
+	r.UpdateReplica(Replica{Name: "agent-a", Ready: true})
+
+	_, err := r.Route("session-1")
+	require.NoError(t, err)
+
+	_, err = r.MigrateSessions("agent-a")
+	assert.Error(t, err)
+
+	r.SetDraining("agent-a", true)
+	_, err = r.MigrateSessions("agent-a")
+	assert.ErrorIs(t, err, ErrNoReplicas)
+}
+
+func TestReplicaFromPodExcludesWarmReplicas(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "agent-a",
+			Labels: map[string]string{neuronetes.LabelRole: neuronetes.RoleWarm},
+		},
+		Status: corev1.PodStatus{
+			Phase:      corev1.PodRunning,
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}},
+		},
+	}
+	assert.False(t, ReplicaFromPod(pod).Ready)
+
+	pod.Labels[neuronetes.LabelRole] = neuronetes.RoleServing
+	assert.True(t, ReplicaFromPod(pod).Ready)
+}
+
+func TestReplicaFromPodMarksDrainingReplicas(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "agent-a",
+			Labels: map[string]string{neuronetes.LabelRole: neuronetes.RoleDraining},
+		},
+		Status: corev1.PodStatus{
+			Phase:      corev1.PodRunning,
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}},
+		},
+	}
+	replica := ReplicaFromPod(pod)
+	// Draining replicas keep their sticky sessions but take no new ones
+	assert.True(t, replica.Ready)
+	assert.True(t, replica.Draining)
+}
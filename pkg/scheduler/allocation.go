@@ -0,0 +1,108 @@
+package scheduler
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/gpu"
+)
+
+// nodeNameIndex indexes pods by the node they are bound to
+const nodeNameIndex = "spec.nodeName"
+
+// indexByNodeName returns the node of a pod for nodeNameIndex
+func indexByNodeName(obj interface{}) ([]string, error) {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok || pod.Spec.NodeName == "" {
+		return nil, nil
+	}
+	return []string{pod.Spec.NodeName}, nil
+}
+
+// nodeAllocation is the GPU resources held by the active pods on a node
+type nodeAllocation struct {
+	// mig is the MIG slices requested
+	mig migSlices
+
+	// vram is the estimated VRAM footprint of pods that declare one, in
+	// bytes
+	vram int64
+
+	// gpus is the whole GPUs requested by pods without a footprint, which
+	// hold all of their memory
+	gpus int64
+
+	// requested is the whole GPUs requested by all pods
+	requested int64
+
+	// claims is the ResourceClaims shared by several pods, whose devices
+	// are counted with the first of them
+	claims map[string]bool
+
+	// pools counts the replicas of each AgentPool, by namespace/name
+	pools map[string]int
+}
+
+// add counts the resources of pod
+func (a *nodeAllocation) add(pod *corev1.Pod) {
+	if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+		return
+	}
+	if a.mig == nil {
+		a.mig = migSlices{}
+	}
+	counted := false
+	if claim := sharedClaim(pod); claim != "" {
+		if a.claims == nil {
+			a.claims = map[string]bool{}
+		}
+		counted = a.claims[claim]
+		a.claims[claim] = true
+	}
+	if !counted {
+		a.mig.add(podMIGSlices(pod))
+		a.requested += gpu.PodGPUs(pod)
+	}
+	if pool := pod.Labels[neuronetes.LabelPool]; pool != "" {
+		if a.pools == nil {
+			a.pools = map[string]int{}
+		}
+		a.pools[pod.Namespace+"/"+pool]++
+	}
+	if footprint, ok := podVRAMFootprint(pod); ok {
+		a.vram += footprint
+	} else if !counted {
+		a.gpus += gpu.PodGPUs(pod)
+	}
+}
+
+// allocationOf returns the resources of the pods on a node of the
+// scheduler's snapshot
+func allocationOf(nodeInfo *framework.NodeInfo) nodeAllocation {
+	var allocated nodeAllocation
+	for _, podInfo := range nodeInfo.Pods {
+		allocated.add(podInfo.Pod)
+	}
+	return allocated
+}
+
+// allocation returns the resources held on each of nodes, looking up only
+// the pods on them
+func (s *GPUTopologyScheduler) allocation(nodes []*corev1.Node) (map[string]nodeAllocation, error) {
+	allocation := make(map[string]nodeAllocation, len(nodes))
+	for _, node := range nodes {
+		objs, err := s.podsByNode.ByIndex(nodeNameIndex, node.Name)
+		if err != nil {
+			return nil, err
+		}
+		var allocated nodeAllocation
+		for _, obj := range objs {
+			if pod, ok := obj.(*corev1.Pod); ok {
+				allocated.add(pod)
+			}
+		}
+		allocation[node.Name] = allocated
+	}
+	return allocation, nil
+}
@@ -0,0 +1,109 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/pricing"
+)
+
+// referenceGPUHourPrice is the price in USD of a GPU-hour that scores 0.5
+// for pools without a budget, about the on-demand price of an A100
+const referenceGPUHourPrice = 2.0
+
+// SetPricing scores nodes on the price of their GPUs and filters out nodes
+// over a pool's maxCostPerHour. Without a provider, nodes are scored on
+// whether they are spot instances.
+func (s *GPUTopologyScheduler) SetPricing(provider pricing.Provider) {
+	s.pricing = provider
+}
+
+// replicaGPUs is the number of GPUs a replica of the pool is billed for
+func replicaGPUs(agentPool *neuronetes.AgentPool) float64 {
+	if agentPool.Spec.GPURequirements == nil || agentPool.Spec.GPURequirements.Count < 1 {
+		return 1
+	}
+	return float64(agentPool.Spec.GPURequirements.Count)
+}
+
+// gpuHourPrice returns the price of a GPU-hour on node, and false if there
+// is no provider or it has no price for the node
+func (s *GPUTopologyScheduler) gpuHourPrice(ctx context.Context, node *corev1.Node) (float64, bool) {
+	if s.pricing == nil {
+		return 0, false
+	}
+	price, err := s.pricing.GPUHourPrice(ctx, pricing.InstanceOf(node))
+	if err != nil {
+		if !errors.Is(err, pricing.ErrNoPrice) {
+			log.FromContext(ctx).V(4).Info("scoring node without GPU price", "node", node.Name, "error", err.Error())
+		}
+		return 0, false
+	}
+	return price, true
+}
+
+// maxCostPerHour returns the pool's budget for a replica, and false if it
+// has none
+func maxCostPerHour(agentPool *neuronetes.AgentPool) (float64, bool) {
+	if agentPool.Spec.Scheduling == nil || agentPool.Spec.Scheduling.CostOptimization == nil {
+		return 0, false
+	}
+	cost := agentPool.Spec.Scheduling.CostOptimization
+	if !cost.Enabled || cost.MaxCostPerHour == nil {
+		return 0, false
+	}
+	return float64(*cost.MaxCostPerHour), true
+}
+
+// withinBudget reports whether a replica of the pool on node costs no more
+// than the pool's maxCostPerHour. Nodes without a known price pass.
+func (s *GPUTopologyScheduler) withinBudget(ctx context.Context, node *corev1.Node, agentPool *neuronetes.AgentPool) bool {
+	budget, ok := maxCostPerHour(agentPool)
+	if !ok {
+		return true
+	}
+	price, ok := s.gpuHourPrice(ctx, node)
+	if !ok {
+		return true
+	}
+	return price*replicaGPUs(agentPool) <= budget
+}
+
+// scoreCostEfficiency scores the price of node's GPUs, falling from 1.0 for
+// free GPUs to 0.5 at half the pool's budget per GPU, or at
+// referenceGPUHourPrice without a budget. Nodes without a known price are
+// scored on whether they are spot instances.
+func (s *GPUTopologyScheduler) scoreCostEfficiency(ctx context.Context, node *corev1.Node, agentPool *neuronetes.AgentPool) float64 {
+	// Score based on cost
+	if agentPool.Spec.Scheduling == nil || agentPool.Spec.Scheduling.CostOptimization == nil {
+		return 0.5
+	}
+
+	if price, ok := s.gpuHourPrice(ctx, node); ok {
+		reference := referenceGPUHourPrice
+		if budget, ok := maxCostPerHour(agentPool); ok && budget > 0 {
+			reference = budget / replicaGPUs(agentPool) / 2
+		}
+		return reference / (reference + price)
+	}
+
+	// Check if spot instance
+	_, ok := node.Labels["node.kubernetes.io/instance-type"]
+	if !ok {
+		return 0.5
+	}
+
+	// Prefer spot if enabled
+	if agentPool.Spec.Scheduling.CostOptimization.SpotEnabled {
+		if pricing.InstanceOf(node).Spot {
+			return 1.0
+		}
+		return 0.6
+	}
+
+	return 0.7
+}
@@ -0,0 +1,81 @@
+package scheduler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/pricing"
+)
+
+func float32Ptr(v float32) *float32 { return &v }
+
+// instanceNode returns a node with 8 GPUs of an instance type
+func instanceNode(name, instanceType string) *corev1.Node {
+	node := gpuNode(name, 8)
+	node.Labels[corev1.LabelInstanceTypeStable] = instanceType
+	return node
+}
+
+func TestScheduleOnGPUPrice(t *testing.T) {
+	ctx := context.Background()
+	cheap := instanceNode("node-cheap", "p4d.24xlarge")
+	pricey := instanceNode("node-pricey", "p5.48xlarge")
+	overBudget := instanceNode("node-over-budget", "p5e.48xlarge")
+	unpriced := instanceNode("node-unpriced", "custom.metal")
+	s := newTestScheduler(t, &SchedulerConfig{CostWeight: 1}, cheap, pricey, overBudget, unpriced)
+	s.SetPricing(pricing.Static{
+		"p4d.24xlarge": {OnDemand: 16},
+		"p5.48xlarge":  {OnDemand: 32},
+		"p5e.48xlarge": {OnDemand: 64},
+	})
+
+	pool := testPool("llm-pool", "llm-agent")
+	pool.Spec.GPURequirements.Count = 2
+	pool.Spec.Scheduling = &neuronetes.SchedulingConfig{
+		CostOptimization: &neuronetes.CostOptimizationConfig{Enabled: true, MaxCostPerHour: float32Ptr(10)},
+	}
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "llm-0", Namespace: "default"}}
+
+	// A replica costs 2 GPUs at $2, $4 and $8 per GPU-hour; nodes without a
+	// price are not filtered
+	assert.True(t, s.withinBudget(ctx, cheap, pool))
+	assert.True(t, s.withinBudget(ctx, pricey, pool))
+	assert.False(t, s.withinBudget(ctx, overBudget, pool))
+	assert.True(t, s.withinBudget(ctx, unpriced, pool))
+
+	// Half the budget per GPU, $2.5, scores 0.5
+	assert.InDelta(t, 2.5/4.5, s.scoreCostEfficiency(ctx, cheap, pool), 1e-9)
+	assert.InDelta(t, 2.5/6.5, s.scoreCostEfficiency(ctx, pricey, pool), 1e-9)
+
+	result, err := s.Schedule(ctx, pod, pool)
+	require.NoError(t, err)
+	assert.NotEqual(t, overBudget.Name, result.Node)
+
+	// Without a budget, prices are scored against the reference price
+	pool.Spec.Scheduling.CostOptimization.MaxCostPerHour = nil
+	assert.True(t, s.withinBudget(ctx, overBudget, pool))
+	assert.InDelta(t, 0.5, s.scoreCostEfficiency(ctx, cheap, pool), 1e-9)
+	assert.InDelta(t, 0.2, s.scoreCostEfficiency(ctx, overBudget, pool), 1e-9)
+}
+
+func TestScoreCostEfficiencyWithoutPrices(t *testing.T) {
+	ctx := context.Background()
+	spot := instanceNode("node-spot", "p4d.24xlarge")
+	spot.Labels["eks.amazonaws.com/capacityType"] = "SPOT"
+	onDemand := instanceNode("node-on-demand", "p4d.24xlarge")
+	s := newTestScheduler(t, &SchedulerConfig{CostWeight: 1}, spot, onDemand)
+
+	pool := testPool("llm-pool", "llm-agent")
+	pool.Spec.Scheduling = &neuronetes.SchedulingConfig{
+		CostOptimization: &neuronetes.CostOptimizationConfig{Enabled: true, SpotEnabled: true, MaxCostPerHour: float32Ptr(1)},
+	}
+	assert.InDelta(t, 1.0, s.scoreCostEfficiency(ctx, spot, pool), 1e-9)
+	assert.InDelta(t, 0.6, s.scoreCostEfficiency(ctx, onDemand, pool), 1e-9)
+	assert.True(t, s.withinBudget(ctx, onDemand, pool))
+}
@@ -0,0 +1,69 @@
+package scheduler
+
+import (
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/bowenislandsong/neuronetes/pkg/gpu"
+)
+
+const (
+	// gfdCountLabel is the number of GPUs of a node, as labeled by GPU
+	// Feature Discovery. Nodes whose GPUs are allocated by a DRA driver
+	// advertise no extended resources, so it is their only count.
+	gfdCountLabel = "nvidia.com/gpu.count"
+
+	// gfdMIGCountSuffix suffixes the GPU Feature Discovery label counting
+	// the MIG devices of a profile, e.g. nvidia.com/mig-1g.5gb.count
+	gfdMIGCountSuffix = ".count"
+)
+
+// nodeGPUs returns the whole GPUs of resources, the capacity or allocatable
+// resources of node, of either vendor, or those GPU Feature Discovery
+// labeled on a node advertising none
+func nodeGPUs(node *corev1.Node, resources corev1.ResourceList) int64 {
+	for _, name := range gpu.Resources {
+		if gpus := resources[name]; !gpus.IsZero() {
+			return gpus.Value()
+		}
+	}
+	count, err := strconv.ParseInt(node.Labels[gfdCountLabel], 10, 64)
+	if err != nil || count < 0 {
+		return 0
+	}
+	return count
+}
+
+// gfdMIGSlices returns the MIG devices GPU Feature Discovery labeled on a
+// node
+func gfdMIGSlices(node *corev1.Node) migSlices {
+	slices := migSlices{}
+	for key, value := range node.Labels {
+		profile, ok := strings.CutPrefix(key, migResourcePrefix)
+		if !ok {
+			continue
+		}
+		profile, ok = strings.CutSuffix(profile, gfdMIGCountSuffix)
+		if !ok {
+			continue
+		}
+		if n, err := strconv.ParseInt(value, 10, 64); err == nil && n > 0 {
+			slices[profile] = n
+		}
+	}
+	return slices
+}
+
+// sharedClaim returns the ResourceClaim a pod shares with the other
+// replicas of its pool, if any. The devices of a shared claim are allocated
+// once, on a single node.
+func sharedClaim(pod *corev1.Pod) string {
+	for _, claim := range pod.Spec.ResourceClaims {
+		if claim.Source.ResourceClaimName != nil {
+			return pod.Namespace + "/" + *claim.Source.ResourceClaimName
+		}
+	}
+	return ""
+}
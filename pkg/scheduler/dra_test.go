@@ -0,0 +1,80 @@
+package scheduler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/gpu"
+)
+
+// draNode returns a node whose GPUs are allocated by a DRA driver, known
+// only from GPU Feature Discovery labels
+func draNode(name string, labels map[string]string) *corev1.Node {
+	node := gpuNode(name, 0)
+	delete(node.Status.Capacity, gpu.NVIDIAResource)
+	delete(node.Status.Allocatable, gpu.NVIDIAResource)
+	for k, v := range labels {
+		node.Labels[k] = v
+	}
+	return node
+}
+
+// claimPod returns a replica on node claiming resources through DRA, from
+// the shared claim if set
+func claimPod(name, node, claimed, shared string) *corev1.Pod {
+	pod := classPod(name, "default", "chat-agent", node)
+	pod.Annotations = map[string]string{neuronetes.AnnotationGPUClaims: claimed}
+	if shared != "" {
+		pod.Spec.ResourceClaims = []corev1.PodResourceClaim{{
+			Name:   "gpu-0",
+			Source: corev1.ClaimSource{ResourceClaimName: &shared},
+		}}
+	}
+	return pod
+}
+
+func TestScheduleOnDRANodes(t *testing.T) {
+	ctx := context.Background()
+	large := draNode("node-large", map[string]string{gfdCountLabel: "8"})
+	small := draNode("node-small", map[string]string{gfdCountLabel: "1"})
+	s := newTestScheduler(t, &SchedulerConfig{SpreadWeight: 1}, large, small,
+		claimPod("chat-0", "node-large", "nvidia.com/gpu=2", ""))
+
+	pool := testPool("chat-pool", "chat-agent")
+	pool.Spec.GPURequirements = &neuronetes.GPURequirements{Count: 2, DRA: &neuronetes.DRAConfig{}}
+	pod := claimPod("chat-1", "", "nvidia.com/gpu=2", "")
+	assert.Equal(t, "not enough GPUs of the required type", s.filterNode(ctx, small, pod, pool, nodeAllocation{}))
+
+	result, err := s.Schedule(ctx, pod, pool)
+	require.NoError(t, err)
+	assert.Equal(t, "node-large", result.Node)
+
+	allocated, err := s.allocation([]*corev1.Node{large})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), allocated["node-large"].requested)
+}
+
+func TestSharedClaimsAreCountedOnce(t *testing.T) {
+	var allocated nodeAllocation
+	allocated.add(claimPod("chat-0", "node-a", "nvidia.com/mig-1g.5gb=1", "chat-gpu-0"))
+	allocated.add(claimPod("chat-1", "node-a", "nvidia.com/mig-1g.5gb=1", "chat-gpu-0"))
+	allocated.add(claimPod("chat-2", "node-a", "nvidia.com/mig-1g.5gb=1", ""))
+	assert.Equal(t, migSlices{"1g.5gb": 2}, allocated.mig)
+
+	node := draNode("node-a", map[string]string{"nvidia.com/mig-1g.5gb.count": "7", "nvidia.com/mig-1g.5gb.memory": "4864"})
+	assert.Equal(t, migSlices{"1g.5gb": 7}, nodeMIGSlices(node))
+	assert.Equal(t, int64(5), freeMIGSlices(node, allocated.mig, "1g.5gb"))
+
+	// Claims are read from the annotation alone
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{
+		neuronetes.AnnotationGPUClaims: "nvidia.com/gpu=2, invalid, nvidia.com/mig-2g.10gb=x",
+	}}}
+	assert.Equal(t, int64(2), gpu.PodGPUs(pod))
+	assert.Empty(t, podMIGSlices(pod))
+}
@@ -0,0 +1,128 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+)
+
+// gangOf returns the key of the gang pod belongs to and the gang's size, or a
+// size of zero for pods scheduled on their own. Replicas of different
+// revisions load different models and never form a gang together.
+func gangOf(pod *corev1.Pod) (string, int) {
+	gang := pod.Labels[neuronetes.LabelGang]
+	if gang == "" {
+		return "", 0
+	}
+	size, err := strconv.Atoi(pod.Annotations[neuronetes.AnnotationGangSize])
+	if err != nil || size < 2 {
+		return "", 0
+	}
+	return pod.Namespace + "/" + gang + "/" + pod.Annotations[neuronetes.AnnotationRevision], size
+}
+
+// Permit holds the replicas of a gang until a whole group of the gang's size
+// has been placed, then binds them together. A group that is not complete
+// within the gang timeout is released to be scheduled again.
+func (p *TopologyPlugin) Permit(ctx context.Context, state *framework.CycleState, pod *corev1.Pod, nodeName string) (*framework.Status, time.Duration) {
+	gang, size := gangOf(pod)
+	if size == 0 {
+		return nil, 0
+	}
+
+	waiting := p.waitingMembers(gang, pod)
+	placed, err := p.placedMembers(gang)
+	if err != nil {
+		return framework.AsStatus(err), 0
+	}
+
+	// Waiting replicas are assumed onto their nodes and so are part of the
+	// snapshot; the rest of it are bound groups
+	bound := placed - len(waiting)
+	if bound < 0 {
+		bound = 0
+	}
+	need := size - bound%size
+	if len(waiting)+1 < need {
+		timeout := p.scheduler.config.GangTimeout
+		if timeout <= 0 {
+			timeout = defaultGangTimeout
+		}
+		p.gangsMu.Lock()
+		if _, ok := p.gangs[gang]; !ok {
+			p.gangs[gang] = time.Now()
+		}
+		p.gangsMu.Unlock()
+		return framework.NewStatus(framework.Wait, fmt.Sprintf("waiting for %d more replicas of gang %s", need-len(waiting)-1, gang)), timeout
+	}
+
+	for _, member := range waiting {
+		member.Allow(Name)
+	}
+	p.gangsMu.Lock()
+	started, ok := p.gangs[gang]
+	delete(p.gangs, gang)
+	p.gangsMu.Unlock()
+	if ok && p.metrics != nil {
+		p.metrics.GangScheduleWait.Observe(time.Since(started).Seconds())
+	}
+	return nil, 0
+}
+
+// rejectGang releases the waiting replicas of pod's gang
+func (p *TopologyPlugin) rejectGang(pod *corev1.Pod) {
+	gang, size := gangOf(pod)
+	if size == 0 {
+		return
+	}
+	for _, member := range p.waitingMembers(gang, pod) {
+		member.Reject(Name, fmt.Sprintf("replica %s of gang %s was not scheduled", pod.Name, gang))
+	}
+	p.gangsMu.Lock()
+	delete(p.gangs, gang)
+	p.gangsMu.Unlock()
+}
+
+// waitingMembers returns the replicas of gang other than pod that wait in
+// Permit
+func (p *TopologyPlugin) waitingMembers(gang string, pod *corev1.Pod) []framework.WaitingPod {
+	var members []framework.WaitingPod
+	p.handle.IterateOverWaitingPods(func(waiting framework.WaitingPod) {
+		other := waiting.GetPod()
+		if other.UID == pod.UID {
+			return
+		}
+		if key, _ := gangOf(other); key == gang {
+			members = append(members, waiting)
+		}
+	})
+	return members
+}
+
+// placedMembers counts the active replicas of gang that are bound or assumed
+// onto a node
+func (p *TopologyPlugin) placedMembers(gang string) (int, error) {
+	nodeInfos, err := p.handle.SnapshotSharedLister().NodeInfos().List()
+	if err != nil {
+		return 0, fmt.Errorf("failed to list nodes: %w", err)
+	}
+	count := 0
+	for _, nodeInfo := range nodeInfos {
+		for _, podInfo := range nodeInfo.Pods {
+			pod := podInfo.Pod
+			if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+				continue
+			}
+			if key, _ := gangOf(pod); key == gang {
+				count++
+			}
+		}
+	}
+	return count, nil
+}
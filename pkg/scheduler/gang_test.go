@@ -0,0 +1,139 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/metrics"
+)
+
+// gangPod returns the i-th replica of a gang of size replicas of revision
+func gangPod(i int, size int, revision string) *corev1.Pod {
+	name := fmt.Sprintf("tp-pool-%s-%d", revision, i)
+	return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name:      name,
+		Namespace: "default",
+		UID:       types.UID(name),
+		Labels: map[string]string{
+			neuronetes.LabelPool: "tp-pool",
+			neuronetes.LabelGang: "tp-pool",
+		},
+		Annotations: map[string]string{
+			neuronetes.AnnotationGangSize: fmt.Sprint(size),
+			neuronetes.AnnotationRevision: revision,
+		},
+	}}
+}
+
+// assume places pod on node-a as the scheduler does before Permit
+func assume(plugin *TopologyPlugin, pod *corev1.Pod) {
+	pod.Spec.NodeName = "node-a"
+	plugin.handle.(*fakeHandle).snapshot["node-a"].AddPod(pod)
+}
+
+// wait adds pod to the replicas waiting in Permit
+func wait(plugin *TopologyPlugin, pod *corev1.Pod) *fakeWaitingPod {
+	waiting := &fakeWaitingPod{pod: pod}
+	handle := plugin.handle.(*fakeHandle)
+	handle.waiting = append(handle.waiting, waiting)
+	return waiting
+}
+
+func gangWaits(t *testing.T, agentMetrics *metrics.AgentMetrics) *dto.Histogram {
+	t.Helper()
+	var m dto.Metric
+	require.NoError(t, agentMetrics.GangScheduleWait.Write(&m))
+	return m.GetHistogram()
+}
+
+func TestTopologyPluginPermitsWholeGangs(t *testing.T) {
+	ctx := context.Background()
+	pool := testPool("tp-pool", "tp-agent")
+	agentMetrics := metrics.NewAgentMetrics(prometheus.NewRegistry())
+	plugin := newTestPlugin(t, pool, agentMetrics, []*corev1.Node{gpuNode("node-a", 8)})
+	state := framework.NewCycleState()
+
+	// The first two replicas of a gang of three wait
+	var waiting []*fakeWaitingPod
+	for i := 0; i < 2; i++ {
+		pod := gangPod(i, 3, "v1")
+		status, timeout := plugin.Permit(ctx, state, pod, "node-a")
+		require.Equal(t, framework.Wait, status.Code(), status.Message())
+		assert.Equal(t, defaultGangTimeout, timeout)
+		assume(plugin, pod)
+		waiting = append(waiting, wait(plugin, pod))
+	}
+
+	// The third binds them all
+	plugin.gangs["default/tp-pool/v1"] = time.Now().Add(-10 * time.Second)
+	third := gangPod(2, 3, "v1")
+	status, _ := plugin.Permit(ctx, state, third, "node-a")
+	require.True(t, status.IsSuccess(), status.Message())
+	for _, w := range waiting {
+		assert.True(t, w.allowed)
+	}
+	histogram := gangWaits(t, agentMetrics)
+	assert.Equal(t, uint64(1), histogram.GetSampleCount())
+	assert.GreaterOrEqual(t, histogram.GetSampleSum(), 10.0)
+	assert.Empty(t, plugin.gangs)
+
+	// Once bound, scaling up waits for a new gang
+	assume(plugin, third)
+	plugin.handle.(*fakeHandle).waiting = nil
+	status, _ = plugin.Permit(ctx, state, gangPod(3, 3, "v1"), "node-a")
+	assert.Equal(t, framework.Wait, status.Code())
+
+	// Replicas of another revision form their own gang
+	status, _ = plugin.Permit(ctx, state, gangPod(0, 3, "v2"), "node-a")
+	assert.Equal(t, framework.Wait, status.Code())
+}
+
+func TestTopologyPluginReplacesGangMember(t *testing.T) {
+	ctx := context.Background()
+	pool := testPool("tp-pool", "tp-agent")
+	plugin := newTestPlugin(t, pool, nil, []*corev1.Node{gpuNode("node-a", 8)})
+	for i := 0; i < 2; i++ {
+		assume(plugin, gangPod(i, 3, "v1"))
+	}
+
+	// The replacement for a lost replica completes its gang on its own
+	status, _ := plugin.Permit(ctx, framework.NewCycleState(), gangPod(2, 3, "v1"), "node-a")
+	assert.True(t, status.IsSuccess(), status.Message())
+
+	// Pods of no gang are not held
+	status, _ = plugin.Permit(ctx, framework.NewCycleState(), poolPod(pool), "node-a")
+	assert.True(t, status.IsSuccess())
+}
+
+func TestTopologyPluginUnreserveRejectsGang(t *testing.T) {
+	ctx := context.Background()
+	pool := testPool("tp-pool", "tp-agent")
+	plugin := newTestPlugin(t, pool, nil, []*corev1.Node{gpuNode("node-a", 8)})
+	state := framework.NewCycleState()
+
+	first := gangPod(0, 3, "v1")
+	status, _ := plugin.Permit(ctx, state, first, "node-a")
+	require.Equal(t, framework.Wait, status.Code())
+	assume(plugin, first)
+	waiting := wait(plugin, first)
+	other := wait(plugin, gangPod(0, 3, "v2"))
+
+	// A replica that times out or fails to bind releases the rest of its gang
+	second := gangPod(1, 3, "v1")
+	plugin.Unreserve(ctx, state, second, "node-a")
+	assert.True(t, waiting.rejected)
+	assert.False(t, other.rejected)
+	assert.Empty(t, plugin.gangs)
+}
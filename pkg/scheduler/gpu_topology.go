@@ -3,20 +3,48 @@ package scheduler
 import (
 	"context"
 	"fmt"
+	"math"
+	"sort"
 	"time"
 
 	corev1 "k8s.io/api/core/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
-	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/informers"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/log"
 
 	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/dcgm"
+	"github.com/bowenislandsong/neuronetes/pkg/metrics"
+	"github.com/bowenislandsong/neuronetes/pkg/plugins"
+	"github.com/bowenislandsong/neuronetes/pkg/pricing"
 )
 
-// GPUTopologyScheduler implements GPU-aware scheduling
+// defaultParallelism is the number of nodes scored at once unless configured
+// otherwise, as kube-scheduler's default
+const defaultParallelism = 16
+
+// GPUTopologyScheduler implements GPU-aware scheduling. Nodes and pods are
+// read from shared informer caches, which the informers keep up to date
+// incrementally, so scheduling does not list them from the API server.
 type GPUTopologyScheduler struct {
-	clientset *kubernetes.Clientset
-	config    *SchedulerConfig
+	nodes      corelisters.NodeLister
+	pods       corelisters.PodLister
+	podsByNode cache.Indexer
+	synced     []cache.InformerSynced
+	config     *SchedulerConfig
+	telemetry  TelemetrySource
+	pricing    pricing.Provider
+	plugins    *plugins.PluginRegistry
+	metrics    *metrics.AgentMetrics
+	scoreCache *scoreCache
+}
+
+// TelemetrySource reports the live state of a node's GPUs
+type TelemetrySource interface {
+	NodeTelemetry(ctx context.Context, node *corev1.Node) (*dcgm.Telemetry, error)
 }
 
 // SchedulerConfig defines scheduler configuration
@@ -33,16 +61,92 @@ type SchedulerConfig struct {
 	// Weight for data locality (0.0-1.0)
 	DataLocalityWeight float64
 
+	// Weight for packing replicas of the same AgentClass onto nodes that
+	// already host it and therefore have its model cached (0.0-1.0).
+	// Competes with SpreadWeight: packing saves memory and load time,
+	// spreading limits the blast radius of a node failure.
+	CachePackWeight float64
+
+	// Weight for spreading replicas of the same AgentClass across nodes (0.0-1.0)
+	SpreadWeight float64
+
+	// Weight for packing MIG slices onto the nodes with the fewest free
+	// slices of the requested profile (0.0-1.0)
+	MIGPackWeight float64
+
+	// Weight for packing replicas onto the nodes with the least VRAM left
+	// after placing them (0.0-1.0)
+	VRAMPackWeight float64
+
+	// Weight for live GPU headroom: idle SMs, free VRAM and idle memory
+	// bandwidth as reported by the telemetry source (0.0-1.0)
+	TelemetryWeight float64
+
+	// Weight for placing replicas of pools with session affinity next to
+	// the pool's other replicas, whose KV caches and model weights are warm
+	// on the node (0.0-1.0)
+	SessionAffinityWeight float64
+
+	// Weights of registered scheduler plugins by name (0.0-1.0). Plugins
+	// not listed are weighted DefaultPluginWeight.
+	PluginWeights map[string]float64
+
 	// Scheduling timeout
 	SchedulingTimeout time.Duration
+
+	// Number of nodes scored at once; defaultParallelism if unset
+	Parallelism int
+
+	// How long the replicas of a gang wait for the rest of their group
+	// before they are released to be scheduled again
+	GangTimeout time.Duration
+
+	// How long the scores of a node that depend only on the node and pool
+	// (GPU topology, model cache and cost) are reused; 0 disables the cache.
+	// Changes to the node or the pool's spec take effect at once.
+	ScoreCacheTTL time.Duration
 }
 
-// NewGPUTopologyScheduler creates a new scheduler
-func NewGPUTopologyScheduler(clientset *kubernetes.Clientset, config *SchedulerConfig) *GPUTopologyScheduler {
-	return &GPUTopologyScheduler{
-		clientset: clientset,
-		config:    config,
+// NewGPUTopologyScheduler creates a scheduler reading nodes and pods from
+// the informers of factory. The factory must be started before Schedule is
+// called.
+func NewGPUTopologyScheduler(factory informers.SharedInformerFactory, config *SchedulerConfig) *GPUTopologyScheduler {
+	nodes := factory.Core().V1().Nodes()
+	pods := factory.Core().V1().Pods()
+	podInformer := pods.Informer()
+	if _, ok := podInformer.GetIndexer().GetIndexers()[nodeNameIndex]; !ok {
+		// Informers cannot be indexed once started; the factory of a
+		// scheduler framework handle is started after its plugins are built
+		_ = podInformer.AddIndexers(cache.Indexers{nodeNameIndex: indexByNodeName})
+	}
+
+	s := &GPUTopologyScheduler{
+		nodes:      nodes.Lister(),
+		pods:       pods.Lister(),
+		podsByNode: podInformer.GetIndexer(),
+		synced:     []cache.InformerSynced{nodes.Informer().HasSynced, podInformer.HasSynced},
+		config:     config,
 	}
+	if config.ScoreCacheTTL > 0 {
+		s.scoreCache = newScoreCache(config.ScoreCacheTTL)
+		// Entries are keyed on the node's resourceVersion, so a missed event
+		// only delays freeing them
+		_ = s.scoreCache.invalidateOnNodeEvents(nodes.Informer())
+	}
+	return s
+}
+
+// SetTelemetrySource scores nodes on the live GPU headroom source reports.
+// Without one, every node scores neutral on headroom.
+func (s *GPUTopologyScheduler) SetTelemetrySource(source TelemetrySource) {
+	s.telemetry = source
+}
+
+// SetMetrics records the placements of the scheduler in m: the topology
+// penalty of the chosen node, whether replicas landed next to their vector
+// stores, and how long the replicas of gangs waited to be placed
+func (s *GPUTopologyScheduler) SetMetrics(m *metrics.AgentMetrics) {
+	s.metrics = m
 }
 
 // ScheduleResult represents a scheduling decision
@@ -54,42 +158,103 @@ type ScheduleResult struct {
 
 // Schedule finds the best node for a pod
 func (s *GPUTopologyScheduler) Schedule(ctx context.Context, pod *corev1.Pod, agentPool *neuronetes.AgentPool) (*ScheduleResult, error) {
+	for _, synced := range s.synced {
+		if !synced() {
+			return nil, fmt.Errorf("node and pod caches are not synced")
+		}
+	}
+
 	// Get all nodes
-	nodes, err := s.listNodes(ctx)
+	nodes, err := s.nodes.List(labels.Everything())
 	if err != nil {
 		return nil, fmt.Errorf("failed to list nodes: %w", err)
 	}
 
+	// Count MIG slices and VRAM already allocated per node
+	allocated, err := s.allocation(nodes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list GPU allocations: %w", err)
+	}
+
 	// Filter nodes
-	feasibleNodes := s.filterNodes(ctx, pod, agentPool, nodes)
+	feasibleNodes := s.filterNodes(ctx, pod, agentPool, nodes, allocated)
 	if len(feasibleNodes) == 0 {
 		return nil, fmt.Errorf("no feasible nodes found")
 	}
 
+	// Count replicas of the same AgentClass per node
+	placement, err := s.classPlacement(ctx, agentPool)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list class replicas: %w", err)
+	}
+
 	// Score nodes
-	scored := s.scoreNodes(ctx, pod, agentPool, feasibleNodes)
+	scored, err := s.scoreNodes(ctx, pod, agentPool, feasibleNodes, placement, allocated)
+	if err != nil {
+		return nil, err
+	}
 
 	// Return best node
 	if len(scored) == 0 {
 		return nil, fmt.Errorf("no nodes scored")
 	}
+	for _, node := range feasibleNodes {
+		if node.Name == scored[0].Node {
+			s.recordPlacement(ctx, node, agentPool)
+			break
+		}
+	}
+	if _, size := gangOf(pod); size > 0 && s.metrics != nil && !pod.CreationTimestamp.IsZero() {
+		s.metrics.GangScheduleWait.Observe(time.Since(pod.CreationTimestamp.Time).Seconds())
+	}
 
 	return &scored[0], nil
 }
 
-func (s *GPUTopologyScheduler) listNodes(ctx context.Context) ([]corev1.Node, error) {
-	nodeList, err := s.clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+// recordPlacement records how far node, chosen for a replica of agentPool,
+// is from the pool's preferred GPU topology and whether it is colocated with
+// the pool's vector stores
+func (s *GPUTopologyScheduler) recordPlacement(ctx context.Context, node *corev1.Node, agentPool *neuronetes.AgentPool) {
+	if s.metrics == nil {
+		return
+	}
+	s.metrics.TopologyPenaltyScore.Set(1 - s.scoreGPUTopology(node, agentPool))
+	if score, ok := s.dataLocality(ctx, node, agentPool); ok {
+		s.metrics.RecordDataLocality(ctx, score == sameNodeScore)
+	}
+}
+
+// classPlacement returns the number of active replicas of the pool's
+// AgentClass on each node
+func (s *GPUTopologyScheduler) classPlacement(ctx context.Context, agentPool *neuronetes.AgentPool) (map[string]int, error) {
+	placement := make(map[string]int)
+	if s.config.CachePackWeight == 0 && s.config.SpreadWeight == 0 {
+		return placement, nil
+	}
+
+	selector := labels.SelectorFromSet(labels.Set{
+		neuronetes.LabelAgentClass: agentPool.Spec.AgentClassRef.Name,
+	})
+	pods, err := s.pods.Pods(agentPool.Namespace).List(selector)
 	if err != nil {
 		return nil, err
 	}
-	return nodeList.Items, nil
+
+	for _, p := range pods {
+		if p.Spec.NodeName == "" || p.Status.Phase == corev1.PodSucceeded || p.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		placement[p.Spec.NodeName]++
+	}
+
+	return placement, nil
 }
 
-func (s *GPUTopologyScheduler) filterNodes(ctx context.Context, pod *corev1.Pod, agentPool *neuronetes.AgentPool, nodes []corev1.Node) []corev1.Node {
-	var feasible []corev1.Node
+func (s *GPUTopologyScheduler) filterNodes(ctx context.Context, pod *corev1.Pod, agentPool *neuronetes.AgentPool, nodes []*corev1.Node, allocated map[string]nodeAllocation) []*corev1.Node {
+	var feasible []*corev1.Node
 
 	for _, node := range nodes {
-		if s.nodePassesFilters(ctx, &node, pod, agentPool) {
+		if s.nodePassesFilters(ctx, node, pod, agentPool, allocated[node.Name]) {
 			feasible = append(feasible, node)
 		}
 	}
@@ -97,34 +262,69 @@ func (s *GPUTopologyScheduler) filterNodes(ctx context.Context, pod *corev1.Pod,
 	return feasible
 }
 
-func (s *GPUTopologyScheduler) nodePassesFilters(ctx context.Context, node *corev1.Node, pod *corev1.Pod, agentPool *neuronetes.AgentPool) bool {
+// nodePassesFilters reports whether node fits a replica of agentPool, given
+// the GPU resources already allocated on it
+func (s *GPUTopologyScheduler) nodePassesFilters(ctx context.Context, node *corev1.Node, pod *corev1.Pod, agentPool *neuronetes.AgentPool, allocated nodeAllocation) bool {
+	return s.filterNode(ctx, node, pod, agentPool, allocated) == ""
+}
+
+// filterNode returns why node does not fit a replica of agentPool, or an
+// empty string if it does
+func (s *GPUTopologyScheduler) filterNode(ctx context.Context, node *corev1.Node, pod *corev1.Pod, agentPool *neuronetes.AgentPool, allocated nodeAllocation) string {
 	// Check node readiness
 	if !s.isNodeReady(node) {
-		return false
+		return "node is not ready"
 	}
 
-	// Check GPU availability
+	// Check GPU availability. Replicas on MIG slices are counted against
+	// free slices instead of whole GPUs.
 	if agentPool.Spec.GPURequirements != nil {
-		if !s.hasRequiredGPUs(node, agentPool.Spec.GPURequirements) {
-			return false
+		if !s.hasRequiredGPUs(node, agentPool, agentPool.Spec.MIGProfile == "") {
+			return "not enough GPUs of the required type"
+		}
+		if !matchesSharing(node, agentPool) {
+			return "GPUs not shared as required"
 		}
 	}
 
+	// Check the bandwidth between the GPUs of a replica
+	if !hasRequiredBandwidth(node, agentPool) {
+		return "GPU interconnect bandwidth below the minimum"
+	}
+
+	// Check the memory of each GPU and the VRAM left on the node
+	if !fitsGPUMemory(node, pod, agentPool) {
+		return "GPU memory below the requirement"
+	}
+	if !hasFreeVRAM(node, pod, agentPool, allocated) {
+		return "not enough free VRAM"
+	}
+
+	// Check the hourly cost of a replica against the pool's budget
+	if !s.withinBudget(ctx, node, agentPool) {
+		return "GPUs cost more than maxCostPerHour"
+	}
+
 	// Check node selector
 	if agentPool.Spec.Scheduling != nil && agentPool.Spec.Scheduling.NodeSelector != nil {
 		if !s.matchesNodeSelector(node, agentPool.Spec.Scheduling.NodeSelector) {
-			return false
+			return "node selector does not match"
 		}
 	}
 
 	// Check MIG profile
 	if agentPool.Spec.MIGProfile != "" {
-		if !s.hasMIGProfile(node, agentPool.Spec.MIGProfile) {
-			return false
+		if freeMIGSlices(node, allocated.mig, agentPool.Spec.MIGProfile) < requestedMIGSlices(pod, agentPool) {
+			return "not enough free MIG slices of the profile"
 		}
 	}
 
-	return true
+	// Run the registered scheduler plugins
+	if plugin := s.filterPlugins(ctx, node, pod, agentPool); plugin != "" {
+		return fmt.Sprintf("rejected by scheduler plugin %s", plugin)
+	}
+
+	return ""
 }
 
 func (s *GPUTopologyScheduler) isNodeReady(node *corev1.Node) bool {
@@ -136,27 +336,26 @@ func (s *GPUTopologyScheduler) isNodeReady(node *corev1.Node) bool {
 	return false
 }
 
-func (s *GPUTopologyScheduler) hasRequiredGPUs(node *corev1.Node, requirements *neuronetes.GPURequirements) bool {
-	// Check GPU count
-	gpuCount := node.Status.Capacity["nvidia.com/gpu"]
-	if gpuCount.IsZero() || int32(gpuCount.Value()) < requirements.Count {
+// hasRequiredGPUs checks the GPU vendor and type of node and, if whole is
+// set, its count of whole GPUs, advertised by the device plugin or labeled
+// for DRA
+func (s *GPUTopologyScheduler) hasRequiredGPUs(node *corev1.Node, agentPool *neuronetes.AgentPool, whole bool) bool {
+	requirements := agentPool.Spec.GPURequirements
+
+	// Check GPU vendor
+	if nodeVendor(node) != poolVendor(agentPool) {
 		return false
 	}
 
-	// Check GPU type
-	if requirements.Type != "" {
-		gpuType, ok := node.Labels["neuronetes.io/gpu-type"]
-		if !ok || gpuType != requirements.Type {
-			return false
-		}
+	// Check GPU count
+	gpuCount := nodeGPUs(node, node.Status.Capacity)
+	if whole && (gpuCount == 0 || int32(gpuCount) < requirements.Count) {
+		return false
 	}
 
-	// Check GPU memory
-	if requirements.Memory != "" {
-		gpuMemory, ok := node.Labels["neuronetes.io/gpu-memory"]
-		if !ok || gpuMemory < requirements.Memory {
-			return false
-		}
+	// Check GPU type
+	if requirements.Type != "" && !matchesGPUType(node, requirements.Type) {
+		return false
 	}
 
 	return true
@@ -166,76 +365,143 @@ func (s *GPUTopologyScheduler) matchesNodeSelector(node *corev1.Node, selector m
 	return labels.SelectorFromSet(selector).Matches(labels.Set(node.Labels))
 }
 
-func (s *GPUTopologyScheduler) hasMIGProfile(node *corev1.Node, profile string) bool {
-	migConfig, ok := node.Labels["neuronetes.io/mig-config"]
-	if !ok {
-		return false
-	}
-
-	// Simple check - in production, parse and verify availability
-	return len(migConfig) > 0
-}
-
-func (s *GPUTopologyScheduler) scoreNodes(ctx context.Context, pod *corev1.Pod, agentPool *neuronetes.AgentPool, nodes []corev1.Node) []ScheduleResult {
-	var results []ScheduleResult
-
-	for _, node := range nodes {
-		score := s.calculateScore(ctx, &node, pod, agentPool)
-		results = append(results, ScheduleResult{
+// scoreNodes scores nodes in parallel, best first. Ties keep the order of
+// nodes.
+func (s *GPUTopologyScheduler) scoreNodes(ctx context.Context, pod *corev1.Pod, agentPool *neuronetes.AgentPool, nodes []*corev1.Node, placement map[string]int, allocated map[string]nodeAllocation) ([]ScheduleResult, error) {
+	results := make([]ScheduleResult, len(nodes))
+	workqueue.ParallelizeUntil(ctx, s.parallelism(), len(nodes), func(i int) {
+		node := nodes[i]
+		results[i] = ScheduleResult{
 			Node:   node.Name,
-			Score:  score,
+			Score:  s.calculateScore(ctx, node, pod, agentPool, placement[node.Name], allocated[node.Name]),
 			Reason: "scored",
-		})
+		}
+	})
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("scoring nodes interrupted: %w", err)
 	}
 
 	// Sort by score (descending)
 	sortByScore(results)
 
-	return results
+	return results, nil
+}
+
+// parallelism returns the number of nodes scored at once
+func (s *GPUTopologyScheduler) parallelism() int {
+	if s.config.Parallelism > 0 {
+		return s.config.Parallelism
+	}
+	return defaultParallelism
+}
+
+func (s *GPUTopologyScheduler) calculateScore(ctx context.Context, node *corev1.Node, pod *corev1.Pod, agentPool *neuronetes.AgentPool, classReplicas int, allocated nodeAllocation) int64 {
+	return s.scoreBreakdown(ctx, node, pod, agentPool, classReplicas, allocated).Total
 }
 
-func (s *GPUTopologyScheduler) calculateScore(ctx context.Context, node *corev1.Node, pod *corev1.Pod, agentPool *neuronetes.AgentPool) int64 {
-	var totalScore float64
+// ScoreBreakdown is the score of a node on each objective (0.0-1.0), and
+// their weighted total (0-100)
+type ScoreBreakdown struct {
+	GPUTopology  float64 `json:"gpuTopology"`
+	ModelCache   float64 `json:"modelCache"`
+	Cost         float64 `json:"cost"`
+	DataLocality float64 `json:"dataLocality"`
+	CachePack    float64 `json:"cachePack"`
+	Spread       float64 `json:"spread"`
+	MIGPack      float64 `json:"migPack"`
+	VRAMPack     float64 `json:"vramPack"`
+
+	// Telemetry is only scored while weighted, as reading it is costly
+	Telemetry float64 `json:"telemetry,omitempty"`
 
-	// GPU topology score
-	topologyScore := s.scoreGPUTopology(node, agentPool)
-	totalScore += topologyScore * s.config.GPUTopologyWeight
+	// SessionAffinity is only scored for pools with session affinity
+	SessionAffinity float64 `json:"sessionAffinity,omitempty"`
+
+	// Plugins are the scores of registered scheduler plugins by name
+	Plugins map[string]float64 `json:"plugins,omitempty"`
+
+	Total int64 `json:"total"`
+}
 
-	// Model cache score
-	cacheScore := s.scoreModelCache(node, agentPool)
-	totalScore += cacheScore * s.config.ModelCacheWeight
+func (s *GPUTopologyScheduler) scoreBreakdown(ctx context.Context, node *corev1.Node, pod *corev1.Pod, agentPool *neuronetes.AgentPool, classReplicas int, allocated nodeAllocation) ScoreBreakdown {
+	static := s.staticScores(ctx, node, agentPool)
+	scores := ScoreBreakdown{
+		// GPU topology score
+		GPUTopology: static.gpuTopology,
 
-	// Cost efficiency score
-	costScore := s.scoreCostEfficiency(node, agentPool)
-	totalScore += costScore * s.config.CostWeight
+		// Model cache score
+		ModelCache: static.modelCache,
 
-	// Data locality score
-	localityScore := s.scoreDataLocality(node, agentPool)
-	totalScore += localityScore * s.config.DataLocalityWeight
+		// Cost efficiency score
+		Cost: static.cost,
+
+		// Data locality score
+		DataLocality: s.scoreDataLocality(ctx, node, agentPool),
+
+		// Cache packing vs. spread of same-class replicas
+		CachePack: scoreCachePack(classReplicas),
+		Spread:    scoreSpread(classReplicas),
+
+		// Best fit of MIG slices and VRAM
+		MIGPack:  scoreMIGPack(node, pod, agentPool, allocated.mig),
+		VRAMPack: scoreVRAMPack(node, pod, agentPool, allocated),
+
+		// Warm caches of the pool's sticky sessions
+		SessionAffinity: scoreSessionAffinity(agentPool, allocated),
+	}
+
+	// Live GPU headroom
+	if s.config.TelemetryWeight > 0 {
+		scores.Telemetry = scoreGPUHeadroom(s.nodeTelemetry(ctx, node))
+	}
+
+	totalScore := scores.GPUTopology*s.config.GPUTopologyWeight +
+		scores.ModelCache*s.config.ModelCacheWeight +
+		scores.Cost*s.config.CostWeight +
+		scores.DataLocality*s.config.DataLocalityWeight +
+		scores.CachePack*s.config.CachePackWeight +
+		scores.Spread*s.config.SpreadWeight +
+		scores.MIGPack*s.config.MIGPackWeight +
+		scores.VRAMPack*s.config.VRAMPackWeight +
+		scores.Telemetry*s.config.TelemetryWeight +
+		scores.SessionAffinity*s.config.SessionAffinityWeight
+
+	// Registered scheduler plugins
+	scores.Plugins = s.scorePlugins(ctx, node, pod, agentPool)
+	for name, score := range scores.Plugins {
+		totalScore += score * s.pluginWeight(name)
+	}
 
 	// Normalize to 0-100
-	return int64(totalScore * 100)
+	scores.Total = int64(totalScore * 100)
+	return scores
 }
 
+// scoreGPUTopology scores how well node's GPUs are interconnected for the
+// pool's locality: by the bandwidth of the best group of GPUs for a replica
+// where the topology was discovered, otherwise by the gpu-topology label
 func (s *GPUTopologyScheduler) scoreGPUTopology(node *corev1.Node, agentPool *neuronetes.AgentPool) float64 {
 	// Score based on GPU topology
 	if agentPool.Spec.GPURequirements == nil || agentPool.Spec.GPURequirements.Topology == nil {
 		return 0.5 // Neutral score
 	}
+	if score, ok := scoreInterconnect(node, agentPool); ok {
+		return score
+	}
 
 	topology := agentPool.Spec.GPURequirements.Topology
-	nodeTopology, ok := node.Labels["neuronetes.io/gpu-topology"]
+	nodeTopology, ok := node.Labels[gpuTopologyLabel]
 	if !ok {
 		return 0.0
 	}
 
 	// Score based on locality match
 	switch topology.Locality {
-	case "nvlink":
-		if nodeTopology == "nvlink" {
+	case "nvlink", "xgmi":
+		if nodeTopology == "nvlink" || nodeTopology == "xgmi" {
 			return 1.0
 		}
-		return 0.3
+		return pcieScore
 	case "same-node":
 		return 0.8
 	case "any":
@@ -257,53 +523,64 @@ func (s *GPUTopologyScheduler) scoreModelCache(node *corev1.Node, agentPool *neu
 	return 0.9
 }
 
-func (s *GPUTopologyScheduler) scoreCostEfficiency(node *corev1.Node, agentPool *neuronetes.AgentPool) float64 {
-	// Score based on cost
-	if agentPool.Spec.Scheduling == nil || agentPool.Spec.Scheduling.CostOptimization == nil {
-		return 0.5
+// nodeTelemetry returns the GPU telemetry of node, or nil if there is no
+// source or the node's telemetry cannot be read
+func (s *GPUTopologyScheduler) nodeTelemetry(ctx context.Context, node *corev1.Node) *dcgm.Telemetry {
+	if s.telemetry == nil {
+		return nil
 	}
+	telemetry, err := s.telemetry.NodeTelemetry(ctx, node)
+	if err != nil {
+		log.FromContext(ctx).V(4).Info("scoring node without GPU telemetry", "node", node.Name, "error", err.Error())
+		return nil
+	}
+	return telemetry
+}
 
-	// Check if spot instance
-	_, ok := node.Labels["node.kubernetes.io/instance-type"]
-	if !ok {
+// scoreGPUHeadroom rewards nodes whose GPUs have idle SMs, free VRAM and
+// idle memory bandwidth. Nodes without telemetry score neutral.
+func scoreGPUHeadroom(telemetry *dcgm.Telemetry) float64 {
+	if telemetry == nil {
 		return 0.5
 	}
-
-	// Prefer spot if enabled
-	if agentPool.Spec.Scheduling.CostOptimization.SpotEnabled {
-		if node.Labels["karpenter.sh/capacity-type"] == "spot" {
-			return 1.0
-		}
-		return 0.6
+	idle := func(pct float64) float64 {
+		return math.Min(1, math.Max(0, (100-pct)/100))
 	}
-
-	return 0.7
+	free := 0.0
+	if total := telemetry.VRAMTotal(); total > 0 {
+		free = float64(telemetry.VRAMFree) / float64(total)
+	}
+	return (idle(telemetry.SMActive) + free + idle(telemetry.MemoryBandwidth)) / 3
 }
 
-func (s *GPUTopologyScheduler) scoreDataLocality(node *corev1.Node, agentPool *neuronetes.AgentPool) float64 {
-	// Score based on data locality
-	if agentPool.Spec.Scheduling == nil || agentPool.Spec.Scheduling.DataLocality == nil {
-		return 0.5
+// scoreCachePack rewards nodes already running the AgentClass, whose model
+// weights are therefore resident on the node
+func scoreCachePack(classReplicas int) float64 {
+	if classReplicas > 0 {
+		return 1.0
 	}
+	return 0.0
+}
 
-	locality := agentPool.Spec.Scheduling.DataLocality
-
-	// Check vector store affinity
-	if len(locality.VectorStoreAffinity) > 0 {
-		// In production, query vector store locations
-		return 0.8
+// scoreSessionAffinity rewards nodes already running replicas of a pool with
+// session affinity. Sessions stick to their replica, and replicas on the
+// same node share its warm model weights and, with prefix caching, the KV
+// cache of common prompts, so a new replica there starts serving sooner.
+func scoreSessionAffinity(agentPool *neuronetes.AgentPool, allocated nodeAllocation) float64 {
+	if agentPool.Spec.SessionAffinity == nil || !agentPool.Spec.SessionAffinity.Enabled {
+		return 0.0
 	}
+	replicas := allocated.pools[agentPool.Namespace+"/"+agentPool.Name]
+	return float64(replicas) / float64(1+replicas)
+}
 
-	return 0.5
+// scoreSpread rewards nodes running fewer replicas of the AgentClass
+func scoreSpread(classReplicas int) float64 {
+	return 1.0 / float64(1+classReplicas)
 }
 
 func sortByScore(results []ScheduleResult) {
-	// Simple bubble sort for now
-	for i := 0; i < len(results)-1; i++ {
-		for j := 0; j < len(results)-i-1; j++ {
-			if results[j].Score < results[j+1].Score {
-				results[j], results[j+1] = results[j+1], results[j]
-			}
-		}
-	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Score > results[j].Score
+	})
 }
@@ -0,0 +1,289 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/dcgm"
+)
+
+func gpuNode(name string, gpus int64) *corev1.Node {
+	return &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: map[string]string{},
+		},
+		Status: corev1.NodeStatus{
+			Capacity: corev1.ResourceList{
+				"nvidia.com/gpu": *resource.NewQuantity(gpus, resource.DecimalSI),
+			},
+			Allocatable: corev1.ResourceList{
+				"nvidia.com/gpu": *resource.NewQuantity(gpus, resource.DecimalSI),
+			},
+			Conditions: []corev1.NodeCondition{
+				{Type: corev1.NodeReady, Status: corev1.ConditionTrue},
+			},
+		},
+	}
+}
+
+func classPod(name, namespace, class, node string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    map[string]string{neuronetes.LabelAgentClass: class},
+		},
+		Spec:   corev1.PodSpec{NodeName: node},
+		Status: corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+}
+
+func testPool(name, class string) *neuronetes.AgentPool {
+	return &neuronetes.AgentPool{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec: neuronetes.AgentPoolSpec{
+			AgentClassRef:   neuronetes.AgentClassReference{Name: class},
+			MinReplicas:     1,
+			MaxReplicas:     4,
+			GPURequirements: &neuronetes.GPURequirements{Count: 1},
+		},
+	}
+}
+
+// newTestScheduler returns a scheduler whose caches hold objects
+func newTestScheduler(t testing.TB, config *SchedulerConfig, objects ...runtime.Object) *GPUTopologyScheduler {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	factory := informers.NewSharedInformerFactory(fake.NewSimpleClientset(objects...), 0)
+	s := NewGPUTopologyScheduler(factory, config)
+	factory.Start(ctx.Done())
+	for informer, synced := range factory.WaitForCacheSync(ctx.Done()) {
+		require.True(t, synced, "%v not synced", informer)
+	}
+	return s
+}
+
+func TestScheduleCachePackVersusSpread(t *testing.T) {
+	ctx := context.Background()
+	objects := []runtime.Object{
+		gpuNode("node-cached", 4),
+		gpuNode("node-fresh", 4),
+		classPod("chat-0", "default", "chat-agent", "node-cached"),
+		// Same class in another namespace must not count
+		classPod("chat-0", "other", "chat-agent", "node-fresh"),
+	}
+	pool := testPool("chat-pool", "chat-agent")
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "chat-1", Namespace: "default"}}
+
+	t.Run("high cache-pack weight prefers the model-cached node", func(t *testing.T) {
+		s := newTestScheduler(t, &SchedulerConfig{CachePackWeight: 0.8, SpreadWeight: 0.2}, objects...)
+		result, err := s.Schedule(ctx, pod, pool)
+		require.NoError(t, err)
+		assert.Equal(t, "node-cached", result.Node)
+	})
+
+	t.Run("high spread weight prefers a fresh node", func(t *testing.T) {
+		s := newTestScheduler(t, &SchedulerConfig{CachePackWeight: 0.2, SpreadWeight: 0.8}, objects...)
+		result, err := s.Schedule(ctx, pod, pool)
+		require.NoError(t, err)
+		assert.Equal(t, "node-fresh", result.Node)
+	})
+}
+
+func TestSchedulePrefersNodesOfSessionAffinePools(t *testing.T) {
+	ctx := context.Background()
+	replica := classPod("chat-0", "default", "chat-agent", "node-warm")
+	replica.Labels[neuronetes.LabelPool] = "chat-pool"
+	// A replica of another pool of the class must not count
+	other := classPod("batch-0", "default", "chat-agent", "node-other")
+	other.Labels[neuronetes.LabelPool] = "batch-pool"
+	s := newTestScheduler(t, &SchedulerConfig{SessionAffinityWeight: 1},
+		gpuNode("node-other", 4), gpuNode("node-warm", 4), replica, other)
+
+	pool := testPool("chat-pool", "chat-agent")
+	pool.Spec.SessionAffinity = &neuronetes.SessionAffinityConfig{Enabled: true}
+	result, err := s.Schedule(ctx, poolPod(pool), pool)
+	require.NoError(t, err)
+	assert.Equal(t, "node-warm", result.Node)
+	assert.Equal(t, int64(50), result.Score)
+
+	// Without session affinity the pool's replicas are not looked at
+	pool.Spec.SessionAffinity.Enabled = false
+	result, err = s.Schedule(ctx, poolPod(pool), pool)
+	require.NoError(t, err)
+	assert.Zero(t, result.Score)
+}
+
+func TestClassPlacementIgnoresTerminalPods(t *testing.T) {
+	done := classPod("chat-done", "default", "chat-agent", "node-a")
+	done.Status.Phase = corev1.PodSucceeded
+	pending := classPod("chat-pending", "default", "chat-agent", "")
+	pending.Status.Phase = corev1.PodPending
+
+	s := newTestScheduler(t, &SchedulerConfig{SpreadWeight: 1},
+		classPod("chat-0", "default", "chat-agent", "node-a"),
+		classPod("chat-1", "default", "chat-agent", "node-a"),
+		classPod("other-0", "default", "other-agent", "node-b"),
+		done, pending,
+	)
+
+	placement, err := s.classPlacement(context.Background(), testPool("chat-pool", "chat-agent"))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"node-a": 2}, placement)
+}
+
+// fakeTelemetry reports fixed telemetry per node
+type fakeTelemetry map[string]*dcgm.Telemetry
+
+func (f fakeTelemetry) NodeTelemetry(ctx context.Context, node *corev1.Node) (*dcgm.Telemetry, error) {
+	telemetry, ok := f[node.Name]
+	if !ok {
+		return nil, assert.AnError
+	}
+	return telemetry, nil
+}
+
+func TestScheduleOnGPUTelemetry(t *testing.T) {
+	pool := testPool("chat-pool", "chat-agent")
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "chat-0", Namespace: "default"}}
+	s := newTestScheduler(t, &SchedulerConfig{TelemetryWeight: 1},
+		gpuNode("node-busy", 4), gpuNode("node-idle", 4), gpuNode("node-unknown", 4))
+	s.SetTelemetrySource(fakeTelemetry{
+		"node-busy": {GPUs: 4, SMActive: 90, MemoryBandwidth: 80, VRAMUsed: 70 << 30, VRAMFree: 10 << 30},
+		"node-idle": {GPUs: 4, SMActive: 10, MemoryBandwidth: 20, VRAMUsed: 20 << 30, VRAMFree: 60 << 30},
+	})
+
+	result, err := s.Schedule(context.Background(), pod, pool)
+	require.NoError(t, err)
+	assert.Equal(t, "node-idle", result.Node)
+	assert.Equal(t, int64(81), result.Score)
+
+	// Nodes without telemetry score neutral, ahead of busy ones
+	assert.InDelta(t, 0.5, scoreGPUHeadroom(nil), 1e-9)
+	assert.Less(t, scoreGPUHeadroom(&dcgm.Telemetry{SMActive: 90, MemoryBandwidth: 80, VRAMUsed: 70, VRAMFree: 10}), 0.5)
+}
+
+func TestScheduleReadsInformerCaches(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	clientset := fake.NewSimpleClientset(gpuNode("node-a", 4))
+	factory := informers.NewSharedInformerFactory(clientset, 0)
+	s := NewGPUTopologyScheduler(factory, &SchedulerConfig{SpreadWeight: 1})
+	pool := testPool("chat-pool", "chat-agent")
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "chat-0", Namespace: "default"}}
+
+	_, err := s.Schedule(ctx, pod, pool)
+	assert.ErrorContains(t, err, "not synced")
+
+	factory.Start(ctx.Done())
+	factory.WaitForCacheSync(ctx.Done())
+	for i := 0; i < 3; i++ {
+		result, err := s.Schedule(ctx, pod, pool)
+		require.NoError(t, err)
+		assert.Equal(t, "node-a", result.Node)
+	}
+
+	// Replicas placed later reach the cache through the watch
+	_, err = clientset.CoreV1().Nodes().Create(ctx, gpuNode("node-b", 4), metav1.CreateOptions{})
+	require.NoError(t, err)
+	_, err = clientset.CoreV1().Pods("default").Create(ctx, classPod("chat-1", "default", "chat-agent", "node-a"), metav1.CreateOptions{})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		result, err := s.Schedule(ctx, pod, pool)
+		return err == nil && result.Node == "node-b"
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Nodes and pods were listed once, by the informers
+	lists := 0
+	for _, action := range clientset.Actions() {
+		if action.GetVerb() == "list" {
+			lists++
+		}
+	}
+	assert.Equal(t, 2, lists)
+}
+
+func TestScoreNodesInParallel(t *testing.T) {
+	var objects []runtime.Object
+	for i := 0; i < 40; i++ {
+		objects = append(objects, gpuNode(fmt.Sprintf("node-%02d", i), 8))
+	}
+	objects = append(objects, classPod("chat-0", "default", "chat-agent", "node-07"))
+	s := newTestScheduler(t, &SchedulerConfig{CachePackWeight: 1, Parallelism: 4}, objects...)
+	pool := testPool("chat-pool", "chat-agent")
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "chat-1", Namespace: "default"}}
+
+	result, err := s.Schedule(context.Background(), pod, pool)
+	require.NoError(t, err)
+	assert.Equal(t, "node-07", result.Node)
+	assert.Equal(t, int64(100), result.Score)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = s.Schedule(ctx, pod, pool)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestSortByScoreKeepsTies(t *testing.T) {
+	results := []ScheduleResult{{Node: "a", Score: 10}, {Node: "b", Score: 30}, {Node: "c", Score: 10}, {Node: "d", Score: 30}}
+	sortByScore(results)
+	var order []string
+	for _, r := range results {
+		order = append(order, r.Node)
+	}
+	assert.Equal(t, []string{"b", "d", "a", "c"}, order)
+}
+
+// BenchmarkSchedule fails if a scheduling decision across benchmarkNodes GPU
+// nodes takes longer than schedulingLatencyBudget
+const (
+	benchmarkNodes          = 500
+	schedulingLatencyBudget = 50 * time.Millisecond
+)
+
+func BenchmarkSchedule(b *testing.B) {
+	var objects []runtime.Object
+	for i := 0; i < benchmarkNodes; i++ {
+		node := gpuNode(fmt.Sprintf("node-%03d", i), 8)
+		node.Labels[gpuTopologyLabel] = []string{"nvlink", "pcie"}[i%2]
+		objects = append(objects, node)
+		for j := 0; j < i%4; j++ {
+			objects = append(objects, classPod(fmt.Sprintf("chat-%d-%d", i, j), "default", "chat-agent", node.Name))
+		}
+	}
+	s := newTestScheduler(b, DefaultSchedulerConfig(), objects...)
+	pool := testPool("chat-pool", "chat-agent")
+	pool.Spec.GPURequirements = &neuronetes.GPURequirements{
+		Count:    2,
+		Topology: &neuronetes.TopologyRequirement{Locality: "nvlink"},
+	}
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "chat-0", Namespace: "default"}}
+	ctx := context.Background()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := s.Schedule(ctx, pod, pool); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+	if latency := b.Elapsed() / time.Duration(b.N); latency > schedulingLatencyBudget {
+		b.Fatalf("scheduling across %d nodes took %v, over the %v budget", benchmarkNodes, latency, schedulingLatencyBudget)
+	}
+}
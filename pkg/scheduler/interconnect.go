@@ -0,0 +1,114 @@
+package scheduler
+
+import (
+	"math"
+
+	corev1 "k8s.io/api/core/v1"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/topology"
+)
+
+const (
+	// gpuTopologyLabel is the interconnect of a node's GPUs, nvlink, xgmi or
+	// pcie, for nodes whose topology was labeled by hand rather than
+	// discovered
+	gpuTopologyLabel = "neuronetes.io/gpu-topology"
+
+	// fullNVLinkBandwidth is the NVLink bandwidth in GB/s, that of an
+	// NVSwitch-connected H100, at which a group scores fully
+	fullNVLinkBandwidth = 900
+
+	// fullXGMIBandwidth is the Infinity Fabric bandwidth in GB/s, that of
+	// the link between two MI300X GPUs, at which a group scores fully
+	fullXGMIBandwidth = 128
+
+	// fullPCIeBandwidth is the PCIe bandwidth in GB/s at which a group
+	// without NVLink scores pcieScore
+	fullPCIeBandwidth = 64
+
+	// pcieScore is the highest score of GPUs joined only by PCIe for a pool
+	// requiring NVLink; NVLink groups score above it
+	pcieScore = 0.3
+)
+
+// nodeLinks returns the GPU link matrix published for node by the topology
+// agent
+func nodeLinks(node *corev1.Node) (topology.Matrix, bool) {
+	value, ok := node.Annotations[topology.AnnotationLinks]
+	if !ok {
+		return nil, false
+	}
+	matrix, err := topology.Decode(value)
+	if err != nil {
+		return nil, false
+	}
+	return matrix, true
+}
+
+// shardGroup returns the best connected GPUs of node for a replica of
+// agentPool. Which GPUs a replica gets is up to the device plugin, so this
+// is the best the node can offer.
+func shardGroup(node *corev1.Node, agentPool *neuronetes.AgentPool) (topology.Group, bool) {
+	matrix, ok := nodeLinks(node)
+	if !ok {
+		return topology.Group{}, false
+	}
+	return matrix.BestGroup(int(agentPool.Spec.GPURequirements.Count))
+}
+
+// hasRequiredBandwidth reports whether the GPUs of node can be joined with
+// the minimum bandwidth the pool requires. Nodes whose topology has not been
+// discovered are not filtered.
+func hasRequiredBandwidth(node *corev1.Node, agentPool *neuronetes.AgentPool) bool {
+	requirements := agentPool.Spec.GPURequirements
+	if requirements == nil || requirements.Topology == nil || requirements.Topology.MinBandwidth == nil {
+		return true
+	}
+	if requirements.Count < 2 {
+		return true
+	}
+	matrix, ok := nodeLinks(node)
+	if !ok {
+		return true
+	}
+	group, ok := matrix.BestGroup(int(requirements.Count))
+	return ok && group.Bandwidth >= requirements.Topology.MinBandwidth.AsApproximateFloat64()
+}
+
+// scoreInterconnect scores the link between the GPUs of a replica for the
+// pool's locality, or returns false if the node's topology is unknown
+func scoreInterconnect(node *corev1.Node, agentPool *neuronetes.AgentPool) (float64, bool) {
+	if agentPool.Spec.GPURequirements.Count < 2 {
+		// A single GPU needs no interconnect
+		_, ok := nodeLinks(node)
+		return 1.0, ok
+	}
+	group, ok := shardGroup(node, agentPool)
+	if !ok {
+		return 0.0, false
+	}
+	switch agentPool.Spec.GPURequirements.Topology.Locality {
+	case "nvlink", "xgmi":
+		return scoreBandwidth(group), true
+	case "same-socket":
+		if group.Link == topology.LinkSys {
+			return pcieScore, true
+		}
+		return 1.0, true
+	}
+	return 0.0, false
+}
+
+// scoreBandwidth scores NVLink and Infinity Fabric groups by their bandwidth
+// above PCIe groups, which are scored by theirs
+func scoreBandwidth(group topology.Group) float64 {
+	if topology.IsFabric(group.Link) {
+		full := float64(fullNVLinkBandwidth)
+		if group.Link == topology.LinkXGMI {
+			full = fullXGMIBandwidth
+		}
+		return pcieScore + (1-pcieScore)*math.Min(1, group.Bandwidth/full)
+	}
+	return pcieScore * math.Min(1, group.Bandwidth/fullPCIeBandwidth)
+}
@@ -0,0 +1,111 @@
+package scheduler
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/topology"
+)
+
+// linkedNode returns a node of gpus GPUs all joined by link, or by NVLink
+// pairs when link is empty
+func linkedNode(name string, gpus int, link string) *corev1.Node {
+	node := gpuNode(name, int64(gpus))
+	rows := make([]string, gpus)
+	for i := range rows {
+		cells := make([]string, gpus)
+		for j := range cells {
+			switch {
+			case i == j:
+				cells[j] = topology.LinkSelf
+			case link != "":
+				cells[j] = link
+			case i/2 == j/2:
+				cells[j] = "NV4"
+			default:
+				cells[j] = topology.LinkSys
+			}
+		}
+		rows[i] = strings.Join(cells, ",")
+	}
+	node.Annotations = map[string]string{topology.AnnotationLinks: strings.Join(rows, ";")}
+	return node
+}
+
+func TestTopologyPluginScoresInterconnectBandwidth(t *testing.T) {
+	ctx := context.Background()
+	pool := testPool("tp-pool", "tp-agent")
+	pool.Spec.GPURequirements = &neuronetes.GPURequirements{
+		Count:    4,
+		Topology: &neuronetes.TopologyRequirement{Locality: "nvlink"},
+	}
+	nodes := []*corev1.Node{
+		linkedNode("node-h100", 8, "NV18"),
+		linkedNode("node-a100", 8, "NV12"),
+		linkedNode("node-pairs", 8, ""),
+		linkedNode("node-pcie", 8, topology.LinkPIX),
+	}
+	labeled := gpuNode("node-labeled", 8)
+	labeled.Labels[gpuTopologyLabel] = "nvlink"
+	nodes = append(nodes, labeled)
+
+	plugin := newTestPlugin(t, pool, nil, nodes)
+	pod := poolPod(pool)
+	state := framework.NewCycleState()
+	_, status := plugin.PreFilter(ctx, state, pod)
+	require.True(t, status.IsSuccess(), status.Message())
+	require.True(t, plugin.PreScore(ctx, state, pod, nil).IsSuccess())
+
+	// NVLink groups score by bandwidth above PCIe ones; four GPUs of a node
+	// of NVLink pairs are joined across sockets
+	for name, expected := range map[string]int64{
+		"node-h100":    100,
+		"node-a100":    76,
+		"node-pcie":    30,
+		"node-pairs":   7,
+		"node-labeled": 100,
+	} {
+		score, status := plugin.Score(ctx, state, pod, name)
+		require.True(t, status.IsSuccess())
+		assert.Equal(t, expected, score, name)
+	}
+
+	// A minimum bandwidth filters out discovered nodes that fall short
+	minimum := resource.MustParse("600")
+	pool.Spec.GPURequirements.Topology.MinBandwidth = &minimum
+	plugin = newTestPlugin(t, pool, nil, nodes)
+	_, status = plugin.PreFilter(ctx, state, pod)
+	require.True(t, status.IsSuccess(), status.Message())
+	for name, schedulable := range map[string]bool{
+		"node-h100":    true,
+		"node-a100":    true,
+		"node-pairs":   false,
+		"node-pcie":    false,
+		"node-labeled": true,
+	} {
+		info, err := plugin.handle.SnapshotSharedLister().NodeInfos().Get(name)
+		require.NoError(t, err)
+		assert.Equal(t, schedulable, plugin.Filter(ctx, state, pod, info).IsSuccess(), name)
+	}
+}
+
+func TestScoreGPUTopologyForSingleGPUs(t *testing.T) {
+	s := &GPUTopologyScheduler{config: DefaultSchedulerConfig()}
+	pool := testPool("chat-pool", "chat-agent")
+	pool.Spec.GPURequirements = &neuronetes.GPURequirements{
+		Count:    1,
+		Topology: &neuronetes.TopologyRequirement{Locality: "nvlink"},
+	}
+
+	// A single GPU needs no interconnect
+	assert.Equal(t, 1.0, s.scoreGPUTopology(linkedNode("node-pcie", 2, topology.LinkPIX), pool))
+	assert.Equal(t, 0.0, s.scoreGPUTopology(gpuNode("node-unknown", 2), pool))
+}
@@ -0,0 +1,294 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+	resourcehelper "k8s.io/kubernetes/pkg/api/v1/resource"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/gpu"
+)
+
+const (
+	// nodePoolLabel assigns a NodeClaim to the Karpenter NodePool managing
+	// its node
+	nodePoolLabel = "karpenter.sh/nodepool"
+
+	// capacityTypeLabel is the Karpenter requirement choosing between spot
+	// and on-demand instances
+	capacityTypeLabel = "karpenter.sh/capacity-type"
+
+	// gpuTypeLabel is the node label the GPU type of a pool is matched
+	// against
+	gpuTypeLabel = "neuronetes.io/gpu-type"
+
+	// migPartitionLabel is the node label the GPU operator's MIG manager
+	// partitions the GPUs of a node by, e.g. all-1g.5gb
+	migPartitionLabel = "nvidia.com/mig.config"
+
+	// annotationProvisionedFor names the replica a NodeClaim was created
+	// for
+	annotationProvisionedFor = "neuronetes.io/provisioned-for"
+)
+
+// nodeClaimGVK is the Karpenter NodeClaim kind
+var nodeClaimGVK = schema.GroupVersionKind{Group: "karpenter.sh", Version: "v1beta1", Kind: "NodeClaim"}
+
+// +kubebuilder:rbac:groups=karpenter.sh,resources=nodeclaims,verbs=get;create
+
+// KarpenterArgs configures the Karpenter NodeClaims created for replicas of
+// AgentPools that fit no node
+type KarpenterArgs struct {
+	// NodePool is the Karpenter NodePool the NodeClaims belong to. Its
+	// disruption policy reclaims nodes no replica ended up on.
+	NodePool string `json:"nodePool"`
+
+	// NodeClassRef is the cloud provider's node class nodes are launched
+	// with, e.g. an EC2NodeClass
+	NodeClassRef KarpenterNodeClassRef `json:"nodeClassRef"`
+
+	// GPUTypeRequirement is the instance requirement the lowercased GPU type
+	// of a pool is matched against, e.g. karpenter.k8s.aws/instance-gpu-name.
+	// Without it only the neuronetes.io/gpu-type label of the node is set.
+	GPUTypeRequirement string `json:"gpuTypeRequirement,omitempty"`
+}
+
+// KarpenterNodeClassRef refers to a cloud provider's node class
+type KarpenterNodeClassRef struct {
+	APIVersion string `json:"apiVersion,omitempty"`
+	Kind       string `json:"kind,omitempty"`
+	Name       string `json:"name"`
+}
+
+// nodeProvisioner creates Karpenter NodeClaims for replicas no node fits
+// and tracks them until they are ready
+type nodeProvisioner struct {
+	client client.Client
+	args   KarpenterArgs
+
+	// mu guards pending, the replicas nodes were provisioned for
+	mu      sync.Mutex
+	pending map[types.UID]bool
+}
+
+func newNodeProvisioner(c client.Client, args KarpenterArgs) *nodeProvisioner {
+	return &nodeProvisioner{client: c, args: args, pending: map[types.UID]bool{}}
+}
+
+// PostFilter provisions a node through Karpenter for a replica of an
+// AgentPool that fits no node, unless one is already provisioned for it.
+// Preemption, when the profile enables it, runs first. The replica stays
+// unschedulable until the node joins the cluster.
+func (p *TopologyPlugin) PostFilter(ctx context.Context, state *framework.CycleState, pod *corev1.Pod, filteredNodeStatusMap framework.NodeToStatusMap) (*framework.PostFilterResult, *framework.Status) {
+	if p.provisioner == nil {
+		return nil, framework.NewStatus(framework.Unschedulable)
+	}
+	if _, err := state.Read(poolStateKey); err != nil {
+		// Pods of no pool are left to the rest of the cluster
+		return nil, framework.NewStatus(framework.Unschedulable)
+	}
+	pool, status := readPool(state)
+	if !status.IsSuccess() {
+		return nil, status
+	}
+
+	name, err := p.provisioner.provision(ctx, pod, pool)
+	if err != nil {
+		return nil, framework.AsStatus(err)
+	}
+	if p.metrics != nil && name != "" {
+		p.metrics.NodeProvisions.Inc()
+	}
+	return nil, framework.NewStatus(framework.Unschedulable, fmt.Sprintf("provisioning a node for AgentPool %s through Karpenter", pool.Name))
+}
+
+// provision creates the NodeClaim for pod, returning its name, or an empty
+// name if it already exists
+func (n *nodeProvisioner) provision(ctx context.Context, pod *corev1.Pod, pool *neuronetes.AgentPool) (string, error) {
+	claim := n.nodeClaim(pod, pool)
+	if err := n.client.Create(ctx, claim); err != nil {
+		if apierrors.IsAlreadyExists(err) {
+			n.track(pod)
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to create NodeClaim for %s/%s: %w", pod.Namespace, pod.Name, err)
+	}
+	n.track(pod)
+	log.FromContext(ctx).Info("Provisioning node for replica", "nodeClaim", claim.GetName(), "pod", client.ObjectKeyFromObject(pod), "pool", pool.Name)
+	return claim.GetName(), nil
+}
+
+// nodeClaimName names the NodeClaim of a replica after its UID, so each
+// replica provisions at most one node
+func nodeClaimName(pod *corev1.Pod) string {
+	return "neuronetes-" + string(pod.UID)
+}
+
+// nodeClaim returns the NodeClaim of a node fitting a replica of pool: the
+// requests of pod, with its GPUs as whole GPUs of its vendor, the GPU type, MIG
+// partitioning, capacity type and node selector of pool
+func (n *nodeProvisioner) nodeClaim(pod *corev1.Pod, pool *neuronetes.AgentPool) *unstructured.Unstructured {
+	claim := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	claim.SetGroupVersionKind(nodeClaimGVK)
+	claim.SetName(nodeClaimName(pod))
+	claim.SetLabels(map[string]string{
+		nodePoolLabel:        n.args.NodePool,
+		neuronetes.LabelPool: pool.Name,
+	})
+	claim.SetAnnotations(map[string]string{annotationProvisionedFor: pod.Namespace + "/" + pod.Name})
+
+	requirements := map[string][]string{}
+	if gpu := pool.Spec.GPURequirements; gpu != nil && gpu.Type != "" {
+		requirements[gpuTypeLabel] = []string{gpu.Type}
+		if n.args.GPUTypeRequirement != "" {
+			requirements[n.args.GPUTypeRequirement] = []string{strings.ToLower(gpu.Type)}
+		}
+	}
+	if pool.Spec.MIGProfile != "" {
+		requirements[migPartitionLabel] = []string{"all-" + pool.Spec.MIGProfile}
+	}
+	requirements[capacityTypeLabel] = []string{"on-demand"}
+	if scheduling := pool.Spec.Scheduling; scheduling != nil {
+		if cost := scheduling.CostOptimization; cost != nil && cost.Enabled && cost.SpotEnabled {
+			requirements[capacityTypeLabel] = []string{"spot", "on-demand"}
+		}
+		for key, value := range scheduling.NodeSelector {
+			requirements[key] = []string{value}
+		}
+	}
+
+	keys := make([]string, 0, len(requirements))
+	for key := range requirements {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	nodeRequirements := make([]interface{}, 0, len(keys))
+	for _, key := range keys {
+		values := make([]interface{}, 0, len(requirements[key]))
+		for _, value := range requirements[key] {
+			values = append(values, value)
+		}
+		nodeRequirements = append(nodeRequirements, map[string]interface{}{
+			"key":      key,
+			"operator": string(corev1.NodeSelectorOpIn),
+			"values":   values,
+		})
+	}
+
+	claim.Object["spec"] = map[string]interface{}{
+		"nodeClassRef": map[string]interface{}{
+			"apiVersion": n.args.NodeClassRef.APIVersion,
+			"kind":       n.args.NodeClassRef.Kind,
+			"name":       n.args.NodeClassRef.Name,
+		},
+		"requirements": nodeRequirements,
+		"resources":    map[string]interface{}{"requests": nodeRequests(pod, pool)},
+	}
+	return claim
+}
+
+// nodeRequests returns the resources a node needs for pod. Karpenter knows
+// GPUs only as whole nvidia.com/gpu or amd.com/gpu, so MIG slices, shared
+// GPUs and DRA claims are requested as the GPUs they are carved from.
+func nodeRequests(pod *corev1.Pod, pool *neuronetes.AgentPool) map[string]interface{} {
+	requests := map[string]interface{}{}
+	for name, quantity := range resourcehelper.PodRequests(pod, resourcehelper.PodResourcesOptions{}) {
+		if strings.HasPrefix(string(name), migResourcePrefix) || name == gpu.NVIDIAResource || name == gpu.AMDResource {
+			continue
+		}
+		requests[string(name)] = quantity.String()
+	}
+
+	var gpus int64
+	if gpu := pool.Spec.GPURequirements; gpu != nil {
+		gpus = int64(gpu.Count)
+	}
+	if pool.Spec.MIGProfile != "" || poolSharingStrategy(pool) != "" {
+		gpus = 1
+	}
+	if gpus > 0 {
+		requests[string(vendorResource(poolVendor(pool)))] = resource.NewQuantity(gpus, resource.DecimalSI).String()
+	}
+	return requests
+}
+
+// track records that a node was provisioned for pod
+func (n *nodeProvisioner) track(pod *corev1.Pod) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.pending[pod.UID] = true
+}
+
+// watchProvisioned records the scaling lag of replicas nodes were
+// provisioned for: the time from their creation, as the autoscaler scaled
+// their pool up, to their being ready on the new node
+func (p *TopologyPlugin) watchProvisioned() error {
+	if p.provisioner == nil || p.metrics == nil {
+		return nil
+	}
+	_, err := p.handle.SharedInformerFactory().Core().V1().Pods().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(oldObj, newObj interface{}) { p.observeProvisioned(newObj) },
+		DeleteFunc: p.forgetProvisioned,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to watch provisioned replicas: %w", err)
+	}
+	return nil
+}
+
+// observeProvisioned records the scaling lag of a provisioned replica once
+// it is ready
+func (p *TopologyPlugin) observeProvisioned(obj interface{}) {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok || !podReady(pod) {
+		return
+	}
+	n := p.provisioner
+	n.mu.Lock()
+	pending := n.pending[pod.UID]
+	delete(n.pending, pod.UID)
+	n.mu.Unlock()
+	if pending {
+		p.metrics.ScalingLag.Observe(time.Since(pod.CreationTimestamp.Time).Seconds())
+	}
+}
+
+// forgetProvisioned stops tracking a replica deleted before it was ready
+func (p *TopologyPlugin) forgetProvisioned(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return
+	}
+	n := p.provisioner
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.pending, pod.UID)
+}
+
+// podReady reports whether pod is ready to serve
+func podReady(pod *corev1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
@@ -0,0 +1,101 @@
+package scheduler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/gpu"
+	"github.com/bowenislandsong/neuronetes/pkg/metrics"
+)
+
+func TestTopologyPluginProvisionsNodeClaims(t *testing.T) {
+	ctx := context.Background()
+	pool := testPool("chat-pool", "chat-agent")
+	pool.Spec.GPURequirements = &neuronetes.GPURequirements{Count: 4, Type: "A100"}
+	pool.Spec.Scheduling = &neuronetes.SchedulingConfig{
+		NodeSelector:     map[string]string{"topology.kubernetes.io/zone": "us-east-1a"},
+		CostOptimization: &neuronetes.CostOptimizationConfig{Enabled: true, SpotEnabled: true},
+	}
+	agentMetrics := metrics.NewAgentMetrics(prometheus.NewRegistry())
+	plugin := newTestPlugin(t, pool, agentMetrics, []*corev1.Node{gpuNode("node-a", 2)})
+	plugin.provisioner = newNodeProvisioner(plugin.pools.(client.Client), KarpenterArgs{
+		NodePool:           "gpu",
+		NodeClassRef:       KarpenterNodeClassRef{APIVersion: "karpenter.k8s.aws/v1beta1", Kind: "EC2NodeClass", Name: "default"},
+		GPUTypeRequirement: "karpenter.k8s.aws/instance-gpu-name",
+	})
+
+	pod := poolPod(pool)
+	pod.UID = "4b0f"
+	pod.Spec.Containers = []corev1.Container{{Resources: corev1.ResourceRequirements{Requests: corev1.ResourceList{
+		corev1.ResourceCPU: resource.MustParse("8"),
+		gpu.NVIDIAResource: resource.MustParse("4"),
+	}}}}
+	state := framework.NewCycleState()
+	_, status := plugin.PreFilter(ctx, state, pod)
+	require.True(t, status.IsSuccess(), status.Message())
+
+	// Retries of the replica reuse its NodeClaim
+	for i := 0; i < 2; i++ {
+		_, status = plugin.PostFilter(ctx, state, pod, nil)
+		assert.Equal(t, framework.Unschedulable, status.Code())
+		assert.Contains(t, status.Message(), "provisioning a node for AgentPool chat-pool")
+	}
+	assert.Equal(t, float64(1), testutil.ToFloat64(agentMetrics.NodeProvisions))
+
+	claim := &unstructured.Unstructured{}
+	claim.SetGroupVersionKind(nodeClaimGVK)
+	require.NoError(t, plugin.pools.Get(ctx, client.ObjectKey{Name: "neuronetes-4b0f"}, claim))
+	assert.Equal(t, "gpu", claim.GetLabels()[nodePoolLabel])
+	assert.Equal(t, "default/chat-pool-0", claim.GetAnnotations()[annotationProvisionedFor])
+
+	requests, _, _ := unstructured.NestedStringMap(claim.Object, "spec", "resources", "requests")
+	assert.Equal(t, map[string]string{"cpu": "8", "nvidia.com/gpu": "4"}, requests)
+	requirements, _, _ := unstructured.NestedSlice(claim.Object, "spec", "requirements")
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"key": "karpenter.k8s.aws/instance-gpu-name", "operator": "In", "values": []interface{}{"a100"}},
+		map[string]interface{}{"key": "karpenter.sh/capacity-type", "operator": "In", "values": []interface{}{"spot", "on-demand"}},
+		map[string]interface{}{"key": "neuronetes.io/gpu-type", "operator": "In", "values": []interface{}{"A100"}},
+		map[string]interface{}{"key": "topology.kubernetes.io/zone", "operator": "In", "values": []interface{}{"us-east-1a"}},
+	}, requirements)
+
+	// The scaling lag is recorded once the replica is ready on its new node
+	pod.CreationTimestamp = metav1.Now()
+	plugin.observeProvisioned(pod)
+	var lag dto.Metric
+	require.NoError(t, agentMetrics.ScalingLag.Write(&lag))
+	assert.Zero(t, lag.GetHistogram().GetSampleCount())
+	pod.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}
+	plugin.observeProvisioned(pod)
+	plugin.observeProvisioned(pod)
+	require.NoError(t, agentMetrics.ScalingLag.Write(&lag))
+	assert.Equal(t, uint64(1), lag.GetHistogram().GetSampleCount())
+}
+
+func TestNodeRequestsForMIGSlices(t *testing.T) {
+	pool := testPool("chat-pool", "chat-agent")
+	pool.Spec.MIGProfile = "1g.5gb"
+	pod := poolPod(pool)
+	pod.Spec.Containers = []corev1.Container{{Resources: corev1.ResourceRequirements{Requests: corev1.ResourceList{
+		"nvidia.com/mig-1g.5gb": resource.MustParse("2"),
+	}}}}
+	// MIG slices are requested as the whole GPU they are carved from
+	assert.Equal(t, map[string]interface{}{"nvidia.com/gpu": "1"}, nodeRequests(pod, pool))
+
+	n := newNodeProvisioner(nil, KarpenterArgs{NodePool: "gpu"})
+	requirements, _, _ := unstructured.NestedSlice(n.nodeClaim(pod, pool).Object, "spec", "requirements")
+	assert.Contains(t, requirements, map[string]interface{}{"key": "nvidia.com/mig.config", "operator": "In", "values": []interface{}{"all-1g.5gb"}})
+	assert.Contains(t, requirements, map[string]interface{}{"key": "karpenter.sh/capacity-type", "operator": "In", "values": []interface{}{"on-demand"}})
+}
@@ -0,0 +1,135 @@
+package scheduler
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+)
+
+// Scores of a node by its network distance to the closest pod of a vector
+// store
+const (
+	sameNodeScore   = 1.0
+	sameZoneScore   = 0.7
+	sameRegionScore = 0.4
+	remoteScore     = 0.1
+
+	// unknownLocalityScore is the neutral score of pools without vector
+	// stores, or whose stores are not running
+	unknownLocalityScore = 0.5
+)
+
+// storeMatchLabels identify the pods of a vector store named by a pool's
+// vectorStoreAffinity, besides a pod of that name
+var storeMatchLabels = []string{
+	neuronetes.LabelVectorStore,
+	"app.kubernetes.io/instance",
+	"app.kubernetes.io/name",
+}
+
+// storeNodes returns the nodes the pods of each vector store of the pool, or
+// their caches, run on. Stores are looked up in the pool's namespace.
+func (s *GPUTopologyScheduler) storeNodes(agentPool *neuronetes.AgentPool) ([][]*corev1.Node, error) {
+	stores := agentPool.Spec.Scheduling.DataLocality.VectorStoreAffinity
+	pods, err := s.pods.Pods(agentPool.Namespace).List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+
+	located := make([][]*corev1.Node, len(stores))
+	for _, pod := range pods {
+		if pod.Spec.NodeName == "" || pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		for i, store := range stores {
+			if !servesStore(pod, store) {
+				continue
+			}
+			node, err := s.nodes.Get(pod.Spec.NodeName)
+			if err != nil {
+				continue
+			}
+			located[i] = append(located[i], node)
+		}
+	}
+	return located, nil
+}
+
+// servesStore reports whether pod is part of the vector store named store
+func servesStore(pod *corev1.Pod, store string) bool {
+	if pod.Name == store {
+		return true
+	}
+	for _, label := range storeMatchLabels {
+		if pod.Labels[label] == store {
+			return true
+		}
+	}
+	return false
+}
+
+// networkDistanceScore scores node by its distance to the closest of nodes
+func networkDistanceScore(node *corev1.Node, nodes []*corev1.Node) float64 {
+	best := remoteScore
+	for _, other := range nodes {
+		switch {
+		case other.Name == node.Name:
+			return sameNodeScore
+		case sameTopology(node, other, corev1.LabelTopologyZone):
+			best = max(best, sameZoneScore)
+		case sameTopology(node, other, corev1.LabelTopologyRegion):
+			best = max(best, sameRegionScore)
+		}
+	}
+	return best
+}
+
+// sameTopology reports whether two nodes share the value of a topology label
+func sameTopology(a, b *corev1.Node, label string) bool {
+	value, ok := a.Labels[label]
+	return ok && value != "" && b.Labels[label] == value
+}
+
+// scoreDataLocality scores a node by its network distance to the vector
+// stores of the pool, averaged over the stores that are running
+func (s *GPUTopologyScheduler) scoreDataLocality(ctx context.Context, node *corev1.Node, agentPool *neuronetes.AgentPool) float64 {
+	score, ok := s.dataLocality(ctx, node, agentPool)
+	if !ok {
+		return unknownLocalityScore
+	}
+	return score
+}
+
+// dataLocality returns the network distance score of a node to the running
+// vector stores of the pool, and false if the pool has none
+func (s *GPUTopologyScheduler) dataLocality(ctx context.Context, node *corev1.Node, agentPool *neuronetes.AgentPool) (float64, bool) {
+	if agentPool.Spec.Scheduling == nil || agentPool.Spec.Scheduling.DataLocality == nil {
+		return 0, false
+	}
+	if len(agentPool.Spec.Scheduling.DataLocality.VectorStoreAffinity) == 0 {
+		return 0, false
+	}
+
+	located, err := s.storeNodes(agentPool)
+	if err != nil {
+		log.FromContext(ctx).V(4).Info("scoring node without vector store locations", "pool", agentPool.Name, "error", err.Error())
+		return 0, false
+	}
+	var total float64
+	running := 0
+	for _, nodes := range located {
+		if len(nodes) == 0 {
+			continue
+		}
+		total += networkDistanceScore(node, nodes)
+		running++
+	}
+	if running == 0 {
+		return 0, false
+	}
+	return total / float64(running), true
+}
@@ -0,0 +1,140 @@
+package scheduler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/gpu"
+	"github.com/bowenislandsong/neuronetes/pkg/metrics"
+)
+
+// zoneNode returns a GPU node in zone of region
+func zoneNode(name, region, zone string) *corev1.Node {
+	node := gpuNode(name, 4)
+	node.Labels[corev1.LabelTopologyRegion] = region
+	node.Labels[corev1.LabelTopologyZone] = zone
+	return node
+}
+
+// storePod returns a running pod of a vector store on node
+func storePod(name, store, node string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+			Labels:    map[string]string{neuronetes.LabelVectorStore: store},
+		},
+		Spec:   corev1.PodSpec{NodeName: node},
+		Status: corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+}
+
+func TestScoreDataLocalityByNetworkDistance(t *testing.T) {
+	ctx := context.Background()
+	nodeA := zoneNode("node-a", "us-east1", "us-east1-b")
+	nodeB := zoneNode("node-b", "us-east1", "us-east1-b")
+	nodeC := zoneNode("node-c", "us-east1", "us-east1-c")
+	nodeD := zoneNode("node-d", "europe-west4", "europe-west4-a")
+	s := newTestScheduler(t, &SchedulerConfig{DataLocalityWeight: 1},
+		nodeA, nodeB, nodeC, nodeD,
+		storePod("weaviate-0", "weaviate", "node-a"),
+		// A store is also matched by pod name
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "qdrant-shard-1", Namespace: "default"},
+			Spec:       corev1.PodSpec{NodeName: "node-c"},
+			Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+		},
+		// Stores of other namespaces do not count
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "weaviate-0", Namespace: "other", Labels: map[string]string{neuronetes.LabelVectorStore: "weaviate"}},
+			Spec:       corev1.PodSpec{NodeName: "node-d"},
+			Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+		},
+	)
+
+	pool := testPool("rag-pool", "rag-agent")
+	pool.Spec.Scheduling = &neuronetes.SchedulingConfig{
+		DataLocality: &neuronetes.DataLocalityConfig{VectorStoreAffinity: []string{"weaviate"}},
+	}
+	for node, expected := range map[*corev1.Node]float64{
+		nodeA: sameNodeScore,
+		nodeB: sameZoneScore,
+		nodeC: sameRegionScore,
+		nodeD: remoteScore,
+	} {
+		assert.InDelta(t, expected, s.scoreDataLocality(ctx, node, pool), 1e-9, node.Name)
+	}
+
+	// Scores are averaged over the running stores; stores that are not
+	// running are left out
+	pool.Spec.Scheduling.DataLocality.VectorStoreAffinity = []string{"weaviate", "qdrant-shard-1", "missing"}
+	assert.InDelta(t, (sameNodeScore+sameRegionScore)/2, s.scoreDataLocality(ctx, nodeA, pool), 1e-9)
+	assert.InDelta(t, (sameRegionScore+sameNodeScore)/2, s.scoreDataLocality(ctx, nodeC, pool), 1e-9)
+
+	pool.Spec.Scheduling.DataLocality.VectorStoreAffinity = []string{"missing"}
+	assert.InDelta(t, unknownLocalityScore, s.scoreDataLocality(ctx, nodeA, pool), 1e-9)
+}
+
+func TestScheduleNextToVectorStore(t *testing.T) {
+	s := newTestScheduler(t, &SchedulerConfig{DataLocalityWeight: 1},
+		zoneNode("node-a", "us-east1", "us-east1-b"),
+		zoneNode("node-b", "us-east1", "us-east1-c"),
+		storePod("weaviate-0", "weaviate", "node-b"),
+	)
+	pool := testPool("rag-pool", "rag-agent")
+	pool.Spec.Scheduling = &neuronetes.SchedulingConfig{
+		DataLocality: &neuronetes.DataLocalityConfig{VectorStoreAffinity: []string{"weaviate"}},
+	}
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "rag-0", Namespace: "default"}}
+
+	result, err := s.Schedule(context.Background(), pod, pool)
+	require.NoError(t, err)
+	assert.Equal(t, "node-b", result.Node)
+	assert.Equal(t, int64(100), result.Score)
+}
+
+func TestScheduleRecordsPlacementMetrics(t *testing.T) {
+	ctx := context.Background()
+	near := zoneNode("node-near", "us-east1", "us-east1-b")
+	store := zoneNode("node-store", "us-east1", "us-east1-b")
+	store.Status.Capacity[gpu.NVIDIAResource] = resource.MustParse("1")
+	s := newTestScheduler(t, &SchedulerConfig{DataLocalityWeight: 1},
+		near, store, storePod("weaviate-0", "weaviate", "node-store"))
+	agentMetrics := metrics.NewAgentMetrics(prometheus.NewRegistry())
+	s.SetMetrics(agentMetrics)
+
+	pool := testPool("rag-pool", "rag-agent")
+	pool.Spec.Scheduling = &neuronetes.SchedulingConfig{
+		DataLocality: &neuronetes.DataLocalityConfig{VectorStoreAffinity: []string{"weaviate"}},
+	}
+	result, err := s.Schedule(ctx, poolPod(pool), pool)
+	require.NoError(t, err)
+	assert.Equal(t, "node-store", result.Node)
+	assert.Equal(t, 1.0, testutil.ToFloat64(agentMetrics.DataLocalityRate))
+	assert.InDelta(t, 1-s.scoreGPUTopology(store, pool), testutil.ToFloat64(agentMetrics.TopologyPenaltyScore), 1e-9)
+
+	// Replicas that do not fit next to their stores are misses, and the
+	// replicas of gangs record how long they waited
+	pool.Spec.GPURequirements.Count = 2
+	pod := poolPod(pool)
+	pod.Labels[neuronetes.LabelGang] = pool.Name
+	pod.Annotations = map[string]string{neuronetes.AnnotationGangSize: "2"}
+	pod.CreationTimestamp = metav1.NewTime(time.Now().Add(-5 * time.Second))
+	result, err = s.Schedule(ctx, pod, pool)
+	require.NoError(t, err)
+	assert.Equal(t, "node-near", result.Node)
+	assert.InDelta(t, 0.5, testutil.ToFloat64(agentMetrics.DataLocalityRate), 1e-9)
+	histogram := gangWaits(t, agentMetrics)
+	assert.Equal(t, uint64(1), histogram.GetSampleCount())
+	assert.GreaterOrEqual(t, histogram.GetSampleSum(), 5.0)
+}
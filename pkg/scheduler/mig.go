@@ -0,0 +1,122 @@
+package scheduler
+
+import (
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/gpu"
+)
+
+const (
+	// migResourcePrefix prefixes the extended resources the NVIDIA device
+	// plugin advertises per MIG profile under the mixed strategy, e.g.
+	// nvidia.com/mig-1g.5gb
+	migResourcePrefix = "nvidia.com/mig-"
+
+	// migConfigLabel lists the MIG slices of a node as profile:count pairs,
+	// e.g. 1g.5gb:7,2g.10gb:3, for nodes whose slices are not advertised as
+	// resources
+	migConfigLabel = "neuronetes.io/mig-config"
+)
+
+// migSlices counts MIG slices by profile
+type migSlices map[string]int64
+
+// nodeMIGSlices returns the MIG slices a node offers. Slices the device
+// plugin advertises as allocatable resources take precedence over the
+// neuronetes.io/mig-config label, and that over the devices GPU Feature
+// Discovery labels on nodes whose MIG devices are allocated through DRA.
+func nodeMIGSlices(node *corev1.Node) migSlices {
+	slices := migSlices{}
+	for name, quantity := range node.Status.Allocatable {
+		if profile, ok := strings.CutPrefix(string(name), migResourcePrefix); ok {
+			slices[profile] = quantity.Value()
+		}
+	}
+	if len(slices) > 0 {
+		return slices
+	}
+
+	for _, entry := range strings.Split(node.Labels[migConfigLabel], ",") {
+		profile, count, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if !ok {
+			continue
+		}
+		n, err := strconv.ParseInt(count, 10, 64)
+		if err != nil || n < 0 {
+			continue
+		}
+		slices[profile] += n
+	}
+	if len(slices) > 0 {
+		return slices
+	}
+	return gfdMIGSlices(node)
+}
+
+// podMIGSlices returns the MIG slices requested or claimed by an active pod
+func podMIGSlices(pod *corev1.Pod) migSlices {
+	slices := migSlices{}
+	if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+		return slices
+	}
+	for name, quantity := range gpu.ClaimedResources(pod) {
+		if profile, ok := strings.CutPrefix(string(name), migResourcePrefix); ok {
+			slices[profile] += quantity.Value()
+		}
+	}
+	for _, container := range pod.Spec.Containers {
+		for name, quantity := range container.Resources.Requests {
+			if profile, ok := strings.CutPrefix(string(name), migResourcePrefix); ok {
+				slices[profile] += quantity.Value()
+			}
+		}
+	}
+	return slices
+}
+
+// add adds the slices of other
+func (s migSlices) add(other migSlices) {
+	for profile, n := range other {
+		s[profile] += n
+	}
+}
+
+// requestedMIGSlices returns how many slices of the pool's MIG profile a
+// replica takes: what the pod requests, or one for a pod that requests none
+func requestedMIGSlices(pod *corev1.Pod, agentPool *neuronetes.AgentPool) int64 {
+	if n := podMIGSlices(pod)[agentPool.Spec.MIGProfile]; n > 0 {
+		return n
+	}
+	return 1
+}
+
+// freeMIGSlices returns the slices of profile on node not allocated to pods
+func freeMIGSlices(node *corev1.Node, allocated migSlices, profile string) int64 {
+	free := nodeMIGSlices(node)[profile] - allocated[profile]
+	if free < 0 {
+		return 0
+	}
+	return free
+}
+
+// scoreMIGPack best-fits a replica onto the node its slices fill the most,
+// leaving whole GPUs free on other nodes for larger profiles
+func scoreMIGPack(node *corev1.Node, pod *corev1.Pod, agentPool *neuronetes.AgentPool, allocated migSlices) float64 {
+	profile := agentPool.Spec.MIGProfile
+	if profile == "" {
+		return 0.0
+	}
+	capacity := nodeMIGSlices(node)[profile]
+	if capacity == 0 {
+		return 0.0
+	}
+	free := freeMIGSlices(node, allocated, profile) - requestedMIGSlices(pod, agentPool)
+	if free < 0 {
+		return 0.0
+	}
+	return 1.0 - float64(free)/float64(capacity)
+}
@@ -0,0 +1,106 @@
+package scheduler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+)
+
+// migNode returns a node advertising slices of 1g.5gb as resources
+func migNode(name string, slices int64) *corev1.Node {
+	node := gpuNode(name, 0)
+	node.Status.Allocatable["nvidia.com/mig-1g.5gb"] = *resource.NewQuantity(slices, resource.DecimalSI)
+	return node
+}
+
+// migPod returns a pod on node requesting slices of 1g.5gb
+func migPod(name, node string, slices int64) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec: corev1.PodSpec{
+			NodeName: node,
+			Containers: []corev1.Container{{
+				Name: "agent",
+				Resources: corev1.ResourceRequirements{Requests: corev1.ResourceList{
+					"nvidia.com/mig-1g.5gb": *resource.NewQuantity(slices, resource.DecimalSI),
+				}},
+			}},
+		},
+		Status: corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+}
+
+func TestNodeMIGSlices(t *testing.T) {
+	node := migNode("node-a", 7)
+	node.Status.Allocatable["nvidia.com/mig-2g.10gb"] = resource.MustParse("3")
+	node.Labels[migConfigLabel] = "1g.5gb:1"
+	assert.Equal(t, migSlices{"1g.5gb": 7, "2g.10gb": 3}, nodeMIGSlices(node))
+
+	// Nodes without MIG resources fall back to the label
+	labeled := gpuNode("node-b", 1)
+	labeled.Labels[migConfigLabel] = "1g.5gb:7, 2g.10gb:3,bad,3g.20gb:x"
+	assert.Equal(t, migSlices{"1g.5gb": 7, "2g.10gb": 3}, nodeMIGSlices(labeled))
+
+	assert.Empty(t, nodeMIGSlices(gpuNode("node-c", 8)))
+}
+
+func TestScheduleBinPacksMIGSlices(t *testing.T) {
+	ctx := context.Background()
+	pool := testPool("small-pool", "small-agent")
+	pool.Spec.MIGProfile = "1g.5gb"
+	pod := migPod("small-0", "", 1)
+	done := migPod("done", "node-fresh", 7)
+	done.Status.Phase = corev1.PodSucceeded
+
+	objects := []runtime.Object{
+		migNode("node-full", 7),
+		migNode("node-busy", 7),
+		migNode("node-fresh", 7),
+		migPod("full-0", "node-full", 7),
+		migPod("busy-0", "node-busy", 4),
+		done,
+	}
+	s := newTestScheduler(t, &SchedulerConfig{MIGPackWeight: 1}, objects...)
+
+	// The replica best-fits onto the busy node, the full one has no slice
+	// left
+	result, err := s.Schedule(ctx, pod, pool)
+	require.NoError(t, err)
+	assert.Equal(t, "node-busy", result.Node)
+	assert.Equal(t, int64(71), result.Score)
+
+	// A replica requesting more slices than the busy node has left goes to
+	// the fresh one
+	result, err = s.Schedule(ctx, migPod("large-0", "", 4), pool)
+	require.NoError(t, err)
+	assert.Equal(t, "node-fresh", result.Node)
+}
+
+func TestTopologyPluginFiltersOnFreeMIGSlices(t *testing.T) {
+	ctx := context.Background()
+	pool := testPool("small-pool", "small-agent")
+	pool.Spec.MIGProfile = "1g.5gb"
+	plugin := newTestPlugin(t, pool, nil,
+		[]*corev1.Node{migNode("node-a", 2), migNode("node-b", 2)},
+		migPod("a-0", "node-a", 1),
+	)
+	pod := poolPod(pool)
+	state := framework.NewCycleState()
+	_, status := plugin.PreFilter(ctx, state, pod)
+	require.True(t, status.IsSuccess(), status.Message())
+
+	nodeA, err := plugin.handle.SnapshotSharedLister().NodeInfos().Get("node-a")
+	require.NoError(t, err)
+	assert.True(t, plugin.Filter(ctx, state, pod, nodeA).IsSuccess())
+
+	// A pod assumed onto the node takes its last slice
+	nodeA.AddPod(migPod("a-1", "node-a", 1))
+	assert.False(t, plugin.Filter(ctx, state, pod, nodeA).IsSuccess())
+}
@@ -0,0 +1,423 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+	frameworkruntime "k8s.io/kubernetes/pkg/scheduler/framework/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/dcgm"
+	"github.com/bowenislandsong/neuronetes/pkg/metrics"
+	"github.com/bowenislandsong/neuronetes/pkg/plugins"
+	"github.com/bowenislandsong/neuronetes/pkg/pricing"
+)
+
+const (
+	// Name is the name of the GPU topology plugin in a
+	// KubeSchedulerConfiguration
+	Name = "GPUTopology"
+
+	// SchedulerName is the scheduler profile AgentPool replicas are
+	// scheduled by
+	SchedulerName = "neuronetes-scheduler"
+
+	// defaultGangTimeout is how long a gang waits for its replicas unless
+	// configured otherwise
+	defaultGangTimeout = 60 * time.Second
+
+	// defaultScoreCacheTTL is how long node scores are reused unless
+	// configured otherwise
+	defaultScoreCacheTTL = 30 * time.Second
+
+	// poolStateKey is the CycleState key of the AgentPool being scheduled
+	poolStateKey framework.StateKey = Name + "/pool"
+)
+
+// GPUTopologyArgs are the arguments of the GPU topology plugin. Unset
+// weights keep their default.
+type GPUTopologyArgs struct {
+	GPUTopologyWeight     *float64 `json:"gpuTopologyWeight,omitempty"`
+	ModelCacheWeight      *float64 `json:"modelCacheWeight,omitempty"`
+	CostWeight            *float64 `json:"costWeight,omitempty"`
+	DataLocalityWeight    *float64 `json:"dataLocalityWeight,omitempty"`
+	CachePackWeight       *float64 `json:"cachePackWeight,omitempty"`
+	SpreadWeight          *float64 `json:"spreadWeight,omitempty"`
+	MIGPackWeight         *float64 `json:"migPackWeight,omitempty"`
+	VRAMPackWeight        *float64 `json:"vramPackWeight,omitempty"`
+	TelemetryWeight       *float64 `json:"telemetryWeight,omitempty"`
+	SessionAffinityWeight *float64 `json:"sessionAffinityWeight,omitempty"`
+	GangTimeoutSeconds    *int64   `json:"gangTimeoutSeconds,omitempty"`
+
+	// ScoreCacheTTLSeconds is how long the scores of a node that depend
+	// only on the node and pool are reused; 0 disables the cache
+	ScoreCacheTTLSeconds *int64 `json:"scoreCacheTTLSeconds,omitempty"`
+
+	// DCGMExporterPort enables scoring on live GPU telemetry, scraped from
+	// the dcgm-exporter serving on this port of each node
+	DCGMExporterPort *int `json:"dcgmExporterPort,omitempty"`
+
+	// PricingProvider enables scoring nodes on the price of their GPUs and
+	// filtering them by the maxCostPerHour of pools: aws, gcp, azure, or
+	// static to only use PricingTable
+	PricingProvider string `json:"pricingProvider,omitempty"`
+
+	// PricingTable is a file of instance type prices that take precedence
+	// over the provider's
+	PricingTable string `json:"pricingTable,omitempty"`
+
+	// GCPAPIKeyFile is a file holding the API key the gcp provider reads the
+	// Cloud Billing Catalog API with
+	GCPAPIKeyFile string `json:"gcpAPIKeyFile,omitempty"`
+
+	// SimulationAddress serves simulations of where the replicas of an
+	// AgentPool would be placed on this address, e.g. :10260
+	SimulationAddress string `json:"simulationAddress,omitempty"`
+
+	// PluginWeights weights the scores of registered scheduler plugins by
+	// name
+	PluginWeights map[string]float64 `json:"pluginWeights,omitempty"`
+
+	// Karpenter provisions nodes through Karpenter NodeClaims for replicas
+	// that fit no node
+	Karpenter *KarpenterArgs `json:"karpenter,omitempty"`
+}
+
+// DefaultSchedulerConfig returns the scoring weights used when none are
+// configured
+func DefaultSchedulerConfig() *SchedulerConfig {
+	return &SchedulerConfig{
+		GPUTopologyWeight:     0.4,
+		ModelCacheWeight:      0.2,
+		CostWeight:            0.15,
+		DataLocalityWeight:    0.1,
+		CachePackWeight:       0.1,
+		SpreadWeight:          0.05,
+		MIGPackWeight:         0.1,
+		VRAMPackWeight:        0.1,
+		TelemetryWeight:       0.1,
+		SessionAffinityWeight: 0.1,
+		GangTimeout:           defaultGangTimeout,
+		ScoreCacheTTL:         defaultScoreCacheTTL,
+	}
+}
+
+// config applies the arguments to the default configuration
+func (args *GPUTopologyArgs) config() *SchedulerConfig {
+	config := DefaultSchedulerConfig()
+	for _, w := range []struct {
+		arg    *float64
+		weight *float64
+	}{
+		{args.GPUTopologyWeight, &config.GPUTopologyWeight},
+		{args.ModelCacheWeight, &config.ModelCacheWeight},
+		{args.CostWeight, &config.CostWeight},
+		{args.DataLocalityWeight, &config.DataLocalityWeight},
+		{args.CachePackWeight, &config.CachePackWeight},
+		{args.SpreadWeight, &config.SpreadWeight},
+		{args.MIGPackWeight, &config.MIGPackWeight},
+		{args.VRAMPackWeight, &config.VRAMPackWeight},
+		{args.TelemetryWeight, &config.TelemetryWeight},
+		{args.SessionAffinityWeight, &config.SessionAffinityWeight},
+	} {
+		if w.arg != nil {
+			*w.weight = *w.arg
+		}
+	}
+	if len(args.PluginWeights) > 0 {
+		config.PluginWeights = args.PluginWeights
+	}
+	if args.GangTimeoutSeconds != nil {
+		config.GangTimeout = time.Duration(*args.GangTimeoutSeconds) * time.Second
+	}
+	if args.ScoreCacheTTLSeconds != nil {
+		config.ScoreCacheTTL = time.Duration(*args.ScoreCacheTTLSeconds) * time.Second
+	}
+	return config
+}
+
+// pricing creates the configured pricing provider
+func (args *GPUTopologyArgs) pricing() (pricing.Provider, error) {
+	config := pricing.Config{Provider: args.PricingProvider, Table: args.PricingTable}
+	if args.GCPAPIKeyFile != "" {
+		key, err := os.ReadFile(args.GCPAPIKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.GCPAPIKey = strings.TrimSpace(string(key))
+	}
+	return pricing.New(context.Background(), config)
+}
+
+// TopologyPlugin runs the GPUTopologyScheduler's filters and scoring as a
+// kube-scheduler framework plugin. Pods without the neuronetes.io/pool label
+// are left to the other plugins of the profile.
+type TopologyPlugin struct {
+	handle    framework.Handle
+	pools     client.Reader
+	scheduler *GPUTopologyScheduler
+	metrics   *metrics.AgentMetrics
+
+	// provisioner creates nodes for replicas that fit no node, if set
+	provisioner *nodeProvisioner
+
+	// gangsMu guards gangs, the time the first waiting replica of each gang
+	// started waiting
+	gangsMu sync.Mutex
+	gangs   map[string]time.Time
+
+	// shardsMu guards shards, the plans of the reserved replicas of sharded
+	// models their annotations do not record yet
+	shardsMu sync.Mutex
+	shards   map[types.UID]reservedShard
+}
+
+var (
+	_ framework.PreFilterPlugin  = &TopologyPlugin{}
+	_ framework.FilterPlugin     = &TopologyPlugin{}
+	_ framework.PostFilterPlugin = &TopologyPlugin{}
+	_ framework.PreScorePlugin   = &TopologyPlugin{}
+	_ framework.ScorePlugin      = &TopologyPlugin{}
+	_ framework.ReservePlugin    = &TopologyPlugin{}
+	_ framework.PermitPlugin     = &TopologyPlugin{}
+	_ framework.PreBindPlugin    = &TopologyPlugin{}
+)
+
+// NewPluginFactory returns the factory registering the plugin with the
+// scheduler. Placements, preempted replicas and the scaling lag of replicas
+// nodes were provisioned for are recorded in agentMetrics, if set.
+func NewPluginFactory(agentMetrics *metrics.AgentMetrics) frameworkruntime.PluginFactory {
+	return func(configuration runtime.Object, handle framework.Handle) (framework.Plugin, error) {
+		args := &GPUTopologyArgs{}
+		if configuration != nil {
+			if err := frameworkruntime.DecodeInto(configuration, args); err != nil {
+				return nil, fmt.Errorf("failed to decode %s args: %w", Name, err)
+			}
+		}
+
+		scheme := runtime.NewScheme()
+		utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+		utilruntime.Must(neuronetes.AddToScheme(scheme))
+		pools, err := client.New(handle.KubeConfig(), client.Options{Scheme: scheme})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create AgentPool client: %w", err)
+		}
+
+		plugin := newTopologyPlugin(handle, pools, args.config(), agentMetrics)
+		plugin.scheduler.SetPlugins(plugins.GetGlobalRegistry())
+		if agentMetrics != nil {
+			if err := plugins.GetGlobalRegistry().RegisterMetrics(agentMetrics); err != nil {
+				return nil, fmt.Errorf("failed to register plugin metrics: %w", err)
+			}
+		}
+		if args.DCGMExporterPort != nil && *args.DCGMExporterPort > 0 {
+			// Nodes running replicas are scraped in the background, off
+			// the scheduling path; other nodes when they are scored
+			bridge := dcgm.NewBridge(pools, dcgm.NewClient(dcgm.Config{Port: *args.DCGMExporterPort}), nil, 0)
+			go func() {
+				utilruntime.HandleError(bridge.Start(context.Background()))
+			}()
+			plugin.scheduler.SetTelemetrySource(bridge)
+		}
+		if args.PricingProvider != "" {
+			provider, err := args.pricing()
+			if err != nil {
+				return nil, fmt.Errorf("failed to create %s pricing provider: %w", args.PricingProvider, err)
+			}
+			plugin.scheduler.SetPricing(provider)
+		}
+		if args.Karpenter != nil {
+			plugin.provisioner = newNodeProvisioner(pools, *args.Karpenter)
+		}
+		if err := plugin.watchPreemptions(); err != nil {
+			return nil, err
+		}
+		if err := plugin.watchProvisioned(); err != nil {
+			return nil, err
+		}
+		if args.SimulationAddress != "" {
+			if err := plugin.serveSimulations(args.SimulationAddress); err != nil {
+				return nil, err
+			}
+		}
+		return plugin, nil
+	}
+}
+
+func newTopologyPlugin(handle framework.Handle, pools client.Reader, config *SchedulerConfig, agentMetrics *metrics.AgentMetrics) *TopologyPlugin {
+	scheduler := NewGPUTopologyScheduler(handle.SharedInformerFactory(), config)
+	scheduler.SetMetrics(agentMetrics)
+	return &TopologyPlugin{
+		handle:    handle,
+		pools:     pools,
+		scheduler: scheduler,
+		metrics:   agentMetrics,
+		gangs:     map[string]time.Time{},
+		shards:    map[types.UID]reservedShard{},
+	}
+}
+
+// Name returns the plugin name
+func (p *TopologyPlugin) Name() string {
+	return Name
+}
+
+// poolState carries the pod's AgentPool through a scheduling cycle
+type poolState struct {
+	pool *neuronetes.AgentPool
+}
+
+// Clone shares the state, which is never modified
+func (s *poolState) Clone() framework.StateData {
+	return s
+}
+
+// PreFilter looks up the AgentPool of the pod. Other pods skip the plugin.
+func (p *TopologyPlugin) PreFilter(ctx context.Context, state *framework.CycleState, pod *corev1.Pod) (*framework.PreFilterResult, *framework.Status) {
+	poolName := pod.Labels[neuronetes.LabelPool]
+	if poolName == "" {
+		return nil, framework.NewStatus(framework.Skip)
+	}
+
+	pool := &neuronetes.AgentPool{}
+	if err := p.pools.Get(ctx, client.ObjectKey{Namespace: pod.Namespace, Name: poolName}, pool); err != nil {
+		return nil, framework.AsStatus(fmt.Errorf("failed to get AgentPool %s: %w", poolName, err))
+	}
+	state.Write(poolStateKey, &poolState{pool: pool})
+	return nil, nil
+}
+
+// PreFilterExtensions returns nil as the filters do not depend on other pods
+func (p *TopologyPlugin) PreFilterExtensions() framework.PreFilterExtensions {
+	return nil
+}
+
+// Filter rejects nodes without the GPUs, labels, free MIG slices or free
+// VRAM the pool requires, and those a registered scheduler plugin rejects
+func (p *TopologyPlugin) Filter(ctx context.Context, state *framework.CycleState, pod *corev1.Pod, nodeInfo *framework.NodeInfo) *framework.Status {
+	pool, status := readPool(state)
+	if !status.IsSuccess() {
+		return status
+	}
+	node := nodeInfo.Node()
+	if node == nil {
+		return framework.NewStatus(framework.Error, "node not found")
+	}
+	if reason := p.scheduler.filterNode(ctx, node, pod, pool, allocationOf(nodeInfo)); reason != "" {
+		return framework.NewStatus(framework.Unschedulable, fmt.Sprintf("node does not meet the GPU requirements of AgentPool %s: %s", pool.Name, reason))
+	}
+	return nil
+}
+
+// PreScore skips scoring for pods of no AgentPool
+func (p *TopologyPlugin) PreScore(ctx context.Context, state *framework.CycleState, pod *corev1.Pod, nodes []*corev1.Node) *framework.Status {
+	if _, err := state.Read(poolStateKey); err != nil {
+		return framework.NewStatus(framework.Skip)
+	}
+	return nil
+}
+
+// Score rates a node with the GPUTopologyScheduler's weighted scoring,
+// recording the score of each registered scheduler plugin. The
+// replicas of the pool's AgentClass and the MIG slices and VRAM already
+// allocated on the node are taken from the scheduler's snapshot, so pods assumed earlier in the
+// same batch count too.
+func (p *TopologyPlugin) Score(ctx context.Context, state *framework.CycleState, pod *corev1.Pod, nodeName string) (int64, *framework.Status) {
+	pool, status := readPool(state)
+	if !status.IsSuccess() {
+		return 0, status
+	}
+	nodeInfo, err := p.handle.SnapshotSharedLister().NodeInfos().Get(nodeName)
+	if err != nil {
+		return 0, framework.AsStatus(fmt.Errorf("failed to get node %s: %w", nodeName, err))
+	}
+
+	scores := p.scheduler.scoreBreakdown(ctx, nodeInfo.Node(), pod, pool, classReplicas(nodeInfo, pool), allocationOf(nodeInfo))
+	if p.metrics != nil {
+		for name, pluginScore := range scores.Plugins {
+			p.metrics.RecordPluginScore(ctx, name, pluginScore*100)
+		}
+	}
+	score := scores.Total
+	if score > framework.MaxNodeScore {
+		score = framework.MaxNodeScore
+	}
+	return score, nil
+}
+
+// ScoreExtensions returns nil as scores are already within 0-100
+func (p *TopologyPlugin) ScoreExtensions() framework.ScoreExtensions {
+	return nil
+}
+
+// Reserve plans the shard a replica of a sharded model serves on the chosen
+// node, and records how far the node is from the pool's preferred GPU
+// topology and whether it is colocated with the pool's vector stores
+func (p *TopologyPlugin) Reserve(ctx context.Context, state *framework.CycleState, pod *corev1.Pod, nodeName string) *framework.Status {
+	pool, status := readPool(state)
+	if !status.IsSuccess() {
+		// Pods of no pool have nothing to record
+		return nil
+	}
+	if status := p.planShard(pod, pool, nodeName); !status.IsSuccess() {
+		return status
+	}
+	if p.metrics == nil {
+		return nil
+	}
+	nodeInfo, err := p.handle.SnapshotSharedLister().NodeInfos().Get(nodeName)
+	if err != nil {
+		return framework.AsStatus(fmt.Errorf("failed to get node %s: %w", nodeName, err))
+	}
+	p.scheduler.recordPlacement(ctx, nodeInfo.Node(), pool)
+	return nil
+}
+
+// Unreserve releases the other waiting replicas of the pod's gang, which can
+// no longer be completed, and the plan of its shard. The penalty gauge is
+// left to the next placement.
+func (p *TopologyPlugin) Unreserve(ctx context.Context, state *framework.CycleState, pod *corev1.Pod, nodeName string) {
+	p.forgetShard(pod)
+	p.rejectGang(pod)
+}
+
+// readPool returns the AgentPool stored by PreFilter
+func readPool(state *framework.CycleState) (*neuronetes.AgentPool, *framework.Status) {
+	data, err := state.Read(poolStateKey)
+	if err != nil {
+		return nil, framework.AsStatus(fmt.Errorf("failed to read AgentPool from cycle state: %w", err))
+	}
+	s, ok := data.(*poolState)
+	if !ok {
+		return nil, framework.AsStatus(fmt.Errorf("unexpected cycle state %T", data))
+	}
+	return s.pool, nil
+}
+
+// classReplicas returns the number of active replicas of the pool's
+// AgentClass on a node
+func classReplicas(nodeInfo *framework.NodeInfo, pool *neuronetes.AgentPool) int {
+	count := 0
+	for _, podInfo := range nodeInfo.Pods {
+		pod := podInfo.Pod
+		if pod.Namespace != pool.Namespace || pod.Labels[neuronetes.LabelAgentClass] != pool.Spec.AgentClassRef.Name {
+			continue
+		}
+		if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		count++
+	}
+	return count
+}
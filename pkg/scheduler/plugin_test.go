@@ -0,0 +1,195 @@
+package scheduler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/metrics"
+)
+
+// fakeSnapshot is a scheduler snapshot of fixed nodes
+type fakeSnapshot map[string]*framework.NodeInfo
+
+func (s fakeSnapshot) NodeInfos() framework.NodeInfoLister       { return s }
+func (s fakeSnapshot) StorageInfos() framework.StorageInfoLister { return nil }
+
+func (s fakeSnapshot) List() ([]*framework.NodeInfo, error) {
+	var infos []*framework.NodeInfo
+	for _, info := range s {
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+func (s fakeSnapshot) HavePodsWithAffinityList() ([]*framework.NodeInfo, error) { return nil, nil }
+
+func (s fakeSnapshot) HavePodsWithRequiredAntiAffinityList() ([]*framework.NodeInfo, error) {
+	return nil, nil
+}
+
+func (s fakeSnapshot) Get(nodeName string) (*framework.NodeInfo, error) {
+	info, ok := s[nodeName]
+	if !ok {
+		return nil, assert.AnError
+	}
+	return info, nil
+}
+
+// fakeWaitingPod is a pod waiting in Permit
+type fakeWaitingPod struct {
+	framework.WaitingPod
+	pod      *corev1.Pod
+	allowed  bool
+	rejected bool
+}
+
+func (w *fakeWaitingPod) GetPod() *corev1.Pod           { return w.pod }
+func (w *fakeWaitingPod) Allow(pluginName string)       { w.allowed = true }
+func (w *fakeWaitingPod) Reject(pluginName, msg string) { w.rejected = true }
+
+// fakeHandle provides the parts of a framework handle the plugin uses
+type fakeHandle struct {
+	framework.Handle
+	snapshot  fakeSnapshot
+	waiting   []*fakeWaitingPod
+	clientset *kubefake.Clientset
+}
+
+func (h *fakeHandle) SnapshotSharedLister() framework.SharedLister { return h.snapshot }
+func (h *fakeHandle) ClientSet() kubernetes.Interface              { return h.clientset }
+func (h *fakeHandle) SharedInformerFactory() informers.SharedInformerFactory {
+	return informers.NewSharedInformerFactory(kubefake.NewSimpleClientset(), 0)
+}
+
+func (h *fakeHandle) IterateOverWaitingPods(callback func(framework.WaitingPod)) {
+	for _, w := range h.waiting {
+		callback(w)
+	}
+}
+
+// newTestPlugin returns the plugin scheduling onto nodes, with pods already
+// running on the node named by their NodeName
+func newTestPlugin(t *testing.T, pool *neuronetes.AgentPool, agentMetrics *metrics.AgentMetrics, nodes []*corev1.Node, pods ...*corev1.Pod) *TopologyPlugin {
+	t.Helper()
+	snapshot := fakeSnapshot{}
+	for _, node := range nodes {
+		info := framework.NewNodeInfo()
+		info.SetNode(node)
+		snapshot[node.Name] = info
+	}
+	for _, pod := range pods {
+		snapshot[pod.Spec.NodeName].AddPod(pod)
+	}
+
+	scheme := runtime.NewScheme()
+	utilruntime.Must(neuronetes.AddToScheme(scheme))
+	pools := fake.NewClientBuilder().WithScheme(scheme).WithObjects(pool).Build()
+	return newTopologyPlugin(&fakeHandle{snapshot: snapshot, clientset: kubefake.NewSimpleClientset()}, pools, &SchedulerConfig{GPUTopologyWeight: 1}, agentMetrics)
+}
+
+func poolPod(pool *neuronetes.AgentPool) *corev1.Pod {
+	return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name:      pool.Name + "-0",
+		Namespace: pool.Namespace,
+		Labels: map[string]string{
+			neuronetes.LabelPool:       pool.Name,
+			neuronetes.LabelAgentClass: pool.Spec.AgentClassRef.Name,
+		},
+	}}
+}
+
+func TestTopologyPluginSkipsPodsOfNoPool(t *testing.T) {
+	pool := testPool("chat-pool", "chat-agent")
+	plugin := newTestPlugin(t, pool, nil, nil)
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"}}
+	state := framework.NewCycleState()
+
+	_, status := plugin.PreFilter(context.Background(), state, pod)
+	assert.True(t, status.IsSkip())
+	assert.True(t, plugin.PreScore(context.Background(), state, pod, nil).IsSkip())
+	assert.True(t, plugin.Reserve(context.Background(), state, pod, "node-a").IsSuccess())
+}
+
+func TestTopologyPluginFiltersAndScores(t *testing.T) {
+	ctx := context.Background()
+	pool := testPool("tp-pool", "tp-agent")
+	pool.Spec.GPURequirements = &neuronetes.GPURequirements{
+		Count:    2,
+		Topology: &neuronetes.TopologyRequirement{Locality: "nvlink"},
+	}
+
+	nvlink := gpuNode("node-nvlink", 8)
+	nvlink.Labels["neuronetes.io/gpu-topology"] = "nvlink"
+	pcie := gpuNode("node-pcie", 8)
+	pcie.Labels["neuronetes.io/gpu-topology"] = "pcie"
+	small := gpuNode("node-small", 1)
+
+	agentMetrics := metrics.NewAgentMetrics(prometheus.NewRegistry())
+	plugin := newTestPlugin(t, pool, agentMetrics, []*corev1.Node{nvlink, pcie, small})
+	pod := poolPod(pool)
+	state := framework.NewCycleState()
+
+	_, status := plugin.PreFilter(ctx, state, pod)
+	require.True(t, status.IsSuccess(), status.Message())
+	require.True(t, plugin.PreScore(ctx, state, pod, nil).IsSuccess())
+
+	// A node with too few GPUs is filtered out
+	for name, schedulable := range map[string]bool{"node-nvlink": true, "node-pcie": true, "node-small": false} {
+		info, err := plugin.handle.SnapshotSharedLister().NodeInfos().Get(name)
+		require.NoError(t, err)
+		assert.Equal(t, schedulable, plugin.Filter(ctx, state, pod, info).IsSuccess(), name)
+	}
+
+	// The NVLink node scores higher
+	nvlinkScore, status := plugin.Score(ctx, state, pod, "node-nvlink")
+	require.True(t, status.IsSuccess())
+	pcieScore, status := plugin.Score(ctx, state, pod, "node-pcie")
+	require.True(t, status.IsSuccess())
+	assert.Equal(t, int64(100), nvlinkScore)
+	assert.Equal(t, int64(30), pcieScore)
+
+	// Reserving a node records its topology penalty
+	require.True(t, plugin.Reserve(ctx, state, pod, "node-pcie").IsSuccess())
+	assert.InDelta(t, 0.7, testutil.ToFloat64(agentMetrics.TopologyPenaltyScore), 1e-9)
+}
+
+func TestTopologyPluginCountsClassReplicasFromSnapshot(t *testing.T) {
+	pool := testPool("chat-pool", "chat-agent")
+	done := classPod("chat-done", "default", "chat-agent", "node-a")
+	done.Status.Phase = corev1.PodSucceeded
+	plugin := newTestPlugin(t, pool, nil,
+		[]*corev1.Node{gpuNode("node-a", 4), gpuNode("node-b", 4)},
+		classPod("chat-0", "default", "chat-agent", "node-a"),
+		classPod("chat-1", "default", "chat-agent", "node-a"),
+		classPod("chat-0", "other", "chat-agent", "node-b"),
+		done,
+	)
+
+	for node, want := range map[string]int{"node-a": 2, "node-b": 0} {
+		info, err := plugin.handle.SnapshotSharedLister().NodeInfos().Get(node)
+		require.NoError(t, err)
+		assert.Equal(t, want, classReplicas(info, pool), node)
+	}
+}
+
+func TestGPUTopologyArgs(t *testing.T) {
+	spread := 0.5
+	config := (&GPUTopologyArgs{SpreadWeight: &spread}).config()
+	assert.Equal(t, 0.5, config.SpreadWeight)
+	assert.Equal(t, DefaultSchedulerConfig().GPUTopologyWeight, config.GPUTopologyWeight)
+}
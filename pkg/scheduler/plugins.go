@@ -0,0 +1,73 @@
+package scheduler
+
+import (
+	"context"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/plugins"
+)
+
+// DefaultPluginWeight weights the score of scheduler plugins without a
+// weight in SchedulerConfig.PluginWeights
+const DefaultPluginWeight = 0.1
+
+// SetPlugins runs the scheduler plugins of registry after the built-in
+// filters and scores, highest priority first. Nodes are scored in parallel,
+// so plugins must be safe for concurrent use.
+func (s *GPUTopologyScheduler) SetPlugins(registry *plugins.PluginRegistry) {
+	s.plugins = registry
+}
+
+// schedulerPlugins returns the registered plugins, highest priority first
+func (s *GPUTopologyScheduler) schedulerPlugins() []plugins.SchedulerPlugin {
+	if s.plugins == nil {
+		return nil
+	}
+	registered := append([]plugins.SchedulerPlugin(nil), s.plugins.GetSchedulers()...)
+	sort.SliceStable(registered, func(i, j int) bool {
+		return registered[i].Priority() > registered[j].Priority()
+	})
+	return registered
+}
+
+// pluginWeight returns the configured weight of the named plugin
+func (s *GPUTopologyScheduler) pluginWeight(name string) float64 {
+	if weight, ok := s.config.PluginWeights[name]; ok {
+		return weight
+	}
+	return DefaultPluginWeight
+}
+
+// filterPlugins returns the first plugin, in priority order, rejecting node,
+// or an empty string if all accept it
+func (s *GPUTopologyScheduler) filterPlugins(ctx context.Context, node *corev1.Node, pod *corev1.Pod, agentPool *neuronetes.AgentPool) string {
+	for _, plugin := range s.schedulerPlugins() {
+		if !plugin.Filter(ctx, pod, node, agentPool) {
+			return plugin.Name()
+		}
+	}
+	return ""
+}
+
+// scorePlugins returns the score of each plugin for node (0.0-1.0), by
+// plugin name. Scores outside 0-100 are clamped.
+func (s *GPUTopologyScheduler) scorePlugins(ctx context.Context, node *corev1.Node, pod *corev1.Pod, agentPool *neuronetes.AgentPool) map[string]float64 {
+	registered := s.schedulerPlugins()
+	if len(registered) == 0 {
+		return nil
+	}
+	scores := make(map[string]float64, len(registered))
+	for _, plugin := range registered {
+		score := plugin.Score(ctx, pod, node, agentPool)
+		if score < 0 {
+			score = 0
+		} else if score > 100 {
+			score = 100
+		}
+		scores[plugin.Name()] = float64(score) / 100
+	}
+	return scores
+}
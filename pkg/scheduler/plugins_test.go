@@ -0,0 +1,114 @@
+package scheduler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/metrics"
+	"github.com/bowenislandsong/neuronetes/pkg/plugins"
+)
+
+// nodePlugin rejects some nodes and scores the others by name
+type nodePlugin struct {
+	name     string
+	priority int
+	rejected map[string]bool
+	scores   map[string]int64
+
+	// filtered is the nodes the plugin filtered, in order
+	filtered *[]string
+}
+
+func (p *nodePlugin) Name() string  { return p.name }
+func (p *nodePlugin) Priority() int { return p.priority }
+
+func (p *nodePlugin) Filter(ctx context.Context, pod *corev1.Pod, node *corev1.Node, pool *neuronetes.AgentPool) bool {
+	if p.filtered != nil {
+		*p.filtered = append(*p.filtered, p.name)
+	}
+	return !p.rejected[node.Name]
+}
+
+func (p *nodePlugin) Score(ctx context.Context, pod *corev1.Pod, node *corev1.Node, pool *neuronetes.AgentPool) int64 {
+	return p.scores[node.Name]
+}
+
+func newPluginRegistry(registered ...plugins.SchedulerPlugin) *plugins.PluginRegistry {
+	registry := plugins.NewPluginRegistry()
+	for _, plugin := range registered {
+		registry.RegisterScheduler(plugin)
+	}
+	return registry
+}
+
+func TestScheduleRunsPlugins(t *testing.T) {
+	ctx := context.Background()
+	s := newTestScheduler(t, &SchedulerConfig{PluginWeights: map[string]float64{"latency": 0.5}},
+		gpuNode("node-a", 8), gpuNode("node-b", 8), gpuNode("node-c", 8))
+
+	var order []string
+	latency := &nodePlugin{
+		name: "latency", priority: 10, filtered: &order,
+		scores: map[string]int64{"node-a": 20, "node-b": 80, "node-c": 100},
+	}
+	zone := &nodePlugin{
+		name: "zone", priority: 100, filtered: &order,
+		rejected: map[string]bool{"node-c": true},
+		scores:   map[string]int64{"node-a": 150, "node-b": -5},
+	}
+	s.SetPlugins(newPluginRegistry(latency, zone))
+
+	pool := testPool("chat-pool", "chat-agent")
+	result, err := s.Schedule(ctx, poolPod(pool), pool)
+	require.NoError(t, err)
+	// 0.8 weighted 0.5 beats 0.2 weighted 0.5 plus 1.0 weighted
+	// DefaultPluginWeight
+	assert.Equal(t, "node-b", result.Node)
+	assert.Equal(t, int64(40), result.Score)
+
+	// Higher priority plugins filter first, and the first rejection stops
+	order = nil
+	reason := s.filterNode(ctx, gpuNode("node-c", 8), poolPod(pool), pool, nodeAllocation{})
+	assert.Equal(t, "rejected by scheduler plugin zone", reason)
+	assert.Equal(t, []string{"zone"}, order)
+
+	// Scores outside 0-100 are clamped
+	scores := s.scoreBreakdown(ctx, gpuNode("node-a", 8), poolPod(pool), pool, 0, nodeAllocation{})
+	assert.Equal(t, map[string]float64{"latency": 0.2, "zone": 1}, scores.Plugins)
+	scores = s.scoreBreakdown(ctx, gpuNode("node-b", 8), poolPod(pool), pool, 0, nodeAllocation{})
+	assert.Equal(t, map[string]float64{"latency": 0.8, "zone": 0}, scores.Plugins)
+}
+
+func TestTopologyPluginRecordsPluginScores(t *testing.T) {
+	ctx := context.Background()
+	pool := testPool("chat-pool", "chat-agent")
+	agentMetrics := metrics.NewAgentMetrics(prometheus.NewRegistry())
+	plugin := newTestPlugin(t, pool, agentMetrics, []*corev1.Node{gpuNode("node-a", 8), gpuNode("node-b", 8)})
+	plugin.scheduler.SetPlugins(newPluginRegistry(&nodePlugin{
+		name:     "zone",
+		rejected: map[string]bool{"node-b": true},
+		scores:   map[string]int64{"node-a": 60},
+	}))
+	pod := poolPod(pool)
+	state := framework.NewCycleState()
+	_, status := plugin.PreFilter(ctx, state, pod)
+	require.True(t, status.IsSuccess(), status.Message())
+
+	info, err := plugin.handle.SnapshotSharedLister().NodeInfos().Get("node-b")
+	require.NoError(t, err)
+	status = plugin.Filter(ctx, state, pod, info)
+	assert.Equal(t, framework.Unschedulable, status.Code())
+	assert.Contains(t, status.Message(), "rejected by scheduler plugin zone")
+
+	_, status = plugin.Score(ctx, state, pod, "node-a")
+	require.True(t, status.IsSuccess())
+	assert.Equal(t, 1, testutil.CollectAndCount(agentMetrics.SchedulerPluginScore))
+}
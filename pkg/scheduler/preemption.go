@@ -0,0 +1,54 @@
+package scheduler
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+)
+
+// watchPreemptions counts the replicas of AgentPools that are preempted.
+// Preemption itself is left to the DefaultPreemption plugin of the profile,
+// which evicts replicas of lower priority pools when a higher priority
+// replica fits nowhere; this plugin's filters run against the node with the
+// victims removed.
+func (p *TopologyPlugin) watchPreemptions() error {
+	if p.metrics == nil {
+		return nil
+	}
+	_, err := p.handle.SharedInformerFactory().Core().V1().Pods().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: p.observePreemption,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to watch pod preemptions: %w", err)
+	}
+	return nil
+}
+
+// observePreemption records a replica the scheduler marked for preemption
+func (p *TopologyPlugin) observePreemption(oldObj, newObj interface{}) {
+	oldPod, ok := oldObj.(*corev1.Pod)
+	if !ok {
+		return
+	}
+	pod, ok := newObj.(*corev1.Pod)
+	if !ok || pod.Labels[neuronetes.LabelPool] == "" {
+		return
+	}
+	if preempted(pod) && !preempted(oldPod) {
+		p.metrics.ReplicaPreemptions.Inc()
+	}
+}
+
+// preempted reports whether the scheduler is evicting pod to make room for a
+// higher priority pod
+func preempted(pod *corev1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.DisruptionTarget && condition.Status == corev1.ConditionTrue {
+			return condition.Reason == corev1.PodReasonPreemptionByScheduler
+		}
+	}
+	return false
+}
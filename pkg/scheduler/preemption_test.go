@@ -0,0 +1,44 @@
+package scheduler
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/bowenislandsong/neuronetes/pkg/metrics"
+)
+
+// withDisruption returns a copy of pod marked for disruption for reason
+func withDisruption(pod *corev1.Pod, reason string) *corev1.Pod {
+	pod = pod.DeepCopy()
+	pod.Status.Conditions = append(pod.Status.Conditions, corev1.PodCondition{
+		Type:               corev1.DisruptionTarget,
+		Status:             corev1.ConditionTrue,
+		Reason:             reason,
+		LastTransitionTime: metav1.Now(),
+	})
+	return pod
+}
+
+func TestTopologyPluginCountsPreemptedReplicas(t *testing.T) {
+	pool := testPool("batch-pool", "batch-agent")
+	agentMetrics := metrics.NewAgentMetrics(prometheus.NewRegistry())
+	plugin := newTestPlugin(t, pool, agentMetrics, nil)
+	replica := poolPod(pool)
+	victim := withDisruption(replica, corev1.PodReasonPreemptionByScheduler)
+
+	plugin.observePreemption(replica, victim)
+	assert.Equal(t, 1.0, testutil.ToFloat64(agentMetrics.ReplicaPreemptions))
+
+	// Later updates of the victim, other disruptions and pods of no pool are
+	// not preemptions of a replica
+	plugin.observePreemption(victim, victim)
+	plugin.observePreemption(replica, withDisruption(replica, "EvictionByEvictionAPI"))
+	web := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"}}
+	plugin.observePreemption(web, withDisruption(web, corev1.PodReasonPreemptionByScheduler))
+	assert.Equal(t, 1.0, testutil.ToFloat64(agentMetrics.ReplicaPreemptions))
+}
@@ -0,0 +1,141 @@
+package scheduler
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+)
+
+// scoreCacheKey identifies the version of a node and of a pool its scores
+// were computed for. Any change to either, such as relabeling the node or
+// editing the pool's spec, misses the cache.
+type scoreCacheKey struct {
+	node        string
+	nodeVersion string
+	pool        string
+	generation  int64
+}
+
+// nodeScores are the scores of a node that depend on the node and pool
+// alone. Scores depending on the pods of the cluster or on live telemetry
+// are computed on every call.
+type nodeScores struct {
+	gpuTopology float64
+	modelCache  float64
+	cost        float64
+	expires     time.Time
+}
+
+// scoreCache reuses the node scores of a pool across scheduling cycles for
+// up to ttl. Entries of a node are dropped as soon as the node changes or is
+// deleted; the ttl bounds how stale prices read by the cost score may be.
+type scoreCache struct {
+	ttl time.Duration
+	now func() time.Time
+
+	// mu guards entries and sweep, when expired entries are next dropped
+	mu      sync.Mutex
+	entries map[scoreCacheKey]nodeScores
+	sweep   time.Time
+}
+
+func newScoreCache(ttl time.Duration) *scoreCache {
+	return &scoreCache{ttl: ttl, now: time.Now, entries: map[scoreCacheKey]nodeScores{}}
+}
+
+// cacheKey returns the key of the scores of node for agentPool
+func cacheKey(node *corev1.Node, agentPool *neuronetes.AgentPool) scoreCacheKey {
+	return scoreCacheKey{
+		node:        node.Name,
+		nodeVersion: node.ResourceVersion,
+		pool:        agentPool.Namespace + "/" + agentPool.Name,
+		generation:  agentPool.Generation,
+	}
+}
+
+// get returns the unexpired scores of key
+func (c *scoreCache) get(key scoreCacheKey) (nodeScores, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	scores, ok := c.entries[key]
+	if !ok || !c.now().Before(scores.expires) {
+		return nodeScores{}, false
+	}
+	return scores, true
+}
+
+// put caches scores under key for the cache's ttl, dropping the entries
+// that expired since the last sweep
+func (c *scoreCache) put(key scoreCacheKey, scores nodeScores) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	if !now.Before(c.sweep) {
+		for k, cached := range c.entries {
+			if !now.Before(cached.expires) {
+				delete(c.entries, k)
+			}
+		}
+		c.sweep = now.Add(c.ttl)
+	}
+	scores.expires = now.Add(c.ttl)
+	c.entries[key] = scores
+}
+
+// invalidateNode drops the cached scores of node
+func (c *scoreCache) invalidateNode(node string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k := range c.entries {
+		if k.node == node {
+			delete(c.entries, k)
+		}
+	}
+}
+
+// invalidateOnNodeEvents drops the cached scores of nodes as they are
+// updated or deleted
+func (c *scoreCache) invalidateOnNodeEvents(informer cache.SharedIndexInformer) error {
+	_, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			if node, ok := newObj.(*corev1.Node); ok {
+				c.invalidateNode(node.Name)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if node, ok := obj.(*corev1.Node); ok {
+				c.invalidateNode(node.Name)
+			}
+		},
+	})
+	return err
+}
+
+// staticScores returns the scores of node for agentPool that depend on the
+// node and pool alone, from the score cache if enabled
+func (s *GPUTopologyScheduler) staticScores(ctx context.Context, node *corev1.Node, agentPool *neuronetes.AgentPool) nodeScores {
+	var key scoreCacheKey
+	if s.scoreCache != nil {
+		key = cacheKey(node, agentPool)
+		if scores, ok := s.scoreCache.get(key); ok {
+			return scores
+		}
+	}
+	scores := nodeScores{
+		gpuTopology: s.scoreGPUTopology(node, agentPool),
+		modelCache:  s.scoreModelCache(node, agentPool),
+		cost:        s.scoreCostEfficiency(ctx, node, agentPool),
+	}
+	if s.scoreCache != nil {
+		s.scoreCache.put(key, scores)
+	}
+	return scores
+}
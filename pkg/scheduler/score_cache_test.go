@@ -0,0 +1,92 @@
+package scheduler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/pricing"
+)
+
+// countingPrices is a pricing provider counting the prices read from it
+type countingPrices struct {
+	calls int
+}
+
+func (p *countingPrices) GPUHourPrice(ctx context.Context, instance pricing.Instance) (float64, error) {
+	p.calls++
+	return 2, nil
+}
+
+func TestScoreCacheReusesNodeScores(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	node := instanceNode("node-a", "p4d.24xlarge")
+	node.ResourceVersion = "1"
+	clientset := fake.NewSimpleClientset(node)
+	factory := informers.NewSharedInformerFactory(clientset, 0)
+	s := NewGPUTopologyScheduler(factory, &SchedulerConfig{CostWeight: 1, ScoreCacheTTL: time.Minute})
+	factory.Start(ctx.Done())
+	factory.WaitForCacheSync(ctx.Done())
+	prices := &countingPrices{}
+	s.SetPricing(prices)
+
+	pool := testPool("llm-pool", "llm-agent")
+	pool.Spec.Scheduling = &neuronetes.SchedulingConfig{CostOptimization: &neuronetes.CostOptimizationConfig{Enabled: true}}
+	pod := poolPod(pool)
+	first := s.scoreBreakdown(ctx, node, pod, pool, 0, nodeAllocation{})
+	assert.Equal(t, first, s.scoreBreakdown(ctx, node, pod, pool, 0, nodeAllocation{}))
+	assert.Equal(t, 1, prices.calls)
+
+	// Editing the pool's spec bumps its generation
+	pool.Generation++
+	s.scoreBreakdown(ctx, node, pod, pool, 0, nodeAllocation{})
+	assert.Equal(t, 2, prices.calls)
+
+	// Scores expire after the ttl
+	now := time.Now()
+	s.scoreCache.now = func() time.Time { return now.Add(time.Minute) }
+	s.scoreBreakdown(ctx, node, pod, pool, 0, nodeAllocation{})
+	assert.Equal(t, 3, prices.calls)
+	s.scoreCache.now = time.Now
+
+	// Updating the node drops its scores
+	updated := node.DeepCopy()
+	updated.ResourceVersion = "2"
+	updated.Labels[gpuTopologyLabel] = "nvlink"
+	_, err := clientset.CoreV1().Nodes().Update(ctx, updated, metav1.UpdateOptions{})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		s.scoreCache.mu.Lock()
+		defer s.scoreCache.mu.Unlock()
+		return len(s.scoreCache.entries) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Nodes are scored afresh at their new version
+	s.scoreBreakdown(ctx, updated, pod, pool, 0, nodeAllocation{})
+	assert.Equal(t, 4, prices.calls)
+}
+
+func TestScoreCacheDisabled(t *testing.T) {
+	ctx := context.Background()
+	node := instanceNode("node-a", "p4d.24xlarge")
+	s := newTestScheduler(t, &SchedulerConfig{CostWeight: 1}, node)
+	prices := &countingPrices{}
+	s.SetPricing(prices)
+
+	pool := testPool("llm-pool", "llm-agent")
+	pool.Spec.Scheduling = &neuronetes.SchedulingConfig{CostOptimization: &neuronetes.CostOptimizationConfig{Enabled: true}}
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "llm-0", Namespace: "default"}}
+	s.scoreBreakdown(ctx, node, pod, pool, 0, nodeAllocation{})
+	s.scoreBreakdown(ctx, node, pod, pool, 0, nodeAllocation{})
+	assert.Nil(t, s.scoreCache)
+	assert.Equal(t, 2, prices.calls)
+}
@@ -0,0 +1,123 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/sharding"
+)
+
+// reservedShard is the plan of a replica reserved on a node, kept until the
+// replica's annotations record it
+type reservedShard struct {
+	node  string
+	shard sharding.Shard
+}
+
+// shardKey returns the key the shards of the same model are grouped by:
+// the replicas of one pool and revision
+func shardKey(pod *corev1.Pod) string {
+	return pod.Namespace + "/" + pod.Labels[neuronetes.LabelPool] + "/" + pod.Annotations[neuronetes.AnnotationRevision]
+}
+
+// planShard plans the shard a replica of a sharded model serves on the node
+// it is reserved on. Replicas are reserved one at a time, so the plans of
+// the replicas reserved before it, waiting in Permit or binding, are taken
+// into account even before their annotations record them.
+func (p *TopologyPlugin) planShard(pod *corev1.Pod, pool *neuronetes.AgentPool, nodeName string) *framework.Status {
+	spec, ok := sharding.SpecOf(pod.Annotations)
+	if !ok {
+		return nil
+	}
+	if pool.Spec.GPURequirements != nil {
+		spec.GPUsPerShard = int(pool.Spec.GPURequirements.Count)
+	}
+	nodeInfos, err := p.handle.SnapshotSharedLister().NodeInfos().List()
+	if err != nil {
+		return framework.AsStatus(fmt.Errorf("failed to list nodes: %w", err))
+	}
+
+	p.shardsMu.Lock()
+	defer p.shardsMu.Unlock()
+	key := shardKey(pod)
+	seen := map[types.UID]bool{}
+	var ranks []int
+	var node sharding.Node
+	for _, nodeInfo := range nodeInfos {
+		onNode := nodeInfo.Node() != nil && nodeInfo.Node().Name == nodeName
+		if onNode {
+			node.Links, _ = nodeLinks(nodeInfo.Node())
+		}
+		for _, podInfo := range nodeInfo.Pods {
+			other := podInfo.Pod
+			if other.UID == pod.UID || other.Status.Phase == corev1.PodSucceeded || other.Status.Phase == corev1.PodFailed {
+				continue
+			}
+			seen[other.UID] = true
+			shard, ok := sharding.Planned(other.Annotations)
+			if ok {
+				delete(p.shards, other.UID)
+			} else if reserved, found := p.shards[other.UID]; found {
+				shard, ok = reserved.shard, true
+			}
+			if !ok {
+				continue
+			}
+			peer := other.Labels[neuronetes.LabelPool] != "" && shardKey(other) == key
+			if peer {
+				ranks = append(ranks, shard.Rank)
+			}
+			if onNode {
+				node.Taken = append(node.Taken, shard.GPUs...)
+				if peer {
+					node.Peers = append(node.Peers, shard.GPUs...)
+				}
+			}
+		}
+	}
+	// Forget the plans of replicas that were deleted or unreserved
+	for uid := range p.shards {
+		if !seen[uid] {
+			delete(p.shards, uid)
+		}
+	}
+
+	p.shards[pod.UID] = reservedShard{node: nodeName, shard: sharding.Plan(spec, ranks, node)}
+	return nil
+}
+
+// forgetShard drops the plan of a replica that was not bound
+func (p *TopologyPlugin) forgetShard(pod *corev1.Pod) {
+	p.shardsMu.Lock()
+	delete(p.shards, pod.UID)
+	p.shardsMu.Unlock()
+}
+
+// PreBind records the plan of the shard a replica serves in its
+// annotations, which its agent container reads through the downward API
+func (p *TopologyPlugin) PreBind(ctx context.Context, state *framework.CycleState, pod *corev1.Pod, nodeName string) *framework.Status {
+	p.shardsMu.Lock()
+	reserved, ok := p.shards[pod.UID]
+	p.shardsMu.Unlock()
+	if !ok || reserved.node != nodeName {
+		return nil
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{"annotations": reserved.shard.Annotations()},
+	})
+	if err != nil {
+		return framework.AsStatus(err)
+	}
+	if _, err := p.handle.ClientSet().CoreV1().Pods(pod.Namespace).Patch(ctx, pod.Name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		return framework.AsStatus(fmt.Errorf("failed to record the shard plan of %s/%s: %w", pod.Namespace, pod.Name, err))
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package scheduler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/topology"
+)
+
+// shardPod returns the i-th replica of a pool serving a model of two
+// tensor-parallel shards
+func shardPod(i int) *corev1.Pod {
+	pod := gangPod(i, 2, "v1")
+	pod.Annotations[neuronetes.AnnotationShardStrategy] = "tensor-parallel"
+	pod.Annotations[neuronetes.AnnotationShardCount] = "2"
+	return pod
+}
+
+func TestTopologyPluginPlansShards(t *testing.T) {
+	ctx := context.Background()
+	pool := testPool("tp-pool", "tp-agent")
+	pool.Spec.GPURequirements.Count = 2
+	node := gpuNode("node-a", 4)
+	node.Annotations = map[string]string{topology.AnnotationLinks: topology.Matrix{
+		{"X", "NV12", "SYS", "SYS"},
+		{"NV12", "X", "SYS", "SYS"},
+		{"SYS", "SYS", "X", "NV12"},
+		{"SYS", "SYS", "NV12", "X"},
+	}.Encode()}
+	plugin := newTestPlugin(t, pool, nil, []*corev1.Node{node})
+	pods := plugin.handle.ClientSet().CoreV1().Pods("default")
+	state := framework.NewCycleState()
+	state.Write(poolStateKey, &poolState{pool: pool})
+
+	// Both shards are reserved before either binds
+	first, second := shardPod(0), shardPod(1)
+	for _, pod := range []*corev1.Pod{first, second} {
+		_, err := pods.Create(ctx, pod, metav1.CreateOptions{})
+		require.NoError(t, err)
+		require.True(t, plugin.Reserve(ctx, state, pod, "node-a").IsSuccess())
+		assume(plugin, pod)
+	}
+	for _, pod := range []*corev1.Pod{first, second} {
+		require.True(t, plugin.PreBind(ctx, state, pod, "node-a").IsSuccess())
+	}
+
+	bound, err := pods.Get(ctx, first.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "0", bound.Annotations[neuronetes.AnnotationShardRank])
+	assert.Equal(t, "0,1", bound.Annotations[neuronetes.AnnotationShardGPUs])
+	bound, err = pods.Get(ctx, second.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "1", bound.Annotations[neuronetes.AnnotationShardRank])
+	assert.Equal(t, "2,3", bound.Annotations[neuronetes.AnnotationShardGPUs])
+
+	// The replacement of an unreserved shard takes its rank
+	plugin.Unreserve(ctx, state, second, "node-a")
+	assert.Len(t, plugin.shards, 1)
+	require.NoError(t, plugin.handle.(*fakeHandle).snapshot["node-a"].RemovePod(second))
+	replacement := shardPod(2)
+	require.True(t, plugin.Reserve(ctx, state, replacement, "node-a").IsSuccess())
+	assert.Equal(t, 1, plugin.shards[replacement.UID].shard.Rank)
+
+	// Replicas of unsharded models are not planned
+	pod := gangPod(3, 2, "v1")
+	require.True(t, plugin.Reserve(ctx, state, pod, "node-a").IsSuccess())
+	assert.NotContains(t, plugin.shards, pod.UID)
+	assert.True(t, plugin.PreBind(ctx, state, pod, "node-a").IsSuccess())
+}
@@ -0,0 +1,72 @@
+package scheduler
+
+import (
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/gpu"
+)
+
+const (
+	// gfdReplicasLabel is the number of nvidia.com/gpu replicas the device
+	// plugin advertises for each GPU of a node, as labeled by GPU Feature
+	// Discovery
+	gfdReplicasLabel = "nvidia.com/gpu.replicas"
+)
+
+// sharingStrategies maps the sharing strategies of pools to those labeled
+// on nodes
+var sharingStrategies = map[string]string{
+	neuronetes.GPUSharingTimeSlicing: "time-slicing",
+	neuronetes.GPUSharingMPS:         "mps",
+}
+
+// poolSharingStrategy returns how the GPUs of agentPool's nodes must be
+// shared, as labeled on nodes, or an empty string for whole GPUs
+func poolSharingStrategy(agentPool *neuronetes.AgentPool) string {
+	if gpu := agentPool.Spec.GPURequirements; gpu != nil && gpu.Sharing != nil {
+		return sharingStrategies[gpu.Sharing.Strategy]
+	}
+	return ""
+}
+
+// nodeSharingStrategy returns how the GPUs of node are shared, or an empty
+// string if they are not
+func nodeSharingStrategy(node *corev1.Node) string {
+	if strategy := node.Labels[gpu.SharingStrategyLabel]; strategy != "none" {
+		return strategy
+	}
+	return ""
+}
+
+// nodeGPUReplicas returns the number of GPU replicas the device plugin of
+// node advertises for each of its GPUs, 1 if they are not shared
+func nodeGPUReplicas(node *corev1.Node) int64 {
+	if nodeSharingStrategy(node) == "" {
+		return 1
+	}
+	replicas, err := strconv.ParseInt(node.Labels[gfdReplicasLabel], 10, 64)
+	if err != nil || replicas < 1 {
+		return 1
+	}
+	return replicas
+}
+
+// matchesSharing reports whether node shares its GPUs as agentPool requires.
+// Pools of whole GPUs are kept off shared GPUs, where they would get a
+// fraction of the memory and compute they expect.
+func matchesSharing(node *corev1.Node, agentPool *neuronetes.AgentPool) bool {
+	return nodeSharingStrategy(node) == poolSharingStrategy(agentPool)
+}
+
+// gpuUnitMemory returns the memory of each GPU unit node advertises in
+// bytes: that of a whole GPU, or its share of one when GPUs are shared
+func gpuUnitMemory(node *corev1.Node) (int64, bool) {
+	memory, ok := nodeGPUMemory(node)
+	if !ok {
+		return 0, false
+	}
+	return memory / nodeGPUReplicas(node), true
+}
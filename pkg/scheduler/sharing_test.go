@@ -0,0 +1,87 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/gpu"
+)
+
+// sharedNode returns a node of gpus 24 GiB GPUs the device plugin shares as
+// replicas GPUs each with strategy
+func sharedNode(name string, gpus, replicas int64, strategy string) *corev1.Node {
+	node := gpuNode(name, gpus*replicas)
+	node.Labels[gfdMemoryLabel] = "24576"
+	node.Labels[gpu.SharingStrategyLabel] = strategy
+	node.Labels[gfdReplicasLabel] = fmt.Sprint(replicas)
+	return node
+}
+
+// sharedPod returns a replica requesting units of a shared GPU on node
+func sharedPod(name, node string, units int64) *corev1.Pod {
+	pod := classPod(name, "default", "small-agent", node)
+	pod.Spec.Containers = []corev1.Container{{Resources: corev1.ResourceRequirements{Requests: corev1.ResourceList{
+		gpu.NVIDIAResource: *resource.NewQuantity(units, resource.DecimalSI),
+	}}}}
+	return pod
+}
+
+func TestScheduleOnSharedGPUs(t *testing.T) {
+	ctx := context.Background()
+	mps := sharedNode("node-mps", 2, 4, "mps")
+	whole := gpuNode("node-whole", 8)
+	whole.Labels[gfdMemoryLabel] = "24576"
+	whole.Labels[gpu.SharingStrategyLabel] = "none"
+	s := newTestScheduler(t, &SchedulerConfig{VRAMPackWeight: 1}, mps, whole)
+
+	pool := testPool("small-pool", "small-agent")
+	pool.Spec.GPURequirements.Sharing = &neuronetes.GPUSharing{Strategy: neuronetes.GPUSharingMPS}
+	pod := sharedPod("small-0", "", 1)
+
+	// Each of the eight replicas of the two GPUs has a quarter of the memory
+	// of a GPU
+	assert.Equal(t, int64(6<<30), podVRAM(mps, pod))
+	assert.Equal(t, int64(48<<30), nodeVRAM(mps))
+	pool.Spec.GPURequirements.Memory = "8Gi"
+	assert.Equal(t, "GPU memory below the requirement", s.filterNode(ctx, mps, pod, pool, nodeAllocation{}))
+	pool.Spec.GPURequirements.Memory = "4Gi"
+
+	assert.Equal(t, "GPUs not shared as required", s.filterNode(ctx, whole, pod, pool, nodeAllocation{}))
+	result, err := s.Schedule(ctx, pod, pool)
+	require.NoError(t, err)
+	assert.Equal(t, "node-mps", result.Node)
+
+	// Replicas hold their share of the GPUs, not whole GPUs
+	var allocated nodeAllocation
+	for i := 0; i < 7; i++ {
+		allocated.add(sharedPod(fmt.Sprintf("small-%d", i+1), "node-mps", 1))
+	}
+	assert.Equal(t, int64(42<<30), allocatedVRAM(mps, allocated))
+	assert.Empty(t, s.filterNode(ctx, mps, pod, pool, allocated))
+	allocated.add(sharedPod("small-8", "node-mps", 1))
+	assert.Equal(t, "not enough free VRAM", s.filterNode(ctx, mps, pod, pool, allocated))
+
+	// Pools of whole GPUs are kept off shared ones, and pools sharing GPUs
+	// with another strategy off them too
+	wholePool := testPool("llm-pool", "llm-agent")
+	assert.Equal(t, "GPUs not shared as required", s.filterNode(ctx, mps, poolPod(wholePool), wholePool, nodeAllocation{}))
+	assert.Empty(t, s.filterNode(ctx, whole, poolPod(wholePool), wholePool, nodeAllocation{}))
+	pool.Spec.GPURequirements.Sharing.Strategy = neuronetes.GPUSharingTimeSlicing
+	assert.Equal(t, "GPUs not shared as required", s.filterNode(ctx, mps, pod, pool, nodeAllocation{}))
+}
+
+func TestNodeGPUReplicas(t *testing.T) {
+	assert.Equal(t, int64(4), nodeGPUReplicas(sharedNode("node-a", 1, 4, "time-slicing")))
+	// Replicas are ignored unless GPUs are shared
+	assert.Equal(t, int64(1), nodeGPUReplicas(sharedNode("node-b", 1, 4, "none")))
+	unlabeled := sharedNode("node-c", 1, 4, "mps")
+	unlabeled.Labels[gfdReplicasLabel] = "many"
+	assert.Equal(t, int64(1), nodeGPUReplicas(unlabeled))
+}
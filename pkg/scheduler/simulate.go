@@ -0,0 +1,243 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"sigs.k8s.io/yaml"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/gpu"
+)
+
+// SimulatePath is the path the simulation endpoint is served on
+const SimulatePath = "/simulate"
+
+// maxSimulatedReplicas bounds the replicas of a simulation request
+const maxSimulatedReplicas = 1000
+
+// Simulation is where the replicas of an AgentPool would be placed
+type Simulation struct {
+	Pool      string `json:"pool"`
+	Namespace string `json:"namespace"`
+
+	// Replicas are the placements of the replicas that fit, in order
+	Replicas []ReplicaPlacement `json:"replicas"`
+
+	// Unschedulable is the number of replicas no node fits
+	Unschedulable int `json:"unschedulable"`
+
+	// Nodes are the filter and score results of each node for the first
+	// replica, by name
+	Nodes []NodeSimulation `json:"nodes"`
+}
+
+// ReplicaPlacement is the node a replica would be placed on
+type ReplicaPlacement struct {
+	Node  string `json:"node"`
+	Score int64  `json:"score"`
+}
+
+// NodeSimulation is how a node fits the first replica of a pool
+type NodeSimulation struct {
+	Node string `json:"node"`
+
+	// Reason is the filter the node fails, empty if it fits
+	Reason string `json:"reason,omitempty"`
+
+	// Scores are set for nodes that fit
+	Scores *ScoreBreakdown `json:"scores,omitempty"`
+
+	// Replicas is the number of the pool's replicas placed on the node
+	Replicas int `json:"replicas"`
+}
+
+// Simulate places replicas of agentPool one after another as the plugin
+// would, accounting the GPUs of each replica before placing the next,
+// without binding anything. Unlike kube-scheduler, nothing is preempted and
+// only the GPU count is checked of the default resource filters.
+func (s *GPUTopologyScheduler) Simulate(ctx context.Context, agentPool *neuronetes.AgentPool, replicas int) (*Simulation, error) {
+	for _, synced := range s.synced {
+		if !synced() {
+			return nil, fmt.Errorf("node and pod caches are not synced")
+		}
+	}
+
+	nodes, err := s.nodes.List(labels.Everything())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list nodes: %w", err)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Name < nodes[j].Name })
+
+	allocated, err := s.allocation(nodes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list GPU allocations: %w", err)
+	}
+	placement, err := s.classPlacement(ctx, agentPool)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list class replicas: %w", err)
+	}
+
+	pod := simulatedReplica(agentPool)
+	simulation := &Simulation{Pool: agentPool.Name, Namespace: agentPool.Namespace}
+	index := make(map[string]int, len(nodes))
+	for i, node := range nodes {
+		result := NodeSimulation{Node: node.Name}
+		result.Reason = s.filterSimulated(ctx, node, pod, agentPool, allocated[node.Name])
+		if result.Reason == "" {
+			scores := s.scoreBreakdown(ctx, node, pod, agentPool, placement[node.Name], allocated[node.Name])
+			result.Scores = &scores
+		}
+		simulation.Nodes = append(simulation.Nodes, result)
+		index[node.Name] = i
+	}
+
+	for i := 0; i < replicas; i++ {
+		var best *corev1.Node
+		var bestScore int64
+		for _, node := range nodes {
+			if s.filterSimulated(ctx, node, pod, agentPool, allocated[node.Name]) != "" {
+				continue
+			}
+			score := s.calculateScore(ctx, node, pod, agentPool, placement[node.Name], allocated[node.Name])
+			if best == nil || score > bestScore {
+				best, bestScore = node, score
+			}
+		}
+		if best == nil {
+			simulation.Unschedulable = replicas - i
+			break
+		}
+
+		simulation.Replicas = append(simulation.Replicas, ReplicaPlacement{Node: best.Name, Score: bestScore})
+		simulation.Nodes[index[best.Name]].Replicas++
+		allocation := allocated[best.Name]
+		allocation.add(pod)
+		allocated[best.Name] = allocation
+		placement[best.Name]++
+	}
+	return simulation, nil
+}
+
+// filterSimulated runs the plugin's filters and, as kube-scheduler would,
+// checks the whole GPUs left on node
+func (s *GPUTopologyScheduler) filterSimulated(ctx context.Context, node *corev1.Node, pod *corev1.Pod, agentPool *neuronetes.AgentPool, allocated nodeAllocation) string {
+	if reason := s.filterNode(ctx, node, pod, agentPool, allocated); reason != "" {
+		return reason
+	}
+	gpus := nodeGPUs(node, node.Status.Allocatable)
+	if requested := gpu.PodGPUs(pod); requested > 0 && gpus-allocated.requested < requested {
+		return "not enough free GPUs"
+	}
+	return ""
+}
+
+// simulatedReplica returns a replica of agentPool requesting its GPUs as the
+// AgentPool controller does
+func simulatedReplica(agentPool *neuronetes.AgentPool) *corev1.Pod {
+	var count int64
+	if gpu := agentPool.Spec.GPURequirements; gpu != nil {
+		count = int64(gpu.Count)
+	}
+	name := vendorResource(poolVendor(agentPool))
+	if agentPool.Spec.MIGProfile != "" {
+		name = corev1.ResourceName(migResourcePrefix + agentPool.Spec.MIGProfile)
+		if count < 1 {
+			count = 1
+		}
+	}
+
+	container := corev1.Container{Name: "agent"}
+	if count > 0 {
+		container.Resources.Requests = corev1.ResourceList{name: *resource.NewQuantity(count, resource.DecimalSI)}
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      agentPool.Name + "-simulated",
+			Namespace: agentPool.Namespace,
+			Labels: map[string]string{
+				neuronetes.LabelPool:       agentPool.Name,
+				neuronetes.LabelAgentClass: agentPool.Spec.AgentClassRef.Name,
+			},
+		},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{container}},
+	}
+	if agentPool.Spec.Scheduling != nil {
+		pod.Spec.NodeSelector = agentPool.Spec.Scheduling.NodeSelector
+	}
+	return pod
+}
+
+// SimulationHandler serves simulations of the AgentPool, as YAML or JSON, in
+// the body of POST requests. The replicas query parameter sets how many
+// replicas are placed, the pool's minReplicas or one by default.
+func SimulationHandler(s *GPUTopologyScheduler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "an AgentPool must be posted", http.StatusMethodNotAllowed)
+			return
+		}
+		data, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		pool := &neuronetes.AgentPool{}
+		if err := yaml.Unmarshal(data, pool); err != nil {
+			http.Error(w, fmt.Sprintf("invalid AgentPool: %v", err), http.StatusBadRequest)
+			return
+		}
+		if pool.Namespace == "" {
+			pool.Namespace = metav1.NamespaceDefault
+		}
+
+		replicas := int(pool.Spec.MinReplicas)
+		if replicas < 1 {
+			replicas = 1
+		}
+		if value := r.URL.Query().Get("replicas"); value != "" {
+			replicas, err = strconv.Atoi(value)
+			if err != nil || replicas < 0 || replicas > maxSimulatedReplicas {
+				http.Error(w, fmt.Sprintf("replicas must be between 0 and %d", maxSimulatedReplicas), http.StatusBadRequest)
+				return
+			}
+		}
+
+		simulation, err := s.Simulate(r.Context(), pool, replicas)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(simulation)
+	})
+}
+
+// serveSimulations serves SimulationHandler on addr for the life of the
+// scheduler
+func (p *TopologyPlugin) serveSimulations(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to serve simulations: %w", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle(SimulatePath, SimulationHandler(p.scheduler))
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		utilruntime.HandleError(server.Serve(listener))
+	}()
+	return nil
+}
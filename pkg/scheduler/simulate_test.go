@@ -0,0 +1,93 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestSimulatePlacesReplicasInTurn(t *testing.T) {
+	notReady := gpuNode("node-c", 8)
+	notReady.Status.Conditions[0].Status = corev1.ConditionFalse
+	busy := classPod("other-0", "default", "other-agent", "node-b")
+	busy.Spec.Containers = simulatedReplica(testPool("other-pool", "other-agent")).Spec.Containers
+	s := newTestScheduler(t, &SchedulerConfig{SpreadWeight: 1},
+		gpuNode("node-a", 4), gpuNode("node-b", 3), notReady, busy)
+
+	pool := testPool("chat-pool", "chat-agent")
+	pool.Spec.GPURequirements.Count = 2
+	simulation, err := s.Simulate(context.Background(), pool, 4)
+	require.NoError(t, err)
+
+	// node-b has 2 of its 3 GPUs free, so it fits one replica; the second
+	// replica spreads onto it, the third shares node-a and the fourth finds
+	// no room left
+	assert.Equal(t, []ReplicaPlacement{
+		{Node: "node-a", Score: 100},
+		{Node: "node-b", Score: 100},
+		{Node: "node-a", Score: 50},
+	}, simulation.Replicas)
+	assert.Equal(t, 1, simulation.Unschedulable)
+
+	require.Len(t, simulation.Nodes, 3)
+	assert.Equal(t, "node-a", simulation.Nodes[0].Node)
+	assert.Equal(t, 2, simulation.Nodes[0].Replicas)
+	require.NotNil(t, simulation.Nodes[0].Scores)
+	assert.InDelta(t, 1.0, simulation.Nodes[0].Scores.Spread, 1e-9)
+	assert.Equal(t, int64(100), simulation.Nodes[0].Scores.Total)
+	assert.Equal(t, 1, simulation.Nodes[1].Replicas)
+	assert.Equal(t, "node is not ready", simulation.Nodes[2].Reason)
+	assert.Nil(t, simulation.Nodes[2].Scores)
+}
+
+func TestSimulationHandler(t *testing.T) {
+	s := newTestScheduler(t, &SchedulerConfig{SpreadWeight: 1}, gpuNode("node-a", 8))
+	server := httptest.NewServer(SimulationHandler(s))
+	defer server.Close()
+
+	pool := `apiVersion: neuronetes.io/v1alpha1
+kind: AgentPool
+metadata:
+  name: chat-pool
+spec:
+  agentClassRef:
+    name: chat-agent
+  minReplicas: 2
+  maxReplicas: 4
+  gpuRequirements:
+    count: 2
+`
+	resp, err := http.Post(server.URL+SimulatePath, "application/yaml", strings.NewReader(pool))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	simulation := &Simulation{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(simulation))
+	assert.Equal(t, "default", simulation.Namespace)
+	assert.Len(t, simulation.Replicas, 2)
+	assert.Zero(t, simulation.Unschedulable)
+
+	resp, err = http.Post(server.URL+SimulatePath+"?replicas=5", "application/yaml", strings.NewReader(pool))
+	require.NoError(t, err)
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(simulation))
+	resp.Body.Close()
+	assert.Len(t, simulation.Replicas, 4)
+	assert.Equal(t, 1, simulation.Unschedulable)
+
+	resp, err = http.Post(server.URL+SimulatePath+"?replicas=-1", "application/yaml", strings.NewReader(pool))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, err = http.Get(server.URL + SimulatePath)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
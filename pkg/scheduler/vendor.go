@@ -0,0 +1,68 @@
+package scheduler
+
+import (
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/gpu"
+)
+
+const (
+	// amdLabelPrefix prefixes the labels of the AMD GPU node labeller
+	amdLabelPrefix = "amd.com/gpu."
+
+	// amdVRAMLabel is the memory of each GPU as labeled by the AMD GPU node
+	// labeller, e.g. 192G
+	amdVRAMLabel = "amd.com/gpu.vram"
+
+	// amdProductLabel is the product name of a node's GPUs as labeled by the
+	// AMD GPU node labeller, e.g. AMD_Instinct_MI300X_OAM
+	amdProductLabel = "amd.com/gpu.product-name"
+)
+
+// poolVendor returns the vendor of the GPUs of agentPool. MIG slices are
+// NVIDIA only.
+func poolVendor(agentPool *neuronetes.AgentPool) string {
+	if gpu := agentPool.Spec.GPURequirements; gpu != nil && gpu.Vendor != "" && agentPool.Spec.MIGProfile == "" {
+		return gpu.Vendor
+	}
+	return neuronetes.GPUVendorNVIDIA
+}
+
+// vendorResource returns the extended resource of whole GPUs of vendor
+func vendorResource(vendor string) corev1.ResourceName {
+	if vendor == neuronetes.GPUVendorAMD {
+		return gpu.AMDResource
+	}
+	return gpu.NVIDIAResource
+}
+
+// nodeVendor returns the vendor of the GPUs of node, from the GPUs it
+// advertises or else the labels of the AMD GPU node labeller
+func nodeVendor(node *corev1.Node) string {
+	if gpus := node.Status.Capacity[gpu.AMDResource]; !gpus.IsZero() {
+		return neuronetes.GPUVendorAMD
+	}
+	if gpus := node.Status.Capacity[gpu.NVIDIAResource]; !gpus.IsZero() {
+		return neuronetes.GPUVendorNVIDIA
+	}
+	for key := range node.Labels {
+		if strings.HasPrefix(key, amdLabelPrefix) {
+			return neuronetes.GPUVendorAMD
+		}
+	}
+	return neuronetes.GPUVendorNVIDIA
+}
+
+// matchesGPUType reports whether the GPUs of node are of gpuType: by the
+// neuronetes.io/gpu-type label, or for AMD nodes without it by the product
+// name the AMD GPU node labeller found, e.g. MI300X
+func matchesGPUType(node *corev1.Node, gpuType string) bool {
+	if value, ok := node.Labels[gpuTypeLabel]; ok {
+		return value == gpuType
+	}
+	product, ok := node.Labels[amdProductLabel]
+	return ok && strings.Contains(strings.ToUpper(product), strings.ToUpper(gpuType))
+}
@@ -0,0 +1,90 @@
+package scheduler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/gpu"
+	"github.com/bowenislandsong/neuronetes/pkg/topology"
+)
+
+// amdNode returns a node of gpus MI300X GPUs all joined by link, labeled by
+// the AMD GPU node labeller
+func amdNode(name string, gpus int, link string) *corev1.Node {
+	node := linkedNode(name, gpus, link)
+	for _, resources := range []corev1.ResourceList{node.Status.Capacity, node.Status.Allocatable} {
+		delete(resources, gpu.NVIDIAResource)
+		resources[gpu.AMDResource] = *resource.NewQuantity(int64(gpus), resource.DecimalSI)
+	}
+	node.Labels[amdProductLabel] = "AMD_Instinct_MI300X_OAM"
+	node.Labels[amdVRAMLabel] = "192G"
+	return node
+}
+
+func TestScheduleOnAMDNodes(t *testing.T) {
+	ctx := context.Background()
+	xgmi := amdNode("node-xgmi", 8, topology.LinkXGMI)
+	pcie := amdNode("node-pcie", 8, topology.LinkPIX)
+	nvidia := linkedNode("node-h100", 8, "NV18")
+	s := newTestScheduler(t, &SchedulerConfig{GPUTopologyWeight: 1}, xgmi, pcie, nvidia)
+
+	pool := testPool("mi300-pool", "llm-agent")
+	pool.Spec.GPURequirements = &neuronetes.GPURequirements{
+		Count:    4,
+		Type:     "MI300X",
+		Memory:   "128Gi",
+		Vendor:   neuronetes.GPUVendorAMD,
+		Topology: &neuronetes.TopologyRequirement{Locality: "xgmi"},
+	}
+	pod := poolPod(pool)
+	pod.Spec.Containers = []corev1.Container{{Resources: corev1.ResourceRequirements{Requests: corev1.ResourceList{
+		gpu.AMDResource: resource.MustParse("4"),
+	}}}}
+	assert.Equal(t, "not enough GPUs of the required type", s.filterNode(ctx, nvidia, pod, pool, nodeAllocation{}))
+	assert.Equal(t, int64(4), gpu.PodGPUs(pod))
+
+	// Infinity Fabric groups score above PCIe ones
+	assert.Equal(t, 1.0, s.scoreGPUTopology(xgmi, pool))
+	assert.InDelta(t, pcieScore, s.scoreGPUTopology(pcie, pool), 1e-9)
+	result, err := s.Schedule(ctx, pod, pool)
+	require.NoError(t, err)
+	assert.Equal(t, "node-xgmi", result.Node)
+
+	// GPUs of NVIDIA pools are not mixed up with AMD ones
+	nvidiaPool := testPool("h100-pool", "llm-agent")
+	nvidiaPool.Spec.GPURequirements.Count = 4
+	assert.Equal(t, "not enough GPUs of the required type", s.filterNode(ctx, xgmi, poolPod(nvidiaPool), nvidiaPool, nodeAllocation{}))
+	result, err = s.Schedule(ctx, poolPod(nvidiaPool), nvidiaPool)
+	require.NoError(t, err)
+	assert.Equal(t, "node-h100", result.Node)
+}
+
+func TestAMDNodeLabels(t *testing.T) {
+	node := amdNode("node-a", 8, topology.LinkXGMI)
+	memory, ok := nodeGPUMemory(node)
+	require.True(t, ok)
+	assert.Equal(t, int64(192e9), memory)
+	assert.Equal(t, int64(8), nodeGPUs(node, node.Status.Allocatable))
+	assert.Equal(t, neuronetes.GPUVendorAMD, nodeVendor(node))
+	assert.True(t, matchesGPUType(node, "MI300X"))
+	assert.False(t, matchesGPUType(node, "MI250X"))
+
+	// The neuronetes.io/gpu-type label takes precedence
+	node.Labels[gpuTypeLabel] = "MI300A"
+	assert.False(t, matchesGPUType(node, "MI300X"))
+
+	// Nodes whose device plugin has not advertised their GPUs yet are known
+	// by their labels
+	delete(node.Status.Capacity, gpu.AMDResource)
+	assert.Equal(t, neuronetes.GPUVendorAMD, nodeVendor(node))
+
+	pool := testPool("mi300-pool", "llm-agent")
+	pool.Spec.GPURequirements.Vendor = neuronetes.GPUVendorAMD
+	assert.Equal(t, map[string]interface{}{"amd.com/gpu": "1"}, nodeRequests(poolPod(pool), pool))
+}
@@ -0,0 +1,155 @@
+package scheduler
+
+import (
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/gpu"
+)
+
+const (
+	// gpuMemoryLabel is the memory of each GPU of a node as a quantity,
+	// e.g. 80Gi
+	gpuMemoryLabel = "neuronetes.io/gpu-memory"
+
+	// gfdMemoryLabel is the memory of each GPU in MiB, as labeled by GPU
+	// Feature Discovery
+	gfdMemoryLabel = "nvidia.com/gpu.memory"
+)
+
+// nodeGPUMemory returns the memory of each GPU of a node in bytes, as
+// labeled by hand, GPU Feature Discovery or the AMD GPU node labeller
+func nodeGPUMemory(node *corev1.Node) (int64, bool) {
+	if value, ok := node.Labels[gpuMemoryLabel]; ok {
+		q, err := resource.ParseQuantity(value)
+		if err != nil || q.Sign() <= 0 {
+			return 0, false
+		}
+		return q.Value(), true
+	}
+	if value, ok := node.Labels[gfdMemoryLabel]; ok {
+		mib, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || mib <= 0 {
+			return 0, false
+		}
+		return mib << 20, true
+	}
+	if value, ok := node.Labels[amdVRAMLabel]; ok {
+		q, err := resource.ParseQuantity(value)
+		if err != nil || q.Sign() <= 0 {
+			return 0, false
+		}
+		return q.Value(), true
+	}
+	return 0, false
+}
+
+// nodeVRAM returns the memory of all allocatable GPUs of a node, or zero if
+// it is unknown. Shared GPUs are counted once, not once per replica.
+func nodeVRAM(node *corev1.Node) int64 {
+	memory, ok := gpuUnitMemory(node)
+	if !ok {
+		return 0
+	}
+	return memory * nodeGPUs(node, node.Status.Allocatable)
+}
+
+// allocatedVRAM returns the VRAM held on a node
+func allocatedVRAM(node *corev1.Node, allocated nodeAllocation) int64 {
+	memory, _ := gpuUnitMemory(node)
+	return allocated.vram + allocated.gpus*memory
+}
+
+// podVRAMFootprint returns the estimated VRAM a pod uses, from its
+// neuronetes.io/vram annotation
+func podVRAMFootprint(pod *corev1.Pod) (int64, bool) {
+	value, ok := pod.Annotations[neuronetes.AnnotationVRAM]
+	if !ok {
+		return 0, false
+	}
+	q, err := resource.ParseQuantity(value)
+	if err != nil || q.Sign() < 0 {
+		return 0, false
+	}
+	return q.Value(), true
+}
+
+// podVRAM returns the VRAM a replica will hold on node: its footprint, or
+// the memory of its whole GPUs or of its share of shared ones
+func podVRAM(node *corev1.Node, pod *corev1.Pod) int64 {
+	if footprint, ok := podVRAMFootprint(pod); ok {
+		return footprint
+	}
+	memory, _ := gpuUnitMemory(node)
+	return gpu.PodGPUs(pod) * memory
+}
+
+// requiredGPUMemory returns the memory each GPU of a replica needs: the
+// pool's minimum, or the replica's share of its footprint if larger. It
+// fails if the pool's minimum is not a valid quantity.
+func requiredGPUMemory(pod *corev1.Pod, agentPool *neuronetes.AgentPool) (int64, bool) {
+	var required int64
+	if req := agentPool.Spec.GPURequirements; req != nil && req.Memory != "" {
+		q, err := resource.ParseQuantity(req.Memory)
+		if err != nil {
+			return 0, false
+		}
+		required = q.Value()
+	}
+	if agentPool.Spec.MIGProfile != "" {
+		// A replica on a MIG slice is bounded by the slice, not the GPU
+		return required, true
+	}
+	if footprint, ok := podVRAMFootprint(pod); ok {
+		gpus := gpu.PodGPUs(pod)
+		if gpus < 1 {
+			gpus = 1
+		}
+		if share := footprint / gpus; share > required {
+			required = share
+		}
+	}
+	return required, true
+}
+
+// fitsGPUMemory reports whether each GPU of node, or each replica of a
+// shared GPU, has the memory a replica needs
+func fitsGPUMemory(node *corev1.Node, pod *corev1.Pod, agentPool *neuronetes.AgentPool) bool {
+	required, ok := requiredGPUMemory(pod, agentPool)
+	if !ok {
+		return false
+	}
+	if required == 0 {
+		return true
+	}
+	memory, ok := gpuUnitMemory(node)
+	return ok && memory >= required
+}
+
+// hasFreeVRAM reports whether node has VRAM left for a replica. Nodes of
+// unknown GPU memory and replicas on MIG slices, which are accounted as
+// slices, always pass.
+func hasFreeVRAM(node *corev1.Node, pod *corev1.Pod, agentPool *neuronetes.AgentPool, allocated nodeAllocation) bool {
+	capacity := nodeVRAM(node)
+	if capacity == 0 || agentPool.Spec.MIGProfile != "" {
+		return true
+	}
+	return capacity-allocatedVRAM(node, allocated) >= podVRAM(node, pod)
+}
+
+// scoreVRAMPack best-fits a replica onto the node its VRAM fills the most,
+// keeping VRAM free in large blocks for bigger models
+func scoreVRAMPack(node *corev1.Node, pod *corev1.Pod, agentPool *neuronetes.AgentPool, allocated nodeAllocation) float64 {
+	capacity := nodeVRAM(node)
+	if capacity == 0 || agentPool.Spec.MIGProfile != "" {
+		return 0.0
+	}
+	free := capacity - allocatedVRAM(node, allocated) - podVRAM(node, pod)
+	if free < 0 {
+		return 0.0
+	}
+	return 1.0 - float64(free)/float64(capacity)
+}
@@ -0,0 +1,136 @@
+package scheduler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/gpu"
+)
+
+// vramNode returns a node of gpus GPUs with memory each
+func vramNode(name string, gpus int64, memory string) *corev1.Node {
+	node := gpuNode(name, gpus)
+	node.Labels[gpuMemoryLabel] = memory
+	return node
+}
+
+// vramPod returns a pod on node with an estimated VRAM footprint and no
+// whole GPUs of its own
+func vramPod(name, node, footprint string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   "default",
+			Annotations: map[string]string{neuronetes.AnnotationVRAM: footprint},
+		},
+		Spec:   corev1.PodSpec{NodeName: node},
+		Status: corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+}
+
+func TestNodeGPUMemory(t *testing.T) {
+	memory, ok := nodeGPUMemory(vramNode("node-a", 8, "40Gi"))
+	assert.True(t, ok)
+	assert.Equal(t, int64(40<<30), memory)
+
+	gfd := gpuNode("node-b", 8)
+	gfd.Labels[gfdMemoryLabel] = "81920"
+	memory, ok = nodeGPUMemory(gfd)
+	assert.True(t, ok)
+	assert.Equal(t, int64(80<<30), memory)
+
+	_, ok = nodeGPUMemory(vramNode("node-c", 8, "lots"))
+	assert.False(t, ok)
+	_, ok = nodeGPUMemory(gpuNode("node-d", 8))
+	assert.False(t, ok)
+}
+
+func TestFitsGPUMemoryComparesQuantities(t *testing.T) {
+	pool := testPool("chat-pool", "chat-agent")
+	pod := &corev1.Pod{}
+
+	// "8Gi" sorts after "40Gi" as a string
+	pool.Spec.GPURequirements.Memory = "8Gi"
+	assert.True(t, fitsGPUMemory(vramNode("node-a", 1, "40Gi"), pod, pool))
+	pool.Spec.GPURequirements.Memory = "40Gi"
+	assert.False(t, fitsGPUMemory(vramNode("node-a", 1, "8Gi"), pod, pool))
+	assert.True(t, fitsGPUMemory(vramNode("node-a", 1, "40960Mi"), pod, pool))
+	assert.False(t, fitsGPUMemory(gpuNode("node-a", 1), pod, pool))
+
+	pool.Spec.GPURequirements.Memory = "forty"
+	assert.False(t, fitsGPUMemory(vramNode("node-a", 1, "80Gi"), pod, pool))
+
+	// A replica's share of its footprint must fit each of its GPUs
+	pool.Spec.GPURequirements.Memory = ""
+	large := vramPod("large", "", "100Gi")
+	assert.False(t, fitsGPUMemory(vramNode("node-a", 2, "80Gi"), large, pool))
+	large.Spec.Containers = []corev1.Container{{Resources: corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{gpu.NVIDIAResource: resource.MustParse("2")},
+	}}}
+	assert.True(t, fitsGPUMemory(vramNode("node-a", 2, "80Gi"), large, pool))
+}
+
+func TestScheduleBinPacksVRAM(t *testing.T) {
+	ctx := context.Background()
+	pool := testPool("chat-pool", "chat-agent")
+	pool.Spec.GPURequirements = nil
+	done := vramPod("done", "node-empty", "80Gi")
+	done.Status.Phase = corev1.PodSucceeded
+
+	objects := []runtime.Object{
+		vramNode("node-full", 1, "80Gi"),
+		vramNode("node-half", 1, "80Gi"),
+		vramNode("node-empty", 1, "80Gi"),
+		vramPod("full-0", "node-full", "70Gi"),
+		vramPod("half-0", "node-half", "40Gi"),
+		done,
+	}
+	s := newTestScheduler(t, &SchedulerConfig{VRAMPackWeight: 1}, objects...)
+
+	// The replica best-fits onto the half-used node, the full one has too
+	// little VRAM left
+	result, err := s.Schedule(ctx, vramPod("chat-0", "", "20Gi"), pool)
+	require.NoError(t, err)
+	assert.Equal(t, "node-half", result.Node)
+	assert.Equal(t, int64(75), result.Score)
+
+	result, err = s.Schedule(ctx, vramPod("chat-1", "", "60Gi"), pool)
+	require.NoError(t, err)
+	assert.Equal(t, "node-empty", result.Node)
+}
+
+func TestTopologyPluginFiltersOnFreeVRAM(t *testing.T) {
+	ctx := context.Background()
+	pool := testPool("chat-pool", "chat-agent")
+	pool.Spec.GPURequirements = nil
+	plugin := newTestPlugin(t, pool, nil, []*corev1.Node{vramNode("node-a", 2, "40Gi")},
+		vramPod("a-0", "node-a", "30Gi"),
+	)
+	pod := poolPod(pool)
+	pod.Annotations = map[string]string{neuronetes.AnnotationVRAM: "30Gi"}
+	state := framework.NewCycleState()
+	_, status := plugin.PreFilter(ctx, state, pod)
+	require.True(t, status.IsSuccess(), status.Message())
+
+	nodeA, err := plugin.handle.SnapshotSharedLister().NodeInfos().Get("node-a")
+	require.NoError(t, err)
+	assert.True(t, plugin.Filter(ctx, state, pod, nodeA).IsSuccess())
+
+	// A pod holding a whole GPU takes all of its memory
+	gpuHolder := vramPod("a-1", "node-a", "")
+	delete(gpuHolder.Annotations, neuronetes.AnnotationVRAM)
+	gpuHolder.Spec.Containers = []corev1.Container{{Resources: corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{gpu.NVIDIAResource: resource.MustParse("1")},
+	}}}
+	nodeA.AddPod(gpuHolder)
+	assert.False(t, plugin.Filter(ctx, state, pod, nodeA).IsSuccess())
+}
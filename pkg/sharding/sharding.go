@@ -0,0 +1,285 @@
+// Package sharding plans the layout of sharded models. Each shard of a
+// tensor- or pipeline-parallel model is one replica of its AgentPool; the
+// planner gives it a rank, the GPUs of its node it runs on, and for
+// pipeline parallelism the layers it serves. The scheduler plans a shard as
+// it binds its replica and records the plan in the replica's annotations,
+// which the environment of the agent container is read from.
+package sharding
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/topology"
+)
+
+// Sharding strategies of a ShardSpec
+const (
+	// StrategyTensorParallel splits every layer across the shards, which
+	// therefore all have to run for the model to serve
+	StrategyTensorParallel = "tensor-parallel"
+
+	// StrategyPipelineParallel splits the layers into consecutive stages,
+	// one per shard
+	StrategyPipelineParallel = "pipeline-parallel"
+
+	// StrategyDataParallel runs whole copies of the model, which need no
+	// plan
+	StrategyDataParallel = "data-parallel"
+)
+
+// Spec describes how a model is sharded
+type Spec struct {
+	// Strategy is StrategyTensorParallel or StrategyPipelineParallel
+	Strategy string
+
+	// Shards is the number of shards of the model
+	Shards int
+
+	// GPUsPerShard is the number of GPUs each shard runs on
+	GPUsPerShard int
+
+	// Layers is the number of transformer layers of the model, zero if
+	// unknown
+	Layers int
+}
+
+// Sharded reports whether the shards of spec are planned: those of tensor-
+// and pipeline-parallel models of more than one shard
+func (s Spec) Sharded() bool {
+	return s.Shards > 1 && (s.Strategy == StrategyTensorParallel || s.Strategy == StrategyPipelineParallel)
+}
+
+// Annotations returns the annotations describing spec on the replicas of a
+// sharded model, for the scheduler to plan them by
+func (s Spec) Annotations() map[string]string {
+	annotations := map[string]string{
+		neuronetes.AnnotationShardStrategy: s.Strategy,
+		neuronetes.AnnotationShardCount:    strconv.Itoa(s.Shards),
+	}
+	if s.Layers > 0 {
+		annotations[neuronetes.AnnotationModelLayers] = strconv.Itoa(s.Layers)
+	}
+	return annotations
+}
+
+// SpecOf returns the spec described by the annotations of a replica, or
+// false if it serves no sharded model. GPUsPerShard is left to the caller.
+func SpecOf(annotations map[string]string) (Spec, bool) {
+	shards, err := strconv.Atoi(annotations[neuronetes.AnnotationShardCount])
+	if err != nil {
+		return Spec{}, false
+	}
+	spec := Spec{Strategy: annotations[neuronetes.AnnotationShardStrategy], Shards: shards}
+	if layers, err := strconv.Atoi(annotations[neuronetes.AnnotationModelLayers]); err == nil {
+		spec.Layers = layers
+	}
+	return spec, spec.Sharded()
+}
+
+// Node is what a plan needs to know of the node a shard is placed on
+type Node struct {
+	// Links is the GPU link matrix of the node, nil if unknown
+	Links topology.Matrix
+
+	// Taken are the GPUs planned for other shards on the node
+	Taken []int
+
+	// Peers are the GPUs of the other shards of the same model on the
+	// node. Of equally connected groups, the one nearest them is planned.
+	Peers []int
+}
+
+// LayerRange is the first and last layer of a pipeline stage
+type LayerRange struct {
+	First int
+	Last  int
+}
+
+// Shard is the plan of a shard
+type Shard struct {
+	// Rank is the index of the shard, from 0 to Spec.Shards-1
+	Rank int
+
+	// GPUs are the indexes of the GPUs of its node the shard runs on, in
+	// order; none if the topology of the node is unknown
+	GPUs []int
+
+	// Link is the slowest link between the GPUs of the shard
+	Link string
+
+	// Layers are the layers a pipeline-parallel shard serves
+	Layers *LayerRange
+}
+
+// Plan plans a shard of spec on node. ranks are those of the shards of the
+// model already placed; the new shard takes the rank fewest of them hold,
+// so that every stage of a pool of several model replicas is filled.
+func Plan(spec Spec, ranks []int, node Node) Shard {
+	shard := Shard{Rank: nextRank(spec.Shards, ranks)}
+	if group, ok := bestGroup(node, spec.GPUsPerShard); ok {
+		shard.GPUs = append([]int(nil), group.GPUs...)
+		sort.Ints(shard.GPUs)
+		shard.Link = group.Link
+	}
+	if spec.Strategy == StrategyPipelineParallel && spec.Layers >= spec.Shards && spec.Shards > 0 {
+		shard.Layers = &LayerRange{
+			First: shard.Rank * spec.Layers / spec.Shards,
+			Last:  (shard.Rank+1)*spec.Layers/spec.Shards - 1,
+		}
+	}
+	return shard
+}
+
+// nextRank returns the lowest of the ranks of shards held fewest times
+func nextRank(shards int, ranks []int) int {
+	if shards < 1 {
+		return 0
+	}
+	held := make([]int, shards)
+	for _, rank := range ranks {
+		if rank >= 0 && rank < shards {
+			held[rank]++
+		}
+	}
+	next := 0
+	for rank, count := range held {
+		if count < held[next] {
+			next = rank
+		}
+	}
+	return next
+}
+
+// bestGroup returns the best connected n GPUs of node not taken by other
+// shards, or of all its GPUs if too few are free: the device plugin, not
+// the plan, decides which GPUs a replica gets
+func bestGroup(node Node, n int) (topology.Group, bool) {
+	if node.Links == nil || n < 1 {
+		return topology.Group{}, false
+	}
+	taken := make(map[int]bool, len(node.Taken))
+	for _, gpu := range node.Taken {
+		taken[gpu] = true
+	}
+	var free, all []int
+	for gpu := range node.Links {
+		all = append(all, gpu)
+		if !taken[gpu] {
+			free = append(free, gpu)
+		}
+	}
+	groups := node.Links.Groups(free, n)
+	if len(groups) == 0 {
+		groups = node.Links.Groups(all, n)
+	}
+	if len(groups) == 0 {
+		return topology.Group{}, false
+	}
+
+	best, bestPeers := groups[0], peerBandwidth(node, groups[0])
+	for _, group := range groups[1:] {
+		peers := peerBandwidth(node, group)
+		if group.Bandwidth > best.Bandwidth || group.Bandwidth == best.Bandwidth && peers > bestPeers {
+			best, bestPeers = group, peers
+		}
+	}
+	return best, true
+}
+
+// peerBandwidth returns the bandwidth of the slowest link between group
+// and the peers of node, zero without peers
+func peerBandwidth(node Node, group topology.Group) float64 {
+	bandwidth := -1.0
+	for _, gpu := range group.GPUs {
+		for _, peer := range node.Peers {
+			if peer < 0 || peer >= len(node.Links) || peer == gpu {
+				continue
+			}
+			if b := topology.Bandwidth(node.Links[gpu][peer]); bandwidth < 0 || b < bandwidth {
+				bandwidth = b
+			}
+		}
+	}
+	if bandwidth < 0 {
+		return 0
+	}
+	return bandwidth
+}
+
+// Annotations returns the annotations recording the plan of shard on its
+// replica
+func (s Shard) Annotations() map[string]string {
+	annotations := map[string]string{
+		neuronetes.AnnotationShardRank: strconv.Itoa(s.Rank),
+	}
+	if len(s.GPUs) > 0 {
+		gpus := make([]string, len(s.GPUs))
+		for i, gpu := range s.GPUs {
+			gpus[i] = strconv.Itoa(gpu)
+		}
+		annotations[neuronetes.AnnotationShardGPUs] = strings.Join(gpus, ",")
+	}
+	if s.Layers != nil {
+		annotations[neuronetes.AnnotationShardLayerRange] = fmt.Sprintf("%d-%d", s.Layers.First, s.Layers.Last)
+	}
+	return annotations
+}
+
+// Planned returns the plan recorded in the annotations of a replica, or
+// false if its shard has not been planned
+func Planned(annotations map[string]string) (Shard, bool) {
+	rank, err := strconv.Atoi(annotations[neuronetes.AnnotationShardRank])
+	if err != nil || rank < 0 {
+		return Shard{}, false
+	}
+	shard := Shard{Rank: rank}
+	if gpus := annotations[neuronetes.AnnotationShardGPUs]; gpus != "" {
+		for _, field := range strings.Split(gpus, ",") {
+			gpu, err := strconv.Atoi(field)
+			if err != nil {
+				return Shard{}, false
+			}
+			shard.GPUs = append(shard.GPUs, gpu)
+		}
+	}
+	if layers := annotations[neuronetes.AnnotationShardLayerRange]; layers != "" {
+		var r LayerRange
+		if _, err := fmt.Sscanf(layers, "%d-%d", &r.First, &r.Last); err == nil {
+			shard.Layers = &r
+		}
+	}
+	return shard, true
+}
+
+// Env returns the environment of the agent container of a shard of spec.
+// The strategy and shard count are fixed; the rank, GPUs and layers are
+// read through the downward API from the annotations the scheduler records
+// the plan in, and are empty until it has.
+func Env(spec Spec) []corev1.EnvVar {
+	env := []corev1.EnvVar{
+		{Name: "NEURONETES_SHARD_STRATEGY", Value: spec.Strategy},
+		{Name: "NEURONETES_SHARD_COUNT", Value: strconv.Itoa(spec.Shards)},
+		annotationEnv("NEURONETES_SHARD_RANK", neuronetes.AnnotationShardRank),
+		annotationEnv("NEURONETES_SHARD_GPUS", neuronetes.AnnotationShardGPUs),
+	}
+	if spec.Strategy == StrategyPipelineParallel {
+		env = append(env, annotationEnv("NEURONETES_SHARD_LAYERS", neuronetes.AnnotationShardLayerRange))
+	}
+	return env
+}
+
+// annotationEnv returns the variable name holding annotation of the pod
+func annotationEnv(name, annotation string) corev1.EnvVar {
+	return corev1.EnvVar{
+		Name: name,
+		ValueFrom: &corev1.EnvVarSource{
+			FieldRef: &corev1.ObjectFieldSelector{FieldPath: fmt.Sprintf("metadata.annotations['%s']", annotation)},
+		},
+	}
+}
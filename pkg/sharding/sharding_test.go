@@ -0,0 +1,106 @@
+package sharding
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+	"github.com/bowenislandsong/neuronetes/pkg/topology"
+)
+
+// pairs is a node of four GPUs joined in NVLink pairs, 0-1 and 2-3, and by
+// PCIe otherwise
+var pairs = topology.Matrix{
+	{"X", "NV12", "SYS", "SYS"},
+	{"NV12", "X", "SYS", "SYS"},
+	{"SYS", "SYS", "X", "NV12"},
+	{"SYS", "SYS", "NV12", "X"},
+}
+
+func TestPlanTensorParallel(t *testing.T) {
+	spec := Spec{Strategy: StrategyTensorParallel, Shards: 2, GPUsPerShard: 2, Layers: 32}
+	require.True(t, spec.Sharded())
+
+	first := Plan(spec, nil, Node{Links: pairs})
+	assert.Equal(t, Shard{Rank: 0, GPUs: []int{0, 1}, Link: "NV12"}, first)
+
+	// The second shard takes the other rank and the other NVLink pair
+	second := Plan(spec, []int{first.Rank}, Node{Links: pairs, Taken: first.GPUs, Peers: first.GPUs})
+	assert.Equal(t, Shard{Rank: 1, GPUs: []int{2, 3}, Link: "NV12"}, second)
+
+	// A node whose GPUs are all planned is planned again, as the device
+	// plugin decides
+	third := Plan(spec, []int{0, 1}, Node{Links: pairs, Taken: []int{0, 1, 2, 3}})
+	assert.Equal(t, 0, third.Rank)
+	assert.Len(t, third.GPUs, 2)
+
+	// Nodes of unknown topology are only given ranks
+	assert.Equal(t, Shard{Rank: 1}, Plan(spec, []int{0, 1, 0}, Node{}))
+}
+
+func TestPlanPrefersPeers(t *testing.T) {
+	// GPUs 1, 2 and 3 are all NVLinked with each other, GPU 3 only to 0
+	links := topology.Matrix{
+		{"X", "SYS", "SYS", "NV4"},
+		{"SYS", "X", "NV4", "NV4"},
+		{"SYS", "NV4", "X", "NV4"},
+		{"NV4", "NV4", "NV4", "X"},
+	}
+	spec := Spec{Strategy: StrategyTensorParallel, Shards: 4, GPUsPerShard: 1}
+	shard := Plan(spec, []int{0}, Node{Links: links, Taken: []int{0}, Peers: []int{0}})
+	assert.Equal(t, []int{3}, shard.GPUs)
+}
+
+func TestPlanPipelineParallel(t *testing.T) {
+	spec := Spec{Strategy: StrategyPipelineParallel, Shards: 3, GPUsPerShard: 1, Layers: 32}
+	var ranks []int
+	var layers []LayerRange
+	for i := 0; i < 3; i++ {
+		shard := Plan(spec, ranks, Node{})
+		ranks = append(ranks, shard.Rank)
+		require.NotNil(t, shard.Layers)
+		layers = append(layers, *shard.Layers)
+	}
+	assert.Equal(t, []int{0, 1, 2}, ranks)
+	assert.Equal(t, []LayerRange{{0, 9}, {10, 20}, {21, 31}}, layers)
+
+	// Without the layers of the model, stages are only ranked
+	spec.Layers = 0
+	assert.Nil(t, Plan(spec, nil, Node{}).Layers)
+}
+
+func TestAnnotations(t *testing.T) {
+	spec := Spec{Strategy: StrategyPipelineParallel, Shards: 2, Layers: 32}
+	parsed, ok := SpecOf(spec.Annotations())
+	require.True(t, ok)
+	assert.Equal(t, spec, parsed)
+
+	shard := Shard{Rank: 1, GPUs: []int{2, 3}, Layers: &LayerRange{First: 16, Last: 31}}
+	annotations := shard.Annotations()
+	assert.Equal(t, map[string]string{
+		neuronetes.AnnotationShardRank:       "1",
+		neuronetes.AnnotationShardGPUs:       "2,3",
+		neuronetes.AnnotationShardLayerRange: "16-31",
+	}, annotations)
+	planned, ok := Planned(annotations)
+	require.True(t, ok)
+	assert.Equal(t, shard, planned)
+
+	_, ok = Planned(nil)
+	assert.False(t, ok)
+	_, ok = SpecOf(Spec{Strategy: StrategyDataParallel, Shards: 4}.Annotations())
+	assert.False(t, ok)
+}
+
+func TestEnv(t *testing.T) {
+	env := Env(Spec{Strategy: StrategyTensorParallel, Shards: 4})
+	require.Len(t, env, 4)
+	assert.Equal(t, corev1.EnvVar{Name: "NEURONETES_SHARD_COUNT", Value: "4"}, env[1])
+	assert.Equal(t, "metadata.annotations['neuronetes.io/shard-rank']", env[2].ValueFrom.FieldRef.FieldPath)
+
+	env = Env(Spec{Strategy: StrategyPipelineParallel, Shards: 2})
+	assert.Equal(t, "NEURONETES_SHARD_LAYERS", env[len(env)-1].Name)
+}
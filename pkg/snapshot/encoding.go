@@ -0,0 +1,56 @@
+package snapshot
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"time"
+)
+
+// Encoder encodes the rows of a snapshot into a file format. Formats such
+// as Parquet plug in by implementing it.
+type Encoder interface {
+	Encode(w io.Writer, rows []Row) error
+
+	// Extension is the file extension of the format, e.g. .csv
+	Extension() string
+
+	// ContentType is the media type of the format
+	ContentType() string
+}
+
+// csvHeader are the columns of CSV snapshots
+var csvHeader = []string{"timestamp", "window_seconds", "metric", "namespace", "pool", "model", "tenant", "value"}
+
+// CSV encodes snapshots as CSV with a header row
+type CSV struct{}
+
+// Encode implements Encoder
+func (CSV) Encode(w io.Writer, rows []Row) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, row := range rows {
+		if err := writer.Write([]string{
+			row.Timestamp.UTC().Format(time.RFC3339),
+			strconv.FormatFloat(row.Window.Seconds(), 'f', -1, 64),
+			row.Metric,
+			row.Namespace,
+			row.Pool,
+			row.Model,
+			row.Tenant,
+			strconv.FormatFloat(row.Value, 'g', -1, 64),
+		}); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// Extension implements Encoder
+func (CSV) Extension() string { return ".csv" }
+
+// ContentType implements Encoder
+func (CSV) ContentType() string { return "text/csv" }
@@ -0,0 +1,254 @@
+// Package snapshot periodically exports aggregates of the cluster's metrics
+// (tokens, GPU hours, cost, SLO compliance) to object storage, for capacity
+// planning with offline tools such as spreadsheets or data warehouses.
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"text/template"
+	"time"
+
+	promv1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// DefaultInterval is how often snapshots are exported, and the window
+	// each one aggregates
+	DefaultInterval = time.Hour
+
+	// DefaultRetention is how long snapshots are kept
+	DefaultRetention = 90 * 24 * time.Hour
+)
+
+// Query is an aggregate exported in snapshots
+type Query struct {
+	// Metric names the aggregate in the rows of a snapshot
+	Metric string
+
+	// Expr is a PromQL template rendered with .Window, the window of the
+	// snapshot. The namespace, pool, model and tenant labels of its series
+	// are kept; others are dropped.
+	Expr string
+}
+
+// DefaultQueries are the aggregates of a snapshot, per pool and, where the
+// metrics carry them, per model and tenant. SLO compliance is the share of
+// turns within the default TTFT and latency objectives, 350ms and 2.5s.
+var DefaultQueries = []Query{
+	{Metric: "input_tokens", Expr: `sum by (namespace, pool, model) (increase(agent_input_tokens_total[{{.Window}}]))`},
+	{Metric: "output_tokens", Expr: `sum by (namespace, pool, model) (increase(agent_output_tokens_total[{{.Window}}]))`},
+	{Metric: "gpu_hours", Expr: `sum by (namespace, pool) (increase(pool_gpu_hours_total[{{.Window}}]))`},
+	{Metric: "cost_usd", Expr: `sum by (namespace, pool) (increase(pool_cost_usd_total[{{.Window}}]))`},
+	{Metric: "tenant_cost_usd", Expr: `sum by (namespace, pool, tenant) (increase(tenant_cost_usd_total[{{.Window}}]))`},
+	{Metric: "ttft_p95_ms", Expr: `histogram_quantile(0.95, sum by (namespace, pool, le) (rate(agent_ttft_ms_bucket[{{.Window}}])))`},
+	{Metric: "ttft_slo_compliance", Expr: `sum by (namespace, pool) (increase(agent_ttft_ms_bucket{le=~"350(\\.0)?"}[{{.Window}}])) / sum by (namespace, pool) (increase(agent_ttft_ms_count[{{.Window}}]))`},
+	{Metric: "latency_slo_compliance", Expr: `sum by (namespace, pool) (increase(agent_latency_ms_bucket{le=~"2500(\\.0)?"}[{{.Window}}])) / sum by (namespace, pool) (increase(agent_latency_ms_count[{{.Window}}]))`},
+}
+
+// Row is an aggregate of a snapshot
+type Row struct {
+	// Timestamp is the end of the window of the snapshot
+	Timestamp time.Time
+	Window    time.Duration
+	Metric    string
+	Namespace string
+	Pool      string
+	Model     string
+	Tenant    string
+	Value     float64
+}
+
+// Querier evaluates PromQL, as promv1.API does
+type Querier interface {
+	Query(ctx context.Context, query string, ts time.Time, opts ...promv1.Option) (model.Value, promv1.Warnings, error)
+}
+
+// Exporter exports a snapshot of the aggregates of the last interval to a
+// store every interval, and deletes the snapshots past the retention
+type Exporter struct {
+	Querier Querier
+	Store   Store
+
+	// Encoder encodes snapshots. Defaults to CSV.
+	Encoder Encoder
+
+	// Queries defaults to DefaultQueries
+	Queries []Query
+
+	// Interval defaults to DefaultInterval
+	Interval time.Duration
+
+	// Retention defaults to DefaultRetention
+	Retention time.Duration
+
+	now func() time.Time
+}
+
+// SetupWithManager adds the exporter to mgr, running on the leader only
+func (e *Exporter) SetupWithManager(mgr ctrl.Manager) error {
+	return mgr.Add(e)
+}
+
+// Start exports and prunes every interval until ctx is done
+func (e *Exporter) Start(ctx context.Context) error {
+	logger := log.FromContext(ctx).WithName("snapshot-exporter")
+	ticker := time.NewTicker(e.interval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			key, err := e.Export(ctx)
+			if err != nil {
+				logger.Error(err, "failed to export metrics snapshot")
+				continue
+			}
+			logger.V(1).Info("exported metrics snapshot", "key", key)
+			if _, err := e.Prune(ctx); err != nil {
+				logger.Error(err, "failed to prune metrics snapshots")
+			}
+		}
+	}
+}
+
+// Export queries the aggregates of the last interval and saves them as a
+// snapshot keyed by date, e.g. 2024/05/01/20240501T120000Z.csv. It returns
+// the key of the snapshot.
+func (e *Exporter) Export(ctx context.Context) (string, error) {
+	now := e.clock().UTC().Truncate(time.Second)
+	rows, err := e.Snapshot(ctx, now)
+	if err != nil {
+		return "", err
+	}
+	encoder := e.encoder()
+	var buf bytes.Buffer
+	if err := encoder.Encode(&buf, rows); err != nil {
+		return "", fmt.Errorf("failed to encode metrics snapshot: %w", err)
+	}
+	key := now.Format("2006/01/02/20060102T150405Z") + encoder.Extension()
+	if err := e.Store.Put(ctx, key, buf.Bytes(), encoder.ContentType()); err != nil {
+		return "", fmt.Errorf("failed to save metrics snapshot %s: %w", key, err)
+	}
+	return key, nil
+}
+
+// Snapshot returns the aggregates of the interval ending at ts, sorted by
+// metric and labels
+func (e *Exporter) Snapshot(ctx context.Context, ts time.Time) ([]Row, error) {
+	window := e.interval()
+	queries := e.Queries
+	if queries == nil {
+		queries = DefaultQueries
+	}
+
+	var rows []Row
+	for _, query := range queries {
+		expr, err := render(query.Expr, window)
+		if err != nil {
+			return nil, fmt.Errorf("invalid query for %s: %w", query.Metric, err)
+		}
+		value, _, err := e.Querier.Query(ctx, expr, ts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query %s: %w", query.Metric, err)
+		}
+		vector, ok := value.(model.Vector)
+		if !ok {
+			return nil, fmt.Errorf("query for %s returned %s, not a vector", query.Metric, value.Type())
+		}
+		for _, sample := range vector {
+			rows = append(rows, Row{
+				Timestamp: ts,
+				Window:    window,
+				Metric:    query.Metric,
+				Namespace: string(sample.Metric["namespace"]),
+				Pool:      string(sample.Metric["pool"]),
+				Model:     string(sample.Metric["model"]),
+				Tenant:    string(sample.Metric["tenant"]),
+				Value:     float64(sample.Value),
+			})
+		}
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		a, b := rows[i], rows[j]
+		if a.Metric != b.Metric {
+			return a.Metric < b.Metric
+		}
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		if a.Pool != b.Pool {
+			return a.Pool < b.Pool
+		}
+		if a.Model != b.Model {
+			return a.Model < b.Model
+		}
+		return a.Tenant < b.Tenant
+	})
+	return rows, nil
+}
+
+// Prune deletes the snapshots saved before the retention and returns their
+// keys
+func (e *Exporter) Prune(ctx context.Context) ([]string, error) {
+	retention := e.Retention
+	if retention <= 0 {
+		retention = DefaultRetention
+	}
+	objects, err := e.Store.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list metrics snapshots: %w", err)
+	}
+	cutoff := e.clock().Add(-retention)
+	var pruned []string
+	for _, object := range objects {
+		if !object.LastModified.Before(cutoff) {
+			continue
+		}
+		if err := e.Store.Delete(ctx, object.Key); err != nil {
+			return pruned, fmt.Errorf("failed to delete metrics snapshot %s: %w", object.Key, err)
+		}
+		pruned = append(pruned, object.Key)
+	}
+	return pruned, nil
+}
+
+func (e *Exporter) interval() time.Duration {
+	if e.Interval <= 0 {
+		return DefaultInterval
+	}
+	return e.Interval
+}
+
+func (e *Exporter) encoder() Encoder {
+	if e.Encoder == nil {
+		return CSV{}
+	}
+	return e.Encoder
+}
+
+func (e *Exporter) clock() time.Time {
+	if e.now == nil {
+		return time.Now()
+	}
+	return e.now()
+}
+
+// render renders a query template for window
+func render(expr string, window time.Duration) (string, error) {
+	tmpl, err := template.New("query").Parse(expr)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, struct{ Window string }{model.Duration(window).String()}); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
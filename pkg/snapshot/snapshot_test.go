@@ -0,0 +1,281 @@
+package snapshot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	promv1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeQuerier answers queries containing a key with its vector
+type fakeQuerier struct {
+	results map[string]model.Vector
+	queries []string
+}
+
+func (q *fakeQuerier) Query(ctx context.Context, query string, ts time.Time, opts ...promv1.Option) (model.Value, promv1.Warnings, error) {
+	q.queries = append(q.queries, query)
+	for key, vector := range q.results {
+		if strings.Contains(query, key) {
+			return vector, nil, nil
+		}
+	}
+	return model.Vector{}, nil, nil
+}
+
+// memoryStore keeps objects in memory
+type memoryStore struct {
+	objects map[string]Object
+	data    map[string][]byte
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{objects: map[string]Object{}, data: map[string][]byte{}}
+}
+
+func (s *memoryStore) Put(ctx context.Context, key string, data []byte, contentType string) error {
+	s.objects[key] = Object{Key: key, LastModified: time.Now()}
+	s.data[key] = data
+	return nil
+}
+
+func (s *memoryStore) List(ctx context.Context) ([]Object, error) {
+	var objects []Object
+	for _, object := range s.objects {
+		objects = append(objects, object)
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, nil
+}
+
+func (s *memoryStore) Delete(ctx context.Context, key string) error {
+	delete(s.objects, key)
+	delete(s.data, key)
+	return nil
+}
+
+func sample(value float64, labels ...string) *model.Sample {
+	metric := model.Metric{}
+	for i := 0; i+1 < len(labels); i += 2 {
+		metric[model.LabelName(labels[i])] = model.LabelValue(labels[i+1])
+	}
+	return &model.Sample{Metric: metric, Value: model.SampleValue(value)}
+}
+
+func TestExport(t *testing.T) {
+	querier := &fakeQuerier{results: map[string]model.Vector{
+		"agent_output_tokens_total": {
+			sample(2000, "namespace", "default", "pool", "chat", "model", "llama-3-8b"),
+			sample(500, "namespace", "default", "pool", "batch", "model", "llama-3-8b"),
+		},
+		"pool_gpu_hours_total":    {sample(4, "namespace", "default", "pool", "chat")},
+		"tenant_cost_usd_total":   {sample(1.25, "namespace", "default", "pool", "chat", "tenant", "acme")},
+		`agent_ttft_ms_bucket{le`: {sample(0.98, "namespace", "default", "pool", "chat")},
+	}}
+	store := newMemoryStore()
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	exporter := &Exporter{Querier: querier, Store: store, now: func() time.Time { return now }}
+
+	key, err := exporter.Export(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "2024/05/01/20240501T120000Z.csv", key)
+	assert.Len(t, querier.queries, len(DefaultQueries))
+	for _, query := range querier.queries {
+		assert.NotContains(t, query, "{{")
+		if strings.Contains(query, "[") {
+			assert.Contains(t, query, "[1h]")
+		}
+	}
+
+	assert.Equal(t, strings.Join([]string{
+		"timestamp,window_seconds,metric,namespace,pool,model,tenant,value",
+		"2024-05-01T12:00:00Z,3600,gpu_hours,default,chat,,,4",
+		"2024-05-01T12:00:00Z,3600,output_tokens,default,batch,llama-3-8b,,500",
+		"2024-05-01T12:00:00Z,3600,output_tokens,default,chat,llama-3-8b,,2000",
+		"2024-05-01T12:00:00Z,3600,tenant_cost_usd,default,chat,,acme,1.25",
+		"2024-05-01T12:00:00Z,3600,ttft_slo_compliance,default,chat,,,0.98",
+		"",
+	}, "\n"), string(store.data[key]))
+}
+
+func TestExportErrors(t *testing.T) {
+	exporter := &Exporter{
+		Querier: &fakeQuerier{},
+		Store:   newMemoryStore(),
+		Queries: []Query{{Metric: "broken", Expr: "{{.Missing"}},
+	}
+	_, err := exporter.Export(context.Background())
+	assert.ErrorContains(t, err, "invalid query for broken")
+
+	exporter.Querier = querierFunc(func(ctx context.Context, query string, ts time.Time, opts ...promv1.Option) (model.Value, promv1.Warnings, error) {
+		return model.Matrix{}, nil, nil
+	})
+	exporter.Queries = []Query{{Metric: "range", Expr: "up"}}
+	_, err = exporter.Export(context.Background())
+	assert.ErrorContains(t, err, "not a vector")
+}
+
+type querierFunc func(ctx context.Context, query string, ts time.Time, opts ...promv1.Option) (model.Value, promv1.Warnings, error)
+
+func (f querierFunc) Query(ctx context.Context, query string, ts time.Time, opts ...promv1.Option) (model.Value, promv1.Warnings, error) {
+	return f(ctx, query, ts, opts...)
+}
+
+func TestPrune(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	store := newMemoryStore()
+	store.objects["old.csv"] = Object{Key: "old.csv", LastModified: now.Add(-8 * 24 * time.Hour)}
+	store.objects["recent.csv"] = Object{Key: "recent.csv", LastModified: now.Add(-6 * 24 * time.Hour)}
+	exporter := &Exporter{Store: store, Retention: 7 * 24 * time.Hour, now: func() time.Time { return now }}
+
+	pruned, err := exporter.Prune(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"old.csv"}, pruned)
+	assert.Contains(t, store.objects, "recent.csv")
+	assert.NotContains(t, store.objects, "old.csv")
+}
+
+func TestS3Store(t *testing.T) {
+	var mu sync.Mutex
+	objects := map[string][]byte{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Contains(t, r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/")
+		assert.NotEmpty(t, r.Header.Get("X-Amz-Content-Sha256"))
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.Method {
+		case http.MethodPut:
+			assert.Equal(t, "text/csv", r.Header.Get("Content-Type"))
+			objects[strings.TrimPrefix(r.URL.Path, "/metrics/")], _ = io.ReadAll(r.Body)
+		case http.MethodGet:
+			assert.Equal(t, "2", r.URL.Query().Get("list-type"))
+			prefix := r.URL.Query().Get("prefix")
+			var keys []string
+			for key := range objects {
+				if strings.HasPrefix(key, prefix) {
+					keys = append(keys, key)
+				}
+			}
+			sort.Strings(keys)
+			// one object per page, to follow continuation tokens
+			start := 0
+			if token := r.URL.Query().Get("continuation-token"); token != "" {
+				_, _ = fmt.Sscanf(token, "%d", &start)
+			}
+			fmt.Fprint(w, "<ListBucketResult>")
+			if start < len(keys) {
+				fmt.Fprintf(w, "<Contents><Key>%s</Key><LastModified>2024-05-01T12:00:00.000Z</LastModified></Contents>", keys[start])
+			}
+			if start+1 < len(keys) {
+				fmt.Fprintf(w, "<IsTruncated>true</IsTruncated><NextContinuationToken>%d</NextContinuationToken>", start+1)
+			}
+			fmt.Fprint(w, "</ListBucketResult>")
+		case http.MethodDelete:
+			delete(objects, strings.TrimPrefix(r.URL.Path, "/metrics/"))
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	store := newS3Store(aws.CredentialsProviderFunc(func(ctx context.Context) (aws.Credentials, error) {
+		return aws.Credentials{AccessKeyID: "AKID", SecretAccessKey: "SECRET"}, nil
+	}), "metrics", "snapshots/", "us-west-2")
+	store.endpoint = server.URL + "/metrics"
+
+	require.NoError(t, store.Put(ctx, "2024/05/01/a.csv", []byte("a"), "text/csv"))
+	require.NoError(t, store.Put(ctx, "2024/05/01/b.csv", []byte("b"), "text/csv"))
+	assert.Equal(t, []byte("a"), objects["snapshots/2024/05/01/a.csv"])
+
+	listed, err := store.List(ctx)
+	require.NoError(t, err)
+	modified := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	assert.Equal(t, []Object{
+		{Key: "2024/05/01/a.csv", LastModified: modified},
+		{Key: "2024/05/01/b.csv", LastModified: modified},
+	}, listed)
+
+	require.NoError(t, store.Delete(ctx, "2024/05/01/a.csv"))
+	assert.NotContains(t, objects, "snapshots/2024/05/01/a.csv")
+}
+
+func TestGCSStore(t *testing.T) {
+	var mu sync.Mutex
+	objects := map[string][]byte{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/upload/storage/v1/b/metrics/o":
+			assert.Equal(t, "media", r.URL.Query().Get("uploadType"))
+			objects[r.URL.Query().Get("name")], _ = io.ReadAll(r.Body)
+			fmt.Fprint(w, "{}")
+		case r.Method == http.MethodGet && r.URL.Path == "/storage/v1/b/metrics/o":
+			prefix := r.URL.Query().Get("prefix")
+			type item struct {
+				Name    string `json:"name"`
+				Updated string `json:"updated"`
+			}
+			var items []item
+			for name := range objects {
+				if strings.HasPrefix(name, prefix) {
+					items = append(items, item{Name: name, Updated: "2024-05-01T12:00:00.000Z"})
+				}
+			}
+			sort.Slice(items, func(i, j int) bool { return items[i].Name < items[j].Name })
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"items": items})
+		case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, "/storage/v1/b/metrics/o/"):
+			delete(objects, strings.TrimPrefix(r.URL.Path, "/storage/v1/b/metrics/o/"))
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	store := newGCSStore(server.Client(), "metrics", "snapshots/")
+	store.endpoint = server.URL
+
+	require.NoError(t, store.Put(ctx, "2024/05/01/a.csv", []byte("a"), "text/csv"))
+	assert.Equal(t, []byte("a"), objects["snapshots/2024/05/01/a.csv"])
+
+	listed, err := store.List(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []Object{{Key: "2024/05/01/a.csv", LastModified: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}}, listed)
+
+	require.NoError(t, store.Delete(ctx, "2024/05/01/a.csv"))
+	assert.Empty(t, objects)
+}
+
+func TestNewStore(t *testing.T) {
+	ctx := context.Background()
+	_, err := NewStore(ctx, "azure://container/prefix")
+	assert.ErrorContains(t, err, "unsupported")
+	_, err = NewStore(ctx, "s3:///prefix")
+	assert.ErrorContains(t, err, "no bucket")
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKID")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "SECRET")
+	t.Setenv("AWS_CONFIG_FILE", "/dev/null")
+	store, err := NewStore(ctx, "s3://metrics/snapshots?region=eu-west-1")
+	require.NoError(t, err)
+	s3, ok := store.(*S3Store)
+	require.True(t, ok)
+	assert.Equal(t, "metrics", s3.bucket)
+	assert.Equal(t, "snapshots/", s3.prefix)
+	assert.Equal(t, "eu-west-1", s3.region)
+}
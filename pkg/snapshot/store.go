@@ -0,0 +1,322 @@
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"golang.org/x/oauth2/google"
+)
+
+// Object is a snapshot saved in a store
+type Object struct {
+	Key          string
+	LastModified time.Time
+}
+
+// Store saves snapshots under keys relative to its location
+type Store interface {
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	List(ctx context.Context) ([]Object, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// NewStore creates the store of location, an s3://bucket/prefix or
+// gs://bucket/prefix URL, with the default credentials of the cloud, such
+// as those of the service account's IAM role or workload identity. The
+// region of S3 buckets defaults to the region of the AWS SDK configuration
+// and can be set with a region query parameter.
+func NewStore(ctx context.Context, location string) (Store, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("invalid snapshot location %q: %w", location, err)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("snapshot location %q has no bucket", location)
+	}
+	prefix := strings.TrimPrefix(u.Path, "/")
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	switch u.Scheme {
+	case "s3":
+		return NewS3Store(ctx, u.Host, prefix, u.Query().Get("region"))
+	case "gs":
+		return NewGCSStore(ctx, u.Host, prefix)
+	default:
+		return nil, fmt.Errorf("unsupported snapshot location %q: want s3:// or gs://", location)
+	}
+}
+
+// S3Store saves snapshots in an S3 bucket. It needs the s3:PutObject,
+// s3:ListBucket and s3:DeleteObject permissions on the prefix.
+type S3Store struct {
+	bucket string
+	prefix string
+	region string
+
+	credentials aws.CredentialsProvider
+	client      *http.Client
+	signer      *v4.Signer
+	now         func() time.Time
+
+	// endpoint is the URL of the bucket, overridden by tests
+	endpoint string
+}
+
+// NewS3Store creates a store saving under prefix in bucket with the
+// default credentials of the AWS SDK. region defaults to the region of the
+// SDK configuration.
+func NewS3Store(ctx context.Context, bucket, prefix, region string) (*S3Store, error) {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS credentials: %w", err)
+	}
+	if region == "" {
+		region = cfg.Region
+	}
+	if region == "" {
+		return nil, fmt.Errorf("no AWS region configured for bucket %s", bucket)
+	}
+	return newS3Store(cfg.Credentials, bucket, prefix, region), nil
+}
+
+func newS3Store(credentials aws.CredentialsProvider, bucket, prefix, region string) *S3Store {
+	return &S3Store{
+		bucket:      bucket,
+		prefix:      prefix,
+		region:      region,
+		credentials: credentials,
+		client:      &http.Client{Timeout: time.Minute},
+		// S3 signs paths as they are sent
+		signer:   v4.NewSigner(func(o *v4.SignerOptions) { o.DisableURIPathEscaping = true }),
+		now:      time.Now,
+		endpoint: "https://" + bucket + ".s3." + region + ".amazonaws.com",
+	}
+}
+
+// Put implements Store
+func (s *S3Store) Put(ctx context.Context, key string, data []byte, contentType string) error {
+	status, body, err := s.do(ctx, http.MethodPut, s.prefix+key, nil, data, contentType)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("failed to put s3://%s/%s%s: unexpected status %d: %s", s.bucket, s.prefix, key, status, bytes.TrimSpace(body))
+	}
+	return nil
+}
+
+// s3ListResult is the response of ListObjectsV2
+type s3ListResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// List implements Store
+func (s *S3Store) List(ctx context.Context) ([]Object, error) {
+	var objects []Object
+	query := url.Values{"list-type": {"2"}, "prefix": {s.prefix}}
+	for {
+		status, body, err := s.do(ctx, http.MethodGet, "", query, nil, "")
+		if err != nil {
+			return nil, err
+		}
+		if status != http.StatusOK {
+			return nil, fmt.Errorf("failed to list s3://%s/%s: unexpected status %d: %s", s.bucket, s.prefix, status, bytes.TrimSpace(body))
+		}
+		var result s3ListResult
+		if err := xml.Unmarshal(body, &result); err != nil {
+			return nil, fmt.Errorf("failed to parse listing of s3://%s/%s: %w", s.bucket, s.prefix, err)
+		}
+		for _, content := range result.Contents {
+			objects = append(objects, Object{Key: strings.TrimPrefix(content.Key, s.prefix), LastModified: content.LastModified})
+		}
+		if !result.IsTruncated {
+			return objects, nil
+		}
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+}
+
+// Delete implements Store
+func (s *S3Store) Delete(ctx context.Context, key string) error {
+	status, body, err := s.do(ctx, http.MethodDelete, s.prefix+key, nil, nil, "")
+	if err != nil {
+		return err
+	}
+	if status != http.StatusNoContent && status != http.StatusOK {
+		return fmt.Errorf("failed to delete s3://%s/%s%s: unexpected status %d: %s", s.bucket, s.prefix, key, status, bytes.TrimSpace(body))
+	}
+	return nil
+}
+
+// do sends a request for key, or the bucket if key is empty, signed with
+// the store's credentials and returns the response status and body
+func (s *S3Store) do(ctx context.Context, method, key string, query url.Values, data []byte, contentType string) (int, []byte, error) {
+	credentials, err := s.credentials.Retrieve(ctx)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to retrieve AWS credentials: %w", err)
+	}
+	endpoint, err := url.JoinPath(s.endpoint, key)
+	if err != nil {
+		return 0, nil, err
+	}
+	if key == "" {
+		endpoint += "/"
+	}
+	if query != nil {
+		endpoint += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, bytes.NewReader(data))
+	if err != nil {
+		return 0, nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	hash := sha256.Sum256(data)
+	payloadHash := hex.EncodeToString(hash[:])
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if err := s.signer.SignHTTP(ctx, credentials, req, payloadHash, "s3", s.region, s.now()); err != nil {
+		return 0, nil, err
+	}
+	return send(s.client, req)
+}
+
+// gcsScope allows reading and writing objects
+const gcsScope = "https://www.googleapis.com/auth/devstorage.read_write"
+
+// GCSStore saves snapshots in a Cloud Storage bucket through its JSON API.
+// It needs the storage.objects.create, list and delete permissions.
+type GCSStore struct {
+	bucket string
+	prefix string
+	client *http.Client
+
+	// endpoint is the URL of the API, overridden by tests
+	endpoint string
+}
+
+// NewGCSStore creates a store saving under prefix in bucket with the
+// application default credentials of Google Cloud
+func NewGCSStore(ctx context.Context, bucket, prefix string) (*GCSStore, error) {
+	client, err := google.DefaultClient(ctx, gcsScope)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load Google Cloud credentials: %w", err)
+	}
+	client.Timeout = time.Minute
+	return newGCSStore(client, bucket, prefix), nil
+}
+
+func newGCSStore(client *http.Client, bucket, prefix string) *GCSStore {
+	return &GCSStore{
+		bucket:   bucket,
+		prefix:   prefix,
+		client:   client,
+		endpoint: "https://storage.googleapis.com",
+	}
+}
+
+// Put implements Store
+func (g *GCSStore) Put(ctx context.Context, key string, data []byte, contentType string) error {
+	endpoint := fmt.Sprintf("%s/upload/storage/v1/b/%s/o?%s", g.endpoint, url.PathEscape(g.bucket),
+		url.Values{"uploadType": {"media"}, "name": {g.prefix + key}}.Encode())
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	status, body, err := send(g.client, req)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("failed to put gs://%s/%s%s: unexpected status %d: %s", g.bucket, g.prefix, key, status, bytes.TrimSpace(body))
+	}
+	return nil
+}
+
+// List implements Store
+func (g *GCSStore) List(ctx context.Context) ([]Object, error) {
+	var objects []Object
+	query := url.Values{"prefix": {g.prefix}, "fields": {"items(name,updated),nextPageToken"}}
+	for {
+		endpoint := fmt.Sprintf("%s/storage/v1/b/%s/o?%s", g.endpoint, url.PathEscape(g.bucket), query.Encode())
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+		if err != nil {
+			return nil, err
+		}
+		status, body, err := send(g.client, req)
+		if err != nil {
+			return nil, err
+		}
+		if status != http.StatusOK {
+			return nil, fmt.Errorf("failed to list gs://%s/%s: unexpected status %d: %s", g.bucket, g.prefix, status, bytes.TrimSpace(body))
+		}
+		var page struct {
+			Items []struct {
+				Name    string    `json:"name"`
+				Updated time.Time `json:"updated"`
+			} `json:"items"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		if err := json.Unmarshal(body, &page); err != nil {
+			return nil, fmt.Errorf("failed to parse listing of gs://%s/%s: %w", g.bucket, g.prefix, err)
+		}
+		for _, item := range page.Items {
+			objects = append(objects, Object{Key: strings.TrimPrefix(item.Name, g.prefix), LastModified: item.Updated})
+		}
+		if page.NextPageToken == "" {
+			return objects, nil
+		}
+		query.Set("pageToken", page.NextPageToken)
+	}
+}
+
+// Delete implements Store
+func (g *GCSStore) Delete(ctx context.Context, key string) error {
+	endpoint := fmt.Sprintf("%s/storage/v1/b/%s/o/%s", g.endpoint, url.PathEscape(g.bucket), url.PathEscape(g.prefix+key))
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	status, body, err := send(g.client, req)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusNoContent && status != http.StatusOK {
+		return fmt.Errorf("failed to delete gs://%s/%s%s: unexpected status %d: %s", g.bucket, g.prefix, key, status, bytes.TrimSpace(body))
+	}
+	return nil
+}
+
+// send sends req and returns the response status and body
+func send(client *http.Client, req *http.Request) (int, []byte, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, err
+	}
+	return resp.StatusCode, data, nil
+}
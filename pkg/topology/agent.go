@@ -0,0 +1,112 @@
+package topology
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// DefaultPublishInterval is how often an Agent rediscovers its node's
+// topology, which only changes when GPUs are replaced or fail
+const DefaultPublishInterval = 10 * time.Minute
+
+// Discover runs nvidia-smi topo -m and parses the GPU link matrix. On nodes
+// without nvidia-smi it reads the links of AMD GPUs from rocm-smi instead.
+func Discover(ctx context.Context) (Matrix, error) {
+	out, err := exec.CommandContext(ctx, "nvidia-smi", "topo", "-m").Output()
+	if errors.Is(err, exec.ErrNotFound) {
+		out, err = exec.CommandContext(ctx, "rocm-smi", "--showtopotype").Output()
+		if err != nil {
+			return nil, fmt.Errorf("failed to run rocm-smi --showtopotype: %w", err)
+		}
+		return ParseROCm(bytes.NewReader(out))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to run nvidia-smi topo: %w", err)
+	}
+	return Parse(bytes.NewReader(out))
+}
+
+// Agent publishes the GPU link matrix of the node it runs on as the
+// AnnotationLinks annotation, where the scheduler reads it. It runs as a
+// DaemonSet on GPU nodes with the node name from the downward API.
+type Agent struct {
+	client   kubernetes.Interface
+	node     string
+	interval time.Duration
+
+	// discover defaults to Discover
+	discover func(ctx context.Context) (Matrix, error)
+}
+
+// NewAgent creates an agent publishing the topology of node every interval.
+// A zero interval uses DefaultPublishInterval.
+func NewAgent(client kubernetes.Interface, node string, interval time.Duration) *Agent {
+	if interval <= 0 {
+		interval = DefaultPublishInterval
+	}
+	return &Agent{
+		client:   client,
+		node:     node,
+		interval: interval,
+		discover: Discover,
+	}
+}
+
+// Publish discovers the topology once and annotates the node if it changed
+func (a *Agent) Publish(ctx context.Context) error {
+	matrix, err := a.discover(ctx)
+	if err != nil {
+		return err
+	}
+	links := matrix.Encode()
+
+	node, err := a.client.CoreV1().Nodes().Get(ctx, a.node, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get node %s: %w", a.node, err)
+	}
+	if node.Annotations[AnnotationLinks] == links {
+		return nil
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{AnnotationLinks: links},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := a.client.CoreV1().Nodes().Patch(ctx, a.node, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		return fmt.Errorf("failed to annotate node %s: %w", a.node, err)
+	}
+	log.FromContext(ctx).Info("Published GPU topology", "node", a.node, "gpus", matrix.GPUs())
+	return nil
+}
+
+// Start publishes every interval until ctx is done. Failures are retried on
+// the next tick.
+func (a *Agent) Start(ctx context.Context) error {
+	logger := log.FromContext(ctx).WithValues("node", a.node)
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+	for {
+		if err := a.Publish(ctx); err != nil {
+			logger.Error(err, "failed to publish GPU topology")
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
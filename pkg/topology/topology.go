@@ -0,0 +1,310 @@
+// Package topology discovers how the GPUs of a node are interconnected and
+// estimates the bandwidth between them, so that the shards of a model can be
+// placed on GPUs joined by NVLink, NVSwitch or AMD Infinity Fabric rather
+// than PCIe.
+package topology
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// AnnotationLinks is the node annotation holding the GPU link matrix
+// published by the topology agent, e.g. X,NV12;NV12,X for two GPUs joined by
+// twelve NVLinks
+const AnnotationLinks = "neuronetes.io/gpu-links"
+
+// Link types reported by nvidia-smi topo -m, from the fastest to the slowest
+// PCIe path. NVLink connections are reported as NV<links>.
+const (
+	LinkSelf = "X"
+	LinkPIX  = "PIX"  // at most a single PCIe bridge
+	LinkPXB  = "PXB"  // multiple PCIe bridges
+	LinkPHB  = "PHB"  // a PCIe host bridge
+	LinkNode = "NODE" // host bridges within a NUMA node
+	LinkSys  = "SYS"  // the interconnect between NUMA nodes
+
+	// LinkXGMI joins two AMD GPUs over Infinity Fabric, as reported by
+	// rocm-smi --showtopotype
+	LinkXGMI = "XGMI"
+)
+
+// nvlinkBandwidth is the bidirectional bandwidth of one NVLink in GB/s, as
+// of NVLink 3 and 4: 12 links give an A100 its 600 GB/s, 18 an H100 900 GB/s
+const nvlinkBandwidth = 50
+
+// xgmiBandwidth is the bidirectional bandwidth of the Infinity Fabric link
+// between two MI300X GPUs in GB/s, which are fully meshed by seven of them
+const xgmiBandwidth = 128
+
+// pcieBandwidth estimates the bidirectional bandwidth in GB/s of PCIe paths,
+// a PCIe 4.0 x16 link degraded by each bridge it crosses
+var pcieBandwidth = map[string]float64{
+	LinkPIX:  64,
+	LinkPXB:  48,
+	LinkPHB:  32,
+	LinkNode: 24,
+	LinkSys:  16,
+}
+
+// Bandwidth returns the estimated bidirectional bandwidth of link in GB/s,
+// or zero for an unknown link
+func Bandwidth(link string) float64 {
+	if n, ok := nvlinks(link); ok {
+		return float64(n) * nvlinkBandwidth
+	}
+	if link == LinkXGMI {
+		return xgmiBandwidth
+	}
+	return pcieBandwidth[link]
+}
+
+// IsNVLink reports whether link joins two GPUs over NVLink
+func IsNVLink(link string) bool {
+	_, ok := nvlinks(link)
+	return ok
+}
+
+// IsFabric reports whether link joins two GPUs over a GPU fabric, NVLink or
+// Infinity Fabric, rather than PCIe
+func IsFabric(link string) bool {
+	return IsNVLink(link) || link == LinkXGMI
+}
+
+// nvlinks returns the number of NVLinks of an NV<links> link
+func nvlinks(link string) (int, bool) {
+	count, ok := strings.CutPrefix(link, "NV")
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.Atoi(count)
+	if err != nil || n < 1 {
+		return 0, false
+	}
+	return n, true
+}
+
+// Matrix holds the link between every pair of a node's GPUs, indexed by GPU
+type Matrix [][]string
+
+// GPUs returns the number of GPUs in the matrix
+func (m Matrix) GPUs() int {
+	return len(m)
+}
+
+// Encode formats the matrix for AnnotationLinks
+func (m Matrix) Encode() string {
+	rows := make([]string, len(m))
+	for i, row := range m {
+		rows[i] = strings.Join(row, ",")
+	}
+	return strings.Join(rows, ";")
+}
+
+// Decode parses a matrix formatted by Encode
+func Decode(s string) (Matrix, error) {
+	if s == "" {
+		return nil, fmt.Errorf("empty GPU link matrix")
+	}
+	rows := strings.Split(s, ";")
+	m := make(Matrix, len(rows))
+	for i, row := range rows {
+		m[i] = strings.Split(row, ",")
+	}
+	return m, m.validate()
+}
+
+// validate checks that the matrix is square with known links
+func (m Matrix) validate() error {
+	for i, row := range m {
+		if len(row) != len(m) {
+			return fmt.Errorf("GPU %d has links to %d GPUs, expected %d", i, len(row), len(m))
+		}
+		for j, link := range row {
+			if i == j {
+				continue
+			}
+			if Bandwidth(link) == 0 {
+				return fmt.Errorf("unknown link %q between GPU %d and GPU %d", link, i, j)
+			}
+		}
+	}
+	return nil
+}
+
+// ansiEscape matches the terminal escape codes nvidia-smi formats its
+// header with
+var ansiEscape = regexp.MustCompile(`\x1b\[[0-9;]*m`)
+
+// Parse reads the GPU link matrix from the output of nvidia-smi topo -m.
+// Columns and rows of NICs and the CPU and NUMA affinity are ignored.
+func Parse(r io.Reader) (Matrix, error) {
+	return parse(r, func(link string) string { return link })
+}
+
+// rocmLinks maps the link types of rocm-smi --showtopotype to links. ROCm
+// does not report the PCIe bridges a path crosses, so PCIe paths are
+// assumed to cross NUMA nodes.
+var rocmLinks = map[string]string{
+	"0":    LinkSelf,
+	"XGMI": LinkXGMI,
+	"PCIE": LinkSys,
+}
+
+// ParseROCm reads the GPU link matrix from the output of rocm-smi
+// --showtopotype on AMD GPU nodes
+func ParseROCm(r io.Reader) (Matrix, error) {
+	return parse(r, func(link string) string {
+		if mapped, ok := rocmLinks[strings.ToUpper(link)]; ok {
+			return mapped
+		}
+		return link
+	})
+}
+
+// parse reads a table of the links between GPUs headed by GPU<n> columns,
+// mapping each link with normalize
+func parse(r io.Reader, normalize func(string) string) (Matrix, error) {
+	scanner := bufio.NewScanner(r)
+	gpus := -1
+	var m Matrix
+	for scanner.Scan() {
+		fields := strings.Fields(ansiEscape.ReplaceAllString(scanner.Text(), ""))
+		if len(fields) == 0 {
+			continue
+		}
+		if gpus < 0 {
+			// The header names the GPU columns first
+			if !isGPU(fields[0]) {
+				continue
+			}
+			gpus = 0
+			for _, field := range fields {
+				if !isGPU(field) {
+					break
+				}
+				gpus++
+			}
+			continue
+		}
+		if !isGPU(fields[0]) {
+			if len(m) > 0 {
+				break
+			}
+			continue
+		}
+		if len(fields) < gpus+1 {
+			return nil, fmt.Errorf("row %s has %d links, expected %d", fields[0], len(fields)-1, gpus)
+		}
+		row := make([]string, gpus)
+		for i, link := range fields[1 : gpus+1] {
+			row[i] = normalize(link)
+		}
+		m = append(m, row)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(m) == 0 {
+		return nil, fmt.Errorf("no GPUs found in topology")
+	}
+	return m, m.validate()
+}
+
+// isGPU reports whether field names a GPU row or column, e.g. GPU0
+func isGPU(field string) bool {
+	index, ok := strings.CutPrefix(field, "GPU")
+	if !ok {
+		return false
+	}
+	_, err := strconv.Atoi(index)
+	return err == nil
+}
+
+// Group is a set of a node's GPUs and the slowest link between them, which
+// bounds the collective operations of shards placed on them
+type Group struct {
+	GPUs []int
+
+	// Link is the slowest link within the group, LinkSelf for a single GPU
+	Link string
+
+	// Bandwidth is the estimated bandwidth of Link in GB/s
+	Bandwidth float64
+}
+
+// BestGroup returns the n GPUs whose slowest link is the fastest. Groups are
+// grown greedily from every GPU, which finds the best group of the NVLink
+// meshes, pairs and NVSwitch fabrics GPUs are built in. It returns false if
+// the node has fewer than n GPUs.
+func (m Matrix) BestGroup(n int) (Group, bool) {
+	all := make([]int, len(m))
+	for i := range all {
+		all[i] = i
+	}
+	groups := m.Groups(all, n)
+	if len(groups) == 0 {
+		return Group{}, false
+	}
+	best := groups[0]
+	for _, group := range groups[1:] {
+		if group.Bandwidth > best.Bandwidth {
+			best = group
+		}
+	}
+	return best, true
+}
+
+// Groups returns the groups of n of the candidate GPUs grown greedily from
+// each of them, or none if there are fewer than n candidates
+func (m Matrix) Groups(candidates []int, n int) []Group {
+	if n < 1 || n > len(candidates) {
+		return nil
+	}
+	groups := make([]Group, 0, len(candidates))
+	for _, start := range candidates {
+		groups = append(groups, m.grow(candidates, start, n))
+	}
+	return groups
+}
+
+// grow builds a group of n of the candidate GPUs from start, adding the GPU
+// that keeps the slowest link fastest each time
+func (m Matrix) grow(candidates []int, start, n int) Group {
+	group := Group{GPUs: []int{start}, Link: LinkSelf}
+	in := map[int]bool{start: true}
+	for len(group.GPUs) < n {
+		next, nextLink, nextBandwidth := -1, "", 0.0
+		for _, candidate := range candidates {
+			if in[candidate] {
+				continue
+			}
+			link, bandwidth := m.slowest(group.GPUs, candidate)
+			if next < 0 || bandwidth > nextBandwidth {
+				next, nextLink, nextBandwidth = candidate, link, bandwidth
+			}
+		}
+		in[next] = true
+		group.GPUs = append(group.GPUs, next)
+		if group.Link == LinkSelf || nextBandwidth < group.Bandwidth {
+			group.Link, group.Bandwidth = nextLink, nextBandwidth
+		}
+	}
+	return group
+}
+
+// slowest returns the slowest link between gpu and the GPUs of group
+func (m Matrix) slowest(group []int, gpu int) (string, float64) {
+	link, bandwidth := "", 0.0
+	for i, member := range group {
+		b := Bandwidth(m[member][gpu])
+		if i == 0 || b < bandwidth {
+			link, bandwidth = m[member][gpu], b
+		}
+	}
+	return link, bandwidth
+}
@@ -0,0 +1,140 @@
+package topology
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// topoOutput is nvidia-smi topo -m on a PCIe server with NVLink bridges
+// between pairs of GPUs and a NIC
+const topoOutput = "\t\x1b[4mGPU0\tGPU1\tGPU2\tGPU3\tNIC0\tCPU Affinity\tNUMA Affinity\tGPU NUMA ID\x1b[0m\n" +
+	"GPU0\t X \tNV4\tPIX\tSYS\tSYS\t0-31\t0\t\tN/A\n" +
+	"GPU1\tNV4\t X \tPIX\tSYS\tSYS\t0-31\t0\t\tN/A\n" +
+	"GPU2\tPIX\tPIX\t X \tNV4\tNODE\t32-63\t1\t\tN/A\n" +
+	"GPU3\tSYS\tSYS\tNV4\t X \tNODE\t32-63\t1\t\tN/A\n" +
+	"NIC0\tSYS\tSYS\tNODE\tNODE\t X \t\t\t\t\n" + `
+
+Legend:
+
+  X    = Self
+  SYS  = Connection traversing PCIe as well as the SMP interconnect between NUMA nodes (e.g., QPI/UPI)
+  NV#  = Connection traversing a bonded set of # NVLinks
+
+NIC Legend:
+
+  NIC0: mlx5_0
+`
+
+func TestParse(t *testing.T) {
+	matrix, err := Parse(strings.NewReader(topoOutput))
+	require.NoError(t, err)
+	assert.Equal(t, 4, matrix.GPUs())
+	assert.Equal(t, "X,NV4,PIX,SYS;NV4,X,PIX,SYS;PIX,PIX,X,NV4;SYS,SYS,NV4,X", matrix.Encode())
+
+	decoded, err := Decode(matrix.Encode())
+	require.NoError(t, err)
+	assert.Equal(t, matrix, decoded)
+}
+
+// rocmOutput is rocm-smi --showtopotype on a server of four AMD GPUs, three
+// of them joined by Infinity Fabric
+const rocmOutput = `
+
+============================ ROCm System Management Interface ============================
+=============================== Link Type between two GPUs ===============================
+       GPU0         GPU1         GPU2         GPU3
+GPU0   0            XGMI         XGMI         PCIE
+GPU1   XGMI         0            XGMI         PCIE
+GPU2   XGMI         XGMI         0            PCIE
+GPU3   PCIE         PCIE         PCIE         0
+================================== End of ROCm SMI Log ===================================
+`
+
+func TestParseROCm(t *testing.T) {
+	matrix, err := ParseROCm(strings.NewReader(rocmOutput))
+	require.NoError(t, err)
+	assert.Equal(t, "X,XGMI,XGMI,SYS;XGMI,X,XGMI,SYS;XGMI,XGMI,X,SYS;SYS,SYS,SYS,X", matrix.Encode())
+
+	group, ok := matrix.BestGroup(3)
+	require.True(t, ok)
+	assert.ElementsMatch(t, []int{0, 1, 2}, group.GPUs)
+	assert.Equal(t, LinkXGMI, group.Link)
+	assert.True(t, IsFabric(group.Link))
+	assert.False(t, IsNVLink(group.Link))
+}
+
+func TestParseRejectsMalformedTopologies(t *testing.T) {
+	_, err := Parse(strings.NewReader("No devices were found\n"))
+	assert.Error(t, err)
+
+	_, err = Decode("X,NV4;NV4")
+	assert.Error(t, err)
+	_, err = Decode("X,QPI;QPI,X")
+	assert.Error(t, err)
+}
+
+func TestBandwidth(t *testing.T) {
+	assert.Equal(t, 600.0, Bandwidth("NV12"))
+	assert.Equal(t, 900.0, Bandwidth("NV18"))
+	assert.Equal(t, 64.0, Bandwidth(LinkPIX))
+	assert.Equal(t, 16.0, Bandwidth(LinkSys))
+	assert.Zero(t, Bandwidth("NV"))
+	assert.True(t, IsNVLink("NV4"))
+	assert.False(t, IsNVLink(LinkNode))
+	assert.Equal(t, 128.0, Bandwidth(LinkXGMI))
+	assert.False(t, IsFabric(LinkPIX))
+}
+
+func TestBestGroup(t *testing.T) {
+	matrix, err := Parse(strings.NewReader(topoOutput))
+	require.NoError(t, err)
+
+	// Two GPUs are placed on an NVLink pair
+	group, ok := matrix.BestGroup(2)
+	require.True(t, ok)
+	assert.ElementsMatch(t, []int{0, 1}, group.GPUs)
+	assert.Equal(t, "NV4", group.Link)
+	assert.Equal(t, 200.0, group.Bandwidth)
+
+	// Three stay behind one PCIe switch rather than crossing sockets
+	group, ok = matrix.BestGroup(3)
+	require.True(t, ok)
+	assert.ElementsMatch(t, []int{0, 1, 2}, group.GPUs)
+	assert.Equal(t, LinkPIX, group.Link)
+
+	// Four cross sockets
+	group, ok = matrix.BestGroup(4)
+	require.True(t, ok)
+	assert.Equal(t, LinkSys, group.Link)
+
+	_, ok = matrix.BestGroup(5)
+	assert.False(t, ok)
+}
+
+func TestAgentPublishesTopology(t *testing.T) {
+	ctx := context.Background()
+	client := fake.NewSimpleClientset(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "gpu-node"}})
+	agent := NewAgent(client, "gpu-node", 0)
+	agent.discover = func(context.Context) (Matrix, error) {
+		return Parse(strings.NewReader(topoOutput))
+	}
+
+	require.NoError(t, agent.Publish(ctx))
+	node, err := client.CoreV1().Nodes().Get(ctx, "gpu-node", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "X,NV4,PIX,SYS;NV4,X,PIX,SYS;PIX,PIX,X,NV4;SYS,SYS,NV4,X", node.Annotations[AnnotationLinks])
+
+	// An unchanged topology is not patched again
+	client.ClearActions()
+	require.NoError(t, agent.Publish(ctx))
+	for _, action := range client.Actions() {
+		assert.NotEqual(t, "patch", action.GetVerb())
+	}
+}
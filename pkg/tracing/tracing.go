@@ -0,0 +1,204 @@
+// Package tracing traces agent turns with OpenTelemetry. A turn is a span
+// with a child span for model inference, each tool call and each retrieval.
+// Trace context travels in ctx within a process, and in HTTP headers or
+// message headers between the gateway, agent replicas and ToolBinding
+// consumers.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TracerName is the name of the OpenTelemetry tracer of NeuroNetes spans
+const TracerName = "neuronetes.ai/tracing"
+
+// Span names
+const (
+	SpanTurn       = "agent.turn"
+	SpanInference  = "agent.inference"
+	SpanToolCall   = "agent.tool_call"
+	SpanRetrieval  = "agent.retrieval"
+	SpanActivation = "agent.activation"
+	SpanConsume    = "toolbinding.consume"
+)
+
+// Propagator carries trace context and baggage across processes in W3C
+// Trace Context headers
+var Propagator propagation.TextMapPropagator = propagation.NewCompositeTextMapPropagator(
+	propagation.TraceContext{}, propagation.Baggage{})
+
+// Turn describes an agent turn
+type Turn struct {
+	Model      string
+	Route      string
+	Tenant     string
+	Session    string
+	AgentClass string
+	AgentPool  string
+}
+
+func (t Turn) attributes() []attribute.KeyValue {
+	var attrs []attribute.KeyValue
+	for _, kv := range []struct{ key, value string }{
+		{"model", t.Model},
+		{"route", t.Route},
+		{"tenant", t.Tenant},
+		{"session", t.Session},
+		{"agentclass", t.AgentClass},
+		{"agentpool", t.AgentPool},
+	} {
+		if kv.value != "" {
+			attrs = append(attrs, attribute.String(kv.key, kv.value))
+		}
+	}
+	return attrs
+}
+
+func tracer() trace.Tracer {
+	return otel.Tracer(TracerName)
+}
+
+// StartTurn starts the span of an agent turn, a child of the span in ctx if
+// any, e.g. one extracted from the request headers
+func StartTurn(ctx context.Context, turn Turn) (context.Context, trace.Span) {
+	return tracer().Start(ctx, SpanTurn,
+		trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(turn.attributes()...))
+}
+
+// StartInference starts the span of a model inference within a turn
+func StartInference(ctx context.Context, model string) (context.Context, trace.Span) {
+	return tracer().Start(ctx, SpanInference, trace.WithAttributes(attribute.String("model", model)))
+}
+
+// StartToolCall starts the span of a tool call within a turn
+func StartToolCall(ctx context.Context, tool string) (context.Context, trace.Span) {
+	return tracer().Start(ctx, SpanToolCall,
+		trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attribute.String("tool", tool)))
+}
+
+// StartRetrieval starts the span of a retrieval from store within a turn
+func StartRetrieval(ctx context.Context, store string) (context.Context, trace.Span) {
+	return tracer().Start(ctx, SpanRetrieval,
+		trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attribute.String("store", store)))
+}
+
+// StartActivation starts the span of a request held while its pool scales
+// from zero
+func StartActivation(ctx context.Context, namespace, pool string) (context.Context, trace.Span) {
+	return tracer().Start(ctx, SpanActivation, trace.WithAttributes(
+		attribute.String("namespace", namespace), attribute.String("agentpool", pool)))
+}
+
+// StartConsume starts the span of a ToolBinding consumer handling a message
+// from a queue or topic, continuing the trace carried in the message headers
+func StartConsume(ctx context.Context, binding, source string, headers map[string]string) (context.Context, trace.Span) {
+	ctx = ExtractMessage(ctx, headers)
+	return tracer().Start(ctx, SpanConsume, trace.WithSpanKind(trace.SpanKindConsumer), trace.WithAttributes(
+		attribute.String("toolbinding", binding), semconv.MessagingDestinationName(source)))
+}
+
+// End ends span, marking it failed if err is not nil
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Inject writes the trace context of ctx to the headers of an outgoing
+// HTTP request
+func Inject(ctx context.Context, header http.Header) {
+	Propagator.Inject(ctx, propagation.HeaderCarrier(header))
+}
+
+// Extract returns ctx carrying the trace context of the headers of an
+// incoming HTTP request
+func Extract(ctx context.Context, header http.Header) context.Context {
+	return Propagator.Extract(ctx, propagation.HeaderCarrier(header))
+}
+
+// InjectMessage writes the trace context of ctx to the headers of a message
+// published to a queue or topic
+func InjectMessage(ctx context.Context, headers map[string]string) {
+	Propagator.Inject(ctx, propagation.MapCarrier(headers))
+}
+
+// ExtractMessage returns ctx carrying the trace context of the headers of a
+// message consumed from a queue or topic
+func ExtractMessage(ctx context.Context, headers map[string]string) context.Context {
+	return Propagator.Extract(ctx, propagation.MapCarrier(headers))
+}
+
+// Config configures exporting spans to an OTLP collector over gRPC
+type Config struct {
+	// Endpoint is the host:port of the collector. When empty the
+	// OTEL_EXPORTER_OTLP_ENDPOINT environment variable or localhost:4317
+	// is used.
+	Endpoint string
+
+	// Insecure disables TLS to the collector
+	Insecure bool
+
+	// Headers are sent with every export, e.g. for authentication
+	Headers map[string]string
+
+	// SampleRatio is the share of traces sampled, 1 if zero. Traces
+	// continued from a sampled parent are always sampled.
+	SampleRatio float64
+
+	// ServiceName identifies the process in the collector
+	ServiceName string
+}
+
+// NewOTLPTracerProvider returns a tracer provider exporting spans to the
+// OTLP collector of config. Callers install it with otel.SetTracerProvider
+// and shut it down on exit to flush the last spans.
+func NewOTLPTracerProvider(ctx context.Context, config Config) (*sdktrace.TracerProvider, error) {
+	var options []otlptracegrpc.Option
+	if config.Endpoint != "" {
+		options = append(options, otlptracegrpc.WithEndpoint(config.Endpoint))
+	}
+	if config.Insecure {
+		options = append(options, otlptracegrpc.WithInsecure())
+	}
+	if len(config.Headers) > 0 {
+		options = append(options, otlptracegrpc.WithHeaders(config.Headers))
+	}
+	exporter, err := otlptracegrpc.New(ctx, options...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP trace exporter: %w", err)
+	}
+
+	ratio := config.SampleRatio
+	if ratio <= 0 {
+		ratio = 1
+	}
+	serviceName := config.ServiceName
+	if serviceName == "" {
+		serviceName = "neuronetes"
+	}
+	res, err := resource.Merge(resource.Default(),
+		resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(serviceName)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build OTLP resource: %w", err)
+	}
+
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+		sdktrace.WithBatcher(exporter),
+	), nil
+}
@@ -0,0 +1,82 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// recordSpans installs a tracer provider recording ended spans for the
+// duration of the test
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	return recorder
+}
+
+func TestTurnSpans(t *testing.T) {
+	recorder := recordSpans(t)
+
+	ctx, turn := StartTurn(context.Background(), Turn{Model: "llama-3-70b", Route: "/chat", Tenant: "tenant-1"})
+	_, inference := StartInference(ctx, "llama-3-70b")
+	End(inference, nil)
+	_, tool := StartToolCall(ctx, "code_search")
+	End(tool, errors.New("timeout"))
+	_, retrieval := StartRetrieval(ctx, "docs")
+	End(retrieval, nil)
+	End(turn, nil)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 4)
+	root := spans[3]
+	assert.Equal(t, SpanTurn, root.Name())
+	assert.Contains(t, root.Attributes(), attribute.String("tenant", "tenant-1"))
+	for _, span := range spans[:3] {
+		assert.Equal(t, root.SpanContext().SpanID(), span.Parent().SpanID())
+		assert.Equal(t, root.SpanContext().TraceID(), span.SpanContext().TraceID())
+	}
+	assert.Equal(t, []string{SpanInference, SpanToolCall, SpanRetrieval},
+		[]string{spans[0].Name(), spans[1].Name(), spans[2].Name()})
+	assert.Equal(t, codes.Error, spans[1].Status().Code)
+	assert.Len(t, spans[1].Events(), 1)
+}
+
+func TestPropagation(t *testing.T) {
+	recorder := recordSpans(t)
+
+	// The gateway forwards the turn to a replica over HTTP
+	ctx, gateway := StartTurn(context.Background(), Turn{Route: "/chat"})
+	header := http.Header{}
+	Inject(ctx, header)
+	assert.NotEmpty(t, header.Get("traceparent"))
+	_, replica := StartInference(Extract(context.Background(), header), "llama-3-70b")
+	End(replica, nil)
+
+	// The replica publishes a tool call to a queue served by a ToolBinding
+	// consumer
+	headers := map[string]string{}
+	InjectMessage(ctx, headers)
+	_, consumer := StartConsume(context.Background(), "code-search", "tool-requests", headers)
+	End(consumer, nil)
+	End(gateway, nil)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 3)
+	for _, span := range spans[:2] {
+		assert.Equal(t, gateway.SpanContext().TraceID(), span.SpanContext().TraceID())
+		assert.Equal(t, gateway.SpanContext().SpanID(), span.Parent().SpanID())
+		assert.True(t, span.Parent().IsRemote())
+	}
+	assert.Equal(t, SpanConsume, spans[1].Name())
+}
@@ -0,0 +1,156 @@
+// Package warmup runs the warm-up sequence of agent replicas. The first
+// requests a runtime serves after loading its weights are slow, as it
+// captures CUDA graphs and compiles kernels for each batch size; sending
+// requests of those sizes before a replica is ready moves that cost out of
+// the path of traffic.
+package warmup
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+)
+
+const (
+	// DefaultPrompt is the prompt of warm-up requests unless configured
+	// otherwise
+	DefaultPrompt = "Hello"
+
+	// DefaultMaxTokens is the number of tokens each warm-up prompt
+	// generates unless configured otherwise
+	DefaultMaxTokens = 16
+
+	// DefaultPath is the completions endpoint of OpenAI-compatible runtimes
+	DefaultPath = "/v1/completions"
+
+	// DefaultTimeout bounds a warm-up sequence unless configured otherwise
+	DefaultTimeout = 10 * time.Minute
+)
+
+// Runner runs the warm-up sequence of agent replicas
+type Runner interface {
+	// WarmUp sends the warm-up requests of config to pod, returning once
+	// the runtime served them all
+	WarmUp(ctx context.Context, pod *corev1.Pod, config *neuronetes.WarmUpConfig) error
+}
+
+// Client warms up replicas through the completions endpoint of the runtime
+// serving on their pod's IP
+type Client struct {
+	http *http.Client
+	port int32
+}
+
+var _ Runner = &Client{}
+
+// NewClient creates a client warming up replicas whose runtime serves on
+// port
+func NewClient(port int32) *Client {
+	return &Client{http: &http.Client{}, port: port}
+}
+
+// completionRequest is the body of an OpenAI-compatible completion request
+type completionRequest struct {
+	Model     string   `json:"model,omitempty"`
+	Prompt    []string `json:"prompt"`
+	MaxTokens int32    `json:"max_tokens"`
+}
+
+// WarmUp implements Runner. Batches are sent one at a time, in the order of
+// config.BatchSizes, and the sequence stops at the first failed request.
+func (c *Client) WarmUp(ctx context.Context, pod *corev1.Pod, config *neuronetes.WarmUpConfig) error {
+	if pod.Status.PodIP == "" {
+		return fmt.Errorf("pod %s has no IP", pod.Name)
+	}
+	ctx, cancel := context.WithTimeout(ctx, Timeout(config))
+	defer cancel()
+
+	url := "http://" + net.JoinHostPort(pod.Status.PodIP, strconv.Itoa(int(c.port))) + path(config)
+	for _, size := range BatchSizes(config) {
+		body, err := json.Marshal(completionRequest{
+			Model:     config.Model,
+			Prompt:    Batch(config, int(size)),
+			MaxTokens: maxTokens(config),
+		})
+		if err != nil {
+			return err
+		}
+		if err := c.post(ctx, url, body); err != nil {
+			return fmt.Errorf("failed to warm up batch size %d of pod %s: %w", size, pod.Name, err)
+		}
+	}
+	return nil
+}
+
+func (c *Client) post(ctx context.Context, url string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(message))
+	}
+	_, err = io.Copy(io.Discard, resp.Body)
+	return err
+}
+
+// Batch returns the prompts of a warm-up request of size prompts, cycling
+// through the prompts of config
+func Batch(config *neuronetes.WarmUpConfig, size int) []string {
+	prompts := config.Prompts
+	if len(prompts) == 0 {
+		prompts = []string{DefaultPrompt}
+	}
+	batch := make([]string, size)
+	for i := range batch {
+		batch[i] = prompts[i%len(prompts)]
+	}
+	return batch
+}
+
+// BatchSizes returns the batch sizes config warms up
+func BatchSizes(config *neuronetes.WarmUpConfig) []int32 {
+	if len(config.BatchSizes) == 0 {
+		return []int32{1}
+	}
+	return config.BatchSizes
+}
+
+// Timeout returns how long the warm-up sequence of config may take
+func Timeout(config *neuronetes.WarmUpConfig) time.Duration {
+	if config.Timeout != nil {
+		return config.Timeout.Duration
+	}
+	return DefaultTimeout
+}
+
+func maxTokens(config *neuronetes.WarmUpConfig) int32 {
+	if config.MaxTokens != nil {
+		return *config.MaxTokens
+	}
+	return DefaultMaxTokens
+}
+
+func path(config *neuronetes.WarmUpConfig) string {
+	if config.Path != "" {
+		return config.Path
+	}
+	return DefaultPath
+}
@@ -0,0 +1,74 @@
+package warmup
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	neuronetes "github.com/bowenislandsong/neuronetes/api/v1alpha1"
+)
+
+func TestClientWarmsUpEachBatchSize(t *testing.T) {
+	var requests []completionRequest
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req completionRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		requests = append(requests, req)
+		paths = append(paths, r.URL.Path)
+		if len(req.Prompt) > 4 {
+			http.Error(w, "batch too large", http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+	host, portString, err := net.SplitHostPort(strings.TrimPrefix(server.URL, "http://"))
+	require.NoError(t, err)
+	port, err := strconv.Atoi(portString)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "chat-pool-0"}, Status: corev1.PodStatus{PodIP: host}}
+	client := NewClient(int32(port))
+
+	// Defaults warm up a single prompt
+	require.NoError(t, client.WarmUp(ctx, pod, &neuronetes.WarmUpConfig{}))
+	require.Len(t, requests, 1)
+	assert.Equal(t, completionRequest{Prompt: []string{DefaultPrompt}, MaxTokens: DefaultMaxTokens}, requests[0])
+	assert.Equal(t, DefaultPath, paths[0])
+
+	maxTokens := int32(4)
+	config := &neuronetes.WarmUpConfig{
+		Prompts:    []string{"a", "b"},
+		BatchSizes: []int32{1, 3},
+		MaxTokens:  &maxTokens,
+		Model:      "llama-3-8b",
+		Path:       "/v1/warm",
+	}
+	requests, paths = nil, nil
+	require.NoError(t, client.WarmUp(ctx, pod, config))
+	assert.Equal(t, []completionRequest{
+		{Model: "llama-3-8b", Prompt: []string{"a"}, MaxTokens: 4},
+		{Model: "llama-3-8b", Prompt: []string{"a", "b", "a"}, MaxTokens: 4},
+	}, requests)
+	assert.Equal(t, []string{"/v1/warm", "/v1/warm"}, paths)
+
+	// The sequence stops at the first failed batch
+	config.BatchSizes = []int32{8, 1}
+	requests = nil
+	err = client.WarmUp(ctx, pod, config)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "batch too large")
+	assert.Len(t, requests, 1)
+
+	assert.Error(t, client.WarmUp(ctx, &corev1.Pod{}, config))
+}
@@ -355,7 +355,7 @@ func TestMetricsAutoscalingReliability(t *testing.T) {
 	m.ReplicaEvictions.Inc()
 	m.SpotInterruptions.Inc()
 	m.FailoverTime.Observe(5)
-	m.ErrorBudgetBurnRate.Set(0.15)
+	m.RecordBurnRate(ctx, "prod", "chat-agent", "ttft", time.Hour, 0.15)
 
 	// Verify metrics
 	decisions := testutil.ToFloat64(m.HPADecisions)
@@ -373,7 +373,7 @@ func TestMetricsAutoscalingReliability(t *testing.T) {
 	failoverCount := testutil.CollectAndCount(m.FailoverTime)
 	assert.Greater(t, failoverCount, 0)
 
-	burnRate := testutil.ToFloat64(m.ErrorBudgetBurnRate)
+	burnRate := testutil.ToFloat64(m.ErrorBudgetBurnRate.WithLabelValues("prod", "chat-agent", "ttft", "1h"))
 	assert.Equal(t, 0.15, burnRate)
 }
 
@@ -548,11 +548,12 @@ func TestMetricsHighCardinality(t *testing.T) {
 	ttftCount := testutil.CollectAndCount(m.TTFTHistogram)
 	assert.Greater(t, ttftCount, 0)
 
-	tokensVal := testutil.ToFloat64(m.TotalTokens)
-	assert.Greater(t, tokensVal, float64(0))
-
-	// Note: In production, these would be separate metric instances with labels
-	// Here we're verifying the metrics structure doesn't explode with cardinality
+	// One series per model and route
+	assert.Equal(t, len(models)*len(routes), ttftCount)
+	assert.Equal(t, len(models), testutil.CollectAndCount(m.TotalTokens))
+	for _, model := range models {
+		assert.Equal(t, float64(len(routes)*1500), testutil.ToFloat64(m.TotalTokens.WithLabelValues(model)))
+	}
 }
 
 // BenchmarkMetricsRecording benchmarks metric recording performance